@@ -0,0 +1,209 @@
+// Copyright (c) 2025 B11K contributors
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	gosync "sync"
+	"text/tabwriter"
+	"time"
+
+	"b11k/internal/config"
+	"b11k/internal/logging"
+	"b11k/internal/pggeo"
+	"b11k/internal/sync"
+	"b11k/internal/trackimport"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type importDirCommand struct {
+	dir       string
+	athleteID int64
+	mode      sync.ImportMode
+	workers   int
+}
+
+// parseImportDirArgs parses the flags of `b11k import-dir`
+func parseImportDirArgs(args []string) importDirCommand {
+	cmd := importDirCommand{}
+	fset := flag.NewFlagSet("import-dir", flag.ExitOnError)
+	fset.Int64Var(&cmd.athleteID, "athlete-id", 0, "Strava athlete ID the activities are imported for (required)")
+	mode := fset.String("mode", string(sync.ImportModeNew), "What a changed file with an already imported name becomes: new (a new activity) or update (replaces the earlier version's activity)")
+	fset.IntVar(&cmd.workers, "workers", min(runtime.NumCPU(), 8), "Files read and parsed at once")
+	_ = fset.Parse(args) // ExitOnError
+
+	cmd.dir = fset.Arg(0)
+	if cmd.dir == "" || cmd.athleteID <= 0 {
+		logging.Fatal("Usage: b11k import-dir -athlete-id ID [-mode new|update] [-workers N] DIR")
+	}
+	parsed, err := sync.ParseImportMode(*mode)
+	if err != nil {
+		logging.Fatal("Invalid -mode", "error", err)
+	}
+	cmd.mode = parsed
+	cmd.workers = max(cmd.workers, 1)
+	return cmd
+}
+
+// parsedImportFile is one file of the directory after reading, hashing and parsing.
+// track is nil for a file skipped by hash or one that failed.
+type parsedImportFile struct {
+	name  string // path relative to the directory, with forward slashes
+	hash  string
+	known *pggeo.ImportFile
+	track *trackimport.Track
+	err   error
+}
+
+// importDirRow is one line of the summary table
+type importDirRow struct {
+	name       string
+	status     string
+	activityID int64
+	detail     string
+}
+
+// runImportDir imports every GPX and TCX file under cmd.dir. Files are read, hashed and
+// parsed by cmd.workers workers; files whose hash was imported before are not parsed.
+// Activities are stored one at a time over the single connection.
+func runImportDir(ctx context.Context, conn *pgx.Conn, cfg config.Config, cmd importDirCommand) {
+	paths, err := trackFilesIn(cmd.dir)
+	if err != nil {
+		logging.Fatal("Failed to list the import directory", "dir", cmd.dir, "error", err)
+	}
+	known, err := pggeo.ListImportFiles(ctx, conn, cmd.athleteID)
+	if err != nil {
+		logging.Fatal("Failed to load imported files", "error", err)
+	}
+	slog.Info("Importing files", "files", len(paths), "dir", cmd.dir, "athlete_id", cmd.athleteID, "workers", cmd.workers, "mode", cmd.mode)
+	started := time.Now()
+
+	parsed := parseImportFiles(cmd.dir, paths, known, cmd.workers)
+	rows := make([]importDirRow, 0, len(paths))
+	stored := 0
+	for file := range parsed {
+		row := importDirRow{name: file.name}
+		switch {
+		case file.err != nil:
+			row.status, row.detail = "failed", file.err.Error()
+		case file.known != nil:
+			row.status, row.activityID = sync.FileAlreadyImported, file.known.ActivityID
+		default:
+			result, err := sync.StoreImportedFile(ctx, conn, cmd.athleteID, file.name, file.hash, file.track, cmd.mode, cfg.HealGPSSpikes)
+			if err != nil {
+				row.status, row.detail = "failed", err.Error()
+				break
+			}
+			row.status, row.activityID = result.Status, result.ActivityID
+			if result.Activity != nil {
+				row.detail = fmt.Sprintf("%d points", len(file.track.Points))
+				stored++
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if stored > 0 && cfg.DiscoveredMapEnabled != nil && *cfg.DiscoveredMapEnabled {
+		if _, err := pggeo.RebuildDiscoveredCoverage(ctx, conn, cmd.athleteID, cfg.DiscoveredSampleDistanceMeters, cfg.DiscoveredRevealRadiusMeters); err != nil {
+			slog.Warn("Failed to rebuild discovered map coverage after import", "error", err)
+		}
+	}
+	printImportDirSummary(os.Stdout, rows)
+	slog.Info("Import finished", "dir", cmd.dir, "duration", time.Since(started).Round(time.Millisecond))
+}
+
+// trackFilesIn lists the .gpx and .tcx files under dir, sorted
+func trackFilesIn(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".gpx", ".tcx":
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	slices.Sort(paths)
+	return paths, err
+}
+
+// parseImportFiles reads, hashes and parses the files on workers goroutines. Results
+// arrive in any order; the channel closes after the last one.
+func parseImportFiles(dir string, paths []string, known map[string]pggeo.ImportFile, workers int) <-chan parsedImportFile {
+	jobs := make(chan string)
+	results := make(chan parsedImportFile, workers)
+	var wg gosync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				results <- parseImportFile(dir, path, known)
+			}
+		}()
+	}
+	go func() {
+		for _, path := range paths {
+			jobs <- path
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func parseImportFile(dir, path string, known map[string]pggeo.ImportFile) parsedImportFile {
+	file := parsedImportFile{name: path}
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		file.name = filepath.ToSlash(rel)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		file.err = err
+		return file
+	}
+	file.hash = pggeo.ImportFileHash(data)
+	if f, ok := known[file.hash]; ok {
+		file.known = &f
+		return file
+	}
+	file.track, file.err = trackimport.Parse(path, data)
+	return file
+}
+
+// printImportDirSummary prints one line per file, sorted by name, and the totals by status
+func printImportDirSummary(out io.Writer, rows []importDirRow) {
+	slices.SortFunc(rows, func(a, b importDirRow) int { return strings.Compare(a.name, b.name) })
+	counts := make(map[string]int)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSTATUS\tACTIVITY\tDETAIL")
+	for _, row := range rows {
+		activity := "-"
+		if row.activityID != 0 {
+			activity = fmt.Sprint(row.activityID)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.name, row.status, activity, row.detail)
+		counts[row.status]++
+	}
+	_ = w.Flush()
+	fmt.Fprintf(out, "\n%d files: %d imported, %d updated, %d already imported, %d failed\n",
+		len(rows), counts[sync.FileImported], counts[sync.FileUpdated], counts[sync.FileAlreadyImported], counts["failed"])
+}
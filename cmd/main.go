@@ -7,42 +7,23 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"b11k/internal/config"
+	"b11k/internal/logging"
+	"b11k/internal/outbound"
 	"b11k/internal/pggeo"
 	"b11k/internal/strava"
 	"b11k/internal/sync"
 	"b11k/internal/web"
 
 	"github.com/jackc/pgx/v5"
-	"gopkg.in/yaml.v3"
 )
 
-type Config struct {
-	StravaClientID                 string  `yaml:"strava_client_id"`
-	StravaClientSecret             string  `yaml:"strava_client_secret"`
-	StravaRedirectURI              string  `yaml:"strava_redirect_uri"`
-	IOSRedirectURI                 string  `yaml:"ios_redirect_uri"`
-	PGIP                           string  `yaml:"pg_ip"`
-	PGPort                         string  `yaml:"pg_port"`
-	PGUser                         string  `yaml:"pg_user"`
-	PGPassword                     string  `yaml:"pg_secret"`
-	PGDatabase                     string  `yaml:"pg_db"`
-	WebHost                        string  `yaml:"web_host"`
-	PublicAPIHost                  string  `yaml:"public_api_host"`
-	WebPort                        string  `yaml:"web_port"`
-	WebProtocol                    string  `yaml:"web_protocol"` // "http" or "https" - use "https" when behind Cloudflare Tunnel or reverse proxy
-	TokenEncryptionKey             string  `yaml:"token_encryption_key"`
-	DevReloadTemplates             bool    `yaml:"dev_reload_templates"`
-	MobileActivityOrder            string  `yaml:"mobile_activity_order"`
-	DiscoveredMapEnabled           *bool   `yaml:"discovered_map_enabled"`
-	DiscoveredRevealRadiusMeters   float64 `yaml:"discovered_reveal_radius_meters"`
-	DiscoveredSampleDistanceMeters float64 `yaml:"discovered_sample_distance_meters"`
-}
-
 func main() {
 	setupDB := flag.Bool("setup-db", false, "Set up database tables and exit")
 	testDB := flag.Bool("test-db", false, "Test database connection and exit")
@@ -50,64 +31,50 @@ func main() {
 	recreateDB := flag.Bool("recreate-db", false, "Drop and recreate all database tables and exit")
 	validateSchema := flag.Bool("validate-schema", false, "Validate database schema and exit")
 	forceRebuild := flag.Bool("force-rebuild", false, "Force rebuild tables with schema mismatches (WARNING: will delete data)")
+	backfillDistance := flag.Bool("backfill-distance", false, "Fill in missing cumulative distances of stored point samples and exit")
+	resimplify := flag.Bool("resimplify", false, "Simplify every stored route and segment again to simplify_tolerance_m and exit")
+	convertPoints := flag.Bool("convert-points", false, "Convert stored point samples to the configured point_storage layout and exit")
+	configPath := flag.String("config", "", "Path to the YAML config file (default: $B11K_CONFIG, else config.yaml when present); B11K_* environment variables override it")
 	// serve flag deprecated; server runs by default
 	_ = flag.Bool("serve", false, "Run web server UI (default)")
 	flag.Parse()
 
-	config := Config{}
-	yamlFile, err := os.ReadFile("config.yaml")
-	if err != nil {
-		log.Fatalf("Error reading config file: %v", err)
+	var seedCmd *seedCommand
+	if flag.Arg(0) == "seed" {
+		cmd := parseSeedArgs(flag.Args()[1:])
+		seedCmd = &cmd
 	}
-	if err := yaml.Unmarshal(yamlFile, &config); err != nil {
-		log.Fatalf("Error parsing config file: %v", err)
+	var importDirCmd *importDirCommand
+	if flag.Arg(0) == "import-dir" {
+		cmd := parseImportDirArgs(flag.Args()[1:])
+		importDirCmd = &cmd
+	}
+	var webhookCmd *webhookCommand
+	if flag.Arg(0) == "webhook" {
+		cmd := parseWebhookArgs(flag.Args()[1:])
+		webhookCmd = &cmd
 	}
-	applyEnvOverrides(&config)
-	normalizeConfig(&config)
-
-	// Construct redirect URI from host and port if not explicitly provided
-	if config.StravaRedirectURI == "" {
-		webHost := config.WebHost
-		if webHost == "" {
-			webHost = "localhost"
-		}
-		webPort := config.WebPort
-		if webPort == "" {
-			webPort = "8080"
-		}
-		// Determine protocol - default to http, but use web_protocol if set
-		protocol := "http"
-		if config.WebProtocol == "https" {
-			protocol = "https"
-		}
 
-		// For standard ports (80 for HTTP, 443 for HTTPS), omit port in URL
-		// For non-standard ports, include port in URL
-		var redirectURI string
-		if (protocol == "http" && webPort == "80") || (protocol == "https" && webPort == "443") {
-			// Standard port - omit from URL
-			redirectURI = fmt.Sprintf("%s://%s/strava/callback", protocol, webHost)
-		} else if protocol == "https" {
-			// HTTPS with non-standard port - but if behind proxy, usually omit port
-			// For Cloudflare Tunnel and most reverse proxies, HTTPS URLs don't include port
-			redirectURI = fmt.Sprintf("%s://%s/strava/callback", protocol, webHost)
-		} else {
-			// HTTP with non-standard port - include port
-			redirectURI = fmt.Sprintf("%s://%s:%s/strava/callback", protocol, webHost, webPort)
-		}
+	// Log as the environment asks while the configuration loads, then as configured
+	setupLogging(config.LogSettings())
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logging.Fatal("Failed to load the configuration", "error", err)
+	}
+	setupLogging(cfg.LogLevel, cfg.LogFormat)
+	pggeo.SetPointStorage(cfg.PointStorage)
+	pggeo.SetSimplifyTolerance(cfg.SimplifyToleranceM)
 
-		config.StravaRedirectURI = redirectURI
-		log.Printf("📝 Constructed Strava redirect URI: %s", config.StravaRedirectURI)
-		if protocol == "http" {
-			log.Printf("💡 If behind Cloudflare Tunnel or reverse proxy with HTTPS, set web_protocol: https in config.yaml")
-		}
+	if webhookCmd != nil {
+		runWebhook(*cfg, *webhookCmd)
+		return
 	}
 
 	// Connect to database
 	ctx := context.Background()
-	conn, err := connectDatabase(ctx, config)
+	conn, err := connectDatabase(ctx, *cfg)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		logging.Fatal("Failed to connect to the database", "error", err)
 	}
 	defer conn.Close(ctx)
 
@@ -138,244 +105,234 @@ func main() {
 	}
 
 	// Validate schema before starting server
-	log.Printf("🔍 Validating database schema...")
+	slog.Info("Validating database schema")
 	if err := pggeo.ValidateAndMigrateSchema(ctx, conn, *forceRebuild); err != nil {
-		log.Fatalf("Error validating/migrating database schema: %v", err)
-	}
-	log.Printf("✅ Schema validation completed")
-
-	// Default behavior: serve web UI (if -serve is provided or not)
-	web.RunServer(ctx, web.Config{
-		StravaClientID:                 config.StravaClientID,
-		StravaClientSecret:             config.StravaClientSecret,
-		StravaRedirectURI:              config.StravaRedirectURI,
-		IOSRedirectURI:                 config.IOSRedirectURI,
-		PGIP:                           config.PGIP,
-		PGPort:                         config.PGPort,
-		PGUser:                         config.PGUser,
-		PGPassword:                     config.PGPassword,
-		PGDatabase:                     config.PGDatabase,
-		WebHost:                        config.WebHost,
-		PublicAPIHost:                  config.PublicAPIHost,
-		WebPort:                        config.WebPort,
-		WebProtocol:                    config.WebProtocol,
-		TokenEncryptionKey:             config.TokenEncryptionKey,
-		DevReloadTemplates:             config.DevReloadTemplates,
-		MobileActivityOrder:            config.MobileActivityOrder,
-		DiscoveredMapEnabled:           *config.DiscoveredMapEnabled,
-		DiscoveredRevealRadiusMeters:   config.DiscoveredRevealRadiusMeters,
-		DiscoveredSampleDistanceMeters: config.DiscoveredSampleDistanceMeters,
+		logging.Fatal("Failed to validate or migrate the database schema", "error", err)
+	}
+	slog.Info("Schema validation completed")
+
+	if seedCmd != nil {
+		runSeed(ctx, conn, *seedCmd)
+		return
+	}
+
+	if importDirCmd != nil {
+		runImportDir(ctx, conn, *cfg, *importDirCmd)
+		return
+	}
+
+	if *backfillDistance {
+		backfillCumulativeDistance(ctx, conn)
+		return
+	}
+
+	if *resimplify {
+		resimplifyRoutes(ctx, conn, cfg.SimplifyToleranceM)
+		return
+	}
+
+	if *convertPoints {
+		convertPointStorage(ctx, conn, cfg.PointStorage)
+		return
+	}
+
+	// Default behavior: serve web UI (if -serve is provided or not). SIGINT and SIGTERM
+	// shut the server down gracefully; the database connection closes after it returns.
+	serverCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	web.RunServer(serverCtx, web.Config{
+		StravaClientID:                 cfg.StravaClientID,
+		StravaClientSecret:             cfg.StravaClientSecret,
+		StravaRedirectURI:              cfg.StravaRedirectURI,
+		IOSRedirectURI:                 cfg.IOSRedirectURI,
+		PGIP:                           cfg.PGIP,
+		PGPort:                         cfg.PGPort,
+		PGUser:                         cfg.PGUser,
+		PGPassword:                     cfg.PGPassword,
+		PGDatabase:                     cfg.PGDatabase,
+		PGMaxConns:                     cfg.PGMaxConns,
+		PGReplicaIP:                    cfg.PGReplicaIP,
+		PGReplicaPort:                  cfg.PGReplicaPort,
+		WebHost:                        cfg.WebHost,
+		PublicAPIHost:                  cfg.PublicAPIHost,
+		WebPort:                        cfg.WebPort,
+		WebProtocol:                    cfg.WebProtocol,
+		BasePath:                       cfg.BasePath,
+		TokenEncryptionKey:             cfg.TokenEncryptionKey,
+		DevReloadTemplates:             cfg.DevReloadTemplates,
+		MobileActivityOrder:            cfg.MobileActivityOrder,
+		DiscoveredMapEnabled:           *cfg.DiscoveredMapEnabled,
+		DiscoveredRevealRadiusMeters:   cfg.DiscoveredRevealRadiusMeters,
+		DiscoveredSampleDistanceMeters: cfg.DiscoveredSampleDistanceMeters,
+		AccountDeletionGraceDays:       cfg.AccountDeletionGraceDays,
+		SkipSpatialSelfCheck:           cfg.SkipSpatialSelfCheck,
+		LazySegmentCache:               cfg.LazySegmentCache,
+		StravaWriteBack:                cfg.StravaWriteBack,
+		AthleteCacheTTL:                time.Duration(cfg.AthleteCacheTTLMinutes) * time.Minute,
+		SegmentCacheTTL:                time.Duration(cfg.SegmentCacheTTLMinutes) * time.Minute,
+		ShutdownGracePeriod:            time.Duration(cfg.ShutdownGraceSeconds) * time.Second,
+		ActivityTypes:                  cfg.ActivityTypes,
+		StravaWebhookVerifyToken:       cfg.StravaWebhookVerifyToken,
+		OutboundWebhooks:               outboundEndpoints(cfg.OutboundWebhooks),
+		AdminAthleteIDs:                cfg.AdminAthleteIDs,
+		PublicAthleteID:                cfg.PublicAthleteID,
+		AutoPullOnView:                 cfg.AutoPullOnView,
+		AutoPullStaleAfter:             time.Duration(cfg.AutoPullStaleHours) * time.Hour,
+		AutoPullMaxActivities:          cfg.AutoPullMaxActivities,
+		HealGPSSpikes:                  cfg.HealGPSSpikes,
+		DigestInterval:                 time.Duration(cfg.LogDigestIntervalMinutes) * time.Minute,
+		ShareViewRetention:             time.Duration(cfg.ShareViewRetentionDays) * 24 * time.Hour,
+		ShareViewGeoIPFile:             cfg.ShareViewGeoIPFile,
+		DebugLogging:                   cfg.DebugLogging,
+		MetricsEnabled:                 cfg.MetricsEnabled,
+		Limits:                         softLimits(*cfg),
+		TagRules:                       cfg.SyncTagRules(),
 	})
 }
 
+// setupLogging logs to stderr at level in format, the standard log package included, and
+// hands pggeo, strava and sync a logger naming them. Settings that do not parse fall back
+// to info and text; config.Load reports them.
+func setupLogging(level, format string) {
+	logger, err := logging.New(os.Stderr, level, format)
+	if err != nil {
+		logger, _ = logging.New(os.Stderr, "", "")
+	}
+	logging.Install(logger)
+	pggeo.SetLogger(logger.With("component", "pggeo"))
+	strava.SetLogger(logger.With("component", "strava"))
+	sync.SetLogger(logger.With("component", "sync"))
+}
+
+// softLimits converts the configured limits, given in megabytes for the database size
+func softLimits(cfg config.Config) pggeo.SoftLimits {
+	return pggeo.SoftLimits{
+		MaxPointSamples:         int64(cfg.MaxPointSamples),
+		MaxDatabaseBytes:        int64(cfg.MaxDatabaseMB) << 20,
+		MaxActivitiesPerAthlete: int64(cfg.MaxActivitiesPerAthlete),
+		Enforce:                 cfg.EnforceLimits,
+	}
+}
+
+func outboundEndpoints(webhooks []config.OutboundWebhook) []outbound.Endpoint {
+	endpoints := make([]outbound.Endpoint, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		endpoints = append(endpoints, outbound.Endpoint{URL: webhook.URL, Secret: webhook.Secret, Events: webhook.Events})
+	}
+	return endpoints
+}
+
 func setupDatabase(ctx context.Context, conn *pgx.Conn) {
-	log.Printf("🔧 Setting up database tables...")
+	slog.Info("Setting up database tables")
 	if err := pggeo.CreateTables(ctx, conn); err != nil {
-		log.Fatalf("Error creating database tables: %v", err)
-	}
-	log.Printf("✅ Database setup completed successfully!")
-	log.Printf("📊 Created tables:")
-	log.Printf("   - activity_summaries")
-	log.Printf("   - activity_geometries")
-	log.Printf("   - point_samples")
-	log.Printf("   - favorite_segments")
-	log.Printf("🔧 Created helper functions for spatial operations")
+		logging.Fatal("Failed to create database tables", "error", err)
+	}
+	slog.Info("Database setup completed", "tables", "activity_summaries,activity_geometries,point_samples,favorite_segments")
+	slog.Info("Created helper functions for spatial operations")
+}
+
+func backfillCumulativeDistance(ctx context.Context, conn *pgx.Conn) {
+	slog.Info("Backfilling cumulative distance of point samples")
+	result, err := pggeo.BackfillCumulativeDistance(ctx, conn, 0)
+	if err != nil {
+		logging.Fatal("Failed to backfill cumulative distance", "error", err)
+	}
+	slog.Info("Backfilled cumulative distance", "points", result.Points, "activities", result.Activities)
+}
+
+func resimplifyRoutes(ctx context.Context, conn *pgx.Conn, toleranceMeters float64) {
+	slog.Info("Simplifying stored routes and segments", "tolerance_m", toleranceMeters)
+	result, err := pggeo.ResimplifyAll(ctx, conn, toleranceMeters)
+	if err != nil {
+		logging.Fatal("Failed to simplify routes", "error", err)
+	}
+	slog.Info("Simplified routes and segments", "routes", result.Activities, "athletes", result.Athletes, "segments", result.Segments)
+}
+
+func convertPointStorage(ctx context.Context, conn *pgx.Conn, layout string) {
+	slog.Info("Converting point samples", "layout", layout)
+	result, err := pggeo.ConvertPointStorage(ctx, conn, 0, layout)
+	if err != nil {
+		logging.Fatal("Failed to convert point samples", "error", err)
+	}
+	slog.Info("Converted point samples", "points", result.Points, "activities", result.Activities)
+	usage, err := pggeo.GetPointStorageUsage(ctx, conn, 0)
+	if err != nil {
+		logging.Fatal("Failed to count stored points", "error", err)
+	}
+	slog.Info("Point storage", "row_points", usage.RowPoints, "row_activities", usage.RowActivities,
+		"stream_points", usage.StreamPoints, "stream_activities", usage.StreamActivities)
 }
 
 func testDatabase(ctx context.Context, conn *pgx.Conn) {
-	log.Printf("🧪 Testing database connection...")
+	slog.Info("Testing database connection")
 
 	// Test basic connection
 	var version string
 	err := conn.QueryRow(ctx, "SELECT version()").Scan(&version)
 	if err != nil {
-		log.Fatalf("Error querying database: %v", err)
+		logging.Fatal("Failed to query the database", "error", err)
 	}
-	log.Printf("✅ Database version: %s", version)
+	slog.Info("Database reachable", "version", version)
 
 	// Test PostGIS availability
 	var postgisVersion string
 	err = conn.QueryRow(ctx, "SELECT PostGIS_Version()").Scan(&postgisVersion)
 	if err != nil {
-		log.Printf("⚠️ PostGIS not available: %v", err)
-		log.Printf("ℹ️ You can still use the application, but spatial functions will be limited")
+		slog.Warn("PostGIS not available, spatial functions will be limited", "error", err)
 	} else {
-		log.Printf("✅ PostGIS version: %s", postgisVersion)
+		slog.Info("PostGIS available", "version", postgisVersion)
 	}
 
 	// Test table existence
 	var count int
 	err = conn.QueryRow(ctx, "SELECT COUNT(*) FROM activity_summaries").Scan(&count)
 	if err != nil {
-		log.Printf("⚠️ Tables don't exist yet. Run with -setup-db flag to create them.")
+		slog.Warn("Tables don't exist yet, run with -setup-db to create them")
 	} else {
-		log.Printf("✅ Tables exist, current activity count: %d", count)
+		slog.Info("Tables exist", "activities", count)
 	}
 
-	log.Printf("🎉 Database test completed successfully!")
+	slog.Info("Database test completed")
 }
 
 func truncateDatabase(ctx context.Context, conn *pgx.Conn) {
-	log.Printf("🗑️ Truncating database tables...")
+	slog.Info("Truncating database tables")
 	if err := pggeo.TruncateTables(ctx, conn); err != nil {
-		log.Fatalf("Error truncating database tables: %v", err)
+		logging.Fatal("Failed to truncate database tables", "error", err)
 	}
-	log.Printf("✅ Database truncated successfully!")
+	slog.Info("Database truncated")
 }
 
 func recreateDatabase(ctx context.Context, conn *pgx.Conn) {
-	log.Printf("🔄 Dropping and recreating database tables...")
+	slog.Info("Dropping and recreating database tables")
 	if err := pggeo.DropAndRecreateTables(ctx, conn); err != nil {
-		log.Fatalf("Error recreating database tables: %v", err)
-	}
-	log.Printf("✅ Database recreated successfully!")
-	log.Printf("📊 Recreated tables:")
-	log.Printf("   - activity_summaries")
-	log.Printf("   - activity_geometries")
-	log.Printf("   - point_samples")
-	log.Printf("   - favorite_segments")
-	log.Printf("   - segment_activity_matches (cache table)")
-	log.Printf("ℹ️ All tables have been dropped and recreated from scratch")
+		logging.Fatal("Failed to recreate database tables", "error", err)
+	}
+	slog.Info("All tables dropped and recreated from scratch",
+		"tables", "activity_summaries,activity_geometries,point_samples,favorite_segments,segment_activity_matches")
 }
 
 func validateDatabaseSchema(ctx context.Context, conn *pgx.Conn, forceRebuild bool) {
-	log.Printf("🔍 Validating database schema...")
+	slog.Info("Validating database schema")
 	if forceRebuild {
-		log.Printf("⚠️ Force rebuild enabled - tables with mismatches will be dropped and recreated")
+		slog.Warn("Force rebuild enabled, tables with mismatches will be dropped and recreated")
 	}
 	if err := pggeo.ValidateAndMigrateSchema(ctx, conn, forceRebuild); err != nil {
-		log.Fatalf("Error validating/migrating database schema: %v", err)
-	}
-	log.Printf("✅ Schema validation completed successfully!")
-	log.Printf("📊 All tables validated and migrated as needed")
-}
-
-func applyEnvOverrides(config *Config) {
-	envString(&config.StravaClientID, "B11K_STRAVA_CLIENT_ID")
-	envString(&config.StravaClientSecret, "B11K_STRAVA_CLIENT_SECRET")
-	envString(&config.StravaRedirectURI, "B11K_STRAVA_REDIRECT_URI")
-	envString(&config.IOSRedirectURI, "B11K_IOS_REDIRECT_URI")
-	envString(&config.PGIP, "B11K_PG_HOST", "B11K_PG_IP")
-	envString(&config.PGPort, "B11K_PG_PORT")
-	envString(&config.PGUser, "B11K_PG_USER")
-	envString(&config.PGPassword, "B11K_PG_PASSWORD", "B11K_PG_SECRET")
-	envString(&config.PGDatabase, "B11K_PG_DATABASE", "B11K_PG_DB")
-	envString(&config.WebHost, "B11K_WEB_HOST")
-	envString(&config.PublicAPIHost, "B11K_PUBLIC_API_HOST")
-	envString(&config.WebPort, "B11K_WEB_PORT")
-	envString(&config.WebProtocol, "B11K_WEB_PROTOCOL")
-	envString(&config.TokenEncryptionKey, "B11K_TOKEN_ENCRYPTION_KEY")
-	envString(&config.MobileActivityOrder, "B11K_MOBILE_ACTIVITY_ORDER")
-	envBool(&config.DevReloadTemplates, "B11K_DEV_RELOAD_TEMPLATES")
-	envBoolPtr(&config.DiscoveredMapEnabled, "B11K_DISCOVERED_MAP_ENABLED")
-	envFloat(&config.DiscoveredRevealRadiusMeters, "B11K_DISCOVERED_REVEAL_RADIUS_METERS")
-	envFloat(&config.DiscoveredSampleDistanceMeters, "B11K_DISCOVERED_SAMPLE_DISTANCE_METERS")
-}
-
-func envString(target *string, names ...string) {
-	for _, name := range names {
-		if value := os.Getenv(name); value != "" {
-			*target = value
-			return
-		}
-	}
-}
-
-func envBool(target *bool, names ...string) {
-	for _, name := range names {
-		value, ok := os.LookupEnv(name)
-		if !ok {
-			continue
-		}
-		switch value {
-		case "1", "true", "TRUE", "yes", "YES", "on", "ON":
-			*target = true
-		case "0", "false", "FALSE", "no", "NO", "off", "OFF":
-			*target = false
-		}
-		return
-	}
-}
-
-func envBoolPtr(target **bool, names ...string) {
-	for _, name := range names {
-		value, ok := os.LookupEnv(name)
-		if !ok {
-			continue
-		}
-		parsed := false
-		switch value {
-		case "1", "true", "TRUE", "yes", "YES", "on", "ON":
-			parsed = true
-		case "0", "false", "FALSE", "no", "NO", "off", "OFF":
-			parsed = false
-		default:
-			return
-		}
-		*target = &parsed
-		return
-	}
-}
-
-func envFloat(target *float64, names ...string) {
-	for _, name := range names {
-		value := os.Getenv(name)
-		if value == "" {
-			continue
-		}
-		parsed, err := strconv.ParseFloat(value, 64)
-		if err == nil {
-			*target = parsed
-		}
-		return
-	}
-}
-
-func normalizeConfig(config *Config) {
-	switch config.MobileActivityOrder {
-	case "map_first", "stats_first":
-	default:
-		config.MobileActivityOrder = "stats_first"
-	}
-	if config.DiscoveredMapEnabled == nil {
-		enabled := true
-		config.DiscoveredMapEnabled = &enabled
-	}
-	if config.DiscoveredRevealRadiusMeters <= 0 {
-		config.DiscoveredRevealRadiusMeters = 100
-	}
-	if config.DiscoveredSampleDistanceMeters <= 0 {
-		config.DiscoveredSampleDistanceMeters = 50
-	}
-	if config.IOSRedirectURI == "" {
-		host := config.PublicAPIHost
-		if host == "" {
-			host = config.WebHost
-		}
-		if host == "" {
-			host = "localhost"
-		}
-		protocol := "http"
-		if config.WebProtocol == "https" {
-			protocol = "https"
-		}
-		if protocol == "https" || config.WebPort == "" || config.WebPort == "80" {
-			config.IOSRedirectURI = fmt.Sprintf("%s://%s/api/mobile/auth/callback", protocol, host)
-		} else {
-			config.IOSRedirectURI = fmt.Sprintf("%s://%s:%s/api/mobile/auth/callback", protocol, host, config.WebPort)
-		}
+		logging.Fatal("Failed to validate or migrate the database schema", "error", err)
 	}
+	slog.Info("Schema validation completed, all tables validated and migrated as needed")
 }
 
-func connectDatabase(ctx context.Context, config Config) (*pgx.Conn, error) {
+func connectDatabase(ctx context.Context, cfg config.Config) (*pgx.Conn, error) {
 	var lastErr error
 	for attempt := 1; attempt <= 30; attempt++ {
-		conn, err := pggeo.Connect(ctx, config.PGUser, config.PGPassword, config.PGIP, config.PGPort, config.PGDatabase)
+		conn, err := pggeo.Connect(ctx, cfg.PGUser, cfg.PGPassword, cfg.PGIP, cfg.PGPort, cfg.PGDatabase)
 		if err == nil {
 			return conn, nil
 		}
 		lastErr = err
-		log.Printf("Waiting for database at %s:%s (%d/30): %v", config.PGIP, config.PGPort, attempt, err)
+		slog.Info("Waiting for database", "host", cfg.PGIP, "port", cfg.PGPort, "attempt", attempt, "max_attempts", 30, "error", err)
 
 		select {
 		case <-ctx.Done():
@@ -386,47 +343,52 @@ func connectDatabase(ctx context.Context, config Config) (*pgx.Conn, error) {
 	return nil, lastErr
 }
 
-func runSync(ctx context.Context, config Config) {
+func runSync(ctx context.Context, cfg config.Config) {
 	// Authenticate with Strava
-	authCfg := strava.NewStravaAuthConfig(config.StravaClientID, config.StravaClientSecret, config.StravaRedirectURI)
-	token, err := strava.ConsoleLogin(*authCfg)
+	authCfg := strava.NewStravaAuthConfig(cfg.StravaClientID, cfg.StravaClientSecret, cfg.StravaRedirectURI)
+	token, err := strava.ConsoleLogin(ctx, *authCfg)
 	if err != nil {
-		log.Fatalf("Error logging in: %v", err)
+		logging.Fatal("Failed to log in to Strava", "error", err)
 	}
 
 	// Create database tables if they don't exist
-	log.Printf("🔧 Setting up database tables...")
-	conn, err := connectDatabase(ctx, config)
+	slog.Info("Setting up database tables")
+	conn, err := connectDatabase(ctx, cfg)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		logging.Fatal("Failed to connect to the database", "error", err)
 	}
 	defer conn.Close(ctx)
 
 	if err := pggeo.CreateTables(ctx, conn); err != nil {
-		log.Fatalf("Error creating database tables: %v", err)
+		logging.Fatal("Failed to create database tables", "error", err)
 	}
-	log.Printf("✅ Database tables ready")
+	slog.Info("Database tables ready")
 
 	// Create sync configuration
 	syncConfig := sync.SyncConfig{
 		StravaAccessToken: token,
 		DatabaseConfig: sync.DatabaseConfig{
-			Host:     config.PGIP,
-			Port:     config.PGPort,
-			User:     config.PGUser,
-			Password: config.PGPassword,
-			Database: config.PGDatabase,
+			Host:     cfg.PGIP,
+			Port:     cfg.PGPort,
+			User:     cfg.PGUser,
+			Password: cfg.PGPassword,
+			Database: cfg.PGDatabase,
 		},
 		Timeframe: sync.TimeframeConfig{
 			StartTime: time.Now().AddDate(0, 0, -30), // Last 30 days
 			EndTime:   time.Time{},                   // No end time (current)
 		},
+		ActivityTypes: cfg.ActivityTypes,
+		HealGPSSpikes: cfg.HealGPSSpikes,
+		MatchSegments: !cfg.LazySegmentCache,
+		Limits:        softLimits(cfg),
+		TagRules:      cfg.SyncTagRules(),
 	}
 
 	// Perform the sync (no progress callback for CLI)
 	result, err := sync.SyncActivitiesFromStravaWithRetry(ctx, syncConfig, 3, nil)
 	if err != nil {
-		log.Fatalf("Error syncing activities: %v", err)
+		logging.Fatal("Failed to sync activities", "error", err)
 	}
 
 	// Print results
@@ -437,6 +399,11 @@ func runSync(ctx context.Context, config Config) {
 	fmt.Printf("   - New activities: %d\n", result.NewActivities)
 	fmt.Printf("   - Successfully processed: %d\n", result.SuccessfullyProcessed)
 	fmt.Printf("   - Failed activities: %d\n", len(result.FailedActivities))
+	fmt.Printf("   - Gone from Strava: %d\n", len(result.GoneActivities))
+	fmt.Printf("   - Rejected: %d\n", len(result.RejectedActivities))
+	fmt.Printf("   - Skipped: %d\n", result.SkippedActivities)
+	fmt.Printf("   - New gear: %d\n", result.NewGear)
+	fmt.Printf("   - Segment matches: %d\n", result.SegmentMatches)
 	fmt.Printf("   - Processing time: %v\n", result.ProcessingTime)
 
 	if len(result.FailedActivities) > 0 {
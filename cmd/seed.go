@@ -0,0 +1,67 @@
+// Copyright (c) 2025 B11K contributors
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"time"
+
+	"b11k/internal/logging"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type seedCommand struct {
+	opts pggeo.SeedOptions
+	wipe bool
+}
+
+// parseSeedArgs parses the flags of `b11k seed`
+func parseSeedArgs(args []string) seedCommand {
+	defaults := pggeo.DefaultSeedOptions()
+	cmd := seedCommand{opts: defaults}
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	fs.IntVar(&cmd.opts.Athletes, "athletes", defaults.Athletes, "Number of demo athletes")
+	fs.IntVar(&cmd.opts.ActivitiesPerAthlete, "activities", defaults.ActivitiesPerAthlete, "Activities per athlete")
+	fs.IntVar(&cmd.opts.SegmentsPerAthlete, "segments", defaults.SegmentsPerAthlete, "Favorite segments per athlete")
+	fs.Int64Var(&cmd.opts.Seed, "seed", defaults.Seed, "Random seed; the same seed always generates the same data")
+	fs.Float64Var(&cmd.opts.CenterLat, "center-lat", defaults.CenterLat, "Latitude routes are generated around")
+	fs.Float64Var(&cmd.opts.CenterLng, "center-lng", defaults.CenterLng, "Longitude routes are generated around")
+	fs.Int64Var(&cmd.opts.FirstAthleteID, "athlete-id", defaults.FirstAthleteID, "ID of the first demo athlete; use your own Strava athlete ID to see the data when logged in")
+	startDate := fs.String("start-date", defaults.StartDate.Format("2006-01-02"), "Date of the first generated ride (YYYY-MM-DD)")
+	fs.BoolVar(&cmd.wipe, "wipe", false, "Delete previously seeded data first")
+	_ = fs.Parse(args) // ExitOnError
+
+	parsed, err := time.Parse("2006-01-02", *startDate)
+	if err != nil {
+		logging.Fatal("Invalid -start-date", "start_date", *startDate, "error", err)
+	}
+	cmd.opts.StartDate = parsed
+	return cmd
+}
+
+func runSeed(ctx context.Context, conn *pgx.Conn, cmd seedCommand) {
+	if cmd.wipe {
+		activities, segments, err := pggeo.WipeSeedData(ctx, conn)
+		if err != nil {
+			logging.Fatal("Failed to wipe seed data", "error", err)
+		}
+		slog.Info("Removed seed data", "activities", activities, "segments", segments)
+		if cmd.opts.ActivitiesPerAthlete == 0 {
+			return
+		}
+	}
+
+	slog.Info("Seeding demo data", "athletes", cmd.opts.Athletes, "activities_per_athlete", cmd.opts.ActivitiesPerAthlete, "seed", cmd.opts.Seed)
+	started := time.Now()
+	result, err := pggeo.SeedDemoData(ctx, conn, cmd.opts)
+	if err != nil {
+		logging.Fatal("Failed to seed demo data, rerun with -wipe to replace earlier seed data", "error", err)
+	}
+	slog.Info("Seeded demo data", "activities", result.Activities, "segments", result.Segments, "segment_efforts", result.SegmentEfforts,
+		"athlete_ids", result.AthleteIDs, "duration", time.Since(started).Round(time.Second))
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2025 B11K contributors
+// Licensed under the Apache License, Version 2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"strings"
+
+	"b11k/internal/config"
+	"b11k/internal/logging"
+	"b11k/internal/strava"
+)
+
+type webhookCommand struct {
+	action      string
+	callbackURL string
+}
+
+// parseWebhookArgs parses `b11k webhook [view|create|delete]`
+func parseWebhookArgs(args []string) webhookCommand {
+	fs := flag.NewFlagSet("webhook", flag.ExitOnError)
+	callbackURL := fs.String("callback-url", "", "Public URL of /strava/webhook; defaults to the Strava redirect URI's host and base path")
+	_ = fs.Parse(args) // ExitOnError
+
+	cmd := webhookCommand{action: fs.Arg(0), callbackURL: *callbackURL}
+	if cmd.action == "" {
+		cmd.action = "view"
+	}
+	switch cmd.action {
+	case "view", "create", "delete":
+	default:
+		logging.Fatal("Unknown webhook action, want view, create or delete", "action", cmd.action)
+	}
+	return cmd
+}
+
+// runWebhook manages the app's Strava push subscription. Creating it needs the server
+// running with strava_webhook_verify_token set, since Strava checks the callback at once.
+func runWebhook(cfg config.Config, cmd webhookCommand) {
+	ctx := context.Background()
+	authCfg := strava.NewStravaAuthConfig(cfg.StravaClientID, cfg.StravaClientSecret, cfg.StravaRedirectURI)
+
+	subscription, err := strava.ViewWebhookSubscription(ctx, *authCfg)
+	if err != nil {
+		logging.Fatal("Failed to view the Strava webhook subscription", "error", err)
+	}
+
+	switch cmd.action {
+	case "view":
+		if subscription == nil {
+			slog.Info("No Strava webhook subscription")
+			return
+		}
+		slog.Info("Strava webhook subscription", "subscription_id", subscription.ID, "callback_url", subscription.CallbackURL)
+	case "create":
+		if cfg.StravaWebhookVerifyToken == "" {
+			logging.Fatal("Set strava_webhook_verify_token (or B11K_STRAVA_WEBHOOK_VERIFY_TOKEN) and restart the server first")
+		}
+		if subscription != nil {
+			logging.Fatal("A Strava webhook subscription already exists, delete it first", "subscription_id", subscription.ID, "callback_url", subscription.CallbackURL)
+		}
+		callbackURL := cmd.callbackURL
+		if callbackURL == "" {
+			callbackURL = strings.TrimSuffix(cfg.StravaRedirectURI, "/strava/callback") + "/strava/webhook"
+		}
+		created, err := strava.CreateWebhookSubscription(ctx, *authCfg, callbackURL, cfg.StravaWebhookVerifyToken)
+		if err != nil {
+			logging.Fatal("Failed to create the Strava webhook subscription", "error", err)
+		}
+		slog.Info("Created Strava webhook subscription", "subscription_id", created.ID, "callback_url", callbackURL)
+	case "delete":
+		if subscription == nil {
+			slog.Info("No Strava webhook subscription to delete")
+			return
+		}
+		if err := strava.DeleteWebhookSubscription(ctx, *authCfg, subscription.ID); err != nil {
+			logging.Fatal("Failed to delete the Strava webhook subscription", "error", err)
+		}
+		slog.Info("Deleted Strava webhook subscription", "subscription_id", subscription.ID)
+	}
+}
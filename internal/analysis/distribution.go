@@ -0,0 +1,169 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"b11k/internal/pggeo"
+)
+
+// Metrics that can be compared as distributions
+const (
+	DistributionMetricWatts     = "watts"
+	DistributionMetricCadence   = "cadence"
+	DistributionMetricHeartrate = "heartrate"
+)
+
+// MaxDistributionBins bounds the histogram resolution a caller can request.
+const MaxDistributionBins = 100
+
+// ValidDistributionMetric reports whether metric can be turned into a distribution.
+func ValidDistributionMetric(metric string) bool {
+	switch metric {
+	case DistributionMetricWatts, DistributionMetricCadence, DistributionMetricHeartrate:
+		return true
+	}
+	return false
+}
+
+// EffortDistribution is one effort's histogram over the shared bin edges.
+type EffortDistribution struct {
+	ActivityID int64    `json:"activity_id"`
+	Effort     int      `json:"effort,omitempty"` // traversal within the activity, set by the caller
+	Counts     []int    `json:"counts"`
+	Samples    int      `json:"samples"`
+	Excluded   int      `json:"excluded"`
+	Median     *float64 `json:"median"`
+	P95        *float64 `json:"p95"`
+}
+
+// DistributionComparison holds histograms for several efforts with common bin edges,
+// so bin i covers [Edges[i], Edges[i+1]) for every effort (the last bin is closed).
+type DistributionComparison struct {
+	Metric  string               `json:"metric"`
+	Edges   []float64            `json:"edges"`
+	Efforts []EffortDistribution `json:"efforts"`
+}
+
+// MetricValues returns the positive values of metric in samples. Missing and zero
+// readings (coasting, sensor dropouts) are counted as excluded.
+func MetricValues(samples []pggeo.PointSample, metric string) ([]float64, int) {
+	values := make([]float64, 0, len(samples))
+	excluded := 0
+	for _, sample := range samples {
+		var value *int
+		switch metric {
+		case DistributionMetricWatts:
+			value = sample.Watts
+		case DistributionMetricCadence:
+			value = sample.Cadence
+		case DistributionMetricHeartrate:
+			value = sample.Heartrate
+		}
+		if value == nil || *value <= 0 {
+			excluded++
+			continue
+		}
+		values = append(values, float64(*value))
+	}
+	return values, excluded
+}
+
+// CompareDistributions builds histograms for each effort using bin edges derived from
+// the combined min/max of all efforts. values and excluded are indexed like activityIDs.
+func CompareDistributions(metric string, bins int, activityIDs []int64, values [][]float64, excluded []int) DistributionComparison {
+	edges := CommonBinEdges(values, bins)
+	comparison := DistributionComparison{
+		Metric:  metric,
+		Edges:   edges,
+		Efforts: make([]EffortDistribution, len(activityIDs)),
+	}
+	for i, activityID := range activityIDs {
+		sorted := append([]float64(nil), values[i]...)
+		sort.Float64s(sorted)
+		effort := EffortDistribution{
+			ActivityID: activityID,
+			Counts:     BinCounts(sorted, edges),
+			Samples:    len(sorted),
+			Excluded:   excluded[i],
+		}
+		if len(sorted) > 0 {
+			median := Percentile(sorted, 50)
+			p95 := Percentile(sorted, 95)
+			effort.Median = &median
+			effort.P95 = &p95
+		}
+		comparison.Efforts[i] = effort
+	}
+	return comparison
+}
+
+// CommonBinEdges returns bins+1 evenly spaced edges spanning every value in sets. It
+// returns no edges when the sets hold no values at all.
+func CommonBinEdges(sets [][]float64, bins int) []float64 {
+	if bins <= 0 {
+		return nil
+	}
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+	for _, set := range sets {
+		for _, v := range set {
+			minValue = math.Min(minValue, v)
+			maxValue = math.Max(maxValue, v)
+		}
+	}
+	if math.IsInf(minValue, 1) {
+		return []float64{}
+	}
+	if maxValue == minValue {
+		maxValue = minValue + 1
+	}
+
+	width := (maxValue - minValue) / float64(bins)
+	edges := make([]float64, bins+1)
+	for i := range edges {
+		edges[i] = minValue + float64(i)*width
+	}
+	edges[bins] = maxValue
+	return edges
+}
+
+// BinCounts counts values into the bins described by edges. Values on the upper edge
+// fall into the last bin.
+func BinCounts(values, edges []float64) []int {
+	if len(edges) < 2 {
+		return []int{}
+	}
+	bins := len(edges) - 1
+	counts := make([]int, bins)
+	minValue, maxValue := edges[0], edges[bins]
+	width := (maxValue - minValue) / float64(bins)
+	for _, v := range values {
+		if v < minValue || v > maxValue {
+			continue
+		}
+		index := int((v - minValue) / width)
+		if index >= bins {
+			index = bins - 1
+		}
+		counts[index]++
+	}
+	return counts
+}
+
+// Percentile returns the p-th percentile (0-100) of sorted values using linear
+// interpolation between closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if upper >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func wattsSamples(watts ...int) []pggeo.PointSample {
+	samples := make([]pggeo.PointSample, len(watts))
+	for i := range watts {
+		if watts[i] < 0 {
+			continue // nil reading
+		}
+		w := watts[i]
+		samples[i] = pggeo.PointSample{PointIndex: i, Watts: &w}
+	}
+	return samples
+}
+
+func TestMetricValuesExcludesNullsAndZeros(t *testing.T) {
+	values, excluded := MetricValues(wattsSamples(200, 0, -1, 250), DistributionMetricWatts)
+	if len(values) != 2 || values[0] != 200 || values[1] != 250 {
+		t.Fatalf("values = %v, want [200 250]", values)
+	}
+	if excluded != 2 {
+		t.Fatalf("excluded = %d, want 2", excluded)
+	}
+}
+
+func TestCompareDistributionsUsesCommonEdges(t *testing.T) {
+	grind, grindExcluded := MetricValues(wattsSamples(100, 150, 200, 0), DistributionMetricWatts)
+	spin, spinExcluded := MetricValues(wattsSamples(250, 300, 400), DistributionMetricWatts)
+	got := CompareDistributions(DistributionMetricWatts, 6, []int64{1, 2}, [][]float64{grind, spin}, []int{grindExcluded, spinExcluded})
+
+	if len(got.Edges) != 7 {
+		t.Fatalf("got %d edges, want 7", len(got.Edges))
+	}
+	if got.Edges[0] != 100 || got.Edges[6] != 400 {
+		t.Fatalf("edges span [%v, %v], want combined [100, 400]", got.Edges[0], got.Edges[6])
+	}
+	for i := 1; i < len(got.Edges); i++ {
+		if math.Abs((got.Edges[i]-got.Edges[i-1])-50) > 1e-9 {
+			t.Fatalf("edge %d width = %v, want 50", i, got.Edges[i]-got.Edges[i-1])
+		}
+	}
+
+	for _, effort := range got.Efforts {
+		if len(effort.Counts) != len(got.Edges)-1 {
+			t.Fatalf("effort %d has %d bins, want %d", effort.ActivityID, len(effort.Counts), len(got.Edges)-1)
+		}
+		total := 0
+		for _, c := range effort.Counts {
+			total += c
+		}
+		if total != effort.Samples {
+			t.Fatalf("effort %d counts sum to %d, want %d samples", effort.ActivityID, total, effort.Samples)
+		}
+	}
+	if got.Efforts[0].Excluded != 1 || got.Efforts[1].Excluded != 0 {
+		t.Fatalf("excluded = %d/%d, want 1/0", got.Efforts[0].Excluded, got.Efforts[1].Excluded)
+	}
+	// The maximum value lands in the last bin rather than past it
+	if got.Efforts[1].Counts[5] != 1 {
+		t.Fatalf("last bin = %d, want 1", got.Efforts[1].Counts[5])
+	}
+	if got.Efforts[0].Median == nil || *got.Efforts[0].Median != 150 {
+		t.Fatalf("median = %v, want 150", got.Efforts[0].Median)
+	}
+}
+
+func TestCompareDistributionsEffortWithoutMetric(t *testing.T) {
+	withPower, withExcluded := MetricValues(wattsSamples(180, 220), DistributionMetricWatts)
+	noPower, noExcluded := MetricValues(wattsSamples(-1, -1, -1), DistributionMetricWatts)
+	got := CompareDistributions(DistributionMetricWatts, 4, []int64{1, 2}, [][]float64{withPower, noPower}, []int{withExcluded, noExcluded})
+
+	if len(got.Edges) != 5 || got.Edges[0] != 180 || got.Edges[4] != 220 {
+		t.Fatalf("edges = %v, want 5 edges spanning the effort that has power", got.Edges)
+	}
+	missing := got.Efforts[1]
+	if missing.Samples != 0 || missing.Excluded != 3 || missing.Median != nil || missing.P95 != nil {
+		t.Fatalf("effort without power = %+v, want no samples, 3 excluded and no stats", missing)
+	}
+	if len(missing.Counts) != 4 {
+		t.Fatalf("effort without power has %d bins, want 4 zeroed bins", len(missing.Counts))
+	}
+	for _, c := range missing.Counts {
+		if c != 0 {
+			t.Fatalf("counts = %v, want all zero", missing.Counts)
+		}
+	}
+
+	none := CompareDistributions(DistributionMetricWatts, 4, []int64{2}, [][]float64{noPower}, []int{noExcluded})
+	if len(none.Edges) != 0 || len(none.Efforts[0].Counts) != 0 {
+		t.Fatalf("all-missing comparison = %+v, want no edges or bins", none)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	if got := Percentile(sorted, 50); got != 6 {
+		t.Fatalf("p50 = %v, want 6", got)
+	}
+	if got := Percentile(sorted, 95); math.Abs(got-10.5) > 1e-9 {
+		t.Fatalf("p95 = %v, want 10.5", got)
+	}
+}
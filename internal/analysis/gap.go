@@ -0,0 +1,98 @@
+// Package analysis contains effort normalization helpers that work on stored point samples.
+package analysis
+
+import (
+	"math"
+
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+)
+
+// flatCost is the Minetti et al. (2002) energy cost of locomotion on flat ground, J/(kg·m).
+const flatCost = 3.6
+
+// maxAdjustableGrade bounds the grade range the Minetti polynomial was fitted on (±45%).
+const maxAdjustableGrade = 0.45
+
+// GradeAdjustmentFactor returns the multiplier that converts a speed held on the given
+// grade (rise over run, e.g. 0.05 for 5%) into its flat-ground equivalent. It uses the
+// energy cost curve published by Minetti et al. (2002), so climbs are scaled up and
+// shallow descents are scaled down.
+func GradeAdjustmentFactor(grade float64) float64 {
+	if math.IsNaN(grade) || math.IsInf(grade, 0) {
+		return 1
+	}
+	g := math.Max(-maxAdjustableGrade, math.Min(maxAdjustableGrade, grade))
+	cost := 155.4*math.Pow(g, 5) - 30.4*math.Pow(g, 4) - 43.3*math.Pow(g, 3) + 46.3*g*g + 19.5*g + flatCost
+	return cost / flatCost
+}
+
+// HasGradeData reports whether samples carry enough grade or altitude data to adjust speed.
+func HasGradeData(samples []pggeo.PointSample) bool {
+	altitudes := 0
+	for _, sample := range samples {
+		if sample.Grade != nil {
+			return true
+		}
+		if sample.Altitude != nil {
+			altitudes++
+			if altitudes >= 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GradeAdjustedSpeed returns the flat-equivalent average speed in m/s for the samples of
+// an effort. Every sample speed is scaled by the adjustment factor for its grade before
+// averaging, so it is a sample mean and on flat ground equals SampleMeanSpeed. Samples use the recorded
+// grade stream when present and otherwise the altitude change from the previous sample.
+// Efforts without grade or altitude data fall back to SampleMeanSpeed; use HasGradeData to tell
+// the two cases apart.
+func GradeAdjustedSpeed(samples []pggeo.PointSample) float64 {
+	if !HasGradeData(samples) {
+		return SampleMeanSpeed(samples)
+	}
+
+	var sum float64
+	count := 0
+	for i, sample := range samples {
+		if sample.Speed == nil {
+			continue
+		}
+		factor := 1.0
+		if grade, ok := sampleGrade(samples, i); ok {
+			factor = GradeAdjustmentFactor(grade)
+		}
+		sum += *sample.Speed * factor
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// sampleGrade returns the grade at samples[i] as rise over run.
+func sampleGrade(samples []pggeo.PointSample, i int) (float64, bool) {
+	sample := samples[i]
+	if sample.Grade != nil {
+		return *sample.Grade / 100.0, true
+	}
+	if i == 0 || sample.Altitude == nil || samples[i-1].Altitude == nil {
+		return 0, false
+	}
+	prev := samples[i-1]
+	run := 0.0
+	if sample.CumulativeDistance != nil && prev.CumulativeDistance != nil {
+		run = *sample.CumulativeDistance - *prev.CumulativeDistance
+	}
+	if run <= 0 {
+		run = geo.HaversineMeters(prev.Lat, prev.Lng, sample.Lat, sample.Lng)
+	}
+	if run < 1 {
+		return 0, false
+	}
+	return (*sample.Altitude - *prev.Altitude) / run, true
+}
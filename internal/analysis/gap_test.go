@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+func TestGradeAdjustmentFactor(t *testing.T) {
+	tests := []struct {
+		grade float64
+		want  float64
+	}{
+		{grade: -0.45, want: 1.1201},
+		{grade: -0.20, want: 0.5000},
+		{grade: -0.10, want: 0.5977},
+		{grade: -0.05, want: 0.7628},
+		{grade: 0, want: 1.0},
+		{grade: 0.05, want: 1.3014},
+		{grade: 0.10, want: 1.6578},
+		{grade: 0.20, want: 2.5019},
+		{grade: 0.30, want: 3.4942},
+		{grade: 0.45, want: 5.3961},
+		{grade: 0.60, want: 5.3961}, // clamped to the fitted range
+		{grade: math.NaN(), want: 1.0},
+	}
+
+	for _, tt := range tests {
+		if got := GradeAdjustmentFactor(tt.grade); math.Abs(got-tt.want) > 0.0005 {
+			t.Fatalf("GradeAdjustmentFactor(%v) = %.4f, want %.4f", tt.grade, got, tt.want)
+		}
+	}
+}
+
+func TestGradeAdjustedSpeedUsesGradeStream(t *testing.T) {
+	samples := []pggeo.PointSample{
+		testSample(0, 5, floatPtr(10), nil),
+		testSample(1, 5, floatPtr(10), nil),
+	}
+
+	got := GradeAdjustedSpeed(samples)
+	want := 5 * GradeAdjustmentFactor(0.10)
+	if math.Abs(got-want) > 1e-9 {
+		t.Fatalf("GradeAdjustedSpeed = %v, want %v", got, want)
+	}
+	if !HasGradeData(samples) {
+		t.Fatal("HasGradeData = false, want true")
+	}
+}
+
+func TestGradeAdjustedSpeedFromAltitude(t *testing.T) {
+	first := testSample(0, 4, nil, floatPtr(100))
+	first.CumulativeDistance = floatPtr(0)
+	second := testSample(1, 4, nil, floatPtr(105))
+	second.CumulativeDistance = floatPtr(100)
+
+	got := GradeAdjustedSpeed([]pggeo.PointSample{first, second})
+	// The first sample has no previous altitude and stays unadjusted.
+	want := (4 + 4*GradeAdjustmentFactor(0.05)) / 2
+	if math.Abs(got-want) > 1e-9 {
+		t.Fatalf("GradeAdjustedSpeed = %v, want %v", got, want)
+	}
+}
+
+func TestGradeAdjustedSpeedFallsBackToSampleMeanSpeed(t *testing.T) {
+	samples := []pggeo.PointSample{
+		testSample(0, 6, nil, nil),
+		testSample(1, 8, nil, nil),
+	}
+
+	if HasGradeData(samples) {
+		t.Fatal("HasGradeData = true, want false")
+	}
+	if got := GradeAdjustedSpeed(samples); got != 7 {
+		t.Fatalf("GradeAdjustedSpeed = %v, want the sample mean 7", got)
+	}
+}
+
+func TestGradeAdjustedSpeedWithDuplicateTimestamps(t *testing.T) {
+	// Index 2 is a merged sensor sample repeating the time and place of index 1
+	samples := []pggeo.PointSample{
+		testSample(0, 5, nil, floatPtr(100)),
+		testSample(1, 5, nil, floatPtr(101)),
+		testSample(1, 5, nil, floatPtr(101)),
+		testSample(2, 5, nil, floatPtr(102)),
+	}
+	for i := range samples {
+		samples[i].PointIndex = i
+		samples[i].Lng = float64(samples[i].Time.Unix()) * 0.0001
+	}
+
+	for name, got := range map[string]float64{
+		"SampleMeanSpeed":    SampleMeanSpeed(samples),
+		"GradeAdjustedSpeed": GradeAdjustedSpeed(samples),
+	} {
+		if math.IsNaN(got) || math.IsInf(got, 0) || got <= 0 {
+			t.Fatalf("%s = %v, want a finite positive speed", name, got)
+		}
+	}
+}
+
+func testSample(index int, speed float64, grade, altitude *float64) pggeo.PointSample {
+	return pggeo.PointSample{
+		PointIndex: index,
+		Time:       time.Unix(int64(index), 0),
+		Speed:      &speed,
+		Grade:      grade,
+		Altitude:   altitude,
+	}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
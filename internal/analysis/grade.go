@@ -0,0 +1,119 @@
+package analysis
+
+import (
+	"math"
+
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+)
+
+// DefaultGradeWindowMeters is the distance RecomputeGrades averages altitude over
+const DefaultGradeWindowMeters = 50.0
+
+// maxRecomputedGrade clamps recomputed grades, in percent; steeper values on a bike are
+// altitude glitches rather than road
+const maxRecomputedGrade = 35.0
+
+// RecomputeGrades derives a grade stream in percent from the samples' altitudes, for use
+// after the altitudes have been corrected. It is RecomputeGradesOver with
+// DefaultGradeWindowMeters.
+func RecomputeGrades(samples []pggeo.PointSample) []float64 {
+	return RecomputeGradesOver(samples, DefaultGradeWindowMeters)
+}
+
+// RecomputeGradesOver returns one grade per sample, in percent like Strava's grade_smooth.
+// Altitudes are averaged over windowMeters of distance centred on each sample, and each
+// grade is the slope of that smoothed profile across the same window, clamped to ±35%.
+// Summing grade × distance therefore follows the corrected profile without picking up
+// altimeter noise. Samples without altitude repeat the previous grade. It returns nil
+// when fewer than two samples have an altitude.
+func RecomputeGradesOver(samples []pggeo.PointSample, windowMeters float64) []float64 {
+	var withAltitude []int
+	for i, sample := range samples {
+		if sample.Altitude != nil && !math.IsNaN(*sample.Altitude) {
+			withAltitude = append(withAltitude, i)
+		}
+	}
+	if len(withAltitude) < 2 {
+		return nil
+	}
+
+	along := sampleDistances(samples)
+	distances := make([]float64, len(withAltitude))
+	altitudes := make([]float64, len(withAltitude))
+	for k, i := range withAltitude {
+		distances[k] = along[i]
+		altitudes[k] = *samples[i].Altitude
+	}
+	halfWindow := windowMeters / 2
+	smoothed := windowMeans(distances, altitudes, halfWindow)
+
+	grades := make([]float64, len(samples))
+	last := 0.0
+	lo, hi, k := 0, 0, 0
+	for i := range samples {
+		if k < len(withAltitude) && withAltitude[k] == i {
+			for hi+1 < len(distances) && distances[hi+1] <= distances[k]+halfWindow {
+				hi++
+			}
+			for distances[lo] < distances[k]-halfWindow {
+				lo++
+			}
+			a, b := lo, hi
+			if a == b {
+				// Samples further apart than the window: use the nearest step
+				if k > 0 {
+					a = k - 1
+				} else {
+					b = k + 1
+				}
+			}
+			if run := distances[b] - distances[a]; run > 0 {
+				grade := 100 * (smoothed[b] - smoothed[a]) / run
+				last = math.Max(-maxRecomputedGrade, math.Min(maxRecomputedGrade, grade))
+			}
+			k++
+		}
+		grades[i] = last
+	}
+	return grades
+}
+
+// sampleDistances returns the distance along the track at each sample. It follows
+// cumulative_distance where consecutive samples both have it and falls back to the
+// straight-line distance between their positions.
+func sampleDistances(samples []pggeo.PointSample) []float64 {
+	distances := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		prev, sample := samples[i-1], samples[i]
+		step := -1.0
+		if sample.CumulativeDistance != nil && prev.CumulativeDistance != nil {
+			step = *sample.CumulativeDistance - *prev.CumulativeDistance
+		}
+		if step < 0 {
+			step = geo.HaversineMeters(prev.Lat, prev.Lng, sample.Lat, sample.Lng)
+		}
+		distances[i] = distances[i-1] + step
+	}
+	return distances
+}
+
+// windowMeans averages the values lying within halfWindow metres of each distance.
+// distances must be ascending.
+func windowMeans(distances, values []float64, halfWindow float64) []float64 {
+	means := make([]float64, len(values))
+	lo, hi := 0, 0
+	sum := 0.0
+	for k, distance := range distances {
+		for hi < len(values) && distances[hi] <= distance+halfWindow {
+			sum += values[hi]
+			hi++
+		}
+		for distances[lo] < distance-halfWindow {
+			sum -= values[lo]
+			lo++
+		}
+		means[k] = sum / float64(hi-lo)
+	}
+	return means
+}
@@ -0,0 +1,70 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+// hillProfile is a 4 km ride over a 60 m sine hill, a sample every 5 m, with ±0.4 m of
+// alternating altimeter noise on top
+func hillProfile() ([]pggeo.PointSample, []float64) {
+	var samples []pggeo.PointSample
+	var truth []float64
+	for i := 0; i <= 800; i++ {
+		distance := float64(i) * 5
+		altitude := 100 + 30*(1-math.Cos(2*math.Pi*distance/4000))
+		noisy := altitude + 0.4*float64(1-2*(i%2))
+		truth = append(truth, altitude)
+		samples = append(samples, pggeo.PointSample{
+			PointIndex:         i,
+			Lat:                45 + distance/111000,
+			Lng:                7,
+			Altitude:           floatPtr(noisy),
+			Grade:              floatPtr(0), // what Strava sent; ignored
+			CumulativeDistance: floatPtr(distance),
+		})
+	}
+	return samples, truth
+}
+
+func TestRecomputeGradesIntegrateToTheCorrectedProfile(t *testing.T) {
+	samples, truth := hillProfile()
+	grades := RecomputeGrades(samples)
+	if len(grades) != len(samples) {
+		t.Fatalf("got %d grades for %d samples", len(grades), len(samples))
+	}
+
+	altitude := truth[0]
+	for i := 1; i < len(samples); i++ {
+		altitude += grades[i] / 100 * (*samples[i].CumulativeDistance - *samples[i-1].CumulativeDistance)
+		if math.Abs(altitude-truth[i]) > 1.5 {
+			t.Fatalf("integrated altitude at %d = %.2f, want %.2f", i, altitude, truth[i])
+		}
+	}
+	// Steepest point of the hill, a quarter of the way in: 30·2π/4000 ≈ 4.7%
+	if math.Abs(grades[200]-4.71) > 0.3 {
+		t.Fatalf("grade on the climb = %.2f%%, want about 4.7%%", grades[200])
+	}
+}
+
+func TestRecomputeGradesClampsAndFillsGaps(t *testing.T) {
+	samples := []pggeo.PointSample{
+		{CumulativeDistance: floatPtr(0), Altitude: floatPtr(100)},
+		{CumulativeDistance: floatPtr(10), Altitude: floatPtr(130)}, // a 300% glitch
+		{CumulativeDistance: floatPtr(20)},
+		{CumulativeDistance: floatPtr(30), Altitude: floatPtr(130)},
+	}
+	grades := RecomputeGradesOver(samples, 1)
+	want := []float64{35, 35, 35, 0}
+	for i := range want {
+		if grades[i] != want[i] {
+			t.Fatalf("grades = %v, want %v", grades, want)
+		}
+	}
+
+	if grades := RecomputeGrades(samples[2:3]); grades != nil {
+		t.Fatalf("grades without altitude = %v, want nil", grades)
+	}
+}
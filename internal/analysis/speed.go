@@ -0,0 +1,99 @@
+package analysis
+
+import (
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+)
+
+// Average speeds come in three derivations that differ for the same ride:
+//
+//   - moving: distance over moving time. Strava's average_speed for an activity, exposed
+//     as avg_speed_moving.
+//   - elapsed: distance over elapsed time, stops included. Segment efforts are timed from
+//     start to finish, so their speeds (avg_speed_elapsed) use it.
+//   - sample mean: the plain mean of the recorded speed samples. It leans towards the
+//     speeds where the device recorded more points, so it is only used where speeds are
+//     reweighted sample by sample, as in GradeAdjustedSpeed.
+//
+// For the same stretch, moving >= elapsed, and on evenly sampled data without stops
+// the sample mean is close to the moving average.
+
+// movingSpeedThreshold is the speed below which a sample without a moving flag counts as stopped, in m/s
+const movingSpeedThreshold = 0.5
+
+// MovingAvgSpeed returns distance over moving time in m/s, or 0 without moving time
+func MovingAvgSpeed(distanceM, movingSeconds float64) float64 {
+	if movingSeconds <= 0 || distanceM <= 0 {
+		return 0
+	}
+	return distanceM / movingSeconds
+}
+
+// ElapsedAvgSpeed returns distance over elapsed time in m/s, or 0 without elapsed time
+func ElapsedAvgSpeed(distanceM, elapsedSeconds float64) float64 {
+	if elapsedSeconds <= 0 || distanceM <= 0 {
+		return 0
+	}
+	return distanceM / elapsedSeconds
+}
+
+// SampleMeanSpeed returns the plain average of the sample speeds in m/s. It is biased by
+// the sampling rate; prefer MovingAvgSpeed or ElapsedAvgSpeed for reported averages.
+func SampleMeanSpeed(samples []pggeo.PointSample) float64 {
+	var sum float64
+	count := 0
+	for _, sample := range samples {
+		if sample.Speed == nil {
+			continue
+		}
+		sum += *sample.Speed
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+// SampleSpeeds holds the three derivations of the average speed over a run of samples
+type SampleSpeeds struct {
+	Moving     float64 `json:"avg_speed_moving"`
+	Elapsed    float64 `json:"avg_speed_elapsed"`
+	SampleMean float64 `json:"avg_speed_sample_mean"`
+}
+
+// SampleAvgSpeeds derives every average speed over samples ordered by time. Distance is
+// the cumulative_distance covered, or the haversine length of the track without it.
+// An interval counts as moving when its end sample is flagged moving, or, without a
+// flag, when that sample's speed is at least movingSpeedThreshold.
+func SampleAvgSpeeds(samples []pggeo.PointSample) SampleSpeeds {
+	speeds := SampleSpeeds{SampleMean: SampleMeanSpeed(samples)}
+	if len(samples) < 2 {
+		return speeds
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	distance := 0.0
+	if first.CumulativeDistance != nil && last.CumulativeDistance != nil {
+		distance = *last.CumulativeDistance - *first.CumulativeDistance
+	} else {
+		for i := 1; i < len(samples); i++ {
+			distance += geo.HaversineMeters(samples[i-1].Lat, samples[i-1].Lng, samples[i].Lat, samples[i].Lng)
+		}
+	}
+	moving := 0.0
+	for i := 1; i < len(samples); i++ {
+		if sampleMoving(samples[i]) {
+			moving += samples[i].Time.Sub(samples[i-1].Time).Seconds()
+		}
+	}
+	speeds.Moving = MovingAvgSpeed(distance, moving)
+	speeds.Elapsed = ElapsedAvgSpeed(distance, last.Time.Sub(first.Time).Seconds())
+	return speeds
+}
+
+func sampleMoving(sample pggeo.PointSample) bool {
+	if sample.Moving != nil {
+		return *sample.Moving
+	}
+	return sample.Speed != nil && *sample.Speed >= movingSpeedThreshold
+}
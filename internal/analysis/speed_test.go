@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+// speedFixture builds a track from (seconds since the previous sample, speed) pairs. The
+// distance of each interval is its duration times the speed of its end sample, and a
+// sample is moving unless its speed is zero.
+func speedFixture(steps [][2]float64) []pggeo.PointSample {
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	samples := make([]pggeo.PointSample, 0, len(steps))
+	elapsed, distance := 0.0, 0.0
+	for i, step := range steps {
+		elapsed += step[0]
+		distance += step[0] * step[1]
+		speed, cumulative, moving := step[1], distance, step[1] > 0
+		samples = append(samples, pggeo.PointSample{
+			PointIndex:         i,
+			Time:               start.Add(time.Duration(elapsed * float64(time.Second))),
+			Speed:              &speed,
+			Moving:             &moving,
+			CumulativeDistance: &cumulative,
+		})
+	}
+	return samples
+}
+
+// continuousRide is ten minutes at 1 Hz, easing between 6 and 10 m/s without stopping
+func continuousRide() [][2]float64 {
+	steps := [][2]float64{{0, 8}}
+	for i := 1; i <= 600; i++ {
+		steps = append(steps, [2]float64{1, 8 + 2*math.Sin(float64(i)/30)})
+	}
+	return steps
+}
+
+func TestSpeedDerivationsAgreeOnContinuousData(t *testing.T) {
+	speeds := SampleAvgSpeeds(speedFixture(continuousRide()))
+	if speeds.Moving <= 0 || speeds.Moving != speeds.Elapsed {
+		t.Fatalf("moving %v, elapsed %v; want equal positive speeds without stops", speeds.Moving, speeds.Elapsed)
+	}
+	if math.Abs(speeds.SampleMean-speeds.Moving)/speeds.Moving > 0.01 {
+		t.Fatalf("sample mean %v is more than 1%% off the moving average %v", speeds.SampleMean, speeds.Moving)
+	}
+}
+
+func TestSpeedDerivationsWithAStop(t *testing.T) {
+	steps := continuousRide()
+	for i := 0; i < 120; i++ {
+		steps = append(steps, [2]float64{1, 0})
+	}
+	steps = append(steps, continuousRide()[1:]...)
+	speeds := SampleAvgSpeeds(speedFixture(steps))
+
+	// 1200 s moving of 1320 s elapsed
+	if math.Abs(speeds.Moving/speeds.Elapsed-1320.0/1200.0) > 1e-9 {
+		t.Fatalf("moving %v, elapsed %v; want their ratio to be elapsed over moving time", speeds.Moving, speeds.Elapsed)
+	}
+	if speeds.Moving < speeds.Elapsed {
+		t.Fatalf("moving %v < elapsed %v", speeds.Moving, speeds.Elapsed)
+	}
+}
+
+func TestSampleMeanSpeedIsBiasedBySampling(t *testing.T) {
+	// Five minutes climbing at 3 m/s recorded every second, then 100 s descending at
+	// 15 m/s recorded every 10 s, as smart recording does
+	steps := [][2]float64{{0, 3}}
+	for i := 0; i < 300; i++ {
+		steps = append(steps, [2]float64{1, 3})
+	}
+	for i := 0; i < 10; i++ {
+		steps = append(steps, [2]float64{10, 15})
+	}
+	speeds := SampleAvgSpeeds(speedFixture(steps))
+	if want := 2400.0 / 400.0; math.Abs(speeds.Moving-want) > 1e-9 || math.Abs(speeds.Elapsed-want) > 1e-9 {
+		t.Fatalf("moving %v, elapsed %v; want %v", speeds.Moving, speeds.Elapsed, want)
+	}
+	if speeds.SampleMean > 0.6*speeds.Moving {
+		t.Fatalf("sample mean %v, want it dragged towards the densely sampled climb (moving %v)", speeds.SampleMean, speeds.Moving)
+	}
+}
+
+func TestAvgSpeedsWithoutTime(t *testing.T) {
+	if got := MovingAvgSpeed(1000, 0); got != 0 {
+		t.Fatalf("MovingAvgSpeed without moving time = %v, want 0", got)
+	}
+	if got := ElapsedAvgSpeed(1000, -5); got != 0 {
+		t.Fatalf("ElapsedAvgSpeed with negative time = %v, want 0", got)
+	}
+	if got := MovingAvgSpeed(36000, 3600); got != 10 {
+		t.Fatalf("MovingAvgSpeed = %v, want 10", got)
+	}
+	speeds := SampleAvgSpeeds(speedFixture([][2]float64{{0, 5}}))
+	if speeds.Moving != 0 || speeds.Elapsed != 0 || speeds.SampleMean != 5 {
+		t.Fatalf("single sample speeds = %+v", speeds)
+	}
+}
@@ -0,0 +1,176 @@
+package analysis
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// MaxRideSpeed is the fastest a ride can plausibly move between two samples, in m/s.
+// A point that is only reachable from both neighbours above it is a GPS teleport.
+const MaxRideSpeed = 40.0
+
+// maxFootSpeed is MaxRideSpeed for runs, walks and hikes
+const maxFootSpeed = 12.0
+
+// MaxPlausibleSpeed returns the spike threshold for a Strava sport type, in m/s
+func MaxPlausibleSpeed(sportType string) float64 {
+	switch {
+	case strings.Contains(sportType, "Run"), sportType == "Walk", sportType == "Hike":
+		return maxFootSpeed
+	default:
+		return MaxRideSpeed
+	}
+}
+
+// TrackPoint is one located sample of an activity; Index is its point_index
+type TrackPoint struct {
+	Index int
+	Lat   float64
+	Lng   float64
+	Time  time.Time
+}
+
+// Spike is a point that teleported away from the route, with the speeds it implies from
+// the previous and to the next point and the position healing moves it to
+type Spike struct {
+	Index     int     `json:"point_index"`
+	Lat       float64 `json:"lat"`
+	Lng       float64 `json:"lng"`
+	SpeedIn   float64 `json:"speed_in"`
+	SpeedOut  float64 `json:"speed_out"`
+	HealedLat float64 `json:"healed_lat"`
+	HealedLng float64 `json:"healed_lng"`
+	// ExtraMeters is how much longer the route is through the spike than through the
+	// healed position
+	ExtraMeters float64 `json:"extra_m"`
+}
+
+// SpikeReport is what CheckActivitySpikes found, and whether it healed the spikes
+type SpikeReport struct {
+	Spikes      []Spike
+	Healed      bool
+	ExtraMeters float64
+}
+
+// DetectSpikes returns the points whose implied speed from the last good point and to
+// the next point both exceed maxSpeed. Requiring both keeps a real burst of speed, or
+// the jump after a signal gap, from being flagged: only a single out-and-back sample
+// is. The first and last points are never flagged. Time steps under a second count as
+// one second, so duplicate timestamps do not make ordinary jitter look infinite.
+func DetectSpikes(points []TrackPoint, maxSpeed float64) []Spike {
+	var spikes []Spike
+	prev := 0
+	for i := 1; i+1 < len(points); i++ {
+		p, next := points[i], points[i+1]
+		in := impliedSpeed(points[prev], p)
+		out := impliedSpeed(p, next)
+		if in <= maxSpeed || out <= maxSpeed {
+			prev = i
+			continue
+		}
+		lat, lng := interpolatePosition(points[prev], next, p.Time)
+		extra := geo.HaversineMeters(points[prev].Lat, points[prev].Lng, p.Lat, p.Lng) +
+			geo.HaversineMeters(p.Lat, p.Lng, next.Lat, next.Lng) -
+			geo.HaversineMeters(points[prev].Lat, points[prev].Lng, lat, lng) -
+			geo.HaversineMeters(lat, lng, next.Lat, next.Lng)
+		spikes = append(spikes, Spike{
+			Index:       p.Index,
+			Lat:         p.Lat,
+			Lng:         p.Lng,
+			SpeedIn:     in,
+			SpeedOut:    out,
+			HealedLat:   lat,
+			HealedLng:   lng,
+			ExtraMeters: math.Max(extra, 0),
+		})
+	}
+	return spikes
+}
+
+func impliedSpeed(a, b TrackPoint) float64 {
+	seconds := math.Max(b.Time.Sub(a.Time).Seconds(), 1)
+	return geo.HaversineMeters(a.Lat, a.Lng, b.Lat, b.Lng) / seconds
+}
+
+// interpolatePosition places t on the straight line from a to b by time, or halfway
+// when they share a timestamp
+func interpolatePosition(a, b TrackPoint, t time.Time) (float64, float64) {
+	fraction := 0.5
+	if span := b.Time.Sub(a.Time); span > 0 {
+		fraction = math.Min(math.Max(float64(t.Sub(a.Time))/float64(span), 0), 1)
+	}
+	return a.Lat + (b.Lat-a.Lat)*fraction, a.Lng + (b.Lng-a.Lng)*fraction
+}
+
+// SamplePoints returns the located samples as track points
+func SamplePoints(samples []pggeo.PointSample) []TrackPoint {
+	points := make([]TrackPoint, len(samples))
+	for i, sample := range samples {
+		points[i] = TrackPoint{Index: sample.PointIndex, Lat: sample.Lat, Lng: sample.Lng, Time: sample.Time}
+	}
+	return points
+}
+
+// HealSamples moves each spike's sample to its healed position and takes the spike's
+// extra distance out of the cumulative distance of every later sample. samples must be
+// in point_index order; they are changed in place. It returns the distance removed.
+func HealSamples(samples []pggeo.PointSample, spikes []Spike) float64 {
+	byIndex := make(map[int]Spike, len(spikes))
+	for _, spike := range spikes {
+		byIndex[spike.Index] = spike
+	}
+	removed := 0.0
+	for i := range samples {
+		sample := &samples[i]
+		if spike, ok := byIndex[sample.PointIndex]; ok {
+			sample.Lat, sample.Lng = spike.HealedLat, spike.HealedLng
+			removed += spike.ExtraMeters
+		}
+		if sample.CumulativeDistance != nil && removed > 0 {
+			distance := math.Max(*sample.CumulativeDistance-removed, 0)
+			sample.CumulativeDistance = &distance
+		}
+	}
+	return removed
+}
+
+// CheckActivitySpikes looks for GPS teleports in a fetched or imported activity before
+// it is stored. With heal set, spikes are moved onto the line between their neighbours
+// and their extra distance is taken out of the distance stream and the summary distance,
+// so the stored route, distances and segment matches come out clean.
+func CheckActivitySpikes(activity *strava.BikeActivity, heal bool) SpikeReport {
+	latLngs, times := activity.LatLngStream.Data, activity.TimeStream.Data
+	points := make([]TrackPoint, 0, len(latLngs))
+	for i, latLng := range latLngs {
+		if i >= len(times) || len(latLng) < 2 {
+			continue
+		}
+		points = append(points, TrackPoint{Index: i, Lat: latLng[0], Lng: latLng[1], Time: times[i]})
+	}
+	report := SpikeReport{Spikes: DetectSpikes(points, MaxPlausibleSpeed(activity.Summary.SportType))}
+	if !heal || len(report.Spikes) == 0 {
+		return report
+	}
+
+	distances := activity.DistanceStream.Data
+	next := 0
+	for i := range latLngs {
+		if next < len(report.Spikes) && report.Spikes[next].Index == i {
+			spike := report.Spikes[next]
+			latLngs[i] = []float64{spike.HealedLat, spike.HealedLng}
+			report.ExtraMeters += spike.ExtraMeters
+			next++
+		}
+		if i < len(distances) && report.ExtraMeters > 0 {
+			distances[i] = math.Max(distances[i]-report.ExtraMeters, 0)
+		}
+	}
+	activity.Summary.Distance = math.Max(activity.Summary.Distance-report.ExtraMeters, 0)
+	report.Healed = true
+	return report
+}
@@ -0,0 +1,126 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+const metersPerDegreeLat = 111195.0
+
+// spikyRide is a 1000-sample ride heading north at 8 m/s, one sample a second, with
+// single-sample teleports 3 km east at index 200 and 3 km south at index 600. It
+// returns the samples with haversine cumulative distances and the spike-free length.
+func spikyRide() ([]pggeo.PointSample, float64) {
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	samples := make([]pggeo.PointSample, 1000)
+	for i := range samples {
+		samples[i] = pggeo.PointSample{
+			PointIndex: i,
+			Time:       start.Add(time.Duration(i) * time.Second),
+			Lat:        47.0 + float64(i)*8/metersPerDegreeLat,
+			Lng:        8.0,
+		}
+	}
+	trueDistance := geo.HaversineMeters(samples[0].Lat, samples[0].Lng, samples[999].Lat, samples[999].Lng)
+	samples[200].Lng += 3000 / (metersPerDegreeLat * math.Cos(47*math.Pi/180))
+	samples[600].Lat -= 3000 / metersPerDegreeLat
+
+	cumulative := 0.0
+	for i := range samples {
+		if i > 0 {
+			cumulative += geo.HaversineMeters(samples[i-1].Lat, samples[i-1].Lng, samples[i].Lat, samples[i].Lng)
+		}
+		distance := cumulative
+		samples[i].CumulativeDistance = &distance
+	}
+	return samples, trueDistance
+}
+
+func withinHalfPercent(got, want float64) bool {
+	return math.Abs(got-want) <= want*0.005
+}
+
+func TestDetectSpikesFindsTeleports(t *testing.T) {
+	samples, _ := spikyRide()
+	spikes := DetectSpikes(SamplePoints(samples), MaxRideSpeed)
+	if len(spikes) != 2 || spikes[0].Index != 200 || spikes[1].Index != 600 {
+		t.Fatalf("spikes = %+v, want indexes 200 and 600", spikes)
+	}
+	for _, spike := range spikes {
+		if spike.SpeedIn < 2900 || spike.SpeedOut < 2900 || spike.ExtraMeters < 5900 {
+			t.Fatalf("spike %+v: want ~3 km/s each way and ~6 km extra", spike)
+		}
+	}
+}
+
+func TestDetectSpikesIgnoresSignalGapsAndBursts(t *testing.T) {
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	points := []TrackPoint{
+		{Index: 0, Lat: 47, Lng: 8, Time: start},
+		{Index: 1, Lat: 47.0001, Lng: 8, Time: start.Add(time.Second)},
+		// 2 km after a 5 minute tunnel is 6.7 m/s, not a teleport
+		{Index: 2, Lat: 47.0181, Lng: 8, Time: start.Add(301 * time.Second)},
+		// The jump to 3 is fast but 3 continues the new position: a relocation, not a spike
+		{Index: 3, Lat: 47.0181 + 100/metersPerDegreeLat, Lng: 8, Time: start.Add(302 * time.Second)},
+		{Index: 4, Lat: 47.0181 + 108/metersPerDegreeLat, Lng: 8, Time: start.Add(303 * time.Second)},
+	}
+	if spikes := DetectSpikes(points, MaxRideSpeed); len(spikes) != 0 {
+		t.Fatalf("spikes = %+v, want none", spikes)
+	}
+}
+
+func TestHealSamplesRestoresDistance(t *testing.T) {
+	samples, trueDistance := spikyRide()
+	if before := *samples[999].CumulativeDistance; withinHalfPercent(before, trueDistance) {
+		t.Fatalf("fixture distance %.0f m is already within 0.5%% of %.0f m", before, trueDistance)
+	}
+	removed := HealSamples(samples, DetectSpikes(SamplePoints(samples), MaxRideSpeed))
+	if got := *samples[999].CumulativeDistance; !withinHalfPercent(got, trueDistance) {
+		t.Fatalf("healed distance = %.0f m (removed %.0f), want within 0.5%% of %.0f m", got, removed, trueDistance)
+	}
+	if math.Abs(samples[200].Lng-8.0) > 1e-9 || math.Abs(samples[600].Lat-samples[599].Lat-8/metersPerDegreeLat) > 1e-9 {
+		t.Fatalf("spikes not moved back onto the route: %+v %+v", samples[200], samples[600])
+	}
+	if spikes := DetectSpikes(SamplePoints(samples), MaxRideSpeed); len(spikes) != 0 {
+		t.Fatalf("healed ride still has spikes %+v", spikes)
+	}
+}
+
+func TestCheckActivitySpikesDetectsOrHeals(t *testing.T) {
+	samples, trueDistance := spikyRide()
+	activity := func() *strava.BikeActivity {
+		a := &strava.BikeActivity{Summary: strava.ActivitySummary{SportType: "Ride", Distance: *samples[999].CumulativeDistance}}
+		for _, sample := range samples {
+			a.TimeStream.Data = append(a.TimeStream.Data, sample.Time)
+			a.LatLngStream.Data = append(a.LatLngStream.Data, []float64{sample.Lat, sample.Lng})
+			a.DistanceStream.Data = append(a.DistanceStream.Data, *sample.CumulativeDistance)
+		}
+		return a
+	}
+
+	flagged := activity()
+	report := CheckActivitySpikes(flagged, false)
+	if len(report.Spikes) != 2 || report.Healed || flagged.LatLngStream.Data[200][1] == 8.0 {
+		t.Fatalf("detect only: report %+v changed the activity or missed spikes", report)
+	}
+
+	healed := activity()
+	report = CheckActivitySpikes(healed, true)
+	if !report.Healed || len(report.Spikes) != 2 {
+		t.Fatalf("report = %+v, want two healed spikes", report)
+	}
+	if got := healed.DistanceStream.Data[999]; !withinHalfPercent(got, trueDistance) {
+		t.Fatalf("healed distance stream ends at %.0f m, want within 0.5%% of %.0f m", got, trueDistance)
+	}
+	if !withinHalfPercent(healed.Summary.Distance, trueDistance) {
+		t.Fatalf("healed summary distance = %.0f m, want within 0.5%% of %.0f m", healed.Summary.Distance, trueDistance)
+	}
+	if lng := healed.LatLngStream.Data[200][1]; math.Abs(lng-8.0) > 1e-9 {
+		t.Fatalf("healed route point 200 at lng %f, want back on 8.0", lng)
+	}
+}
@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+// Metrics that can be plotted on a segment timeline
+const (
+	TimelineMetricElapsed = "elapsed"
+	TimelineMetricSpeed   = "speed"
+	TimelineMetricHR      = "hr"
+)
+
+// ValidTimelineMetric reports whether metric can be plotted on a segment timeline.
+func ValidTimelineMetric(metric string) bool {
+	switch metric {
+	case TimelineMetricElapsed, TimelineMetricSpeed, TimelineMetricHR:
+		return true
+	}
+	return false
+}
+
+// TimelinePoint is one effort on a segment timeline. Best is the rolling best up to and
+// including this effort; it is omitted for metrics without a "better" direction (HR).
+type TimelinePoint struct {
+	Date       time.Time `json:"date"`
+	Value      float64   `json:"value"`
+	ActivityID int64     `json:"activity_id"`
+	Effort     int       `json:"effort"`
+	Best       *float64  `json:"best,omitempty"`
+}
+
+// SegmentTimeline is a segment's efforts over time in one metric. Elapsed is in seconds,
+// speed in m/s and HR in bpm.
+type SegmentTimeline struct {
+	Metric   string          `json:"metric"`
+	Points   []TimelinePoint `json:"points"`
+	Excluded int             `json:"excluded"`
+}
+
+// BuildSegmentTimeline turns efforts (oldest first) into timeline points for metric.
+// Efforts without a positive value are counted as excluded. The rolling best is the
+// cumulative minimum for elapsed time and the cumulative maximum for speed.
+func BuildSegmentTimeline(efforts []pggeo.SegmentTimelineEffort, metric string) SegmentTimeline {
+	timeline := SegmentTimeline{Metric: metric, Points: make([]TimelinePoint, 0, len(efforts))}
+	var best *float64
+	for _, effort := range efforts {
+		var value *float64
+		switch metric {
+		case TimelineMetricElapsed:
+			value = effort.ElapsedSeconds
+		case TimelineMetricSpeed:
+			value = effort.AvgSpeed
+		case TimelineMetricHR:
+			value = effort.AvgHR
+		}
+		if value == nil || *value <= 0 {
+			timeline.Excluded++
+			continue
+		}
+
+		point := TimelinePoint{Date: effort.StartDate, Value: *value, ActivityID: effort.ActivityID, Effort: effort.EffortNumber}
+		if metric != TimelineMetricHR {
+			if best == nil || (metric == TimelineMetricElapsed && *value < *best) || (metric == TimelineMetricSpeed && *value > *best) {
+				v := *value
+				best = &v
+			}
+			b := *best
+			point.Best = &b
+		}
+		timeline.Points = append(timeline.Points, point)
+	}
+	return timeline
+}
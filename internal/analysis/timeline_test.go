@@ -0,0 +1,79 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+func timelineValue(v float64) *float64 {
+	if v < 0 {
+		return nil
+	}
+	return &v
+}
+
+// timelineFixture spans three seasons; the 2022 ride has no HR and the second effort
+// of activity 4 has no cached metrics at all
+func timelineFixture() []pggeo.SegmentTimelineEffort {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 8, 0, 0, 0, time.UTC)
+	}
+	return []pggeo.SegmentTimelineEffort{
+		{ActivityID: 1, EffortNumber: 1, StartDate: day(2022, 5, 3), ElapsedSeconds: timelineValue(300), AvgSpeed: timelineValue(6), AvgHR: timelineValue(-1)},
+		{ActivityID: 2, EffortNumber: 1, StartDate: day(2022, 9, 18), ElapsedSeconds: timelineValue(280), AvgSpeed: timelineValue(6.4), AvgHR: timelineValue(151)},
+		{ActivityID: 3, EffortNumber: 1, StartDate: day(2023, 4, 1), ElapsedSeconds: timelineValue(320), AvgSpeed: timelineValue(5.6), AvgHR: timelineValue(140)},
+		{ActivityID: 4, EffortNumber: 1, StartDate: day(2024, 6, 12), ElapsedSeconds: timelineValue(260), AvgSpeed: timelineValue(6.9), AvgHR: timelineValue(158)},
+		{ActivityID: 4, EffortNumber: 2, StartDate: day(2024, 6, 12), ElapsedSeconds: timelineValue(-1), AvgSpeed: timelineValue(0), AvgHR: timelineValue(-1)},
+		{ActivityID: 5, EffortNumber: 1, StartDate: day(2024, 8, 30), ElapsedSeconds: timelineValue(275), AvgSpeed: timelineValue(6.5), AvgHR: timelineValue(149)},
+	}
+}
+
+func TestBuildSegmentTimelineElapsedBestIsCumulativeMinimum(t *testing.T) {
+	got := BuildSegmentTimeline(timelineFixture(), TimelineMetricElapsed)
+	wantValues := []float64{300, 280, 320, 260, 275}
+	wantBest := []float64{300, 280, 280, 260, 260}
+	if len(got.Points) != len(wantValues) || got.Excluded != 1 {
+		t.Fatalf("got %d points, %d excluded; want %d points, 1 excluded", len(got.Points), got.Excluded, len(wantValues))
+	}
+	for i, point := range got.Points {
+		if point.Value != wantValues[i] || point.Best == nil || *point.Best != wantBest[i] {
+			t.Fatalf("point %d = %+v, want value %v best %v", i, point, wantValues[i], wantBest[i])
+		}
+		if i > 0 && point.Date.Before(got.Points[i-1].Date) {
+			t.Fatalf("point %d (%s) is before point %d", i, point.Date, i-1)
+		}
+	}
+	if got.Points[0].Date.Year() != 2022 || got.Points[len(got.Points)-1].Date.Year() != 2024 {
+		t.Fatalf("points span %d-%d, want 2022-2024", got.Points[0].Date.Year(), got.Points[len(got.Points)-1].Date.Year())
+	}
+}
+
+func TestBuildSegmentTimelineSpeedBestIsCumulativeMaximum(t *testing.T) {
+	got := BuildSegmentTimeline(timelineFixture(), TimelineMetricSpeed)
+	wantBest := []float64{6, 6.4, 6.4, 6.9, 6.9}
+	if len(got.Points) != len(wantBest) || got.Excluded != 1 {
+		t.Fatalf("got %d points, %d excluded; want %d points, 1 excluded", len(got.Points), got.Excluded, len(wantBest))
+	}
+	for i, point := range got.Points {
+		if point.Best == nil || *point.Best != wantBest[i] {
+			t.Fatalf("point %d best = %v, want %v", i, point.Best, wantBest[i])
+		}
+	}
+}
+
+func TestBuildSegmentTimelineHRHasNoBestLine(t *testing.T) {
+	got := BuildSegmentTimeline(timelineFixture(), TimelineMetricHR)
+	if len(got.Points) != 4 || got.Excluded != 2 {
+		t.Fatalf("got %d points, %d excluded; want 4 points, 2 excluded", len(got.Points), got.Excluded)
+	}
+	for _, point := range got.Points {
+		if point.Best != nil {
+			t.Fatalf("HR point %+v has a rolling best", point)
+		}
+	}
+	if got.Points[0].ActivityID != 2 {
+		t.Fatalf("first HR point is activity %d, want 2", got.Points[0].ActivityID)
+	}
+}
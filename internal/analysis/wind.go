@@ -0,0 +1,244 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// Limits for out-and-back detection and wind estimation.
+const (
+	windOverlapToleranceM     = 30.0   // how far the return leg may stray from the way out
+	windMinOverlap            = 0.8    // share of each leg that must lie along the other
+	windMinTurnaroundM        = 1000.0 // shortest out-and-back with a usable route axis
+	windMinLegSamples         = 30     // moving samples needed per direction
+	windMinMovingSpeed        = 1.0    // m/s; slower samples are stops and walking
+	windMinPowerCoverage      = 0.5    // share of moving samples that must carry watts
+	windMaxOverlapPoints      = 500    // legs are thinned to this many points for the overlap check
+	windFullConfidenceSamples = 600    // moving samples per direction (10 min at 1 Hz) for full data confidence
+)
+
+var (
+	// ErrWindNotOutAndBack is returned for routes whose two halves do not retrace each other.
+	ErrWindNotOutAndBack = errors.New("route is not out-and-back")
+	// ErrWindInsufficientData is returned when the samples cannot support an estimate.
+	ErrWindInsufficientData = errors.New("not enough data to estimate wind")
+)
+
+// WindLeg summarizes one direction of an out-and-back ride.
+type WindLeg struct {
+	Samples  int      `json:"samples"`
+	SpeedMPS float64  `json:"speed_mps"`
+	Watts    *float64 `json:"watts,omitempty"`
+}
+
+// WindEstimate is the wind along the axis of an out-and-back ride, derived from the speed
+// (and power, when recorded) difference between the two directions.
+type WindEstimate struct {
+	ActivityID      int64   `json:"activity_id"`
+	HeadwindOutMPS  float64 `json:"headwind_out_mps"` // positive: headwind on the way out, tailwind back
+	WindSpeedMPS    float64 `json:"wind_speed_mps"`
+	FromBearingDeg  float64 `json:"from_bearing_deg"` // compass direction the wind blows from, along the route axis
+	AxisBearingDeg  float64 `json:"axis_bearing_deg"` // start towards the turnaround
+	TurnaroundIndex int     `json:"turnaround_index"`
+	Overlap         float64 `json:"overlap"` // 0-1, share of the route that retraces itself
+	Out             WindLeg `json:"out"`
+	Back            WindLeg `json:"back"`
+	UsedPower       bool    `json:"used_power"`
+	Confidence      float64 `json:"confidence"` // 0-1
+}
+
+// EstimateWind estimates the effective wind along an out-and-back route. The turnaround
+// is the sample farthest from the start; the ride counts as out-and-back when each half
+// lies within windOverlapToleranceM of the other half reversed over at least
+// windMinOverlap of its length (the length-within-buffer measure the segment matcher
+// uses). Climbs and descents cancel out over the two directions.
+//
+// At equal effort the air speed is the same both ways, so the ground speeds differ by
+// twice the wind: w = (v_back - v_out) / 2. With power on both legs the speeds are first
+// brought to equal power assuming air speed grows with the cube root of power:
+// w = (v_back - k·v_out) / (1 + k), k = ∛(P_back / P_out).
+func EstimateWind(activity *strava.ActivitySummary, samples []pggeo.PointSample) (*WindEstimate, error) {
+	if len(samples) < 2*windMinLegSamples {
+		return nil, fmt.Errorf("%w: %d samples", ErrWindInsufficientData, len(samples))
+	}
+
+	start := samples[0]
+	turn, farthest := 0, 0.0
+	for i, sample := range samples {
+		if d := geo.HaversineMeters(start.Lat, start.Lng, sample.Lat, sample.Lng); d > farthest {
+			turn, farthest = i, d
+		}
+	}
+	if farthest < windMinTurnaroundM {
+		return nil, fmt.Errorf("%w: the route never gets more than %.0f m from the start", ErrWindNotOutAndBack, farthest)
+	}
+
+	out := samples[:turn+1]
+	back := reversedSamples(samples[turn:])
+	overlap := math.Min(legOverlap(out, back), legOverlap(back, out))
+	if overlap < windMinOverlap {
+		return nil, fmt.Errorf("%w: only %.0f%% of the route retraces itself", ErrWindNotOutAndBack, overlap*100)
+	}
+
+	outLeg := windLegStats(out)
+	backLeg := windLegStats(back)
+	if outLeg.Samples < windMinLegSamples || backLeg.Samples < windMinLegSamples {
+		return nil, fmt.Errorf("%w: %d moving speed samples out and %d back", ErrWindInsufficientData, outLeg.Samples, backLeg.Samples)
+	}
+
+	usedPower := outLeg.Watts != nil && backLeg.Watts != nil
+	k := 1.0
+	if usedPower {
+		k = math.Cbrt(*backLeg.Watts / *outLeg.Watts)
+	}
+	headwind := (backLeg.SpeedMPS - k*outLeg.SpeedMPS) / (1 + k)
+
+	axis := initialBearing(start.Lat, start.Lng, samples[turn].Lat, samples[turn].Lng)
+	from := axis
+	if headwind < 0 {
+		from = math.Mod(axis+180, 360)
+	}
+
+	estimate := &WindEstimate{
+		HeadwindOutMPS:  headwind,
+		WindSpeedMPS:    math.Abs(headwind),
+		FromBearingDeg:  from,
+		AxisBearingDeg:  axis,
+		TurnaroundIndex: turn,
+		Overlap:         overlap,
+		Out:             outLeg,
+		Back:            backLeg,
+		UsedPower:       usedPower,
+		Confidence:      windConfidence(overlap, outLeg, backLeg, usedPower),
+	}
+	if activity != nil {
+		estimate.ActivityID = activity.ID
+	}
+	return estimate, nil
+}
+
+// windConfidence scores an estimate from 0 to 1: a cleaner retrace, more moving samples
+// and recorded power (which removes differences in effort) all raise it.
+func windConfidence(overlap float64, out, back WindLeg, usedPower bool) float64 {
+	overlapScore := 0.5 + 0.5*(overlap-windMinOverlap)/(1-windMinOverlap)
+	dataScore := math.Min(1, float64(min(out.Samples, back.Samples))/windFullConfidenceSamples)
+	powerScore := 0.6
+	if usedPower {
+		powerScore = 1
+	}
+	return math.Round(overlapScore*dataScore*powerScore*100) / 100
+}
+
+// windLegStats averages the moving speed of a leg, and its power when enough samples
+// carry watts.
+func windLegStats(samples []pggeo.PointSample) WindLeg {
+	var leg WindLeg
+	var speedSum, wattsSum float64
+	wattsCount := 0
+	for _, sample := range samples {
+		if sample.Speed == nil || *sample.Speed < windMinMovingSpeed || (sample.Moving != nil && !*sample.Moving) {
+			continue
+		}
+		leg.Samples++
+		speedSum += *sample.Speed
+		if sample.Watts != nil {
+			wattsSum += float64(*sample.Watts)
+			wattsCount++
+		}
+	}
+	if leg.Samples == 0 {
+		return leg
+	}
+	leg.SpeedMPS = speedSum / float64(leg.Samples)
+	if wattsCount > 0 && float64(wattsCount) >= windMinPowerCoverage*float64(leg.Samples) && wattsSum > 0 {
+		watts := wattsSum / float64(wattsCount)
+		leg.Watts = &watts
+	}
+	return leg
+}
+
+// legOverlap returns the share of leg's length that lies within windOverlapToleranceM
+// of other.
+func legOverlap(leg, other []pggeo.PointSample) float64 {
+	leg = thinSamples(leg, windMaxOverlapPoints)
+	other = thinSamples(other, windMaxOverlapPoints)
+	if len(leg) < 2 || len(other) < 2 {
+		return 0
+	}
+	// Project to local meters around the leg start; fine over the length of one ride
+	originLat, originLng := leg[0].Lat, leg[0].Lng
+	project := func(s pggeo.PointSample) (float64, float64) {
+		const metersPerDegree = 111320.0
+		return (s.Lng - originLng) * metersPerDegree * math.Cos(originLat*math.Pi/180), (s.Lat - originLat) * metersPerDegree
+	}
+	otherX := make([]float64, len(other))
+	otherY := make([]float64, len(other))
+	for i, s := range other {
+		otherX[i], otherY[i] = project(s)
+	}
+
+	var total, covered float64
+	prevX, prevY := project(leg[0])
+	for _, s := range leg[1:] {
+		x, y := project(s)
+		length := math.Hypot(x-prevX, y-prevY)
+		total += length
+		midX, midY := (x+prevX)/2, (y+prevY)/2
+		for j := 1; j < len(other); j++ {
+			if pointSegmentDistance(midX, midY, otherX[j-1], otherY[j-1], otherX[j], otherY[j]) <= windOverlapToleranceM {
+				covered += length
+				break
+			}
+		}
+		prevX, prevY = x, y
+	}
+	if total == 0 {
+		return 0
+	}
+	return covered / total
+}
+
+func pointSegmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	lengthSq := dx*dx + dy*dy
+	t := 0.0
+	if lengthSq > 0 {
+		t = math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/lengthSq))
+	}
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+// thinSamples keeps about limit evenly spaced samples, always including both ends.
+func thinSamples(samples []pggeo.PointSample, limit int) []pggeo.PointSample {
+	if len(samples) <= limit {
+		return samples
+	}
+	step := float64(len(samples)-1) / float64(limit-1)
+	thinned := make([]pggeo.PointSample, 0, limit)
+	for i := 0; i < limit; i++ {
+		thinned = append(thinned, samples[int(math.Round(float64(i)*step))])
+	}
+	return thinned
+}
+
+func reversedSamples(samples []pggeo.PointSample) []pggeo.PointSample {
+	reversed := make([]pggeo.PointSample, len(samples))
+	for i, sample := range samples {
+		reversed[len(samples)-1-i] = sample
+	}
+	return reversed
+}
+
+// initialBearing returns the compass bearing in degrees from the first point to the second.
+func initialBearing(lat1, lng1, lat2, lng2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	y := math.Sin(dLng) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLng)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
@@ -0,0 +1,131 @@
+package analysis
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// outAndBack builds a ride due east for distance meters and back on the other side of the
+// road, one sample every 10 m, at the given speeds and power (0 for no power meter).
+func outAndBack(distance, speedOut, speedBack float64, wattsOut, wattsBack int) []pggeo.PointSample {
+	const lat, lng, step = 46.0, 7.0, 10.0
+	metersPerDegreeLng := 111320.0 * math.Cos(lat*math.Pi/180)
+	var samples []pggeo.PointSample
+	at := time.Unix(0, 0)
+	add := func(east, north, speed float64, watts int) {
+		sample := pggeo.PointSample{
+			PointIndex: len(samples),
+			Time:       at,
+			Lat:        lat + north/111320.0,
+			Lng:        lng + east/metersPerDegreeLng,
+			Speed:      floatPtr(speed),
+		}
+		if watts > 0 {
+			sample.Watts = &watts
+		}
+		samples = append(samples, sample)
+		at = at.Add(time.Duration(step / speed * float64(time.Second)))
+	}
+	for d := 0.0; d <= distance; d += step {
+		add(d, 0, speedOut, wattsOut)
+	}
+	for d := distance - step; d >= 0; d -= step {
+		add(d, 6, speedBack, wattsBack)
+	}
+	return samples
+}
+
+func TestEstimateWindRecoversHeadwind(t *testing.T) {
+	// 2 m/s slower out than back at the same power: 1 m/s of headwind out of the east
+	activity := &strava.ActivitySummary{ID: 9}
+	estimate, err := EstimateWind(activity, outAndBack(5000, 8, 10, 200, 200))
+	if err != nil {
+		t.Fatalf("EstimateWind: %v", err)
+	}
+	if estimate.ActivityID != 9 || !estimate.UsedPower {
+		t.Fatalf("estimate = %+v, want activity 9 estimated with power", estimate)
+	}
+	if math.Abs(estimate.HeadwindOutMPS-1) > 0.05 || math.Abs(estimate.WindSpeedMPS-1) > 0.05 {
+		t.Fatalf("headwind out = %.2f m/s (speed %.2f), want about 1", estimate.HeadwindOutMPS, estimate.WindSpeedMPS)
+	}
+	if math.Abs(estimate.AxisBearingDeg-90) > 1 || math.Abs(estimate.FromBearingDeg-90) > 1 {
+		t.Fatalf("axis %.1f°, wind from %.1f°, want both east (90°)", estimate.AxisBearingDeg, estimate.FromBearingDeg)
+	}
+	if estimate.Overlap < 0.95 || estimate.Confidence <= 0 || estimate.Confidence > 1 {
+		t.Fatalf("overlap %.2f confidence %.2f, want a clean retrace and a confidence in (0, 1]", estimate.Overlap, estimate.Confidence)
+	}
+}
+
+func TestEstimateWindRecoversTailwindWithoutPower(t *testing.T) {
+	estimate, err := EstimateWind(nil, outAndBack(4000, 11, 8, 0, 0))
+	if err != nil {
+		t.Fatalf("EstimateWind: %v", err)
+	}
+	if estimate.UsedPower {
+		t.Fatal("UsedPower = true for a ride without watts")
+	}
+	if math.Abs(estimate.HeadwindOutMPS+1.5) > 0.05 {
+		t.Fatalf("headwind out = %.2f m/s, want about -1.5 (tailwind out)", estimate.HeadwindOutMPS)
+	}
+	if math.Abs(estimate.FromBearingDeg-270) > 1 {
+		t.Fatalf("wind from %.1f°, want west (270°)", estimate.FromBearingDeg)
+	}
+}
+
+func TestEstimateWindNormalizesPower(t *testing.T) {
+	// Same speed both ways, but it took much more power on the way out: a headwind out
+	estimate, err := EstimateWind(nil, outAndBack(5000, 9, 9, 250, 150))
+	if err != nil {
+		t.Fatalf("EstimateWind: %v", err)
+	}
+	k := math.Cbrt(150.0 / 250.0)
+	want := (9 - k*9) / (1 + k)
+	if math.Abs(estimate.HeadwindOutMPS-want) > 0.01 || estimate.HeadwindOutMPS <= 0 {
+		t.Fatalf("headwind out = %.3f m/s, want %.3f", estimate.HeadwindOutMPS, want)
+	}
+
+	// Without the power correction the same speeds mean calm air
+	calm, err := EstimateWind(nil, outAndBack(5000, 9, 9, 0, 0))
+	if err != nil {
+		t.Fatalf("EstimateWind: %v", err)
+	}
+	if math.Abs(calm.HeadwindOutMPS) > 1e-9 {
+		t.Fatalf("headwind out = %.3f m/s, want 0", calm.HeadwindOutMPS)
+	}
+}
+
+func TestEstimateWindRejectsOneWayRoutes(t *testing.T) {
+	oneWay := outAndBack(10000, 9, 9, 0, 0)[:1001]
+	if _, err := EstimateWind(nil, oneWay); !errors.Is(err, ErrWindNotOutAndBack) {
+		t.Fatalf("one-way ride error = %v, want ErrWindNotOutAndBack", err)
+	}
+
+	// A loop returns to the start along a different road
+	loop := outAndBack(5000, 9, 9, 0, 0)
+	for i := 501; i < len(loop); i++ {
+		loop[i].Lat += 0.01 * math.Sin(float64(len(loop)-i)/float64(len(loop)-501)*math.Pi)
+	}
+	if _, err := EstimateWind(nil, loop); !errors.Is(err, ErrWindNotOutAndBack) {
+		t.Fatalf("loop error = %v, want ErrWindNotOutAndBack", err)
+	}
+}
+
+func TestEstimateWindNeedsEnoughData(t *testing.T) {
+	if _, err := EstimateWind(nil, outAndBack(5000, 9, 9, 0, 0)[:20]); !errors.Is(err, ErrWindInsufficientData) {
+		t.Fatalf("short ride error = %v, want ErrWindInsufficientData", err)
+	}
+
+	// A retrace without speed data has nothing to compare
+	samples := outAndBack(5000, 9, 9, 0, 0)
+	for i := range samples {
+		samples[i].Speed = nil
+	}
+	if _, err := EstimateWind(nil, samples); !errors.Is(err, ErrWindInsufficientData) {
+		t.Fatalf("speedless ride error = %v, want ErrWindInsufficientData", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package cache holds the bounded in-memory map used by the server's lookup caches.
+package cache
+
+import "container/list"
+
+// LRU is a map bounded to maxEntries that evicts the least recently used entry when full.
+// It is not safe for concurrent use; callers hold their own lock.
+type LRU[K comparable, V any] struct {
+	maxEntries int
+	order      *list.List // front is the most recently used
+	items      map[K]*list.Element
+}
+
+type lruItem[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU returns an empty cache holding at most maxEntries entries (at least one)
+func NewLRU[K comparable, V any](maxEntries int) *LRU[K, V] {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &LRU[K, V]{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value for key and marks it as recently used
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	element, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruItem[K, V]).value, true
+}
+
+// Add stores value under key, evicting the least recently used entry when the cache is full
+func (c *LRU[K, V]) Add(key K, value V) {
+	if element, ok := c.items[key]; ok {
+		element.Value.(*lruItem[K, V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem[K, V]).key)
+	}
+	c.items[key] = c.order.PushFront(&lruItem[K, V]{key: key, value: value})
+}
+
+// Remove deletes key if present
+func (c *LRU[K, V]) Remove(key K) {
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
+
+// RemoveFunc deletes every entry for which match returns true and reports how many it removed
+func (c *LRU[K, V]) RemoveFunc(match func(key K, value V) bool) int {
+	removed := 0
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		item := element.Value.(*lruItem[K, V])
+		if match(item.key, item.value) {
+			c.order.Remove(element)
+			delete(c.items, item.key)
+			removed++
+		}
+		element = next
+	}
+	return removed
+}
+
+// Len returns the number of entries
+func (c *LRU[K, V]) Len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,47 @@
+package cache
+
+import "testing"
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[string, int](2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a missing")
+	}
+	c.Add("c", 3) // b is now the least recently used
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should have been evicted")
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := c.Get(key); !ok || got != want {
+			t.Fatalf("Get(%s) = %d, %v; want %d", key, got, ok, want)
+		}
+	}
+
+	c.Add("a", 10)
+	if got, _ := c.Get("a"); got != 10 || c.Len() != 2 {
+		t.Fatalf("after update a = %d with %d entries, want 10 with 2", got, c.Len())
+	}
+}
+
+func TestLRURemove(t *testing.T) {
+	c := NewLRU[int, string](10)
+	for i := 0; i < 5; i++ {
+		c.Add(i, "v")
+	}
+	c.Remove(0)
+	c.Remove(42)
+	if removed := c.RemoveFunc(func(key int, _ string) bool { return key%2 == 1 }); removed != 2 {
+		t.Fatalf("RemoveFunc removed %d, want 2", removed)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", c.Len())
+	}
+	for _, key := range []int{2, 4} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("%d missing", key)
+		}
+	}
+}
@@ -0,0 +1,668 @@
+// Package config loads the b11k configuration from an optional YAML file and B11K_*
+// environment variables, which take precedence over the file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"b11k/internal/logging"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+	"b11k/internal/sync"
+	"b11k/internal/web"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is read when no path is given and it exists; without it the configuration
+// comes from the environment alone
+const DefaultPath = "config.yaml"
+
+// PathEnv names the config file when the -config flag is not given
+const PathEnv = "B11K_CONFIG"
+
+type Config struct {
+	StravaClientID                 string   `yaml:"strava_client_id"`
+	StravaClientSecret             string   `yaml:"strava_client_secret"`
+	StravaRedirectURI              string   `yaml:"strava_redirect_uri"`
+	IOSRedirectURI                 string   `yaml:"ios_redirect_uri"`
+	PGIP                           string   `yaml:"pg_ip"`
+	PGPort                         string   `yaml:"pg_port"`
+	PGUser                         string   `yaml:"pg_user"`
+	PGPassword                     string   `yaml:"pg_secret"`
+	PGDatabase                     string   `yaml:"pg_db"`
+	PGMaxConns                     int      `yaml:"pg_max_conns"`
+	PGReplicaIP                    string   `yaml:"pg_replica_ip"`
+	PGReplicaPort                  string   `yaml:"pg_replica_port"`
+	WebHost                        string   `yaml:"web_host"`
+	PublicAPIHost                  string   `yaml:"public_api_host"`
+	WebPort                        string   `yaml:"web_port"`
+	WebProtocol                    string   `yaml:"web_protocol"` // "http" or "https" - use "https" when behind Cloudflare Tunnel or reverse proxy
+	BasePath                       string   `yaml:"base_path"`    // URL prefix when served from a subpath, e.g. "/b11k"
+	TokenEncryptionKey             string   `yaml:"token_encryption_key"`
+	DevReloadTemplates             bool     `yaml:"dev_reload_templates"`
+	MobileActivityOrder            string   `yaml:"mobile_activity_order"`
+	DiscoveredMapEnabled           *bool    `yaml:"discovered_map_enabled"`
+	DiscoveredRevealRadiusMeters   float64  `yaml:"discovered_reveal_radius_meters"`
+	DiscoveredSampleDistanceMeters float64  `yaml:"discovered_sample_distance_meters"`
+	AccountDeletionGraceDays       int      `yaml:"account_deletion_grace_days"`
+	SkipSpatialSelfCheck           bool     `yaml:"skip_spatial_self_check"`
+	LazySegmentCache               bool     `yaml:"lazy_segment_cache"`
+	StravaWriteBack                bool     `yaml:"strava_write_back"`
+	AthleteCacheTTLMinutes         int      `yaml:"athlete_cache_ttl_minutes"`
+	SegmentCacheTTLMinutes         int      `yaml:"segment_cache_ttl_minutes"`
+	ShutdownGraceSeconds           int      `yaml:"shutdown_grace_seconds"`
+	ActivityTypes                  []string `yaml:"activity_types"`
+	StravaWebhookVerifyToken       string   `yaml:"strava_webhook_verify_token"`
+	AdminAthleteIDs                []int64  `yaml:"admin_athlete_ids"`
+	PublicAthleteID                int64    `yaml:"public_athlete_id"`
+	AutoPullOnView                 bool     `yaml:"auto_pull_on_view"`
+	AutoPullStaleHours             int      `yaml:"auto_pull_stale_hours"`
+	AutoPullMaxActivities          int      `yaml:"auto_pull_max_activities"`
+	HealGPSSpikes                  bool     `yaml:"heal_gps_spikes"`
+	LogDigestIntervalMinutes       int      `yaml:"log_digest_interval_minutes"`
+	ShareViewRetentionDays         int      `yaml:"share_view_retention_days"`
+	ShareViewGeoIPFile             string   `yaml:"share_view_geoip_file"` // start_ip,end_ip,country CSV, e.g. DB-IP country lite
+	DebugLogging                   bool     `yaml:"debug_logging"`
+
+	// LogLevel is debug, info, warn or error and LogFormat text or json
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+	// MetricsEnabled serves Prometheus metrics at /metrics
+	MetricsEnabled bool `yaml:"metrics_enabled"`
+
+	// Soft limits on database growth, warned about when crossed; zero disables a limit.
+	// With EnforceLimits, syncs also stop fetching new activities past them.
+	MaxPointSamples         int  `yaml:"max_point_samples"`
+	MaxDatabaseMB           int  `yaml:"max_database_mb"`
+	MaxActivitiesPerAthlete int  `yaml:"max_activities_per_athlete"`
+	EnforceLimits           bool `yaml:"enforce_limits"`
+	// SimplifyToleranceM is the tolerance in meters routes and segments are simplified to
+	// when stored, for maps; -resimplify applies a change to those stored before
+	SimplifyToleranceM float64 `yaml:"simplify_tolerance_m"`
+	// PointStorage is how new GPS points are stored: rows, one per point, or streams,
+	// one compressed row per activity; see pggeo.PointStorageStreams
+	PointStorage string `yaml:"point_storage"`
+
+	// Endpoints that receive activity and PR events; see internal/outbound
+	OutboundWebhooks []OutboundWebhook `yaml:"outbound_webhooks"`
+
+	// Places are named points, such as home and work, that tag rules refer to
+	Places map[string]Place `yaml:"places"`
+	// TagRules tag activities as they are first stored; see sync.TagRule
+	TagRules []TagRule `yaml:"tag_rules"`
+}
+
+type Place struct {
+	Lat float64 `yaml:"lat"`
+	Lng float64 `yaml:"lng"`
+}
+
+// TagRule is a sync.TagRule as written in the file; conditions left empty always hold
+type TagRule struct {
+	Tag           string   `yaml:"tag"`
+	AthleteID     int64    `yaml:"athlete_id"`      // 0 applies the rule to every athlete
+	Between       []string `yaml:"between"`         // two places, the ride runs from one to the other
+	RadiusM       float64  `yaml:"radius_m"`        // around the places; 250 when 0
+	MaxDistanceKM float64  `yaml:"max_distance_km"` // rides shorter than this
+	Weekdays      []string `yaml:"weekdays"`        // mon ... sun, of the local start
+	TimeWindows   []string `yaml:"time_windows"`    // "06:00-10:00", of the local start
+	Types         []string `yaml:"types"`           // Strava types or sport types
+}
+
+type OutboundWebhook struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"` // empty subscribes to every event
+}
+
+// Error lists every problem found while loading, so one run reports all of them
+type Error struct {
+	Missing []string // required settings, as "yaml_key (ENV_NAME)"
+	Invalid []string
+}
+
+func (e *Error) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing required settings: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		parts = append(parts, "invalid settings: "+strings.Join(e.Invalid, "; "))
+	}
+	return "invalid configuration: " + strings.Join(parts, "; ")
+}
+
+// Load reads the YAML file at path, applies the environment on top, fills defaults and
+// validates the result. An empty path means $B11K_CONFIG, else DefaultPath when it
+// exists; a path that was asked for must exist. Validation failures are an *Error.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(PathEnv)
+	}
+	optional := path == ""
+	if optional {
+		path = DefaultPath
+	}
+
+	config := &Config{}
+	yamlFile, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(yamlFile, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case optional && errors.Is(err, os.ErrNotExist):
+		slog.Info("No config file, reading the configuration from the environment", "path", path)
+	default:
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	env := envReader{lookup: os.LookupEnv}
+	env.apply(config)
+	normalize(config)
+	if err := validate(config, env.invalid); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// required lists the settings without a usable default
+var required = []struct {
+	key, env string
+	value    func(*Config) string
+}{
+	{"strava_client_id", "B11K_STRAVA_CLIENT_ID", func(c *Config) string { return c.StravaClientID }},
+	{"strava_client_secret", "B11K_STRAVA_CLIENT_SECRET", func(c *Config) string { return c.StravaClientSecret }},
+	{"pg_ip", "B11K_PG_HOST", func(c *Config) string { return c.PGIP }},
+	{"pg_user", "B11K_PG_USER", func(c *Config) string { return c.PGUser }},
+	{"pg_db", "B11K_PG_DATABASE", func(c *Config) string { return c.PGDatabase }},
+}
+
+func validate(config *Config, invalid []string) error {
+	problems := &Error{Invalid: invalid}
+	for _, setting := range required {
+		if strings.TrimSpace(setting.value(config)) == "" {
+			problems.Missing = append(problems.Missing, fmt.Sprintf("%s (%s)", setting.key, setting.env))
+		}
+	}
+	switch config.WebProtocol {
+	case "", "http", "https":
+	default:
+		problems.Invalid = append(problems.Invalid, fmt.Sprintf("web_protocol %q must be http or https", config.WebProtocol))
+	}
+	for _, limit := range []struct {
+		key   string
+		value int
+	}{
+		{"max_point_samples", config.MaxPointSamples},
+		{"max_database_mb", config.MaxDatabaseMB},
+		{"max_activities_per_athlete", config.MaxActivitiesPerAthlete},
+	} {
+		if limit.value < 0 {
+			problems.Invalid = append(problems.Invalid, fmt.Sprintf("%s %d must not be negative", limit.key, limit.value))
+		}
+	}
+	if _, err := logging.ParseLevel(config.LogLevel); err != nil {
+		problems.Invalid = append(problems.Invalid, fmt.Sprintf("log_level %q must be debug, info, warn or error", config.LogLevel))
+	}
+	switch config.LogFormat {
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		problems.Invalid = append(problems.Invalid, fmt.Sprintf("log_format %q must be text or json", config.LogFormat))
+	}
+	if config.SimplifyToleranceM < 0 || config.SimplifyToleranceM > pggeo.MaxSimplifyToleranceM {
+		problems.Invalid = append(problems.Invalid, fmt.Sprintf("simplify_tolerance_m %g must be above 0 and at most %.0f", config.SimplifyToleranceM, pggeo.MaxSimplifyToleranceM))
+	}
+	if !pggeo.ValidPointStorage(config.PointStorage) {
+		problems.Invalid = append(problems.Invalid, fmt.Sprintf("point_storage %q must be rows or streams", config.PointStorage))
+	}
+	if config.PublicAthleteID < 0 {
+		problems.Invalid = append(problems.Invalid, fmt.Sprintf("public_athlete_id %d must not be negative", config.PublicAthleteID))
+	}
+	for i, webhook := range config.OutboundWebhooks {
+		if strings.TrimSpace(webhook.URL) == "" {
+			problems.Invalid = append(problems.Invalid, fmt.Sprintf("outbound_webhooks[%d] has no url", i))
+		}
+	}
+	_, ruleProblems := config.tagRules()
+	problems.Invalid = append(problems.Invalid, ruleProblems...)
+	if len(problems.Missing) > 0 || len(problems.Invalid) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// normalize fills defaults and derives the redirect URIs left empty
+func normalize(config *Config) {
+	config.BasePath = web.NormalizeBasePath(config.BasePath)
+	if config.PGPort == "" {
+		config.PGPort = "5432"
+	}
+	switch config.MobileActivityOrder {
+	case "map_first", "stats_first":
+	default:
+		config.MobileActivityOrder = "stats_first"
+	}
+	if config.DiscoveredMapEnabled == nil {
+		enabled := true
+		config.DiscoveredMapEnabled = &enabled
+	}
+	if config.DiscoveredRevealRadiusMeters <= 0 {
+		config.DiscoveredRevealRadiusMeters = 100
+	}
+	if config.DiscoveredSampleDistanceMeters <= 0 {
+		config.DiscoveredSampleDistanceMeters = 50
+	}
+	if config.PGMaxConns <= 0 {
+		config.PGMaxConns = pggeo.DefaultPoolMaxConns
+	}
+	if config.PGReplicaIP != "" && config.PGReplicaPort == "" {
+		config.PGReplicaPort = config.PGPort
+	}
+	if config.AccountDeletionGraceDays <= 0 {
+		config.AccountDeletionGraceDays = 30
+	}
+	if config.AthleteCacheTTLMinutes <= 0 {
+		config.AthleteCacheTTLMinutes = 15
+	}
+	if config.SegmentCacheTTLMinutes <= 0 {
+		config.SegmentCacheTTLMinutes = 60
+	}
+	if config.ShutdownGraceSeconds <= 0 {
+		config.ShutdownGraceSeconds = 30
+	}
+	if config.AutoPullStaleHours <= 0 {
+		config.AutoPullStaleHours = 6
+	}
+	if config.AutoPullMaxActivities <= 0 {
+		config.AutoPullMaxActivities = 5
+	}
+	if config.LogDigestIntervalMinutes <= 0 {
+		config.LogDigestIntervalMinutes = 24 * 60
+	}
+	if config.ShareViewRetentionDays <= 0 {
+		config.ShareViewRetentionDays = 90
+	}
+	config.LogLevel = strings.ToLower(strings.TrimSpace(config.LogLevel))
+	if config.LogLevel == "" {
+		config.LogLevel = "info"
+	}
+	config.LogFormat = strings.ToLower(strings.TrimSpace(config.LogFormat))
+	if config.LogFormat == "" {
+		config.LogFormat = logging.FormatText
+	}
+	if config.SimplifyToleranceM == 0 {
+		config.SimplifyToleranceM = pggeo.DefaultSimplifyToleranceM
+	}
+	config.PointStorage = strings.ToLower(strings.TrimSpace(config.PointStorage))
+	if config.PointStorage == "" {
+		config.PointStorage = pggeo.PointStorageRows
+	}
+	if config.IOSRedirectURI == "" {
+		host := config.PublicAPIHost
+		if host == "" {
+			host = config.WebHost
+		}
+		if host == "" {
+			host = "localhost"
+		}
+		protocol := "http"
+		if config.WebProtocol == "https" {
+			protocol = "https"
+		}
+		if protocol == "https" || config.WebPort == "" || config.WebPort == "80" {
+			config.IOSRedirectURI = fmt.Sprintf("%s://%s%s/api/mobile/auth/callback", protocol, host, config.BasePath)
+		} else {
+			config.IOSRedirectURI = fmt.Sprintf("%s://%s:%s%s/api/mobile/auth/callback", protocol, host, config.WebPort, config.BasePath)
+		}
+	}
+
+	// Construct redirect URI from host and port if not explicitly provided
+	if config.StravaRedirectURI == "" {
+		webHost := config.WebHost
+		if webHost == "" {
+			webHost = "localhost"
+		}
+		webPort := config.WebPort
+		if webPort == "" {
+			webPort = "8080"
+		}
+		// Determine protocol - default to http, but use web_protocol if set
+		protocol := "http"
+		if config.WebProtocol == "https" {
+			protocol = "https"
+		}
+
+		// For standard ports (80 for HTTP, 443 for HTTPS), omit port in URL
+		// For non-standard ports, include port in URL
+		var redirectURI string
+		if (protocol == "http" && webPort == "80") || (protocol == "https" && webPort == "443") {
+			// Standard port - omit from URL
+			redirectURI = fmt.Sprintf("%s://%s%s/strava/callback", protocol, webHost, config.BasePath)
+		} else if protocol == "https" {
+			// HTTPS with non-standard port - but if behind proxy, usually omit port
+			// For Cloudflare Tunnel and most reverse proxies, HTTPS URLs don't include port
+			redirectURI = fmt.Sprintf("%s://%s%s/strava/callback", protocol, webHost, config.BasePath)
+		} else {
+			// HTTP with non-standard port - include port
+			redirectURI = fmt.Sprintf("%s://%s:%s%s/strava/callback", protocol, webHost, webPort, config.BasePath)
+		}
+
+		config.StravaRedirectURI = redirectURI
+		slog.Info("Constructed Strava redirect URI", "redirect_uri", config.StravaRedirectURI)
+		if protocol == "http" {
+			slog.Info("If behind Cloudflare Tunnel or a reverse proxy with HTTPS, set web_protocol: https in config.yaml")
+		}
+	}
+}
+
+// envReader applies B11K_* variables; values that do not parse are collected in invalid
+type envReader struct {
+	lookup  func(name string) (string, bool)
+	invalid []string
+}
+
+func (e *envReader) apply(config *Config) {
+	e.envString(&config.StravaClientID, "B11K_STRAVA_CLIENT_ID")
+	e.envString(&config.StravaClientSecret, "B11K_STRAVA_CLIENT_SECRET")
+	e.envString(&config.StravaRedirectURI, "B11K_STRAVA_REDIRECT_URI")
+	e.envString(&config.IOSRedirectURI, "B11K_IOS_REDIRECT_URI")
+	e.envString(&config.StravaWebhookVerifyToken, "B11K_STRAVA_WEBHOOK_VERIFY_TOKEN")
+	e.envString(&config.PGIP, "B11K_PG_HOST", "B11K_PG_IP")
+	e.envString(&config.PGPort, "B11K_PG_PORT")
+	e.envString(&config.PGUser, "B11K_PG_USER")
+	e.envString(&config.PGPassword, "B11K_PG_PASSWORD", "B11K_PG_SECRET")
+	e.envString(&config.PGDatabase, "B11K_PG_DATABASE", "B11K_PG_DB")
+	e.envInt(&config.PGMaxConns, "B11K_PG_MAX_CONNS")
+	e.envString(&config.PGReplicaIP, "B11K_PG_REPLICA_HOST", "B11K_PG_REPLICA_IP")
+	e.envString(&config.PGReplicaPort, "B11K_PG_REPLICA_PORT")
+	e.envString(&config.WebHost, "B11K_WEB_HOST")
+	e.envString(&config.PublicAPIHost, "B11K_PUBLIC_API_HOST")
+	e.envString(&config.WebPort, "B11K_WEB_PORT")
+	e.envString(&config.WebProtocol, "B11K_WEB_PROTOCOL")
+	e.envString(&config.BasePath, "B11K_BASE_PATH")
+	e.envString(&config.TokenEncryptionKey, "B11K_TOKEN_ENCRYPTION_KEY")
+	e.envString(&config.MobileActivityOrder, "B11K_MOBILE_ACTIVITY_ORDER")
+	e.envBool(&config.DevReloadTemplates, "B11K_DEV_RELOAD_TEMPLATES")
+	e.envBoolPtr(&config.DiscoveredMapEnabled, "B11K_DISCOVERED_MAP_ENABLED")
+	e.envFloat(&config.DiscoveredRevealRadiusMeters, "B11K_DISCOVERED_REVEAL_RADIUS_METERS")
+	e.envFloat(&config.DiscoveredSampleDistanceMeters, "B11K_DISCOVERED_SAMPLE_DISTANCE_METERS")
+	e.envInt(&config.AccountDeletionGraceDays, "B11K_ACCOUNT_DELETION_GRACE_DAYS")
+	e.envBool(&config.SkipSpatialSelfCheck, "B11K_SKIP_SPATIAL_SELF_CHECK")
+	e.envBool(&config.LazySegmentCache, "B11K_LAZY_SEGMENT_CACHE")
+	e.envBool(&config.StravaWriteBack, "B11K_STRAVA_WRITE_BACK")
+	e.envInt(&config.AthleteCacheTTLMinutes, "B11K_ATHLETE_CACHE_TTL_MINUTES")
+	e.envInt(&config.SegmentCacheTTLMinutes, "B11K_SEGMENT_CACHE_TTL_MINUTES")
+	e.envInt(&config.ShutdownGraceSeconds, "B11K_SHUTDOWN_GRACE_SECONDS")
+	e.envList(&config.ActivityTypes, "B11K_ACTIVITY_TYPES")
+	e.envInt64List(&config.AdminAthleteIDs, "B11K_ADMIN_ATHLETE_IDS")
+	e.envInt64(&config.PublicAthleteID, "B11K_PUBLIC_ATHLETE_ID")
+	e.envBool(&config.AutoPullOnView, "B11K_AUTO_PULL_ON_VIEW")
+	e.envInt(&config.AutoPullStaleHours, "B11K_AUTO_PULL_STALE_HOURS")
+	e.envInt(&config.AutoPullMaxActivities, "B11K_AUTO_PULL_MAX_ACTIVITIES")
+	e.envBool(&config.HealGPSSpikes, "B11K_HEAL_GPS_SPIKES")
+	e.envInt(&config.LogDigestIntervalMinutes, "B11K_LOG_DIGEST_INTERVAL_MINUTES")
+	e.envInt(&config.ShareViewRetentionDays, "B11K_SHARE_VIEW_RETENTION_DAYS")
+	e.envString(&config.ShareViewGeoIPFile, "B11K_SHARE_VIEW_GEOIP_FILE")
+	e.envBool(&config.DebugLogging, "B11K_DEBUG_LOGGING")
+	e.envLogSettings(config)
+	e.envBool(&config.MetricsEnabled, "B11K_METRICS_ENABLED")
+	e.envInt(&config.MaxPointSamples, "B11K_MAX_POINT_SAMPLES")
+	e.envInt(&config.MaxDatabaseMB, "B11K_MAX_DATABASE_MB")
+	e.envInt(&config.MaxActivitiesPerAthlete, "B11K_MAX_ACTIVITIES_PER_ATHLETE")
+	e.envBool(&config.EnforceLimits, "B11K_ENFORCE_LIMITS")
+	e.envFloat(&config.SimplifyToleranceM, "B11K_SIMPLIFY_TOLERANCE_M")
+	e.envString(&config.PointStorage, "B11K_POINT_STORAGE")
+	e.envWebhooks(&config.OutboundWebhooks, "B11K_OUTBOUND_WEBHOOKS")
+	e.envPlaces(&config.Places, "B11K_PLACES")
+	e.envTagRules(&config.TagRules, "B11K_TAG_RULES")
+}
+
+// envLogSettings reads the log level and format; the unprefixed names are the ones
+// container platforms commonly set
+func (e *envReader) envLogSettings(config *Config) {
+	e.envString(&config.LogLevel, "B11K_LOG_LEVEL", "LOG_LEVEL")
+	e.envString(&config.LogFormat, "B11K_LOG_FORMAT", "LOG_FORMAT")
+}
+
+// LogSettings returns the log level and format set in the environment, so the lines
+// logged while the configuration loads already come out as asked
+func LogSettings() (level, format string) {
+	config := &Config{}
+	env := envReader{lookup: os.LookupEnv}
+	env.envLogSettings(config)
+	return config.LogLevel, config.LogFormat
+}
+
+// value returns the first of names that is set and not empty
+func (e *envReader) value(names ...string) (string, string, bool) {
+	for _, name := range names {
+		if value, ok := e.lookup(name); ok && value != "" {
+			return name, value, true
+		}
+	}
+	return "", "", false
+}
+
+func (e *envReader) reject(name, value, want string) {
+	e.invalid = append(e.invalid, fmt.Sprintf("%s=%q is not %s", name, value, want))
+}
+
+func (e *envReader) envString(target *string, names ...string) {
+	if _, value, ok := e.value(names...); ok {
+		*target = value
+	}
+}
+
+// envList allows an empty value, which clears a list set in the file
+func (e *envReader) envList(target *[]string, names ...string) {
+	for _, name := range names {
+		if value, ok := e.lookup(name); ok {
+			*target = strava.ParseActivityTypes(value)
+			return
+		}
+	}
+}
+
+func (e *envReader) envInt64List(target *[]int64, names ...string) {
+	for _, name := range names {
+		value, ok := e.lookup(name)
+		if !ok {
+			continue
+		}
+		var parsed []int64
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				e.reject(name, value, "a comma-separated list of IDs")
+				return
+			}
+			parsed = append(parsed, id)
+		}
+		*target = parsed
+		return
+	}
+}
+
+func parseBool(value string) (bool, bool) {
+	switch value {
+	case "1", "true", "TRUE", "yes", "YES", "on", "ON":
+		return true, true
+	case "0", "false", "FALSE", "no", "NO", "off", "OFF":
+		return false, true
+	}
+	return false, false
+}
+
+func (e *envReader) envBool(target *bool, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		parsed, valid := parseBool(value)
+		if !valid {
+			e.reject(name, value, "a boolean")
+			return
+		}
+		*target = parsed
+	}
+}
+
+func (e *envReader) envBoolPtr(target **bool, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		parsed, valid := parseBool(value)
+		if !valid {
+			e.reject(name, value, "a boolean")
+			return
+		}
+		*target = &parsed
+	}
+}
+
+func (e *envReader) envFloat(target *float64, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.reject(name, value, "a number")
+			return
+		}
+		*target = parsed
+	}
+}
+
+func (e *envReader) envInt(target *int, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			e.reject(name, value, "an integer")
+			return
+		}
+		*target = parsed
+	}
+}
+
+func (e *envReader) envInt64(target *int64, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			e.reject(name, value, "an ID")
+			return
+		}
+		*target = parsed
+	}
+}
+
+// envWebhooks reads outbound webhooks as a YAML or JSON list, the same shape as the file
+func (e *envReader) envWebhooks(target *[]OutboundWebhook, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		var parsed []OutboundWebhook
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			e.reject(name, value, "a list of {url, secret, events}")
+			return
+		}
+		*target = parsed
+	}
+}
+
+// envPlaces reads places as a YAML or JSON map, the same shape as the file
+func (e *envReader) envPlaces(target *map[string]Place, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		var parsed map[string]Place
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			e.reject(name, value, "a map of names to {lat, lng}")
+			return
+		}
+		*target = parsed
+	}
+}
+
+// envTagRules reads tag rules as a YAML or JSON list, the same shape as the file
+func (e *envReader) envTagRules(target *[]TagRule, names ...string) {
+	if name, value, ok := e.value(names...); ok {
+		var parsed []TagRule
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			e.reject(name, value, "a list of {tag, between, radius_m, max_distance_km, weekdays, time_windows, types}")
+			return
+		}
+		*target = parsed
+	}
+}
+
+// SyncTagRules returns the tag rules with their places and times resolved. Load has
+// validated them, so none are left out of a loaded configuration.
+func (c *Config) SyncTagRules() []sync.TagRule {
+	rules, _ := c.tagRules()
+	return rules
+}
+
+// tagRules resolves the tag rules, listing the problems of the ones it leaves out
+func (c *Config) tagRules() ([]sync.TagRule, []string) {
+	var problems []string
+	for _, name := range slices.Sorted(maps.Keys(c.Places)) {
+		if place := c.Places[name]; place.Lat < -90 || place.Lat > 90 || place.Lng < -180 || place.Lng > 180 {
+			problems = append(problems, fmt.Sprintf("places.%s is not a valid lat and lng", name))
+		}
+	}
+	var rules []sync.TagRule
+	for i, rule := range c.TagRules {
+		var ruleProblems []string
+		invalid := func(format string, args ...interface{}) {
+			ruleProblems = append(ruleProblems, fmt.Sprintf("tag_rules[%d] ", i)+fmt.Sprintf(format, args...))
+		}
+		tag, err := pggeo.NormalizeTag(rule.Tag)
+		if err != nil {
+			invalid("%v", err)
+		}
+		resolved := sync.TagRule{
+			Tag:          tag,
+			AthleteID:    rule.AthleteID,
+			RadiusM:      rule.RadiusM,
+			MaxDistanceM: rule.MaxDistanceKM * 1000,
+			Types:        rule.Types,
+		}
+		if rule.AthleteID < 0 {
+			invalid("athlete_id %d must not be negative", rule.AthleteID)
+		}
+		if rule.RadiusM < 0 || rule.MaxDistanceKM < 0 {
+			invalid("radius_m and max_distance_km must not be negative")
+		}
+		if len(rule.Between) != 0 && len(rule.Between) != 2 {
+			invalid("between must name two places")
+		}
+		for _, name := range rule.Between {
+			place, ok := c.Places[name]
+			if !ok {
+				invalid("between names unknown place %q", name)
+			}
+			resolved.Between = append(resolved.Between, sync.Place{Lat: place.Lat, Lng: place.Lng})
+		}
+		for _, value := range rule.Weekdays {
+			day, err := sync.ParseWeekday(value)
+			if err != nil {
+				invalid("%v", err)
+			}
+			resolved.Weekdays = append(resolved.Weekdays, day)
+		}
+		for _, value := range rule.TimeWindows {
+			window, err := sync.ParseTimeWindow(value)
+			if err != nil {
+				invalid("%v", err)
+			}
+			resolved.Windows = append(resolved.Windows, window)
+		}
+		if len(ruleProblems) > 0 {
+			problems = append(problems, ruleProblems...)
+			continue
+		}
+		rules = append(rules, resolved)
+	}
+	return rules, problems
+}
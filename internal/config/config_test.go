@@ -0,0 +1,256 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/sync"
+)
+
+// clearEnv unsets every B11K_ variable of the environment running the tests, and the
+// unprefixed log settings
+func clearEnv(t *testing.T) {
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "B11K_") || name == "LOG_LEVEL" || name == "LOG_FORMAT" {
+			t.Setenv(name, "")
+			_ = os.Unsetenv(name)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "b11k.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+const fileConfig = `
+strava_client_id: "file-id"
+strava_client_secret: file-secret
+pg_ip: db.file
+pg_user: b11k
+pg_db: b11k_db
+pg_max_conns: 4
+activity_types: [Ride]
+`
+
+func TestLoadEnvironmentOverridesFile(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, fileConfig)
+	t.Setenv("B11K_STRAVA_CLIENT_ID", "env-id")
+	t.Setenv("B11K_PG_HOST", "db.env")
+	t.Setenv("B11K_PG_IP", "ignored: B11K_PG_HOST comes first")
+	t.Setenv("B11K_PG_MAX_CONNS", "12")
+	t.Setenv("B11K_ACTIVITY_TYPES", "")
+	t.Setenv("B11K_DISCOVERED_MAP_ENABLED", "false")
+	t.Setenv("B11K_SEGMENT_CACHE_TTL_MINUTES", "5")
+	t.Setenv("B11K_STRAVA_WRITE_BACK", "true")
+	t.Setenv("B11K_MAX_DATABASE_MB", "2048")
+	t.Setenv("B11K_ENFORCE_LIMITS", "true")
+	t.Setenv("B11K_PUBLIC_ATHLETE_ID", "4242")
+	t.Setenv("B11K_LOG_DIGEST_INTERVAL_MINUTES", "60")
+	t.Setenv("B11K_DEBUG_LOGGING", "on")
+	t.Setenv("LOG_LEVEL", "WARN")
+	t.Setenv("B11K_LOG_FORMAT", "json")
+	t.Setenv("B11K_METRICS_ENABLED", "true")
+	t.Setenv("B11K_POINT_STORAGE", "Streams")
+	t.Setenv("B11K_SIMPLIFY_TOLERANCE_M", "12.5")
+	t.Setenv("B11K_OUTBOUND_WEBHOOKS", `[{"url": "https://hooks.example/b11k", "events": ["segment.pr"]}]`)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.StravaClientID != "env-id" || cfg.StravaClientSecret != "file-secret" {
+		t.Fatalf("strava = %q/%q, want the env ID and the file secret", cfg.StravaClientID, cfg.StravaClientSecret)
+	}
+	if cfg.PGIP != "db.env" || cfg.PGMaxConns != 12 || cfg.PGPort != "5432" {
+		t.Fatalf("database = %s:%s max %d", cfg.PGIP, cfg.PGPort, cfg.PGMaxConns)
+	}
+	if len(cfg.ActivityTypes) != 0 {
+		t.Fatalf("activity types = %v; an empty variable should clear the file's list", cfg.ActivityTypes)
+	}
+	if cfg.DiscoveredMapEnabled == nil || *cfg.DiscoveredMapEnabled {
+		t.Fatal("B11K_DISCOVERED_MAP_ENABLED=false was not applied")
+	}
+	if cfg.SegmentCacheTTLMinutes != 5 {
+		t.Fatalf("segment cache TTL = %d minutes, want 5", cfg.SegmentCacheTTLMinutes)
+	}
+	if !cfg.StravaWriteBack {
+		t.Fatal("B11K_STRAVA_WRITE_BACK=true was not applied")
+	}
+	if cfg.MaxDatabaseMB != 2048 || !cfg.EnforceLimits || cfg.MaxPointSamples != 0 {
+		t.Fatalf("limits = %d MB, %d points, enforce %v", cfg.MaxDatabaseMB, cfg.MaxPointSamples, cfg.EnforceLimits)
+	}
+	if cfg.PublicAthleteID != 4242 {
+		t.Fatalf("public athlete = %d, want 4242", cfg.PublicAthleteID)
+	}
+	if cfg.LogDigestIntervalMinutes != 60 || !cfg.DebugLogging {
+		t.Fatalf("log digest every %d minutes, debug %v; want 60 and true", cfg.LogDigestIntervalMinutes, cfg.DebugLogging)
+	}
+	if cfg.LogLevel != "warn" || cfg.LogFormat != "json" {
+		t.Fatalf("logging at %q as %q, want warn as json", cfg.LogLevel, cfg.LogFormat)
+	}
+	if !cfg.MetricsEnabled {
+		t.Fatal("metrics disabled, want enabled from the environment")
+	}
+	if cfg.PointStorage != "streams" {
+		t.Fatalf("point storage = %q, want streams from the environment", cfg.PointStorage)
+	}
+	if cfg.SimplifyToleranceM != 12.5 {
+		t.Fatalf("simplify tolerance = %g m, want 12.5 from the environment", cfg.SimplifyToleranceM)
+	}
+	if level, format := LogSettings(); level != "WARN" || format != "json" {
+		t.Fatalf("LogSettings = %q, %q; want the environment's WARN and json", level, format)
+	}
+	want := []OutboundWebhook{{URL: "https://hooks.example/b11k", Events: []string{"segment.pr"}}}
+	if !reflect.DeepEqual(cfg.OutboundWebhooks, want) {
+		t.Fatalf("webhooks = %+v", cfg.OutboundWebhooks)
+	}
+	if cfg.StravaRedirectURI != "http://localhost:8080/strava/callback" {
+		t.Fatalf("redirect URI = %q", cfg.StravaRedirectURI)
+	}
+}
+
+func TestLoadFromEnvironmentWithoutFile(t *testing.T) {
+	clearEnv(t)
+	t.Chdir(t.TempDir())
+	t.Setenv("B11K_STRAVA_CLIENT_ID", "id")
+	t.Setenv("B11K_STRAVA_CLIENT_SECRET", "secret")
+	t.Setenv("B11K_PG_HOST", "db")
+	t.Setenv("B11K_PG_USER", "b11k")
+	t.Setenv("B11K_PG_DATABASE", "b11k_db")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Load without config.yaml: %v", err)
+	}
+	if cfg.PGIP != "db" || cfg.AthleteCacheTTLMinutes != 15 || cfg.SegmentCacheTTLMinutes != 60 || cfg.MobileActivityOrder != "stats_first" ||
+		cfg.LogDigestIntervalMinutes != 24*60 || cfg.DebugLogging || cfg.LogLevel != "info" || cfg.LogFormat != "text" || cfg.MetricsEnabled || cfg.PointStorage != "rows" || cfg.SimplifyToleranceM != 8 ||
+		cfg.ShareViewRetentionDays != 90 {
+		t.Fatalf("config = %+v", cfg)
+	}
+
+	// A file that was asked for must exist
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load of a missing -config path = %v, want not exist", err)
+	}
+	t.Setenv(PathEnv, "missing.yaml")
+	if _, err := Load(""); err == nil {
+		t.Fatal("a missing B11K_CONFIG path was ignored")
+	}
+}
+
+func TestLoadListsEveryMissingAndInvalidSetting(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, "pg_ip: db\nweb_protocol: ftp\noutbound_webhooks: [{secret: s}]\nmax_point_samples: -1\nlog_format: xml\npoint_storage: blobs\nsimplify_tolerance_m: -3\n")
+	t.Setenv("B11K_PG_MAX_CONNS", "ten")
+	t.Setenv("B11K_LAZY_SEGMENT_CACHE", "maybe")
+
+	_, err := Load(path)
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Load = %v, want *Error", err)
+	}
+	wantMissing := []string{
+		"strava_client_id (B11K_STRAVA_CLIENT_ID)",
+		"strava_client_secret (B11K_STRAVA_CLIENT_SECRET)",
+		"pg_user (B11K_PG_USER)",
+		"pg_db (B11K_PG_DATABASE)",
+	}
+	if !reflect.DeepEqual(configErr.Missing, wantMissing) {
+		t.Fatalf("missing = %v, want %v", configErr.Missing, wantMissing)
+	}
+	if len(configErr.Invalid) != 8 {
+		t.Fatalf("invalid = %v, want max conns, lazy cache, protocol, point limit, log format, simplify tolerance, point storage and webhook", configErr.Invalid)
+	}
+	for _, want := range []string{"B11K_PG_MAX_CONNS", "B11K_LAZY_SEGMENT_CACHE", "web_protocol", "max_point_samples", "log_format", "simplify_tolerance_m", "point_storage", "outbound_webhooks[0]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadTagRules(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, fileConfig+`
+places:
+  home: {lat: 48.8566, lng: 2.3522}
+  work: {lat: 48.8738, lng: 2.2950}
+tag_rules:
+  - tag: Commute
+    between: [home, work]
+    max_distance_km: 15
+    weekdays: [mon, tue, wed, thu, fri]
+    time_windows: ["06:00-10:00", "16:00-20:00"]
+    types: [Ride]
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := []sync.TagRule{{
+		Tag:          "commute",
+		Between:      []sync.Place{{Lat: 48.8566, Lng: 2.3522}, {Lat: 48.8738, Lng: 2.2950}},
+		MaxDistanceM: 15000,
+		Weekdays:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Windows:      []sync.TimeWindow{{From: 6 * time.Hour, To: 10 * time.Hour}, {From: 16 * time.Hour, To: 20 * time.Hour}},
+		Types:        []string{"Ride"},
+	}}
+	if got := cfg.SyncTagRules(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("tag rules = %+v, want %+v", got, want)
+	}
+
+	// The environment replaces the file's places and rules
+	t.Setenv("B11K_PLACES", `{"gym": {"lat": 1, "lng": 2}}`)
+	t.Setenv("B11K_TAG_RULES", `[{"tag": "gym", "between": ["gym", "gym"], "radius_m": 100}]`)
+	if cfg, err = Load(path); err != nil {
+		t.Fatalf("Load with B11K_TAG_RULES: %v", err)
+	}
+	if rules := cfg.SyncTagRules(); len(rules) != 1 || rules[0].Tag != "gym" || rules[0].RadiusM != 100 || len(rules[0].Between) != 2 {
+		t.Fatalf("tag rules = %+v, want the gym rule of the environment", rules)
+	}
+}
+
+func TestLoadRejectsInvalidTagRules(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, fileConfig+`
+places:
+  home: {lat: 95, lng: 2}
+tag_rules:
+  - tag: two words
+  - tag: commute
+    between: [home, office]
+    weekdays: [someday]
+    time_windows: ["7-9"]
+  - tag: loop
+    between: [home]
+    max_distance_km: -1
+`)
+	_, err := Load(path)
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Load = %v, want *Error", err)
+	}
+	for _, want := range []string{
+		"places.home",
+		`tag_rules[0] tag "two words"`,
+		`tag_rules[1] between names unknown place "office"`,
+		`tag_rules[1] unknown weekday "someday"`,
+		`tag_rules[1] time window "7-9"`,
+		"tag_rules[2] radius_m and max_distance_km",
+		"tag_rules[2] between must name two places",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
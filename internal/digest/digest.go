@@ -0,0 +1,256 @@
+// Package digest coalesces the routine log records of B11K's background work. Subsystems
+// count what they did in a Collector instead of logging every item; once per interval
+// the Collector logs one summary record per subsystem and starts counting afresh. The
+// per-item records are still logged with Debug set, and failures are always logged at once.
+package digest
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is used when Run is given no interval
+const DefaultInterval = 24 * time.Hour
+
+// Summary is what the subsystems counted between Since and Until, by subsystem and counter
+type Summary struct {
+	Since      time.Time                    `json:"since"`
+	Until      time.Time                    `json:"until"`
+	Subsystems map[string]map[string]uint64 `json:"subsystems"`
+}
+
+// log writes the summary as one record per subsystem, in name order, with the
+// subsystem, the period and one attribute per counter
+func (s Summary) log(logger *slog.Logger) {
+	period := s.Until.Sub(s.Since).Round(time.Second)
+	for _, subsystem := range slices.Sorted(maps.Keys(s.Subsystems)) {
+		counts := s.Subsystems[subsystem]
+		attrs := make([]any, 0, 2+len(counts))
+		attrs = append(attrs, slog.String("subsystem", subsystem), slog.Duration("period", period))
+		for _, counter := range slices.Sorted(maps.Keys(counts)) {
+			attrs = append(attrs, slog.Uint64(counter, counts[counter]))
+		}
+		logger.Info("Digest", attrs...)
+	}
+}
+
+// Options configure a Collector
+type Options struct {
+	// Debug logs every recorded event too, not only the digest
+	Debug bool
+	// Now and Logger replace the clock and the logger; nil uses time.Now and
+	// slog.Default
+	Now    func() time.Time
+	Logger *slog.Logger
+}
+
+// Collector counts background events for the digest. It is safe for concurrent use. A
+// nil *Collector logs failures and drops everything else, so callers need not check
+// whether one is set.
+type Collector struct {
+	opts Options
+
+	mu       sync.Mutex
+	counts   map[string]map[string]uint64
+	totals   map[string]map[string]uint64 // never reset, for the metrics endpoint
+	since    time.Time
+	last     *Summary
+	interval time.Duration
+}
+
+// New returns a Collector counting from now
+func New(opts Options) *Collector {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Collector{
+		opts:   opts,
+		counts: make(map[string]map[string]uint64),
+		totals: make(map[string]map[string]uint64),
+		since:  opts.Now(),
+	}
+}
+
+// Register declares the counters of a subsystem, so the digest shows them even when
+// they stay at zero
+func (c *Collector) Register(subsystem string, counters ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, counter := range counters {
+		c.addLocked(subsystem, counter, 0)
+	}
+}
+
+// Add adds n to a subsystem's counter without logging anything
+func (c *Collector) Add(subsystem, counter string, n uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addLocked(subsystem, counter, n)
+}
+
+func (c *Collector) addLocked(subsystem, counter string, n uint64) {
+	for _, byCounter := range []map[string]map[string]uint64{c.counts, c.totals} {
+		counts := byCounter[subsystem]
+		if counts == nil {
+			counts = make(map[string]uint64)
+			byCounter[subsystem] = counts
+		}
+		counts[counter] += n
+	}
+}
+
+// Record counts a routine event and logs msg and args only with Debug set
+func (c *Collector) Record(subsystem, counter, msg string, args ...any) {
+	if c == nil {
+		return
+	}
+	c.Add(subsystem, counter, 1)
+	if c.opts.Debug {
+		c.opts.Logger.Info(msg, args...)
+	}
+}
+
+// Debug logs msg and args only with Debug set, for details of an event counted with Add
+func (c *Collector) Debug(msg string, args ...any) {
+	if c != nil && c.opts.Debug {
+		c.opts.Logger.Info(msg, args...)
+	}
+}
+
+// Warn counts a failure that is retried or worked around and logs it right away
+func (c *Collector) Warn(subsystem, counter, msg string, args ...any) {
+	c.failed(slog.LevelWarn, subsystem, counter, msg, args)
+}
+
+// Error counts a failure and logs it right away
+func (c *Collector) Error(subsystem, counter, msg string, args ...any) {
+	c.failed(slog.LevelError, subsystem, counter, msg, args)
+}
+
+func (c *Collector) failed(level slog.Level, subsystem, counter, msg string, args []any) {
+	if c == nil {
+		slog.Log(context.Background(), level, msg, args...)
+		return
+	}
+	c.Add(subsystem, counter, 1)
+	c.opts.Logger.Log(context.Background(), level, msg, args...)
+}
+
+// Snapshot returns the counts since the last digest without resetting them
+func (c *Collector) Snapshot() Summary {
+	if c == nil {
+		return Summary{Subsystems: map[string]map[string]uint64{}}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.summaryLocked(c.opts.Now())
+}
+
+// Totals returns every count since the Collector was created, which Flush does not
+// reset, by subsystem and counter
+func (c *Collector) Totals() map[string]map[string]uint64 {
+	if c == nil {
+		return map[string]map[string]uint64{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	totals := make(map[string]map[string]uint64, len(c.totals))
+	for subsystem, counts := range c.totals {
+		totals[subsystem] = maps.Clone(counts)
+	}
+	return totals
+}
+
+// Last returns the most recently logged digest, or nil before the first one
+func (c *Collector) Last() *Summary {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// Interval returns the interval Run logs digests at, zero before Run starts
+func (c *Collector) Interval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.interval
+}
+
+func (c *Collector) summaryLocked(until time.Time) Summary {
+	summary := Summary{Since: c.since, Until: until, Subsystems: make(map[string]map[string]uint64, len(c.counts))}
+	for subsystem, counts := range c.counts {
+		summary.Subsystems[subsystem] = maps.Clone(counts)
+	}
+	return summary
+}
+
+// Flush logs the digest and resets every counter to zero. Registered counters stay
+// registered.
+func (c *Collector) Flush() Summary {
+	if c == nil {
+		return Summary{}
+	}
+	c.mu.Lock()
+	now := c.opts.Now()
+	summary := c.summaryLocked(now)
+	for _, counts := range c.counts {
+		for counter := range counts {
+			counts[counter] = 0
+		}
+	}
+	c.since = now
+	c.last = &summary
+	c.mu.Unlock()
+
+	summary.log(c.opts.Logger)
+	return summary
+}
+
+// NextBoundary returns the first multiple of interval after now, counted from the zero
+// time, so a daily digest lands at midnight UTC and an hourly one on the hour
+func NextBoundary(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
+
+// Run logs a digest at every interval boundary until ctx ends. Counts left over at
+// shutdown are logged by a last Flush. A zero interval means DefaultInterval.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	if c == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	c.mu.Lock()
+	c.interval = interval
+	c.mu.Unlock()
+	for {
+		now := c.opts.Now()
+		timer := time.NewTimer(NextBoundary(now, interval).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			c.Flush()
+		}
+	}
+}
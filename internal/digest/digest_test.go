@@ -0,0 +1,240 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the records a Collector logs as text lines without the time
+type recorder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *recorder) logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(r, &slog.HandlerOptions{ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+		if attr.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return attr
+	}}))
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(r.buf.String()), "\n")
+	r.buf.Reset()
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	return lines
+}
+
+func TestCountsAggregateAcrossGoroutines(t *testing.T) {
+	var logged recorder
+	c := New(Options{Logger: logged.logger()})
+	c.Register("webhooks", "processed", "failed")
+
+	const workers, events = 16, 500
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < events; j++ {
+				c.Record("webhooks", "processed", "Processed event", "event", j)
+				if j%100 == 0 {
+					c.Error("webhooks", "failed", "Event failed", "event", j)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := c.Snapshot().Subsystems["webhooks"]
+	if counts["processed"] != workers*events || counts["failed"] != workers*events/100 {
+		t.Fatalf("counts = %v, want processed=%d failed=%d", counts, workers*events, workers*events/100)
+	}
+	// Without Debug only the errors were logged
+	if lines := logged.take(); len(lines) != workers*events/100 {
+		t.Fatalf("logged %d lines, want only the %d errors", len(lines), workers*events/100)
+	}
+}
+
+func TestFlushLogsOneLinePerSubsystemAndResets(t *testing.T) {
+	var logged recorder
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	c := New(Options{Logger: logged.logger(), Now: func() time.Time { return now }})
+	c.Register("webhooks", "processed", "failed")
+	for i := 0; i < 14; i++ {
+		c.Record("webhooks", "processed", "Routine")
+	}
+	c.Add("pr checks", "checked", 3)
+
+	now = start.Add(24 * time.Hour)
+	summary := c.Flush()
+	want := []string{
+		`level=INFO msg=Digest subsystem="pr checks" period=24h0m0s checked=3`,
+		`level=INFO msg=Digest subsystem=webhooks period=24h0m0s failed=0 processed=14`,
+	}
+	if lines := logged.take(); !slices.Equal(lines, want) {
+		t.Fatalf("digest lines = %q, want %q", lines, want)
+	}
+	if !summary.Since.Equal(start) || !summary.Until.Equal(now) {
+		t.Fatalf("digest covers %s to %s, want %s to %s", summary.Since, summary.Until, start, now)
+	}
+	if last := c.Last(); last == nil || last.Subsystems["webhooks"]["processed"] != 14 {
+		t.Fatalf("Last() = %+v, want the flushed digest", last)
+	}
+
+	after := c.Snapshot()
+	if !after.Since.Equal(now) {
+		t.Fatalf("counting restarted at %s, want %s", after.Since, now)
+	}
+	for subsystem, counts := range after.Subsystems {
+		for counter, n := range counts {
+			if n != 0 {
+				t.Fatalf("%s %s = %d after the digest, want 0", subsystem, counter, n)
+			}
+		}
+	}
+	if _, ok := after.Subsystems["webhooks"]["failed"]; !ok {
+		t.Fatal("registered counter dropped by the reset")
+	}
+	c.Add("pr checks", "checked", 2)
+	if totals := c.Totals(); totals["webhooks"]["processed"] != 14 || totals["pr checks"]["checked"] != 5 {
+		t.Fatalf("Totals() = %v, want the counts since New, across the reset", totals)
+	}
+}
+
+func TestDebugLogsRecordedEvents(t *testing.T) {
+	var logged recorder
+	c := New(Options{Debug: true, Logger: logged.logger()})
+	c.Record("webhooks", "processed", "Saved activity", "activity_id", 7)
+	c.Debug("Refreshed", "duration", time.Second)
+	want := []string{`level=INFO msg="Saved activity" activity_id=7`, `level=INFO msg=Refreshed duration=1s`}
+	if lines := logged.take(); !slices.Equal(lines, want) {
+		t.Fatalf("logged %q, want the routine records with Debug", lines)
+	}
+
+	quiet := New(Options{Logger: logged.logger()})
+	quiet.Record("webhooks", "processed", "Saved activity", "activity_id", 8)
+	quiet.Debug("Refreshed", "duration", time.Second)
+	if lines := logged.take(); len(lines) != 0 {
+		t.Fatalf("logged %q without Debug", lines)
+	}
+}
+
+func TestFailuresAreLoggedAtTheirLevel(t *testing.T) {
+	var logged recorder
+	c := New(Options{Logger: logged.logger()})
+	c.Warn("prefetch", "failed", "Prefetch failed", "athlete_id", 7)
+	c.Error("webhooks", "failed", "Failed to save activity", "activity_id", 9)
+	want := []string{`level=WARN msg="Prefetch failed" athlete_id=7`, `level=ERROR msg="Failed to save activity" activity_id=9`}
+	if lines := logged.take(); !slices.Equal(lines, want) {
+		t.Fatalf("logged %q, want %q", lines, want)
+	}
+	if counts := c.Snapshot().Subsystems; counts["prefetch"]["failed"] != 1 || counts["webhooks"]["failed"] != 1 {
+		t.Fatalf("counts = %v", counts)
+	}
+}
+
+func TestNilCollectorIsSafe(t *testing.T) {
+	var c *Collector
+	c.Register("webhooks", "processed")
+	c.Record("webhooks", "processed", "Routine")
+	c.Add("webhooks", "processed", 2)
+	c.Flush()
+	if c.Last() != nil || len(c.Snapshot().Subsystems) != 0 {
+		t.Fatal("nil collector kept counts")
+	}
+}
+
+func TestNextBoundary(t *testing.T) {
+	cases := []struct {
+		now      time.Time
+		interval time.Duration
+		want     time.Time
+	}{
+		{time.Date(2026, 3, 1, 17, 42, 0, 0, time.UTC), 24 * time.Hour, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 1, 17, 42, 0, 0, time.UTC), time.Hour, time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)},
+		// Exactly on a boundary waits for the next one
+		{time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC), time.Hour, time.Date(2026, 3, 1, 19, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		if got := NextBoundary(tc.now, tc.interval); !got.Equal(tc.want) {
+			t.Errorf("NextBoundary(%s, %s) = %s, want %s", tc.now, tc.interval, got, tc.want)
+		}
+	}
+}
+
+func TestRunFiresOnTheIntervalBoundary(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	flushed := make(chan struct{}, 4)
+	c := New(Options{Logger: slog.New(flushHandler{flushed})})
+	c.Register("webhooks", "processed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, interval)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-flushed:
+		case <-time.After(5 * interval):
+			t.Fatal("no digest within the interval")
+		}
+		last := c.Last()
+		boundary := last.Until.Truncate(interval)
+		if late := last.Until.Sub(boundary); late > interval/2 {
+			t.Fatalf("digest logged %s after the boundary %s", late, boundary)
+		}
+		if i > 0 && !last.Since.Truncate(interval).Equal(boundary.Add(-interval)) {
+			t.Fatalf("digest covers %s to %s, want one interval", last.Since, last.Until)
+		}
+	}
+	if c.Interval() != interval {
+		t.Fatalf("Interval() = %s, want %s", c.Interval(), interval)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * interval):
+		t.Fatal("Run did not return once its context ended")
+	}
+}
+
+// flushHandler signals every record logged, which without Debug is a digest
+type flushHandler struct {
+	flushed chan struct{}
+}
+
+func (h flushHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h flushHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h flushHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h flushHandler) Handle(context.Context, slog.Record) error {
+	select {
+	case h.flushed <- struct{}{}:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package geo
+
+import "math"
+
+// earthRadiusMeters is the mean Earth radius the distances are computed with
+const earthRadiusMeters = 6371000.0
+
+// HaversineMeters returns the great-circle distance in meters between two points given
+// in degrees
+func HaversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLng := (lng2 - lng1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusMeters * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
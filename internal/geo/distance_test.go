@@ -0,0 +1,23 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversineMeters(t *testing.T) {
+	for _, tt := range []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"same point", 47.37, 8.54, 47.37, 8.54, 0},
+		{"one degree of latitude", 0, 0, 1, 0, 111194.93},
+		{"one degree of longitude at 60N", 60, 10, 60, 11, 55596.93},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, 111194.93},
+	} {
+		if got := HaversineMeters(tt.lat1, tt.lng1, tt.lat2, tt.lng2); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%s: HaversineMeters = %.2f, want %.2f", tt.name, got, tt.want)
+		}
+	}
+}
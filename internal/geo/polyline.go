@@ -0,0 +1,99 @@
+// Package geo holds coordinate helpers shared by the Strava client, the web API and
+// the packages measuring tracks.
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// PolylinePrecision is the number of decimal places kept by EncodePolyline and expected
+// by DecodePolyline, the polyline5 format used by Google and Strava
+const PolylinePrecision = 5
+
+// polylineScale turns degrees into the integers the format encodes
+const polylineScale = 1e5
+
+// EncodePolyline encodes [lat, lng] pairs, the layout of LatLngStream.Data, as a Google
+// encoded polyline with 5 decimal places. Pairs with fewer than two values are skipped.
+func EncodePolyline(points [][]float64) string {
+	var b strings.Builder
+	b.Grow(len(points) * 8)
+	var prevLat, prevLng int64
+	for _, point := range points {
+		if len(point) < 2 {
+			continue
+		}
+		lat := int64(math.Round(point[0] * polylineScale))
+		lng := int64(math.Round(point[1] * polylineScale))
+		encodePolylineValue(&b, lat-prevLat)
+		encodePolylineValue(&b, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return b.String()
+}
+
+// encodePolylineValue appends one zigzag-encoded value in 5-bit chunks
+func encodePolylineValue(b *strings.Builder, value int64) {
+	v := value << 1
+	if value < 0 {
+		v = ^v
+	}
+	for v >= 0x20 {
+		b.WriteByte(byte((0x20 | (v & 0x1f)) + 63))
+		v >>= 5
+	}
+	b.WriteByte(byte(v + 63))
+}
+
+// DecodePolyline decodes a Google encoded polyline into [lat, lng] pairs, the same layout
+// as LatLngStream.Data
+func DecodePolyline(encoded string) ([][]float64, error) {
+	var points [][]float64
+	var lat, lng int64
+	for i := 0; i < len(encoded); {
+		dLat, next, err := decodePolylineValue(encoded, i)
+		if err != nil {
+			return nil, err
+		}
+		dLng, next, err := decodePolylineValue(encoded, next)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		lat += dLat
+		lng += dLng
+		points = append(points, []float64{float64(lat) / polylineScale, float64(lng) / polylineScale})
+	}
+	return points, nil
+}
+
+// decodePolylineValue reads one zigzag-encoded varint starting at pos and returns it with
+// the position of the next value
+func decodePolylineValue(encoded string, pos int) (int64, int, error) {
+	var result int64
+	var shift uint
+	for {
+		if pos >= len(encoded) {
+			return 0, pos, fmt.Errorf("truncated polyline at offset %d", pos)
+		}
+		b := int64(encoded[pos]) - 63
+		if b < 0 || b > 63 {
+			return 0, pos, fmt.Errorf("invalid polyline character %q at offset %d", encoded[pos], pos)
+		}
+		pos++
+		result |= (b & 0x1f) << shift
+		shift += 5
+		if b < 0x20 {
+			break
+		}
+		if shift > 60 {
+			return 0, pos, fmt.Errorf("polyline value too long at offset %d", pos)
+		}
+	}
+	if result&1 != 0 {
+		return ^(result >> 1), pos, nil
+	}
+	return result >> 1, pos, nil
+}
@@ -0,0 +1,104 @@
+package geo
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Vectors from Google's "Encoded Polyline Algorithm Format" documentation
+var polylineVectors = []struct {
+	name    string
+	encoded string
+	points  [][]float64
+}{
+	{
+		name:    "reference example",
+		encoded: "_p~iF~ps|U_ulLnnqC_mqNvxq`@",
+		points:  [][]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}},
+	},
+	{
+		name:    "southern hemisphere with repeated point",
+		encoded: "b_vmEaa|y[??oiAqd@",
+		points:  [][]float64{{-33.86882, 151.20929}, {-33.86882, 151.20929}, {-33.8569, 151.2153}},
+	},
+	{
+		name:    "empty",
+		encoded: "",
+		points:  nil,
+	},
+}
+
+func TestDecodePolylineKnownValues(t *testing.T) {
+	for _, tc := range polylineVectors {
+		got, err := DecodePolyline(tc.encoded)
+		if err != nil {
+			t.Fatalf("%s: DecodePolyline: %v", tc.name, err)
+		}
+		if len(got) != len(tc.points) {
+			t.Fatalf("%s: decoded %d points, want %d", tc.name, len(got), len(tc.points))
+		}
+		for i := range tc.points {
+			if math.Abs(got[i][0]-tc.points[i][0]) > 1e-9 || math.Abs(got[i][1]-tc.points[i][1]) > 1e-9 {
+				t.Fatalf("%s: point %d = %v, want %v", tc.name, i, got[i], tc.points[i])
+			}
+		}
+	}
+}
+
+func TestDecodePolylineRejectsMalformedInput(t *testing.T) {
+	for _, encoded := range []string{"_p~iF~ps|U_", "_p~iF", "_p~iF ps|U"} {
+		if _, err := DecodePolyline(encoded); err == nil {
+			t.Errorf("DecodePolyline(%q) succeeded, want an error", encoded)
+		}
+	}
+}
+
+func TestEncodePolylineKnownValues(t *testing.T) {
+	for _, tc := range polylineVectors {
+		if got := EncodePolyline(tc.points); got != tc.encoded {
+			t.Fatalf("%s: EncodePolyline = %q, want %q", tc.name, got, tc.encoded)
+		}
+	}
+	// The documentation's single-value example, -179.9832104, encodes to "`~oia@"
+	if got := EncodePolyline([][]float64{{-179.9832104, 0}}); !strings.HasPrefix(got, "`~oia@") {
+		t.Fatalf("EncodePolyline(-179.9832104) = %q, want it to start with \"`~oia@\"", got)
+	}
+	// Rounding to 5 decimals happens on the absolute value, so errors do not accumulate
+	if got := EncodePolyline([][]float64{{0.000004, 0}, {0.000008, 0}, {0.000012, 0}}); got != "??A???" {
+		t.Fatalf("EncodePolyline of sub-precision steps = %q", got)
+	}
+}
+
+func TestPolylineRoundTripAndSize(t *testing.T) {
+	// A 30 km ride with a point every ~10 m, as a simplified route would be sent
+	var route [][]float64
+	for i := 0; i < 3000; i++ {
+		angle := float64(i) / 500
+		route = append(route, []float64{45.1234567 + 0.05*math.Sin(angle), 7.6543210 + 0.08*math.Cos(angle*1.3)})
+	}
+
+	encoded := EncodePolyline(route)
+	decoded, err := DecodePolyline(encoded)
+	if err != nil || len(decoded) != len(route) {
+		t.Fatalf("round trip: %d points, %v", len(decoded), err)
+	}
+	for i := range route {
+		if math.Abs(decoded[i][0]-route[i][0]) > 0.5e-5 || math.Abs(decoded[i][1]-route[i][1]) > 0.5e-5 {
+			t.Fatalf("point %d = %v, want %v within 5 decimals", i, decoded[i], route[i])
+		}
+	}
+
+	// GeoJSON coordinates as ST_AsGeoJSON(..., 6) writes them
+	coordinates := make([][]float64, len(route))
+	for i, point := range route {
+		coordinates[i] = []float64{math.Round(point[1]*1e6) / 1e6, math.Round(point[0]*1e6) / 1e6}
+	}
+	geoJSON, _ := json.Marshal(coordinates)
+	ratio := float64(len(geoJSON)) / float64(len(encoded))
+	t.Logf("%d points: GeoJSON coordinates %d bytes, polyline %d bytes (%.1fx)", len(route), len(geoJSON), len(encoded), ratio)
+	if ratio < 5 {
+		t.Fatalf("polyline is only %.1fx smaller than GeoJSON (%d vs %d bytes)", ratio, len(encoded), len(geoJSON))
+	}
+}
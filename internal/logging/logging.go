@@ -0,0 +1,132 @@
+// Package logging builds B11K's slog logger from the configured level and format and
+// routes the standard log package through it, so lines that libraries write with
+// log.Printf come out in the same format. Attributes named like credentials, and
+// credentials spelled out in messages, are redacted before they reach the output.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Formats of the log output
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces a sensitive value in the output
+const Redacted = "[redacted]"
+
+// ParseLevel reads debug, info, warn (or warning) or error in any case; empty is info
+func ParseLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, want debug, info, warn or error", value)
+}
+
+// New returns a logger writing records of at least level to w as text or json; empty
+// values mean info and text
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	minLevel, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: minLevel, ReplaceAttr: redactAttr}
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, want text or json", format)
+}
+
+// Install makes logger the slog default and sends the standard log package through it.
+// B11K itself logs with slog; a line written with log.Printf, by a library such as
+// net/http, is logged at info.
+func Install(logger *slog.Logger) {
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(stdWriter{logger: logger})
+}
+
+// Fatal logs msg and args at error with the default logger and exits with status 1,
+// for failures a command or the server cannot start past
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// stdWriter turns each write of the standard logger into one record
+type stdWriter struct {
+	logger *slog.Logger
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.logger.Log(context.Background(), slog.LevelInfo, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// sensitiveKeys are the parts of attribute names whose values are never logged
+var sensitiveKeys = []string{"token", "password", "passwd", "secret", "authorization", "cookie", "api_key", "apikey"}
+
+// SensitiveKey reports whether an attribute named key holds a credential
+func SensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeys {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials written out in text: query or form parameters, JSON fields and
+// Authorization header values
+var (
+	credentialParam = regexp.MustCompile(`(?i)\b((?:access_|refresh_|verify_|hub\.verify_)?token|client_secret|password|code)=[^&\s"',;:]+`)
+	credentialField = regexp.MustCompile(`(?i)"((?:access_|refresh_)?token|client_secret|password)"\s*:\s*"[^"]*"`)
+	bearerValue     = regexp.MustCompile(`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9._~+/=-]+`)
+)
+
+// RedactText masks the credentials written out in value
+func RedactText(value string) string {
+	value = credentialParam.ReplaceAllString(value, "$1="+Redacted)
+	value = credentialField.ReplaceAllString(value, `"$1":"`+Redacted+`"`)
+	return bearerValue.ReplaceAllString(value, "$1 "+Redacted)
+}
+
+// redactAttr masks the values of sensitive attributes and the credentials in messages,
+// strings and errors
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup {
+		return attr
+	}
+	if attr.Key != slog.MessageKey && SensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, RedactText(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, RedactText(err.Error()))
+		}
+	}
+	return attr
+}
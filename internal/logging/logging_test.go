@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// records decodes the JSON lines written to buf
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		out = append(out, record)
+	}
+	return out
+}
+
+func TestNewFiltersByLevelAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "WARN", "json")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("routine")
+	logger.Warn("slow query", "duration_ms", 1200)
+	got := records(t, &buf)
+	if len(got) != 1 || got[0]["msg"] != "slow query" || got[0]["level"] != "WARN" || got[0]["duration_ms"] != float64(1200) {
+		t.Fatalf("records = %v, want only the warning", got)
+	}
+
+	buf.Reset()
+	if logger, err = New(&buf, "", ""); err != nil {
+		t.Fatalf("New with defaults: %v", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("shown", "athlete_id", 7)
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, `level=INFO msg=shown athlete_id=7`) {
+		t.Fatalf("default output = %q, want info as text", out)
+	}
+
+	for _, bad := range [][2]string{{"verbose", "text"}, {"info", "xml"}} {
+		if _, err := New(&buf, bad[0], bad[1]); err == nil {
+			t.Fatalf("New(%q, %q) accepted", bad[0], bad[1])
+		}
+	}
+}
+
+func TestCredentialsAreRedacted(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "debug", "json")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	logger.Info("token exchange failed: POST /oauth/token?code=abc123&client_secret=s3cret",
+		"access_token", "tok-1",
+		"db_password", "hunter2",
+		"auth", slog.GroupValue(slog.String("refresh_token", "tok-2")),
+		"error", errors.New(`strava said {"access_token":"tok-3","expires_at":1}`),
+		"header", "Bearer tok-4",
+		"athlete_id", 7,
+	)
+	out := buf.String()
+	for _, secret := range []string{"abc123", "s3cret", "tok-1", "hunter2", "tok-2", "tok-3", "tok-4"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("output leaks %q: %s", secret, out)
+		}
+	}
+	record := records(t, &buf)[0]
+	if record["athlete_id"] != float64(7) || record["access_token"] != Redacted {
+		t.Fatalf("record = %v", record)
+	}
+	if !strings.Contains(out, `expires_at`) || !strings.Contains(out, "/oauth/token?code="+Redacted) {
+		t.Fatalf("redaction removed more than the credentials: %s", out)
+	}
+}
+
+func TestInstallRoutesTheStandardLogger(t *testing.T) {
+	previous, flags, writer := slog.Default(), log.Flags(), log.Writer()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetFlags(flags)
+		log.SetOutput(writer)
+	})
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", "json")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	Install(logger)
+	log.Printf("http: TLS handshake error from 10.0.0.1: token=abc %v", errors.New("EOF"))
+	// The text of a line does not decide its level
+	log.Printf("❌ Error in a library")
+
+	got := records(t, &buf)
+	if len(got) != 2 {
+		t.Fatalf("records = %v, want both lines", got)
+	}
+	if got[0]["level"] != "INFO" || got[0]["msg"] != "http: TLS handshake error from 10.0.0.1: token="+Redacted+" EOF" {
+		t.Fatalf("first line = %v", got[0])
+	}
+	if got[1]["level"] != "INFO" {
+		t.Fatalf("second line = %v, want info", got[1])
+	}
+
+	buf.Reset()
+	if logger, err = New(&buf, "warn", "json"); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	Install(logger)
+	log.Printf("routine library line")
+	if buf.Len() != 0 {
+		t.Fatalf("info line logged at warn: %s", buf.String())
+	}
+}
@@ -0,0 +1,110 @@
+// Package metrics holds B11K's Prometheus metrics and serves them for /metrics. The
+// packages doing the work record through the functions here, so none of them needs to
+// know about Prometheus: strava counts its API calls, sync its runs and activities,
+// pggeo times its heavy queries and web times each request by route. State the web
+// server already keeps, like its queues and soft limits, is read through Sources.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Results counted by sync_activities_processed_total
+const (
+	ResultSaved    = "saved"
+	ResultFailed   = "failed"
+	ResultRejected = "rejected"
+	ResultGone     = "gone"
+)
+
+// StatusError labels a Strava request that got no response
+const StatusError = "error"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	stravaRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "strava_api_requests_total",
+		Help: "Requests sent to the Strava API by endpoint and response status.",
+	}, []string{"endpoint", "status"})
+	syncActivities = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sync_activities_processed_total",
+		Help: "Activities handled by syncs by result; a retried activity counts once per attempt.",
+	}, []string{"result"})
+	syncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sync_duration_seconds",
+		Help:    "How long a Strava sync took, not counting the rounds retrying failed activities.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	})
+	syncErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sync_errors_total",
+		Help: "Strava syncs that ended with an error.",
+	})
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "How long the heavy database calls took by name.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"query_name"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long the server took to answer by route pattern, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		stravaRequests, syncActivities, syncDuration, syncErrors, dbQueryDuration, httpRequestDuration,
+	)
+}
+
+// Handler serves every metric in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// StravaRequest counts a Strava API call; status is the HTTP status, or StatusError
+// when the request failed without one
+func StravaRequest(endpoint, status string) {
+	stravaRequests.WithLabelValues(endpoint, status).Inc()
+}
+
+// SyncActivities counts n activities a sync handled with result
+func SyncActivities(result string, n int) {
+	if n > 0 {
+		syncActivities.WithLabelValues(result).Add(float64(n))
+	}
+}
+
+// SyncFinished records a finished sync and whether it failed
+func SyncFinished(d time.Duration, failed bool) {
+	syncDuration.Observe(d.Seconds())
+	if failed {
+		syncErrors.Inc()
+	}
+}
+
+// DBQuery records how long the heavy database call name took
+func DBQuery(name string, d time.Duration) {
+	dbQueryDuration.WithLabelValues(name).Observe(d.Seconds())
+}
+
+// HTTPRequest records how long a request to route took; route is the ServeMux pattern
+// that served it and unusual methods are OTHER, so the labels stay bounded whatever
+// clients send
+func HTTPRequest(route, method string, status int, d time.Duration) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+	httpRequestDuration.WithLabelValues(route, method, strconv.Itoa(status)).Observe(d.Seconds())
+}
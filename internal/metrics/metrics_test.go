@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/queue"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestHandlerExportsWhatWasRecorded(t *testing.T) {
+	StravaRequest("/athlete/activities", "429")
+	StravaRequest("/athlete/activities", StatusError)
+	SyncActivities(ResultSaved, 3)
+	SyncActivities(ResultFailed, 0)
+	SyncFinished(90*time.Second, true)
+	DBQuery("segment_match", 40*time.Millisecond)
+	HTTPRequest("GET /api/activities/{id}", "PROPFIND", 200, time.Millisecond)
+
+	out := scrape(t)
+	for _, want := range []string{
+		`strava_api_requests_total{endpoint="/athlete/activities",status="429"} 1`,
+		`strava_api_requests_total{endpoint="/athlete/activities",status="error"} 1`,
+		`sync_activities_processed_total{result="saved"} 3`,
+		`sync_duration_seconds_bucket{le="120"} 1`,
+		`sync_errors_total 1`,
+		`db_query_duration_seconds_bucket{query_name="segment_match",le="0.05"} 1`,
+		`http_request_duration_seconds_count{method="OTHER",route="GET /api/activities/{id}",status="200"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("metrics lack %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `result="failed"`) {
+		t.Fatal("counting no activities created a series")
+	}
+}
+
+func TestSourcesAreReadAtEveryScrape(t *testing.T) {
+	degraded := false
+	SetSources(Sources{
+		SpatialDegraded: func() bool { return degraded },
+		AthleteCache:    func() (int64, int64) { return 12, 3 },
+		Queues: func() []queue.Stats {
+			return []queue.Stats{{Name: "share_views", Capacity: 1024, Depth: 7, Enqueued: 40, Processed: 33, Dropped: 2, AvgLatencyMS: 250, MaxLatencyMS: 1500}}
+		},
+		SoftLimits: func() []SoftLimit {
+			return []SoftLimit{{Name: "point_samples", Value: 120, Max: 100}, {Name: "database_size", Value: 10, Max: 50}}
+		},
+		Digest: func() map[string]map[string]uint64 {
+			return map[string]map[string]uint64{"webhooks": {"sent": 14, "failed": 1}}
+		},
+	})
+	t.Cleanup(func() { SetSources(Sources{}) })
+
+	out := scrape(t)
+	for _, want := range []string{
+		`spatial_self_check_degraded 0`,
+		`athlete_cache_hits_total 12`,
+		`athlete_cache_misses_total 3`,
+		`queue_depth{queue="share_views"} 7`,
+		`queue_capacity{queue="share_views"} 1024`,
+		`queue_enqueued_total{queue="share_views"} 40`,
+		`queue_processed_total{queue="share_views"} 33`,
+		`queue_dropped_total{queue="share_views"} 2`,
+		`queue_avg_latency_seconds{queue="share_views"} 0.25`,
+		`queue_max_latency_seconds{queue="share_views"} 1.5`,
+		`soft_limit_usage{limit="point_samples"} 120`,
+		`soft_limit_max{limit="point_samples"} 100`,
+		`soft_limit_exceeded{limit="point_samples"} 1`,
+		`soft_limit_exceeded{limit="database_size"} 0`,
+		`digest_events_total{counter="sent",subsystem="webhooks"} 14`,
+		`digest_events_total{counter="failed",subsystem="webhooks"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("metrics lack %q:\n%s", want, out)
+		}
+	}
+
+	degraded = true
+	if out := scrape(t); !strings.Contains(out, "spatial_self_check_degraded 1") {
+		t.Fatal("the spatial flag was not read again")
+	}
+
+	SetSources(Sources{})
+	if out := scrape(t); strings.Contains(out, "queue_depth") || strings.Contains(out, "spatial_self_check_degraded") {
+		t.Fatal("metrics exported without a source")
+	}
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"sync"
+
+	"b11k/internal/queue"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SoftLimit is one configured soft limit and the usage last measured against it
+type SoftLimit struct {
+	Name  string
+	Value int64
+	Max   int64
+}
+
+// Sources read state the web server already keeps, at every scrape, so it is exported
+// without being counted twice. A nil field leaves its metrics out.
+type Sources struct {
+	// SpatialDegraded reports whether the startup spatial self-check failed
+	SpatialDegraded func() bool
+	// AthleteCache returns the Strava athlete cache hits and misses since startup
+	AthleteCache func() (hits, misses int64)
+	// Queues returns the stats of every in-process queue
+	Queues func() []queue.Stats
+	// SoftLimits returns the configured limits with the last measured usage, none
+	// before the first check
+	SoftLimits func() []SoftLimit
+	// Digest returns the digest counts since startup by subsystem and counter
+	Digest func() map[string]map[string]uint64
+}
+
+var (
+	spatialDegradedDesc = prometheus.NewDesc("spatial_self_check_degraded",
+		"1 when the startup self-check of the PostGIS helper functions failed.", nil, nil)
+	athleteCacheHitsDesc = prometheus.NewDesc("athlete_cache_hits_total",
+		"Strava athlete lookups answered from the cache.", nil, nil)
+	athleteCacheMissesDesc = prometheus.NewDesc("athlete_cache_misses_total",
+		"Strava athlete lookups the cache could not answer.", nil, nil)
+	queueDepthDesc = prometheus.NewDesc("queue_depth",
+		"Items waiting in an in-process queue.", []string{"queue"}, nil)
+	queueCapacityDesc = prometheus.NewDesc("queue_capacity",
+		"How many items an in-process queue holds before its overflow policy applies.", []string{"queue"}, nil)
+	queueEnqueuedDesc = prometheus.NewDesc("queue_enqueued_total",
+		"Items pushed to an in-process queue.", []string{"queue"}, nil)
+	queueProcessedDesc = prometheus.NewDesc("queue_processed_total",
+		"Items an in-process queue's consumer finished.", []string{"queue"}, nil)
+	queueDroppedDesc = prometheus.NewDesc("queue_dropped_total",
+		"Items an in-process queue dropped when full.", []string{"queue"}, nil)
+	queueAvgLatencyDesc = prometheus.NewDesc("queue_avg_latency_seconds",
+		"Average time from push to done in an in-process queue.", []string{"queue"}, nil)
+	queueMaxLatencyDesc = prometheus.NewDesc("queue_max_latency_seconds",
+		"Longest time from push to done in an in-process queue.", []string{"queue"}, nil)
+	softLimitUsageDesc = prometheus.NewDesc("soft_limit_usage",
+		"Usage last measured against a soft limit.", []string{"limit"}, nil)
+	softLimitMaxDesc = prometheus.NewDesc("soft_limit_max",
+		"A configured soft limit.", []string{"limit"}, nil)
+	softLimitExceededDesc = prometheus.NewDesc("soft_limit_exceeded",
+		"1 while the last measured usage is past a soft limit.", []string{"limit"}, nil)
+	digestEventsDesc = prometheus.NewDesc("digest_events_total",
+		"Background events counted for the log digest, by subsystem and counter.", []string{"subsystem", "counter"}, nil)
+)
+
+// sourceCollector exports the current Sources at every scrape
+type sourceCollector struct {
+	mu      sync.RWMutex
+	sources Sources
+}
+
+var registeredSources = &sourceCollector{}
+
+func init() {
+	registry.MustRegister(registeredSources)
+}
+
+// SetSources replaces the sources read at every scrape
+func SetSources(sources Sources) {
+	registeredSources.mu.Lock()
+	defer registeredSources.mu.Unlock()
+	registeredSources.sources = sources
+}
+
+func (c *sourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		spatialDegradedDesc, athleteCacheHitsDesc, athleteCacheMissesDesc,
+		queueDepthDesc, queueCapacityDesc, queueEnqueuedDesc, queueProcessedDesc, queueDroppedDesc,
+		queueAvgLatencyDesc, queueMaxLatencyDesc,
+		softLimitUsageDesc, softLimitMaxDesc, softLimitExceededDesc, digestEventsDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *sourceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	sources := c.sources
+	c.mu.RUnlock()
+
+	if sources.SpatialDegraded != nil {
+		ch <- prometheus.MustNewConstMetric(spatialDegradedDesc, prometheus.GaugeValue, boolValue(sources.SpatialDegraded()))
+	}
+	if sources.AthleteCache != nil {
+		hits, misses := sources.AthleteCache()
+		ch <- prometheus.MustNewConstMetric(athleteCacheHitsDesc, prometheus.CounterValue, float64(hits))
+		ch <- prometheus.MustNewConstMetric(athleteCacheMissesDesc, prometheus.CounterValue, float64(misses))
+	}
+	if sources.Queues != nil {
+		for _, q := range sources.Queues() {
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(q.Depth), q.Name)
+			ch <- prometheus.MustNewConstMetric(queueCapacityDesc, prometheus.GaugeValue, float64(q.Capacity), q.Name)
+			ch <- prometheus.MustNewConstMetric(queueEnqueuedDesc, prometheus.CounterValue, float64(q.Enqueued), q.Name)
+			ch <- prometheus.MustNewConstMetric(queueProcessedDesc, prometheus.CounterValue, float64(q.Processed), q.Name)
+			ch <- prometheus.MustNewConstMetric(queueDroppedDesc, prometheus.CounterValue, float64(q.Dropped), q.Name)
+			ch <- prometheus.MustNewConstMetric(queueAvgLatencyDesc, prometheus.GaugeValue, q.AvgLatencyMS/1000, q.Name)
+			ch <- prometheus.MustNewConstMetric(queueMaxLatencyDesc, prometheus.GaugeValue, q.MaxLatencyMS/1000, q.Name)
+		}
+	}
+	if sources.SoftLimits != nil {
+		for _, limit := range sources.SoftLimits() {
+			ch <- prometheus.MustNewConstMetric(softLimitUsageDesc, prometheus.GaugeValue, float64(limit.Value), limit.Name)
+			ch <- prometheus.MustNewConstMetric(softLimitMaxDesc, prometheus.GaugeValue, float64(limit.Max), limit.Name)
+			ch <- prometheus.MustNewConstMetric(softLimitExceededDesc, prometheus.GaugeValue, boolValue(limit.Value > limit.Max), limit.Name)
+		}
+	}
+	if sources.Digest != nil {
+		for subsystem, counts := range sources.Digest() {
+			for counter, n := range counts {
+				ch <- prometheus.MustNewConstMetric(digestEventsDesc, prometheus.CounterValue, float64(n), subsystem, counter)
+			}
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
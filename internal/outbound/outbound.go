@@ -0,0 +1,524 @@
+// Package outbound delivers B11K events to configured HTTP endpoints. Events are queued
+// per endpoint and sent in the background, signed with the endpoint's secret and retried
+// with exponential backoff; deliveries that give up are kept in a dead-letter list.
+// With a Store, events that overflow a queue or are still queued at shutdown are kept
+// there and delivered later instead of being lost.
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"b11k/internal/digest"
+	"b11k/internal/queue"
+)
+
+// Event types
+const (
+	EventActivityCreated = "activity.created"
+	EventActivityUpdated = "activity.updated"
+	EventSegmentPR       = "segment.pr"
+)
+
+// EventTypes lists every event an endpoint can subscribe to
+var EventTypes = []string{EventActivityCreated, EventActivityUpdated, EventSegmentPR}
+
+// DigestSubsystem names the dispatcher's counters in the log digest
+const DigestSubsystem = "outbound webhooks"
+
+// Request headers. The signature is "sha256=" and the hex HMAC-SHA256 of the timestamp,
+// a dot and the body, keyed with the endpoint secret; see Sign.
+const (
+	HeaderEvent     = "X-B11K-Event"
+	HeaderDelivery  = "X-B11K-Delivery"
+	HeaderTimestamp = "X-B11K-Timestamp"
+	HeaderSignature = "X-B11K-Signature"
+)
+
+const (
+	defaultQueueSize      = 256
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 2 * time.Second
+	maxBackoff            = 5 * time.Minute
+	maxFailures           = 100
+	storeBatchSize        = 50
+	storeTimeout          = 5 * time.Second
+)
+
+// Endpoint is a receiver of events. Empty Events subscribes to every event type.
+type Endpoint struct {
+	URL    string
+	Secret string
+	Events []string
+}
+
+func (e Endpoint) wants(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Options tune delivery; zero values use the defaults
+type Options struct {
+	QueueSize      int           // events buffered per endpoint (default 256)
+	MaxAttempts    int           // attempts per delivery before giving up (default 5)
+	InitialBackoff time.Duration // wait after the first failure, doubled each retry (default 2s)
+	Client         *http.Client  // default has a 10s timeout
+	// Store, when set, takes events that overflow a queue and those left at shutdown.
+	// Without one a full queue drops its oldest event.
+	Store Store
+	// Digest counts deliveries, retries and saved events, which are then only logged
+	// at debug level; failures are always logged
+	Digest *digest.Collector
+}
+
+// Store persists events an endpoint's queue could not hold
+type Store interface {
+	// Save keeps events for the endpoint, in order
+	Save(ctx context.Context, endpointURL string, events []Event) error
+	// Take removes and returns up to limit of the endpoint's oldest saved events
+	Take(ctx context.Context, endpointURL string, limit int) ([]Event, error)
+}
+
+// Event is the JSON body posted to endpoints. Data is the activity summary for activity
+// events and a SegmentPR-shaped object for segment.pr; it never carries point samples.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	AthleteID int64           `json:"athlete_id"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Failure is a delivery that gave up, kept for GET /api/admin/webhooks/failures
+type Failure struct {
+	EndpointURL string    `json:"endpoint_url"`
+	EventID     string    `json:"event_id"`
+	EventType   string    `json:"event_type"`
+	AthleteID   int64     `json:"athlete_id"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	FailedAt    time.Time `json:"failed_at"`
+}
+
+type queuedEvent struct {
+	event Event
+	body  []byte
+}
+
+type endpointQueue struct {
+	Endpoint
+	events *queue.Queue[queuedEvent]
+	// saved is bumped whenever events go to the Store, and reset once a Take finds
+	// fewer than asked for; nonzero means the Store may hold events for the endpoint
+	saved atomic.Int64
+}
+
+// Dispatcher queues events for its endpoints. A nil *Dispatcher accepts and drops every
+// event, so callers need not check whether outbound webhooks are configured.
+type Dispatcher struct {
+	endpoints []*endpointQueue
+	opts      Options
+	sleep     func(ctx context.Context, d time.Duration) error
+
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+	stopping atomic.Bool
+
+	mu       sync.Mutex
+	failures []Failure // oldest first, at most maxFailures
+}
+
+// NewDispatcher validates the endpoints and returns a dispatcher for them, or nil when
+// there are none. Call Start to begin delivering.
+func NewDispatcher(endpoints []Endpoint, opts Options) (*Dispatcher, error) {
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultInitialBackoff
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	opts.Digest.Register(DigestSubsystem, "delivered", "retried", "saves", "failed")
+
+	d := &Dispatcher{opts: opts, sleep: sleepContext}
+	for i, endpoint := range endpoints {
+		parsed, err := url.Parse(endpoint.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("outbound webhook %d: url %q must be an absolute http(s) URL", i+1, endpoint.URL)
+		}
+		if endpoint.Secret == "" {
+			return nil, fmt.Errorf("outbound webhook %s: secret is required", endpoint.URL)
+		}
+		for _, eventType := range endpoint.Events {
+			if !knownEventType(eventType) {
+				return nil, fmt.Errorf("outbound webhook %s: unknown event %q", endpoint.URL, eventType)
+			}
+		}
+		endpointQueue := &endpointQueue{Endpoint: endpoint}
+		queueOpts := queue.Options[queuedEvent]{Capacity: opts.QueueSize, Policy: queue.DropOldest}
+		if opts.Store != nil {
+			queueOpts.Policy = queue.Spill
+			queueOpts.Spill = func(queued queuedEvent) error {
+				return d.save(endpointQueue, []Event{queued.event})
+			}
+		} else {
+			queueOpts.OnDrop = func(queued queuedEvent) {
+				d.recordFailure(endpoint.URL, queued.event, 0, "queue full")
+			}
+		}
+		events, err := queue.New("webhook "+endpoint.URL, queueOpts)
+		if err != nil {
+			return nil, err
+		}
+		endpointQueue.events = events
+		d.endpoints = append(d.endpoints, endpointQueue)
+	}
+	return d, nil
+}
+
+func knownEventType(eventType string) bool {
+	for _, t := range EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Start runs one delivery worker per endpoint until ctx is done or Shutdown. Workers
+// deliver events left in the Store by an earlier run once their queue is empty.
+func (d *Dispatcher) Start(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	ctx, d.cancel = context.WithCancel(ctx)
+	for _, endpoint := range d.endpoints {
+		if d.opts.Store != nil {
+			endpoint.saved.Store(1)
+		}
+		d.workers.Add(1)
+		go d.run(ctx, endpoint)
+	}
+}
+
+// Shutdown stops accepting events and lets the workers deliver what is queued until ctx
+// is done. Events still queued or being retried then go to the Store, or are recorded
+// as failures without one.
+func (d *Dispatcher) Shutdown(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	d.stopping.Store(true)
+	for _, endpoint := range d.endpoints {
+		endpoint.events.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Webhook deliveries still queued at shutdown, saving them")
+	}
+	if d.cancel != nil {
+		d.cancel()
+	}
+	<-done
+
+	for _, endpoint := range d.endpoints {
+		left := endpoint.events.Drain()
+		if len(left) == 0 {
+			continue
+		}
+		events := make([]Event, len(left))
+		for i, queued := range left {
+			events[i] = queued.event
+		}
+		d.saveOrFail(endpoint, events, "dispatcher stopped")
+	}
+}
+
+// QueueStats returns a snapshot of every endpoint's queue
+func (d *Dispatcher) QueueStats() []queue.Stats {
+	if d == nil {
+		return []queue.Stats{}
+	}
+	stats := make([]queue.Stats, len(d.endpoints))
+	for i, endpoint := range d.endpoints {
+		stats[i] = endpoint.events.Stats()
+	}
+	return stats
+}
+
+// Wants reports whether any endpoint subscribes to eventType, so callers can skip
+// expensive work such as PR detection when nobody listens
+func (d *Dispatcher) Wants(eventType string) bool {
+	if d == nil {
+		return false
+	}
+	for _, endpoint := range d.endpoints {
+		if endpoint.wants(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit queues an event for every subscribed endpoint without blocking on deliveries. data
+// is marshalled at once, so later changes to it are not sent. An endpoint whose queue is
+// full saves the event to the Store, or drops its oldest event as a failure.
+func (d *Dispatcher) Emit(eventType string, athleteID int64, data any) {
+	if !d.Wants(eventType) {
+		return
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		d.opts.Digest.Error(DigestSubsystem, "failed", "Failed to encode webhook event", "type", eventType, "error", err)
+		return
+	}
+	event := Event{
+		ID:        newEventID(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		AthleteID: athleteID,
+		Data:      raw,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.opts.Digest.Error(DigestSubsystem, "failed", "Failed to encode webhook event", "type", eventType, "error", err)
+		return
+	}
+	for _, endpoint := range d.endpoints {
+		if !endpoint.wants(eventType) {
+			continue
+		}
+		err := endpoint.events.Push(context.Background(), queuedEvent{event: event, body: body})
+		if errors.Is(err, queue.ErrClosed) {
+			d.saveOrFail(endpoint, []Event{event}, "dispatcher stopped")
+		} else if err != nil {
+			d.opts.Digest.Error(DigestSubsystem, "failed", "Failed to save overflowing webhook event", "event_id", event.ID, "url", endpoint.URL, "error", err)
+			d.recordFailure(endpoint.URL, event, 0, "queue full")
+		}
+	}
+}
+
+// Failures returns the deliveries that gave up, newest first
+func (d *Dispatcher) Failures() []Failure {
+	if d == nil {
+		return []Failure{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	failures := make([]Failure, len(d.failures))
+	for i, failure := range d.failures {
+		failures[len(d.failures)-1-i] = failure
+	}
+	return failures
+}
+
+func (d *Dispatcher) run(ctx context.Context, endpoint *endpointQueue) {
+	defer d.workers.Done()
+	for ctx.Err() == nil {
+		if endpoint.saved.Load() > 0 && endpoint.events.Len() == 0 && !d.stopping.Load() {
+			d.deliverSaved(ctx, endpoint)
+			continue
+		}
+		item, ok := endpoint.events.Pop(ctx)
+		if !ok {
+			return
+		}
+		d.deliver(ctx, endpoint, item.Value)
+		endpoint.events.Done(item)
+	}
+}
+
+// deliverSaved takes the next batch of the endpoint's events from the Store and delivers
+// them; whatever is left when ctx ends goes back
+func (d *Dispatcher) deliverSaved(ctx context.Context, endpoint *endpointQueue) {
+	seen := endpoint.saved.Load()
+	events, err := d.opts.Store.Take(ctx, endpoint.URL, storeBatchSize)
+	if err != nil {
+		d.opts.Digest.Warn(DigestSubsystem, "failed", "Failed to load saved webhook events", "url", endpoint.URL, "error", err)
+		_ = d.sleep(ctx, d.opts.InitialBackoff)
+		return
+	}
+	if len(events) < storeBatchSize {
+		endpoint.saved.CompareAndSwap(seen, 0)
+	}
+	for i, event := range events {
+		if ctx.Err() != nil {
+			d.saveOrFail(endpoint, events[i:], "dispatcher stopped")
+			return
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			d.opts.Digest.Error(DigestSubsystem, "failed", "Failed to encode saved webhook event", "event_id", event.ID, "error", err)
+			continue
+		}
+		d.deliver(ctx, endpoint, queuedEvent{event: event, body: body})
+	}
+}
+
+// deliver posts one event, retrying network errors, 408, 429 and 5xx responses with
+// exponential backoff. Other responses are not retried. An event interrupted by ctx
+// ending goes to the Store when there is one.
+func (d *Dispatcher) deliver(ctx context.Context, endpoint *endpointQueue, queued queuedEvent) {
+	backoff := d.opts.InitialBackoff
+	attempts := 0
+	for {
+		attempts++
+		retry, err := d.post(ctx, endpoint.Endpoint, queued)
+		if err == nil {
+			d.opts.Digest.Record(DigestSubsystem, "delivered", "Delivered webhook", "event_id", queued.event.ID, "type", queued.event.Type, "url", endpoint.URL)
+			return
+		}
+		if retry && attempts < d.opts.MaxAttempts {
+			d.opts.Digest.Record(DigestSubsystem, "retried", "Webhook delivery failed, retrying", "event_id", queued.event.ID, "url", endpoint.URL,
+				"attempt", attempts, "max_attempts", d.opts.MaxAttempts, "backoff", backoff, "error", err)
+			if sleepErr := d.sleep(ctx, backoff); sleepErr == nil {
+				backoff = min(backoff*2, maxBackoff)
+				continue
+			}
+		}
+		if ctx.Err() != nil && d.opts.Store != nil {
+			d.saveOrFail(endpoint, []Event{queued.event}, err.Error())
+			return
+		}
+		d.opts.Digest.Error(DigestSubsystem, "failed", "Giving up on webhook delivery", "event_id", queued.event.ID, "type", queued.event.Type,
+			"url", endpoint.URL, "attempts", attempts, "error", err)
+		d.recordFailure(endpoint.URL, queued.event, attempts, err.Error())
+		return
+	}
+}
+
+// post sends the event once and reports whether a failure is worth retrying
+func (d *Dispatcher) post(ctx context.Context, endpoint Endpoint, queued queuedEvent) (bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(queued.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, queued.event.Type)
+	req.Header.Set(HeaderDelivery, queued.event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, queued.body))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// save hands events to the Store, marking the endpoint as having saved events
+func (d *Dispatcher) save(endpoint *endpointQueue, events []Event) error {
+	if d.opts.Store == nil {
+		return errors.New("no store configured")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := d.opts.Store.Save(ctx, endpoint.URL, events); err != nil {
+		return err
+	}
+	endpoint.saved.Add(1)
+	return nil
+}
+
+// saveOrFail saves events, recording them as failures with reason when they cannot be
+func (d *Dispatcher) saveOrFail(endpoint *endpointQueue, events []Event, reason string) {
+	err := d.save(endpoint, events)
+	if err == nil {
+		d.opts.Digest.Record(DigestSubsystem, "saves", "Saved webhook events", "events", len(events), "url", endpoint.URL)
+		return
+	}
+	if d.opts.Store != nil {
+		d.opts.Digest.Error(DigestSubsystem, "failed", "Failed to save webhook events", "events", len(events), "url", endpoint.URL, "error", err)
+	}
+	for _, event := range events {
+		d.recordFailure(endpoint.URL, event, 0, reason)
+	}
+}
+
+func (d *Dispatcher) recordFailure(endpointURL string, event Event, attempts int, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = append(d.failures, Failure{
+		EndpointURL: endpointURL,
+		EventID:     event.ID,
+		EventType:   event.Type,
+		AthleteID:   event.AthleteID,
+		Attempts:    attempts,
+		LastError:   reason,
+		FailedAt:    time.Now().UTC(),
+	})
+	if len(d.failures) > maxFailures {
+		d.failures = d.failures[len(d.failures)-maxFailures:]
+	}
+}
+
+// Sign returns the X-B11K-Signature value for a body sent at timestamp (Unix seconds).
+// Receivers recompute it with their copy of the secret and compare with hmac.Equal.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,289 @@
+package outbound
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type receivedEvent struct {
+	event     Event
+	signature string
+	valid     bool
+}
+
+// receiver answers each delivery with the next status in statuses (the last one repeats)
+// and reports the events it accepted with a 2xx
+func receiver(t *testing.T, secret string, statuses ...int) (*httptest.Server, *atomic.Int32, chan receivedEvent) {
+	t.Helper()
+	var calls atomic.Int32
+	received := make(chan receivedEvent, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		status := statuses[min(call, len(statuses))-1]
+		w.WriteHeader(status)
+		if status >= 300 {
+			return
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("body %q: %v", body, err)
+		}
+		signature := r.Header.Get(HeaderSignature)
+		expected := Sign(secret, r.Header.Get(HeaderTimestamp), body)
+		received <- receivedEvent{event: event, signature: signature, valid: hmac.Equal([]byte(signature), []byte(expected))}
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &calls, received
+}
+
+func startDispatcher(t *testing.T, endpoints []Endpoint, opts Options) *Dispatcher {
+	t.Helper()
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = time.Millisecond
+	}
+	d, err := NewDispatcher(endpoints, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d.Start(ctx)
+	return d
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	ts, _, received := receiver(t, "s3cret", http.StatusOK)
+	d := startDispatcher(t, []Endpoint{{URL: ts.URL, Secret: "s3cret", Events: []string{EventActivityCreated}}}, Options{})
+
+	d.Emit(EventActivityUpdated, 1, map[string]any{"id": 9}) // not subscribed
+	d.Emit(EventActivityCreated, 1, map[string]any{"id": 10, "name": "Morning Ride"})
+
+	select {
+	case got := <-received:
+		if !got.valid {
+			t.Fatalf("signature %q does not verify", got.signature)
+		}
+		var data map[string]any
+		if got.event.Type != EventActivityCreated || got.event.AthleteID != 1 || json.Unmarshal(got.event.Data, &data) != nil || data["name"] != "Morning Ride" {
+			t.Fatalf("event = %+v", got.event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery")
+	}
+	select {
+	case got := <-received:
+		t.Fatalf("unsubscribed %s event was delivered", got.event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if Sign("other", "1700000000", []byte("{}")) == Sign("s3cret", "1700000000", []byte("{}")) {
+		t.Fatal("signature does not depend on the secret")
+	}
+}
+
+func TestDispatcherRetriesServerErrors(t *testing.T) {
+	ts, calls, received := receiver(t, "s3cret", http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	d := startDispatcher(t, []Endpoint{{URL: ts.URL, Secret: "s3cret"}}, Options{MaxAttempts: 5})
+
+	d.Emit(EventActivityCreated, 1, map[string]any{"id": 10})
+	select {
+	case got := <-received:
+		if !got.valid {
+			t.Fatal("retried delivery is not signed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery after retries")
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("%d attempts, want 3", calls.Load())
+	}
+	if failures := d.Failures(); len(failures) != 0 {
+		t.Fatalf("failures = %+v, want none", failures)
+	}
+}
+
+func TestDispatcherGivesUpAfterMaxAttempts(t *testing.T) {
+	ts, calls, _ := receiver(t, "s3cret", http.StatusInternalServerError)
+	d := startDispatcher(t, []Endpoint{{URL: ts.URL, Secret: "s3cret"}}, Options{MaxAttempts: 3})
+
+	d.Emit(EventSegmentPR, 7, map[string]any{"segment_id": 3})
+	waitFor(t, "the dead letter", func() bool { return len(d.Failures()) == 1 })
+	failure := d.Failures()[0]
+	if calls.Load() != 3 || failure.Attempts != 3 || failure.EventType != EventSegmentPR || failure.AthleteID != 7 || failure.LastError != "status 500" {
+		t.Fatalf("after %d calls failure = %+v, want 3 attempts ending in status 500", calls.Load(), failure)
+	}
+}
+
+func TestDispatcherDoesNotRetryClientErrors(t *testing.T) {
+	ts, calls, _ := receiver(t, "s3cret", http.StatusUnauthorized)
+	d := startDispatcher(t, []Endpoint{{URL: ts.URL, Secret: "s3cret"}}, Options{MaxAttempts: 5})
+
+	d.Emit(EventActivityCreated, 1, map[string]any{"id": 10})
+	waitFor(t, "the dead letter", func() bool { return len(d.Failures()) == 1 })
+	if calls.Load() != 1 || d.Failures()[0].Attempts != 1 {
+		t.Fatalf("%d calls, failure %+v; want one attempt", calls.Load(), d.Failures()[0])
+	}
+}
+
+func TestDispatcherEmitNeverBlocks(t *testing.T) {
+	// Not started, so nothing drains the queue
+	d, err := NewDispatcher([]Endpoint{{URL: "http://127.0.0.1:1/hook", Secret: "s3cret"}}, Options{QueueSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		d.Emit(EventActivityCreated, 1, map[string]any{"id": i})
+	}
+	failures := d.Failures()
+	if len(failures) != 3 || failures[0].LastError != "queue full" {
+		t.Fatalf("failures = %+v, want 3 overflowed events", failures)
+	}
+
+	var none *Dispatcher
+	none.Emit(EventActivityCreated, 1, nil)
+	if none.Wants(EventActivityCreated) || len(none.Failures()) != 0 {
+		t.Fatal("nil dispatcher is not inert")
+	}
+}
+
+func TestNewDispatcherValidatesEndpoints(t *testing.T) {
+	for _, endpoint := range []Endpoint{
+		{URL: "ftp://example.com/hook", Secret: "s"},
+		{URL: "/relative", Secret: "s"},
+		{URL: "https://example.com/hook"},
+		{URL: "https://example.com/hook", Secret: "s", Events: []string{"activity.deleted"}},
+	} {
+		if _, err := NewDispatcher([]Endpoint{endpoint}, Options{}); err == nil {
+			t.Fatalf("endpoint %+v accepted", endpoint)
+		}
+	}
+	if d, err := NewDispatcher(nil, Options{}); d != nil || err != nil {
+		t.Fatalf("no endpoints = %v, %v; want nil", d, err)
+	}
+}
+
+// memoryStore is a Store kept in memory
+type memoryStore struct {
+	mu     sync.Mutex
+	events map[string][]Event
+}
+
+func (m *memoryStore) Save(_ context.Context, endpointURL string, events []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.events == nil {
+		m.events = make(map[string][]Event)
+	}
+	m.events[endpointURL] = append(m.events[endpointURL], events...)
+	return nil
+}
+
+func (m *memoryStore) Take(_ context.Context, endpointURL string, limit int) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := m.events[endpointURL]
+	n := min(limit, len(events))
+	taken := append([]Event(nil), events[:n]...)
+	m.events[endpointURL] = events[n:]
+	return taken, nil
+}
+
+func (m *memoryStore) count(endpointURL string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.events[endpointURL])
+}
+
+func TestDispatcherSpillsOverflowToStore(t *testing.T) {
+	store := &memoryStore{}
+	endpoints := []Endpoint{{URL: "http://127.0.0.1:1/hook", Secret: "s3cret"}}
+	d, err := NewDispatcher(endpoints, Options{QueueSize: 100, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const emitted = 10000
+	for i := 0; i < emitted; i++ {
+		d.Emit(EventActivityCreated, 1, map[string]any{"id": i})
+	}
+	stats := d.QueueStats()[0]
+	if stats.Depth != 100 || stats.Spilled != emitted-100 || store.count(endpoints[0].URL) != emitted-100 {
+		t.Fatalf("stats = %+v, %d saved; want 100 queued and the rest saved", stats, store.count(endpoints[0].URL))
+	}
+	if len(d.Failures()) != 0 {
+		t.Fatalf("failures = %+v, want none", d.Failures())
+	}
+
+	// Not started, so shutdown saves the queued events too
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	d.Shutdown(ctx)
+	if saved := store.count(endpoints[0].URL); saved != emitted {
+		t.Fatalf("%d events saved after shutdown, want %d", saved, emitted)
+	}
+	d.Emit(EventActivityCreated, 1, map[string]any{"id": "late"})
+	if saved := store.count(endpoints[0].URL); saved != emitted+1 {
+		t.Fatalf("event emitted after shutdown was not saved (%d saved)", saved)
+	}
+}
+
+func TestDispatcherDeliversSavedEvents(t *testing.T) {
+	ts, _, received := receiver(t, "s3cret", http.StatusOK)
+	store := &memoryStore{}
+	for i := 0; i < 3; i++ {
+		_ = store.Save(context.Background(), ts.URL, []Event{{ID: strconv.Itoa(i), Type: EventActivityCreated, AthleteID: 1, Data: json.RawMessage("{}")}})
+	}
+	startDispatcher(t, []Endpoint{{URL: ts.URL, Secret: "s3cret"}}, Options{Store: store})
+
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-received:
+			if !got.valid || got.event.ID != strconv.Itoa(i) {
+				t.Fatalf("delivery %d = %+v", i, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("saved event %d was not delivered", i)
+		}
+	}
+	if store.count(ts.URL) != 0 {
+		t.Fatal("delivered events are still saved")
+	}
+}
+
+func TestDispatcherShutdownSavesRetries(t *testing.T) {
+	ts, calls, _ := receiver(t, "s3cret", http.StatusServiceUnavailable)
+	store := &memoryStore{}
+	d := startDispatcher(t, []Endpoint{{URL: ts.URL, Secret: "s3cret"}}, Options{Store: store, InitialBackoff: time.Hour})
+
+	d.Emit(EventActivityCreated, 1, map[string]any{"id": 1})
+	waitFor(t, "the first attempt", func() bool { return calls.Load() == 1 })
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	d.Shutdown(ctx)
+	if store.count(ts.URL) != 1 || len(d.Failures()) != 0 {
+		t.Fatalf("%d saved, failures %+v; want the retrying event saved", store.count(ts.URL), d.Failures())
+	}
+}
@@ -0,0 +1,263 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AccountDeletionRequest represents a scheduled deletion of everything stored for an athlete
+type AccountDeletionRequest struct {
+	AthleteID    int64      `json:"athlete_id"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	ExecuteAfter time.Time  `json:"execute_after"`
+	CancelledAt  *time.Time `json:"cancelled_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// Pending reports whether the request is neither cancelled nor completed
+func (r AccountDeletionRequest) Pending() bool {
+	return r.CancelledAt == nil && r.CompletedAt == nil
+}
+
+// Due reports whether the request is pending and its grace period has passed
+func (r AccountDeletionRequest) Due(now time.Time) bool {
+	return r.Pending() && !now.Before(r.ExecuteAfter)
+}
+
+// Events of the account audit trail
+const (
+	AccountEventExported          = "exported"
+	AccountEventDeletionScheduled = "deletion_scheduled"
+	AccountEventDeletionCancelled = "deletion_cancelled"
+	AccountEventDeletionCompleted = "deletion_completed"
+)
+
+// AccountEvent is one entry of an athlete's audit trail
+type AccountEvent struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordAccountEvent appends event to the athlete's audit trail
+func RecordAccountEvent(ctx context.Context, conn DB, athleteID int64, event string) error {
+	if _, err := conn.Exec(ctx, `INSERT INTO account_events (athlete_id, event) VALUES ($1, $2)`, athleteID, event); err != nil {
+		return fmt.Errorf("failed to record account event %s: %w", event, err)
+	}
+	return nil
+}
+
+// ListAccountEvents returns the athlete's audit trail, oldest first
+func ListAccountEvents(ctx context.Context, conn DB, athleteID int64) ([]AccountEvent, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT event, created_at FROM account_events WHERE athlete_id = $1 ORDER BY id
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list account events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []AccountEvent{}
+	for rows.Next() {
+		var event AccountEvent
+		if err := rows.Scan(&event.Event, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan account event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// ScheduleAccountDeletion schedules deletion of the athlete's data after gracePeriod.
+// Scheduling again replaces any earlier (cancelled or pending) request.
+func ScheduleAccountDeletion(ctx context.Context, conn DB, athleteID int64, gracePeriod time.Duration) (*AccountDeletionRequest, error) {
+	var req AccountDeletionRequest
+	err := conn.QueryRow(ctx, `
+		INSERT INTO account_deletion_requests (athlete_id, requested_at, execute_after)
+		VALUES ($1, NOW(), NOW() + $2::INTERVAL)
+		ON CONFLICT (athlete_id) DO UPDATE SET
+			requested_at = EXCLUDED.requested_at,
+			execute_after = EXCLUDED.execute_after,
+			cancelled_at = NULL,
+			completed_at = NULL
+		RETURNING athlete_id, requested_at, execute_after, cancelled_at, completed_at
+	`, athleteID, fmt.Sprintf("%d seconds", int64(gracePeriod.Seconds()))).Scan(
+		&req.AthleteID, &req.RequestedAt, &req.ExecuteAfter, &req.CancelledAt, &req.CompletedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule account deletion: %w", err)
+	}
+	return &req, nil
+}
+
+// CancelAccountDeletion cancels a pending deletion request.
+// It returns false when there was no pending request to cancel.
+func CancelAccountDeletion(ctx context.Context, conn DB, athleteID int64) (bool, error) {
+	tag, err := conn.Exec(ctx, `
+		UPDATE account_deletion_requests
+		SET cancelled_at = NOW()
+		WHERE athlete_id = $1 AND cancelled_at IS NULL AND completed_at IS NULL
+	`, athleteID)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel account deletion: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetAccountDeletionRequest returns the athlete's deletion request, or nil if none exists
+func GetAccountDeletionRequest(ctx context.Context, conn DB, athleteID int64) (*AccountDeletionRequest, error) {
+	var req AccountDeletionRequest
+	err := conn.QueryRow(ctx, `
+		SELECT athlete_id, requested_at, execute_after, cancelled_at, completed_at
+		FROM account_deletion_requests
+		WHERE athlete_id = $1
+	`, athleteID).Scan(&req.AthleteID, &req.RequestedAt, &req.ExecuteAfter, &req.CancelledAt, &req.CompletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account deletion request: %w", err)
+	}
+	return &req, nil
+}
+
+// ListDueAccountDeletions returns athletes whose pending deletion grace period has passed
+func ListDueAccountDeletions(ctx context.Context, conn DB, now time.Time) ([]int64, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT athlete_id
+		FROM account_deletion_requests
+		WHERE cancelled_at IS NULL AND completed_at IS NULL AND execute_after <= $1
+		ORDER BY execute_after
+	`, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due account deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var athleteIDs []int64
+	for rows.Next() {
+		var athleteID int64
+		if err := rows.Scan(&athleteID); err != nil {
+			return nil, fmt.Errorf("failed to scan account deletion: %w", err)
+		}
+		athleteIDs = append(athleteIDs, athleteID)
+	}
+	return athleteIDs, rows.Err()
+}
+
+// ExecuteAccountDeletion deletes all data stored for the athlete if their deletion request
+// is still due, and marks the request completed and records it in the audit trail in the
+// same transaction. The request row is
+// locked first, so a cancellation racing with the deletion either wins or waits.
+// It returns false when the request was cancelled, completed or not yet due.
+func ExecuteAccountDeletion(ctx context.Context, conn DB, athleteID int64, now time.Time) (bool, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin account deletion: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var req AccountDeletionRequest
+	err = tx.QueryRow(ctx, `
+		SELECT athlete_id, requested_at, execute_after, cancelled_at, completed_at
+		FROM account_deletion_requests
+		WHERE athlete_id = $1
+		FOR UPDATE
+	`, athleteID).Scan(&req.AthleteID, &req.RequestedAt, &req.ExecuteAfter, &req.CancelledAt, &req.CompletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("lock account deletion request: %w", err)
+	}
+	if !req.Due(now) {
+		return false, nil
+	}
+
+	if err := deleteAthleteData(ctx, tx, athleteID); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE account_deletion_requests SET completed_at = NOW() WHERE athlete_id = $1`, athleteID); err != nil {
+		return false, fmt.Errorf("mark account deletion completed: %w", err)
+	}
+	if err := RecordAccountEvent(ctx, tx, athleteID, AccountEventDeletionCompleted); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit account deletion: %w", err)
+	}
+	return true, nil
+}
+
+// deleteAthleteData removes every row stored for the athlete. Segment match caches go
+// with their segments and activities through ON DELETE CASCADE. The audit trail in
+// account_events is kept.
+func deleteAthleteData(ctx context.Context, tx pgx.Tx, athleteID int64) error {
+	queries := []struct {
+		table string
+		query string
+	}{
+		{"discovered_coverage_cache", `DELETE FROM discovered_coverage_cache WHERE athlete_id = $1`},
+		{"discovered_activity_buffers", `DELETE FROM discovered_activity_buffers WHERE athlete_id = $1`},
+		{"explored_area", `DELETE FROM explored_area WHERE athlete_id = $1`},
+		{"point_samples", `DELETE FROM point_samples WHERE athlete_id = $1`},
+		{"activity_streams", `DELETE FROM activity_streams WHERE athlete_id = $1`},
+		{"activity_geometries", `DELETE FROM activity_geometries WHERE athlete_id = $1`},
+		{"favorite_segments", `DELETE FROM favorite_segments WHERE athlete_id = $1`},
+		{"import_files", `DELETE FROM import_files WHERE athlete_id = $1`},
+		{"activity_tags", `DELETE FROM activity_tags WHERE athlete_id = $1`},
+		{"activity_summaries", `DELETE FROM activity_summaries WHERE athlete_id = $1`},
+		{"mobile_app_sessions", `DELETE FROM mobile_app_sessions WHERE athlete_id = $1`},
+		{"athlete_settings", `DELETE FROM athlete_settings WHERE athlete_id = $1`},
+		{"athlete_tokens", `DELETE FROM athlete_tokens WHERE athlete_id = $1`},
+		{"skipped_activities", `DELETE FROM skipped_activities WHERE athlete_id = $1`},
+		{"athlete_profiles", `DELETE FROM athlete_profiles WHERE athlete_id = $1`},
+		{"gear", `DELETE FROM gear WHERE athlete_id = $1`},
+		{"athletes", `DELETE FROM athletes WHERE id = $1`},
+		{"public_stats_tokens", `DELETE FROM public_stats_tokens WHERE athlete_id = $1`},
+		{"announcement_dismissals", `DELETE FROM announcement_dismissals WHERE token_key IN (SELECT token_key FROM web_sessions WHERE athlete_id = $1)`},
+		{"web_sessions", `DELETE FROM web_sessions WHERE athlete_id = $1`},
+		{"outbound_webhook_queue", `DELETE FROM outbound_webhook_queue WHERE athlete_id = $1`},
+	}
+	for _, q := range queries {
+		if _, err := tx.Exec(ctx, q.query, athleteID); err != nil {
+			return fmt.Errorf("delete %s: %w", q.table, err)
+		}
+	}
+	return nil
+}
+
+// DeleteActivity removes one of the athlete's activities. Its geometry, point samples,
+// discovered-map buffer and segment matches go with it through ON DELETE CASCADE, and
+// OnActivityChanged invalidates what else was derived from it. It returns false when the
+// athlete has no such activity.
+func DeleteActivity(ctx context.Context, conn DB, athleteID, activityID int64) (bool, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin activity deletion: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM activity_summaries WHERE id = $1 AND athlete_id = $2 FOR UPDATE
+	`, activityID, athleteID).Scan(&activityID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("lock activity %d: %w", activityID, err)
+	}
+	if err := OnActivityChanged(ctx, tx, athleteID, activityID, ActivityDeleted); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM activity_summaries WHERE id = $1`, activityID); err != nil {
+		return false, fmt.Errorf("delete activity %d: %w", activityID, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit activity deletion: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,38 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAccountEventsOutliveAccountDeletion(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000801)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM account_events WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM account_deletion_requests WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if err := RecordAccountEvent(ctx, conn, athleteID, AccountEventExported); err != nil {
+		t.Fatalf("RecordAccountEvent: %v", err)
+	}
+	if _, err := ScheduleAccountDeletion(ctx, conn, athleteID, 0); err != nil {
+		t.Fatalf("ScheduleAccountDeletion: %v", err)
+	}
+	if deleted, err := ExecuteAccountDeletion(ctx, conn, athleteID, time.Now().Add(time.Minute)); err != nil || !deleted {
+		t.Fatalf("ExecuteAccountDeletion = %v, %v; want deleted", deleted, err)
+	}
+
+	events, err := ListAccountEvents(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("ListAccountEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].Event != AccountEventExported || events[1].Event != AccountEventDeletionCompleted {
+		t.Fatalf("events = %#v, want export then completed deletion", events)
+	}
+}
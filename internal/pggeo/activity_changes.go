@@ -0,0 +1,246 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ActivityChange is what happened to an activity, see OnActivityChanged
+type ActivityChange string
+
+const (
+	// ActivityReplaced is a stored activity saved again with a different route or timing:
+	// a refetch from Strava, a webhook update or an updated file import
+	ActivityReplaced ActivityChange = "replaced"
+	// ActivityDeleted is an activity about to be deleted
+	ActivityDeleted ActivityChange = "deleted"
+	// ActivityHealed is an activity whose GPS points were moved
+	ActivityHealed ActivityChange = "healed"
+	// ActivityRegraded is an activity whose point grades were derived again
+	ActivityRegraded ActivityChange = "regraded"
+)
+
+// ActivityChanges lists every change, for tests that go through all of them
+var ActivityChanges = []ActivityChange{ActivityReplaced, ActivityDeleted, ActivityHealed, ActivityRegraded}
+
+// ActivityInvalidator keeps one kind of data derived from activities from going stale.
+// Cheap data is dropped right away; data that is costly to rebuild is marked stale and
+// rebuilt later by the work that already builds it, such as the next sync or page visit.
+type ActivityInvalidator struct {
+	// Name identifies the derived data in errors and tests
+	Name string
+	// Changes are the changes the derived data depends on
+	Changes []ActivityChange
+	// Invalidate drops or marks stale the activity's derived data. It runs before the
+	// change is written, so it still sees the activity as it was.
+	Invalidate func(ctx context.Context, conn DB, athleteID, activityID int64, change ActivityChange) error
+	// Invalidated reports whether nothing stale is left of the activity's derived data
+	// after the change; tests check it for every registered invalidator
+	Invalidated func(ctx context.Context, conn DB, athleteID, activityID int64, change ActivityChange) (bool, error)
+}
+
+var (
+	activityInvalidatorsMu sync.RWMutex
+	activityInvalidators   = []ActivityInvalidator{
+		segmentMatchesInvalidator,
+		exploredAreaInvalidator,
+		discoveredCoverageInvalidator,
+		similarActivitiesInvalidator,
+	}
+)
+
+// RegisterActivityInvalidator adds derived data kept outside these tables, such as an
+// in-memory cache, to what OnActivityChanged invalidates. An invalidator registered
+// again under the same name replaces the earlier one.
+func RegisterActivityInvalidator(invalidator ActivityInvalidator) {
+	activityInvalidatorsMu.Lock()
+	defer activityInvalidatorsMu.Unlock()
+	for i, registered := range activityInvalidators {
+		if registered.Name == invalidator.Name {
+			activityInvalidators[i] = invalidator
+			return
+		}
+	}
+	activityInvalidators = append(activityInvalidators, invalidator)
+}
+
+// ActivityInvalidators returns the registered invalidators
+func ActivityInvalidators() []ActivityInvalidator {
+	activityInvalidatorsMu.RLock()
+	defer activityInvalidatorsMu.RUnlock()
+	return slices.Clone(activityInvalidators)
+}
+
+// OnActivityChanged invalidates everything derived from the activity that depends on the
+// change. Every path that changes an activity's route, points or grades, or deletes it,
+// calls it before writing the change, in the same transaction when there is one.
+func OnActivityChanged(ctx context.Context, conn DB, athleteID, activityID int64, change ActivityChange) error {
+	for _, invalidator := range ActivityInvalidators() {
+		if !slices.Contains(invalidator.Changes, change) {
+			continue
+		}
+		if err := invalidator.Invalidate(ctx, conn, athleteID, activityID, change); err != nil {
+			return fmt.Errorf("failed to invalidate %s of activity %d: %w", invalidator.Name, activityID, err)
+		}
+	}
+	return nil
+}
+
+// segmentMatchesInvalidator drops the activity's cached segment efforts, which the next
+// segment page visit or cache refresh computes again. A new route may cross segments it
+// missed before, so the athlete's segment caches are also expired, except after a
+// deletion. New grades only clear the grade-adjusted speeds.
+var segmentMatchesInvalidator = ActivityInvalidator{
+	Name:    "segment matches",
+	Changes: ActivityChanges,
+	Invalidate: func(ctx context.Context, conn DB, athleteID, activityID int64, change ActivityChange) error {
+		if change == ActivityRegraded {
+			_, err := conn.Exec(ctx, `
+				UPDATE segment_activity_matches
+				SET grade_adjusted_speed = NULL, grade_adjusted = NULL
+				WHERE activity_id = $1
+			`, activityID)
+			return err
+		}
+		if err := InvalidateActivityCache(ctx, conn, activityID); err != nil {
+			return err
+		}
+		if change == ActivityDeleted {
+			return nil
+		}
+		_, err := conn.Exec(ctx, `
+			UPDATE segment_match_cache_state SET refreshed_at = to_timestamp(0)
+			WHERE segment_id IN (SELECT id FROM favorite_segments WHERE athlete_id = $1)
+		`, athleteID)
+		return err
+	},
+	Invalidated: func(ctx context.Context, conn DB, athleteID, activityID int64, change ActivityChange) (bool, error) {
+		var stale bool
+		var err error
+		switch change {
+		case ActivityRegraded:
+			err = conn.QueryRow(ctx, `
+				SELECT EXISTS (SELECT 1 FROM segment_activity_matches WHERE activity_id = $1 AND grade_adjusted_speed IS NOT NULL)
+			`, activityID).Scan(&stale)
+		case ActivityDeleted:
+			err = conn.QueryRow(ctx, `
+				SELECT EXISTS (SELECT 1 FROM segment_activity_matches WHERE activity_id = $1)
+			`, activityID).Scan(&stale)
+		default:
+			err = conn.QueryRow(ctx, `
+				SELECT EXISTS (SELECT 1 FROM segment_activity_matches WHERE activity_id = $2)
+					OR EXISTS (
+						SELECT 1 FROM segment_match_cache_state st
+						JOIN favorite_segments f ON f.id = st.segment_id
+						WHERE f.athlete_id = $1 AND st.refreshed_at > to_timestamp(0)
+					)
+			`, athleteID, activityID).Scan(&stale)
+		}
+		return !stale, err
+	},
+}
+
+// exploredAreaInvalidator resets the athlete's explored area and the new distances from
+// the activity's start on, which the next sync measures again
+var exploredAreaInvalidator = ActivityInvalidator{
+	Name:    "explored area",
+	Changes: []ActivityChange{ActivityReplaced, ActivityDeleted, ActivityHealed},
+	Invalidate: func(ctx context.Context, conn DB, athleteID, activityID int64, _ ActivityChange) error {
+		var startDate time.Time
+		err := conn.QueryRow(ctx, `
+			SELECT start_date FROM activity_summaries WHERE athlete_id = $1 AND id = $2
+		`, athleteID, activityID).Scan(&startDate)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load activity %d: %w", activityID, err)
+		}
+		return ResetExploredArea(ctx, conn, athleteID, startDate)
+	},
+	Invalidated: func(ctx context.Context, conn DB, athleteID, activityID int64, _ ActivityChange) (bool, error) {
+		var stale bool
+		err := conn.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM explored_area WHERE athlete_id = $1)
+				OR EXISTS (SELECT 1 FROM activity_summaries WHERE id = $2 AND new_distance_m IS NOT NULL)
+		`, athleteID, activityID).Scan(&stale)
+		return !stale, err
+	},
+}
+
+// discoveredCoverageInvalidator marks the athlete's discovered map stale, so it is
+// rebuilt on the next rebuild request
+var discoveredCoverageInvalidator = ActivityInvalidator{
+	Name:    "discovered coverage",
+	Changes: []ActivityChange{ActivityReplaced, ActivityDeleted, ActivityHealed},
+	Invalidate: func(ctx context.Context, conn DB, athleteID, _ int64, _ ActivityChange) error {
+		return MarkDiscoveredCoverageStale(ctx, conn, athleteID)
+	},
+	Invalidated: func(ctx context.Context, conn DB, athleteID, _ int64, _ ActivityChange) (bool, error) {
+		var stale bool
+		err := conn.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM discovered_coverage_cache WHERE athlete_id = $1 AND NOT stale)
+		`, athleteID).Scan(&stale)
+		return !stale, err
+	},
+}
+
+// similarActivitiesInvalidator drops the athlete's cached similar activities, since a
+// changed route may join or leave any of them. Deleted activities drop out of the lists
+// when they are read.
+var similarActivitiesInvalidator = ActivityInvalidator{
+	Name:    "similar activities",
+	Changes: []ActivityChange{ActivityReplaced, ActivityHealed},
+	Invalidate: func(ctx context.Context, conn DB, athleteID, _ int64, _ ActivityChange) error {
+		_, err := conn.Exec(ctx, `DELETE FROM similar_activities_cache WHERE athlete_id = $1`, athleteID)
+		return err
+	},
+	Invalidated: func(ctx context.Context, conn DB, athleteID, _ int64, _ ActivityChange) (bool, error) {
+		var stale bool
+		err := conn.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM similar_activities_cache WHERE athlete_id = $1)
+		`, athleteID).Scan(&stale)
+		return !stale, err
+	},
+}
+
+// activityDataChanged reports whether saving activity with route replaces a stored
+// activity's route or timing, which derived data is computed from. A renamed activity
+// keeps its derived data, and a new one has none yet.
+func activityDataChanged(ctx context.Context, conn DB, activity *strava.BikeActivity, route [][]float64) (bool, error) {
+	lons := make([]float64, len(route))
+	lats := make([]float64, len(route))
+	for i, point := range route {
+		lats[i], lons[i] = point[0], point[1]
+	}
+	var changed bool
+	err := conn.QueryRow(ctx, `
+		SELECT s.start_date IS DISTINCT FROM $3
+			OR s.distance IS DISTINCT FROM $4
+			OR s.moving_time IS DISTINCT FROM $5
+			OR s.elapsed_time IS DISTINCT FROM $6
+			OR (cardinality($7::double precision[]) >= 2 AND (
+				g.route_geog IS NULL
+				OR NOT ST_OrderingEquals(g.route_geog::geometry, make_route_geog_from_lonlat($7, $8)::geometry)
+			))
+		FROM activity_summaries s
+		LEFT JOIN activity_geometries g ON g.activity_id = s.id
+		WHERE s.id = $1 AND s.athlete_id = $2
+	`, activity.Summary.ID, activity.Summary.AthleteID, activity.Summary.StartDateTime, activity.Summary.Distance,
+		activity.Summary.MovingTime, activity.Summary.ElapsedTime, lons, lats).Scan(&changed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to compare activity %d with the stored one: %w", activity.Summary.ID, err)
+	}
+	return changed, nil
+}
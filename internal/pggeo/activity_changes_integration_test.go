@@ -0,0 +1,160 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+const (
+	changesFixtureAthleteID  = int64(990000502)
+	changesFixtureActivityID = int64(990000502001)
+	changesFixtureSegmentID  = int64(-502)
+)
+
+// changesFixtureActivity rides north from the self-check origin, shifted east by
+// shiftDeg, with a sample every 10 seconds and 100 m
+func changesFixtureActivity(shiftDeg float64) *strava.BikeActivity {
+	start := time.Date(2024, 7, 1, 7, 0, 0, 0, time.UTC)
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID:            changesFixtureActivityID,
+		AthleteID:     changesFixtureAthleteID,
+		Name:          "Derived data fixture",
+		Type:          "Ride",
+		SportType:     "Ride",
+		StartDate:     start.Format(time.RFC3339),
+		StartDateTime: start,
+		Distance:      400,
+		MovingTime:    40,
+		ElapsedTime:   40,
+	}}
+	for i := 0; i < 5; i++ {
+		activity.LatLngStream.Data = append(activity.LatLngStream.Data, []float64{selfCheckOriginLat + float64(i)*0.0009, selfCheckOriginLon + shiftDeg})
+		activity.TimeStream.Data = append(activity.TimeStream.Data, start.Add(time.Duration(i)*10*time.Second))
+	}
+	return activity
+}
+
+// setupChangesFixture stores the fixture activity with derived data of every kind: a
+// cached segment effort with a grade-adjusted speed in a fresh segment cache, a measured
+// new distance in the explored area, a built discovered map and cached similar activities
+func setupChangesFixture(t *testing.T, ctx context.Context, conn DB) {
+	t.Helper()
+	cleanupChangesFixture(conn)
+	if err := InsertBikeActivity(ctx, conn, changesFixtureActivity(0)); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	fixture := []string{
+		`INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+			SELECT $1, $2, 'derived data fixture', route_geog FROM activity_geometries WHERE activity_id = $3`,
+		`INSERT INTO segment_activity_matches (segment_id, activity_id, tolerance_meters, min_distance_m, overlap_length_m, overlap_percentage, grade_adjusted_speed, grade_adjusted)
+			VALUES ($1, $3, 10, 0, 400, 100, 8.5, TRUE)`,
+		`INSERT INTO segment_match_cache_state (segment_id, tolerance_meters) VALUES ($1, 10)`,
+		`UPDATE activity_summaries SET new_distance_m = 400 WHERE id = $3 AND athlete_id = $2`,
+		`INSERT INTO explored_area (athlete_id, buffer_m, through_start_date, through_activity_id)
+			SELECT $2, 25, start_date, id FROM activity_summaries WHERE id = $3`,
+		`INSERT INTO discovered_coverage_cache (athlete_id, sample_distance_m, radius_m, stale, rebuilt_at)
+			VALUES ($2, 50, 25, FALSE, NOW())`,
+		`INSERT INTO similar_activities_cache (activity_id, athlete_id, tolerance_meters, similar_activity_ids, coverages)
+			VALUES ($3, $2, 30, '{}', '{}')`,
+	}
+	for _, query := range fixture {
+		if _, err := conn.Exec(ctx, query, changesFixtureSegmentID, changesFixtureAthleteID, changesFixtureActivityID); err != nil {
+			t.Fatalf("derived data fixture: %v", err)
+		}
+	}
+}
+
+func cleanupChangesFixture(conn DB) {
+	ctx := context.Background()
+	_, _ = conn.Exec(ctx, `DELETE FROM favorite_segments WHERE id = $1`, changesFixtureSegmentID)
+	_, _ = conn.Exec(ctx, `DELETE FROM activity_summaries WHERE id = $1`, changesFixtureActivityID)
+	_, _ = conn.Exec(ctx, `DELETE FROM explored_area WHERE athlete_id = $1`, changesFixtureAthleteID)
+	_, _ = conn.Exec(ctx, `DELETE FROM discovered_coverage_cache WHERE athlete_id = $1`, changesFixtureAthleteID)
+}
+
+// checkInvalidated reports every invalidator depending on change whose derived data is
+// left as wantInvalidated is not
+func checkInvalidated(t *testing.T, ctx context.Context, conn DB, change ActivityChange, wantInvalidated bool) {
+	t.Helper()
+	for _, invalidator := range ActivityInvalidators() {
+		depends := false
+		for _, c := range invalidator.Changes {
+			depends = depends || c == change
+		}
+		if !depends {
+			continue
+		}
+		invalidated, err := invalidator.Invalidated(ctx, conn, changesFixtureAthleteID, changesFixtureActivityID, change)
+		if err != nil {
+			t.Fatalf("%s: %v", invalidator.Name, err)
+		}
+		if invalidated != wantInvalidated {
+			t.Errorf("%s after %s: invalidated = %v, want %v", invalidator.Name, change, invalidated, wantInvalidated)
+		}
+	}
+}
+
+// TestEveryActivityChangeInvalidatesDerivedData changes the fixture activity through each
+// path that changes activities and checks that every registered derived data is dropped
+// or marked for rebuilding. An invalidator whose data the fixture does not hold fails
+// before the change, so new derived data must extend the fixture.
+func TestEveryActivityChangeInvalidatesDerivedData(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	t.Cleanup(func() { cleanupChangesFixture(conn) })
+
+	paths := map[ActivityChange]func() error{
+		ActivityReplaced: func() error {
+			return InsertBikeActivityUpsert(ctx, conn, changesFixtureActivity(0.0005))
+		},
+		ActivityDeleted: func() error {
+			deleted, err := DeleteActivity(ctx, conn, changesFixtureAthleteID, changesFixtureActivityID)
+			if err == nil && !deleted {
+				t.Fatal("fixture activity not deleted")
+			}
+			return err
+		},
+		ActivityHealed: func() error {
+			samples, err := GetPointSamplesForActivity(ctx, conn, changesFixtureAthleteID, changesFixtureActivityID)
+			if err != nil {
+				return err
+			}
+			samples[2].Lng += 0.0001
+			return HealActivityPoints(ctx, conn, changesFixtureAthleteID, changesFixtureActivityID, samples[2:3], 1, 5)
+		},
+		ActivityRegraded: func() error {
+			return ReplaceActivityGrades(ctx, conn, changesFixtureAthleteID, changesFixtureActivityID, []int{0, 1, 2, 3, 4}, []float64{1, 2, 3, 2, 1})
+		},
+	}
+	for _, change := range ActivityChanges {
+		path, ok := paths[change]
+		if !ok {
+			t.Fatalf("no path changes an activity as %s", change)
+		}
+		t.Run(string(change), func(t *testing.T) {
+			setupChangesFixture(t, ctx, conn)
+			checkInvalidated(t, ctx, conn, change, false)
+			if err := path(); err != nil {
+				t.Fatalf("%s: %v", change, err)
+			}
+			checkInvalidated(t, ctx, conn, change, true)
+		})
+	}
+}
+
+func TestSavingAnUnchangedActivityKeepsDerivedData(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	t.Cleanup(func() { cleanupChangesFixture(conn) })
+	setupChangesFixture(t, ctx, conn)
+
+	renamed := changesFixtureActivity(0)
+	renamed.Summary.Name = "Renamed on Strava"
+	if err := InsertBikeActivityUpsert(ctx, conn, renamed); err != nil {
+		t.Fatalf("InsertBikeActivityUpsert: %v", err)
+	}
+	checkInvalidated(t, ctx, conn, ActivityReplaced, false)
+}
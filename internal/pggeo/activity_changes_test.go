@@ -0,0 +1,63 @@
+package pggeo
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestActivityInvalidatorsAreComplete(t *testing.T) {
+	names := make(map[string]bool)
+	for _, invalidator := range ActivityInvalidators() {
+		if invalidator.Name == "" || names[invalidator.Name] {
+			t.Fatalf("invalidator name %q is empty or taken", invalidator.Name)
+		}
+		names[invalidator.Name] = true
+		if len(invalidator.Changes) == 0 || invalidator.Invalidate == nil || invalidator.Invalidated == nil {
+			t.Fatalf("%s: needs changes, Invalidate and Invalidated", invalidator.Name)
+		}
+		for _, change := range invalidator.Changes {
+			if !slices.Contains(ActivityChanges, change) {
+				t.Fatalf("%s: unknown change %q", invalidator.Name, change)
+			}
+		}
+	}
+}
+
+func TestOnActivityChangedRunsTheInvalidatorsOfTheChange(t *testing.T) {
+	saved := activityInvalidators
+	activityInvalidators = nil
+	t.Cleanup(func() { activityInvalidators = saved })
+
+	var ran []string
+	record := func(name string, changes ...ActivityChange) ActivityInvalidator {
+		return ActivityInvalidator{
+			Name:    name,
+			Changes: changes,
+			Invalidate: func(_ context.Context, _ DB, athleteID, activityID int64, change ActivityChange) error {
+				if athleteID != 1 || activityID != 2 {
+					t.Fatalf("%s invalidated athlete %d activity %d, want 1 and 2", name, athleteID, activityID)
+				}
+				ran = append(ran, name+" "+string(change))
+				return nil
+			},
+			Invalidated: func(context.Context, DB, int64, int64, ActivityChange) (bool, error) { return true, nil },
+		}
+	}
+	RegisterActivityInvalidator(record("routes", ActivityReplaced, ActivityHealed))
+	RegisterActivityInvalidator(record("grades", ActivityRegraded))
+	RegisterActivityInvalidator(record("everything", ActivityChanges...))
+	// Registering under a taken name replaces the earlier invalidator
+	RegisterActivityInvalidator(record("grades", ActivityRegraded, ActivityDeleted))
+
+	if err := OnActivityChanged(context.Background(), nil, 1, 2, ActivityDeleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := OnActivityChanged(context.Background(), nil, 1, 2, ActivityHealed); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"grades deleted", "everything deleted", "routes healed", "everything healed"}
+	if !slices.Equal(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+}
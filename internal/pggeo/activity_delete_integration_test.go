@@ -0,0 +1,64 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestDeleteActivityRemovesOnlyTheOwnersActivity(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000301), int64(990000301001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	start := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID:        activityID,
+		AthleteID: athleteID,
+		Name:      "Duplicate GPS glitch",
+		Type:      "Ride",
+		SportType: "Ride",
+		StartDate: start.Format(time.RFC3339),
+		Distance:  1200,
+	}}
+	activity.LatLngStream.Data = [][]float64{{52.5200, 13.4050}, {52.5210, 13.4060}, {52.5220, 13.4070}}
+	for i := range activity.LatLngStream.Data {
+		activity.TimeStream.Data = append(activity.TimeStream.Data, start.Add(time.Duration(i)*10*time.Second))
+	}
+	if err := InsertBikeActivity(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+
+	if deleted, err := DeleteActivity(ctx, conn, athleteID+1, activityID); err != nil || deleted {
+		t.Fatalf("another athlete's delete = %v, %v; want nothing deleted", deleted, err)
+	}
+	if _, err := GetActivityByID(ctx, conn, athleteID, activityID); err != nil {
+		t.Fatalf("activity gone after another athlete's delete: %v", err)
+	}
+	if deleted, err := DeleteActivity(ctx, conn, athleteID, activityID); err != nil || !deleted {
+		t.Fatalf("owner's delete = %v, %v", deleted, err)
+	}
+	if _, err := GetActivityByID(ctx, conn, athleteID, activityID); err == nil {
+		t.Fatal("activity still stored after delete")
+	}
+	for _, table := range []string{"activity_geometries", "point_samples"} {
+		var left int
+		if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM `+table+` WHERE activity_id = $1`, activityID).Scan(&left); err != nil {
+			t.Fatal(err)
+		}
+		if left != 0 {
+			t.Fatalf("%d %s rows left after delete", left, table)
+		}
+	}
+	if deleted, err := DeleteActivity(ctx, conn, athleteID, activityID); err != nil || deleted {
+		t.Fatalf("second delete = %v, %v; want nothing deleted", deleted, err)
+	}
+}
@@ -0,0 +1,156 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+// Activity list orders for ActivityFilter.Sort; every order is largest or newest first
+const (
+	ActivitySortDate      = "date"
+	ActivitySortDistance  = "distance"
+	ActivitySortElevation = "elevation"
+	ActivitySortDuration  = "duration"  // moving time
+	ActivitySortNewRoads  = "new_roads" // new distance, see ComputeNewDistance
+)
+
+// activitySortColumns maps each order to its column. Only these fixed strings are ever
+// placed in ORDER BY; everything the caller supplies is a bind parameter.
+var activitySortColumns = map[string]string{
+	ActivitySortDate:      "start_date",
+	ActivitySortDistance:  "distance",
+	ActivitySortElevation: "total_elevation_gain",
+	ActivitySortDuration:  "moving_time",
+	ActivitySortNewRoads:  "COALESCE(new_distance_m, 0)",
+}
+
+// ValidActivitySort reports whether sortBy is one of the ActivitySort orders
+func ValidActivitySort(sortBy string) bool {
+	_, ok := activitySortColumns[sortBy]
+	return ok
+}
+
+// ActivityFilter selects and orders an athlete's activities. Zero values leave a
+// criterion out, so the zero filter lists every activity newest first.
+type ActivityFilter struct {
+	Query       string    // case-insensitive substring of the name
+	Type        string    // Strava type or sport type, matched like strava.MatchesActivityType
+	Start       time.Time // activities starting at or after Start
+	End         time.Time // activities starting before End
+	MinDistance float64   // meters
+	MaxDistance float64   // meters
+	Tag         string    // activities carrying this tag
+	ExcludeTag  string    // activities without this tag
+	Sort        string    // an ActivitySort order; ActivitySortDate when empty
+	PinnedFirst bool      // pinned activities before the rest, each group in Sort order
+	// InstanceOnly keeps the activities other viewers may see, see ActivityVisibleTo
+	InstanceOnly bool
+	Limit        int // at most Limit activities; no limit when 0
+	Offset       int
+}
+
+// escapeLikePattern makes s match literally inside a LIKE pattern, whose default
+// escape character is the backslash
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// where builds the WHERE clause and its arguments, starting with athleteID as $1
+func (f ActivityFilter) where(athleteID int64) (string, []interface{}) {
+	conditions := []string{"athlete_id = $1"}
+	args := []interface{}{athleteID}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if query := strings.TrimSpace(f.Query); query != "" {
+		add("name ILIKE ?", "%"+escapeLikePattern(query)+"%")
+	}
+	if activityType := strings.TrimSpace(f.Type); activityType != "" {
+		add("(lower(type) = lower(?) OR lower(sport_type) = lower(?))", activityType)
+	}
+	if !f.Start.IsZero() {
+		add("start_date >= ?", f.Start)
+	}
+	if !f.End.IsZero() {
+		add("start_date < ?", f.End)
+	}
+	if f.MinDistance > 0 {
+		add("distance >= ?", f.MinDistance)
+	}
+	if f.MaxDistance > 0 {
+		add("distance <= ?", f.MaxDistance)
+	}
+	if f.Tag != "" {
+		add("id IN (SELECT activity_id FROM activity_tags WHERE athlete_id = $1 AND tag = ?)", f.Tag)
+	}
+	if f.ExcludeTag != "" {
+		add("id NOT IN (SELECT activity_id FROM activity_tags WHERE athlete_id = $1 AND tag = ?)", f.ExcludeTag)
+	}
+	if f.InstanceOnly {
+		add("visibility = ?", ActivityVisibilityInstance)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// selectQuery builds the activity query for the filter
+func (f ActivityFilter) selectQuery(athleteID int64) (string, []interface{}) {
+	where, args := f.where(athleteID)
+	column, ok := activitySortColumns[f.Sort]
+	if !ok {
+		column = activitySortColumns[ActivitySortDate]
+	}
+	orderBy := column + " DESC, id DESC"
+	if column != "start_date" {
+		orderBy = column + " DESC, start_date DESC, id DESC"
+	}
+	if f.PinnedFirst {
+		orderBy = "pinned DESC, " + orderBy
+	}
+
+	query := `SELECT ` + activitySummaryColumns + `
+	FROM activity_summaries
+	WHERE ` + where + `
+	ORDER BY ` + orderBy
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if f.Offset > 0 {
+		args = append(args, f.Offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	return query, args
+}
+
+// countQuery builds the query counting every activity the filter matches, ignoring
+// Limit and Offset
+func (f ActivityFilter) countQuery(athleteID int64) (string, []interface{}) {
+	where, args := f.where(athleteID)
+	return `SELECT COUNT(*) FROM activity_summaries WHERE ` + where, args
+}
+
+// QueryActivities returns the athlete's activities matching filter in its order
+func QueryActivities(ctx context.Context, conn DB, athleteID int64, filter ActivityFilter) ([]strava.ActivitySummary, error) {
+	query, args := filter.selectQuery(athleteID)
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activities: %w", err)
+	}
+	return scanActivitySummaries(rows)
+}
+
+// CountActivities returns how many of the athlete's activities match filter
+func CountActivities(ctx context.Context, conn DB, athleteID int64, filter ActivityFilter) (int, error) {
+	query, args := filter.countQuery(athleteID)
+	var count int
+	if err := conn.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count activities: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,111 @@
+package pggeo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivityFilterZeroValueListsEverythingNewestFirst(t *testing.T) {
+	query, args := ActivityFilter{}.selectQuery(7)
+	if !strings.Contains(query, "WHERE athlete_id = $1\n") || !strings.HasSuffix(query, "ORDER BY start_date DESC, id DESC") {
+		t.Fatalf("query = %q", query)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(7)}) {
+		t.Fatalf("args = %v, want [7]", args)
+	}
+}
+
+func TestActivityFilterBuildsParameterizedConditions(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	filter := ActivityFilter{
+		Query:       " commute ",
+		Type:        "Ride",
+		Start:       start,
+		End:         end,
+		MinDistance: 20000,
+		MaxDistance: 80000,
+		Sort:        ActivitySortDistance,
+		PinnedFirst: true,
+		Limit:       20,
+		Offset:      40,
+	}
+	query, args := filter.selectQuery(7)
+	for _, want := range []string{
+		"athlete_id = $1 AND name ILIKE $2 AND (lower(type) = lower($3) OR lower(sport_type) = lower($3))" +
+			" AND start_date >= $4 AND start_date < $5 AND distance >= $6 AND distance <= $7",
+		"ORDER BY pinned DESC, distance DESC, start_date DESC, id DESC LIMIT $8 OFFSET $9",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("query = %q, want it to contain %q", query, want)
+		}
+	}
+	wantArgs := []interface{}{int64(7), "%commute%", "Ride", start, end, 20000.0, 80000.0, 20, 40}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Fatalf("args = %#v, want %#v", args, wantArgs)
+	}
+
+	count, countArgs := filter.countQuery(7)
+	if strings.Contains(count, "LIMIT") || strings.Contains(count, "ORDER BY") || !reflect.DeepEqual(countArgs, wantArgs[:7]) {
+		t.Fatalf("count query = %q with %v", count, countArgs)
+	}
+}
+
+func TestActivityFilterInstanceOnlyHidesPrivateActivities(t *testing.T) {
+	query, args := ActivityFilter{Type: "Ride", InstanceOnly: true}.countQuery(7)
+	if !strings.Contains(query, "AND visibility = $3") || !reflect.DeepEqual(args, []interface{}{int64(7), "Ride", ActivityVisibilityInstance}) {
+		t.Fatalf("count query = %q with %v", query, args)
+	}
+}
+
+func TestActivityFilterTags(t *testing.T) {
+	query, args := ActivityFilter{Tag: "commute", ExcludeTag: "race"}.countQuery(7)
+	for _, want := range []string{
+		"AND id IN (SELECT activity_id FROM activity_tags WHERE athlete_id = $1 AND tag = $2)",
+		"AND id NOT IN (SELECT activity_id FROM activity_tags WHERE athlete_id = $1 AND tag = $3)",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("count query = %q, want it to contain %q", query, want)
+		}
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(7), "commute", "race"}) {
+		t.Fatalf("args = %v, want the tags as bind parameters", args)
+	}
+}
+
+func TestActivityFilterSortOrders(t *testing.T) {
+	for sortBy, want := range map[string]string{
+		"":                    "ORDER BY start_date DESC, id DESC",
+		ActivitySortDate:      "ORDER BY start_date DESC, id DESC",
+		ActivitySortElevation: "ORDER BY total_elevation_gain DESC, start_date DESC, id DESC",
+		ActivitySortDuration:  "ORDER BY moving_time DESC, start_date DESC, id DESC",
+		ActivitySortNewRoads:  "ORDER BY COALESCE(new_distance_m, 0) DESC, start_date DESC, id DESC",
+		"name; DROP TABLE x":  "ORDER BY start_date DESC, id DESC",
+	} {
+		if query, _ := (ActivityFilter{Sort: sortBy}).selectQuery(7); !strings.HasSuffix(query, want) {
+			t.Errorf("sort %q: query = %q, want suffix %q", sortBy, query, want)
+		}
+	}
+	if ValidActivitySort("name") || !ValidActivitySort(ActivitySortDuration) {
+		t.Fatal("ValidActivitySort accepts the wrong orders")
+	}
+}
+
+func TestActivityFilterKeepsInputOutOfTheSQL(t *testing.T) {
+	hostile := `x' OR '1'='1'; DROP TABLE activity_summaries; --`
+	query, args := ActivityFilter{Query: hostile, Type: hostile}.selectQuery(7)
+	if strings.Contains(query, "DROP") || strings.Contains(query, "'1'") {
+		t.Fatalf("input reached the SQL: %q", query)
+	}
+	if args[1] != "%"+escapeLikePattern(hostile)+"%" || args[2] != hostile {
+		t.Fatalf("args = %v, want the input as bind parameters", args)
+	}
+
+	// LIKE wildcards in the search are literal
+	_, args = ActivityFilter{Query: `100%_done\`}.selectQuery(7)
+	if args[1] != `%100\%\_done\\%` {
+		t.Fatalf("pattern = %q, want escaped wildcards", args[1])
+	}
+}
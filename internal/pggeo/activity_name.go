@@ -0,0 +1,48 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the name and description of one activity, in characters
+const (
+	MaxActivityNameLength        = 255
+	MaxActivityDescriptionLength = 5000
+)
+
+// UpdateActivitySummaryName renames an activity owned by athleteID and, when description
+// is not nil, replaces its description; an empty description is stored as NULL. A nil
+// name keeps the current one. A renamed activity keeps its name when it is synced again.
+func UpdateActivitySummaryName(ctx context.Context, conn DB, athleteID, activityID int64, name, description *string) error {
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		if trimmed == "" {
+			return invalidInputf("name must not be empty")
+		}
+		if utf8.RuneCountInString(trimmed) > MaxActivityNameLength {
+			return invalidInputf("name is longer than %d characters", MaxActivityNameLength)
+		}
+		name = &trimmed
+	}
+	if description != nil && utf8.RuneCountInString(*description) > MaxActivityDescriptionLength {
+		return invalidInputf("description is longer than %d characters", MaxActivityDescriptionLength)
+	}
+	tag, err := conn.Exec(ctx, `
+		UPDATE activity_summaries
+		SET name = COALESCE($1, name),
+			name_edited_at = CASE WHEN $1::TEXT IS NULL THEN name_edited_at ELSE NOW() END,
+			description = CASE WHEN $2::TEXT IS NULL THEN description ELSE NULLIF($2, '') END,
+			updated_at = NOW()
+		WHERE athlete_id = $3 AND id = $4
+	`, name, description, athleteID, activityID)
+	if err != nil {
+		return fmt.Errorf("failed to update activity name: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestActivityRenameSurvivesResyncUpsert(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000407), int64(990000407001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	activity := &strava.ActivitySummary{
+		ID:        activityID,
+		AthleteID: athleteID,
+		Name:      "Morning Ride",
+		Type:      "Ride",
+		SportType: "Ride",
+		StartDate: "2024-07-12T07:00:00Z",
+		Distance:  80000,
+	}
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+	}
+	name, description := "  Col du Tourmalet attempt ", "Windy at the top"
+	if err := UpdateActivitySummaryName(ctx, conn, athleteID, activityID, &name, &description); err != nil {
+		t.Fatalf("UpdateActivitySummaryName: %v", err)
+	}
+	if err := UpdateActivitySummaryName(ctx, conn, athleteID+1, activityID, &name, nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("rename by another athlete = %v, want not found", err)
+	}
+	blank, long := " ", strings.Repeat("x", MaxActivityNameLength+1)
+	for _, bad := range []*string{&blank, &long} {
+		if err := UpdateActivitySummaryName(ctx, conn, athleteID, activityID, bad, nil); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("rename to %q = %v, want invalid input", *bad, err)
+		}
+	}
+
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("re-sync upsert: %v", err)
+	}
+	stored, err := GetActivityByID(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetActivityByID: %v", err)
+	}
+	if stored.Name != "Col du Tourmalet attempt" || stored.Description != description {
+		t.Fatalf("after re-sync name = %q, description = %q", stored.Name, stored.Description)
+	}
+
+	// A description-only edit keeps the name; an empty one clears the description
+	empty := ""
+	if err := UpdateActivitySummaryName(ctx, conn, athleteID, activityID, nil, &empty); err != nil {
+		t.Fatalf("clear description: %v", err)
+	}
+	var storedName string
+	var descriptionIsNull bool
+	if err := conn.QueryRow(ctx, `SELECT name, description IS NULL FROM activity_summaries WHERE id = $1`, activityID).Scan(&storedName, &descriptionIsNull); err != nil {
+		t.Fatalf("read activity: %v", err)
+	}
+	if storedName != "Col du Tourmalet attempt" || !descriptionIsNull {
+		t.Fatalf("name = %q, description cleared %v", storedName, descriptionIsNull)
+	}
+}
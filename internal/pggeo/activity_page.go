@@ -0,0 +1,89 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const activitySummaryColumns = `id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain,
+		   type, sport_type, workout_type, start_date, utc_offset,
+		   start_lat, start_lng, end_lat, end_lng,
+		   location_city, location_state, location_country, gear_id, gear_name,
+		   average_speed, max_speed, average_cadence, average_watts,
+		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score, visibility, pinned, new_distance_m`
+
+// GetPinnedActivities returns the athlete's pinned activities, newest first
+func GetPinnedActivities(ctx context.Context, conn DB, athleteID int64) ([]strava.ActivitySummary, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT `+activitySummaryColumns+`
+	FROM activity_summaries
+	WHERE athlete_id = $1 AND pinned
+	ORDER BY start_date DESC, id DESC
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pinned activities: %w", err)
+	}
+	return scanActivitySummaries(rows)
+}
+
+// ListActivityTypes returns the distinct sport types (or types, where the sport type is
+// empty) of the athlete's activities, sorted
+func ListActivityTypes(ctx context.Context, conn DB, athleteID int64) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT DISTINCT COALESCE(NULLIF(sport_type, ''), type) AS activity_type
+		FROM activity_summaries
+		WHERE athlete_id = $1 AND COALESCE(NULLIF(sport_type, ''), type) <> ''
+		ORDER BY activity_type
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activity types: %w", err)
+	}
+	types, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan activity types: %w", err)
+	}
+	return types, nil
+}
+
+// scanActivitySummaries reads rows selecting activitySummaryColumns and closes them
+func scanActivitySummaries(rows pgx.Rows) ([]strava.ActivitySummary, error) {
+	defer rows.Close()
+
+	activities := []strava.ActivitySummary{}
+	for rows.Next() {
+		var activity strava.ActivitySummary
+		var startLat, startLng, endLat, endLng *float64
+		var locationCity, locationState *string
+		var workoutType *int
+
+		err := rows.Scan(
+			&activity.ID, &activity.AthleteID, &activity.Name, &activity.Distance, &activity.MovingTime, &activity.ElapsedTime,
+			&activity.TotalElevationGain, &activity.Type, &activity.SportType, &workoutType,
+			&activity.StartDateTime, &activity.UtcOffset, &startLat, &startLng, &endLat, &endLng,
+			&locationCity, &locationState, &activity.LocationCountry, &activity.GearID, &activity.GearName,
+			&activity.AverageSpeed, &activity.MaxSpeed, &activity.AverageCadence, &activity.AverageWatts,
+			&activity.Kilojoules, &activity.AverageHeartrate, &activity.MaxHeartrate, &activity.MaxWatts,
+			&activity.SufferScore, &activity.InstanceVisibility, &activity.Pinned, &activity.NewDistance,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan activity: %w", err)
+		}
+
+		activity.WorkoutType = workoutType
+		if startLat != nil && startLng != nil {
+			activity.StartLatLng = &[]float64{*startLat, *startLng}
+		}
+		if endLat != nil && endLng != nil {
+			activity.EndLatLng = &[]float64{*endLat, *endLng}
+		}
+		activity.LocationCity = locationCity
+		activity.LocationState = locationState
+
+		activities = append(activities, activity)
+	}
+	return activities, rows.Err()
+}
@@ -0,0 +1,154 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestActivitiesPageFiltersSortsAndCounts(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000401)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// IDs 1-5 oldest to newest: two rides, a gravel ride, a virtual ride and a run
+	types := [][2]string{{"Ride", "Ride"}, {"Ride", "Ride"}, {"Ride", "GravelRide"}, {"VirtualRide", "VirtualRide"}, {"Run", ""}}
+	start := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	for i, typ := range types {
+		activity := &strava.ActivitySummary{
+			ID:        athleteID*1000 + int64(i+1),
+			AthleteID: athleteID,
+			Name:      fmt.Sprintf("Activity %d", i+1),
+			Type:      typ[0],
+			SportType: typ[1],
+			StartDate: start.AddDate(0, 0, i).Format(time.RFC3339),
+			Distance:  10000,
+		}
+		if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+			t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+		}
+	}
+	if err := SetActivityPinned(ctx, conn, athleteID, athleteID*1000+1, true); err != nil {
+		t.Fatalf("SetActivityPinned: %v", err)
+	}
+
+	page := func(activityType string, limit, offset int, pinnedFirst bool) string {
+		t.Helper()
+		activities, err := QueryActivities(ctx, conn, athleteID, ActivityFilter{Type: activityType, Limit: limit, Offset: offset, PinnedFirst: pinnedFirst})
+		if err != nil {
+			t.Fatalf("QueryActivities: %v", err)
+		}
+		ids := make([]string, len(activities))
+		for i, activity := range activities {
+			ids[i] = fmt.Sprint(activity.ID - athleteID*1000)
+		}
+		return strings.Join(ids, ",")
+	}
+	if got := page("", 2, 0, false); got != "5,4" {
+		t.Fatalf("first page = %s, want 5,4", got)
+	}
+	if got := page("", 2, 4, false); got != "1" {
+		t.Fatalf("last page = %s, want 1", got)
+	}
+	if got := page("", 3, 0, true); got != "1,5,4" {
+		t.Fatalf("pinned first = %s, want 1,5,4", got)
+	}
+	if got := page("ride", 10, 0, false); got != "3,2,1" {
+		t.Fatalf("Ride filter = %s, want the rides including the gravel ride", got)
+	}
+	if got := page("VirtualRide", 10, 0, false); got != "4" {
+		t.Fatalf("VirtualRide filter = %s, want 4", got)
+	}
+
+	for activityType, want := range map[string]int{"": 5, "Ride": 3, "GravelRide": 1, "Swim": 0} {
+		if got, err := CountActivities(ctx, conn, athleteID, ActivityFilter{Type: activityType}); err != nil || got != want {
+			t.Fatalf("CountActivities(%q) = %d, %v; want %d", activityType, got, err, want)
+		}
+	}
+	if pinned, err := GetPinnedActivities(ctx, conn, athleteID); err != nil || len(pinned) != 1 || pinned[0].ID != athleteID*1000+1 {
+		t.Fatalf("GetPinnedActivities = %v, %v", pinned, err)
+	}
+	if options, err := ListActivityTypes(ctx, conn, athleteID); err != nil || strings.Join(options, ",") != "GravelRide,Ride,Run,VirtualRide" {
+		t.Fatalf("ListActivityTypes = %v, %v", options, err)
+	}
+}
+
+func TestQueryActivitiesFiltersByNameDateAndDistance(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000402)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	rides := []struct {
+		name     string
+		day      int
+		distance float64
+	}{
+		{"Morning Commute", 1, 12000},
+		{"Long commute home", 2, 31000},
+		{"Evening COMMUTE detour", 3, 24000},
+		{"Hill repeats", 4, 40000},
+		{"100% effort", 5, 5000},
+	}
+	start := time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC)
+	for i, ride := range rides {
+		activity := &strava.ActivitySummary{
+			ID:        athleteID*1000 + int64(i+1),
+			AthleteID: athleteID,
+			Name:      ride.name,
+			Type:      "Ride",
+			SportType: "Ride",
+			StartDate: start.AddDate(0, 0, ride.day-1).Format(time.RFC3339),
+			Distance:  ride.distance,
+		}
+		if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+			t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+		}
+	}
+
+	query := func(filter ActivityFilter) string {
+		t.Helper()
+		activities, err := QueryActivities(ctx, conn, athleteID, filter)
+		if err != nil {
+			t.Fatalf("QueryActivities(%+v): %v", filter, err)
+		}
+		count, err := CountActivities(ctx, conn, athleteID, filter)
+		if err != nil || count != len(activities) {
+			t.Fatalf("CountActivities(%+v) = %d, %v; want %d", filter, count, err, len(activities))
+		}
+		ids := make([]string, len(activities))
+		for i, activity := range activities {
+			ids[i] = fmt.Sprint(activity.ID - athleteID*1000)
+		}
+		return strings.Join(ids, ",")
+	}
+	cases := []struct {
+		filter ActivityFilter
+		want   string
+	}{
+		{ActivityFilter{Query: "commute", MinDistance: 20000, Sort: ActivitySortDistance}, "2,3"},
+		{ActivityFilter{Query: "commute"}, "3,2,1"},
+		{ActivityFilter{MaxDistance: 24000, Sort: ActivitySortDistance}, "3,1,5"},
+		{ActivityFilter{Start: start.AddDate(0, 0, 1).Truncate(24 * time.Hour), End: start.AddDate(0, 0, 3).Truncate(24 * time.Hour)}, "3,2"},
+		{ActivityFilter{Query: "%"}, "5"},
+		{ActivityFilter{Query: "' OR 1=1 --"}, ""},
+	}
+	for _, tc := range cases {
+		if got := query(tc.filter); got != tc.want {
+			t.Errorf("QueryActivities(%+v) = %s, want %s", tc.filter, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxTagLength bounds the length of an activity tag
+const MaxTagLength = 40
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// NormalizeTag trims and lowercases tag and checks it is one word of letters, digits,
+// "-" and "_" of at most MaxTagLength characters
+func NormalizeTag(tag string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(tag))
+	if len(normalized) > MaxTagLength || !tagPattern.MatchString(normalized) {
+		return "", fmt.Errorf("tag %q must be 1 to %d letters, digits, - or _", tag, MaxTagLength)
+	}
+	return normalized, nil
+}
+
+// TagCount is a tag with the number of the athlete's activities carrying it
+type TagCount struct {
+	Tag        string `json:"tag"`
+	Activities int    `json:"activities"`
+}
+
+// AddActivityTags tags the athlete's activity; tags it already has are kept as they are
+func AddActivityTags(ctx context.Context, conn DB, athleteID, activityID int64, tags ...string) error {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		value, err := NormalizeTag(tag)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, value)
+	}
+
+	var exists bool
+	if err := conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM activity_summaries WHERE athlete_id = $1 AND id = $2)
+	`, athleteID, activityID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check activity %d: %w", activityID, err)
+	}
+	if !exists {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_tags (activity_id, athlete_id, tag)
+		SELECT $2, $1, tag FROM unnest($3::text[]) AS tag
+		ON CONFLICT (activity_id, tag) DO NOTHING
+	`, athleteID, activityID, normalized); err != nil {
+		return fmt.Errorf("failed to tag activity %d: %w", activityID, err)
+	}
+	return nil
+}
+
+// RemoveActivityTag removes tag from the athlete's activity and reports whether it had it
+func RemoveActivityTag(ctx context.Context, conn DB, athleteID, activityID int64, tag string) (bool, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	result, err := conn.Exec(ctx, `
+		DELETE FROM activity_tags WHERE athlete_id = $1 AND activity_id = $2 AND tag = $3
+	`, athleteID, activityID, tag)
+	if err != nil {
+		return false, fmt.Errorf("failed to untag activity %d: %w", activityID, err)
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// ListActivityTags returns the tags of the athlete's activity in alphabetical order
+func ListActivityTags(ctx context.Context, conn DB, athleteID, activityID int64) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT tag FROM activity_tags WHERE athlete_id = $1 AND activity_id = $2 ORDER BY tag
+	`, athleteID, activityID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of activity %d: %w", activityID, err)
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan activity tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// ActivityTag is a tag on one of the athlete's activities
+type ActivityTag struct {
+	ActivityID int64     `json:"activity_id"`
+	Tag        string    `json:"tag"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ListAthleteActivityTags returns every tag on the athlete's activities, by activity
+func ListAthleteActivityTags(ctx context.Context, conn DB, athleteID int64) ([]ActivityTag, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT activity_id, tag, created_at FROM activity_tags WHERE athlete_id = $1 ORDER BY activity_id, tag
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activity tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []ActivityTag{}
+	for rows.Next() {
+		var tag ActivityTag
+		if err := rows.Scan(&tag.ActivityID, &tag.Tag, &tag.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan activity tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// ListAthleteTags returns every tag the athlete uses with how many activities carry it,
+// in alphabetical order
+func ListAthleteTags(ctx context.Context, conn DB, athleteID int64) ([]TagCount, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT tag, COUNT(*) FROM activity_tags WHERE athlete_id = $1 GROUP BY tag ORDER BY tag
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Activities); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -0,0 +1,74 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestActivityTagsFilterListsAndStats(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000799)
+	const base = int64(990000799000)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for day := 0; day < 3; day++ {
+		ride := similarFixtureRide(athleteID, base+int64(day), day, 11, 0)
+		if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+			t.Fatalf("InsertBikeActivity %d: %v", ride.Summary.ID, err)
+		}
+	}
+	if err := AddActivityTags(ctx, conn, athleteID, base, "commute", " Commute "); err != nil {
+		t.Fatalf("AddActivityTags: %v", err)
+	}
+	if err := AddActivityTags(ctx, conn, athleteID, base+1, "commute", "rain"); err != nil {
+		t.Fatalf("AddActivityTags: %v", err)
+	}
+	if err := AddActivityTags(ctx, conn, athleteID+1, base+2, "commute"); !errors.Is(err, ErrActivityNotFound) {
+		t.Fatalf("tagging another athlete's activity = %v, want not found", err)
+	}
+
+	tags, err := ListAthleteTags(ctx, conn, athleteID)
+	if err != nil || !reflect.DeepEqual(tags, []TagCount{{Tag: "commute", Activities: 2}, {Tag: "rain", Activities: 1}}) {
+		t.Fatalf("ListAthleteTags = %v, %v", tags, err)
+	}
+	for filter, want := range map[ActivityFilter]int{
+		{Tag: "commute"}:                     2,
+		{ExcludeTag: "commute"}:              1,
+		{Tag: "commute", ExcludeTag: "rain"}: 1,
+		{Tag: "unused"}:                      0,
+	} {
+		if count, err := CountActivities(ctx, conn, athleteID, filter); err != nil || count != want {
+			t.Errorf("CountActivities(%+v) = %d, %v; want %d", filter, count, err, want)
+		}
+	}
+	stats, err := GetAthleteStats(ctx, conn, athleteID, ActivityFilter{ExcludeTag: "commute"}, DisplayZone{})
+	if err != nil || stats.Totals.Activities != 1 {
+		t.Fatalf("stats without commutes = %+v, %v; want one activity", stats, err)
+	}
+
+	if removed, err := RemoveActivityTag(ctx, conn, athleteID, base+1, "RAIN"); err != nil || !removed {
+		t.Fatalf("RemoveActivityTag = %v, %v", removed, err)
+	}
+	if removed, err := RemoveActivityTag(ctx, conn, athleteID, base+1, "rain"); err != nil || removed {
+		t.Fatalf("removing a missing tag = %v, %v; want false", removed, err)
+	}
+	if tags, err := ListActivityTags(ctx, conn, athleteID, base+1); err != nil || !reflect.DeepEqual(tags, []string{"commute"}) {
+		t.Fatalf("ListActivityTags = %v, %v", tags, err)
+	}
+
+	// Tags go with their activity
+	if _, err := conn.Exec(ctx, `DELETE FROM activity_summaries WHERE id = $1`, base); err != nil {
+		t.Fatalf("delete activity: %v", err)
+	}
+	if tags, err := ListAthleteTags(ctx, conn, athleteID); err != nil || !reflect.DeepEqual(tags, []TagCount{{Tag: "commute", Activities: 1}}) {
+		t.Fatalf("tags after deleting an activity = %v, %v", tags, err)
+	}
+}
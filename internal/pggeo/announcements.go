@@ -0,0 +1,223 @@
+package pggeo
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Announcement levels. Warnings are listed above infos.
+const (
+	AnnouncementLevelInfo    = "info"
+	AnnouncementLevelWarning = "warning"
+)
+
+// MaxAnnouncementLength is the longest announcement message in bytes
+const MaxAnnouncementLength = 1000
+
+// Announcement is an instance-wide message, e.g. about a maintenance window, shown on
+// every page from StartsAt until EndsAt. A nil EndsAt shows it until it is expired.
+type Announcement struct {
+	ID        int64      `json:"id"`
+	Message   string     `json:"message"`
+	Level     string     `json:"level"`
+	StartsAt  time.Time  `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	CreatedBy int64      `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ValidAnnouncementLevel reports whether level is info or warning
+func ValidAnnouncementLevel(level string) bool {
+	return level == AnnouncementLevelInfo || level == AnnouncementLevelWarning
+}
+
+const announcementColumns = `id, message, level, starts_at, ends_at, created_by, created_at`
+
+func scanAnnouncement(row pgx.Row) (Announcement, error) {
+	var a Announcement
+	err := row.Scan(&a.ID, &a.Message, &a.Level, &a.StartsAt, &a.EndsAt, &a.CreatedBy, &a.CreatedAt)
+	return a, err
+}
+
+// CreateAnnouncement stores a new announcement. A zero StartsAt starts it now.
+func CreateAnnouncement(ctx context.Context, conn DB, a Announcement) (*Announcement, error) {
+	a.Message = strings.TrimSpace(a.Message)
+	if a.Message == "" {
+		return nil, invalidInputf("announcement message is empty")
+	}
+	if len(a.Message) > MaxAnnouncementLength {
+		return nil, invalidInputf("announcement message is longer than %d bytes", MaxAnnouncementLength)
+	}
+	if !ValidAnnouncementLevel(a.Level) {
+		return nil, invalidInputf("invalid announcement level %q", a.Level)
+	}
+	var startsAt *time.Time
+	if !a.StartsAt.IsZero() {
+		startsAt = &a.StartsAt
+	}
+	if a.EndsAt != nil && startsAt != nil && !a.EndsAt.After(*startsAt) {
+		return nil, invalidInputf("announcement ends before it starts")
+	}
+
+	created, err := scanAnnouncement(conn.QueryRow(ctx, `
+		INSERT INTO announcements (message, level, starts_at, ends_at, created_by)
+		VALUES ($1, $2, COALESCE($3, NOW()), $4, $5)
+		RETURNING `+announcementColumns,
+		a.Message, a.Level, startsAt, a.EndsAt, a.CreatedBy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create announcement: %w", err)
+	}
+	return &created, nil
+}
+
+// ExpireAnnouncement ends the announcement at now, or keeps its earlier end
+func ExpireAnnouncement(ctx context.Context, conn DB, announcementID int64, now time.Time) (*Announcement, error) {
+	expired, err := scanAnnouncement(conn.QueryRow(ctx, `
+		UPDATE announcements SET ends_at = LEAST(COALESCE(ends_at, $2), $2)
+		WHERE id = $1
+		RETURNING `+announcementColumns,
+		announcementID, now))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, notFoundf(err, "announcement with ID %d not found", announcementID)
+		}
+		return nil, fmt.Errorf("failed to expire announcement: %w", err)
+	}
+	return &expired, nil
+}
+
+// ListAnnouncements returns the latest announcements, expired ones included, newest first
+func ListAnnouncements(ctx context.Context, conn DB, limit int) ([]Announcement, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT `+announcementColumns+`
+		FROM announcements
+		ORDER BY id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list announcements: %w", err)
+	}
+	return collectAnnouncements(rows)
+}
+
+// GetActiveAnnouncements returns the announcements shown at now, warnings first and then
+// the latest to start. With a tokenKey, the ones that web session dismissed are left
+// out. Expired announcements drop out by time, so they never need cleaning up.
+func GetActiveAnnouncements(ctx context.Context, conn DB, now time.Time, tokenKey string) ([]Announcement, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT `+announcementColumns+`
+		FROM announcements a
+		WHERE a.starts_at <= $1 AND (a.ends_at IS NULL OR a.ends_at > $1)
+			AND NOT EXISTS (
+				SELECT 1 FROM announcement_dismissals d
+				WHERE d.announcement_id = a.id AND d.token_key = $2
+			)
+	`, now, tokenKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active announcements: %w", err)
+	}
+	announcements, err := collectAnnouncements(rows)
+	if err != nil {
+		return nil, err
+	}
+	SortAnnouncements(announcements)
+	return announcements, nil
+}
+
+// ReplaceAnnouncement keeps one active announcement by createdBy starting with prefix,
+// showing a.Message: an active one with that message is kept, the others expire at now,
+// and a is created when none matched. An empty a.Message only expires them. It returns
+// the announcement shown, nil without a message. Background checks use it to post a
+// banner once and update it as their findings change.
+func ReplaceAnnouncement(ctx context.Context, conn DB, prefix string, a Announcement, now time.Time) (*Announcement, error) {
+	active, err := GetActiveAnnouncements(ctx, conn, now, "")
+	if err != nil {
+		return nil, err
+	}
+	a.Message = strings.TrimSpace(a.Message)
+	var kept *Announcement
+	for _, existing := range active {
+		if existing.CreatedBy != a.CreatedBy || !strings.HasPrefix(existing.Message, prefix) {
+			continue
+		}
+		if kept == nil && a.Message != "" && existing.Message == a.Message && existing.Level == a.Level {
+			kept = &existing
+			continue
+		}
+		if _, err := ExpireAnnouncement(ctx, conn, existing.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	if kept != nil || a.Message == "" {
+		return kept, nil
+	}
+	a.StartsAt = now
+	return CreateAnnouncement(ctx, conn, a)
+}
+
+// SortAnnouncements orders announcements for display: warnings above infos, and within
+// a level the latest to start first
+func SortAnnouncements(announcements []Announcement) {
+	slices.SortStableFunc(announcements, func(a, b Announcement) int {
+		if rank := announcementRank(b.Level) - announcementRank(a.Level); rank != 0 {
+			return rank
+		}
+		if c := b.StartsAt.Compare(a.StartsAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+}
+
+func announcementRank(level string) int {
+	if level == AnnouncementLevelWarning {
+		return 1
+	}
+	return 0
+}
+
+// DismissAnnouncement hides the announcement from one web session for good
+func DismissAnnouncement(ctx context.Context, conn DB, announcementID int64, tokenKey string) error {
+	tag, err := conn.Exec(ctx, `
+		INSERT INTO announcement_dismissals (announcement_id, token_key)
+		SELECT id, $2 FROM announcements WHERE id = $1
+		ON CONFLICT DO NOTHING
+	`, announcementID, tokenKey)
+	if err != nil {
+		return fmt.Errorf("failed to dismiss announcement: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM announcements WHERE id = $1)`, announcementID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check announcement: %w", err)
+	}
+	if !exists {
+		return notFoundf(nil, "announcement with ID %d not found", announcementID)
+	}
+	return nil
+}
+
+func collectAnnouncements(rows pgx.Rows) ([]Announcement, error) {
+	defer rows.Close()
+	announcements := []Announcement{}
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan announcement: %w", err)
+		}
+		announcements = append(announcements, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read announcements: %w", err)
+	}
+	return announcements, nil
+}
@@ -0,0 +1,97 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestActiveAnnouncementsFollowTimeAndDismissals(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const adminID = int64(990000785)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM announcements WHERE created_by = $1`, adminID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	ends := now.Add(time.Hour)
+	ended := now.Add(-time.Minute)
+	create := func(a Announcement) *Announcement {
+		t.Helper()
+		a.CreatedBy = adminID
+		created, err := CreateAnnouncement(ctx, conn, a)
+		if err != nil {
+			t.Fatalf("CreateAnnouncement(%q): %v", a.Message, err)
+		}
+		return created
+	}
+	info := create(Announcement{Message: "New segments page", Level: AnnouncementLevelInfo, StartsAt: now.Add(-time.Minute)})
+	warning := create(Announcement{Message: "Maintenance tonight", Level: AnnouncementLevelWarning, StartsAt: now.Add(-2 * time.Minute), EndsAt: &ends})
+	create(Announcement{Message: "Upcoming", Level: AnnouncementLevelWarning, StartsAt: now.Add(time.Hour)})
+	create(Announcement{Message: "Over", Level: AnnouncementLevelWarning, StartsAt: now.Add(-time.Hour), EndsAt: &ended})
+
+	active := func(tokenKey string) []int64 {
+		t.Helper()
+		announcements, err := GetActiveAnnouncements(ctx, conn, now, tokenKey)
+		if err != nil {
+			t.Fatalf("GetActiveAnnouncements: %v", err)
+		}
+		var ids []int64
+		for _, a := range announcements {
+			if a.CreatedBy == adminID {
+				ids = append(ids, a.ID)
+			}
+		}
+		return ids
+	}
+	if got := active(""); len(got) != 2 || got[0] != warning.ID || got[1] != info.ID {
+		t.Fatalf("active = %v, want warning %d above info %d", got, warning.ID, info.ID)
+	}
+
+	if err := DismissAnnouncement(ctx, conn, warning.ID, "session:laptop"); err != nil {
+		t.Fatalf("DismissAnnouncement: %v", err)
+	}
+	// Dismissing again is harmless
+	if err := DismissAnnouncement(ctx, conn, warning.ID, "session:laptop"); err != nil {
+		t.Fatalf("DismissAnnouncement again: %v", err)
+	}
+	if got := active("session:laptop"); len(got) != 1 || got[0] != info.ID {
+		t.Fatalf("active for the dismissing session = %v, want only %d", got, info.ID)
+	}
+	if got := active("session:phone"); len(got) != 2 {
+		t.Fatalf("active for another session = %v, want both", got)
+	}
+	if err := DismissAnnouncement(ctx, conn, -1, "session:laptop"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("dismiss missing announcement error = %v, want ErrNotFound", err)
+	}
+
+	expired, err := ExpireAnnouncement(ctx, conn, info.ID, now)
+	if err != nil || expired.EndsAt == nil || !expired.EndsAt.Equal(now) {
+		t.Fatalf("ExpireAnnouncement = %+v, %v", expired, err)
+	}
+	if got := active("session:phone"); len(got) != 1 || got[0] != warning.ID {
+		t.Fatalf("active after expiry = %v, want only %d", got, warning.ID)
+	}
+	// Expiring an announcement that already ended keeps the earlier end
+	if again, err := ExpireAnnouncement(ctx, conn, info.ID, now.Add(time.Hour)); err != nil || !again.EndsAt.Equal(now) {
+		t.Fatalf("second ExpireAnnouncement = %+v, %v", again, err)
+	}
+	if _, err := ExpireAnnouncement(ctx, conn, -1, now); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expire missing announcement error = %v, want ErrNotFound", err)
+	}
+
+	for _, bad := range []Announcement{
+		{Message: " ", Level: AnnouncementLevelInfo},
+		{Message: "Hi", Level: "critical"},
+		{Message: "Hi", Level: AnnouncementLevelInfo, StartsAt: now, EndsAt: &ended},
+	} {
+		if _, err := CreateAnnouncement(ctx, conn, bad); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("CreateAnnouncement(%+v) error = %v, want ErrInvalidInput", bad, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package pggeo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSortAnnouncementsPutsWarningsFirst(t *testing.T) {
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	announcements := []Announcement{
+		{ID: 1, Level: AnnouncementLevelInfo, StartsAt: base.Add(2 * time.Hour)},
+		{ID: 2, Level: AnnouncementLevelWarning, StartsAt: base},
+		{ID: 3, Level: AnnouncementLevelInfo, StartsAt: base.Add(2 * time.Hour)},
+		{ID: 4, Level: AnnouncementLevelWarning, StartsAt: base.Add(time.Hour)},
+		{ID: 5, Level: AnnouncementLevelInfo, StartsAt: base.Add(3 * time.Hour)},
+	}
+	SortAnnouncements(announcements)
+
+	want := []int64{4, 2, 5, 3, 1}
+	for i, a := range announcements {
+		if a.ID != want[i] {
+			t.Fatalf("order = %v, want IDs %v", announcementIDs(announcements), want)
+		}
+	}
+}
+
+func announcementIDs(announcements []Announcement) []int64 {
+	ids := make([]int64, len(announcements))
+	for i, a := range announcements {
+		ids[i] = a.ID
+	}
+	return ids
+}
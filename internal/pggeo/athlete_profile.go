@@ -0,0 +1,120 @@
+package pggeo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AthleteProfile is the Strava metadata prefetched after login, so pages can show the
+// athlete, their HR zones and gear names without calling Strava
+type AthleteProfile struct {
+	Athlete      strava.Athlete
+	HRZones      *strava.HeartRateZones // nil when the athlete has no HR zones or Strava refused them
+	Gear         []Gear                 // every gear item stored for the athlete, see ListGear
+	PrefetchedAt time.Time
+}
+
+// GearNames maps the profile's gear IDs to their names
+func (p *AthleteProfile) GearNames() map[string]string {
+	names := make(map[string]string, len(p.Gear))
+	for _, gear := range p.Gear {
+		names[gear.ID] = gear.Name
+	}
+	return names
+}
+
+// SaveAthleteProfile replaces the athlete's stored profile, stores the name and kind of
+// each of their gear items in gear, refreshes their row in athletes, and names the gear of
+// their activities that has no name yet. Gear no longer listed is kept: activities still
+// name it.
+func SaveAthleteProfile(ctx context.Context, conn DB, profile *AthleteProfile) error {
+	var zones []byte
+	if profile.HRZones != nil {
+		var err error
+		if zones, err = json.Marshal(profile.HRZones); err != nil {
+			return fmt.Errorf("failed to encode HR zones: %w", err)
+		}
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	athlete := profile.Athlete
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO athlete_profiles (athlete_id, firstname, lastname, profile, hr_zones, prefetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (athlete_id) DO UPDATE SET
+			firstname = EXCLUDED.firstname,
+			lastname = EXCLUDED.lastname,
+			profile = EXCLUDED.profile,
+			hr_zones = EXCLUDED.hr_zones,
+			prefetched_at = EXCLUDED.prefetched_at
+	`, athlete.ID, athlete.FirstName, athlete.LastName, athlete.Profile, zones, profile.PrefetchedAt); err != nil {
+		return fmt.Errorf("failed to store athlete profile: %w", err)
+	}
+	if _, err := tx.Exec(ctx, upsertAthleteQuery, athlete.ID, athlete.FirstName, athlete.LastName, athlete.Profile); err != nil {
+		return fmt.Errorf("failed to store athlete: %w", err)
+	}
+	for _, gear := range profile.Gear {
+		// Brand, model and retired come from the sync's gear fetch and are kept
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO gear (id, athlete_id, name, kind)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, kind = EXCLUDED.kind
+			WHERE gear.athlete_id = EXCLUDED.athlete_id
+		`, gear.ID, athlete.ID, gear.Name, gear.Kind); err != nil {
+			return fmt.Errorf("failed to store gear %s: %w", gear.ID, err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE activity_summaries a
+		SET gear_name = g.name, updated_at = NOW()
+		FROM gear g
+		WHERE a.athlete_id = $1 AND g.athlete_id = $1 AND a.gear_id = g.id
+		  AND a.gear_name IS NULL AND g.name <> ''
+	`, athlete.ID); err != nil {
+		return fmt.Errorf("failed to name activity gear: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit athlete profile: %w", err)
+	}
+	return nil
+}
+
+// GetAthleteProfile returns the athlete's prefetched profile, or nil when none is stored
+func GetAthleteProfile(ctx context.Context, conn DB, athleteID int64) (*AthleteProfile, error) {
+	profile := AthleteProfile{Athlete: strava.Athlete{ID: athleteID}}
+	var zones []byte
+	err := conn.QueryRow(ctx, `
+		SELECT firstname, lastname, profile, hr_zones, prefetched_at
+		FROM athlete_profiles
+		WHERE athlete_id = $1
+	`, athleteID).Scan(&profile.Athlete.FirstName, &profile.Athlete.LastName, &profile.Athlete.Profile, &zones, &profile.PrefetchedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get athlete profile: %w", err)
+	}
+	if zones != nil {
+		profile.HRZones = &strava.HeartRateZones{}
+		if err := json.Unmarshal(zones, profile.HRZones); err != nil {
+			return nil, fmt.Errorf("failed to decode HR zones: %w", err)
+		}
+	}
+
+	if profile.Gear, err = ListGear(ctx, conn, athleteID); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
@@ -0,0 +1,78 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestAthleteProfileRoundTripNamesActivityGear(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000775), int64(990000775001)
+	cleanup := func() {
+		for _, query := range []string{
+			`DELETE FROM activity_summaries WHERE id = $1`,
+			`DELETE FROM athlete_profiles WHERE athlete_id = $2`,
+			`DELETE FROM gear WHERE athlete_id = $2`,
+			`DELETE FROM athletes WHERE id = $2`,
+		} {
+			_, _ = conn.Exec(context.Background(), query, activityID, athleteID)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if profile, err := GetAthleteProfile(ctx, conn, athleteID); err != nil || profile != nil {
+		t.Fatalf("profile before prefetch = %+v, %v; want none", profile, err)
+	}
+	if err := InsertActivitySummaryUpsert(ctx, conn, &strava.ActivitySummary{
+		ID: activityID, AthleteID: athleteID, Name: "Commute", Type: "Ride", SportType: "Ride",
+		StartDate: "2024-05-02T07:00:00Z", Distance: 8000, GearID: "b1",
+	}); err != nil {
+		t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+	}
+
+	saved := &AthleteProfile{
+		Athlete:      strava.Athlete{ID: athleteID, FirstName: "Ada", LastName: "L"},
+		HRZones:      &strava.HeartRateZones{Zones: []strava.HRZone{{Min: 0, Max: 120}, {Min: 120, Max: -1}}},
+		Gear:         []Gear{{ID: "b1", Name: "Road", Kind: GearKindBike}, {ID: "g1", Name: "Trail", Kind: GearKindShoe}},
+		PrefetchedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := SaveAthleteProfile(ctx, conn, saved); err != nil {
+		t.Fatalf("SaveAthleteProfile: %v", err)
+	}
+	// The sync's details of the bike survive a later prefetch, which also keeps the shoes
+	// it no longer lists
+	if err := UpsertGear(ctx, conn, Gear{ID: "b1", AthleteID: athleteID, Name: "Road", Brand: "Canyon"}); err != nil {
+		t.Fatalf("UpsertGear: %v", err)
+	}
+	saved.Gear = saved.Gear[:1]
+	if err := SaveAthleteProfile(ctx, conn, saved); err != nil {
+		t.Fatalf("second SaveAthleteProfile: %v", err)
+	}
+
+	profile, err := GetAthleteProfile(ctx, conn, athleteID)
+	if err != nil || profile == nil {
+		t.Fatalf("GetAthleteProfile = %+v, %v", profile, err)
+	}
+	if profile.Athlete.FirstName != "Ada" || profile.HRZones == nil || len(profile.HRZones.Zones) != 2 || !profile.PrefetchedAt.Equal(saved.PrefetchedAt) {
+		t.Fatalf("profile = %+v, want the saved athlete and zones", profile)
+	}
+	if len(profile.Gear) != 2 || profile.GearNames()["b1"] != "Road" || profile.GearNames()["g1"] != "Trail" {
+		t.Fatalf("gear = %+v, want the bike and the shoes", profile.Gear)
+	}
+	if bike := profile.Gear[0]; bike.ID != "b1" || bike.Kind != GearKindBike || bike.Brand != "Canyon" {
+		t.Fatalf("bike = %+v, want its kind from the prefetch and its brand from the sync", bike)
+	}
+	activity, err := GetActivityByID(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetActivityByID: %v", err)
+	}
+	if activity.GearName == nil || *activity.GearName != "Road" {
+		t.Fatalf("activity gear name = %v, want Road", activity.GearName)
+	}
+}
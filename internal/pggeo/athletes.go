@@ -0,0 +1,53 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StoredAthlete is an athlete's Strava identity from the athletes table
+type StoredAthlete struct {
+	Athlete   strava.Athlete
+	UpdatedAt time.Time
+}
+
+// UpsertAthlete stores the athlete's name and avatar, stamped now
+func UpsertAthlete(ctx context.Context, conn DB, athlete *strava.Athlete) error {
+	if _, err := conn.Exec(ctx, upsertAthleteQuery, athlete.ID, athlete.FirstName, athlete.LastName, athlete.Profile); err != nil {
+		return fmt.Errorf("failed to store athlete %d: %w", athlete.ID, err)
+	}
+	return nil
+}
+
+const upsertAthleteQuery = `
+	INSERT INTO athletes (id, firstname, lastname, profile_url, updated_at)
+	VALUES ($1, $2, $3, $4, NOW())
+	ON CONFLICT (id) DO UPDATE SET
+		firstname = EXCLUDED.firstname,
+		lastname = EXCLUDED.lastname,
+		profile_url = EXCLUDED.profile_url,
+		updated_at = EXCLUDED.updated_at
+`
+
+// GetAthlete returns the stored athlete, or nil when none is stored
+func GetAthlete(ctx context.Context, conn DB, athleteID int64) (*StoredAthlete, error) {
+	stored := StoredAthlete{Athlete: strava.Athlete{ID: athleteID}}
+	err := conn.QueryRow(ctx, `
+		SELECT firstname, lastname, profile_url, updated_at
+		FROM athletes
+		WHERE id = $1
+	`, athleteID).Scan(&stored.Athlete.FirstName, &stored.Athlete.LastName, &stored.Athlete.Profile, &stored.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get athlete %d: %w", athleteID, err)
+	}
+	return &stored, nil
+}
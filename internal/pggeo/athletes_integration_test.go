@@ -0,0 +1,41 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestAthletesUpsertAndGet(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000781)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM athletes WHERE id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM athlete_profiles WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if stored, err := GetAthlete(ctx, conn, athleteID); err != nil || stored != nil {
+		t.Fatalf("athlete before login = %+v, %v; want none", stored, err)
+	}
+	if err := UpsertAthlete(ctx, conn, &strava.Athlete{ID: athleteID, FirstName: "Ada", LastName: "L", Profile: "https://example.test/a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := GetAthlete(ctx, conn, athleteID)
+	if err != nil || stored == nil || stored.Athlete.FirstName != "Ada" || stored.Athlete.Profile != "https://example.test/a.jpg" || time.Since(stored.UpdatedAt) > time.Minute {
+		t.Fatalf("stored = %+v, %v", stored, err)
+	}
+
+	// A prefetched profile refreshes the identity too
+	if err := SaveAthleteProfile(ctx, conn, &AthleteProfile{Athlete: strava.Athlete{ID: athleteID, FirstName: "Ada", LastName: "Lovelace"}, PrefetchedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err = GetAthlete(ctx, conn, athleteID); err != nil || stored.Athlete.LastName != "Lovelace" || stored.Athlete.Profile != "" {
+		t.Fatalf("after prefetch = %+v, %v", stored, err)
+	}
+}
@@ -2,32 +2,65 @@ package pggeo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/jackc/pgx/v5"
 )
 
-// SegmentActivityCacheEntry represents a cached segment-activity match with metrics
+// How the overlap_percentage of a cached match was computed. Rows cached as buffered
+// predate the projected coverage and are recomputed when read.
+const (
+	OverlapMethodBuffered  = "buffered"
+	OverlapMethodProjected = "projected"
+)
+
+// SegmentActivityCacheEntry represents a cached segment-activity match with metrics for one
+// traversal of the segment. EffortCount is nil until the activity's traversals were detected.
 type SegmentActivityCacheEntry struct {
-	SegmentID         int64
-	ActivityID        int64
-	ToleranceMeters   float64
-	MinDistanceM      float64
-	OverlapLengthM    float64
-	OverlapPercentage float64
-	StartIndex        *int
-	EndIndex          *int
-	AvgHR             *float64
-	AvgSpeed          *float64
-	DistanceM         *float64
-	ElevationGainM    *float64
-	ElapsedSeconds    *float64
-	DirectionChecked  bool
+	SegmentID          int64
+	ActivityID         int64
+	ToleranceMeters    float64
+	EffortNumber       int
+	EffortCount        *int
+	Direction          string // SegmentDirectionForward or SegmentDirectionReverse
+	MinDistanceM       float64
+	OverlapLengthM     float64
+	OverlapPercentage  float64
+	StartIndex         *int
+	EndIndex           *int
+	AvgHR              *float64
+	AvgSpeed           *float64
+	DistanceM          *float64
+	ElevationGainM     *float64
+	ElapsedSeconds     *float64
+	GradeAdjustedSpeed *float64
+	GradeAdjusted      *bool
+	DirectionChecked   bool
+	OverlapMethod      string // OverlapMethodProjected or OverlapMethodBuffered
+}
+
+// segmentEffortColumns are the segment_activity_matches columns scanSegmentEffort reads
+const segmentEffortColumns = `segment_id, activity_id, tolerance_meters, effort_number, effort_count, direction,
+	min_distance_m, overlap_length_m, overlap_percentage,
+	start_index, end_index, avg_hr, avg_speed, distance_m, elevation_gain_m, elapsed_seconds,
+	grade_adjusted_speed, grade_adjusted, direction_checked, overlap_method`
+
+func scanSegmentEffort(rows pgx.Rows) (SegmentActivityCacheEntry, error) {
+	var entry SegmentActivityCacheEntry
+	err := rows.Scan(
+		&entry.SegmentID, &entry.ActivityID, &entry.ToleranceMeters, &entry.EffortNumber, &entry.EffortCount, &entry.Direction,
+		&entry.MinDistanceM, &entry.OverlapLengthM, &entry.OverlapPercentage,
+		&entry.StartIndex, &entry.EndIndex, &entry.AvgHR, &entry.AvgSpeed,
+		&entry.DistanceM, &entry.ElevationGainM, &entry.ElapsedSeconds,
+		&entry.GradeAdjustedSpeed, &entry.GradeAdjusted, &entry.DirectionChecked, &entry.OverlapMethod,
+	)
+	return entry, err
 }
 
-// CacheSegmentActivityMatches caches segment-activity match results
+// CacheSegmentActivityMatches caches segment-activity match results on the first effort row
 // Uses UPSERT to update existing entries or insert new ones, preserving cache for other segments
-func CacheSegmentActivityMatches(ctx context.Context, conn *pgx.Conn, segmentID int64, toleranceMeters float64, matches []SegmentMatchResult) error {
+func CacheSegmentActivityMatches(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64, matches []SegmentMatchResult) error {
 	if len(matches) == 0 {
 		return nil
 	}
@@ -50,48 +83,74 @@ func CacheSegmentActivityMatches(ctx context.Context, conn *pgx.Conn, segmentID
 
 		_, err := conn.Exec(ctx, `
 			INSERT INTO segment_activity_matches 
-			(segment_id, activity_id, tolerance_meters, min_distance_m, overlap_length_m, overlap_percentage, direction_checked, cached_at)
-			VALUES ($1, $2, $3, $4, $5, $6, TRUE, NOW())
-			ON CONFLICT (segment_id, activity_id, tolerance_meters) 
+			(segment_id, activity_id, tolerance_meters, effort_number, min_distance_m, overlap_length_m, overlap_percentage, direction_checked, overlap_method, cached_at)
+			VALUES ($1, $2, $3, 1, $4, $5, $6, TRUE, $7, NOW())
+			ON CONFLICT (segment_id, activity_id, tolerance_meters, effort_number) 
 			DO UPDATE SET 
 				min_distance_m = EXCLUDED.min_distance_m,
 				overlap_length_m = EXCLUDED.overlap_length_m,
 				overlap_percentage = EXCLUDED.overlap_percentage,
 				direction_checked = TRUE,
+				overlap_method = EXCLUDED.overlap_method,
 				cached_at = NOW()
-		`, segmentID, match.ActivityID, toleranceMeters, match.MinDistanceM, match.OverlapLengthM, overlapPct)
+		`, segmentID, match.ActivityID, toleranceMeters, match.MinDistanceM, match.OverlapLengthM, overlapPct, OverlapMethodProjected)
 		if err != nil {
 			return fmt.Errorf("failed to cache match: %w", err)
 		}
+
+		// Later efforts carry copies of the match metadata
+		_, err = conn.Exec(ctx, `
+			UPDATE segment_activity_matches
+			SET min_distance_m = $4, overlap_length_m = $5, overlap_percentage = $6, overlap_method = $7
+			WHERE segment_id = $1 AND activity_id = $2 AND tolerance_meters = $3 AND effort_number > 1
+		`, segmentID, match.ActivityID, toleranceMeters, match.MinDistanceM, match.OverlapLengthM, overlapPct, OverlapMethodProjected)
+		if err != nil {
+			return fmt.Errorf("failed to update cached efforts of match: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// CacheSegmentActivityMetrics caches metrics for a segment-activity match
-func CacheSegmentActivityMetrics(ctx context.Context, conn *pgx.Conn, segmentID, activityID int64, toleranceMeters float64, startIndex, endIndex int, avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds float64) error {
-	tag, err := conn.Exec(ctx, `
-		UPDATE segment_activity_matches
-		SET start_index = $1,
-			end_index = $2,
-			avg_hr = $3,
-			avg_speed = $4,
-			distance_m = $5,
-			elevation_gain_m = $6,
-			elapsed_seconds = $7,
-			direction_checked = TRUE,
-			cached_at = NOW()
-		WHERE segment_id = $8 AND activity_id = $9 AND tolerance_meters = $10
-	`, startIndex, endIndex, avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds, segmentID, activityID, toleranceMeters)
-	if err != nil || tag.RowsAffected() == 0 {
-		// If update didn't affect any rows, try insert (match might not exist yet).
-		_, err = conn.Exec(ctx, `
+// CacheSegmentActivityEfforts replaces the cached efforts of an activity on a segment with the
+// given traversals, numbered from 1 in order. Match metadata is copied from the first effort row
+// when it exists.
+func CacheSegmentActivityEfforts(ctx context.Context, conn DB, segmentID, activityID int64, toleranceMeters float64, efforts []SegmentActivityCacheEntry) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin effort cache update: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var minDistance, overlapLength, overlapPercentage float64
+	overlapMethod := OverlapMethodProjected
+	err = tx.QueryRow(ctx, `
+		SELECT min_distance_m, overlap_length_m, overlap_percentage, overlap_method
+		FROM segment_activity_matches
+		WHERE segment_id = $1 AND activity_id = $2 AND tolerance_meters = $3 AND effort_number = 1
+	`, segmentID, activityID, toleranceMeters).Scan(&minDistance, &overlapLength, &overlapPercentage, &overlapMethod)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to read cached match: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM segment_activity_matches
+		WHERE segment_id = $1 AND activity_id = $2 AND tolerance_meters = $3 AND effort_number > $4
+	`, segmentID, activityID, toleranceMeters, max(len(efforts), 1)); err != nil {
+		return fmt.Errorf("failed to drop stale efforts: %w", err)
+	}
+
+	for i, effort := range efforts {
+		_, err := tx.Exec(ctx, `
 			INSERT INTO segment_activity_matches 
-			(segment_id, activity_id, tolerance_meters, min_distance_m, overlap_length_m, overlap_percentage,
+			(segment_id, activity_id, tolerance_meters, effort_number, effort_count, direction,
+			 min_distance_m, overlap_length_m, overlap_percentage, overlap_method,
 			 start_index, end_index, avg_hr, avg_speed, distance_m, elevation_gain_m, elapsed_seconds, direction_checked, cached_at)
-			VALUES ($8, $9, $10, 0, 0, 0, $1, $2, $3, $4, $5, $6, $7, TRUE, NOW())
-			ON CONFLICT (segment_id, activity_id, tolerance_meters) 
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $17, $10, $11, $12, $13, $14, $15, $16, TRUE, NOW())
+			ON CONFLICT (segment_id, activity_id, tolerance_meters, effort_number) 
 			DO UPDATE SET 
+				effort_count = EXCLUDED.effort_count,
+				direction = EXCLUDED.direction,
 				start_index = EXCLUDED.start_index,
 				end_index = EXCLUDED.end_index,
 				avg_hr = EXCLUDED.avg_hr,
@@ -99,41 +158,96 @@ func CacheSegmentActivityMetrics(ctx context.Context, conn *pgx.Conn, segmentID,
 				distance_m = EXCLUDED.distance_m,
 				elevation_gain_m = EXCLUDED.elevation_gain_m,
 				elapsed_seconds = EXCLUDED.elapsed_seconds,
+				grade_adjusted_speed = NULL,
+				grade_adjusted = NULL,
 				direction_checked = TRUE,
 				cached_at = NOW()
-		`, startIndex, endIndex, avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds, segmentID, activityID, toleranceMeters)
+		`, segmentID, activityID, toleranceMeters, i+1, len(efforts), effort.Direction,
+			minDistance, overlapLength, overlapPercentage,
+			effort.StartIndex, effort.EndIndex, effort.AvgHR, effort.AvgSpeed,
+			effort.DistanceM, effort.ElevationGainM, effort.ElapsedSeconds, overlapMethod)
 		if err != nil {
-			return fmt.Errorf("failed to cache metrics: %w", err)
+			return fmt.Errorf("failed to cache effort %d: %w", i+1, err)
 		}
 	}
+	if len(efforts) == 0 {
+		// Keep the match row but record that the activity has no traversal
+		if _, err := tx.Exec(ctx, `
+			UPDATE segment_activity_matches
+			SET effort_count = 0, start_index = NULL, end_index = NULL, cached_at = NOW()
+			WHERE segment_id = $1 AND activity_id = $2 AND tolerance_meters = $3 AND effort_number = 1
+		`, segmentID, activityID, toleranceMeters); err != nil {
+			return fmt.Errorf("failed to record missing efforts: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit effort cache update: %w", err)
+	}
 	return nil
 }
 
-// GetCachedSegmentActivityMetrics retrieves cached metrics for a segment-activity match
-func GetCachedSegmentActivityMetrics(ctx context.Context, conn *pgx.Conn, segmentID, activityID int64, toleranceMeters float64) (*SegmentActivityCacheEntry, error) {
-	var entry SegmentActivityCacheEntry
-	err := conn.QueryRow(ctx, `
-		SELECT segment_id, activity_id, tolerance_meters, min_distance_m, overlap_length_m, overlap_percentage,
-			start_index, end_index, avg_hr, avg_speed, distance_m, elevation_gain_m, elapsed_seconds, direction_checked
+// GetCachedSegmentActivityEfforts retrieves the cached effort rows of an activity on a segment,
+// ordered by effort number
+func GetCachedSegmentActivityEfforts(ctx context.Context, conn DB, segmentID, activityID int64, toleranceMeters float64) ([]SegmentActivityCacheEntry, error) {
+	efforts, err := getCachedSegmentEfforts(ctx, conn, segmentID, toleranceMeters, []int64{activityID})
+	if err != nil {
+		return nil, err
+	}
+	return efforts[activityID], nil
+}
+
+// getCachedSegmentEfforts retrieves the cached effort rows of the activities on a segment in
+// one query, keyed by activity and ordered by effort number; nil activityIDs reads every
+// activity cached for the segment
+func getCachedSegmentEfforts(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64, activityIDs []int64) (map[int64][]SegmentActivityCacheEntry, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT `+segmentEffortColumns+`
 		FROM segment_activity_matches
-		WHERE segment_id = $1 AND activity_id = $2 AND tolerance_meters = $3 AND direction_checked = TRUE
-	`, segmentID, activityID, toleranceMeters).Scan(
-		&entry.SegmentID, &entry.ActivityID, &entry.ToleranceMeters,
-		&entry.MinDistanceM, &entry.OverlapLengthM, &entry.OverlapPercentage,
-		&entry.StartIndex, &entry.EndIndex, &entry.AvgHR, &entry.AvgSpeed,
-		&entry.DistanceM, &entry.ElevationGainM, &entry.ElapsedSeconds, &entry.DirectionChecked,
-	)
-	if err == pgx.ErrNoRows {
-		return nil, nil
+		WHERE segment_id = $1 AND tolerance_meters = $2 AND direction_checked = TRUE
+		  AND ($3::BIGINT[] IS NULL OR activity_id = ANY($3))
+		ORDER BY activity_id, effort_number
+	`, segmentID, toleranceMeters, activityIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached efforts: %w", err)
+	}
+	defer rows.Close()
+
+	efforts := make(map[int64][]SegmentActivityCacheEntry)
+	for rows.Next() {
+		entry, err := scanSegmentEffort(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cached effort: %w", err)
+		}
+		efforts[entry.ActivityID] = append(efforts[entry.ActivityID], entry)
 	}
+	return efforts, rows.Err()
+}
+
+// CacheSegmentActivityGradeAdjustedSpeed stores the grade-adjusted speed for a cached segment effort.
+// adjusted is false when the effort had no grade or altitude data and speed is the raw average.
+func CacheSegmentActivityGradeAdjustedSpeed(ctx context.Context, conn DB, segmentID, activityID int64, toleranceMeters float64, effortNumber int, speed float64, adjusted bool) error {
+	_, err := conn.Exec(ctx, `
+		UPDATE segment_activity_matches
+		SET grade_adjusted_speed = $1,
+			grade_adjusted = $2
+		WHERE segment_id = $3 AND activity_id = $4 AND tolerance_meters = $5 AND effort_number = $6
+	`, speed, adjusted, segmentID, activityID, toleranceMeters, effortNumber)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get cached metrics: %w", err)
+		return fmt.Errorf("failed to cache grade-adjusted speed: %w", err)
 	}
-	return &entry, nil
+	return nil
 }
 
-// InvalidateSegmentCache invalidates cached matches for a segment
-func InvalidateSegmentCache(ctx context.Context, conn *pgx.Conn, segmentID int64) error {
+// InvalidateSegmentCache invalidates cached matches for a segment, so its next page visit
+// scans every activity again
+func InvalidateSegmentCache(ctx context.Context, conn DB, segmentID int64) error {
+	if _, err := conn.Exec(ctx, `
+		DELETE FROM segment_match_cache_state
+		WHERE segment_id = $1
+	`, segmentID); err != nil {
+		return err
+	}
 	_, err := conn.Exec(ctx, `
 		DELETE FROM segment_activity_matches
 		WHERE segment_id = $1
@@ -142,7 +256,7 @@ func InvalidateSegmentCache(ctx context.Context, conn *pgx.Conn, segmentID int64
 }
 
 // InvalidateActivityCache invalidates cached matches for an activity
-func InvalidateActivityCache(ctx context.Context, conn *pgx.Conn, activityID int64) error {
+func InvalidateActivityCache(ctx context.Context, conn DB, activityID int64) error {
 	_, err := conn.Exec(ctx, `
 		DELETE FROM segment_activity_matches
 		WHERE activity_id = $1
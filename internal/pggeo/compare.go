@@ -0,0 +1,184 @@
+package pggeo
+
+import (
+	"context"
+	"math"
+)
+
+// DefaultCompareStepMeters is the spacing of the distance grid CompareActivities resamples on
+const DefaultCompareStepMeters = 50.0
+
+// CompareMetrics are the metrics CompareActivities can align, named as in graph requests
+var CompareMetrics = []string{"speed", "heartrate", "height", "cadence", "watts", "grade"}
+
+// ComparedMetric is one metric of two activities on a shared distance grid. Values are nil
+// where an activity has no data, including past the end of the shorter ride; Delta is
+// Second - First where both have a value.
+type ComparedMetric struct {
+	First  []*float64 `json:"first"`
+	Second []*float64 `json:"second"`
+	Delta  []*float64 `json:"delta"`
+}
+
+// ActivityComparison holds two activities' metrics resampled onto the same distance grid,
+// so they can be overlaid on a distance axis however differently they were ridden
+type ActivityComparison struct {
+	ActivityIDs [2]int64                  `json:"activity_ids"`
+	StepM       float64                   `json:"step_m"`
+	DistanceM   []float64                 `json:"distance_m"`
+	Metrics     map[string]ComparedMetric `json:"metrics"`
+}
+
+// CompareActivities loads two of the athlete's activities and aligns the requested metrics
+// every stepM meters of cumulative distance with CompareSamples. It fails when either
+// activity has no samples.
+func CompareActivities(ctx context.Context, conn DB, athleteID int64, activityIDs [2]int64, metrics []string, stepM float64) (*ActivityComparison, error) {
+	var samples [2][]PointSample
+	for i, activityID := range activityIDs {
+		loaded, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+		if err != nil {
+			return nil, err
+		}
+		if len(loaded) == 0 {
+			return nil, activityNotFoundf(nil, "activity with ID %d not found", activityID)
+		}
+		samples[i] = loaded
+	}
+	comparison := CompareSamples(samples[0], samples[1], metrics, stepM)
+	comparison.ActivityIDs = activityIDs
+	return comparison, nil
+}
+
+// CompareSamples resamples both activities onto a grid from 0 to the longer ride's
+// distance every stepM meters, interpolating each metric linearly between the samples
+// around each grid distance. Samples without cumulative_distance are skipped.
+func CompareSamples(first, second []PointSample, metrics []string, stepM float64) *ActivityComparison {
+	if stepM <= 0 {
+		stepM = DefaultCompareStepMeters
+	}
+	length := math.Max(sampleDistanceSpan(first), sampleDistanceSpan(second))
+	grid := make([]float64, 0, int(length/stepM)+2)
+	for i := 0; float64(i)*stepM < length; i++ {
+		grid = append(grid, float64(i)*stepM)
+	}
+	grid = append(grid, length)
+
+	comparison := &ActivityComparison{StepM: stepM, DistanceM: grid, Metrics: make(map[string]ComparedMetric)}
+	for _, metric := range metrics {
+		compared := ComparedMetric{
+			First:  resampleByDistance(first, metric, grid),
+			Second: resampleByDistance(second, metric, grid),
+			Delta:  make([]*float64, len(grid)),
+		}
+		for i := range grid {
+			if compared.First[i] != nil && compared.Second[i] != nil {
+				delta := *compared.Second[i] - *compared.First[i]
+				compared.Delta[i] = &delta
+			}
+		}
+		comparison.Metrics[metric] = compared
+	}
+	return comparison
+}
+
+// sampleDistanceSpan is the largest cumulative_distance of the samples, measured from
+// their first one so rides whose streams start past 0 still line up
+func sampleDistanceSpan(samples []PointSample) float64 {
+	span := 0.0
+	origin, ok := firstSampleDistance(samples)
+	if !ok {
+		return 0
+	}
+	for _, sample := range samples {
+		if sample.CumulativeDistance != nil {
+			span = math.Max(span, *sample.CumulativeDistance-origin)
+		}
+	}
+	return span
+}
+
+func firstSampleDistance(samples []PointSample) (float64, bool) {
+	for _, sample := range samples {
+		if sample.CumulativeDistance != nil {
+			return *sample.CumulativeDistance, true
+		}
+	}
+	return 0, false
+}
+
+// resampleByDistance interpolates metric at each grid distance. Grid distances outside
+// the samples that carry the metric get nil.
+func resampleByDistance(samples []PointSample, metric string, grid []float64) []*float64 {
+	values := make([]*float64, len(grid))
+	origin, ok := firstSampleDistance(samples)
+	if !ok {
+		return values
+	}
+
+	type point struct{ distance, value float64 }
+	var points []point
+	for _, sample := range samples {
+		value, ok := sampleMetricValue(sample, metric)
+		if !ok || sample.CumulativeDistance == nil {
+			continue
+		}
+		distance := *sample.CumulativeDistance - origin
+		// Cumulative distance never goes back; drop samples that would
+		if n := len(points); n > 0 && distance < points[n-1].distance {
+			continue
+		}
+		points = append(points, point{distance, value})
+	}
+	if len(points) == 0 {
+		return values
+	}
+
+	j := 0
+	for i, d := range grid {
+		if d < points[0].distance || d > points[len(points)-1].distance {
+			continue
+		}
+		for j+1 < len(points) && points[j+1].distance < d {
+			j++
+		}
+		value := points[j].value
+		if j+1 < len(points) {
+			if span := points[j+1].distance - points[j].distance; span > 0 {
+				value += (points[j+1].value - points[j].value) * (d - points[j].distance) / span
+			}
+		}
+		values[i] = &value
+	}
+	return values
+}
+
+// sampleMetricValue returns the named graph metric of a sample
+func sampleMetricValue(sample PointSample, metric string) (float64, bool) {
+	switch metric {
+	case "speed":
+		if sample.Speed != nil {
+			return *sample.Speed, true
+		}
+	case "heartrate":
+		if sample.Heartrate != nil {
+			return float64(*sample.Heartrate), true
+		}
+	case "height":
+		if sample.Altitude != nil {
+			return *sample.Altitude, true
+		}
+	case "cadence":
+		if sample.Cadence != nil {
+			return float64(*sample.Cadence), true
+		}
+	case "watts":
+		if sample.Watts != nil {
+			return float64(*sample.Watts), true
+		}
+	case "grade":
+		if sample.Grade != nil {
+			return *sample.Grade, true
+		}
+	}
+	return 0, false
+}
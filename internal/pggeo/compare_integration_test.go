@@ -0,0 +1,54 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCompareActivitiesScopesToAthlete(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000780)
+	activityIDs := [2]int64{990000780001, 990000780002}
+	cleanup := func() {
+		for _, id := range activityIDs {
+			_, _ = conn.Exec(context.Background(), `DELETE FROM point_samples WHERE activity_id = $1`, id)
+			_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, id)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// 1 km at 5 m/s and 1.5 km at 6 m/s, a sample every 10 m
+	for i, ride := range []struct{ points, speed int }{{101, 5}, {151, 6}} {
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'compare fixture', $3, 200, 200, 0, 'Ride', NOW())
+		`, activityIDs[i], athleteID, float64(ride.points-1)*10); err != nil {
+			t.Fatalf("insert activity: %v", err)
+		}
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, speed, cumulative_distance)
+			SELECT $1, $2, i, NOW() + make_interval(secs => i * 2), ST_GeogFromText('POINT(7 45)'), $3, i * 10
+			FROM generate_series(0, $4 - 1) AS i
+		`, activityIDs[i], athleteID, ride.speed, ride.points); err != nil {
+			t.Fatalf("insert samples: %v", err)
+		}
+	}
+
+	if _, err := CompareActivities(ctx, conn, athleteID+1, activityIDs, []string{"speed"}, 50); err == nil {
+		t.Fatal("another athlete compared the activities")
+	}
+	comparison, err := CompareActivities(ctx, conn, athleteID, activityIDs, []string{"speed"}, 50)
+	if err != nil {
+		t.Fatalf("CompareActivities: %v", err)
+	}
+	if n := len(comparison.DistanceM); n != 31 || comparison.ActivityIDs != activityIDs {
+		t.Fatalf("comparison of %v has %d grid points, want 31", comparison.ActivityIDs, n)
+	}
+	speed := comparison.Metrics["speed"]
+	if speed.Delta[0] == nil || *speed.Delta[0] != 1 || speed.First[30] != nil || speed.Second[30] == nil {
+		t.Fatalf("speed = %+v", speed)
+	}
+}
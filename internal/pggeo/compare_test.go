@@ -0,0 +1,81 @@
+package pggeo
+
+import (
+	"math"
+	"testing"
+)
+
+// compareRide samples a ride every stepM meters up to length, with speed and heart rate
+// from the given functions of distance
+func compareRide(length, stepM float64, speed func(d float64) float64, heartrate func(d float64) int) []PointSample {
+	var samples []PointSample
+	for d := 0.0; d <= length; d += stepM {
+		distance, v, hr := d, speed(d), heartrate(d)
+		samples = append(samples, PointSample{CumulativeDistance: &distance, Speed: &v, Heartrate: &hr})
+	}
+	return samples
+}
+
+func TestCompareSamplesAlignsRidesOfDifferentLengths(t *testing.T) {
+	// Last month: 1 km sampled every 7 m; today: 1.2 km sampled every 3 m, 1 m/s faster
+	first := compareRide(1000, 7, func(d float64) float64 { return 5 + d/1000 }, func(float64) int { return 140 })
+	second := compareRide(1200, 3, func(d float64) float64 { return 6 + d/1000 }, func(float64) int { return 150 })
+
+	comparison := CompareSamples(first, second, []string{"speed", "heartrate"}, 50)
+	if comparison.StepM != 50 || comparison.DistanceM[0] != 0 || comparison.DistanceM[1] != 50 {
+		t.Fatalf("grid = %v", comparison.DistanceM[:2])
+	}
+	// The grid runs to the longer ride: 0, 50, ..., 1150, 1200
+	if n := len(comparison.DistanceM); n != 25 || comparison.DistanceM[n-1] != 1200 {
+		t.Fatalf("grid has %d points ending at %v, want 25 ending at 1200", n, comparison.DistanceM[n-1])
+	}
+
+	speed := comparison.Metrics["speed"]
+	for i, d := range comparison.DistanceM {
+		if d > 994 { // the first ride's last sample
+			if speed.First[i] != nil || speed.Delta[i] != nil {
+				t.Fatalf("first ride has speed at %v m, past its end", d)
+			}
+			if speed.Second[i] == nil {
+				t.Fatalf("second ride has no speed at %v m", d)
+			}
+			continue
+		}
+		if speed.First[i] == nil || math.Abs(*speed.First[i]-(5+d/1000)) > 1e-9 {
+			t.Fatalf("first ride speed at %v m = %v, want %v", d, speed.First[i], 5+d/1000)
+		}
+		if speed.Delta[i] == nil || math.Abs(*speed.Delta[i]-1) > 1e-9 {
+			t.Fatalf("delta at %v m = %v, want 1", d, speed.Delta[i])
+		}
+	}
+	if hr := comparison.Metrics["heartrate"]; hr.Delta[3] == nil || *hr.Delta[3] != 10 {
+		t.Fatalf("heart rate delta = %v, want 10", hr.Delta[3])
+	}
+}
+
+func TestCompareSamplesWithoutMetricOrDistance(t *testing.T) {
+	first := compareRide(500, 10, func(float64) float64 { return 5 }, func(float64) int { return 140 })
+	// The second ride's stream starts 2 km in and has no power
+	second := compareRide(500, 10, func(float64) float64 { return 6 }, func(float64) int { return 150 })
+	for i := range second {
+		shifted := *second[i].CumulativeDistance + 2000
+		second[i].CumulativeDistance = &shifted
+	}
+
+	comparison := CompareSamples(first, second, []string{"watts", "speed"}, 100)
+	if n := len(comparison.DistanceM); n != 6 {
+		t.Fatalf("grid = %v, want 0..500 every 100 m", comparison.DistanceM)
+	}
+	for i := range comparison.DistanceM {
+		if comparison.Metrics["watts"].First[i] != nil || comparison.Metrics["watts"].Delta[i] != nil {
+			t.Fatal("rides without power got watts")
+		}
+		if delta := comparison.Metrics["speed"].Delta[i]; delta == nil || *delta != 1 {
+			t.Fatalf("speed delta %d = %v, want 1 once both start at 0", i, delta)
+		}
+	}
+
+	if empty := CompareSamples(nil, nil, []string{"speed"}, 50); len(empty.DistanceM) != 1 || empty.Metrics["speed"].First[0] != nil {
+		t.Fatalf("no samples = %+v", empty)
+	}
+}
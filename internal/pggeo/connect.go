@@ -3,14 +3,113 @@ package pggeo
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// DefaultPoolMaxConns is the pool size used when none is configured
+const DefaultPoolMaxConns = 10
+
+const (
+	// poolHealthCheckPeriod is how often idle pool connections are checked, so the
+	// connections a database restart killed are replaced before a request picks them up
+	poolHealthCheckPeriod = 15 * time.Second
+	poolPingTimeout       = 2 * time.Second
+)
+
+// connectBackoff is waited between attempts to reach the database at startup, so the
+// server can start alongside a database that is still booting
+var connectBackoff = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+
+// DB is the query surface shared by *pgx.Conn, *pgxpool.Pool and pgx.Tx, so the same
+// functions serve the one-shot CLI connection and the web server's pool.
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+func connString(user, password, host, port, dbname string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s", host, port, user, password, dbname)
+}
+
 func Connect(ctx context.Context, user, password, host, port, dbname string) (*pgx.Conn, error) {
-	conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s", host, port, user, password, dbname))
+	conn, err := pgx.Connect(ctx, connString(user, password, host, port, dbname))
 	if err != nil {
 		return nil, err
 	}
 	return conn, nil
 }
+
+// ConnectPool opens a connection pool of at most maxConns connections (DefaultPoolMaxConns
+// if <= 0), retrying for about 15 seconds while the database is unreachable. The pool
+// replaces broken connections by itself, so it keeps working across database restarts.
+func ConnectPool(ctx context.Context, user, password, host, port, dbname string, maxConns int) (*pgxpool.Pool, error) {
+	poolConfig, err := parsePoolConfig(user, password, host, port, dbname, maxConns)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		err = pool.Ping(ctx)
+		if err == nil {
+			return pool, nil
+		}
+		if attempt == len(connectBackoff) {
+			break
+		}
+		logger().Warn("Database not reachable yet, retrying", "retry_in", connectBackoff[attempt], "error", err)
+		select {
+		case <-time.After(connectBackoff[attempt]):
+		case <-ctx.Done():
+			pool.Close()
+			return nil, ctx.Err()
+		}
+	}
+	pool.Close()
+	return nil, err
+}
+
+// NewReplicaPool creates a pool for a read replica whose sessions default to read-only
+// transactions, so a write routed there by mistake fails instead of diverging. Unlike
+// ConnectPool it does not dial: the replica may be down at startup and come back later.
+func NewReplicaPool(ctx context.Context, user, password, host, port, dbname string, maxConns int) (*pgxpool.Pool, error) {
+	poolConfig, err := parsePoolConfig(user, password, host, port, dbname, maxConns)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+func parsePoolConfig(user, password, host, port, dbname string, maxConns int) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(connString(user, password, host, port, dbname))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database config: %w", err)
+	}
+	if maxConns <= 0 {
+		maxConns = DefaultPoolMaxConns
+	}
+	poolConfig.MaxConns = int32(maxConns) // #nosec G115 -- pool sizes are small config values.
+	poolConfig.HealthCheckPeriod = poolHealthCheckPeriod
+	poolConfig.PingTimeout = poolPingTimeout
+	poolConfig.ConnConfig.Tracer = NewQueryTracer(DefaultSlowQueryThreshold)
+	return poolConfig, nil
+}
+
+// PostGISVersion returns the version of the PostGIS extension, or an error when it is
+// not installed in the database
+func PostGISVersion(ctx context.Context, conn DB) (string, error) {
+	var version string
+	if err := conn.QueryRow(ctx, "SELECT PostGIS_Version()").Scan(&version); err != nil {
+		return "", fmt.Errorf("failed to query PostGIS version: %w", err)
+	}
+	return version, nil
+}
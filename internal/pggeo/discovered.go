@@ -2,6 +2,7 @@ package pggeo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -22,12 +23,13 @@ type DiscoveredCoverageStatus struct {
 	Message              string     `json:"message,omitempty"`
 }
 
-func RebuildDiscoveredCoverage(ctx context.Context, conn *pgx.Conn, athleteID int64, sampleDistanceMeters, radiusMeters float64) (*DiscoveredCoverageStatus, error) {
+func RebuildDiscoveredCoverage(ctx context.Context, conn DB, athleteID int64, sampleDistanceMeters, radiusMeters float64) (*DiscoveredCoverageStatus, error) {
+	defer timeQuery("rebuild_discovered_coverage", time.Now())
 	if sampleDistanceMeters <= 0 {
-		return nil, fmt.Errorf("sample distance must be positive")
+		return nil, invalidInputf("sample distance must be positive")
 	}
 	if radiusMeters <= 0 {
-		return nil, fmt.Errorf("reveal radius must be positive")
+		return nil, invalidInputf("reveal radius must be positive")
 	}
 
 	tx, err := conn.Begin(ctx)
@@ -50,7 +52,7 @@ func RebuildDiscoveredCoverage(ctx context.Context, conn *pgx.Conn, athleteID in
 			FLOOR(COALESCE(p.cumulative_distance, 0) / $2)::BIGINT AS bucket,
 			ROW_NUMBER() OVER (PARTITION BY p.activity_id ORDER BY p.point_index) AS rn,
 			COUNT(*) OVER (PARTITION BY p.activity_id) AS total
-		FROM point_samples p
+		FROM point_sample_rows p
 		JOIN activity_summaries s ON s.id = p.activity_id AND s.athlete_id = p.athlete_id
 		WHERE p.athlete_id = $1
 			AND LOWER(COALESCE(s.type, '') || ' ' || COALESCE(s.sport_type, '')) ~ '(ride|bike|cycling)'
@@ -154,7 +156,7 @@ func RebuildDiscoveredCoverage(ctx context.Context, conn *pgx.Conn, athleteID in
 	return GetDiscoveredCoverageStatus(ctx, conn, athleteID, sampleDistanceMeters, radiusMeters)
 }
 
-func MarkDiscoveredCoverageStale(ctx context.Context, conn *pgx.Conn, athleteID int64) error {
+func MarkDiscoveredCoverageStale(ctx context.Context, conn DB, athleteID int64) error {
 	_, err := conn.Exec(ctx, `
 		UPDATE discovered_coverage_cache
 		SET stale = TRUE, updated_at = NOW()
@@ -163,7 +165,7 @@ func MarkDiscoveredCoverageStale(ctx context.Context, conn *pgx.Conn, athleteID
 	return err
 }
 
-func GetDiscoveredCoverageStatus(ctx context.Context, conn *pgx.Conn, athleteID int64, sampleDistanceMeters, radiusMeters float64) (*DiscoveredCoverageStatus, error) {
+func GetDiscoveredCoverageStatus(ctx context.Context, conn DB, athleteID int64, sampleDistanceMeters, radiusMeters float64) (*DiscoveredCoverageStatus, error) {
 	buildable, err := countBuildableBikeActivities(ctx, conn, athleteID)
 	if err != nil {
 		return nil, err
@@ -198,7 +200,7 @@ func GetDiscoveredCoverageStatus(ctx context.Context, conn *pgx.Conn, athleteID
 		WHERE athlete_id = $1
 	`, athleteID).Scan(&cachedSample, &cachedRadius, &status.CachedActivities, &stale, &rebuiltAt, &minLng, &minLat, &maxLng, &maxLat)
 	if err != nil {
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return status, nil
 		}
 		return nil, fmt.Errorf("load discovered coverage status: %w", err)
@@ -225,7 +227,7 @@ func GetDiscoveredCoverageStatus(ctx context.Context, conn *pgx.Conn, athleteID
 	return status, nil
 }
 
-func GetDiscoveredFogFeatureCollection(ctx context.Context, conn *pgx.Conn, athleteID int64, minLng, minLat, maxLng, maxLat, sampleDistanceMeters, radiusMeters float64) (string, error) {
+func GetDiscoveredFogFeatureCollection(ctx context.Context, conn DB, athleteID int64, minLng, minLat, maxLng, maxLat, sampleDistanceMeters, radiusMeters float64) (string, error) {
 	query := `
 	WITH viewport AS (
 		SELECT ST_MakeEnvelope($2, $3, $4, $5, 4326) AS geom
@@ -269,7 +271,7 @@ func GetDiscoveredFogFeatureCollection(ctx context.Context, conn *pgx.Conn, athl
 	return featureCollection, nil
 }
 
-func GetDiscoveredCoverageFeatureCollection(ctx context.Context, conn *pgx.Conn, athleteID int64, minLng, minLat, maxLng, maxLat, sampleDistanceMeters, radiusMeters float64) (string, error) {
+func GetDiscoveredCoverageFeatureCollection(ctx context.Context, conn DB, athleteID int64, minLng, minLat, maxLng, maxLat, sampleDistanceMeters, radiusMeters float64) (string, error) {
 	query := `
 	WITH viewport AS (
 		SELECT ST_MakeEnvelope($2, $3, $4, $5, 4326) AS geom
@@ -311,13 +313,13 @@ func GetDiscoveredCoverageFeatureCollection(ctx context.Context, conn *pgx.Conn,
 	return featureCollection, nil
 }
 
-func countBuildableBikeActivities(ctx context.Context, conn *pgx.Conn, athleteID int64) (int, error) {
+func countBuildableBikeActivities(ctx context.Context, conn DB, athleteID int64) (int, error) {
 	query := `
 	SELECT COUNT(*)::INTEGER
 	FROM (
 		SELECT s.id
 		FROM activity_summaries s
-		JOIN point_samples p ON p.activity_id = s.id AND p.athlete_id = s.athlete_id
+		JOIN point_sample_rows p ON p.activity_id = s.id AND p.athlete_id = s.athlete_id
 		WHERE s.athlete_id = $1
 			AND LOWER(COALESCE(s.type, '') || ' ' || COALESCE(s.sport_type, '')) ~ '(ride|bike|cycling)'
 		GROUP BY s.id
@@ -0,0 +1,136 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	// The zone names settings accept must not depend on the host's zoneinfo
+	_ "time/tzdata"
+)
+
+// ActivityLocalTimezone labels times shown in each activity's own local time, from the
+// utc_offset Strava reported where it was recorded
+const ActivityLocalTimezone = "activity_local"
+
+// DisplayZone is the clock activity times are shown and grouped by: Location when set,
+// otherwise each activity's own local time
+type DisplayZone struct {
+	Location *time.Location
+}
+
+// LoadDisplayZone resolves an IANA zone name such as "Europe/Berlin"; an empty name or
+// ActivityLocalTimezone selects activity-local time
+func LoadDisplayZone(name string) (DisplayZone, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == ActivityLocalTimezone {
+		return DisplayZone{}, nil
+	}
+	// "Local" would follow the server's zone, not one the athlete chose
+	if name == "Local" {
+		return DisplayZone{}, invalidInputf("unknown time zone %q", name)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return DisplayZone{}, invalidInputf("unknown time zone %q", name)
+	}
+	return DisplayZone{Location: location}, nil
+}
+
+// Name is the IANA name of the zone, or ActivityLocalTimezone
+func (z DisplayZone) Name() string {
+	if z.Location == nil {
+		return ActivityLocalTimezone
+	}
+	return z.Location.String()
+}
+
+// In returns an activity's start in the zone; activity-local time uses its UTC offset in
+// seconds
+func (z DisplayZone) In(start time.Time, utcOffset float64) time.Time {
+	if z.Location != nil {
+		return start.In(z.Location)
+	}
+	return start.In(time.FixedZone("", int(utcOffset)))
+}
+
+// Format formats an activity's start in the zone, with its offset
+func (z DisplayZone) Format(start time.Time, utcOffset float64) string {
+	if start.IsZero() {
+		return ""
+	}
+	return z.In(start, utcOffset).Format("2006-01-02 15:04 MST")
+}
+
+// wallClock returns t's wall clock in the zone as a UTC time, the way Postgres returns a
+// timestamp without time zone. Activity-local time has no zone of its own for t and
+// stays in UTC.
+func (z DisplayZone) wallClock(t time.Time) time.Time {
+	if z.Location != nil {
+		t = t.In(z.Location)
+	} else {
+		t = t.UTC()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// localStartSQL returns the SQL for the local start of the activity aliased alias as a
+// timestamp without time zone, to group by in place of start_date, appending the zone to
+// args when it takes one
+func (z DisplayZone) localStartSQL(alias string, args []any) (string, []any) {
+	if alias != "" {
+		alias += "."
+	}
+	if z.Location == nil {
+		return fmt.Sprintf("(%sstart_date AT TIME ZONE 'UTC' + make_interval(secs => COALESCE(%sutc_offset, 0)))", alias, alias), args
+	}
+	args = append(args, z.Location.String())
+	return fmt.Sprintf("(%sstart_date AT TIME ZONE $%d)", alias, len(args)), args
+}
+
+// SetAthleteDisplayTimezone stores the zone the athlete's activity times are shown and
+// grouped by; nil clears it, falling back to activity-local time
+func SetAthleteDisplayTimezone(ctx context.Context, conn DB, athleteID int64, name *string) (*AthleteSettings, error) {
+	if name != nil {
+		zone, err := LoadDisplayZone(*name)
+		if err != nil {
+			return nil, err
+		}
+		if zone.Location == nil {
+			name = nil
+		} else {
+			canonical := zone.Name()
+			name = &canonical
+		}
+	}
+	settings := AthleteSettings{AthleteID: athleteID}
+	err := conn.QueryRow(ctx, `
+		INSERT INTO athlete_settings (athlete_id, display_timezone, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (athlete_id) DO UPDATE
+		SET display_timezone = EXCLUDED.display_timezone, updated_at = NOW()
+		RETURNING default_tolerance_m, display_timezone
+	`, athleteID, name).Scan(&settings.DefaultToleranceM, &settings.DisplayTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set athlete display time zone: %w", err)
+	}
+	return &settings, nil
+}
+
+// GetAthleteDisplayZone returns the zone the athlete chose, or activity-local time. A
+// stored zone this build no longer knows also falls back to activity-local time.
+func GetAthleteDisplayZone(ctx context.Context, conn DB, athleteID int64) (DisplayZone, error) {
+	settings, err := GetAthleteSettings(ctx, conn, athleteID)
+	if err != nil {
+		return DisplayZone{}, err
+	}
+	if settings.DisplayTimezone == nil {
+		return DisplayZone{}, nil
+	}
+	zone, err := LoadDisplayZone(*settings.DisplayTimezone)
+	if err != nil {
+		return DisplayZone{}, nil
+	}
+	return zone, nil
+}
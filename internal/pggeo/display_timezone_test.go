@@ -0,0 +1,58 @@
+package pggeo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadDisplayZoneValidatesNames(t *testing.T) {
+	for _, name := range []string{"", " ", ActivityLocalTimezone} {
+		zone, err := LoadDisplayZone(name)
+		if err != nil || zone.Location != nil || zone.Name() != ActivityLocalTimezone {
+			t.Fatalf("LoadDisplayZone(%q) = %v, %v; want activity-local", name, zone.Name(), err)
+		}
+	}
+	zone, err := LoadDisplayZone(" Europe/Berlin ")
+	if err != nil || zone.Name() != "Europe/Berlin" {
+		t.Fatalf("LoadDisplayZone(Europe/Berlin) = %v, %v", zone.Name(), err)
+	}
+	for _, name := range []string{"Local", "Mars/Olympus_Mons", "+02:00", "../etc/passwd"} {
+		if _, err := LoadDisplayZone(name); !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("LoadDisplayZone(%q) = %v, want invalid input", name, err)
+		}
+	}
+}
+
+func TestDisplayZoneFormatsActivityStart(t *testing.T) {
+	start := time.Date(2024, 6, 9, 16, 0, 0, 0, time.UTC)
+	tokyo := 9 * float64(time.Hour/time.Second)
+	if got := (DisplayZone{}).Format(start, tokyo); got != "2024-06-10 01:00 +0900" {
+		t.Fatalf("activity-local start = %q", got)
+	}
+	berlin, err := LoadDisplayZone("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadDisplayZone: %v", err)
+	}
+	if got := berlin.Format(start, tokyo); got != "2024-06-09 18:00 CEST" {
+		t.Fatalf("Berlin start = %q", got)
+	}
+	if got := berlin.Format(time.Time{}, 0); got != "" {
+		t.Fatalf("zero start = %q, want empty", got)
+	}
+}
+
+func TestDisplayZoneLocalStartSQLNumbersItsArgument(t *testing.T) {
+	sql, args := DisplayZone{}.localStartSQL("a", []any{int64(1)})
+	if len(args) != 1 || sql != "(a.start_date AT TIME ZONE 'UTC' + make_interval(secs => COALESCE(a.utc_offset, 0)))" {
+		t.Fatalf("activity-local SQL = %s, %v", sql, args)
+	}
+	berlin, err := LoadDisplayZone("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadDisplayZone: %v", err)
+	}
+	sql, args = berlin.localStartSQL("", []any{int64(1), "x"})
+	if sql != "(start_date AT TIME ZONE $3)" || len(args) != 3 || args[2] != "Europe/Berlin" {
+		t.Fatalf("Berlin SQL = %s, %v", sql, args)
+	}
+}
@@ -0,0 +1,131 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+
+	"b11k/internal/geo"
+)
+
+// distanceBackfillBatch is how many point samples one backfill UPDATE writes
+const distanceBackfillBatch = 5000
+
+// DistanceBackfillResult counts what BackfillCumulativeDistance filled in
+type DistanceBackfillResult struct {
+	Activities int   `json:"activities"`
+	Points     int64 `json:"points"`
+}
+
+// BackfillCumulativeDistance fills in the cumulative_distance of point samples stored
+// before the column existed; graphs and comparisons need it for their distance axis.
+// Each activity's samples are summed in point_index order with the haversine distance,
+// as on insert, and only the NULL ones are written, in batches of distanceBackfillBatch
+// inside one transaction per activity. athleteID of zero backfills every athlete.
+func BackfillCumulativeDistance(ctx context.Context, conn DB, athleteID int64) (*DistanceBackfillResult, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT DISTINCT activity_id FROM point_sample_rows
+		WHERE cumulative_distance IS NULL AND ($1 = 0 OR athlete_id = $1)
+		ORDER BY activity_id
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find activities without cumulative distance: %w", err)
+	}
+	var activityIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan activity ID: %w", err)
+		}
+		activityIDs = append(activityIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find activities without cumulative distance: %w", err)
+	}
+
+	result := &DistanceBackfillResult{}
+	for _, activityID := range activityIDs {
+		points, err := backfillActivityDistance(ctx, conn, activityID)
+		if err != nil {
+			return result, err
+		}
+		result.Activities++
+		result.Points += points
+		logger().Info("Backfilled cumulative distance", "activity_id", activityID, "points", points)
+	}
+	return result, nil
+}
+
+// backfillActivityDistance backfills one activity and returns the points it updated
+func backfillActivityDistance(ctx context.Context, conn DB, activityID int64) (int64, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT point_index, ST_Y(location::geometry), ST_X(location::geometry), cumulative_distance IS NULL
+		FROM point_sample_rows
+		WHERE activity_id = $1 AND location IS NOT NULL
+		ORDER BY point_index
+	`, activityID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load points of activity %d: %w", activityID, err)
+	}
+	var pointIndexes []int
+	var lats, lngs []float64
+	var missing int64
+	for rows.Next() {
+		var pointIndex int
+		var lat, lng float64
+		var isMissing bool
+		if err := rows.Scan(&pointIndex, &lat, &lng, &isMissing); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan point of activity %d: %w", activityID, err)
+		}
+		pointIndexes = append(pointIndexes, pointIndex)
+		lats = append(lats, lat)
+		lngs = append(lngs, lng)
+		if isMissing {
+			missing++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to load points of activity %d: %w", activityID, err)
+	}
+	distances := cumulativeDistances(lats, lngs)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for start := 0; start < len(pointIndexes); start += distanceBackfillBatch {
+		end := min(start+distanceBackfillBatch, len(pointIndexes))
+		_, err := tx.Exec(ctx, `
+			UPDATE point_samples p
+			SET cumulative_distance = b.distance
+			FROM UNNEST($2::integer[], $3::double precision[]) AS b(point_index, distance)
+			WHERE p.activity_id = $1 AND p.point_index = b.point_index AND p.cumulative_distance IS NULL
+		`, activityID, pointIndexes[start:end], distances[start:end])
+		if err != nil {
+			return 0, fmt.Errorf("failed to backfill cumulative distance of activity %d: %w", activityID, err)
+		}
+	}
+	// An activity stored as a stream is filled in one statement
+	if err := updatePointStream(ctx, tx, activityID, "cumulative_distances", pointIndexes, distances, true); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit backfilled activity %d: %w", activityID, err)
+	}
+	// Only NULL distances are written, in whichever layout holds the activity
+	return missing, nil
+}
+
+// cumulativeDistances returns the running haversine distance along the points in meters
+func cumulativeDistances(lats, lngs []float64) []float64 {
+	distances := make([]float64, len(lats))
+	for i := 1; i < len(lats); i++ {
+		distances[i] = distances[i-1] + geo.HaversineMeters(lats[i-1], lngs[i-1], lats[i], lngs[i])
+	}
+	return distances
+}
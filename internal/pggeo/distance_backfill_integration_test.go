@@ -0,0 +1,65 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/strava"
+)
+
+func TestBackfillCumulativeDistanceFillsNullSamples(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000784), int64(990000784101)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID:        activityID,
+		AthleteID: athleteID,
+		Name:      "Synced long ago",
+		Type:      "Ride",
+		SportType: "Ride",
+		StartDate: "2019-06-01T07:00:00Z",
+	}}
+	start := time.Date(2019, 6, 1, 7, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		activity.TimeStream.Data = append(activity.TimeStream.Data, start.Add(time.Duration(i)*time.Second))
+		activity.LatLngStream.Data = append(activity.LatLngStream.Data, []float64{47.0 + float64(i)*0.0001, 8.0})
+	}
+	if err := InsertBikeActivityUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertBikeActivityUpsert: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `UPDATE point_samples SET cumulative_distance = NULL WHERE activity_id = $1`, activityID); err != nil {
+		t.Fatalf("clear cumulative distance: %v", err)
+	}
+
+	result, err := BackfillCumulativeDistance(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("BackfillCumulativeDistance: %v", err)
+	}
+	if result.Activities != 1 || result.Points != 20 {
+		t.Fatalf("result = %+v, want 1 activity and 20 points", result)
+	}
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetPointSamplesForActivity: %v", err)
+	}
+	want := geo.HaversineMeters(47.0, 8.0, 47.0019, 8.0)
+	last := samples[len(samples)-1].CumulativeDistance
+	if last == nil || math.Abs(*last-want) > 0.5 {
+		t.Fatalf("last cumulative distance = %v, want %.1f m", last, want)
+	}
+
+	again, err := BackfillCumulativeDistance(ctx, conn, athleteID)
+	if err != nil || again.Activities != 0 || again.Points != 0 {
+		t.Fatalf("second backfill = %+v, %v; want nothing left to do", again, err)
+	}
+}
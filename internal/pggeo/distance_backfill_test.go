@@ -0,0 +1,24 @@
+package pggeo
+
+import (
+	"math"
+	"testing"
+
+	"b11k/internal/geo"
+)
+
+func TestCumulativeDistancesSumTheLegs(t *testing.T) {
+	lats := []float64{47.0, 47.001, 47.001, 47.002}
+	lngs := []float64{8.0, 8.0, 8.0, 8.0}
+	distances := cumulativeDistances(lats, lngs)
+	leg := geo.HaversineMeters(47.0, 8.0, 47.001, 8.0)
+	want := []float64{0, leg, leg, leg + geo.HaversineMeters(47.001, 8.0, 47.002, 8.0)}
+	for i := range want {
+		if math.Abs(distances[i]-want[i]) > 1e-9 {
+			t.Fatalf("distances = %v, want %v", distances, want)
+		}
+	}
+	if len(cumulativeDistances(nil, nil)) != 0 {
+		t.Fatal("no points should give no distances")
+	}
+}
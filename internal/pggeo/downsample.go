@@ -0,0 +1,62 @@
+package pggeo
+
+import "math"
+
+// DownsampleGraphSeries reduces series to exactly maxPoints points with the
+// largest-triangle-three-buckets algorithm (Steinarsson, 2013), keyed on time. The first
+// and last points are kept, and each bucket in between keeps the point forming the largest
+// triangle with its neighbours' picks, so spikes and dips survive where an average would
+// flatten them. Series already within maxPoints, or maxPoints < 3, are returned unchanged.
+func DownsampleGraphSeries(series []GraphDataPoint, maxPoints int) []GraphDataPoint {
+	if maxPoints < 3 || len(series) <= maxPoints {
+		return series
+	}
+
+	origin := series[0].Time
+	x := func(i int) float64 { return series[i].Time.Sub(origin).Seconds() }
+
+	sampled := make([]GraphDataPoint, 0, maxPoints)
+	sampled = append(sampled, series[0])
+	// Buckets cover the points between the first and the last
+	bucketSize := float64(len(series)-2) / float64(maxPoints-2)
+	picked := 0
+	for bucket := 0; bucket < maxPoints-2; bucket++ {
+		start := int(float64(bucket)*bucketSize) + 1
+		end := int(float64(bucket+1)*bucketSize) + 1
+
+		// The next bucket is represented by its average; the last one by the final point
+		nextStart, nextEnd := end, int(float64(bucket+2)*bucketSize)+1
+		if nextEnd > len(series)-1 {
+			nextStart, nextEnd = len(series)-1, len(series)
+		}
+		var avgX, avgY float64
+		for i := nextStart; i < nextEnd; i++ {
+			avgX += x(i)
+			avgY += series[i].Value
+		}
+		avgX /= float64(nextEnd - nextStart)
+		avgY /= float64(nextEnd - nextStart)
+
+		ax, ay := x(picked), series[picked].Value
+		best, bestArea := start, -1.0
+		for i := start; i < end; i++ {
+			area := math.Abs((ax-avgX)*(series[i].Value-ay) - (ax-x(i))*(avgY-ay))
+			if area > bestArea {
+				best, bestArea = i, area
+			}
+		}
+		sampled = append(sampled, series[best])
+		picked = best
+	}
+	return append(sampled, series[len(series)-1])
+}
+
+// Downsample applies DownsampleGraphSeries to each metric series
+func (g *GraphData) Downsample(maxPoints int) {
+	g.Speed = DownsampleGraphSeries(g.Speed, maxPoints)
+	g.Heartrate = DownsampleGraphSeries(g.Heartrate, maxPoints)
+	g.Height = DownsampleGraphSeries(g.Height, maxPoints)
+	g.Cadence = DownsampleGraphSeries(g.Cadence, maxPoints)
+	g.Watts = DownsampleGraphSeries(g.Watts, maxPoints)
+	g.Grade = DownsampleGraphSeries(g.Grade, maxPoints)
+}
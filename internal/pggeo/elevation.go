@@ -0,0 +1,96 @@
+package pggeo
+
+import "sort"
+
+// ElevationGainOptions tunes ComputeElevationGain
+type ElevationGainOptions struct {
+	// MedianWindow is the number of altitude samples in the moving median, centred on
+	// each sample; values below 2 disable smoothing
+	MedianWindow int
+	// ThresholdM is the hysteresis: the profile has to move this far against the
+	// current trend before a climb or descent is counted
+	ThresholdM float64
+}
+
+// DefaultElevationGainOptions suits barometric altimeters recording every second or so
+var DefaultElevationGainOptions = ElevationGainOptions{MedianWindow: 5, ThresholdM: 3}
+
+// ComputeElevationGain returns the climbing and descending along the samples in metres.
+// Altitudes are first smoothed with a moving median, then a climb only counts once the
+// profile has turned down by opts.ThresholdM from its top (and a descent once it has
+// turned back up), so sensor noise below the threshold adds nothing while real climbs
+// count in full. ok is false when fewer than two samples have an altitude.
+func ComputeElevationGain(samples []PointSample, opts ElevationGainOptions) (gain, loss float64, ok bool) {
+	var altitudes []float64
+	for _, sample := range samples {
+		if sample.Altitude != nil {
+			altitudes = append(altitudes, *sample.Altitude)
+		}
+	}
+	if len(altitudes) < 2 {
+		return 0, 0, false
+	}
+	if opts.MedianWindow >= 2 {
+		altitudes = movingMedian(altitudes, opts.MedianWindow)
+	}
+
+	const (
+		undecided = iota
+		climbing
+		descending
+	)
+	trend := undecided
+	low, high := altitudes[0], altitudes[0]
+	var anchor, extreme float64 // last turning point, and the furthest point since
+	for _, altitude := range altitudes[1:] {
+		switch trend {
+		case undecided:
+			low, high = min(low, altitude), max(high, altitude)
+			if altitude-low >= opts.ThresholdM {
+				trend, anchor, extreme = climbing, low, altitude
+			} else if high-altitude >= opts.ThresholdM {
+				trend, anchor, extreme = descending, high, altitude
+			}
+		case climbing:
+			if altitude > extreme {
+				extreme = altitude
+			} else if extreme-altitude >= opts.ThresholdM {
+				gain += extreme - anchor
+				trend, anchor, extreme = descending, extreme, altitude
+			}
+		case descending:
+			if altitude < extreme {
+				extreme = altitude
+			} else if altitude-extreme >= opts.ThresholdM {
+				loss += anchor - extreme
+				trend, anchor, extreme = climbing, extreme, altitude
+			}
+		}
+	}
+	switch trend {
+	case climbing:
+		gain += extreme - anchor
+	case descending:
+		loss += anchor - extreme
+	}
+	return gain, loss, true
+}
+
+// movingMedian replaces each value with the median of the window values centred on it;
+// the window shrinks at both ends
+func movingMedian(values []float64, window int) []float64 {
+	half := window / 2
+	result := make([]float64, len(values))
+	buf := make([]float64, 0, window+1)
+	for i := range values {
+		lo, hi := max(0, i-half), min(len(values), i+half+1)
+		buf = append(buf[:0], values[lo:hi]...)
+		sort.Float64s(buf)
+		if n := len(buf); n%2 == 1 {
+			result[i] = buf[n/2]
+		} else {
+			result[i] = (buf[n/2-1] + buf[n/2]) / 2
+		}
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package pggeo
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// altitudeSamples builds one sample per altitude
+func altitudeSamples(altitudes []float64) []PointSample {
+	samples := make([]PointSample, len(altitudes))
+	for i := range altitudes {
+		samples[i] = PointSample{PointIndex: i, Altitude: &altitudes[i]}
+	}
+	return samples
+}
+
+// noisyProfile samples profile at n points and adds barometric-style noise: ±0.2 m
+// jitter on every sample plus occasional 1.5 m wobbles lasting a few seconds
+func noisyProfile(n int, profile func(i int) float64) []float64 {
+	rng := rand.New(rand.NewSource(779))
+	altitudes := make([]float64, n)
+	wobble, wobbleLeft := 0.0, 0
+	for i := range altitudes {
+		if wobbleLeft == 0 && rng.Intn(60) == 0 {
+			wobble, wobbleLeft = 1.5*(2*rng.Float64()-1), 3+rng.Intn(5)
+		}
+		if wobbleLeft > 0 {
+			wobbleLeft--
+		} else {
+			wobble = 0
+		}
+		altitudes[i] = profile(i) + wobble + 0.2*math.Round(2*rng.Float64()-1)
+	}
+	return altitudes
+}
+
+func naiveGain(altitudes []float64) float64 {
+	gain := 0.0
+	for i := 1; i < len(altitudes); i++ {
+		gain += max(0, altitudes[i]-altitudes[i-1])
+	}
+	return gain
+}
+
+func TestComputeElevationGainIgnoresNoiseOnAFlatCommute(t *testing.T) {
+	// 40 minutes on the flat
+	altitudes := noisyProfile(2400, func(int) float64 { return 120 })
+	if naive := naiveGain(altitudes); naive < 80 {
+		t.Fatalf("fixture too quiet: naive gain %.1f m", naive)
+	}
+
+	gain, loss, ok := ComputeElevationGain(altitudeSamples(altitudes), DefaultElevationGainOptions)
+	if !ok || gain > 3 || loss > 3 {
+		t.Fatalf("flat commute gain/loss = %.1f/%.1f m (ok %v), want under 3 m", gain, loss, ok)
+	}
+}
+
+func TestComputeElevationGainCountsRealClimbs(t *testing.T) {
+	// Flat, a 60 m climb at 6%, a 25 m descent, a 10 m rise, then flat again; 1 m per sample
+	profile := func(i int) float64 {
+		switch {
+		case i < 500:
+			return 200
+		case i < 1500:
+			return 200 + 0.06*float64(i-500)
+		case i < 2000:
+			return 260 - 0.05*float64(i-1500)
+		case i < 2200:
+			return 235 + 0.05*float64(i-2000)
+		default:
+			return 245
+		}
+	}
+	altitudes := noisyProfile(3000, profile)
+
+	gain, loss, ok := ComputeElevationGain(altitudeSamples(altitudes), DefaultElevationGainOptions)
+	if !ok || math.Abs(gain-70) > 4 || math.Abs(loss-25) > 4 {
+		t.Fatalf("gain/loss = %.1f/%.1f m (ok %v), want about 70/25 m", gain, loss, ok)
+	}
+}
+
+func TestComputeElevationGainHysteresis(t *testing.T) {
+	// Rolling 2 m bumps stay under the 3 m threshold; one 4 m bump does not
+	altitudes := []float64{100, 102, 100, 102, 100, 104, 100}
+	opts := ElevationGainOptions{ThresholdM: 3}
+	if gain, loss, _ := ComputeElevationGain(altitudeSamples(altitudes), opts); gain != 4 || loss != 4 {
+		t.Fatalf("gain/loss = %v/%v, want 4/4", gain, loss)
+	}
+
+	missing := []PointSample{{PointIndex: 0}, {PointIndex: 1, Altitude: &altitudes[0]}}
+	if _, _, ok := ComputeElevationGain(missing, DefaultElevationGainOptions); ok {
+		t.Fatal("one altitude sample was measured")
+	}
+}
@@ -0,0 +1,72 @@
+package pggeo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of domain error. Functions that read, change or delete a row by its key return
+// an *Error of one of these kinds, so callers tell them apart with errors.Is instead of
+// matching message text. Lookups documented to return nil when nothing is stored, such
+// as GetAthlete, keep doing so.
+var (
+	// ErrNotFound is a row that does not exist, or not for the athlete asking
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is an insert colliding with a stored row
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrInvalidInput is an argument refused before it reached the database
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrForeignAthlete is a write to a row stored for another athlete
+	ErrForeignAthlete = errors.New("belongs to another athlete")
+)
+
+// Not found errors of the main rows, each also ErrNotFound. Lookups of an activity or a
+// favorite segment by ID or name return them, so callers check for the missing row
+// with errors.Is(err, ErrActivityNotFound) and tell it apart from a database failure.
+var (
+	ErrActivityNotFound error = &Error{Kind: ErrNotFound, Msg: "activity not found"}
+	ErrSegmentNotFound  error = &Error{Kind: ErrNotFound, Msg: "segment not found"}
+)
+
+// Error is a domain error. Kind is one of the kinds above and Err the underlying cause,
+// such as pgx.ErrNoRows, or nil; errors.Is matches both.
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func notFoundf(cause error, format string, args ...any) error {
+	return &Error{Kind: ErrNotFound, Msg: fmt.Sprintf(format, args...), Err: cause}
+}
+
+func activityNotFoundf(cause error, format string, args ...any) error {
+	return &Error{Kind: ErrActivityNotFound, Msg: fmt.Sprintf(format, args...), Err: cause}
+}
+
+func segmentNotFoundf(cause error, format string, args ...any) error {
+	return &Error{Kind: ErrSegmentNotFound, Msg: fmt.Sprintf(format, args...), Err: cause}
+}
+
+func alreadyExistsf(cause error, format string, args ...any) error {
+	return &Error{Kind: ErrAlreadyExists, Msg: fmt.Sprintf(format, args...), Err: cause}
+}
+
+func invalidInputf(format string, args ...any) error {
+	return &Error{Kind: ErrInvalidInput, Msg: fmt.Sprintf(format, args...)}
+}
+
+func foreignAthletef(format string, args ...any) error {
+	return &Error{Kind: ErrForeignAthlete, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,124 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestNotFoundErrorsMatchErrNotFound(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID, segmentID = int64(990000783), int64(990000783001), int64(990000783)
+	route := [][]float64{{47.0, 8.0}, {47.01, 8.0}}
+
+	cases := []struct {
+		name string
+		call func() error
+		// noRows is set when the error also wraps pgx.ErrNoRows from a scan
+		noRows bool
+	}{
+		{"GetActivityByID", func() error {
+			_, err := GetActivityByID(ctx, conn, athleteID, activityID)
+			return err
+		}, true},
+		{"SetActivityNotes", func() error { return SetActivityNotes(ctx, conn, athleteID, activityID, "notes") }, false},
+		{"SetActivityPinned", func() error { return SetActivityPinned(ctx, conn, athleteID, activityID, true) }, false},
+		{"SetActivityVisibility", func() error {
+			return SetActivityVisibility(ctx, conn, athleteID, activityID, ActivityVisibilityInstance)
+		}, false},
+		{"ReplaceActivityGrades", func() error {
+			return ReplaceActivityGrades(ctx, conn, athleteID, activityID, []int{0}, []float64{1})
+		}, false},
+		{"CompareActivities", func() error {
+			_, err := CompareActivities(ctx, conn, athleteID, [2]int64{activityID, activityID}, nil, 10)
+			return err
+		}, false},
+		{"HealActivityPoints", func() error { return HealActivityPoints(ctx, conn, athleteID, activityID, nil, 1, 10) }, false},
+		{"InsertActivityGeometry", func() error { return InsertActivityGeometry(ctx, conn, athleteID, activityID, route) }, false},
+		{"InsertPointSamples", func() error {
+			return InsertPointSamples(ctx, conn, &strava.BikeActivity{Summary: strava.ActivitySummary{ID: activityID, AthleteID: athleteID}})
+		}, false},
+		{"GetFavoriteSegment", func() error {
+			_, err := GetFavoriteSegment(ctx, conn, athleteID, segmentID)
+			return err
+		}, true},
+		{"GetFavoriteSegmentByName", func() error {
+			_, err := GetFavoriteSegmentByName(ctx, conn, athleteID, "Nowhere")
+			return err
+		}, true},
+		{"UpdateFavoriteSegment", func() error {
+			_, err := UpdateFavoriteSegment(ctx, conn, athleteID, segmentID, "Nowhere", "", route, nil)
+			return err
+		}, true},
+		{"UpdateFavoriteSegmentDetails", func() error {
+			_, err := UpdateFavoriteSegmentDetails(ctx, conn, athleteID, segmentID, "Nowhere", "")
+			return err
+		}, true},
+		{"DeleteFavoriteSegment", func() error { return DeleteFavoriteSegment(ctx, conn, athleteID, segmentID) }, false},
+		{"SetSegmentPinned", func() error { return SetSegmentPinned(ctx, conn, athleteID, segmentID, true) }, false},
+		{"SetSegmentDefaultTolerance", func() error {
+			_, err := SetSegmentDefaultTolerance(ctx, conn, athleteID, segmentID, nil)
+			return err
+		}, false},
+		{"FindRoutePartsMatchingSegmentByName", func() error {
+			_, err := FindRoutePartsMatchingSegmentByName(ctx, conn, athleteID, "Nowhere", 15)
+			return err
+		}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("error = %v, want ErrNotFound", err)
+			}
+			var domainErr *Error
+			if !errors.As(err, &domainErr) || domainErr.Kind != ErrNotFound {
+				t.Fatalf("error %v does not unwrap to a not found *Error", err)
+			}
+			if tc.noRows && !errors.Is(err, pgx.ErrNoRows) {
+				t.Fatalf("error %v does not wrap pgx.ErrNoRows", err)
+			}
+		})
+	}
+}
+
+func TestUpsertRefusesAnotherAthletesActivity(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000784), int64(990000784001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	activity := &strava.ActivitySummary{ID: activityID, AthleteID: athleteID, Name: "Mine", Type: "Ride", SportType: "Ride", StartDate: "2024-05-02T07:00:00Z"}
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+	}
+	if err := InsertActivitySummary(ctx, conn, activity); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("second insert error = %v, want ErrAlreadyExists", err)
+	}
+
+	taken := *activity
+	taken.AthleteID, taken.Name = athleteID+1, "Theirs"
+	if err := InsertActivitySummaryUpsert(ctx, conn, &taken); !errors.Is(err, ErrForeignAthlete) {
+		t.Fatalf("upsert for another athlete error = %v, want ErrForeignAthlete", err)
+	}
+	stored, err := GetActivityByID(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetActivityByID: %v", err)
+	}
+	if stored.Name != "Mine" {
+		t.Fatalf("name = %q, the other athlete's upsert overwrote the activity", stored.Name)
+	}
+
+	if err := SetActivityVisibility(ctx, conn, athleteID, activityID, "everyone"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("invalid visibility error = %v, want ErrInvalidInput", err)
+	}
+}
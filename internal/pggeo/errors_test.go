@@ -0,0 +1,59 @@
+package pggeo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestErrorMatchesKindAndCause(t *testing.T) {
+	err := fmt.Errorf("failed to load: %w", notFoundf(pgx.ErrNoRows, "activity with ID %d not found", 7))
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("%v: want both ErrNotFound and pgx.ErrNoRows", err)
+	}
+	if errors.Is(err, ErrAlreadyExists) || errors.Is(err, ErrInvalidInput) || errors.Is(err, ErrForeignAthlete) {
+		t.Fatalf("%v matches another kind", err)
+	}
+	var domainErr *Error
+	if !errors.As(err, &domainErr) || domainErr.Msg != "activity with ID 7 not found" {
+		t.Fatalf("errors.As = %+v, want the not found *Error", domainErr)
+	}
+	if got := err.Error(); got != "failed to load: activity with ID 7 not found" {
+		t.Fatalf("message = %q", got)
+	}
+
+	if err := invalidInputf("invalid visibility %q", "everyone"); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("%v: want ErrInvalidInput", err)
+	}
+	if err := fmt.Errorf("%w: %q", ErrSegmentNameExists, "Climb"); !errors.Is(err, ErrAlreadyExists) || !errors.Is(err, ErrSegmentNameExists) {
+		t.Fatalf("%v: want ErrAlreadyExists and ErrSegmentNameExists", err)
+	}
+	if !errors.Is(ErrSegmentNameAmbiguous, ErrInvalidInput) {
+		t.Fatal("ErrSegmentNameAmbiguous is not ErrInvalidInput")
+	}
+}
+
+func TestMissingRowsHaveTheirOwnErrors(t *testing.T) {
+	activity := fmt.Errorf("failed to load: %w", activityNotFoundf(pgx.ErrNoRows, "activity with ID %d not found", 7))
+	if !errors.Is(activity, ErrActivityNotFound) || !errors.Is(activity, ErrNotFound) || !errors.Is(activity, pgx.ErrNoRows) {
+		t.Fatalf("%v: want ErrActivityNotFound, ErrNotFound and pgx.ErrNoRows", activity)
+	}
+	if errors.Is(activity, ErrSegmentNotFound) {
+		t.Fatalf("%v matches ErrSegmentNotFound", activity)
+	}
+	if got := activity.Error(); got != "failed to load: activity with ID 7 not found" {
+		t.Fatalf("message = %q", got)
+	}
+
+	segment := segmentNotFoundf(nil, "segment with ID %d not found", 3)
+	if !errors.Is(segment, ErrSegmentNotFound) || !errors.Is(segment, ErrNotFound) || errors.Is(segment, ErrActivityNotFound) {
+		t.Fatalf("%v: want only ErrSegmentNotFound and ErrNotFound", segment)
+	}
+
+	// A database failure is no missing row
+	if broken := fmt.Errorf("failed to get activity: %w", errors.New("connection reset")); errors.Is(broken, ErrActivityNotFound) || errors.Is(broken, ErrNotFound) {
+		t.Fatalf("%v matches a not found error", broken)
+	}
+}
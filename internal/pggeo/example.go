@@ -73,14 +73,14 @@ func ExampleUsage() {
 	fmt.Printf("✅ Found %d activities in date range\n", len(activities))
 
 	// Example: Query activities in a bounding box (example: San Francisco area)
-	activities, err = GetActivitiesInBoundingBox(ctx, conn, 37.7, -122.5, 37.8, -122.4)
+	activities, err = GetActivitiesInBoundingBox(ctx, conn, athleteID, 37.7, -122.5, 37.8, -122.4)
 	if err != nil {
 		log.Fatal("Failed to query activities in bounding box:", err)
 	}
 	fmt.Printf("✅ Found %d activities in bounding box\n", len(activities))
 
 	// Example: Find activities near a specific point
-	nearResults, err := FindActivitiesNear(ctx, conn, -122.4194, 37.7749, 1000) // 1km radius
+	nearResults, err := FindActivitiesNear(ctx, conn, athleteID, -122.4194, 37.7749, 1000) // 1km radius
 	if err != nil {
 		log.Fatal("Failed to find activities near point:", err)
 	}
@@ -88,7 +88,7 @@ func ExampleUsage() {
 
 	// Example: Find activities intersecting a line (example route)
 	lineWKT := "LINESTRING(-122.4194 37.7749, -122.4094 37.7849)"
-	intersectionResults, err := FindActivitiesIntersectingLine(ctx, conn, lineWKT, 50) // 50m tolerance
+	intersectionResults, err := FindActivitiesIntersectingLine(ctx, conn, athleteID, lineWKT, 50) // 50m tolerance
 	if err != nil {
 		log.Fatal("Failed to find activities intersecting line:", err)
 	}
@@ -117,14 +117,14 @@ func ExampleUsage() {
 	}
 	// Example athlete ID (in real usage, get from authenticated user)
 	exampleAthleteID := int64(12345)
-	segment, err := InsertFavoriteSegment(ctx, conn, exampleAthleteID, "Golden Gate Segment", "A test segment in San Francisco", segmentPoints, nil)
+	segment, err := InsertFavoriteSegment(ctx, conn, exampleAthleteID, "Golden Gate Segment", "A test segment in San Francisco", segmentPoints, nil, nil)
 	if err != nil {
 		log.Fatal("Failed to create favorite segment:", err)
 	}
 	fmt.Printf("✅ Created favorite segment: %s (ID: %d)\n", segment.Name, segment.ID)
 
 	// Example: Find route parts matching the segment
-	matches, err := FindRoutePartsMatchingSegment(ctx, conn, segment.ID, 50) // 50m tolerance
+	matches, err := FindRoutePartsMatchingSegment(ctx, conn, exampleAthleteID, segment.ID, 50) // 50m tolerance
 	if err != nil {
 		log.Fatal("Failed to find matching route parts:", err)
 	}
@@ -138,7 +138,7 @@ func ExampleUsage() {
 	fmt.Printf("✅ Found %d favorite segments\n", len(segments))
 
 	// Example: Find route parts matching segment by name
-	matchesByName, err := FindRoutePartsMatchingSegmentByName(ctx, conn, "Golden Gate Segment", 100) // 100m tolerance
+	matchesByName, err := FindRoutePartsMatchingSegmentByName(ctx, conn, exampleAthleteID, "Golden Gate Segment", 100) // 100m tolerance
 	if err != nil {
 		log.Fatal("Failed to find matching route parts by name:", err)
 	}
@@ -0,0 +1,294 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ExploredBufferMeters is how close to an earlier route a stretch of road counts as
+// already ridden
+const ExploredBufferMeters = 25.0
+
+// exploredKey orders activities the way exploration sees them: by start, then by ID
+type exploredKey struct {
+	StartDate  time.Time
+	ActivityID int64
+}
+
+func (k exploredKey) before(other exploredKey) bool {
+	if !k.StartDate.Equal(other.StartDate) {
+		return k.StartDate.Before(other.StartDate)
+	}
+	return k.ActivityID < other.ActivityID
+}
+
+// exploredAfter returns the bind values of an optional lower bound; a NULL start means
+// "from the first activity"
+func exploredAfter(after *exploredKey) (*time.Time, int64) {
+	if after == nil {
+		return nil, 0
+	}
+	return &after.StartDate, after.ActivityID
+}
+
+// exploredBufferSQL is the buffered simplified route of activity_geometries g
+const exploredBufferSQL = `ST_Buffer(COALESCE(g.route_geog_simplified, g.route_geog), $3)::geometry`
+
+// measureNewDistanceQuery measures the route of activity $2 outside the explored area:
+// the stored area clipped to the route's surroundings when $7 is set, plus the buffered
+// routes of the activities after ($5, $6) and before ($4, $2). Both parts are limited to
+// routes whose bounding box meets the buffered route's.
+const measureNewDistanceQuery = `
+WITH route AS (
+	SELECT g.route_geog::geometry AS geom,
+		   ST_Envelope(ST_Buffer(g.route_geog, $3)::geometry) AS env
+	FROM activity_geometries g
+	WHERE g.activity_id = $2 AND g.athlete_id = $1
+),
+prior AS (
+	SELECT ST_CollectionExtract(ST_Intersection(a.area_geom, route.env), 3) AS geom
+	FROM explored_area a, route
+	WHERE $7 AND a.athlete_id = $1 AND a.area_geom && route.env
+	UNION ALL
+	SELECT ` + exploredBufferSQL + `
+	FROM activity_geometries g
+	JOIN activity_summaries s ON s.id = g.activity_id
+	CROSS JOIN route
+	WHERE s.athlete_id = $1
+	  AND g.route_bbox_geom && route.env
+	  AND (s.start_date, s.id) < ($4, $2)
+	  AND ($5::TIMESTAMPTZ IS NULL OR (s.start_date, s.id) > ($5, $6))
+)
+SELECT ST_Length(COALESCE(ST_Difference(route.geom, (SELECT ST_Union(geom) FROM prior)), route.geom)::geography)
+FROM route
+`
+
+// measureNewDistance returns the length of the activity's route not within
+// ExploredBufferMeters of an earlier activity. With useArea the explored_area row stands
+// for the activities up to after; otherwise after must be nil and every earlier activity
+// is buffered from scratch.
+func measureNewDistance(ctx context.Context, conn DB, athleteID int64, activity exploredKey, after *exploredKey, useArea bool) (float64, error) {
+	afterStart, afterID := exploredAfter(after)
+	var meters float64
+	err := conn.QueryRow(ctx, measureNewDistanceQuery, athleteID, activity.ActivityID, ExploredBufferMeters,
+		activity.StartDate, afterStart, afterID, useArea).Scan(&meters)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil // no route
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure new distance of activity %d: %w", activity.ActivityID, err)
+	}
+	return meters, nil
+}
+
+// extendExploredArea adds the buffered routes of the athlete's activities after after
+// (all when nil) up to and including through to the explored area
+func extendExploredArea(ctx context.Context, conn DB, athleteID int64, after *exploredKey, through exploredKey) error {
+	afterStart, afterID := exploredAfter(after)
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO explored_area (athlete_id, buffer_m, area_geom, through_start_date, through_activity_id, updated_at)
+		SELECT $1, $3, ST_Multi(ST_CollectionExtract(ST_Union(geom), 3)), $4, $2, NOW()
+		FROM (
+			SELECT area_geom AS geom FROM explored_area WHERE athlete_id = $1
+			UNION ALL
+			SELECT `+exploredBufferSQL+`
+			FROM activity_geometries g
+			JOIN activity_summaries s ON s.id = g.activity_id
+			WHERE s.athlete_id = $1
+			  AND (s.start_date, s.id) <= ($4, $2)
+			  AND ($5::TIMESTAMPTZ IS NULL OR (s.start_date, s.id) > ($5, $6))
+		) parts
+		ON CONFLICT (athlete_id) DO UPDATE SET
+			buffer_m = EXCLUDED.buffer_m,
+			area_geom = EXCLUDED.area_geom,
+			through_start_date = EXCLUDED.through_start_date,
+			through_activity_id = EXCLUDED.through_activity_id,
+			updated_at = NOW()
+	`, athleteID, through.ActivityID, ExploredBufferMeters, through.StartDate, afterStart, afterID); err != nil {
+		return fmt.Errorf("failed to extend explored area: %w", err)
+	}
+	return nil
+}
+
+// lockExploredArea returns the last activity the athlete's explored area covers, or nil
+// when there is no area yet. An area built with another buffer is dropped.
+func lockExploredArea(ctx context.Context, tx pgx.Tx, athleteID int64) (*exploredKey, error) {
+	var through exploredKey
+	var bufferMeters float64
+	err := tx.QueryRow(ctx, `
+		SELECT through_start_date, through_activity_id, buffer_m
+		FROM explored_area WHERE athlete_id = $1
+		FOR UPDATE
+	`, athleteID).Scan(&through.StartDate, &through.ActivityID, &bufferMeters)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read explored area: %w", err)
+	}
+	if bufferMeters != ExploredBufferMeters {
+		if _, err := tx.Exec(ctx, `DELETE FROM explored_area WHERE athlete_id = $1`, athleteID); err != nil {
+			return nil, fmt.Errorf("failed to drop outdated explored area: %w", err)
+		}
+		return nil, nil
+	}
+	return &through, nil
+}
+
+// ComputeNewDistance measures how many meters of the activity's route are more than
+// ExploredBufferMeters from every earlier activity of the athlete and stores it in
+// new_distance_m. When the activity is newer than everything the athlete's explored area
+// covers, the area is used and then extended to the activity; an older activity is
+// measured from scratch and leaves the area alone (ComputeNewDistances resets the area
+// for those). An activity without a route gets 0.
+func ComputeNewDistance(ctx context.Context, conn DB, athleteID, activityID int64) (float64, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin new distance computation: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	activity := exploredKey{ActivityID: activityID}
+	if err := tx.QueryRow(ctx, `
+		SELECT start_date FROM activity_summaries WHERE athlete_id = $1 AND id = $2
+	`, athleteID, activityID).Scan(&activity.StartDate); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, activityNotFoundf(err, "activity with ID %d not found", activityID)
+		}
+		return 0, fmt.Errorf("failed to load activity %d: %w", activityID, err)
+	}
+	through, err := lockExploredArea(ctx, tx, athleteID)
+	if err != nil {
+		return 0, err
+	}
+
+	incremental := through == nil || through.before(activity)
+	var newDistance float64
+	if incremental {
+		newDistance, err = measureNewDistance(ctx, tx, athleteID, activity, through, through != nil)
+	} else {
+		newDistance, err = measureNewDistance(ctx, tx, athleteID, activity, nil, false)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE activity_summaries SET new_distance_m = $3 WHERE athlete_id = $1 AND id = $2
+	`, athleteID, activityID, newDistance); err != nil {
+		return 0, fmt.Errorf("failed to store new distance of activity %d: %w", activityID, err)
+	}
+	if incremental {
+		if err := extendExploredArea(ctx, tx, athleteID, through, activity); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit new distance of activity %d: %w", activityID, err)
+	}
+	return newDistance, nil
+}
+
+// ComputeNewDistances computes new_distance_m for every activity of the athlete that has
+// none yet, oldest first, calling progress after each one. When such an activity is older
+// than the explored area's newest, or than an activity already measured, the area is
+// reset from its start so later activities are measured again with it. It returns how
+// many activities were computed.
+func ComputeNewDistances(ctx context.Context, conn DB, athleteID int64, progress func(done, total int)) (int, error) {
+	defer timeQuery("compute_new_distances", time.Now())
+	var first exploredKey
+	err := conn.QueryRow(ctx, `
+		SELECT start_date, id FROM activity_summaries
+		WHERE athlete_id = $1 AND new_distance_m IS NULL
+		ORDER BY start_date, id
+		LIMIT 1
+	`, athleteID).Scan(&first.StartDate, &first.ActivityID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find activities without new distance: %w", err)
+	}
+
+	var through exploredKey
+	err = conn.QueryRow(ctx, `
+		SELECT through_start_date, through_activity_id FROM explored_area WHERE athlete_id = $1
+	`, athleteID).Scan(&through.StartDate, &through.ActivityID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("failed to read explored area: %w", err)
+	}
+	reset := err == nil && !through.before(first)
+	if !reset {
+		// An activity moved to an earlier start, e.g. by an updated import, after the
+		// area was dropped
+		if err := conn.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM activity_summaries
+				WHERE athlete_id = $1 AND new_distance_m IS NOT NULL AND (start_date, id) > ($2, $3)
+			)
+		`, athleteID, first.StartDate, first.ActivityID).Scan(&reset); err != nil {
+			return 0, fmt.Errorf("failed to find activities measured after %d: %w", first.ActivityID, err)
+		}
+	}
+	if reset {
+		if err := ResetExploredArea(ctx, conn, athleteID, first.StartDate); err != nil {
+			return 0, err
+		}
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT id FROM activity_summaries
+		WHERE athlete_id = $1 AND new_distance_m IS NULL
+		ORDER BY start_date, id
+	`, athleteID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list activities without new distance: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, fmt.Errorf("failed to list activities without new distance: %w", err)
+	}
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if _, err := ComputeNewDistance(ctx, conn, athleteID, id); err != nil {
+			return i, err
+		}
+		if progress != nil {
+			progress(i+1, len(ids))
+		}
+	}
+	return len(ids), nil
+}
+
+// ResetExploredArea drops the athlete's explored area and the new distance of every
+// activity starting at or after from, so ComputeNewDistances measures them again. Call it
+// when an activity's route is removed or replaced.
+func ResetExploredArea(ctx context.Context, conn DB, athleteID int64, from time.Time) error {
+	if _, err := conn.Exec(ctx, `DELETE FROM explored_area WHERE athlete_id = $1`, athleteID); err != nil {
+		return fmt.Errorf("failed to drop explored area: %w", err)
+	}
+	if _, err := conn.Exec(ctx, `
+		UPDATE activity_summaries SET new_distance_m = NULL
+		WHERE athlete_id = $1 AND start_date >= $2
+	`, athleteID, from); err != nil {
+		return fmt.Errorf("failed to clear new distances: %w", err)
+	}
+	return nil
+}
+
+// GetExploredDistance returns the athlete's lifetime new distance in meters, the length
+// of road ridden for the first time over all activities measured so far
+func GetExploredDistance(ctx context.Context, conn DB, athleteID int64) (float64, error) {
+	var meters float64
+	if err := conn.QueryRow(ctx, `
+		SELECT COALESCE(SUM(new_distance_m), 0) FROM activity_summaries WHERE athlete_id = $1
+	`, athleteID).Scan(&meters); err != nil {
+		return 0, fmt.Errorf("failed to sum explored distance: %w", err)
+	}
+	return meters, nil
+}
@@ -0,0 +1,168 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+// Routes of an athlete exploring west to east along two parallel roads 5 km apart
+const (
+	exploredFixtureAthleteID  = 990000408
+	exploredFixtureActivityID = -2000 // the activities are -2000 down to -2009
+	exploredFixtureLatNorth   = selfCheckOriginLat + 0.045
+	exploredFixtureToleranceM = 1.0
+)
+
+// exploredFixtureDay is the start of the fixture activities' first day
+var exploredFixtureDay = time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+
+// insertExploredFixtureActivity stores an activity riding east along lat from fromM to
+// toM meters east of the self-check origin, starting day days after exploredFixtureDay
+func insertExploredFixtureActivity(t *testing.T, ctx context.Context, conn DB, activityID int64, day int, lat, fromM, toM float64) {
+	t.Helper()
+	var lons, lats []float64
+	for m := fromM; m <= toM; m += 100 {
+		lons = append(lons, selfCheckOriginLon+m/metersPerDegreeLon45)
+		lats = append(lats, lat)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+		VALUES ($1, $2, 'explored fixture', $3, 600, 600, 0, 'Ride', $4)
+	`, activityID, int64(exploredFixtureAthleteID), toM-fromM, exploredFixtureDay.AddDate(0, 0, day)); err != nil {
+		t.Fatalf("insert explored fixture activity %d: %v", activityID, err)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+		VALUES ($1, $2, make_route_geog_from_lonlat($3, $4))
+	`, activityID, int64(exploredFixtureAthleteID), lons, lats); err != nil {
+		t.Fatalf("insert explored fixture route %d: %v", activityID, err)
+	}
+	if _, err := conn.Exec(ctx, `SELECT refresh_activity_simplified($1)`, activityID); err != nil {
+		t.Fatalf("simplify explored fixture route %d: %v", activityID, err)
+	}
+}
+
+// checkNewDistancesMatchScratch compares every stored new distance with one measured
+// from scratch and returns them by activity
+func checkNewDistancesMatchScratch(t *testing.T, ctx context.Context, conn DB) map[int64]float64 {
+	t.Helper()
+	rows, err := conn.Query(ctx, `
+		SELECT id, start_date, new_distance_m FROM activity_summaries WHERE athlete_id = $1
+	`, int64(exploredFixtureAthleteID))
+	if err != nil {
+		t.Fatalf("query new distances: %v", err)
+	}
+	type stored struct {
+		key    exploredKey
+		meters *float64
+	}
+	var all []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.key.ActivityID, &s.key.StartDate, &s.meters); err != nil {
+			t.Fatalf("scan new distance: %v", err)
+		}
+		all = append(all, s)
+	}
+	rows.Close()
+
+	got := make(map[int64]float64)
+	for _, s := range all {
+		if s.meters == nil {
+			t.Fatalf("activity %d has no new distance", s.key.ActivityID)
+		}
+		scratch, err := measureNewDistance(ctx, conn, exploredFixtureAthleteID, s.key, nil, false)
+		if err != nil {
+			t.Fatalf("measure activity %d from scratch: %v", s.key.ActivityID, err)
+		}
+		if math.Abs(*s.meters-scratch) > exploredFixtureToleranceM {
+			t.Errorf("activity %d: incremental %.1f m, from scratch %.1f m", s.key.ActivityID, *s.meters, scratch)
+		}
+		got[s.key.ActivityID] = *s.meters
+	}
+	return got
+}
+
+func checkNewDistance(t *testing.T, got map[int64]float64, activityID int64, want float64) {
+	t.Helper()
+	// The buffer around earlier routes makes a partly new route up to its width shorter
+	if math.Abs(got[activityID]-want) > ExploredBufferMeters+exploredFixtureToleranceM {
+		t.Errorf("activity %d: new distance %.1f m, want about %.0f m", activityID, got[activityID], want)
+	}
+}
+
+func TestIncrementalExploredAreaMatchesScratch(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = conn.Exec(ctx, "DELETE FROM explored_area WHERE athlete_id = $1", int64(exploredFixtureAthleteID))
+		_, _ = conn.Exec(ctx, "DELETE FROM activity_summaries WHERE athlete_id = $1", int64(exploredFixtureAthleteID))
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	south := float64(selfCheckOriginLat)
+	first, extended, disjoint, repeat := int64(exploredFixtureActivityID), int64(exploredFixtureActivityID-1),
+		int64(exploredFixtureActivityID-2), int64(exploredFixtureActivityID-3)
+	insertExploredFixtureActivity(t, ctx, conn, first, 0, south, 0, 2000)
+	insertExploredFixtureActivity(t, ctx, conn, extended, 1, south, 1000, 4000)
+	insertExploredFixtureActivity(t, ctx, conn, disjoint, 2, exploredFixtureLatNorth, 0, 2000)
+	insertExploredFixtureActivity(t, ctx, conn, repeat, 3, south, 0, 4000)
+
+	measured, err := ComputeNewDistances(ctx, conn, exploredFixtureAthleteID, nil)
+	if err != nil || measured != 4 {
+		t.Fatalf("ComputeNewDistances = %d, %v; want 4", measured, err)
+	}
+	got := checkNewDistancesMatchScratch(t, ctx, conn)
+	checkNewDistance(t, got, first, 2000)
+	checkNewDistance(t, got, extended, 2000)
+	checkNewDistance(t, got, disjoint, 2000)
+	checkNewDistance(t, got, repeat, 0)
+
+	// A newer activity extends the area without touching the others
+	newer := int64(exploredFixtureActivityID - 4)
+	insertExploredFixtureActivity(t, ctx, conn, newer, 4, exploredFixtureLatNorth, 1000, 3000)
+	if measured, err := ComputeNewDistances(ctx, conn, exploredFixtureAthleteID, nil); err != nil || measured != 1 {
+		t.Fatalf("ComputeNewDistances after a newer activity = %d, %v; want 1", measured, err)
+	}
+	got = checkNewDistancesMatchScratch(t, ctx, conn)
+	checkNewDistance(t, got, newer, 1000)
+
+	// An activity older than the area resets it and the activities after it are measured again
+	older := int64(exploredFixtureActivityID - 5)
+	insertExploredFixtureActivity(t, ctx, conn, older, -1, exploredFixtureLatNorth, 0, 3000)
+	if measured, err := ComputeNewDistances(ctx, conn, exploredFixtureAthleteID, nil); err != nil || measured != 6 {
+		t.Fatalf("ComputeNewDistances after an older activity = %d, %v; want 6", measured, err)
+	}
+	got = checkNewDistancesMatchScratch(t, ctx, conn)
+	checkNewDistance(t, got, older, 3000)
+	checkNewDistance(t, got, disjoint, 0)
+	checkNewDistance(t, got, newer, 0)
+
+	explored, err := GetExploredDistance(ctx, conn, exploredFixtureAthleteID)
+	if err != nil {
+		t.Fatalf("GetExploredDistance: %v", err)
+	}
+	var sum float64
+	for _, meters := range got {
+		sum += meters
+	}
+	if math.Abs(explored-sum) > exploredFixtureToleranceM || math.Abs(explored-7000) > 2*ExploredBufferMeters {
+		t.Fatalf("explored distance %.1f m, want the sum %.1f m of about 7000 m", explored, sum)
+	}
+
+	// Deleting an activity measures the later ones again without its route
+	if deleted, err := DeleteActivity(ctx, conn, exploredFixtureAthleteID, older); err != nil || !deleted {
+		t.Fatalf("DeleteActivity = %v, %v", deleted, err)
+	}
+	if measured, err := ComputeNewDistances(ctx, conn, exploredFixtureAthleteID, nil); err != nil || measured != 5 {
+		t.Fatalf("ComputeNewDistances after a deletion = %d, %v; want 5", measured, err)
+	}
+	got = checkNewDistancesMatchScratch(t, ctx, conn)
+	checkNewDistance(t, got, disjoint, 2000)
+	checkNewDistance(t, got, newer, 1000)
+}
@@ -0,0 +1,148 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Kinds of gear; gear only seen on activities has no kind until a login prefetch lists it
+const (
+	GearKindBike = "bike"
+	GearKindShoe = "shoe"
+)
+
+// Gear is a bike or pair of shoes as stored in gear
+type Gear struct {
+	ID        string `json:"id"`
+	AthleteID int64  `json:"athlete_id"`
+	Name      string `json:"name"`
+	Brand     string `json:"brand"`
+	Model     string `json:"model"`
+	Retired   bool   `json:"retired"`
+	Kind      string `json:"kind"`
+}
+
+// GearStats is one of the athlete's gear items with what their activities put on it.
+// Known is false for a gear_id whose details were not fetched yet; Name then falls back
+// to the name stored on its activities.
+type GearStats struct {
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	Brand          string  `json:"brand"`
+	Model          string  `json:"model"`
+	Retired        bool    `json:"retired"`
+	Known          bool    `json:"known"`
+	Activities     int     `json:"activities"`
+	DistanceMeters float64 `json:"distance_m"`
+	DistanceKM     float64 `json:"distance_km"`
+}
+
+// UpsertGear stores the details of a gear item fetched from Strava, keeping created_at of
+// an earlier fetch and the stored kind when gear.Kind is empty. Gear stored for another
+// athlete is left alone.
+func UpsertGear(ctx context.Context, conn DB, gear Gear) error {
+	tag, err := conn.Exec(ctx, `
+		INSERT INTO gear (id, athlete_id, name, brand, model, retired, kind, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (id) DO UPDATE
+		SET name = EXCLUDED.name, brand = EXCLUDED.brand, model = EXCLUDED.model, retired = EXCLUDED.retired,
+			kind = COALESCE(NULLIF(EXCLUDED.kind, ''), gear.kind), fetched_at = EXCLUDED.fetched_at
+		WHERE gear.athlete_id = EXCLUDED.athlete_id
+	`, gear.ID, gear.AthleteID, gear.Name, gear.Brand, gear.Model, gear.Retired, gear.Kind)
+	if err != nil {
+		return fmt.Errorf("failed to store gear %s: %w", gear.ID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return foreignAthletef("gear %s belongs to another athlete", gear.ID)
+	}
+	return nil
+}
+
+// ListGear returns the details stored for the athlete's gear, by kind and name
+func ListGear(ctx context.Context, conn DB, athleteID int64) ([]Gear, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT id, athlete_id, name, brand, model, retired, kind FROM gear WHERE athlete_id = $1 ORDER BY kind, name, id
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gear: %w", err)
+	}
+	defer rows.Close()
+
+	gear := []Gear{}
+	for rows.Next() {
+		var item Gear
+		if err := rows.Scan(&item.ID, &item.AthleteID, &item.Name, &item.Brand, &item.Model, &item.Retired, &item.Kind); err != nil {
+			return nil, fmt.Errorf("failed to scan gear: %w", err)
+		}
+		gear = append(gear, item)
+	}
+	return gear, rows.Err()
+}
+
+// GetUnresolvedGearIDs returns up to limit gear IDs named by the athlete's activities whose
+// details were not fetched yet, most used first. Gear only listed by the login prefetch
+// has a name but no brand or model, so it counts as unresolved.
+func GetUnresolvedGearIDs(ctx context.Context, conn DB, athleteID int64, limit int) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT a.gear_id
+		FROM activity_summaries a
+		WHERE a.athlete_id = $1 AND a.gear_id IS NOT NULL AND a.gear_id <> ''
+			AND NOT EXISTS (SELECT 1 FROM gear g WHERE g.id = a.gear_id AND g.fetched_at IS NOT NULL)
+		GROUP BY a.gear_id
+		ORDER BY COUNT(*) DESC, a.gear_id
+		LIMIT $2
+	`, athleteID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find unresolved gear: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan gear ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetGearStats returns the athlete's gear with the distance and count of the activities on
+// each, longest distance first. Gear without activities is listed with zeros and gear_ids
+// of activities without details yet are listed as not Known.
+func GetGearStats(ctx context.Context, conn DB, athleteID int64) ([]GearStats, error) {
+	rows, err := conn.Query(ctx, `
+		WITH usage AS (
+			SELECT gear_id, COUNT(*) AS activities, COALESCE(SUM(distance), 0) AS distance,
+				MAX(gear_name) AS gear_name
+			FROM activity_summaries
+			WHERE athlete_id = $1 AND gear_id IS NOT NULL AND gear_id <> ''
+			GROUP BY gear_id
+		), owned AS (
+			SELECT id, name, brand, model, retired FROM gear WHERE athlete_id = $1
+		)
+		SELECT COALESCE(g.id, u.gear_id), COALESCE(NULLIF(g.name, ''), u.gear_name, ''),
+			COALESCE(g.brand, ''), COALESCE(g.model, ''), COALESCE(g.retired, FALSE),
+			g.id IS NOT NULL, COALESCE(u.activities, 0), COALESCE(u.distance, 0)
+		FROM owned g
+		FULL OUTER JOIN usage u ON u.gear_id = g.id
+		ORDER BY 8 DESC, 1
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gear stats: %w", err)
+	}
+	defer rows.Close()
+	stats := []GearStats{}
+	for rows.Next() {
+		var g GearStats
+		if err := rows.Scan(&g.ID, &g.Name, &g.Brand, &g.Model, &g.Retired, &g.Known, &g.Activities, &g.DistanceMeters); err != nil {
+			return nil, fmt.Errorf("failed to scan gear stats: %w", err)
+		}
+		g.DistanceKM = g.DistanceMeters / 1000
+		stats = append(stats, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get gear stats: %w", err)
+	}
+	return stats, nil
+}
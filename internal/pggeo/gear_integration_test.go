@@ -0,0 +1,78 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestGearStatsSumActivitiesPerGear(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000786)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM gear WHERE id IN ('b990000786', 'b990000787', 'b990000788')`)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	insert := func(id int64, gearID string, meters float64) {
+		t.Helper()
+		activity := &strava.ActivitySummary{
+			ID: id, AthleteID: athleteID, Name: fmt.Sprintf("Ride %d", id), Type: "Ride", SportType: "GravelRide",
+			StartDate: "2024-05-02T07:00:00Z", Distance: meters, GearID: gearID,
+		}
+		if err := InsertActivitySummary(ctx, conn, activity); err != nil {
+			t.Fatalf("InsertActivitySummary: %v", err)
+		}
+	}
+	// 61 gravel rides adding up to 4,200 km, two on a bike without details yet
+	for i := int64(0); i < 61; i++ {
+		insert(990000786000+i, "b990000786", 4_200_000.0/61)
+	}
+	insert(990000786100, "b990000787", 30_000)
+	insert(990000786101, "b990000787", 20_000)
+	insert(990000786102, "", 10_000)
+
+	unresolved, err := GetUnresolvedGearIDs(ctx, conn, athleteID, 10)
+	if err != nil || len(unresolved) != 2 || unresolved[0] != "b990000786" || unresolved[1] != "b990000787" {
+		t.Fatalf("GetUnresolvedGearIDs = %v, %v; want both bikes, most used first", unresolved, err)
+	}
+	if err := UpsertGear(ctx, conn, Gear{ID: "b990000786", AthleteID: athleteID, Name: "Gravel", Brand: "Canyon", Model: "Grizl"}); err != nil {
+		t.Fatalf("UpsertGear: %v", err)
+	}
+	if err := UpsertGear(ctx, conn, Gear{ID: "b990000788", AthleteID: athleteID, Name: "Old road bike", Retired: true}); err != nil {
+		t.Fatalf("UpsertGear: %v", err)
+	}
+	if err := UpsertGear(ctx, conn, Gear{ID: "b990000786", AthleteID: athleteID + 1, Name: "Stolen"}); !errors.Is(err, ErrForeignAthlete) {
+		t.Fatalf("UpsertGear for another athlete error = %v, want ErrForeignAthlete", err)
+	}
+	if unresolved, err := GetUnresolvedGearIDs(ctx, conn, athleteID, 10); err != nil || len(unresolved) != 1 || unresolved[0] != "b990000787" {
+		t.Fatalf("GetUnresolvedGearIDs after upsert = %v, %v", unresolved, err)
+	}
+
+	stats, err := GetGearStats(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("GetGearStats: %v", err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("GetGearStats = %+v, want 3 items", stats)
+	}
+	gravel := stats[0]
+	if gravel.ID != "b990000786" || gravel.Name != "Gravel" || gravel.Brand != "Canyon" || !gravel.Known ||
+		gravel.Activities != 61 || math.Abs(gravel.DistanceKM-4200) > 0.001 {
+		t.Fatalf("gravel bike = %+v, want 4,200 km across 61 rides", gravel)
+	}
+	if unknown := stats[1]; unknown.ID != "b990000787" || unknown.Known || unknown.Activities != 2 || unknown.DistanceMeters != 50_000 {
+		t.Fatalf("bike without details = %+v", unknown)
+	}
+	if retired := stats[2]; retired.ID != "b990000788" || !retired.Retired || retired.Activities != 0 || retired.DistanceMeters != 0 {
+		t.Fatalf("unused retired bike = %+v", retired)
+	}
+}
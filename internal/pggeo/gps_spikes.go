@@ -0,0 +1,116 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// GPSQuality counts GPS teleport spikes found in an activity's route
+type GPSQuality struct {
+	// Spikes are still in the stored route
+	Spikes int `json:"spikes"`
+	// Healed were moved back onto the route when saving or by POST .../heal
+	Healed int `json:"healed"`
+}
+
+// SetActivityGPSSpikes records the spikes found when the activity was saved
+func SetActivityGPSSpikes(ctx context.Context, conn DB, activityID int64, quality GPSQuality) error {
+	if _, err := conn.Exec(ctx, `
+		UPDATE activity_summaries SET gps_spikes = $2, gps_spikes_healed = $3 WHERE id = $1
+	`, activityID, quality.Spikes, quality.Healed); err != nil {
+		return fmt.Errorf("failed to record GPS spikes of activity %d: %w", activityID, err)
+	}
+	return nil
+}
+
+// GetActivityGPSQuality returns the activity's recorded spikes
+func GetActivityGPSQuality(ctx context.Context, conn DB, athleteID, activityID int64) (GPSQuality, error) {
+	var quality GPSQuality
+	err := conn.QueryRow(ctx, `
+		SELECT gps_spikes, gps_spikes_healed FROM activity_summaries WHERE athlete_id = $1 AND id = $2
+	`, athleteID, activityID).Scan(&quality.Spikes, &quality.Healed)
+	if err != nil {
+		return quality, fmt.Errorf("failed to get GPS quality of activity %d: %w", activityID, err)
+	}
+	return quality, nil
+}
+
+// HealActivityPoints stores healed samples: their locations and cumulative distances are
+// written back, the route geometry is rebuilt from the samples, removedMeters comes off
+// the summary distance and the healed spikes are counted. OnActivityChanged invalidates
+// what was derived from the old points, such as segment matches the spikes may have
+// created.
+func HealActivityPoints(ctx context.Context, conn DB, athleteID, activityID int64, samples []PointSample, healed int, removedMeters float64) error {
+	pointIndexes := make([]int, len(samples))
+	lats := make([]float64, len(samples))
+	lngs := make([]float64, len(samples))
+	distances := make([]*float64, len(samples))
+	for i, sample := range samples {
+		pointIndexes[i] = sample.PointIndex
+		lats[i], lngs[i] = sample.Lat, sample.Lng
+		distances[i] = sample.CumulativeDistance
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE activity_summaries
+		SET distance = GREATEST(distance - $3, 0),
+			gps_spikes = GREATEST(gps_spikes - $4, 0),
+			gps_spikes_healed = gps_spikes_healed + $4,
+			updated_at = NOW()
+		WHERE athlete_id = $1 AND id = $2
+	`, athleteID, activityID, removedMeters, healed)
+	if err != nil {
+		return fmt.Errorf("failed to update activity distance: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	if err := OnActivityChanged(ctx, tx, athleteID, activityID, ActivityHealed); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE point_samples p
+		SET location = ST_SetSRID(ST_MakePoint(h.lng, h.lat), 4326)::geography,
+			cumulative_distance = h.distance
+		FROM UNNEST($2::integer[], $3::double precision[], $4::double precision[], $5::double precision[])
+			AS h(point_index, lat, lng, distance)
+		WHERE p.activity_id = $1 AND p.point_index = h.point_index
+	`, activityID, pointIndexes, lats, lngs, distances); err != nil {
+		return fmt.Errorf("failed to update healed point samples: %w", err)
+	}
+	for _, column := range []struct {
+		name   string
+		values any
+	}{{"lats", lats}, {"lngs", lngs}, {"cumulative_distances", distances}} {
+		if err := updatePointStream(ctx, tx, activityID, column.name, pointIndexes, column.values, false); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE activity_geometries
+		SET route_geog = (
+			SELECT ST_MakeLine(location::geometry ORDER BY point_index)::geography
+			FROM point_sample_rows WHERE activity_id = $1
+		)
+		WHERE activity_id = $1
+	`, activityID); err != nil {
+		return fmt.Errorf("failed to rebuild activity geometry: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit healed activity: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, `SELECT refresh_activity_simplified($1, $2)`, activityID, SimplifyTolerance()); err != nil {
+		logger().Warn("Could not refresh simplified geometry", "activity_id", activityID, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestHealActivityPointsRewritesRouteAndDistance(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000782), int64(990000782001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM point_samples WHERE activity_id = $1`, activityID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_geometries WHERE activity_id = $1`, activityID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// Three samples along a meridian, the middle one teleported 0.1° east
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+		VALUES ($1, $2, 'spike fixture', 15000, 2, 2, 0, 'Ride', NOW())
+	`, activityID, athleteID); err != nil {
+		t.Fatalf("insert activity: %v", err)
+	}
+	if err := SetActivityGPSSpikes(ctx, conn, activityID, GPSQuality{Spikes: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, cumulative_distance)
+		VALUES ($1, $2, 0, NOW(), ST_GeogFromText('POINT(7 45)'), 0),
+			($1, $2, 1, NOW() + INTERVAL '1 second', ST_GeogFromText('POINT(7.1 45.00005)'), 7500),
+			($1, $2, 2, NOW() + INTERVAL '2 seconds', ST_GeogFromText('POINT(7 45.0001)'), 15000)
+	`, activityID, athleteID); err != nil {
+		t.Fatalf("insert samples: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+		VALUES ($1, $2, ST_GeogFromText('LINESTRING(7 45, 7.1 45.00005, 7 45.0001)'))
+	`, activityID, athleteID); err != nil {
+		t.Fatalf("insert geometry: %v", err)
+	}
+
+	halfway, end := 5.5, 11.0
+	healed := []PointSample{
+		{PointIndex: 1, Lat: 45.00005, Lng: 7, CumulativeDistance: &halfway},
+		{PointIndex: 2, Lat: 45.0001, Lng: 7, CumulativeDistance: &end},
+	}
+	if err := HealActivityPoints(ctx, conn, athleteID+1, activityID, healed, 1, 14989); err == nil {
+		t.Fatal("another athlete healed the activity")
+	}
+	if err := HealActivityPoints(ctx, conn, athleteID, activityID, healed, 1, 14989); err != nil {
+		t.Fatalf("HealActivityPoints: %v", err)
+	}
+
+	var distance, routeLength float64
+	if err := conn.QueryRow(ctx, `
+		SELECT s.distance, ST_Length(g.route_geog)
+		FROM activity_summaries s JOIN activity_geometries g ON g.activity_id = s.id
+		WHERE s.id = $1
+	`, activityID).Scan(&distance, &routeLength); err != nil {
+		t.Fatal(err)
+	}
+	if distance != 11 || math.Abs(routeLength-11.1) > 0.5 {
+		t.Fatalf("distance %.1f, route length %.1f; want 11 and ~11.1", distance, routeLength)
+	}
+	quality, err := GetActivityGPSQuality(ctx, conn, athleteID, activityID)
+	if err != nil || quality != (GPSQuality{Healed: 1}) {
+		t.Fatalf("quality = %+v, %v; want the spike moved to healed", quality, err)
+	}
+}
@@ -0,0 +1,52 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReplaceActivityGrades overwrites the stored grade of the activity's samples with locally
+// derived values, grades[i] going to the sample at pointIndexes[i], and marks the activity's
+// grades as derived. OnActivityChanged clears what was derived from the old grades, such
+// as the grade-adjusted speeds of its segment efforts. Re-fetching the streams from Strava puts the
+// original grades back.
+func ReplaceActivityGrades(ctx context.Context, conn DB, athleteID, activityID int64, pointIndexes []int, grades []float64) error {
+	if len(pointIndexes) != len(grades) {
+		return invalidInputf("got %d grades for %d samples", len(grades), len(pointIndexes))
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE activity_summaries
+		SET grades_derived = TRUE, updated_at = NOW()
+		WHERE athlete_id = $1 AND id = $2
+	`, athleteID, activityID)
+	if err != nil {
+		return fmt.Errorf("failed to mark activity grades as derived: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	if err := OnActivityChanged(ctx, tx, athleteID, activityID, ActivityRegraded); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE point_samples p
+		SET grade = g.grade
+		FROM UNNEST($2::integer[], $3::double precision[]) AS g(point_index, grade)
+		WHERE p.activity_id = $1 AND p.point_index = g.point_index
+	`, activityID, pointIndexes, grades); err != nil {
+		return fmt.Errorf("failed to update point sample grades: %w", err)
+	}
+	if err := updatePointStream(ctx, tx, activityID, "grades", pointIndexes, grades, false); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,54 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReplaceActivityGradesMarksActivityDerived(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000778), int64(990000778001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM point_samples WHERE activity_id = $1`, activityID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+		VALUES ($1, $2, 'grades fixture', 30, 3, 3, 0, 'Ride', NOW())
+	`, activityID, athleteID); err != nil {
+		t.Fatalf("insert activity: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, altitude, grade, cumulative_distance)
+		SELECT $1, $2, i, NOW() + make_interval(secs => i), ST_GeogFromText('POINT(7 45)'), 100 + i, 9, i * 10
+		FROM generate_series(0, 2) AS i
+	`, activityID, athleteID); err != nil {
+		t.Fatalf("insert samples: %v", err)
+	}
+
+	if err := ReplaceActivityGrades(ctx, conn, athleteID+1, activityID, []int{0}, []float64{1}); err == nil {
+		t.Fatal("another athlete rewrote the grades")
+	}
+	if err := ReplaceActivityGrades(ctx, conn, athleteID, activityID, []int{0, 1, 2}, []float64{0, 10, 10}); err != nil {
+		t.Fatalf("ReplaceActivityGrades: %v", err)
+	}
+
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	if err != nil || len(samples) != 3 {
+		t.Fatalf("samples = %+v, %v", samples, err)
+	}
+	for i, want := range []float64{0, 10, 10} {
+		if samples[i].Grade == nil || *samples[i].Grade != want {
+			t.Fatalf("sample %d grade = %v, want %v", i, samples[i].Grade, want)
+		}
+	}
+	var derived bool
+	if err := conn.QueryRow(ctx, `SELECT grades_derived FROM activity_summaries WHERE id = $1`, activityID).Scan(&derived); err != nil || !derived {
+		t.Fatalf("grades_derived = %v, %v; want true", derived, err)
+	}
+}
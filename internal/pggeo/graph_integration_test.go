@@ -0,0 +1,169 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// A 5-hour ride sampled every second at 5 m/s with a 4-minute segment in the middle
+const (
+	graphFixtureActivityID = -2
+	graphFixtureSegmentID  = -2
+	graphFixtureAthleteID  = -2
+	graphFixturePoints     = 5 * 60 * 60
+	graphFixtureStepMeters = 5.0
+	graphFixtureSegStart   = graphFixturePoints / 2
+	graphFixtureSegEnd     = graphFixtureSegStart + 4*60
+	graphFixtureToleranceM = 15.0
+)
+
+var graphFixtureMetrics = []string{"speed", "heartrate", "height", "cadence"}
+
+func setupGraphFixture(tb testing.TB) (context.Context, *pgx.Conn) {
+	tb.Helper()
+	ctx, conn := connectIntegrationDB(tb)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), "DELETE FROM favorite_segments WHERE id = $1", int64(graphFixtureSegmentID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM point_samples WHERE activity_id = $1", int64(graphFixtureActivityID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_geometries WHERE activity_id = $1", int64(graphFixtureActivityID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_summaries WHERE id = $1", int64(graphFixtureActivityID))
+	}
+	cleanup()
+	tb.Cleanup(cleanup)
+
+	lons := make([]float64, graphFixturePoints)
+	lats := make([]float64, graphFixturePoints)
+	for i := range lons {
+		lons[i] = selfCheckOriginLon
+		lats[i] = selfCheckOriginLat + float64(i)*graphFixtureStepMeters/metersPerDegreeLat45
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'graph fixture', $3, $4, $4, 0, 'Ride', NOW())`,
+			[]any{int64(graphFixtureActivityID), int64(graphFixtureAthleteID), float64(graphFixturePoints) * graphFixtureStepMeters, graphFixturePoints}},
+		{`INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+			VALUES ($1, $2, make_route_geog_from_lonlat($3, $4))`,
+			[]any{int64(graphFixtureActivityID), int64(graphFixtureAthleteID), lons, lats}},
+		{`INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, altitude, heartrate, speed, cadence, cumulative_distance)
+			SELECT $1, $2, i - 1, NOW() + make_interval(secs => i),
+				ST_SetSRID(ST_MakePoint(($3::DOUBLE PRECISION[])[i], ($4::DOUBLE PRECISION[])[i]), 4326)::GEOGRAPHY,
+				100 + i % 50, 140 + i % 20, $5, 85, (i - 1) * $5::DOUBLE PRECISION
+			FROM generate_subscripts($3::DOUBLE PRECISION[], 1) AS i`,
+			[]any{int64(graphFixtureActivityID), int64(graphFixtureAthleteID), lons, lats, graphFixtureStepMeters}},
+		{`INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+			VALUES ($1, $2, 'graph fixture', make_route_geog_from_lonlat($3, $4))`,
+			[]any{int64(graphFixtureSegmentID), int64(graphFixtureAthleteID),
+				lons[graphFixtureSegStart : graphFixtureSegEnd+1], lats[graphFixtureSegStart : graphFixtureSegEnd+1]}},
+	}
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			tb.Fatalf("insert graph fixture: %v", err)
+		}
+	}
+	return ctx, conn
+}
+
+func TestGraphDataForSegmentInActivityMatchesFullLoad(t *testing.T) {
+	ctx, conn := setupGraphFixture(t)
+
+	got, err := GetGraphDataForSegmentInActivity(ctx, conn, graphFixtureAthleteID, graphFixtureActivityID, graphFixtureSegmentID, graphFixtureToleranceM, 1, graphFixtureMetrics, false, nil)
+	if err != nil {
+		t.Fatalf("GetGraphDataForSegmentInActivity: %v", err)
+	}
+	want, err := graphDataForSegmentFullLoad(ctx, conn)
+	if err != nil {
+		t.Fatalf("full load: %v", err)
+	}
+	if len(got.Speed) != len(want.Speed) || len(got.Heartrate) != len(want.Heartrate) ||
+		len(got.Height) != len(want.Height) || len(got.Cadence) != len(want.Cadence) {
+		t.Fatalf("series lengths differ: got %d/%d/%d/%d, want %d/%d/%d/%d",
+			len(got.Speed), len(got.Heartrate), len(got.Height), len(got.Cadence),
+			len(want.Speed), len(want.Heartrate), len(want.Height), len(want.Cadence))
+	}
+	if len(got.Speed) == 0 || len(got.Speed) > graphFixtureSegEnd-graphFixtureSegStart+10 {
+		t.Fatalf("speed series has %d points, want about %d", len(got.Speed), graphFixtureSegEnd-graphFixtureSegStart+1)
+	}
+
+	// Cached indices must be used as-is
+	cacheGraphFixtureEffort(t, ctx, conn, 10, 19)
+	cached, err := GetGraphDataForSegmentInActivity(ctx, conn, graphFixtureAthleteID, graphFixtureActivityID, graphFixtureSegmentID, graphFixtureToleranceM, 1, []string{"speed"}, false, nil)
+	if err != nil {
+		t.Fatalf("GetGraphDataForSegmentInActivity with cache: %v", err)
+	}
+	if len(cached.Speed) != 10 {
+		t.Fatalf("cached range returned %d points, want 10", len(cached.Speed))
+	}
+}
+
+// cacheGraphFixtureEffort caches a single effort over the given range with zero metrics
+func cacheGraphFixtureEffort(tb testing.TB, ctx context.Context, conn *pgx.Conn, startIndex, endIndex int) {
+	tb.Helper()
+	count, zero := 1, 0.0
+	effort := SegmentActivityCacheEntry{
+		EffortNumber: 1, EffortCount: &count, StartIndex: &startIndex, EndIndex: &endIndex,
+		AvgHR: &zero, AvgSpeed: &zero, DistanceM: &zero, ElevationGainM: &zero, ElapsedSeconds: &zero,
+	}
+	if err := CacheSegmentActivityEfforts(ctx, conn, graphFixtureSegmentID, graphFixtureActivityID, graphFixtureToleranceM, []SegmentActivityCacheEntry{effort}); err != nil {
+		tb.Fatalf("CacheSegmentActivityEfforts: %v", err)
+	}
+}
+
+// graphDataForSegmentFullLoad is the previous implementation: load every sample, filter in Go
+func graphDataForSegmentFullLoad(ctx context.Context, conn *pgx.Conn) (*GraphData, error) {
+	var startIndex, endIndex int
+	if err := conn.QueryRow(ctx, `SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`,
+		int64(graphFixtureSegmentID), int64(graphFixtureActivityID), int64(graphFixtureAthleteID), graphFixtureToleranceM,
+	).Scan(&startIndex, &endIndex); err != nil {
+		return nil, err
+	}
+	samples, err := GetPointSamplesForActivity(ctx, conn, graphFixtureAthleteID, graphFixtureActivityID)
+	if err != nil {
+		return nil, err
+	}
+	var segmentSamples []PointSample
+	for _, sample := range samples {
+		if sample.PointIndex >= startIndex && sample.PointIndex <= endIndex {
+			segmentSamples = append(segmentSamples, sample)
+		}
+	}
+	return buildGraphData(segmentSamples, graphFixtureMetrics, false, nil), nil
+}
+
+func BenchmarkGraphDataForSegmentFullLoad(b *testing.B) {
+	ctx, conn := setupGraphFixture(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := graphDataForSegmentFullLoad(ctx, conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGraphDataForSegmentInActivity(b *testing.B) {
+	ctx, conn := setupGraphFixture(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetGraphDataForSegmentInActivity(ctx, conn, graphFixtureAthleteID, graphFixtureActivityID, graphFixtureSegmentID, graphFixtureToleranceM, 1, graphFixtureMetrics, false, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGraphDataForSegmentInActivityCached(b *testing.B) {
+	ctx, conn := setupGraphFixture(b)
+	cacheGraphFixtureEffort(b, ctx, conn, graphFixtureSegStart, graphFixtureSegEnd)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GetGraphDataForSegmentInActivity(ctx, conn, graphFixtureAthleteID, graphFixtureActivityID, graphFixtureSegmentID, graphFixtureToleranceM, 1, graphFixtureMetrics, false, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
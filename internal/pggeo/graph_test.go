@@ -0,0 +1,114 @@
+package pggeo
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBuildGraphDataIncludesWattsAndGrade(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	watts := func(v int) *int { return &v }
+	grade := func(v float64) *float64 { return &v }
+	samples := []PointSample{
+		{Time: start, Watts: watts(200), Grade: grade(1.5)},
+		{Time: start.Add(time.Second), Watts: watts(250)},
+		{Time: start.Add(2 * time.Second), Grade: grade(-2)},
+	}
+
+	data := buildGraphData(samples, []string{"watts", "grade"}, false, nil)
+	if len(data.Watts) != 2 || data.Watts[1].Value != 250 {
+		t.Fatalf("watts = %+v", data.Watts)
+	}
+	if len(data.Grade) != 2 || data.Grade[1].Value != -2 {
+		t.Fatalf("grade = %+v", data.Grade)
+	}
+	if data.Heartrate != nil || data.Speed != nil {
+		t.Fatal("metrics that were not asked for were filled")
+	}
+	if only := buildGraphData(samples, []string{"grade"}, false, nil); only.Watts != nil {
+		t.Fatal("watts were filled without being asked for")
+	}
+}
+
+func TestSmoothGraphSeriesAveragesTheTrailingWindow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	var series []GraphDataPoint
+	for i, value := range []float64{100, 200, 300, 400} {
+		series = append(series, GraphDataPoint{Time: start.Add(time.Duration(i) * 10 * time.Second), Value: value})
+	}
+
+	smoothed := SmoothGraphSeries(series, 30*time.Second)
+	// The window ending at each point covers it and the points less than 30 s before it
+	for i, want := range []float64{100, 150, 200, 300} {
+		if smoothed[i].Value != want || !smoothed[i].Time.Equal(series[i].Time) {
+			t.Fatalf("point %d = %+v, want %v", i, smoothed[i], want)
+		}
+	}
+	if series[3].Value != 400 {
+		t.Fatal("smoothing changed the input series")
+	}
+	if same := SmoothGraphSeries(series, 0); same[3].Value != 400 {
+		t.Fatal("a zero window smoothed the series")
+	}
+}
+
+func TestDownsampleGraphSeriesKeepsSpikesAndCount(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	// Six hours at 1 Hz of a gentle wave, with one sprint and one stop
+	series := make([]GraphDataPoint, 6*60*60)
+	for i := range series {
+		series[i] = GraphDataPoint{Time: start.Add(time.Duration(i) * time.Second), Value: 200 + 20*math.Sin(float64(i)/300)}
+	}
+	series[7777].Value = 1100
+	series[15000].Value = 0
+
+	for _, maxPoints := range []int{3, 500, 2000} {
+		sampled := DownsampleGraphSeries(series, maxPoints)
+		if len(sampled) != maxPoints {
+			t.Fatalf("maxPoints %d returned %d points", maxPoints, len(sampled))
+		}
+		if !sampled[0].Time.Equal(series[0].Time) || !sampled[maxPoints-1].Time.Equal(series[len(series)-1].Time) {
+			t.Fatalf("maxPoints %d lost the first or last point", maxPoints)
+		}
+		for i := 1; i < len(sampled); i++ {
+			if !sampled[i].Time.After(sampled[i-1].Time) {
+				t.Fatalf("maxPoints %d: points out of order at %d", maxPoints, i)
+			}
+		}
+		if maxPoints < 500 {
+			continue
+		}
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, point := range sampled {
+			low, high = math.Min(low, point.Value), math.Max(high, point.Value)
+		}
+		if high != 1100 || low != 0 {
+			t.Fatalf("maxPoints %d kept range %v..%v, want the 0 dip and 1100 spike", maxPoints, low, high)
+		}
+	}
+
+	if short := DownsampleGraphSeries(series[:100], 2000); len(short) != 100 {
+		t.Fatalf("a short series was resampled to %d points", len(short))
+	}
+}
+
+func TestDownsampledLongRideStaysSmall(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	samples := make([]PointSample, 6*60*60)
+	for i := range samples {
+		speed, distance, heartrate := 8+math.Sin(float64(i)/7), float64(i)*8.123, 140+i%37
+		samples[i] = PointSample{Time: start.Add(time.Duration(i) * time.Second), Speed: &speed, Heartrate: &heartrate, CumulativeDistance: &distance}
+	}
+
+	data := buildGraphData(samples, []string{"speed", "heartrate"}, false, nil)
+	data.Downsample(1000)
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Speed) != 1000 || len(body) > 200*1024 {
+		t.Fatalf("%d speed points in %d bytes, want 1000 under 200 KB", len(data.Speed), len(body))
+	}
+}
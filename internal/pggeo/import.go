@@ -0,0 +1,44 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+
+	"b11k/internal/strava"
+)
+
+// ImportActivityIDBase is the first ID given to activities imported from files. Like the
+// seed IDs it sits far above real Strava IDs, below the seed range and below 2^53.
+const ImportActivityIDBase int64 = 7_000_000_000_000_000
+
+// createImportedActivityIDSequenceSQL creates the sequence imported activity IDs come from.
+// It is never dropped with the tables so IDs are not handed out twice.
+var createImportedActivityIDSequenceSQL = fmt.Sprintf(
+	"CREATE SEQUENCE IF NOT EXISTS imported_activity_ids START WITH %d MINVALUE %d MAXVALUE %d",
+	ImportActivityIDBase, ImportActivityIDBase, SeedActivityIDBase-1,
+)
+
+// NextImportedActivityID reserves an ID for an activity imported from a file
+func NextImportedActivityID(ctx context.Context, conn DB) (int64, error) {
+	var id int64
+	if err := conn.QueryRow(ctx, `SELECT nextval('imported_activity_ids')`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to reserve imported activity ID: %w", err)
+	}
+	return id, nil
+}
+
+// InsertImportedActivity stores an activity parsed from a GPX or TCX file through the
+// normal insert pipeline and marks it with source 'import'. The activity must already
+// carry an ID from NextImportedActivityID.
+func InsertImportedActivity(ctx context.Context, conn DB, activity *strava.BikeActivity) error {
+	if activity.Summary.ID < ImportActivityIDBase || activity.Summary.ID >= SeedActivityIDBase {
+		return invalidInputf("activity ID %d is outside the imported activity range", activity.Summary.ID)
+	}
+	if err := InsertBikeActivityUpsert(ctx, conn, activity); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, `UPDATE activity_summaries SET source = $2 WHERE id = $1`, activity.Summary.ID, SourceImport); err != nil {
+		return fmt.Errorf("failed to mark imported activity %d: %w", activity.Summary.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package pggeo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ImportFile records one imported GPX or TCX file by the SHA-256 of its content
+type ImportFile struct {
+	AthleteID  int64     `json:"athlete_id"`
+	Hash       string    `json:"hash"`
+	ActivityID int64     `json:"activity_id"`
+	Filename   string    `json:"filename"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// ImportFileHash returns the hex SHA-256 of a file's content. Two files with the same ride
+// but any difference in their bytes hash differently and are both imported.
+func ImportFileHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+const importFileColumns = `athlete_id, hash, activity_id, filename, imported_at`
+
+func scanImportFile(row pgx.Row) (ImportFile, error) {
+	var f ImportFile
+	err := row.Scan(&f.AthleteID, &f.Hash, &f.ActivityID, &f.Filename, &f.ImportedAt)
+	return f, err
+}
+
+// GetImportFileByHash returns the athlete's import of the file with that hash, nil when
+// the file was never imported or its activity is gone
+func GetImportFileByHash(ctx context.Context, conn DB, athleteID int64, hash string) (*ImportFile, error) {
+	f, err := scanImportFile(conn.QueryRow(ctx, `
+		SELECT `+importFileColumns+`
+		FROM import_files
+		WHERE athlete_id = $1 AND hash = $2
+	`, athleteID, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to look up import file: %w", err)
+	}
+	return &f, nil
+}
+
+// GetLatestImportFileByName returns the athlete's latest import of a file with that name,
+// nil when there is none
+func GetLatestImportFileByName(ctx context.Context, conn DB, athleteID int64, filename string) (*ImportFile, error) {
+	f, err := scanImportFile(conn.QueryRow(ctx, `
+		SELECT `+importFileColumns+`
+		FROM import_files
+		WHERE athlete_id = $1 AND filename = $2
+		ORDER BY imported_at DESC
+		LIMIT 1
+	`, athleteID, filename))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to look up import file: %w", err)
+	}
+	return &f, nil
+}
+
+// ListImportFiles returns every file the athlete imported, keyed by hash, so a directory
+// import can skip known files before parsing them
+func ListImportFiles(ctx context.Context, conn DB, athleteID int64) (map[string]ImportFile, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT `+importFileColumns+`
+		FROM import_files
+		WHERE athlete_id = $1
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list import files: %w", err)
+	}
+	defer rows.Close()
+	files := make(map[string]ImportFile)
+	for rows.Next() {
+		f, err := scanImportFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan import file: %w", err)
+		}
+		files[f.Hash] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list import files: %w", err)
+	}
+	return files, nil
+}
+
+// RecordImportFile stores the hash of a file just imported as f.ActivityID. When the file
+// replaced that activity's content, the hashes of the activity's earlier versions under
+// the same name are dropped so those versions import again.
+func RecordImportFile(ctx context.Context, conn DB, f ImportFile) error {
+	_, err := conn.Exec(ctx, `
+		WITH superseded AS (
+			DELETE FROM import_files
+			WHERE athlete_id = $1 AND activity_id = $3 AND filename = $4 AND hash <> $2
+		)
+		INSERT INTO import_files (athlete_id, hash, activity_id, filename)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (athlete_id, hash) DO UPDATE
+		SET activity_id = EXCLUDED.activity_id, filename = EXCLUDED.filename, imported_at = NOW()
+	`, f.AthleteID, f.Hash, f.ActivityID, f.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to record import file %s: %w", f.Filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package pggeo
+
+import "testing"
+
+func TestImportFileHashFollowsContent(t *testing.T) {
+	ride := `<gpx><trk><trkseg><trkpt lat="52.0" lon="4.0"/><trkpt lat="52.001" lon="4.0"/></trkseg></trk></gpx>`
+	hash := ImportFileHash([]byte(ride))
+	if len(hash) != 64 {
+		t.Fatalf("hash %q is not hex SHA-256", hash)
+	}
+	if again := ImportFileHash([]byte(ride)); again != hash {
+		t.Fatalf("same content hashed to %q and %q", hash, again)
+	}
+	// The same ride exported twice, e.g. with another creator, is a different file
+	if other := ImportFileHash([]byte(ride + "\n")); other == hash {
+		t.Fatal("distinct files share a hash")
+	}
+}
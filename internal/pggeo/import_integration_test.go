@@ -0,0 +1,115 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestImportedActivityGetsSequenceIDAndSource(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000105)
+	t.Cleanup(func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	})
+
+	activityID, err := NextImportedActivityID(ctx, conn)
+	if err != nil {
+		t.Fatalf("NextImportedActivityID: %v", err)
+	}
+	if activityID < ImportActivityIDBase || activityID >= SeedActivityIDBase {
+		t.Fatalf("imported activity ID %d outside [%d, %d)", activityID, ImportActivityIDBase, SeedActivityIDBase)
+	}
+	next, err := NextImportedActivityID(ctx, conn)
+	if err != nil || next <= activityID {
+		t.Fatalf("second NextImportedActivityID = %d, %v; want an ID above %d", next, err, activityID)
+	}
+
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID:            activityID,
+		AthleteID:     athleteID,
+		Name:          "Head unit ride",
+		Type:          "Ride",
+		SportType:     "Ride",
+		StartDate:     start.Format(time.RFC3339),
+		StartDateTime: start,
+	}}
+	for i := 0; i < 3; i++ {
+		activity.TimeStream.Data = append(activity.TimeStream.Data, start.Add(time.Duration(i)*time.Second))
+		activity.LatLngStream.Data = append(activity.LatLngStream.Data, []float64{selfCheckOriginLat + float64(i)*0.0001, selfCheckOriginLon})
+	}
+	if err := InsertImportedActivity(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertImportedActivity: %v", err)
+	}
+
+	var source string
+	if err := conn.QueryRow(ctx, `SELECT source FROM activity_summaries WHERE id = $1`, activityID).Scan(&source); err != nil {
+		t.Fatalf("read source: %v", err)
+	}
+	if source != SourceImport {
+		t.Fatalf("source = %q, want %q", source, SourceImport)
+	}
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	if err != nil || len(samples) != 3 {
+		t.Fatalf("point samples = %d, %v; want 3", len(samples), err)
+	}
+	route, err := GetRoutePointsForActivity(ctx, conn, athleteID, activityID)
+	if err != nil || len(route) != 3 {
+		t.Fatalf("route points = %d, %v; want 3", len(route), err)
+	}
+
+	activity.Summary.ID = 12345
+	if err := InsertImportedActivity(ctx, conn, activity); err == nil {
+		t.Fatal("InsertImportedActivity accepted an ID outside the imported range")
+	}
+}
+
+func TestLatestActivityStartDateIgnoresImports(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000106)
+	t.Cleanup(func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	})
+
+	if latest, err := GetLatestActivityStartDate(ctx, conn, athleteID); err != nil || latest != nil {
+		t.Fatalf("latest start without activities = %v, %v; want nil", latest, err)
+	}
+
+	newest := time.Date(2024, 5, 3, 7, 30, 0, 0, time.UTC)
+	importedID, err := NextImportedActivityID(ctx, conn)
+	if err != nil {
+		t.Fatalf("NextImportedActivityID: %v", err)
+	}
+	rides := []struct {
+		id     int64
+		start  time.Time
+		insert func(context.Context, DB, *strava.BikeActivity) error
+	}{
+		{990000106001, newest.Add(-48 * time.Hour), InsertBikeActivity},
+		{990000106002, newest, InsertBikeActivity},
+		{importedID, newest.Add(24 * time.Hour), InsertImportedActivity},
+	}
+	for _, ride := range rides {
+		activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+			ID: ride.id, AthleteID: athleteID, Name: "Ride", Type: "Ride", SportType: "Ride",
+			StartDate: ride.start.Format(time.RFC3339), StartDateTime: ride.start,
+		}}
+		for i := 0; i < 3; i++ {
+			activity.TimeStream.Data = append(activity.TimeStream.Data, ride.start.Add(time.Duration(i)*time.Second))
+			activity.LatLngStream.Data = append(activity.LatLngStream.Data, []float64{selfCheckOriginLat + float64(i)*0.0001, selfCheckOriginLon})
+		}
+		if err := ride.insert(ctx, conn, activity); err != nil {
+			t.Fatalf("insert activity %d: %v", ride.id, err)
+		}
+	}
+
+	latest, err := GetLatestActivityStartDate(ctx, conn, athleteID)
+	if err != nil || latest == nil || !latest.Equal(newest) {
+		t.Fatalf("latest start = %v, %v; want the newest Strava ride at %s", latest, err, newest)
+	}
+}
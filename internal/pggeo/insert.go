@@ -3,38 +3,23 @@ package pggeo
 import (
 	"context"
 	"fmt"
-	"log"
-	"math"
 	"strings"
+	"time"
 
+	"b11k/internal/geo"
 	"b11k/internal/strava"
-
-	"github.com/jackc/pgx/v5"
 )
 
-// haversineDistance calculates the distance between two points using the Haversine formula
-// Returns distance in meters
-func haversineDistance(lat1, lng1, lat2, lng2 float64) float64 {
-	const R = 6371000 // Earth radius in meters
-	dLat := (lat2 - lat1) * math.Pi / 180
-	dLng := (lng2 - lng1) * math.Pi / 180
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*
-			math.Sin(dLng/2)*math.Sin(dLng/2)
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	return R * c
-}
-
 // InsertActivitySummary inserts an activity summary into the database
 // Returns an error if the activity already exists
-func InsertActivitySummary(ctx context.Context, conn *pgx.Conn, activity *strava.ActivitySummary) error {
+func InsertActivitySummary(ctx context.Context, conn DB, activity *strava.ActivitySummary) error {
 	// Check if activity already exists
 	exists, err := ActivityExists(ctx, conn, activity.ID)
 	if err != nil {
 		return fmt.Errorf("failed to check if activity exists: %w", err)
 	}
 	if exists {
-		return fmt.Errorf("activity with ID %d already exists", activity.ID)
+		return alreadyExistsf(nil, "activity with ID %d already exists", activity.ID)
 	}
 	query := `
 	INSERT INTO activity_summaries (
@@ -74,17 +59,17 @@ func InsertActivitySummary(ctx context.Context, conn *pgx.Conn, activity *strava
 
 // InsertActivityGeometry inserts activity geometry data using the new schema
 // Returns an error if the activity doesn't exist in activity_summaries
-func InsertActivityGeometry(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64, latLngData [][]float64) error {
+func InsertActivityGeometry(ctx context.Context, conn DB, athleteID, activityID int64, latLngData [][]float64) error {
 	// Check if activity exists in summaries table
 	exists, err := ActivityExists(ctx, conn, activityID)
 	if err != nil {
 		return fmt.Errorf("failed to check if activity exists: %w", err)
 	}
 	if !exists {
-		return fmt.Errorf("activity with ID %d does not exist in activity_summaries", activityID)
+		return activityNotFoundf(nil, "activity with ID %d does not exist in activity_summaries", activityID)
 	}
 	if len(latLngData) < 2 {
-		return fmt.Errorf("need at least 2 points to create a linestring")
+		return invalidInputf("need at least 2 points to create a linestring")
 	}
 
 	// Extract longitude and latitude arrays for the helper function
@@ -105,7 +90,7 @@ func InsertActivityGeometry(ctx context.Context, conn *pgx.Conn, athleteID, acti
 	_, err = conn.Exec(ctx, query, activityID, athleteID, lons, lats)
 	if err != nil {
 		// If helper function doesn't exist, try direct PostGIS approach
-		log.Printf("⚠️ Helper function failed, trying direct PostGIS approach: %v", err)
+		logger().Warn("Helper function failed, trying direct PostGIS approach", "activity_id", activityID, "error", err)
 
 		// Create a simple linestring from the coordinates
 		points := make([]string, len(latLngData))
@@ -127,29 +112,30 @@ func InsertActivityGeometry(ctx context.Context, conn *pgx.Conn, athleteID, acti
 		}
 	}
 
-	// Refresh the simplified route with default tolerance (if helper function exists)
-	refreshQuery := `SELECT refresh_activity_simplified($1)`
-	_, err = conn.Exec(ctx, refreshQuery, activityID)
+	// Refresh the simplified route with the configured tolerance (if helper function exists)
+	refreshQuery := `SELECT refresh_activity_simplified($1, $2)`
+	_, err = conn.Exec(ctx, refreshQuery, activityID, SimplifyTolerance())
 	if err != nil {
 		// If helper function doesn't exist, skip the refresh (not critical)
-		log.Printf("⚠️ Warning: Could not refresh simplified geometry for activity %d: %v", activityID, err)
+		logger().Warn("Could not refresh simplified geometry", "activity_id", activityID, "error", err)
 	}
 	return nil
 }
 
 // InsertPointSamples inserts point samples for an activity
 // Returns an error if the activity doesn't exist in activity_summaries
-func InsertPointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.BikeActivity) error {
+func InsertPointSamples(ctx context.Context, conn DB, activity *strava.BikeActivity) error {
+	defer timeQuery("insert_point_samples", time.Now())
 	// Check if activity exists in summaries table
 	exists, err := ActivityExists(ctx, conn, activity.Summary.ID)
 	if err != nil {
 		return fmt.Errorf("failed to check if activity exists: %w", err)
 	}
 	if !exists {
-		return fmt.Errorf("activity with ID %d does not exist in activity_summaries", activity.Summary.ID)
+		return activityNotFoundf(nil, "activity with ID %d does not exist in activity_summaries", activity.Summary.ID)
 	}
 	if len(activity.TimeStream.Data) == 0 {
-		return fmt.Errorf("no time stream data available")
+		return invalidInputf("no time stream data available")
 	}
 
 	// Start a transaction for batch insert
@@ -159,6 +145,13 @@ func InsertPointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.Bi
 	}
 	defer tx.Rollback(ctx)
 
+	if writesPointStreams() {
+		if err := writePointStream(ctx, tx, activity); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
 	// Prepare the insert statement
 	query := `
 	INSERT INTO point_samples (
@@ -195,7 +188,7 @@ func InsertPointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.Bi
 
 			// Calculate cumulative distance
 			if hasPrevPoint {
-				cumulativeDistance += haversineDistance(prevLat, prevLng, lat, lng)
+				cumulativeDistance += geo.HaversineMeters(prevLat, prevLng, lat, lng)
 			}
 			prevLat = lat
 			prevLng = lng
@@ -247,19 +240,24 @@ func InsertPointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.Bi
 
 // InsertBikeActivity inserts a complete bike activity (summary, geometry, and points)
 // Returns an error if the activity already exists
-func InsertBikeActivity(ctx context.Context, conn *pgx.Conn, activity *strava.BikeActivity) error {
+func InsertBikeActivity(ctx context.Context, conn DB, activity *strava.BikeActivity) error {
 	// Insert activity summary
 	if err := InsertActivitySummary(ctx, conn, &activity.Summary); err != nil {
 		return fmt.Errorf("failed to insert activity summary: %w", err)
 	}
 
-	// Insert activity geometry if we have lat/lng data
-	if len(activity.LatLngStream.Data) > 0 {
-		if err := InsertActivityGeometry(ctx, conn, activity.Summary.AthleteID, activity.Summary.ID, activity.LatLngStream.Data); err != nil {
+	// Insert activity geometry if we have lat/lng data, falling back to the map polyline
+	route := activity.RouteLatLng()
+	if len(route) > 0 {
+		if err := InsertActivityGeometry(ctx, conn, activity.Summary.AthleteID, activity.Summary.ID, route); err != nil {
 			return fmt.Errorf("failed to insert activity geometry: %w", err)
 		}
 	}
 
+	if routeOnly(activity, route) {
+		return nil
+	}
+
 	// Insert point samples
 	if err := InsertPointSamples(ctx, conn, activity); err != nil {
 		return fmt.Errorf("failed to insert point samples: %w", err)
@@ -268,8 +266,9 @@ func InsertBikeActivity(ctx context.Context, conn *pgx.Conn, activity *strava.Bi
 	return nil
 }
 
-// InsertActivitySummaryUpsert inserts or updates an activity summary (allows overwriting existing data)
-func InsertActivitySummaryUpsert(ctx context.Context, conn *pgx.Conn, activity *strava.ActivitySummary) error {
+// InsertActivitySummaryUpsert inserts or updates an activity summary (allows overwriting existing data).
+// An activity stored for another athlete is left alone and ErrForeignAthlete returned.
+func InsertActivitySummaryUpsert(ctx context.Context, conn DB, activity *strava.ActivitySummary) error {
 	query := `
 	INSERT INTO activity_summaries (
 		id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain,
@@ -283,7 +282,7 @@ func InsertActivitySummaryUpsert(ctx context.Context, conn *pgx.Conn, activity *
 		$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30
 	) ON CONFLICT (id) DO UPDATE SET
 		athlete_id = EXCLUDED.athlete_id,
-		name = EXCLUDED.name,
+		name = CASE WHEN activity_summaries.name_edited_at IS NULL THEN EXCLUDED.name ELSE activity_summaries.name END,
 		distance = EXCLUDED.distance,
 		moving_time = EXCLUDED.moving_time,
 		elapsed_time = EXCLUDED.elapsed_time,
@@ -312,6 +311,7 @@ func InsertActivitySummaryUpsert(ctx context.Context, conn *pgx.Conn, activity *
 		max_watts = EXCLUDED.max_watts,
 		suffer_score = EXCLUDED.suffer_score,
 		updated_at = NOW()
+	WHERE activity_summaries.athlete_id = EXCLUDED.athlete_id
 	`
 
 	var startLat, startLng, endLat, endLng *float64
@@ -324,7 +324,7 @@ func InsertActivitySummaryUpsert(ctx context.Context, conn *pgx.Conn, activity *
 		endLng = &(*activity.EndLatLng)[1]
 	}
 
-	_, err := conn.Exec(ctx, query,
+	tag, err := conn.Exec(ctx, query,
 		activity.ID, activity.AthleteID, activity.Name, activity.Distance, activity.MovingTime, activity.ElapsedTime,
 		activity.TotalElevationGain, activity.Type, activity.SportType, activity.WorkoutType,
 		activity.StartDateTime, activity.UtcOffset, startLat, startLng, endLat, endLng,
@@ -333,24 +333,48 @@ func InsertActivitySummaryUpsert(ctx context.Context, conn *pgx.Conn, activity *
 		activity.Kilojoules, activity.AverageHeartrate, activity.MaxHeartrate, activity.MaxWatts,
 		activity.SufferScore,
 	)
-
-	return err
+	if err != nil {
+		return err
+	}
+	// The conflict update only applies to the athlete's own row
+	if tag.RowsAffected() == 0 {
+		return foreignAthletef("activity with ID %d belongs to another athlete", activity.ID)
+	}
+	return nil
 }
 
-// InsertBikeActivityUpsert inserts or updates a complete bike activity (allows overwriting existing data)
-func InsertBikeActivityUpsert(ctx context.Context, conn *pgx.Conn, activity *strava.BikeActivity) error {
+// InsertBikeActivityUpsert inserts or updates a complete bike activity (allows overwriting existing data).
+// Replacing a stored activity's route or timing invalidates its derived data first.
+func InsertBikeActivityUpsert(ctx context.Context, conn DB, activity *strava.BikeActivity) error {
+	defer timeQuery("save_activity", time.Now())
+	route := activity.RouteLatLng()
+	changed, err := activityDataChanged(ctx, conn, activity, route)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := OnActivityChanged(ctx, conn, activity.Summary.AthleteID, activity.Summary.ID, ActivityReplaced); err != nil {
+			return err
+		}
+	}
+
 	// Insert/update activity summary
 	if err := InsertActivitySummaryUpsert(ctx, conn, &activity.Summary); err != nil {
 		return fmt.Errorf("failed to upsert activity summary: %w", err)
 	}
 
-	// Insert/update activity geometry if we have lat/lng data
-	if len(activity.LatLngStream.Data) > 0 {
-		if err := InsertActivityGeometryUpsert(ctx, conn, activity.Summary.AthleteID, activity.Summary.ID, activity.LatLngStream.Data); err != nil {
+	// Insert/update activity geometry if we have lat/lng data, falling back to the map polyline
+	if len(route) > 0 {
+		if err := InsertActivityGeometryUpsert(ctx, conn, activity.Summary.AthleteID, activity.Summary.ID, route); err != nil {
 			return fmt.Errorf("failed to upsert activity geometry: %w", err)
 		}
 	}
 
+	if routeOnly(activity, route) {
+		logger().Info("Activity has no streams, saved its route from the map polyline", "activity_id", activity.Summary.ID)
+		return nil
+	}
+
 	// Delete existing point samples and insert new ones
 	if err := ReplacePointSamples(ctx, conn, activity); err != nil {
 		return fmt.Errorf("failed to replace point samples: %w", err)
@@ -359,10 +383,16 @@ func InsertBikeActivityUpsert(ctx context.Context, conn *pgx.Conn, activity *str
 	return nil
 }
 
+// routeOnly reports an activity that came without streams but whose route could still be
+// decoded from its polyline; it gets a geometry row but no point samples
+func routeOnly(activity *strava.BikeActivity, route [][]float64) bool {
+	return len(activity.TimeStream.Data) == 0 && len(activity.LatLngStream.Data) == 0 && len(route) > 0
+}
+
 // InsertActivityGeometryUpsert inserts or updates activity geometry data
-func InsertActivityGeometryUpsert(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64, latLngData [][]float64) error {
+func InsertActivityGeometryUpsert(ctx context.Context, conn DB, athleteID, activityID int64, latLngData [][]float64) error {
 	if len(latLngData) < 2 {
-		return fmt.Errorf("need at least 2 points to create a linestring")
+		return invalidInputf("need at least 2 points to create a linestring")
 	}
 
 	// Extract longitude and latitude arrays for the helper function
@@ -386,7 +416,7 @@ func InsertActivityGeometryUpsert(ctx context.Context, conn *pgx.Conn, athleteID
 	_, err := conn.Exec(ctx, query, activityID, athleteID, lons, lats)
 	if err != nil {
 		// If helper function doesn't exist, try direct PostGIS approach
-		log.Printf("⚠️ Helper function failed, trying direct PostGIS approach: %v", err)
+		logger().Warn("Helper function failed, trying direct PostGIS approach", "activity_id", activityID, "error", err)
 
 		// Create a simple linestring from the coordinates
 		points := make([]string, len(latLngData))
@@ -412,20 +442,21 @@ func InsertActivityGeometryUpsert(ctx context.Context, conn *pgx.Conn, athleteID
 		}
 	}
 
-	// Refresh the simplified route with default tolerance (if helper function exists)
-	refreshQuery := `SELECT refresh_activity_simplified($1)`
-	_, err = conn.Exec(ctx, refreshQuery, activityID)
+	// Refresh the simplified route with the configured tolerance (if helper function exists)
+	refreshQuery := `SELECT refresh_activity_simplified($1, $2)`
+	_, err = conn.Exec(ctx, refreshQuery, activityID, SimplifyTolerance())
 	if err != nil {
 		// If helper function doesn't exist, skip the refresh (not critical)
-		log.Printf("⚠️ Warning: Could not refresh simplified geometry for activity %d: %v", activityID, err)
+		logger().Warn("Could not refresh simplified geometry", "activity_id", activityID, "error", err)
 	}
 	return nil
 }
 
 // ReplacePointSamples deletes existing point samples and inserts new ones
-func ReplacePointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.BikeActivity) error {
+func ReplacePointSamples(ctx context.Context, conn DB, activity *strava.BikeActivity) error {
+	defer timeQuery("insert_point_samples", time.Now())
 	if len(activity.TimeStream.Data) == 0 {
-		return fmt.Errorf("no time stream data available")
+		return invalidInputf("no time stream data available")
 	}
 
 	// Start a transaction for batch operations
@@ -435,12 +466,26 @@ func ReplacePointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.B
 	}
 	defer tx.Rollback(ctx)
 
-	// Delete existing point samples
+	// Delete existing point samples in either layout
 	deleteQuery := `DELETE FROM point_samples WHERE activity_id = $1`
 	_, err = tx.Exec(ctx, deleteQuery, activity.Summary.ID)
 	if err != nil {
 		return fmt.Errorf("failed to delete existing point samples: %w", err)
 	}
+	if _, err := tx.Exec(ctx, `DELETE FROM activity_streams WHERE activity_id = $1`, activity.Summary.ID); err != nil {
+		return fmt.Errorf("failed to delete existing point stream: %w", err)
+	}
+	// The fresh streams carry Strava's own grades again
+	if _, err := tx.Exec(ctx, `UPDATE activity_summaries SET grades_derived = FALSE WHERE id = $1`, activity.Summary.ID); err != nil {
+		return fmt.Errorf("failed to reset derived grades flag: %w", err)
+	}
+
+	if writesPointStreams() {
+		if err := writePointStream(ctx, tx, activity); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
 
 	// Prepare the insert statement
 	insertQuery := `
@@ -480,7 +525,7 @@ func ReplacePointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.B
 
 			// Calculate cumulative distance
 			if hasPrevPoint {
-				cumulativeDistance += haversineDistance(prevLat, prevLng, lat, lng)
+				cumulativeDistance += geo.HaversineMeters(prevLat, prevLng, lat, lng)
 			}
 			prevLat = lat
 			prevLng = lng
@@ -532,15 +577,15 @@ func ReplacePointSamples(ctx context.Context, conn *pgx.Conn, activity *strava.B
 }
 
 // InsertBikeActivityWithLogging inserts a complete bike activity with logging
-func InsertBikeActivityWithLogging(ctx context.Context, conn *pgx.Conn, activity *strava.BikeActivity) error {
-	log.Printf("🚴 Starting to save complete bike activity %d (%s)", activity.Summary.ID, activity.Summary.Name)
+func InsertBikeActivityWithLogging(ctx context.Context, conn DB, activity *strava.BikeActivity) error {
+	logger().Debug("Saving activity", "activity_id", activity.Summary.ID)
 
 	err := InsertBikeActivityUpsert(ctx, conn, activity)
 	if err != nil {
-		log.Printf("❌ Error saving activity %d: %v", activity.Summary.ID, err)
+		logger().Error("Failed to save activity", "activity_id", activity.Summary.ID, "error", err)
 		return fmt.Errorf("failed to save bike activity: %w", err)
 	}
 
-	log.Printf("✅ Successfully saved complete bike activity %d", activity.Summary.ID)
+	logger().Info("Saved activity", "activity_id", activity.Summary.ID)
 	return nil
 }
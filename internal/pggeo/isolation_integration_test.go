@@ -0,0 +1,136 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// TestSpatialQueriesAndSegmentsStayWithTheirAthlete rides the same route as two athletes
+// and checks that neither sees the other's private activity or segment through any
+// spatial search, segment match or segment lookup.
+func TestSpatialQueriesAndSegmentsStayWithTheirAthlete(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const alice, bob = int64(990000803), int64(990000804)
+	const aliceRide, bobRide = int64(990000803000), int64(990000804000)
+	cleanup := func() {
+		for _, athleteID := range []int64{alice, bob} {
+			_, _ = conn.Exec(context.Background(), `DELETE FROM favorite_segments WHERE athlete_id = $1`, athleteID)
+			_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	ride := similarFixtureRide(alice, aliceRide, 0, 12, 0)
+	if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	if err := InsertBikeActivity(ctx, conn, similarFixtureRide(bob, bobRide, 0, 12, 0)); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	segment, err := InsertFavoriteSegment(ctx, conn, alice, "Shared road", "", ride.LatLngStream.Data[2:8], nil, nil)
+	if err != nil {
+		t.Fatalf("InsertFavoriteSegment: %v", err)
+	}
+
+	lat, lon := ride.LatLngStream.Data[5][0], ride.LatLngStream.Data[5][1]
+	line := fmt.Sprintf("LINESTRING(%f %f, %f %f)", lon, ride.LatLngStream.Data[2][0], lon, ride.LatLngStream.Data[8][0])
+	found := func(viewer int64) map[string][]int64 {
+		t.Helper()
+		result := map[string][]int64{}
+		near, err := FindActivitiesNear(ctx, conn, viewer, lon, lat, 50)
+		if err != nil {
+			t.Fatalf("FindActivitiesNear: %v", err)
+		}
+		for _, n := range near {
+			result["near"] = append(result["near"], n.ActivityID)
+		}
+		crossing, err := FindActivitiesIntersectingLine(ctx, conn, viewer, line, 15)
+		if err != nil {
+			t.Fatalf("FindActivitiesIntersectingLine: %v", err)
+		}
+		for _, c := range crossing {
+			result["line"] = append(result["line"], c.ActivityID)
+		}
+		inBox, err := GetActivitiesInBoundingBox(ctx, conn, viewer, lat-0.001, lon-0.001, lat+0.001, lon+0.001)
+		if err != nil {
+			t.Fatalf("GetActivitiesInBoundingBox: %v", err)
+		}
+		for _, a := range inBox {
+			result["bbox"] = append(result["bbox"], a.ID)
+		}
+		return result
+	}
+	only := func(viewer int64, want ...int64) {
+		t.Helper()
+		results := found(viewer)
+		for _, search := range []string{"near", "line", "bbox"} {
+			if ids := slices.Sorted(slices.Values(results[search])); !slices.Equal(ids, want) {
+				t.Fatalf("%s search by athlete %d found %v, want %v", search, viewer, ids, want)
+			}
+		}
+	}
+	only(alice, aliceRide)
+	only(bob, bobRide)
+
+	// The SQL helpers filter on their own, without the Go wrappers
+	var leaked int
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM find_activities_near($1, $2, $3, 50) WHERE activity_id = $4`, alice, lon, lat, bobRide).Scan(&leaked); err != nil || leaked != 0 {
+		t.Fatalf("find_activities_near for alice found bob's ride %d times, %v", leaked, err)
+	}
+
+	// Instance-visible activities are shared with everyone, as elsewhere
+	if err := SetActivityVisibility(ctx, conn, bob, bobRide, ActivityVisibilityInstance); err != nil {
+		t.Fatalf("SetActivityVisibility: %v", err)
+	}
+	only(alice, aliceRide, bobRide)
+	only(bob, bobRide)
+
+	// Segments only match their owner's activities, shared or not
+	matches, err := FindRoutePartsMatchingSegment(ctx, conn, alice, segment.ID, 15)
+	if err != nil || len(matches) != 1 || matches[0].ActivityID != aliceRide {
+		t.Fatalf("alice's segment matched %+v, %v; want only her ride", matches, err)
+	}
+	if matches, err := FindRoutePartsMatchingSegmentInActivities(ctx, conn, alice, segment.ID, 15, []int64{aliceRide, bobRide}); err != nil || len(matches) != 1 {
+		t.Fatalf("alice's segment among both rides matched %+v, %v", matches, err)
+	}
+	if matches, err := FindRoutePartsMatchingSegment(ctx, conn, bob, segment.ID, 15); err != nil || len(matches) != 0 {
+		t.Fatalf("bob matched alice's segment: %+v, %v", matches, err)
+	}
+	efforts, err := GetActivitiesForSegment(ctx, conn, alice, segment.ID, 15, "", true, 0, SegmentEffortFilter{})
+	if err != nil || len(efforts) != 1 || efforts[0].ID != aliceRide {
+		t.Fatalf("efforts on alice's segment = %+v, %v; want only her ride, not bob's shared one", efforts, err)
+	}
+
+	// Another athlete's segment is not found, whatever is done with it
+	for name, call := range map[string]func() error{
+		"GetFavoriteSegment": func() error { _, err := GetFavoriteSegment(ctx, conn, bob, segment.ID); return err },
+		"UpdateFavoriteSegment": func() error {
+			_, err := UpdateFavoriteSegment(ctx, conn, bob, segment.ID, "Taken", "", ride.LatLngStream.Data[0:2], nil)
+			return err
+		},
+		"UpdateFavoriteSegmentDetails": func() error {
+			_, err := UpdateFavoriteSegmentDetails(ctx, conn, bob, segment.ID, "Taken", "")
+			return err
+		},
+		"SetSegmentDefaultTolerance": func() error {
+			tolerance := 30.0
+			_, err := SetSegmentDefaultTolerance(ctx, conn, bob, segment.ID, &tolerance)
+			return err
+		},
+		"DeleteFavoriteSegment": func() error { return DeleteFavoriteSegment(ctx, conn, bob, segment.ID) },
+	} {
+		if err := call(); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s by another athlete: error = %v, want ErrNotFound", name, err)
+		}
+	}
+	kept, err := GetFavoriteSegment(ctx, conn, alice, segment.ID)
+	if err != nil || kept.Name != "Shared road" || kept.DefaultToleranceM != nil || kept.SegmentGeog != segment.SegmentGeog {
+		t.Fatalf("alice's segment after bob's attempts = %+v, %v", kept, err)
+	}
+}
@@ -0,0 +1,15 @@
+package pggeo
+
+import (
+	"time"
+
+	"b11k/internal/metrics"
+)
+
+// timeQuery records a heavy call started at started in db_query_duration_seconds under
+// name; call it deferred at the top of the call. Timed: segment_match,
+// match_new_activities, save_activity, insert_point_samples, compute_new_distances and
+// rebuild_discovered_coverage.
+func timeQuery(name string, started time.Time) {
+	metrics.DBQuery(name, time.Since(started))
+}
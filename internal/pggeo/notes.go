@@ -0,0 +1,29 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxActivityNotesLength caps the markdown notes of one activity, in bytes
+const MaxActivityNotesLength = 20000
+
+// SetActivityNotes replaces the markdown notes of a single activity owned by athleteID.
+// Empty notes are stored as NULL.
+func SetActivityNotes(ctx context.Context, conn DB, athleteID, activityID int64, notes string) error {
+	if len(notes) > MaxActivityNotesLength {
+		return invalidInputf("notes are longer than %d bytes", MaxActivityNotesLength)
+	}
+	tag, err := conn.Exec(ctx, `
+		UPDATE activity_summaries
+		SET notes = NULLIF($1, ''), updated_at = NOW()
+		WHERE athlete_id = $2 AND id = $3
+	`, notes, athleteID, activityID)
+	if err != nil {
+		return fmt.Errorf("failed to update activity notes: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestActivityNotesSurviveResyncUpsert(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000405), int64(990000405001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	activity := &strava.ActivitySummary{
+		ID:        activityID,
+		AthleteID: athleteID,
+		Name:      "Tempo",
+		Type:      "Ride",
+		SportType: "Ride",
+		StartDate: "2024-05-02T07:00:00Z",
+		Distance:  30000,
+	}
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+	}
+	const notes = "## Legs\n\n*heavy* after Tuesday"
+	if err := SetActivityNotes(ctx, conn, athleteID, activityID, notes); err != nil {
+		t.Fatalf("SetActivityNotes: %v", err)
+	}
+	if err := SetActivityNotes(ctx, conn, athleteID+1, activityID, "mine now"); err == nil {
+		t.Fatal("another athlete could change the notes")
+	}
+	if err := SetActivityNotes(ctx, conn, athleteID, activityID, strings.Repeat("x", MaxActivityNotesLength+1)); err == nil {
+		t.Fatal("overlong notes were accepted")
+	}
+
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("re-sync upsert: %v", err)
+	}
+	stored, err := GetActivityByID(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetActivityByID: %v", err)
+	}
+	if stored.Notes != notes {
+		t.Fatalf("notes = %q, want %q", stored.Notes, notes)
+	}
+
+	if err := SetActivityNotes(ctx, conn, athleteID, activityID, ""); err != nil {
+		t.Fatalf("clear notes: %v", err)
+	}
+	var isNull bool
+	if err := conn.QueryRow(ctx, `SELECT notes IS NULL FROM activity_summaries WHERE id = $1`, activityID).Scan(&isNull); err != nil {
+		t.Fatalf("read notes: %v", err)
+	}
+	if !isNull {
+		t.Fatal("cleared notes were not stored as NULL")
+	}
+}
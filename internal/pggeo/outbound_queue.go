@@ -0,0 +1,76 @@
+package pggeo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"b11k/internal/outbound"
+)
+
+// SaveOutboundWebhookEvents appends events to the endpoint's saved webhook queue
+func SaveOutboundWebhookEvents(ctx context.Context, conn DB, endpointURL string, events []outbound.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	ids := make([]string, len(events))
+	types := make([]string, len(events))
+	athleteIDs := make([]int64, len(events))
+	createdAts := make([]time.Time, len(events))
+	data := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+		types[i] = event.Type
+		athleteIDs[i] = event.AthleteID
+		createdAts[i] = event.CreatedAt
+		data[i] = string(event.Data)
+	}
+	_, err := conn.Exec(ctx, `
+		INSERT INTO outbound_webhook_queue (endpoint_url, event_id, event_type, athlete_id, created_at, data)
+		SELECT $1, e.event_id, e.event_type, e.athlete_id, e.created_at, e.data
+		FROM UNNEST($2::text[], $3::text[], $4::bigint[], $5::timestamptz[], $6::text[]) WITH ORDINALITY
+			AS e(event_id, event_type, athlete_id, created_at, data, ord)
+		ORDER BY e.ord
+	`, endpointURL, ids, types, athleteIDs, createdAts, data)
+	if err != nil {
+		return fmt.Errorf("failed to save outbound webhook events: %w", err)
+	}
+	return nil
+}
+
+// TakeOutboundWebhookEvents deletes and returns up to limit of the endpoint's oldest
+// saved webhook events
+func TakeOutboundWebhookEvents(ctx context.Context, conn DB, endpointURL string, limit int) ([]outbound.Event, error) {
+	rows, err := conn.Query(ctx, `
+		WITH taken AS (
+			DELETE FROM outbound_webhook_queue
+			WHERE id IN (
+				SELECT id FROM outbound_webhook_queue
+				WHERE endpoint_url = $1
+				ORDER BY id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, event_id, event_type, athlete_id, created_at, data
+		)
+		SELECT event_id, event_type, athlete_id, created_at, data FROM taken ORDER BY id
+	`, endpointURL, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take outbound webhook events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]outbound.Event, 0)
+	for rows.Next() {
+		var event outbound.Event
+		var data string
+		if err := rows.Scan(&event.ID, &event.Type, &event.AthleteID, &event.CreatedAt, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan outbound webhook event: %w", err)
+		}
+		event.CreatedAt = event.CreatedAt.UTC()
+		event.Data = json.RawMessage(data)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
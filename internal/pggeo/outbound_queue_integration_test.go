@@ -0,0 +1,46 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"b11k/internal/outbound"
+)
+
+func TestOutboundWebhookQueueSaveAndTake(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const endpointURL = "https://hooks.example.test/b11k-integration"
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM outbound_webhook_queue WHERE endpoint_url = $1`, endpointURL)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	created := time.Date(2026, 5, 1, 8, 30, 0, 0, time.UTC)
+	var events []outbound.Event
+	for _, id := range []string{"a", "b", "c"} {
+		events = append(events, outbound.Event{ID: id, Type: outbound.EventActivityCreated, CreatedAt: created, AthleteID: 990000778, Data: json.RawMessage(`{"id":1}`)})
+	}
+	if err := SaveOutboundWebhookEvents(ctx, conn, endpointURL, events); err != nil {
+		t.Fatal(err)
+	}
+
+	taken, err := TakeOutboundWebhookEvents(ctx, conn, endpointURL, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taken) != 2 || taken[0].ID != "a" || taken[1].ID != "b" || !taken[0].CreatedAt.Equal(created) || string(taken[0].Data) != `{"id":1}` {
+		t.Fatalf("taken = %+v, want the two oldest events intact", taken)
+	}
+	rest, err := TakeOutboundWebhookEvents(ctx, conn, endpointURL, 10)
+	if err != nil || len(rest) != 1 || rest[0].ID != "c" {
+		t.Fatalf("rest = %+v, %v; want the remaining event", rest, err)
+	}
+	if empty, err := TakeOutboundWebhookEvents(ctx, conn, endpointURL, 10); err != nil || len(empty) != 0 {
+		t.Fatalf("after taking everything = %+v, %v", empty, err)
+	}
+}
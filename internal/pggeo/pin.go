@@ -0,0 +1,74 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"b11k/internal/strava"
+)
+
+// SetActivityPinned pins or unpins a single activity owned by athleteID
+func SetActivityPinned(ctx context.Context, conn DB, athleteID, activityID int64, pinned bool) error {
+	tag, err := conn.Exec(ctx, `
+		UPDATE activity_summaries
+		SET pinned = $1, updated_at = NOW()
+		WHERE athlete_id = $2 AND id = $3
+	`, pinned, athleteID, activityID)
+	if err != nil {
+		return fmt.Errorf("failed to update activity pin: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	return nil
+}
+
+// SetSegmentPinned pins or unpins a single favorite segment owned by athleteID
+func SetSegmentPinned(ctx context.Context, conn DB, athleteID, segmentID int64, pinned bool) error {
+	tag, err := conn.Exec(ctx, `
+		UPDATE favorite_segments
+		SET pinned = $1, updated_at = NOW()
+		WHERE athlete_id = $2 AND id = $3
+	`, pinned, athleteID, segmentID)
+	if err != nil {
+		return fmt.Errorf("failed to update segment pin: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return segmentNotFoundf(nil, "segment with ID %d not found", segmentID)
+	}
+	return nil
+}
+
+// SortActivitiesPinnedFirst moves pinned activities to the front, keeping the existing
+// order within the pinned and unpinned groups
+func SortActivitiesPinnedFirst(activities []strava.ActivitySummary) {
+	sort.SliceStable(activities, func(i, j int) bool {
+		return activities[i].Pinned && !activities[j].Pinned
+	})
+}
+
+// SortSegmentsPinnedFirst is SortActivitiesPinnedFirst for favorite segments
+func SortSegmentsPinnedFirst(segments []FavoriteSegment) {
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Pinned && !segments[j].Pinned
+	})
+}
+
+// SortSegmentSummariesPinnedFirst is SortActivitiesPinnedFirst for segment dashboard rows
+func SortSegmentSummariesPinnedFirst(summaries []SegmentDashboardSummary) {
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].Pinned && !summaries[j].Pinned
+	})
+}
+
+// PinnedActivities returns the pinned activities in their current order
+func PinnedActivities(activities []strava.ActivitySummary) []strava.ActivitySummary {
+	var pinned []strava.ActivitySummary
+	for _, activity := range activities {
+		if activity.Pinned {
+			pinned = append(pinned, activity)
+		}
+	}
+	return pinned
+}
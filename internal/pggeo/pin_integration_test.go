@@ -0,0 +1,55 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestPinnedSurvivesResyncUpsert(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000101), int64(990000101001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	activity := &strava.ActivitySummary{
+		ID:        activityID,
+		AthleteID: athleteID,
+		Name:      "Morning Ride",
+		Type:      "Ride",
+		SportType: "Ride",
+		StartDate: "2024-05-01T07:00:00Z",
+		Distance:  12000,
+	}
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+	}
+	if err := SetActivityPinned(ctx, conn, athleteID, activityID, true); err != nil {
+		t.Fatalf("SetActivityPinned: %v", err)
+	}
+	if err := SetActivityPinned(ctx, conn, athleteID+1, activityID, false); err == nil {
+		t.Fatal("another athlete could unpin the activity")
+	}
+
+	activity.Name = "Renamed on Strava"
+	if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("re-sync upsert: %v", err)
+	}
+
+	stored, err := GetActivityByID(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetActivityByID: %v", err)
+	}
+	if stored.Name != "Renamed on Strava" {
+		t.Fatalf("name = %q, want the re-synced name", stored.Name)
+	}
+	if !stored.Pinned {
+		t.Fatal("pinned flag lost on re-sync")
+	}
+}
@@ -0,0 +1,308 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Point storage layouts. Rows keep one point_samples row per GPS point; streams keep one
+// activity_streams row per activity holding every point in arrays, which Postgres
+// compresses and which needs no per-point indexes. Readers see both through the
+// point_sample_rows view, so a library may mix them while it is converted.
+const (
+	PointStorageRows    = "rows"
+	PointStorageStreams = "streams"
+)
+
+// ValidPointStorage reports whether layout is one of the point storage layouts
+func ValidPointStorage(layout string) bool {
+	return layout == PointStorageRows || layout == PointStorageStreams
+}
+
+// pointStorage is the layout new point samples are written in; rows until
+// SetPointStorage is called
+var pointStorage atomic.Value
+
+// SetPointStorage makes the package write new point samples in layout; anything but
+// PointStorageStreams writes rows
+func SetPointStorage(layout string) {
+	pointStorage.Store(layout)
+}
+
+func writesPointStreams() bool {
+	layout, _ := pointStorage.Load().(string)
+	return layout == PointStorageStreams
+}
+
+// pointStream is an activity's point samples as the columns of activity_streams. Points
+// without a location are left out, as they are from point_samples, so PointIndexes may
+// skip numbers.
+type pointStream struct {
+	PointIndexes        []int32
+	Times               []time.Time
+	Lats                []float64
+	Lngs                []float64
+	Altitudes           []*float64
+	Heartrates          []*int
+	Speeds              []*float64
+	Watts               []*int
+	Cadences            []*int
+	Grades              []*float64
+	Moving              []*bool
+	Temperatures        []*int
+	CumulativeDistances []float64
+}
+
+// newPointStream builds the stream of activity with the values the point_samples rows
+// would get: coordinates rounded to the 8 decimals rows are written with, and the
+// cumulative distance from Strava's distance stream, else summed along the route
+func newPointStream(activity *strava.BikeActivity) pointStream {
+	var stream pointStream
+	var cumulativeDistance, prevLat, prevLng float64
+	hasPrevPoint := false
+	for i := 0; i < len(activity.TimeStream.Data); i++ {
+		if i >= len(activity.LatLngStream.Data) || len(activity.LatLngStream.Data[i]) < 2 {
+			continue
+		}
+		lat := roundCoordinate(activity.LatLngStream.Data[i][0])
+		lng := roundCoordinate(activity.LatLngStream.Data[i][1])
+		if hasPrevPoint {
+			cumulativeDistance += geo.HaversineMeters(prevLat, prevLng, activity.LatLngStream.Data[i][0], activity.LatLngStream.Data[i][1])
+		}
+		prevLat, prevLng = activity.LatLngStream.Data[i][0], activity.LatLngStream.Data[i][1]
+		hasPrevPoint = true
+
+		stream.PointIndexes = append(stream.PointIndexes, int32(i))
+		stream.Times = append(stream.Times, activity.TimeStream.Data[i])
+		stream.Lats = append(stream.Lats, lat)
+		stream.Lngs = append(stream.Lngs, lng)
+		stream.Altitudes = append(stream.Altitudes, streamValue(activity.AltitudeStream.Data, i))
+		stream.Heartrates = append(stream.Heartrates, streamValue(activity.HeartrateStream.Data, i))
+		stream.Speeds = append(stream.Speeds, streamValue(activity.SpeedStream.Data, i))
+		stream.Watts = append(stream.Watts, streamValue(activity.WattsStream.Data, i))
+		stream.Cadences = append(stream.Cadences, streamValue(activity.CadenceStream.Data, i))
+		stream.Grades = append(stream.Grades, streamValue(activity.GradeStream.Data, i))
+		stream.Moving = append(stream.Moving, streamValue(activity.MovingStream.Data, i))
+		stream.Temperatures = append(stream.Temperatures, streamValue(activity.TemperatureStream.Data, i))
+		if i < len(activity.DistanceStream.Data) {
+			stream.CumulativeDistances = append(stream.CumulativeDistances, activity.DistanceStream.Data[i])
+		} else {
+			stream.CumulativeDistances = append(stream.CumulativeDistances, cumulativeDistance)
+		}
+	}
+	return stream
+}
+
+func streamValue[T any](data []T, i int) *T {
+	if i < len(data) {
+		return &data[i]
+	}
+	return nil
+}
+
+// roundCoordinate rounds like the "%.8f" of the point_samples WKT, so both layouts
+// return the same coordinates
+func roundCoordinate(value float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(value, 'f', 8, 64), 64)
+	return rounded
+}
+
+// writePointStream replaces the activity's stream with the points of activity
+func writePointStream(ctx context.Context, tx DB, activity *strava.BikeActivity) error {
+	stream := newPointStream(activity)
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO activity_streams (
+			activity_id, athlete_id, point_count, ids, point_indexes, times, lats, lngs, altitudes,
+			heartrates, speeds, watts, cadences, grades, moving, temperatures, cumulative_distances
+		) VALUES (
+			$1, $2, $3,
+			ARRAY(SELECT nextval(pg_get_serial_sequence('point_samples', 'id')) FROM generate_series(1, $3)),
+			$4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16
+		)
+		ON CONFLICT (activity_id) DO UPDATE SET
+			athlete_id = EXCLUDED.athlete_id, point_count = EXCLUDED.point_count, ids = EXCLUDED.ids,
+			point_indexes = EXCLUDED.point_indexes, times = EXCLUDED.times, lats = EXCLUDED.lats,
+			lngs = EXCLUDED.lngs, altitudes = EXCLUDED.altitudes, heartrates = EXCLUDED.heartrates,
+			speeds = EXCLUDED.speeds, watts = EXCLUDED.watts, cadences = EXCLUDED.cadences,
+			grades = EXCLUDED.grades, moving = EXCLUDED.moving, temperatures = EXCLUDED.temperatures,
+			cumulative_distances = EXCLUDED.cumulative_distances, created_at = NOW()
+	`, activity.Summary.ID, activity.Summary.AthleteID, len(stream.PointIndexes), stream.PointIndexes,
+		stream.Times, stream.Lats, stream.Lngs, stream.Altitudes, stream.Heartrates, stream.Speeds,
+		stream.Watts, stream.Cadences, stream.Grades, stream.Moving, stream.Temperatures,
+		stream.CumulativeDistances); err != nil {
+		return fmt.Errorf("failed to write point stream: %w", err)
+	}
+	return nil
+}
+
+// updatePointStream sets column of the activity's stream at pointIndexes to values, a
+// slice of float64 or *float64, the array counterpart of an UPDATE point_samples ... FROM
+// UNNEST. With onlyNull, values
+// already set are kept. Activities stored as rows are left alone.
+func updatePointStream(ctx context.Context, tx DB, activityID int64, column string, pointIndexes []int, values any, onlyNull bool) error {
+	merged := "CASE WHEN n.point_index IS NULL THEN o.value ELSE n.value END"
+	if onlyNull {
+		merged = "COALESCE(o.value, n.value)"
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`
+		UPDATE activity_streams s
+		SET %[1]s = (
+			SELECT array_agg(%[2]s ORDER BY o.ord)
+			FROM unnest(s.point_indexes, s.%[1]s) WITH ORDINALITY AS o(point_index, value, ord)
+			LEFT JOIN unnest($2::integer[], $3::double precision[]) AS n(point_index, value)
+				ON n.point_index = o.point_index
+		)
+		WHERE s.activity_id = $1
+	`, column, merged), activityID, pointIndexes, values); err != nil {
+		return fmt.Errorf("failed to update %s of point stream: %w", column, err)
+	}
+	return nil
+}
+
+// PointStorageUsage counts the stored points of each layout
+type PointStorageUsage struct {
+	RowActivities    int64 `json:"row_activities"`
+	RowPoints        int64 `json:"row_points"`
+	StreamActivities int64 `json:"stream_activities"`
+	StreamPoints     int64 `json:"stream_points"`
+}
+
+// PointStorageConversion counts what ConvertPointStorage moved
+type PointStorageConversion struct {
+	Activities int   `json:"activities"`
+	Points     int64 `json:"points"`
+}
+
+// ConvertPointStorage moves the point samples of every activity not yet stored in
+// layout into it, one activity per transaction, so it can be stopped and run again.
+// athleteID of zero converts every athlete. Responses read from either layout alike.
+func ConvertPointStorage(ctx context.Context, conn DB, athleteID int64, layout string) (*PointStorageConversion, error) {
+	if !ValidPointStorage(layout) {
+		return nil, invalidInputf("point storage must be %s or %s", PointStorageRows, PointStorageStreams)
+	}
+	source := `SELECT DISTINCT activity_id FROM point_samples WHERE $1 = 0 OR athlete_id = $1 ORDER BY activity_id`
+	if layout == PointStorageRows {
+		source = `SELECT activity_id FROM activity_streams WHERE $1 = 0 OR athlete_id = $1 ORDER BY activity_id`
+	}
+	rows, err := conn.Query(ctx, source, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find activities to convert: %w", err)
+	}
+	activityIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("failed to find activities to convert: %w", err)
+	}
+
+	result := &PointStorageConversion{}
+	for _, activityID := range activityIDs {
+		points, err := convertActivityPoints(ctx, conn, activityID, layout)
+		if err != nil {
+			return result, err
+		}
+		result.Activities++
+		result.Points += points
+		logger().Debug("Converted point samples", "activity_id", activityID, "layout", layout, "points", points)
+	}
+	return result, nil
+}
+
+// convertActivityPoints moves one activity's points into layout and returns how many
+func convertActivityPoints(ctx context.Context, conn DB, activityID int64, layout string) (int64, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var points int64
+	if layout == PointStorageStreams {
+		err = tx.QueryRow(ctx, `
+			WITH moved AS (
+				INSERT INTO activity_streams (
+					activity_id, athlete_id, point_count, ids, point_indexes, times, lats, lngs, altitudes,
+					heartrates, speeds, watts, cadences, grades, moving, temperatures, cumulative_distances
+				)
+				SELECT activity_id, MIN(athlete_id), COUNT(*),
+					array_agg(id ORDER BY point_index),
+					array_agg(point_index ORDER BY point_index),
+					array_agg(time ORDER BY point_index),
+					array_agg(ST_Y(location::geometry) ORDER BY point_index),
+					array_agg(ST_X(location::geometry) ORDER BY point_index),
+					array_agg(altitude ORDER BY point_index),
+					array_agg(heartrate ORDER BY point_index),
+					array_agg(speed ORDER BY point_index),
+					array_agg(watts ORDER BY point_index),
+					array_agg(cadence ORDER BY point_index),
+					array_agg(grade ORDER BY point_index),
+					array_agg(moving ORDER BY point_index),
+					array_agg(temperature ORDER BY point_index),
+					array_agg(cumulative_distance ORDER BY point_index)
+				FROM point_samples
+				WHERE activity_id = $1
+				GROUP BY activity_id
+				ON CONFLICT (activity_id) DO NOTHING
+				RETURNING point_count
+			)
+			SELECT point_count FROM moved
+		`, activityID).Scan(&points)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return 0, fmt.Errorf("failed to convert points of activity %d to a stream: %w", activityID, err)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			// A stream written since is newer than the rows
+			points = 0
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM point_samples WHERE activity_id = $1`, activityID); err != nil {
+			return 0, fmt.Errorf("failed to delete converted point samples of activity %d: %w", activityID, err)
+		}
+	} else {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO point_samples (
+				id, activity_id, athlete_id, point_index, time, location, altitude, heartrate,
+				speed, watts, cadence, grade, moving, temperature, cumulative_distance
+			)
+			SELECT id, activity_id, athlete_id, point_index, time, location, altitude, heartrate,
+				speed, watts, cadence, grade, moving, temperature, cumulative_distance
+			FROM point_sample_rows
+			WHERE activity_id = $1
+				AND NOT EXISTS (SELECT 1 FROM point_samples WHERE activity_id = $1)
+			ORDER BY point_index
+		`, activityID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert the stream of activity %d to rows: %w", activityID, err)
+		}
+		points = tag.RowsAffected()
+		if _, err := tx.Exec(ctx, `DELETE FROM activity_streams WHERE activity_id = $1`, activityID); err != nil {
+			return 0, fmt.Errorf("failed to delete converted stream of activity %d: %w", activityID, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit converted activity %d: %w", activityID, err)
+	}
+	return points, nil
+}
+
+// GetPointStorageUsage counts the activities and points stored in each layout, exactly,
+// for athleteID or every athlete when it is zero
+func GetPointStorageUsage(ctx context.Context, conn DB, athleteID int64) (*PointStorageUsage, error) {
+	usage := &PointStorageUsage{}
+	if err := conn.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT activity_id) FROM point_samples WHERE $1 = 0 OR athlete_id = $1),
+			(SELECT COUNT(*) FROM point_samples WHERE $1 = 0 OR athlete_id = $1),
+			(SELECT COUNT(*) FROM activity_streams WHERE $1 = 0 OR athlete_id = $1),
+			(SELECT COALESCE(SUM(point_count), 0) FROM activity_streams WHERE $1 = 0 OR athlete_id = $1)
+	`, athleteID).Scan(&usage.RowActivities, &usage.RowPoints, &usage.StreamActivities, &usage.StreamPoints); err != nil {
+		return nil, fmt.Errorf("failed to count stored points: %w", err)
+	}
+	return usage, nil
+}
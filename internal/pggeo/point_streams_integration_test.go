@@ -0,0 +1,96 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPointStreamsReadLikeRowsAndConvertBothWays(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000800)
+	const base = int64(990000800000)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+	t.Cleanup(func() { SetPointStorage(PointStorageRows) })
+
+	ride := similarFixtureRide(athleteID, base, 0, 12, 0)
+	ride.LatLngStream.Data[4] = nil // a point without location leaves a gap in point_index
+	for i := range ride.TimeStream.Data {
+		ride.AltitudeStream.Data = append(ride.AltitudeStream.Data, 100+float64(i)*1.5)
+		ride.HeartrateStream.Data = append(ride.HeartrateStream.Data, 120+i)
+	}
+	SetPointStorage(PointStorageRows)
+	if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	rows, err := GetPointSamplesForActivity(ctx, conn, athleteID, base)
+	if err != nil || len(rows) != 11 {
+		t.Fatalf("samples as rows = %d, %v; want 11", len(rows), err)
+	}
+
+	converted, err := ConvertPointStorage(ctx, conn, athleteID, PointStorageStreams)
+	if err != nil || converted.Activities != 1 || converted.Points != 11 {
+		t.Fatalf("ConvertPointStorage to streams = %+v, %v", converted, err)
+	}
+	usage, err := GetPointStorageUsage(ctx, conn, athleteID)
+	if err != nil || *usage != (PointStorageUsage{StreamActivities: 1, StreamPoints: 11}) {
+		t.Fatalf("usage after converting = %+v, %v", usage, err)
+	}
+	streams, err := GetPointSamplesForActivity(ctx, conn, athleteID, base)
+	if err != nil || !reflect.DeepEqual(streams, rows) {
+		t.Fatalf("samples as a stream differ from rows:\n%+v\n%+v (%v)", streams, rows, err)
+	}
+	part, err := GetPointSamplesForActivityRange(ctx, conn, athleteID, base, 3, 6)
+	if err != nil || !reflect.DeepEqual(part, rows[3:6]) {
+		t.Fatalf("range of a stream = %+v, %v; want points 3, 5 and 6", part, err)
+	}
+	if again, err := ConvertPointStorage(ctx, conn, athleteID, PointStorageStreams); err != nil || again.Activities != 0 {
+		t.Fatalf("converting again = %+v, %v; want nothing to do", again, err)
+	}
+
+	// Updates reach the stream
+	if err := ReplaceActivityGrades(ctx, conn, athleteID, base, []int{0, 5}, []float64{2.5, -1}); err != nil {
+		t.Fatalf("ReplaceActivityGrades: %v", err)
+	}
+	graded, err := GetPointSamplesForActivity(ctx, conn, athleteID, base)
+	if err != nil || graded[0].Grade == nil || *graded[0].Grade != 2.5 || graded[4].Grade == nil || *graded[4].Grade != -1 || graded[1].Grade != nil {
+		t.Fatalf("grades of the stream = %+v, %v", graded, err)
+	}
+
+	back, err := ConvertPointStorage(ctx, conn, athleteID, PointStorageRows)
+	if err != nil || back.Activities != 1 || back.Points != 11 {
+		t.Fatalf("ConvertPointStorage to rows = %+v, %v", back, err)
+	}
+	restored, err := GetPointSamplesForActivity(ctx, conn, athleteID, base)
+	if err != nil || !reflect.DeepEqual(restored, graded) {
+		t.Fatalf("samples converted back differ:\n%+v\n%+v (%v)", restored, graded, err)
+	}
+
+	// Written as a stream, a ride reads back as the same points it has as rows
+	SetPointStorage(PointStorageStreams)
+	ride.Summary.ID = base + 1
+	if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+		t.Fatalf("InsertBikeActivity as a stream: %v", err)
+	}
+	written, err := GetPointSamplesForActivity(ctx, conn, athleteID, base+1)
+	if err != nil || len(written) != len(rows) {
+		t.Fatalf("samples written as a stream = %d, %v", len(written), err)
+	}
+	for i := range written {
+		want := rows[i]
+		want.ID, want.ActivityID = written[i].ID, base+1
+		if !reflect.DeepEqual(written[i], want) {
+			t.Fatalf("point %d written as a stream = %+v, want %+v", i, written[i], want)
+		}
+	}
+	usage, err = GetPointStorageUsage(ctx, conn, athleteID)
+	if err != nil || *usage != (PointStorageUsage{RowActivities: 1, RowPoints: 11, StreamActivities: 1, StreamPoints: 11}) {
+		t.Fatalf("usage with both layouts = %+v, %v", usage, err)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestActivityWithoutStreamsGetsRouteFromPolyline(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000102), int64(990000102001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_geometries WHERE activity_id = $1`, activityID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID:        activityID,
+		AthleteID: athleteID,
+		Name:      "Privacy-restricted ride",
+		Type:      "Ride",
+		SportType: "Ride",
+		StartDate: "2019-06-01T07:00:00Z",
+	}}
+	activity.Map.Polyline = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+
+	if err := InsertBikeActivityUpsert(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertBikeActivityUpsert: %v", err)
+	}
+
+	route, err := GetRoutePointsForActivity(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetRoutePointsForActivity: %v", err)
+	}
+	if len(route) != 3 || math.Abs(route[0].Lat-38.5) > 1e-6 || math.Abs(route[2].Lng+126.453) > 1e-6 {
+		t.Fatalf("route = %+v, want the 3 decoded polyline points", route)
+	}
+}
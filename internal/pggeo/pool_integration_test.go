@@ -0,0 +1,51 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPoolRunsQueriesConcurrently(t *testing.T) {
+	dsn := os.Getenv("B11K_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("B11K_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	// Two slow queries through the shared DB interface must overlap, not queue
+	var db DB = pool
+	const sleep = 300 * time.Millisecond
+	started := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.Exec(ctx, "SELECT pg_sleep($1)", sleep.Seconds())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("pg_sleep: %v", err)
+		}
+	}
+	if elapsed := time.Since(started); elapsed >= 2*sleep {
+		t.Fatalf("queries took %s, want them to run concurrently (< %s)", elapsed, 2*sleep)
+	}
+}
@@ -0,0 +1,215 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Fields a public stats token can expose. None of them reveal a location.
+const (
+	PublicStatDistanceKM       = "distance_km"
+	PublicStatRides            = "rides"
+	PublicStatHours            = "hours"
+	PublicStatElevationM       = "elevation_m"
+	PublicStatYearDistanceKM   = "year_distance_km"
+	PublicStatLastActivityDate = "last_activity_date"
+)
+
+// PublicStatFields lists every public stats field, the default set of a new token
+var PublicStatFields = []string{
+	PublicStatDistanceKM,
+	PublicStatRides,
+	PublicStatHours,
+	PublicStatElevationM,
+	PublicStatYearDistanceKM,
+	PublicStatLastActivityDate,
+}
+
+// ValidPublicStatField reports whether field is one of PublicStatFields
+func ValidPublicStatField(field string) bool {
+	for _, known := range PublicStatFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// PublicStatsToken lets anyone holding the token read the chosen stats of an athlete.
+// Only the token's hash is stored.
+type PublicStatsToken struct {
+	ID        int64      `json:"id"`
+	AthleteID int64      `json:"-"`
+	Fields    []string   `json:"fields"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxViews  *int64     `json:"max_views"`
+	// Views counts the requests the token answered
+	Views int64 `json:"views"`
+}
+
+// ShareLimits end a share token before it is revoked; nil fields do not limit it
+type ShareLimits struct {
+	ExpiresAt *time.Time
+	MaxViews  *int64
+}
+
+// ErrShareTokenGone is a token past its expiry or its views. Its row is kept until the
+// sweep deletes it, so it answers as gone rather than as never issued.
+var ErrShareTokenGone = errors.New("share token expired or used up")
+
+const publicStatsTokenColumns = `id, athlete_id, fields, created_at, expires_at, max_views, views`
+
+func scanPublicStatsToken(row pgx.Row) (*PublicStatsToken, error) {
+	var token PublicStatsToken
+	err := row.Scan(&token.ID, &token.AthleteID, &token.Fields, &token.CreatedAt, &token.ExpiresAt, &token.MaxViews, &token.Views)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// PublicStats are the all-time totals behind a public stats token
+type PublicStats struct {
+	Activities     int
+	DistanceM      float64
+	MovingTimeS    float64
+	ElevationGainM float64
+	YearDistanceM  float64    // activities starting on or after the yearStart passed to GetPublicStats
+	LastActivity   *time.Time // start of the most recent activity, nil without activities
+}
+
+// CreatePublicStatsToken stores a token under its hash with the fields it exposes and
+// the limits it ends at
+func CreatePublicStatsToken(ctx context.Context, conn DB, athleteID int64, tokenKey string, fields []string, limits ShareLimits) (*PublicStatsToken, error) {
+	for _, field := range fields {
+		if !ValidPublicStatField(field) {
+			return nil, invalidInputf("unknown public stats field %q", field)
+		}
+	}
+	if limits.MaxViews != nil && *limits.MaxViews <= 0 {
+		return nil, invalidInputf("max views must be positive, got %d", *limits.MaxViews)
+	}
+	token, err := scanPublicStatsToken(conn.QueryRow(ctx, `
+		INSERT INTO public_stats_tokens (token_key, athlete_id, fields, expires_at, max_views)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+publicStatsTokenColumns,
+		tokenKey, athleteID, fields, limits.ExpiresAt, limits.MaxViews))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create public stats token: %w", err)
+	}
+	return token, nil
+}
+
+// GetPublicStatsToken returns the token stored under tokenKey, or nil when there is none.
+// It neither counts a view nor checks the limits; see UsePublicStatsToken.
+func GetPublicStatsToken(ctx context.Context, conn DB, tokenKey string) (*PublicStatsToken, error) {
+	token, err := scanPublicStatsToken(conn.QueryRow(ctx, `
+		SELECT `+publicStatsTokenColumns+` FROM public_stats_tokens WHERE token_key = $1
+	`, tokenKey))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public stats token: %w", err)
+	}
+	return token, nil
+}
+
+// UsePublicStatsToken counts a view of the token stored under tokenKey and returns it
+// with the view counted, or nil when there is none. A token past its expiry at now or
+// out of views is ErrShareTokenGone. The view is taken by a single conditional UPDATE,
+// so concurrent requests never answer more than MaxViews between them.
+func UsePublicStatsToken(ctx context.Context, conn DB, tokenKey string, now time.Time) (*PublicStatsToken, error) {
+	token, err := scanPublicStatsToken(conn.QueryRow(ctx, `
+		UPDATE public_stats_tokens SET views = views + 1
+		WHERE token_key = $1
+		  AND (expires_at IS NULL OR expires_at > $2)
+		  AND (max_views IS NULL OR views < max_views)
+		RETURNING `+publicStatsTokenColumns,
+		tokenKey, now))
+	if err == nil {
+		return token, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to use public stats token: %w", err)
+	}
+	var exists bool
+	if err := conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM public_stats_tokens WHERE token_key = $1)
+	`, tokenKey).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check public stats token: %w", err)
+	}
+	if exists {
+		return nil, ErrShareTokenGone
+	}
+	return nil, nil
+}
+
+// DeleteExpiredPublicStatsTokens deletes the tokens that expired by now and returns how
+// many it deleted. Tokens out of views are kept for their owner to see until revoked.
+func DeleteExpiredPublicStatsTokens(ctx context.Context, conn DB, now time.Time) (int64, error) {
+	tag, err := conn.Exec(ctx, `DELETE FROM public_stats_tokens WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired public stats tokens: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListPublicStatsTokens returns the athlete's tokens, newest first
+func ListPublicStatsTokens(ctx context.Context, conn DB, athleteID int64) ([]PublicStatsToken, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT `+publicStatsTokenColumns+`
+		FROM public_stats_tokens
+		WHERE athlete_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query public stats tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]PublicStatsToken, 0)
+	for rows.Next() {
+		token, err := scanPublicStatsToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan public stats token: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// DeletePublicStatsTokens revokes one of the athlete's tokens, or all of them when
+// tokenID is 0, and returns how many were revoked
+func DeletePublicStatsTokens(ctx context.Context, conn DB, athleteID, tokenID int64) (int64, error) {
+	tag, err := conn.Exec(ctx, `
+		DELETE FROM public_stats_tokens WHERE athlete_id = $1 AND ($2 = 0 OR id = $2)
+	`, athleteID, tokenID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete public stats tokens: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetPublicStats totals all of the athlete's activities with one query
+func GetPublicStats(ctx context.Context, conn DB, athleteID int64, yearStart time.Time) (*PublicStats, error) {
+	var stats PublicStats
+	err := conn.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(distance), 0), COALESCE(SUM(moving_time), 0),
+			   COALESCE(SUM(total_elevation_gain), 0),
+			   COALESCE(SUM(distance) FILTER (WHERE start_date >= $2), 0),
+			   MAX(start_date)
+		FROM activity_summaries
+		WHERE athlete_id = $1
+	`, athleteID, yearStart).Scan(&stats.Activities, &stats.DistanceM, &stats.MovingTimeS,
+		&stats.ElevationGainM, &stats.YearDistanceM, &stats.LastActivity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query public stats: %w", err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,179 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPublicStatsTokensAndTotals(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000776)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM public_stats_tokens WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for i, start := range []string{"2023-12-30T08:00:00Z", "2024-03-02T08:00:00Z"} {
+		if err := InsertActivitySummaryUpsert(ctx, conn, &strava.ActivitySummary{
+			ID: athleteID*10 + int64(i), AthleteID: athleteID, Name: "Ride", Type: "Ride", SportType: "Ride",
+			StartDate: start, Distance: 10000, MovingTime: 1800, TotalElevationGain: 100,
+		}); err != nil {
+			t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+		}
+	}
+	stats, err := GetPublicStats(ctx, conn, athleteID, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("GetPublicStats: %v", err)
+	}
+	if stats.Activities != 2 || stats.DistanceM != 20000 || stats.MovingTimeS != 3600 || stats.ElevationGainM != 200 || stats.YearDistanceM != 10000 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats.LastActivity == nil || !stats.LastActivity.Equal(time.Date(2024, 3, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("last activity = %v", stats.LastActivity)
+	}
+
+	if _, err := CreatePublicStatsToken(ctx, conn, athleteID, "key-bad", []string{"start_latlng"}, ShareLimits{}); err == nil {
+		t.Fatal("created a token with a location field")
+	}
+	first, err := CreatePublicStatsToken(ctx, conn, athleteID, "key-a", []string{PublicStatRides}, ShareLimits{})
+	if err != nil {
+		t.Fatalf("CreatePublicStatsToken: %v", err)
+	}
+	if _, err := CreatePublicStatsToken(ctx, conn, athleteID, "key-b", PublicStatFields, ShareLimits{}); err != nil {
+		t.Fatalf("CreatePublicStatsToken: %v", err)
+	}
+	token, err := GetPublicStatsToken(ctx, conn, "key-a")
+	if err != nil || token == nil || token.AthleteID != athleteID || len(token.Fields) != 1 || token.Fields[0] != PublicStatRides {
+		t.Fatalf("GetPublicStatsToken = %+v, %v", token, err)
+	}
+	if tokens, err := ListPublicStatsTokens(ctx, conn, athleteID); err != nil || len(tokens) != 2 {
+		t.Fatalf("ListPublicStatsTokens = %+v, %v; want 2", tokens, err)
+	}
+
+	if n, err := DeletePublicStatsTokens(ctx, conn, athleteID+1, first.ID); err != nil || n != 0 {
+		t.Fatalf("another athlete revoked %d tokens, %v", n, err)
+	}
+	if n, err := DeletePublicStatsTokens(ctx, conn, athleteID, first.ID); err != nil || n != 1 {
+		t.Fatalf("DeletePublicStatsTokens = %d, %v; want 1", n, err)
+	}
+	if token, err := GetPublicStatsToken(ctx, conn, "key-a"); err != nil || token != nil {
+		t.Fatalf("revoked token = %+v, %v; want none", token, err)
+	}
+	if n, err := DeletePublicStatsTokens(ctx, conn, athleteID, 0); err != nil || n != 1 {
+		t.Fatalf("revoking all = %d, %v; want 1", n, err)
+	}
+}
+
+func TestPublicStatsTokenLimits(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000777)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM public_stats_tokens WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	now := time.Now()
+	past, future, views := now.Add(-time.Minute), now.Add(time.Hour), int64(2)
+	if _, err := CreatePublicStatsToken(ctx, conn, athleteID, "key-zero", PublicStatFields, ShareLimits{MaxViews: new(int64)}); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("zero max views = %v, want ErrInvalidInput", err)
+	}
+	for key, limits := range map[string]ShareLimits{
+		"key-expired": {ExpiresAt: &past},
+		"key-limited": {ExpiresAt: &future, MaxViews: &views},
+	} {
+		if _, err := CreatePublicStatsToken(ctx, conn, athleteID, key, PublicStatFields, limits); err != nil {
+			t.Fatalf("CreatePublicStatsToken(%s): %v", key, err)
+		}
+	}
+
+	if token, err := UsePublicStatsToken(ctx, conn, "key-expired", now); !errors.Is(err, ErrShareTokenGone) || token != nil {
+		t.Fatalf("expired token = %+v, %v; want ErrShareTokenGone", token, err)
+	}
+	for i := int64(1); i <= views; i++ {
+		token, err := UsePublicStatsToken(ctx, conn, "key-limited", now)
+		if err != nil || token == nil || token.Views != i || *token.MaxViews != views {
+			t.Fatalf("view %d = %+v, %v", i, token, err)
+		}
+	}
+	if _, err := UsePublicStatsToken(ctx, conn, "key-limited", now); !errors.Is(err, ErrShareTokenGone) {
+		t.Fatalf("view past max views = %v, want ErrShareTokenGone", err)
+	}
+	if _, err := UsePublicStatsToken(ctx, conn, "key-limited", future.Add(time.Second)); !errors.Is(err, ErrShareTokenGone) {
+		t.Fatalf("view after expiry = %v, want ErrShareTokenGone", err)
+	}
+	if token, err := UsePublicStatsToken(ctx, conn, "key-never-issued", now); err != nil || token != nil {
+		t.Fatalf("unknown token = %+v, %v; want none", token, err)
+	}
+
+	// The sweep deletes only the expired token; the used up one stays listed
+	if n, err := DeleteExpiredPublicStatsTokens(ctx, conn, now); err != nil || n != 1 {
+		t.Fatalf("DeleteExpiredPublicStatsTokens = %d, %v; want 1", n, err)
+	}
+	if token, err := UsePublicStatsToken(ctx, conn, "key-expired", now); err != nil || token != nil {
+		t.Fatalf("swept token = %+v, %v; want none", token, err)
+	}
+	tokens, err := ListPublicStatsTokens(ctx, conn, athleteID)
+	if err != nil || len(tokens) != 1 || tokens[0].Views != views {
+		t.Fatalf("ListPublicStatsTokens = %+v, %v; want the used up token", tokens, err)
+	}
+}
+
+func TestPublicStatsTokenViewsUnderConcurrentRequests(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	pool, err := pgxpool.New(ctx, os.Getenv("B11K_TEST_DATABASE_URL"))
+	if err != nil {
+		t.Fatalf("connect pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	const athleteID = int64(990000778)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM public_stats_tokens WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	maxViews := int64(5)
+	if _, err := CreatePublicStatsToken(ctx, conn, athleteID, "key-concurrent", PublicStatFields, ShareLimits{MaxViews: &maxViews}); err != nil {
+		t.Fatalf("CreatePublicStatsToken: %v", err)
+	}
+
+	const requests = 40
+	var served, gone atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := UsePublicStatsToken(ctx, pool, "key-concurrent", time.Now())
+			switch {
+			case errors.Is(err, ErrShareTokenGone):
+				gone.Add(1)
+			case err != nil || token == nil:
+				t.Errorf("UsePublicStatsToken = %+v, %v", token, err)
+			default:
+				served.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if served.Load() != maxViews || gone.Load() != requests-maxViews {
+		t.Fatalf("served %d and refused %d of %d requests, want %d served", served.Load(), gone.Load(), requests, maxViews)
+	}
+	if token, err := GetPublicStatsToken(ctx, conn, "key-concurrent"); err != nil || token.Views != maxViews {
+		t.Fatalf("token after the requests = %+v, %v; want %d views", token, err, maxViews)
+	}
+}
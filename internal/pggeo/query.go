@@ -2,8 +2,8 @@ package pggeo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"sort"
 	"strings"
 	"time"
@@ -14,14 +14,15 @@ import (
 )
 
 // GetActivityByID retrieves an activity summary by ID
-func GetActivityByID(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64) (*strava.ActivitySummary, error) {
+func GetActivityByID(ctx context.Context, conn DB, athleteID, activityID int64) (*strava.ActivitySummary, error) {
 	query := `
 	SELECT id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain,
 		   type, sport_type, workout_type, start_date, utc_offset,
 		   start_lat, start_lng, end_lat, end_lng,
 		   location_city, location_state, location_country, gear_id, gear_name,
 		   average_speed, max_speed, average_cadence, average_watts,
-		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score
+		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score, visibility, pinned,
+		   COALESCE(notes, ''), COALESCE(description, ''), new_distance_m
 	FROM activity_summaries
 	WHERE athlete_id = $1 AND id = $2
 	`
@@ -40,12 +41,13 @@ func GetActivityByID(ctx context.Context, conn *pgx.Conn, athleteID, activityID
 		&locationCity, &locationState, &activity.LocationCountry, &activity.GearID, &activity.GearName,
 		&activity.AverageSpeed, &activity.MaxSpeed, &activity.AverageCadence, &activity.AverageWatts,
 		&activity.Kilojoules, &activity.AverageHeartrate, &activity.MaxHeartrate, &activity.MaxWatts,
-		&activity.SufferScore,
+		&activity.SufferScore, &activity.InstanceVisibility, &activity.Pinned,
+		&activity.Notes, &activity.Description, &activity.NewDistance,
 	)
 
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("activity with ID %d not found", activityID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, activityNotFoundf(err, "activity with ID %d not found", activityID)
 		}
 		return nil, fmt.Errorf("failed to scan activity: %w", err)
 	}
@@ -64,7 +66,7 @@ func GetActivityByID(ctx context.Context, conn *pgx.Conn, athleteID, activityID
 	return &activity, nil
 }
 
-func UpdateGearNameForGearID(ctx context.Context, conn *pgx.Conn, athleteID int64, gearID, gearName string) error {
+func UpdateGearNameForGearID(ctx context.Context, conn DB, athleteID int64, gearID, gearName string) error {
 	_, err := conn.Exec(ctx, `
 		UPDATE activity_summaries
 		SET gear_name = $1, updated_at = NOW()
@@ -74,14 +76,14 @@ func UpdateGearNameForGearID(ctx context.Context, conn *pgx.Conn, athleteID int6
 }
 
 // GetActivitiesByDateRange retrieves activities within a date range for a specific athlete
-func GetActivitiesByDateRange(ctx context.Context, conn *pgx.Conn, athleteID int64, startDate, endDate time.Time) ([]strava.ActivitySummary, error) {
+func GetActivitiesByDateRange(ctx context.Context, conn DB, athleteID int64, startDate, endDate time.Time) ([]strava.ActivitySummary, error) {
 	query := `
 	SELECT id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain,
 		   type, sport_type, workout_type, start_date, utc_offset,
 		   start_lat, start_lng, end_lat, end_lng,
 		   location_city, location_state, location_country, gear_id, gear_name,
 		   average_speed, max_speed, average_cadence, average_watts,
-		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score
+		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score, visibility, pinned
 	FROM activity_summaries
 	WHERE athlete_id = $1 AND start_date >= $2 AND start_date <= $3
 	ORDER BY start_date DESC
@@ -107,7 +109,7 @@ func GetActivitiesByDateRange(ctx context.Context, conn *pgx.Conn, athleteID int
 			&locationCity, &locationState, &activity.LocationCountry, &activity.GearID, &activity.GearName,
 			&activity.AverageSpeed, &activity.MaxSpeed, &activity.AverageCadence, &activity.AverageWatts,
 			&activity.Kilojoules, &activity.AverageHeartrate, &activity.MaxHeartrate, &activity.MaxWatts,
-			&activity.SufferScore,
+			&activity.SufferScore, &activity.InstanceVisibility, &activity.Pinned,
 		)
 
 		if err != nil {
@@ -132,14 +134,14 @@ func GetActivitiesByDateRange(ctx context.Context, conn *pgx.Conn, athleteID int
 }
 
 // GetAllActivities retrieves all activities for a specific athlete ordered by start date descending
-func GetAllActivities(ctx context.Context, conn *pgx.Conn, athleteID int64) ([]strava.ActivitySummary, error) {
+func GetAllActivities(ctx context.Context, conn DB, athleteID int64) ([]strava.ActivitySummary, error) {
 	query := `
 	SELECT id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain,
 		   type, sport_type, workout_type, start_date, utc_offset,
 		   start_lat, start_lng, end_lat, end_lng,
 		   location_city, location_state, location_country, gear_id, gear_name,
 		   average_speed, max_speed, average_cadence, average_watts,
-		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score
+		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score, visibility, pinned
 	FROM activity_summaries
 	WHERE athlete_id = $1
 	ORDER BY start_date DESC
@@ -165,7 +167,7 @@ func GetAllActivities(ctx context.Context, conn *pgx.Conn, athleteID int64) ([]s
 			&locationCity, &locationState, &activity.LocationCountry, &activity.GearID, &activity.GearName,
 			&activity.AverageSpeed, &activity.MaxSpeed, &activity.AverageCadence, &activity.AverageWatts,
 			&activity.Kilojoules, &activity.AverageHeartrate, &activity.MaxHeartrate, &activity.MaxWatts,
-			&activity.SufferScore,
+			&activity.SufferScore, &activity.InstanceVisibility, &activity.Pinned,
 		)
 
 		if err != nil {
@@ -189,22 +191,24 @@ func GetAllActivities(ctx context.Context, conn *pgx.Conn, athleteID int64) ([]s
 	return activities, rows.Err()
 }
 
-// GetActivitiesInBoundingBox retrieves activities that intersect with a bounding box
-func GetActivitiesInBoundingBox(ctx context.Context, conn *pgx.Conn, minLat, minLng, maxLat, maxLng float64) ([]strava.ActivitySummary, error) {
+// GetActivitiesInBoundingBox retrieves activities that intersect with a bounding box.
+// Other athletes' activities are only included when they are instance-visible.
+func GetActivitiesInBoundingBox(ctx context.Context, conn DB, viewerAthleteID int64, minLat, minLng, maxLat, maxLng float64) ([]strava.ActivitySummary, error) {
 	query := `
 	SELECT s.id, s.athlete_id, s.name, s.distance, s.moving_time, s.elapsed_time, s.total_elevation_gain,
 		   s.type, s.sport_type, s.workout_type, s.start_date, s.utc_offset,
 		   s.start_lat, s.start_lng, s.end_lat, s.end_lng,
 		   s.location_city, s.location_state, s.location_country, s.gear_id, s.gear_name,
 		   s.average_speed, s.max_speed, s.average_cadence, s.average_watts,
-		   s.kilojoules, s.average_heartrate, s.max_heartrate, s.max_watts, s.suffer_score
+		   s.kilojoules, s.average_heartrate, s.max_heartrate, s.max_watts, s.suffer_score, s.visibility, s.pinned
 	FROM activity_summaries s
 	JOIN activity_geometries g ON s.id = g.activity_id
 	WHERE g.route_bbox_geom && ST_MakeEnvelope($1, $2, $3, $4, 4326)
+	  AND ` + visibleToViewerSQL("s", "$5") + `
 	ORDER BY s.start_date DESC
 	`
 
-	rows, err := conn.Query(ctx, query, minLng, minLat, maxLng, maxLat)
+	rows, err := conn.Query(ctx, query, minLng, minLat, maxLng, maxLat, viewerAthleteID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query activities in bounding box: %w", err)
 	}
@@ -224,7 +228,7 @@ func GetActivitiesInBoundingBox(ctx context.Context, conn *pgx.Conn, minLat, min
 			&locationCity, &locationState, &activity.LocationCountry, &activity.GearID, &activity.GearName,
 			&activity.AverageSpeed, &activity.MaxSpeed, &activity.AverageCadence, &activity.AverageWatts,
 			&activity.Kilojoules, &activity.AverageHeartrate, &activity.MaxHeartrate, &activity.MaxWatts,
-			&activity.SufferScore,
+			&activity.SufferScore, &activity.InstanceVisibility, &activity.Pinned,
 		)
 
 		if err != nil {
@@ -249,12 +253,12 @@ func GetActivitiesInBoundingBox(ctx context.Context, conn *pgx.Conn, minLat, min
 }
 
 // GetPointSamplesForActivity retrieves all point samples for a specific activity
-func GetPointSamplesForActivity(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64) ([]PointSample, error) {
+func GetPointSamplesForActivity(ctx context.Context, conn DB, athleteID, activityID int64) ([]PointSample, error) {
 	query := `
 	SELECT id, activity_id, athlete_id, point_index, time, 
 		   ST_Y(location::geometry) as lat, ST_X(location::geometry) as lng,
 		   altitude, heartrate, speed, watts, cadence, grade, moving, temperature, cumulative_distance
-	FROM point_samples
+	FROM point_sample_rows
 	WHERE athlete_id = $1 AND activity_id = $2
 	ORDER BY point_index
 	`
@@ -263,9 +267,62 @@ func GetPointSamplesForActivity(ctx context.Context, conn *pgx.Conn, athleteID,
 	if err != nil {
 		return nil, fmt.Errorf("failed to query point samples: %w", err)
 	}
-	defer rows.Close()
+	return scanPointSamples(rows)
+}
+
+// StreamPointSamplesForActivity calls fn with each point sample of the activity in
+// point_index order as it is read, so memory does not grow with the activity length.
+// It returns how many samples were passed to fn; an error from fn stops the stream.
+func StreamPointSamplesForActivity(ctx context.Context, conn DB, athleteID, activityID int64, fn func(PointSample) error) (int, error) {
+	query := `
+	SELECT id, activity_id, athlete_id, point_index, time, 
+		   ST_Y(location::geometry) as lat, ST_X(location::geometry) as lng,
+		   altitude, heartrate, speed, watts, cadence, grade, moving, temperature, cumulative_distance
+	FROM point_sample_rows
+	WHERE athlete_id = $1 AND activity_id = $2
+	ORDER BY point_index
+	`
+
+	rows, err := conn.Query(ctx, query, athleteID, activityID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query point samples: %w", err)
+	}
+	return eachPointSample(rows, fn)
+}
+
+// GetPointSamplesForActivityRange retrieves point samples with point_index between startIndex and endIndex (inclusive)
+func GetPointSamplesForActivityRange(ctx context.Context, conn DB, athleteID, activityID int64, startIndex, endIndex int) ([]PointSample, error) {
+	query := `
+	SELECT id, activity_id, athlete_id, point_index, time, 
+		   ST_Y(location::geometry) as lat, ST_X(location::geometry) as lng,
+		   altitude, heartrate, speed, watts, cadence, grade, moving, temperature, cumulative_distance
+	FROM point_sample_rows
+	WHERE athlete_id = $1 AND activity_id = $2 AND point_index BETWEEN $3 AND $4
+	ORDER BY point_index
+	`
 
+	rows, err := conn.Query(ctx, query, athleteID, activityID, startIndex, endIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query point samples: %w", err)
+	}
+	return scanPointSamples(rows)
+}
+
+func scanPointSamples(rows pgx.Rows) ([]PointSample, error) {
 	var samples []PointSample
+	if _, err := eachPointSample(rows, func(sample PointSample) error {
+		samples = append(samples, sample)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func eachPointSample(rows pgx.Rows, fn func(PointSample) error) (int, error) {
+	defer rows.Close()
+
+	count := 0
 	for rows.Next() {
 		var sample PointSample
 		err := rows.Scan(
@@ -276,17 +333,33 @@ func GetPointSamplesForActivity(ctx context.Context, conn *pgx.Conn, athleteID,
 		)
 
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan point sample: %w", err)
+			return count, fmt.Errorf("failed to scan point sample: %w", err)
 		}
 
-		samples = append(samples, sample)
+		if err := fn(sample); err != nil {
+			return count, err
+		}
+		count++
 	}
 
-	return samples, rows.Err()
+	return count, rows.Err()
 }
 
 // GetRoutePointsForActivity retrieves route coordinates from the stored activity geometry.
-func GetRoutePointsForActivity(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64) ([]PointSample, error) {
+func GetRoutePointsForActivity(ctx context.Context, conn DB, athleteID, activityID int64) ([]PointSample, error) {
+	var samples []PointSample
+	if _, err := StreamRoutePointsForActivity(ctx, conn, athleteID, activityID, func(sample PointSample) error {
+		samples = append(samples, sample)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// StreamRoutePointsForActivity calls fn with each coordinate of the stored activity
+// geometry in order, like StreamPointSamplesForActivity
+func StreamRoutePointsForActivity(ctx context.Context, conn DB, athleteID, activityID int64, fn func(PointSample) error) (int, error) {
 	query := `
 	SELECT
 		(dp.path[1] - 1)::integer AS point_index,
@@ -300,22 +373,25 @@ func GetRoutePointsForActivity(ctx context.Context, conn *pgx.Conn, athleteID, a
 
 	rows, err := conn.Query(ctx, query, athleteID, activityID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query route geometry points: %w", err)
+		return 0, fmt.Errorf("failed to query route geometry points: %w", err)
 	}
 	defer rows.Close()
 
-	var samples []PointSample
+	count := 0
 	for rows.Next() {
 		var sample PointSample
 		sample.ActivityID = activityID
 		sample.AthleteID = athleteID
 		if err := rows.Scan(&sample.PointIndex, &sample.Lat, &sample.Lng); err != nil {
-			return nil, fmt.Errorf("failed to scan route geometry point: %w", err)
+			return count, fmt.Errorf("failed to scan route geometry point: %w", err)
 		}
-		samples = append(samples, sample)
+		if err := fn(sample); err != nil {
+			return count, err
+		}
+		count++
 	}
 
-	return samples, rows.Err()
+	return count, rows.Err()
 }
 
 // GraphDataPoint represents a single data point in a graph time series
@@ -332,6 +408,12 @@ type GraphData struct {
 	Heartrate []GraphDataPoint `json:"heartrate,omitempty"`
 	Height    []GraphDataPoint `json:"height,omitempty"`
 	Cadence   []GraphDataPoint `json:"cadence,omitempty"`
+	Watts     []GraphDataPoint `json:"watts,omitempty"`
+	Grade     []GraphDataPoint `json:"grade,omitempty"` // percent
+	// Timing is set when the samples contain duplicate or backward timestamps
+	Timing *TimingQuality `json:"timing_quality,omitempty"`
+	// GPS is set when GPS spikes were found in the whole activity's route
+	GPS *GPSQuality `json:"gps_quality,omitempty"`
 }
 
 type HRZoneDistribution struct {
@@ -340,10 +422,11 @@ type HRZoneDistribution struct {
 	Min        int     `json:"min"`
 	Max        int     `json:"max"`
 	Samples    int     `json:"samples"`
+	Seconds    float64 `json:"seconds"` // Time in zone; samples sharing a timestamp add nothing
 	Percentage float64 `json:"percentage"`
 }
 
-func GetHRZoneDistributionForActivity(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64, hrZones *strava.HeartRateZones) ([]HRZoneDistribution, error) {
+func GetHRZoneDistributionForActivity(ctx context.Context, conn DB, athleteID, activityID int64, hrZones *strava.HeartRateZones) ([]HRZoneDistribution, error) {
 	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
 	if err != nil {
 		return nil, err
@@ -351,29 +434,67 @@ func GetHRZoneDistributionForActivity(ctx context.Context, conn *pgx.Conn, athle
 	return calculateHRZoneDistribution(samples, hrZones), nil
 }
 
-func GetHRZoneDistributionForSegmentInActivity(ctx context.Context, conn *pgx.Conn, athleteID, activityID, segmentID int64, toleranceMeters float64, hrZones *strava.HeartRateZones) ([]HRZoneDistribution, error) {
-	var startIndex, endIndex int
-	if err := conn.QueryRow(ctx,
-		`SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`,
-		segmentID, activityID, athleteID, toleranceMeters,
-	).Scan(&startIndex, &endIndex); err != nil {
-		if err == pgx.ErrNoRows {
+func GetHRZoneDistributionForSegmentInActivity(ctx context.Context, conn DB, athleteID, activityID, segmentID int64, toleranceMeters float64, effortNumber int, hrZones *strava.HeartRateZones) ([]HRZoneDistribution, error) {
+	startIndex, endIndex, err := findSegmentPointIndices(ctx, conn, athleteID, activityID, segmentID, toleranceMeters, effortNumber)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
 			return []HRZoneDistribution{}, nil
 		}
 		return nil, err
 	}
 
-	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	segmentSamples, err := GetPointSamplesForActivityRange(ctx, conn, athleteID, activityID, startIndex, endIndex)
 	if err != nil {
 		return nil, err
 	}
-	segmentSamples := make([]PointSample, 0, endIndex-startIndex+1)
-	for _, sample := range samples {
-		if sample.PointIndex >= startIndex && sample.PointIndex <= endIndex {
-			segmentSamples = append(segmentSamples, sample)
+	return calculateHRZoneDistribution(segmentSamples, hrZones), nil
+}
+
+// findSegmentPointIndices returns the point index range of one traversal of a segment within
+// an activity, preferring efforts cached in segment_activity_matches over running
+// find_segment_traversals. It only reads, so it can run on a replica. It returns
+// ErrNotFound when the activity has no such traversal.
+func findSegmentPointIndices(ctx context.Context, conn DB, athleteID, activityID, segmentID int64, toleranceMeters float64, effortNumber int) (int, int, error) {
+	cached, err := GetCachedSegmentActivityEfforts(ctx, conn, segmentID, activityID, toleranceMeters)
+	if err != nil {
+		return 0, 0, err
+	}
+	if complete, count := segmentEffortsComplete(cached); complete {
+		for _, effort := range cached[:count] {
+			if effort.EffortNumber == effortNumber {
+				return *effort.StartIndex, *effort.EndIndex, nil
+			}
 		}
+		return 0, 0, noSegmentEffort(activityID, segmentID, effortNumber)
 	}
-	return calculateHRZoneDistribution(segmentSamples, hrZones), nil
+
+	traversals, err := FindSegmentTraversals(ctx, conn, athleteID, activityID, segmentID, toleranceMeters)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, traversal := range traversals {
+		if traversal.EffortNumber == effortNumber {
+			return traversal.StartIndex, traversal.EndIndex, nil
+		}
+	}
+	return 0, 0, noSegmentEffort(activityID, segmentID, effortNumber)
+}
+
+func noSegmentEffort(activityID, segmentID int64, effortNumber int) error {
+	return notFoundf(pgx.ErrNoRows, "activity %d has no effort %d on segment %d", activityID, effortNumber, segmentID)
+}
+
+// GetPointSamplesForSegmentInActivity returns the activity's samples inside the point index
+// range of one traversal, or no samples when the activity has no such traversal.
+func GetPointSamplesForSegmentInActivity(ctx context.Context, conn DB, athleteID, activityID, segmentID int64, toleranceMeters float64, effortNumber int) ([]PointSample, error) {
+	startIndex, endIndex, err := findSegmentPointIndices(ctx, conn, athleteID, activityID, segmentID, toleranceMeters, effortNumber)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return []PointSample{}, nil
+		}
+		return nil, fmt.Errorf("failed to find segment indices: %w", err)
+	}
+	return GetPointSamplesForActivityRange(ctx, conn, athleteID, activityID, startIndex, endIndex)
 }
 
 func calculateHRZoneDistribution(samples []PointSample, hrZones *strava.HeartRateZones) []HRZoneDistribution {
@@ -391,8 +512,9 @@ func calculateHRZoneDistribution(samples []PointSample, hrZones *strava.HeartRat
 		}
 	}
 
+	intervals, _ := SampleIntervals(samples)
 	total := 0
-	for _, sample := range samples {
+	for i, sample := range samples {
 		if sample.Heartrate == nil || *sample.Heartrate <= 0 {
 			continue
 		}
@@ -401,6 +523,7 @@ func calculateHRZoneDistribution(samples []PointSample, hrZones *strava.HeartRat
 			continue
 		}
 		distribution[zone-1].Samples++
+		distribution[zone-1].Seconds += intervals[i]
 		total++
 	}
 
@@ -414,146 +537,133 @@ func calculateHRZoneDistribution(samples []PointSample, hrZones *strava.HeartRat
 }
 
 // GetGraphDataForActivity retrieves graph data for specified metrics for an activity
-func GetGraphDataForActivity(ctx context.Context, conn *pgx.Conn, athleteID, activityID int64, metrics []string, includeZones bool, hrZones *strava.HeartRateZones) (*GraphData, error) {
+func GetGraphDataForActivity(ctx context.Context, conn DB, athleteID, activityID int64, metrics []string, includeZones bool, hrZones *strava.HeartRateZones) (*GraphData, error) {
 	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
 	if err != nil {
 		return nil, err
 	}
-
-	result := &GraphData{}
-	metricMap := make(map[string]bool)
-	for _, m := range metrics {
-		metricMap[m] = true
-	}
-
-	for _, sample := range samples {
-		if metricMap["speed"] && sample.Speed != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: *sample.Speed,
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Speed = append(result.Speed, point)
-		}
-		if metricMap["heartrate"] && sample.Heartrate != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: float64(*sample.Heartrate),
-			}
-			if includeZones && hrZones != nil {
-				zone := calculateHRZone(*sample.Heartrate, hrZones)
-				point.Zone = &zone
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Heartrate = append(result.Heartrate, point)
-		}
-		if metricMap["height"] && sample.Altitude != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: *sample.Altitude,
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Height = append(result.Height, point)
+	result := buildGraphData(samples, metrics, includeZones, hrZones)
+	if len(samples) > 0 {
+		quality, err := GetActivityGPSQuality(ctx, conn, athleteID, activityID)
+		if err != nil {
+			return nil, err
 		}
-		if metricMap["cadence"] && sample.Cadence != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: float64(*sample.Cadence),
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Cadence = append(result.Cadence, point)
+		if quality.Spikes > 0 || quality.Healed > 0 {
+			result.GPS = &quality
 		}
 	}
-
 	return result, nil
 }
 
-// GetGraphDataForSegmentInActivity retrieves graph data for a segment portion of an activity
-func GetGraphDataForSegmentInActivity(ctx context.Context, conn *pgx.Conn, athleteID, activityID, segmentID int64, metrics []string, includeZones bool, hrZones *strava.HeartRateZones) (*GraphData, error) {
-	// First, get the segment's start and end indices in the activity
-	var startIndex, endIndex int
-	query := `SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`
-	err := conn.QueryRow(ctx, query, segmentID, activityID, athleteID, 15.0).Scan(&startIndex, &endIndex)
+// GetGraphDataForSegmentInActivity retrieves graph data for one traversal of a segment in an
+// activity. Only the samples inside that traversal's point index range are loaded.
+func GetGraphDataForSegmentInActivity(ctx context.Context, conn DB, athleteID, activityID, segmentID int64, toleranceMeters float64, effortNumber int, metrics []string, includeZones bool, hrZones *strava.HeartRateZones) (*GraphData, error) {
+	startIndex, endIndex, err := findSegmentPointIndices(ctx, conn, athleteID, activityID, segmentID, toleranceMeters, effortNumber)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find segment indices: %w", err)
 	}
 
-	// Get all point samples for the activity
-	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	samples, err := GetPointSamplesForActivityRange(ctx, conn, athleteID, activityID, startIndex, endIndex)
 	if err != nil {
 		return nil, err
 	}
+	return buildGraphData(samples, metrics, includeZones, hrZones), nil
+}
 
-	// Filter samples to segment range
-	segmentSamples := []PointSample{}
-	for _, sample := range samples {
-		if sample.PointIndex >= startIndex && sample.PointIndex <= endIndex {
-			segmentSamples = append(segmentSamples, sample)
-		}
-	}
-
+// buildGraphData extracts the requested metric series from point samples. Consecutive
+// points with the same time and value are sent once.
+func buildGraphData(samples []PointSample, metrics []string, includeZones bool, hrZones *strava.HeartRateZones) *GraphData {
 	result := &GraphData{}
 	metricMap := make(map[string]bool)
 	for _, m := range metrics {
 		metricMap[m] = true
 	}
 
-	for _, sample := range segmentSamples {
+	if _, quality := SampleIntervals(samples); quality.Irregular() {
+		result.Timing = &quality
+	}
+
+	for _, sample := range samples {
 		if metricMap["speed"] && sample.Speed != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: *sample.Speed,
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Speed = append(result.Speed, point)
+			result.Speed = appendGraphPoint(result.Speed, GraphDataPoint{
+				Time:     sample.Time,
+				Value:    *sample.Speed,
+				Distance: sample.CumulativeDistance,
+			})
 		}
 		if metricMap["heartrate"] && sample.Heartrate != nil {
 			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: float64(*sample.Heartrate),
+				Time:     sample.Time,
+				Value:    float64(*sample.Heartrate),
+				Distance: sample.CumulativeDistance,
 			}
 			if includeZones && hrZones != nil {
 				zone := calculateHRZone(*sample.Heartrate, hrZones)
 				point.Zone = &zone
 			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Heartrate = append(result.Heartrate, point)
+			result.Heartrate = appendGraphPoint(result.Heartrate, point)
 		}
 		if metricMap["height"] && sample.Altitude != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: *sample.Altitude,
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Height = append(result.Height, point)
+			result.Height = appendGraphPoint(result.Height, GraphDataPoint{
+				Time:     sample.Time,
+				Value:    *sample.Altitude,
+				Distance: sample.CumulativeDistance,
+			})
 		}
 		if metricMap["cadence"] && sample.Cadence != nil {
-			point := GraphDataPoint{
-				Time:  sample.Time,
-				Value: float64(*sample.Cadence),
-			}
-			if sample.CumulativeDistance != nil {
-				point.Distance = sample.CumulativeDistance
-			}
-			result.Cadence = append(result.Cadence, point)
+			result.Cadence = appendGraphPoint(result.Cadence, GraphDataPoint{
+				Time:     sample.Time,
+				Value:    float64(*sample.Cadence),
+				Distance: sample.CumulativeDistance,
+			})
+		}
+		if metricMap["watts"] && sample.Watts != nil {
+			result.Watts = appendGraphPoint(result.Watts, GraphDataPoint{
+				Time:     sample.Time,
+				Value:    float64(*sample.Watts),
+				Distance: sample.CumulativeDistance,
+			})
+		}
+		if metricMap["grade"] && sample.Grade != nil {
+			result.Grade = appendGraphPoint(result.Grade, GraphDataPoint{
+				Time:     sample.Time,
+				Value:    *sample.Grade,
+				Distance: sample.CumulativeDistance,
+			})
 		}
 	}
 
-	return result, nil
+	return result
+}
+
+// SmoothGraphSeries replaces each value with the mean of the values in the trailing
+// window ending at its time, like the 30 s rolling average behind normalized power.
+// Points must be in time order; window <= 0 returns series unchanged.
+func SmoothGraphSeries(series []GraphDataPoint, window time.Duration) []GraphDataPoint {
+	if window <= 0 || len(series) == 0 {
+		return series
+	}
+	smoothed := make([]GraphDataPoint, len(series))
+	start := 0
+	sum := 0.0
+	for i, point := range series {
+		sum += point.Value
+		for point.Time.Sub(series[start].Time) >= window {
+			sum -= series[start].Value
+			start++
+		}
+		smoothed[i] = point
+		smoothed[i].Value = sum / float64(i-start+1)
+	}
+	return smoothed
+}
+
+// appendGraphPoint appends point unless it repeats the time and value of the last point
+func appendGraphPoint(series []GraphDataPoint, point GraphDataPoint) []GraphDataPoint {
+	if n := len(series); n > 0 && series[n-1].Time.Equal(point.Time) && series[n-1].Value == point.Value {
+		return series
+	}
+	return append(series, point)
 }
 
 // calculateHRZone determines which HR zone (1-5) a heart rate value falls into
@@ -606,11 +716,16 @@ type ActivityIntersectionResult struct {
 	OverlapLengthM float64 `json:"overlap_length_m"`
 }
 
-// FindActivitiesNear finds activities within a specified radius of a point
-func FindActivitiesNear(ctx context.Context, conn *pgx.Conn, lon, lat, radiusMeters float64) ([]ActivityNearResult, error) {
-	query := `SELECT * FROM find_activities_near($1, $2, $3)`
+// FindActivitiesNear finds activities within a specified radius of a point.
+// Other athletes' activities are only included when they are instance-visible.
+func FindActivitiesNear(ctx context.Context, conn DB, viewerAthleteID int64, lon, lat, radiusMeters float64) ([]ActivityNearResult, error) {
+	query := `
+	SELECT activity_id, min_dist_m
+	FROM find_activities_near($1, $2, $3, $4)
+	ORDER BY min_dist_m
+	`
 
-	rows, err := conn.Query(ctx, query, lon, lat, radiusMeters)
+	rows, err := conn.Query(ctx, query, viewerAthleteID, lon, lat, radiusMeters)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find activities near point: %w", err)
 	}
@@ -629,11 +744,16 @@ func FindActivitiesNear(ctx context.Context, conn *pgx.Conn, lon, lat, radiusMet
 	return results, rows.Err()
 }
 
-// FindActivitiesIntersectingLine finds activities that intersect with a given line
-func FindActivitiesIntersectingLine(ctx context.Context, conn *pgx.Conn, lineWKT string, toleranceMeters float64) ([]ActivityIntersectionResult, error) {
-	query := `SELECT * FROM find_activities_intersecting_line(ST_GeogFromText($1), $2)`
+// FindActivitiesIntersectingLine finds activities that intersect with a given line.
+// Other athletes' activities are only included when they are instance-visible.
+func FindActivitiesIntersectingLine(ctx context.Context, conn DB, viewerAthleteID int64, lineWKT string, toleranceMeters float64) ([]ActivityIntersectionResult, error) {
+	query := `
+	SELECT activity_id, min_distance_m, overlap_length_m
+	FROM find_activities_intersecting_line($1, ST_GeogFromText($2), $3)
+	ORDER BY min_distance_m
+	`
 
-	rows, err := conn.Query(ctx, query, lineWKT, toleranceMeters)
+	rows, err := conn.Query(ctx, query, viewerAthleteID, lineWKT, toleranceMeters)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find activities intersecting line: %w", err)
 	}
@@ -653,21 +773,21 @@ func FindActivitiesIntersectingLine(ctx context.Context, conn *pgx.Conn, lineWKT
 }
 
 // RefreshActivitySimplified refreshes the simplified geometry for a specific activity
-func RefreshActivitySimplified(ctx context.Context, conn *pgx.Conn, activityID int64, toleranceMeters float64) error {
+func RefreshActivitySimplified(ctx context.Context, conn DB, activityID int64, toleranceMeters float64) error {
 	query := `SELECT refresh_activity_simplified($1, $2)`
 	_, err := conn.Exec(ctx, query, activityID, toleranceMeters)
 	return err
 }
 
-// RefreshAllSimplified refreshes the simplified geometry for all activities
-func RefreshAllSimplified(ctx context.Context, conn *pgx.Conn, toleranceMeters float64) error {
-	query := `SELECT refresh_all_simplified($1)`
-	_, err := conn.Exec(ctx, query, toleranceMeters)
+// RefreshAllSimplified refreshes the simplified geometry for all activities and segments;
+// see ResimplifyAll
+func RefreshAllSimplified(ctx context.Context, conn DB, toleranceMeters float64) error {
+	_, err := ResimplifyAll(ctx, conn, toleranceMeters)
 	return err
 }
 
 // ActivityExists checks if an activity with the given ID already exists in the database
-func ActivityExists(ctx context.Context, conn *pgx.Conn, activityID int64) (bool, error) {
+func ActivityExists(ctx context.Context, conn DB, activityID int64) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM activity_summaries WHERE id = $1)`
 	var exists bool
 	err := conn.QueryRow(ctx, query, activityID).Scan(&exists)
@@ -675,7 +795,7 @@ func ActivityExists(ctx context.Context, conn *pgx.Conn, activityID int64) (bool
 }
 
 // ActivitiesExist checks which activities from a list already exist in the database
-func ActivitiesExist(ctx context.Context, conn *pgx.Conn, activityIDs []int64) (map[int64]bool, error) {
+func ActivitiesExist(ctx context.Context, conn DB, activityIDs []int64) (map[int64]bool, error) {
 	if len(activityIDs) == 0 {
 		return make(map[int64]bool), nil
 	}
@@ -721,7 +841,7 @@ func ActivitiesExist(ctx context.Context, conn *pgx.Conn, activityIDs []int64) (
 }
 
 // GetExistingActivityIDs returns a set of activity IDs that already exist in the database
-func GetExistingActivityIDs(ctx context.Context, conn *pgx.Conn, activityIDs []int64) (map[int64]struct{}, error) {
+func GetExistingActivityIDs(ctx context.Context, conn DB, activityIDs []int64) (map[int64]struct{}, error) {
 	existsMap, err := ActivitiesExist(ctx, conn, activityIDs)
 	if err != nil {
 		return nil, err
@@ -738,12 +858,10 @@ func GetExistingActivityIDs(ctx context.Context, conn *pgx.Conn, activityIDs []i
 }
 
 // ActivitiesExistWithLogging checks which activities from a list exist in the database with logging
-func ActivitiesExistWithLogging(ctx context.Context, conn *pgx.Conn, activityIDs []int64) (map[int64]bool, error) {
-	log.Printf("🔍 Checking existence of %d activities in database", len(activityIDs))
-
+func ActivitiesExistWithLogging(ctx context.Context, conn DB, activityIDs []int64) (map[int64]bool, error) {
 	existsMap, err := ActivitiesExist(ctx, conn, activityIDs)
 	if err != nil {
-		log.Printf("❌ Error checking activities existence: %v", err)
+		logger().Error("Failed to check which activities exist", "error", err)
 		return nil, fmt.Errorf("failed to check activities existence: %w", err)
 	}
 
@@ -754,11 +872,26 @@ func ActivitiesExistWithLogging(ctx context.Context, conn *pgx.Conn, activityIDs
 		}
 	}
 
-	log.Printf("📊 Found %d existing activities out of %d checked", existingCount, len(activityIDs))
+	logger().Debug("Checked which activities exist", "existing", existingCount, "checked", len(activityIDs))
 
 	return existsMap, nil
 }
 
+// GetLatestActivityStartDate returns the start of the athlete's most recent activity synced
+// from Strava, or nil when none is stored. Imported and seeded activities are ignored.
+func GetLatestActivityStartDate(ctx context.Context, conn DB, athleteID int64) (*time.Time, error) {
+	var latest *time.Time
+	err := conn.QueryRow(ctx, `
+		SELECT MAX(start_date)
+		FROM activity_summaries
+		WHERE athlete_id = $1 AND source = $2
+	`, athleteID, SourceStrava).Scan(&latest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest activity start date: %w", err)
+	}
+	return latest, nil
+}
+
 // SegmentActivityMatch represents a cached match between a segment and activity
 type SegmentActivityMatch struct {
 	SegmentID         int64   `json:"segment_id"`
@@ -770,45 +903,48 @@ type SegmentActivityMatch struct {
 	CachedAt          string  `json:"cached_at"`
 }
 
-// ActivityWithMatch represents an activity with its match metadata
+// ActivityWithMatch represents one traversal of a segment: the activity with its match
+// metadata and the metrics of that effort. An activity riding the segment several times
+// appears once per effort.
 type ActivityWithMatch struct {
 	strava.ActivitySummary
-	MinDistanceM       float64              `json:"min_distance_m"`
-	OverlapLengthM     float64              `json:"overlap_length_m"`
-	OverlapPercentage  float64              `json:"overlap_percentage"`
-	StartDateFormatted string               `json:"start_date_formatted"`             // Formatted date for display
-	SegmentAvgHR       *float64             `json:"segment_avg_hr,omitempty"`         // Segment-specific avg HR
-	SegmentAvgSpeed    *float64             `json:"segment_avg_speed,omitempty"`      // Segment-specific avg speed
-	SegmentDistance    *float64             `json:"segment_distance,omitempty"`       // Segment-specific distance
-	SegmentElevation   *float64             `json:"segment_elevation_gain,omitempty"` // Segment-specific elevation gain
-	SegmentElapsedSecs *float64             `json:"segment_elapsed_seconds,omitempty"`
-	SegmentHRZones     []HRZoneDistribution `json:"segment_hr_zones,omitempty"`
+	EffortNumber           int                  `json:"effort_number"` // 1-based traversal within the activity
+	EffortCount            int                  `json:"effort_count"`  // traversals of the segment in the activity
+	Direction              string               `json:"direction"`     // SegmentDirectionForward or SegmentDirectionReverse
+	MinDistanceM           float64              `json:"min_distance_m"`
+	OverlapLengthM         float64              `json:"overlap_length_m"`
+	OverlapPercentage      float64              `json:"overlap_percentage"`
+	StartDateFormatted     string               `json:"start_date_formatted"`                // Formatted date for display
+	SegmentAvgHR           *float64             `json:"segment_avg_hr,omitempty"`            // Segment-specific avg HR
+	SegmentAvgSpeed        *float64             `json:"segment_avg_speed,omitempty"`         // Deprecated alias of segment_avg_speed_elapsed
+	SegmentAvgSpeedElapsed *float64             `json:"segment_avg_speed_elapsed,omitempty"` // Segment distance over elapsed time
+	SegmentDistance        *float64             `json:"segment_distance,omitempty"`          // Segment-specific distance
+	SegmentElevation       *float64             `json:"segment_elevation_gain,omitempty"`    // Segment-specific elevation gain
+	SegmentElapsedSecs     *float64             `json:"segment_elapsed_seconds,omitempty"`
+	SegmentStartIndex      *int                 `json:"-"`
+	SegmentEndIndex        *int                 `json:"-"`
+	SegmentGAP             *float64             `json:"segment_grade_adjusted_speed,omitempty"` // Sample mean of grade-adjusted speeds (plain sample mean when unadjusted)
+	SegmentGAPAdjusted     *bool                `json:"segment_grade_adjusted,omitempty"`       // False when no grade/altitude data was available
+	SegmentHRZones         []HRZoneDistribution `json:"segment_hr_zones,omitempty"`
 }
 
 // GetActivitiesForSegment retrieves activities matching a segment, using cache when available
-// It also loads segment-specific metrics for sorting
-func GetActivitiesForSegment(ctx context.Context, conn *pgx.Conn, athleteID, segmentID int64, toleranceMeters float64, sortBy string, forceRefresh bool) ([]ActivityWithMatch, error) {
+// It also loads segment-specific metrics for sorting. filter drops efforts by direction and
+// activities by overlap; matches are cached unfiltered. The cache is served while it was
+// filled within cacheTTL (DefaultSegmentMatchCacheTTL when <= 0).
+func GetActivitiesForSegment(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters float64, sortBy string, forceRefresh bool, cacheTTL time.Duration, filter SegmentEffortFilter) ([]ActivityWithMatch, error) {
 	// Check cache first (unless force refresh)
 	if !forceRefresh {
-		cached, err := getCachedSegmentMatches(ctx, conn, segmentID, toleranceMeters)
-		if err == nil && len(cached) > 0 {
-			// Check if cache is recent (within last hour)
-			var latestCacheTime time.Time
-			if err := conn.QueryRow(ctx, `
-				SELECT MAX(cached_at::text::timestamptz)
-				FROM segment_activity_matches
-				WHERE segment_id = $1 AND tolerance_meters = $2
-			`, segmentID, toleranceMeters).Scan(&latestCacheTime); err == nil {
-				if time.Since(latestCacheTime) < time.Hour {
-					// Use cached results (with tolerance for loading segment metrics)
-					return getActivitiesWithMatchesWithTolerance(ctx, conn, athleteID, cached, sortBy, segmentID, toleranceMeters)
-				}
-			}
+		cached, efforts, ok, err := getCachedSegmentMatches(ctx, conn, segmentID, toleranceMeters, cacheTTL)
+		if err != nil {
+			logger().Warn("Failed to read segment match cache", "segment_id", segmentID, "error", err)
+		} else if ok {
+			return getActivitiesWithMatchesWithTolerance(ctx, conn, athleteID, cached, efforts, sortBy, segmentID, toleranceMeters, filter)
 		}
 	}
 
 	// Cache miss or stale - run spatial query and cache results
-	matches, err := FindRoutePartsMatchingSegment(ctx, conn, segmentID, toleranceMeters)
+	matches, err := FindRoutePartsMatchingSegment(ctx, conn, athleteID, segmentID, toleranceMeters)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find matching activities: %w", err)
 	}
@@ -816,46 +952,67 @@ func GetActivitiesForSegment(ctx context.Context, conn *pgx.Conn, athleteID, seg
 	// Cache the results
 	if err := CacheSegmentActivityMatches(ctx, conn, segmentID, toleranceMeters, matches); err != nil {
 		// Log but don't fail - cache is optional
-		log.Printf("⚠️ Failed to cache segment matches: %v", err)
+		logger().Warn("Failed to cache segment matches", "segment_id", segmentID, "error", err)
+	} else if err := markSegmentMatchCacheScanned(ctx, conn, segmentID, toleranceMeters); err != nil {
+		logger().Warn("Failed to cache segment matches", "segment_id", segmentID, "error", err)
 	}
 
 	// Convert to ActivityWithMatch (with tolerance for loading segment metrics)
-	return getActivitiesWithMatchesWithTolerance(ctx, conn, athleteID, matches, sortBy, segmentID, toleranceMeters)
+	return getActivitiesWithMatchesWithTolerance(ctx, conn, athleteID, matches, nil, sortBy, segmentID, toleranceMeters, filter)
 }
 
-// getCachedSegmentMatches retrieves cached matches from the database
-func getCachedSegmentMatches(ctx context.Context, conn *pgx.Conn, segmentID int64, toleranceMeters float64) ([]SegmentMatchResult, error) {
-	query := `
-	SELECT activity_id, segment_id, min_distance_m, overlap_length_m, overlap_percentage
-	FROM segment_activity_matches
-	WHERE segment_id = $1 AND tolerance_meters = $2 AND direction_checked = TRUE
-	ORDER BY min_distance_m, overlap_percentage DESC
-	`
-
-	rows, err := conn.Query(ctx, query, segmentID, toleranceMeters)
+// getCachedSegmentMatches retrieves cached matches from the database together with every
+// cached effort, keyed by activity. ok is false when the cache is older than ttl or holds
+// any match computed with the buffered overlap method, so it is recomputed.
+func getCachedSegmentMatches(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64, ttl time.Duration) ([]SegmentMatchResult, map[int64][]SegmentActivityCacheEntry, bool, error) {
+	fresh, err := segmentMatchCacheFresh(ctx, conn, segmentID, toleranceMeters, ttl)
+	if err != nil || !fresh {
+		return nil, nil, false, err
+	}
+	efforts, err := getCachedSegmentEfforts(ctx, conn, segmentID, toleranceMeters, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
-	defer rows.Close()
 
-	var results []SegmentMatchResult
-	for rows.Next() {
-		var result SegmentMatchResult
-		err := rows.Scan(
-			&result.ActivityID, &result.SegmentID,
-			&result.MinDistanceM, &result.OverlapLengthM, &result.OverlapPercentage,
-		)
-		if err != nil {
-			return nil, err
+	results := make([]SegmentMatchResult, 0, len(efforts))
+	for activityID, entries := range efforts {
+		for _, entry := range entries {
+			if entry.OverlapMethod != OverlapMethodProjected {
+				return nil, nil, false, nil
+			}
 		}
-		results = append(results, result)
+		if entries[0].EffortNumber != 1 {
+			continue
+		}
+		results = append(results, SegmentMatchResult{
+			ActivityID:        activityID,
+			SegmentID:         segmentID,
+			MinDistanceM:      entries[0].MinDistanceM,
+			OverlapLengthM:    entries[0].OverlapLengthM,
+			OverlapPercentage: entries[0].OverlapPercentage,
+		})
 	}
-
-	return results, rows.Err()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].MinDistanceM != results[j].MinDistanceM {
+			return results[i].MinDistanceM < results[j].MinDistanceM
+		}
+		return results[i].OverlapPercentage > results[j].OverlapPercentage
+	})
+	return results, efforts, true, nil
 }
 
-// getActivitiesWithMatchesWithTolerance retrieves activity summaries and combines with match metadata and segment metrics
-func getActivitiesWithMatchesWithTolerance(ctx context.Context, conn *pgx.Conn, athleteID int64, matches []SegmentMatchResult, sortBy string, segmentID int64, toleranceMeters float64) ([]ActivityWithMatch, error) {
+// getActivitiesWithMatchesWithTolerance retrieves activity summaries and combines with match metadata and segment metrics.
+// cachedEfforts holds the cached effort rows by activity when the caller already read them;
+// nil reads them for all matches in one query. Only activities whose cached efforts are
+// incomplete are measured one by one.
+func getActivitiesWithMatchesWithTolerance(ctx context.Context, conn DB, athleteID int64, matches []SegmentMatchResult, cachedEfforts map[int64][]SegmentActivityCacheEntry, sortBy string, segmentID int64, toleranceMeters float64, filter SegmentEffortFilter) ([]ActivityWithMatch, error) {
+	kept := make([]SegmentMatchResult, 0, len(matches))
+	for _, match := range matches {
+		if filter.keepsMatch(match) {
+			kept = append(kept, match)
+		}
+	}
+	matches = kept
 	if len(matches) == 0 {
 		return []ActivityWithMatch{}, nil
 	}
@@ -873,6 +1030,12 @@ func getActivitiesWithMatchesWithTolerance(ctx context.Context, conn *pgx.Conn,
 	if err != nil {
 		return nil, fmt.Errorf("failed to get activities: %w", err)
 	}
+	if cachedEfforts == nil {
+		cachedEfforts, err = getCachedSegmentEfforts(ctx, conn, segmentID, toleranceMeters, activityIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Combine with match metadata and segment metrics
 	result := make([]ActivityWithMatch, 0, len(activities))
@@ -882,88 +1045,186 @@ func getActivitiesWithMatchesWithTolerance(ctx context.Context, conn *pgx.Conn,
 			continue // Skip if match not found (shouldn't happen)
 		}
 
-		awm := ActivityWithMatch{
-			ActivitySummary:    activity,
-			MinDistanceM:       match.MinDistanceM,
-			OverlapLengthM:     match.OverlapLengthM,
-			OverlapPercentage:  match.OverlapPercentage,
-			StartDateFormatted: activity.StartDateTime.Format(time.RFC3339),
-		}
-
-		effort, err := ensureSegmentActivityMetrics(ctx, conn, athleteID, segmentID, activity.ID, toleranceMeters)
+		efforts, err := completeSegmentActivityEfforts(ctx, conn, athleteID, segmentID, activity.ID, toleranceMeters, cachedEfforts[activity.ID])
 		if err != nil {
-			log.Printf("⚠️ Failed to load segment metrics for activity %d: %v", activity.ID, err)
-		}
-		if effort == nil {
+			logger().Warn("Failed to load segment metrics", "segment_id", segmentID, "activity_id", activity.ID, "error", err)
 			continue
 		}
-		awm.SegmentAvgHR = effort.AvgHR
-		awm.SegmentAvgSpeed = effort.AvgSpeed
-		awm.SegmentDistance = effort.DistanceM
-		awm.SegmentElevation = effort.ElevationGainM
-		awm.SegmentElapsedSecs = effort.ElapsedSeconds
-
-		result = append(result, awm)
+		for _, effort := range efforts {
+			if !filter.keepsEffort(effort) {
+				continue
+			}
+			result = append(result, ActivityWithMatch{
+				ActivitySummary:        activity,
+				EffortNumber:           effort.EffortNumber,
+				EffortCount:            len(efforts),
+				Direction:              effort.Direction,
+				MinDistanceM:           match.MinDistanceM,
+				OverlapLengthM:         match.OverlapLengthM,
+				OverlapPercentage:      match.OverlapPercentage,
+				StartDateFormatted:     activity.StartDateTime.Format(time.RFC3339),
+				SegmentAvgHR:           effort.AvgHR,
+				SegmentAvgSpeed:        effort.AvgSpeed,
+				SegmentAvgSpeedElapsed: effort.AvgSpeed,
+				SegmentDistance:        effort.DistanceM,
+				SegmentElevation:       effort.ElevationGainM,
+				SegmentElapsedSecs:     effort.ElapsedSeconds,
+				SegmentStartIndex:      effort.StartIndex,
+				SegmentEndIndex:        effort.EndIndex,
+				SegmentGAP:             effort.GradeAdjustedSpeed,
+				SegmentGAPAdjusted:     effort.GradeAdjusted,
+			})
+		}
 	}
 
 	// Apply sorting
-	sortActivitiesWithMatches(result, sortBy)
+	SortActivitiesWithMatches(result, sortBy)
 
 	return result, nil
 }
 
-func ensureSegmentActivityMetrics(ctx context.Context, conn *pgx.Conn, athleteID, segmentID, activityID int64, toleranceMeters float64) (*SegmentActivityCacheEntry, error) {
-	cached, err := GetCachedSegmentActivityMetrics(ctx, conn, segmentID, activityID, toleranceMeters)
+// SegmentTraversal is one pass through a segment within an activity
+type SegmentTraversal struct {
+	EffortNumber int    `json:"effort_number"`
+	StartIndex   int    `json:"start_index"`
+	EndIndex     int    `json:"end_index"`
+	Direction    string `json:"direction"`
+}
+
+// FindSegmentTraversals returns every traversal of the segment in the activity in ride order,
+// numbered from 1, in either direction. For a reverse traversal StartIndex is where the
+// activity reached the segment's end.
+func FindSegmentTraversals(ctx context.Context, conn DB, athleteID, activityID, segmentID int64, toleranceMeters float64) ([]SegmentTraversal, error) {
+	rows, err := conn.Query(ctx,
+		`SELECT effort_number, start_index, end_index, direction FROM find_segment_traversals($1, $2, $3, $4)`,
+		segmentID, activityID, athleteID, toleranceMeters,
+	)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to find segment traversals: %w", err)
 	}
-	if cached != nil && cached.StartIndex != nil && cached.EndIndex != nil &&
-		cached.AvgHR != nil && cached.AvgSpeed != nil && cached.DistanceM != nil &&
-		cached.ElevationGainM != nil && cached.ElapsedSeconds != nil {
-		return cached, nil
+	defer rows.Close()
+
+	var traversals []SegmentTraversal
+	for rows.Next() {
+		var traversal SegmentTraversal
+		if err := rows.Scan(&traversal.EffortNumber, &traversal.StartIndex, &traversal.EndIndex, &traversal.Direction); err != nil {
+			return nil, fmt.Errorf("failed to scan segment traversal: %w", err)
+		}
+		traversals = append(traversals, traversal)
 	}
+	return traversals, rows.Err()
+}
 
-	var startIndex, endIndex int
-	if err := conn.QueryRow(ctx,
-		`SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`,
-		segmentID, activityID, athleteID, toleranceMeters,
-	).Scan(&startIndex, &endIndex); err != nil {
-		if err == pgx.ErrNoRows {
+// EnsureSegmentActivityEfforts returns one entry per traversal of the segment in the activity,
+// with indices and metrics. Cached efforts are used when complete; otherwise the traversals
+// are detected, measured and cached. No entries means the activity does not traverse the segment.
+func EnsureSegmentActivityEfforts(ctx context.Context, conn DB, athleteID, segmentID, activityID int64, toleranceMeters float64) ([]SegmentActivityCacheEntry, error) {
+	cached, err := GetCachedSegmentActivityEfforts(ctx, conn, segmentID, activityID, toleranceMeters)
+	if err != nil {
+		return nil, err
+	}
+	return completeSegmentActivityEfforts(ctx, conn, athleteID, segmentID, activityID, toleranceMeters, cached)
+}
+
+// completeSegmentActivityEfforts returns the cached efforts of the activity when they are
+// complete, and otherwise detects, measures and caches them
+func completeSegmentActivityEfforts(ctx context.Context, conn DB, athleteID, segmentID, activityID int64, toleranceMeters float64, cached []SegmentActivityCacheEntry) ([]SegmentActivityCacheEntry, error) {
+	if complete, count := segmentEffortsComplete(cached); complete {
+		if count == 0 {
 			return nil, nil
 		}
+		return cached, nil
+	}
+
+	traversals, err := FindSegmentTraversals(ctx, conn, athleteID, activityID, segmentID, toleranceMeters)
+	if err != nil {
 		return nil, err
 	}
+	count := len(traversals)
+	efforts := make([]SegmentActivityCacheEntry, 0, count)
+	for _, traversal := range traversals {
+		startIndex, endIndex := traversal.StartIndex, traversal.EndIndex
+		var avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds float64
+		if err := conn.QueryRow(ctx,
+			`SELECT * FROM get_segment_range_metrics($1, $2, $3, $4)`,
+			activityID, athleteID, startIndex, endIndex,
+		).Scan(&avgHR, &avgSpeed, &distanceM, &elevationGainM, &elapsedSeconds); err != nil {
+			return nil, fmt.Errorf("failed to measure effort %d: %w", traversal.EffortNumber, err)
+		}
+		// The SQL sums every altitude step, which noise inflates; re-measure with smoothing
+		samples, err := GetPointSamplesForActivityRange(ctx, conn, athleteID, activityID, startIndex, endIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load effort %d samples: %w", traversal.EffortNumber, err)
+		}
+		if gain, _, ok := ComputeElevationGain(samples, DefaultElevationGainOptions); ok {
+			elevationGainM = gain
+		}
+		efforts = append(efforts, SegmentActivityCacheEntry{
+			SegmentID:        segmentID,
+			ActivityID:       activityID,
+			ToleranceMeters:  toleranceMeters,
+			EffortNumber:     traversal.EffortNumber,
+			EffortCount:      &count,
+			Direction:        traversal.Direction,
+			StartIndex:       &startIndex,
+			EndIndex:         &endIndex,
+			AvgHR:            &avgHR,
+			AvgSpeed:         &avgSpeed,
+			DistanceM:        &distanceM,
+			ElevationGainM:   &elevationGainM,
+			ElapsedSeconds:   &elapsedSeconds,
+			DirectionChecked: true,
+		})
+	}
 
-	var avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds float64
-	if err := conn.QueryRow(ctx,
-		`SELECT * FROM get_activity_segment_metrics($1, $2, $3, $4)`,
-		segmentID, activityID, athleteID, toleranceMeters,
-	).Scan(&avgHR, &avgSpeed, &distanceM, &elevationGainM, &elapsedSeconds); err != nil {
+	if err := CacheSegmentActivityEfforts(ctx, conn, segmentID, activityID, toleranceMeters, efforts); err != nil {
 		return nil, err
 	}
+	return efforts, nil
+}
 
-	if err := CacheSegmentActivityMetrics(ctx, conn, segmentID, activityID, toleranceMeters, startIndex, endIndex, avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds); err != nil {
+// GetSegmentActivityEffort returns one traversal of the segment in the activity, or nil when
+// the activity has fewer traversals
+func GetSegmentActivityEffort(ctx context.Context, conn DB, athleteID, segmentID, activityID int64, toleranceMeters float64, effortNumber int) (*SegmentActivityCacheEntry, error) {
+	efforts, err := EnsureSegmentActivityEfforts(ctx, conn, athleteID, segmentID, activityID, toleranceMeters)
+	if err != nil {
 		return nil, err
 	}
+	for i := range efforts {
+		if efforts[i].EffortNumber == effortNumber {
+			return &efforts[i], nil
+		}
+	}
+	return nil, nil
+}
 
-	return &SegmentActivityCacheEntry{
-		SegmentID:        segmentID,
-		ActivityID:       activityID,
-		ToleranceMeters:  toleranceMeters,
-		StartIndex:       &startIndex,
-		EndIndex:         &endIndex,
-		AvgHR:            &avgHR,
-		AvgSpeed:         &avgSpeed,
-		DistanceM:        &distanceM,
-		ElevationGainM:   &elevationGainM,
-		ElapsedSeconds:   &elapsedSeconds,
-		DirectionChecked: true,
-	}, nil
+// segmentEffortsComplete reports whether cached rows hold every traversal with its metrics,
+// and how many traversals there are. Rows cached before traversal detection have no count.
+func segmentEffortsComplete(entries []SegmentActivityCacheEntry) (bool, int) {
+	if len(entries) == 0 || entries[0].EffortCount == nil {
+		return false, 0
+	}
+	count := *entries[0].EffortCount
+	if count == 0 {
+		return true, 0
+	}
+	if len(entries) != count {
+		return false, 0
+	}
+	for i, entry := range entries {
+		if entry.EffortNumber != i+1 || entry.EffortCount == nil || *entry.EffortCount != count ||
+			entry.StartIndex == nil || entry.EndIndex == nil ||
+			entry.AvgHR == nil || entry.AvgSpeed == nil || entry.DistanceM == nil ||
+			entry.ElevationGainM == nil || entry.ElapsedSeconds == nil {
+			return false, 0
+		}
+	}
+	return true, count
 }
 
-// sortActivitiesWithMatches sorts activities by the specified criteria
+// SortActivitiesWithMatches sorts activities by the specified criteria
 // Uses segment-specific metrics when available, falls back to whole activity metrics
-func sortActivitiesWithMatches(activities []ActivityWithMatch, sortBy string) {
+func SortActivitiesWithMatches(activities []ActivityWithMatch, sortBy string) {
 	switch sortBy {
 	case "avg_hr":
 		sort.Slice(activities, func(i, j int) bool {
@@ -986,30 +1247,37 @@ func sortActivitiesWithMatches(activities []ActivityWithMatch, sortBy string) {
 		sort.Slice(activities, func(i, j int) bool {
 			// Prefer segment-specific speed, fall back to whole activity speed
 			speedI := 0.0
-			if activities[i].SegmentAvgSpeed != nil {
-				speedI = *activities[i].SegmentAvgSpeed
+			if activities[i].SegmentAvgSpeedElapsed != nil {
+				speedI = *activities[i].SegmentAvgSpeedElapsed
 			} else if activities[i].AverageSpeed > 0 {
 				speedI = activities[i].AverageSpeed
 			}
 			speedJ := 0.0
-			if activities[j].SegmentAvgSpeed != nil {
-				speedJ = *activities[j].SegmentAvgSpeed
+			if activities[j].SegmentAvgSpeedElapsed != nil {
+				speedJ = *activities[j].SegmentAvgSpeedElapsed
 			} else if activities[j].AverageSpeed > 0 {
 				speedJ = activities[j].AverageSpeed
 			}
 			return speedI > speedJ // Descending
 		})
-	case "total_time":
+	case "gap":
 		sort.Slice(activities, func(i, j int) bool {
-			timeI := activities[i].ElapsedTime
-			if activities[i].SegmentElapsedSecs != nil {
-				timeI = *activities[i].SegmentElapsedSecs
+			// Prefer grade-adjusted speed, fall back to segment and whole activity speed
+			return gradeAdjustedSortSpeed(activities[i]) > gradeAdjustedSortSpeed(activities[j]) // Descending
+		})
+	case "time", "total_time":
+		// Leaderboard: fastest traverse first. Efforts without a segment time go last
+		// instead of ranking by the whole ride; equal times keep the earlier effort ahead.
+		sort.SliceStable(activities, func(i, j int) bool {
+			timeI, okI := segmentSortSeconds(activities[i])
+			timeJ, okJ := segmentSortSeconds(activities[j])
+			if okI != okJ {
+				return okI
 			}
-			timeJ := activities[j].ElapsedTime
-			if activities[j].SegmentElapsedSecs != nil {
-				timeJ = *activities[j].SegmentElapsedSecs
+			if timeI != timeJ {
+				return timeI < timeJ
 			}
-			return timeI < timeJ
+			return activities[i].StartDateTime.Before(activities[j].StartDateTime)
 		})
 	case "date":
 		sort.Slice(activities, func(i, j int) bool {
@@ -1023,8 +1291,26 @@ func sortActivitiesWithMatches(activities []ActivityWithMatch, sortBy string) {
 	}
 }
 
+// segmentSortSeconds returns the elapsed time over the segment portion, if known
+func segmentSortSeconds(activity ActivityWithMatch) (float64, bool) {
+	if activity.SegmentElapsedSecs == nil || *activity.SegmentElapsedSecs <= 0 {
+		return 0, false
+	}
+	return *activity.SegmentElapsedSecs, true
+}
+
+func gradeAdjustedSortSpeed(activity ActivityWithMatch) float64 {
+	if activity.SegmentGAP != nil {
+		return *activity.SegmentGAP
+	}
+	if activity.SegmentAvgSpeedElapsed != nil {
+		return *activity.SegmentAvgSpeedElapsed
+	}
+	return activity.AverageSpeed
+}
+
 // GetActivitiesByIDs retrieves activities by their IDs
-func GetActivitiesByIDs(ctx context.Context, conn *pgx.Conn, athleteID int64, activityIDs []int64) ([]strava.ActivitySummary, error) {
+func GetActivitiesByIDs(ctx context.Context, conn DB, athleteID int64, activityIDs []int64) ([]strava.ActivitySummary, error) {
 	if len(activityIDs) == 0 {
 		return []strava.ActivitySummary{}, nil
 	}
@@ -1035,7 +1321,7 @@ func GetActivitiesByIDs(ctx context.Context, conn *pgx.Conn, athleteID int64, ac
 		   start_lat, start_lng, end_lat, end_lng,
 		   location_city, location_state, location_country, gear_id, gear_name,
 		   average_speed, max_speed, average_cadence, average_watts,
-		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score
+		   kilojoules, average_heartrate, max_heartrate, max_watts, suffer_score, visibility, pinned
 	FROM activity_summaries
 	WHERE athlete_id = $1 AND id = ANY($2)
 	`
@@ -1060,7 +1346,7 @@ func GetActivitiesByIDs(ctx context.Context, conn *pgx.Conn, athleteID int64, ac
 			&locationCity, &locationState, &activity.LocationCountry, &activity.GearID, &activity.GearName,
 			&activity.AverageSpeed, &activity.MaxSpeed, &activity.AverageCadence, &activity.AverageWatts,
 			&activity.Kilojoules, &activity.AverageHeartrate, &activity.MaxHeartrate, &activity.MaxWatts,
-			&activity.SufferScore,
+			&activity.SufferScore, &activity.InstanceVisibility, &activity.Pinned,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan activity: %w", err)
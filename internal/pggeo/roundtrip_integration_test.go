@@ -0,0 +1,116 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+const roundTripAthleteID = int64(990000503)
+
+// roundTripActivity is the example's sample ride, owned by the round trip athlete and
+// moved to a fixed date so every run stores the same rows
+func roundTripActivity() *strava.BikeActivity {
+	activity := createSampleActivity()
+	start := time.Date(2024, 5, 4, 9, 0, 0, 0, time.UTC)
+	activity.Summary.AthleteID = roundTripAthleteID
+	activity.Summary.StartDateTime = start
+	activity.Summary.StartDate = start.Format(time.RFC3339)
+	for i := range activity.TimeStream.Data {
+		activity.TimeStream.Data[i] = start.Add(time.Duration(i) * time.Second)
+	}
+	return activity
+}
+
+func cleanupRoundTrip(conn DB) {
+	ctx := context.Background()
+	_, _ = conn.Exec(ctx, `DELETE FROM favorite_segments WHERE athlete_id = $1`, roundTripAthleteID)
+	_, _ = conn.Exec(ctx, `DELETE FROM activity_summaries WHERE athlete_id = $1`, roundTripAthleteID)
+}
+
+// TestSampleActivityRoundTrip stores the sample ride, reads its points back, matches it
+// to a segment drawn along its middle, caches the match and checks that a schema
+// validation of the up to date tables keeps all of it
+func TestSampleActivityRoundTrip(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanupRoundTrip(conn)
+	t.Cleanup(func() { cleanupRoundTrip(conn) })
+
+	activity := roundTripActivity()
+	if err := InsertBikeActivity(ctx, conn, activity); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	if err := InsertBikeActivity(ctx, conn, activity); err == nil {
+		t.Fatal("a second InsertBikeActivity of the same activity succeeded")
+	}
+
+	samples, err := GetPointSamplesForActivity(ctx, conn, roundTripAthleteID, activity.Summary.ID)
+	if err != nil {
+		t.Fatalf("GetPointSamplesForActivity: %v", err)
+	}
+	if len(samples) != len(activity.LatLngStream.Data) {
+		t.Fatalf("read %d point samples, stored %d", len(samples), len(activity.LatLngStream.Data))
+	}
+	for i, sample := range samples {
+		want := activity.LatLngStream.Data[i]
+		if sample.PointIndex != i || sample.Lat != want[0] || sample.Lng != want[1] ||
+			!sample.Time.Equal(activity.TimeStream.Data[i]) ||
+			sample.Heartrate == nil || *sample.Heartrate != activity.HeartrateStream.Data[i] ||
+			sample.Altitude == nil || *sample.Altitude != activity.AltitudeStream.Data[i] {
+			t.Fatalf("sample %d = %+v, want the stored point %v", i, sample, want)
+		}
+	}
+	if samples[len(samples)-1].CumulativeDistance == nil || *samples[len(samples)-1].CumulativeDistance <= 0 {
+		t.Fatal("stored samples have no cumulative distance")
+	}
+
+	segment, err := InsertFavoriteSegment(ctx, conn, roundTripAthleteID, "Round trip", "", activity.LatLngStream.Data[30:70], nil, nil)
+	if err != nil {
+		t.Fatalf("InsertFavoriteSegment: %v", err)
+	}
+	const tolerance = 20.0
+	matches, err := FindRoutePartsMatchingSegment(ctx, conn, roundTripAthleteID, segment.ID, tolerance)
+	if err != nil {
+		t.Fatalf("FindRoutePartsMatchingSegment: %v", err)
+	}
+	if len(matches) != 1 || matches[0].ActivityID != activity.Summary.ID || matches[0].OverlapPercentage < 95 {
+		t.Fatalf("matches = %+v, want the sample ride covering the segment", matches)
+	}
+
+	if err := CacheSegmentActivityMatches(ctx, conn, segment.ID, tolerance, matches); err != nil {
+		t.Fatalf("CacheSegmentActivityMatches: %v", err)
+	}
+	// Caching again updates the rows in place
+	if err := CacheSegmentActivityMatches(ctx, conn, segment.ID, tolerance, matches); err != nil {
+		t.Fatalf("CacheSegmentActivityMatches again: %v", err)
+	}
+	checkCachedMatch := func(when string) {
+		t.Helper()
+		efforts, err := getCachedSegmentEfforts(ctx, conn, segment.ID, tolerance, nil)
+		if err != nil {
+			t.Fatalf("%s: getCachedSegmentEfforts: %v", when, err)
+		}
+		entries := efforts[activity.Summary.ID]
+		if len(efforts) != 1 || len(entries) != 1 || entries[0].EffortNumber != 1 ||
+			entries[0].OverlapPercentage != matches[0].OverlapPercentage || entries[0].OverlapMethod != OverlapMethodProjected {
+			t.Fatalf("%s: cached efforts = %+v, want one projected match", when, efforts)
+		}
+	}
+	checkCachedMatch("after caching")
+
+	if err := ValidateAndMigrateSchema(ctx, conn, false); err != nil {
+		t.Fatalf("ValidateAndMigrateSchema: %v", err)
+	}
+	checkCachedMatch("after schema validation")
+	kept, err := GetPointSamplesForActivity(ctx, conn, roundTripAthleteID, activity.Summary.ID)
+	if err != nil || len(kept) != len(samples) {
+		t.Fatalf("after schema validation: %d point samples, %v; want %d", len(kept), err, len(samples))
+	}
+	if stored, err := GetFavoriteSegment(ctx, conn, roundTripAthleteID, segment.ID); err != nil || stored.Name != "Round trip" {
+		t.Fatalf("after schema validation: segment = %+v, %v", stored, err)
+	}
+}
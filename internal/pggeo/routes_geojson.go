@@ -0,0 +1,106 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// Values of simplifyMeters for the routes queries other than a tolerance to simplify to
+const (
+	// SimplifyStored sends the simplified route stored with the activity, else the full one
+	SimplifyStored = 0.0
+	// SimplifyFull sends the full route as recorded
+	SimplifyFull = -1.0
+)
+
+// BBox is a longitude/latitude rectangle in WGS84
+type BBox struct {
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+// routeFeaturesQuery selects one GeoJSON Feature per route of athlete $1, newest first.
+// A nil bbox selects every route; otherwise only routes whose bounding box intersects it,
+// using the route_bbox_geom index. With simplify $2 > 0 the full route is simplified to
+// that tolerance, with SimplifyFull it is sent as is, and with SimplifyStored the stored
+// simplified route is used when there is one. With
+// polyline the route is sent as a polyline5 string and precision instead of a geometry.
+func routeFeaturesQuery(athleteID int64, bbox *BBox, simplifyMeters float64, polyline bool) (string, []interface{}) {
+	args := []interface{}{athleteID, simplifyMeters}
+	bboxCondition := ""
+	if bbox != nil {
+		args = append(args, bbox.MinLng, bbox.MinLat, bbox.MaxLng, bbox.MaxLat)
+		bboxCondition = "AND g.route_bbox_geom && ST_MakeEnvelope($3, $4, $5, $6, 4326)"
+	}
+
+	route := `CASE
+				WHEN $2::DOUBLE PRECISION > 0 THEN simplify_route_geog_meters(g.route_geog, $2)
+				WHEN $2::DOUBLE PRECISION < 0 THEN g.route_geog
+				ELSE COALESCE(g.route_geog_simplified, g.route_geog)
+			END`
+	geometry := `'geometry', ST_AsGeoJSON(` + route + `, 6)::json`
+	if polyline {
+		geometry = `'geometry', NULL,
+		'polyline', ST_AsEncodedPolyline((` + route + `)::geometry, 5),
+		'precision', 5`
+	}
+
+	query := `
+	SELECT json_build_object(
+		'type', 'Feature',
+		'id', s.id,
+		'properties', json_build_object('id', s.id, 'name', s.name, 'start_date', s.start_date),
+		` + geometry + `
+	) AS feature, s.start_date, s.id
+	FROM activity_geometries g
+	JOIN activity_summaries s ON s.id = g.activity_id
+	WHERE g.athlete_id = $1 AND s.athlete_id = $1
+	` + bboxCondition
+	return query, args
+}
+
+// GetRoutesGeoJSON returns the athlete's routes as a GeoJSON FeatureCollection of
+// LineStrings with the activity id, name and start date as properties, newest first.
+// PostGIS builds the whole document so no geometry is parsed in Go.
+func GetRoutesGeoJSON(ctx context.Context, conn DB, athleteID int64, bbox *BBox, simplifyMeters float64) (string, error) {
+	features, args := routeFeaturesQuery(athleteID, bbox, simplifyMeters, false)
+	query := `
+	SELECT json_build_object(
+		'type', 'FeatureCollection',
+		'features', COALESCE(json_agg(r.feature ORDER BY r.start_date DESC, r.id DESC), '[]'::json)
+	)::text
+	FROM (` + features + `) r`
+
+	var featureCollection string
+	if err := conn.QueryRow(ctx, query, args...).Scan(&featureCollection); err != nil {
+		return "", fmt.Errorf("failed to load routes geojson: %w", err)
+	}
+	return featureCollection, nil
+}
+
+// StreamRoutesGeoJSON calls fn with each Feature of GetRoutesGeoJSON as encoded JSON as
+// it is read, so memory does not grow with the number of routes. The bytes are only
+// valid during the call. It returns how many features were passed to fn. With polyline
+// each Feature has a null geometry and carries the route as "polyline" (polyline5,
+// decodable with geo.DecodePolyline) and "precision": 5, about 8x smaller.
+func StreamRoutesGeoJSON(ctx context.Context, conn DB, athleteID int64, bbox *BBox, simplifyMeters float64, polyline bool, fn func(feature []byte) error) (int, error) {
+	features, args := routeFeaturesQuery(athleteID, bbox, simplifyMeters, polyline)
+	rows, err := conn.Query(ctx, features+`
+	ORDER BY s.start_date DESC, s.id DESC`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query routes geojson: %w", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		values := rows.RawValues()
+		if len(values) == 0 || values[0] == nil {
+			return count, fmt.Errorf("failed to read route feature: empty row")
+		}
+		if err := fn(values[0]); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
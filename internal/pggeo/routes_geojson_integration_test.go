@@ -0,0 +1,145 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"b11k/internal/geo"
+)
+
+type routesFeatureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type       string `json:"type"`
+		Properties struct {
+			ID        int64  `json:"id"`
+			Name      string `json:"name"`
+			StartDate string `json:"start_date"`
+		} `json:"properties"`
+		Geometry struct {
+			Type        string      `json:"type"`
+			Coordinates [][]float64 `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+func TestRoutesGeoJSONFiltersByBBox(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	opts := smallSeedOptions()
+	seeded, err := SeedDemoData(ctx, conn, opts)
+	if err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	athleteID := seeded.AthleteIDs[0]
+
+	load := func(bbox *BBox, simplifyMeters float64) routesFeatureCollection {
+		t.Helper()
+		raw, err := GetRoutesGeoJSON(ctx, conn, athleteID, bbox, simplifyMeters)
+		if err != nil {
+			t.Fatalf("GetRoutesGeoJSON: %v", err)
+		}
+		var collection routesFeatureCollection
+		if err := json.Unmarshal([]byte(raw), &collection); err != nil {
+			t.Fatalf("invalid GeoJSON %q: %v", raw, err)
+		}
+		return collection
+	}
+
+	all := load(nil, 0)
+	if all.Type != "FeatureCollection" || len(all.Features) != opts.ActivitiesPerAthlete {
+		t.Fatalf("got %s with %d features, want %d", all.Type, len(all.Features), opts.ActivitiesPerAthlete)
+	}
+	for _, feature := range all.Features {
+		if feature.Geometry.Type != "LineString" || len(feature.Geometry.Coordinates) < 2 {
+			t.Fatalf("feature %d geometry = %+v", feature.Properties.ID, feature.Geometry)
+		}
+		if feature.Properties.ID == 0 || feature.Properties.Name == "" || feature.Properties.StartDate == "" {
+			t.Fatalf("feature properties = %+v", feature.Properties)
+		}
+	}
+
+	around := &BBox{MinLng: opts.CenterLng - 0.5, MinLat: opts.CenterLat - 0.5, MaxLng: opts.CenterLng + 0.5, MaxLat: opts.CenterLat + 0.5}
+	if got := load(around, 50); len(got.Features) != opts.ActivitiesPerAthlete {
+		t.Fatalf("viewport around the rides has %d features, want %d", len(got.Features), opts.ActivitiesPerAthlete)
+	}
+	elsewhere := &BBox{MinLng: -122.5, MinLat: 37.7, MaxLng: -122.4, MaxLat: 37.8}
+	if got := load(elsewhere, 0); got.Type != "FeatureCollection" || len(got.Features) != 0 {
+		t.Fatalf("viewport away from the rides has %d features, want an empty collection", len(got.Features))
+	}
+}
+
+func TestRoutesPolylineMatchesGeoJSON(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	seeded, err := SeedDemoData(ctx, conn, smallSeedOptions())
+	if err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	athleteID := seeded.AthleteIDs[0]
+
+	stream := func(polyline bool) ([][]byte, int) {
+		var features [][]byte
+		size := 0
+		if _, err := StreamRoutesGeoJSON(ctx, conn, athleteID, nil, 0, polyline, func(feature []byte) error {
+			features = append(features, append([]byte(nil), feature...))
+			size += len(feature)
+			return nil
+		}); err != nil {
+			t.Fatalf("StreamRoutesGeoJSON(polyline=%v): %v", polyline, err)
+		}
+		return features, size
+	}
+	geoJSONFeatures, geoJSONSize := stream(false)
+	polylineFeatures, polylineSize := stream(true)
+	if len(polylineFeatures) != len(geoJSONFeatures) || len(polylineFeatures) == 0 {
+		t.Fatalf("%d polyline features for %d GeoJSON ones", len(polylineFeatures), len(geoJSONFeatures))
+	}
+	t.Logf("%d routes: GeoJSON %d bytes, polyline %d bytes", len(polylineFeatures), geoJSONSize, polylineSize)
+
+	for i := range polylineFeatures {
+		var plain struct {
+			Geometry struct {
+				Coordinates [][]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		}
+		var encoded struct {
+			Geometry  json.RawMessage `json:"geometry"`
+			Polyline  string          `json:"polyline"`
+			Precision int             `json:"precision"`
+		}
+		if json.Unmarshal(geoJSONFeatures[i], &plain) != nil || json.Unmarshal(polylineFeatures[i], &encoded) != nil {
+			t.Fatalf("feature %d is not JSON", i)
+		}
+		points, err := geo.DecodePolyline(encoded.Polyline)
+		if err != nil || encoded.Precision != 5 || string(encoded.Geometry) != "null" {
+			t.Fatalf("feature %d = %s, %v", i, polylineFeatures[i], err)
+		}
+		if len(points) != len(plain.Geometry.Coordinates) {
+			t.Fatalf("feature %d decodes to %d points, want %d", i, len(points), len(plain.Geometry.Coordinates))
+		}
+		for j, coordinate := range plain.Geometry.Coordinates {
+			if math.Abs(points[j][0]-coordinate[1]) > 1e-5 || math.Abs(points[j][1]-coordinate[0]) > 1e-5 {
+				t.Fatalf("feature %d point %d = %v, GeoJSON has %v", i, j, points[j], coordinate)
+			}
+		}
+	}
+}
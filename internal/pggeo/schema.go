@@ -3,13 +3,11 @@ package pggeo
 import (
 	"context"
 	"fmt"
-	"log"
+	"sort"
 	"strings"
-
-	"github.com/jackc/pgx/v5"
 )
 
-func CreateTables(ctx context.Context, conn *pgx.Conn) error {
+func CreateTables(ctx context.Context, conn DB) error {
 
 	if err := createActivitySummariesTable(ctx, conn); err != nil {
 		return fmt.Errorf("failed to create activity summaries table: %w", err)
@@ -35,6 +33,14 @@ func CreateTables(ctx context.Context, conn *pgx.Conn) error {
 		return fmt.Errorf("failed to create segment activity matches table: %w", err)
 	}
 
+	if err := createSegmentMatchCacheStateTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create segment match cache state table: %w", err)
+	}
+
+	if err := createSimilarActivitiesCacheTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create similar activities cache table: %w", err)
+	}
+
 	if err := createDiscoveredActivityBuffersTable(ctx, conn); err != nil {
 		return fmt.Errorf("failed to create discovered activity buffers table: %w", err)
 	}
@@ -43,6 +49,78 @@ func CreateTables(ctx context.Context, conn *pgx.Conn) error {
 		return fmt.Errorf("failed to create discovered coverage cache table: %w", err)
 	}
 
+	if err := createExploredAreaTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create explored area table: %w", err)
+	}
+
+	if err := createAccountDeletionRequestsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create account deletion requests table: %w", err)
+	}
+
+	if err := createAccountEventsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create account events table: %w", err)
+	}
+
+	if err := createAthleteSettingsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create athlete settings table: %w", err)
+	}
+
+	if err := createAthleteTokensTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create athlete tokens table: %w", err)
+	}
+
+	if err := createSkippedActivitiesTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create skipped activities table: %w", err)
+	}
+
+	if err := createAthleteProfilesTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create athlete profiles table: %w", err)
+	}
+
+	if err := createAthletesTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create athletes table: %w", err)
+	}
+
+	if err := createPublicStatsTokensTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create public stats tokens table: %w", err)
+	}
+
+	if err := createShareViewsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create share views table: %w", err)
+	}
+
+	if err := createWebSessionsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create web sessions table: %w", err)
+	}
+
+	if err := createOutboundWebhookQueueTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create outbound webhook queue table: %w", err)
+	}
+
+	if err := createAnnouncementsTables(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create announcements tables: %w", err)
+	}
+
+	if err := createImportFilesTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create import files table: %w", err)
+	}
+
+	if err := createGearTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create gear table: %w", err)
+	}
+
+	if err := createActivityTagsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create activity tags table: %w", err)
+	}
+
+	if err := createActivityStreamsTable(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create activity streams table: %w", err)
+	}
+
+	if err := createPointSampleRowsView(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create point sample rows view: %w", err)
+	}
+
 	if err := createHelperFunctions(ctx, conn); err != nil {
 		return fmt.Errorf("failed to create helper functions: %w", err)
 	}
@@ -50,15 +128,33 @@ func CreateTables(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func TruncateTables(ctx context.Context, conn *pgx.Conn) error {
+func TruncateTables(ctx context.Context, conn DB) error {
 	tables := []string{
 		"discovered_coverage_cache",
 		"discovered_activity_buffers",
+		"explored_area",
 		"point_samples",
 		"activity_geometries",
 		"activity_summaries",
 		"favorite_segments",
 		"mobile_app_sessions",
+		"account_deletion_requests",
+		"account_events",
+		"athlete_settings",
+		"athlete_tokens",
+		"skipped_activities",
+		"athlete_profiles",
+		"athletes",
+		"share_views",
+		"public_stats_tokens",
+		"web_sessions",
+		"outbound_webhook_queue",
+		"announcement_dismissals",
+		"announcements",
+		"import_files",
+		"gear",
+		"activity_tags",
+		"activity_streams",
 	}
 
 	for _, table := range tables {
@@ -71,41 +167,60 @@ func TruncateTables(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func DropAndRecreateTables(ctx context.Context, conn *pgx.Conn) error {
+func DropAndRecreateTables(ctx context.Context, conn DB) error {
 	// Drop tables in reverse dependency order
 	// Note: segment_activity_matches has foreign keys to both favorite_segments and activity_summaries
 	// so it needs to be dropped before those, but CASCADE will handle it anyway
 	tables := []string{
 		"segment_activity_matches", // Cache table with foreign keys
+		"segment_match_cache_state",
+		"similar_activities_cache",
 		"discovered_coverage_cache",
 		"discovered_activity_buffers",
+		"explored_area",
 		"point_samples",       // Depends on activity_summaries
 		"activity_geometries", // Depends on activity_summaries
 		"favorite_segments",   // Independent but referenced by segment_activity_matches
 		"mobile_app_sessions",
+		"account_deletion_requests",
+		"account_events",
+		"athlete_settings",
+		"athlete_tokens",
+		"skipped_activities",
+		"athlete_profiles",
+		"athletes",
+		"share_views",
+		"public_stats_tokens",
+		"web_sessions",
+		"outbound_webhook_queue",
+		"announcement_dismissals",
+		"announcements",
+		"gear",
+		"import_files",       // Depends on activity_summaries
+		"activity_tags",      // Depends on activity_summaries
+		"activity_streams",   // Depends on activity_summaries
 		"activity_summaries", // Base table
 	}
 
-	log.Printf("🗑️ Dropping %d tables...", len(tables))
+	logger().Warn("Dropping all tables", "tables", len(tables))
 	for _, table := range tables {
 		query := fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", table)
 		if _, err := conn.Exec(ctx, query); err != nil {
 			return fmt.Errorf("failed to drop table %s: %w", table, err)
 		}
-		log.Printf("   ✓ Dropped table: %s", table)
+		logger().Info("Dropped table", "table", table)
 	}
 
-	log.Printf("🔨 Recreating all tables...")
 	// Recreate all tables
 	if err := CreateTables(ctx, conn); err != nil {
 		return err
 	}
 
-	log.Printf("✅ All tables dropped and recreated successfully")
+	logger().Info("Recreated all tables")
 	return nil
 }
 
-func createActivitySummariesTable(ctx context.Context, conn *pgx.Conn) error {
+func createActivitySummariesTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS activity_summaries (
 		id BIGINT PRIMARY KEY,
@@ -138,6 +253,16 @@ func createActivitySummariesTable(ctx context.Context, conn *pgx.Conn) error {
 		max_heartrate DOUBLE PRECISION,
 		max_watts DOUBLE PRECISION,
 		suffer_score DOUBLE PRECISION,
+		visibility TEXT NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'instance')),
+		pinned BOOLEAN NOT NULL DEFAULT FALSE,
+		notes TEXT,
+		description TEXT,
+		name_edited_at TIMESTAMPTZ,
+		source TEXT NOT NULL DEFAULT 'strava',
+		grades_derived BOOLEAN NOT NULL DEFAULT FALSE,
+		gps_spikes INTEGER NOT NULL DEFAULT 0,
+		gps_spikes_healed INTEGER NOT NULL DEFAULT 0,
+		new_distance_m DOUBLE PRECISION,
 		created_at TIMESTAMPTZ DEFAULT NOW(),
 		updated_at TIMESTAMPTZ DEFAULT NOW()
 	)`
@@ -150,6 +275,9 @@ func createActivitySummariesTable(ctx context.Context, conn *pgx.Conn) error {
 	if _, err := conn.Exec(ctx, "ALTER TABLE activity_summaries ADD COLUMN IF NOT EXISTS gear_name TEXT"); err != nil {
 		return fmt.Errorf("failed to alter activity_summaries: %w", err)
 	}
+	if _, err := conn.Exec(ctx, createImportedActivityIDSequenceSQL); err != nil {
+		return fmt.Errorf("failed to create imported activity ID sequence: %w", err)
+	}
 
 	indexes := []string{
 		"CREATE INDEX IF NOT EXISTS idx_activity_summaries_athlete_id ON activity_summaries (athlete_id)",
@@ -169,7 +297,7 @@ func createActivitySummariesTable(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func createActivityGeometriesTable(ctx context.Context, conn *pgx.Conn) error {
+func createActivityGeometriesTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS activity_geometries (
 		activity_id BIGINT PRIMARY KEY REFERENCES activity_summaries(id) ON DELETE CASCADE,
@@ -206,7 +334,7 @@ func createActivityGeometriesTable(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func createMobileAppSessionsTable(ctx context.Context, conn *pgx.Conn) error {
+func createMobileAppSessionsTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS mobile_app_sessions (
 		session_token TEXT PRIMARY KEY,
@@ -240,7 +368,7 @@ func createMobileAppSessionsTable(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func createPointSamplesTable(ctx context.Context, conn *pgx.Conn) error {
+func createPointSamplesTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS point_samples (
 		id BIGSERIAL PRIMARY KEY,
@@ -285,7 +413,7 @@ func createPointSamplesTable(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func createFavoriteSegmentsTable(ctx context.Context, conn *pgx.Conn) error {
+func createFavoriteSegmentsTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS favorite_segments (
 		id BIGSERIAL PRIMARY KEY,
@@ -299,6 +427,9 @@ func createFavoriteSegmentsTable(ctx context.Context, conn *pgx.Conn) error {
 		elevation_gain_m DOUBLE PRECISION,
 		elevation_loss_m DOUBLE PRECISION,
 		net_elevation_m DOUBLE PRECISION,
+		default_tolerance_m DOUBLE PRECISION CHECK (default_tolerance_m > 0),
+		pinned BOOLEAN NOT NULL DEFAULT FALSE,
+		source TEXT NOT NULL DEFAULT 'strava',
 		created_at TIMESTAMPTZ DEFAULT NOW(),
 		updated_at TIMESTAMPTZ DEFAULT NOW(),
 		CONSTRAINT segments_has_two_points
@@ -313,6 +444,9 @@ func createFavoriteSegmentsTable(ctx context.Context, conn *pgx.Conn) error {
 	alterQueries := []string{
 		"ALTER TABLE favorite_segments ADD COLUMN IF NOT EXISTS elevation_loss_m DOUBLE PRECISION",
 		"ALTER TABLE favorite_segments ADD COLUMN IF NOT EXISTS net_elevation_m DOUBLE PRECISION",
+		"ALTER TABLE favorite_segments ADD COLUMN IF NOT EXISTS default_tolerance_m DOUBLE PRECISION CHECK (default_tolerance_m > 0)",
+		"ALTER TABLE favorite_segments ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'strava'",
+		"ALTER TABLE favorite_segments ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE",
 	}
 	for _, alterQuery := range alterQueries {
 		if _, err := conn.Exec(ctx, alterQuery); err != nil {
@@ -325,7 +459,6 @@ func createFavoriteSegmentsTable(ctx context.Context, conn *pgx.Conn) error {
 		"CREATE INDEX IF NOT EXISTS idx_favorite_segments_segment_geog ON favorite_segments USING GIST (segment_geog)",
 		"CREATE INDEX IF NOT EXISTS idx_favorite_segments_bbox ON favorite_segments USING GIST (segment_bbox_geom)",
 		"CREATE INDEX IF NOT EXISTS idx_favorite_segments_segment_geog_simplified ON favorite_segments USING GIST (segment_geog_simplified)",
-		"CREATE INDEX IF NOT EXISTS idx_favorite_segments_athlete_name ON favorite_segments (athlete_id, name)",
 		"CREATE INDEX IF NOT EXISTS idx_favorite_segments_created_at ON favorite_segments (created_at)",
 	}
 
@@ -335,15 +468,137 @@ func createFavoriteSegmentsTable(ctx context.Context, conn *pgx.Conn) error {
 		}
 	}
 
+	return ensureUniqueSegmentNames(ctx, conn)
+}
+
+// ensureUniqueSegmentNames makes (athlete_id, name) unique on favorite_segments. Existing
+// duplicates are renamed first: the oldest segment keeps the name and the others get the
+// first free " (2)", " (3)", ... suffix.
+func ensureUniqueSegmentNames(ctx context.Context, conn DB) error {
+	var indexExists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass('idx_favorite_segments_athlete_name_unique') IS NOT NULL`).Scan(&indexExists); err != nil {
+		return fmt.Errorf("failed to check segment name index: %w", err)
+	}
+	if indexExists {
+		return nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin segment name migration: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Block inserts so no new duplicate appears between the renames and the index
+	if _, err := tx.Exec(ctx, `LOCK TABLE favorite_segments IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock favorite_segments: %w", err)
+	}
+	renamed, err := renameDuplicateSegmentNames(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_favorite_segments_athlete_name_unique ON favorite_segments (athlete_id, name)",
+		"DROP INDEX IF EXISTS idx_favorite_segments_athlete_name", // covered by the unique index
+	} {
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to create segment name index: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit segment name migration: %w", err)
+	}
+	if renamed > 0 {
+		logger().Info("Renamed favorite segments that shared a name with another segment of the same athlete", "segments", renamed)
+	}
 	return nil
 }
 
-func createSegmentActivityMatchesTable(ctx context.Context, conn *pgx.Conn) error {
+// renameDuplicateSegmentNames gives every segment whose athlete has an older segment of
+// the same name a unique suffixed name and returns how many were renamed
+func renameDuplicateSegmentNames(ctx context.Context, conn DB) (int, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT id, athlete_id, name
+		FROM favorite_segments
+		WHERE athlete_id IN (
+			SELECT athlete_id FROM favorite_segments GROUP BY athlete_id, name HAVING COUNT(*) > 1
+		)
+		ORDER BY athlete_id, created_at NULLS LAST, id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find duplicate segment names: %w", err)
+	}
+	var segments []segmentName
+	for rows.Next() {
+		var segment segmentName
+		if err := rows.Scan(&segment.ID, &segment.AthleteID, &segment.Name); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan segment name: %w", err)
+		}
+		segments = append(segments, segment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read segment names: %w", err)
+	}
+
+	renames := dedupeSegmentNames(segments)
+	for _, segment := range renames {
+		if _, err := conn.Exec(ctx, `UPDATE favorite_segments SET name = $2, updated_at = NOW() WHERE id = $1`, segment.ID, segment.Name); err != nil {
+			return 0, fmt.Errorf("failed to rename segment %d: %w", segment.ID, err)
+		}
+	}
+	return len(renames), nil
+}
+
+type segmentName struct {
+	ID        int64
+	AthleteID int64
+	Name      string
+}
+
+// dedupeSegmentNames returns the new names of the segments, given oldest first, that
+// repeat an earlier segment's name for the same athlete. A suffix is never one of the
+// athlete's existing names, so "Climb (2)" survives next to two "Climb" segments.
+func dedupeSegmentNames(segments []segmentName) []segmentName {
+	used := make(map[int64]map[string]bool)
+	for _, segment := range segments {
+		if used[segment.AthleteID] == nil {
+			used[segment.AthleteID] = make(map[string]bool)
+		}
+		used[segment.AthleteID][segment.Name] = true
+	}
+
+	seen := make(map[segmentName]bool)
+	var renames []segmentName
+	for _, segment := range segments {
+		key := segmentName{AthleteID: segment.AthleteID, Name: segment.Name}
+		if !seen[key] {
+			seen[key] = true
+			continue
+		}
+		names := used[segment.AthleteID]
+		for n := 2; ; n++ {
+			candidate := fmt.Sprintf("%s (%d)", segment.Name, n)
+			if !names[candidate] {
+				names[candidate] = true
+				renames = append(renames, segmentName{ID: segment.ID, AthleteID: segment.AthleteID, Name: candidate})
+				break
+			}
+		}
+	}
+	return renames
+}
+
+func createSegmentActivityMatchesTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS segment_activity_matches (
 		segment_id BIGINT NOT NULL REFERENCES favorite_segments(id) ON DELETE CASCADE,
 		activity_id BIGINT NOT NULL REFERENCES activity_summaries(id) ON DELETE CASCADE,
 		tolerance_meters DOUBLE PRECISION NOT NULL,
+		effort_number INTEGER NOT NULL DEFAULT 1,
+		effort_count INTEGER,
+		direction TEXT NOT NULL DEFAULT 'forward' CHECK (direction IN ('forward', 'reverse')),
 		min_distance_m DOUBLE PRECISION NOT NULL,
 		overlap_length_m DOUBLE PRECISION NOT NULL,
 		overlap_percentage DOUBLE PRECISION NOT NULL,
@@ -351,12 +606,16 @@ func createSegmentActivityMatchesTable(ctx context.Context, conn *pgx.Conn) erro
 		end_index INTEGER,
 		avg_hr DOUBLE PRECISION,
 		avg_speed DOUBLE PRECISION,
+		avg_speed_basis TEXT NOT NULL DEFAULT 'elapsed',
 		distance_m DOUBLE PRECISION,
 		elevation_gain_m DOUBLE PRECISION,
 		elapsed_seconds DOUBLE PRECISION,
+		grade_adjusted_speed DOUBLE PRECISION,
+		grade_adjusted BOOLEAN,
 		direction_checked BOOLEAN NOT NULL DEFAULT TRUE,
+		overlap_method TEXT NOT NULL DEFAULT 'buffered' CHECK (overlap_method IN ('buffered', 'projected')),
 		cached_at TIMESTAMPTZ DEFAULT NOW(),
-		PRIMARY KEY (segment_id, activity_id, tolerance_meters)
+		PRIMARY KEY (segment_id, activity_id, tolerance_meters, effort_number)
 	)`
 
 	_, err := conn.Exec(ctx, query)
@@ -379,21 +638,59 @@ func createSegmentActivityMatchesTable(ctx context.Context, conn *pgx.Conn) erro
 	return nil
 }
 
-func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
+// createSegmentMatchCacheStateTable records when each segment's match cache was last
+// filled: last_full_scan_at by a scan of every activity, refreshed_at also by matching new
+// activities. The cache is served while refreshed_at is within the TTL.
+func createSegmentMatchCacheStateTable(ctx context.Context, conn DB) error {
+	_, err := conn.Exec(ctx, `
+	CREATE TABLE IF NOT EXISTS segment_match_cache_state (
+		segment_id BIGINT NOT NULL REFERENCES favorite_segments(id) ON DELETE CASCADE,
+		tolerance_meters DOUBLE PRECISION NOT NULL,
+		last_full_scan_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		refreshed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (segment_id, tolerance_meters)
+	)`)
+	return err
+}
+
+// createSimilarActivitiesCacheTable holds the activities FindSimilarActivities found on the
+// same route as each activity at a tolerance, with their coverages in the same order. A row
+// is served until the athlete adds a route after computed_at.
+func createSimilarActivitiesCacheTable(ctx context.Context, conn DB) error {
+	_, err := conn.Exec(ctx, `
+	CREATE TABLE IF NOT EXISTS similar_activities_cache (
+		activity_id BIGINT NOT NULL REFERENCES activity_summaries(id) ON DELETE CASCADE,
+		athlete_id BIGINT NOT NULL,
+		tolerance_meters DOUBLE PRECISION NOT NULL,
+		similar_activity_ids BIGINT[] NOT NULL,
+		coverages DOUBLE PRECISION[] NOT NULL,
+		computed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (activity_id, tolerance_meters)
+	)`)
+	return err
+}
+
+func createHelperFunctions(ctx context.Context, conn DB) error {
 	// First, check if PostGIS is available
 	var postgisVersion string
 	err := conn.QueryRow(ctx, "SELECT PostGIS_Version()").Scan(&postgisVersion)
 	if err != nil {
-		log.Printf("⚠️ PostGIS not available, skipping spatial helper functions: %v", err)
+		logger().Warn("PostGIS not available, skipping spatial helper functions", "error", err)
 		return nil
 	}
-	log.Printf("✅ PostGIS version: %s", postgisVersion)
+	logger().Info("PostGIS available", "version", postgisVersion)
 
 	dropHelperQueries := []string{
 		"DROP FUNCTION IF EXISTS find_route_parts_matching_segment(BIGINT, DOUBLE PRECISION)",
+		"DROP FUNCTION IF EXISTS find_route_parts_matching_segment(BIGINT, DOUBLE PRECISION, BIGINT[])",
+		"DROP FUNCTION IF EXISTS find_activities_near(DOUBLE PRECISION, DOUBLE PRECISION, DOUBLE PRECISION)",
+		"DROP FUNCTION IF EXISTS find_activities_intersecting_line(GEOGRAPHY, DOUBLE PRECISION)",
 		"DROP FUNCTION IF EXISTS find_route_parts_matching_segment_by_name(TEXT, DOUBLE PRECISION)",
+		"DROP FUNCTION IF EXISTS find_route_parts_matching_segment_by_name(BIGINT, TEXT, DOUBLE PRECISION)",
 		"DROP FUNCTION IF EXISTS find_segment_point_indices(BIGINT, BIGINT, BIGINT, DOUBLE PRECISION)",
+		"DROP FUNCTION IF EXISTS find_segment_traversals(BIGINT, BIGINT, BIGINT, DOUBLE PRECISION)",
 		"DROP FUNCTION IF EXISTS get_activity_segment_metrics(BIGINT, BIGINT, BIGINT, DOUBLE PRECISION)",
+		"DROP FUNCTION IF EXISTS get_segment_range_metrics(BIGINT, BIGINT, INTEGER, INTEGER)",
 	}
 	for _, dropQuery := range dropHelperQueries {
 		if _, err := conn.Exec(ctx, dropQuery); err != nil {
@@ -453,8 +750,10 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 		UPDATE activity_geometries
 		SET route_geog_simplified = simplify_route_geog_meters(route_geog, p_tolerance_meters);
 		$$;`,
-		// Find activities near
+		// Find activities near, among those the viewer may see: their own and other
+		// athletes' instance-visible ones
 		`CREATE OR REPLACE FUNCTION find_activities_near(
+			p_viewer_athlete_id BIGINT,
 			p_lon DOUBLE PRECISION,
 			p_lat DOUBLE PRECISION,
 			p_radius_meters DOUBLE PRECISION
@@ -467,12 +766,15 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 			)
 			SELECT a.activity_id,
 					ST_Distance(a.route_geog, q.pt) AS min_dist_m
-			FROM activity_geometries a, q
-			WHERE ST_DWithin(a.route_geog, q.pt, q.r)
+			FROM activity_geometries a
+			JOIN activity_summaries s ON s.id = a.activity_id, q
+			WHERE ` + visibleToViewerSQL("s", "p_viewer_athlete_id") + `
+			  AND ST_DWithin(a.route_geog, q.pt, q.r)
 			ORDER BY min_dist_m;
 			$$;`,
-		// Find activities intersecting line
+		// Find activities intersecting line, among those the viewer may see
 		`CREATE OR REPLACE FUNCTION find_activities_intersecting_line(
+			p_viewer_athlete_id BIGINT,
 			p_line GEOGRAPHY,              -- input route/segment, GEOGRAPHY(LINESTRING,4326)
 			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
 			)
@@ -497,8 +799,10 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 					q.line
 				)
 				) AS overlap_length_m
-			FROM activity_geometries a, q
-			WHERE ST_DWithin(a.route_geog, q.line, q.tol)
+			FROM activity_geometries a
+			JOIN activity_summaries s ON s.id = a.activity_id, q
+			WHERE ` + visibleToViewerSQL("s", "p_viewer_athlete_id") + `
+			  AND ST_DWithin(a.route_geog, q.line, q.tol)
 			ORDER BY min_distance_m;
 			$$;`,
 
@@ -514,12 +818,77 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 			WHERE id = p_segment_id;
 			$$;`,
 
+		// Projected coverage of a segment by an activity: the fraction of the segment, 0 to 1,
+		// that the activity rode along. Points within tolerance are projected onto the segment
+		// (ST_LineLocatePoint); each pair of consecutive such points covers the range between
+		// their positions and overlapping ranges are merged. Crossing the segment covers
+		// almost nothing, unlike the length of the segment inside a buffer of the route.
+		`CREATE OR REPLACE FUNCTION segment_projected_coverage(
+			p_segment GEOGRAPHY,
+			p_activity_id BIGINT,
+			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
+			)
+			RETURNS DOUBLE PRECISION
+			LANGUAGE SQL STABLE AS
+			$$
+			WITH near_points AS (
+				SELECT
+					ps.point_index,
+					ST_LineLocatePoint(p_segment::geometry, ps.location::geometry) AS position
+				FROM point_sample_rows ps
+				WHERE ps.activity_id = p_activity_id
+				  AND ST_DWithin(ps.location, p_segment, p_tolerance_meters)
+			),
+			pairs AS (
+				SELECT
+					point_index,
+					position,
+					LEAD(point_index) OVER (ORDER BY point_index) AS next_index,
+					LEAD(position) OVER (ORDER BY point_index) AS next_position
+				FROM near_points
+			),
+			ranges AS (
+				SELECT LEAST(position, next_position) AS lo, GREATEST(position, next_position) AS hi
+				FROM pairs
+				WHERE next_index = point_index + 1
+			),
+			-- A range starts a new island when it begins after every range before it ended
+			starts AS (
+				SELECT
+					lo,
+					hi,
+					CASE WHEN lo > MAX(hi) OVER (ORDER BY lo, hi ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING)
+						THEN 0 ELSE 1 END AS same_island
+				FROM ranges
+			),
+			islands AS (
+				SELECT lo, hi, SUM(1 - same_island) OVER (ORDER BY lo, hi ROWS UNBOUNDED PRECEDING) AS island
+				FROM starts
+			)
+			SELECT COALESCE(SUM(island_hi - island_lo), 0.0)::DOUBLE PRECISION
+			FROM (
+				SELECT MIN(lo) AS island_lo, MAX(hi) AS island_hi
+				FROM islands
+				GROUP BY island
+			) merged;
+			$$;`,
+
 		// Find route parts matching segment
 		// Uses geometry-based matching: checks if segment geometry is within tolerance of activity route
-		// This allows for deviations along the route and works regardless of point density
+		// This allows for deviations along the route and works regardless of point density.
+		// Activities passing both segment endpoints match in either direction;
+		// find_segment_traversals decides the direction of each effort. overlap_percentage is
+		// the projected coverage; overlap_length_m is the segment length inside a buffer of the
+		// route, kept as a diagnostic.
+		// Only the segment owner's activities are matched, and nothing when p_athlete_id does
+		// not own the segment: segments are private, so another athlete's instance-visible
+		// ride never becomes an effort on them. p_activity_ids limits the search to those activities, e.g.
+		// ones just imported.
 		`CREATE OR REPLACE FUNCTION find_route_parts_matching_segment(
+			p_athlete_id BIGINT,
 			p_segment_id BIGINT,
-			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
+			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0,
+			p_activity_ids BIGINT[] DEFAULT NULL
 			)
 			RETURNS TABLE (
 			activity_id BIGINT,
@@ -533,7 +902,7 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 			WITH segment_data AS (
 				SELECT segment_geog, ST_Length(segment_geog) AS segment_length
 				FROM favorite_segments
-				WHERE id = p_segment_id
+				WHERE id = p_segment_id AND athlete_id = p_athlete_id
 			),
 			-- Ensure segment exists
 			segment_check AS (
@@ -552,6 +921,8 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 				CROSS JOIN segment_data sd
 				CROSS JOIN segment_check sc
 				WHERE sc.cnt > 0  -- Only proceed if segment exists
+				  AND a.athlete_id = p_athlete_id
+				  AND (p_activity_ids IS NULL OR a.activity_id = ANY(p_activity_ids))
 				  AND ST_DWithin(a.route_geog, sd.segment_geog, p_tolerance_meters)
 			),
 			direction_matches AS (
@@ -564,21 +935,20 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 				CROSS JOIN segment_endpoints se
 				CROSS JOIN LATERAL (
 					SELECT ps.point_index, ST_Distance(ps.location, se.start_geog) AS dist
-					FROM point_samples ps
+					FROM point_sample_rows ps
 					WHERE ps.activity_id = ca.activity_id
 					ORDER BY dist
 					LIMIT 1
 				) start_point
 				CROSS JOIN LATERAL (
 					SELECT ps.point_index, ST_Distance(ps.location, se.end_geog) AS dist
-					FROM point_samples ps
+					FROM point_sample_rows ps
 					WHERE ps.activity_id = ca.activity_id
 					ORDER BY dist
 					LIMIT 1
 				) end_point
 				WHERE start_point.dist <= p_tolerance_meters
 				  AND end_point.dist <= p_tolerance_meters
-				  AND start_point.point_index < end_point.point_index
 			),
 			-- Check if all segment points are within tolerance of the activity route
 			-- This ensures the segment geometry matches (allows deviations along route)
@@ -629,19 +999,17 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 				p_segment_id AS segment_id,
 				oc.min_distance_m,
 				oc.overlap_length_m,
-				CASE 
-					WHEN sd.segment_length > 0 THEN
-						LEAST((oc.overlap_length_m / sd.segment_length) * 100.0, 100.0)
-					ELSE 0.0
-				END AS overlap_percentage
+				LEAST(segment_projected_coverage(sd.segment_geog, oc.activity_id, p_tolerance_meters) * 100.0, 100.0) AS overlap_percentage
 			FROM overlap_calc oc
 			CROSS JOIN segment_data sd
 			WHERE oc.overlap_length_m > 0
 			ORDER BY oc.min_distance_m, oc.overlap_length_m DESC;
 			$$;`,
 
-		// Find route parts matching segment by name
+		// Find route parts of the athlete's activities matching the athlete's segment by
+		// name; (athlete_id, name) is unique so at most one segment is used
 		`CREATE OR REPLACE FUNCTION find_route_parts_matching_segment_by_name(
+			p_athlete_id BIGINT,
 			p_segment_name TEXT,
 			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
 			)
@@ -658,7 +1026,7 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 			WITH segment_data AS (
 				SELECT id, name, segment_geog, ST_Length(segment_geog) AS segment_length
 				FROM favorite_segments
-				WHERE name = p_segment_name
+				WHERE athlete_id = p_athlete_id AND name = p_segment_name
 			),
 			q AS (
 				SELECT
@@ -686,19 +1054,20 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 				CROSS JOIN q
 				CROSS JOIN LATERAL (
 					SELECT ps.point_index, ST_Distance(ps.location, q.start_geog) AS dist
-					FROM point_samples ps
+					FROM point_sample_rows ps
 					WHERE ps.activity_id = a.activity_id
 					ORDER BY dist
 					LIMIT 1
 				) start_point
 				CROSS JOIN LATERAL (
 					SELECT ps.point_index, ST_Distance(ps.location, q.end_geog) AS dist
-					FROM point_samples ps
+					FROM point_sample_rows ps
 					WHERE ps.activity_id = a.activity_id
 					ORDER BY dist
 					LIMIT 1
 				) end_point
-				WHERE ST_DWithin(a.route_geog, q.line, q.tol)
+				WHERE a.athlete_id = p_athlete_id
+				  AND ST_DWithin(a.route_geog, q.line, q.tol)
 				  AND start_point.dist <= q.tol
 				  AND end_point.dist <= q.tol
 				  AND start_point.point_index < end_point.point_index
@@ -714,64 +1083,155 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 						d.line
 					)
 				) AS overlap_length_m,
-				CASE 
-					WHEN d.segment_length > 0 THEN
-						ST_Length(
-							ST_Intersection(
-								ST_Buffer(a.route_geog::geometry, d.tol)::geography,
-								d.line
-							)
-						) / d.segment_length * 100.0
-					ELSE 0.0
-				END AS overlap_percentage
+				LEAST(segment_projected_coverage(d.line, d.activity_id, d.tol) * 100.0, 100.0) AS overlap_percentage
 			FROM directed d
 			INNER JOIN activity_geometries a ON a.activity_id = d.activity_id
 			ORDER BY min_distance_m, overlap_percentage DESC;
 			$$;`,
-		// Find point indices for segment portion in an activity
-		`CREATE OR REPLACE FUNCTION find_segment_point_indices(
+		// Find every traversal of a segment in an activity, in either direction. Points within
+		// tolerance of the segment line form runs; gaps of up to 10 points (GPS noise, a brief
+		// detour) do not end a run. In a run, consecutive points near the segment start, or
+		// near its end, are one visit at the nearest point, and adjacent visits pair up: start
+		// then end is a forward traversal, end then start a reverse one. A pair only counts
+		// when the points' positions along the segment (ST_LineLocatePoint) rise for forward,
+		// or fall for reverse, never going back by more than the tolerance. Laps of a loop
+		// become separate efforts and an out-and-back gives a forward and a reverse one.
+		`CREATE OR REPLACE FUNCTION find_segment_traversals(
 			p_segment_id BIGINT,
 			p_activity_id BIGINT,
 			p_athlete_id BIGINT,
 			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
 		)
 		RETURNS TABLE (
+			effort_number INTEGER,
 			start_index INTEGER,
-			end_index INTEGER
+			end_index INTEGER,
+			direction TEXT
 		)
 		LANGUAGE SQL STABLE AS
 		$$
-		WITH segment_endpoints AS (
+		WITH segment AS (
 			SELECT
+				segment_geog,
+				segment_geog::geometry AS segment_geom,
+				GREATEST(ST_Length(segment_geog), 1.0) AS segment_length,
 				ST_StartPoint(segment_geog::geometry)::geography AS start_geog,
-				ST_EndPoint(segment_geog::geometry)::geography AS end_geog,
-				p_tolerance_meters AS tol
+				ST_EndPoint(segment_geog::geometry)::geography AS end_geog
 			FROM favorite_segments
 			WHERE id = p_segment_id
 		),
-		start_point AS (
-			SELECT ps.point_index, ST_Distance(ps.location, se.start_geog) AS dist
-			FROM point_samples ps, segment_endpoints se
-			WHERE ps.activity_id = p_activity_id 
+		near_points AS (
+			SELECT
+				ps.point_index,
+				ST_LineLocatePoint(s.segment_geom, ps.location::geometry) AS position,
+				ST_Distance(ps.location, s.start_geog) AS start_dist,
+				ST_Distance(ps.location, s.end_geog) AS end_dist,
+				ps.point_index - LAG(ps.point_index) OVER (ORDER BY ps.point_index) AS gap
+			FROM point_sample_rows ps, segment s
+			WHERE ps.activity_id = p_activity_id
 			  AND ps.athlete_id = p_athlete_id
-			ORDER BY dist
-			LIMIT 1
+			  AND ST_DWithin(ps.location, s.segment_geog, p_tolerance_meters)
 		),
-		end_point AS (
-			SELECT ps.point_index, ST_Distance(ps.location, se.end_geog) AS dist
-			FROM point_samples ps, segment_endpoints se
-			WHERE ps.activity_id = p_activity_id 
-			  AND ps.athlete_id = p_athlete_id
-			ORDER BY dist
-			LIMIT 1
+		runs AS (
+			SELECT
+				point_index,
+				position,
+				start_dist,
+				end_dist,
+				SUM(CASE WHEN gap IS NULL OR gap > 10 THEN 1 ELSE 0 END) OVER (ORDER BY point_index) AS run_id
+			FROM near_points
+		),
+		-- Points near the start (S) or the end (E); one near both belongs to the nearer
+		anchors AS (
+			SELECT
+				run_id,
+				point_index,
+				CASE WHEN start_dist <= end_dist THEN 'S' ELSE 'E' END AS kind,
+				LEAST(start_dist, end_dist) AS dist
+			FROM runs
+			WHERE LEAST(start_dist, end_dist) <= p_tolerance_meters
+		),
+		visits AS (
+			SELECT
+				run_id,
+				point_index,
+				kind,
+				dist,
+				SUM(CASE WHEN kind IS DISTINCT FROM prev_kind THEN 1 ELSE 0 END)
+					OVER (PARTITION BY run_id ORDER BY point_index) AS visit_id
+			FROM (
+				SELECT a.*, LAG(a.kind) OVER (PARTITION BY a.run_id ORDER BY a.point_index) AS prev_kind
+				FROM anchors a
+			) k
+		),
+		visit_points AS (
+			SELECT DISTINCT ON (run_id, visit_id) run_id, visit_id, kind, point_index
+			FROM visits
+			ORDER BY run_id, visit_id, dist, point_index
+		),
+		candidates AS (
+			SELECT
+				run_id,
+				start_index,
+				end_index,
+				CASE WHEN kind = 'S' THEN 'forward' ELSE 'reverse' END AS direction
+			FROM (
+				SELECT
+					run_id,
+					kind,
+					point_index AS start_index,
+					LEAD(point_index) OVER (PARTITION BY run_id ORDER BY visit_id) AS end_index
+				FROM visit_points
+			) pairs
+			WHERE end_index IS NOT NULL
+		),
+		traversals AS (
+			SELECT c.start_index, c.end_index, c.direction
+			FROM candidates c
+			CROSS JOIN segment s
+			CROSS JOIN LATERAL (
+				SELECT MAX(CASE
+					WHEN c.direction = 'forward' THEN p.running_max - p.position
+					ELSE p.position - p.running_min
+				END) AS backtrack
+				FROM (
+					SELECT
+						r.position,
+						MAX(r.position) OVER (ORDER BY r.point_index) AS running_max,
+						MIN(r.position) OVER (ORDER BY r.point_index) AS running_min
+					FROM runs r
+					WHERE r.run_id = c.run_id
+					  AND r.point_index BETWEEN c.start_index AND c.end_index
+				) p
+			) m
+			WHERE m.backtrack * s.segment_length <= p_tolerance_meters
 		)
-		SELECT 
-			sp.point_index::INTEGER AS start_index,
-			ep.point_index::INTEGER AS end_index
-		FROM start_point sp, end_point ep, segment_endpoints se
-		WHERE sp.dist <= se.tol
-		  AND ep.dist <= se.tol
-		  AND sp.point_index < ep.point_index;
+		SELECT
+			(ROW_NUMBER() OVER (ORDER BY t.start_index))::INTEGER AS effort_number,
+			t.start_index::INTEGER,
+			t.end_index::INTEGER,
+			t.direction
+		FROM traversals t
+		ORDER BY t.start_index;
+		$$;`,
+		// Find point indices for the first forward traversal of a segment in an activity
+		`CREATE OR REPLACE FUNCTION find_segment_point_indices(
+			p_segment_id BIGINT,
+			p_activity_id BIGINT,
+			p_athlete_id BIGINT,
+			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
+		)
+		RETURNS TABLE (
+			start_index INTEGER,
+			end_index INTEGER
+		)
+		LANGUAGE SQL STABLE AS
+		$$
+		SELECT t.start_index, t.end_index
+		FROM find_segment_traversals(p_segment_id, p_activity_id, p_athlete_id, p_tolerance_meters) t
+		WHERE t.direction = 'forward'
+		ORDER BY t.effort_number
+		LIMIT 1;
 		$$;`,
 		// Get segment metrics (distance, elevation gain)
 		`CREATE OR REPLACE FUNCTION get_segment_metrics(
@@ -789,12 +1249,13 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 		FROM favorite_segments
 		WHERE id = p_segment_id;
 		$$;`,
-		// Get activity segment portion metrics
-		`CREATE OR REPLACE FUNCTION get_activity_segment_metrics(
-			p_segment_id BIGINT,
+		// Get metrics for a point index range of an activity. avg_speed is distance over
+		// elapsed time (analysis.ElapsedAvgSpeed), not the mean of the speed samples.
+		`CREATE OR REPLACE FUNCTION get_segment_range_metrics(
 			p_activity_id BIGINT,
 			p_athlete_id BIGINT,
-			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
+			p_start_index INTEGER,
+			p_end_index INTEGER
 		)
 		RETURNS TABLE (
 			avg_hr DOUBLE PRECISION,
@@ -805,11 +1266,7 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 		)
 		LANGUAGE SQL STABLE AS
 		$$
-		WITH segment_indices AS (
-			SELECT start_index, end_index
-			FROM find_segment_point_indices(p_segment_id, p_activity_id, p_athlete_id, p_tolerance_meters)
-		),
-		segment_points AS (
+		WITH segment_points AS (
 			SELECT 
 				ps.point_index,
 				ps.time,
@@ -819,16 +1276,15 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 				ps.location,
 				LAG(ps.altitude) OVER (ORDER BY ps.point_index) AS prev_altitude,
 				LAG(ps.location) OVER (ORDER BY ps.point_index) AS prev_location
-			FROM point_samples ps, segment_indices si
+			FROM point_sample_rows ps
 			WHERE ps.activity_id = p_activity_id 
 			  AND ps.athlete_id = p_athlete_id
-			  AND ps.point_index BETWEEN si.start_index AND si.end_index
+			  AND ps.point_index BETWEEN p_start_index AND p_end_index
 			ORDER BY ps.point_index
 		),
 		segment_metrics AS (
 			SELECT 
 				AVG(heartrate) FILTER (WHERE heartrate IS NOT NULL) AS avg_hr,
-				AVG(speed) FILTER (WHERE speed IS NOT NULL) AS avg_speed,
 				SUM(
 					CASE 
 						WHEN altitude IS NOT NULL AND prev_altitude IS NOT NULL 
@@ -849,12 +1305,41 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 		)
 		SELECT 
 			COALESCE((SELECT avg_hr FROM segment_metrics), 0.0) AS avg_hr,
-			COALESCE((SELECT avg_speed FROM segment_metrics), 0.0) AS avg_speed,
+			COALESCE((SELECT distance_m / NULLIF(elapsed_seconds, 0) FROM segment_metrics), 0.0) AS avg_speed,
 			COALESCE((SELECT distance_m FROM segment_metrics), 0.0) AS distance_m,
 			COALESCE((SELECT elevation_gain FROM segment_metrics), 0.0) AS elevation_gain_m,
 			COALESCE((SELECT elapsed_seconds FROM segment_metrics), 0.0) AS elapsed_seconds
 		FROM (SELECT 1) AS dummy;
 		$$;`,
+		// Get activity segment portion metrics for the first traversal; zeros when there is none
+		`CREATE OR REPLACE FUNCTION get_activity_segment_metrics(
+			p_segment_id BIGINT,
+			p_activity_id BIGINT,
+			p_athlete_id BIGINT,
+			p_tolerance_meters DOUBLE PRECISION DEFAULT 15.0
+		)
+		RETURNS TABLE (
+			avg_hr DOUBLE PRECISION,
+			avg_speed DOUBLE PRECISION,
+			distance_m DOUBLE PRECISION,
+			elevation_gain_m DOUBLE PRECISION,
+			elapsed_seconds DOUBLE PRECISION
+		)
+		LANGUAGE SQL STABLE AS
+		$$
+		SELECT
+			COALESCE(m.avg_hr, 0.0),
+			COALESCE(m.avg_speed, 0.0),
+			COALESCE(m.distance_m, 0.0),
+			COALESCE(m.elevation_gain_m, 0.0),
+			COALESCE(m.elapsed_seconds, 0.0)
+		FROM (SELECT 1) AS dummy
+		LEFT JOIN LATERAL (
+			SELECT rm.*
+			FROM find_segment_point_indices(p_segment_id, p_activity_id, p_athlete_id, p_tolerance_meters) si
+			CROSS JOIN LATERAL get_segment_range_metrics(p_activity_id, p_athlete_id, si.start_index, si.end_index) rm
+		) m ON TRUE;
+		$$;`,
 	}
 
 	for _, helperQuery := range helperQueries {
@@ -866,7 +1351,7 @@ func createHelperFunctions(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func createDiscoveredActivityBuffersTable(ctx context.Context, conn *pgx.Conn) error {
+func createDiscoveredActivityBuffersTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS discovered_activity_buffers (
 		activity_id BIGINT PRIMARY KEY REFERENCES activity_summaries(id) ON DELETE CASCADE,
@@ -901,7 +1386,7 @@ func createDiscoveredActivityBuffersTable(ctx context.Context, conn *pgx.Conn) e
 	return nil
 }
 
-func createDiscoveredCoverageCacheTable(ctx context.Context, conn *pgx.Conn) error {
+func createDiscoveredCoverageCacheTable(ctx context.Context, conn DB) error {
 	query := `
 	CREATE TABLE IF NOT EXISTS discovered_coverage_cache (
 		athlete_id BIGINT PRIMARY KEY,
@@ -937,140 +1422,680 @@ func createDiscoveredCoverageCacheTable(ctx context.Context, conn *pgx.Conn) err
 	return nil
 }
 
-// TableSchema represents the expected schema for a table
-type TableSchema struct {
-	Name        string
-	Columns     []ColumnDef
-	Indexes     []string
-	Constraints []string
-	IsCache     bool // If true, safe to drop/recreate on mismatch
+// createExploredAreaTable holds each athlete's explored area: the union of the buffered
+// simplified routes of their activities up to through_start_date/through_activity_id,
+// extended as new activities are measured (see ComputeNewDistance)
+func createExploredAreaTable(ctx context.Context, conn DB) error {
+	_, err := conn.Exec(ctx, `
+	CREATE TABLE IF NOT EXISTS explored_area (
+		athlete_id BIGINT PRIMARY KEY,
+		buffer_m DOUBLE PRECISION NOT NULL,
+		area_geom GEOMETRY(MULTIPOLYGON, 4326),
+		through_start_date TIMESTAMPTZ NOT NULL,
+		through_activity_id BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	return err
 }
 
-// ColumnDef represents a column definition
-type ColumnDef struct {
-	Name         string
-	Type         string
-	Nullable     bool
-	DefaultValue *string
-}
+func createAthleteSettingsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS athlete_settings (
+		athlete_id BIGINT PRIMARY KEY,
+		default_tolerance_m DOUBLE PRECISION CHECK (default_tolerance_m > 0),
+		display_timezone TEXT,
+		updated_at TIMESTAMPTZ DEFAULT NOW()
+	)`
 
-// TableValidationResult represents the result of validating a table
-type TableValidationResult struct {
-	TableName   string
-	Exists      bool
-	Matches     bool
-	Differences []string
-	ActionTaken string
+	_, err := conn.Exec(ctx, query)
+	return err
 }
 
-// ValidateAndMigrateSchema validates all tables and creates/fixes them as needed
-// If forceRebuild is true, tables with schema mismatches will be dropped and recreated
-// even if they are not cache tables (WARNING: this will delete all data in those tables)
-func ValidateAndMigrateSchema(ctx context.Context, conn *pgx.Conn, forceRebuild bool) error {
-	log.Printf("🔍 Validating database schema...")
-	if forceRebuild {
-		log.Printf("⚠️ Force rebuild mode enabled - mismatched tables will be dropped and recreated")
-	}
+// createAthleteTokensTable stores the Strava tokens behind each web login so expired
+// access tokens can be refreshed without re-authorizing. token_key is the hashed cookie token.
+func createAthleteTokensTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS athlete_tokens (
+		token_key TEXT PRIMARY KEY,
+		athlete_id BIGINT NOT NULL,
+		access_token TEXT NOT NULL,
+		refresh_token TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		created_at TIMESTAMPTZ DEFAULT NOW(),
+		updated_at TIMESTAMPTZ DEFAULT NOW()
+	)`
 
-	if err := ensureFavoriteSegmentColumns(ctx, conn); err != nil {
-		return err
-	}
-	if err := ensureActivitySummaryColumns(ctx, conn); err != nil {
-		return err
-	}
-	if err := ensureMobileAppSessionColumns(ctx, conn); err != nil {
+	if _, err := conn.Exec(ctx, query); err != nil {
 		return err
 	}
 
-	expectedSchemas := GetExpectedTableSchemas()
-	var results []TableValidationResult
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_athlete_tokens_athlete_id ON athlete_tokens (athlete_id)",
+	}
 
-	for _, schema := range expectedSchemas {
-		result, err := ValidateTableSchema(ctx, conn, schema)
-		if err != nil {
-			log.Printf("❌ Error validating table %s: %v", schema.Name, err)
-			return fmt.Errorf("failed to validate table %s: %w", schema.Name, err)
+	for _, indexQuery := range indexes {
+		if _, err := conn.Exec(ctx, indexQuery); err != nil {
+			return fmt.Errorf("failed to create athlete_tokens index: %w", err)
 		}
-		results = append(results, result)
+	}
 
-		// Handle missing or mismatched tables
-		if !result.Exists {
-			log.Printf("📝 Table %s does not exist, creating...", schema.Name)
-			if err := createTableBySchema(ctx, conn, schema); err != nil {
-				return fmt.Errorf("failed to create table %s: %w", schema.Name, err)
-			}
-			result.ActionTaken = "created"
-			log.Printf("✅ Created table %s", schema.Name)
-		} else if !result.Matches {
-			log.Printf("⚠️ Table %s schema mismatch detected", schema.Name)
-			if len(result.Differences) > 0 {
-				for _, diff := range result.Differences {
-					log.Printf("   - %s", diff)
-				}
-			}
+	return nil
+}
 
-			// For cache tables, always drop and recreate
-			// For data tables, only drop and recreate if forceRebuild is true
-			shouldRebuild := schema.IsCache || forceRebuild
-
-			if shouldRebuild {
-				if forceRebuild && !schema.IsCache {
-					log.Printf("⚠️ WARNING: Force rebuilding data table %s - ALL DATA WILL BE LOST", schema.Name)
-				}
-				log.Printf("🔄 Dropping and recreating table %s...", schema.Name)
-				dropQuery := fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", schema.Name)
-				if _, err := conn.Exec(ctx, dropQuery); err != nil {
-					return fmt.Errorf("failed to drop table %s: %w", schema.Name, err)
-				}
-				if err := createTableBySchema(ctx, conn, schema); err != nil {
-					return fmt.Errorf("failed to recreate table %s: %w", schema.Name, err)
-				}
-				result.ActionTaken = "recreated"
-				log.Printf("✅ Recreated table %s", schema.Name)
-			} else {
-				// For data tables without force rebuild, log warning but don't auto-fix
-				log.Printf("⚠️ Table %s has schema differences but is not a cache table", schema.Name)
-				log.Printf("   Use -force-rebuild flag to rebuild this table (WARNING: will delete all data)")
-				result.ActionTaken = "warning"
-			}
-		} else {
-			log.Printf("✅ Table %s schema is valid", schema.Name)
-			result.ActionTaken = "valid"
-		}
-	}
+// createSkippedActivitiesTable records activities the sync stopped fetching, e.g. ones
+// deleted on Strava between the listing and the detail fetch
+func createSkippedActivitiesTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS skipped_activities (
+		athlete_id BIGINT NOT NULL,
+		activity_id BIGINT NOT NULL,
+		reason TEXT NOT NULL,
+		first_seen TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (athlete_id, activity_id)
+	)`
 
-	// Ensure helper functions exist
-	if err := createHelperFunctions(ctx, conn); err != nil {
-		log.Printf("⚠️ Warning: failed to create helper functions: %v", err)
-		// Don't fail on this, as PostGIS might not be available
-	}
+	_, err := conn.Exec(ctx, query)
+	return err
+}
 
-	// Migrate point_samples table to add cumulative_distance column if it doesn't exist
-	if err := migratePointSamplesTable(ctx, conn); err != nil {
-		log.Printf("⚠️ Warning: failed to migrate point_samples table: %v", err)
-		// Don't fail on this, migration can be done manually
-	}
+// createAthleteProfilesTable stores the Strava profile and HR zones prefetched at login,
+// read by pages instead of Strava; the prefetched gear goes to gear
+func createAthleteProfilesTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS athlete_profiles (
+		athlete_id BIGINT PRIMARY KEY,
+		firstname TEXT NOT NULL DEFAULT '',
+		lastname TEXT NOT NULL DEFAULT '',
+		profile TEXT NOT NULL DEFAULT '',
+		hr_zones JSONB,
+		prefetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
 
-	log.Printf("✅ Schema validation completed")
-	return nil
+	_, err := conn.Exec(ctx, query)
+	return err
 }
 
-func ensureFavoriteSegmentColumns(ctx context.Context, conn *pgx.Conn) error {
-	queries := []string{
-		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS elevation_loss_m DOUBLE PRECISION",
-		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS net_elevation_m DOUBLE PRECISION",
-	}
-	for _, query := range queries {
-		if _, err := conn.Exec(ctx, query); err != nil {
-			return fmt.Errorf("failed to ensure favorite_segments compatibility columns: %w", err)
-		}
-	}
-	return nil
+// createAthletesTable stores each athlete's Strava identity as last seen at login, sync
+// or prefetch, so pages resolve who is signed in without calling Strava
+func createAthletesTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS athletes (
+		id BIGINT PRIMARY KEY,
+		firstname TEXT NOT NULL DEFAULT '',
+		lastname TEXT NOT NULL DEFAULT '',
+		profile_url TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+	_, err := conn.Exec(ctx, query)
+	return err
 }
 
-func ensureActivitySummaryColumns(ctx context.Context, conn *pgx.Conn) error {
+// createPublicStatsTokensTable stores the tokens behind /public/stats; token_key is the
+// hashed token. expires_at and max_views are optional limits, views the requests answered.
+func createPublicStatsTokensTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS public_stats_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		token_key TEXT NOT NULL UNIQUE,
+		athlete_id BIGINT NOT NULL,
+		fields TEXT[] NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMPTZ,
+		max_views BIGINT CHECK (max_views > 0),
+		views BIGINT NOT NULL DEFAULT 0
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		"ALTER TABLE public_stats_tokens ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ",
+		"ALTER TABLE public_stats_tokens ADD COLUMN IF NOT EXISTS max_views BIGINT CHECK (max_views > 0)",
+		"ALTER TABLE public_stats_tokens ADD COLUMN IF NOT EXISTS views BIGINT NOT NULL DEFAULT 0",
+	} {
+		if _, err := conn.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to add public_stats_tokens limit columns: %w", err)
+		}
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_public_stats_tokens_athlete_id ON public_stats_tokens (athlete_id)"); err != nil {
+		return fmt.Errorf("failed to create public_stats_tokens index: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_public_stats_tokens_expires_at ON public_stats_tokens (expires_at) WHERE expires_at IS NOT NULL"); err != nil {
+		return fmt.Errorf("failed to create public_stats_tokens expiry index: %w", err)
+	}
+	return nil
+}
+
+// createShareViewsTable stores one row per view of a share token, for its owner's view
+// statistics. Rows go with their token and are pruned after the configured retention.
+func createShareViewsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS share_views (
+		id BIGSERIAL PRIMARY KEY,
+		token_id BIGINT NOT NULL REFERENCES public_stats_tokens(id) ON DELETE CASCADE,
+		viewed_at TIMESTAMPTZ NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		country TEXT,
+		bot BOOLEAN NOT NULL DEFAULT FALSE
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_share_views_token_id ON share_views (token_id, viewed_at)"); err != nil {
+		return fmt.Errorf("failed to create share_views index: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_share_views_viewed_at ON share_views (viewed_at)"); err != nil {
+		return fmt.Errorf("failed to create share_views retention index: %w", err)
+	}
+	return nil
+}
+
+// createWebSessionsTable lists the web logins of athlete_tokens for the settings page.
+// token_key matches athlete_tokens; rows appear when a session is first used.
+func createWebSessionsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS web_sessions (
+		id BIGSERIAL PRIMARY KEY,
+		token_key TEXT NOT NULL UNIQUE,
+		athlete_id BIGINT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_web_sessions_athlete_id ON web_sessions (athlete_id)"); err != nil {
+		return fmt.Errorf("failed to create web_sessions index: %w", err)
+	}
+	return nil
+}
+
+// createAnnouncementsTables creates the instance-wide announcements shown as a banner on
+// every page while now is in [starts_at, ends_at), and the web sessions that dismissed
+// them. token_key matches web_sessions.
+func createAnnouncementsTables(ctx context.Context, conn DB) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS announcements (
+			id BIGSERIAL PRIMARY KEY,
+			message TEXT NOT NULL,
+			level TEXT NOT NULL DEFAULT 'info',
+			starts_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			ends_at TIMESTAMPTZ,
+			created_by BIGINT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS announcement_dismissals (
+			announcement_id BIGINT NOT NULL REFERENCES announcements(id) ON DELETE CASCADE,
+			token_key TEXT NOT NULL,
+			dismissed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (announcement_id, token_key)
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_announcement_dismissals_token_key ON announcement_dismissals (token_key)",
+	}
+	for _, query := range queries {
+		if _, err := conn.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createImportFilesTable records the SHA-256 of every imported GPX or TCX file, so the same
+// file uploaded again is skipped without parsing. Rows go with their activity.
+func createImportFilesTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS import_files (
+		athlete_id BIGINT NOT NULL,
+		hash TEXT NOT NULL,
+		activity_id BIGINT NOT NULL REFERENCES activity_summaries(id) ON DELETE CASCADE,
+		filename TEXT NOT NULL,
+		imported_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (athlete_id, hash)
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_import_files_filename ON import_files (athlete_id, filename)"); err != nil {
+		return fmt.Errorf("failed to create import_files index: %w", err)
+	}
+	return nil
+}
+
+// createActivityTagsTable holds the tags of activities, added by hand or by the tag rules
+// of a sync. Rows go with their activity.
+func createActivityTagsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS activity_tags (
+		activity_id BIGINT NOT NULL REFERENCES activity_summaries(id) ON DELETE CASCADE,
+		athlete_id BIGINT NOT NULL,
+		tag TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (activity_id, tag)
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_activity_tags_athlete_tag ON activity_tags (athlete_id, tag)"); err != nil {
+		return fmt.Errorf("failed to create activity_tags index: %w", err)
+	}
+	return nil
+}
+
+// createActivityStreamsTable holds the point samples of activities stored as streams, one
+// row per activity with a same-length array per point_samples column. Point ids come from
+// the point_samples sequence, so a point keeps its id when converted either way. Postgres compresses
+// the arrays, so an activity takes a fraction of its rows' space, but a single point can
+// only be read by expanding the whole activity. Rows go with their activity.
+func createActivityStreamsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS activity_streams (
+		activity_id BIGINT PRIMARY KEY REFERENCES activity_summaries(id) ON DELETE CASCADE,
+		athlete_id BIGINT NOT NULL,
+		point_count INTEGER NOT NULL,
+		ids BIGINT[] NOT NULL,
+		point_indexes INTEGER[] NOT NULL,
+		times TIMESTAMPTZ[] NOT NULL,
+		lats DOUBLE PRECISION[] NOT NULL,
+		lngs DOUBLE PRECISION[] NOT NULL,
+		altitudes DOUBLE PRECISION[],
+		heartrates INTEGER[],
+		speeds DOUBLE PRECISION[],
+		watts INTEGER[],
+		cadences INTEGER[],
+		grades DOUBLE PRECISION[],
+		moving BOOLEAN[],
+		temperatures INTEGER[],
+		cumulative_distances DOUBLE PRECISION[],
+		created_at TIMESTAMPTZ DEFAULT NOW()
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_activity_streams_athlete_id ON activity_streams (athlete_id)"); err != nil {
+		return fmt.Errorf("failed to create activity_streams index: %w", err)
+	}
+	return nil
+}
+
+// createPointSampleRowsView creates point_sample_rows, the point samples of both layouts
+// with the columns of point_samples, which every reader queries. Streams are expanded only
+// for the activities a query selects by activity_id or athlete_id.
+func createPointSampleRowsView(ctx context.Context, conn DB) error {
+	// The view lists every point_samples column, so older tables get theirs first
+	if err := migratePointSamplesTable(ctx, conn); err != nil {
+		return err
+	}
+	query := `
+	CREATE OR REPLACE VIEW point_sample_rows AS
+	SELECT id, activity_id, athlete_id, point_index, time, location, altitude, heartrate,
+		speed, watts, cadence, grade, moving, temperature, cumulative_distance
+	FROM point_samples
+	UNION ALL
+	SELECT p.id, s.activity_id, s.athlete_id, p.point_index, p.time,
+		ST_SetSRID(ST_MakePoint(p.lng, p.lat), 4326)::geography,
+		p.altitude, p.heartrate, p.speed, p.watts, p.cadence, p.grade, p.moving,
+		p.temperature, p.cumulative_distance
+	FROM activity_streams s
+	CROSS JOIN LATERAL unnest(
+		s.ids, s.point_indexes, s.times, s.lats, s.lngs, s.altitudes, s.heartrates, s.speeds,
+		s.watts, s.cadences, s.grades, s.moving, s.temperatures, s.cumulative_distances
+	) AS p(id, point_index, time, lat, lng, altitude, heartrate, speed, watts, cadence, grade,
+		moving, temperature, cumulative_distance)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+// createGearTable holds the athlete's bikes and shoes: those listed by the login prefetch,
+// with their kind, and those the athlete's activities name by gear_id, with the details
+// fetched during sync at fetched_at. Strava gear IDs are global.
+func createGearTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS gear (
+		id TEXT PRIMARY KEY,
+		athlete_id BIGINT NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		brand TEXT NOT NULL DEFAULT '',
+		model TEXT NOT NULL DEFAULT '',
+		retired BOOLEAN NOT NULL DEFAULT FALSE,
+		kind TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		fetched_at TIMESTAMPTZ
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_gear_athlete_id ON gear (athlete_id)"); err != nil {
+		return fmt.Errorf("failed to create gear index: %w", err)
+	}
+	return nil
+}
+
+// createOutboundWebhookQueueTable holds outbound webhook events that overflowed their
+// endpoint's in-memory queue or were still queued at shutdown, oldest first by id
+func createOutboundWebhookQueueTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS outbound_webhook_queue (
+		id BIGSERIAL PRIMARY KEY,
+		endpoint_url TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		athlete_id BIGINT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		data TEXT NOT NULL,
+		saved_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_outbound_webhook_queue_endpoint ON outbound_webhook_queue (endpoint_url, id)"); err != nil {
+		return fmt.Errorf("failed to create outbound_webhook_queue index: %w", err)
+	}
+	return nil
+}
+
+func createAccountDeletionRequestsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS account_deletion_requests (
+		athlete_id BIGINT PRIMARY KEY,
+		requested_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		execute_after TIMESTAMPTZ NOT NULL,
+		cancelled_at TIMESTAMPTZ,
+		completed_at TIMESTAMPTZ
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_account_deletion_requests_execute_after ON account_deletion_requests (execute_after)",
+	}
+
+	for _, indexQuery := range indexes {
+		if _, err := conn.Exec(ctx, indexQuery); err != nil {
+			return fmt.Errorf("failed to create account deletion request index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// createAccountEventsTable creates the audit trail of exports and deletion requests. Its
+// rows outlive the athlete's data, so a completed deletion stays on record.
+func createAccountEventsTable(ctx context.Context, conn DB) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS account_events (
+		id BIGSERIAL PRIMARY KEY,
+		athlete_id BIGINT NOT NULL,
+		event TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`
+
+	if _, err := conn.Exec(ctx, query); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_account_events_athlete_id ON account_events (athlete_id, id)"); err != nil {
+		return fmt.Errorf("failed to create account events index: %w", err)
+	}
+	return nil
+}
+
+// TableSchema represents the expected schema for a table
+type TableSchema struct {
+	Name        string
+	Columns     []ColumnDef
+	Indexes     []string
+	Constraints []string
+	IsCache     bool // If true, safe to drop/recreate on mismatch
+}
+
+// ColumnDef represents a column definition
+type ColumnDef struct {
+	Name         string
+	Type         string
+	Nullable     bool
+	DefaultValue *string
+}
+
+// TableValidationResult represents the result of validating a table
+type TableValidationResult struct {
+	TableName   string
+	Exists      bool
+	Matches     bool
+	Differences []string
+	// MissingColumns and MissingIndexes can be added to the table in place; Incompatible
+	// lists the differences only dropping and recreating the table fixes
+	MissingColumns []ColumnDef
+	MissingIndexes []string
+	Incompatible   []string
+	// ActionTaken is "created", "valid", "altered" (columns or indexes added in place),
+	// "recreated" or "warning" (incompatible differences left alone)
+	ActionTaken string
+}
+
+// columnDefault returns a ColumnDef.DefaultValue
+func columnDefault(expr string) *string {
+	return &expr
+}
+
+// addColumnSQL returns the ALTER TABLE statement adding col to table, or false when col
+// cannot be added to a table that already has rows: a NOT NULL column needs a default,
+// and array columns do not carry their element type in ColumnDef
+func addColumnSQL(table string, col ColumnDef) (string, bool) {
+	if (!col.Nullable && col.DefaultValue == nil) || col.Type == "ARRAY" {
+		return "", false
+	}
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, col.Name, col.Type)
+	if col.DefaultValue != nil {
+		query += " DEFAULT " + *col.DefaultValue
+	}
+	if !col.Nullable {
+		query += " NOT NULL"
+	}
+	return query, true
+}
+
+// ValidateAndMigrateSchema validates all tables and creates/fixes them as needed
+// If forceRebuild is true, tables with schema mismatches will be dropped and recreated
+// even if they are not cache tables (WARNING: this will delete all data in those tables)
+func ValidateAndMigrateSchema(ctx context.Context, conn DB, forceRebuild bool) error {
+	logger().Info("Validating database schema", "force_rebuild", forceRebuild)
+	if forceRebuild {
+		logger().Warn("Force rebuild enabled, mismatched tables will be dropped and recreated")
+	}
+
+	if err := ensureFavoriteSegmentColumns(ctx, conn); err != nil {
+		return err
+	}
+	if err := ensureActivitySummaryColumns(ctx, conn); err != nil {
+		return err
+	}
+	if err := ensureMobileAppSessionColumns(ctx, conn); err != nil {
+		return err
+	}
+	if err := ensureAthleteSettingsColumns(ctx, conn); err != nil {
+		return err
+	}
+	if err := ensureSegmentActivityMatchColumns(ctx, conn); err != nil {
+		return err
+	}
+
+	for _, schema := range GetExpectedTableSchemas() {
+		if _, err := migrateTable(ctx, conn, schema, forceRebuild, createTableBySchema); err != nil {
+			return err
+		}
+	}
+	if err := migrateAthleteGear(ctx, conn); err != nil {
+		return err
+	}
+
+	// Readers need the view over both point layouts, dropped with either table on rebuild;
+	// the helper functions read it too
+	if err := createPointSampleRowsView(ctx, conn); err != nil {
+		return fmt.Errorf("failed to create point sample rows view: %w", err)
+	}
+
+	// Ensure helper functions exist
+	if err := createHelperFunctions(ctx, conn); err != nil {
+		logger().Warn("Failed to create helper functions", "error", err)
+		// Don't fail on this, as PostGIS might not be available
+	}
+
+	// Migrate point_samples table to add cumulative_distance column if it doesn't exist
+	if err := migratePointSamplesTable(ctx, conn); err != nil {
+		logger().Warn("Failed to migrate the point_samples table", "error", err)
+		// Don't fail on this, migration can be done manually
+	}
+
+	logger().Info("Schema validation completed")
+	return nil
+}
+
+// migrateAthleteGear moves the prefetched gear of the former athlete_gear table into gear
+// and drops athlete_gear. Gear already in gear keeps its details and only gains its kind.
+func migrateAthleteGear(ctx context.Context, conn DB) error {
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass('athlete_gear') IS NOT NULL`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check for athlete_gear: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+	tag, err := conn.Exec(ctx, `
+		INSERT INTO gear (id, athlete_id, name, kind)
+		SELECT gear_id, athlete_id, name, kind FROM athlete_gear
+		ON CONFLICT (id) DO UPDATE SET kind = EXCLUDED.kind
+		WHERE gear.athlete_id = EXCLUDED.athlete_id AND gear.kind = ''
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to move athlete_gear into gear: %w", err)
+	}
+	if _, err := conn.Exec(ctx, `DROP TABLE athlete_gear`); err != nil {
+		return fmt.Errorf("failed to drop athlete_gear: %w", err)
+	}
+	logger().Info("Moved athlete_gear into gear", "rows", tag.RowsAffected())
+	return nil
+}
+
+// migrateTable brings one table in line with schema. A missing table is created with
+// create. Missing nullable or defaulted columns are added with ALTER TABLE and missing
+// indexes by running create again, which only uses IF NOT EXISTS, so rows are kept.
+// Incompatible differences drop and recreate cache tables, and data tables only with
+// forceRebuild; otherwise they are logged and left alone.
+func migrateTable(ctx context.Context, conn DB, schema TableSchema, forceRebuild bool, create func(context.Context, DB, TableSchema) error) (TableValidationResult, error) {
+	result, err := ValidateTableSchema(ctx, conn, schema)
+	if err != nil {
+		logger().Error("Failed to validate table", "table", schema.Name, "error", err)
+		return result, fmt.Errorf("failed to validate table %s: %w", schema.Name, err)
+	}
+
+	switch {
+	case !result.Exists:
+		if err := create(ctx, conn, schema); err != nil {
+			return result, fmt.Errorf("failed to create table %s: %w", schema.Name, err)
+		}
+		result.ActionTaken = "created"
+		logger().Info("Created table", "table", schema.Name)
+		return result, nil
+	case result.Matches:
+		logger().Debug("Table schema is valid", "table", schema.Name)
+		result.ActionTaken = "valid"
+		return result, nil
+	}
+
+	logger().Warn("Table schema mismatch", "table", schema.Name, "differences", result.Differences)
+
+	if len(result.Incompatible) > 0 && (schema.IsCache || forceRebuild) {
+		if !schema.IsCache {
+			logger().Warn("Force rebuilding a data table, all its data is lost", "table", schema.Name)
+		}
+		dropQuery := fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", schema.Name)
+		if _, err := conn.Exec(ctx, dropQuery); err != nil {
+			return result, fmt.Errorf("failed to drop table %s: %w", schema.Name, err)
+		}
+		if err := create(ctx, conn, schema); err != nil {
+			return result, fmt.Errorf("failed to recreate table %s: %w", schema.Name, err)
+		}
+		result.ActionTaken = "recreated"
+		logger().Info("Recreated table", "table", schema.Name)
+		return result, nil
+	}
+
+	// Additive fixes are applied even when incompatible differences remain
+	result.ActionTaken = "valid"
+	for _, col := range result.MissingColumns {
+		query, _ := addColumnSQL(schema.Name, col)
+		if _, err := conn.Exec(ctx, query); err != nil {
+			return result, fmt.Errorf("failed to add column %s.%s: %w", schema.Name, col.Name, err)
+		}
+		logger().Info("Added column", "table", schema.Name, "column", col.Name)
+		result.ActionTaken = "altered"
+	}
+	if len(result.MissingIndexes) > 0 {
+		if err := create(ctx, conn, schema); err != nil {
+			return result, fmt.Errorf("failed to create missing indexes on %s: %w", schema.Name, err)
+		}
+		logger().Info("Created indexes", "table", schema.Name, "indexes", result.MissingIndexes)
+		result.ActionTaken = "altered"
+	}
+	if len(result.Incompatible) > 0 {
+		logger().Warn("Table has schema differences that need a rebuild; run with -force-rebuild to rebuild it, deleting all its data",
+			"table", schema.Name, "differences", result.Incompatible)
+		result.ActionTaken = "warning"
+	}
+	return result, nil
+}
+
+func ensureFavoriteSegmentColumns(ctx context.Context, conn DB) error {
+	queries := []string{
+		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS elevation_loss_m DOUBLE PRECISION",
+		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS net_elevation_m DOUBLE PRECISION",
+		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS default_tolerance_m DOUBLE PRECISION CHECK (default_tolerance_m > 0)",
+		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'strava'",
+		"ALTER TABLE IF EXISTS favorite_segments ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE",
+	}
+	for _, query := range queries {
+		if _, err := conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to ensure favorite_segments compatibility columns: %w", err)
+		}
+	}
+
+	var tableExists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass('favorite_segments') IS NOT NULL`).Scan(&tableExists); err != nil {
+		return fmt.Errorf("failed to check favorite_segments table: %w", err)
+	}
+	if !tableExists {
+		return nil
+	}
+	return ensureUniqueSegmentNames(ctx, conn)
+}
+
+func ensureActivitySummaryColumns(ctx context.Context, conn DB) error {
 	queries := []string{
 		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS gear_name TEXT",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'instance'))",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'strava'",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS notes TEXT",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS description TEXT",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS name_edited_at TIMESTAMPTZ",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS grades_derived BOOLEAN NOT NULL DEFAULT FALSE",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS gps_spikes INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS gps_spikes_healed INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE IF EXISTS activity_summaries ADD COLUMN IF NOT EXISTS new_distance_m DOUBLE PRECISION",
+		createImportedActivityIDSequenceSQL,
 	}
 	for _, query := range queries {
 		if _, err := conn.Exec(ctx, query); err != nil {
@@ -1080,7 +2105,76 @@ func ensureActivitySummaryColumns(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func ensureMobileAppSessionColumns(ctx context.Context, conn *pgx.Conn) error {
+func ensureAthleteSettingsColumns(ctx context.Context, conn DB) error {
+	if _, err := conn.Exec(ctx, "ALTER TABLE IF EXISTS athlete_settings ADD COLUMN IF NOT EXISTS display_timezone TEXT"); err != nil {
+		return fmt.Errorf("failed to ensure athlete_settings compatibility columns: %w", err)
+	}
+	return nil
+}
+
+func ensureSegmentActivityMatchColumns(ctx context.Context, conn DB) error {
+	queries := []string{
+		"ALTER TABLE IF EXISTS segment_activity_matches ADD COLUMN IF NOT EXISTS grade_adjusted_speed DOUBLE PRECISION",
+		"ALTER TABLE IF EXISTS segment_activity_matches ADD COLUMN IF NOT EXISTS grade_adjusted BOOLEAN",
+		"ALTER TABLE IF EXISTS segment_activity_matches ADD COLUMN IF NOT EXISTS effort_number INTEGER NOT NULL DEFAULT 1",
+		"ALTER TABLE IF EXISTS segment_activity_matches ADD COLUMN IF NOT EXISTS effort_count INTEGER",
+		// Rows cached before overlap_percentage was the projected coverage keep the buffered
+		// value; they are flagged and recomputed when their segment is next read
+		"ALTER TABLE IF EXISTS segment_activity_matches ADD COLUMN IF NOT EXISTS overlap_method TEXT NOT NULL DEFAULT 'buffered' CHECK (overlap_method IN ('buffered', 'projected'))",
+		// One row per traversal: widen the old (segment, activity, tolerance) key once
+		`DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1
+				FROM pg_index i
+				JOIN pg_class c ON c.oid = i.indrelid
+				WHERE c.relname = 'segment_activity_matches'
+				  AND i.indisprimary
+				  AND NOT EXISTS (
+					SELECT 1 FROM pg_attribute a
+					WHERE a.attrelid = c.oid AND a.attname = 'effort_number' AND a.attnum = ANY(i.indkey)
+				  )
+			) THEN
+				ALTER TABLE segment_activity_matches DROP CONSTRAINT segment_activity_matches_pkey;
+				ALTER TABLE segment_activity_matches ADD PRIMARY KEY (segment_id, activity_id, tolerance_meters, effort_number);
+			END IF;
+		END $$`,
+		// Efforts cached before traversals had a direction never include reverse rides,
+		// so the cache is dropped once when the column is added
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'segment_activity_matches' AND column_name = 'direction'
+			) THEN
+				ALTER TABLE segment_activity_matches ADD COLUMN direction TEXT NOT NULL DEFAULT 'forward' CHECK (direction IN ('forward', 'reverse'));
+				DELETE FROM segment_activity_matches;
+			END IF;
+		END $$`,
+		// Efforts used to cache the mean of the speed samples as avg_speed; distance and
+		// elapsed time cover the same points, so the speed is recomputed in place once
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'segment_activity_matches' AND column_name = 'avg_speed_basis'
+			) THEN
+				UPDATE segment_activity_matches
+				SET avg_speed = COALESCE(distance_m / NULLIF(elapsed_seconds, 0), 0.0)
+				WHERE avg_speed IS NOT NULL;
+				ALTER TABLE segment_activity_matches ADD COLUMN avg_speed_basis TEXT NOT NULL DEFAULT 'elapsed';
+			END IF;
+		END $$`,
+	}
+	for _, query := range queries {
+		if _, err := conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to ensure segment_activity_matches compatibility columns: %w", err)
+		}
+	}
+	return nil
+}
+
+func ensureMobileAppSessionColumns(ctx context.Context, conn DB) error {
 	exists, err := tableExists(ctx, conn, "mobile_app_sessions")
 	if err != nil {
 		return fmt.Errorf("failed to check mobile_app_sessions table: %w", err)
@@ -1101,7 +2195,7 @@ func ensureMobileAppSessionColumns(ctx context.Context, conn *pgx.Conn) error {
 	return nil
 }
 
-func tableExists(ctx context.Context, conn *pgx.Conn, tableName string) (bool, error) {
+func tableExists(ctx context.Context, conn DB, tableName string) (bool, error) {
 	var exists bool
 	query := `
 		SELECT EXISTS (
@@ -1117,7 +2211,7 @@ func tableExists(ctx context.Context, conn *pgx.Conn, tableName string) (bool, e
 }
 
 // migratePointSamplesTable adds optional stream columns to point_samples if they don't exist.
-func migratePointSamplesTable(ctx context.Context, conn *pgx.Conn) error {
+func migratePointSamplesTable(ctx context.Context, conn DB) error {
 	columns := []struct {
 		name       string
 		definition string
@@ -1138,13 +2232,12 @@ func migratePointSamplesTable(ctx context.Context, conn *pgx.Conn) error {
 		}
 
 		if count == 0 {
-			log.Printf("📝 Adding %s column to point_samples table...", column.name)
 			alterQuery := fmt.Sprintf(`ALTER TABLE point_samples ADD COLUMN %s %s`, column.name, column.definition)
 			_, err := conn.Exec(ctx, alterQuery)
 			if err != nil {
 				return fmt.Errorf("failed to add %s column: %w", column.name, err)
 			}
-			log.Printf("✅ Added %s column to point_samples table", column.name)
+			logger().Info("Added column", "table", "point_samples", "column", column.name)
 		}
 	}
 
@@ -1152,7 +2245,7 @@ func migratePointSamplesTable(ctx context.Context, conn *pgx.Conn) error {
 }
 
 // ValidateTableSchema validates a table against expected schema
-func ValidateTableSchema(ctx context.Context, conn *pgx.Conn, expected TableSchema) (TableValidationResult, error) {
+func ValidateTableSchema(ctx context.Context, conn DB, expected TableSchema) (TableValidationResult, error) {
 	result := TableValidationResult{
 		TableName:   expected.Name,
 		Exists:      false,
@@ -1212,35 +2305,6 @@ func ValidateTableSchema(ctx context.Context, conn *pgx.Conn, expected TableSche
 		actualColumns[col.Name] = col
 	}
 
-	// Check expected columns
-	expectedColumns := make(map[string]ColumnDef)
-	for _, col := range expected.Columns {
-		expectedColumns[col.Name] = col
-	}
-
-	// Compare columns
-	for _, expectedCol := range expected.Columns {
-		actualCol, ok := actualColumns[expectedCol.Name]
-		if !ok {
-			result.Differences = append(result.Differences,
-				fmt.Sprintf("missing column: %s", expectedCol.Name))
-			continue
-		}
-
-		// Normalize type for comparison (PostgreSQL has many type aliases)
-		expectedType := normalizeType(expectedCol.Type)
-		actualType := normalizeType(actualCol.Type)
-		if expectedType != actualType {
-			result.Differences = append(result.Differences,
-				fmt.Sprintf("column %s type mismatch: expected %s, got %s", expectedCol.Name, expectedType, actualType))
-		}
-
-		if expectedCol.Nullable != actualCol.Nullable {
-			result.Differences = append(result.Differences,
-				fmt.Sprintf("column %s nullable mismatch: expected %v, got %v", expectedCol.Name, expectedCol.Nullable, actualCol.Nullable))
-		}
-	}
-
 	// Check for extra columns (warn but don't fail)
 	// First, check if columns are generated columns (we should ignore those if they're not in expected schema)
 	generatedColumnsQuery := `
@@ -1262,16 +2326,7 @@ func ValidateTableSchema(ctx context.Context, conn *pgx.Conn, expected TableSche
 		}
 	}
 
-	for colName := range actualColumns {
-		if _, ok := expectedColumns[colName]; !ok {
-			// Don't warn about generated columns that aren't in expected schema
-			// (they're auto-created and may vary)
-			if !generatedCols[colName] {
-				result.Differences = append(result.Differences,
-					fmt.Sprintf("extra column: %s (not in expected schema)", colName))
-			}
-		}
-	}
+	compareColumns(expected, actualColumns, generatedCols, &result)
 
 	// Check indexes (simplified - just check if they exist)
 	for _, indexName := range expected.Indexes {
@@ -1284,12 +2339,13 @@ func ValidateTableSchema(ctx context.Context, conn *pgx.Conn, expected TableSche
 		var indexExists bool
 		if err := conn.QueryRow(ctx, indexQuery, indexName).Scan(&indexExists); err != nil {
 			// Log but don't fail
-			log.Printf("⚠️ Could not check index %s: %v", indexName, err)
+			logger().Warn("Could not check index", "index", indexName, "error", err)
 			continue
 		}
 		if !indexExists {
 			result.Differences = append(result.Differences,
 				fmt.Sprintf("missing index: %s", indexName))
+			result.MissingIndexes = append(result.MissingIndexes, indexName)
 		}
 	}
 
@@ -1297,6 +2353,64 @@ func ValidateTableSchema(ctx context.Context, conn *pgx.Conn, expected TableSche
 	return result, nil
 }
 
+// compareColumns records how the actual columns differ from the expected ones, sorting
+// each difference into the columns that can be added in place and the incompatible
+// differences. Extra columns only get in the way when they are NOT NULL without a
+// default, since inserts that do not know them would fail. Generated columns that are
+// not in the expected schema are ignored, as they are auto-created and may vary.
+func compareColumns(expected TableSchema, actualColumns map[string]ColumnDef, generatedCols map[string]bool, result *TableValidationResult) {
+	expectedColumns := make(map[string]ColumnDef)
+	for _, col := range expected.Columns {
+		expectedColumns[col.Name] = col
+	}
+
+	for _, expectedCol := range expected.Columns {
+		actualCol, ok := actualColumns[expectedCol.Name]
+		if !ok {
+			result.Differences = append(result.Differences,
+				fmt.Sprintf("missing column: %s", expectedCol.Name))
+			if _, ok := addColumnSQL(expected.Name, expectedCol); ok {
+				result.MissingColumns = append(result.MissingColumns, expectedCol)
+			} else {
+				result.Incompatible = append(result.Incompatible,
+					fmt.Sprintf("missing column %s cannot be added to existing rows", expectedCol.Name))
+			}
+			continue
+		}
+
+		// Normalize type for comparison (PostgreSQL has many type aliases)
+		expectedType := normalizeType(expectedCol.Type)
+		actualType := normalizeType(actualCol.Type)
+		if expectedType != actualType {
+			diff := fmt.Sprintf("column %s type mismatch: expected %s, got %s", expectedCol.Name, expectedType, actualType)
+			result.Differences = append(result.Differences, diff)
+			result.Incompatible = append(result.Incompatible, diff)
+		}
+
+		if expectedCol.Nullable != actualCol.Nullable {
+			diff := fmt.Sprintf("column %s nullable mismatch: expected %v, got %v", expectedCol.Name, expectedCol.Nullable, actualCol.Nullable)
+			result.Differences = append(result.Differences, diff)
+			result.Incompatible = append(result.Incompatible, diff)
+		}
+	}
+
+	extra := make([]string, 0)
+	for colName := range actualColumns {
+		if _, ok := expectedColumns[colName]; !ok && !generatedCols[colName] {
+			extra = append(extra, colName)
+		}
+	}
+	sort.Strings(extra)
+	for _, colName := range extra {
+		result.Differences = append(result.Differences,
+			fmt.Sprintf("extra column: %s (not in expected schema)", colName))
+		if col := actualColumns[colName]; !col.Nullable && col.DefaultValue == nil {
+			result.Incompatible = append(result.Incompatible,
+				fmt.Sprintf("extra column %s is NOT NULL without a default", colName))
+		}
+	}
+}
+
 // normalizeType normalizes PostgreSQL type names for comparison
 func normalizeType(typ string) string {
 	typ = strings.ToLower(typ)
@@ -1365,8 +2479,18 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "max_heartrate", Type: "double precision", Nullable: true},
 				{Name: "max_watts", Type: "double precision", Nullable: true},
 				{Name: "suffer_score", Type: "double precision", Nullable: true},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "visibility", Type: "text", Nullable: false, DefaultValue: columnDefault("'private'")},
+				{Name: "pinned", Type: "boolean", Nullable: false, DefaultValue: columnDefault("FALSE")},
+				{Name: "notes", Type: "text", Nullable: true},
+				{Name: "description", Type: "text", Nullable: true},
+				{Name: "name_edited_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "source", Type: "text", Nullable: false, DefaultValue: columnDefault("'strava'")},
+				{Name: "grades_derived", Type: "boolean", Nullable: false, DefaultValue: columnDefault("FALSE")},
+				{Name: "gps_spikes", Type: "integer", Nullable: false, DefaultValue: columnDefault("0")},
+				{Name: "gps_spikes_healed", Type: "integer", Nullable: false, DefaultValue: columnDefault("0")},
+				{Name: "new_distance_m", Type: "double precision", Nullable: true},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_activity_summaries_athlete_id",
@@ -1386,8 +2510,8 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "route_geog", Type: "geography", Nullable: false},
 				{Name: "route_bbox_geom", Type: "geometry", Nullable: true}, // Generated column
 				{Name: "route_geog_simplified", Type: "geography", Nullable: true},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_activity_geometries_athlete_id",
@@ -1416,7 +2540,7 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "moving", Type: "boolean", Nullable: true},
 				{Name: "temperature", Type: "integer", Nullable: true},
 				{Name: "cumulative_distance", Type: "double precision", Nullable: true},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_point_samples_athlete_id",
@@ -1442,15 +2566,18 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "elevation_gain_m", Type: "double precision", Nullable: true},
 				{Name: "elevation_loss_m", Type: "double precision", Nullable: true},
 				{Name: "net_elevation_m", Type: "double precision", Nullable: true},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "default_tolerance_m", Type: "double precision", Nullable: true},
+				{Name: "pinned", Type: "boolean", Nullable: false, DefaultValue: columnDefault("FALSE")},
+				{Name: "source", Type: "text", Nullable: false, DefaultValue: columnDefault("'strava'")},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_favorite_segments_athlete_id",
 				"idx_favorite_segments_segment_geog",
 				"idx_favorite_segments_bbox",
 				"idx_favorite_segments_segment_geog_simplified",
-				"idx_favorite_segments_athlete_name",
+				"idx_favorite_segments_athlete_name_unique",
 				"idx_favorite_segments_created_at",
 			},
 		},
@@ -1466,10 +2593,10 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "strava_access_token", Type: "text", Nullable: false},
 				{Name: "strava_refresh_token", Type: "text", Nullable: false},
 				{Name: "strava_expires_at", Type: "timestamp with time zone", Nullable: false},
-				{Name: "session_expires_at", Type: "timestamp with time zone", Nullable: false},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "last_seen_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "session_expires_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("(NOW() + INTERVAL '90 days')")},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "last_seen_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_mobile_app_sessions_athlete_id",
@@ -1484,6 +2611,9 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "segment_id", Type: "bigint", Nullable: false},
 				{Name: "activity_id", Type: "bigint", Nullable: false},
 				{Name: "tolerance_meters", Type: "double precision", Nullable: false},
+				{Name: "effort_number", Type: "integer", Nullable: false, DefaultValue: columnDefault("1")},
+				{Name: "effort_count", Type: "integer", Nullable: true},
+				{Name: "direction", Type: "text", Nullable: false, DefaultValue: columnDefault("'forward'")},
 				{Name: "min_distance_m", Type: "double precision", Nullable: false},
 				{Name: "overlap_length_m", Type: "double precision", Nullable: false},
 				{Name: "overlap_percentage", Type: "double precision", Nullable: false},
@@ -1491,11 +2621,15 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "end_index", Type: "integer", Nullable: true},
 				{Name: "avg_hr", Type: "double precision", Nullable: true},
 				{Name: "avg_speed", Type: "double precision", Nullable: true},
+				{Name: "avg_speed_basis", Type: "text", Nullable: false, DefaultValue: columnDefault("'elapsed'")},
 				{Name: "distance_m", Type: "double precision", Nullable: true},
 				{Name: "elevation_gain_m", Type: "double precision", Nullable: true},
 				{Name: "elapsed_seconds", Type: "double precision", Nullable: true},
-				{Name: "direction_checked", Type: "boolean", Nullable: false},
-				{Name: "cached_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "grade_adjusted_speed", Type: "double precision", Nullable: true},
+				{Name: "grade_adjusted", Type: "boolean", Nullable: true},
+				{Name: "direction_checked", Type: "boolean", Nullable: false, DefaultValue: columnDefault("TRUE")},
+				{Name: "overlap_method", Type: "text", Nullable: false, DefaultValue: columnDefault("'buffered'")},
+				{Name: "cached_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_segment_activity_matches_segment_tolerance",
@@ -1503,6 +2637,28 @@ func GetExpectedTableSchemas() []TableSchema {
 				"idx_segment_activity_matches_cached_at",
 			},
 		},
+		{
+			Name:    "segment_match_cache_state",
+			IsCache: true,
+			Columns: []ColumnDef{
+				{Name: "segment_id", Type: "bigint", Nullable: false},
+				{Name: "tolerance_meters", Type: "double precision", Nullable: false},
+				{Name: "last_full_scan_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+				{Name: "refreshed_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+		},
+		{
+			Name:    "similar_activities_cache",
+			IsCache: true,
+			Columns: []ColumnDef{
+				{Name: "activity_id", Type: "bigint", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "tolerance_meters", Type: "double precision", Nullable: false},
+				{Name: "similar_activity_ids", Type: "ARRAY", Nullable: false},
+				{Name: "coverages", Type: "ARRAY", Nullable: false},
+				{Name: "computed_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+		},
 		{
 			Name:    "discovered_activity_buffers",
 			IsCache: true,
@@ -1514,8 +2670,8 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "route_geog", Type: "geography", Nullable: false},
 				{Name: "buffer_geog", Type: "geography", Nullable: false},
 				{Name: "buffer_bbox_geom", Type: "geometry", Nullable: true},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_discovered_activity_buffers_athlete_id",
@@ -1531,13 +2687,13 @@ func GetExpectedTableSchemas() []TableSchema {
 				{Name: "athlete_id", Type: "bigint", Nullable: false},
 				{Name: "sample_distance_m", Type: "double precision", Nullable: false},
 				{Name: "radius_m", Type: "double precision", Nullable: false},
-				{Name: "activity_count", Type: "integer", Nullable: false},
+				{Name: "activity_count", Type: "integer", Nullable: false, DefaultValue: columnDefault("0")},
 				{Name: "coverage_geog", Type: "geography", Nullable: true},
 				{Name: "coverage_bbox_geom", Type: "geometry", Nullable: true},
-				{Name: "stale", Type: "boolean", Nullable: false},
+				{Name: "stale", Type: "boolean", Nullable: false, DefaultValue: columnDefault("TRUE")},
 				{Name: "rebuilt_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "created_at", Type: "timestamp with time zone", Nullable: true},
-				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
 			},
 			Indexes: []string{
 				"idx_discovered_coverage_cache_coverage_geog",
@@ -1546,11 +2702,275 @@ func GetExpectedTableSchemas() []TableSchema {
 				"idx_discovered_coverage_cache_stale",
 			},
 		},
+		{
+			Name:    "explored_area",
+			IsCache: true,
+			Columns: []ColumnDef{
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "buffer_m", Type: "double precision", Nullable: false},
+				{Name: "area_geom", Type: "geometry", Nullable: true},
+				{Name: "through_start_date", Type: "timestamp with time zone", Nullable: false},
+				{Name: "through_activity_id", Type: "bigint", Nullable: false},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+		},
+		{
+			Name:    "account_deletion_requests",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "requested_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+				{Name: "execute_after", Type: "timestamp with time zone", Nullable: false},
+				{Name: "cancelled_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "completed_at", Type: "timestamp with time zone", Nullable: true},
+			},
+			Indexes: []string{
+				"idx_account_deletion_requests_execute_after",
+			},
+		},
+		{
+			Name:    "account_events",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "event", Type: "text", Nullable: false},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_account_events_athlete_id",
+			},
+		},
+		{
+			Name:    "athlete_settings",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "default_tolerance_m", Type: "double precision", Nullable: true},
+				{Name: "display_timezone", Type: "text", Nullable: true},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{},
+		},
+		{
+			Name:    "athlete_tokens",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "token_key", Type: "text", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "access_token", Type: "text", Nullable: false},
+				{Name: "refresh_token", Type: "text", Nullable: false},
+				{Name: "expires_at", Type: "timestamp with time zone", Nullable: false},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_athlete_tokens_athlete_id",
+			},
+		},
+		{
+			Name:    "skipped_activities",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "activity_id", Type: "bigint", Nullable: false},
+				{Name: "reason", Type: "text", Nullable: false},
+				{Name: "first_seen", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{},
+		},
+		{
+			Name:    "athlete_profiles",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "firstname", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "lastname", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "profile", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "hr_zones", Type: "jsonb", Nullable: true},
+				{Name: "prefetched_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{},
+		},
+		{
+			Name:    "public_stats_tokens",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "token_key", Type: "text", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "fields", Type: "ARRAY", Nullable: false},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+				{Name: "expires_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "max_views", Type: "bigint", Nullable: true},
+				{Name: "views", Type: "bigint", Nullable: false, DefaultValue: columnDefault("0")},
+			},
+			Indexes: []string{
+				"idx_public_stats_tokens_athlete_id",
+				"idx_public_stats_tokens_expires_at",
+			},
+		},
+		{
+			Name:    "share_views",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "token_id", Type: "bigint", Nullable: false},
+				{Name: "viewed_at", Type: "timestamp with time zone", Nullable: false},
+				{Name: "user_agent", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "country", Type: "text", Nullable: true},
+				{Name: "bot", Type: "boolean", Nullable: false, DefaultValue: columnDefault("FALSE")},
+			},
+			Indexes: []string{
+				"idx_share_views_token_id",
+				"idx_share_views_viewed_at",
+			},
+		},
+		{
+			Name:    "web_sessions",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "token_key", Type: "text", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "user_agent", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+				{Name: "last_used_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_web_sessions_athlete_id",
+			},
+		},
+		{
+			Name:    "athletes",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "firstname", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "lastname", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "profile_url", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "updated_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+		},
+		{
+			Name:    "outbound_webhook_queue",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "endpoint_url", Type: "text", Nullable: false},
+				{Name: "event_id", Type: "text", Nullable: false},
+				{Name: "event_type", Type: "text", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false},
+				{Name: "data", Type: "text", Nullable: false},
+				{Name: "saved_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_outbound_webhook_queue_endpoint",
+			},
+		},
+		{
+			Name:    "announcements",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "bigint", Nullable: false},
+				{Name: "message", Type: "text", Nullable: false},
+				{Name: "level", Type: "text", Nullable: false, DefaultValue: columnDefault("'info'")},
+				{Name: "starts_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+				{Name: "ends_at", Type: "timestamp with time zone", Nullable: true},
+				{Name: "created_by", Type: "bigint", Nullable: false},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+		},
+		{
+			Name:    "announcement_dismissals",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "announcement_id", Type: "bigint", Nullable: false},
+				{Name: "token_key", Type: "text", Nullable: false},
+				{Name: "dismissed_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_announcement_dismissals_token_key",
+			},
+		},
+		{
+			Name:    "import_files",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "hash", Type: "text", Nullable: false},
+				{Name: "activity_id", Type: "bigint", Nullable: false},
+				{Name: "filename", Type: "text", Nullable: false},
+				{Name: "imported_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_import_files_filename",
+			},
+		},
+		{
+			Name:    "gear",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "id", Type: "text", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "name", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "brand", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "model", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "retired", Type: "boolean", Nullable: false, DefaultValue: columnDefault("FALSE")},
+				{Name: "kind", Type: "text", Nullable: false, DefaultValue: columnDefault("''")},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+				{Name: "fetched_at", Type: "timestamp with time zone", Nullable: true},
+			},
+			Indexes: []string{
+				"idx_gear_athlete_id",
+			},
+		},
+		{
+			Name:    "activity_tags",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "activity_id", Type: "bigint", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "tag", Type: "text", Nullable: false},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: false, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_activity_tags_athlete_tag",
+			},
+		},
+		{
+			Name:    "activity_streams",
+			IsCache: false,
+			Columns: []ColumnDef{
+				{Name: "activity_id", Type: "bigint", Nullable: false},
+				{Name: "athlete_id", Type: "bigint", Nullable: false},
+				{Name: "point_count", Type: "integer", Nullable: false},
+				{Name: "ids", Type: "ARRAY", Nullable: false},
+				{Name: "point_indexes", Type: "ARRAY", Nullable: false},
+				{Name: "times", Type: "ARRAY", Nullable: false},
+				{Name: "lats", Type: "ARRAY", Nullable: false},
+				{Name: "lngs", Type: "ARRAY", Nullable: false},
+				{Name: "altitudes", Type: "ARRAY", Nullable: true},
+				{Name: "heartrates", Type: "ARRAY", Nullable: true},
+				{Name: "speeds", Type: "ARRAY", Nullable: true},
+				{Name: "watts", Type: "ARRAY", Nullable: true},
+				{Name: "cadences", Type: "ARRAY", Nullable: true},
+				{Name: "grades", Type: "ARRAY", Nullable: true},
+				{Name: "moving", Type: "ARRAY", Nullable: true},
+				{Name: "temperatures", Type: "ARRAY", Nullable: true},
+				{Name: "cumulative_distances", Type: "ARRAY", Nullable: true},
+				{Name: "created_at", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")},
+			},
+			Indexes: []string{
+				"idx_activity_streams_athlete_id",
+			},
+		},
 	}
 }
 
 // createTableBySchema creates a table based on the schema definition
-func createTableBySchema(ctx context.Context, conn *pgx.Conn, schema TableSchema) error {
+func createTableBySchema(ctx context.Context, conn DB, schema TableSchema) error {
 	// This is a simplified version - for full implementation, we'd need to handle
 	// all the CREATE TABLE logic. For now, we'll call the existing create functions
 	switch schema.Name {
@@ -1566,10 +2986,48 @@ func createTableBySchema(ctx context.Context, conn *pgx.Conn, schema TableSchema
 		return createMobileAppSessionsTable(ctx, conn)
 	case "segment_activity_matches":
 		return createSegmentActivityMatchesTable(ctx, conn)
+	case "segment_match_cache_state":
+		return createSegmentMatchCacheStateTable(ctx, conn)
+	case "similar_activities_cache":
+		return createSimilarActivitiesCacheTable(ctx, conn)
 	case "discovered_activity_buffers":
 		return createDiscoveredActivityBuffersTable(ctx, conn)
 	case "discovered_coverage_cache":
 		return createDiscoveredCoverageCacheTable(ctx, conn)
+	case "explored_area":
+		return createExploredAreaTable(ctx, conn)
+	case "account_deletion_requests":
+		return createAccountDeletionRequestsTable(ctx, conn)
+	case "account_events":
+		return createAccountEventsTable(ctx, conn)
+	case "athlete_settings":
+		return createAthleteSettingsTable(ctx, conn)
+	case "athlete_tokens":
+		return createAthleteTokensTable(ctx, conn)
+	case "skipped_activities":
+		return createSkippedActivitiesTable(ctx, conn)
+	case "athlete_profiles":
+		return createAthleteProfilesTable(ctx, conn)
+	case "athletes":
+		return createAthletesTable(ctx, conn)
+	case "public_stats_tokens":
+		return createPublicStatsTokensTable(ctx, conn)
+	case "share_views":
+		return createShareViewsTable(ctx, conn)
+	case "web_sessions":
+		return createWebSessionsTable(ctx, conn)
+	case "outbound_webhook_queue":
+		return createOutboundWebhookQueueTable(ctx, conn)
+	case "announcements", "announcement_dismissals":
+		return createAnnouncementsTables(ctx, conn)
+	case "import_files":
+		return createImportFilesTable(ctx, conn)
+	case "gear":
+		return createGearTable(ctx, conn)
+	case "activity_tags":
+		return createActivityTagsTable(ctx, conn)
+	case "activity_streams":
+		return createActivityStreamsTable(ctx, conn)
 	default:
 		return fmt.Errorf("unknown table schema: %s", schema.Name)
 	}
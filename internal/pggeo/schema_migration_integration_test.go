@@ -0,0 +1,82 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+)
+
+// migrationFixture is a data table whose expected schema grows a column and an index
+// after rows were written
+func migrationFixture(extra ...ColumnDef) TableSchema {
+	return TableSchema{
+		Name: "schema_migration_fixture",
+		Columns: append([]ColumnDef{
+			{Name: "id", Type: "bigint"},
+			{Name: "name", Type: "text"},
+		}, extra...),
+	}
+}
+
+func createMigrationFixture(ctx context.Context, conn DB, schema TableSchema) error {
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migration_fixture (id BIGINT NOT NULL, name TEXT NOT NULL)`); err != nil {
+		return err
+	}
+	for _, index := range schema.Indexes {
+		if _, err := conn.Exec(ctx, `CREATE INDEX IF NOT EXISTS `+index+` ON schema_migration_fixture (name)`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestMigrateTableAddsColumnsWithoutLosingRows(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DROP TABLE IF EXISTS schema_migration_fixture`)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	result, err := migrateTable(ctx, conn, migrationFixture(), false, createMigrationFixture)
+	if err != nil || result.ActionTaken != "created" {
+		t.Fatalf("first migration = %+v, %v; want created", result, err)
+	}
+	if _, err := conn.Exec(ctx, `INSERT INTO schema_migration_fixture (id, name) VALUES (1, 'kept')`); err != nil {
+		t.Fatal(err)
+	}
+
+	grown := migrationFixture(
+		ColumnDef{Name: "note", Type: "text", Nullable: true},
+		ColumnDef{Name: "score", Type: "integer", DefaultValue: columnDefault("7")},
+	)
+	grown.Indexes = []string{"idx_schema_migration_fixture_name"}
+	result, err = migrateTable(ctx, conn, grown, false, createMigrationFixture)
+	if err != nil || result.ActionTaken != "altered" {
+		t.Fatalf("grown migration = %+v, %v; want altered", result, err)
+	}
+	var name string
+	var score int
+	if err := conn.QueryRow(ctx, `SELECT name, score FROM schema_migration_fixture WHERE id = 1`).Scan(&name, &score); err != nil || name != "kept" || score != 7 {
+		t.Fatalf("row after migration = %q/%d, %v; want the row kept with the default", name, score, err)
+	}
+	if result, err := ValidateTableSchema(ctx, conn, grown); err != nil || !result.Matches {
+		t.Fatalf("after altering, validation = %+v, %v; want a match", result, err)
+	}
+
+	// A type change still needs -force-rebuild and leaves the data alone without it
+	changed := migrationFixture(ColumnDef{Name: "note", Type: "text", Nullable: true}, ColumnDef{Name: "score", Type: "text", DefaultValue: columnDefault("'7'")})
+	result, err = migrateTable(ctx, conn, changed, false, createMigrationFixture)
+	if err != nil || result.ActionTaken != "warning" || len(result.Incompatible) != 1 {
+		t.Fatalf("type change = %+v, %v; want a warning", result, err)
+	}
+	var rows int
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM schema_migration_fixture`).Scan(&rows); err != nil || rows != 1 {
+		t.Fatalf("rows after the warning = %d, %v; want 1", rows, err)
+	}
+	result, err = migrateTable(ctx, conn, changed, true, createMigrationFixture)
+	if err != nil || result.ActionTaken != "recreated" {
+		t.Fatalf("forced type change = %+v, %v; want recreated", result, err)
+	}
+}
@@ -0,0 +1,83 @@
+package pggeo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddColumnSQL(t *testing.T) {
+	for _, tt := range []struct {
+		col  ColumnDef
+		want string
+	}{
+		{ColumnDef{Name: "note", Type: "text", Nullable: true}, "ALTER TABLE t ADD COLUMN IF NOT EXISTS note text"},
+		{ColumnDef{Name: "score", Type: "integer", DefaultValue: columnDefault("0")}, "ALTER TABLE t ADD COLUMN IF NOT EXISTS score integer DEFAULT 0 NOT NULL"},
+		{ColumnDef{Name: "seen", Type: "timestamp with time zone", Nullable: true, DefaultValue: columnDefault("NOW()")}, "ALTER TABLE t ADD COLUMN IF NOT EXISTS seen timestamp with time zone DEFAULT NOW()"},
+		{ColumnDef{Name: "id", Type: "bigint"}, ""},
+		{ColumnDef{Name: "fields", Type: "ARRAY", Nullable: true}, ""},
+	} {
+		got, ok := addColumnSQL("t", tt.col)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Fatalf("addColumnSQL(%s) = %q, %v; want %q", tt.col.Name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestCompareColumnsSortsAdditiveFromIncompatible(t *testing.T) {
+	expected := TableSchema{Name: "t", Columns: []ColumnDef{
+		{Name: "id", Type: "bigint"},
+		{Name: "kind", Type: "text"},
+		{Name: "note", Type: "text", Nullable: true},
+		{Name: "score", Type: "integer", DefaultValue: columnDefault("0")},
+		{Name: "owner", Type: "bigint"},
+	}}
+	actual := map[string]ColumnDef{
+		"id":     {Name: "id", Type: "int8"},
+		"kind":   {Name: "kind", Type: "integer"},
+		"legacy": {Name: "legacy", Type: "text", Nullable: true},
+		"strict": {Name: "strict", Type: "text"},
+		"search": {Name: "search", Type: "tsvector"},
+	}
+	var result TableValidationResult
+	compareColumns(expected, actual, map[string]bool{"search": true}, &result)
+
+	var added []string
+	for _, col := range result.MissingColumns {
+		added = append(added, col.Name)
+	}
+	if strings.Join(added, ",") != "note,score" {
+		t.Fatalf("missing columns = %v, want note and score", added)
+	}
+	incompatible := strings.Join(result.Incompatible, "\n")
+	for _, want := range []string{"kind type mismatch", "missing column owner", "extra column strict"} {
+		if !strings.Contains(incompatible, want) {
+			t.Fatalf("incompatible = %q, want it to mention %q", incompatible, want)
+		}
+	}
+	if len(result.Incompatible) != 3 || len(result.Differences) != 6 {
+		t.Fatalf("result = %+v; want 3 incompatible of 6 differences", result)
+	}
+}
+
+// Adding any defaulted or nullable column of the expected schemas to an existing table
+// must be an ALTER TABLE, never a rebuild
+func TestExpectedColumnsWithDefaultsAreAdditive(t *testing.T) {
+	for _, schema := range GetExpectedTableSchemas() {
+		for i, col := range schema.Columns {
+			if !col.Nullable && col.DefaultValue == nil || col.Type == "ARRAY" {
+				continue
+			}
+			actual := make(map[string]ColumnDef)
+			for j, other := range schema.Columns {
+				if j != i {
+					actual[other.Name] = other
+				}
+			}
+			var result TableValidationResult
+			compareColumns(schema, actual, nil, &result)
+			if len(result.Incompatible) != 0 || len(result.MissingColumns) != 1 {
+				t.Fatalf("%s without %s: %+v; want one column to add", schema.Name, col.Name, result)
+			}
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/strava"
+)
+
+// Row sources recorded in activity_summaries.source and favorite_segments.source
+const (
+	SourceStrava = "strava"
+	SourceSeed   = "seed"
+	SourceImport = "import"
+)
+
+// Seeded IDs sit far above real Strava IDs but below 2^53 so they survive JSON in the browser
+const (
+	SeedAthleteIDBase  int64 = 8_000_000_000
+	SeedActivityIDBase int64 = 8_000_000_000_000_000
+	seedActivityStride int64 = 1_000_000
+)
+
+// SeedOptions controls the synthetic data generated by SeedDemoData
+type SeedOptions struct {
+	Athletes             int
+	ActivitiesPerAthlete int
+	SegmentsPerAthlete   int
+	Seed                 int64
+	CenterLat            float64
+	CenterLng            float64
+	FirstAthleteID       int64     // athlete IDs are FirstAthleteID, FirstAthleteID+1, ...
+	StartDate            time.Time // first activity day; later ones follow every one to three days
+}
+
+// DefaultSeedOptions returns two athletes riding around Amsterdam from a fixed date
+func DefaultSeedOptions() SeedOptions {
+	return SeedOptions{
+		Athletes:             2,
+		ActivitiesPerAthlete: 50,
+		SegmentsPerAthlete:   2,
+		Seed:                 1,
+		CenterLat:            52.3676,
+		CenterLng:            4.9041,
+		FirstAthleteID:       SeedAthleteIDBase + 1,
+		StartDate:            time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// SeedResult summarizes what SeedDemoData inserted
+type SeedResult struct {
+	AthleteIDs     []int64
+	Activities     int
+	Segments       int
+	SegmentEfforts int
+}
+
+// seedRoute is a loop of waypoints shared by several activities, so segments carved from
+// one ride match the others
+type seedRoute struct {
+	waypoints [][]float64 // lat, lng
+	hills     [3][2]float64
+}
+
+// SeedDemoData inserts deterministic synthetic rides, favorite segments and segment match
+// caches for local development. Everything goes through the normal insert pipeline and is
+// marked with source 'seed' so WipeSeedData can remove it again. The same options always
+// produce the same data.
+func SeedDemoData(ctx context.Context, conn DB, opts SeedOptions) (*SeedResult, error) {
+	if opts.Athletes <= 0 || opts.ActivitiesPerAthlete < 0 || opts.SegmentsPerAthlete < 0 {
+		return nil, invalidInputf("invalid seed options: need at least one athlete and non-negative counts")
+	}
+	if int64(opts.ActivitiesPerAthlete) >= seedActivityStride {
+		return nil, invalidInputf("at most %d activities per athlete", seedActivityStride-1)
+	}
+	rng := rand.New(rand.NewSource(opts.Seed)) // #nosec G404 -- demo data must be reproducible, not secret.
+	result := &SeedResult{}
+
+	// A handful of loops per city; athletes share them like they would share local roads
+	routes := make([]seedRoute, 4)
+	for i := range routes {
+		routes[i] = newSeedRoute(rng, opts.CenterLat, opts.CenterLng)
+	}
+
+	for a := 0; a < opts.Athletes; a++ {
+		athleteID := opts.FirstAthleteID + int64(a)
+		result.AthleteIDs = append(result.AthleteIDs, athleteID)
+		profile := newSeedAthleteProfile(rng)
+		day := opts.StartDate
+
+		firstRideOnRoute := make(map[int]*strava.BikeActivity)
+		for i := 0; i < opts.ActivitiesPerAthlete; i++ {
+			day = day.AddDate(0, 0, 1+rng.Intn(3))
+			start := day.Add(time.Duration(6*60+rng.Intn(12*60)) * time.Minute)
+			routeIndex := rng.Intn(len(routes))
+			activityID := SeedActivityIDBase + int64(a)*seedActivityStride + int64(i) + 1
+			activity := generateSeedActivity(rng, routes[routeIndex], profile, athleteID, activityID, start)
+
+			if err := InsertBikeActivity(ctx, conn, activity); err != nil {
+				return result, fmt.Errorf("failed to insert seed activity %d: %w", activityID, err)
+			}
+			if _, err := conn.Exec(ctx, `UPDATE activity_summaries SET source = $2 WHERE id = $1`, activityID, SourceSeed); err != nil {
+				return result, fmt.Errorf("failed to mark seed activity %d: %w", activityID, err)
+			}
+			result.Activities++
+			if _, ok := firstRideOnRoute[routeIndex]; !ok {
+				firstRideOnRoute[routeIndex] = activity
+			}
+		}
+
+		segments := 0
+		for routeIndex := 0; routeIndex < len(routes) && segments < opts.SegmentsPerAthlete; routeIndex++ {
+			activity, ok := firstRideOnRoute[routeIndex]
+			if !ok {
+				continue
+			}
+			segments++
+			efforts, err := seedSegmentFromActivity(ctx, conn, rng, athleteID, activity, segments)
+			if err != nil {
+				return result, err
+			}
+			result.Segments++
+			result.SegmentEfforts += efforts
+		}
+		logger().Info("Seeded athlete", "athlete_id", athleteID)
+	}
+	return result, nil
+}
+
+// WipeSeedData deletes every row SeedDemoData created. Point samples, geometries and match
+// caches follow through their ON DELETE CASCADE foreign keys.
+func WipeSeedData(ctx context.Context, conn DB) (activities int64, segments int64, err error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM discovered_coverage_cache
+		WHERE athlete_id IN (SELECT DISTINCT athlete_id FROM activity_summaries WHERE source = $1)
+	`, SourceSeed); err != nil {
+		return 0, 0, fmt.Errorf("failed to clear seeded coverage: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM favorite_segments WHERE source = $1`, SourceSeed)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete seeded segments: %w", err)
+	}
+	segments = tag.RowsAffected()
+	tag, err = tx.Exec(ctx, `DELETE FROM activity_summaries WHERE source = $1`, SourceSeed)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete seeded activities: %w", err)
+	}
+	activities = tag.RowsAffected()
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit wipe: %w", err)
+	}
+	return activities, segments, nil
+}
+
+func newSeedRoute(rng *rand.Rand, centerLat, centerLng float64) seedRoute {
+	// Loop out from the center through 6-9 waypoints at increasing bearings
+	count := 6 + rng.Intn(4)
+	offset := rng.Float64() * 2 * math.Pi
+	radiusM := 2500 + rng.Float64()*4500
+	route := seedRoute{waypoints: [][]float64{{centerLat, centerLng}}}
+	for i := 0; i < count; i++ {
+		bearing := offset + float64(i)*math.Pi/float64(count)*1.6
+		distance := radiusM * (0.4 + 0.6*rng.Float64())
+		route.waypoints = append(route.waypoints, offsetLatLng(centerLat, centerLng, distance*math.Cos(bearing), distance*math.Sin(bearing)))
+	}
+	route.waypoints = append(route.waypoints, []float64{centerLat, centerLng})
+	for i := range route.hills {
+		route.hills[i] = [2]float64{5 + rng.Float64()*25, 1500 + rng.Float64()*6000} // amplitude m, wavelength m
+	}
+	return route
+}
+
+// offsetLatLng moves a point north and east by the given meters
+func offsetLatLng(lat, lng, northM, eastM float64) []float64 {
+	dLat := northM / 111320.0
+	dLng := eastM / (111320.0 * math.Cos(lat*math.Pi/180))
+	return []float64{lat + dLat, lng + dLng}
+}
+
+type seedAthleteProfile struct {
+	cruiseSpeed float64 // m/s on the flat
+	restHR      float64
+	maxHR       float64
+	cadence     float64
+	massKg      float64
+}
+
+func newSeedAthleteProfile(rng *rand.Rand) seedAthleteProfile {
+	return seedAthleteProfile{
+		cruiseSpeed: 7 + rng.Float64()*2,
+		restHR:      55 + rng.Float64()*10,
+		maxHR:       180 + rng.Float64()*15,
+		cadence:     80 + rng.Float64()*12,
+		massKg:      70 + rng.Float64()*20,
+	}
+}
+
+func (r seedRoute) altitude(distanceM float64) float64 {
+	altitude := 10.0
+	for _, hill := range r.hills {
+		altitude += hill[0] * (1 + math.Sin(2*math.Pi*distanceM/hill[1]))
+	}
+	return altitude
+}
+
+// generateSeedActivity rides the route once at 1 Hz with a few meters of GPS jitter and
+// speed, heart rate, cadence and power driven by the gradient
+func generateSeedActivity(rng *rand.Rand, route seedRoute, profile seedAthleteProfile, athleteID, activityID int64, start time.Time) *strava.BikeActivity {
+	// Perturb the shared waypoints a little so each ride takes a slightly different line
+	waypoints := make([][]float64, len(route.waypoints))
+	for i, wp := range route.waypoints {
+		if i == 0 || i == len(route.waypoints)-1 {
+			waypoints[i] = wp
+			continue
+		}
+		waypoints[i] = offsetLatLng(wp[0], wp[1], rng.NormFloat64()*3, rng.NormFloat64()*3)
+	}
+
+	activity := &strava.BikeActivity{}
+	dayForm := 0.9 + rng.Float64()*0.2
+	var (
+		distance, elevationGain float64
+		hrSum                   float64
+		cadenceSum, wattsSum    float64
+		cadenceCount            int
+		maxSpeed                float64
+		maxHR, maxWatts         int
+		hr                      = profile.restHR + 30
+	)
+	temperature := 8 + rng.Intn(18)
+	prevAltitude := route.altitude(0)
+
+	for leg := 0; leg+1 < len(waypoints); leg++ {
+		from, to := waypoints[leg], waypoints[leg+1]
+		legLength := geo.HaversineMeters(from[0], from[1], to[0], to[1])
+		for along := 0.0; along < legLength; {
+			frac := along / legLength
+			lat := from[0] + (to[0]-from[0])*frac
+			lng := from[1] + (to[1]-from[1])*frac
+			point := offsetLatLng(lat, lng, rng.NormFloat64()*1.5, rng.NormFloat64()*1.5)
+
+			altitude := route.altitude(distance)
+			grade := 0.0
+			if len(activity.AltitudeStream.Data) > 0 {
+				grade = (altitude - prevAltitude) / math.Max(activity.SpeedStream.Data[len(activity.SpeedStream.Data)-1], 1) * 100
+			}
+			prevAltitude = altitude
+
+			speed := profile.cruiseSpeed*dayForm*(1-grade*0.06) + rng.NormFloat64()*0.3
+			speed = math.Max(2.5, math.Min(speed, 16))
+			effort := math.Max(0, math.Min(1, 0.55+grade*0.06+rng.NormFloat64()*0.03))
+			targetHR := profile.restHR + (profile.maxHR-profile.restHR)*effort
+			hr += (targetHR - hr) * 0.05 // heart rate lags effort by ~20 s
+
+			cadence, watts := 0, 0
+			if grade > -3 {
+				cadence = int(profile.cadence + rng.NormFloat64()*4)
+				// Rolling resistance, aero drag and climbing
+				power := profile.massKg*9.81*speed*(0.005+grade/100) + 0.5*1.2*0.35*speed*speed*speed
+				watts = int(math.Max(0, power+rng.NormFloat64()*15))
+			}
+
+			activity.TimeStream.Data = append(activity.TimeStream.Data, start.Add(time.Duration(len(activity.TimeStream.Data))*time.Second))
+			activity.LatLngStream.Data = append(activity.LatLngStream.Data, point)
+			activity.AltitudeStream.Data = append(activity.AltitudeStream.Data, math.Round(altitude*10)/10)
+			activity.SpeedStream.Data = append(activity.SpeedStream.Data, speed)
+			activity.GradeStream.Data = append(activity.GradeStream.Data, math.Round(grade*10)/10)
+			activity.HeartrateStream.Data = append(activity.HeartrateStream.Data, int(hr))
+			activity.CadenceStream.Data = append(activity.CadenceStream.Data, cadence)
+			activity.WattsStream.Data = append(activity.WattsStream.Data, watts)
+			activity.MovingStream.Data = append(activity.MovingStream.Data, true)
+			activity.TemperatureStream.Data = append(activity.TemperatureStream.Data, temperature)
+			activity.DistanceStream.Data = append(activity.DistanceStream.Data, distance)
+
+			if n := len(activity.AltitudeStream.Data); n > 1 && altitude > activity.AltitudeStream.Data[n-2] {
+				elevationGain += altitude - activity.AltitudeStream.Data[n-2]
+			}
+			hrSum += hr
+			wattsSum += float64(watts)
+			if cadence > 0 {
+				cadenceSum += float64(cadence)
+				cadenceCount++
+			}
+			maxSpeed = math.Max(maxSpeed, speed)
+			maxHR = max(maxHR, int(hr))
+			maxWatts = max(maxWatts, watts)
+
+			along += speed
+			distance += speed
+		}
+	}
+
+	samples := float64(len(activity.TimeStream.Data))
+	startLatLng := activity.LatLngStream.Data[0]
+	endLatLng := activity.LatLngStream.Data[len(activity.LatLngStream.Data)-1]
+	city := "Demo City"
+	activity.Summary = strava.ActivitySummary{
+		ID:                 activityID,
+		AthleteID:          athleteID,
+		Name:               seedActivityName(rng, start),
+		Distance:           distance,
+		MovingTime:         samples,
+		ElapsedTime:        samples,
+		TotalElevationGain: elevationGain,
+		Type:               "Ride",
+		SportType:          "Ride",
+		StartDate:          start.Format(time.RFC3339),
+		StartDateTime:      start,
+		StartLatLng:        &startLatLng,
+		EndLatLng:          &endLatLng,
+		LocationCity:       &city,
+		GearID:             fmt.Sprintf("seed-bike-%d", athleteID),
+		AverageSpeed:       distance / samples, // distance over moving time, as Strava reports it
+		MaxSpeed:           maxSpeed,
+		AverageWatts:       wattsSum / samples,
+		Kilojoules:         wattsSum / 1000,
+		AverageHeartrate:   hrSum / samples,
+		MaxHeartrate:       float64(maxHR),
+		MaxWatts:           float64(maxWatts),
+	}
+	if cadenceCount > 0 {
+		activity.Summary.AverageCadence = cadenceSum / float64(cadenceCount)
+	}
+	return activity
+}
+
+func seedActivityName(rng *rand.Rand, start time.Time) string {
+	names := []string{"Ride", "Recovery spin", "Tempo loop", "Hill repeats", "Coffee ride", "Long ride"}
+	partOfDay := "Morning"
+	switch {
+	case start.Hour() >= 17:
+		partOfDay = "Evening"
+	case start.Hour() >= 12:
+		partOfDay = "Afternoon"
+	}
+	return partOfDay + " " + names[rng.Intn(len(names))]
+}
+
+// seedSegmentFromActivity carves a 1-3 km favorite segment out of a generated ride and
+// precomputes its match cache. It returns the number of matched efforts.
+func seedSegmentFromActivity(ctx context.Context, conn DB, rng *rand.Rand, athleteID int64, activity *strava.BikeActivity, ordinal int) (int, error) {
+	points := len(activity.LatLngStream.Data)
+	length := 150 + rng.Intn(250) // seconds at ~8 m/s
+	if length >= points {
+		length = points - 1
+	}
+	startIndex := rng.Intn(points - length)
+	endIndex := startIndex + length
+
+	latLng := activity.LatLngStream.Data[startIndex : endIndex+1]
+	samples := make([]PointSample, 0, len(latLng))
+	for i := startIndex; i <= endIndex; i++ {
+		samples = append(samples, PointSample{PointIndex: i, Altitude: &activity.AltitudeStream.Data[i]})
+	}
+	name := fmt.Sprintf("Demo segment %d", ordinal)
+	segment, err := InsertFavoriteSegment(ctx, conn, athleteID, name, "Generated by b11k seed", latLng, samples, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert seed segment: %w", err)
+	}
+	if _, err := conn.Exec(ctx, `UPDATE favorite_segments SET source = $2 WHERE id = $1`, segment.ID, SourceSeed); err != nil {
+		return 0, fmt.Errorf("failed to mark seed segment %d: %w", segment.ID, err)
+	}
+	efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segment.ID, DefaultSegmentToleranceM, "", true, 0, SegmentEffortFilter{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to precompute matches for seed segment %d: %w", segment.ID, err)
+	}
+	return len(efforts), nil
+}
@@ -0,0 +1,105 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func smallSeedOptions() SeedOptions {
+	opts := DefaultSeedOptions()
+	opts.Athletes = 1
+	opts.ActivitiesPerAthlete = 3
+	opts.SegmentsPerAthlete = 1
+	opts.Seed = 42
+	return opts
+}
+
+type seededActivity struct {
+	id       int64
+	name     string
+	distance float64
+	points   int
+}
+
+func seededActivities(t *testing.T, ctx context.Context, conn *pgx.Conn) []seededActivity {
+	t.Helper()
+	rows, err := conn.Query(ctx, `
+		SELECT a.id, a.name, a.distance, (SELECT COUNT(*) FROM point_samples p WHERE p.activity_id = a.id)
+		FROM activity_summaries a
+		WHERE a.source = $1
+		ORDER BY a.id
+	`, SourceSeed)
+	if err != nil {
+		t.Fatalf("query seeded activities: %v", err)
+	}
+	defer rows.Close()
+	var out []seededActivity
+	for rows.Next() {
+		var a seededActivity
+		if err := rows.Scan(&a.id, &a.name, &a.distance, &a.points); err != nil {
+			t.Fatalf("scan seeded activity: %v", err)
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func TestSeedDemoDataIsDeterministicAndWipeable(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	first, err := SeedDemoData(ctx, conn, smallSeedOptions())
+	if err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	if first.Activities != 3 || first.Segments != 1 {
+		t.Fatalf("seeded %d activities and %d segments, want 3 and 1", first.Activities, first.Segments)
+	}
+	if first.SegmentEfforts < 1 {
+		t.Fatalf("segment matched %d efforts, want at least the ride it was carved from", first.SegmentEfforts)
+	}
+	before := seededActivities(t, ctx, conn)
+
+	var geometries int
+	if err := conn.QueryRow(ctx, `
+		SELECT COUNT(*) FROM activity_geometries g
+		JOIN activity_summaries a ON a.id = g.activity_id
+		WHERE a.source = $1 AND g.route_geog_simplified IS NOT NULL
+	`, SourceSeed).Scan(&geometries); err != nil {
+		t.Fatalf("count geometries: %v", err)
+	}
+	if geometries != 3 {
+		t.Fatalf("got %d simplified geometries, want 3", geometries)
+	}
+
+	activities, segments, err := WipeSeedData(ctx, conn)
+	if err != nil {
+		t.Fatalf("WipeSeedData: %v", err)
+	}
+	if activities != 3 || segments != 1 {
+		t.Fatalf("wiped %d activities and %d segments, want 3 and 1", activities, segments)
+	}
+
+	if _, err := SeedDemoData(ctx, conn, smallSeedOptions()); err != nil {
+		t.Fatalf("SeedDemoData again: %v", err)
+	}
+	after := seededActivities(t, ctx, conn)
+	if len(after) != len(before) {
+		t.Fatalf("reseed produced %d activities, want %d", len(after), len(before))
+	}
+	for i := range before {
+		if before[i] != after[i] {
+			t.Fatalf("activity %d differs between runs: %+v vs %+v", i, before[i], after[i])
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package pggeo
+
+// Directions of a segment traversal, stored in segment_activity_matches.direction.
+// Forward rides the segment from its start to its end, reverse from its end to its start.
+const (
+	SegmentDirectionForward = "forward"
+	SegmentDirectionReverse = "reverse"
+)
+
+// ValidSegmentDirection reports whether d is a supported traversal direction
+func ValidSegmentDirection(d string) bool {
+	return d == SegmentDirectionForward || d == SegmentDirectionReverse
+}
+
+// SegmentEffortFilter narrows the efforts returned by GetActivitiesForSegment. The zero
+// value keeps every effort in both directions. Leaderboards, PRs and the timeline only
+// compare forward efforts; see ForwardSegmentEfforts.
+type SegmentEffortFilter struct {
+	// Direction keeps efforts in that direction; empty keeps both
+	Direction string
+	// MinOverlapPercentage drops activities covering less of the segment
+	MinOverlapPercentage float64
+}
+
+// ForwardSegmentEfforts keeps only efforts ridden in the segment's direction
+var ForwardSegmentEfforts = SegmentEffortFilter{Direction: SegmentDirectionForward}
+
+func (f SegmentEffortFilter) keepsMatch(match SegmentMatchResult) bool {
+	return match.OverlapPercentage >= f.MinOverlapPercentage
+}
+
+func (f SegmentEffortFilter) keepsEffort(effort SegmentActivityCacheEntry) bool {
+	return f.Direction == "" || effort.Direction == f.Direction
+}
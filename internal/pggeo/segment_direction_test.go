@@ -0,0 +1,19 @@
+package pggeo
+
+import "testing"
+
+func TestSegmentEffortFilter(t *testing.T) {
+	forward := SegmentActivityCacheEntry{Direction: SegmentDirectionForward}
+	reverse := SegmentActivityCacheEntry{Direction: SegmentDirectionReverse}
+	if all := (SegmentEffortFilter{}); !all.keepsEffort(forward) || !all.keepsEffort(reverse) {
+		t.Fatal("the zero filter should keep both directions")
+	}
+	if ForwardSegmentEfforts.keepsEffort(reverse) || !ForwardSegmentEfforts.keepsEffort(forward) {
+		t.Fatal("ForwardSegmentEfforts should keep only forward efforts")
+	}
+
+	filter := SegmentEffortFilter{MinOverlapPercentage: 80}
+	if filter.keepsMatch(SegmentMatchResult{OverlapPercentage: 79.9}) || !filter.keepsMatch(SegmentMatchResult{OverlapPercentage: 80}) {
+		t.Fatal("matches below the minimum overlap should be dropped")
+	}
+}
@@ -0,0 +1,85 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// segmentRouteElevationRadiusM is how close a recorded sample must be to a segment
+// vertex to lend it its altitude
+const segmentRouteElevationRadiusM = 10.0
+
+// SegmentRoutePoint is one vertex of a segment; Altitude is nil when the segment has no
+// elevation profile or no recorded sample lies near the vertex
+type SegmentRoutePoint struct {
+	Lat      float64
+	Lng      float64
+	Altitude *float64
+}
+
+// SegmentRoute is a segment's geometry for export to a head unit
+type SegmentRoute struct {
+	ID     int64
+	Name   string
+	Points []SegmentRoutePoint
+}
+
+// GetSegmentRoutes returns the athlete's segments as routes, ordered by name; no
+// segmentIDs returns all of them. Segments created from an activity have an elevation
+// profile, so their vertices take the altitude of the athlete's nearest recorded sample
+// within 10 m. Drawn segments have none and export without altitude.
+func GetSegmentRoutes(ctx context.Context, conn DB, athleteID int64, segmentIDs []int64) ([]SegmentRoute, error) {
+	if segmentIDs == nil {
+		segmentIDs = []int64{} // NULL would match nothing
+	}
+	rows, err := conn.Query(ctx, `
+	SELECT fs.id, fs.name, ST_Y(dp.geom), ST_X(dp.geom), ele.altitude
+	FROM favorite_segments fs
+	CROSS JOIN LATERAL ST_DumpPoints(fs.segment_geog::geometry) dp
+	LEFT JOIN LATERAL (
+		SELECT ps.altitude
+		FROM point_sample_rows ps
+		WHERE ps.athlete_id = fs.athlete_id AND ps.altitude IS NOT NULL
+		  AND ST_DWithin(ps.location, dp.geom::geography, $3)
+		ORDER BY ps.location <-> dp.geom::geography
+		LIMIT 1
+	) ele ON fs.elevation_gain_m IS NOT NULL
+	WHERE fs.athlete_id = $1 AND (cardinality($2::BIGINT[]) = 0 OR fs.id = ANY($2))
+	ORDER BY fs.name, fs.id, dp.path[1]
+	`, athleteID, segmentIDs, segmentRouteElevationRadiusM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query segment routes: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []SegmentRoute
+	for rows.Next() {
+		var id int64
+		var name string
+		var point SegmentRoutePoint
+		if err := rows.Scan(&id, &name, &point.Lat, &point.Lng, &point.Altitude); err != nil {
+			return nil, fmt.Errorf("failed to scan segment route point: %w", err)
+		}
+		if len(routes) == 0 || routes[len(routes)-1].ID != id {
+			routes = append(routes, SegmentRoute{ID: id, Name: name})
+		}
+		route := &routes[len(routes)-1]
+		route.Points = append(route.Points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read segment routes: %w", err)
+	}
+	return routes, nil
+}
+
+// GetSegmentRoute returns one of the athlete's segments as a route
+func GetSegmentRoute(ctx context.Context, conn DB, athleteID, segmentID int64) (*SegmentRoute, error) {
+	routes, err := GetSegmentRoutes(ctx, conn, athleteID, []int64{segmentID})
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, segmentNotFoundf(nil, "segment %d not found", segmentID)
+	}
+	return &routes[0], nil
+}
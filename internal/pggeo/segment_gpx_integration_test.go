@@ -0,0 +1,72 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGetSegmentRoutesTakeAltitudeFromNearbySamples(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const activityID = int64(-7)
+	segmentIDs := []int64{-7, -8}
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), "DELETE FROM favorite_segments WHERE id = ANY($1)", segmentIDs)
+		_, _ = conn.Exec(context.Background(), "DELETE FROM point_samples WHERE activity_id = $1", activityID)
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_geometries WHERE activity_id = $1", activityID)
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_summaries WHERE id = $1", activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	var lons, lats []float64
+	for i := 0; i < 5; i++ {
+		lons = append(lons, selfCheckOriginLon+float64(i)*overlapFixtureStepMeters/metersPerDegreeLon45)
+		lats = append(lats, selfCheckOriginLat)
+	}
+	insertOverlapFixtureActivity(t, ctx, conn, activityID, lons, lats)
+	if _, err := conn.Exec(ctx, "UPDATE point_samples SET altitude = 100 + point_index WHERE activity_id = $1", activityID); err != nil {
+		t.Fatalf("set altitudes: %v", err)
+	}
+	// "B recorded" has an elevation profile, "A drawn" has none
+	gain := 4.0
+	for _, segment := range []struct {
+		id   int64
+		name string
+		gain *float64
+	}{{-7, "B recorded", &gain}, {-8, "A drawn", nil}} {
+		if _, err := conn.Exec(ctx, `INSERT INTO favorite_segments (id, athlete_id, name, segment_geog, elevation_gain_m)
+			VALUES ($1, $2, $3, make_route_geog_from_lonlat($4, $5), $6)`,
+			segment.id, int64(overlapFixtureAthleteID), segment.name, lons, lats, segment.gain); err != nil {
+			t.Fatalf("insert segment %s: %v", segment.name, err)
+		}
+	}
+
+	routes, err := GetSegmentRoutes(ctx, conn, overlapFixtureAthleteID, nil)
+	if err != nil {
+		t.Fatalf("GetSegmentRoutes: %v", err)
+	}
+	if len(routes) != 2 || routes[0].Name != "A drawn" || routes[1].Name != "B recorded" {
+		t.Fatalf("routes = %+v, want both segments by name", routes)
+	}
+	for _, point := range routes[0].Points {
+		if point.Altitude != nil {
+			t.Fatalf("drawn segment point has altitude %v", *point.Altitude)
+		}
+	}
+	recorded := routes[1]
+	if len(recorded.Points) != len(lons) {
+		t.Fatalf("recorded route has %d points, want %d", len(recorded.Points), len(lons))
+	}
+	for i, point := range recorded.Points {
+		if point.Altitude == nil || *point.Altitude != float64(100+i) {
+			t.Fatalf("point %d = %+v, want altitude %d", i, point, 100+i)
+		}
+	}
+
+	if _, err := GetSegmentRoute(ctx, conn, overlapFixtureAthleteID+1, -7); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetSegmentRoute for another athlete = %v, want not found", err)
+	}
+}
@@ -0,0 +1,80 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestSegmentLeaderboardRanksFastestForwardTraverses(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	opts := smallSeedOptions()
+	opts.ActivitiesPerAthlete = 8
+	if _, err := SeedDemoData(ctx, conn, opts); err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	var segmentID, athleteID int64
+	if err := conn.QueryRow(ctx, `SELECT id, athlete_id FROM favorite_segments WHERE source = $1 LIMIT 1`, SourceSeed).Scan(&segmentID, &athleteID); err != nil {
+		t.Fatalf("find seeded segment: %v", err)
+	}
+	const tolerance = 15.0
+	efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segmentID, tolerance, "time", true, 0, ForwardSegmentEfforts)
+	if err != nil || len(efforts) == 0 {
+		t.Fatalf("GetActivitiesForSegment = %d efforts, %v; want some", len(efforts), err)
+	}
+
+	// Ride the first effort backwards: same line, reversed order, increasing timestamps
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, efforts[0].ID)
+	if err != nil {
+		t.Fatalf("GetPointSamplesForActivity: %v", err)
+	}
+	reverseID := SeedActivityIDBase + seedActivityStride - 1
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	reversed := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID: reverseID, AthleteID: athleteID, Name: "Reverse ride", Type: "Ride", SportType: "Ride",
+		StartDate: start.Format(time.RFC3339), StartDateTime: start,
+	}}
+	for i := len(samples) - 1; i >= 0; i-- {
+		reversed.TimeStream.Data = append(reversed.TimeStream.Data, start.Add(time.Duration(len(samples)-1-i)*time.Second))
+		reversed.LatLngStream.Data = append(reversed.LatLngStream.Data, []float64{samples[i].Lat, samples[i].Lng})
+	}
+	if err := InsertBikeActivity(ctx, conn, reversed); err != nil {
+		t.Fatalf("insert reversed ride: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `UPDATE activity_summaries SET source = $2 WHERE id = $1`, reverseID, SourceSeed); err != nil {
+		t.Fatalf("mark reversed ride: %v", err)
+	}
+
+	efforts, err = GetActivitiesForSegment(ctx, conn, athleteID, segmentID, tolerance, "time", true, 0, ForwardSegmentEfforts)
+	if err != nil {
+		t.Fatalf("GetActivitiesForSegment after reverse ride: %v", err)
+	}
+	for i, effort := range efforts {
+		if effort.ID == reverseID {
+			t.Fatal("the reversed ride was matched as an effort")
+		}
+		if effort.SegmentElapsedSecs == nil || *effort.SegmentElapsedSecs <= 0 {
+			t.Fatalf("effort %d has no segment time", effort.ID)
+		}
+		cached, err := GetCachedSegmentActivityEfforts(ctx, conn, segmentID, effort.ID, tolerance)
+		if err != nil || len(cached) < effort.EffortNumber || cached[effort.EffortNumber-1].ElapsedSeconds == nil ||
+			*cached[effort.EffortNumber-1].ElapsedSeconds != *effort.SegmentElapsedSecs {
+			t.Fatalf("cached efforts for %d = %+v, %v; want elapsed %v", effort.ID, cached, err, *effort.SegmentElapsedSecs)
+		}
+		if i > 0 && *efforts[i-1].SegmentElapsedSecs > *effort.SegmentElapsedSecs {
+			t.Fatalf("effort %d (%.0fs) ranked after a slower one (%.0fs)", effort.ID, *effort.SegmentElapsedSecs, *efforts[i-1].SegmentElapsedSecs)
+		}
+	}
+}
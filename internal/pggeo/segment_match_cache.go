@@ -0,0 +1,68 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultSegmentMatchCacheTTL is how long a segment's match cache is served after it was
+// last filled when no TTL is configured
+const DefaultSegmentMatchCacheTTL = time.Hour
+
+// markSegmentMatchCacheScanned records that every activity was just matched against the
+// segment at the tolerance
+func markSegmentMatchCacheScanned(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64) error {
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO segment_match_cache_state (segment_id, tolerance_meters, last_full_scan_at, refreshed_at)
+		VALUES ($1, $2, NOW(), NOW())
+		ON CONFLICT (segment_id, tolerance_meters)
+		DO UPDATE SET last_full_scan_at = NOW(), refreshed_at = NOW()
+	`, segmentID, toleranceMeters); err != nil {
+		return fmt.Errorf("failed to record segment cache scan: %w", err)
+	}
+	return nil
+}
+
+// markSegmentMatchCacheRefreshed records that new activities were just matched against a
+// segment scanned before, which keeps its cache fresh
+func markSegmentMatchCacheRefreshed(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64) error {
+	if _, err := conn.Exec(ctx, `
+		UPDATE segment_match_cache_state SET refreshed_at = NOW()
+		WHERE segment_id = $1 AND tolerance_meters = $2
+	`, segmentID, toleranceMeters); err != nil {
+		return fmt.Errorf("failed to mark segment cache fresh: %w", err)
+	}
+	return nil
+}
+
+// segmentMatchCacheScanned reports whether the segment's matches at the tolerance were
+// ever computed against every activity
+func segmentMatchCacheScanned(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64) (bool, error) {
+	var scanned bool
+	if err := conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM segment_match_cache_state WHERE segment_id = $1 AND tolerance_meters = $2)
+	`, segmentID, toleranceMeters).Scan(&scanned); err != nil {
+		return false, fmt.Errorf("failed to check segment cache: %w", err)
+	}
+	return scanned, nil
+}
+
+// segmentMatchCacheFresh reports whether the segment's match cache at the tolerance was
+// filled within the TTL; ttl <= 0 uses DefaultSegmentMatchCacheTTL
+func segmentMatchCacheFresh(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = DefaultSegmentMatchCacheTTL
+	}
+	var fresh bool
+	if err := conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM segment_match_cache_state
+			WHERE segment_id = $1 AND tolerance_meters = $2
+			  AND refreshed_at > NOW() - make_interval(secs => $3)
+		)
+	`, segmentID, toleranceMeters, ttl.Seconds()).Scan(&fresh); err != nil {
+		return false, fmt.Errorf("failed to check segment cache age: %w", err)
+	}
+	return fresh, nil
+}
@@ -0,0 +1,87 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A segment ridden by 200 activities, each along its whole length
+const (
+	popularFixtureSegmentID   = -9
+	popularFixtureActivityID  = -1000 // the activities are -1000 down to -1199
+	popularFixtureActivities  = 200
+	popularFixturePoints      = 5
+	popularFixtureToleranceM  = 10.0
+	popularFixtureCacheTTL    = 3 * time.Hour
+	popularFixtureCacheAgeMin = 120
+)
+
+func TestSegmentPageStatementsDoNotGrowWithMatches(t *testing.T) {
+	setupCtx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		ctx := context.Background()
+		low, high := int64(popularFixtureActivityID-popularFixtureActivities+1), int64(popularFixtureActivityID)
+		_, _ = conn.Exec(ctx, "DELETE FROM favorite_segments WHERE id = $1", int64(popularFixtureSegmentID))
+		_, _ = conn.Exec(ctx, "DELETE FROM point_samples WHERE activity_id BETWEEN $1 AND $2", low, high)
+		_, _ = conn.Exec(ctx, "DELETE FROM activity_geometries WHERE activity_id BETWEEN $1 AND $2", low, high)
+		_, _ = conn.Exec(ctx, "DELETE FROM activity_summaries WHERE id BETWEEN $1 AND $2", low, high)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	var lons, lats []float64
+	for i := 0; i < popularFixturePoints; i++ {
+		lons = append(lons, selfCheckOriginLon+float64(i)*overlapFixtureStepMeters/metersPerDegreeLon45)
+		lats = append(lats, selfCheckOriginLat)
+	}
+	for i := 0; i < popularFixtureActivities; i++ {
+		insertOverlapFixtureActivity(t, setupCtx, conn, int64(popularFixtureActivityID-i), lons, lats)
+	}
+	if _, err := conn.Exec(setupCtx, `INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+		VALUES ($1, $2, 'popular fixture', make_route_geog_from_lonlat($3, $4))`,
+		int64(popularFixtureSegmentID), int64(overlapFixtureAthleteID), lons, lats); err != nil {
+		t.Fatalf("insert popular fixture segment: %v", err)
+	}
+
+	full, err := GetActivitiesForSegment(setupCtx, conn, overlapFixtureAthleteID, popularFixtureSegmentID, popularFixtureToleranceM, "", true, 0, SegmentEffortFilter{})
+	if err != nil {
+		t.Fatalf("full match: %v", err)
+	}
+	if got := len(segmentActivityIDs(full)); got != popularFixtureActivities {
+		t.Fatalf("full match found %d activities, want %d", got, popularFixtureActivities)
+	}
+
+	pool := tracedIntegrationPool(t)
+	assertStatementBudget(t, "segment activities", func(ctx context.Context) (int, error) {
+		page, err := GetActivitiesForSegment(ctx, pool, overlapFixtureAthleteID, popularFixtureSegmentID, popularFixtureToleranceM, "", false, 0, SegmentEffortFilter{})
+		return len(page), err
+	})
+
+	// A cache filled two hours ago is served under a longer TTL and rescanned under the default
+	if _, err := conn.Exec(setupCtx, `
+		UPDATE segment_match_cache_state SET refreshed_at = NOW() - make_interval(mins => $2) WHERE segment_id = $1
+	`, int64(popularFixtureSegmentID), popularFixtureCacheAgeMin); err != nil {
+		t.Fatalf("age cache: %v", err)
+	}
+	for _, tc := range []struct {
+		ttl    time.Duration
+		rescan bool
+	}{{popularFixtureCacheTTL, false}, {0, true}} {
+		ctx, statements := WithStatementLog(context.Background())
+		page, err := GetActivitiesForSegment(ctx, pool, overlapFixtureAthleteID, popularFixtureSegmentID, popularFixtureToleranceM, "", false, tc.ttl, SegmentEffortFilter{})
+		if err != nil {
+			t.Fatalf("segment page with TTL %s: %v", tc.ttl, err)
+		}
+		if len(page) != len(full) {
+			t.Fatalf("segment page with TTL %s shows %d efforts, want %d", tc.ttl, len(page), len(full))
+		}
+		rescanned := strings.Contains(strings.Join(statements.Statements(), "\n"), "find_route_parts_matching_segment")
+		if rescanned != tc.rescan {
+			t.Fatalf("segment page with TTL %s rescanned = %v, want %v", tc.ttl, rescanned, tc.rescan)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"errors"
+	"testing"
+)
+
+const segmentNamesAthleteID = 990000404
+
+var segmentNamesPoints = [][]float64{{37.80, -122.47}, {37.81, -122.47}}
+
+func TestDuplicateSegmentNamesAreRejectedPerAthlete(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		if _, err := conn.Exec(ctx, `DELETE FROM favorite_segments WHERE athlete_id IN ($1, $2)`, segmentNamesAthleteID, segmentNamesAthleteID+1); err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	first, err := InsertFavoriteSegment(ctx, conn, segmentNamesAthleteID, "Climb", "", segmentNamesPoints, nil, nil)
+	if err != nil {
+		t.Fatalf("InsertFavoriteSegment: %v", err)
+	}
+	if _, err := InsertFavoriteSegment(ctx, conn, segmentNamesAthleteID, "Climb", "", segmentNamesPoints, nil, nil); !errors.Is(err, ErrSegmentNameExists) {
+		t.Fatalf("duplicate insert error = %v, want ErrSegmentNameExists", err)
+	}
+	if _, err := InsertFavoriteSegment(ctx, conn, segmentNamesAthleteID+1, "Climb", "", segmentNamesPoints, nil, nil); err != nil {
+		t.Fatalf("another athlete's segment with the same name: %v", err)
+	}
+
+	second, err := InsertFavoriteSegment(ctx, conn, segmentNamesAthleteID, "Sprint", "", segmentNamesPoints, nil, nil)
+	if err != nil {
+		t.Fatalf("InsertFavoriteSegment: %v", err)
+	}
+	if _, err := UpdateFavoriteSegmentDetails(ctx, conn, segmentNamesAthleteID, second.ID, first.Name, ""); !errors.Is(err, ErrSegmentNameExists) {
+		t.Fatalf("rename to a taken name error = %v, want ErrSegmentNameExists", err)
+	}
+	if _, err := UpdateFavoriteSegment(ctx, conn, segmentNamesAthleteID, second.ID, first.Name, "", segmentNamesPoints, nil); !errors.Is(err, ErrSegmentNameExists) {
+		t.Fatalf("update to a taken name error = %v, want ErrSegmentNameExists", err)
+	}
+
+	if _, err := FindRoutePartsMatchingSegmentByName(ctx, conn, segmentNamesAthleteID, "Climb", 15); err != nil {
+		t.Fatalf("FindRoutePartsMatchingSegmentByName: %v", err)
+	}
+	if _, err := FindRoutePartsMatchingSegmentByName(ctx, conn, segmentNamesAthleteID, "Missing", 15); err == nil {
+		t.Fatal("lookup of a missing name should fail")
+	}
+}
+
+func TestMigrationRenamesDuplicateSegmentNames(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		if _, err := conn.Exec(ctx, `DELETE FROM favorite_segments WHERE athlete_id = $1`, segmentNamesAthleteID); err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+	t.Cleanup(func() {
+		if err := ensureUniqueSegmentNames(ctx, conn); err != nil {
+			t.Fatalf("restore unique index: %v", err)
+		}
+	})
+
+	// Recreate the pre-migration schema, where duplicates were allowed
+	if _, err := conn.Exec(ctx, `DROP INDEX idx_favorite_segments_athlete_name_unique`); err != nil {
+		t.Fatalf("drop unique index: %v", err)
+	}
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		segment, err := InsertFavoriteSegment(ctx, conn, segmentNamesAthleteID, "Climb", "", segmentNamesPoints, nil, nil)
+		if err != nil {
+			t.Fatalf("InsertFavoriteSegment %d: %v", i, err)
+		}
+		ids = append(ids, segment.ID)
+	}
+	if _, err := FindRoutePartsMatchingSegmentByName(ctx, conn, segmentNamesAthleteID, "Climb", 15); !errors.Is(err, ErrSegmentNameAmbiguous) {
+		t.Fatalf("lookup of a duplicated name error = %v, want ErrSegmentNameAmbiguous", err)
+	}
+
+	if err := ValidateAndMigrateSchema(ctx, conn, false); err != nil {
+		t.Fatalf("ValidateAndMigrateSchema: %v", err)
+	}
+	for i, want := range []string{"Climb", "Climb (2)", "Climb (3)"} {
+		segment, err := GetFavoriteSegment(ctx, conn, segmentNamesAthleteID, ids[i])
+		if err != nil {
+			t.Fatalf("GetFavoriteSegment: %v", err)
+		}
+		if segment.Name != want {
+			t.Fatalf("segment %d is named %q, want %q", i, segment.Name, want)
+		}
+	}
+	if _, err := InsertFavoriteSegment(ctx, conn, segmentNamesAthleteID, "Climb", "", segmentNamesPoints, nil, nil); !errors.Is(err, ErrSegmentNameExists) {
+		t.Fatalf("insert after migration error = %v, want ErrSegmentNameExists", err)
+	}
+}
@@ -0,0 +1,37 @@
+package pggeo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDedupeSegmentNamesKeepsOldestAndSkipsTakenSuffixes(t *testing.T) {
+	// Oldest first per athlete, as renameDuplicateSegmentNames queries them
+	segments := []segmentName{
+		{ID: 1, AthleteID: 10, Name: "Climb"},
+		{ID: 2, AthleteID: 10, Name: "Climb (2)"},
+		{ID: 3, AthleteID: 10, Name: "Climb"},
+		{ID: 4, AthleteID: 10, Name: "Climb"},
+		{ID: 5, AthleteID: 10, Name: "Sprint"},
+		{ID: 6, AthleteID: 20, Name: "Climb"},
+		{ID: 7, AthleteID: 20, Name: "Climb"},
+	}
+	want := []segmentName{
+		{ID: 3, AthleteID: 10, Name: "Climb (3)"},
+		{ID: 4, AthleteID: 10, Name: "Climb (4)"},
+		{ID: 7, AthleteID: 20, Name: "Climb (2)"},
+	}
+	if got := dedupeSegmentNames(segments); !reflect.DeepEqual(got, want) {
+		t.Fatalf("renames = %+v, want %+v", got, want)
+	}
+}
+
+func TestDedupeSegmentNamesLeavesUniqueNamesAlone(t *testing.T) {
+	segments := []segmentName{
+		{ID: 1, AthleteID: 10, Name: "Climb"},
+		{ID: 2, AthleteID: 20, Name: "Climb"},
+	}
+	if got := dedupeSegmentNames(segments); len(got) != 0 {
+		t.Fatalf("renames = %+v, want none", got)
+	}
+}
@@ -0,0 +1,172 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// A 400 m segment heading east, an activity riding along it and one zigzagging across it:
+// every 10 m east the zigzag is 40 m north, on the segment, 40 m south, on the segment.
+const (
+	overlapFixtureAthleteID   = -5
+	overlapFixtureSegmentID   = -5
+	overlapFixtureStraightID  = -5
+	overlapFixtureZigzagID    = -6
+	overlapFixturePoints      = 41
+	overlapFixtureStepMeters  = 10.0
+	overlapFixtureZigzagM     = 40.0
+	overlapFixtureToleranceM  = 10.0
+	overlapFixtureMinCoverage = 99.0
+)
+
+func TestSegmentProjectedCoverageIgnoresCrossings(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	activityIDs := []int64{overlapFixtureStraightID, overlapFixtureZigzagID}
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), "DELETE FROM favorite_segments WHERE id = $1", int64(overlapFixtureSegmentID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM point_samples WHERE activity_id = ANY($1)", activityIDs)
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_geometries WHERE activity_id = ANY($1)", activityIDs)
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_summaries WHERE id = ANY($1)", activityIDs)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	zigzag := []float64{overlapFixtureZigzagM, 0, -overlapFixtureZigzagM, 0}
+	var lons, straightLats, zigzagLats []float64
+	for i := 0; i < overlapFixturePoints; i++ {
+		lons = append(lons, selfCheckOriginLon+float64(i)*overlapFixtureStepMeters/metersPerDegreeLon45)
+		straightLats = append(straightLats, selfCheckOriginLat)
+		zigzagLats = append(zigzagLats, selfCheckOriginLat+zigzag[i%len(zigzag)]/metersPerDegreeLat45)
+	}
+	for _, fixture := range []struct {
+		id   int64
+		lats []float64
+	}{{overlapFixtureStraightID, straightLats}, {overlapFixtureZigzagID, zigzagLats}} {
+		insertOverlapFixtureActivity(t, ctx, conn, fixture.id, lons, fixture.lats)
+	}
+	if _, err := conn.Exec(ctx, `INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+		VALUES ($1, $2, 'overlap fixture', make_route_geog_from_lonlat($3, $4))`,
+		int64(overlapFixtureSegmentID), int64(overlapFixtureAthleteID), lons, straightLats); err != nil {
+		t.Fatalf("insert overlap fixture segment: %v", err)
+	}
+
+	straight, err := GetSegmentOverlap(ctx, conn, overlapFixtureAthleteID, overlapFixtureSegmentID, overlapFixtureStraightID, overlapFixtureToleranceM)
+	if err != nil {
+		t.Fatalf("GetSegmentOverlap straight: %v", err)
+	}
+	if straight.CoveragePercentage < overlapFixtureMinCoverage {
+		t.Fatalf("straight ride covers %.1f%%, want >= %.0f%%", straight.CoveragePercentage, overlapFixtureMinCoverage)
+	}
+
+	zig, err := GetSegmentOverlap(ctx, conn, overlapFixtureAthleteID, overlapFixtureSegmentID, overlapFixtureZigzagID, overlapFixtureToleranceM)
+	if err != nil {
+		t.Fatalf("GetSegmentOverlap zigzag: %v", err)
+	}
+	if zig.BufferedPercentage < 90 {
+		t.Fatalf("zigzag buffered overlap = %.1f%%, want the old method to report >= 90%%", zig.BufferedPercentage)
+	}
+	if zig.CoveragePercentage > 10 {
+		t.Fatalf("zigzag covers %.1f%%, want <= 10%%", zig.CoveragePercentage)
+	}
+
+	matches, err := FindRoutePartsMatchingSegment(ctx, conn, overlapFixtureAthleteID, overlapFixtureSegmentID, overlapFixtureToleranceM)
+	if err != nil {
+		t.Fatalf("FindRoutePartsMatchingSegment: %v", err)
+	}
+	for _, match := range matches {
+		if match.ActivityID == overlapFixtureZigzagID && match.OverlapPercentage > 10 {
+			t.Fatalf("zigzag match overlap = %.1f%%, want the projected coverage", match.OverlapPercentage)
+		}
+	}
+
+	if _, err := GetSegmentOverlap(ctx, conn, overlapFixtureAthleteID+1, overlapFixtureSegmentID, overlapFixtureStraightID, overlapFixtureToleranceM); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetSegmentOverlap for another athlete = %v, want not found", err)
+	}
+}
+
+func TestSegmentMatchCacheRecomputesBufferedRows(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), "DELETE FROM favorite_segments WHERE id = $1", int64(overlapFixtureSegmentID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM point_samples WHERE activity_id = $1", int64(overlapFixtureStraightID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_geometries WHERE activity_id = $1", int64(overlapFixtureStraightID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_summaries WHERE id = $1", int64(overlapFixtureStraightID))
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	var lons, lats []float64
+	for i := 0; i < overlapFixturePoints; i++ {
+		lons = append(lons, selfCheckOriginLon+float64(i)*overlapFixtureStepMeters/metersPerDegreeLon45)
+		lats = append(lats, selfCheckOriginLat)
+	}
+	insertOverlapFixtureActivity(t, ctx, conn, overlapFixtureStraightID, lons, lats)
+	if _, err := conn.Exec(ctx, `INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+		VALUES ($1, $2, 'overlap fixture', make_route_geog_from_lonlat($3, $4))`,
+		int64(overlapFixtureSegmentID), int64(overlapFixtureAthleteID), lons, lats); err != nil {
+		t.Fatalf("insert overlap fixture segment: %v", err)
+	}
+
+	// A match cached with the old method, as left behind by the column migration, in a
+	// cache that is otherwise fresh
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO segment_activity_matches
+		(segment_id, activity_id, tolerance_meters, effort_number, min_distance_m, overlap_length_m, overlap_percentage, overlap_method)
+		VALUES ($1, $2, $3, 1, 0, 400, 42, 'buffered')
+	`, int64(overlapFixtureSegmentID), int64(overlapFixtureStraightID), overlapFixtureToleranceM); err != nil {
+		t.Fatalf("insert buffered cache row: %v", err)
+	}
+	if err := markSegmentMatchCacheScanned(ctx, conn, overlapFixtureSegmentID, overlapFixtureToleranceM); err != nil {
+		t.Fatalf("mark cache scanned: %v", err)
+	}
+	cached, _, ok, err := getCachedSegmentMatches(ctx, conn, overlapFixtureSegmentID, overlapFixtureToleranceM, 0)
+	if err != nil || ok {
+		t.Fatalf("cached matches = %+v, %v; want a miss for buffered rows", cached, err)
+	}
+
+	page, err := GetActivitiesForSegment(ctx, conn, overlapFixtureAthleteID, overlapFixtureSegmentID, overlapFixtureToleranceM, "", false, 0, SegmentEffortFilter{})
+	if err != nil {
+		t.Fatalf("GetActivitiesForSegment: %v", err)
+	}
+	if len(page) == 0 || page[0].OverlapPercentage < overlapFixtureMinCoverage {
+		t.Fatalf("segment page = %+v, want the straight ride recomputed at >= %.0f%%", page, overlapFixtureMinCoverage)
+	}
+	var method string
+	if err := conn.QueryRow(ctx, `
+		SELECT overlap_method FROM segment_activity_matches
+		WHERE segment_id = $1 AND activity_id = $2 AND tolerance_meters = $3 AND effort_number = 1
+	`, int64(overlapFixtureSegmentID), int64(overlapFixtureStraightID), overlapFixtureToleranceM).Scan(&method); err != nil {
+		t.Fatalf("read overlap method: %v", err)
+	}
+	if method != OverlapMethodProjected {
+		t.Fatalf("overlap method = %q, want %q", method, OverlapMethodProjected)
+	}
+}
+
+func insertOverlapFixtureActivity(t *testing.T, ctx context.Context, conn DB, activityID int64, lons, lats []float64) {
+	t.Helper()
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'overlap fixture', $3, $4, $4, 0, 'Ride', NOW())`,
+			[]any{activityID, int64(overlapFixtureAthleteID), float64(len(lons)-1) * overlapFixtureStepMeters, len(lons)}},
+		{`INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+			VALUES ($1, $2, make_route_geog_from_lonlat($3, $4))`,
+			[]any{activityID, int64(overlapFixtureAthleteID), lons, lats}},
+		{`INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location)
+			SELECT $1, $2, i - 1, TIMESTAMPTZ '2024-05-01 08:00:00Z' + make_interval(secs => i),
+				ST_SetSRID(ST_MakePoint(($3::DOUBLE PRECISION[])[i], ($4::DOUBLE PRECISION[])[i]), 4326)::GEOGRAPHY
+			FROM generate_subscripts($3::DOUBLE PRECISION[], 1) AS i`,
+			[]any{activityID, int64(overlapFixtureAthleteID), lons, lats}},
+	}
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("insert overlap fixture activity %d: %v", activityID, err)
+		}
+	}
+}
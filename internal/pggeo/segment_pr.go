@@ -0,0 +1,73 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// SegmentPR is a favorite segment's new fastest traversal
+type SegmentPR struct {
+	SegmentID              int64   `json:"segment_id"`
+	SegmentName            string  `json:"segment_name"`
+	ActivityID             int64   `json:"activity_id"`
+	EffortNumber           int     `json:"effort_number"`
+	ElapsedSeconds         float64 `json:"elapsed_seconds"`
+	PreviousBestSeconds    float64 `json:"previous_best_seconds"`
+	PreviousBestActivityID int64   `json:"previous_best_activity_id"`
+	ToleranceMeters        float64 `json:"tolerance_m"`
+}
+
+// DetectSegmentPRs returns the athlete's favorite segments on which a traversal in the
+// activity is faster than every effort of their other activities. A segment ridden for
+// the first time is not a PR. Each segment's matches are recomputed at its effective
+// tolerance so the new activity is included, which also refreshes the match cache.
+func DetectSegmentPRs(ctx context.Context, conn DB, athleteID, activityID int64, athleteDefaultToleranceM *float64) ([]SegmentPR, error) {
+	segments, err := ListFavoriteSegments(ctx, conn, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+
+	var prs []SegmentPR
+	for _, segment := range segments {
+		tolerance, _ := ResolveTolerance(nil, segment.DefaultToleranceM, athleteDefaultToleranceM)
+		efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segment.ID, tolerance, "time", true, 0, ForwardSegmentEfforts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load efforts on segment %d: %w", segment.ID, err)
+		}
+		if pr, ok := segmentPR(efforts, activityID); ok {
+			pr.SegmentID = segment.ID
+			pr.SegmentName = segment.Name
+			pr.ToleranceMeters = tolerance
+			prs = append(prs, pr)
+		}
+	}
+	return prs, nil
+}
+
+// segmentPR compares the activity's fastest effort with the fastest of every other activity
+func segmentPR(efforts []ActivityWithMatch, activityID int64) (SegmentPR, bool) {
+	var best, previous *ActivityWithMatch
+	for i := range efforts {
+		effort := &efforts[i]
+		if effort.SegmentElapsedSecs == nil || *effort.SegmentElapsedSecs <= 0 {
+			continue
+		}
+		target := &previous
+		if effort.ID == activityID {
+			target = &best
+		}
+		if *target == nil || *effort.SegmentElapsedSecs < *(*target).SegmentElapsedSecs {
+			*target = effort
+		}
+	}
+	if best == nil || previous == nil || *best.SegmentElapsedSecs >= *previous.SegmentElapsedSecs {
+		return SegmentPR{}, false
+	}
+	return SegmentPR{
+		ActivityID:             activityID,
+		EffortNumber:           best.EffortNumber,
+		ElapsedSeconds:         *best.SegmentElapsedSecs,
+		PreviousBestSeconds:    *previous.SegmentElapsedSecs,
+		PreviousBestActivityID: previous.ID,
+	}, true
+}
@@ -0,0 +1,86 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestDetectSegmentPRsFindsFasterRide(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	opts := smallSeedOptions()
+	opts.ActivitiesPerAthlete = 6
+	if _, err := SeedDemoData(ctx, conn, opts); err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	var segmentID, athleteID int64
+	if err := conn.QueryRow(ctx, `SELECT id, athlete_id FROM favorite_segments WHERE source = $1 LIMIT 1`, SourceSeed).Scan(&segmentID, &athleteID); err != nil {
+		t.Fatalf("find seeded segment: %v", err)
+	}
+	tolerance, _ := ResolveTolerance(nil, nil, nil)
+	efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segmentID, tolerance, "time", true, 0, ForwardSegmentEfforts)
+	if err != nil || len(efforts) < 2 {
+		t.Fatalf("GetActivitiesForSegment = %d efforts, %v; want at least 2", len(efforts), err)
+	}
+	slowest := efforts[len(efforts)-1]
+	if prs, err := DetectSegmentPRs(ctx, conn, athleteID, slowest.ID, nil); err != nil || containsSegmentPR(prs, segmentID) {
+		t.Fatalf("slowest ride PRs = %+v, %v; want none on segment %d", prs, err, segmentID)
+	}
+
+	// Ride the fastest effort's route again at twice the speed
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, efforts[0].ID)
+	if err != nil {
+		t.Fatalf("GetPointSamplesForActivity: %v", err)
+	}
+	fastID := SeedActivityIDBase + seedActivityStride - 2
+	start := time.Date(2024, 7, 1, 8, 0, 0, 0, time.UTC)
+	fast := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID: fastID, AthleteID: athleteID, Name: "Flying", Type: "Ride", SportType: "Ride",
+		StartDate: start.Format(time.RFC3339), StartDateTime: start,
+	}}
+	for _, sample := range samples {
+		fast.TimeStream.Data = append(fast.TimeStream.Data, start.Add(sample.Time.Sub(samples[0].Time)/2))
+		fast.LatLngStream.Data = append(fast.LatLngStream.Data, []float64{sample.Lat, sample.Lng})
+	}
+	if err := InsertBikeActivity(ctx, conn, fast); err != nil {
+		t.Fatalf("insert fast ride: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `UPDATE activity_summaries SET source = $2 WHERE id = $1`, fastID, SourceSeed); err != nil {
+		t.Fatalf("mark fast ride: %v", err)
+	}
+
+	prs, err := DetectSegmentPRs(ctx, conn, athleteID, fastID, nil)
+	if err != nil {
+		t.Fatalf("DetectSegmentPRs: %v", err)
+	}
+	for _, pr := range prs {
+		if pr.SegmentID == segmentID {
+			if pr.PreviousBestActivityID != efforts[0].ID || pr.ElapsedSeconds >= pr.PreviousBestSeconds {
+				t.Fatalf("PR = %+v, want it to beat activity %d", pr, efforts[0].ID)
+			}
+			return
+		}
+	}
+	t.Fatalf("PRs = %+v, want one on segment %d", prs, segmentID)
+}
+
+func containsSegmentPR(prs []SegmentPR, segmentID int64) bool {
+	for _, pr := range prs {
+		if pr.SegmentID == segmentID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SegmentCacheRefresh reports the progress of RefreshSegmentCachesForActivities
+type SegmentCacheRefresh struct {
+	Activities    int   `json:"activities"`
+	Resimplified  int64 `json:"resimplified"`
+	SegmentsDone  int   `json:"segments_done"`
+	SegmentsTotal int   `json:"segments_total"`
+	Efforts       int   `json:"efforts"`
+}
+
+// RefreshMissingSimplified simplifies the routes of the given activities that have no
+// simplified route yet, e.g. because the import skipped it or it failed. It returns how
+// many routes it simplified.
+func RefreshMissingSimplified(ctx context.Context, conn DB, athleteID int64, activityIDs []int64) (int64, error) {
+	if len(activityIDs) == 0 {
+		return 0, nil
+	}
+	tag, err := conn.Exec(ctx, `
+		UPDATE activity_geometries
+		SET route_geog_simplified = simplify_route_geog_meters(route_geog, $3)
+		WHERE athlete_id = $1 AND activity_id = ANY($2) AND route_geog_simplified IS NULL
+	`, athleteID, activityIDs, SimplifyTolerance())
+	if err != nil {
+		return 0, fmt.Errorf("failed to simplify imported routes: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RefreshSegmentCacheForActivities adds the given activities of the athlete to the
+// segment's match cache and measures their efforts, so the segment page does not have to
+// after a bulk import. A segment never scanned at this tolerance is matched against every
+// activity instead. The segment's cache counts as fresh afterwards. It returns the number
+// of efforts cached for the activities.
+func RefreshSegmentCacheForActivities(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters float64, activityIDs []int64) (int, error) {
+	scanned, err := segmentMatchCacheScanned(ctx, conn, segmentID, toleranceMeters)
+	if err != nil {
+		return 0, err
+	}
+	if !scanned {
+		efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segmentID, toleranceMeters, "", true, 0, SegmentEffortFilter{})
+		if err != nil {
+			return 0, err
+		}
+		return len(efforts), nil
+	}
+
+	matches, err := matchActivitiesToSegment(ctx, conn, athleteID, segmentID, toleranceMeters, activityIDs)
+	if err != nil {
+		return 0, err
+	}
+	efforts := 0
+	for _, match := range matches {
+		entries, err := EnsureSegmentActivityEfforts(ctx, conn, athleteID, segmentID, match.ActivityID, toleranceMeters)
+		if err != nil {
+			return efforts, fmt.Errorf("failed to measure activity %d on segment %d: %w", match.ActivityID, segmentID, err)
+		}
+		efforts += len(entries)
+	}
+	return efforts, nil
+}
+
+// matchActivitiesToSegment adds those of the activities matching the segment to its match
+// cache and marks the cache fresh
+func matchActivitiesToSegment(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters float64, activityIDs []int64) ([]SegmentMatchResult, error) {
+	matches, err := FindRoutePartsMatchingSegmentInActivities(ctx, conn, athleteID, segmentID, toleranceMeters, activityIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := CacheSegmentActivityMatches(ctx, conn, segmentID, toleranceMeters, matches); err != nil {
+		return nil, err
+	}
+	if err := markSegmentMatchCacheRefreshed(ctx, conn, segmentID, toleranceMeters); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// MatchActivitiesToSegments adds new activities of the athlete to the match cache of each
+// of the athlete's segments at its effective tolerance, so they are listed on segment
+// pages without a full re-scan. Only the new activities are matched. Segments never
+// scanned at that tolerance are skipped: their first page visit matches every activity. Efforts are measured when a page first shows them. progress, when set, is
+// called after each segment. It returns how many segment matches were cached.
+func MatchActivitiesToSegments(ctx context.Context, conn DB, athleteID int64, activityIDs []int64, athleteDefaultToleranceM *float64, progress func(done, total int)) (int, error) {
+	defer timeQuery("match_new_activities", time.Now())
+	if len(activityIDs) == 0 {
+		return 0, nil
+	}
+	segments, err := ListFavoriteSegments(ctx, conn, athleteID)
+	if err != nil {
+		return 0, err
+	}
+	matched := 0
+	for i, segment := range segments {
+		if err := ctx.Err(); err != nil {
+			return matched, err
+		}
+		tolerance, _ := ResolveTolerance(nil, segment.DefaultToleranceM, athleteDefaultToleranceM)
+		scanned, err := segmentMatchCacheScanned(ctx, conn, segment.ID, tolerance)
+		if err != nil {
+			return matched, err
+		}
+		if scanned {
+			matches, err := matchActivitiesToSegment(ctx, conn, athleteID, segment.ID, tolerance, activityIDs)
+			if err != nil {
+				return matched, fmt.Errorf("failed to match activities to segment %d: %w", segment.ID, err)
+			}
+			matched += len(matches)
+		}
+		if progress != nil {
+			progress(i+1, len(segments))
+		}
+	}
+	return matched, nil
+}
+
+// RefreshSegmentCachesForActivities runs after a bulk import: it simplifies any imported
+// route left without a simplified copy, then refreshes every favorite segment of the
+// athlete at its effective tolerance for the new activities. pause is waited between
+// segments so the refresh does not monopolize the database; progress, when set, is called
+// after each step.
+func RefreshSegmentCachesForActivities(ctx context.Context, conn DB, athleteID int64, activityIDs []int64, athleteDefaultToleranceM *float64, pause time.Duration, progress func(SegmentCacheRefresh)) (SegmentCacheRefresh, error) {
+	refresh := SegmentCacheRefresh{Activities: len(activityIDs)}
+	report := func() {
+		if progress != nil {
+			progress(refresh)
+		}
+	}
+	if len(activityIDs) == 0 {
+		return refresh, nil
+	}
+
+	resimplified, err := RefreshMissingSimplified(ctx, conn, athleteID, activityIDs)
+	if err != nil {
+		return refresh, err
+	}
+	refresh.Resimplified = resimplified
+
+	segments, err := ListFavoriteSegments(ctx, conn, athleteID)
+	if err != nil {
+		return refresh, err
+	}
+	refresh.SegmentsTotal = len(segments)
+	report()
+
+	for i, segment := range segments {
+		if i > 0 && pause > 0 {
+			select {
+			case <-time.After(pause):
+			case <-ctx.Done():
+				return refresh, ctx.Err()
+			}
+		}
+		tolerance, _ := ResolveTolerance(nil, segment.DefaultToleranceM, athleteDefaultToleranceM)
+		efforts, err := RefreshSegmentCacheForActivities(ctx, conn, athleteID, segment.ID, tolerance, activityIDs)
+		refresh.Efforts += efforts
+		if err != nil {
+			return refresh, fmt.Errorf("failed to refresh segment %d: %w", segment.ID, err)
+		}
+		refresh.SegmentsDone++
+		report()
+	}
+	return refresh, nil
+}
@@ -0,0 +1,191 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSegmentCacheRefreshWarmsSegmentAfterBulkImport(t *testing.T) {
+	setupCtx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	opts := DefaultSeedOptions()
+	opts.Athletes = 1
+	opts.ActivitiesPerAthlete = 12
+	opts.SegmentsPerAthlete = 1
+	opts.Seed = 11
+	seeded, err := SeedDemoData(setupCtx, conn, opts)
+	if err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	athleteID := seeded.AthleteIDs[0]
+	var segmentID int64
+	if err := conn.QueryRow(setupCtx, `
+		SELECT id FROM favorite_segments WHERE athlete_id = $1 AND source = $2
+	`, athleteID, SourceSeed).Scan(&segmentID); err != nil {
+		t.Fatalf("find seeded segment: %v", err)
+	}
+
+	full, err := GetActivitiesForSegment(setupCtx, conn, athleteID, segmentID, DefaultSegmentToleranceM, "", true, 0, SegmentEffortFilter{})
+	if err != nil {
+		t.Fatalf("full match: %v", err)
+	}
+	matched := segmentActivityIDs(full)
+	if len(matched) < 2 {
+		t.Fatalf("seed matched %d activities, need at least 2", len(matched))
+	}
+
+	// Pretend every match but the oldest arrived in a bulk import after the cache was
+	// last computed, more than an hour ago
+	imported := matched[1:]
+	if _, err := conn.Exec(setupCtx, `
+		DELETE FROM segment_activity_matches WHERE segment_id = $1 AND activity_id = ANY($2)
+	`, segmentID, imported); err != nil {
+		t.Fatalf("drop imported matches: %v", err)
+	}
+	if _, err := conn.Exec(setupCtx, `
+		UPDATE segment_match_cache_state SET refreshed_at = NOW() - INTERVAL '2 hours' WHERE segment_id = $1
+	`, segmentID); err != nil {
+		t.Fatalf("age cache: %v", err)
+	}
+
+	var updates []SegmentCacheRefresh
+	refresh, err := RefreshSegmentCachesForActivities(setupCtx, conn, athleteID, imported, nil, 0, func(progress SegmentCacheRefresh) {
+		updates = append(updates, progress)
+	})
+	if err != nil {
+		t.Fatalf("RefreshSegmentCachesForActivities: %v", err)
+	}
+	if refresh.SegmentsDone != 1 || refresh.SegmentsTotal != 1 || refresh.Efforts < len(imported) {
+		t.Fatalf("refresh = %+v, want 1 segment and at least %d efforts", refresh, len(imported))
+	}
+	if len(updates) == 0 || updates[len(updates)-1].SegmentsDone != 1 {
+		t.Fatalf("progress updates = %+v", updates)
+	}
+
+	pool := tracedIntegrationPool(t)
+	ctx, statements := WithStatementLog(context.Background())
+	page, err := GetActivitiesForSegment(ctx, pool, athleteID, segmentID, DefaultSegmentToleranceM, "", false, 0, SegmentEffortFilter{})
+	if err != nil {
+		t.Fatalf("segment page: %v", err)
+	}
+	for _, statement := range statements.Statements() {
+		if strings.Contains(statement, "find_route_parts_matching_segment") || strings.Contains(statement, "find_segment_traversals") {
+			t.Fatalf("segment page recomputed matches after the refresh:\n  %s", strings.Join(statements.Statements(), "\n  "))
+		}
+	}
+	if got := segmentActivityIDs(page); len(got) != len(matched) {
+		t.Fatalf("segment page shows activities %v, want %v", got, matched)
+	}
+	if len(page) != len(full) {
+		t.Fatalf("segment page shows %d efforts, want %d", len(page), len(full))
+	}
+}
+
+func TestMatchActivitiesToSegmentsExtendsOnlyCachedSegments(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	opts := DefaultSeedOptions()
+	opts.Athletes = 1
+	opts.ActivitiesPerAthlete = 12
+	opts.SegmentsPerAthlete = 2
+	opts.Seed = 11
+	seeded, err := SeedDemoData(ctx, conn, opts)
+	if err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	athleteID := seeded.AthleteIDs[0]
+	segments, err := ListFavoriteSegments(ctx, conn, athleteID)
+	if err != nil || len(segments) != 2 {
+		t.Fatalf("seeded segments = %+v, %v; want 2", segments, err)
+	}
+	warm, cold := segments[0].ID, segments[1].ID
+
+	full, err := GetActivitiesForSegment(ctx, conn, athleteID, warm, DefaultSegmentToleranceM, "", true, 0, SegmentEffortFilter{})
+	if err != nil {
+		t.Fatalf("full match: %v", err)
+	}
+	matched := segmentActivityIDs(full)
+	if len(matched) < 2 {
+		t.Fatalf("seed matched %d activities, need at least 2", len(matched))
+	}
+	// Pretend the newest matches arrived in a sync after the cache was computed
+	synced := matched[1:]
+	if _, err := conn.Exec(ctx, `
+		DELETE FROM segment_activity_matches WHERE segment_id = $1 AND activity_id = ANY($2)
+	`, warm, synced); err != nil {
+		t.Fatalf("drop synced matches: %v", err)
+	}
+	if err := InvalidateSegmentCache(ctx, conn, cold); err != nil {
+		t.Fatalf("clear cold segment: %v", err)
+	}
+
+	var progress []int
+	count, err := MatchActivitiesToSegments(ctx, conn, athleteID, synced, nil, func(done, total int) {
+		if total != 2 {
+			t.Fatalf("progress total = %d, want 2", total)
+		}
+		progress = append(progress, done)
+	})
+	if err != nil {
+		t.Fatalf("MatchActivitiesToSegments: %v", err)
+	}
+	if count < len(synced) {
+		t.Fatalf("matched %d, want at least %d", count, len(synced))
+	}
+	if len(progress) != 2 || progress[1] != 2 {
+		t.Fatalf("progress = %v, want both segments reported", progress)
+	}
+
+	cached, _, ok, err := getCachedSegmentMatches(ctx, conn, warm, DefaultSegmentToleranceM, 0)
+	if err != nil || !ok {
+		t.Fatalf("cached matches: %v, fresh %v", err, ok)
+	}
+	inCache := make(map[int64]bool)
+	for _, match := range cached {
+		inCache[match.ActivityID] = true
+	}
+	for _, id := range matched {
+		if !inCache[id] {
+			t.Fatalf("activity %d missing from the warm segment cache %+v", id, cached)
+		}
+	}
+	var coldRows int
+	if err := conn.QueryRow(ctx, "SELECT COUNT(*) FROM segment_activity_matches WHERE segment_id = $1", cold).Scan(&coldRows); err != nil {
+		t.Fatalf("count cold rows: %v", err)
+	}
+	if coldRows != 0 {
+		t.Fatalf("uncached segment got %d rows, want it left for its first page visit", coldRows)
+	}
+}
+
+// segmentActivityIDs returns the distinct activities of efforts, oldest ID first
+func segmentActivityIDs(efforts []ActivityWithMatch) []int64 {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, effort := range efforts {
+		if !seen[effort.ID] {
+			seen[effort.ID] = true
+			ids = append(ids, effort.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,53 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimelineMinOverlapPercentage is how much of a segment a cached match has to cover to
+// count as an effort on the timeline; partial passes would distort the trend
+const TimelineMinOverlapPercentage = 90.0
+
+// SegmentTimelineEffort is one cached traversal of a segment with the activity's start.
+// Metrics are nil when the match cache has no positive value for them.
+type SegmentTimelineEffort struct {
+	ActivityID     int64
+	EffortNumber   int
+	StartDate      time.Time
+	ElapsedSeconds *float64
+	AvgSpeed       *float64
+	AvgHR          *float64
+}
+
+// GetSegmentTimeline returns the athlete's cached forward efforts on a segment at
+// toleranceMeters covering at least minOverlapPercentage of it, oldest first. It reads only the match
+// cache, so efforts appear once the segment's matches have been found with projected coverage.
+func GetSegmentTimeline(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters, minOverlapPercentage float64) ([]SegmentTimelineEffort, error) {
+	rows, err := conn.Query(ctx, `
+	SELECT m.activity_id, m.effort_number, a.start_date,
+		   NULLIF(m.elapsed_seconds, 0), NULLIF(m.avg_speed, 0), NULLIF(m.avg_hr, 0)
+	FROM segment_activity_matches m
+	INNER JOIN activity_summaries a ON a.id = m.activity_id
+	WHERE a.athlete_id = $1 AND m.segment_id = $2 AND m.tolerance_meters = $3
+	  AND m.direction_checked = TRUE AND m.direction = 'forward'
+	  AND m.overlap_method = 'projected' AND m.overlap_percentage >= $4
+	ORDER BY a.start_date, m.activity_id, m.effort_number
+	`, athleteID, segmentID, toleranceMeters, minOverlapPercentage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query segment timeline: %w", err)
+	}
+	defer rows.Close()
+
+	efforts := []SegmentTimelineEffort{}
+	for rows.Next() {
+		var effort SegmentTimelineEffort
+		if err := rows.Scan(&effort.ActivityID, &effort.EffortNumber, &effort.StartDate,
+			&effort.ElapsedSeconds, &effort.AvgSpeed, &effort.AvgHR); err != nil {
+			return nil, fmt.Errorf("failed to scan segment timeline effort: %w", err)
+		}
+		efforts = append(efforts, effort)
+	}
+	return efforts, rows.Err()
+}
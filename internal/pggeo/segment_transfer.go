@@ -0,0 +1,160 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SegmentImportSkip leaves out imported segments whose name the athlete already uses
+	SegmentImportSkip = "skip"
+	// SegmentImportSuffix imports them as "Name (2)", "Name (3)" and so on
+	SegmentImportSuffix = "suffix"
+
+	// maxSegmentNameSuffix bounds the suffixes tried for one imported name
+	maxSegmentNameSuffix = 100
+)
+
+// ValidSegmentImportDuplicates reports whether onDuplicate is one of the SegmentImport* modes
+func ValidSegmentImportDuplicates(onDuplicate string) bool {
+	return onDuplicate == SegmentImportSkip || onDuplicate == SegmentImportSuffix
+}
+
+// GetSegmentsGeoJSON returns the athlete's segments as a GeoJSON FeatureCollection of
+// LineStrings ordered by name. The properties carry what ImportFavoriteSegments needs to
+// recreate each segment as it was, elevation included.
+func GetSegmentsGeoJSON(ctx context.Context, conn DB, athleteID int64) (string, error) {
+	var geoJSON string
+	err := conn.QueryRow(ctx, `
+	SELECT json_build_object(
+		'type', 'FeatureCollection',
+		'features', COALESCE(json_agg(json_build_object(
+			'type', 'Feature',
+			'geometry', ST_AsGeoJSON(fs.segment_geog::geometry)::json,
+			'properties', json_build_object(
+				'name', fs.name,
+				'description', fs.description,
+				'elevation_gain_m', fs.elevation_gain_m,
+				'elevation_loss_m', fs.elevation_loss_m,
+				'net_elevation_m', fs.net_elevation_m,
+				'default_tolerance_m', fs.default_tolerance_m,
+				'pinned', fs.pinned
+			)
+		) ORDER BY fs.name, fs.id), '[]'::json)
+	)::text
+	FROM favorite_segments fs
+	WHERE fs.athlete_id = $1
+	`, athleteID).Scan(&geoJSON)
+	if err != nil {
+		return "", fmt.Errorf("failed to export segments: %w", err)
+	}
+	return geoJSON, nil
+}
+
+// SegmentImport is one segment to import. Points are [lat, lng] pairs; Altitudes, when
+// given, holds one altitude per point and measures the elevation unless the elevation
+// fields bring it ready-made, as in a GetSegmentsGeoJSON export.
+type SegmentImport struct {
+	Name              string
+	Description       string
+	Points            [][]float64
+	Altitudes         []*float64
+	ElevationGainM    *float64
+	ElevationLossM    *float64
+	NetElevationM     *float64
+	DefaultToleranceM *float64
+	Pinned            bool
+}
+
+// SegmentImportItem is the outcome of importing one segment
+type SegmentImportItem struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	SegmentID int64  `json:"segment_id,omitempty"`
+	Status    string `json:"status"` // created, skipped or failed
+	Error     string `json:"error,omitempty"`
+}
+
+// SegmentImportResult counts what ImportFavoriteSegments did, with one item per segment
+type SegmentImportResult struct {
+	Created int                 `json:"created"`
+	Skipped int                 `json:"skipped"`
+	Failed  int                 `json:"failed"`
+	Items   []SegmentImportItem `json:"results"`
+}
+
+// ImportFavoriteSegments creates the segments one by one with InsertFavoriteSegment.
+// A name the athlete already uses is skipped or suffixed as onDuplicate says; a segment
+// refused as invalid is reported failed and the rest still imported. Database errors stop
+// the import, keeping the segments created before.
+func ImportFavoriteSegments(ctx context.Context, conn DB, athleteID int64, segments []SegmentImport, onDuplicate string) (*SegmentImportResult, error) {
+	if !ValidSegmentImportDuplicates(onDuplicate) {
+		return nil, invalidInputf("on_duplicate must be %s or %s", SegmentImportSkip, SegmentImportSuffix)
+	}
+	result := &SegmentImportResult{Items: make([]SegmentImportItem, 0, len(segments))}
+	for i, imported := range segments {
+		item := SegmentImportItem{Index: i, Name: imported.Name}
+		segment, err := importFavoriteSegment(ctx, conn, athleteID, imported, onDuplicate)
+		switch {
+		case err == nil:
+			item.Name, item.SegmentID, item.Status = segment.Name, segment.ID, "created"
+			result.Created++
+		case errors.Is(err, ErrSegmentNameExists):
+			item.Status, item.Error = "skipped", "segment name already exists"
+			result.Skipped++
+		case errors.Is(err, ErrInvalidInput):
+			item.Status, item.Error = "failed", err.Error()
+			result.Failed++
+		default:
+			return result, fmt.Errorf("failed to import segment %q: %w", imported.Name, err)
+		}
+		result.Items = append(result.Items, item)
+	}
+	return result, nil
+}
+
+// importFavoriteSegment inserts one segment, trying suffixed names when asked, and restores
+// the elevation and pin the import brought along
+func importFavoriteSegment(ctx context.Context, conn DB, athleteID int64, imported SegmentImport, onDuplicate string) (*FavoriteSegment, error) {
+	if imported.Name == "" {
+		return nil, invalidInputf("name is required")
+	}
+	if imported.Altitudes != nil && len(imported.Altitudes) != len(imported.Points) {
+		return nil, invalidInputf("got %d altitudes for %d points", len(imported.Altitudes), len(imported.Points))
+	}
+	var samples []PointSample
+	for i, altitude := range imported.Altitudes {
+		samples = append(samples, PointSample{PointIndex: i, Lat: imported.Points[i][0], Lng: imported.Points[i][1], Altitude: altitude})
+	}
+
+	name := imported.Name
+	var segment *FavoriteSegment
+	for suffix := 2; ; suffix++ {
+		var err error
+		segment, err = InsertFavoriteSegment(ctx, conn, athleteID, name, imported.Description, imported.Points, samples, imported.DefaultToleranceM)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrSegmentNameExists) || onDuplicate != SegmentImportSuffix || suffix > maxSegmentNameSuffix {
+			return nil, err
+		}
+		name = fmt.Sprintf("%s (%d)", imported.Name, suffix)
+	}
+
+	if imported.ElevationGainM == nil && imported.ElevationLossM == nil && imported.NetElevationM == nil && !imported.Pinned {
+		return segment, nil
+	}
+	if imported.ElevationGainM != nil || imported.ElevationLossM != nil || imported.NetElevationM != nil {
+		segment.ElevationGainM, segment.ElevationLossM, segment.NetElevationM = imported.ElevationGainM, imported.ElevationLossM, imported.NetElevationM
+	}
+	segment.Pinned = imported.Pinned
+	if _, err := conn.Exec(ctx, `
+		UPDATE favorite_segments
+		SET elevation_gain_m = $3, elevation_loss_m = $4, net_elevation_m = $5, pinned = $6
+		WHERE id = $1 AND athlete_id = $2
+	`, segment.ID, athleteID, segment.ElevationGainM, segment.ElevationLossM, segment.NetElevationM, segment.Pinned); err != nil {
+		return nil, fmt.Errorf("failed to restore elevation of segment %d: %w", segment.ID, err)
+	}
+	return segment, nil
+}
@@ -0,0 +1,102 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// segmentsExport reads back what GetSegmentsGeoJSON writes, the way the web importer does
+func segmentsExport(t *testing.T, raw string) []SegmentImport {
+	t.Helper()
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Type        string      `json:"type"`
+				Coordinates [][]float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				Name              string   `json:"name"`
+				Description       *string  `json:"description"`
+				ElevationGainM    *float64 `json:"elevation_gain_m"`
+				ElevationLossM    *float64 `json:"elevation_loss_m"`
+				NetElevationM     *float64 `json:"net_elevation_m"`
+				DefaultToleranceM *float64 `json:"default_tolerance_m"`
+				Pinned            bool     `json:"pinned"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(raw), &collection); err != nil {
+		t.Fatalf("export = %s: %v", raw, err)
+	}
+	segments := make([]SegmentImport, len(collection.Features))
+	for i, feature := range collection.Features {
+		if feature.Geometry.Type != "LineString" {
+			t.Fatalf("feature %d geometry = %s", i, feature.Geometry.Type)
+		}
+		props := feature.Properties
+		segments[i] = SegmentImport{Name: props.Name, ElevationGainM: props.ElevationGainM, ElevationLossM: props.ElevationLossM,
+			NetElevationM: props.NetElevationM, DefaultToleranceM: props.DefaultToleranceM, Pinned: props.Pinned}
+		if props.Description != nil {
+			segments[i].Description = *props.Description
+		}
+		for _, position := range feature.Geometry.Coordinates {
+			segments[i].Points = append(segments[i].Points, []float64{position[1], position[0]})
+		}
+	}
+	return segments
+}
+
+func TestSegmentsExportedAsGeoJSONImportBackTheSame(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000802)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM favorite_segments WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	low, high := 100.0, 130.0
+	tolerance := 25.0
+	created, err := ImportFavoriteSegments(ctx, conn, athleteID, []SegmentImport{
+		{Name: "Climb", Description: "to the pass", Points: [][]float64{{45.10, 6.90}, {45.11, 6.91}, {45.12, 6.92}},
+			Altitudes: []*float64{&low, nil, &high}, DefaultToleranceM: &tolerance, Pinned: true},
+		{Name: "Flat", Points: [][]float64{{45.00, 7.00}, {45.01, 7.00}}},
+		{Name: "Dot", Points: [][]float64{{45.00, 7.00}}},
+	}, SegmentImportSkip)
+	if err != nil || created.Created != 2 || created.Failed != 1 || created.Items[2].Status != "failed" {
+		t.Fatalf("ImportFavoriteSegments = %+v, %v", created, err)
+	}
+
+	exported, err := GetSegmentsGeoJSON(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("GetSegmentsGeoJSON: %v", err)
+	}
+	segments := segmentsExport(t, exported)
+	if len(segments) != 2 || segments[0].Name != "Climb" || segments[0].NetElevationM == nil || *segments[0].NetElevationM != 30 || !segments[0].Pinned {
+		t.Fatalf("exported segments = %+v", segments)
+	}
+
+	cleanup()
+	restored, err := ImportFavoriteSegments(ctx, conn, athleteID, segments, SegmentImportSkip)
+	if err != nil || restored.Created != 2 {
+		t.Fatalf("re-import = %+v, %v", restored, err)
+	}
+	if again, err := GetSegmentsGeoJSON(ctx, conn, athleteID); err != nil || again != exported {
+		t.Fatalf("export after re-import differs:\n%s\n%s (%v)", again, exported, err)
+	}
+
+	skipped, err := ImportFavoriteSegments(ctx, conn, athleteID, segments, SegmentImportSkip)
+	if err != nil || skipped.Created != 0 || skipped.Skipped != 2 {
+		t.Fatalf("import of taken names with skip = %+v, %v", skipped, err)
+	}
+	suffixed, err := ImportFavoriteSegments(ctx, conn, athleteID, segments[:1], SegmentImportSuffix)
+	if err != nil || suffixed.Created != 1 || suffixed.Items[0].Name != "Climb (2)" {
+		t.Fatalf("import of taken names with suffix = %+v, %v", suffixed, err)
+	}
+	if _, err := ImportFavoriteSegments(ctx, conn, athleteID, segments, "rename"); err == nil {
+		t.Fatal("an unknown duplicate mode was accepted")
+	}
+}
@@ -0,0 +1,267 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+// Three laps of a 200 m square sampled every 5 m; the segment is the middle of the north leg.
+// The second lap is ridden at half speed.
+const (
+	lapFixtureActivityID = -3
+	lapFixtureSegmentID  = -3
+	lapFixtureAthleteID  = -3
+	lapFixtureSide       = 40 // points per side
+	lapFixtureStepMeters = 5.0
+	lapFixtureLaps       = 3
+	lapFixtureSegStart   = 5
+	lapFixtureSegEnd     = 35
+	lapFixtureToleranceM = 10.0
+)
+
+func TestSegmentTraversalsSplitLaps(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), "DELETE FROM favorite_segments WHERE id = $1", int64(lapFixtureSegmentID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM point_samples WHERE activity_id = $1", int64(lapFixtureActivityID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_geometries WHERE activity_id = $1", int64(lapFixtureActivityID))
+		_, _ = conn.Exec(context.Background(), "DELETE FROM activity_summaries WHERE id = $1", int64(lapFixtureActivityID))
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// North, east, south, west legs of the square, repeated per lap
+	legs := [][2]float64{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+	var lons, lats, seconds []float64
+	north, east, clock := 0.0, 0.0, 0.0
+	for lap := 0; lap < lapFixtureLaps; lap++ {
+		step := 1.0
+		if lap == 1 {
+			step = 2.0
+		}
+		for _, leg := range legs {
+			for i := 0; i < lapFixtureSide; i++ {
+				lats = append(lats, selfCheckOriginLat+north/metersPerDegreeLat45)
+				lons = append(lons, selfCheckOriginLon+east/metersPerDegreeLon45)
+				seconds = append(seconds, clock)
+				north += leg[0] * lapFixtureStepMeters
+				east += leg[1] * lapFixtureStepMeters
+				clock += step
+			}
+		}
+	}
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'lap fixture', $3, $4, $4, 0, 'Ride', NOW())`,
+			[]any{int64(lapFixtureActivityID), int64(lapFixtureAthleteID), float64(len(lons)) * lapFixtureStepMeters, seconds[len(seconds)-1]}},
+		{`INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+			VALUES ($1, $2, make_route_geog_from_lonlat($3, $4))`,
+			[]any{int64(lapFixtureActivityID), int64(lapFixtureAthleteID), lons, lats}},
+		{`INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location)
+			SELECT $1, $2, i - 1, TIMESTAMPTZ '2024-05-01 08:00:00Z' + make_interval(secs => ($5::DOUBLE PRECISION[])[i]),
+				ST_SetSRID(ST_MakePoint(($3::DOUBLE PRECISION[])[i], ($4::DOUBLE PRECISION[])[i]), 4326)::GEOGRAPHY
+			FROM generate_subscripts($3::DOUBLE PRECISION[], 1) AS i`,
+			[]any{int64(lapFixtureActivityID), int64(lapFixtureAthleteID), lons, lats, seconds}},
+		{`INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+			VALUES ($1, $2, 'lap fixture', make_route_geog_from_lonlat($3, $4))`,
+			[]any{int64(lapFixtureSegmentID), int64(lapFixtureAthleteID),
+				lons[lapFixtureSegStart : lapFixtureSegEnd+1], lats[lapFixtureSegStart : lapFixtureSegEnd+1]}},
+	}
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("insert lap fixture: %v", err)
+		}
+	}
+
+	traversals, err := FindSegmentTraversals(ctx, conn, lapFixtureAthleteID, lapFixtureActivityID, lapFixtureSegmentID, lapFixtureToleranceM)
+	if err != nil {
+		t.Fatalf("FindSegmentTraversals: %v", err)
+	}
+	if len(traversals) != lapFixtureLaps {
+		t.Fatalf("traversals = %+v, want one per lap", traversals)
+	}
+	lapPoints := 4 * lapFixtureSide
+	for i, traversal := range traversals {
+		wantStart, wantEnd := i*lapPoints+lapFixtureSegStart, i*lapPoints+lapFixtureSegEnd
+		if traversal.EffortNumber != i+1 || traversal.StartIndex != wantStart || traversal.EndIndex != wantEnd || traversal.Direction != SegmentDirectionForward {
+			t.Fatalf("traversal %d = %+v, want forward effort %d over %d..%d", i, traversal, i+1, wantStart, wantEnd)
+		}
+	}
+
+	efforts, err := EnsureSegmentActivityEfforts(ctx, conn, lapFixtureAthleteID, lapFixtureSegmentID, lapFixtureActivityID, lapFixtureToleranceM)
+	if err != nil || len(efforts) != lapFixtureLaps {
+		t.Fatalf("EnsureSegmentActivityEfforts = %d efforts, %v; want %d", len(efforts), err, lapFixtureLaps)
+	}
+	wantSeconds := []float64{30, 60, 30}
+	for i, effort := range efforts {
+		if math.Abs(*effort.ElapsedSeconds-wantSeconds[i]) > 1e-6 {
+			t.Fatalf("effort %d took %.1fs, want %.0fs", i+1, *effort.ElapsedSeconds, wantSeconds[i])
+		}
+		// Average speed is distance over elapsed time; the fixture has no speed samples
+		if want := *effort.DistanceM / wantSeconds[i]; math.Abs(*effort.AvgSpeed-want) > 1e-6 {
+			t.Fatalf("effort %d averaged %.3f m/s, want distance over elapsed time %.3f m/s", i+1, *effort.AvgSpeed, want)
+		}
+	}
+	cached, err := GetCachedSegmentActivityEfforts(ctx, conn, lapFixtureSegmentID, lapFixtureActivityID, lapFixtureToleranceM)
+	if complete, count := segmentEffortsComplete(cached); err != nil || !complete || count != lapFixtureLaps {
+		t.Fatalf("cached efforts = %d rows (complete %v), %v; want %d", len(cached), complete, err, lapFixtureLaps)
+	}
+
+	rows, err := GetActivitiesForSegment(ctx, conn, lapFixtureAthleteID, lapFixtureSegmentID, lapFixtureToleranceM, "time", true, 0, SegmentEffortFilter{})
+	if err != nil {
+		t.Fatalf("GetActivitiesForSegment: %v", err)
+	}
+	if len(rows) != lapFixtureLaps {
+		t.Fatalf("effort rows = %d, want one per lap", len(rows))
+	}
+	if rows[len(rows)-1].EffortNumber != 2 || rows[0].EffortCount != lapFixtureLaps {
+		t.Fatalf("slowest effort = %d of %d, want the second lap of %d", rows[len(rows)-1].EffortNumber, rows[0].EffortCount, lapFixtureLaps)
+	}
+
+	// The graph for the second lap covers only that lap
+	graph, err := GetGraphDataForSegmentInActivity(ctx, conn, lapFixtureAthleteID, lapFixtureActivityID, lapFixtureSegmentID, lapFixtureToleranceM, 2, []string{"speed"}, false, nil)
+	if err != nil {
+		t.Fatalf("GetGraphDataForSegmentInActivity: %v", err)
+	}
+	if graph.Timing != nil {
+		t.Fatalf("second lap timing = %+v, want regular", graph.Timing)
+	}
+	if _, err := GetGraphDataForSegmentInActivity(ctx, conn, lapFixtureAthleteID, lapFixtureActivityID, lapFixtureSegmentID, lapFixtureToleranceM, 4, []string{"speed"}, false, nil); err == nil {
+		t.Fatal("a fourth effort should not exist")
+	}
+}
+
+// Activities on a straight 500 m line north sampled every 5 m; the segment is 100..300 m
+const (
+	outAndBackAthleteID  = -4
+	outAndBackSegmentID  = -4
+	outAndBackPoints     = 100
+	outAndBackSegStart   = 20
+	outAndBackSegEnd     = 60
+	outAndBackToleranceM = 10.0
+)
+
+// insertOutAndBackActivity stores an activity riding north to point turnAt of the line and
+// straight back to the start
+func insertOutAndBackActivity(t *testing.T, ctx context.Context, conn DB, activityID int64, turnAt int) {
+	t.Helper()
+	var lons, lats []float64
+	for i := 0; i <= turnAt; i++ {
+		lats = append(lats, selfCheckOriginLat+float64(i)*lapFixtureStepMeters/metersPerDegreeLat45)
+		lons = append(lons, selfCheckOriginLon)
+	}
+	for i := turnAt - 1; i >= 0; i-- {
+		lats = append(lats, lats[i])
+		lons = append(lons, selfCheckOriginLon)
+	}
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'out and back', $3, $4, $4, 0, 'Ride', NOW())`,
+			[]any{activityID, int64(outAndBackAthleteID), float64(len(lons)) * lapFixtureStepMeters, float64(len(lons))}},
+		{`INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+			VALUES ($1, $2, make_route_geog_from_lonlat($3, $4))`,
+			[]any{activityID, int64(outAndBackAthleteID), lons, lats}},
+		{`INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location)
+			SELECT $1, $2, i - 1, TIMESTAMPTZ '2024-05-01 08:00:00Z' + make_interval(secs => i),
+				ST_SetSRID(ST_MakePoint(($3::DOUBLE PRECISION[])[i], ($4::DOUBLE PRECISION[])[i]), 4326)::GEOGRAPHY
+			FROM generate_subscripts($3::DOUBLE PRECISION[], 1) AS i`,
+			[]any{activityID, int64(outAndBackAthleteID), lons, lats}},
+	}
+	for _, stmt := range statements {
+		if _, err := conn.Exec(ctx, stmt.query, stmt.args...); err != nil {
+			t.Fatalf("insert out-and-back activity %d: %v", activityID, err)
+		}
+	}
+}
+
+func TestSegmentTraversalsOutAndBack(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const (
+		pastEndID   = int64(-41) // turns well past the segment end
+		atEndID     = int64(-42) // turns at the segment end, one run of near points
+		clippedID   = int64(-43) // turns halfway along the segment
+		pastEndTurn = outAndBackPoints - 1
+	)
+	activityIDs := []int64{pastEndID, atEndID, clippedID}
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), "DELETE FROM favorite_segments WHERE id = $1", int64(outAndBackSegmentID))
+		for _, id := range activityIDs {
+			_, _ = conn.Exec(context.Background(), "DELETE FROM point_samples WHERE activity_id = $1", id)
+			_, _ = conn.Exec(context.Background(), "DELETE FROM activity_geometries WHERE activity_id = $1", id)
+			_, _ = conn.Exec(context.Background(), "DELETE FROM activity_summaries WHERE id = $1", id)
+		}
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	insertOutAndBackActivity(t, ctx, conn, pastEndID, pastEndTurn)
+	insertOutAndBackActivity(t, ctx, conn, atEndID, outAndBackSegEnd)
+	insertOutAndBackActivity(t, ctx, conn, clippedID, (outAndBackSegStart+outAndBackSegEnd)/2)
+
+	var segLons, segLats []float64
+	for i := outAndBackSegStart; i <= outAndBackSegEnd; i++ {
+		segLats = append(segLats, selfCheckOriginLat+float64(i)*lapFixtureStepMeters/metersPerDegreeLat45)
+		segLons = append(segLons, selfCheckOriginLon)
+	}
+	if _, err := conn.Exec(ctx, `INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+		VALUES ($1, $2, 'out and back fixture', make_route_geog_from_lonlat($3, $4))`,
+		int64(outAndBackSegmentID), int64(outAndBackAthleteID), segLons, segLats); err != nil {
+		t.Fatalf("insert segment: %v", err)
+	}
+
+	for _, tc := range []struct {
+		activityID int64
+		turnAt     int
+	}{{pastEndID, pastEndTurn}, {atEndID, outAndBackSegEnd}} {
+		traversals, err := FindSegmentTraversals(ctx, conn, outAndBackAthleteID, tc.activityID, outAndBackSegmentID, outAndBackToleranceM)
+		if err != nil {
+			t.Fatalf("FindSegmentTraversals(%d): %v", tc.activityID, err)
+		}
+		// The way back reaches line point i at index 2*turnAt - i
+		want := []SegmentTraversal{
+			{EffortNumber: 1, StartIndex: outAndBackSegStart, EndIndex: outAndBackSegEnd, Direction: SegmentDirectionForward},
+			{EffortNumber: 2, StartIndex: 2*tc.turnAt - outAndBackSegEnd, EndIndex: 2*tc.turnAt - outAndBackSegStart, Direction: SegmentDirectionReverse},
+		}
+		if len(traversals) != len(want) || traversals[0] != want[0] || traversals[1] != want[1] {
+			t.Fatalf("activity %d traversals = %+v, want %+v", tc.activityID, traversals, want)
+		}
+	}
+
+	clipped, err := FindSegmentTraversals(ctx, conn, outAndBackAthleteID, clippedID, outAndBackSegmentID, outAndBackToleranceM)
+	if err != nil || len(clipped) != 0 {
+		t.Fatalf("clipped pass traversals = %+v, %v; want none", clipped, err)
+	}
+
+	for _, tc := range []struct {
+		filter SegmentEffortFilter
+		want   int
+	}{
+		{SegmentEffortFilter{}, 4},
+		{ForwardSegmentEfforts, 2},
+		{SegmentEffortFilter{Direction: SegmentDirectionReverse}, 2},
+		{SegmentEffortFilter{MinOverlapPercentage: 100.5}, 0},
+	} {
+		efforts, err := GetActivitiesForSegment(ctx, conn, outAndBackAthleteID, outAndBackSegmentID, outAndBackToleranceM, "date", true, 0, tc.filter)
+		if err != nil {
+			t.Fatalf("GetActivitiesForSegment(%+v): %v", tc.filter, err)
+		}
+		if len(efforts) != tc.want {
+			t.Fatalf("GetActivitiesForSegment(%+v) = %d efforts, want %d", tc.filter, len(efforts), tc.want)
+		}
+		for _, effort := range efforts {
+			if tc.filter.Direction != "" && effort.Direction != tc.filter.Direction {
+				t.Fatalf("effort %d.%d is %s, want only %s", effort.ID, effort.EffortNumber, effort.Direction, tc.filter.Direction)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRenamingSegmentKeepsGeometryAndCachedMatches(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	if _, err := SeedDemoData(ctx, conn, smallSeedOptions()); err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	var athleteID, segmentID int64
+	if err := conn.QueryRow(ctx, `SELECT athlete_id, id FROM favorite_segments WHERE source = $1 LIMIT 1`, SourceSeed).Scan(&athleteID, &segmentID); err != nil {
+		t.Fatalf("find seeded segment: %v", err)
+	}
+	before, err := GetFavoriteSegment(ctx, conn, athleteID, segmentID)
+	if err != nil {
+		t.Fatalf("GetFavoriteSegment: %v", err)
+	}
+	cachedMatches := func() int {
+		var n int
+		if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM segment_activity_matches WHERE segment_id = $1`, segmentID).Scan(&n); err != nil {
+			t.Fatalf("count cached matches: %v", err)
+		}
+		return n
+	}
+	cached := cachedMatches()
+	if cached == 0 {
+		t.Fatal("seeding should have cached the segment's matches")
+	}
+
+	renamed, err := UpdateFavoriteSegmentDetails(ctx, conn, athleteID, segmentID, "Renamed climb", "new description")
+	if err != nil {
+		t.Fatalf("UpdateFavoriteSegmentDetails: %v", err)
+	}
+	if renamed.Name != "Renamed climb" || renamed.Description == nil || *renamed.Description != "new description" {
+		t.Fatalf("renamed segment = %+v", renamed)
+	}
+	if renamed.SegmentGeog != before.SegmentGeog {
+		t.Fatal("rename changed the geometry")
+	}
+	if got := cachedMatches(); got != cached {
+		t.Fatalf("rename left %d cached matches, want %d", got, cached)
+	}
+}
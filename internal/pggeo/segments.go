@@ -2,13 +2,28 @@ package pggeo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+var (
+	// ErrSegmentNameExists is returned when the athlete already has a segment with the name
+	ErrSegmentNameExists error = &Error{Kind: ErrAlreadyExists, Msg: "segment name already exists"}
+	// ErrSegmentNameAmbiguous is returned when a lookup by name finds several segments
+	ErrSegmentNameAmbiguous error = &Error{Kind: ErrInvalidInput, Msg: "segment name is ambiguous"}
+)
+
+// isSegmentNameConflict reports whether err violates the (athlete_id, name) uniqueness
+func isSegmentNameConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_favorite_segments_athlete_name_unique"
+}
+
 // FavoriteSegment represents a favorite segment
 type FavoriteSegment struct {
 	ID                    int64    `json:"id"`
@@ -20,6 +35,8 @@ type FavoriteSegment struct {
 	ElevationGainM        *float64 `json:"elevation_gain_m,omitempty"`
 	ElevationLossM        *float64 `json:"elevation_loss_m,omitempty"`
 	NetElevationM         *float64 `json:"net_elevation_m,omitempty"`
+	DefaultToleranceM     *float64 `json:"default_tolerance_m,omitempty"`
+	Pinned                bool     `json:"pinned"`
 	CreatedAt             string   `json:"created_at"`
 	UpdatedAt             string   `json:"updated_at"`
 }
@@ -34,6 +51,15 @@ type SegmentMatchResult struct {
 	OverlapPercentage float64 `json:"overlap_percentage"`
 }
 
+// SegmentOverlap is how much of a segment one activity covers. CoveragePercentage is the
+// projected coverage reported as overlap_percentage of matches; the buffered values measure
+// the segment inside a buffer of the route, which crossings and zigzags inflate.
+type SegmentOverlap struct {
+	CoveragePercentage float64 `json:"coverage_percentage"`
+	BufferedLengthM    float64 `json:"buffered_length_m"`
+	BufferedPercentage float64 `json:"buffered_percentage"`
+}
+
 // SegmentDashboardSummary is a compact, presentation-ready segment overview.
 type SegmentDashboardSummary struct {
 	ID            int64
@@ -60,13 +86,39 @@ type SegmentDashboardSummary struct {
 	SortAscent    float64
 	SortDirection string
 	SortName      string
+	ToleranceM    float64
+	Pinned        bool
+}
+
+// segmentElevationFromSamples measures the climbing and descending with
+// ComputeElevationGain; values are nil when the samples carry no usable altitude
+func segmentElevationFromSamples(pointSamples []PointSample) (gain, loss, net *float64) {
+	if len(pointSamples) == 0 {
+		return nil, nil, nil
+	}
+	totalGain, totalLoss, _ := ComputeElevationGain(pointSamples, DefaultElevationGainOptions)
+	if totalGain > 0 {
+		gain = &totalGain
+	}
+	if totalLoss > 0 {
+		loss = &totalLoss
+	}
+	if pointSamples[0].Altitude != nil && pointSamples[len(pointSamples)-1].Altitude != nil {
+		diff := *pointSamples[len(pointSamples)-1].Altitude - *pointSamples[0].Altitude
+		net = &diff
+	}
+	return gain, loss, net
 }
 
 // InsertFavoriteSegment inserts a new favorite segment
 // If pointSamples is provided, elevation gain will be calculated from them
-func InsertFavoriteSegment(ctx context.Context, conn *pgx.Conn, athleteID int64, name, description string, latLngData [][]float64, pointSamples []PointSample) (*FavoriteSegment, error) {
+// defaultToleranceM may be nil to fall back to the athlete or global tolerance
+func InsertFavoriteSegment(ctx context.Context, conn DB, athleteID int64, name, description string, latLngData [][]float64, pointSamples []PointSample, defaultToleranceM *float64) (*FavoriteSegment, error) {
 	if len(latLngData) < 2 {
-		return nil, fmt.Errorf("need at least 2 points to create a linestring")
+		return nil, invalidInputf("need at least 2 points to create a linestring")
+	}
+	if defaultToleranceM != nil && !ValidToleranceMeters(*defaultToleranceM) {
+		return nil, invalidInputf("invalid default tolerance %.2f", *defaultToleranceM)
 	}
 
 	// Extract longitude and latitude arrays for the helper function
@@ -79,41 +131,15 @@ func InsertFavoriteSegment(ctx context.Context, conn *pgx.Conn, athleteID int64,
 	}
 
 	// Calculate elevation gain from point samples if available
-	var elevationGain *float64
-	var elevationLoss *float64
-	var netElevation *float64
-	if len(pointSamples) > 0 {
-		totalGain := 0.0
-		totalLoss := 0.0
-		for i := 1; i < len(pointSamples); i++ {
-			if pointSamples[i].Altitude != nil && pointSamples[i-1].Altitude != nil {
-				diff := *pointSamples[i].Altitude - *pointSamples[i-1].Altitude
-				if diff > 0 {
-					totalGain += diff
-				} else if diff < 0 {
-					totalLoss += -diff
-				}
-			}
-		}
-		if totalGain > 0 {
-			elevationGain = &totalGain
-		}
-		if totalLoss > 0 {
-			elevationLoss = &totalLoss
-		}
-		if pointSamples[0].Altitude != nil && pointSamples[len(pointSamples)-1].Altitude != nil {
-			net := *pointSamples[len(pointSamples)-1].Altitude - *pointSamples[0].Altitude
-			netElevation = &net
-		}
-	}
+	elevationGain, elevationLoss, netElevation := segmentElevationFromSamples(pointSamples)
 
 	query := `
-	INSERT INTO favorite_segments (athlete_id, name, description, segment_geog, elevation_gain_m, elevation_loss_m, net_elevation_m)
-	VALUES ($1, $2, $3, make_route_geog_from_lonlat($4, $5), $6, $7, $8)
+	INSERT INTO favorite_segments (athlete_id, name, description, segment_geog, elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m)
+	VALUES ($1, $2, $3, make_route_geog_from_lonlat($4, $5), $6, $7, $8, $9)
 	RETURNING id, athlete_id, name, description, 
 		ST_AsText(segment_geog::geometry) as segment_geog,
 		ST_AsText(segment_geog_simplified::geometry) as segment_geog_simplified,
-		elevation_gain_m, elevation_loss_m, net_elevation_m,
+		elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m, pinned,
 		created_at::text, updated_at::text
 	`
 
@@ -123,20 +149,23 @@ func InsertFavoriteSegment(ctx context.Context, conn *pgx.Conn, athleteID int64,
 		desc = &description
 	}
 
-	err := conn.QueryRow(ctx, query, athleteID, name, desc, lons, lats, elevationGain, elevationLoss, netElevation).Scan(
+	err := conn.QueryRow(ctx, query, athleteID, name, desc, lons, lats, elevationGain, elevationLoss, netElevation, defaultToleranceM).Scan(
 		&segment.ID, &segment.AthleteID, &segment.Name, &segment.Description,
 		&segment.SegmentGeog, &segment.SegmentGeogSimplified,
-		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM,
+		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM, &segment.DefaultToleranceM, &segment.Pinned,
 		&segment.CreatedAt, &segment.UpdatedAt,
 	)
 
 	if err != nil {
+		if isSegmentNameConflict(err) {
+			return nil, fmt.Errorf("%w: %q", ErrSegmentNameExists, name)
+		}
 		return nil, fmt.Errorf("failed to insert favorite segment: %w", err)
 	}
 
-	// Refresh the simplified segment with default tolerance
-	refreshQuery := `SELECT refresh_segment_simplified($1)`
-	_, err = conn.Exec(ctx, refreshQuery, segment.ID)
+	// Refresh the simplified segment with the configured tolerance
+	refreshQuery := `SELECT refresh_segment_simplified($1, $2)`
+	_, err = conn.Exec(ctx, refreshQuery, segment.ID, SimplifyTolerance())
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh simplified segment: %w", err)
 	}
@@ -144,29 +173,30 @@ func InsertFavoriteSegment(ctx context.Context, conn *pgx.Conn, athleteID int64,
 	return &segment, nil
 }
 
-// GetFavoriteSegment retrieves a favorite segment by ID
-func GetFavoriteSegment(ctx context.Context, conn *pgx.Conn, segmentID int64) (*FavoriteSegment, error) {
+// GetFavoriteSegment retrieves one of the athlete's favorite segments by ID; another
+// athlete's segment is not found
+func GetFavoriteSegment(ctx context.Context, conn DB, athleteID, segmentID int64) (*FavoriteSegment, error) {
 	query := `
 	SELECT id, athlete_id, name, description,
 		ST_AsText(segment_geog::geometry) as segment_geog,
 		ST_AsText(segment_geog_simplified::geometry) as segment_geog_simplified,
-		elevation_gain_m, elevation_loss_m, net_elevation_m,
+		elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m, pinned,
 		created_at::text, updated_at::text
 	FROM favorite_segments
-	WHERE id = $1
+	WHERE id = $1 AND athlete_id = $2
 	`
 
 	var segment FavoriteSegment
-	err := conn.QueryRow(ctx, query, segmentID).Scan(
+	err := conn.QueryRow(ctx, query, segmentID, athleteID).Scan(
 		&segment.ID, &segment.AthleteID, &segment.Name, &segment.Description,
 		&segment.SegmentGeog, &segment.SegmentGeogSimplified,
-		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM,
+		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM, &segment.DefaultToleranceM, &segment.Pinned,
 		&segment.CreatedAt, &segment.UpdatedAt,
 	)
 
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("segment with ID %d not found", segmentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, segmentNotFoundf(err, "segment with ID %d not found", segmentID)
 		}
 		return nil, fmt.Errorf("failed to get favorite segment: %w", err)
 	}
@@ -175,12 +205,12 @@ func GetFavoriteSegment(ctx context.Context, conn *pgx.Conn, segmentID int64) (*
 }
 
 // GetFavoriteSegmentByName retrieves a favorite segment by name for a specific athlete
-func GetFavoriteSegmentByName(ctx context.Context, conn *pgx.Conn, athleteID int64, name string) (*FavoriteSegment, error) {
+func GetFavoriteSegmentByName(ctx context.Context, conn DB, athleteID int64, name string) (*FavoriteSegment, error) {
 	query := `
 	SELECT id, athlete_id, name, description,
 		ST_AsText(segment_geog::geometry) as segment_geog,
 		ST_AsText(segment_geog_simplified::geometry) as segment_geog_simplified,
-		elevation_gain_m, elevation_loss_m, net_elevation_m,
+		elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m, pinned,
 		created_at::text, updated_at::text
 	FROM favorite_segments
 	WHERE athlete_id = $1 AND name = $2
@@ -190,13 +220,13 @@ func GetFavoriteSegmentByName(ctx context.Context, conn *pgx.Conn, athleteID int
 	err := conn.QueryRow(ctx, query, athleteID, name).Scan(
 		&segment.ID, &segment.AthleteID, &segment.Name, &segment.Description,
 		&segment.SegmentGeog, &segment.SegmentGeogSimplified,
-		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM,
+		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM, &segment.DefaultToleranceM, &segment.Pinned,
 		&segment.CreatedAt, &segment.UpdatedAt,
 	)
 
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("segment with name '%s' not found", name)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, segmentNotFoundf(err, "segment with name '%s' not found", name)
 		}
 		return nil, fmt.Errorf("failed to get favorite segment: %w", err)
 	}
@@ -205,12 +235,12 @@ func GetFavoriteSegmentByName(ctx context.Context, conn *pgx.Conn, athleteID int
 }
 
 // ListFavoriteSegments retrieves all favorite segments for a specific athlete
-func ListFavoriteSegments(ctx context.Context, conn *pgx.Conn, athleteID int64) ([]FavoriteSegment, error) {
+func ListFavoriteSegments(ctx context.Context, conn DB, athleteID int64) ([]FavoriteSegment, error) {
 	query := `
 	SELECT id, athlete_id, name, description,
 		ST_AsText(segment_geog::geometry) as segment_geog,
 		ST_AsText(segment_geog_simplified::geometry) as segment_geog_simplified,
-		elevation_gain_m, elevation_loss_m, net_elevation_m,
+		elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m, pinned,
 		created_at::text, updated_at::text
 	FROM favorite_segments
 	WHERE athlete_id = $1
@@ -229,7 +259,7 @@ func ListFavoriteSegments(ctx context.Context, conn *pgx.Conn, athleteID int64)
 		err := rows.Scan(
 			&segment.ID, &segment.AthleteID, &segment.Name, &segment.Description,
 			&segment.SegmentGeog, &segment.SegmentGeogSimplified,
-			&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM,
+			&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM, &segment.DefaultToleranceM, &segment.Pinned,
 			&segment.CreatedAt, &segment.UpdatedAt,
 		)
 		if err != nil {
@@ -242,7 +272,9 @@ func ListFavoriteSegments(ctx context.Context, conn *pgx.Conn, athleteID int64)
 }
 
 // ListSegmentDashboardSummaries retrieves dashboard-ready summaries for all favorite segments.
-func ListSegmentDashboardSummaries(ctx context.Context, conn *pgx.Conn, athleteID int64, toleranceMeters float64) ([]SegmentDashboardSummary, error) {
+// Each segment is matched at its effective tolerance (see ResolveTolerance); cached matches
+// are used while younger than cacheTTL.
+func ListSegmentDashboardSummaries(ctx context.Context, conn DB, athleteID int64, explicitToleranceM, athleteToleranceM *float64, cacheTTL time.Duration) ([]SegmentDashboardSummary, error) {
 	segments, err := ListFavoriteSegments(ctx, conn, athleteID)
 	if err != nil {
 		return nil, err
@@ -272,6 +304,7 @@ func ListSegmentDashboardSummaries(ctx context.Context, conn *pgx.Conn, athleteI
 			SortAscent:    0,
 			SortDirection: "unknown",
 			SortName:      strings.ToLower(segment.Name),
+			Pinned:        segment.Pinned,
 		}
 
 		var distanceM float64
@@ -306,9 +339,11 @@ func ListSegmentDashboardSummaries(ctx context.Context, conn *pgx.Conn, athleteI
 		}
 		summary.SortDirection = summary.DirectionKey
 
-		efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segment.ID, toleranceMeters, "total_time", false)
+		tolerance, _ := ResolveTolerance(explicitToleranceM, segment.DefaultToleranceM, athleteToleranceM)
+		summary.ToleranceM = tolerance
+		efforts, err := GetActivitiesForSegment(ctx, conn, athleteID, segment.ID, tolerance, "total_time", false, cacheTTL, ForwardSegmentEfforts)
 		if err != nil {
-			log.Printf("⚠️ Failed to summarize segment %d: %v", segment.ID, err)
+			logger().Warn("Failed to summarize segment", "segment_id", segment.ID, "error", err)
 			summaries = append(summaries, summary)
 			continue
 		}
@@ -364,10 +399,12 @@ func formatDurationLabel(seconds float64) string {
 	return fmt.Sprintf("%d:%02d", m, s)
 }
 
-// UpdateFavoriteSegment updates an existing favorite segment and invalidates its cache
-func UpdateFavoriteSegment(ctx context.Context, conn *pgx.Conn, segmentID int64, name, description string, latLngData [][]float64) (*FavoriteSegment, error) {
+// UpdateFavoriteSegment updates one of the athlete's favorite segments and invalidates its
+// cache. When pointSamples is given the elevation columns are recomputed from them,
+// otherwise they are kept.
+func UpdateFavoriteSegment(ctx context.Context, conn DB, athleteID, segmentID int64, name, description string, latLngData [][]float64, pointSamples []PointSample) (*FavoriteSegment, error) {
 	if len(latLngData) < 2 {
-		return nil, fmt.Errorf("need at least 2 points to create a linestring")
+		return nil, invalidInputf("need at least 2 points to create a linestring")
 	}
 
 	// Extract longitude and latitude arrays for the helper function
@@ -381,12 +418,16 @@ func UpdateFavoriteSegment(ctx context.Context, conn *pgx.Conn, segmentID int64,
 
 	query := `
 	UPDATE favorite_segments 
-	SET name = $2, description = $3, segment_geog = make_route_geog_from_lonlat($4, $5), updated_at = NOW()
-	WHERE id = $1
+	SET name = $2, description = $3, segment_geog = make_route_geog_from_lonlat($4, $5),
+		elevation_gain_m = CASE WHEN $6 THEN $7 ELSE elevation_gain_m END,
+		elevation_loss_m = CASE WHEN $6 THEN $8 ELSE elevation_loss_m END,
+		net_elevation_m = CASE WHEN $6 THEN $9 ELSE net_elevation_m END,
+		updated_at = NOW()
+	WHERE id = $1 AND athlete_id = $10
 	RETURNING id, athlete_id, name, description,
 		ST_AsText(segment_geog::geometry) as segment_geog,
 		ST_AsText(segment_geog_simplified::geometry) as segment_geog_simplified,
-		elevation_gain_m, elevation_loss_m, net_elevation_m,
+		elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m, pinned,
 		created_at::text, updated_at::text
 	`
 
@@ -396,62 +437,125 @@ func UpdateFavoriteSegment(ctx context.Context, conn *pgx.Conn, segmentID int64,
 		desc = &description
 	}
 
-	err := conn.QueryRow(ctx, query, segmentID, name, desc, lons, lats).Scan(
+	elevationGain, elevationLoss, netElevation := segmentElevationFromSamples(pointSamples)
+	err := conn.QueryRow(ctx, query, segmentID, name, desc, lons, lats,
+		len(pointSamples) > 0, elevationGain, elevationLoss, netElevation, athleteID).Scan(
 		&segment.ID, &segment.AthleteID, &segment.Name, &segment.Description,
 		&segment.SegmentGeog, &segment.SegmentGeogSimplified,
-		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM,
+		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM, &segment.DefaultToleranceM, &segment.Pinned,
 		&segment.CreatedAt, &segment.UpdatedAt,
 	)
 
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("segment with ID %d not found", segmentID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, segmentNotFoundf(err, "segment with ID %d not found", segmentID)
+		}
+		if isSegmentNameConflict(err) {
+			return nil, fmt.Errorf("%w: %q", ErrSegmentNameExists, name)
 		}
 		return nil, fmt.Errorf("failed to update favorite segment: %w", err)
 	}
 
-	// Refresh the simplified segment with default tolerance
-	refreshQuery := `SELECT refresh_segment_simplified($1)`
-	_, err = conn.Exec(ctx, refreshQuery, segment.ID)
+	// Refresh the simplified segment with the configured tolerance
+	refreshQuery := `SELECT refresh_segment_simplified($1, $2)`
+	_, err = conn.Exec(ctx, refreshQuery, segment.ID, SimplifyTolerance())
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh simplified segment: %w", err)
 	}
 
 	// Invalidate cache since segment geometry changed
 	if err := InvalidateSegmentCache(ctx, conn, segmentID); err != nil {
-		log.Printf("⚠️ Failed to invalidate cache for segment %d: %v", segmentID, err)
+		logger().Warn("Failed to invalidate segment cache", "segment_id", segmentID, "error", err)
 		// Continue even if cache invalidation fails
 	}
 
 	return &segment, nil
 }
 
-// DeleteFavoriteSegment deletes a favorite segment and invalidates its cache
-func DeleteFavoriteSegment(ctx context.Context, conn *pgx.Conn, segmentID int64) error {
+// UpdateFavoriteSegmentDetails renames one of the athlete's segments and changes its
+// description without touching the geometry, so cached matches stay valid
+func UpdateFavoriteSegmentDetails(ctx context.Context, conn DB, athleteID, segmentID int64, name, description string) (*FavoriteSegment, error) {
+	query := `
+	UPDATE favorite_segments
+	SET name = $2, description = $3, updated_at = NOW()
+	WHERE id = $1 AND athlete_id = $4
+	RETURNING id, athlete_id, name, description,
+		ST_AsText(segment_geog::geometry) as segment_geog,
+		ST_AsText(segment_geog_simplified::geometry) as segment_geog_simplified,
+		elevation_gain_m, elevation_loss_m, net_elevation_m, default_tolerance_m, pinned,
+		created_at::text, updated_at::text
+	`
+
+	var segment FavoriteSegment
+	var desc *string
+	if description != "" {
+		desc = &description
+	}
+
+	err := conn.QueryRow(ctx, query, segmentID, name, desc, athleteID).Scan(
+		&segment.ID, &segment.AthleteID, &segment.Name, &segment.Description,
+		&segment.SegmentGeog, &segment.SegmentGeogSimplified,
+		&segment.ElevationGainM, &segment.ElevationLossM, &segment.NetElevationM, &segment.DefaultToleranceM, &segment.Pinned,
+		&segment.CreatedAt, &segment.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, segmentNotFoundf(err, "segment with ID %d not found", segmentID)
+		}
+		if isSegmentNameConflict(err) {
+			return nil, fmt.Errorf("%w: %q", ErrSegmentNameExists, name)
+		}
+		return nil, fmt.Errorf("failed to update favorite segment: %w", err)
+	}
+	return &segment, nil
+}
+
+// DeleteFavoriteSegment deletes one of the athlete's favorite segments and invalidates its cache
+func DeleteFavoriteSegment(ctx context.Context, conn DB, athleteID, segmentID int64) error {
+	if _, err := GetFavoriteSegment(ctx, conn, athleteID, segmentID); err != nil {
+		return err
+	}
+
 	// Invalidate cache before deleting segment (CASCADE will handle it, but we do it explicitly for clarity)
 	if err := InvalidateSegmentCache(ctx, conn, segmentID); err != nil {
-		log.Printf("⚠️ Failed to invalidate cache for segment %d: %v", segmentID, err)
+		logger().Warn("Failed to invalidate segment cache", "segment_id", segmentID, "error", err)
 		// Continue with deletion even if cache invalidation fails
 	}
 
-	query := `DELETE FROM favorite_segments WHERE id = $1`
-	result, err := conn.Exec(ctx, query, segmentID)
+	query := `DELETE FROM favorite_segments WHERE id = $1 AND athlete_id = $2`
+	result, err := conn.Exec(ctx, query, segmentID, athleteID)
 	if err != nil {
 		return fmt.Errorf("failed to delete favorite segment: %w", err)
 	}
 
 	if result.RowsAffected() == 0 {
-		return fmt.Errorf("segment with ID %d not found", segmentID)
+		return segmentNotFoundf(nil, "segment with ID %d not found", segmentID)
 	}
 
 	return nil
 }
 
-// FindRoutePartsMatchingSegment finds route parts from activities that match a segment
-func FindRoutePartsMatchingSegment(ctx context.Context, conn *pgx.Conn, segmentID int64, toleranceMeters float64) ([]SegmentMatchResult, error) {
-	query := `SELECT * FROM find_route_parts_matching_segment($1, $2)`
+// FindRoutePartsMatchingSegment finds route parts from the athlete's activities that match
+// the athlete's segment; other athletes' activities never match
+func FindRoutePartsMatchingSegment(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters float64) ([]SegmentMatchResult, error) {
+	return findRoutePartsMatchingSegment(ctx, conn, athleteID, segmentID, toleranceMeters, nil)
+}
+
+// FindRoutePartsMatchingSegmentInActivities is FindRoutePartsMatchingSegment limited to the
+// given activities, so new activities can be matched without rescanning the others
+func FindRoutePartsMatchingSegmentInActivities(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters float64, activityIDs []int64) ([]SegmentMatchResult, error) {
+	if len(activityIDs) == 0 {
+		return nil, nil
+	}
+	return findRoutePartsMatchingSegment(ctx, conn, athleteID, segmentID, toleranceMeters, activityIDs)
+}
+
+// findRoutePartsMatchingSegment matches every activity of the athlete when activityIDs is nil
+func findRoutePartsMatchingSegment(ctx context.Context, conn DB, athleteID, segmentID int64, toleranceMeters float64, activityIDs []int64) ([]SegmentMatchResult, error) {
+	defer timeQuery("segment_match", time.Now())
+	query := `SELECT * FROM find_route_parts_matching_segment($1, $2, $3, $4)`
 
-	rows, err := conn.Query(ctx, query, segmentID, toleranceMeters)
+	rows, err := conn.Query(ctx, query, athleteID, segmentID, toleranceMeters, activityIDs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find route parts matching segment: %w", err)
 	}
@@ -473,11 +577,50 @@ func FindRoutePartsMatchingSegment(ctx context.Context, conn *pgx.Conn, segmentI
 	return results, rows.Err()
 }
 
-// FindRoutePartsMatchingSegmentByName finds route parts from activities that match a segment by name
-func FindRoutePartsMatchingSegmentByName(ctx context.Context, conn *pgx.Conn, segmentName string, toleranceMeters float64) ([]SegmentMatchResult, error) {
-	query := `SELECT * FROM find_route_parts_matching_segment_by_name($1, $2)`
+// GetSegmentOverlap measures how much of the athlete's segment their activity covers at
+// toleranceMeters, whether or not the activity matches the segment
+func GetSegmentOverlap(ctx context.Context, conn DB, athleteID, segmentID, activityID int64, toleranceMeters float64) (*SegmentOverlap, error) {
+	var overlap SegmentOverlap
+	var segmentLength float64
+	err := conn.QueryRow(ctx, `
+		SELECT
+			LEAST(segment_projected_coverage(s.segment_geog, a.activity_id, $4) * 100.0, 100.0),
+			ST_Length(ST_Intersection(ST_Buffer(a.route_geog::geometry, $4)::geography, s.segment_geog)),
+			ST_Length(s.segment_geog)
+		FROM favorite_segments s
+		INNER JOIN activity_geometries a ON a.activity_id = $3 AND a.athlete_id = s.athlete_id
+		WHERE s.id = $2 AND s.athlete_id = $1
+	`, athleteID, segmentID, activityID, toleranceMeters).Scan(&overlap.CoveragePercentage, &overlap.BufferedLengthM, &segmentLength)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, notFoundf(err, "segment %d or activity %d not found for athlete %d", segmentID, activityID, athleteID)
+		}
+		return nil, fmt.Errorf("failed to measure segment overlap: %w", err)
+	}
+	if segmentLength > 0 {
+		overlap.BufferedPercentage = min(overlap.BufferedLengthM/segmentLength*100.0, 100.0)
+	}
+	return &overlap, nil
+}
+
+// FindRoutePartsMatchingSegmentByName finds route parts from the athlete's activities
+// that match the athlete's segment with the given name
+func FindRoutePartsMatchingSegmentByName(ctx context.Context, conn DB, athleteID int64, segmentName string, toleranceMeters float64) ([]SegmentMatchResult, error) {
+	var segments int
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM favorite_segments WHERE athlete_id = $1 AND name = $2`, athleteID, segmentName).Scan(&segments); err != nil {
+		return nil, fmt.Errorf("failed to look up segment by name: %w", err)
+	}
+	switch {
+	case segments == 0:
+		return nil, segmentNotFoundf(nil, "segment %q not found for athlete %d", segmentName, athleteID)
+	case segments > 1:
+		// Only possible before the uniqueness migration has run
+		return nil, fmt.Errorf("%w: athlete %d has %d segments named %q", ErrSegmentNameAmbiguous, athleteID, segments, segmentName)
+	}
+
+	query := `SELECT * FROM find_route_parts_matching_segment_by_name($1, $2, $3)`
 
-	rows, err := conn.Query(ctx, query, segmentName, toleranceMeters)
+	rows, err := conn.Query(ctx, query, athleteID, segmentName, toleranceMeters)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find route parts matching segment by name: %w", err)
 	}
@@ -500,7 +643,7 @@ func FindRoutePartsMatchingSegmentByName(ctx context.Context, conn *pgx.Conn, se
 }
 
 // RefreshSegmentSimplified refreshes the simplified geometry for a specific segment
-func RefreshSegmentSimplified(ctx context.Context, conn *pgx.Conn, segmentID int64, toleranceMeters float64) error {
+func RefreshSegmentSimplified(ctx context.Context, conn DB, segmentID int64, toleranceMeters float64) error {
 	query := `SELECT refresh_segment_simplified($1, $2)`
 	_, err := conn.Exec(ctx, query, segmentID, toleranceMeters)
 	return err
@@ -0,0 +1,232 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Fixture identifiers are negative so they can never collide with Strava IDs. Everything
+// the self-check inserts is rolled back before VerifySpatialFunctions returns.
+const (
+	selfCheckActivityID = -1
+	selfCheckSegmentID  = -1
+	selfCheckAthleteID  = -1
+	selfCheckTimeout    = 5 * time.Second
+
+	// Fixture geometry: a straight 1 km line heading north from (10°E, 45°N) sampled every
+	// 100 m, a probe point 100 m east of its midpoint and a segment over its middle 500 m.
+	selfCheckOriginLon     = 10.0
+	selfCheckOriginLat     = 45.0
+	selfCheckStepMeters    = 100.0
+	selfCheckSteps         = 10
+	selfCheckProbeMeters   = 100.0
+	selfCheckSegmentStart  = 2
+	selfCheckSegmentEnd    = 7
+	selfCheckToleranceM    = 15.0
+	metersPerDegreeLat45   = 111132.92
+	metersPerDegreeLon45   = 78846.81
+	selfCheckLengthRelTol  = 0.01
+	selfCheckMinOverlapPct = 99.0
+)
+
+// SpatialCheckResult is the outcome of one spatial self-check
+type SpatialCheckResult struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+	Passed   bool   `json:"passed"`
+}
+
+// SpatialCheckError is returned by VerifySpatialFunctions when at least one check failed
+type SpatialCheckError struct {
+	Failed []SpatialCheckResult
+}
+
+func (e *SpatialCheckError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for _, check := range e.Failed {
+		names = append(names, fmt.Sprintf("%s (expected %s, got %s)", check.Name, check.Expected, check.Got))
+	}
+	return fmt.Sprintf("spatial self-check failed: %s", strings.Join(names, "; "))
+}
+
+// VerifySpatialFunctions runs the installed helper functions against small built-in fixtures
+// with known answers. It returns every check result and a *SpatialCheckError if any check
+// produced a wrong answer; other errors mean the check itself could not run.
+func VerifySpatialFunctions(ctx context.Context, conn DB) ([]SpatialCheckResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+	defer cancel()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin spatial self-check: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	lons, lats := selfCheckRoute()
+	probeLon := selfCheckOriginLon + selfCheckProbeMeters/metersPerDegreeLon45
+	probeLat := lats[selfCheckSteps/2]
+
+	var results []SpatialCheckResult
+	record := func(name, expected string, got float64, passed bool) {
+		results = append(results, SpatialCheckResult{
+			Name:     name,
+			Expected: expected,
+			Got:      formatCheckValue(got),
+			Passed:   passed,
+		})
+	}
+	routeLength := float64(selfCheckSteps) * selfCheckStepMeters
+
+	// Route construction and geography length
+	var length float64
+	if err := tx.QueryRow(ctx, `SELECT ST_Length(make_route_geog_from_lonlat($1, $2))`, lons, lats).Scan(&length); err != nil {
+		return nil, fmt.Errorf("failed to run make_route_geog_from_lonlat check: %w", err)
+	}
+	record("make_route_geog_from_lonlat length", fmt.Sprintf("%.0f m ±%.0f%%", routeLength, selfCheckLengthRelTol*100),
+		length, withinRelative(length, routeLength, selfCheckLengthRelTol))
+
+	// Simplification of a straight line keeps its endpoints and its length
+	var simplifiedLength float64
+	var simplifiedPoints int
+	if err := tx.QueryRow(ctx, `
+		SELECT ST_Length(g), ST_NPoints(g::geometry)
+		FROM (SELECT simplify_route_geog_meters(make_route_geog_from_lonlat($1, $2), 8.0) AS g) s
+	`, lons, lats).Scan(&simplifiedLength, &simplifiedPoints); err != nil {
+		return nil, fmt.Errorf("failed to run simplify_route_geog_meters check: %w", err)
+	}
+	record("simplify_route_geog_meters length", fmt.Sprintf("%.0f m ±%.0f%%", routeLength, selfCheckLengthRelTol*100),
+		simplifiedLength, withinRelative(simplifiedLength, routeLength, selfCheckLengthRelTol))
+	record("simplify_route_geog_meters points", "2", float64(simplifiedPoints), simplifiedPoints == 2)
+
+	if err := insertSelfCheckFixture(ctx, tx, lons, lats); err != nil {
+		return nil, err
+	}
+
+	// Distance from a point 100 m off the route
+	var nearDistance float64
+	err = tx.QueryRow(ctx, `
+		SELECT min_dist_m FROM find_activities_near($1, $2, $3, $4) WHERE activity_id = $5
+	`, int64(selfCheckAthleteID), probeLon, probeLat, selfCheckProbeMeters*1.5, selfCheckActivityID).Scan(&nearDistance)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		record("find_activities_near distance", fmt.Sprintf("%.0f m ±%.0f%%", selfCheckProbeMeters, selfCheckLengthRelTol*100), math.NaN(), false)
+	case err != nil:
+		return nil, fmt.Errorf("failed to run find_activities_near check: %w", err)
+	default:
+		record("find_activities_near distance", fmt.Sprintf("%.0f m ±%.0f%%", selfCheckProbeMeters, selfCheckLengthRelTol*100),
+			nearDistance, withinRelative(nearDistance, selfCheckProbeMeters, selfCheckLengthRelTol))
+	}
+
+	// The same point must fall outside a radius smaller than its distance
+	var tooNear int
+	if err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM find_activities_near($1, $2, $3, $4) WHERE activity_id = $5
+	`, int64(selfCheckAthleteID), probeLon, probeLat, selfCheckProbeMeters/2, selfCheckActivityID).Scan(&tooNear); err != nil {
+		return nil, fmt.Errorf("failed to run find_activities_near radius check: %w", err)
+	}
+	record("find_activities_near radius", "0 matches", float64(tooNear), tooNear == 0)
+
+	// A segment laid over the route matches with ~100% overlap
+	var overlap float64
+	err = tx.QueryRow(ctx, `
+		SELECT overlap_percentage FROM find_route_parts_matching_segment($1, $2, $3) WHERE activity_id = $4
+	`, int64(selfCheckAthleteID), int64(selfCheckSegmentID), selfCheckToleranceM, selfCheckActivityID).Scan(&overlap)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		record("find_route_parts_matching_segment overlap", fmt.Sprintf(">= %.0f%%", selfCheckMinOverlapPct), 0, false)
+	case err != nil:
+		return nil, fmt.Errorf("failed to run find_route_parts_matching_segment check: %w", err)
+	default:
+		record("find_route_parts_matching_segment overlap", fmt.Sprintf(">= %.0f%%", selfCheckMinOverlapPct), overlap, overlap >= selfCheckMinOverlapPct)
+	}
+
+	// The segment endpoints resolve to the fixture's sample indices
+	var startIndex, endIndex int
+	err = tx.QueryRow(ctx, `
+		SELECT start_index, end_index FROM find_segment_point_indices($1, $2, $3, $4)
+	`, int64(selfCheckSegmentID), int64(selfCheckActivityID), int64(selfCheckAthleteID), selfCheckToleranceM).Scan(&startIndex, &endIndex)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to run find_segment_point_indices check: %w", err)
+	}
+	found := err == nil
+	record("find_segment_point_indices start", fmt.Sprintf("%d", selfCheckSegmentStart), float64(startIndex), found && startIndex == selfCheckSegmentStart)
+	record("find_segment_point_indices end", fmt.Sprintf("%d", selfCheckSegmentEnd), float64(endIndex), found && endIndex == selfCheckSegmentEnd)
+
+	var failed []SpatialCheckResult
+	for _, result := range results {
+		if !result.Passed {
+			failed = append(failed, result)
+		}
+	}
+	if len(failed) > 0 {
+		return results, &SpatialCheckError{Failed: failed}
+	}
+	return results, nil
+}
+
+func insertSelfCheckFixture(ctx context.Context, tx pgx.Tx, lons, lats []float64) error {
+	routeLength := float64(selfCheckSteps) * selfCheckStepMeters
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+		VALUES ($1, $2, 'spatial self-check', $3, 0, 0, 0, 'Ride', NOW())
+	`, int64(selfCheckActivityID), int64(selfCheckAthleteID), routeLength); err != nil {
+		return fmt.Errorf("failed to insert self-check activity: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO activity_geometries (activity_id, athlete_id, route_geog)
+		VALUES ($1, $2, make_route_geog_from_lonlat($3, $4))
+	`, int64(selfCheckActivityID), int64(selfCheckAthleteID), lons, lats); err != nil {
+		return fmt.Errorf("failed to insert self-check geometry: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, cumulative_distance)
+		SELECT $1, $2, i - 1, NOW() + make_interval(secs => i),
+			ST_SetSRID(ST_MakePoint(($3::DOUBLE PRECISION[])[i], ($4::DOUBLE PRECISION[])[i]), 4326)::GEOGRAPHY,
+			(i - 1) * $5::DOUBLE PRECISION
+		FROM generate_subscripts($3::DOUBLE PRECISION[], 1) AS i
+	`, int64(selfCheckActivityID), int64(selfCheckAthleteID), lons, lats, selfCheckStepMeters); err != nil {
+		return fmt.Errorf("failed to insert self-check point samples: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO favorite_segments (id, athlete_id, name, segment_geog)
+		VALUES ($1, $2, 'spatial self-check', make_route_geog_from_lonlat($3, $4))
+	`, int64(selfCheckSegmentID), int64(selfCheckAthleteID),
+		lons[selfCheckSegmentStart:selfCheckSegmentEnd+1], lats[selfCheckSegmentStart:selfCheckSegmentEnd+1]); err != nil {
+		return fmt.Errorf("failed to insert self-check segment: %w", err)
+	}
+	return nil
+}
+
+// selfCheckRoute returns the longitudes and latitudes of the fixture route
+func selfCheckRoute() ([]float64, []float64) {
+	lons := make([]float64, selfCheckSteps+1)
+	lats := make([]float64, selfCheckSteps+1)
+	for i := range lons {
+		lons[i] = selfCheckOriginLon
+		lats[i] = selfCheckOriginLat + float64(i)*selfCheckStepMeters/metersPerDegreeLat45
+	}
+	return lons, lats
+}
+
+func withinRelative(got, want, tolerance float64) bool {
+	if math.IsNaN(got) {
+		return false
+	}
+	return math.Abs(got-want) <= math.Abs(want)*tolerance
+}
+
+func formatCheckValue(v float64) string {
+	if math.IsNaN(v) {
+		return "no result"
+	}
+	return fmt.Sprintf("%.2f", v)
+}
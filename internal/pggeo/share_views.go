@@ -0,0 +1,78 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ShareView is one answered request of a share token
+type ShareView struct {
+	TokenID   int64
+	ViewedAt  time.Time
+	UserAgent string
+	Country   string // ISO code from the GeoIP lookup, empty when unknown
+	Bot       bool
+}
+
+// ShareViewStats sums up the views of a share token that are still kept. Bots are
+// counted apart and left out of the other figures.
+type ShareViewStats struct {
+	Views        int64      `json:"views"`
+	BotViews     int64      `json:"bot_views"`
+	DaysWithView int64      `json:"days_with_views"` // UTC days
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+}
+
+// RecordShareView stores a view of a share token. A view of a token revoked meanwhile
+// fails on the token's foreign key.
+func RecordShareView(ctx context.Context, conn DB, view ShareView) error {
+	var country *string
+	if view.Country != "" {
+		country = &view.Country
+	}
+	_, err := conn.Exec(ctx, `
+		INSERT INTO share_views (token_id, viewed_at, user_agent, country, bot)
+		VALUES ($1, $2, $3, $4, $5)
+	`, view.TokenID, view.ViewedAt, view.UserAgent, country, view.Bot)
+	if err != nil {
+		return fmt.Errorf("failed to record share view: %w", err)
+	}
+	return nil
+}
+
+// GetShareViewStats sums up the views of one of the athlete's public stats tokens.
+// Another athlete's token is ErrNotFound.
+func GetShareViewStats(ctx context.Context, conn DB, athleteID, tokenID int64) (*ShareViewStats, error) {
+	var stats ShareViewStats
+	err := conn.QueryRow(ctx, `
+		SELECT COUNT(v.id) FILTER (WHERE NOT v.bot),
+			   COUNT(v.id) FILTER (WHERE v.bot),
+			   COUNT(DISTINCT (v.viewed_at AT TIME ZONE 'UTC')::date) FILTER (WHERE NOT v.bot),
+			   MAX(v.viewed_at) FILTER (WHERE NOT v.bot)
+		FROM public_stats_tokens t
+		LEFT JOIN share_views v ON v.token_id = t.id
+		WHERE t.id = $1 AND t.athlete_id = $2
+		GROUP BY t.id
+	`, tokenID, athleteID).Scan(&stats.Views, &stats.BotViews, &stats.DaysWithView, &stats.LastViewedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundf(err, "share token %d not found", tokenID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get share view stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// DeleteShareViewsBefore prunes the views older than cutoff and returns how many it
+// deleted
+func DeleteShareViewsBefore(ctx context.Context, conn DB, cutoff time.Time) (int64, error) {
+	tag, err := conn.Exec(ctx, `DELETE FROM share_views WHERE viewed_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune share views: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
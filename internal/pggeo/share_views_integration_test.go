@@ -0,0 +1,65 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShareViewStatsAndPruning(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000758)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM public_stats_tokens WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	token, err := CreatePublicStatsToken(ctx, conn, athleteID, "key-views", PublicStatFields, ShareLimits{})
+	if err != nil {
+		t.Fatalf("CreatePublicStatsToken: %v", err)
+	}
+	if stats, err := GetShareViewStats(ctx, conn, athleteID, token.ID); err != nil || stats.Views != 0 || stats.LastViewedAt != nil {
+		t.Fatalf("stats of an unviewed link = %+v, %v", stats, err)
+	}
+
+	day := time.Date(2025, 4, 1, 10, 0, 0, 0, time.UTC)
+	for _, view := range []ShareView{
+		{TokenID: token.ID, ViewedAt: day.AddDate(0, 0, -200), UserAgent: "Firefox"},
+		{TokenID: token.ID, ViewedAt: day, UserAgent: "Firefox", Country: "DE"},
+		{TokenID: token.ID, ViewedAt: day.Add(time.Hour), UserAgent: "Safari"},
+		{TokenID: token.ID, ViewedAt: day.AddDate(0, 0, 1), UserAgent: "Googlebot", Bot: true},
+	} {
+		if err := RecordShareView(ctx, conn, view); err != nil {
+			t.Fatalf("RecordShareView: %v", err)
+		}
+	}
+	if err := RecordShareView(ctx, conn, ShareView{TokenID: -1, ViewedAt: day}); err == nil {
+		t.Fatal("recorded a view of a token that does not exist")
+	}
+
+	if n, err := DeleteShareViewsBefore(ctx, conn, day.AddDate(0, 0, -90)); err != nil || n != 1 {
+		t.Fatalf("DeleteShareViewsBefore = %d, %v; want 1", n, err)
+	}
+	stats, err := GetShareViewStats(ctx, conn, athleteID, token.ID)
+	if err != nil {
+		t.Fatalf("GetShareViewStats: %v", err)
+	}
+	if stats.Views != 2 || stats.BotViews != 1 || stats.DaysWithView != 1 || stats.LastViewedAt == nil || !stats.LastViewedAt.Equal(day.Add(time.Hour)) {
+		t.Fatalf("stats = %+v, want 2 views on 1 day, 1 bot view", stats)
+	}
+	if _, err := GetShareViewStats(ctx, conn, athleteID+1, token.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("another athlete's stats: %v, want ErrNotFound", err)
+	}
+
+	if _, err := DeletePublicStatsTokens(ctx, conn, athleteID, token.ID); err != nil {
+		t.Fatalf("DeletePublicStatsTokens: %v", err)
+	}
+	var left int
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM share_views WHERE token_id = $1`, token.ID).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("%d views left after revoking, %v", left, err)
+	}
+}
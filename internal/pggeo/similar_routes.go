@@ -0,0 +1,163 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultSimilarRouteToleranceM is how far apart two rides of the same route may run when
+// FindSimilarActivities is given no tolerance
+const DefaultSimilarRouteToleranceM = 30.0
+
+// MinSimilarRouteCoverage is the share of each route that must lie within the tolerance of
+// the other for the two to count as the same route
+const MinSimilarRouteCoverage = 0.9
+
+// SimilarActivity is another ride of the same route, with the times to compare
+type SimilarActivity struct {
+	ActivityID     int64     `json:"activity_id"`
+	Name           string    `json:"name"`
+	StartDate      time.Time `json:"start_date"`
+	DistanceM      float64   `json:"distance_m"`
+	ElapsedSeconds float64   `json:"elapsed_time"`
+	MovingSeconds  float64   `json:"moving_time"`
+	// Coverage is the smaller of the shares of either route near the other, 0 to 1
+	Coverage float64 `json:"coverage"`
+}
+
+// FindSimilarActivities returns the athlete's other activities whose routes overlap the
+// activity's by at least MinSimilarRouteCoverage in both directions at toleranceMeters,
+// newest first. Matches are cached per activity and tolerance until the athlete adds a
+// route or one of their routes changes. An activity without a route has no similar
+// activities; toleranceMeters <= 0 uses DefaultSimilarRouteToleranceM.
+func FindSimilarActivities(ctx context.Context, conn DB, athleteID, activityID int64, toleranceMeters float64) ([]SimilarActivity, error) {
+	if toleranceMeters <= 0 {
+		toleranceMeters = DefaultSimilarRouteToleranceM
+	}
+
+	var hasRoute bool
+	err := conn.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM activity_geometries WHERE activity_id = a.id)
+		FROM activity_summaries a
+		WHERE a.athlete_id = $1 AND a.id = $2
+	`, athleteID, activityID).Scan(&hasRoute)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, activityNotFoundf(err, "activity with ID %d not found", activityID)
+		}
+		return nil, fmt.Errorf("failed to load activity %d: %w", activityID, err)
+	}
+	if !hasRoute {
+		return []SimilarActivity{}, nil
+	}
+
+	fresh, err := similarActivitiesCacheFresh(ctx, conn, athleteID, activityID, toleranceMeters)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		if err := matchSimilarActivities(ctx, conn, athleteID, activityID, toleranceMeters); err != nil {
+			return nil, err
+		}
+	}
+	return loadSimilarActivities(ctx, conn, athleteID, activityID, toleranceMeters)
+}
+
+// similarActivitiesCacheFresh reports whether the activity's matches at the tolerance were
+// computed after the athlete's newest route was added; changed routes drop the cache
+// through similarActivitiesInvalidator
+func similarActivitiesCacheFresh(ctx context.Context, conn DB, athleteID, activityID int64, toleranceMeters float64) (bool, error) {
+	var fresh bool
+	if err := conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM similar_activities_cache c
+			WHERE c.activity_id = $2 AND c.tolerance_meters = $3
+			  AND NOT EXISTS (
+				SELECT 1 FROM activity_geometries g
+				WHERE g.athlete_id = $1 AND g.created_at > c.computed_at
+			  )
+		)
+	`, athleteID, activityID, toleranceMeters).Scan(&fresh); err != nil {
+		return false, fmt.Errorf("failed to check similar activities cache: %w", err)
+	}
+	return fresh, nil
+}
+
+// matchSimilarActivities compares the activity's simplified route with every route of the
+// athlete near it and caches the ones covering each other. Routes more than a quarter
+// longer or shorter are skipped before measuring, as they cannot reach the coverage.
+func matchSimilarActivities(ctx context.Context, conn DB, athleteID, activityID int64, toleranceMeters float64) error {
+	defer timeQuery("similar_activities", time.Now())
+
+	_, err := conn.Exec(ctx, `
+		WITH target AS (
+			SELECT route, ST_Length(route) AS length_m, ST_Buffer(route, $3) AS corridor
+			FROM (
+				SELECT COALESCE(route_geog_simplified, route_geog) AS route
+				FROM activity_geometries
+				WHERE athlete_id = $1 AND activity_id = $2
+			) r
+		),
+		candidates AS (
+			SELECT g.activity_id, COALESCE(g.route_geog_simplified, g.route_geog) AS route
+			FROM activity_geometries g, target t
+			WHERE g.athlete_id = $1 AND g.activity_id <> $2
+			  AND ST_DWithin(g.route_geog, t.route, $3)
+			  AND ST_Length(COALESCE(g.route_geog_simplified, g.route_geog)) BETWEEN t.length_m * 0.75 AND t.length_m / 0.75
+		),
+		coverage AS (
+			SELECT c.activity_id, LEAST(
+				ST_Length(ST_Intersection(c.route, t.corridor)) / NULLIF(ST_Length(c.route), 0),
+				ST_Length(ST_Intersection(t.route, ST_Buffer(c.route, $3))) / NULLIF(t.length_m, 0)
+			) AS coverage
+			FROM candidates c, target t
+		),
+		matched AS (
+			SELECT
+				COALESCE(array_agg(activity_id ORDER BY activity_id), '{}') AS ids,
+				COALESCE(array_agg(LEAST(coverage, 1.0) ORDER BY activity_id), '{}') AS coverages
+			FROM coverage
+			WHERE coverage >= $4
+		)
+		INSERT INTO similar_activities_cache (activity_id, athlete_id, tolerance_meters, similar_activity_ids, coverages, computed_at)
+		SELECT $2, $1, $3, ids, coverages, NOW() FROM matched
+		ON CONFLICT (activity_id, tolerance_meters)
+		DO UPDATE SET similar_activity_ids = EXCLUDED.similar_activity_ids, coverages = EXCLUDED.coverages, computed_at = NOW()
+	`, athleteID, activityID, toleranceMeters, MinSimilarRouteCoverage)
+	if err != nil {
+		return fmt.Errorf("failed to match similar activities for activity %d: %w", activityID, err)
+	}
+	return nil
+}
+
+// loadSimilarActivities reads the cached matches with their summaries; activities deleted
+// since drop out
+func loadSimilarActivities(ctx context.Context, conn DB, athleteID, activityID int64, toleranceMeters float64) ([]SimilarActivity, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT a.id, a.name, a.start_date, a.distance, a.elapsed_time, a.moving_time, m.coverage
+		FROM similar_activities_cache c
+		CROSS JOIN LATERAL unnest(c.similar_activity_ids, c.coverages) AS m(activity_id, coverage)
+		INNER JOIN activity_summaries a ON a.id = m.activity_id AND a.athlete_id = $1
+		WHERE c.activity_id = $2 AND c.tolerance_meters = $3
+		ORDER BY a.start_date DESC
+	`, athleteID, activityID, toleranceMeters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load similar activities: %w", err)
+	}
+	defer rows.Close()
+
+	similar := []SimilarActivity{}
+	for rows.Next() {
+		var activity SimilarActivity
+		if err := rows.Scan(&activity.ActivityID, &activity.Name, &activity.StartDate, &activity.DistanceM,
+			&activity.ElapsedSeconds, &activity.MovingSeconds, &activity.Coverage); err != nil {
+			return nil, fmt.Errorf("failed to scan similar activity: %w", err)
+		}
+		similar = append(similar, activity)
+	}
+	return similar, rows.Err()
+}
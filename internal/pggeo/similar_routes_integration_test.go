@@ -0,0 +1,75 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+// similarFixtureRide rides points*100 m north from the self-check origin, shifted east by
+// shiftDeg, starting dayOffset days after the first ride
+func similarFixtureRide(athleteID, activityID int64, dayOffset, points int, shiftDeg float64) *strava.BikeActivity {
+	start := time.Date(2024, 8, 1, 7, 0, 0, 0, time.UTC).AddDate(0, 0, dayOffset)
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{
+		ID:            activityID,
+		AthleteID:     athleteID,
+		Name:          "Similar route fixture",
+		Type:          "Ride",
+		SportType:     "Ride",
+		StartDate:     start.Format(time.RFC3339),
+		StartDateTime: start,
+		Distance:      float64(points-1) * 100,
+		MovingTime:    float64(100 + dayOffset),
+		ElapsedTime:   float64(120 + dayOffset),
+	}}
+	for i := 0; i < points; i++ {
+		activity.LatLngStream.Data = append(activity.LatLngStream.Data, []float64{selfCheckOriginLat + float64(i)*0.0009, selfCheckOriginLon + shiftDeg})
+		activity.TimeStream.Data = append(activity.TimeStream.Data, start.Add(time.Duration(i)*10*time.Second))
+	}
+	return activity
+}
+
+func TestFindSimilarActivitiesMatchesTheSameRoute(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000798)
+	const base = int64(990000798000)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for _, ride := range []*strava.BikeActivity{
+		similarFixtureRide(athleteID, base+1, 0, 21, 0),
+		similarFixtureRide(athleteID, base+2, 1, 21, 0.00005), // the same 2 km about 4 m east
+		similarFixtureRide(athleteID, base+3, 2, 11, 0),       // only its first half
+		similarFixtureRide(athleteID, base+4, 3, 21, 0.01),    // a parallel road 800 m east
+	} {
+		if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+			t.Fatalf("InsertBikeActivity %d: %v", ride.Summary.ID, err)
+		}
+	}
+
+	similar, err := FindSimilarActivities(ctx, conn, athleteID, base+1, 0)
+	if err != nil {
+		t.Fatalf("FindSimilarActivities: %v", err)
+	}
+	if len(similar) != 1 || similar[0].ActivityID != base+2 || similar[0].ElapsedSeconds != 121 || similar[0].Coverage < MinSimilarRouteCoverage {
+		t.Fatalf("similar = %+v, want only the shifted ride", similar)
+	}
+	if _, err := FindSimilarActivities(ctx, conn, athleteID+1, base+1, 0); err == nil {
+		t.Fatal("another athlete found similar activities")
+	}
+
+	// A new ride of the route expires the cached matches
+	if err := InsertBikeActivity(ctx, conn, similarFixtureRide(athleteID, base+5, 4, 21, -0.00005)); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	if similar, err = FindSimilarActivities(ctx, conn, athleteID, base+1, 0); err != nil || len(similar) != 2 || similar[0].ActivityID != base+5 {
+		t.Fatalf("similar after a new ride = %+v, %v; want the new ride first", similar, err)
+	}
+}
@@ -0,0 +1,88 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// DefaultSimplifyToleranceM is the tolerance stored routes and segments are simplified
+	// to until SetSimplifyTolerance is called
+	DefaultSimplifyToleranceM = 8.0
+	// MaxSimplifyToleranceM caps the tolerance, past which a route keeps little but its ends
+	MaxSimplifyToleranceM = 1000.0
+)
+
+// simplifyTolerance holds the float64 tolerance new routes and segments are simplified to
+var simplifyTolerance atomic.Value
+
+// SetSimplifyTolerance makes the package simplify routes and segments stored from now on
+// to toleranceMeters; those stored before keep theirs until ResimplifyAll
+func SetSimplifyTolerance(toleranceMeters float64) {
+	simplifyTolerance.Store(toleranceMeters)
+}
+
+// SimplifyTolerance is the tolerance in meters new routes and segments are simplified to
+func SimplifyTolerance() float64 {
+	if tolerance, ok := simplifyTolerance.Load().(float64); ok && tolerance > 0 {
+		return tolerance
+	}
+	return DefaultSimplifyToleranceM
+}
+
+// ResimplifyResult counts what ResimplifyAll simplified again
+type ResimplifyResult struct {
+	ToleranceM float64 `json:"tolerance_m"`
+	Athletes   int     `json:"athletes"`
+	Activities int64   `json:"activities"`
+	Segments   int64   `json:"segments"`
+}
+
+// ResimplifyAll simplifies every stored route and segment again to toleranceMeters, such
+// as after the configured tolerance changed. Routes are updated one athlete per statement,
+// logging progress after each, so a large library neither holds one long transaction nor
+// runs silently.
+func ResimplifyAll(ctx context.Context, conn DB, toleranceMeters float64) (*ResimplifyResult, error) {
+	if toleranceMeters <= 0 || toleranceMeters > MaxSimplifyToleranceM {
+		return nil, invalidInputf("tolerance must be above 0 and at most %.0f meters", MaxSimplifyToleranceM)
+	}
+	rows, err := conn.Query(ctx, `SELECT DISTINCT athlete_id FROM activity_geometries ORDER BY athlete_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list athletes with routes: %w", err)
+	}
+	athleteIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("failed to list athletes with routes: %w", err)
+	}
+
+	result := &ResimplifyResult{ToleranceM: toleranceMeters}
+	for i, athleteID := range athleteIDs {
+		tag, err := conn.Exec(ctx, `
+			UPDATE activity_geometries
+			SET route_geog_simplified = simplify_route_geog_meters(route_geog, $2)
+			WHERE athlete_id = $1
+		`, athleteID, toleranceMeters)
+		if err != nil {
+			return result, fmt.Errorf("failed to simplify routes of athlete %d: %w", athleteID, err)
+		}
+		result.Athletes++
+		result.Activities += tag.RowsAffected()
+		logger().Info("Simplified routes", "athlete_id", athleteID, "activities", tag.RowsAffected(),
+			"athletes_done", i+1, "athletes_total", len(athleteIDs), "tolerance_m", toleranceMeters)
+	}
+
+	var segments int64
+	if err := conn.QueryRow(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT refresh_segment_simplified(id, $1) FROM favorite_segments
+		) refreshed
+	`, toleranceMeters).Scan(&segments); err != nil {
+		return result, fmt.Errorf("failed to simplify segments: %w", err)
+	}
+	result.Segments = segments
+	logger().Info("Simplified segments", "segments", segments, "tolerance_m", toleranceMeters)
+	return result, nil
+}
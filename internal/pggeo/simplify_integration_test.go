@@ -0,0 +1,71 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestResimplifyAllAndRouteGeometryChoice(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000801)
+	const base = int64(990000801000)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+	t.Cleanup(func() {
+		SetSimplifyTolerance(DefaultSimplifyToleranceM)
+		_, _ = ResimplifyAll(context.Background(), conn, DefaultSimplifyToleranceM)
+	})
+
+	// A route zigzagging about 4 m either side of a straight line
+	ride := similarFixtureRide(athleteID, base, 0, 12, 0)
+	for i := 1; i < len(ride.LatLngStream.Data); i += 2 {
+		ride.LatLngStream.Data[i][1] += 0.00005
+	}
+	SetSimplifyTolerance(DefaultSimplifyToleranceM)
+	if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	points := func(simplifyMeters float64) int {
+		t.Helper()
+		raw, err := GetRoutesGeoJSON(ctx, conn, athleteID, nil, simplifyMeters)
+		if err != nil {
+			t.Fatalf("GetRoutesGeoJSON: %v", err)
+		}
+		var collection routesFeatureCollection
+		if err := json.Unmarshal([]byte(raw), &collection); err != nil || len(collection.Features) != 1 {
+			t.Fatalf("routes = %s, %v", raw, err)
+		}
+		return len(collection.Features[0].Geometry.Coordinates)
+	}
+	if stored, full := points(SimplifyStored), points(SimplifyFull); stored != 2 || full != 12 {
+		t.Fatalf("stored route has %d points and full %d, want 2 and 12", stored, full)
+	}
+
+	if _, err := ResimplifyAll(ctx, conn, 0); err == nil {
+		t.Fatal("a zero tolerance was accepted")
+	}
+	result, err := ResimplifyAll(ctx, conn, 1)
+	if err != nil || result.Activities < 1 || result.ToleranceM != 1 {
+		t.Fatalf("ResimplifyAll = %+v, %v", result, err)
+	}
+	if stored := points(SimplifyStored); stored != 12 {
+		t.Fatalf("route simplified to 1 m has %d points, want all 12", stored)
+	}
+
+	// New routes follow the configured tolerance
+	SetSimplifyTolerance(1)
+	ride.Summary.ID = base + 1
+	if err := InsertBikeActivity(ctx, conn, ride); err != nil {
+		t.Fatalf("InsertBikeActivity: %v", err)
+	}
+	var simplified int
+	if err := conn.QueryRow(ctx, `SELECT ST_NPoints(route_geog_simplified::geometry) FROM activity_geometries WHERE activity_id = $1`, base+1).Scan(&simplified); err != nil || simplified != 12 {
+		t.Fatalf("new route simplified to %d points, %v; want 12", simplified, err)
+	}
+}
@@ -0,0 +1,87 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SkipReasonGoneFromStrava marks an activity Strava answered 404 for: it was listed but
+// deleted before its details could be fetched
+const SkipReasonGoneFromStrava = "gone"
+
+// SkippedActivity is an activity the sync no longer tries to fetch
+type SkippedActivity struct {
+	ActivityID int64     `json:"activity_id"`
+	Reason     string    `json:"reason"`
+	FirstSeen  time.Time `json:"first_seen"`
+}
+
+// MarkActivitySkipped records that the sync should stop fetching an activity. Marking it
+// again keeps the original reason and first_seen.
+func MarkActivitySkipped(ctx context.Context, conn DB, athleteID, activityID int64, reason string) error {
+	_, err := conn.Exec(ctx, `
+		INSERT INTO skipped_activities (athlete_id, activity_id, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (athlete_id, activity_id) DO NOTHING
+	`, athleteID, activityID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to mark activity %d as skipped: %w", activityID, err)
+	}
+	return nil
+}
+
+// GetSkippedActivityIDs returns the athlete's skipped activity IDs as a set
+func GetSkippedActivityIDs(ctx context.Context, conn DB, athleteID int64) (map[int64]bool, error) {
+	rows, err := conn.Query(ctx, `SELECT activity_id FROM skipped_activities WHERE athlete_id = $1`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query skipped activities: %w", err)
+	}
+	defer rows.Close()
+
+	skipped := make(map[int64]bool)
+	for rows.Next() {
+		var activityID int64
+		if err := rows.Scan(&activityID); err != nil {
+			return nil, fmt.Errorf("failed to scan skipped activity: %w", err)
+		}
+		skipped[activityID] = true
+	}
+	return skipped, rows.Err()
+}
+
+// ListSkippedActivities returns the athlete's skipped activities, newest first
+func ListSkippedActivities(ctx context.Context, conn DB, athleteID int64) ([]SkippedActivity, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT activity_id, reason, first_seen
+		FROM skipped_activities
+		WHERE athlete_id = $1
+		ORDER BY first_seen DESC, activity_id DESC
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query skipped activities: %w", err)
+	}
+	defer rows.Close()
+
+	skipped := make([]SkippedActivity, 0)
+	for rows.Next() {
+		var activity SkippedActivity
+		if err := rows.Scan(&activity.ActivityID, &activity.Reason, &activity.FirstSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan skipped activity: %w", err)
+		}
+		skipped = append(skipped, activity)
+	}
+	return skipped, rows.Err()
+}
+
+// DeleteSkippedActivity lets the next sync try the activity again. It returns false when
+// the athlete has no such entry.
+func DeleteSkippedActivity(ctx context.Context, conn DB, athleteID, activityID int64) (bool, error) {
+	tag, err := conn.Exec(ctx, `
+		DELETE FROM skipped_activities WHERE athlete_id = $1 AND activity_id = $2
+	`, athleteID, activityID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete skipped activity %d: %w", activityID, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,54 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSkippedActivitiesArePerAthleteAndClearable(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000406), int64(990000406001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM skipped_activities WHERE athlete_id IN ($1, $2)`, athleteID, athleteID+1)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if err := MarkActivitySkipped(ctx, conn, athleteID, activityID, SkipReasonGoneFromStrava); err != nil {
+		t.Fatalf("MarkActivitySkipped: %v", err)
+	}
+	if err := MarkActivitySkipped(ctx, conn, athleteID, activityID, "other"); err != nil {
+		t.Fatalf("MarkActivitySkipped again: %v", err)
+	}
+	listed, err := ListSkippedActivities(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("ListSkippedActivities: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ActivityID != activityID || listed[0].Reason != SkipReasonGoneFromStrava {
+		t.Fatalf("listed = %+v, want one gone entry", listed)
+	}
+
+	other, err := GetSkippedActivityIDs(ctx, conn, athleteID+1)
+	if err != nil {
+		t.Fatalf("GetSkippedActivityIDs: %v", err)
+	}
+	if len(other) != 0 {
+		t.Fatalf("another athlete sees skips %v", other)
+	}
+	if deleted, err := DeleteSkippedActivity(ctx, conn, athleteID+1, activityID); err != nil || deleted {
+		t.Fatalf("another athlete cleared the skip: %v, %v", deleted, err)
+	}
+
+	if deleted, err := DeleteSkippedActivity(ctx, conn, athleteID, activityID); err != nil || !deleted {
+		t.Fatalf("DeleteSkippedActivity = %v, %v; want true", deleted, err)
+	}
+	skipped, err := GetSkippedActivityIDs(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("GetSkippedActivityIDs: %v", err)
+	}
+	if skipped[activityID] {
+		t.Fatal("cleared activity is still skipped")
+	}
+}
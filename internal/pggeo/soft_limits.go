@@ -0,0 +1,126 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Soft limits on how far the database may grow
+const (
+	LimitPointSamples      = "point_samples"
+	LimitDatabaseSize      = "database_size"
+	LimitAthleteActivities = "athlete_activities"
+)
+
+// SoftLimits are thresholds on database growth; a zero maximum disables that limit.
+// Crossing one is warned about; with Enforce, syncs also stop fetching new activities.
+type SoftLimits struct {
+	MaxPointSamples         int64 `json:"max_point_samples,omitempty"`
+	MaxDatabaseBytes        int64 `json:"max_database_bytes,omitempty"`
+	MaxActivitiesPerAthlete int64 `json:"max_activities_per_athlete,omitempty"`
+	Enforce                 bool  `json:"enforce"`
+}
+
+// Enabled reports whether any limit is set
+func (l SoftLimits) Enabled() bool {
+	return l.MaxPointSamples > 0 || l.MaxDatabaseBytes > 0 || l.MaxActivitiesPerAthlete > 0
+}
+
+// InstanceUsage is how large the database has grown. PointSamples is the planner's row
+// estimate, which is cheap on a large table and refreshed by autovacuum, plus the points
+// of activities stored as streams, counted from one row per activity.
+type InstanceUsage struct {
+	PointSamples  int64 `json:"point_samples"`
+	DatabaseBytes int64 `json:"database_bytes"`
+	// TopAthleteID is the athlete with the most activities, TopAthleteActivities
+	// their count
+	TopAthleteID         int64 `json:"top_athlete_id,omitempty"`
+	TopAthleteActivities int64 `json:"top_athlete_activities"`
+}
+
+// LimitViolation is one soft limit the usage is past, with what an operator can do
+type LimitViolation struct {
+	Limit       string `json:"limit"`
+	Value       int64  `json:"value"`
+	Max         int64  `json:"max"`
+	AthleteID   int64  `json:"athlete_id,omitempty"`
+	Remediation string `json:"remediation"`
+}
+
+func (v LimitViolation) String() string {
+	switch v.Limit {
+	case LimitDatabaseSize:
+		return fmt.Sprintf("database size %d MB of %d MB", v.Value>>20, v.Max>>20)
+	case LimitAthleteActivities:
+		return fmt.Sprintf("athlete %d has %d activities of %d", v.AthleteID, v.Value, v.Max)
+	default:
+		return fmt.Sprintf("%d point samples of %d", v.Value, v.Max)
+	}
+}
+
+// limitRemediations suggest what to do about each limit
+var limitRemediations = map[string]string{
+	LimitPointSamples:      "delete old activities or reimport them without streams, or raise max_point_samples",
+	LimitDatabaseSize:      "delete unused athletes and activities, run VACUUM FULL after large deletions, or raise max_database_mb",
+	LimitAthleteActivities: "ask the athlete to delete old activities, or raise max_activities_per_athlete",
+}
+
+// Check returns the limits usage is past, in a fixed order
+func (l SoftLimits) Check(usage InstanceUsage) []LimitViolation {
+	var violations []LimitViolation
+	add := func(limit string, value, max, athleteID int64) {
+		if max > 0 && value > max {
+			violations = append(violations, LimitViolation{
+				Limit: limit, Value: value, Max: max, AthleteID: athleteID, Remediation: limitRemediations[limit],
+			})
+		}
+	}
+	add(LimitPointSamples, usage.PointSamples, l.MaxPointSamples, 0)
+	add(LimitDatabaseSize, usage.DatabaseBytes, l.MaxDatabaseBytes, 0)
+	add(LimitAthleteActivities, usage.TopAthleteActivities, l.MaxActivitiesPerAthlete, usage.TopAthleteID)
+	return violations
+}
+
+// DescribeLimitViolations joins the violations into one line
+func DescribeLimitViolations(violations []LimitViolation) string {
+	parts := make([]string, len(violations))
+	for i, v := range violations {
+		parts[i] = v.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// GetInstanceUsage measures the database for the soft limits. With athleteID the
+// activity count is that athlete's; without it, the athlete with the most activities.
+func GetInstanceUsage(ctx context.Context, conn DB, athleteID int64) (*InstanceUsage, error) {
+	usage := &InstanceUsage{}
+	if err := conn.QueryRow(ctx, `
+		SELECT GREATEST(c.reltuples, 0)::BIGINT
+				+ (SELECT COALESCE(SUM(point_count), 0) FROM activity_streams)::BIGINT,
+			pg_database_size(current_database())
+		FROM pg_class c
+		WHERE c.oid = 'point_samples'::regclass
+	`).Scan(&usage.PointSamples, &usage.DatabaseBytes); err != nil {
+		return nil, fmt.Errorf("failed to measure database size: %w", err)
+	}
+
+	query := `
+		SELECT athlete_id, COUNT(*) FROM activity_summaries
+		GROUP BY athlete_id
+		ORDER BY COUNT(*) DESC, athlete_id
+		LIMIT 1`
+	args := []interface{}{}
+	if athleteID != 0 {
+		query = `SELECT $1::BIGINT, COUNT(*) FROM activity_summaries WHERE athlete_id = $1`
+		args = append(args, athleteID)
+	}
+	err := conn.QueryRow(ctx, query, args...).Scan(&usage.TopAthleteID, &usage.TopAthleteActivities)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to count activities per athlete: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,115 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInstanceUsageCrossesSeededLimits(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000790)
+	const activities, samplesPerActivity = 4, 50
+	cleanup := func() {
+		ctx := context.Background()
+		_, _ = conn.Exec(ctx, `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(ctx, `DELETE FROM announcements WHERE created_by = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	before, err := GetInstanceUsage(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("GetInstanceUsage: %v", err)
+	}
+	if before.TopAthleteID != athleteID || before.TopAthleteActivities != 0 || before.DatabaseBytes <= 0 {
+		t.Fatalf("usage before seeding = %+v", before)
+	}
+
+	for i := int64(1); i <= activities; i++ {
+		activityID := athleteID*1000 + i
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'limits fixture', 500, 100, 100, 0, 'Ride', NOW() - make_interval(days => $3))
+		`, activityID, athleteID, int(i)); err != nil {
+			t.Fatalf("insert activity: %v", err)
+		}
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, cumulative_distance)
+			SELECT $1, $2, i, NOW() + make_interval(secs => i), ST_GeogFromText('POINT(7 45)'), i * 10
+			FROM generate_series(0, $3 - 1) AS i
+		`, activityID, athleteID, samplesPerActivity); err != nil {
+			t.Fatalf("insert samples: %v", err)
+		}
+	}
+	// The point count is the planner's estimate, which ANALYZE brings up to date
+	if _, err := conn.Exec(ctx, `ANALYZE point_samples`); err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+
+	usage, err := GetInstanceUsage(ctx, conn, athleteID)
+	if err != nil {
+		t.Fatalf("GetInstanceUsage after seeding: %v", err)
+	}
+	if usage.TopAthleteActivities != activities || usage.PointSamples < activities*samplesPerActivity {
+		t.Fatalf("usage after seeding = %+v, want %d activities and at least %d points", usage, activities, activities*samplesPerActivity)
+	}
+	instance, err := GetInstanceUsage(ctx, conn, 0)
+	if err != nil || instance.TopAthleteActivities < activities {
+		t.Fatalf("instance usage = %+v, %v; want the busiest athlete to have at least %d activities", instance, err, activities)
+	}
+
+	limits := SoftLimits{
+		MaxPointSamples:         usage.PointSamples - 1,
+		MaxDatabaseBytes:        usage.DatabaseBytes - 1,
+		MaxActivitiesPerAthlete: activities - 1,
+	}
+	violations := limits.Check(*usage)
+	if len(violations) != 3 || violations[2].AthleteID != athleteID {
+		t.Fatalf("violations = %+v, want every limit", violations)
+	}
+
+	// The warning banner is posted once and follows the violations
+	const prefix = "Storage limits: "
+	now := time.Now().UTC().Truncate(time.Second)
+	banner := func(message string) *Announcement {
+		t.Helper()
+		posted, err := ReplaceAnnouncement(ctx, conn, prefix, Announcement{
+			Message: message, Level: AnnouncementLevelWarning, CreatedBy: athleteID,
+		}, now)
+		if err != nil {
+			t.Fatalf("ReplaceAnnouncement(%q): %v", message, err)
+		}
+		return posted
+	}
+	active := func() []Announcement {
+		t.Helper()
+		all, err := GetActiveAnnouncements(ctx, conn, now.Add(time.Second), "")
+		if err != nil {
+			t.Fatalf("GetActiveAnnouncements: %v", err)
+		}
+		var ours []Announcement
+		for _, a := range all {
+			if a.CreatedBy == athleteID {
+				ours = append(ours, a)
+			}
+		}
+		return ours
+	}
+	first := banner(prefix + DescribeLimitViolations(violations))
+	if again := banner(prefix + DescribeLimitViolations(violations)); again == nil || again.ID != first.ID {
+		t.Fatalf("the same violations posted %+v, want announcement %d kept", again, first.ID)
+	}
+	updated := banner(prefix + DescribeLimitViolations(violations[:1]))
+	if got := active(); len(got) != 1 || got[0].ID != updated.ID || updated.ID == first.ID {
+		t.Fatalf("active after a change = %+v, want only the new announcement %d", got, updated.ID)
+	}
+	if cleared := banner(""); cleared != nil {
+		t.Fatalf("clearing posted %+v", cleared)
+	}
+	if got := active(); len(got) != 0 {
+		t.Fatalf("active after clearing = %+v, want none", got)
+	}
+}
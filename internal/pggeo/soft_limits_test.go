@@ -0,0 +1,57 @@
+package pggeo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSoftLimitsCheckReportsEachExceededLimit(t *testing.T) {
+	limits := SoftLimits{MaxPointSamples: 1000, MaxDatabaseBytes: 64 << 20, MaxActivitiesPerAthlete: 50}
+	usage := InstanceUsage{PointSamples: 1000, DatabaseBytes: 64 << 20, TopAthleteID: 7, TopAthleteActivities: 50}
+	if got := limits.Check(usage); len(got) != 0 {
+		t.Fatalf("usage at the limits reported %+v", got)
+	}
+
+	tests := []struct {
+		name  string
+		usage InstanceUsage
+		limit string
+	}{
+		{"point samples", InstanceUsage{PointSamples: 1001}, LimitPointSamples},
+		{"database size", InstanceUsage{DatabaseBytes: 65 << 20}, LimitDatabaseSize},
+		{"athlete activities", InstanceUsage{TopAthleteID: 7, TopAthleteActivities: 51}, LimitAthleteActivities},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limits.Check(tt.usage)
+			if len(got) != 1 || got[0].Limit != tt.limit {
+				t.Fatalf("Check = %+v, want only %s", got, tt.limit)
+			}
+			if got[0].Remediation == "" {
+				t.Fatalf("%s has no remediation", tt.limit)
+			}
+		})
+	}
+
+	all := limits.Check(InstanceUsage{PointSamples: 2000, DatabaseBytes: 128 << 20, TopAthleteID: 7, TopAthleteActivities: 80})
+	if len(all) != 3 || all[2].AthleteID != 7 {
+		t.Fatalf("Check = %+v, want all three limits", all)
+	}
+	description := DescribeLimitViolations(all)
+	for _, want := range []string{"2000 point samples of 1000", "database size 128 MB of 64 MB", "athlete 7 has 80 activities of 50"} {
+		if !strings.Contains(description, want) {
+			t.Fatalf("description %q does not mention %q", description, want)
+		}
+	}
+}
+
+func TestSoftLimitsZeroDisablesALimit(t *testing.T) {
+	limits := SoftLimits{MaxDatabaseBytes: 1 << 30}
+	if !limits.Enabled() || (SoftLimits{Enforce: true}).Enabled() {
+		t.Fatal("Enabled should follow the maximums only")
+	}
+	got := limits.Check(InstanceUsage{PointSamples: 1 << 40, TopAthleteActivities: 1 << 20})
+	if len(got) != 0 {
+		t.Fatalf("Check = %+v, want nothing past the disabled limits", got)
+	}
+}
@@ -0,0 +1,119 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultSparklinePoints is the length of the series GetActivitySparklines returns
+const DefaultSparklinePoints = 40
+
+// MaxSparklinePoints bounds the sparkline resolution a caller can request
+const MaxSparklinePoints = 200
+
+// sparklineColumns maps the sparkline metrics to their point_samples columns
+var sparklineColumns = map[string]string{
+	"speed":     "speed",
+	"heartrate": "heartrate",
+	"watts":     "watts",
+	"cadence":   "cadence",
+	"altitude":  "altitude",
+}
+
+// ValidSparklineMetric reports whether metric can be drawn as a sparkline
+func ValidSparklineMetric(metric string) bool {
+	_, ok := sparklineColumns[metric]
+	return ok
+}
+
+// GetActivitySparklines returns a downsampled series of metric for each activity, with
+// exactly points values. Samples are split into equal point_index buckets with
+// width_bucket and averaged, all activities in a single query. Buckets without samples
+// repeat the nearest earlier value (or the first value for leading gaps). Activities
+// without the metric are absent from the result.
+func GetActivitySparklines(ctx context.Context, conn DB, athleteID int64, activityIDs []int64, metric string, points int) (map[int64][]float64, error) {
+	column, ok := sparklineColumns[metric]
+	if !ok {
+		return nil, invalidInputf("invalid sparkline metric %q", metric)
+	}
+	if points < 1 || points > MaxSparklinePoints {
+		return nil, invalidInputf("sparkline points must be between 1 and %d", MaxSparklinePoints)
+	}
+	sparklines := make(map[int64][]float64)
+	if len(activityIDs) == 0 {
+		return sparklines, nil
+	}
+
+	query := fmt.Sprintf(`
+	WITH bounds AS (
+		SELECT activity_id, MIN(point_index) AS first_index, MAX(point_index) AS last_index
+		FROM point_sample_rows
+		WHERE athlete_id = $1 AND activity_id = ANY($2) AND %[1]s IS NOT NULL
+		GROUP BY activity_id
+	)
+	SELECT ps.activity_id,
+		width_bucket(ps.point_index, b.first_index, b.last_index + 1, $3) AS bucket,
+		ROUND(AVG(ps.%[1]s)::NUMERIC, 2)::DOUBLE PRECISION AS value
+	FROM point_sample_rows ps
+	JOIN bounds b ON b.activity_id = ps.activity_id
+	WHERE ps.athlete_id = $1 AND ps.activity_id = ANY($2) AND ps.%[1]s IS NOT NULL
+	GROUP BY ps.activity_id, bucket
+	ORDER BY ps.activity_id, bucket
+	`, column)
+
+	rows, err := conn.Query(ctx, query, athleteID, activityIDs, points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sparklines: %w", err)
+	}
+	defer rows.Close()
+
+	filled := make(map[int64][]bool)
+	for rows.Next() {
+		var activityID int64
+		var bucket int
+		var value float64
+		if err := rows.Scan(&activityID, &bucket, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan sparkline bucket: %w", err)
+		}
+		if bucket < 1 || bucket > points {
+			continue
+		}
+		if _, ok := sparklines[activityID]; !ok {
+			sparklines[activityID] = make([]float64, points)
+			filled[activityID] = make([]bool, points)
+		}
+		sparklines[activityID][bucket-1] = value
+		filled[activityID][bucket-1] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sparklines: %w", err)
+	}
+
+	for activityID, series := range sparklines {
+		fillSparklineGaps(series, filled[activityID])
+	}
+	return sparklines, nil
+}
+
+// fillSparklineGaps gives empty buckets the value of the nearest earlier bucket, and
+// leading empty buckets the first value
+func fillSparklineGaps(series []float64, filled []bool) {
+	first := -1
+	for i := range series {
+		if filled[i] {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return
+	}
+	for i := 0; i < first; i++ {
+		series[i] = series[first]
+	}
+	for i := first + 1; i < len(series); i++ {
+		if !filled[i] {
+			series[i] = series[i-1]
+		}
+	}
+}
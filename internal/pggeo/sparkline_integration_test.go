@@ -0,0 +1,76 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+)
+
+func TestActivitySparklinesBucketByPointIndex(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000104)
+	const longID, shortID, noSpeedID = int64(990000104001), int64(990000104002), int64(990000104003)
+	ids := []int64{longID, shortID, noSpeedID}
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM point_samples WHERE activity_id = ANY($1)`, ids)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = ANY($1)`, ids)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for _, id := range ids {
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+			VALUES ($1, $2, 'sparkline fixture', 0, 0, 0, 0, 'Ride', NOW())
+		`, id, athleteID); err != nil {
+			t.Fatalf("insert activity %d: %v", id, err)
+		}
+	}
+	// speed equals point_index, so every bucket averages a known run of indices
+	insertSamples := func(activityID int64, count int, withSpeed bool) {
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, speed)
+			SELECT $1, $2, i, NOW() + make_interval(secs => i),
+				ST_SetSRID(ST_MakePoint($4, $5 + i * 0.0001), 4326)::GEOGRAPHY,
+				CASE WHEN $6 THEN i::DOUBLE PRECISION END
+			FROM generate_series(0, $3 - 1) AS i
+		`, activityID, athleteID, count, selfCheckOriginLon, selfCheckOriginLat, withSpeed); err != nil {
+			t.Fatalf("insert samples for %d: %v", activityID, err)
+		}
+	}
+	insertSamples(longID, 100, true)
+	insertSamples(shortID, 4, true)
+	insertSamples(noSpeedID, 10, false)
+
+	sparklines, err := GetActivitySparklines(ctx, conn, athleteID, ids, "speed", 10)
+	if err != nil {
+		t.Fatalf("GetActivitySparklines: %v", err)
+	}
+
+	long := sparklines[longID]
+	if len(long) != 10 {
+		t.Fatalf("long sparkline = %v, want 10 values", long)
+	}
+	for bucket, got := range long {
+		if want := float64(bucket*10) + 4.5; got != want {
+			t.Fatalf("long sparkline = %v, bucket %d = %v, want %v", long, bucket, got, want)
+		}
+	}
+
+	// 4 samples over 10 buckets land in buckets 0, 2, 5 and 7; the gaps repeat the previous value
+	wantShort := []float64{0, 0, 1, 1, 1, 2, 2, 3, 3, 3}
+	short := sparklines[shortID]
+	if len(short) != len(wantShort) {
+		t.Fatalf("short sparkline = %v, want %v", short, wantShort)
+	}
+	for i := range wantShort {
+		if short[i] != wantShort[i] {
+			t.Fatalf("short sparkline = %v, want %v", short, wantShort)
+		}
+	}
+
+	if got, ok := sparklines[noSpeedID]; ok {
+		t.Fatalf("activity without speed got sparkline %v, want none", got)
+	}
+}
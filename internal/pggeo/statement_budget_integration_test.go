@@ -0,0 +1,176 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// statementBudget is the most statements an endpoint's queries may run: Base plus PerRow
+// for every row returned. PerRow > 0 documents a known per-row query; raise a budget only
+// together with a comment saying why.
+type statementBudget struct {
+	Base   int
+	PerRow int
+}
+
+func (b statementBudget) limit(rows int) int {
+	return b.Base + b.PerRow*rows
+}
+
+var statementBudgets = map[string]statementBudget{
+	// GetAllActivities is a single query regardless of list size
+	"activities list": {Base: 1},
+	// Index page: count, the page itself, pinned activities and type options
+	"activities page": {Base: 4},
+	// Cache age, the cached matches with their efforts and the summaries
+	"segment activities": {Base: 3},
+	// Matches joined with their activities in one query
+	"segment timeline": {Base: 1},
+	// Totals, weeks and months come from one grouping-sets query
+	"athlete stats": {Base: 1},
+	// The whole FeatureCollection is built by one query
+	"routes geojson": {Base: 1},
+	// Summary, point samples and graph data
+	"activity full": {Base: 3},
+}
+
+// tracedIntegrationPool opens a pool with the production tracer installed
+func tracedIntegrationPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("B11K_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("B11K_TEST_DATABASE_URL not set")
+	}
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	config.ConnConfig.Tracer = NewQueryTracer(DefaultSlowQueryThreshold)
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatalf("connect pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// assertStatementBudget runs op with a statement log and fails with the statement list
+// when it exceeds the named budget. op returns the number of rows it produced.
+func assertStatementBudget(t *testing.T, name string, op func(ctx context.Context) (int, error)) {
+	t.Helper()
+	budget, ok := statementBudgets[name]
+	if !ok {
+		t.Fatalf("no statement budget declared for %q", name)
+	}
+	ctx, statements := WithStatementLog(context.Background())
+	rows, err := op(ctx)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if rows == 0 {
+		t.Fatalf("%s returned no rows; the fixture is too small to catch per-row queries", name)
+	}
+	if got, limit := statements.Count(), budget.limit(rows); got > limit {
+		t.Fatalf("%s ran %d statements for %d rows, budget is %d:\n  %s",
+			name, got, rows, limit, strings.Join(statements.Statements(), "\n  "))
+	}
+}
+
+func TestEndpointStatementBudgets(t *testing.T) {
+	setupCtx, conn := connectIntegrationDB(t)
+	wipe := func() {
+		if _, _, err := WipeSeedData(context.Background(), conn); err != nil {
+			t.Fatalf("WipeSeedData: %v", err)
+		}
+	}
+	wipe()
+	t.Cleanup(wipe)
+
+	opts := DefaultSeedOptions()
+	opts.Athletes = 1
+	opts.ActivitiesPerAthlete = 30
+	opts.SegmentsPerAthlete = 2
+	opts.Seed = 7
+	seeded, err := SeedDemoData(setupCtx, conn, opts)
+	if err != nil {
+		t.Fatalf("SeedDemoData: %v", err)
+	}
+	athleteID := seeded.AthleteIDs[0]
+
+	var segmentID, activityID int64
+	if err := conn.QueryRow(setupCtx, `
+		SELECT id FROM favorite_segments WHERE athlete_id = $1 AND source = $2 ORDER BY id LIMIT 1
+	`, athleteID, SourceSeed).Scan(&segmentID); err != nil {
+		t.Fatalf("find seeded segment: %v", err)
+	}
+	if err := conn.QueryRow(setupCtx, `
+		SELECT id FROM activity_summaries WHERE athlete_id = $1 AND source = $2 ORDER BY id LIMIT 1
+	`, athleteID, SourceSeed).Scan(&activityID); err != nil {
+		t.Fatalf("find seeded activity: %v", err)
+	}
+
+	pool := tracedIntegrationPool(t)
+
+	assertStatementBudget(t, "activities list", func(ctx context.Context) (int, error) {
+		activities, err := GetAllActivities(ctx, pool, athleteID)
+		return len(activities), err
+	})
+
+	assertStatementBudget(t, "activities page", func(ctx context.Context) (int, error) {
+		if _, err := CountActivities(ctx, pool, athleteID, ActivityFilter{}); err != nil {
+			return 0, err
+		}
+		activities, err := QueryActivities(ctx, pool, athleteID, ActivityFilter{PinnedFirst: true, Limit: 20})
+		if err != nil {
+			return 0, err
+		}
+		if _, err := GetPinnedActivities(ctx, pool, athleteID); err != nil {
+			return 0, err
+		}
+		_, err = ListActivityTypes(ctx, pool, athleteID)
+		return len(activities), err
+	})
+
+	assertStatementBudget(t, "segment activities", func(ctx context.Context) (int, error) {
+		efforts, err := GetActivitiesForSegment(ctx, pool, athleteID, segmentID, DefaultSegmentToleranceM, "", false, 0, SegmentEffortFilter{})
+		return len(efforts), err
+	})
+
+	assertStatementBudget(t, "segment timeline", func(ctx context.Context) (int, error) {
+		efforts, err := GetSegmentTimeline(ctx, pool, athleteID, segmentID, DefaultSegmentToleranceM, TimelineMinOverlapPercentage)
+		return len(efforts), err
+	})
+
+	assertStatementBudget(t, "athlete stats", func(ctx context.Context) (int, error) {
+		stats, err := GetAthleteStats(ctx, pool, athleteID, ActivityFilter{}, DisplayZone{})
+		if err != nil {
+			return 0, err
+		}
+		return len(stats.Months), nil
+	})
+
+	assertStatementBudget(t, "routes geojson", func(ctx context.Context) (int, error) {
+		_, err := GetRoutesGeoJSON(ctx, pool, athleteID, nil, 0)
+		return 1, err
+	})
+
+	assertStatementBudget(t, "activity full", func(ctx context.Context) (int, error) {
+		if _, err := GetActivityByID(ctx, pool, athleteID, activityID); err != nil {
+			return 0, err
+		}
+		samples, err := GetPointSamplesForActivity(ctx, pool, athleteID, activityID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := GetGraphDataForActivity(ctx, pool, athleteID, activityID, []string{"heartrate", "speed", "altitude"}, false, nil); err != nil {
+			return 0, err
+		}
+		return len(samples), nil
+	})
+}
@@ -0,0 +1,146 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Breakdowns of AthleteStats
+const (
+	StatsGroupWeek  = "week"  // ISO weeks starting on Monday
+	StatsGroupMonth = "month" // calendar months
+)
+
+// kcalPerKilojoule converts mechanical work to food energy the way Strava does,
+// assuming roughly 24% efficiency
+const kcalPerKilojoule = 0.239006
+
+// StatsTotals sums a set of activities
+type StatsTotals struct {
+	Activities     int     `json:"activities"`
+	DistanceM      float64 `json:"distance_m"`
+	MovingTimeS    float64 `json:"moving_time_s"`
+	ElevationGainM float64 `json:"elevation_gain_m"`
+	Kilojoules     float64 `json:"kilojoules"`
+	Kcal           float64 `json:"kcal"`
+}
+
+// StatsAverages are per-activity means; SpeedMPS is total distance over total moving time
+type StatsAverages struct {
+	DistanceM      float64 `json:"distance_m"`
+	MovingTimeS    float64 `json:"moving_time_s"`
+	ElevationGainM float64 `json:"elevation_gain_m"`
+	Kilojoules     float64 `json:"kilojoules"`
+	SpeedMPS       float64 `json:"speed_mps"`
+}
+
+// StatsPeriod is the totals of one week or month starting at Start, a local date of the
+// zone the stats were grouped by
+type StatsPeriod struct {
+	Start time.Time `json:"start"`
+	StatsTotals
+}
+
+// AthleteStats summarises the athlete's activities in a date range. Weeks and Months
+// cover every period from the first to the last activity, empty ones with zero totals.
+type AthleteStats struct {
+	Totals   StatsTotals   `json:"totals"`
+	Averages StatsAverages `json:"averages"`
+	Weeks    []StatsPeriod `json:"weeks"`
+	Months   []StatsPeriod `json:"months"`
+}
+
+// GetAthleteStats aggregates the athlete's activities matching filter, such as those
+// starting in [filter.Start, filter.End), with one query; its order and paging are
+// ignored. Activities fall into the weeks and months of their local start in zone.
+func GetAthleteStats(ctx context.Context, conn DB, athleteID int64, filter ActivityFilter, zone DisplayZone) (*AthleteStats, error) {
+	where, args := filter.where(athleteID)
+	localStart, args := zone.localStartSQL("", args)
+	rows, err := conn.Query(ctx, `
+	SELECT GROUPING(week), GROUPING(month), week, month, COUNT(*),
+		   COALESCE(SUM(distance), 0), COALESCE(SUM(moving_time), 0),
+		   COALESCE(SUM(total_elevation_gain), 0), COALESCE(SUM(kilojoules), 0)
+	FROM (
+		SELECT distance, moving_time, total_elevation_gain, kilojoules,
+			   date_trunc('week', `+localStart+`) AS week,
+			   date_trunc('month', `+localStart+`) AS month
+		FROM activity_summaries
+		WHERE `+where+`
+	) a
+	GROUP BY GROUPING SETS ((), (week), (month))
+	ORDER BY week NULLS FIRST, month NULLS FIRST
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query athlete stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &AthleteStats{}
+	var weeks, months []StatsPeriod
+	for rows.Next() {
+		var noWeek, noMonth int
+		var week, month *time.Time
+		var totals StatsTotals
+		if err := rows.Scan(&noWeek, &noMonth, &week, &month, &totals.Activities,
+			&totals.DistanceM, &totals.MovingTimeS, &totals.ElevationGainM, &totals.Kilojoules); err != nil {
+			return nil, fmt.Errorf("failed to scan athlete stats: %w", err)
+		}
+		totals.Kcal = totals.Kilojoules * kcalPerKilojoule
+		switch {
+		case noWeek == 0:
+			weeks = append(weeks, StatsPeriod{Start: week.UTC(), StatsTotals: totals})
+		case noMonth == 0:
+			months = append(months, StatsPeriod{Start: month.UTC(), StatsTotals: totals})
+		default:
+			stats.Totals = totals
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read athlete stats: %w", err)
+	}
+
+	stats.Averages = averageStats(stats.Totals)
+	stats.Weeks = fillStatsPeriods(weeks, StatsGroupWeek)
+	stats.Months = fillStatsPeriods(months, StatsGroupMonth)
+	return stats, nil
+}
+
+func averageStats(totals StatsTotals) StatsAverages {
+	if totals.Activities == 0 {
+		return StatsAverages{}
+	}
+	n := float64(totals.Activities)
+	averages := StatsAverages{
+		DistanceM:      totals.DistanceM / n,
+		MovingTimeS:    totals.MovingTimeS / n,
+		ElevationGainM: totals.ElevationGainM / n,
+		Kilojoules:     totals.Kilojoules / n,
+	}
+	if totals.MovingTimeS > 0 {
+		averages.SpeedMPS = totals.DistanceM / totals.MovingTimeS
+	}
+	return averages
+}
+
+// fillStatsPeriods inserts empty periods between the sorted periods so a chart has one
+// bar per week or month
+func fillStatsPeriods(periods []StatsPeriod, group string) []StatsPeriod {
+	filled := []StatsPeriod{}
+	for _, period := range periods {
+		if len(filled) > 0 {
+			for next := nextStatsPeriod(filled[len(filled)-1].Start, group); next.Before(period.Start); next = nextStatsPeriod(next, group) {
+				filled = append(filled, StatsPeriod{Start: next})
+			}
+		}
+		filled = append(filled, period)
+	}
+	return filled
+}
+
+func nextStatsPeriod(start time.Time, group string) time.Time {
+	if group == StatsGroupMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
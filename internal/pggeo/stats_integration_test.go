@@ -0,0 +1,141 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestGetAthleteStatsAggregatesMonthsAndWeeks(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000403)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	rides := []struct {
+		start    time.Time
+		distance float64
+	}{
+		{time.Date(2023, 12, 31, 9, 0, 0, 0, time.UTC), 99000}, // outside 2024
+		{time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), 30000},
+		{time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC), 20000},
+		{time.Date(2024, 3, 10, 9, 0, 0, 0, time.UTC), 50000},
+	}
+	for i, ride := range rides {
+		activity := &strava.ActivitySummary{
+			ID:                 athleteID*1000 + int64(i+1),
+			AthleteID:          athleteID,
+			Name:               "Ride",
+			Type:               "Ride",
+			StartDate:          ride.start.Format(time.RFC3339),
+			Distance:           ride.distance,
+			MovingTime:         ride.distance / 8,
+			TotalElevationGain: 100,
+			Kilojoules:         500,
+		}
+		if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+			t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+		}
+	}
+
+	stats, err := GetAthleteStats(ctx, conn, athleteID, ActivityFilter{Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}, DisplayZone{})
+	if err != nil {
+		t.Fatalf("GetAthleteStats: %v", err)
+	}
+	if stats.Totals.Activities != 3 || stats.Totals.DistanceM != 100000 || stats.Totals.ElevationGainM != 300 || stats.Totals.Kilojoules != 1500 {
+		t.Fatalf("totals = %+v, want the three 2024 rides", stats.Totals)
+	}
+	if stats.Averages.SpeedMPS != 8 {
+		t.Fatalf("average speed = %v, want 8", stats.Averages.SpeedMPS)
+	}
+	if len(stats.Months) != 3 || stats.Months[0].DistanceM != 50000 || stats.Months[1].Activities != 0 || stats.Months[2].DistanceM != 50000 {
+		t.Fatalf("months = %+v, want January, an empty February and March", stats.Months)
+	}
+	if !stats.Months[0].Start.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("first month starts %s", stats.Months[0].Start)
+	}
+	// 2 and 4 January share the week of Monday 1 January
+	if len(stats.Weeks) == 0 || stats.Weeks[0].Activities != 2 || !stats.Weeks[0].Start.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("first week = %+v, want both January rides", stats.Weeks)
+	}
+
+	empty, err := GetAthleteStats(ctx, conn, athleteID, ActivityFilter{Start: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}, DisplayZone{})
+	if err != nil || empty.Totals.Activities != 0 || len(empty.Months) != 0 {
+		t.Fatalf("empty range = %+v, %v", empty, err)
+	}
+}
+
+func TestGetAthleteStatsGroupsTravelByDisplayZone(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000404)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// Rides in five zones around the weeks starting Monday 10 and 17 June 2024. Berlin
+	// is UTC+2 in June.
+	hour := float64(time.Hour / time.Second)
+	rides := []struct {
+		start     time.Time
+		utcOffset float64
+		distance  float64
+	}{
+		{time.Date(2024, 6, 9, 16, 0, 0, 0, time.UTC), 9 * hour, 1000},   // Tokyo Monday 01:00, Berlin Sunday 18:00
+		{time.Date(2024, 6, 10, 5, 0, 0, 0, time.UTC), -7 * hour, 2000},  // Los Angeles Sunday 22:00, Berlin Monday 07:00
+		{time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC), -4 * hour, 4000},  // New York midweek
+		{time.Date(2024, 6, 16, 15, 0, 0, 0, time.UTC), 10 * hour, 8000}, // Sydney Monday 01:00, Berlin Sunday 17:00
+		{time.Date(2024, 6, 12, 10, 0, 0, 0, time.UTC), 1 * hour, 16000}, // London midweek
+	}
+	for i, ride := range rides {
+		activity := &strava.ActivitySummary{
+			ID:                 athleteID*1000 + int64(i+1),
+			AthleteID:          athleteID,
+			Name:               "Ride",
+			Type:               "Ride",
+			StartDate:          ride.start.Format(time.RFC3339),
+			StartDateTime:      ride.start,
+			UtcOffset:          ride.utcOffset,
+			Distance:           ride.distance,
+			MovingTime:         ride.distance / 8,
+			TotalElevationGain: 10,
+		}
+		if err := InsertActivitySummaryUpsert(ctx, conn, activity); err != nil {
+			t.Fatalf("InsertActivitySummaryUpsert: %v", err)
+		}
+	}
+	berlin, err := LoadDisplayZone("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadDisplayZone: %v", err)
+	}
+
+	week := func(day int) time.Time { return time.Date(2024, 6, day, 0, 0, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		zone  DisplayZone
+		weeks map[time.Time]float64
+	}{
+		{DisplayZone{}, map[time.Time]float64{week(3): 6000, week(10): 17000, week(17): 8000}},
+		{berlin, map[time.Time]float64{week(3): 5000, week(10): 26000}},
+	} {
+		stats, err := GetAthleteStats(ctx, conn, athleteID, ActivityFilter{}, tc.zone)
+		if err != nil {
+			t.Fatalf("GetAthleteStats in %s: %v", tc.zone.Name(), err)
+		}
+		if stats.Totals.DistanceM != 31000 || len(stats.Weeks) != len(tc.weeks) {
+			t.Fatalf("%s weeks = %+v, want %v", tc.zone.Name(), stats.Weeks, tc.weeks)
+		}
+		for _, period := range stats.Weeks {
+			if want, ok := tc.weeks[period.Start]; !ok || period.DistanceM != want {
+				t.Fatalf("%s week %s = %.0f m, want %v", tc.zone.Name(), period.Start.Format("2006-01-02"), period.DistanceM, tc.weeks)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package pggeo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFillStatsPeriodsAddsEmptyPeriods(t *testing.T) {
+	month := func(m time.Month) time.Time { return time.Date(2024, m, 1, 0, 0, 0, 0, time.UTC) }
+	months := fillStatsPeriods([]StatsPeriod{
+		{Start: month(1), StatsTotals: StatsTotals{Activities: 2, DistanceM: 50000}},
+		{Start: month(4), StatsTotals: StatsTotals{Activities: 1, DistanceM: 20000}},
+	}, StatsGroupMonth)
+	if len(months) != 4 {
+		t.Fatalf("got %d months, want January to April", len(months))
+	}
+	for i, want := range []float64{50000, 0, 0, 20000} {
+		if !months[i].Start.Equal(month(time.Month(i+1))) || months[i].DistanceM != want {
+			t.Fatalf("month %d = %+v, want %s with %v m", i, months[i], month(time.Month(i+1)), want)
+		}
+	}
+
+	monday := time.Date(2024, 12, 23, 0, 0, 0, 0, time.UTC)
+	weeks := fillStatsPeriods([]StatsPeriod{{Start: monday}, {Start: monday.AddDate(0, 0, 21)}}, StatsGroupWeek)
+	if len(weeks) != 4 || !weeks[1].Start.Equal(monday.AddDate(0, 0, 7)) || !weeks[3].Start.Equal(time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("weeks = %+v, want four consecutive Mondays across the new year", weeks)
+	}
+
+	if empty := fillStatsPeriods(nil, StatsGroupMonth); empty == nil || len(empty) != 0 {
+		t.Fatalf("no periods = %#v, want an empty list", empty)
+	}
+}
+
+func TestAverageStats(t *testing.T) {
+	got := averageStats(StatsTotals{Activities: 4, DistanceM: 100000, MovingTimeS: 14400, ElevationGainM: 800, Kilojoules: 2000})
+	want := StatsAverages{DistanceM: 25000, MovingTimeS: 3600, ElevationGainM: 200, Kilojoules: 500, SpeedMPS: 100000.0 / 14400}
+	if got != want {
+		t.Fatalf("averageStats = %+v, want %+v", got, want)
+	}
+	if zero := averageStats(StatsTotals{}); zero != (StatsAverages{}) {
+		t.Fatalf("averageStats of nothing = %+v", zero)
+	}
+}
@@ -0,0 +1,43 @@
+package pggeo
+
+// TimingQuality counts irregular timestamps found in a stream of point samples
+type TimingQuality struct {
+	// DuplicateTimestamps counts samples sharing the timestamp of an earlier sample
+	DuplicateTimestamps int `json:"duplicate_timestamps"`
+	// BackwardTimestamps counts samples whose timestamp is earlier than one already seen
+	BackwardTimestamps int `json:"backward_timestamps"`
+}
+
+// Irregular reports whether any duplicate or backward timestamps were found
+func (q TimingQuality) Irregular() bool {
+	return q.DuplicateTimestamps > 0 || q.BackwardTimestamps > 0
+}
+
+// SampleIntervals returns the seconds each sample adds to the activity clock, for samples
+// in point_index order as the point sample queries return them. Samples with identical
+// timestamps are ordered by point_index: the first one carries the interval and the rest
+// contribute zero. A timestamp earlier than the latest one seen is clamped to a zero
+// interval and counted, so intervals are never negative and add up to the span between
+// the first and the latest timestamp. The first sample always has a zero interval.
+func SampleIntervals(samples []PointSample) ([]float64, TimingQuality) {
+	intervals := make([]float64, len(samples))
+	var quality TimingQuality
+	if len(samples) == 0 {
+		return intervals, quality
+	}
+
+	latest := samples[0].Time
+	for i := 1; i < len(samples); i++ {
+		t := samples[i].Time
+		switch {
+		case t.Equal(latest):
+			quality.DuplicateTimestamps++
+		case t.Before(latest):
+			quality.BackwardTimestamps++
+		default:
+			intervals[i] = t.Sub(latest).Seconds()
+			latest = t
+		}
+	}
+	return intervals, quality
+}
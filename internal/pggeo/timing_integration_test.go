@@ -0,0 +1,92 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestDuplicateTimestampsKeepDerivedMetricsSane(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID, activityID = int64(990000103), int64(990000103001)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM point_samples WHERE activity_id = $1`, activityID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = $1`, activityID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// A 1 Hz stream with a merged sensor sample (index 2 repeats second 1 with the same
+	// heart rate) and a clock glitch (index 4 jumps back to second 1)
+	offsets := []int{0, 1, 1, 2, 1, 3}
+	heartrates := []int{120, 130, 130, 150, 150, 170}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date)
+		VALUES ($1, $2, 'duplicate timestamps', 30, 3, 3, 0, 'Ride', '2024-05-01T08:00:00Z')
+	`, activityID, athleteID); err != nil {
+		t.Fatalf("insert activity: %v", err)
+	}
+	if _, err := conn.Exec(ctx, `
+		INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, heartrate, speed, cumulative_distance)
+		SELECT $1, $2, i - 1, TIMESTAMPTZ '2024-05-01T08:00:00Z' + make_interval(secs => ($3::INTEGER[])[i]),
+			ST_SetSRID(ST_MakePoint($5, $6 + i * 0.0001), 4326)::GEOGRAPHY,
+			($4::INTEGER[])[i], 10, (i - 1) * 10
+		FROM generate_subscripts($3::INTEGER[], 1) AS i
+	`, activityID, athleteID, offsets, heartrates, selfCheckOriginLon, selfCheckOriginLat); err != nil {
+		t.Fatalf("insert point samples: %v", err)
+	}
+
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	if err != nil {
+		t.Fatalf("GetPointSamplesForActivity: %v", err)
+	}
+	intervals, quality := SampleIntervals(samples)
+	wantIntervals := []float64{0, 1, 0, 1, 0, 1}
+	for i, want := range wantIntervals {
+		if intervals[i] != want {
+			t.Fatalf("intervals = %v, want %v", intervals, wantIntervals)
+		}
+	}
+	if quality.DuplicateTimestamps != 1 || quality.BackwardTimestamps != 1 {
+		t.Fatalf("quality = %+v, want 1 duplicate and 1 backward timestamp", quality)
+	}
+
+	zones := &strava.HeartRateZones{Zones: []strava.HRZone{{Min: 0, Max: 140}, {Min: 141, Max: 160}, {Min: 161, Max: 220}}}
+	distribution, err := GetHRZoneDistributionForActivity(ctx, conn, athleteID, activityID, zones)
+	if err != nil {
+		t.Fatalf("GetHRZoneDistributionForActivity: %v", err)
+	}
+	wantSeconds := []float64{1, 1, 1}
+	totalPercentage := 0.0
+	for i, zone := range distribution {
+		if math.IsNaN(zone.Percentage) || math.IsInf(zone.Percentage, 0) || zone.Seconds < 0 {
+			t.Fatalf("zone %d = %+v, want finite values", zone.Zone, zone)
+		}
+		if zone.Seconds != wantSeconds[i] {
+			t.Fatalf("zone %d seconds = %v, want %v", zone.Zone, zone.Seconds, wantSeconds[i])
+		}
+		totalPercentage += zone.Percentage
+	}
+	if math.Abs(totalPercentage-100) > 1e-9 {
+		t.Fatalf("zone percentages add up to %v, want 100", totalPercentage)
+	}
+
+	graph, err := GetGraphDataForActivity(ctx, conn, athleteID, activityID, []string{"heartrate", "speed"}, false, nil)
+	if err != nil {
+		t.Fatalf("GetGraphDataForActivity: %v", err)
+	}
+	if graph.Timing == nil || *graph.Timing != quality {
+		t.Fatalf("graph timing = %+v, want %+v", graph.Timing, quality)
+	}
+	// The repeated (second 1, 130 bpm) pair is sent once; the glitch sample differs in time
+	if len(graph.Heartrate) != len(samples)-1 {
+		t.Fatalf("heartrate points = %d, want %d", len(graph.Heartrate), len(samples)-1)
+	}
+	if len(graph.Speed) != len(samples)-1 {
+		t.Fatalf("speed points = %d, want %d", len(graph.Speed), len(samples)-1)
+	}
+}
@@ -0,0 +1,104 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultSegmentToleranceM is the matching tolerance used when neither the request, the
+// segment nor the athlete specify one.
+const DefaultSegmentToleranceM = 15.0
+
+// MaxSegmentToleranceM bounds stored tolerances; wider corridors match unrelated roads.
+const MaxSegmentToleranceM = 200.0
+
+// Where an effective tolerance came from, in order of precedence
+const (
+	ToleranceSourceRequest = "request"
+	ToleranceSourceSegment = "segment"
+	ToleranceSourceAthlete = "athlete"
+	ToleranceSourceDefault = "default"
+)
+
+// ValidToleranceMeters reports whether meters can be stored as a default tolerance
+func ValidToleranceMeters(meters float64) bool {
+	return !math.IsNaN(meters) && meters > 0 && meters <= MaxSegmentToleranceM
+}
+
+// ResolveTolerance picks the effective matching tolerance: an explicit request value wins,
+// then the segment's own default, then the athlete's setting, then DefaultSegmentToleranceM.
+func ResolveTolerance(explicit, segmentDefault, athleteDefault *float64) (float64, string) {
+	switch {
+	case explicit != nil:
+		return *explicit, ToleranceSourceRequest
+	case segmentDefault != nil:
+		return *segmentDefault, ToleranceSourceSegment
+	case athleteDefault != nil:
+		return *athleteDefault, ToleranceSourceAthlete
+	default:
+		return DefaultSegmentToleranceM, ToleranceSourceDefault
+	}
+}
+
+// AthleteSettings holds per-athlete preferences
+type AthleteSettings struct {
+	AthleteID         int64    `json:"athlete_id"`
+	DefaultToleranceM *float64 `json:"default_tolerance_m"`
+	// DisplayTimezone is the IANA zone activity times are shown in, nil for activity-local
+	DisplayTimezone *string `json:"display_timezone"`
+}
+
+// GetAthleteSettings returns the athlete's settings, or empty settings when none are stored
+func GetAthleteSettings(ctx context.Context, conn DB, athleteID int64) (*AthleteSettings, error) {
+	settings := AthleteSettings{AthleteID: athleteID}
+	err := conn.QueryRow(ctx, `
+		SELECT default_tolerance_m, display_timezone FROM athlete_settings WHERE athlete_id = $1
+	`, athleteID).Scan(&settings.DefaultToleranceM, &settings.DisplayTimezone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get athlete settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// SetAthleteDefaultTolerance stores the athlete's default tolerance; nil clears it
+func SetAthleteDefaultTolerance(ctx context.Context, conn DB, athleteID int64, meters *float64) (*AthleteSettings, error) {
+	if meters != nil && !ValidToleranceMeters(*meters) {
+		return nil, invalidInputf("invalid default tolerance %.2f", *meters)
+	}
+	settings := AthleteSettings{AthleteID: athleteID}
+	err := conn.QueryRow(ctx, `
+		INSERT INTO athlete_settings (athlete_id, default_tolerance_m, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (athlete_id) DO UPDATE
+		SET default_tolerance_m = EXCLUDED.default_tolerance_m, updated_at = NOW()
+		RETURNING default_tolerance_m, display_timezone
+	`, athleteID, meters).Scan(&settings.DefaultToleranceM, &settings.DisplayTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set athlete default tolerance: %w", err)
+	}
+	return &settings, nil
+}
+
+// SetSegmentDefaultTolerance stores the default tolerance of one of the athlete's segments;
+// nil clears it. Cached matches are keyed on tolerance, so existing cache rows stay valid.
+func SetSegmentDefaultTolerance(ctx context.Context, conn DB, athleteID, segmentID int64, meters *float64) (*FavoriteSegment, error) {
+	if meters != nil && !ValidToleranceMeters(*meters) {
+		return nil, invalidInputf("invalid default tolerance %.2f", *meters)
+	}
+	tag, err := conn.Exec(ctx, `
+		UPDATE favorite_segments
+		SET default_tolerance_m = $2, updated_at = NOW()
+		WHERE id = $1 AND athlete_id = $3
+	`, segmentID, meters, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set segment default tolerance: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, segmentNotFoundf(nil, "segment with ID %d not found", segmentID)
+	}
+	return GetFavoriteSegment(ctx, conn, athleteID, segmentID)
+}
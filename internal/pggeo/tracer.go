@@ -0,0 +1,107 @@
+package pggeo
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultSlowQueryThreshold is how long a statement may run before QueryTracer logs it
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// QueryTracer is the pgx tracer installed on every pool. It logs slow statements and,
+// when the context carries a StatementLog, records each statement so tests can assert
+// how many queries an operation runs.
+type QueryTracer struct {
+	// SlowQueryThreshold disables slow-query logging when <= 0
+	SlowQueryThreshold time.Duration
+}
+
+// NewQueryTracer returns a tracer logging statements slower than slowQueryThreshold
+func NewQueryTracer(slowQueryThreshold time.Duration) *QueryTracer {
+	return &QueryTracer{SlowQueryThreshold: slowQueryThreshold}
+}
+
+type traceStartKey struct{}
+
+type traceStart struct {
+	sql     string
+	started time.Time
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if statements := statementLogFrom(ctx); statements != nil {
+		statements.record(data.SQL)
+	}
+	return context.WithValue(ctx, traceStartKey{}, traceStart{sql: data.SQL, started: time.Now()})
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if t.SlowQueryThreshold <= 0 {
+		return
+	}
+	start, ok := ctx.Value(traceStartKey{}).(traceStart)
+	if !ok {
+		return
+	}
+	if elapsed := time.Since(start.started); elapsed >= t.SlowQueryThreshold {
+		logger().Warn("Slow query", "duration", elapsed.Round(time.Millisecond), "command", data.CommandTag.String(), "sql", CompactSQL(start.sql))
+	}
+}
+
+// StatementLog collects the statements run with a context from WithStatementLog
+type StatementLog struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+type statementLogKey struct{}
+
+// WithStatementLog returns a context whose statements are recorded in the returned log,
+// provided the connection uses QueryTracer
+func WithStatementLog(ctx context.Context) (context.Context, *StatementLog) {
+	statements := &StatementLog{}
+	return context.WithValue(ctx, statementLogKey{}, statements), statements
+}
+
+func statementLogFrom(ctx context.Context) *StatementLog {
+	statements, _ := ctx.Value(statementLogKey{}).(*StatementLog)
+	return statements
+}
+
+func (l *StatementLog) record(sql string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statements = append(l.statements, CompactSQL(sql))
+}
+
+// Count returns the number of statements recorded so far
+func (l *StatementLog) Count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.statements)
+}
+
+// Statements returns the recorded statements in execution order
+func (l *StatementLog) Statements() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.statements...)
+}
+
+// Reset forgets the recorded statements
+func (l *StatementLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.statements = nil
+}
+
+// CompactSQL collapses whitespace so a statement fits on one log line
+func CompactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
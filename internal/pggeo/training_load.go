@@ -0,0 +1,175 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+// TrainingLoadWeek is the load of the activities starting in the week from Start, the
+// local Monday in the load's time zone. ZoneSeconds holds the time spent in each HR
+// zone, Z1 first.
+type TrainingLoadWeek struct {
+	Start       time.Time `json:"start"`
+	Activities  int       `json:"activities"`
+	MovingTimeS float64   `json:"moving_time_s"`
+	DistanceM   float64   `json:"distance_m"`
+	SufferScore float64   `json:"suffer_score"`
+	ZoneSeconds []float64 `json:"zone_seconds"`
+}
+
+// TrainingLoad is the athlete's weekly load, oldest week first, with the zones the time
+// in zone was bucketed by and the time zone weeks were cut in
+type TrainingLoad struct {
+	Timezone string               `json:"timezone"`
+	Zones    []HRZoneDistribution `json:"zones"`
+	Weeks    []TrainingLoadWeek   `json:"weeks"`
+}
+
+// GetTimeInZones returns the seconds the activity spent in each of the zones, Z1 first.
+// Samples without heart rate add nothing.
+func GetTimeInZones(ctx context.Context, conn DB, athleteID, activityID int64, zones *strava.HeartRateZones) ([]float64, error) {
+	samples, err := GetPointSamplesForActivity(ctx, conn, athleteID, activityID)
+	if err != nil {
+		return nil, err
+	}
+	return timeInZones(samples, zones), nil
+}
+
+// timeInZones buckets the intervals of samples with heart rate by calculateHRZone
+func timeInZones(samples []PointSample, zones *strava.HeartRateZones) []float64 {
+	if zones == nil || len(zones.Zones) == 0 {
+		return []float64{}
+	}
+	seconds := make([]float64, len(zones.Zones))
+	intervals, _ := SampleIntervals(samples)
+	for i, sample := range samples {
+		if sample.Heartrate == nil || *sample.Heartrate <= 0 {
+			continue
+		}
+		if zone := calculateHRZone(*sample.Heartrate, zones); zone > 0 && zone <= len(seconds) {
+			seconds[zone-1] += intervals[i]
+		}
+	}
+	return seconds
+}
+
+// GetTrainingLoad returns the athlete's load for the last weeks weeks up to the one holding
+// now, every week listed even without activities. Activities fall into the week of their
+// local start in displayZone. Point samples are read one activity at a time, so memory
+// does not grow with the number of activities.
+func GetTrainingLoad(ctx context.Context, conn DB, athleteID int64, weeks int, now time.Time, zones *strava.HeartRateZones, displayZone DisplayZone) (*TrainingLoad, error) {
+	zoneCount := 0
+	if zones != nil {
+		zoneCount = len(zones.Zones)
+	}
+	load := &TrainingLoad{
+		Timezone: displayZone.Name(),
+		Zones:    calculateHRZoneDistribution(nil, zones),
+		Weeks:    trainingLoadWeeks(displayZone.wallClock(now), weeks, zoneCount),
+	}
+	if len(load.Weeks) == 0 {
+		return load, nil
+	}
+	// A day of slack either side of the first week covers every UTC offset; activities
+	// starting before it locally fall outside byStart
+	since := load.Weeks[0].Start.AddDate(0, 0, -1)
+	byStart := make(map[time.Time]*TrainingLoadWeek, len(load.Weeks))
+	for i := range load.Weeks {
+		byStart[load.Weeks[i].Start] = &load.Weeks[i]
+	}
+
+	localStart, args := displayZone.localStartSQL("", []any{athleteID, since})
+	rows, err := conn.Query(ctx, `
+	SELECT date_trunc('week', `+localStart+`) AS week, COUNT(*),
+		   COALESCE(SUM(moving_time), 0), COALESCE(SUM(distance), 0), COALESCE(SUM(suffer_score), 0)
+	FROM activity_summaries
+	WHERE athlete_id = $1 AND start_date >= $2
+	GROUP BY week
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query training load: %w", err)
+	}
+	for rows.Next() {
+		var start time.Time
+		var totals TrainingLoadWeek
+		if err := rows.Scan(&start, &totals.Activities, &totals.MovingTimeS, &totals.DistanceM, &totals.SufferScore); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan training load: %w", err)
+		}
+		if week, ok := byStart[start.UTC()]; ok {
+			week.Activities, week.MovingTimeS, week.DistanceM, week.SufferScore = totals.Activities, totals.MovingTimeS, totals.DistanceM, totals.SufferScore
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read training load: %w", err)
+	}
+	if zoneCount == 0 {
+		return load, nil
+	}
+
+	localStart, args = displayZone.localStartSQL("a", []any{athleteID, since})
+	rows, err = conn.Query(ctx, `
+	SELECT ps.activity_id, date_trunc('week', `+localStart+`), ps.time, ps.heartrate
+	FROM point_sample_rows ps
+	INNER JOIN activity_summaries a ON a.id = ps.activity_id
+	WHERE a.athlete_id = $1 AND ps.athlete_id = $1 AND a.start_date >= $2
+	ORDER BY ps.activity_id, ps.point_index
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query training load samples: %w", err)
+	}
+	defer rows.Close()
+	var activityID int64
+	var activityWeek time.Time
+	var samples []PointSample
+	flush := func() {
+		if week, ok := byStart[activityWeek.UTC()]; ok {
+			for zone, seconds := range timeInZones(samples, zones) {
+				week.ZoneSeconds[zone] += seconds
+			}
+		}
+		samples = samples[:0]
+	}
+	for rows.Next() {
+		var sample PointSample
+		var start time.Time
+		if err := rows.Scan(&sample.ActivityID, &start, &sample.Time, &sample.Heartrate); err != nil {
+			return nil, fmt.Errorf("failed to scan training load sample: %w", err)
+		}
+		if sample.ActivityID != activityID && len(samples) > 0 {
+			flush()
+		}
+		activityID, activityWeek = sample.ActivityID, start
+		samples = append(samples, sample)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read training load samples: %w", err)
+	}
+	if len(samples) > 0 {
+		flush()
+	}
+	return load, nil
+}
+
+// trainingLoadWeeks returns the weeks empty weeks ending with the one holding now, a wall
+// clock time in UTC, each with zones zeroed zone totals
+func trainingLoadWeeks(now time.Time, weeks, zones int) []TrainingLoadWeek {
+	if weeks <= 0 {
+		return []TrainingLoadWeek{}
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	// Weeks start on Monday, as date_trunc('week') does
+	current := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	result := make([]TrainingLoadWeek, weeks)
+	for i := range result {
+		result[i] = TrainingLoadWeek{
+			Start:       current.AddDate(0, 0, -7*(weeks-1-i)),
+			ZoneSeconds: make([]float64, zones),
+		}
+	}
+	return result
+}
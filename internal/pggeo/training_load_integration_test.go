@@ -0,0 +1,77 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestTrainingLoadSumsWeeksAndTimeInZones(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000104)
+	activityIDs := []int64{990000104001, 990000104002, 990000104003}
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM point_samples WHERE activity_id = ANY($1)`, activityIDs)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE id = ANY($1)`, activityIDs)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	// Two rides in the week of Monday 2024-06-03 and one three weeks earlier, outside a
+	// two-week window
+	now := time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC)
+	starts := []time.Time{
+		time.Date(2024, 6, 3, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 6, 4, 8, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 13, 8, 0, 0, 0, time.UTC),
+	}
+	heartrates := [][]int{{110, 110, 130, 130}, {170, 170, 170}, {170, 170}}
+	for i, id := range activityIDs {
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO activity_summaries (id, athlete_id, name, distance, moving_time, elapsed_time, total_elevation_gain, type, start_date, suffer_score)
+			VALUES ($1, $2, 'training load', 1000, 600, 600, 0, 'Ride', $3, 20)
+		`, id, athleteID, starts[i]); err != nil {
+			t.Fatalf("insert activity: %v", err)
+		}
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO point_samples (activity_id, athlete_id, point_index, time, location, heartrate)
+			SELECT $1, $2, i - 1, $3::TIMESTAMPTZ + make_interval(secs => (i - 1) * 10),
+				ST_SetSRID(ST_MakePoint($5, $6 + i * 0.0001), 4326)::GEOGRAPHY, ($4::INTEGER[])[i]
+			FROM generate_subscripts($4::INTEGER[], 1) AS i
+		`, id, athleteID, starts[i], heartrates[i], selfCheckOriginLon, selfCheckOriginLat); err != nil {
+			t.Fatalf("insert point samples: %v", err)
+		}
+	}
+
+	zones := &strava.HeartRateZones{Zones: []strava.HRZone{{Min: 0, Max: 120}, {Min: 121, Max: 150}, {Min: 151, Max: -1}}}
+	load, err := GetTrainingLoad(ctx, conn, athleteID, 2, now, zones, DisplayZone{})
+	if err != nil {
+		t.Fatalf("GetTrainingLoad: %v", err)
+	}
+	if len(load.Weeks) != 2 || len(load.Zones) != 3 {
+		t.Fatalf("load = %+v, want 2 weeks and 3 zones", load)
+	}
+	if empty := load.Weeks[0]; empty.Activities != 0 || empty.ZoneSeconds[2] != 0 {
+		t.Fatalf("previous week = %+v, want empty", empty)
+	}
+	week := load.Weeks[1]
+	if week.Activities != 2 || week.MovingTimeS != 1200 || week.DistanceM != 2000 || week.SufferScore != 40 {
+		t.Fatalf("current week = %+v, want 2 activities, 1200 s, 2000 m and suffer score 40", week)
+	}
+	// Each sample counts the 10 s since the previous one of its activity
+	want := []float64{10, 20, 20}
+	for i := range want {
+		if week.ZoneSeconds[i] != want[i] {
+			t.Fatalf("time in zones = %v, want %v", week.ZoneSeconds, want)
+		}
+	}
+
+	seconds, err := GetTimeInZones(ctx, conn, athleteID, activityIDs[0], zones)
+	if err != nil || len(seconds) != 3 || seconds[0] != 10 || seconds[1] != 20 {
+		t.Fatalf("GetTimeInZones = %v, %v; want [10 20 0]", seconds, err)
+	}
+}
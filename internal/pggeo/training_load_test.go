@@ -0,0 +1,70 @@
+package pggeo
+
+import (
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestTimeInZonesBucketsSampleIntervals(t *testing.T) {
+	zones := &strava.HeartRateZones{Zones: []strava.HRZone{{Min: 0, Max: 120}, {Min: 121, Max: 150}, {Min: 151, Max: -1}}}
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	hr := func(v int) *int { return &v }
+	samples := []PointSample{
+		{Time: start, Heartrate: hr(100)},
+		{Time: start.Add(2 * time.Second), Heartrate: hr(110)},  // 2 s in Z1
+		{Time: start.Add(5 * time.Second), Heartrate: hr(130)},  // 3 s in Z2
+		{Time: start.Add(5 * time.Second), Heartrate: hr(140)},  // duplicate timestamp adds nothing
+		{Time: start.Add(6 * time.Second)},                      // no heart rate
+		{Time: start.Add(10 * time.Second), Heartrate: hr(170)}, // 4 s in Z3
+		{Time: start.Add(11 * time.Second), Heartrate: hr(0)},   // dropout
+		{Time: start.Add(15 * time.Second), Heartrate: hr(210)}, // above every zone counts as Z3
+		{Time: start.Add(14 * time.Second), Heartrate: hr(125)}, // backward timestamp adds nothing
+		{Time: start.Add(16 * time.Second), Heartrate: hr(121)}, // 1 s in Z2
+	}
+	got := timeInZones(samples, zones)
+	want := []float64{2, 4, 8}
+	if len(got) != len(want) {
+		t.Fatalf("timeInZones = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("timeInZones = %v, want %v", got, want)
+		}
+	}
+
+	if got := timeInZones(samples, nil); len(got) != 0 {
+		t.Fatalf("timeInZones without zones = %v, want none", got)
+	}
+}
+
+func TestTrainingLoadWeeksEndWithCurrentWeek(t *testing.T) {
+	// Sunday evening in UTC+2 is still Sunday in UTC
+	now := time.Date(2024, 6, 9, 23, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	weeks := trainingLoadWeeks(DisplayZone{}.wallClock(now), 3, 5)
+	if len(weeks) != 3 {
+		t.Fatalf("weeks = %d, want 3", len(weeks))
+	}
+	for i, want := range []time.Time{
+		time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 27, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+	} {
+		if !weeks[i].Start.Equal(want) || len(weeks[i].ZoneSeconds) != 5 {
+			t.Fatalf("week %d = %+v, want start %s with 5 zones", i, weeks[i], want)
+		}
+	}
+	// Monday half past midnight in Berlin is already the next week there
+	berlin, err := LoadDisplayZone("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("LoadDisplayZone: %v", err)
+	}
+	monday := time.Date(2024, 6, 9, 22, 30, 0, 0, time.UTC)
+	if got := trainingLoadWeeks(berlin.wallClock(monday), 1, 0); !got[0].Start.Equal(time.Date(2024, 6, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Berlin week starts %s, want 2024-06-10", got[0].Start)
+	}
+	if got := trainingLoadWeeks(now, 0, 5); len(got) != 0 {
+		t.Fatalf("zero weeks = %+v, want none", got)
+	}
+}
@@ -0,0 +1,102 @@
+package pggeo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+// Activity visibility values stored in activity_summaries.visibility.
+// Private activities are only shown to their owner; instance activities may be shown
+// to other athletes on the same B11K instance.
+const (
+	ActivityVisibilityPrivate  = "private"
+	ActivityVisibilityInstance = "instance"
+)
+
+// ValidActivityVisibility reports whether v is a supported visibility value
+func ValidActivityVisibility(v string) bool {
+	return v == ActivityVisibilityPrivate || v == ActivityVisibilityInstance
+}
+
+// ActivityVisibleTo reports whether viewerAthleteID may see the activity.
+// Owners always see their own activities; other athletes only see instance-visible ones.
+func ActivityVisibleTo(activity strava.ActivitySummary, viewerAthleteID int64) bool {
+	return activity.AthleteID == viewerAthleteID || activity.InstanceVisibility == ActivityVisibilityInstance
+}
+
+// visibleToViewerSQL is the WHERE fragment matching ActivityVisibleTo for an activity_summaries alias
+func visibleToViewerSQL(alias, viewerParam string) string {
+	return fmt.Sprintf("(%[1]s.athlete_id = %[2]s OR %[1]s.visibility = '%[3]s')", alias, viewerParam, ActivityVisibilityInstance)
+}
+
+// SetActivityVisibility changes the visibility of a single activity owned by athleteID
+func SetActivityVisibility(ctx context.Context, conn DB, athleteID, activityID int64, visibility string) error {
+	if !ValidActivityVisibility(visibility) {
+		return invalidInputf("invalid visibility %q", visibility)
+	}
+	tag, err := conn.Exec(ctx, `
+		UPDATE activity_summaries
+		SET visibility = $1, updated_at = NOW()
+		WHERE athlete_id = $2 AND id = $3
+	`, visibility, athleteID, activityID)
+	if err != nil {
+		return fmt.Errorf("failed to update activity visibility: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return activityNotFoundf(nil, "activity with ID %d not found", activityID)
+	}
+	return nil
+}
+
+// ActivityVisibilityFilter selects the activities changed by SetActivitiesVisibility.
+// Empty fields do not filter.
+type ActivityVisibilityFilter struct {
+	ActivityIDs []int64    `json:"activity_ids,omitempty"`
+	StartDate   *time.Time `json:"start,omitempty"`
+	EndDate     *time.Time `json:"end,omitempty"`
+	Type        string     `json:"type,omitempty"`
+	Visibility  string     `json:"visibility,omitempty"`
+}
+
+// SetActivitiesVisibility changes the visibility of all of athleteID's activities matching
+// the filter and returns the number of updated rows
+func SetActivitiesVisibility(ctx context.Context, conn DB, athleteID int64, visibility string, filter ActivityVisibilityFilter) (int64, error) {
+	if !ValidActivityVisibility(visibility) {
+		return 0, invalidInputf("invalid visibility %q", visibility)
+	}
+
+	query := `
+	UPDATE activity_summaries
+	SET visibility = $1, updated_at = NOW()
+	WHERE athlete_id = $2 AND visibility <> $1`
+	args := []interface{}{visibility, athleteID}
+	if len(filter.ActivityIDs) > 0 {
+		args = append(args, filter.ActivityIDs)
+		query += fmt.Sprintf(" AND id = ANY($%d)", len(args))
+	}
+	if filter.StartDate != nil {
+		args = append(args, *filter.StartDate)
+		query += fmt.Sprintf(" AND start_date >= $%d", len(args))
+	}
+	if filter.EndDate != nil {
+		args = append(args, *filter.EndDate)
+		query += fmt.Sprintf(" AND start_date < $%d", len(args))
+	}
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		query += fmt.Sprintf(" AND type = $%d", len(args))
+	}
+	if filter.Visibility != "" {
+		args = append(args, filter.Visibility)
+		query += fmt.Sprintf(" AND visibility = $%d", len(args))
+	}
+
+	tag, err := conn.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update activity visibility: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,109 @@
+package pggeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// WebSession is a web login as listed on the settings page. Its Strava tokens stay in
+// athlete_tokens under the same TokenKey.
+type WebSession struct {
+	ID         int64
+	TokenKey   string
+	AthleteID  int64
+	UserAgent  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// TouchWebSession records that the login stored under tokenKey was used at usedAt. The
+// row is created on first use, dated from the login; revoked logins are left alone.
+func TouchWebSession(ctx context.Context, conn DB, tokenKey, userAgent string, usedAt time.Time) error {
+	_, err := conn.Exec(ctx, `
+		INSERT INTO web_sessions (token_key, athlete_id, user_agent, created_at, last_used_at)
+		SELECT token_key, athlete_id, $2, COALESCE(created_at, $3), $3
+		FROM athlete_tokens
+		WHERE token_key = $1
+		ON CONFLICT (token_key) DO UPDATE SET
+			user_agent = EXCLUDED.user_agent,
+			last_used_at = GREATEST(web_sessions.last_used_at, EXCLUDED.last_used_at)
+	`, tokenKey, userAgent, usedAt)
+	if err != nil {
+		return fmt.Errorf("failed to touch web session: %w", err)
+	}
+	return nil
+}
+
+// ListWebSessions returns the athlete's web logins, most recently used first
+func ListWebSessions(ctx context.Context, conn DB, athleteID int64) ([]WebSession, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT id, token_key, athlete_id, user_agent, created_at, last_used_at
+		FROM web_sessions
+		WHERE athlete_id = $1
+		ORDER BY last_used_at DESC, id DESC
+	`, athleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query web sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]WebSession, 0)
+	for rows.Next() {
+		var session WebSession
+		if err := rows.Scan(&session.ID, &session.TokenKey, &session.AthleteID, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan web session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeWebSession deletes one of the athlete's web logins with its stored tokens and
+// returns its token key, or "" when the athlete has no such session
+func RevokeWebSession(ctx context.Context, conn DB, athleteID, sessionID int64) (string, error) {
+	var tokenKey string
+	err := conn.QueryRow(ctx, `
+		WITH revoked AS (
+			DELETE FROM web_sessions WHERE athlete_id = $1 AND id = $2 RETURNING token_key
+		), tokens AS (
+			DELETE FROM athlete_tokens WHERE token_key IN (SELECT token_key FROM revoked)
+		), dismissals AS (
+			DELETE FROM announcement_dismissals WHERE token_key IN (SELECT token_key FROM revoked)
+		)
+		SELECT token_key FROM revoked
+	`, athleteID, sessionID).Scan(&tokenKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to revoke web session: %w", err)
+	}
+	return tokenKey, nil
+}
+
+// DeleteWebLoginsCreatedBefore deletes the logins stored under keys starting with
+// keyPrefix that were created before cutoff, with their web_sessions rows and
+// announcement dismissals, and returns how many it deleted
+func DeleteWebLoginsCreatedBefore(ctx context.Context, conn DB, keyPrefix string, cutoff time.Time) (int64, error) {
+	var deleted int64
+	err := conn.QueryRow(ctx, `
+		WITH expired AS (
+			DELETE FROM athlete_tokens
+			WHERE starts_with(token_key, $1) AND COALESCE(created_at, updated_at) < $2
+			RETURNING token_key
+		), sessions AS (
+			DELETE FROM web_sessions WHERE token_key IN (SELECT token_key FROM expired)
+		), dismissals AS (
+			DELETE FROM announcement_dismissals WHERE token_key IN (SELECT token_key FROM expired)
+		)
+		SELECT COUNT(*) FROM expired
+	`, keyPrefix, cutoff).Scan(&deleted)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired web logins: %w", err)
+	}
+	return deleted, nil
+}
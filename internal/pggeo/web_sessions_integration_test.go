@@ -0,0 +1,130 @@
+//go:build integration
+
+package pggeo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWebSessionsTouchListAndRevoke(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000777)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM web_sessions WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM athlete_tokens WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for _, key := range []string{"session:a", "session:b"} {
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO athlete_tokens (token_key, athlete_id, access_token, refresh_token, expires_at)
+			VALUES ($1, $2, 'access', 'refresh', NOW() + INTERVAL '1 hour')
+		`, key, athleteID); err != nil {
+			t.Fatalf("insert token: %v", err)
+		}
+	}
+
+	used := time.Now().UTC().Truncate(time.Second)
+	if err := TouchWebSession(ctx, conn, "session:a", "Laptop", used); err != nil {
+		t.Fatalf("TouchWebSession: %v", err)
+	}
+	// An older, late write does not move last_used_at back
+	if err := TouchWebSession(ctx, conn, "session:a", "Laptop 2", used.Add(-time.Hour)); err != nil {
+		t.Fatalf("TouchWebSession: %v", err)
+	}
+	if err := TouchWebSession(ctx, conn, "session:b", "Phone", used.Add(-time.Minute)); err != nil {
+		t.Fatalf("TouchWebSession: %v", err)
+	}
+	// Unknown logins get no row
+	if err := TouchWebSession(ctx, conn, "session:gone", "Ghost", used); err != nil {
+		t.Fatalf("TouchWebSession: %v", err)
+	}
+
+	sessions, err := ListWebSessions(ctx, conn, athleteID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("ListWebSessions = %+v, %v; want 2", sessions, err)
+	}
+	if sessions[0].TokenKey != "session:a" || !sessions[0].LastUsedAt.Equal(used) || sessions[0].UserAgent != "Laptop 2" {
+		t.Fatalf("most recent session = %+v", sessions[0])
+	}
+
+	if key, err := RevokeWebSession(ctx, conn, athleteID+1, sessions[1].ID); err != nil || key != "" {
+		t.Fatalf("another athlete revoked %q, %v", key, err)
+	}
+	key, err := RevokeWebSession(ctx, conn, athleteID, sessions[1].ID)
+	if err != nil || key != "session:b" {
+		t.Fatalf("RevokeWebSession = %q, %v", key, err)
+	}
+	var tokens int
+	if err := conn.QueryRow(ctx, `SELECT COUNT(*) FROM athlete_tokens WHERE token_key = 'session:b'`).Scan(&tokens); err != nil || tokens != 0 {
+		t.Fatalf("revoked session kept %d token rows, %v", tokens, err)
+	}
+	// A use queued before the revocation cannot bring the session back
+	if err := TouchWebSession(ctx, conn, "session:b", "Phone", used); err != nil {
+		t.Fatalf("TouchWebSession: %v", err)
+	}
+	if sessions, _ := ListWebSessions(ctx, conn, athleteID); len(sessions) != 1 {
+		t.Fatalf("sessions after revoking = %+v, want 1", sessions)
+	}
+}
+
+func TestDeleteWebLoginsCreatedBefore(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000778)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM web_sessions WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM athlete_tokens WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for key, created := range map[string]time.Time{
+		"session:old":   now.Add(-31 * 24 * time.Hour),
+		"session:fresh": now.Add(-time.Hour),
+		"other:old":     now.Add(-31 * 24 * time.Hour),
+	} {
+		if _, err := conn.Exec(ctx, `
+			INSERT INTO athlete_tokens (token_key, athlete_id, access_token, refresh_token, expires_at, created_at)
+			VALUES ($1, $2, 'access', 'refresh', NOW() + INTERVAL '1 hour', $3)
+		`, key, athleteID, created); err != nil {
+			t.Fatalf("insert token: %v", err)
+		}
+		if err := TouchWebSession(ctx, conn, key, "Laptop", now); err != nil {
+			t.Fatalf("TouchWebSession: %v", err)
+		}
+	}
+
+	deleted, err := DeleteWebLoginsCreatedBefore(ctx, conn, "session:", now.Add(-30*24*time.Hour))
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteWebLoginsCreatedBefore = %d, %v; want 1", deleted, err)
+	}
+	var keys []string
+	rows, err := conn.Query(ctx, `SELECT token_key FROM athlete_tokens WHERE athlete_id = $1 ORDER BY token_key`, athleteID)
+	if err != nil {
+		t.Fatalf("query tokens: %v", err)
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatalf("scan token: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if len(keys) != 2 || keys[0] != "other:old" || keys[1] != "session:fresh" {
+		t.Fatalf("tokens kept = %v, want other:old and session:fresh", keys)
+	}
+	sessions, err := ListWebSessions(ctx, conn, athleteID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("ListWebSessions = %+v, %v; want the 2 kept logins", sessions, err)
+	}
+	for _, session := range sessions {
+		if session.TokenKey == "session:old" {
+			t.Fatal("the expired login kept its web_sessions row")
+		}
+	}
+}
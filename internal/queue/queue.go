@@ -0,0 +1,289 @@
+// Package queue provides the bounded in-memory queues behind B11K's background work. Each
+// queue has a fixed capacity and an overflow policy, and counts what went through it so
+// GET /api/admin/queues can show depth, drops and latency.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Policy decides what Push does when the queue is full
+type Policy string
+
+const (
+	// Block waits for room, or for the push context to end
+	Block Policy = "block"
+	// DropOldest evicts the oldest item to make room
+	DropOldest Policy = "drop_oldest"
+	// Spill hands the item to the queue's spill function, which persists it elsewhere
+	Spill Policy = "spill"
+)
+
+// ErrClosed is returned by Push once Close has been called
+var ErrClosed = errors.New("queue closed")
+
+// Options configure a Queue. Spill is required with the Spill policy; OnDrop, when set,
+// is called with each item DropOldest evicts.
+type Options[T any] struct {
+	Capacity int
+	Policy   Policy
+	Spill    func(T) error
+	OnDrop   func(T)
+}
+
+// Stats is a snapshot of a queue's counters. Enqueued = Dequeued + Dropped + Depth;
+// spilled items, and those whose spill failed, never enter the queue.
+type Stats struct {
+	Name         string  `json:"name"`
+	Policy       Policy  `json:"policy"`
+	Capacity     int     `json:"capacity"`
+	Depth        int     `json:"depth"`
+	MaxDepth     int     `json:"max_depth"`
+	Enqueued     uint64  `json:"enqueued"`
+	Dequeued     uint64  `json:"dequeued"`
+	Processed    uint64  `json:"processed"`
+	Dropped      uint64  `json:"dropped"`
+	Spilled      uint64  `json:"spilled"`
+	SpillErrors  uint64  `json:"spill_errors"`
+	Blocked      uint64  `json:"blocked"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+	MaxLatencyMS float64 `json:"max_latency_ms"`
+	Closed       bool    `json:"closed"`
+}
+
+// Item is a dequeued value and when it was pushed; pass it to Done once handled
+type Item[T any] struct {
+	Value      T
+	EnqueuedAt time.Time
+}
+
+type entry[T any] struct {
+	value      T
+	enqueuedAt time.Time
+}
+
+// Queue is a bounded FIFO safe for concurrent producers and consumers. Its items live in
+// a ring allocated once at Capacity, so memory stays bounded however far consumers lag.
+type Queue[T any] struct {
+	name string
+	opts Options[T]
+
+	mu     sync.Mutex
+	ring   []entry[T]
+	head   int
+	size   int
+	closed bool
+	stats  Stats
+	total  time.Duration
+
+	// Signalled, without blocking, whenever an item arrives or room frees up
+	ready chan struct{}
+	room  chan struct{}
+}
+
+// New returns an empty queue; Capacity defaults to 1
+func New[T any](name string, opts Options[T]) (*Queue[T], error) {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1
+	}
+	switch opts.Policy {
+	case Block, DropOldest:
+	case Spill:
+		if opts.Spill == nil {
+			return nil, fmt.Errorf("queue %s: spill policy needs a spill function", name)
+		}
+	default:
+		return nil, fmt.Errorf("queue %s: unknown overflow policy %q", name, opts.Policy)
+	}
+	return &Queue[T]{
+		name:  name,
+		opts:  opts,
+		ring:  make([]entry[T], opts.Capacity),
+		ready: make(chan struct{}, 1),
+		room:  make(chan struct{}, 1),
+		stats: Stats{Name: name, Policy: opts.Policy, Capacity: opts.Capacity},
+	}, nil
+}
+
+// Push adds value, applying the overflow policy when the queue is full. ctx only matters
+// for Block. It fails with ErrClosed after Close, with ctx's error when a blocked push
+// gives up, and with the spill function's error when spilling fails.
+func (q *Queue[T]) Push(ctx context.Context, value T) error {
+	waited := false
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		if q.size < len(q.ring) {
+			q.append(value)
+			if waited {
+				q.stats.Blocked++
+			}
+			q.mu.Unlock()
+			return nil
+		}
+
+		switch q.opts.Policy {
+		case DropOldest:
+			evicted := q.take()
+			q.stats.Dropped++
+			q.append(value)
+			q.mu.Unlock()
+			if q.opts.OnDrop != nil {
+				q.opts.OnDrop(evicted.value)
+			}
+			return nil
+		case Spill:
+			q.mu.Unlock()
+			err := q.opts.Spill(value)
+			q.mu.Lock()
+			if err != nil {
+				q.stats.SpillErrors++
+			} else {
+				q.stats.Spilled++
+			}
+			q.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("failed to spill %s item: %w", q.name, err)
+			}
+			return nil
+		}
+
+		q.mu.Unlock()
+		waited = true
+		select {
+		case <-q.room:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pop waits for the oldest item. ok is false once ctx is done, or once the queue is
+// closed and empty.
+func (q *Queue[T]) Pop(ctx context.Context) (item Item[T], ok bool) {
+	for {
+		if item, ok := q.TryPop(); ok {
+			return item, true
+		}
+		q.mu.Lock()
+		closed := q.closed && q.size == 0
+		q.mu.Unlock()
+		if closed {
+			return Item[T]{}, false
+		}
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return Item[T]{}, false
+		}
+	}
+}
+
+// TryPop returns the oldest item without waiting
+func (q *Queue[T]) TryPop() (Item[T], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.size == 0 {
+		return Item[T]{}, false
+	}
+	e := q.take()
+	q.stats.Dequeued++
+	return Item[T]{Value: e.value, EnqueuedAt: e.enqueuedAt}, true
+}
+
+// Done records that a popped item has been handled, counting the time since it was
+// pushed as its latency
+func (q *Queue[T]) Done(item Item[T]) {
+	latency := time.Since(item.EnqueuedAt)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stats.Processed++
+	q.total += latency
+	if ms := float64(latency) / float64(time.Millisecond); ms > q.stats.MaxLatencyMS {
+		q.stats.MaxLatencyMS = ms
+	}
+}
+
+// Close stops the queue accepting items and wakes waiting consumers and producers.
+// Items already queued can still be popped.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.ready)
+	close(q.room)
+}
+
+// Drain removes and returns every queued item, oldest first
+func (q *Queue[T]) Drain() []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	values := make([]T, 0, q.size)
+	for q.size > 0 {
+		values = append(values, q.take().value)
+		q.stats.Dequeued++
+	}
+	return values
+}
+
+// Len is the number of queued items
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Stats returns a snapshot of the queue's counters
+func (q *Queue[T]) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = q.size
+	stats.Closed = q.closed
+	if stats.Processed > 0 {
+		stats.AvgLatencyMS = float64(q.total) / float64(time.Millisecond) / float64(stats.Processed)
+	}
+	return stats
+}
+
+// append and take require q.mu and, for append, room in the ring
+func (q *Queue[T]) append(value T) {
+	q.ring[(q.head+q.size)%len(q.ring)] = entry[T]{value: value, enqueuedAt: time.Now()}
+	q.size++
+	q.stats.Enqueued++
+	q.stats.MaxDepth = max(q.stats.MaxDepth, q.size)
+	if !q.closed {
+		signal(q.ready)
+	}
+}
+
+func (q *Queue[T]) take() entry[T] {
+	e := q.ring[q.head]
+	q.ring[q.head] = entry[T]{} // let the ring drop its reference
+	q.head = (q.head + 1) % len(q.ring)
+	q.size--
+	if q.size > 0 && !q.closed {
+		signal(q.ready)
+	}
+	if !q.closed {
+		signal(q.room)
+	}
+	return e
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const loadItems = 10000
+
+func newQueue[T any](t *testing.T, opts Options[T]) *Queue[T] {
+	t.Helper()
+	q, err := New("test", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func checkAccounting(t *testing.T, stats Stats) {
+	t.Helper()
+	if stats.Enqueued != stats.Dequeued+stats.Dropped+uint64(stats.Depth) {
+		t.Fatalf("stats = %+v: enqueued != dequeued + dropped + depth", stats)
+	}
+	if stats.Depth > stats.Capacity || stats.MaxDepth > stats.Capacity {
+		t.Fatalf("stats = %+v: depth exceeded capacity", stats)
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+func TestDropOldestUnderLoad(t *testing.T) {
+	var evicted atomic.Int64
+	q := newQueue(t, Options[[]byte]{Capacity: 100, Policy: DropOldest, OnDrop: func([]byte) { evicted.Add(1) }})
+
+	before := heapInUse()
+	for i := 0; i < loadItems; i++ {
+		payload := make([]byte, 1024)
+		payload[0], payload[1] = byte(i>>8), byte(i)
+		if err := q.Push(context.Background(), payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 10k 1 KiB payloads are 10 MiB; only the last 100 may stay reachable
+	if grown := int64(heapInUse()) - int64(before); grown > 1<<20 {
+		t.Fatalf("heap grew by %d bytes holding %d items", grown, q.Len())
+	}
+
+	stats := q.Stats()
+	checkAccounting(t, stats)
+	if stats.Depth != 100 || stats.Dropped != loadItems-100 || evicted.Load() != loadItems-100 {
+		t.Fatalf("stats = %+v, %d evicted; want 100 kept and the rest dropped", stats, evicted.Load())
+	}
+	first, _ := q.TryPop()
+	if int(first.Value[0])<<8|int(first.Value[1]) != loadItems-100 {
+		t.Fatalf("oldest kept item is %d, want the 100th newest", first.Value[0])
+	}
+}
+
+func TestSpillUnderLoad(t *testing.T) {
+	var mu sync.Mutex
+	var spilled []int
+	q := newQueue(t, Options[int]{Capacity: 50, Policy: Spill, Spill: func(v int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if v%1000 == 999 {
+			return errors.New("disk full")
+		}
+		spilled = append(spilled, v)
+		return nil
+	}})
+
+	failed := 0
+	for i := 0; i < loadItems; i++ {
+		if err := q.Push(context.Background(), i); err != nil {
+			failed++
+		}
+	}
+	stats := q.Stats()
+	checkAccounting(t, stats)
+	if stats.Depth != 50 || stats.Spilled != uint64(len(spilled)) || stats.SpillErrors != uint64(failed) || failed != 10 {
+		t.Fatalf("stats = %+v, %d spilled, %d failed", stats, len(spilled), failed)
+	}
+	if stats.Enqueued+stats.Spilled+stats.SpillErrors != loadItems {
+		t.Fatalf("stats = %+v do not account for %d pushes", stats, loadItems)
+	}
+	if spilled[0] != 50 {
+		t.Fatalf("first spilled item = %d, want the first that did not fit", spilled[0])
+	}
+}
+
+func TestBlockUnderLoad(t *testing.T) {
+	q := newQueue(t, Options[int]{Capacity: 10, Policy: Block})
+
+	var wg sync.WaitGroup
+	for p := 0; p < 4; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < loadItems/4; i++ {
+				if err := q.Push(context.Background(), i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	received := make(chan int)
+	for c := 0; c < 2; c++ {
+		go func() {
+			count := 0
+			for {
+				item, ok := q.Pop(context.Background())
+				if !ok {
+					received <- count
+					return
+				}
+				q.Done(item)
+				count++
+			}
+		}()
+	}
+	wg.Wait()
+	q.Close()
+	total := <-received + <-received
+
+	stats := q.Stats()
+	checkAccounting(t, stats)
+	if total != loadItems || stats.Processed != loadItems || stats.Dropped != 0 || stats.Depth != 0 {
+		t.Fatalf("received %d, stats = %+v; want every item exactly once", total, stats)
+	}
+	if stats.Blocked == 0 || stats.MaxLatencyMS < stats.AvgLatencyMS {
+		t.Fatalf("stats = %+v: expected blocked pushes and consistent latency", stats)
+	}
+}
+
+func TestBlockedPushHonoursContext(t *testing.T) {
+	q := newQueue(t, Options[int]{Capacity: 1, Policy: Block})
+	if err := q.Push(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Push(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("push into a full queue = %v, want the deadline", err)
+	}
+}
+
+func TestCloseAndDrain(t *testing.T) {
+	q := newQueue(t, Options[int]{Capacity: 5, Policy: Block})
+	for i := 0; i < 3; i++ {
+		_ = q.Push(context.Background(), i)
+	}
+	q.Close()
+	if err := q.Push(context.Background(), 9); !errors.Is(err, ErrClosed) {
+		t.Fatalf("push after close = %v, want ErrClosed", err)
+	}
+	if item, ok := q.Pop(context.Background()); !ok || item.Value != 0 {
+		t.Fatalf("pop after close = %v, %v; want the queued head", item, ok)
+	}
+	if rest := q.Drain(); len(rest) != 2 || rest[0] != 1 {
+		t.Fatalf("drain = %v", rest)
+	}
+	if _, ok := q.Pop(context.Background()); ok {
+		t.Fatal("pop from a closed, empty queue succeeded")
+	}
+	checkAccounting(t, q.Stats())
+}
+
+func TestNewValidatesPolicy(t *testing.T) {
+	if _, err := New("x", Options[int]{Policy: Spill}); err == nil {
+		t.Fatal("spill without a spill function was accepted")
+	}
+	if _, err := New("x", Options[int]{Policy: "lifo"}); err == nil {
+		t.Fatal("unknown policy was accepted")
+	}
+}
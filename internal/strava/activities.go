@@ -1,7 +1,9 @@
 package strava
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -39,6 +41,10 @@ import (
   }
 */
 
+// ErrActivityNotFound is returned when Strava has no activity with the requested ID, e.g.
+// because it was deleted
+var ErrActivityNotFound = errors.New("strava activity not found")
+
 type ActivitySummaryList []ActivitySummary
 type BikeActivityList []BikeActivity
 
@@ -62,7 +68,7 @@ type ActivitySummary struct {
 	LocationCountry    *string    `json:"location_country"`
 	GearID             string     `json:"gear_id"`
 	GearName           *string    `json:"gear_name,omitempty"`
-	AverageSpeed       float64    `json:"average_speed"`
+	AverageSpeed       float64    `json:"average_speed"` // Strava's distance over moving time; deprecated in B11K's API for avg_speed_moving
 	MaxSpeed           float64    `json:"max_speed"`
 	AverageCadence     float64    `json:"average_cadence"`
 	AverageWatts       float64    `json:"average_watts"`
@@ -72,6 +78,26 @@ type ActivitySummary struct {
 	MaxWatts           float64    `json:"max_watts"`
 	SufferScore        float64    `json:"suffer_score"`
 
+	// InstanceVisibility is B11K's own visibility ("private" or "instance"), not Strava's
+	InstanceVisibility string `json:"instance_visibility,omitempty"`
+	// Pinned activities are listed first; local to B11K like InstanceVisibility
+	Pinned bool `json:"pinned"`
+	// Notes are the athlete's own markdown notes, kept by B11K only
+	Notes string `json:"notes,omitempty"`
+	// Description is the activity description; B11K stores it only when edited here, syncs
+	// leave it alone
+	Description string `json:"description,omitempty"`
+	// NewDistance is how many meters of the route were on roads the athlete had not ridden
+	// before, computed by B11K; nil until computed
+	NewDistance *float64 `json:"new_distance_m,omitempty"`
+	// Sparkline is a short downsampled metric series for list views, computed by B11K;
+	// null when the activity has no samples for the metric
+	Sparkline []float64 `json:"sparkline"`
+	// AvgSpeedMoving (distance over moving time) and AvgSpeedElapsed (distance over
+	// elapsed time) are filled by B11K before an activity is served
+	AvgSpeedMoving  float64 `json:"avg_speed_moving"`
+	AvgSpeedElapsed float64 `json:"avg_speed_elapsed"`
+
 	StartDateTime time.Time `json:"-"`
 }
 
@@ -98,9 +124,13 @@ type BikeActivity struct {
 	TemperatureStream TemperatureStream
 }
 
+// Gear is a bike or pair of shoes. Brand and model only come with GET /gear/{id}.
 type Gear struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	BrandName string `json:"brand_name"`
+	ModelName string `json:"model_name"`
+	Retired   bool   `json:"retired"`
 }
 
 type TimeStream struct {
@@ -178,18 +208,23 @@ func (b *ActivitySummaryList) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
-func FetchBikeActivities(accessToken string, earliestTime time.Time, latestTime time.Time) (ActivitySummaryList, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
+// FetchActivities pages through the athlete's activities, waiting out Strava's rate
+// limits when needed. ctx cancels both requests and rate limit waits. Only activities
+// matching types are returned; empty types keeps every activity. An activity listed on
+// two pages, because one was uploaded or deleted while paging, is returned once.
+func FetchActivities(ctx context.Context, accessToken string, earliestTime time.Time, latestTime time.Time, types []string) (ActivitySummaryList, error) {
+	return defaultAPI.fetchActivities(ctx, accessToken, earliestTime, latestTime, types)
+}
+
+func (a api) fetchActivities(ctx context.Context, accessToken string, earliestTime time.Time, latestTime time.Time, types []string) (ActivitySummaryList, error) {
+	client := newHTTPClient()
 	var allActivities ActivitySummaryList
+	listed := make(map[int64]bool)
 	page := 1
-	perPage := 200
-
-	fmt.Println("📄 Fetching activities page by page...")
+	perPage := a.activitiesPerPage()
 
 	for {
-		fmt.Printf("   Fetching page %d... ", page)
-
-		url := fmt.Sprintf("https://www.strava.com/api/v3/athlete/activities?page=%d&per_page=%d", page, perPage)
+		url := fmt.Sprintf("%s/athlete/activities?page=%d&per_page=%d", a.baseURL, page, perPage)
 		if !earliestTime.IsZero() {
 			url += fmt.Sprintf("&after=%d", earliestTime.Unix())
 		}
@@ -197,14 +232,14 @@ func FetchBikeActivities(accessToken string, earliestTime time.Time, latestTime
 			url += fmt.Sprintf("&before=%d", latestTime.Unix())
 		}
 
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
 
 		req.Header.Set("Authorization", "Bearer "+accessToken)
 
-		resp, err := client.Do(req)
+		resp, err := a.limiter.Do(ctx, client, req)
 		if err != nil {
 			return nil, err
 		}
@@ -226,44 +261,70 @@ func FetchBikeActivities(accessToken string, earliestTime time.Time, latestTime
 			return nil, err
 		}
 
+		// An upload while paging shifts the listing, repeating an activity on the next page
+		for _, activity := range pageActivities {
+			if !listed[activity.ID] {
+				listed[activity.ID] = true
+				allActivities = append(allActivities, activity)
+			}
+		}
+
 		// If we get fewer activities than perPage, we've reached the last page
+		logger().Debug("Fetched activities page", "page", page, "activities", len(pageActivities))
 		if len(pageActivities) < perPage {
-			allActivities = append(allActivities, pageActivities...)
-			fmt.Printf("found %d activities (last page)\n", len(pageActivities))
 			break
 		}
 
-		allActivities = append(allActivities, pageActivities...)
-		fmt.Printf("found %d activities\n", len(pageActivities))
-
 		page++
 
-		// Small delay to respect API rate limits
-		time.Sleep(100 * time.Millisecond)
-
 		// Safety check to prevent infinite loops
-		if page > 100 {
-			fmt.Println("⚠️  Reached maximum page limit (100), stopping pagination")
+		if page > a.pageLimit() {
+			logger().Warn("Reached the page limit, stopping pagination", "pages", a.pageLimit())
 			break
 		}
 	}
 
-	fmt.Printf("📊 Total activities fetched: %d\n", len(allActivities))
-
-	// Filter for biking activities
-	var bikingActivities ActivitySummaryList
+	var matchingActivities ActivitySummaryList
 	for _, activity := range allActivities {
-		if activity.Type == "Ride" {
+		if MatchesActivityType(activity, types) {
 			startDateTime, err := time.Parse(time.RFC3339, activity.StartDate)
 			if err != nil {
 				return nil, err
 			}
 			activity.StartDateTime = startDateTime
-			bikingActivities = append(bikingActivities, activity)
+			matchingActivities = append(matchingActivities, activity)
+		}
+	}
+	logger().Debug("Fetched activities", "activities", len(allActivities), "matching", len(matchingActivities), "types", strings.Join(types, ","))
+
+	return matchingActivities, nil
+}
+
+// MatchesActivityType reports whether the activity's type or sport_type is one of types,
+// ignoring case. Strava keeps e.g. GravelRide only in sport_type with type Ride, so both
+// are checked. Empty types matches everything.
+func MatchesActivityType(activity ActivitySummary, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if strings.EqualFold(activity.Type, t) || strings.EqualFold(activity.SportType, t) {
+			return true
 		}
 	}
+	return false
+}
 
-	return bikingActivities, nil
+// ParseActivityTypes splits a comma-separated type list such as "Ride,VirtualRide",
+// dropping blanks
+func ParseActivityTypes(value string) []string {
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
 }
 
 func (a *ActivitySummary) ToString() string {
@@ -298,77 +359,136 @@ func (a *ActivitySummaryList) ToString() string {
 	return sb.String()
 }
 
-func (a *ActivitySummaryList) GetDetailedActivities(accessToken string) (BikeActivityList, error) {
+// GetDetailedActivities fetches each activity and its streams, two requests per activity,
+// waiting out Strava's rate limits when needed.
+func (a *ActivitySummaryList) GetDetailedActivities(ctx context.Context, accessToken string) (BikeActivityList, error) {
+	return defaultAPI.getDetailedActivities(ctx, accessToken, *a)
+}
+
+func (a api) getDetailedActivities(ctx context.Context, accessToken string, activities ActivitySummaryList) (BikeActivityList, error) {
 	var detailedActivities BikeActivityList
-	client := &http.Client{Timeout: 30 * time.Second}
-	for _, activity := range *a {
-		fmt.Printf("Fetching detailed activity %d (%s)...\n", activity.ID, activity.Name)
-		activityURL := fmt.Sprintf("https://www.strava.com/api/v3/activities/%d", activity.ID)
-		req, err := http.NewRequest("GET", activityURL, nil)
-		if err != nil {
-			return nil, err
+	client := newHTTPClient()
+	for _, activity := range activities {
+		// Stop between activities too, not only inside a request or a rate limit wait
+		if err := ctx.Err(); err != nil {
+			return detailedActivities, err
 		}
-		req.Header.Set("Authorization", "Bearer "+accessToken)
-		resp, err := client.Do(req)
+		detailedActivity, err := a.fetchDetailedActivity(ctx, client, accessToken, activity.ID, &activity)
 		if err != nil {
 			return nil, err
 		}
-		body, err := io.ReadAll(resp.Body)
-		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
-			err = closeErr
-		}
-		if err != nil {
-			return nil, err
-		}
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to fetch activity with status %d: %s", resp.StatusCode, string(body))
-		}
-		var detailedActivity BikeActivity
-		if err := json.Unmarshal(body, &detailedActivity); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal activity: %v", err)
-		}
-		detailedActivity.Summary = activity
-		if detailedActivity.Gear != nil && detailedActivity.Gear.Name != "" {
-			detailedActivity.Summary.GearName = &detailedActivity.Gear.Name
-		}
-		time.Sleep(100 * time.Millisecond)
-		streamParams := url.Values{}
-		streamParams.Set("keys", strings.Join(activityStreamKeys, ","))
-		streamParams.Set("key_by_type", "true")
-		streamUrl := fmt.Sprintf("https://www.strava.com/api/v3/activities/%d/streams?%s", activity.ID, streamParams.Encode())
-		req, err = http.NewRequest("GET", streamUrl, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %v", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+accessToken)
-		resp, err = client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to do request: %v", err)
-		}
-		body, err = io.ReadAll(resp.Body)
-		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
-			err = closeErr
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read body: %v", err)
-		}
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to fetch streams with status %d: %s", resp.StatusCode, string(body))
-		}
-		streams, err := decodeRawStravaStreams(body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal streams: %v", err)
-		}
-		if err := detailedActivity.AddStreams(streams); err != nil {
-			return nil, fmt.Errorf("failed to add streams: %v", err)
-		}
-		fmt.Print(detailedActivity.StreamsSummary())
-		detailedActivities = append(detailedActivities, detailedActivity)
+		logger().Debug("Fetched detailed activity", "activity_id", activity.ID, "streams", strings.TrimSpace(detailedActivity.StreamsSummary()))
+		detailedActivities = append(detailedActivities, *detailedActivity)
 
 	}
 	return detailedActivities, nil
 }
 
+// FetchActivity fetches a single activity and its streams when there is no listed summary
+// to start from, e.g. for a webhook event. The summary is taken from the detailed activity,
+// with AthleteID and StartDateTime filled in.
+func FetchActivity(ctx context.Context, accessToken string, activityID int64) (*BikeActivity, error) {
+	return defaultAPI.fetchActivity(ctx, accessToken, activityID)
+}
+
+func (a api) fetchActivity(ctx context.Context, accessToken string, activityID int64) (*BikeActivity, error) {
+	client := newHTTPClient()
+	return a.fetchDetailedActivity(ctx, client, accessToken, activityID, nil)
+}
+
+// fetchDetailedActivity fetches an activity and its streams. A nil summary is decoded from
+// the detailed activity itself.
+func (a api) fetchDetailedActivity(ctx context.Context, client *http.Client, accessToken string, activityID int64, summary *ActivitySummary) (*BikeActivity, error) {
+	activityURL := fmt.Sprintf("%s/activities/%d", a.baseURL, activityID)
+	req, err := http.NewRequestWithContext(ctx, "GET", activityURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := a.limiter.Do(ctx, client, req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %d", ErrActivityNotFound, activityID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch activity with status %d: %s", resp.StatusCode, string(body))
+	}
+	var detailedActivity BikeActivity
+	if err := json.Unmarshal(body, &detailedActivity); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal activity: %v", err)
+	}
+	if summary != nil {
+		detailedActivity.Summary = *summary
+	} else if detailedActivity.Summary, err = decodeDetailedSummary(body); err != nil {
+		return nil, err
+	}
+	if detailedActivity.Gear != nil && detailedActivity.Gear.Name != "" {
+		detailedActivity.Summary.GearName = &detailedActivity.Gear.Name
+	}
+	streamParams := url.Values{}
+	streamParams.Set("keys", strings.Join(activityStreamKeys, ","))
+	streamParams.Set("key_by_type", "true")
+	streamUrl := fmt.Sprintf("%s/activities/%d/streams?%s", a.baseURL, activityID, streamParams.Encode())
+	req, err = http.NewRequestWithContext(ctx, "GET", streamUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err = a.limiter.Do(ctx, client, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to do request: %w", err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch streams with status %d: %s", resp.StatusCode, string(body))
+	}
+	streams, err := decodeRawStravaStreams(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal streams: %v", err)
+	}
+	if err := detailedActivity.AddStreams(streams); err != nil {
+		return nil, fmt.Errorf("failed to add streams: %v", err)
+	}
+	return &detailedActivity, nil
+}
+
+// decodeDetailedSummary reads the summary fields of a detailed activity. Strava nests the
+// owner as "athlete": {"id": ...} rather than the athlete_id stored here.
+func decodeDetailedSummary(body []byte) (ActivitySummary, error) {
+	var detail struct {
+		ActivitySummary
+		Athlete struct {
+			ID int64 `json:"id"`
+		} `json:"athlete"`
+	}
+	if err := json.Unmarshal(body, &detail); err != nil {
+		return ActivitySummary{}, fmt.Errorf("failed to unmarshal activity summary: %v", err)
+	}
+	summary := detail.ActivitySummary
+	summary.AthleteID = detail.Athlete.ID
+	startDateTime, err := time.Parse(time.RFC3339, summary.StartDate)
+	if err != nil {
+		return ActivitySummary{}, fmt.Errorf("failed to parse activity start date %q: %v", summary.StartDate, err)
+	}
+	summary.StartDateTime = startDateTime
+	return summary, nil
+}
+
 func decodeRawStravaStreams(body []byte) ([]RawStravaStream, error) {
 	var streams []RawStravaStream
 	if err := json.Unmarshal(body, &streams); err == nil {
@@ -96,3 +96,38 @@ func TestAddStreamsIncludesAllRequestedSensorStreams(t *testing.T) {
 		t.Fatalf("temperature stream = %#v", activity.TemperatureStream.Data)
 	}
 }
+
+func TestMatchesActivityTypeChecksTypeAndSportType(t *testing.T) {
+	gravel := ActivitySummary{Type: "Ride", SportType: "GravelRide"}
+	virtual := ActivitySummary{Type: "VirtualRide", SportType: "VirtualRide"}
+	run := ActivitySummary{Type: "Run", SportType: "Run"}
+
+	if !MatchesActivityType(run, nil) {
+		t.Fatal("empty filter should match every type")
+	}
+	if !MatchesActivityType(gravel, []string{"gravelride"}) || !MatchesActivityType(gravel, []string{"Ride"}) {
+		t.Fatal("gravel ride should match both its type and sport type")
+	}
+	if !MatchesActivityType(virtual, []string{"Ride", "VirtualRide"}) {
+		t.Fatal("virtual ride should match a filter listing it")
+	}
+	if MatchesActivityType(run, []string{"Ride", "VirtualRide"}) {
+		t.Fatal("run should not match a ride filter")
+	}
+}
+
+func TestParseActivityTypes(t *testing.T) {
+	got := ParseActivityTypes(" Ride, VirtualRide ,,GravelRide ")
+	want := []string{"Ride", "VirtualRide", "GravelRide"}
+	if len(got) != len(want) {
+		t.Fatalf("ParseActivityTypes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ParseActivityTypes = %v, want %v", got, want)
+		}
+	}
+	if got := ParseActivityTypes(""); got != nil {
+		t.Fatalf("ParseActivityTypes(\"\") = %v, want nil", got)
+	}
+}
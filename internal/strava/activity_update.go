@@ -0,0 +1,63 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// activitiesURL is Strava's activity endpoint; tests point it at a fake
+var activitiesURL = "https://www.strava.com/api/v3/activities/"
+
+// ErrActivityWriteUnauthorized is returned when Strava refuses an activity edit, typically
+// because the athlete granted access before the activity:write scope was requested
+var ErrActivityWriteUnauthorized = errors.New("strava token may not edit activities")
+
+// ActivityUpdate holds the activity fields UpdateActivity changes; nil fields are left as
+// they are on Strava
+type ActivityUpdate struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// UpdateActivity changes an activity's fields on Strava. It needs a token granted the
+// activity:write scope (see StravaAuthConfig.ActivityWrite).
+func UpdateActivity(ctx context.Context, accessToken string, activityID int64, fields ActivityUpdate) error {
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	client := newHTTPClient()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s%d", activitiesURL, activityID), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defaultRateLimiter.Observe(resp)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %d", ErrActivityNotFound, activityID)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: status %d: %s", ErrActivityWriteUnauthorized, resp.StatusCode, string(body))
+	default:
+		return fmt.Errorf("failed to update activity %d: status %d: %s", activityID, resp.StatusCode, string(body))
+	}
+}
@@ -0,0 +1,71 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUpdateActivitySendsOnlyGivenFields(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer token-a":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method != http.MethodPut || r.URL.Path != "/activities/42":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Errorf("decode body: %v", err)
+			}
+			_, _ = w.Write([]byte(`{"id": 42}`))
+		}
+	}))
+	defer server.Close()
+	saved := activitiesURL
+	activitiesURL = server.URL + "/activities/"
+	defer func() { activitiesURL = saved }()
+
+	name := "Col du Tourmalet attempt"
+	if err := UpdateActivity(context.Background(), "token-a", 42, ActivityUpdate{Name: &name}); err != nil {
+		t.Fatalf("UpdateActivity: %v", err)
+	}
+	if len(got) != 1 || got["name"] != name {
+		t.Fatalf("body = %v, want only the name", got)
+	}
+
+	empty := ""
+	if err := UpdateActivity(context.Background(), "token-a", 42, ActivityUpdate{Description: &empty}); err != nil {
+		t.Fatalf("UpdateActivity clearing the description: %v", err)
+	}
+	if description, ok := got["description"]; !ok || description != "" {
+		t.Fatalf("body = %v, want an empty description sent", got)
+	}
+
+	if err := UpdateActivity(context.Background(), "token-b", 42, ActivityUpdate{Name: &name}); !errors.Is(err, ErrActivityWriteUnauthorized) {
+		t.Fatalf("UpdateActivity with a read-only token = %v, want ErrActivityWriteUnauthorized", err)
+	}
+	if err := UpdateActivity(context.Background(), "token-a", 7, ActivityUpdate{Name: &name}); !errors.Is(err, ErrActivityNotFound) {
+		t.Fatalf("UpdateActivity of a missing activity = %v, want ErrActivityNotFound", err)
+	}
+}
+
+func TestAuthURLAsksForActivityWriteOnlyWhenEnabled(t *testing.T) {
+	config := StravaAuthConfig{ClientID: "1", RedirectURI: "https://b11k.example/strava/callback"}
+	for _, write := range []bool{false, true} {
+		config.ActivityWrite = write
+		parsed, err := url.Parse(GenerateAuthURLWithState(config, "s"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scope := parsed.Query().Get("scope")
+		if !strings.Contains(scope, "activity:read_all") || strings.Contains(scope, "activity:write") != write {
+			t.Fatalf("ActivityWrite %v: scope = %q", write, scope)
+		}
+	}
+}
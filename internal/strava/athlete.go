@@ -1,11 +1,11 @@
 package strava
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 )
 
 // Athlete represents the authenticated athlete profile subset we need
@@ -17,9 +17,13 @@ type Athlete struct {
 }
 
 // FetchCurrentAthlete retrieves the profile for the current authenticated athlete
-func FetchCurrentAthlete(accessToken string) (*Athlete, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest("GET", "https://www.strava.com/api/v3/athlete", nil)
+func FetchCurrentAthlete(ctx context.Context, accessToken string) (*Athlete, error) {
+	return defaultAPI.fetchCurrentAthlete(ctx, accessToken)
+}
+
+func (a api) fetchCurrentAthlete(ctx context.Context, accessToken string) (*Athlete, error) {
+	client := newHTTPClient()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/athlete", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -30,6 +34,8 @@ func FetchCurrentAthlete(accessToken string) (*Athlete, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	// Interactive lookups never wait, but their usage still counts toward the limits
+	a.limiter.Observe(resp)
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -37,9 +43,61 @@ func FetchCurrentAthlete(accessToken string) (*Athlete, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("fetch athlete failed: %d: %s", resp.StatusCode, string(body))
 	}
-	var a Athlete
-	if err := json.Unmarshal(body, &a); err != nil {
+	var athlete Athlete
+	if err := json.Unmarshal(body, &athlete); err != nil {
 		return nil, err
 	}
-	return &a, nil
+	return &athlete, nil
+}
+
+// AthleteDetail is the detailed athlete representation, which also lists the athlete's gear
+type AthleteDetail struct {
+	Athlete
+	Bikes []Gear `json:"bikes"`
+	Shoes []Gear `json:"shoes"`
+}
+
+// FetchAthleteDetail retrieves the current athlete with their bikes and shoes. Unlike
+// FetchCurrentAthlete it waits for the rate limiter, for use by background jobs.
+func FetchAthleteDetail(ctx context.Context, accessToken string) (*AthleteDetail, error) {
+	var detail AthleteDetail
+	if err := fetchAthleteJSON(ctx, "https://www.strava.com/api/v3/athlete", accessToken, &detail); err != nil {
+		return nil, fmt.Errorf("failed to fetch athlete: %w", err)
+	}
+	return &detail, nil
+}
+
+// FetchHeartRateZonesContext is FetchHeartRateZones for background jobs: it also waits
+// for the rate limiter
+func FetchHeartRateZonesContext(ctx context.Context, accessToken string) (*AthleteZones, error) {
+	var zones AthleteZones
+	if err := fetchAthleteJSON(ctx, "https://www.strava.com/api/v3/athlete/zones", accessToken, &zones); err != nil {
+		return nil, fmt.Errorf("failed to fetch zones: %w", err)
+	}
+	return &zones, nil
+}
+
+// fetchAthleteJSON GETs url through the shared rate limiter and decodes the response into out
+func fetchAthleteJSON(ctx context.Context, url, accessToken string, out interface{}) error {
+	client := newHTTPClient()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := defaultRateLimiter.Do(ctx, client, req)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, out)
 }
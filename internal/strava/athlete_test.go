@@ -0,0 +1,42 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchAthleteJSONDecodesDetailedAthlete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-a" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 7, "firstname": "Ada", "lastname": "L", "profile": "https://example.com/a.jpg",
+			"bikes": [{"id": "b1", "name": "Road"}], "shoes": [{"id": "g2", "name": "Trail"}]}`))
+	}))
+	defer server.Close()
+
+	var detail AthleteDetail
+	if err := fetchAthleteJSON(context.Background(), server.URL, "token-a", &detail); err != nil {
+		t.Fatalf("fetchAthleteJSON: %v", err)
+	}
+	if detail.ID != 7 || detail.FirstName != "Ada" || len(detail.Bikes) != 1 || detail.Bikes[0].Name != "Road" || len(detail.Shoes) != 1 {
+		t.Fatalf("detail = %+v", detail)
+	}
+	if err := fetchAthleteJSON(context.Background(), server.URL, "token-b", &detail); err == nil {
+		t.Fatal("a 401 response was not reported")
+	}
+}
+
+func TestTokenResponseCarriesTheAthlete(t *testing.T) {
+	var resp StravaTokenResponse
+	if err := json.Unmarshal([]byte(`{"access_token": "a", "refresh_token": "r", "expires_at": 1, "athlete": {"id": 9, "firstname": "Bo"}}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Athlete == nil || resp.Athlete.ID != 9 {
+		t.Fatalf("athlete = %+v, want athlete 9", resp.Athlete)
+	}
+}
@@ -1,25 +1,28 @@
 package strava
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 )
 
 type StravaAuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURI  string
+	// ActivityWrite also asks for the activity:write scope, which UpdateActivity needs
+	ActivityWrite bool
 }
 
 type StravaTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	ExpiresAt    int64  `json:"expires_at"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string   `json:"access_token"`
+	ExpiresAt    int64    `json:"expires_at"`
+	RefreshToken string   `json:"refresh_token"`
+	Athlete      *Athlete `json:"athlete"` // sent with code exchanges, not with refreshes
 }
 
 func NewStravaAuthConfig(clientID, clientSecret, redirectURI string) *StravaAuthConfig {
@@ -36,7 +39,11 @@ func generateStravaAuthURLWithOptions(config StravaAuthConfig, baseURL, state st
 	params.Add("redirect_uri", config.RedirectURI)
 	params.Add("response_type", "code")
 	params.Add("approval_prompt", "auto")
-	params.Add("scope", "read,activity:read_all,profile:read_all")
+	scope := "read,activity:read_all,profile:read_all"
+	if config.ActivityWrite {
+		scope += ",activity:write"
+	}
+	params.Add("scope", scope)
 	if state != "" {
 		params.Add("state", state)
 	}
@@ -44,16 +51,16 @@ func generateStravaAuthURLWithOptions(config StravaAuthConfig, baseURL, state st
 	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
 }
 
-func exchangeCodeForToken(config StravaAuthConfig, code string) (string, error) {
-	tokenResp, err := exchangeCodeForTokenResponse(config, code)
+func exchangeCodeForToken(ctx context.Context, config StravaAuthConfig, code string) (string, error) {
+	tokenResp, err := exchangeCodeForTokenResponse(ctx, config, code)
 	if err != nil {
 		return "", err
 	}
 	return tokenResp.AccessToken, nil
 }
 
-func exchangeCodeForTokenResponse(config StravaAuthConfig, code string) (*StravaTokenResponse, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
+func exchangeCodeForTokenResponse(ctx context.Context, config StravaAuthConfig, code string) (*StravaTokenResponse, error) {
+	client := newHTTPClient()
 
 	data := url.Values{}
 	data.Set("client_id", config.ClientID)
@@ -65,7 +72,7 @@ func exchangeCodeForTokenResponse(config StravaAuthConfig, code string) (*Strava
 		data.Set("redirect_uri", config.RedirectURI)
 	}
 
-	req, err := http.NewRequest("POST", "https://www.strava.com/oauth/token", strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://www.strava.com/oauth/token", strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -100,18 +107,18 @@ func exchangeCodeForTokenResponse(config StravaAuthConfig, code string) (*Strava
 }
 
 // ExchangeCodeForToken exchanges an authorization code for an access token (exported helper)
-func ExchangeCodeForToken(config StravaAuthConfig, code string) (string, error) {
-	return exchangeCodeForToken(config, code)
+func ExchangeCodeForToken(ctx context.Context, config StravaAuthConfig, code string) (string, error) {
+	return exchangeCodeForToken(ctx, config, code)
 }
 
 // ExchangeCodeForTokenResponse exchanges an authorization code for full token metadata.
-func ExchangeCodeForTokenResponse(config StravaAuthConfig, code string) (*StravaTokenResponse, error) {
-	return exchangeCodeForTokenResponse(config, code)
+func ExchangeCodeForTokenResponse(ctx context.Context, config StravaAuthConfig, code string) (*StravaTokenResponse, error) {
+	return exchangeCodeForTokenResponse(ctx, config, code)
 }
 
 // RefreshAccessToken refreshes an expired Strava access token.
-func RefreshAccessToken(config StravaAuthConfig, refreshToken string) (*StravaTokenResponse, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
+func RefreshAccessToken(ctx context.Context, config StravaAuthConfig, refreshToken string) (*StravaTokenResponse, error) {
+	client := newHTTPClient()
 
 	data := url.Values{}
 	data.Set("client_id", config.ClientID)
@@ -119,7 +126,7 @@ func RefreshAccessToken(config StravaAuthConfig, refreshToken string) (*StravaTo
 	data.Set("refresh_token", refreshToken)
 	data.Set("grant_type", "refresh_token")
 
-	req, err := http.NewRequest("POST", "https://www.strava.com/oauth/token", strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://www.strava.com/oauth/token", strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -151,6 +158,12 @@ func GenerateAuthURL(config StravaAuthConfig) string {
 	return generateStravaAuthURL(config)
 }
 
+// GenerateAuthURLWithState returns the Strava OAuth authorization URL carrying state,
+// which Strava passes back to the redirect URI unchanged
+func GenerateAuthURLWithState(config StravaAuthConfig, state string) string {
+	return generateStravaAuthURLWithOptions(config, "https://www.strava.com/oauth/authorize", state)
+}
+
 // GenerateMobileAuthURL returns a Strava mobile OAuth URL for iOS/Android flows.
 func GenerateMobileAuthURL(config StravaAuthConfig, state string) string {
 	return generateStravaAuthURLWithOptions(config, "https://www.strava.com/oauth/mobile/authorize", state)
@@ -161,7 +174,7 @@ func GenerateMobileAppAuthURL(config StravaAuthConfig, state string) string {
 	return generateStravaAuthURLWithOptions(config, "strava://oauth/mobile/authorize", state)
 }
 
-func ConsoleLogin(config StravaAuthConfig) (string, error) {
+func ConsoleLogin(ctx context.Context, config StravaAuthConfig) (string, error) {
 	fmt.Println("Please go to the following URL to login:")
 	fmt.Println(generateStravaAuthURL(config))
 	fmt.Println("Enter the code:")
@@ -169,7 +182,7 @@ func ConsoleLogin(config StravaAuthConfig) (string, error) {
 	if _, err := fmt.Scanln(&code); err != nil {
 		return "", fmt.Errorf("read authorization code: %w", err)
 	}
-	token, err := exchangeCodeForToken(config, code)
+	token, err := exchangeCodeForToken(ctx, config, code)
 	if err != nil {
 		fmt.Println("Error exchanging code for token:", err)
 		return "", err
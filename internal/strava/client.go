@@ -0,0 +1,110 @@
+package strava
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Client is the Strava API as syncs use it. HTTPClient calls Strava; tests pass fakes
+// that answer without the network.
+type Client interface {
+	// FetchCurrentAthlete returns the athlete the access token belongs to
+	FetchCurrentAthlete(ctx context.Context, accessToken string) (*Athlete, error)
+	// FetchActivities lists the athlete's activities started between earliestTime and
+	// latestTime, of types when given
+	FetchActivities(ctx context.Context, accessToken string, earliestTime, latestTime time.Time, types []string) (ActivitySummaryList, error)
+	// GetDetailedActivities fetches each listed activity with its streams
+	GetDetailedActivities(ctx context.Context, accessToken string, activities ActivitySummaryList) (BikeActivityList, error)
+	// FetchActivity fetches one activity with its streams by ID alone; a deleted
+	// activity is ErrActivityNotFound
+	FetchActivity(ctx context.Context, accessToken string, activityID int64) (*BikeActivity, error)
+	FetchHeartRateZones(ctx context.Context, accessToken string) (*AthleteZones, error)
+	FetchGear(ctx context.Context, accessToken, gearID string) (*Gear, error)
+}
+
+// StravaAPIURL is the root of the Strava API
+const StravaAPIURL = "https://www.strava.com/api/v3"
+
+// Listing defaults: Strava's largest page, and a stop for runaway pagination
+const (
+	defaultActivitiesPerPage = 200
+	defaultPageLimit         = 100
+)
+
+// api is where the calls of a Client go and the limiter pacing them. Zero perPage and
+// maxPages use the listing defaults.
+type api struct {
+	baseURL  string
+	limiter  *RateLimiter
+	perPage  int
+	maxPages int
+}
+
+// defaultAPI is Strava's, paced by the process-wide limiter
+var defaultAPI = api{baseURL: StravaAPIURL, limiter: defaultRateLimiter}
+
+func (a api) activitiesPerPage() int {
+	if a.perPage > 0 {
+		return a.perPage
+	}
+	return defaultActivitiesPerPage
+}
+
+func (a api) pageLimit() int {
+	if a.maxPages > 0 {
+		return a.maxPages
+	}
+	return defaultPageLimit
+}
+
+// HTTPClient is the Client that calls the Strava API. The zero value calls Strava,
+// sharing the package rate limiter.
+type HTTPClient struct {
+	// BaseURL replaces StravaAPIURL, e.g. with a simulator's
+	BaseURL string
+	// Limiter paces the requests; nil shares the process-wide limiter
+	Limiter *RateLimiter
+	// PerPage and MaxPages, when positive, replace the listing's page size and page limit
+	PerPage  int
+	MaxPages int
+}
+
+// DefaultClient is used where no Client is given
+var DefaultClient Client = HTTPClient{}
+
+func (c HTTPClient) api() api {
+	a := defaultAPI
+	if c.BaseURL != "" {
+		a.baseURL = strings.TrimSuffix(c.BaseURL, "/")
+	}
+	if c.Limiter != nil {
+		a.limiter = c.Limiter
+	}
+	a.perPage, a.maxPages = c.PerPage, c.MaxPages
+	return a
+}
+
+func (c HTTPClient) FetchCurrentAthlete(ctx context.Context, accessToken string) (*Athlete, error) {
+	return c.api().fetchCurrentAthlete(ctx, accessToken)
+}
+
+func (c HTTPClient) FetchActivities(ctx context.Context, accessToken string, earliestTime, latestTime time.Time, types []string) (ActivitySummaryList, error) {
+	return c.api().fetchActivities(ctx, accessToken, earliestTime, latestTime, types)
+}
+
+func (c HTTPClient) GetDetailedActivities(ctx context.Context, accessToken string, activities ActivitySummaryList) (BikeActivityList, error) {
+	return c.api().getDetailedActivities(ctx, accessToken, activities)
+}
+
+func (c HTTPClient) FetchActivity(ctx context.Context, accessToken string, activityID int64) (*BikeActivity, error) {
+	return c.api().fetchActivity(ctx, accessToken, activityID)
+}
+
+func (c HTTPClient) FetchHeartRateZones(ctx context.Context, accessToken string) (*AthleteZones, error) {
+	return c.api().fetchHeartRateZones(ctx, accessToken)
+}
+
+func (c HTTPClient) FetchGear(ctx context.Context, accessToken, gearID string) (*Gear, error) {
+	return c.api().fetchGear(ctx, accessToken, gearID)
+}
@@ -0,0 +1,299 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/testsupport"
+)
+
+// simAPI calls sim with small pages, pacing requests on its virtual clock
+func simAPI(sim *testsupport.StravaSim) api {
+	return api{
+		baseURL:  sim.URL,
+		limiter:  NewRateLimiterWithClock(sim.Now, sim.Sleep),
+		perPage:  2,
+		maxPages: 3,
+	}
+}
+
+// simRides returns n rides a day apart, IDs from 1 oldest
+func simRides(n int) []testsupport.SimActivity {
+	start := time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)
+	rides := make([]testsupport.SimActivity, n)
+	for i := range rides {
+		rides[i] = testsupport.SimActivity{ID: int64(i + 1), Name: "Ride", Start: start.AddDate(0, 0, i)}
+	}
+	return rides
+}
+
+func summaryIDs(activities ActivitySummaryList) []int64 {
+	ids := make([]int64, 0, len(activities))
+	for _, activity := range activities {
+		ids = append(ids, activity.ID)
+	}
+	return ids
+}
+
+func TestFetchActivitiesPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		scenario testsupport.StravaScenario
+		wantIDs  []int64
+		// wantPages is the listing requests made
+		wantPages int
+	}{
+		{
+			name:      "exactly full last page asks for one more",
+			scenario:  testsupport.StravaScenario{Activities: simRides(4)},
+			wantIDs:   []int64{4, 3, 2, 1},
+			wantPages: 3,
+		},
+		{
+			name:      "short page ends the listing",
+			scenario:  testsupport.StravaScenario{Activities: simRides(3)},
+			wantIDs:   []int64{3, 2, 1},
+			wantPages: 2,
+		},
+		{
+			// Strava sometimes answers a page short before the end; the listing stops there
+			name:      "scripted short page",
+			scenario:  testsupport.StravaScenario{Activities: simRides(5), PageSizes: []int{1}},
+			wantIDs:   []int64{5},
+			wantPages: 1,
+		},
+		{
+			name:      "page limit stops a runaway listing",
+			scenario:  testsupport.StravaScenario{Activities: simRides(9)},
+			wantIDs:   []int64{9, 8, 7, 6, 5, 4},
+			wantPages: 3,
+		},
+		{
+			name: "upload mid pagination is listed once",
+			scenario: testsupport.StravaScenario{
+				Activities: simRides(4),
+				BeforePage: map[int]func(*testsupport.StravaSim){
+					2: func(sim *testsupport.StravaSim) {
+						sim.Upload(testsupport.SimActivity{ID: 10, Start: time.Date(2026, 2, 10, 8, 0, 0, 0, time.UTC)})
+					},
+				},
+			},
+			// The upload pushes 3 onto page 2, where it is dropped; 10 itself is only
+			// picked up by the next sync
+			wantIDs:   []int64{4, 3, 2, 1},
+			wantPages: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := testsupport.NewStravaSim(t, tt.scenario)
+			activities, err := simAPI(sim).fetchActivities(context.Background(), "token", time.Time{}, time.Time{}, nil)
+			if err != nil {
+				t.Fatalf("fetchActivities: %v", err)
+			}
+			if got := summaryIDs(activities); !slices.Equal(got, tt.wantIDs) {
+				t.Fatalf("listed %v, want %v", got, tt.wantIDs)
+			}
+			if pages := len(sim.RequestsTo("/athlete/activities")); pages != tt.wantPages {
+				t.Fatalf("fetched %d pages, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestFetchActivitiesWaitsOutThrottling(t *testing.T) {
+	sim := testsupport.NewStravaSim(t, testsupport.StravaScenario{
+		Activities:        simRides(3),
+		ThrottledRequests: []int{2},
+	})
+	var waits []RateLimitWindow
+	ctx := WithRateLimitNotifier(context.Background(), func(wait time.Duration, window RateLimitWindow) {
+		waits = append(waits, window)
+	})
+
+	activities, err := simAPI(sim).fetchActivities(ctx, "token", time.Time{}, time.Time{}, nil)
+	if err != nil {
+		t.Fatalf("fetchActivities: %v", err)
+	}
+	if got := summaryIDs(activities); !slices.Equal(got, []int64{3, 2, 1}) {
+		t.Fatalf("listed %v, want every activity once", got)
+	}
+	if !slices.Equal(waits, []RateLimitWindow{RateLimitShortTerm}) {
+		t.Fatalf("notified %v, want one 15-minute wait", waits)
+	}
+	// The sim starts at 06:00, so the wait runs to the 06:15 reset
+	if sleeps := sim.Sleeps(); len(sleeps) != 1 || sleeps[0] != 15*time.Minute+time.Second {
+		t.Fatalf("slept %v, want until just after the window reset", sleeps)
+	}
+	if requests := sim.Requests(); len(requests) != 3 || requests[1] != requests[2] {
+		t.Fatalf("requests = %v, want the throttled page asked again", requests)
+	}
+}
+
+func TestFetchActivitiesFailsWhenThrottlingPersists(t *testing.T) {
+	sim := testsupport.NewStravaSim(t, testsupport.StravaScenario{
+		Activities:        simRides(1),
+		ThrottledRequests: []int{1, 2, 3, 4},
+	})
+	_, err := simAPI(sim).fetchActivities(context.Background(), "token", time.Time{}, time.Time{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "429") {
+		t.Fatalf("err = %v, want the 429 once the retries are spent", err)
+	}
+	if len(sim.Sleeps()) != maxRateLimitRetries {
+		t.Fatalf("slept %v, want a wait before each retry", sim.Sleeps())
+	}
+}
+
+func TestFetchActivitiesStopsWhenCancelledWhileThrottled(t *testing.T) {
+	sim := testsupport.NewStravaSim(t, testsupport.StravaScenario{
+		Activities:        simRides(1),
+		ThrottledRequests: []int{1},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = WithRateLimitNotifier(ctx, func(time.Duration, RateLimitWindow) { cancel() })
+	_, err := simAPI(sim).fetchActivities(ctx, "token", time.Time{}, time.Time{}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if len(sim.Requests()) != 1 {
+		t.Fatalf("requests = %v, want no retry after cancellation", sim.Requests())
+	}
+}
+
+func TestFetchActivityDetails(t *testing.T) {
+	sim := testsupport.NewStravaSim(t, testsupport.StravaScenario{
+		AthleteID: 7,
+		Activities: []testsupport.SimActivity{
+			{ID: 1, Start: time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC), Points: 50},
+			{ID: 2, Deleted: true},
+			{ID: 3, Failures: 1},
+		},
+	})
+	a := simAPI(sim)
+
+	activity, err := a.fetchActivity(context.Background(), "token", 1)
+	if err != nil {
+		t.Fatalf("fetchActivity: %v", err)
+	}
+	if activity.Summary.AthleteID != 7 || len(activity.LatLngStream.Data) != 50 || len(activity.TimeStream.Data) != 50 {
+		t.Fatalf("activity = athlete %d with %d points", activity.Summary.AthleteID, len(activity.LatLngStream.Data))
+	}
+
+	if _, err := a.fetchActivity(context.Background(), "token", 2); !errors.Is(err, ErrActivityNotFound) {
+		t.Fatalf("deleted activity err = %v, want ErrActivityNotFound", err)
+	}
+	// A server error is not a deletion, so the caller retries it rather than giving up
+	if _, err := a.fetchActivity(context.Background(), "token", 3); err == nil || errors.Is(err, ErrActivityNotFound) {
+		t.Fatalf("failing activity err = %v, want a retryable error", err)
+	}
+	if _, err := a.fetchActivity(context.Background(), "token", 3); err != nil {
+		t.Fatalf("retried activity: %v", err)
+	}
+}
+
+func TestFetchActivityStreamVariants(t *testing.T) {
+	activities := []testsupport.SimActivity{{ID: 1, Start: time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC), Points: 2500}}
+	tests := []struct {
+		name       string
+		scenario   testsupport.StravaScenario
+		wantPoints int
+	}{
+		{"keyed by type", testsupport.StravaScenario{Activities: activities}, 2500},
+		{"array", testsupport.StravaScenario{Activities: activities, StreamsAsArray: true}, 2500},
+		{"medium resolution", testsupport.StravaScenario{Activities: activities, Resolution: testsupport.ResolutionMedium}, 1000},
+		{"low resolution array", testsupport.StravaScenario{Activities: activities, Resolution: testsupport.ResolutionLow, StreamsAsArray: true}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := testsupport.NewStravaSim(t, tt.scenario)
+			activity, err := simAPI(sim).fetchActivity(context.Background(), "token", 1)
+			if err != nil {
+				t.Fatalf("fetchActivity: %v", err)
+			}
+			streams := map[string]int{
+				"time":      len(activity.TimeStream.Data),
+				"latlng":    len(activity.LatLngStream.Data),
+				"distance":  len(activity.DistanceStream.Data),
+				"altitude":  len(activity.AltitudeStream.Data),
+				"heartrate": len(activity.HeartrateStream.Data),
+				"moving":    len(activity.MovingStream.Data),
+			}
+			for stream, points := range streams {
+				if points != tt.wantPoints {
+					t.Fatalf("%s stream has %d points, want %d", stream, points, tt.wantPoints)
+				}
+			}
+			// Downsampled times still span the whole ride
+			last := activity.TimeStream.Data[len(activity.TimeStream.Data)-1]
+			if got := last.Sub(activity.Summary.StartDateTime); got != 2499*time.Second {
+				t.Fatalf("time stream ends %s after the start, want 2499s", got)
+			}
+		})
+	}
+}
+
+func TestGetDetailedActivitiesStopsWhenCancelled(t *testing.T) {
+	sim := testsupport.NewStravaSim(t, testsupport.StravaScenario{Activities: simRides(3)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Cancel while the first activity's streams are in flight
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/activities/1/streams" {
+			cancel()
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	a := simAPI(sim)
+	a.baseURL = server.URL
+
+	fetched, err := a.getDetailedActivities(ctx, "token", ActivitySummaryList{{ID: 1}, {ID: 2}, {ID: 3}})
+	if !errors.Is(err, context.Canceled) || len(fetched) != 0 {
+		t.Fatalf("fetched %d, err = %v; want context.Canceled", len(fetched), err)
+	}
+	if requests := sim.Requests(); len(requests) != 2 {
+		t.Fatalf("requests = %v, want none after the cancelled one", requests)
+	}
+
+	// Cancelled before it starts, nothing is asked for at all
+	fetched, err = a.getDetailedActivities(ctx, "token", ActivitySummaryList{{ID: 2}})
+	if !errors.Is(err, context.Canceled) || len(fetched) != 0 || len(sim.Requests()) != 2 {
+		t.Fatalf("fetched %d, err = %v after %d requests; want none", len(fetched), err, len(sim.Requests()))
+	}
+}
+
+func TestCancellationAbortsAnInFlightRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Strava hangs; only the client going away ends the request
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client := HTTPClient{BaseURL: server.URL, Limiter: NewRateLimiter()}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	calls := map[string]func() error{
+		"athlete": func() error { _, err := client.FetchCurrentAthlete(ctx, "token"); return err },
+		"zones":   func() error { _, err := client.FetchHeartRateZones(ctx, "token"); return err },
+		"gear":    func() error { _, err := client.FetchGear(ctx, "token", "b1"); return err },
+		"listing": func() error {
+			_, err := client.FetchActivities(ctx, "token", time.Time{}, time.Time{}, nil)
+			return err
+		},
+	}
+	for name, call := range calls {
+		started := time.Now()
+		if err := call(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s err = %v, want context.DeadlineExceeded", name, err)
+		}
+		if elapsed := time.Since(started); elapsed > 5*time.Second {
+			t.Errorf("%s returned after %s, want as soon as ctx is done", name, elapsed)
+		}
+	}
+}
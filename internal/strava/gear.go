@@ -1,17 +1,22 @@
 package strava
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
+	"net/url"
 )
 
 // FetchGear retrieves a Strava gear object by ID.
-func FetchGear(accessToken, gearID string) (*Gear, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest("GET", "https://www.strava.com/api/v3/gear/"+gearID, nil)
+func FetchGear(ctx context.Context, accessToken, gearID string) (*Gear, error) {
+	return defaultAPI.fetchGear(ctx, accessToken, gearID)
+}
+
+func (a api) fetchGear(ctx context.Context, accessToken, gearID string) (*Gear, error) {
+	client := newHTTPClient()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/gear/"+url.PathEscape(gearID), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -22,6 +27,7 @@ func FetchGear(accessToken, gearID string) (*Gear, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	a.limiter.Observe(resp)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -0,0 +1,32 @@
+package strava
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchGearDecodesDetailedGear(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gear/b123" || r.Header.Get("Authorization") != "Bearer token-a" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "b123", "primary": true, "name": "Gravel", "distance": 4200000,
+			"brand_name": "Canyon", "model_name": "Grizl", "retired": false}`))
+	}))
+	defer server.Close()
+	client := HTTPClient{BaseURL: server.URL}
+
+	gear, err := client.FetchGear(context.Background(), "token-a", "b123")
+	if err != nil {
+		t.Fatalf("FetchGear: %v", err)
+	}
+	if gear.ID != "b123" || gear.Name != "Gravel" || gear.BrandName != "Canyon" || gear.ModelName != "Grizl" || gear.Retired {
+		t.Fatalf("gear = %+v", gear)
+	}
+	if _, err := client.FetchGear(context.Background(), "token-a", "b999"); err == nil {
+		t.Fatal("a 404 response was not reported")
+	}
+}
@@ -0,0 +1,48 @@
+package strava
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"b11k/internal/metrics"
+)
+
+// requestTimeout bounds every Strava call
+const requestTimeout = 30 * time.Second
+
+// newHTTPClient returns the client of a Strava call. Its transport counts each request
+// in strava_api_requests_total, retries after a 429 included.
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: requestTimeout, Transport: countingTransport{next: http.DefaultTransport}}
+}
+
+type countingTransport struct {
+	next http.RoundTripper
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	status := metrics.StatusError
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.StravaRequest(endpointLabel(req.URL.Path), status)
+	return resp, err
+}
+
+// endpointLabel is the path below /api/v3 with its IDs replaced, e.g.
+// /activities/{id}/streams, so the label takes a handful of values
+func endpointLabel(path string) string {
+	if i := strings.Index(path, "/api/v3"); i >= 0 {
+		path = path[i+len("/api/v3"):]
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "0123456789") {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,50 @@
+package strava
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/metrics"
+)
+
+func TestEndpointLabelHidesIDs(t *testing.T) {
+	for path, want := range map[string]string{
+		"/api/v3/activities/12345/streams": "/activities/{id}/streams",
+		"/api/v3/athlete/activities":       "/athlete/activities",
+		"/api/v3/gear/b9876":               "/gear/{id}",
+		"/oauth/token":                     "/oauth/token",
+	} {
+		if got := endpointLabel(path); got != want {
+			t.Fatalf("endpointLabel(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRequestsAreCountedByStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	resp, err := newHTTPClient().Get(srv.URL + "/api/v3/routes/77")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if _, err := newHTTPClient().Get("http://127.0.0.1:0/api/v3/routes/78"); err == nil {
+		t.Fatal("Get to a closed port succeeded")
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`strava_api_requests_total{endpoint="/routes/{id}",status="429"} 1`,
+		`strava_api_requests_total{endpoint="/routes/{id}",status="error"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics lack %q", want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package strava
+
+import "b11k/internal/geo"
+
+// RouteLatLng returns the route as [lat, lng] pairs: the latlng stream when Strava sent
+// one, otherwise the decoded map polyline. Older and privacy-restricted activities often
+// come without streams but still carry a polyline.
+func (b *BikeActivity) RouteLatLng() [][]float64 {
+	if len(b.LatLngStream.Data) > 0 {
+		return b.LatLngStream.Data
+	}
+	for _, encoded := range []string{b.Map.Polyline, b.Map.SummaryPolyline} {
+		if encoded == "" {
+			continue
+		}
+		points, err := geo.DecodePolyline(encoded)
+		if err != nil {
+			logger().Warn("Failed to decode polyline", "activity_id", b.Summary.ID, "error", err)
+			continue
+		}
+		if len(points) >= 2 {
+			return points
+		}
+	}
+	return nil
+}
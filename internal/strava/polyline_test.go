@@ -0,0 +1,21 @@
+package strava
+
+import "testing"
+
+func TestRouteLatLngFallsBackToPolyline(t *testing.T) {
+	var activity BikeActivity
+	activity.Map.SummaryPolyline = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+	if got := activity.RouteLatLng(); len(got) != 3 || got[0][0] != 38.5 {
+		t.Fatalf("summary polyline fallback = %v", got)
+	}
+
+	activity.Map.Polyline = "b_vmEaa|y[??oiAqd@"
+	if got := activity.RouteLatLng(); len(got) != 3 || got[0][0] != -33.86882 {
+		t.Fatalf("detailed polyline not preferred: %v", got)
+	}
+
+	activity.LatLngStream.Data = [][]float64{{1, 2}, {3, 4}}
+	if got := activity.RouteLatLng(); len(got) != 2 || got[0][0] != 1 {
+		t.Fatalf("latlng stream not preferred: %v", got)
+	}
+}
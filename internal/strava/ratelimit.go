@@ -0,0 +1,231 @@
+package strava
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strava's documented default application limits
+const (
+	DefaultShortTermLimit = 100  // requests per 15 minutes
+	DefaultDailyLimit     = 1000 // requests per UTC day
+
+	shortTermWindow = 15 * time.Minute
+	// rateLimitReserve keeps a few requests spare for interactive pages while a sync runs
+	rateLimitReserve    = 2
+	maxRateLimitRetries = 3
+)
+
+// RateLimitWindow names the Strava limit a caller is waiting on
+type RateLimitWindow string
+
+const (
+	RateLimitShortTerm RateLimitWindow = "15-minute"
+	RateLimitDaily     RateLimitWindow = "daily"
+)
+
+// RateLimitWaitFunc is told before the limiter sleeps, so long syncs can explain the pause
+type RateLimitWaitFunc func(wait time.Duration, window RateLimitWindow)
+
+type rateLimitWaitKey struct{}
+
+// WithRateLimitNotifier returns a context whose Strava calls report rate limit waits to fn
+func WithRateLimitNotifier(ctx context.Context, fn RateLimitWaitFunc) context.Context {
+	return context.WithValue(ctx, rateLimitWaitKey{}, fn)
+}
+
+// RateLimiter tracks Strava's 15-minute and daily request windows from the
+// X-RateLimit-Limit / X-RateLimit-Usage response headers and delays requests
+// before either limit is reached.
+type RateLimiter struct {
+	mu          sync.Mutex
+	shortLimit  int
+	dailyLimit  int
+	shortUsage  int
+	dailyUsage  int
+	shortWindow time.Time // start of the 15-minute window the usage belongs to
+	dailyWindow time.Time // start of the UTC day the usage belongs to
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRateLimiter returns a limiter that assumes Strava's default limits until the
+// first response reports the real ones.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		shortLimit: DefaultShortTermLimit,
+		dailyLimit: DefaultDailyLimit,
+		now:        time.Now,
+		sleep:      sleepContext,
+	}
+}
+
+// NewRateLimiterWithClock is NewRateLimiter on another clock: waits call sleep instead
+// of blocking, and windows roll over by now. Simulations pass a virtual clock so a wait
+// for Strava's limits to reset takes no time.
+func NewRateLimiterWithClock(now func() time.Time, sleep func(ctx context.Context, d time.Duration) error) *RateLimiter {
+	l := NewRateLimiter()
+	l.now = now
+	l.sleep = sleep
+	return l
+}
+
+// defaultRateLimiter is shared by every call in the process: Strava limits apply to the
+// application, not to an athlete or token.
+var defaultRateLimiter = NewRateLimiter()
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rollWindows clears usage that belongs to windows that have since reset. Callers hold mu.
+func (l *RateLimiter) rollWindows(now time.Time) {
+	shortWindow := now.UTC().Truncate(shortTermWindow)
+	if !l.shortWindow.Equal(shortWindow) {
+		l.shortWindow = shortWindow
+		l.shortUsage = 0
+	}
+	dailyWindow := startOfUTCDay(now)
+	if !l.dailyWindow.Equal(dailyWindow) {
+		l.dailyWindow = dailyWindow
+		l.dailyUsage = 0
+	}
+}
+
+func startOfUTCDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// reserve counts one request against both windows, or returns how long to wait for the
+// exhausted window to reset.
+func (l *RateLimiter) reserve() (time.Duration, RateLimitWindow) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.rollWindows(now)
+	if l.dailyUsage >= l.dailyLimit-rateLimitReserve {
+		return l.dailyWindow.Add(24 * time.Hour).Sub(now), RateLimitDaily
+	}
+	if l.shortUsage >= l.shortLimit-rateLimitReserve {
+		return l.shortWindow.Add(shortTermWindow).Sub(now), RateLimitShortTerm
+	}
+	l.shortUsage++
+	l.dailyUsage++
+	return 0, ""
+}
+
+// Remaining reports how many requests are left in the current 15-minute and daily
+// windows before the limiter starts waiting
+func (l *RateLimiter) Remaining() (short, daily int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollWindows(l.now())
+	short = max(l.shortLimit-rateLimitReserve-l.shortUsage, 0)
+	daily = max(l.dailyLimit-rateLimitReserve-l.dailyUsage, 0)
+	return short, daily
+}
+
+// RemainingRequests reports the process-wide Strava budget left, see Remaining
+func RemainingRequests() (short, daily int) {
+	return defaultRateLimiter.Remaining()
+}
+
+// Wait blocks until a request fits in both windows or ctx is cancelled
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait, window := l.reserve()
+		if wait <= 0 {
+			return nil
+		}
+		if notify, ok := ctx.Value(rateLimitWaitKey{}).(RateLimitWaitFunc); ok && notify != nil {
+			notify(wait, window)
+		}
+		logger().Warn("Strava rate limit reached, waiting", "window", string(window), "wait", wait.Round(time.Second))
+		// Wake just after the reset so the next reserve sees the new window
+		if err := l.sleep(ctx, wait+time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// Observe updates the windows from a Strava response. Read-specific headers are
+// preferred because every call this package makes is a read.
+func (l *RateLimiter) Observe(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	limitHeader, usageHeader := resp.Header.Get("X-ReadRateLimit-Limit"), resp.Header.Get("X-ReadRateLimit-Usage")
+	if limitHeader == "" || usageHeader == "" {
+		limitHeader, usageHeader = resp.Header.Get("X-RateLimit-Limit"), resp.Header.Get("X-RateLimit-Usage")
+	}
+	shortLimit, dailyLimit, limitOK := parseRateLimitPair(limitHeader)
+	shortUsage, dailyUsage, usageOK := parseRateLimitPair(usageHeader)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rollWindows(l.now())
+	if limitOK && shortLimit > 0 && dailyLimit > 0 {
+		l.shortLimit = shortLimit
+		l.dailyLimit = dailyLimit
+	}
+	if usageOK {
+		l.shortUsage = shortUsage
+		l.dailyUsage = dailyUsage
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// Whichever window is exhausted, wait out at least the short one
+		l.shortUsage = max(l.shortUsage, l.shortLimit)
+		if usageOK && dailyUsage >= l.dailyLimit {
+			l.dailyUsage = l.dailyLimit
+		}
+	}
+}
+
+func parseRateLimitPair(header string) (int, int, bool) {
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	short, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	daily, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return short, daily, true
+}
+
+// Do sends req once a request fits in the limits, records the returned usage and
+// retries after a 429 once the window has reset.
+func (l *RateLimiter) Do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	for attempt := 0; ; attempt++ {
+		if err := l.Wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		l.Observe(resp)
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitRetries {
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+	}
+}
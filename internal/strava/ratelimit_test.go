@@ -0,0 +1,150 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClockLimiter returns a limiter whose sleeps advance a fake clock instead of blocking
+func fakeClockLimiter(start time.Time) (*RateLimiter, *time.Time, *[]time.Duration) {
+	now := start
+	var slept []time.Duration
+	l := NewRateLimiter()
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	return l, &now, &slept
+}
+
+func rateLimitResponse(status int, limit, usage string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	resp.Header.Set("X-RateLimit-Limit", limit)
+	resp.Header.Set("X-RateLimit-Usage", usage)
+	return resp
+}
+
+func TestRateLimiterObserveParsesHeaders(t *testing.T) {
+	l, _, _ := fakeClockLimiter(time.Date(2024, 5, 1, 10, 3, 0, 0, time.UTC))
+	resp := rateLimitResponse(http.StatusOK, "200,2000", "42,310")
+	resp.Header.Set("X-ReadRateLimit-Limit", "100,1000")
+	resp.Header.Set("X-ReadRateLimit-Usage", "12, 140")
+	l.Observe(resp)
+	if l.shortLimit != 100 || l.dailyLimit != 1000 || l.shortUsage != 12 || l.dailyUsage != 140 {
+		t.Fatalf("read headers not preferred: limits %d/%d usage %d/%d", l.shortLimit, l.dailyLimit, l.shortUsage, l.dailyUsage)
+	}
+
+	l.Observe(rateLimitResponse(http.StatusOK, "200,2000", "42,310"))
+	if l.shortLimit != 200 || l.dailyLimit != 2000 || l.shortUsage != 42 || l.dailyUsage != 310 {
+		t.Fatalf("overall headers: limits %d/%d usage %d/%d", l.shortLimit, l.dailyLimit, l.shortUsage, l.dailyUsage)
+	}
+
+	l.Observe(rateLimitResponse(http.StatusOK, "garbage", ""))
+	if l.shortLimit != 200 || l.shortUsage != 42 {
+		t.Fatalf("malformed headers changed state: limit %d usage %d", l.shortLimit, l.shortUsage)
+	}
+}
+
+func TestRateLimiterWaitsForShortTermWindow(t *testing.T) {
+	l, now, slept := fakeClockLimiter(time.Date(2024, 5, 1, 10, 3, 0, 0, time.UTC))
+	l.Observe(rateLimitResponse(http.StatusOK, "100,1000", "98,300"))
+
+	var notified RateLimitWindow
+	ctx := WithRateLimitNotifier(context.Background(), func(wait time.Duration, window RateLimitWindow) {
+		notified = window
+	})
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if notified != RateLimitShortTerm {
+		t.Fatalf("notified window = %q, want %q", notified, RateLimitShortTerm)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 12*time.Minute+time.Second {
+		t.Fatalf("slept %v, want one 12m1s wait until 10:15", *slept)
+	}
+	if now.Before(time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)) {
+		t.Fatalf("clock at %s, want past the 10:15 reset", now)
+	}
+	if l.shortUsage != 1 || l.dailyUsage != 301 {
+		t.Fatalf("usage after wait = %d/%d, want 1/301", l.shortUsage, l.dailyUsage)
+	}
+}
+
+func TestRateLimiterWaitsForDailyWindow(t *testing.T) {
+	l, _, slept := fakeClockLimiter(time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC))
+	l.Observe(rateLimitResponse(http.StatusOK, "100,1000", "5,999"))
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 2*time.Hour+time.Second {
+		t.Fatalf("slept %v, want one wait until UTC midnight", *slept)
+	}
+}
+
+func TestRateLimiterWaitHonoursCancellation(t *testing.T) {
+	l := NewRateLimiter()
+	l.Observe(rateLimitResponse(http.StatusOK, "100,1000", "100,100"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiterDoRetriesAfterTooManyRequests(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-RateLimit-Limit", "100,1000")
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Usage", "100,400")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("X-RateLimit-Usage", "1,401")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	l, _, slept := fakeClockLimiter(time.Date(2024, 5, 1, 10, 14, 0, 0, time.UTC))
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := l.Do(context.Background(), server.Client(), req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests != 2 {
+		t.Fatalf("status %d after %d requests, want 200 after 2", resp.StatusCode, requests)
+	}
+	if len(*slept) != 1 {
+		t.Fatalf("slept %v, want one wait for the window reset", *slept)
+	}
+}
+
+func TestRateLimiterRemaining(t *testing.T) {
+	l, now, _ := fakeClockLimiter(time.Date(2024, 5, 1, 10, 3, 0, 0, time.UTC))
+	l.Observe(rateLimitResponse(http.StatusOK, "100,1000", "40,990"))
+	if short, daily := l.Remaining(); short != 58 || daily != 8 {
+		t.Fatalf("remaining = %d/%d, want 58/8", short, daily)
+	}
+	l.Observe(rateLimitResponse(http.StatusOK, "100,1000", "99,999"))
+	if short, daily := l.Remaining(); short != 0 || daily != 0 {
+		t.Fatalf("remaining past the reserve = %d/%d, want 0/0", short, daily)
+	}
+	*now = now.Add(15 * time.Minute)
+	if short, daily := l.Remaining(); short != 98 || daily != 0 {
+		t.Fatalf("remaining after the short window reset = %d/%d, want 98/0", short, daily)
+	}
+}
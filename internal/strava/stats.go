@@ -13,7 +13,6 @@ func CalculateStats(activities ActivitySummaryList) {
 	totalElapsedTime := 0.0
 	totalElevationGain := 0.0
 	totalCalories := 0.0
-	totalSpeed := 0.0
 	totalMaxSpeed := 0.0
 
 	earliest := time.Time{}
@@ -35,7 +34,6 @@ func CalculateStats(activities ActivitySummaryList) {
 		totalElapsedTime += activity.ElapsedTime
 		totalElevationGain += activity.TotalElevationGain
 		totalCalories += activity.Kilojoules * 0.239006
-		totalSpeed += activity.AverageSpeed
 		totalMaxSpeed += activity.MaxSpeed
 		totalActivities++
 	}
@@ -48,6 +46,12 @@ func CalculateStats(activities ActivitySummaryList) {
 	avgIncline := totalElevationGain / totalDistance * 100
 	avgInclineDegrees := math.Atan2(totalElevationGain, totalDistance) * 180 / math.Pi
 	bikeTimePercentage := totalElapsedTime / latest.Sub(earliest).Seconds() * 100
+	// Moving average over all rides: total distance over total moving time, not a mean of
+	// per-activity averages, which would weigh a short ride like a long one
+	avgMovingSpeed := 0.0
+	if totalMovingTime > 0 {
+		avgMovingSpeed = totalDistance / totalMovingTime * 3.6
+	}
 	fmt.Printf("Total activities: %d\n", totalActivities)
 	fmt.Printf("Total distance: %.0f km\n", totalDistance/1000)
 	fmt.Printf("Total moving time: %.2f hours\n", totalMovingTime/3600)
@@ -61,7 +65,7 @@ func CalculateStats(activities ActivitySummaryList) {
 	fmt.Printf("Avg elevation gain: %.2f m per activity, %.2f m per week\n",
 		avgElevationGainPerActivity, avgElevationGainPerWeek)
 	fmt.Printf("Virtual incline: %.2f%% (%.4f°)\n", avgIncline, avgInclineDegrees)
-	fmt.Printf("Avg max speed: %.2f km/h, avg speed: %.2f km/h\n",
-		totalMaxSpeed*3.6/(float64(totalActivities)), totalSpeed*3.6/float64(totalActivities))
+	fmt.Printf("Avg max speed: %.2f km/h, avg moving speed: %.2f km/h\n",
+		totalMaxSpeed*3.6/(float64(totalActivities)), avgMovingSpeed)
 	fmt.Printf("Bike time percentage: %.5f%%\n", bikeTimePercentage)
 }
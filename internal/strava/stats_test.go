@@ -0,0 +1,33 @@
+package strava
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCalculateStatsWithoutMovingTime(t *testing.T) {
+	activities := ActivitySummaryList{
+		{StartDate: "2025-03-01T08:00:00Z", Distance: 1000, ElapsedTime: 600},
+		{StartDate: "2025-03-08T08:00:00Z", Distance: 2000, ElapsedTime: 900},
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	CalculateStats(activities)
+	os.Stdout = stdout
+	_ = w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(out), "avg moving speed: 0.00 km/h") {
+		t.Fatalf("activities without moving time should average 0 km/h:\n%s", out)
+	}
+}
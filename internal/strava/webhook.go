@@ -0,0 +1,126 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// pushSubscriptionsURL is Strava's webhook subscription endpoint; a variable so tests can
+// point it at a fake server
+var pushSubscriptionsURL = "https://www.strava.com/api/v3/push_subscriptions"
+
+// WebhookSubscription is the app's Strava push subscription. Strava allows one per app.
+type WebhookSubscription struct {
+	ID            int64  `json:"id"`
+	ApplicationID int64  `json:"application_id"`
+	CallbackURL   string `json:"callback_url"`
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+// WebhookEvent is the payload Strava posts to the callback URL
+type WebhookEvent struct {
+	ObjectType string `json:"object_type"` // "activity" or "athlete"
+	ObjectID   int64  `json:"object_id"`
+	AspectType string `json:"aspect_type"` // "create", "update" or "delete"
+	// Updates lists changed fields for "update" events, e.g. {"title": "Morning Ride"};
+	// {"authorized": "false"} on an athlete means they revoked access
+	Updates        map[string]string `json:"updates"`
+	OwnerID        int64             `json:"owner_id"`
+	SubscriptionID int64             `json:"subscription_id"`
+	EventTime      int64             `json:"event_time"`
+}
+
+// CreateWebhookSubscription subscribes the app to push events. Strava validates
+// callbackURL straight away with a GET carrying hub.challenge and verifyToken, so the
+// server must already be reachable there.
+func CreateWebhookSubscription(ctx context.Context, config StravaAuthConfig, callbackURL, verifyToken string) (*WebhookSubscription, error) {
+	data := url.Values{}
+	data.Set("client_id", config.ClientID)
+	data.Set("client_secret", config.ClientSecret)
+	data.Set("callback_url", callbackURL)
+	data.Set("verify_token", verifyToken)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", pushSubscriptionsURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, err := doPushSubscriptionRequest(req, http.StatusCreated, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+	subscription := WebhookSubscription{CallbackURL: callbackURL}
+	if err := json.Unmarshal(body, &subscription); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ViewWebhookSubscription returns the app's push subscription, or nil when there is none
+func ViewWebhookSubscription(ctx context.Context, config StravaAuthConfig) (*WebhookSubscription, error) {
+	params := url.Values{}
+	params.Set("client_id", config.ClientID)
+	params.Set("client_secret", config.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pushSubscriptionsURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	body, err := doPushSubscriptionRequest(req, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to view webhook subscription: %w", err)
+	}
+	var subscriptions []WebhookSubscription
+	if err := json.Unmarshal(body, &subscriptions); err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, nil
+	}
+	return &subscriptions[0], nil
+}
+
+// DeleteWebhookSubscription removes the push subscription with the given ID
+func DeleteWebhookSubscription(ctx context.Context, config StravaAuthConfig, subscriptionID int64) error {
+	params := url.Values{}
+	params.Set("client_id", config.ClientID)
+	params.Set("client_secret", config.ClientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/%d?%s", pushSubscriptionsURL, subscriptionID, params.Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if _, err := doPushSubscriptionRequest(req, http.StatusNoContent, http.StatusOK); err != nil {
+		return fmt.Errorf("failed to delete webhook subscription %d: %w", subscriptionID, err)
+	}
+	return nil
+}
+
+func doPushSubscriptionRequest(req *http.Request, okStatuses ...int) ([]byte, error) {
+	client := newHTTPClient()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Strava API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range okStatuses {
+		if resp.StatusCode == status {
+			return body, nil
+		}
+	}
+	return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+}
@@ -0,0 +1,68 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func fakePushSubscriptions(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	previous := pushSubscriptionsURL
+	pushSubscriptionsURL = ts.URL + "/push_subscriptions"
+	t.Cleanup(func() { pushSubscriptionsURL = previous })
+}
+
+func TestWebhookSubscriptionLifecycle(t *testing.T) {
+	config := StravaAuthConfig{ClientID: "123", ClientSecret: "secret"}
+	var subscribed bool
+	fakePushSubscriptions(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Form.Get("client_id") != "123" || r.Form.Get("client_secret") != "secret" {
+			t.Errorf("%s %s without app credentials", r.Method, r.URL.Path)
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/push_subscriptions":
+			if r.Form.Get("callback_url") != "https://b11k.example/strava/webhook" || r.Form.Get("verify_token") != "verify" {
+				t.Errorf("create form = %v", r.Form)
+			}
+			subscribed = true
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]int64{"id": 77})
+		case r.Method == http.MethodGet && r.URL.Path == "/push_subscriptions":
+			subscriptions := []WebhookSubscription{}
+			if subscribed {
+				subscriptions = append(subscriptions, WebhookSubscription{ID: 77, CallbackURL: "https://b11k.example/strava/webhook"})
+			}
+			_ = json.NewEncoder(w).Encode(subscriptions)
+		case r.Method == http.MethodDelete && r.URL.Path == "/push_subscriptions/77" && subscribed:
+			subscribed = false
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, `{"message":"Resource Not Found"}`, http.StatusNotFound)
+		}
+	})
+
+	if subscription, err := ViewWebhookSubscription(context.Background(), config); err != nil || subscription != nil {
+		t.Fatalf("view before create = %+v, %v; want none", subscription, err)
+	}
+	created, err := CreateWebhookSubscription(context.Background(), config, "https://b11k.example/strava/webhook", "verify")
+	if err != nil || created.ID != 77 || created.CallbackURL != "https://b11k.example/strava/webhook" {
+		t.Fatalf("create = %+v, %v", created, err)
+	}
+	if subscription, err := ViewWebhookSubscription(context.Background(), config); err != nil || subscription == nil || subscription.ID != 77 {
+		t.Fatalf("view after create = %+v, %v", subscription, err)
+	}
+	if err := DeleteWebhookSubscription(context.Background(), config, 77); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := DeleteWebhookSubscription(context.Background(), config, 77); err == nil {
+		t.Fatal("deleting a missing subscription succeeded")
+	}
+}
@@ -1,11 +1,11 @@
 package strava
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 )
 
 // AthleteZones models the Strava athlete zones response
@@ -25,9 +25,13 @@ type HRZone struct {
 }
 
 // FetchHeartRateZones retrieves the authenticated athlete's heart rate zones using the access token
-func FetchHeartRateZones(accessToken string) (*AthleteZones, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	req, err := http.NewRequest("GET", "https://www.strava.com/api/v3/athlete/zones", nil)
+func FetchHeartRateZones(ctx context.Context, accessToken string) (*AthleteZones, error) {
+	return defaultAPI.fetchHeartRateZones(ctx, accessToken)
+}
+
+func (a api) fetchHeartRateZones(ctx context.Context, accessToken string) (*AthleteZones, error) {
+	client := newHTTPClient()
+	req, err := http.NewRequestWithContext(ctx, "GET", a.baseURL+"/athlete/zones", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,6 +42,7 @@ func FetchHeartRateZones(accessToken string) (*AthleteZones, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	a.limiter.Observe(resp)
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -0,0 +1,30 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+)
+
+// measureNewRoads computes the new distance of every activity of the athlete that has
+// none yet, the ones this sync saved and any left by earlier syncs or imports, reporting
+// an "exploring" phase. A failure is recorded in result.Errors but does not fail the sync:
+// the remaining activities are measured by the next sync.
+func measureNewRoads(ctx context.Context, db store, athleteID int64, result *SyncResult, progressCallback ProgressCallback) {
+	if athleteID == 0 || ctx.Err() != nil {
+		return
+	}
+	measured, err := db.ComputeNewDistances(ctx, athleteID, func(done, total int) {
+		if progressCallback != nil {
+			progressCallback("exploring", done, total, fmt.Sprintf("Measured new roads of activity %d/%d", done, total))
+		}
+	})
+	result.ExploredActivities += measured
+	if err != nil {
+		logger().Warn("Failed to measure new roads", "athlete_id", athleteID, "error", err)
+		result.Errors = append(result.Errors, fmt.Errorf("failed to measure new roads: %w", err))
+		return
+	}
+	if measured > 0 {
+		logger().Info("Measured new roads", "athlete_id", athleteID, "activities", measured)
+	}
+}
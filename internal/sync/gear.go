@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"context"
+	"strings"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// maxGearFetchesPerSync caps the gear requests of one sync; gear left over is fetched by
+// the next one
+const maxGearFetchesPerSync = 10
+
+func (c SyncConfig) fetchGear(ctx context.Context, gearID string) (*strava.Gear, error) {
+	if c.FetchGear != nil {
+		return c.FetchGear(ctx, c.accessToken(), gearID)
+	}
+	return c.stravaClient().FetchGear(ctx, c.accessToken(), gearID)
+}
+
+// resolveNewGear fetches the details of gear the athlete's activities name but the gear
+// table does not know yet, and returns how many it stored. Failures are logged only: gear
+// is not worth failing a sync over.
+func resolveNewGear(ctx context.Context, db store, config SyncConfig, athleteID int64) int {
+	gearIDs, err := db.GetUnresolvedGearIDs(ctx, athleteID, maxGearFetchesPerSync)
+	if err != nil {
+		logger().Warn("Failed to list unresolved gear", "athlete_id", athleteID, "error", err)
+		return 0
+	}
+	stored := 0
+	for _, gearID := range gearIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		gear, err := config.fetchGear(ctx, gearID)
+		if err != nil {
+			logger().Warn("Failed to fetch gear", "athlete_id", athleteID, "gear_id", gearID, "error", err)
+			continue
+		}
+		if err := db.UpsertGear(ctx, pggeo.Gear{
+			ID:        gearID,
+			AthleteID: athleteID,
+			Name:      strings.TrimSpace(gear.Name),
+			Brand:     strings.TrimSpace(gear.BrandName),
+			Model:     strings.TrimSpace(gear.ModelName),
+			Retired:   gear.Retired,
+		}); err != nil {
+			logger().Warn("Failed to store gear", "athlete_id", athleteID, "gear_id", gearID, "error", err)
+			continue
+		}
+		logger().Info("Stored gear", "athlete_id", athleteID, "gear_id", gearID)
+		stored++
+	}
+	return stored
+}
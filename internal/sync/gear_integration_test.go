@@ -0,0 +1,62 @@
+//go:build integration
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func TestResolveNewGearStoresUnseenGearOnce(t *testing.T) {
+	ctx, conn := connectIntegrationDB(t)
+	const athleteID = int64(990000787)
+	cleanup := func() {
+		_, _ = conn.Exec(context.Background(), `DELETE FROM activity_summaries WHERE athlete_id = $1`, athleteID)
+		_, _ = conn.Exec(context.Background(), `DELETE FROM gear WHERE athlete_id = $1`, athleteID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	for i, gearID := range []string{"b990000790", "b990000790", "b990000791"} {
+		activity := &strava.ActivitySummary{
+			ID: 990000787000 + int64(i), AthleteID: athleteID, Name: "Ride", Type: "Ride", SportType: "Ride",
+			StartDate: "2024-05-02T07:00:00Z", Distance: 10_000, GearID: gearID,
+		}
+		if err := pggeo.InsertActivitySummary(ctx, conn, activity); err != nil {
+			t.Fatalf("InsertActivitySummary: %v", err)
+		}
+	}
+
+	var fetched []string
+	config := SyncConfig{
+		StravaAccessToken: "token",
+		FetchGear: func(_ context.Context, accessToken, gearID string) (*strava.Gear, error) {
+			fetched = append(fetched, gearID)
+			if gearID == "b990000791" {
+				return nil, errors.New("status 404")
+			}
+			return &strava.Gear{ID: gearID, Name: " Gravel ", BrandName: "Canyon", ModelName: "Grizl"}, nil
+		},
+	}
+	if stored := resolveNewGear(ctx, pgStore{conn: conn}, config, athleteID); stored != 1 {
+		t.Fatalf("resolveNewGear stored %d, want 1", stored)
+	}
+	if len(fetched) != 2 {
+		t.Fatalf("fetched %v, want each gear once", fetched)
+	}
+
+	// Stored gear is not fetched again; the failed one is retried
+	fetched = nil
+	resolveNewGear(ctx, pgStore{conn: conn}, config, athleteID)
+	if len(fetched) != 1 || fetched[0] != "b990000791" {
+		t.Fatalf("second sync fetched %v, want only the failed gear", fetched)
+	}
+	stats, err := pggeo.GetGearStats(ctx, conn, athleteID)
+	if err != nil || len(stats) != 2 || stats[0].Name != "Gravel" || stats[0].Model != "Grizl" || stats[0].Activities != 2 {
+		t.Fatalf("GetGearStats = %+v, %v", stats, err)
+	}
+}
@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+	"b11k/internal/trackimport"
+)
+
+// ImportMode decides what a changed file becomes: one whose name was imported before but
+// whose content hashes differently
+type ImportMode string
+
+const (
+	// ImportModeNew imports a changed file as a new activity
+	ImportModeNew ImportMode = "new"
+	// ImportModeUpdate replaces the activity of the file's latest earlier version
+	ImportModeUpdate ImportMode = "update"
+)
+
+// ParseImportMode parses "new" or "update"; empty means new
+func ParseImportMode(value string) (ImportMode, error) {
+	switch ImportMode(value) {
+	case "", ImportModeNew:
+		return ImportModeNew, nil
+	case ImportModeUpdate:
+		return ImportModeUpdate, nil
+	}
+	return "", fmt.Errorf("invalid import mode %q, want %q or %q", value, ImportModeNew, ImportModeUpdate)
+}
+
+// Outcomes of storing an imported file
+const (
+	FileImported        = "imported"
+	FileUpdated         = "updated"
+	FileAlreadyImported = "already_imported"
+)
+
+// FileImportResult is what StoreImportedFile did with one file. Activity is nil for a
+// file that was already imported; ActivityID then links the earlier import.
+type FileImportResult struct {
+	Status     string
+	ActivityID int64
+	Activity   *strava.BikeActivity
+}
+
+// StoreImportedFile stores a parsed GPX or TCX file as an activity of the athlete and
+// records its content hash. A file whose hash is already recorded is not stored again. A
+// changed file with the name of an earlier import becomes a new activity or replaces that
+// import's activity, depending on mode. GPS spikes are checked, and healed when
+// healSpikes is set, as for synced activities.
+func StoreImportedFile(ctx context.Context, conn pggeo.DB, athleteID int64, filename, hash string, track *trackimport.Track, mode ImportMode, healSpikes bool) (*FileImportResult, error) {
+	known, err := pggeo.GetImportFileByHash(ctx, conn, athleteID, hash)
+	if err != nil {
+		return nil, err
+	}
+	if known != nil {
+		return &FileImportResult{Status: FileAlreadyImported, ActivityID: known.ActivityID}, nil
+	}
+
+	result := &FileImportResult{Status: FileImported}
+	if mode == ImportModeUpdate {
+		earlier, err := pggeo.GetLatestImportFileByName(ctx, conn, athleteID, filename)
+		if err != nil {
+			return nil, err
+		}
+		if earlier != nil {
+			result.Status = FileUpdated
+			result.ActivityID = earlier.ActivityID
+		}
+	}
+	if result.ActivityID == 0 {
+		if result.ActivityID, err = pggeo.NextImportedActivityID(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	activity := track.Activity(athleteID, result.ActivityID)
+	spikes := analysis.CheckActivitySpikes(activity, healSpikes)
+	if err := pggeo.InsertImportedActivity(ctx, conn, activity); err != nil {
+		return nil, err
+	}
+	if err := RecordGPSSpikes(ctx, conn, result.ActivityID, spikes); err != nil {
+		logger().Warn("Failed to record GPS spikes", "activity_id", result.ActivityID, "error", err)
+	}
+	if err := pggeo.RecordImportFile(ctx, conn, pggeo.ImportFile{
+		AthleteID:  athleteID,
+		Hash:       hash,
+		ActivityID: result.ActivityID,
+		Filename:   filename,
+	}); err != nil {
+		return nil, err
+	}
+	result.Activity = activity
+	return result, nil
+}
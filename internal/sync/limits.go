@@ -0,0 +1,33 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+
+	"b11k/internal/pggeo"
+)
+
+// checkEnforcedLimits returns an ErrLimitsExceeded error when limits are enforced and
+// the database or the athlete is past one of them. Usage that cannot be measured is
+// logged and lets the sync go on, as the periodic check will warn about it too.
+func checkEnforcedLimits(ctx context.Context, db store, limits pggeo.SoftLimits, athleteID int64) error {
+	if !limits.Enforce || !limits.Enabled() {
+		return nil
+	}
+	usage, err := db.GetInstanceUsage(ctx, athleteID)
+	if err != nil {
+		logger().Warn("Failed to check the soft limits, syncing anyway", "athlete_id", athleteID, "error", err)
+		return nil
+	}
+	return limitsError(limits, *usage)
+}
+
+// limitsError describes the limits usage is past, or returns nil when there are none
+func limitsError(limits pggeo.SoftLimits, usage pggeo.InstanceUsage) error {
+	violations := limits.Check(usage)
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w, not fetching new activities: %s (%s)", ErrLimitsExceeded,
+		pggeo.DescribeLimitViolations(violations), violations[0].Remediation)
+}
@@ -0,0 +1,21 @@
+package sync
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// packageLogger is where the package logs; nil until SetLogger is called
+var packageLogger atomic.Pointer[slog.Logger]
+
+// SetLogger makes the package log to l; nil goes back to slog.Default
+func SetLogger(l *slog.Logger) {
+	packageLogger.Store(l)
+}
+
+func logger() *slog.Logger {
+	if l := packageLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// Sync phases reported in SyncResult.PhaseTimings
+const (
+	PhaseConnect    = "connect"    // database connection
+	PhaseAthlete    = "athlete"    // current athlete lookup
+	PhaseListing    = "listing"    // paging through the Strava activity list
+	PhaseExisting   = "existing"   // checking which activities are already stored
+	PhaseDetails    = "details"    // detail and stream requests, including stream parsing
+	PhaseSaving     = "saving"     // database writes, accumulated over activities
+	PhaseSegments   = "segments"   // matching saved activities to cached segments
+	PhaseExplored   = "explored"   // measuring new roads, see pggeo.ComputeNewDistances
+	PhaseDiscovered = "discovered" // discovered map coverage rebuild
+	PhaseRetries    = "retries"    // re-fetching and saving failed activities
+)
+
+// PhaseTiming is the time a sync spent in one phase
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// phaseClock accumulates time per phase in first-started order. It is safe for concurrent
+// use, so workers timing items of the same phase add up correctly.
+type phaseClock struct {
+	mu     gosync.Mutex
+	order  []string
+	totals map[string]time.Duration
+}
+
+// start begins timing phase and returns the function that stops it:
+//
+//	defer clock.start(PhaseListing)()
+func (c *phaseClock) start(phase string) func() {
+	started := time.Now()
+	return func() { c.add(phase, time.Since(started)) }
+}
+
+func (c *phaseClock) add(phase string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.totals == nil {
+		c.totals = make(map[string]time.Duration)
+	}
+	if _, ok := c.totals[phase]; !ok {
+		c.order = append(c.order, phase)
+	}
+	c.totals[phase] += d
+}
+
+func (c *phaseClock) timings() []PhaseTiming {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timings := make([]PhaseTiming, 0, len(c.order))
+	for _, phase := range c.order {
+		timings = append(timings, PhaseTiming{Phase: phase, Duration: c.totals[phase]})
+	}
+	return timings
+}
+
+// FormatPhaseTimings renders timings as one line, e.g. "listing 4s, details 3m30s, saving 38s"
+func FormatPhaseTimings(timings []PhaseTiming) string {
+	parts := make([]string, 0, len(timings))
+	for _, timing := range timings {
+		d := timing.Duration.Round(time.Millisecond)
+		if d >= time.Second {
+			d = d.Round(time.Second)
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", timing.Phase, d))
+	}
+	return strings.Join(parts, ", ")
+}
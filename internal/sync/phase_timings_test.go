@@ -0,0 +1,70 @@
+package sync
+
+import (
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestPhaseClockSumsToTotal(t *testing.T) {
+	var clock phaseClock
+	started := time.Now()
+	// Fake phases in sync order; saving runs once per activity and accumulates
+	for _, phase := range []string{PhaseConnect, PhaseListing, PhaseDetails, PhaseSaving, PhaseSaving, PhaseSaving} {
+		stop := clock.start(phase)
+		time.Sleep(5 * time.Millisecond)
+		stop()
+	}
+	total := time.Since(started)
+
+	timings := clock.timings()
+	wantOrder := []string{PhaseConnect, PhaseListing, PhaseDetails, PhaseSaving}
+	if len(timings) != len(wantOrder) {
+		t.Fatalf("timings = %+v, want phases %v", timings, wantOrder)
+	}
+	var sum time.Duration
+	for i, timing := range timings {
+		if timing.Phase != wantOrder[i] {
+			t.Fatalf("phase %d = %s, want %s", i, timing.Phase, wantOrder[i])
+		}
+		sum += timing.Duration
+	}
+	if sum > total || total-sum > total/10 {
+		t.Fatalf("phases sum to %s, total %s", sum, total)
+	}
+	if saving := timings[3].Duration; saving < 15*time.Millisecond {
+		t.Fatalf("saving = %s, want the three saves accumulated", saving)
+	}
+}
+
+func TestPhaseClockAggregatesConcurrentWorkers(t *testing.T) {
+	var clock phaseClock
+	const workers, items = 4, 5
+	var wg gosync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < items; i++ {
+				clock.add(PhaseDetails, time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	timings := clock.timings()
+	if len(timings) != 1 || timings[0].Duration != workers*items*time.Millisecond {
+		t.Fatalf("timings = %+v, want %s of details", timings, workers*items*time.Millisecond)
+	}
+}
+
+func TestFormatPhaseTimings(t *testing.T) {
+	got := FormatPhaseTimings([]PhaseTiming{
+		{Phase: PhaseListing, Duration: 4200 * time.Millisecond},
+		{Phase: PhaseDetails, Duration: 210 * time.Second},
+		{Phase: PhaseExisting, Duration: 12345 * time.Microsecond},
+	})
+	if want := "listing 4s, details 3m30s, existing 12ms"; got != want {
+		t.Fatalf("FormatPhaseTimings = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+
+	"b11k/internal/pggeo"
+)
+
+// matchSavedActivitiesToSegments adds the activities a sync saved to the match caches of
+// the athlete's segments at the athlete's default tolerance, reporting a
+// "matching_segments" phase. A failure is recorded in result.Errors but does not fail the
+// sync: the activities are then matched on the segments' next page visit.
+func matchSavedActivitiesToSegments(ctx context.Context, db store, athleteID int64, activityIDs []int64, result *SyncResult, progressCallback ProgressCallback) {
+	if len(activityIDs) == 0 || ctx.Err() != nil {
+		return
+	}
+	settings, err := db.GetAthleteSettings(ctx, athleteID)
+	if err != nil {
+		logger().Warn("Failed to load athlete settings, matching segments at their own or the default tolerance", "athlete_id", athleteID, "error", err)
+		settings = &pggeo.AthleteSettings{AthleteID: athleteID}
+	}
+	if progressCallback != nil {
+		progressCallback("matching_segments", 0, 0, fmt.Sprintf("Matching %d new activities to segments...", len(activityIDs)))
+	}
+	logger().Debug("Matching new activities to segments", "athlete_id", athleteID, "activities", len(activityIDs))
+	matched, err := db.MatchActivitiesToSegments(ctx, athleteID, activityIDs, settings.DefaultToleranceM, func(done, total int) {
+		if progressCallback != nil {
+			progressCallback("matching_segments", done, total, fmt.Sprintf("Matched segment %d/%d", done, total))
+		}
+	})
+	result.SegmentMatches += matched
+	if err != nil {
+		logger().Warn("Failed to match new activities to segments", "athlete_id", athleteID, "error", err)
+		result.Errors = append(result.Errors, fmt.Errorf("failed to match new activities to segments: %w", err))
+		return
+	}
+	logger().Info("Cached segment matches of the new activities", "athlete_id", athleteID, "matches", matched)
+}
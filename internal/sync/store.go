@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"context"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// store is the database as a sync uses it. pgStore runs each call on a connection;
+// unit tests replace openStore with a fake to test the orchestration without Postgres.
+type store interface {
+	UpsertAthlete(ctx context.Context, athlete *strava.Athlete) error
+	GetLatestActivityStartDate(ctx context.Context, athleteID int64) (*time.Time, error)
+	ActivitiesExist(ctx context.Context, activityIDs []int64) (map[int64]bool, error)
+	GetSkippedActivityIDs(ctx context.Context, athleteID int64) (map[int64]bool, error)
+	MarkActivitySkipped(ctx context.Context, athleteID, activityID int64, reason string) error
+	GetInstanceUsage(ctx context.Context, athleteID int64) (*pggeo.InstanceUsage, error)
+	// SaveActivity stores a fetched activity, see the package function of that name
+	SaveActivity(ctx context.Context, activity *strava.BikeActivity, healSpikes bool) error
+	AddActivityTags(ctx context.Context, athleteID, activityID int64, tags []string) error
+	GetUnresolvedGearIDs(ctx context.Context, athleteID int64, limit int) ([]string, error)
+	UpsertGear(ctx context.Context, gear pggeo.Gear) error
+	GetAthleteSettings(ctx context.Context, athleteID int64) (*pggeo.AthleteSettings, error)
+	MatchActivitiesToSegments(ctx context.Context, athleteID int64, activityIDs []int64, athleteDefaultToleranceM *float64, progress func(done, total int)) (int, error)
+	ComputeNewDistances(ctx context.Context, athleteID int64, progress func(done, total int)) (int, error)
+	RebuildDiscoveredCoverage(ctx context.Context, athleteID int64, sampleDistanceMeters, radiusMeters float64) error
+	Close(ctx context.Context) error
+}
+
+// openStore connects a sync to the database. Tests replace it to fake the database.
+var openStore = func(ctx context.Context, cfg DatabaseConfig) (store, error) {
+	conn, err := pggeo.Connect(ctx, cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	return pgStore{conn: conn}, nil
+}
+
+// pgStore is the store of a database connection
+type pgStore struct {
+	conn *pgx.Conn
+}
+
+func (s pgStore) UpsertAthlete(ctx context.Context, athlete *strava.Athlete) error {
+	return pggeo.UpsertAthlete(ctx, s.conn, athlete)
+}
+
+func (s pgStore) GetLatestActivityStartDate(ctx context.Context, athleteID int64) (*time.Time, error) {
+	return pggeo.GetLatestActivityStartDate(ctx, s.conn, athleteID)
+}
+
+func (s pgStore) ActivitiesExist(ctx context.Context, activityIDs []int64) (map[int64]bool, error) {
+	return pggeo.ActivitiesExistWithLogging(ctx, s.conn, activityIDs)
+}
+
+func (s pgStore) GetSkippedActivityIDs(ctx context.Context, athleteID int64) (map[int64]bool, error) {
+	return pggeo.GetSkippedActivityIDs(ctx, s.conn, athleteID)
+}
+
+func (s pgStore) MarkActivitySkipped(ctx context.Context, athleteID, activityID int64, reason string) error {
+	return pggeo.MarkActivitySkipped(ctx, s.conn, athleteID, activityID, reason)
+}
+
+func (s pgStore) GetInstanceUsage(ctx context.Context, athleteID int64) (*pggeo.InstanceUsage, error) {
+	return pggeo.GetInstanceUsage(ctx, s.conn, athleteID)
+}
+
+func (s pgStore) SaveActivity(ctx context.Context, activity *strava.BikeActivity, healSpikes bool) error {
+	return SaveActivity(ctx, s.conn, activity, healSpikes)
+}
+
+func (s pgStore) AddActivityTags(ctx context.Context, athleteID, activityID int64, tags []string) error {
+	return pggeo.AddActivityTags(ctx, s.conn, athleteID, activityID, tags...)
+}
+
+func (s pgStore) GetUnresolvedGearIDs(ctx context.Context, athleteID int64, limit int) ([]string, error) {
+	return pggeo.GetUnresolvedGearIDs(ctx, s.conn, athleteID, limit)
+}
+
+func (s pgStore) UpsertGear(ctx context.Context, gear pggeo.Gear) error {
+	return pggeo.UpsertGear(ctx, s.conn, gear)
+}
+
+func (s pgStore) GetAthleteSettings(ctx context.Context, athleteID int64) (*pggeo.AthleteSettings, error) {
+	return pggeo.GetAthleteSettings(ctx, s.conn, athleteID)
+}
+
+func (s pgStore) MatchActivitiesToSegments(ctx context.Context, athleteID int64, activityIDs []int64, athleteDefaultToleranceM *float64, progress func(done, total int)) (int, error) {
+	return pggeo.MatchActivitiesToSegments(ctx, s.conn, athleteID, activityIDs, athleteDefaultToleranceM, progress)
+}
+
+func (s pgStore) ComputeNewDistances(ctx context.Context, athleteID int64, progress func(done, total int)) (int, error) {
+	return pggeo.ComputeNewDistances(ctx, s.conn, athleteID, progress)
+}
+
+func (s pgStore) RebuildDiscoveredCoverage(ctx context.Context, athleteID int64, sampleDistanceMeters, radiusMeters float64) error {
+	_, err := pggeo.RebuildDiscoveredCoverage(ctx, s.conn, athleteID, sampleDistanceMeters, radiusMeters)
+	return err
+}
+
+func (s pgStore) Close(ctx context.Context) error {
+	return s.conn.Close(ctx)
+}
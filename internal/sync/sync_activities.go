@@ -2,10 +2,16 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
+	"b11k/internal/analysis"
+	"b11k/internal/metrics"
 	"b11k/internal/pggeo"
 	"b11k/internal/strava"
 )
@@ -13,9 +19,74 @@ import (
 // SyncConfig holds configuration for the sync process
 type SyncConfig struct {
 	StravaAccessToken string
-	DatabaseConfig    DatabaseConfig
-	Timeframe         TimeframeConfig
-	DiscoveredMap     DiscoveredMapConfig
+	// AccessTokenProvider, when set, is asked for a current access token before each
+	// Strava call so a long sync survives the access token expiring midway.
+	AccessTokenProvider func() (string, error)
+	DatabaseConfig      DatabaseConfig
+	Timeframe           TimeframeConfig
+	DiscoveredMap       DiscoveredMapConfig
+	// ActivityTypes limits the sync to these Strava types or sport types (e.g. Ride,
+	// VirtualRide, GravelRide); empty syncs every activity
+	ActivityTypes []string
+	// Incremental replaces the timeframe with everything since the newest stored Strava
+	// activity minus IncrementalSyncOverlap. With nothing stored yet, Timeframe is used.
+	Incremental bool
+	// OnActivitySaved, when set, is called after each new activity is saved. It runs on
+	// the sync goroutine, so it must return quickly and not fail the sync.
+	OnActivitySaved func(activity *strava.BikeActivity)
+	// MaxNewActivities, when positive, caps how many new activities get their details
+	// fetched. The oldest are fetched first so the next incremental sync picks up the rest.
+	MaxNewActivities int
+	// HealGPSSpikes moves GPS teleport spikes back onto the route before saving; without
+	// it they are only counted, see SaveActivity
+	HealGPSSpikes bool
+	// Strava is the API the sync calls; nil uses strava.DefaultClient
+	Strava strava.Client
+	// FetchGear, when set, replaces Strava.FetchGear for gear seen for the first time
+	FetchGear func(ctx context.Context, accessToken, gearID string) (*strava.Gear, error)
+	// MatchSegments adds the saved activities to the match caches of the athlete's
+	// segments before the sync returns, see pggeo.MatchActivitiesToSegments
+	MatchSegments bool
+	// Limits, when Enforce is set, stop the sync before it fetches the details of new
+	// activities once the database or the athlete is past one of them
+	Limits pggeo.SoftLimits
+	// TagRules tag each new activity right after it is saved, see TagRule
+	TagRules []TagRule
+}
+
+// ErrLimitsExceeded is reported in SyncResult.Errors when enforced soft limits kept the
+// sync from fetching new activities
+var ErrLimitsExceeded = errors.New("soft limits exceeded")
+
+// IncrementalSyncOverlap is how far before the newest stored activity an incremental sync
+// starts, so activities uploaded late with an earlier start time are still picked up
+const IncrementalSyncOverlap = 24 * time.Hour
+
+// accessToken returns the token for the next Strava call, falling back to
+// StravaAccessToken when the provider fails.
+func (c SyncConfig) accessToken() string {
+	if c.AccessTokenProvider == nil {
+		return c.StravaAccessToken
+	}
+	token, err := c.AccessTokenProvider()
+	if err != nil || token == "" {
+		logger().Warn("Failed to get a fresh Strava access token, using the original", "error", err)
+		return c.StravaAccessToken
+	}
+	return token
+}
+
+func (c SyncConfig) stravaClient() strava.Client {
+	if c.Strava == nil {
+		return strava.DefaultClient
+	}
+	return c.Strava
+}
+
+func (c SyncConfig) activitySaved(activity *strava.BikeActivity) {
+	if c.OnActivitySaved != nil {
+		c.OnActivitySaved(activity)
+	}
 }
 
 type DiscoveredMapConfig struct {
@@ -41,17 +112,41 @@ type TimeframeConfig struct {
 
 // SyncResult holds the results of a sync operation
 type SyncResult struct {
+	AthleteID             int64
 	TotalActivitiesFound  int
 	ExistingActivities    int
 	NewActivities         int
 	SuccessfullyProcessed int
 	FailedActivities      []int64
-	ProcessingTime        time.Duration
-	Errors                []error
+	// SavedActivityIDs are the activities this sync added, including retries
+	SavedActivityIDs []int64
+	// GoneActivities were listed but answered 404 when fetching their details, i.e. they
+	// were deleted on Strava meanwhile. They are recorded in skipped_activities.
+	GoneActivities []int64
+	// SkippedActivities counts listed activities an earlier sync recorded as skipped;
+	// they are not fetched again
+	SkippedActivities int
+	// RejectedActivities were refused by the database for good, e.g. unusable streams or
+	// an ID stored for another athlete; they are not retried
+	RejectedActivities []int64
+	// DeferredActivities counts new activities left for a later sync by MaxNewActivities
+	DeferredActivities int
+	// NewGear counts gear items whose details this sync fetched for the first time
+	NewGear int
+	// SegmentMatches counts segment matches cached for the saved activities
+	SegmentMatches int
+	// ExploredActivities counts activities whose new distance was measured, see
+	// pggeo.ComputeNewDistances
+	ExploredActivities int
+	ProcessingTime     time.Duration
+	// PhaseTimings breaks ProcessingTime down by phase, in the order phases ran
+	PhaseTimings []PhaseTiming
+	Errors       []error
 }
 
 // ProgressCallback is called to report sync progress
-// phase: "fetching_activities", "fetching_details", "saving"
+// phase: "fetching_activities", "fetching_details", "waiting_rate_limit", "saving",
+// "matching_segments", "exploring", "discovered"
 // current: current item being processed
 // total: total items to process
 // message: optional message describing current operation
@@ -63,48 +158,85 @@ type ProgressCallback func(phase string, current, total int, message string)
 // 3. Fetches details and streams for new activities
 // 4. Saves new activities to the database
 // 5. Logs all major steps and errors
-// If progressCallback is provided, it will be called to report progress
+// If progressCallback is provided, it will be called to report progress. The run's
+// duration and whether it failed are recorded in the sync metrics.
 func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCallback ProgressCallback) (*SyncResult, error) {
+	started := time.Now()
+	result, err := syncActivities(ctx, config, progressCallback)
+	metrics.SyncFinished(time.Since(started), err != nil && !errors.Is(err, context.Canceled))
+	return result, err
+}
+
+func syncActivities(ctx context.Context, config SyncConfig, progressCallback ProgressCallback) (*SyncResult, error) {
 	startTime := time.Now()
-	log.Printf("🚀 Starting Strava activity sync process")
-	log.Printf("📅 Timeframe: %s to %s",
-		config.Timeframe.StartTime.Format("2006-01-02 15:04:05"),
-		config.Timeframe.EndTime.Format("2006-01-02 15:04:05"))
+	ctx = withRateLimitProgress(ctx, progressCallback)
+	logger().Info("Starting Strava activity sync",
+		"from", config.Timeframe.StartTime.Format(time.RFC3339),
+		"to", config.Timeframe.EndTime.Format(time.RFC3339))
 
 	result := &SyncResult{
-		FailedActivities: make([]int64, 0),
-		Errors:           make([]error, 0),
+		FailedActivities:   make([]int64, 0),
+		GoneActivities:     make([]int64, 0),
+		RejectedActivities: make([]int64, 0),
+		Errors:             make([]error, 0),
 	}
+	var clock phaseClock
+	defer func() {
+		result.PhaseTimings = clock.timings()
+		logger().Info("Sync phases", "athlete_id", result.AthleteID, "phases", FormatPhaseTimings(result.PhaseTimings))
+	}()
 
 	// Step 1: Connect to database
-	log.Printf("🔌 Connecting to database...")
-	conn, err := pggeo.Connect(ctx, config.DatabaseConfig.User, config.DatabaseConfig.Password,
-		config.DatabaseConfig.Host, config.DatabaseConfig.Port, config.DatabaseConfig.Database)
+	stop := clock.start(PhaseConnect)
+	db, err := openStore(ctx, config.DatabaseConfig)
+	stop()
 	if err != nil {
-		log.Printf("❌ Failed to connect to database: %v", err)
+		logger().Error("Failed to connect to database", "error", err)
 		return result, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	defer conn.Close(ctx)
-	log.Printf("✅ Successfully connected to database")
+	defer db.Close(ctx)
 
 	// Step 2: Get current athlete info
-	log.Printf("👤 Fetching current athlete info...")
-	athlete, err := strava.FetchCurrentAthlete(config.StravaAccessToken)
+	stop = clock.start(PhaseAthlete)
+	athlete, err := config.stravaClient().FetchCurrentAthlete(ctx, config.accessToken())
+	stop()
 	if err != nil {
-		log.Printf("❌ Failed to fetch athlete info: %v", err)
+		logger().Error("Failed to fetch athlete info", "error", err)
 		return result, fmt.Errorf("failed to fetch athlete info: %w", err)
 	}
-	log.Printf("✅ Found athlete: %s %s (ID: %d)", athlete.FirstName, athlete.LastName, athlete.ID)
+	logger().Info("Syncing athlete", "athlete_id", athlete.ID)
+	result.AthleteID = athlete.ID
+	if err := db.UpsertAthlete(ctx, athlete); err != nil {
+		logger().Warn("Failed to store athlete", "athlete_id", athlete.ID, "error", err)
+	}
+
+	if config.Incremental {
+		stop = clock.start(PhaseExisting)
+		latest, err := db.GetLatestActivityStartDate(ctx, athlete.ID)
+		stop()
+		if err != nil {
+			logger().Error("Failed to find the newest stored activity", "athlete_id", athlete.ID, "error", err)
+			return result, err
+		}
+		if latest != nil {
+			config.Timeframe = TimeframeConfig{StartTime: latest.Add(-IncrementalSyncOverlap)}
+			logger().Info("Incremental sync", "athlete_id", athlete.ID,
+				"after", config.Timeframe.StartTime.Format(time.RFC3339), "newest_stored", latest.Format(time.RFC3339))
+		} else {
+			logger().Info("No stored activities yet, incremental sync uses the requested timeframe", "athlete_id", athlete.ID)
+		}
+	}
 
 	// Step 3: Fetch activities from Strava
 	if progressCallback != nil {
 		progressCallback("fetching_activities", 0, 0, "Fetching activities from Strava...")
 	}
-	log.Printf("📡 Fetching activities from Strava...")
-	bikeActivities, err := strava.FetchBikeActivities(config.StravaAccessToken,
-		config.Timeframe.StartTime, config.Timeframe.EndTime)
+	stop = clock.start(PhaseListing)
+	bikeActivities, err := config.stravaClient().FetchActivities(ctx, config.accessToken(),
+		config.Timeframe.StartTime, config.Timeframe.EndTime, config.ActivityTypes)
+	stop()
 	if err != nil {
-		log.Printf("❌ Failed to fetch activities from Strava: %v", err)
+		logger().Error("Failed to fetch activities from Strava", "athlete_id", athlete.ID, "error", err)
 		result.Errors = append(result.Errors, fmt.Errorf("failed to fetch activities: %w", err))
 		return result, fmt.Errorf("failed to fetch activities from Strava: %w", err)
 	}
@@ -120,31 +252,37 @@ func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCa
 		progressCallback("fetching_activities", len(bikeActivities), len(bikeActivities), fmt.Sprintf("Found %d activities", len(bikeActivities)))
 	}
 
+	logger().Info("Listed Strava activities", "athlete_id", athlete.ID, "activities", len(bikeActivities))
 	if len(bikeActivities) == 0 {
-		log.Printf("ℹ️ No bike activities found in the specified timeframe")
 		result.ProcessingTime = time.Since(startTime)
 		return result, nil
-	} else {
-		log.Printf("✅ Found %d bike activities from Strava", len(bikeActivities))
 	}
 
 	for _, activity := range bikeActivities {
-		log.Printf("Activity: %s", activity.ToString())
+		logger().Debug("Listed activity", "athlete_id", athlete.ID, "activity", activity.ToString())
 	}
 
 	// Step 4: Check which activities already exist in database
-	log.Printf("🔍 Checking which activities already exist in database...")
 	activityIDs := make([]int64, len(bikeActivities))
 	for i, activity := range bikeActivities {
 		activityIDs[i] = activity.ID
 	}
 
-	existsMap, err := pggeo.ActivitiesExistWithLogging(ctx, conn, activityIDs)
+	stop = clock.start(PhaseExisting)
+	existsMap, err := db.ActivitiesExist(ctx, activityIDs)
 	if err != nil {
-		log.Printf("❌ Failed to check existing activities: %v", err)
+		stop()
+		logger().Error("Failed to check existing activities", "athlete_id", athlete.ID, "error", err)
 		result.Errors = append(result.Errors, fmt.Errorf("failed to check existing activities: %w", err))
 		return result, fmt.Errorf("failed to check existing activities: %w", err)
 	}
+	skipped, err := db.GetSkippedActivityIDs(ctx, athlete.ID)
+	stop()
+	if err != nil {
+		logger().Error("Failed to load skipped activities", "athlete_id", athlete.ID, "error", err)
+		result.Errors = append(result.Errors, fmt.Errorf("failed to load skipped activities: %w", err))
+		return result, fmt.Errorf("failed to load skipped activities: %w", err)
+	}
 
 	// Count existing and new activities
 	var newActivities strava.ActivitySummaryList
@@ -153,14 +291,25 @@ func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCa
 			result.ExistingActivities++
 		} else {
 			newActivities = append(newActivities, activity)
-			result.NewActivities++
 		}
 	}
+	newActivities, result.SkippedActivities = withoutSkippedActivities(newActivities, skipped)
+	result.NewActivities = len(newActivities)
+	newActivities, result.DeferredActivities = oldestActivities(newActivities, config.MaxNewActivities)
 
-	log.Printf("📊 Activity status: %d existing, %d new", result.ExistingActivities, result.NewActivities)
+	logger().Info("Compared with stored activities", "athlete_id", athlete.ID,
+		"existing", result.ExistingActivities, "new", result.NewActivities,
+		"skipped", result.SkippedActivities, "deferred", result.DeferredActivities)
 
 	if len(newActivities) == 0 {
-		log.Printf("ℹ️ All activities already exist in database")
+		result.ProcessingTime = time.Since(startTime)
+		return result, nil
+	}
+
+	if err := checkEnforcedLimits(ctx, db, config.Limits, athlete.ID); err != nil {
+		logger().Warn("Not fetching new activities", "athlete_id", athlete.ID, "activities", len(newActivities), "error", err)
+		result.Errors = append(result.Errors, err)
+		result.DeferredActivities += len(newActivities)
 		result.ProcessingTime = time.Since(startTime)
 		return result, nil
 	}
@@ -169,12 +318,19 @@ func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCa
 	if progressCallback != nil {
 		progressCallback("fetching_details", 0, len(newActivities), fmt.Sprintf("Fetching details for %d activities...", len(newActivities)))
 	}
-	log.Printf("📋 Fetching detailed information for %d new activities...", len(newActivities))
 
 	// Fetch detailed activities with progress tracking
-	detailedActivities, err := fetchDetailedActivitiesWithProgress(newActivities, config.StravaAccessToken, progressCallback)
+	detailedActivities, gone, err := fetchDetailedActivitiesWithProgress(ctx, newActivities, config, progressCallback, &clock)
+	result.GoneActivities = append(result.GoneActivities, gone...)
+	markGoneActivities(ctx, db, athlete.ID, gone, result)
+	if ctx.Err() != nil {
+		// Cancelled: nothing more can be written with this context
+		logger().Info("Sync cancelled while fetching activities", "athlete_id", athlete.ID, "fetched", len(detailedActivities), "activities", len(newActivities))
+		result.ProcessingTime = time.Since(startTime)
+		return result, ctx.Err()
+	}
 	if err != nil {
-		log.Printf("❌ Failed to fetch detailed activities: %v", err)
+		logger().Error("Failed to fetch detailed activities", "athlete_id", athlete.ID, "error", err)
 		result.Errors = append(result.Errors, fmt.Errorf("failed to fetch detailed activities: %w", err))
 		// Continue with partial results if some activities were fetched successfully
 	}
@@ -187,15 +343,29 @@ func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCa
 	if progressCallback != nil {
 		progressCallback("saving", 0, len(detailedActivities), fmt.Sprintf("Saving %d activities to database...", len(detailedActivities)))
 	}
-	log.Printf("💾 Saving %d new activities to database...", len(detailedActivities))
 	for i, detailedActivity := range detailedActivities {
+		if ctx.Err() != nil {
+			logger().Info("Sync cancelled while saving activities", "athlete_id", athlete.ID, "saved", result.SuccessfullyProcessed, "activities", len(detailedActivities))
+			result.ProcessingTime = time.Since(startTime)
+			return result, ctx.Err()
+		}
 		activityID := detailedActivity.Summary.ID
 		activityName := detailedActivity.Summary.Name
-		log.Printf("💾 Saving activity %d/%d: %d (%s)", i+1, len(detailedActivities), activityID, activityName)
+		logger().Debug("Saving activity", "athlete_id", athlete.ID, "activity_id", activityID, "index", i+1, "activities", len(detailedActivities))
 
-		if err := pggeo.InsertBikeActivityWithLogging(ctx, conn, &detailedActivity); err != nil {
-			log.Printf("❌ Failed to save activity %d: %v", activityID, err)
-			result.FailedActivities = append(result.FailedActivities, activityID)
+		// A started save runs to the end even if the sync is cancelled meanwhile, so a
+		// shutdown never leaves an activity half written
+		stop = clock.start(PhaseSaving)
+		err := db.SaveActivity(context.WithoutCancel(ctx), &detailedActivity, config.HealGPSSpikes)
+		stop()
+		if err != nil {
+			logger().Error("Failed to save activity", "athlete_id", athlete.ID, "activity_id", activityID, "error", err)
+			countSaveFailure(err)
+			if saveRetryable(err) {
+				result.FailedActivities = append(result.FailedActivities, activityID)
+			} else {
+				result.RejectedActivities = append(result.RejectedActivities, activityID)
+			}
 			result.Errors = append(result.Errors, fmt.Errorf("failed to save activity %d: %w", activityID, err))
 			if progressCallback != nil {
 				progressCallback("saving", i+1, len(detailedActivities), fmt.Sprintf("Failed to save: %s", activityName))
@@ -203,39 +373,67 @@ func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCa
 			continue
 		}
 
+		tagSavedActivity(context.WithoutCancel(ctx), db, config.TagRules, &detailedActivity)
 		result.SuccessfullyProcessed++
-		log.Printf("✅ Successfully saved activity %d", activityID)
+		result.SavedActivityIDs = append(result.SavedActivityIDs, activityID)
+		metrics.SyncActivities(metrics.ResultSaved, 1)
+		logger().Debug("Saved activity", "athlete_id", athlete.ID, "activity_id", activityID)
+		config.activitySaved(&detailedActivity)
 		if progressCallback != nil {
 			progressCallback("saving", i+1, len(detailedActivities), fmt.Sprintf("Saved: %s", activityName))
 		}
 	}
 
-	// Final summary
-	result.ProcessingTime = time.Since(startTime)
-	log.Printf("🎉 Sync process completed!")
-	log.Printf("📊 Final results:")
-	log.Printf("   - Total activities found: %d", result.TotalActivitiesFound)
-	log.Printf("   - Existing activities: %d", result.ExistingActivities)
-	log.Printf("   - New activities: %d", result.NewActivities)
-	log.Printf("   - Successfully processed: %d", result.SuccessfullyProcessed)
-	log.Printf("   - Failed activities: %d", len(result.FailedActivities))
-	log.Printf("   - Processing time: %v", result.ProcessingTime)
+	// Step 6: Fetch the details of gear no earlier sync has seen
+	if ctx.Err() == nil {
+		result.NewGear = resolveNewGear(ctx, db, config, athlete.ID)
+	}
 
-	if len(result.FailedActivities) > 0 {
-		log.Printf("❌ Failed activity IDs: %v", result.FailedActivities)
+	// Step 7: Add the saved activities to the segment match caches
+	if config.MatchSegments {
+		stop = clock.start(PhaseSegments)
+		matchSavedActivitiesToSegments(ctx, db, athlete.ID, result.SavedActivityIDs, result, progressCallback)
+		stop()
 	}
 
-	if len(result.Errors) > 0 {
-		log.Printf("⚠️ Total errors encountered: %d", len(result.Errors))
+	// Step 8: Measure the new roads of the saved activities and any measured by no sync yet
+	stop = clock.start(PhaseExplored)
+	measureNewRoads(ctx, db, athlete.ID, result, progressCallback)
+	stop()
+
+	// Final summary
+	result.ProcessingTime = time.Since(startTime)
+	level := slog.LevelInfo
+	if len(result.FailedActivities) > 0 || len(result.Errors) > 0 {
+		level = slog.LevelWarn
 	}
+	logger().Log(ctx, level, "Sync completed", "athlete_id", athlete.ID,
+		"found", result.TotalActivitiesFound,
+		"existing", result.ExistingActivities,
+		"new", result.NewActivities,
+		"saved", result.SuccessfullyProcessed,
+		"failed", len(result.FailedActivities),
+		"failed_ids", result.FailedActivities,
+		"gone", len(result.GoneActivities),
+		"rejected", len(result.RejectedActivities),
+		"skipped", result.SkippedActivities,
+		"new_gear", result.NewGear,
+		"segment_matches", result.SegmentMatches,
+		"explored", result.ExploredActivities,
+		"errors", len(result.Errors),
+		"duration", result.ProcessingTime)
 
 	if config.DiscoveredMap.Enabled && result.SuccessfullyProcessed > 0 {
 		if progressCallback != nil {
 			progressCallback("discovered", 0, 1, "Rebuilding discovered map coverage...")
 		}
-		log.Printf("🗺️ Rebuilding discovered map coverage for athlete %d", athlete.ID)
-		if _, err := pggeo.RebuildDiscoveredCoverage(ctx, conn, athlete.ID, config.DiscoveredMap.SampleDistanceMeters, config.DiscoveredMap.RevealRadiusMeters); err != nil {
-			log.Printf("⚠️ Failed to rebuild discovered map coverage: %v", err)
+		logger().Info("Rebuilding discovered map coverage", "athlete_id", athlete.ID)
+		stop = clock.start(PhaseDiscovered)
+		err := db.RebuildDiscoveredCoverage(ctx, athlete.ID, config.DiscoveredMap.SampleDistanceMeters, config.DiscoveredMap.RevealRadiusMeters)
+		stop()
+		result.ProcessingTime = time.Since(startTime)
+		if err != nil {
+			logger().Warn("Failed to rebuild discovered map coverage", "athlete_id", athlete.ID, "error", err)
 			result.Errors = append(result.Errors, fmt.Errorf("failed to rebuild discovered map coverage: %w", err))
 			if progressCallback != nil {
 				progressCallback("discovered", 1, 1, "Discovered map rebuild failed")
@@ -244,25 +442,136 @@ func SyncActivitiesFromStrava(ctx context.Context, config SyncConfig, progressCa
 			if progressCallback != nil {
 				progressCallback("discovered", 1, 1, "Discovered map coverage rebuilt")
 			}
-			log.Printf("✅ Discovered map coverage rebuilt")
+			logger().Info("Discovered map coverage rebuilt", "athlete_id", athlete.ID)
 		}
 	}
 
 	return result, nil
 }
 
-// fetchDetailedActivitiesWithProgress fetches detailed activities with progress tracking
-func fetchDetailedActivitiesWithProgress(activities strava.ActivitySummaryList, accessToken string, progressCallback ProgressCallback) (strava.BikeActivityList, error) {
+// withRateLimitProgress reports Strava rate limit waits as a "waiting_rate_limit" phase
+func withRateLimitProgress(ctx context.Context, progressCallback ProgressCallback) context.Context {
+	if progressCallback == nil {
+		return ctx
+	}
+	return strava.WithRateLimitNotifier(ctx, func(wait time.Duration, window strava.RateLimitWindow) {
+		progressCallback("waiting_rate_limit", 0, 0,
+			fmt.Sprintf("Waiting %s for Strava %s rate limit...", wait.Round(time.Second), window))
+	})
+}
+
+// withoutSkippedActivities drops the activities in skipped, returning how many it dropped
+func withoutSkippedActivities(activities strava.ActivitySummaryList, skipped map[int64]bool) (strava.ActivitySummaryList, int) {
+	if len(skipped) == 0 {
+		return activities, 0
+	}
+	kept := activities[:0:0]
+	for _, activity := range activities {
+		if !skipped[activity.ID] {
+			kept = append(kept, activity)
+		}
+	}
+	return kept, len(activities) - len(kept)
+}
+
+// SaveActivity stores a fetched activity after checking its route for GPS teleport
+// spikes. With healSpikes they are moved back onto the route first; otherwise the route
+// is stored as recorded and the spikes are only counted in the activity's GPS quality.
+func SaveActivity(ctx context.Context, conn pggeo.DB, activity *strava.BikeActivity, healSpikes bool) error {
+	report := analysis.CheckActivitySpikes(activity, healSpikes)
+	if err := pggeo.InsertBikeActivityWithLogging(ctx, conn, activity); err != nil {
+		return err
+	}
+	// The activity is saved; missing quality counts are not worth failing it for
+	if err := RecordGPSSpikes(ctx, conn, activity.Summary.ID, report); err != nil {
+		logger().Warn("Failed to record GPS spikes", "activity_id", activity.Summary.ID, "error", err)
+	}
+	return nil
+}
+
+// countSaveFailure counts an activity that failed to save as failed or, when another
+// attempt would fail the same way, rejected
+func countSaveFailure(err error) {
+	if saveRetryable(err) {
+		metrics.SyncActivities(metrics.ResultFailed, 1)
+	} else {
+		metrics.SyncActivities(metrics.ResultRejected, 1)
+	}
+}
+
+// saveRetryable reports whether saving an activity may succeed on another attempt.
+// Refused input and activities stored for another athlete fail the same way every time.
+func saveRetryable(err error) bool {
+	return !errors.Is(err, pggeo.ErrInvalidInput) && !errors.Is(err, pggeo.ErrForeignAthlete)
+}
+
+// RecordGPSSpikes stores what CheckActivitySpikes found for a just saved activity
+func RecordGPSSpikes(ctx context.Context, conn pggeo.DB, activityID int64, report analysis.SpikeReport) error {
+	quality := pggeo.GPSQuality{Spikes: len(report.Spikes)}
+	switch {
+	case report.Healed:
+		quality = pggeo.GPSQuality{Healed: len(report.Spikes)}
+		logger().Info("Healed GPS spikes", "activity_id", activityID, "spikes", len(report.Spikes), "removed_m", math.Round(report.ExtraMeters))
+	case len(report.Spikes) > 0:
+		logger().Info("Found GPS spikes", "activity_id", activityID, "spikes", len(report.Spikes))
+	}
+	return pggeo.SetActivityGPSSpikes(ctx, conn, activityID, quality)
+}
+
+// oldestActivities keeps the limit earliest-starting activities, returning how many it
+// left out. A limit of zero or less keeps everything.
+func oldestActivities(activities strava.ActivitySummaryList, limit int) (strava.ActivitySummaryList, int) {
+	if limit <= 0 || len(activities) <= limit {
+		return activities, 0
+	}
+	sorted := slices.Clone(activities)
+	// Strava start dates are UTC RFC 3339, so they sort as strings
+	slices.SortStableFunc(sorted, func(a, b strava.ActivitySummary) int {
+		return strings.Compare(a.StartDate, b.StartDate)
+	})
+	return sorted[:limit], len(activities) - limit
+}
+
+// markGoneActivities records activities deleted on Strava so later syncs skip them. A
+// failure is reported in result but does not fail the sync.
+func markGoneActivities(ctx context.Context, db store, athleteID int64, gone []int64, result *SyncResult) {
+	for _, activityID := range gone {
+		if err := db.MarkActivitySkipped(ctx, athleteID, activityID, pggeo.SkipReasonGoneFromStrava); err != nil {
+			logger().Warn("Failed to record activity as gone", "athlete_id", athleteID, "activity_id", activityID, "error", err)
+			result.Errors = append(result.Errors, err)
+		}
+	}
+}
+
+// fetchDetailedActivitiesWithProgress fetches detailed activities with progress tracking,
+// adding each fetch to the details phase of clock. It also returns the IDs Strava no
+// longer has; other failures are logged and retried by the next sync.
+func fetchDetailedActivitiesWithProgress(ctx context.Context, activities strava.ActivitySummaryList, config SyncConfig, progressCallback ProgressCallback, clock *phaseClock) (strava.BikeActivityList, []int64, error) {
 	var detailedActivities strava.BikeActivityList
+	var gone []int64
 	total := len(activities)
 
 	// Fetch activities one by one to track progress
 	for i, activity := range activities {
-		// Create a single-item list and fetch it
-		singleActivityList := strava.ActivitySummaryList{activity}
-		results, err := singleActivityList.GetDetailedActivities(accessToken)
+		stop := clock.start(PhaseDetails)
+		results, err := config.stravaClient().GetDetailedActivities(ctx, config.accessToken(), strava.ActivitySummaryList{activity})
+		stop()
+		if ctx.Err() != nil {
+			// Cancelled, possibly during a rate limit wait: keep what was fetched so far
+			return detailedActivities, gone, ctx.Err()
+		}
+		if errors.Is(err, strava.ErrActivityNotFound) {
+			logger().Info("Activity is gone from Strava, it will not be fetched again", "activity_id", activity.ID)
+			metrics.SyncActivities(metrics.ResultGone, 1)
+			gone = append(gone, activity.ID)
+			if progressCallback != nil {
+				progressCallback("fetching_details", i+1, total, fmt.Sprintf("Gone from Strava: %s", activity.Name))
+			}
+			continue
+		}
 		if err != nil {
-			log.Printf("⚠️ Failed to fetch details for activity %d: %v", activity.ID, err)
+			logger().Warn("Failed to fetch activity details", "activity_id", activity.ID, "error", err)
+			metrics.SyncActivities(metrics.ResultFailed, 1)
 			// Continue with next activity
 			if progressCallback != nil {
 				progressCallback("fetching_details", i+1, total, fmt.Sprintf("Failed: %s", activity.Name))
@@ -280,12 +589,17 @@ func fetchDetailedActivitiesWithProgress(activities strava.ActivitySummaryList,
 		}
 	}
 
-	return detailedActivities, nil
+	return detailedActivities, gone, nil
 }
 
+// retryBackoff is the wait after the first retry round, growing by itself each round;
+// tests shorten it
+var retryBackoff = time.Second
+
 // SyncActivitiesFromStravaWithRetry performs the sync with retry logic for failed activities
 func SyncActivitiesFromStravaWithRetry(ctx context.Context, config SyncConfig, maxRetries int, progressCallback ProgressCallback) (*SyncResult, error) {
-	log.Printf("🔄 Starting sync with retry logic (max retries: %d)", maxRetries)
+	logger().Debug("Starting sync with retries", "max_retries", maxRetries)
+	ctx = withRateLimitProgress(ctx, progressCallback)
 
 	// Initial sync
 	result, err := SyncActivitiesFromStrava(ctx, config, progressCallback)
@@ -299,73 +613,105 @@ func SyncActivitiesFromStravaWithRetry(ctx context.Context, config SyncConfig, m
 	}
 	successesBeforeRetry := result.SuccessfullyProcessed
 	var retryAthleteID int64
+	retryStarted := time.Now()
+	defer func() {
+		retried := time.Since(retryStarted)
+		result.PhaseTimings = append(result.PhaseTimings, PhaseTiming{Phase: PhaseRetries, Duration: retried})
+		result.ProcessingTime += retried
+		logger().Info("Sync phases with retries", "athlete_id", result.AthleteID, "phases", FormatPhaseTimings(result.PhaseTimings))
+	}()
 
 	// Retry failed activities
-	for attempt := 1; attempt <= maxRetries && len(result.FailedActivities) > 0; attempt++ {
-		log.Printf("🔄 Retry attempt %d for %d failed activities", attempt, len(result.FailedActivities))
+	for attempt := 1; attempt <= maxRetries && len(result.FailedActivities) > 0 && ctx.Err() == nil; attempt++ {
+		logger().Info("Retrying failed activities", "athlete_id", result.AthleteID, "attempt", attempt, "activities", len(result.FailedActivities))
 
 		// Get connection for retry
-		conn, err := pggeo.Connect(ctx, config.DatabaseConfig.User, config.DatabaseConfig.Password,
-			config.DatabaseConfig.Host, config.DatabaseConfig.Port, config.DatabaseConfig.Database)
+		db, err := openStore(ctx, config.DatabaseConfig)
 		if err != nil {
-			log.Printf("❌ Failed to connect to database for retry: %v", err)
+			logger().Error("Failed to connect to database for retry", "error", err)
 			break
 		}
 
 		// Process failed activities
-		var stillFailed []int64
+		var stillFailed, saved []int64
 		for _, activityID := range result.FailedActivities {
-			log.Printf("🔄 Retrying activity %d", activityID)
-
-			// Fetch single activity details using existing function
-			activities := strava.ActivitySummaryList{{ID: activityID}}
-			detailedActivities, err := activities.GetDetailedActivities(config.StravaAccessToken)
-			if err != nil || len(detailedActivities) == 0 {
-				log.Printf("❌ Retry failed for activity %d: %v", activityID, err)
+			// Fetch the activity on its own; its summary comes from the detailed activity
+			detailedActivity, err := config.stravaClient().FetchActivity(ctx, config.accessToken(), activityID)
+			if errors.Is(err, strava.ErrActivityNotFound) {
+				logger().Info("Activity is gone from Strava, not retrying", "activity_id", activityID)
+				metrics.SyncActivities(metrics.ResultGone, 1)
+				result.GoneActivities = append(result.GoneActivities, activityID)
+				markGoneActivities(ctx, db, result.AthleteID, []int64{activityID}, result)
+				continue
+			}
+			if err != nil {
+				logger().Error("Retry failed", "activity_id", activityID, "error", err)
+				metrics.SyncActivities(metrics.ResultFailed, 1)
 				stillFailed = append(stillFailed, activityID)
 				continue
 			}
 
 			// Save to database
-			if err := pggeo.InsertBikeActivityWithLogging(ctx, conn, &detailedActivities[0]); err != nil {
-				log.Printf("❌ Retry save failed for activity %d: %v", activityID, err)
-				stillFailed = append(stillFailed, activityID)
+			if err := db.SaveActivity(context.WithoutCancel(ctx), detailedActivity, config.HealGPSSpikes); err != nil {
+				logger().Error("Retry failed to save", "activity_id", activityID, "error", err)
+				countSaveFailure(err)
+				if saveRetryable(err) {
+					stillFailed = append(stillFailed, activityID)
+				} else {
+					result.RejectedActivities = append(result.RejectedActivities, activityID)
+				}
 				continue
 			}
 
-			log.Printf("✅ Retry successful for activity %d", activityID)
-			retryAthleteID = detailedActivities[0].Summary.AthleteID
+			tagSavedActivity(context.WithoutCancel(ctx), db, config.TagRules, detailedActivity)
+			logger().Info("Retry succeeded", "activity_id", activityID)
+			metrics.SyncActivities(metrics.ResultSaved, 1)
+			config.activitySaved(detailedActivity)
+			retryAthleteID = detailedActivity.Summary.AthleteID
 			result.SuccessfullyProcessed++
+			result.SavedActivityIDs = append(result.SavedActivityIDs, activityID)
+			saved = append(saved, activityID)
+		}
+		if config.MatchSegments {
+			matchSavedActivitiesToSegments(ctx, db, retryAthleteID, saved, result, progressCallback)
+		}
+		if len(saved) > 0 {
+			measureNewRoads(ctx, db, retryAthleteID, result, progressCallback)
 		}
 
-		if err := conn.Close(ctx); err != nil {
-			log.Printf("⚠️ Failed to close retry database connection: %v", err)
+		if err := db.Close(ctx); err != nil {
+			logger().Warn("Failed to close retry database connection", "error", err)
 		}
 		result.FailedActivities = stillFailed
 
 		if len(stillFailed) == 0 {
-			log.Printf("✅ All activities successfully processed after retry")
+			logger().Info("All activities saved after retrying", "athlete_id", result.AthleteID)
 			break
 		}
 
 		// Wait before next retry
 		if attempt < maxRetries {
-			time.Sleep(time.Duration(attempt) * time.Second)
+			select {
+			case <-time.After(time.Duration(attempt) * retryBackoff):
+			case <-ctx.Done():
+			}
 		}
 	}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
 
 	if config.DiscoveredMap.Enabled && result.SuccessfullyProcessed > successesBeforeRetry && retryAthleteID != 0 {
-		conn, err := pggeo.Connect(ctx, config.DatabaseConfig.User, config.DatabaseConfig.Password,
-			config.DatabaseConfig.Host, config.DatabaseConfig.Port, config.DatabaseConfig.Database)
+		db, err := openStore(ctx, config.DatabaseConfig)
 		if err != nil {
 			result.Errors = append(result.Errors, fmt.Errorf("failed to connect for discovered map retry rebuild: %w", err))
 			return result, nil
 		}
-		defer conn.Close(ctx)
+		defer db.Close(ctx)
 		if progressCallback != nil {
 			progressCallback("discovered", 0, 1, "Rebuilding discovered map coverage after retries...")
 		}
-		if _, err := pggeo.RebuildDiscoveredCoverage(ctx, conn, retryAthleteID, config.DiscoveredMap.SampleDistanceMeters, config.DiscoveredMap.RevealRadiusMeters); err != nil {
+		if err := db.RebuildDiscoveredCoverage(ctx, retryAthleteID, config.DiscoveredMap.SampleDistanceMeters, config.DiscoveredMap.RevealRadiusMeters); err != nil {
 			result.Errors = append(result.Errors, fmt.Errorf("failed to rebuild discovered map coverage after retries: %w", err))
 			if progressCallback != nil {
 				progressCallback("discovered", 1, 1, "Discovered map rebuild failed after retries")
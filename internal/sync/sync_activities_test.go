@@ -0,0 +1,697 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+	"b11k/internal/testsupport"
+)
+
+func TestActivitiesGoneFromStravaAreSkippedOnTheNextRun(t *testing.T) {
+	sim := testsupport.NewStravaSim(t, testsupport.StravaScenario{Activities: []testsupport.SimActivity{
+		{ID: 1}, {ID: 2, Deleted: true}, {ID: 3, Failures: 1},
+	}})
+	config := simConfig(sim)
+	listed := strava.ActivitySummaryList{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	// First run: 2 answers 404 and is reported gone; 3 fails transiently and is not
+	var clock phaseClock
+	fetched, gone, err := fetchDetailedActivitiesWithProgress(context.Background(), listed, config, nil, &clock)
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0].Summary.ID != 1 {
+		t.Fatalf("first run fetched %+v, want only activity 1", fetched)
+	}
+	if !reflect.DeepEqual(gone, []int64{2}) {
+		t.Fatalf("first run gone = %v, want [2]", gone)
+	}
+
+	// Second run: the recorded skip keeps 2 from being fetched, 3 is retried
+	skippedIDs := make(map[int64]bool)
+	for _, id := range gone {
+		skippedIDs[id] = true
+	}
+	toFetch, skipped := withoutSkippedActivities(strava.ActivitySummaryList{{ID: 2}, {ID: 3}}, skippedIDs)
+	if skipped != 1 {
+		t.Fatalf("second run skipped %d, want 1", skipped)
+	}
+	if fetched, gone, err = fetchDetailedActivitiesWithProgress(context.Background(), toFetch, config, nil, &clock); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if len(fetched) != 1 || fetched[0].Summary.ID != 3 || len(gone) != 0 {
+		t.Fatalf("second run fetched %d activities, gone %v; want only 3", len(fetched), gone)
+	}
+	if got := detailFetches(sim); !reflect.DeepEqual(got, []int64{1, 2, 3, 3}) {
+		t.Fatalf("fetched details of %v, want 2 never asked for again", got)
+	}
+}
+
+func TestWithoutSkippedActivitiesKeepsEverythingWithoutSkips(t *testing.T) {
+	listed := strava.ActivitySummaryList{{ID: 1}, {ID: 2}}
+	kept, skipped := withoutSkippedActivities(listed, nil)
+	if skipped != 0 || len(kept) != 2 {
+		t.Fatalf("kept %d, skipped %d; want 2 and 0", len(kept), skipped)
+	}
+}
+
+func TestOldestActivitiesDefersTheNewest(t *testing.T) {
+	listed := strava.ActivitySummaryList{
+		{ID: 3, StartDate: "2024-05-03T08:00:00Z"},
+		{ID: 1, StartDate: "2024-05-01T08:00:00Z"},
+		{ID: 2, StartDate: "2024-05-02T08:00:00Z"},
+	}
+	kept, deferred := oldestActivities(listed, 2)
+	if deferred != 1 || len(kept) != 2 || kept[0].ID != 1 || kept[1].ID != 2 {
+		t.Fatalf("kept %v, deferred %d; want the two oldest and 1 deferred", kept, deferred)
+	}
+	if listed[0].ID != 3 {
+		t.Fatal("oldestActivities reordered its input")
+	}
+	if kept, deferred := oldestActivities(listed, 0); deferred != 0 || len(kept) != 3 {
+		t.Fatalf("no limit kept %d, deferred %d", len(kept), deferred)
+	}
+}
+
+func TestSaveRetryableLeavesRefusedActivitiesAlone(t *testing.T) {
+	refused := []error{
+		fmt.Errorf("failed to save bike activity: %w", &pggeo.Error{Kind: pggeo.ErrInvalidInput, Msg: "no time stream data available"}),
+		fmt.Errorf("failed to upsert activity summary: %w", &pggeo.Error{Kind: pggeo.ErrForeignAthlete, Msg: "activity with ID 5 belongs to another athlete"}),
+	}
+	for _, err := range refused {
+		if saveRetryable(err) {
+			t.Errorf("%v should not be retried", err)
+		}
+	}
+	if !saveRetryable(errors.New("conn closed")) {
+		t.Error("a connection error should be retried")
+	}
+}
+
+func TestParseImportMode(t *testing.T) {
+	for value, want := range map[string]ImportMode{"": ImportModeNew, "new": ImportModeNew, "update": ImportModeUpdate} {
+		if got, err := ParseImportMode(value); err != nil || got != want {
+			t.Fatalf("ParseImportMode(%q) = %q, %v; want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseImportMode("replace"); err == nil {
+		t.Fatal("ParseImportMode accepted an unknown mode")
+	}
+}
+
+func TestEnforcedLimitsStopNewDetailFetches(t *testing.T) {
+	limits := pggeo.SoftLimits{MaxPointSamples: 1000, MaxDatabaseBytes: 1 << 30, MaxActivitiesPerAthlete: 100, Enforce: true}
+	under := pggeo.InstanceUsage{PointSamples: 1000, DatabaseBytes: 1 << 30, TopAthleteID: 7, TopAthleteActivities: 100}
+	if err := limitsError(limits, under); err != nil {
+		t.Fatalf("usage at the limits = %v, want nil", err)
+	}
+
+	tests := []struct {
+		name  string
+		usage func(*pggeo.InstanceUsage)
+		want  string
+	}{
+		{"point samples", func(u *pggeo.InstanceUsage) { u.PointSamples++ }, "1001 point samples of 1000"},
+		{"database size", func(u *pggeo.InstanceUsage) { u.DatabaseBytes = 2 << 30 }, "database size 2048 MB of 1024 MB"},
+		{"athlete activities", func(u *pggeo.InstanceUsage) { u.TopAthleteActivities = 150 }, "athlete 7 has 150 activities of 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := under
+			tt.usage(&usage)
+			err := limitsError(limits, usage)
+			if !errors.Is(err, ErrLimitsExceeded) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("limitsError = %v, want ErrLimitsExceeded mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	// Without Enforce the limits are only warned about, and the database is not asked
+	limits.Enforce = false
+	if err := checkEnforcedLimits(context.Background(), nil, limits, 7); err != nil {
+		t.Fatalf("unenforced limits = %v, want nil", err)
+	}
+}
+
+// fakeStore keeps activities in memory the way the database would for a sync. Saved
+// activities exist for the next sync.
+type fakeStore struct {
+	existing map[int64]bool
+	skipped  map[int64]bool
+	// latest is the start of the newest stored activity, for incremental syncs
+	latest *time.Time
+	// saveErrors are returned by the saves of an activity in turn, then it saves
+	saveErrors map[int64][]error
+	saved      []int64
+	// points counts the track points of each saved activity
+	points map[int64]int
+	marked []int64
+	// tags holds the tags added to each activity
+	tags   map[int64][]string
+	opened int
+	closed int
+}
+
+func (s *fakeStore) UpsertAthlete(context.Context, *strava.Athlete) error { return nil }
+
+func (s *fakeStore) GetLatestActivityStartDate(context.Context, int64) (*time.Time, error) {
+	return s.latest, nil
+}
+
+func (s *fakeStore) ActivitiesExist(_ context.Context, activityIDs []int64) (map[int64]bool, error) {
+	exists := make(map[int64]bool)
+	for _, id := range activityIDs {
+		exists[id] = s.existing[id]
+	}
+	return exists, nil
+}
+
+func (s *fakeStore) GetSkippedActivityIDs(context.Context, int64) (map[int64]bool, error) {
+	return s.skipped, nil
+}
+
+func (s *fakeStore) MarkActivitySkipped(_ context.Context, _, activityID int64, _ string) error {
+	s.marked = append(s.marked, activityID)
+	if s.skipped == nil {
+		s.skipped = make(map[int64]bool)
+	}
+	s.skipped[activityID] = true
+	return nil
+}
+
+func (s *fakeStore) GetInstanceUsage(context.Context, int64) (*pggeo.InstanceUsage, error) {
+	return &pggeo.InstanceUsage{}, nil
+}
+
+func (s *fakeStore) SaveActivity(ctx context.Context, activity *strava.BikeActivity, _ bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	id := activity.Summary.ID
+	if errs := s.saveErrors[id]; len(errs) > 0 {
+		s.saveErrors[id] = errs[1:]
+		return errs[0]
+	}
+	s.saved = append(s.saved, id)
+	if s.existing == nil {
+		s.existing = make(map[int64]bool)
+	}
+	s.existing[id] = true
+	if s.points == nil {
+		s.points = make(map[int64]int)
+	}
+	s.points[id] = len(activity.LatLngStream.Data)
+	return nil
+}
+
+func (s *fakeStore) AddActivityTags(_ context.Context, _, activityID int64, tags []string) error {
+	if s.tags == nil {
+		s.tags = make(map[int64][]string)
+	}
+	s.tags[activityID] = append(s.tags[activityID], tags...)
+	return nil
+}
+
+func (s *fakeStore) GetUnresolvedGearIDs(context.Context, int64, int) ([]string, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) UpsertGear(context.Context, pggeo.Gear) error { return nil }
+
+func (s *fakeStore) GetAthleteSettings(_ context.Context, athleteID int64) (*pggeo.AthleteSettings, error) {
+	return &pggeo.AthleteSettings{AthleteID: athleteID}, nil
+}
+
+func (s *fakeStore) MatchActivitiesToSegments(context.Context, int64, []int64, *float64, func(done, total int)) (int, error) {
+	return 0, nil
+}
+
+func (s *fakeStore) ComputeNewDistances(context.Context, int64, func(done, total int)) (int, error) {
+	return 0, nil
+}
+
+func (s *fakeStore) RebuildDiscoveredCoverage(context.Context, int64, float64, float64) error {
+	return nil
+}
+
+func (s *fakeStore) Close(context.Context) error {
+	s.closed++
+	return nil
+}
+
+// useFakeStore makes syncs open fake instead of connecting to Postgres, and retries wait
+// no time
+func useFakeStore(t *testing.T, fake *fakeStore) {
+	t.Helper()
+	originalOpen, originalBackoff := openStore, retryBackoff
+	openStore = func(context.Context, DatabaseConfig) (store, error) {
+		fake.opened++
+		return fake, nil
+	}
+	retryBackoff = 0
+	t.Cleanup(func() { openStore, retryBackoff = originalOpen, originalBackoff })
+}
+
+// simRides returns n rides a day apart from 2026-02-01, IDs from 1 oldest
+func simRides(n int) []testsupport.SimActivity {
+	start := time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)
+	rides := make([]testsupport.SimActivity, n)
+	for i := range rides {
+		rides[i] = testsupport.SimActivity{ID: int64(i + 1), Name: fmt.Sprintf("Ride %d", i+1), Start: start.AddDate(0, 0, i)}
+	}
+	return rides
+}
+
+// simConfig syncs against sim with pages of two, waiting on its virtual clock
+func simConfig(sim *testsupport.StravaSim) SyncConfig {
+	return SyncConfig{
+		StravaAccessToken: "token",
+		Strava: strava.HTTPClient{
+			BaseURL: sim.URL,
+			Limiter: strava.NewRateLimiterWithClock(sim.Now, sim.Sleep),
+			PerPage: 2,
+		},
+	}
+}
+
+// detailFetches returns the activity IDs whose details sim was asked for, in order
+func detailFetches(sim *testsupport.StravaSim) []int64 {
+	var ids []int64
+	for _, request := range sim.RequestsTo("/activities/") {
+		var id int64
+		if _, err := fmt.Sscanf(request, "GET /activities/%d", &id); err == nil && !strings.Contains(request, "/streams") {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// syncScenario is one sync run against a simulated Strava and a fake database
+type syncScenario struct {
+	name   string
+	strava testsupport.StravaScenario
+	db     *fakeStore
+	// config adjusts the sync's configuration, e.g. to act on sim mid sync
+	config func(c *SyncConfig, sim *testsupport.StravaSim)
+	// retries, when positive, runs SyncActivitiesFromStravaWithRetry
+	retries int
+
+	wantFound, wantExisting, wantSkipped, wantNew, wantDeferred int
+	wantSaved, wantGone, wantFailed, wantRejected               []int64
+	// wantFetched is the activities whose details were fetched, in order
+	wantFetched []int64
+	// wantWaiting is reported in a waiting_rate_limit progress message
+	wantWaiting string
+	check       func(t *testing.T, sim *testsupport.StravaSim, db *fakeStore, result *SyncResult)
+}
+
+func runSyncScenarios(t *testing.T, scenarios []syncScenario) {
+	t.Helper()
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			if sc.strava.AthleteID == 0 {
+				sc.strava.AthleteID = 42
+			}
+			sim := testsupport.NewStravaSim(t, sc.strava)
+			db := sc.db
+			if db == nil {
+				db = &fakeStore{}
+			}
+			useFakeStore(t, db)
+			config := simConfig(sim)
+			if sc.config != nil {
+				sc.config(&config, sim)
+			}
+			var waiting []string
+			progress := func(phase string, current, total int, message string) {
+				if phase == "waiting_rate_limit" {
+					waiting = append(waiting, message)
+				}
+			}
+
+			var result *SyncResult
+			var err error
+			if sc.retries > 0 {
+				result, err = SyncActivitiesFromStravaWithRetry(context.Background(), config, sc.retries, progress)
+			} else {
+				result, err = SyncActivitiesFromStrava(context.Background(), config, progress)
+			}
+			if err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+
+			if result.AthleteID != sc.strava.AthleteID {
+				t.Errorf("athlete = %d, want %d", result.AthleteID, sc.strava.AthleteID)
+			}
+			counts := []int{result.TotalActivitiesFound, result.ExistingActivities, result.SkippedActivities, result.NewActivities, result.DeferredActivities}
+			if want := []int{sc.wantFound, sc.wantExisting, sc.wantSkipped, sc.wantNew, sc.wantDeferred}; !slices.Equal(counts, want) {
+				t.Errorf("found, existing, skipped, new, deferred = %v, want %v", counts, want)
+			}
+			if !slices.Equal(db.saved, sc.wantSaved) || !slices.Equal(result.SavedActivityIDs, sc.wantSaved) {
+				t.Errorf("saved %v (reported %v), want %v", db.saved, result.SavedActivityIDs, sc.wantSaved)
+			}
+			if result.SuccessfullyProcessed != len(sc.wantSaved) {
+				t.Errorf("successfully processed %d, want %d", result.SuccessfullyProcessed, len(sc.wantSaved))
+			}
+			if !slices.Equal(result.GoneActivities, nonNil(sc.wantGone)) || !slices.Equal(db.marked, sc.wantGone) {
+				t.Errorf("gone %v, marked skipped %v; want %v", result.GoneActivities, db.marked, sc.wantGone)
+			}
+			if !slices.Equal(result.FailedActivities, sc.wantFailed) || !slices.Equal(result.RejectedActivities, nonNil(sc.wantRejected)) {
+				t.Errorf("failed %v, rejected %v; want %v and %v", result.FailedActivities, result.RejectedActivities, sc.wantFailed, sc.wantRejected)
+			}
+			if got := detailFetches(sim); !slices.Equal(got, sc.wantFetched) {
+				t.Errorf("fetched details of %v, want %v", got, sc.wantFetched)
+			}
+			if sc.wantWaiting == "" && len(waiting) > 0 {
+				t.Errorf("waited for rate limits: %v", waiting)
+			}
+			if sc.wantWaiting != "" && !slices.ContainsFunc(waiting, func(message string) bool { return strings.Contains(message, sc.wantWaiting) }) {
+				t.Errorf("rate limit waits %v, want one mentioning %q", waiting, sc.wantWaiting)
+			}
+			if db.closed != db.opened {
+				t.Errorf("%d of %d connections closed", db.closed, db.opened)
+			}
+			if sc.check != nil {
+				sc.check(t, sim, db, result)
+			}
+		})
+	}
+}
+
+// nonNil is want as the result reports it: empty lists are allocated
+func nonNil(ids []int64) []int64 {
+	if ids == nil {
+		return []int64{}
+	}
+	return ids
+}
+
+func TestSyncScenarios(t *testing.T) {
+	runSyncScenarios(t, []syncScenario{
+		{
+			name:         "new and existing activities",
+			strava:       testsupport.StravaScenario{Activities: simRides(5)},
+			db:           &fakeStore{existing: map[int64]bool{1: true, 3: true}, skipped: map[int64]bool{4: true}},
+			wantFound:    5,
+			wantExisting: 2,
+			wantSkipped:  1,
+			wantNew:      2,
+			wantSaved:    []int64{5, 2},
+			wantFetched:  []int64{5, 2},
+		},
+		{
+			name:        "exactly full last page",
+			strava:      testsupport.StravaScenario{Activities: simRides(4)},
+			wantFound:   4,
+			wantNew:     4,
+			wantSaved:   []int64{4, 3, 2, 1},
+			wantFetched: []int64{4, 3, 2, 1},
+			check: func(t *testing.T, sim *testsupport.StravaSim, _ *fakeStore, _ *SyncResult) {
+				if pages := len(sim.RequestsTo("/athlete/activities")); pages != 3 {
+					t.Errorf("listed %d pages, want the two full ones and an empty one", pages)
+				}
+			},
+		},
+		{
+			name:        "activity types",
+			strava:      testsupport.StravaScenario{Activities: []testsupport.SimActivity{{ID: 1, SportType: "Run"}, {ID: 2, SportType: "GravelRide"}, {ID: 3}}},
+			config:      func(c *SyncConfig, _ *testsupport.StravaSim) { c.ActivityTypes = []string{"Ride", "GravelRide"} },
+			wantFound:   2,
+			wantNew:     2,
+			wantSaved:   []int64{2, 3},
+			wantFetched: []int64{2, 3},
+		},
+		{
+			name:         "new activities past the cap wait for the next sync",
+			strava:       testsupport.StravaScenario{Activities: simRides(4)},
+			config:       func(c *SyncConfig, _ *testsupport.StravaSim) { c.MaxNewActivities = 2 },
+			wantFound:    4,
+			wantNew:      4,
+			wantDeferred: 2,
+			wantSaved:    []int64{1, 2},
+			wantFetched:  []int64{1, 2},
+		},
+		{
+			name:         "incremental sync lists from the newest stored activity minus the overlap",
+			strava:       testsupport.StravaScenario{Activities: simRides(5)},
+			db:           &fakeStore{existing: map[int64]bool{1: true, 2: true, 3: true}, latest: &simRides(3)[2].Start},
+			config:       func(c *SyncConfig, _ *testsupport.StravaSim) { c.Incremental = true },
+			wantFound:    3,
+			wantExisting: 1,
+			wantNew:      2,
+			wantSaved:    []int64{4, 5},
+			wantFetched:  []int64{4, 5},
+			check: func(t *testing.T, sim *testsupport.StravaSim, _ *fakeStore, _ *SyncResult) {
+				after := fmt.Sprintf("after=%d", simRides(2)[1].Start.Unix())
+				if listing := sim.RequestsTo("/athlete/activities"); len(listing) == 0 || !strings.Contains(listing[0], after) {
+					t.Errorf("listing %v, want it to start %s", listing, after)
+				}
+			},
+		},
+		{
+			name:        "downsampled streams in a list",
+			strava:      testsupport.StravaScenario{Activities: []testsupport.SimActivity{{ID: 1, Points: 3000}}, StreamsAsArray: true, Resolution: testsupport.ResolutionMedium},
+			wantFound:   1,
+			wantNew:     1,
+			wantSaved:   []int64{1},
+			wantFetched: []int64{1},
+			check: func(t *testing.T, _ *testsupport.StravaSim, db *fakeStore, _ *SyncResult) {
+				if db.points[1] != 1000 {
+					t.Errorf("saved %d points, want the 1000 of the medium resolution", db.points[1])
+				}
+			},
+		},
+		{
+			// Rides start on Sunday, Monday and Tuesday; 1 is only saved by the retry
+			name:   "tag rules tag saved and retried activities",
+			strava: testsupport.StravaScenario{Activities: simRides(3)},
+			db:     &fakeStore{saveErrors: map[int64][]error{1: {errors.New("conn closed")}}},
+			config: func(c *SyncConfig, _ *testsupport.StravaSim) {
+				c.TagRules = []TagRule{
+					{Tag: "weekend", Weekdays: []time.Weekday{time.Saturday, time.Sunday}},
+					{Tag: "tuesday", Weekdays: []time.Weekday{time.Tuesday}},
+					{Tag: "other-athlete", AthleteID: 7},
+				}
+			},
+			retries:     1,
+			wantFound:   3,
+			wantNew:     3,
+			wantSaved:   []int64{3, 2, 1},
+			wantFetched: []int64{3, 2, 1, 1},
+			check: func(t *testing.T, _ *testsupport.StravaSim, db *fakeStore, _ *SyncResult) {
+				want := map[int64][]string{1: {"weekend"}, 3: {"tuesday"}}
+				if !maps.EqualFunc(db.tags, want, slices.Equal[[]string]) {
+					t.Errorf("tags = %v, want %v", db.tags, want)
+				}
+			},
+		},
+	})
+}
+
+// TestSyncRegressionScenarios replays the Strava behaviour behind past sync bugs
+func TestSyncRegressionScenarios(t *testing.T) {
+	runSyncScenarios(t, []syncScenario{
+		{
+			// An upload while paging shifted an activity onto the next page, which was
+			// then fetched and saved twice
+			name: "upload mid pagination saves nothing twice",
+			strava: testsupport.StravaScenario{
+				Activities: simRides(4),
+				BeforePage: map[int]func(*testsupport.StravaSim){
+					2: func(sim *testsupport.StravaSim) {
+						sim.Upload(testsupport.SimActivity{ID: 10, Start: time.Date(2026, 2, 10, 8, 0, 0, 0, time.UTC)})
+					},
+				},
+			},
+			wantFound:   4,
+			wantNew:     4,
+			wantSaved:   []int64{4, 3, 2, 1},
+			wantFetched: []int64{4, 3, 2, 1},
+		},
+		{
+			// A 429 used to fail the listing and with it the whole sync
+			name:        "throttled listing waits for the window",
+			strava:      testsupport.StravaScenario{Activities: simRides(3), ThrottledRequests: []int{3}},
+			wantFound:   3,
+			wantNew:     3,
+			wantSaved:   []int64{3, 2, 1},
+			wantFetched: []int64{3, 2, 1},
+			wantWaiting: string(strava.RateLimitShortTerm),
+		},
+		{
+			name:        "throttled streams wait for the window",
+			strava:      testsupport.StravaScenario{Activities: simRides(2), ThrottledRequests: []int{5}},
+			wantFound:   2,
+			wantNew:     2,
+			wantSaved:   []int64{2, 1},
+			wantFetched: []int64{2, 1},
+			wantWaiting: string(strava.RateLimitShortTerm),
+		},
+		{
+			// Long syncs ran into the short-term limit instead of pacing themselves
+			name:        "short-term limit paces the sync",
+			strava:      testsupport.StravaScenario{Activities: simRides(3), ShortTermLimit: 6},
+			wantFound:   3,
+			wantNew:     3,
+			wantSaved:   []int64{3, 2, 1},
+			wantFetched: []int64{3, 2, 1},
+			wantWaiting: string(strava.RateLimitShortTerm),
+			check: func(t *testing.T, sim *testsupport.StravaSim, _ *fakeStore, _ *SyncResult) {
+				if len(sim.Requests()) != 9 {
+					t.Errorf("made %d requests, want 9 without a throttled one", len(sim.Requests()))
+				}
+			},
+		},
+		{
+			name:        "daily limit waits for the next day",
+			strava:      testsupport.StravaScenario{Activities: simRides(2), DailyLimit: 6},
+			wantFound:   2,
+			wantNew:     2,
+			wantSaved:   []int64{2, 1},
+			wantFetched: []int64{2, 1},
+			wantWaiting: string(strava.RateLimitDaily),
+			check: func(t *testing.T, sim *testsupport.StravaSim, _ *fakeStore, _ *SyncResult) {
+				if day := sim.Now().Day(); day != 2 {
+					t.Errorf("finished on March %d, want the next day", day)
+				}
+			},
+		},
+		{
+			// A deleted activity was retried by every sync forever
+			name:        "activity deleted after the listing is skipped from then on",
+			strava:      testsupport.StravaScenario{Activities: []testsupport.SimActivity{{ID: 1}, {ID: 2, Deleted: true}}},
+			wantFound:   2,
+			wantNew:     2,
+			wantSaved:   []int64{1},
+			wantGone:    []int64{2},
+			wantFetched: []int64{1, 2},
+		},
+		{
+			// One failure used to abort the sync and drop the activities after it
+			name: "failures accumulate without stopping",
+			strava: testsupport.StravaScenario{Activities: []testsupport.SimActivity{
+				{ID: 1}, {ID: 2, Failures: 1}, {ID: 3, Deleted: true}, {ID: 4}, {ID: 5},
+			}},
+			db: &fakeStore{saveErrors: map[int64][]error{
+				4: {errors.New("conn closed")},
+				5: {fmt.Errorf("failed to save bike activity: %w", &pggeo.Error{Kind: pggeo.ErrInvalidInput, Msg: "no time stream data available"})},
+			}},
+			wantFound:    5,
+			wantNew:      5,
+			wantSaved:    []int64{1},
+			wantGone:     []int64{3},
+			wantFailed:   []int64{4},
+			wantRejected: []int64{5},
+			// The transient fetch failure of 2 is left for the next sync
+			wantFetched: []int64{1, 2, 3, 4, 5},
+			check: func(t *testing.T, _ *testsupport.StravaSim, _ *fakeStore, result *SyncResult) {
+				if len(result.Errors) != 2 {
+					t.Errorf("errors = %v, want the two failed saves", result.Errors)
+				}
+			},
+		},
+		{
+			name:        "failed saves are refetched and retried",
+			strava:      testsupport.StravaScenario{Activities: simRides(3)},
+			db:          &fakeStore{saveErrors: map[int64][]error{1: {errors.New("conn closed")}, 2: {errors.New("conn closed"), errors.New("conn closed")}}},
+			retries:     3,
+			wantFound:   3,
+			wantNew:     3,
+			wantSaved:   []int64{3, 1, 2},
+			wantFetched: []int64{3, 2, 1, 2, 1, 2},
+			check: func(t *testing.T, _ *testsupport.StravaSim, db *fakeStore, _ *SyncResult) {
+				if db.opened != 3 {
+					t.Errorf("opened %d connections, want the sync and two retry rounds", db.opened)
+				}
+			},
+		},
+		{
+			name:        "retries give up after the last round",
+			strava:      testsupport.StravaScenario{Activities: simRides(2)},
+			db:          &fakeStore{saveErrors: map[int64][]error{1: {errors.New("conn closed"), errors.New("conn closed"), errors.New("conn closed"), errors.New("conn closed")}}},
+			retries:     2,
+			wantFound:   2,
+			wantNew:     2,
+			wantSaved:   []int64{2},
+			wantFailed:  []int64{1},
+			wantFetched: []int64{2, 1, 1, 1},
+			check: func(t *testing.T, _ *testsupport.StravaSim, db *fakeStore, result *SyncResult) {
+				if len(db.saveErrors[1]) != 1 {
+					t.Errorf("%d save errors left, want 1 saved once per round", len(db.saveErrors[1]))
+				}
+				if len(result.PhaseTimings) == 0 || result.PhaseTimings[len(result.PhaseTimings)-1].Phase != PhaseRetries {
+					t.Errorf("phases %v, want the retries timed last", result.PhaseTimings)
+				}
+			},
+		},
+		{
+			name:   "activity deleted before its retry is gone",
+			strava: testsupport.StravaScenario{Activities: simRides(2)},
+			db:     &fakeStore{saveErrors: map[int64][]error{1: {errors.New("conn closed")}}},
+			// Saves come after every fetch, so 1 is deleted once it was fetched
+			config: func(c *SyncConfig, sim *testsupport.StravaSim) {
+				c.OnActivitySaved = func(*strava.BikeActivity) { sim.Delete(1) }
+			},
+			retries:     1,
+			wantFound:   2,
+			wantNew:     2,
+			wantSaved:   []int64{2},
+			wantGone:    []int64{1},
+			wantFetched: []int64{2, 1, 1},
+		},
+	})
+}
+
+func TestCancelledSyncStopsStravaRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancelOnPage cancels the sync as that listing page is asked for, cancelOnDetail
+		// once that many activities have their details
+		cancelOnPage   int
+		cancelOnDetail int
+		wantRequests   int
+	}{
+		// athlete, page 1 and the in-flight page 2
+		{name: "while listing", cancelOnPage: 2, wantRequests: 3},
+		// athlete, three pages, then the first activity and its streams
+		{name: "between details", cancelOnDetail: 1, wantRequests: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			scenario := testsupport.StravaScenario{AthleteID: 42, Activities: simRides(4)}
+			if tt.cancelOnPage > 0 {
+				scenario.BeforePage = map[int]func(*testsupport.StravaSim){tt.cancelOnPage: func(*testsupport.StravaSim) { cancel() }}
+			}
+			sim := testsupport.NewStravaSim(t, scenario)
+			db := &fakeStore{}
+			useFakeStore(t, db)
+			progress := func(phase string, current, total int, message string) {
+				if phase == "fetching_details" && current == tt.cancelOnDetail {
+					cancel()
+				}
+			}
+
+			_, err := SyncActivitiesFromStravaWithRetry(ctx, simConfig(sim), 3, progress)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("err = %v, want context.Canceled", err)
+			}
+			if requests := sim.Requests(); len(requests) != tt.wantRequests {
+				t.Fatalf("requests = %v, want %d", requests, tt.wantRequests)
+			}
+			if len(db.saved) != 0 || db.closed != db.opened {
+				t.Fatalf("saved %v, %d of %d connections closed", db.saved, db.closed, db.opened)
+			}
+		})
+	}
+}
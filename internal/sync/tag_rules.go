@@ -0,0 +1,193 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// DefaultTagRuleRadiusM is how close to a place a ride must start or end when a rule sets
+// no radius
+const DefaultTagRuleRadiusM = 250.0
+
+// Place is a point a tag rule measures starts and ends against, such as home or work
+type Place struct {
+	Lat float64
+	Lng float64
+}
+
+// TimeWindow is a span of the local day, From inclusive and To exclusive, as offsets from
+// midnight. A window whose To is before From runs over midnight.
+type TimeWindow struct {
+	From time.Duration
+	To   time.Duration
+}
+
+// ParseTimeWindow reads a window written as "HH:MM-HH:MM"
+func ParseTimeWindow(value string) (TimeWindow, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("time window %q must be HH:MM-HH:MM", value)
+	}
+	var window TimeWindow
+	for _, part := range []struct {
+		text   string
+		target *time.Duration
+	}{{from, &window.From}, {to, &window.To}} {
+		hours, minutes, ok := strings.Cut(strings.TrimSpace(part.text), ":")
+		h, errH := strconv.Atoi(hours)
+		m, errM := strconv.Atoi(minutes)
+		if !ok || errH != nil || errM != nil || h < 0 || h > 24 || m < 0 || m > 59 || h*60+m > 24*60 {
+			return TimeWindow{}, fmt.Errorf("time window %q must be HH:MM-HH:MM", value)
+		}
+		*part.target = time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	}
+	return window, nil
+}
+
+func (w TimeWindow) contains(offset time.Duration) bool {
+	if w.To < w.From {
+		return offset >= w.From || offset < w.To
+	}
+	return offset >= w.From && offset < w.To
+}
+
+// ParseWeekday reads a weekday as its English name or first three letters in any case
+func ParseWeekday(value string) (time.Weekday, error) {
+	name := strings.ToLower(strings.TrimSpace(value))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || (len(name) == 3 && strings.HasPrefix(full, name)) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", value)
+}
+
+// TagRule tags the activities it matches with Tag when they are first stored. Every
+// condition set must hold; a rule without conditions tags every activity.
+type TagRule struct {
+	Tag string
+	// AthleteID limits the rule to one athlete; 0 applies it to everyone
+	AthleteID int64
+	// Between holds two places the ride must run between, starting within RadiusM of
+	// one and ending within RadiusM of the other, in either direction
+	Between []Place
+	// RadiusM is the radius around the places; DefaultTagRuleRadiusM when 0
+	RadiusM float64
+	// MaxDistanceM keeps rides shorter than this many meters; no limit when 0
+	MaxDistanceM float64
+	// Weekdays and Windows limit the local start of the ride
+	Weekdays []time.Weekday
+	Windows  []TimeWindow
+	// Types are Strava types or sport types, matched like strava.MatchesActivityType
+	Types []string
+}
+
+// Matches reports whether the rule tags activity
+func (r TagRule) Matches(activity *strava.BikeActivity) bool {
+	summary := activity.Summary
+	if r.AthleteID != 0 && r.AthleteID != summary.AthleteID {
+		return false
+	}
+	if r.MaxDistanceM > 0 && summary.Distance >= r.MaxDistanceM {
+		return false
+	}
+	if !strava.MatchesActivityType(summary, r.Types) {
+		return false
+	}
+	if len(r.Weekdays) > 0 || len(r.Windows) > 0 {
+		start, ok := localStart(summary)
+		if !ok || (len(r.Weekdays) > 0 && !slices.Contains(r.Weekdays, start.Weekday())) {
+			return false
+		}
+		offset := time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute + time.Duration(start.Second())*time.Second
+		if len(r.Windows) > 0 && !slices.ContainsFunc(r.Windows, func(w TimeWindow) bool { return w.contains(offset) }) {
+			return false
+		}
+	}
+	if len(r.Between) == 2 {
+		startPoint, endPoint, ok := routeEnds(activity)
+		if !ok {
+			return false
+		}
+		radius := r.RadiusM
+		if radius <= 0 {
+			radius = DefaultTagRuleRadiusM
+		}
+		near := func(point []float64, place Place) bool {
+			return geo.HaversineMeters(point[0], point[1], place.Lat, place.Lng) <= radius
+		}
+		a, b := r.Between[0], r.Between[1]
+		if !(near(startPoint, a) && near(endPoint, b)) && !(near(startPoint, b) && near(endPoint, a)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ActivityTags returns the tags of the rules matching activity, each once
+func ActivityTags(rules []TagRule, activity *strava.BikeActivity) []string {
+	var tags []string
+	for _, rule := range rules {
+		if !slices.Contains(tags, rule.Tag) && rule.Matches(activity) {
+			tags = append(tags, rule.Tag)
+		}
+	}
+	return tags
+}
+
+// TagActivity adds the tags of the rules matching a newly stored activity
+func TagActivity(ctx context.Context, conn pggeo.DB, rules []TagRule, activity *strava.BikeActivity) error {
+	tags := ActivityTags(rules, activity)
+	if len(tags) == 0 {
+		return nil
+	}
+	return pggeo.AddActivityTags(ctx, conn, activity.Summary.AthleteID, activity.Summary.ID, tags...)
+}
+
+// tagSavedActivity runs the sync's tag rules on a saved activity. A failure is logged,
+// not counted against the sync, as the activity itself was stored.
+func tagSavedActivity(ctx context.Context, db store, rules []TagRule, activity *strava.BikeActivity) {
+	tags := ActivityTags(rules, activity)
+	if len(tags) == 0 {
+		return
+	}
+	if err := db.AddActivityTags(ctx, activity.Summary.AthleteID, activity.Summary.ID, tags); err != nil {
+		logger().Warn("Failed to tag activity", "athlete_id", activity.Summary.AthleteID, "activity_id", activity.Summary.ID, "tags", tags, "error", err)
+	}
+}
+
+// localStart is the activity's start in the time zone it was ridden in
+func localStart(summary strava.ActivitySummary) (time.Time, bool) {
+	start := summary.StartDateTime
+	if start.IsZero() {
+		parsed, err := time.Parse(time.RFC3339, summary.StartDate)
+		if err != nil {
+			return time.Time{}, false
+		}
+		start = parsed
+	}
+	return start.UTC().Add(time.Duration(summary.UtcOffset) * time.Second), true
+}
+
+// routeEnds returns the first and last [lat, lng] of the activity, from Strava's summary
+// or else from its GPS stream
+func routeEnds(activity *strava.BikeActivity) ([]float64, []float64, bool) {
+	summary := activity.Summary
+	if summary.StartLatLng != nil && len(*summary.StartLatLng) >= 2 && summary.EndLatLng != nil && len(*summary.EndLatLng) >= 2 {
+		return *summary.StartLatLng, *summary.EndLatLng, true
+	}
+	points := activity.LatLngStream.Data
+	if len(points) == 0 || len(points[0]) < 2 || len(points[len(points)-1]) < 2 {
+		return nil, nil, false
+	}
+	return points[0], points[len(points)-1], true
+}
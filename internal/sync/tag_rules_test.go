@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    TimeWindow
+		wantErr bool
+	}{
+		{value: "06:30-09:00", want: TimeWindow{From: 6*time.Hour + 30*time.Minute, To: 9 * time.Hour}},
+		{value: " 22:00 - 02:00 ", want: TimeWindow{From: 22 * time.Hour, To: 2 * time.Hour}},
+		{value: "16:00-24:00", want: TimeWindow{From: 16 * time.Hour, To: 24 * time.Hour}},
+		{value: "6-9", wantErr: true},
+		{value: "06:00", wantErr: true},
+		{value: "06:60-07:00", wantErr: true},
+		{value: "24:30-01:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimeWindow(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTimeWindow(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	for value, want := range map[string]time.Weekday{"mon": time.Monday, "Friday": time.Friday, " SUN ": time.Sunday} {
+		if got, err := ParseWeekday(value); err != nil || got != want {
+			t.Errorf("ParseWeekday(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "mo", "monday!"} {
+		if _, err := ParseWeekday(value); err == nil {
+			t.Errorf("ParseWeekday(%q) should fail", value)
+		}
+	}
+}
+
+func TestTagRuleMatches(t *testing.T) {
+	home := Place{Lat: 48.8566, Lng: 2.3522}
+	work := Place{Lat: 48.8738, Lng: 2.2950}
+	commute := TagRule{
+		Tag:          "commute",
+		Between:      []Place{home, work},
+		MaxDistanceM: 15000,
+		Weekdays:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Windows:      []TimeWindow{{From: 6 * time.Hour, To: 10 * time.Hour}, {From: 16 * time.Hour, To: 20 * time.Hour}},
+		Types:        []string{"Ride"},
+	}
+	// ride returns a ride from the place at from to the one at to starting at local
+	// on a +2h clock, given in UTC as Strava does
+	ride := func(from, to Place, local time.Time, distance float64) *strava.BikeActivity {
+		activity := &strava.BikeActivity{}
+		activity.Summary.AthleteID = 1
+		activity.Summary.Type = "Ride"
+		activity.Summary.SportType = "Ride"
+		activity.Summary.Distance = distance
+		activity.Summary.UtcOffset = 7200
+		activity.Summary.StartDateTime = local.Add(-2 * time.Hour).UTC()
+		start, end := []float64{from.Lat, from.Lng}, []float64{to.Lat, to.Lng}
+		activity.Summary.StartLatLng, activity.Summary.EndLatLng = &start, &end
+		return activity
+	}
+	// 2026-10-12 is a Monday
+	morning := time.Date(2026, 10, 12, 8, 15, 0, 0, time.UTC)
+	nearHome := Place{Lat: home.Lat + 0.001, Lng: home.Lng}
+	elsewhere := Place{Lat: 48.80, Lng: 2.40}
+
+	tests := []struct {
+		name     string
+		activity *strava.BikeActivity
+		want     bool
+	}{
+		{name: "morning commute", activity: ride(home, work, morning, 6000), want: true},
+		{name: "evening commute the other way", activity: ride(work, nearHome, morning.Add(9*time.Hour), 6200), want: true},
+		{name: "weekend", activity: ride(home, work, morning.AddDate(0, 0, 5), 6000)},
+		// 10:30 local is 08:30 UTC, inside the window had the offset been ignored
+		{name: "after the window", activity: ride(home, work, morning.Add(135*time.Minute), 6000)},
+		{name: "too long", activity: ride(home, work, morning, 40000)},
+		{name: "ends elsewhere", activity: ride(home, elsewhere, morning, 6000)},
+		{name: "round trip from home", activity: ride(home, home, morning, 6000)},
+	}
+	for _, tt := range tests {
+		if got := commute.Matches(tt.activity); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	virtual := ride(home, work, morning, 6000)
+	virtual.Summary.Type, virtual.Summary.SportType = "VirtualRide", "VirtualRide"
+	if commute.Matches(virtual) {
+		t.Error("a virtual ride should not match a Ride rule")
+	}
+
+	// Without start and end in the summary the GPS stream gives them
+	streamed := ride(home, work, morning, 6000)
+	streamed.Summary.StartLatLng, streamed.Summary.EndLatLng = nil, nil
+	streamed.LatLngStream.Data = [][]float64{{home.Lat, home.Lng}, {48.86, 2.33}, {work.Lat, work.Lng}}
+	if !commute.Matches(streamed) {
+		t.Error("the GPS stream should give the ends of the ride")
+	}
+	streamed.LatLngStream.Data = nil
+	if commute.Matches(streamed) {
+		t.Error("a ride without a route should not match a rule between places")
+	}
+
+	rules := []TagRule{commute, {Tag: "short", MaxDistanceM: 10000}, {Tag: "commute"}, {Tag: "theirs", AthleteID: 2}}
+	if got := ActivityTags(rules, ride(home, work, morning, 6000)); !slices.Equal(got, []string{"commute", "short"}) {
+		t.Errorf("tags = %v, want commute and short once each", got)
+	}
+}
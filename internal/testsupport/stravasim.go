@@ -0,0 +1,493 @@
+// Package testsupport holds fakes shared by the tests of several packages. Only tests
+// import it. It imports no other B11K package, so the tests of any of them can use it.
+package testsupport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Strava's default page size and rate limits, used where a scenario sets none
+const (
+	stravaDefaultPerPage = 30
+	stravaShortWindow    = 15 * time.Minute
+	// unlimitedRequests is reported as the limits of scenarios without any, so clients
+	// never pause for them
+	unlimitedRequests = 1_000_000
+)
+
+// Stream resolutions of a scenario. Strava answers low and medium with at most 100 and
+// 1000 points sampled evenly from the recording.
+const (
+	ResolutionHigh   = "high"
+	ResolutionMedium = "medium"
+	ResolutionLow    = "low"
+)
+
+// SimActivity is one activity of the simulated athlete
+type SimActivity struct {
+	ID    int64
+	Name  string
+	Start time.Time
+	// SportType is also the activity's type; Ride when empty
+	SportType string
+	// Points is the length of the recorded streams, 10 when zero
+	Points int
+	// Deleted activities stay listed but answer 404 to their details, as if they were
+	// deleted on Strava between the listing and the detail fetch
+	Deleted bool
+	// Failures answers that many detail requests with a 502 before one succeeds
+	Failures int
+}
+
+// StravaScenario scripts a StravaSim
+type StravaScenario struct {
+	AthleteID  int64
+	Activities []SimActivity
+	// PageSizes caps the activities of listing pages in turn: page n answers at most
+	// PageSizes[n-1], still starting at (n-1)*per_page. Missing or zero entries answer a
+	// full page.
+	PageSizes []int
+	// BeforePage runs before page n of a listing is answered, once per page, e.g. to
+	// Upload an activity in the middle of a sync
+	BeforePage map[int]func(*StravaSim)
+	// ThrottledRequests are answered 429 with the usage at the short-term limit, counting
+	// every request from 1
+	ThrottledRequests []int
+	// ShortTermLimit and DailyLimit, when positive, are the limits reported in the
+	// X-RateLimit headers; requests past them are answered 429 until their window resets
+	ShortTermLimit int
+	DailyLimit     int
+	// StreamsAsArray answers streams as a list even when key_by_type asks for an object
+	StreamsAsArray bool
+	// Resolution downsamples the streams, see ResolutionLow; empty answers every point
+	Resolution string
+	// Start is the virtual clock's start, 2026-03-01 06:00 UTC when zero
+	Start time.Time
+}
+
+// StravaSim is an in-process Strava API following a scenario. Its clock is virtual:
+// Sleep advances it instead of blocking, so rate limit waits take no time when a client's
+// limiter uses Now and Sleep.
+type StravaSim struct {
+	// URL is the API root to call instead of https://www.strava.com/api/v3
+	URL string
+
+	mu          sync.Mutex
+	scenario    StravaScenario
+	activities  []SimActivity
+	failures    map[int64]int
+	pagesRun    map[int]bool
+	throttled   map[int]bool
+	clock       time.Time
+	requests    []string
+	sleeps      []time.Duration
+	window      time.Time
+	windowUsage int
+	day         time.Time
+	dayUsage    int
+}
+
+// NewStravaSim serves scenario until the test ends
+func NewStravaSim(t testing.TB, scenario StravaScenario) *StravaSim {
+	t.Helper()
+	s := &StravaSim{
+		scenario:   scenario,
+		activities: slices.Clone(scenario.Activities),
+		failures:   make(map[int64]int),
+		pagesRun:   make(map[int]bool),
+		throttled:  make(map[int]bool),
+		clock:      scenario.Start,
+	}
+	if s.clock.IsZero() {
+		s.clock = time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	}
+	for _, activity := range scenario.Activities {
+		s.failures[activity.ID] = activity.Failures
+	}
+	for _, n := range scenario.ThrottledRequests {
+		s.throttled[n] = true
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	s.URL = server.URL
+	return s
+}
+
+// Now is the virtual clock
+func (s *StravaSim) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock
+}
+
+// Sleep advances the virtual clock by d, or returns ctx's error when it is done
+func (s *StravaSim) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = s.clock.Add(d)
+	s.sleeps = append(s.sleeps, d)
+	return nil
+}
+
+// Sleeps returns the waits clients slept through, in order
+func (s *StravaSim) Sleeps() []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.sleeps)
+}
+
+// Requests returns every request answered so far as "METHOD /path?query", in order
+func (s *StravaSim) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// RequestsTo returns the requests whose path starts with prefix
+func (s *StravaSim) RequestsTo(prefix string) []string {
+	var matching []string
+	for _, request := range s.Requests() {
+		_, target, _ := strings.Cut(request, " ")
+		if strings.HasPrefix(target, prefix) {
+			matching = append(matching, request)
+		}
+	}
+	return matching
+}
+
+// Upload adds an activity, as if the athlete uploaded it now
+func (s *StravaSim) Upload(activity SimActivity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activities = append(s.activities, activity)
+	s.failures[activity.ID] = activity.Failures
+}
+
+// Delete removes an activity from the listing and its details, as if the athlete deleted
+// it now
+func (s *StravaSim) Delete(activityID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activities = slices.DeleteFunc(s.activities, func(a SimActivity) bool { return a.ID == activityID })
+}
+
+func (s *StravaSim) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	throttled := s.countRequestLocked(len(s.requests))
+	s.writeRateLimitHeadersLocked(w, throttled)
+	s.mu.Unlock()
+	if throttled {
+		writeSimJSON(w, http.StatusTooManyRequests, map[string]string{"message": "Rate Limit Exceeded"})
+		return
+	}
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeSimJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/athlete":
+		writeSimJSON(w, http.StatusOK, map[string]interface{}{"id": s.scenario.AthleteID, "firstname": "Sim", "lastname": "Rider"})
+	case path == "/athlete/activities":
+		s.serveListing(w, r)
+	case strings.HasPrefix(path, "/activities/"):
+		idText, streams := strings.CutSuffix(strings.TrimPrefix(path, "/activities/"), "/streams")
+		id, err := strconv.ParseInt(idText, 10, 64)
+		if err != nil {
+			writeSimJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+			return
+		}
+		if streams {
+			s.serveStreams(w, r, id)
+		} else {
+			s.serveActivity(w, id)
+		}
+	default:
+		writeSimJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+	}
+}
+
+// countRequestLocked counts request n against the windows, or reports it throttled
+func (s *StravaSim) countRequestLocked(n int) bool {
+	if window := s.clock.UTC().Truncate(stravaShortWindow); !window.Equal(s.window) {
+		s.window, s.windowUsage = window, 0
+	}
+	y, m, d := s.clock.UTC().Date()
+	if day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC); !day.Equal(s.day) {
+		s.day, s.dayUsage = day, 0
+	}
+	if s.throttled[n] ||
+		(s.scenario.ShortTermLimit > 0 && s.windowUsage >= s.scenario.ShortTermLimit) ||
+		(s.scenario.DailyLimit > 0 && s.dayUsage >= s.scenario.DailyLimit) {
+		return true
+	}
+	s.windowUsage++
+	s.dayUsage++
+	return false
+}
+
+func (s *StravaSim) limitsLocked() (short, daily int) {
+	short, daily = s.scenario.ShortTermLimit, s.scenario.DailyLimit
+	if short <= 0 {
+		short = unlimitedRequests
+	}
+	if daily <= 0 {
+		daily = unlimitedRequests
+	}
+	return short, daily
+}
+
+// writeRateLimitHeadersLocked reports the usage like Strava. A throttled request reports
+// the short-term window as used up, so the client waits for it to reset.
+func (s *StravaSim) writeRateLimitHeadersLocked(w http.ResponseWriter, throttled bool) {
+	short, daily := s.limitsLocked()
+	shortUsage, dailyUsage := s.windowUsage, s.dayUsage
+	if throttled {
+		shortUsage = max(shortUsage, short)
+		if s.scenario.DailyLimit > 0 && s.dayUsage >= s.scenario.DailyLimit {
+			dailyUsage = daily
+		}
+	}
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d,%d", short, daily))
+	w.Header().Set("X-RateLimit-Usage", fmt.Sprintf("%d,%d", shortUsage, dailyUsage))
+}
+
+// serveListing answers GET /athlete/activities: newest first, or oldest first from after
+// when it is given, as Strava does
+func (s *StravaSim) serveListing(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := queryInt(query.Get("page"), 1)
+	perPage := queryInt(query.Get("per_page"), stravaDefaultPerPage)
+	after, hasAfter := queryUnix(query.Get("after"))
+	before, hasBefore := queryUnix(query.Get("before"))
+	if page < 1 || perPage < 1 {
+		writeSimJSON(w, http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+		return
+	}
+
+	s.mu.Lock()
+	hook := s.scenario.BeforePage[page]
+	if s.pagesRun[page] {
+		hook = nil
+	}
+	s.pagesRun[page] = true
+	s.mu.Unlock()
+	if hook != nil {
+		hook(s)
+	}
+
+	s.mu.Lock()
+	var listed []SimActivity
+	for _, activity := range s.activities {
+		if (hasAfter && !activity.Start.After(after)) || (hasBefore && !activity.Start.Before(before)) {
+			continue
+		}
+		listed = append(listed, activity)
+	}
+	s.mu.Unlock()
+	slices.SortStableFunc(listed, func(a, b SimActivity) int {
+		if hasAfter {
+			return a.Start.Compare(b.Start)
+		}
+		return b.Start.Compare(a.Start)
+	})
+
+	size := perPage
+	if page <= len(s.scenario.PageSizes) && s.scenario.PageSizes[page-1] > 0 {
+		size = min(size, s.scenario.PageSizes[page-1])
+	}
+	from := min((page-1)*perPage, len(listed))
+	to := min(from+size, len(listed))
+	summaries := make([]map[string]interface{}, 0, to-from)
+	for _, activity := range listed[from:to] {
+		summaries = append(summaries, s.summary(activity))
+	}
+	writeSimJSON(w, http.StatusOK, summaries)
+}
+
+// findLocked returns the stored activity with id
+func (s *StravaSim) findLocked(id int64) (SimActivity, bool) {
+	for _, activity := range s.activities {
+		if activity.ID == id {
+			return activity, true
+		}
+	}
+	return SimActivity{}, false
+}
+
+// detail finds an activity for a detail request, answering the scripted failures
+func (s *StravaSim) detail(w http.ResponseWriter, id int64) (SimActivity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity, ok := s.findLocked(id)
+	if !ok || activity.Deleted {
+		writeSimJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+		return SimActivity{}, false
+	}
+	if s.failures[id] > 0 {
+		s.failures[id]--
+		writeSimJSON(w, http.StatusBadGateway, map[string]string{"message": "Bad Gateway"})
+		return SimActivity{}, false
+	}
+	return activity, true
+}
+
+func (s *StravaSim) serveActivity(w http.ResponseWriter, id int64) {
+	activity, ok := s.detail(w, id)
+	if !ok {
+		return
+	}
+	writeSimJSON(w, http.StatusOK, s.summary(activity))
+}
+
+// serveStreams answers the activity's streams, keyed by type when asked unless the
+// scenario answers lists
+func (s *StravaSim) serveStreams(w http.ResponseWriter, r *http.Request, id int64) {
+	s.mu.Lock()
+	activity, ok := s.findLocked(id)
+	s.mu.Unlock()
+	if !ok || activity.Deleted {
+		writeSimJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+		return
+	}
+	keys := strings.Split(r.URL.Query().Get("keys"), ",")
+	streams := SimStreams(activity, s.scenario.Resolution)
+	if r.URL.Query().Get("key_by_type") == "true" && !s.scenario.StreamsAsArray {
+		keyed := make(map[string]SimStream)
+		for _, stream := range streams {
+			if slices.Contains(keys, stream.Type) {
+				keyed[stream.Type] = stream
+			}
+		}
+		writeSimJSON(w, http.StatusOK, keyed)
+		return
+	}
+	list := make([]SimStream, 0, len(streams))
+	for _, stream := range streams {
+		if slices.Contains(keys, stream.Type) {
+			list = append(list, stream)
+		}
+	}
+	writeSimJSON(w, http.StatusOK, list)
+}
+
+// summary is the activity as Strava lists it and answers its details, without the streams
+func (s *StravaSim) summary(activity SimActivity) map[string]interface{} {
+	sportType := activity.SportType
+	if sportType == "" {
+		sportType = "Ride"
+	}
+	points := simPoints(activity)
+	return map[string]interface{}{
+		"id":           activity.ID,
+		"athlete":      map[string]int64{"id": s.scenario.AthleteID},
+		"name":         activity.Name,
+		"type":         sportType,
+		"sport_type":   sportType,
+		"start_date":   activity.Start.UTC().Format(time.RFC3339),
+		"distance":     float64(points-1) * simStepMeters,
+		"moving_time":  points - 1,
+		"elapsed_time": points - 1,
+	}
+}
+
+// SimStream is a stream as Strava answers it
+type SimStream struct {
+	Type         string        `json:"type"`
+	Data         []interface{} `json:"data"`
+	SeriesType   string        `json:"series_type"`
+	OriginalSize int           `json:"original_size"`
+	Resolution   string        `json:"resolution"`
+}
+
+// simStepMeters is the distance between consecutive points of a simulated recording
+const simStepMeters = 10.0
+
+func simPoints(activity SimActivity) int {
+	if activity.Points > 0 {
+		return activity.Points
+	}
+	return 10
+}
+
+// SimStreams returns the streams of the activity at resolution: one point a second
+// heading north from 44.8, 20.4, sampled down for low and medium
+func SimStreams(activity SimActivity, resolution string) []SimStream {
+	points := simPoints(activity)
+	indexes := make([]int, 0, points)
+	limit := points
+	switch resolution {
+	case ResolutionLow:
+		limit = min(points, 100)
+	case ResolutionMedium:
+		limit = min(points, 1000)
+	default:
+		resolution = ResolutionHigh
+	}
+	for i := 0; i < limit; i++ {
+		if limit == 1 {
+			indexes = append(indexes, 0)
+			break
+		}
+		indexes = append(indexes, int(math.Round(float64(i)*float64(points-1)/float64(limit-1))))
+	}
+
+	series := func(streamType string, value func(i int) interface{}) SimStream {
+		data := make([]interface{}, len(indexes))
+		for j, i := range indexes {
+			data[j] = value(i)
+		}
+		return SimStream{Type: streamType, Data: data, SeriesType: "distance", OriginalSize: points, Resolution: resolution}
+	}
+	return []SimStream{
+		series("time", func(i int) interface{} { return i }),
+		series("latlng", func(i int) interface{} {
+			return []float64{44.8 + float64(i)*simStepMeters/111_320, 20.4}
+		}),
+		series("distance", func(i int) interface{} { return float64(i) * simStepMeters }),
+		series("altitude", func(i int) interface{} { return 100 + float64(i%20) }),
+		series("heartrate", func(i int) interface{} { return 120 + i%40 }),
+		series("moving", func(i int) interface{} { return true }),
+	}
+}
+
+func queryInt(value string, fallback int) int {
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func queryUnix(value string) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+func writeSimJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,200 @@
+package testsupport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// simGet sends an authorized GET to the simulator and decodes a 200 answer into out
+func simGet(t *testing.T, sim *StravaSim, path string, out interface{}) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, sim.URL+path, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+	}
+	return resp
+}
+
+func listedIDs(t *testing.T, sim *StravaSim, path string) []int64 {
+	t.Helper()
+	var page []struct {
+		ID int64 `json:"id"`
+	}
+	if resp := simGet(t, sim, path, &page); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %d", path, resp.StatusCode)
+	}
+	ids := make([]int64, 0, len(page))
+	for _, activity := range page {
+		ids = append(ids, activity.ID)
+	}
+	return ids
+}
+
+func dailyRides(n int) []SimActivity {
+	start := time.Date(2026, 2, 1, 8, 0, 0, 0, time.UTC)
+	activities := make([]SimActivity, n)
+	for i := range activities {
+		activities[i] = SimActivity{ID: int64(i + 1), Start: start.AddDate(0, 0, i)}
+	}
+	return activities
+}
+
+func TestStravaSimListsPages(t *testing.T) {
+	sim := NewStravaSim(t, StravaScenario{Activities: dailyRides(5), PageSizes: []int{0, 1}})
+
+	if got := listedIDs(t, sim, "/athlete/activities?page=1&per_page=2"); !slices.Equal(got, []int64{5, 4}) {
+		t.Fatalf("newest first page 1 = %v, want [5 4]", got)
+	}
+	// The second page is cut short by the scenario
+	if got := listedIDs(t, sim, "/athlete/activities?page=2&per_page=2"); !slices.Equal(got, []int64{3}) {
+		t.Fatalf("short page 2 = %v, want [3]", got)
+	}
+	if got := listedIDs(t, sim, "/athlete/activities?page=4&per_page=2"); len(got) != 0 {
+		t.Fatalf("page past the end = %v, want none", got)
+	}
+
+	after := time.Date(2026, 2, 2, 12, 0, 0, 0, time.UTC).Unix()
+	got := listedIDs(t, sim, "/athlete/activities?page=1&per_page=10&after="+strconv.FormatInt(after, 10))
+	if !slices.Equal(got, []int64{3, 4, 5}) {
+		t.Fatalf("after = %v, want oldest first [3 4 5]", got)
+	}
+}
+
+func TestStravaSimUploadMidPaginationRepeatsAnActivity(t *testing.T) {
+	rides := dailyRides(4)
+	sim := NewStravaSim(t, StravaScenario{
+		Activities: rides,
+		BeforePage: map[int]func(*StravaSim){
+			2: func(s *StravaSim) {
+				s.Upload(SimActivity{ID: 9, Start: rides[3].Start.Add(time.Hour)})
+			},
+		},
+	})
+
+	first := listedIDs(t, sim, "/athlete/activities?page=1&per_page=2")
+	second := listedIDs(t, sim, "/athlete/activities?page=2&per_page=2")
+	if !slices.Equal(first, []int64{4, 3}) || !slices.Equal(second, []int64{3, 2}) {
+		t.Fatalf("pages = %v %v, want [4 3] [3 2] with 3 shifted onto the next page", first, second)
+	}
+	// The hook runs once, so asking again does not upload twice
+	listedIDs(t, sim, "/athlete/activities?page=2&per_page=2")
+	if got := listedIDs(t, sim, "/athlete/activities?per_page=10"); len(got) != 5 {
+		t.Fatalf("listing after the upload = %v, want 5 activities", got)
+	}
+}
+
+func TestStravaSimDetailFailuresAndDeletions(t *testing.T) {
+	sim := NewStravaSim(t, StravaScenario{Activities: []SimActivity{
+		{ID: 1, Failures: 2},
+		{ID: 2, Deleted: true},
+		{ID: 3},
+	}})
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		codes = append(codes, simGet(t, sim, "/activities/1", nil).StatusCode)
+	}
+	if !slices.Equal(codes, []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}) {
+		t.Fatalf("failing activity = %v, want two 502s then 200", codes)
+	}
+	if code := simGet(t, sim, "/activities/2", nil).StatusCode; code != http.StatusNotFound {
+		t.Fatalf("deleted activity = %d, want 404", code)
+	}
+	if code := simGet(t, sim, "/activities/2/streams?keys=time", nil).StatusCode; code != http.StatusNotFound {
+		t.Fatalf("deleted activity streams = %d, want 404", code)
+	}
+	sim.Delete(3)
+	if code := simGet(t, sim, "/activities/3", nil).StatusCode; code != http.StatusNotFound {
+		t.Fatalf("activity deleted later = %d, want 404", code)
+	}
+	if got := len(sim.RequestsTo("/activities/1")); got != 3 {
+		t.Fatalf("recorded %d requests of activity 1, want 3", got)
+	}
+}
+
+func TestStravaSimRateLimits(t *testing.T) {
+	sim := NewStravaSim(t, StravaScenario{ShortTermLimit: 2, DailyLimit: 100, ThrottledRequests: []int{1}})
+
+	resp := simGet(t, sim, "/athlete", nil)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("X-RateLimit-Usage") != "2,0" {
+		t.Fatalf("scripted 429 = %d usage %q, want 429 at the short-term limit", resp.StatusCode, resp.Header.Get("X-RateLimit-Usage"))
+	}
+	for i, want := range []string{"1,1", "2,2"} {
+		resp = simGet(t, sim, "/athlete", nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Usage") != want {
+			t.Fatalf("request %d = %d usage %q, want 200 with %q", i+2, resp.StatusCode, resp.Header.Get("X-RateLimit-Usage"), want)
+		}
+		if limit := resp.Header.Get("X-RateLimit-Limit"); limit != "2,100" {
+			t.Fatalf("limit = %q, want 2,100", limit)
+		}
+	}
+	if resp = simGet(t, sim, "/athlete", nil); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("past the limit = %d, want 429", resp.StatusCode)
+	}
+
+	// The window resets on the virtual clock
+	if err := sim.Sleep(context.Background(), 15*time.Minute); err != nil {
+		t.Fatalf("Sleep: %v", err)
+	}
+	if resp = simGet(t, sim, "/athlete", nil); resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Usage") != "1,3" {
+		t.Fatalf("after the reset = %d usage %q, want 200 with 1,3", resp.StatusCode, resp.Header.Get("X-RateLimit-Usage"))
+	}
+	if sleeps := sim.Sleeps(); !slices.Equal(sleeps, []time.Duration{15 * time.Minute}) {
+		t.Fatalf("sleeps = %v", sleeps)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := sim.Now()
+	if err := sim.Sleep(ctx, time.Hour); err == nil || !sim.Now().Equal(before) {
+		t.Fatalf("cancelled Sleep = %v, moved the clock to %v", err, sim.Now())
+	}
+}
+
+func TestStravaSimStreamVariants(t *testing.T) {
+	activities := []SimActivity{{ID: 1, Points: 500}}
+	const path = "/activities/1/streams?keys=time,latlng,watts&key_by_type=true"
+
+	keyed := NewStravaSim(t, StravaScenario{Activities: activities})
+	var byType map[string]SimStream
+	simGet(t, keyed, path, &byType)
+	if len(byType) != 2 || len(byType["latlng"].Data) != 500 || byType["time"].Resolution != ResolutionHigh {
+		t.Fatalf("keyed streams = %d types, %d points", len(byType), len(byType["latlng"].Data))
+	}
+
+	low := NewStravaSim(t, StravaScenario{Activities: activities, Resolution: ResolutionLow, StreamsAsArray: true})
+	var list []SimStream
+	simGet(t, low, path, &list)
+	if len(list) != 2 {
+		t.Fatalf("array streams = %+v, want time and latlng", list)
+	}
+	for _, stream := range list {
+		if len(stream.Data) != 100 || stream.OriginalSize != 500 || stream.Resolution != ResolutionLow {
+			t.Fatalf("low %s stream = %d of %d points at %q", stream.Type, len(stream.Data), stream.OriginalSize, stream.Resolution)
+		}
+	}
+	// Downsampling keeps both ends of the recording
+	times := list[0].Data
+	if list[0].Type != "time" {
+		times = list[1].Data
+	}
+	if times[0] != float64(0) || times[len(times)-1] != float64(499) {
+		t.Fatalf("low time stream runs %v to %v, want 0 to 499", times[0], times[len(times)-1])
+	}
+}
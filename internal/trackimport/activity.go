@@ -0,0 +1,176 @@
+package trackimport
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"b11k/internal/geo"
+	"b11k/internal/strava"
+)
+
+// movingSpeedThreshold is the speed in m/s below which an interval counts as stopped
+const movingSpeedThreshold = 1.0
+
+// elevationGainThreshold is how far in meters altitude must rise above the last low point
+// before the climb counts, so GPS and barometer noise does not add up to phantom gain
+const elevationGainThreshold = 2.0
+
+// Activity converts the track into an activity with the given IDs. Streams are filled from
+// the points and the summary (distance, moving and elapsed time, elevation gain, speeds,
+// heart rate, cadence and power) is computed from them. Readings missing on some points
+// repeat the nearest earlier reading so every stream stays aligned with the time stream.
+func (t *Track) Activity(athleteID, activityID int64) *strava.BikeActivity {
+	activity := &strava.BikeActivity{}
+	points := t.Points
+	n := len(points)
+
+	var distance, movingTime, maxSpeed float64
+	for i, p := range points {
+		activity.TimeStream.Data = append(activity.TimeStream.Data, p.Time)
+		activity.LatLngStream.Data = append(activity.LatLngStream.Data, []float64{p.Lat, p.Lng})
+
+		speed := 0.0
+		moving := false
+		if i > 0 {
+			prev := points[i-1]
+			step := geo.HaversineMeters(prev.Lat, prev.Lng, p.Lat, p.Lng)
+			distance += step
+			if dt := p.Time.Sub(prev.Time).Seconds(); dt > 0 {
+				speed = step / dt
+				if speed >= movingSpeedThreshold {
+					moving = true
+					movingTime += dt
+				}
+			}
+		}
+		maxSpeed = math.Max(maxSpeed, speed)
+		activity.DistanceStream.Data = append(activity.DistanceStream.Data, distance)
+		activity.SpeedStream.Data = append(activity.SpeedStream.Data, speed)
+		activity.MovingStream.Data = append(activity.MovingStream.Data, moving)
+	}
+
+	activity.AltitudeStream.Data = alignedFloats(points, func(p Point) *float64 { return p.Altitude })
+	activity.HeartrateStream.Data = alignedInts(points, func(p Point) *int { return p.Heartrate })
+	activity.CadenceStream.Data = alignedInts(points, func(p Point) *int { return p.Cadence })
+	activity.WattsStream.Data = alignedInts(points, func(p Point) *int { return p.Watts })
+
+	start, end := points[0], points[n-1]
+	elapsed := end.Time.Sub(start.Time).Seconds()
+	summary := &activity.Summary
+	summary.ID = activityID
+	summary.AthleteID = athleteID
+	summary.Name = t.Name
+	summary.Type = activityType(t.Sport)
+	summary.SportType = summary.Type
+	summary.StartDateTime = start.Time.UTC()
+	summary.StartDate = summary.StartDateTime.Format(time.RFC3339)
+	_, offset := start.Time.Zone()
+	summary.UtcOffset = float64(offset)
+	summary.StartLatLng = &[]float64{start.Lat, start.Lng}
+	summary.EndLatLng = &[]float64{end.Lat, end.Lng}
+	summary.Distance = distance
+	summary.MovingTime = movingTime
+	summary.ElapsedTime = elapsed
+	summary.TotalElevationGain = elevationGain(activity.AltitudeStream.Data)
+	summary.MaxSpeed = maxSpeed
+	if movingTime > 0 {
+		summary.AverageSpeed = distance / movingTime
+	}
+	summary.AverageHeartrate, summary.MaxHeartrate = averageAndMax(points, func(p Point) *int { return p.Heartrate })
+	summary.AverageCadence, _ = averageAndMax(points, func(p Point) *int { return p.Cadence })
+	summary.AverageWatts, summary.MaxWatts = averageAndMax(points, func(p Point) *int { return p.Watts })
+	return activity
+}
+
+// activityType maps the sport recorded in the file to a Strava activity type
+func activityType(sport string) string {
+	switch strings.ToLower(strings.TrimSpace(sport)) {
+	case "running", "run", "trail_running":
+		return "Run"
+	case "walking", "walk", "hiking", "hike":
+		return "Walk"
+	default:
+		return "Ride"
+	}
+}
+
+// alignedFloats returns one value per point, or nil when no point has a reading
+func alignedFloats(points []Point, reading func(Point) *float64) []float64 {
+	first := -1
+	for i, p := range points {
+		if reading(p) != nil {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	values := make([]float64, len(points))
+	last := *reading(points[first])
+	for i, p := range points {
+		if v := reading(p); v != nil {
+			last = *v
+		}
+		values[i] = last
+	}
+	return values
+}
+
+// alignedInts returns one value per point, or nil when no point has a reading
+func alignedInts(points []Point, reading func(Point) *int) []int {
+	floats := alignedFloats(points, func(p Point) *float64 {
+		if v := reading(p); v != nil {
+			f := float64(*v)
+			return &f
+		}
+		return nil
+	})
+	if floats == nil {
+		return nil
+	}
+	values := make([]int, len(floats))
+	for i, f := range floats {
+		values[i] = int(f)
+	}
+	return values
+}
+
+// averageAndMax averages the positive readings; zeros are coasting or sensor dropouts
+func averageAndMax(points []Point, reading func(Point) *int) (float64, float64) {
+	var sum, maxValue float64
+	count := 0
+	for _, p := range points {
+		v := reading(p)
+		if v == nil || *v <= 0 {
+			continue
+		}
+		sum += float64(*v)
+		count++
+		maxValue = math.Max(maxValue, float64(*v))
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return sum / float64(count), maxValue
+}
+
+// elevationGain adds up climbs of at least elevationGainThreshold above the last low point
+func elevationGain(altitudes []float64) float64 {
+	if len(altitudes) == 0 {
+		return 0
+	}
+	gain := 0.0
+	low := altitudes[0]
+	for _, altitude := range altitudes[1:] {
+		switch {
+		case altitude < low:
+			low = altitude
+		case altitude-low >= elevationGainThreshold:
+			gain += altitude - low
+			low = altitude
+		}
+	}
+	return gain
+}
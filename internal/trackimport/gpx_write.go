@@ -0,0 +1,141 @@
+package trackimport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// GPX 1.1 namespace and schema, as loaded by Garmin and Wahoo head units
+const (
+	gpxNamespace      = "http://www.topografix.com/GPX/1/1"
+	gpxSchemaLocation = gpxNamespace + " http://www.topografix.com/GPX/1/1/gpx.xsd"
+	xsiNamespace      = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// gpxCoordinateDecimals caps coordinate precision: 6 decimals is about 0.1 m, finer than
+// any GPS fix, and keeps files small
+const gpxCoordinateDecimals = 6
+
+// gpxDocument is the subset of GPX 1.1 WriteGPX emits, fields in schema order
+type gpxDocument struct {
+	XMLName        xml.Name     `xml:"gpx"`
+	Version        string       `xml:"version,attr"`
+	Creator        string       `xml:"creator,attr"`
+	Xmlns          string       `xml:"xmlns,attr"`
+	XmlnsXsi       string       `xml:"xmlns:xsi,attr"`
+	SchemaLocation string       `xml:"xsi:schemaLocation,attr"`
+	Metadata       *gpxMetadata `xml:"metadata,omitempty"`
+	Routes         []gpxRoute   `xml:"rte"`
+	Tracks         []gpxTrack   `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+type gpxRoute struct {
+	Name   string     `xml:"name,omitempty"`
+	Points []gpxPoint `xml:"rtept"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Type     string       `xml:"type,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Ele  string `xml:"ele,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+// GPXOptions shapes the file WriteGPX produces
+type GPXOptions struct {
+	// Creator names the program in the gpx element; GPX requires one
+	Creator string
+	// Name, when set, names the whole file in its metadata
+	Name string
+	// AsTrack writes each track as a <trk> with one segment instead of a <rte>
+	AsTrack bool
+	// Time stamps the metadata; zero leaves it out
+	Time time.Time
+}
+
+// WriteGPX writes the tracks as one GPX 1.1 document, each a route (or a track with
+// options.AsTrack) named after it. Points carry <ele> when they have an altitude and
+// <time> when they have a time; coordinates are rounded to 6 decimals.
+func WriteGPX(w io.Writer, tracks []Track, options GPXOptions) error {
+	creator := options.Creator
+	if creator == "" {
+		creator = "b11k"
+	}
+	doc := gpxDocument{
+		Version:        "1.1",
+		Creator:        creator,
+		Xmlns:          gpxNamespace,
+		XmlnsXsi:       xsiNamespace,
+		SchemaLocation: gpxSchemaLocation,
+	}
+	if options.Name != "" || !options.Time.IsZero() {
+		doc.Metadata = &gpxMetadata{Name: options.Name}
+		if !options.Time.IsZero() {
+			doc.Metadata.Time = options.Time.UTC().Format(time.RFC3339)
+		}
+	}
+	for _, track := range tracks {
+		points := make([]gpxPoint, 0, len(track.Points))
+		for _, p := range track.Points {
+			if math.IsNaN(p.Lat) || math.IsNaN(p.Lng) || p.Lat < -90 || p.Lat > 90 || p.Lng < -180 || p.Lng >= 180 {
+				return fmt.Errorf("point %.6f,%.6f of %q is outside GPX coordinate bounds", p.Lat, p.Lng, track.Name)
+			}
+			point := gpxPoint{Lat: formatGPXCoordinate(p.Lat), Lon: formatGPXCoordinate(p.Lng)}
+			if p.Altitude != nil && !math.IsNaN(*p.Altitude) && !math.IsInf(*p.Altitude, 0) {
+				point.Ele = strconv.FormatFloat(math.Round(*p.Altitude*10)/10, 'f', -1, 64)
+			}
+			if !p.Time.IsZero() {
+				point.Time = p.Time.UTC().Format(time.RFC3339)
+			}
+			points = append(points, point)
+		}
+		if options.AsTrack {
+			doc.Tracks = append(doc.Tracks, gpxTrack{Name: track.Name, Type: track.Sport, Segments: []gpxSegment{{Points: points}}})
+		} else {
+			doc.Routes = append(doc.Routes, gpxRoute{Name: track.Name, Points: points})
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write GPX: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write GPX: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write GPX: %w", err)
+	}
+	return nil
+}
+
+// formatGPXCoordinate writes a coordinate with at most gpxCoordinateDecimals decimals and
+// no trailing zeros
+func formatGPXCoordinate(degrees float64) string {
+	scale := math.Pow10(gpxCoordinateDecimals)
+	rounded := math.Round(degrees*scale) / scale
+	if rounded == 0 {
+		rounded = 0 // no "-0"
+	}
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
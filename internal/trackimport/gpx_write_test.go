@@ -0,0 +1,207 @@
+package trackimport
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gpxChildOrder is the element order GPX 1.1 requires for the children WriteGPX emits
+var gpxChildOrder = map[string][]string{
+	"gpx":      {"metadata", "rte", "trk"},
+	"metadata": {"name", "time"},
+	"rte":      {"name", "rtept"},
+	"trk":      {"name", "type", "trkseg"},
+	"trkseg":   {"trkpt"},
+	"rtept":    {"ele", "time"},
+	"trkpt":    {"ele", "time"},
+}
+
+// validateGPX checks a document against the parts of the GPX 1.1 schema WriteGPX uses:
+// the namespace and required attributes, child element order, coordinate ranges and the
+// xsd:decimal and xsd:dateTime value types
+func validateGPX(t *testing.T, data []byte) {
+	t.Helper()
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	type open struct {
+		name string
+		next int // index in gpxChildOrder of the earliest child still allowed
+	}
+	var stack []*open
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("GPX is not well-formed: %v", err)
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			if tok.Name.Space != "http://www.topografix.com/GPX/1/1" {
+				t.Fatalf("<%s> is in namespace %q", name, tok.Name.Space)
+			}
+			attrs := make(map[string]string)
+			for _, attr := range tok.Attr {
+				attrs[attr.Name.Local] = attr.Value
+			}
+			if len(stack) == 0 {
+				if name != "gpx" || attrs["version"] != "1.1" || attrs["creator"] == "" || !strings.Contains(attrs["schemaLocation"], "gpx.xsd") {
+					t.Fatalf("root <%s> attributes %v, want gpx version 1.1 with a creator", name, attrs)
+				}
+			} else {
+				parent := stack[len(stack)-1]
+				order := gpxChildOrder[parent.name]
+				index := -1
+				for i := parent.next; i < len(order); i++ {
+					if order[i] == name {
+						index = i
+						break
+					}
+				}
+				if index < 0 {
+					t.Fatalf("<%s> not allowed at this position in <%s>", name, parent.name)
+				}
+				parent.next = index
+				if order[index] == "name" || order[index] == "time" || order[index] == "ele" || order[index] == "type" {
+					parent.next = index + 1 // at most one
+				}
+			}
+			if name == "rtept" || name == "trkpt" {
+				checkGPXCoordinate(t, attrs["lat"], -90, 90, true)
+				checkGPXCoordinate(t, attrs["lon"], -180, 180, false)
+			}
+			stack = append(stack, &open{name: name})
+			text.Reset()
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			switch tok.Name.Local {
+			case "ele":
+				if _, err := strconv.ParseFloat(value, 64); err != nil || strings.ContainsAny(value, "eE") {
+					t.Fatalf("<ele>%s</ele> is not an xsd:decimal", value)
+				}
+			case "time":
+				if _, err := time.Parse(time.RFC3339, value); err != nil {
+					t.Fatalf("<time>%s</time> is not an xsd:dateTime", value)
+				}
+			}
+			stack = stack[:len(stack)-1]
+			text.Reset()
+		}
+	}
+}
+
+func checkGPXCoordinate(t *testing.T, value string, min, max float64, maxInclusive bool) {
+	t.Helper()
+	degrees, err := strconv.ParseFloat(value, 64)
+	if err != nil || strings.ContainsAny(value, "eE") {
+		t.Fatalf("coordinate %q is not an xsd:decimal", value)
+	}
+	if degrees < min || degrees > max || (!maxInclusive && degrees == max) {
+		t.Fatalf("coordinate %s outside [%v, %v]", value, min, max)
+	}
+	if dot := strings.IndexByte(value, '.'); dot >= 0 && len(value)-dot-1 > gpxCoordinateDecimals {
+		t.Fatalf("coordinate %s has more than %d decimals", value, gpxCoordinateDecimals)
+	}
+}
+
+func TestWriteGPXRoutesValidate(t *testing.T) {
+	ele := 312.345
+	tracks := []Track{
+		{Name: `Col & "Climb" <north>`, Points: []Point{
+			{Lat: 45.123456789, Lng: 6.987654321, Altitude: &ele},
+			{Lat: -0.0000001, Lng: -179.9999999},
+			{Lat: 90, Lng: 0},
+		}},
+		{Name: "Second", Points: []Point{{Lat: 1, Lng: 2}, {Lat: 1.5, Lng: 2.5}}},
+	}
+	var buf bytes.Buffer
+	if err := WriteGPX(&buf, tracks, GPXOptions{Name: "Segments", Time: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("WriteGPX: %v", err)
+	}
+	validateGPX(t, buf.Bytes())
+	out := buf.String()
+	for _, want := range []string{
+		`<rtept lat="45.123457" lon="6.987654">`,
+		`<ele>312.3</ele>`,
+		`<rtept lat="0" lon="-180">`,
+		`<name>Col &amp; &#34;Climb&#34; &lt;north&gt;</name>`,
+		`xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("GPX lacks %s:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "<rte>") != 2 || strings.Contains(out, "<trk>") {
+		t.Fatalf("want two routes and no tracks:\n%s", out)
+	}
+}
+
+func TestWriteGPXTracksRoundTrip(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	ele := 100.0
+	track := Track{Name: "Morning", Sport: "cycling", Points: []Point{
+		{Time: start, Lat: 45.5, Lng: 7.25, Altitude: &ele},
+		{Time: start.Add(time.Second), Lat: 45.500001, Lng: 7.250001},
+	}}
+	var buf bytes.Buffer
+	if err := WriteGPX(&buf, []Track{track}, GPXOptions{AsTrack: true}); err != nil {
+		t.Fatalf("WriteGPX: %v", err)
+	}
+	validateGPX(t, buf.Bytes())
+	parsed, err := Parse("morning.gpx", buf.Bytes())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if parsed.Name != "Morning" || parsed.Sport != "cycling" || len(parsed.Points) != 2 {
+		t.Fatalf("parsed = %+v", parsed)
+	}
+	if !parsed.Points[1].Time.Equal(start.Add(time.Second)) || parsed.Points[0].Altitude == nil || *parsed.Points[0].Altitude != 100 {
+		t.Fatalf("points = %+v", parsed.Points)
+	}
+}
+
+func TestParseGPXPathsReadsExportedRoutesAndTracks(t *testing.T) {
+	ele := 12.0
+	routes := []Track{
+		{Name: "Climb", Points: []Point{{Lat: 45.1, Lng: 6.9, Altitude: &ele}, {Lat: 45.2, Lng: 6.95}}},
+		{Name: "Sprint", Points: []Point{{Lat: 1, Lng: 2}, {Lat: 1.5, Lng: 2.5}, {Lat: 2, Lng: 3}}},
+	}
+	var buf bytes.Buffer
+	if err := WriteGPX(&buf, routes, GPXOptions{}); err != nil {
+		t.Fatalf("WriteGPX: %v", err)
+	}
+	paths, err := ParseGPXPaths(buf.Bytes())
+	if err != nil || len(paths) != 2 || paths[0].Name != "Climb" || len(paths[1].Points) != 3 {
+		t.Fatalf("paths = %+v, %v", paths, err)
+	}
+	if paths[0].Points[0].Altitude == nil || *paths[0].Points[0].Altitude != 12 || paths[0].Points[1].Altitude != nil {
+		t.Fatalf("points = %+v", paths[0].Points)
+	}
+
+	// Untimed tracks count too, one path per track with its segments joined
+	paths, err = ParseGPXPaths([]byte(`<gpx><trk><name>A</name><trkseg><trkpt lat="1" lon="2"/></trkseg>` +
+		`<trkseg><trkpt lat="1.1" lon="2"/></trkseg></trk><trk><name>B</name><trkseg><trkpt lat="3" lon="4"/></trkseg></trk></gpx>`))
+	if err != nil || len(paths) != 2 || len(paths[0].Points) != 2 || len(paths[1].Points) != 1 {
+		t.Fatalf("track paths = %+v, %v", paths, err)
+	}
+	if _, err := ParseGPXPaths([]byte("{}")); err == nil {
+		t.Fatal("JSON parsed as GPX")
+	}
+}
+
+func TestWriteGPXRejectsOutOfRangePoints(t *testing.T) {
+	for _, p := range []Point{{Lat: 90.5, Lng: 0}, {Lat: 0, Lng: 180}} {
+		if err := WriteGPX(io.Discard, []Track{{Name: "bad", Points: []Point{p}}}, GPXOptions{}); err == nil {
+			t.Fatalf("WriteGPX accepted %+v", p)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package trackimport turns GPX and TCX files recorded by head units into activities that
+// go through the same insert pipeline as activities synced from Strava, and writes GPX
+// files for loading routes back onto them.
+package trackimport
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Supported file formats
+const (
+	FormatGPX = "gpx"
+	FormatTCX = "tcx"
+)
+
+// Point is one recorded track point. Optional readings are nil when the file has none.
+type Point struct {
+	Time      time.Time
+	Lat       float64
+	Lng       float64
+	Altitude  *float64
+	Heartrate *int
+	Cadence   *int
+	Watts     *int
+}
+
+// Track is a parsed file: its name, sport as recorded in the file and points in order
+type Track struct {
+	Name   string
+	Sport  string
+	Points []Point
+}
+
+// Parse reads a GPX or TCX file. The format comes from the file extension, or from the
+// root element when the extension is unknown. Points without a position or time are
+// skipped; a track needs at least two usable points.
+func Parse(filename string, data []byte) (*Track, error) {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+	if format != FormatGPX && format != FormatTCX {
+		format = sniffFormat(data)
+	}
+
+	var track *Track
+	var err error
+	switch format {
+	case FormatGPX:
+		track, err = parseGPX(data)
+	case FormatTCX:
+		track, err = parseTCX(data)
+	default:
+		return nil, fmt.Errorf("unsupported file format for %q: expected .gpx or .tcx", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(track.Points) < 2 {
+		return nil, fmt.Errorf("%q has %d timed track points, need at least 2", filename, len(track.Points))
+	}
+	if track.Name == "" {
+		track.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	return track, nil
+}
+
+// sniffFormat returns the format named by the document's root element, or ""
+func sniffFormat(data []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "gpx":
+				return FormatGPX
+			case "TrainingCenterDatabase":
+				return FormatTCX
+			}
+			return ""
+		}
+	}
+}
+
+// GPX 1.1 with the Garmin TrackPointExtension for heart rate and cadence. Element names
+// are matched without namespaces, so gpxtpx: and ns3: prefixes both work.
+type gpxFile struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Type     string `xml:"type"`
+		Segments []struct {
+			Points []struct {
+				Lat        float64  `xml:"lat,attr"`
+				Lon        float64  `xml:"lon,attr"`
+				Ele        *float64 `xml:"ele"`
+				Time       string   `xml:"time"`
+				Extensions struct {
+					Power     *int `xml:"power"`
+					Extension struct {
+						HR    *int `xml:"hr"`
+						Cad   *int `xml:"cad"`
+						Power *int `xml:"power"`
+					} `xml:"TrackPointExtension"`
+				} `xml:"extensions"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string `xml:"name"`
+		Points []struct {
+			Lat float64  `xml:"lat,attr"`
+			Lon float64  `xml:"lon,attr"`
+			Ele *float64 `xml:"ele"`
+		} `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+func parseGPX(data []byte) (*Track, error) {
+	var file gpxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse GPX: %w", err)
+	}
+	track := &Track{Name: strings.TrimSpace(file.Metadata.Name)}
+	for _, trk := range file.Tracks {
+		if track.Name == "" {
+			track.Name = strings.TrimSpace(trk.Name)
+		}
+		if track.Sport == "" {
+			track.Sport = strings.TrimSpace(trk.Type)
+		}
+		for _, seg := range trk.Segments {
+			for _, p := range seg.Points {
+				t, err := parseTime(p.Time)
+				if err != nil {
+					continue
+				}
+				watts := p.Extensions.Extension.Power
+				if watts == nil {
+					watts = p.Extensions.Power
+				}
+				track.Points = append(track.Points, Point{
+					Time:      t,
+					Lat:       p.Lat,
+					Lng:       p.Lon,
+					Altitude:  p.Ele,
+					Heartrate: p.Extensions.Extension.HR,
+					Cadence:   p.Extensions.Extension.Cad,
+					Watts:     watts,
+				})
+			}
+		}
+	}
+	return track, nil
+}
+
+// ParseGPXPaths reads every track and route of a GPX file as a path of its own, in file
+// order, such as the segments WriteGPX exports. Unlike Parse, points need no time, and a
+// track's segments are joined. Paths are returned whatever their length; callers decide
+// how many points they need.
+func ParseGPXPaths(data []byte) ([]Track, error) {
+	var file gpxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse GPX: %w", err)
+	}
+	var paths []Track
+	for _, trk := range file.Tracks {
+		path := Track{Name: strings.TrimSpace(trk.Name), Sport: strings.TrimSpace(trk.Type)}
+		for _, seg := range trk.Segments {
+			for _, p := range seg.Points {
+				t, _ := parseTime(p.Time)
+				path.Points = append(path.Points, Point{Time: t, Lat: p.Lat, Lng: p.Lon, Altitude: p.Ele})
+			}
+		}
+		paths = append(paths, path)
+	}
+	for _, rte := range file.Routes {
+		path := Track{Name: strings.TrimSpace(rte.Name)}
+		for _, p := range rte.Points {
+			path.Points = append(path.Points, Point{Lat: p.Lat, Lng: p.Lon, Altitude: p.Ele})
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// TCX as written by Garmin and Wahoo, with the ActivityExtension for power
+type tcxFile struct {
+	Activities []struct {
+		Sport string `xml:"Sport,attr"`
+		Notes string `xml:"Notes"`
+		Laps  []struct {
+			Trackpoints []struct {
+				Time     string `xml:"Time"`
+				Position *struct {
+					Lat float64 `xml:"LatitudeDegrees"`
+					Lng float64 `xml:"LongitudeDegrees"`
+				} `xml:"Position"`
+				Altitude  *float64 `xml:"AltitudeMeters"`
+				Heartrate *struct {
+					Value int `xml:"Value"`
+				} `xml:"HeartRateBpm"`
+				Cadence    *int `xml:"Cadence"`
+				Extensions struct {
+					TPX struct {
+						Watts *int `xml:"Watts"`
+					} `xml:"TPX"`
+				} `xml:"Extensions"`
+			} `xml:"Track>Trackpoint"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+func parseTCX(data []byte) (*Track, error) {
+	var file tcxFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse TCX: %w", err)
+	}
+	track := &Track{}
+	for _, activity := range file.Activities {
+		if track.Sport == "" {
+			track.Sport = activity.Sport
+		}
+		if track.Name == "" {
+			track.Name = strings.TrimSpace(activity.Notes)
+		}
+		for _, lap := range activity.Laps {
+			for _, p := range lap.Trackpoints {
+				if p.Position == nil {
+					continue
+				}
+				t, err := parseTime(p.Time)
+				if err != nil {
+					continue
+				}
+				point := Point{
+					Time:     t,
+					Lat:      p.Position.Lat,
+					Lng:      p.Position.Lng,
+					Altitude: p.Altitude,
+					Cadence:  p.Cadence,
+					Watts:    p.Extensions.TPX.Watts,
+				}
+				if p.Heartrate != nil {
+					hr := p.Heartrate.Value
+					point.Heartrate = &hr
+				}
+				track.Points = append(track.Points, point)
+			}
+		}
+	}
+	return track, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339, strings.TrimSpace(value))
+}
@@ -0,0 +1,147 @@
+package trackimport
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="ELEMNT" xmlns="http://www.topografix.com/GPX/1/1"
+  xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1">
+  <trk>
+    <name>Morning loop</name>
+    <type>cycling</type>
+    <trkseg>
+      <trkpt lat="52.0000" lon="4.0000"><ele>10</ele><time>2024-05-01T08:00:00Z</time>
+        <extensions><gpxtpx:TrackPointExtension><gpxtpx:hr>120</gpxtpx:hr><gpxtpx:cad>80</gpxtpx:cad></gpxtpx:TrackPointExtension></extensions></trkpt>
+      <trkpt lat="52.0009" lon="4.0000"><ele>13</ele><time>2024-05-01T08:00:20Z</time>
+        <extensions><gpxtpx:TrackPointExtension><gpxtpx:hr>140</gpxtpx:hr><gpxtpx:cad>90</gpxtpx:cad></gpxtpx:TrackPointExtension></extensions></trkpt>
+      <trkpt lat="52.0009" lon="4.0000"><ele>13</ele><time>2024-05-01T08:01:20Z</time></trkpt>
+      <trkpt lat="52.0018" lon="4.0000"><ele>12</ele><time>2024-05-01T08:01:40Z</time>
+        <extensions><gpxtpx:TrackPointExtension><gpxtpx:hr>160</gpxtpx:hr></gpxtpx:TrackPointExtension></extensions></trkpt>
+      <trkpt lat="52.0030" lon="4.0000"><ele>11</ele></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+const testTCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+  xmlns:ns3="http://www.garmin.com/xmlschemas/ActivityExtension/v2">
+  <Activities>
+    <Activity Sport="Biking">
+      <Lap StartTime="2024-05-02T07:00:00Z">
+        <Track>
+          <Trackpoint><Time>2024-05-02T07:00:00Z</Time>
+            <Position><LatitudeDegrees>45.0</LatitudeDegrees><LongitudeDegrees>7.0</LongitudeDegrees></Position>
+            <AltitudeMeters>300</AltitudeMeters><HeartRateBpm><Value>110</Value></HeartRateBpm><Cadence>85</Cadence>
+            <Extensions><ns3:TPX><ns3:Watts>200</ns3:Watts></ns3:TPX></Extensions></Trackpoint>
+          <Trackpoint><Time>2024-05-02T07:00:01Z</Time><HeartRateBpm><Value>111</Value></HeartRateBpm></Trackpoint>
+          <Trackpoint><Time>2024-05-02T07:00:10Z</Time>
+            <Position><LatitudeDegrees>45.001</LatitudeDegrees><LongitudeDegrees>7.0</LongitudeDegrees></Position>
+            <AltitudeMeters>305</AltitudeMeters><HeartRateBpm><Value>130</Value></HeartRateBpm><Cadence>90</Cadence>
+            <Extensions><ns3:TPX><ns3:Watts>300</ns3:Watts></ns3:TPX></Extensions></Trackpoint>
+        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>`
+
+func TestParseGPX(t *testing.T) {
+	track, err := Parse("ride.gpx", []byte(testGPX))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if track.Name != "Morning loop" || track.Sport != "cycling" {
+		t.Fatalf("track = %q/%q, want Morning loop/cycling", track.Name, track.Sport)
+	}
+	// The last point has no time and is skipped
+	if len(track.Points) != 4 {
+		t.Fatalf("points = %d, want 4", len(track.Points))
+	}
+	first := track.Points[0]
+	if first.Heartrate == nil || *first.Heartrate != 120 || first.Cadence == nil || *first.Cadence != 80 {
+		t.Fatalf("first point = %+v, want hr 120 and cadence 80 from the extension", first)
+	}
+	if track.Points[2].Heartrate != nil {
+		t.Fatalf("third point heart rate = %v, want none", *track.Points[2].Heartrate)
+	}
+}
+
+func TestParseTCX(t *testing.T) {
+	track, err := Parse("ride.tcx", []byte(testTCX))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if track.Sport != "Biking" || track.Name != "ride" {
+		t.Fatalf("track = %q/%q, want the file name and Biking", track.Name, track.Sport)
+	}
+	// The trackpoint without a position is skipped
+	if len(track.Points) != 2 {
+		t.Fatalf("points = %d, want 2", len(track.Points))
+	}
+	last := track.Points[1]
+	if last.Watts == nil || *last.Watts != 300 || last.Altitude == nil || *last.Altitude != 305 {
+		t.Fatalf("last point = %+v, want 300 W at 305 m", last)
+	}
+}
+
+func TestParseDetectsFormatFromContent(t *testing.T) {
+	track, err := Parse("upload.xml", []byte(testTCX))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(track.Points) != 2 {
+		t.Fatalf("points = %d, want 2", len(track.Points))
+	}
+	if _, err := Parse("notes.txt", []byte("hello")); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Fatalf("Parse(notes.txt) error = %v, want unsupported format", err)
+	}
+}
+
+func TestTrackActivitySummary(t *testing.T) {
+	track, err := Parse("ride.gpx", []byte(testGPX))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	activity := track.Activity(42, 7_000_000_000_000_001)
+	summary := activity.Summary
+
+	if summary.ID != 7_000_000_000_000_001 || summary.AthleteID != 42 || summary.Type != "Ride" {
+		t.Fatalf("summary = %+v, want the given IDs and type Ride", summary)
+	}
+	if summary.StartDate != "2024-05-01T08:00:00Z" || summary.StartDateTime.IsZero() {
+		t.Fatalf("start = %q, want 2024-05-01T08:00:00Z", summary.StartDate)
+	}
+	// Two legs of 0.0009° latitude (~100 m each) in 20 s; the minute at the lights is not moving
+	if math.Abs(summary.Distance-200.1) > 0.5 {
+		t.Fatalf("distance = %.1f, want ~200 m", summary.Distance)
+	}
+	if summary.MovingTime != 40 || summary.ElapsedTime != 100 {
+		t.Fatalf("moving/elapsed = %v/%v, want 40/100", summary.MovingTime, summary.ElapsedTime)
+	}
+	if math.Abs(summary.AverageSpeed-summary.Distance/40) > 1e-9 {
+		t.Fatalf("average speed = %v, want distance over moving time", summary.AverageSpeed)
+	}
+	if summary.TotalElevationGain != 3 {
+		t.Fatalf("elevation gain = %v, want 3", summary.TotalElevationGain)
+	}
+	if summary.AverageHeartrate != 140 || summary.MaxHeartrate != 160 {
+		t.Fatalf("heart rate avg/max = %v/%v, want 140/160", summary.AverageHeartrate, summary.MaxHeartrate)
+	}
+
+	// Every stream lines up with the time stream; the missing reading repeats the previous one
+	n := len(activity.TimeStream.Data)
+	if len(activity.LatLngStream.Data) != n || len(activity.HeartrateStream.Data) != n || len(activity.DistanceStream.Data) != n {
+		t.Fatalf("stream lengths differ from the %d timestamps", n)
+	}
+	if activity.HeartrateStream.Data[2] != 140 {
+		t.Fatalf("heart rate stream = %v, want the gap filled with 140", activity.HeartrateStream.Data)
+	}
+	if activity.MovingStream.Data[2] {
+		t.Fatal("the stationary minute should not be moving")
+	}
+	if activity.WattsStream.Data != nil {
+		t.Fatalf("watts stream = %v, want none for a file without power", activity.WattsStream.Data)
+	}
+}
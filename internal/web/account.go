@@ -0,0 +1,487 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const accountDeletionCheckInterval = time.Hour
+
+// exportedSession describes a stored mobile session without its secrets
+type exportedSession struct {
+	CreatedAt        *time.Time `json:"created_at,omitempty"`
+	LastSeenAt       *time.Time `json:"last_seen_at,omitempty"`
+	SessionExpiresAt time.Time  `json:"session_expires_at"`
+}
+
+// exportedWebSession describes a web login without its token key; the Strava tokens
+// stored with it are not exported
+type exportedWebSession struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// exportedProfile is the Strava profile prefetched after login; its gear is exported
+// with the rest of the athlete's gear
+type exportedProfile struct {
+	Athlete      strava.Athlete         `json:"athlete"`
+	HRZones      *strava.HeartRateZones `json:"hr_zones"`
+	PrefetchedAt time.Time              `json:"prefetched_at"`
+}
+
+// athleteExport is everything B11K stores about one athlete, except point samples
+type athleteExport struct {
+	ExportedAt      time.Time                     `json:"exported_at"`
+	Athlete         *strava.Athlete               `json:"athlete"`
+	Profile         *exportedProfile              `json:"athlete_profile"`
+	DeletionRequest *pggeo.AccountDeletionRequest `json:"deletion_request"`
+	Settings        *pggeo.AthleteSettings        `json:"settings"`
+	Sessions        []exportedSession             `json:"sessions"`
+	WebSessions     []exportedWebSession          `json:"web_sessions"`
+	Segments        []pggeo.FavoriteSegment       `json:"segments"`
+	Tags            []pggeo.ActivityTag           `json:"tags"`
+	Gear            []pggeo.Gear                  `json:"gear"`
+	ShareLinks      []pggeo.PublicStatsToken      `json:"share_links"` // metadata only, the tokens are not stored
+	ImportFiles     []pggeo.ImportFile            `json:"import_files"`
+	AuditEvents     []pggeo.AccountEvent          `json:"audit_events"`
+	Activities      []strava.ActivitySummary      `json:"activities"`
+}
+
+// exportRecord is one NDJSON line of an export that includes point samples
+type exportRecord struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// handleMeExport handles GET /api/me/export
+func (s *server) handleMeExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	export := athleteExport{ExportedAt: time.Now().UTC(), Athlete: scope.Athlete}
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		if export.DeletionRequest, dbErr = pggeo.GetAccountDeletionRequest(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.Settings, dbErr = pggeo.GetAthleteSettings(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.Profile, dbErr = s.getExportedProfile(conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.Sessions, dbErr = s.listExportedSessions(conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.WebSessions, dbErr = s.listExportedWebSessions(conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.Segments, dbErr = pggeo.ListFavoriteSegments(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.Tags, dbErr = pggeo.ListAthleteActivityTags(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.Gear, dbErr = pggeo.ListGear(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.ShareLinks, dbErr = pggeo.ListPublicStatsTokens(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.ImportFiles, dbErr = s.listExportedImportFiles(conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		if export.AuditEvents, dbErr = pggeo.ListAccountEvents(s.ctx, conn, scope.AthleteID); dbErr != nil {
+			return dbErr
+		}
+		export.Activities, dbErr = pggeo.GetAllActivities(s.ctx, conn, scope.AthleteID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to build export", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", `attachment; filename="b11k-export.json"`)
+	if r.URL.Query().Get("include_points") != "true" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := writeAthleteExportJSON(w, export); err != nil {
+			slog.Warn("Export interrupted", "athlete_id", scope.AthleteID, "error", err)
+			return
+		}
+		s.recordAccountEvent(scope.AthleteID, pggeo.AccountEventExported)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="b11k-export.ndjson"`)
+	pointsFor := func(activityID int64) ([]pggeo.PointSample, error) {
+		var samples []pggeo.PointSample
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, scope.AthleteID, activityID)
+			return dbErr
+		})
+		return samples, err
+	}
+	if err := writeAthleteExportNDJSON(w, export, pointsFor); err != nil {
+		slog.Warn("Export interrupted", "athlete_id", scope.AthleteID, "error", err)
+		return
+	}
+	s.recordAccountEvent(scope.AthleteID, pggeo.AccountEventExported)
+}
+
+// recordAccountEvent appends to the athlete's audit trail. The action it records has
+// already happened, so a failure is only logged.
+func (s *server) recordAccountEvent(athleteID int64, event string) {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.RecordAccountEvent(s.ctx, conn, athleteID, event)
+	})
+	if err != nil {
+		slog.Warn("Failed to record account event", "athlete_id", athleteID, "event", event, "error", err)
+	}
+}
+
+// writeAthleteExportJSON streams the export as a single JSON document, encoding one
+// activity at a time instead of building the whole document in memory.
+func writeAthleteExportJSON(w io.Writer, export athleteExport) error {
+	enc := json.NewEncoder(w)
+	write := func(s string) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+	field := func(name string, v interface{}) error {
+		if err := write(`"` + name + `":`); err != nil {
+			return err
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return write(",")
+	}
+
+	if err := write("{"); err != nil {
+		return err
+	}
+	if err := field("exported_at", export.ExportedAt); err != nil {
+		return err
+	}
+	if err := field("athlete", export.Athlete); err != nil {
+		return err
+	}
+	if err := field("athlete_profile", export.Profile); err != nil {
+		return err
+	}
+	if err := field("deletion_request", export.DeletionRequest); err != nil {
+		return err
+	}
+	if err := field("settings", export.Settings); err != nil {
+		return err
+	}
+	if err := field("sessions", nonNilSlice(export.Sessions)); err != nil {
+		return err
+	}
+	if err := field("web_sessions", nonNilSlice(export.WebSessions)); err != nil {
+		return err
+	}
+	if err := field("segments", nonNilSlice(export.Segments)); err != nil {
+		return err
+	}
+	if err := field("tags", nonNilSlice(export.Tags)); err != nil {
+		return err
+	}
+	if err := field("gear", nonNilSlice(export.Gear)); err != nil {
+		return err
+	}
+	if err := field("share_links", nonNilSlice(export.ShareLinks)); err != nil {
+		return err
+	}
+	if err := field("import_files", nonNilSlice(export.ImportFiles)); err != nil {
+		return err
+	}
+	if err := field("audit_events", nonNilSlice(export.AuditEvents)); err != nil {
+		return err
+	}
+	if err := write(`"activities":[`); err != nil {
+		return err
+	}
+	for i, activity := range export.Activities {
+		if i > 0 {
+			if err := write(","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(activity); err != nil {
+			return err
+		}
+	}
+	return write("]}\n")
+}
+
+// writeAthleteExportNDJSON streams the export as one JSON record per line, followed by
+// the point samples of every activity loaded one activity at a time.
+func writeAthleteExportNDJSON(w io.Writer, export athleteExport, pointsFor func(activityID int64) ([]pggeo.PointSample, error)) error {
+	enc := json.NewEncoder(w)
+	records := []exportRecord{
+		{Type: "export", Data: map[string]interface{}{"exported_at": export.ExportedAt}},
+		{Type: "athlete", Data: export.Athlete},
+		{Type: "athlete_profile", Data: export.Profile},
+		{Type: "deletion_request", Data: export.DeletionRequest},
+		{Type: "settings", Data: export.Settings},
+	}
+	for _, session := range export.Sessions {
+		records = append(records, exportRecord{Type: "session", Data: session})
+	}
+	for _, session := range export.WebSessions {
+		records = append(records, exportRecord{Type: "web_session", Data: session})
+	}
+	for _, segment := range export.Segments {
+		records = append(records, exportRecord{Type: "segment", Data: segment})
+	}
+	for _, tag := range export.Tags {
+		records = append(records, exportRecord{Type: "tag", Data: tag})
+	}
+	for _, gear := range export.Gear {
+		records = append(records, exportRecord{Type: "gear", Data: gear})
+	}
+	for _, link := range export.ShareLinks {
+		records = append(records, exportRecord{Type: "share_link", Data: link})
+	}
+	for _, file := range export.ImportFiles {
+		records = append(records, exportRecord{Type: "import_file", Data: file})
+	}
+	for _, event := range export.AuditEvents {
+		records = append(records, exportRecord{Type: "audit_event", Data: event})
+	}
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	for _, activity := range export.Activities {
+		if err := enc.Encode(exportRecord{Type: "activity", Data: activity}); err != nil {
+			return err
+		}
+		samples, err := pointsFor(activity.ID)
+		if err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			if err := enc.Encode(exportRecord{Type: "point_sample", Data: sample}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func nonNilSlice[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func (s *server) listExportedSessions(conn *pgxpool.Pool, athleteID int64) ([]exportedSession, error) {
+	rows, err := conn.Query(s.ctx, `
+		SELECT created_at, last_seen_at, session_expires_at
+		FROM mobile_app_sessions
+		WHERE athlete_id = $1
+		ORDER BY created_at
+	`, athleteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []exportedSession
+	for rows.Next() {
+		var session exportedSession
+		if err := rows.Scan(&session.CreatedAt, &session.LastSeenAt, &session.SessionExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// listExportedWebSessions returns the athlete's web logins without their token keys
+func (s *server) listExportedWebSessions(conn *pgxpool.Pool, athleteID int64) ([]exportedWebSession, error) {
+	stored, err := pggeo.ListWebSessions(s.ctx, conn, athleteID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]exportedWebSession, len(stored))
+	for i, session := range stored {
+		sessions[i] = exportedWebSession{ID: session.ID, UserAgent: session.UserAgent, CreatedAt: session.CreatedAt, LastUsedAt: session.LastUsedAt}
+	}
+	return sessions, nil
+}
+
+// getExportedProfile returns the athlete's prefetched profile, nil when none is stored
+func (s *server) getExportedProfile(conn *pgxpool.Pool, athleteID int64) (*exportedProfile, error) {
+	profile, err := pggeo.GetAthleteProfile(s.ctx, conn, athleteID)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+	return &exportedProfile{Athlete: profile.Athlete, HRZones: profile.HRZones, PrefetchedAt: profile.PrefetchedAt}, nil
+}
+
+// listExportedImportFiles returns the files the athlete imported, oldest first
+func (s *server) listExportedImportFiles(conn *pgxpool.Pool, athleteID int64) ([]pggeo.ImportFile, error) {
+	byHash, err := pggeo.ListImportFiles(s.ctx, conn, athleteID)
+	if err != nil {
+		return nil, err
+	}
+	files := slices.Collect(maps.Values(byHash))
+	slices.SortFunc(files, func(a, b pggeo.ImportFile) int {
+		if c := a.ImportedAt.Compare(b.ImportedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Hash, b.Hash)
+	})
+	return files, nil
+}
+
+// handleMeDeleteRequest handles GET/POST/DELETE /api/me/delete-request.
+// POST schedules deletion after the configured grace period, DELETE cancels it.
+func (s *server) handleMeDeleteRequest(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req *pggeo.AccountDeletionRequest
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			req, dbErr = pggeo.GetAccountDeletionRequest(s.ctx, conn, scope.AthleteID)
+			return dbErr
+		})
+	case http.MethodPost:
+		grace := time.Duration(s.cfg.AccountDeletionGraceDays) * 24 * time.Hour
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			req, dbErr = pggeo.ScheduleAccountDeletion(s.ctx, conn, scope.AthleteID, grace)
+			return dbErr
+		})
+		if err == nil {
+			slog.Info("Account deletion scheduled", "athlete_id", scope.AthleteID, "execute_after", req.ExecuteAfter.Format(time.RFC3339))
+			s.recordAccountEvent(scope.AthleteID, pggeo.AccountEventDeletionScheduled)
+		}
+	case http.MethodDelete:
+		var cancelled bool
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			cancelled, dbErr = pggeo.CancelAccountDeletion(s.ctx, conn, scope.AthleteID)
+			if dbErr != nil {
+				return dbErr
+			}
+			req, dbErr = pggeo.GetAccountDeletionRequest(s.ctx, conn, scope.AthleteID)
+			return dbErr
+		})
+		if err == nil && !cancelled {
+			writeError(w, r, newAPIError(http.StatusNotFound, "no pending deletion request"))
+			return
+		}
+		if err == nil {
+			slog.Info("Account deletion cancelled", "athlete_id", scope.AthleteID)
+			s.recordAccountEvent(scope.AthleteID, pggeo.AccountEventDeletionCancelled)
+		}
+	default:
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if err != nil {
+		slog.Error("Account deletion request failed", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"grace_period_days": s.cfg.AccountDeletionGraceDays,
+		"deletion_request":  req,
+	})
+}
+
+// runAccountDeletions executes due account deletion requests until the server context ends
+func (s *server) runAccountDeletions() {
+	ticker := time.NewTicker(accountDeletionCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.executeDueAccountDeletions(time.Now())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *server) executeDueAccountDeletions(now time.Time) {
+	var athleteIDs []int64
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		athleteIDs, dbErr = pggeo.ListDueAccountDeletions(s.ctx, conn, now)
+		return dbErr
+	})
+	if err != nil {
+		slog.Warn("Failed to list due account deletions", "error", err)
+		return
+	}
+
+	for _, athleteID := range athleteIDs {
+		var deleted bool
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			deleted, dbErr = pggeo.ExecuteAccountDeletion(s.ctx, conn, athleteID, now)
+			return dbErr
+		})
+		if err != nil {
+			slog.Error("Account deletion failed", "athlete_id", athleteID, "error", err)
+			continue
+		}
+		if !deleted {
+			continue
+		}
+		s.forgetAthleteSessions(athleteID)
+		slog.Info("Account deletion completed", "athlete_id", athleteID)
+	}
+}
+
+// forgetAthleteSessions drops in-memory sessions that belong to a deleted athlete
+func (s *server) forgetAthleteSessions(athleteID int64) {
+	s.mobileMu.Lock()
+	for token, session := range s.mobileSessions {
+		if session.Athlete != nil && session.Athlete.ID == athleteID {
+			delete(s.mobileSessions, token)
+		}
+	}
+	s.mobileMu.Unlock()
+
+	s.forgetWebAthlete(athleteID)
+}
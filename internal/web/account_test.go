@@ -0,0 +1,183 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func TestWriteAthleteExportJSONWithNullFields(t *testing.T) {
+	export := athleteExport{
+		ExportedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Activities: []strava.ActivitySummary{
+			{ID: 1},
+			{ID: 2, Name: "Quote \" and newline \n", StartLatLng: nil, GearName: nil},
+		},
+		Segments: []pggeo.FavoriteSegment{{ID: 3}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAthleteExportJSON(&buf, export); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Fatalf("export is not valid JSON: %s", buf.String())
+	}
+
+	var decoded struct {
+		Athlete         *strava.Athlete          `json:"athlete"`
+		DeletionRequest interface{}              `json:"deletion_request"`
+		Sessions        []exportedSession        `json:"sessions"`
+		Segments        []pggeo.FavoriteSegment  `json:"segments"`
+		Tags            []pggeo.ActivityTag      `json:"tags"`
+		ShareLinks      []pggeo.PublicStatsToken `json:"share_links"`
+		AuditEvents     []pggeo.AccountEvent     `json:"audit_events"`
+		Activities      []strava.ActivitySummary `json:"activities"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Athlete != nil || decoded.DeletionRequest != nil {
+		t.Fatalf("nil fields should export as null: %#v", decoded)
+	}
+	if decoded.Sessions == nil || len(decoded.Sessions) != 0 {
+		t.Fatalf("sessions = %#v, want empty array", decoded.Sessions)
+	}
+	if decoded.Tags == nil || decoded.ShareLinks == nil || decoded.AuditEvents == nil {
+		t.Fatalf("tags, share links and audit events should export as empty arrays: %#v", decoded)
+	}
+	if len(decoded.Activities) != 2 || decoded.Activities[1].Name != "Quote \" and newline \n" {
+		t.Fatalf("activities = %#v", decoded.Activities)
+	}
+}
+
+func TestWriteAthleteExportJSONHasEverySection(t *testing.T) {
+	created := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	export := athleteExport{
+		Athlete:     &strava.Athlete{ID: 9},
+		Profile:     &exportedProfile{Athlete: strava.Athlete{ID: 9, FirstName: "Ada"}, PrefetchedAt: created},
+		WebSessions: []exportedWebSession{{ID: 3, UserAgent: "Firefox", CreatedAt: created, LastUsedAt: created}},
+		Gear:        []pggeo.Gear{{ID: "b1", Name: "Road bike", Kind: pggeo.GearKindBike}},
+		ImportFiles: []pggeo.ImportFile{{AthleteID: 9, Hash: "ab12", ActivityID: 1, Filename: "ride.gpx", ImportedAt: created}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeAthleteExportJSON(&buf, export); err != nil {
+		t.Fatal(err)
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &sections); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"exported_at", "athlete", "athlete_profile", "deletion_request", "settings", "sessions", "web_sessions",
+		"segments", "tags", "gear", "share_links", "import_files", "audit_events", "activities",
+	} {
+		if _, ok := sections[name]; !ok {
+			t.Errorf("export lacks %q", name)
+		}
+	}
+
+	var decoded struct {
+		Profile     exportedProfile    `json:"athlete_profile"`
+		WebSessions []map[string]any   `json:"web_sessions"`
+		Gear        []pggeo.Gear       `json:"gear"`
+		ImportFiles []pggeo.ImportFile `json:"import_files"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Profile.Athlete.FirstName != "Ada" || len(decoded.Gear) != 1 || decoded.Gear[0].Kind != pggeo.GearKindBike ||
+		len(decoded.ImportFiles) != 1 || decoded.ImportFiles[0].Filename != "ride.gpx" {
+		t.Fatalf("decoded export = %+v", decoded)
+	}
+	if len(decoded.WebSessions) != 1 || decoded.WebSessions[0]["user_agent"] != "Firefox" {
+		t.Fatalf("web sessions = %v", decoded.WebSessions)
+	}
+	for _, secret := range []string{"token_key", "token_hash", "access_token", "refresh_token"} {
+		if bytes.Contains(buf.Bytes(), []byte(secret)) {
+			t.Errorf("export contains %q", secret)
+		}
+	}
+}
+
+func TestWriteAthleteExportJSONEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeAthleteExportJSON(&buf, athleteExport{}); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Fatalf("empty export is not valid JSON: %s", buf.String())
+	}
+}
+
+func TestWriteAthleteExportNDJSONIncludesPoints(t *testing.T) {
+	export := athleteExport{
+		Athlete:     &strava.Athlete{ID: 9},
+		Tags:        []pggeo.ActivityTag{{ActivityID: 1, Tag: "commute"}},
+		Profile:     &exportedProfile{Athlete: strava.Athlete{ID: 9}},
+		WebSessions: []exportedWebSession{{ID: 3}},
+		Gear:        []pggeo.Gear{{ID: "b1", Name: "Road bike"}},
+		ShareLinks:  []pggeo.PublicStatsToken{{ID: 4, Fields: []string{"distance"}}},
+		ImportFiles: []pggeo.ImportFile{{Hash: "ab12", Filename: "ride.gpx"}},
+		AuditEvents: []pggeo.AccountEvent{{Event: pggeo.AccountEventExported}},
+		Activities:  []strava.ActivitySummary{{ID: 1}, {ID: 2}},
+	}
+	pointsFor := func(activityID int64) ([]pggeo.PointSample, error) {
+		if activityID == 2 {
+			return nil, nil
+		}
+		return []pggeo.PointSample{{ActivityID: activityID, PointIndex: 0}, {ActivityID: activityID, PointIndex: 1}}, nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeAthleteExportNDJSON(&buf, export, pointsFor); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		counts[record.Type]++
+	}
+	if counts["activity"] != 2 || counts["point_sample"] != 2 || counts["athlete"] != 1 ||
+		counts["tag"] != 1 || counts["gear"] != 1 || counts["share_link"] != 1 || counts["audit_event"] != 1 ||
+		counts["athlete_profile"] != 1 || counts["web_session"] != 1 || counts["import_file"] != 1 {
+		t.Fatalf("record counts = %#v", counts)
+	}
+}
+
+func TestAccountDeletionRequestCancellationPreventsDeletion(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	req := pggeo.AccountDeletionRequest{
+		AthleteID:    1,
+		RequestedAt:  now.Add(-31 * 24 * time.Hour),
+		ExecuteAfter: now.Add(-time.Hour),
+	}
+	if !req.Due(now) {
+		t.Fatal("request past its grace period should be due")
+	}
+
+	cancelledAt := now.Add(-2 * time.Hour)
+	req.CancelledAt = &cancelledAt
+	if req.Due(now) {
+		t.Fatal("cancelled request must not be due")
+	}
+
+	req.CancelledAt = nil
+	req.ExecuteAfter = now.Add(time.Hour)
+	if req.Due(now) {
+		t.Fatal("request inside its grace period must not be due")
+	}
+}
@@ -0,0 +1,97 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Bounds of ?step_m= on the comparison endpoint
+const (
+	minCompareStepMeters = 10.0
+	maxCompareStepMeters = 1000.0
+)
+
+// activityCompareParams parses ?ids=a,b, ?metrics= and the optional ?step_m= of
+// GET /api/activities/compare
+func activityCompareParams(r *http.Request) ([2]int64, []string, float64, error) {
+	var ids [2]int64
+	parts := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(parts) != 2 {
+		return ids, nil, 0, fmt.Errorf("ids must be two activity ids")
+	}
+	for i, part := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return ids, nil, 0, fmt.Errorf("invalid activity id %q", part)
+		}
+		ids[i] = id
+	}
+	if ids[0] == ids[1] {
+		return ids, nil, 0, fmt.Errorf("ids must be two different activities")
+	}
+
+	metrics, err := graphMetricsParam(r)
+	if err != nil {
+		return ids, nil, 0, err
+	}
+	for _, metric := range metrics {
+		if !slices.Contains(pggeo.CompareMetrics, metric) {
+			return ids, nil, 0, fmt.Errorf("unknown metric %q", metric)
+		}
+	}
+
+	step := pggeo.DefaultCompareStepMeters
+	if raw := strings.TrimSpace(r.URL.Query().Get("step_m")); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < minCompareStepMeters || value > maxCompareStepMeters {
+			return ids, nil, 0, fmt.Errorf("step_m must be between %.0f and %.0f", minCompareStepMeters, maxCompareStepMeters)
+		}
+		step = value
+	}
+	return ids, metrics, step, nil
+}
+
+// handleActivityCompare handles GET /api/activities/compare?ids=123,456&metrics=speed,heartrate,
+// both activities' metrics resampled every step_m meters (50 by default) of distance with
+// per-distance deltas, for overlaying two rides of the same route
+func (s *server) handleActivityCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	ids, metrics, step, err := activityCompareParams(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	var comparison *pggeo.ActivityComparison
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		comparison, dbErr = pggeo.CompareActivities(s.ctx, conn, scope.AthleteID, ids, metrics, step)
+		return dbErr
+	})
+	if err != nil {
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, err)
+			return
+		}
+		slog.Error("Failed to compare activities", "activity_id", ids[0], "other_activity_id", ids[1], "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSONCompact(w, r, comparison)
+}
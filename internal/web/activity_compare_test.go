@@ -0,0 +1,32 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActivityCompareParams(t *testing.T) {
+	ids, metrics, step, err := activityCompareParams(httptest.NewRequest("GET", "/api/activities/compare?ids=123,%20456&metrics=speed,heartrate", nil))
+	if err != nil || ids != [2]int64{123, 456} || len(metrics) != 2 || step != 50 {
+		t.Fatalf("params = %v %v %v %v", ids, metrics, step, err)
+	}
+	if _, _, step, err := activityCompareParams(httptest.NewRequest("GET", "/api/activities/compare?ids=1,2&metrics=watts&step_m=100", nil)); err != nil || step != 100 {
+		t.Fatalf("step_m = %v, %v; want 100", step, err)
+	}
+
+	for _, query := range []string{
+		"metrics=speed",
+		"ids=1&metrics=speed",
+		"ids=1,2,3&metrics=speed",
+		"ids=1,1&metrics=speed",
+		"ids=1,x&metrics=speed",
+		"ids=1,2",
+		"ids=1,2&metrics=speed,temperature",
+		"ids=1,2&metrics=speed&step_m=5",
+		"ids=1,2&metrics=speed&step_m=abc",
+	} {
+		if _, _, _, err := activityCompareParams(httptest.NewRequest("GET", "/api/activities/compare?"+query, nil)); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+}
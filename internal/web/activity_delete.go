@@ -0,0 +1,32 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleActivityDelete handles DELETE /api/activities/:id. Strava keeps its copy, so a
+// later sync may import the activity again.
+func (s *server) handleActivityDelete(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	var deleted bool
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var err error
+		deleted, err = pggeo.DeleteActivity(s.ctx, conn, athleteID, activityID)
+		return err
+	})
+	if err != nil {
+		slog.Error("Failed to delete activity", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+		return
+	}
+	slog.Info("Deleted activity", "activity_id", activityID, "athlete_id", athleteID)
+	w.WriteHeader(http.StatusNoContent)
+}
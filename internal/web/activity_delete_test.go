@@ -0,0 +1,21 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActivityDeleteRequiresLoginAndNumericID(t *testing.T) {
+	h := newWebhookTestServer().routes()
+	for target, want := range map[string]int{
+		"/api/activities/123":      http.StatusUnauthorized,
+		"/api/activities/not-a-id": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, target, nil))
+		if rec.Code != want {
+			t.Fatalf("DELETE %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+}
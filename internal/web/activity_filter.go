@@ -0,0 +1,107 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+const dateParamLayout = "2006-01-02"
+
+// dateRangeParams reads ?start= and ?end= (YYYY-MM-DD, both inclusive) as the half-open
+// UTC range [start, end+1 day); a missing bound is the zero time
+func dateRangeParams(r *http.Request) (start, end time.Time, err error) {
+	query := r.URL.Query()
+	if value := strings.TrimSpace(query.Get("start")); value != "" {
+		if start, err = time.Parse(dateParamLayout, value); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("start must be a YYYY-MM-DD date")
+		}
+	}
+	if value := strings.TrimSpace(query.Get("end")); value != "" {
+		if end, err = time.Parse(dateParamLayout, value); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("end must be a YYYY-MM-DD date")
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start must not be after end")
+	}
+	return start, end, nil
+}
+
+// tagFilterParams reads ?tag= and ?exclude_tag=, the tag activities must or must not carry
+func tagFilterParams(r *http.Request, filter *pggeo.ActivityFilter) error {
+	for param, target := range map[string]*string{"tag": &filter.Tag, "exclude_tag": &filter.ExcludeTag} {
+		value := strings.TrimSpace(r.URL.Query().Get(param))
+		if value == "" {
+			continue
+		}
+		tag, err := pggeo.NormalizeTag(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", param, err)
+		}
+		*target = tag
+	}
+	return nil
+}
+
+// activityFilter reads the activity list filter from ?q=, ?type=, the dateRangeParams,
+// ?min_distance= and ?max_distance= (meters), the tagFilterParams, ?sort= and
+// ?pinned_first=, without paging
+func activityFilter(r *http.Request) (pggeo.ActivityFilter, error) {
+	query := r.URL.Query()
+	filter := pggeo.ActivityFilter{
+		Query:       strings.TrimSpace(query.Get("q")),
+		Type:        strings.TrimSpace(query.Get("type")),
+		Sort:        strings.TrimSpace(query.Get("sort")),
+		PinnedFirst: pinnedFirst(r),
+	}
+	if filter.Sort == "" {
+		filter.Sort = pggeo.ActivitySortDate
+	}
+	if !pggeo.ValidActivitySort(filter.Sort) {
+		return filter, fmt.Errorf("sort must be one of date, distance, elevation, duration, new_roads")
+	}
+
+	var err error
+	if filter.Start, filter.End, err = dateRangeParams(r); err != nil {
+		return filter, err
+	}
+
+	for param, target := range map[string]*float64{"min_distance": &filter.MinDistance, "max_distance": &filter.MaxDistance} {
+		value := strings.TrimSpace(query.Get(param))
+		if value == "" {
+			continue
+		}
+		meters, err := strconv.ParseFloat(value, 64)
+		if err != nil || meters < 0 || math.IsNaN(meters) || math.IsInf(meters, 0) {
+			return filter, fmt.Errorf("%s must be a non-negative number of meters", param)
+		}
+		*target = meters
+	}
+	if filter.MaxDistance > 0 && filter.MinDistance > filter.MaxDistance {
+		return filter, fmt.Errorf("min_distance must not exceed max_distance")
+	}
+	if err := tagFilterParams(r, &filter); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// activityFilterNarrows reports whether filter leaves out any activities
+func activityFilterNarrows(filter pggeo.ActivityFilter) bool {
+	return filter.Query != "" || filter.Type != "" || !filter.Start.IsZero() || !filter.End.IsZero() ||
+		filter.MinDistance > 0 || filter.MaxDistance > 0 || filter.Tag != "" || filter.ExcludeTag != ""
+}
+
+// activityListURL links to page of the index with the request's filter and page size
+func (s *server) activityListURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return s.url("/strava/") + "?" + query.Encode()
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+func TestActivityFilterParams(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/activities?q=+commute+&type=Ride&start=2024-01-01&end=2024-06-30&min_distance=20000&max_distance=80000&sort=distance&pinned_first=false", nil)
+	filter, err := activityFilter(req)
+	if err != nil {
+		t.Fatalf("activityFilter: %v", err)
+	}
+	want := pggeo.ActivityFilter{
+		Query:       "commute",
+		Type:        "Ride",
+		Start:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:         time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		MinDistance: 20000,
+		MaxDistance: 80000,
+		Sort:        pggeo.ActivitySortDistance,
+	}
+	if filter != want {
+		t.Fatalf("filter = %+v, want %+v", filter, want)
+	}
+	if !activityFilterNarrows(filter) {
+		t.Fatal("filter does not narrow the list")
+	}
+
+	defaults, err := activityFilter(httptest.NewRequest(http.MethodGet, "/api/activities", nil))
+	if err != nil || defaults.Sort != pggeo.ActivitySortDate || !defaults.PinnedFirst || activityFilterNarrows(defaults) {
+		t.Fatalf("defaults = %+v, %v", defaults, err)
+	}
+
+	for _, query := range []string{
+		"sort=name",
+		"start=01/02/2024",
+		"end=2024-13-01",
+		"start=2024-02-01&end=2024-01-01",
+		"min_distance=-1",
+		"max_distance=far",
+		"min_distance=NaN",
+		"min_distance=5000&max_distance=1000",
+		"tag=two+words",
+		"exclude_tag=%23commute",
+	} {
+		if _, err := activityFilter(httptest.NewRequest(http.MethodGet, "/api/activities?"+query, nil)); err == nil {
+			t.Fatalf("%s: expected error", query)
+		}
+	}
+}
+
+func TestTagFilterParams(t *testing.T) {
+	filter, err := activityFilter(httptest.NewRequest(http.MethodGet, "/api/activities?tag=+Commute+&exclude_tag=race", nil))
+	if err != nil {
+		t.Fatalf("activityFilter: %v", err)
+	}
+	if filter.Tag != "commute" || filter.ExcludeTag != "race" {
+		t.Fatalf("tag, exclude_tag = %q, %q; want commute, race", filter.Tag, filter.ExcludeTag)
+	}
+	if !activityFilterNarrows(pggeo.ActivityFilter{ExcludeTag: "commute"}) {
+		t.Fatal("an excluded tag does not narrow the list")
+	}
+}
+
+func TestActivityListURLKeepsTheFilter(t *testing.T) {
+	s := &server{cfg: Config{BasePath: "/b11k"}}
+	req := httptest.NewRequest(http.MethodGet, "/strava/?q=a%26b&sort=distance&page=2&per_page=50", nil)
+	if got, want := s.activityListURL(req, 3), "/b11k/strava/?page=3&per_page=50&q=a%26b&sort=distance"; got != want {
+		t.Fatalf("activityListURL = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,300 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderActivitiesPageWithReq(w, r)
+}
+
+func (s *server) handleStravaHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/strava/" {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderActivitiesPageWithReq(w, r)
+}
+
+func (s *server) renderActivitiesPageWithReq(w http.ResponseWriter, r *http.Request) {
+	scope := s.webReadSessionFromRequest(r)
+
+	page, perPage := pageParams(r)
+	filter, err := activityFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.InstanceOnly = scope.Anonymous
+
+	// Only the requested page is loaded; the count sizes the pager
+	var pageItems, pinned []strava.ActivitySummary
+	var typeOptions []string
+	var zone pggeo.DisplayZone
+	total := 0
+	if scope.Athlete != nil {
+		err := s.withReadDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			if total, dbErr = pggeo.CountActivities(s.ctx, conn, scope.AthleteID, filter); dbErr != nil {
+				return dbErr
+			}
+			page = min(page, pageCount(total, perPage))
+			filter.Limit, filter.Offset = perPage, (page-1)*perPage
+			if pageItems, dbErr = pggeo.QueryActivities(s.ctx, conn, scope.AthleteID, filter); dbErr != nil {
+				return dbErr
+			}
+			if pinned, dbErr = pggeo.GetPinnedActivities(s.ctx, conn, scope.AthleteID); dbErr != nil {
+				return dbErr
+			}
+			pinned = visibleActivities(scope.viewerID(), pinned)
+			typeOptions, dbErr = pggeo.ListActivityTypes(s.ctx, conn, scope.AthleteID)
+			return dbErr
+		})
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		pageItems = s.enrichGearNames(scope, pageItems)
+		pinned = s.enrichGearNames(scope, pinned)
+		s.fillSparklines(scope.AthleteID, pageItems, sparklineMetric(r))
+		s.maybeAutoPull(r, scope)
+		if zone, err = s.displayZone(r, scope.AthleteID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	totalPages := pageCount(total, perPage)
+	data := struct {
+		Activities           []strava.ActivitySummary
+		Pinned               []strava.ActivitySummary
+		Type                 string
+		TypeOptions          []string
+		Filter               url.Values // the raw filter parameters, to refill the form
+		Sort                 string
+		Filtered             bool
+		PrevURL              string
+		NextURL              string
+		ShowLoginCTA         bool
+		Anonymous            bool
+		Authorized           bool
+		Athlete              *strava.Athlete
+		CurrentPage          int
+		TotalPages           int
+		HasNext              bool
+		HasPrev              bool
+		PerPage              int
+		DiscoveredMapEnabled bool
+		Announcements        []pggeo.Announcement
+		DisplayZone          pggeo.DisplayZone
+	}{
+		Activities:           pageItems,
+		Pinned:               pinned,
+		Type:                 filter.Type,
+		TypeOptions:          typeOptions,
+		Filter:               r.URL.Query(),
+		Sort:                 filter.Sort,
+		Filtered:             activityFilterNarrows(filter),
+		PrevURL:              s.activityListURL(r, page-1),
+		NextURL:              s.activityListURL(r, page+1),
+		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
+		Anonymous:            scope.Anonymous,
+		Authorized:           scope.StravaToken != "",
+		Athlete:              scope.Athlete,
+		CurrentPage:          page,
+		TotalPages:           totalPages,
+		HasNext:              page < totalPages,
+		HasPrev:              page > 1,
+		PerPage:              perPage,
+		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
+		Announcements:        s.pageAnnouncements(r, scope),
+		DisplayZone:          zone,
+	}
+	if err := s.executeTemplate(w, "index.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleActivity handles GET /activity/{id}, the activity page
+func (s *server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	activityID, ok := pathID(w, r, "id", "invalid id")
+	if !ok {
+		return
+	}
+	scope, ok := s.webReadScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var activity *strava.ActivitySummary
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		activity, dbErr = pggeo.GetActivityByID(s.ctx, conn, scope.AthleteID, activityID)
+		return dbErr
+	})
+	if err == nil && !pggeo.ActivityVisibleTo(*activity, scope.viewerID()) {
+		err = newAPIError(http.StatusNotFound, "activity not found")
+	}
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusNotFound)
+		return
+	}
+	enriched := s.enrichGearNames(scope, []strava.ActivitySummary{*activity})
+	if len(enriched) > 0 {
+		activity = &enriched[0]
+	}
+	setAvgSpeeds(activity)
+	zone, err := s.displayZone(r, scope.AthleteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var activityHRZones []pggeo.HRZoneDistribution
+	if scope.StravaToken != "" {
+		if zones, err := s.athleteHRZones(r.Context(), scope); err == nil && zones != nil {
+			err = s.withReadDB(func(conn *pgxpool.Pool) error {
+				var dbErr error
+				activityHRZones, dbErr = pggeo.GetHRZoneDistributionForActivity(s.ctx, conn, scope.AthleteID, activityID, zones)
+				return dbErr
+			})
+			if err != nil {
+				slog.Warn("Failed to calculate activity HR zones", "activity_id", activityID, "error", err)
+			}
+		}
+	}
+	data := struct {
+		Activity             strava.ActivitySummary
+		ActivityHRZones      []pggeo.HRZoneDistribution
+		Athlete              *strava.Athlete
+		ShowLoginCTA         bool
+		Anonymous            bool
+		Authorized           bool
+		MobileActivityOrder  string
+		DiscoveredMapEnabled bool
+		Announcements        []pggeo.Announcement
+		DisplayZone          pggeo.DisplayZone
+	}{
+		Activity:             *activity,
+		ActivityHRZones:      activityHRZones,
+		Athlete:              scope.Athlete,
+		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
+		Anonymous:            scope.Anonymous,
+		Authorized:           scope.StravaToken != "",
+		MobileActivityOrder:  s.cfg.MobileActivityOrder,
+		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
+		Announcements:        s.pageAnnouncements(r, scope),
+		DisplayZone:          zone,
+	}
+	if err := s.executeTemplate(w, "activity.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleActivitiesAPI handles GET /api/activities?page=&per_page= with the filter
+// parameters of activityFilter, one page of the matching activities with their total in
+// X-Total-Count
+func (s *server) handleActivitiesAPI(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	page, perPage := pageParams(r)
+	filter, err := activityFilter(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	filter.InstanceOnly = scope.Anonymous
+	filter.Limit, filter.Offset = perPage, (page-1)*perPage
+	var activities []strava.ActivitySummary
+	total := 0
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		if total, dbErr = pggeo.CountActivities(s.ctx, conn, scope.AthleteID, filter); dbErr != nil {
+			return dbErr
+		}
+		activities, dbErr = pggeo.QueryActivities(s.ctx, conn, scope.AthleteID, filter)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	activities = s.enrichGearNames(scope, activities)
+	fillAvgSpeeds(activities)
+	s.fillSparklines(scope.AthleteID, activities, sparklineMetric(r))
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSONCompact(w, r, activities)
+}
+
+// handleActivityGraph handles GET /api/activities/{id}/graph: the activity's metrics
+// series for the graphs, with ?metrics=, ?smooth=, ?max_points= and ?include_zones=true
+func (s *server) handleActivityGraph(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64) {
+	metrics, err := graphMetricsParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	smooth, err := graphSmoothParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	maxPoints, err := graphMaxPointsParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	includeZones := r.URL.Query().Get("include_zones") == "true"
+
+	var hrZones *strava.HeartRateZones
+	if includeZones {
+		hrZones, _ = s.athleteHRZones(r.Context(), scope)
+	}
+
+	var graphData *pggeo.GraphData
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		graphData, dbErr = pggeo.GetGraphDataForActivity(s.ctx, conn, scope.AthleteID, activityID, metrics, includeZones, hrZones)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	graphData.Watts = pggeo.SmoothGraphSeries(graphData.Watts, smooth)
+	graphData.Downsample(maxPoints)
+	writeJSONCompact(w, r, graphData)
+}
+
+// handleActivityPoints handles GET /api/activities/{id}/points, streamed as they are read
+// since long rides have tens of thousands of samples
+func (s *server) handleActivityPoints(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64) {
+	stream := newJSONArrayStream(w, r, "", "")
+	encode := func(sample pggeo.PointSample) error { return stream.encode(sample) }
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		count, dbErr := pggeo.StreamPointSamplesForActivity(s.ctx, conn, scope.AthleteID, activityID, encode)
+		if dbErr != nil || count > 0 {
+			return stream.abort(dbErr)
+		}
+		// Activities synced without streams only have the route decoded from their polyline
+		_, dbErr = pggeo.StreamRoutePointsForActivity(s.ctx, conn, scope.AthleteID, activityID, encode)
+		return stream.abort(dbErr)
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	stream.close()
+}
@@ -0,0 +1,186 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/sync"
+	"b11k/internal/trackimport"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Upload limits for POST /api/activities/import
+const (
+	maxImportRequestBytes = 64 << 20
+	maxImportFileBytes    = 32 << 20
+	maxImportFiles        = 20
+)
+
+// activityImportResult reports the outcome for one uploaded file. Status is set for a
+// file that was stored or skipped: imported, updated or already_imported.
+type activityImportResult struct {
+	Filename   string `json:"filename"`
+	Status     string `json:"status,omitempty"`
+	ActivityID int64  `json:"activity_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Points     int    `json:"points,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// handleActivityImport handles POST /api/activities/import. Every multipart "file" part is
+// parsed as GPX or TCX and stored as a new activity of the caller; one bad file does not
+// stop the others. A file whose content was imported before is skipped and reported as
+// already_imported with its activity. ?mode=update makes a changed file replace the
+// activity of its earlier version with the same name instead of adding one.
+func (s *server) handleActivityImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	mode, err := sync.ParseImportMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportRequestBytes)
+	if err := r.ParseMultipartForm(maxImportFileBytes); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid multipart upload"))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "no file uploaded"))
+		return
+	}
+	if len(files) > maxImportFiles {
+		writeError(w, r, newAPIError(http.StatusBadRequest, fmt.Sprintf("at most %d files per upload", maxImportFiles)))
+		return
+	}
+
+	results := make([]activityImportResult, 0, len(files))
+	var importedIDs []int64
+	skipped := 0
+	for _, header := range files {
+		result := s.importActivityFile(scope.AthleteID, header, mode)
+		switch {
+		case result.Error != "":
+		case result.Status == sync.FileAlreadyImported:
+			skipped++
+		default:
+			importedIDs = append(importedIDs, result.ActivityID)
+		}
+		results = append(results, result)
+	}
+	imported := len(importedIDs)
+	s.queueSegmentCacheRefresh(scope.AthleteID, importedIDs)
+
+	if imported > 0 && s.cfg.DiscoveredMapEnabled {
+		if err := s.withDB(func(conn *pgxpool.Pool) error {
+			_, err := pggeo.RebuildDiscoveredCoverage(s.ctx, conn, scope.AthleteID, s.cfg.DiscoveredSampleDistanceMeters, s.cfg.DiscoveredRevealRadiusMeters)
+			return err
+		}); err != nil {
+			slog.Warn("Failed to rebuild discovered map coverage after import", "error", err)
+		}
+	}
+
+	status := http.StatusOK
+	if imported == 0 && skipped == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported":         imported,
+		"already_imported": skipped,
+		"failed":           len(results) - imported - skipped,
+		"results":          results,
+	})
+}
+
+// importActivityFile parses and stores one uploaded file. A file whose hash is already
+// recorded is reported without being parsed.
+func (s *server) importActivityFile(athleteID int64, header *multipart.FileHeader, mode sync.ImportMode) activityImportResult {
+	result := activityImportResult{Filename: header.Filename}
+	if header.Size > maxImportFileBytes {
+		result.Error = "file too large"
+		return result
+	}
+	data, err := readMultipartFile(header)
+	if err != nil {
+		result.Error = "failed to read file"
+		return result
+	}
+	hash := pggeo.ImportFileHash(data)
+	var known *pggeo.ImportFile
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		known, dbErr = pggeo.GetImportFileByHash(s.ctx, conn, athleteID, hash)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to look up import", "filename", header.Filename, "error", err)
+		result.Error = "failed to store activity"
+		return result
+	}
+	if known != nil {
+		result.Status = sync.FileAlreadyImported
+		result.ActivityID = known.ActivityID
+		return result
+	}
+	track, err := trackimport.Parse(header.Filename, data)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var stored *sync.FileImportResult
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		stored, dbErr = sync.StoreImportedFile(s.ctx, conn, athleteID, header.Filename, hash, track, mode, s.cfg.HealGPSSpikes)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to import activity file", "filename", header.Filename, "error", err)
+		result.Error = "failed to store activity"
+		return result
+	}
+	result.Status = stored.Status
+	result.ActivityID = stored.ActivityID
+	if stored.Activity == nil {
+		return result
+	}
+	if stored.Status == sync.FileImported && len(s.cfg.TagRules) > 0 {
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			return sync.TagActivity(s.ctx, conn, s.cfg.TagRules, stored.Activity)
+		})
+		if err != nil {
+			slog.Warn("Failed to tag activity", "activity_id", stored.ActivityID, "error", err)
+		}
+	}
+	s.activitySaved(&stored.Activity.Summary, stored.Status == sync.FileImported)
+	result.Name = track.Name
+	result.Points = len(track.Points)
+	slog.Info("Imported activity file", "filename", header.Filename, "activity_id", result.ActivityID, "points", result.Points, "status", result.Status)
+	return result
+}
+
+func readMultipartFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, maxImportFileBytes+1))
+}
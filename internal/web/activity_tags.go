@@ -0,0 +1,107 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleTags handles GET /api/tags, the athlete's tags with how many activities carry
+// each, for the tag filter of the activity list and stats
+func (s *server) handleTags(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var tags []pggeo.TagCount
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		tags, dbErr = pggeo.ListAthleteTags(s.ctx, conn, scope.AthleteID)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"tags": tags})
+}
+
+// handleActivityTags handles GET /api/activities/{id}/tags
+func (s *server) handleActivityTags(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	s.writeActivityTags(w, r, athleteID, activityID)
+}
+
+// handleActivityTagAdd handles POST /api/activities/{id}/tags with {"tag": "..."}. Adding
+// a tag the activity already has is not an error.
+func (s *server) handleActivityTagAdd(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	var req struct {
+		Tag string `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid request body"))
+		return
+	}
+	tag, err := pggeo.NormalizeTag(req.Tag)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.AddActivityTags(s.ctx, conn, athleteID, activityID, tag)
+	})
+	if err != nil {
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+			return
+		}
+		slog.Error("Failed to tag activity", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	s.writeActivityTags(w, r, athleteID, activityID)
+}
+
+// handleActivityTagRemove handles DELETE /api/activities/{id}/tags/{tag}. Tags added by
+// tag rules stay removed, as rules only tag activities when they are first stored.
+func (s *server) handleActivityTagRemove(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	var removed bool
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		removed, dbErr = pggeo.RemoveActivityTag(s.ctx, conn, athleteID, activityID, r.PathValue("tag"))
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to untag activity", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		writeError(w, r, newAPIError(http.StatusNotFound, "tag not found"))
+		return
+	}
+	s.writeActivityTags(w, r, athleteID, activityID)
+}
+
+// writeActivityTags answers with the activity's tags, read from the primary so a change
+// just made is included
+func (s *server) writeActivityTags(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	var tags []string
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		tags, dbErr = pggeo.ListActivityTags(s.ctx, conn, athleteID, activityID)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":   activityID,
+		"tags": tags,
+	})
+}
@@ -0,0 +1,36 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestActivityTagEndpointsValidateBeforeQuerying(t *testing.T) {
+	s := newSyncJobTestServer()
+	h := s.routes()
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/tags", nil),
+		httptest.NewRequest(http.MethodGet, "/api/activities/5/tags", nil),
+		httptest.NewRequest(http.MethodPost, "/api/activities/5/tags", strings.NewReader(`{"tag":"commute"}`)),
+		httptest.NewRequest(http.MethodDelete, "/api/activities/5/tags/commute", nil),
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("anonymous %s %s = %d, want 401", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+
+	for _, body := range []string{`not json`, `{"tag":""}`, `{"tag":"two words"}`, `{"tag":"` + strings.Repeat("a", 41) + `"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/activities/5/tags", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("POST %s = %d, want 400", body, rec.Code)
+		}
+	}
+}
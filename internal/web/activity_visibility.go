@@ -0,0 +1,257 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// activityPatchRequest is the body of PATCH /api/activities/:id. Omitted fields keep
+// their current values.
+type activityPatchRequest struct {
+	Visibility  *string `json:"visibility"`
+	Notes       *string `json:"notes"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+type activitiesVisibilityRequest struct {
+	Visibility string `json:"visibility"`
+	Filter     struct {
+		ActivityIDs []int64 `json:"activity_ids"`
+		Start       string  `json:"start"`
+		End         string  `json:"end"`
+		Type        string  `json:"type"`
+		Visibility  string  `json:"visibility"`
+	} `json:"filter"`
+}
+
+// handleActivityPatch handles PATCH /api/activities/:id. A name or description change is
+// also sent to Strava when write-back is enabled; the local change stands when that fails
+// and the response reports it as partial.
+func (s *server) handleActivityPatch(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64) {
+	athleteID := scope.AthleteID
+	var req activityPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid request body"))
+		return
+	}
+	if req.Visibility == nil && req.Notes == nil && req.Name == nil && req.Description == nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "no supported fields to update"))
+		return
+	}
+	response := map[string]interface{}{"id": activityID}
+
+	var visibility string
+	if req.Visibility != nil {
+		visibility = strings.TrimSpace(*req.Visibility)
+		if !pggeo.ValidActivityVisibility(visibility) {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "visibility must be private or instance"))
+			return
+		}
+		response["visibility"] = visibility
+	}
+	var notes string
+	if req.Notes != nil {
+		notes = strings.TrimSpace(*req.Notes)
+		if len(notes) > pggeo.MaxActivityNotesLength {
+			writeError(w, r, newAPIError(http.StatusBadRequest, fmt.Sprintf("notes must be at most %d bytes", pggeo.MaxActivityNotesLength)))
+			return
+		}
+		response["notes"] = notes
+		response["notes_html"] = renderMarkdown(notes)
+	}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || utf8.RuneCountInString(name) > pggeo.MaxActivityNameLength {
+			writeError(w, r, newAPIError(http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", pggeo.MaxActivityNameLength)))
+			return
+		}
+		req.Name = &name
+		response["name"] = name
+	}
+	if req.Description != nil {
+		if utf8.RuneCountInString(*req.Description) > pggeo.MaxActivityDescriptionLength {
+			writeError(w, r, newAPIError(http.StatusBadRequest, fmt.Sprintf("description must be at most %d characters", pggeo.MaxActivityDescriptionLength)))
+			return
+		}
+		response["description"] = *req.Description
+	}
+
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		if req.Visibility != nil {
+			if err := pggeo.SetActivityVisibility(s.ctx, conn, athleteID, activityID, visibility); err != nil {
+				return err
+			}
+		}
+		if req.Notes != nil {
+			if err := pggeo.SetActivityNotes(s.ctx, conn, athleteID, activityID, notes); err != nil {
+				return err
+			}
+		}
+		if req.Name != nil || req.Description != nil {
+			return pggeo.UpdateActivitySummaryName(s.ctx, conn, athleteID, activityID, req.Name, req.Description)
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+			return
+		}
+		slog.Error("Failed to update activity", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if req.Name != nil || req.Description != nil {
+		status := s.writeActivityBack(r.Context(), scope, activityID, strava.ActivityUpdate{Name: req.Name, Description: req.Description})
+		response["strava"] = status
+		response["partial"] = status.Status == stravaWriteBackFailed
+	}
+	writeJSON(w, response)
+}
+
+// Outcomes of sending an activity edit to Strava
+const (
+	stravaWriteBackUpdated  = "updated"
+	stravaWriteBackFailed   = "failed"
+	stravaWriteBackDisabled = "disabled"
+	stravaWriteBackLocal    = "local" // imported or seeded, not on Strava
+)
+
+// stravaWriteBackStatus tells the client whether an activity edit reached Strava
+type stravaWriteBackStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// writeActivityBack sends an activity edit to Strava when write-back is enabled. Failures
+// are logged and reported, never fatal: the edit is already stored locally.
+func (s *server) writeActivityBack(ctx context.Context, scope athleteScope, activityID int64, fields strava.ActivityUpdate) stravaWriteBackStatus {
+	if !s.cfg.StravaWriteBack {
+		return stravaWriteBackStatus{Status: stravaWriteBackDisabled}
+	}
+	if activityID >= pggeo.ImportActivityIDBase {
+		return stravaWriteBackStatus{Status: stravaWriteBackLocal}
+	}
+	if scope.StravaToken == "" {
+		return stravaWriteBackStatus{Status: stravaWriteBackFailed, Error: "no Strava token for this session"}
+	}
+	update := strava.UpdateActivity
+	if s.updateActivity != nil {
+		update = s.updateActivity
+	}
+	if err := update(ctx, scope.StravaToken, activityID, fields); err != nil {
+		slog.Warn("Failed to write activity back to Strava", "activity_id", activityID, "error", err)
+		message := "Strava rejected the update"
+		if errors.Is(err, strava.ErrActivityWriteUnauthorized) {
+			message = "Strava did not grant write access; sign in again to allow it"
+		} else if errors.Is(err, strava.ErrActivityNotFound) {
+			message = "the activity is not on Strava"
+		}
+		return stravaWriteBackStatus{Status: stravaWriteBackFailed, Error: message}
+	}
+	return stravaWriteBackStatus{Status: stravaWriteBackUpdated}
+}
+
+// handleActivitiesVisibilityAPI handles POST /api/activities/visibility for bulk updates
+func (s *server) handleActivitiesVisibilityAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req activitiesVisibilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid request body"))
+		return
+	}
+	if !pggeo.ValidActivityVisibility(req.Visibility) {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "visibility must be private or instance"))
+		return
+	}
+	if req.Filter.Visibility != "" && !pggeo.ValidActivityVisibility(req.Filter.Visibility) {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "filter visibility must be private or instance"))
+		return
+	}
+
+	filter := pggeo.ActivityVisibilityFilter{
+		ActivityIDs: req.Filter.ActivityIDs,
+		Type:        strings.TrimSpace(req.Filter.Type),
+		Visibility:  req.Filter.Visibility,
+	}
+	if req.Filter.Start != "" {
+		start, err := time.Parse("2006-01-02", req.Filter.Start)
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid filter start date"))
+			return
+		}
+		filter.StartDate = &start
+	}
+	if req.Filter.End != "" {
+		end, err := time.Parse("2006-01-02", req.Filter.End)
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid filter end date"))
+			return
+		}
+		end = end.AddDate(0, 0, 1) // inclusive end date
+		filter.EndDate = &end
+	}
+
+	var updated int64
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		updated, dbErr = pggeo.SetActivitiesVisibility(s.ctx, conn, scope.AthleteID, req.Visibility, filter)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to bulk update activity visibility", "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"visibility": req.Visibility,
+		"updated":    updated,
+	})
+}
+
+// visibleActivities drops the activities the viewer is not allowed to see
+func visibleActivities(viewerAthleteID int64, activities []strava.ActivitySummary) []strava.ActivitySummary {
+	visible := activities[:0]
+	for _, activity := range activities {
+		if pggeo.ActivityVisibleTo(activity, viewerAthleteID) {
+			visible = append(visible, activity)
+		}
+	}
+	return visible
+}
+
+// visibleSegmentEfforts drops efforts the viewer is not allowed to see. Segments only
+// match their owner's activities, so this guards the listing rather than sharing
+// instance-visible rides of other athletes.
+func visibleSegmentEfforts(viewerAthleteID int64, efforts []pggeo.ActivityWithMatch) []pggeo.ActivityWithMatch {
+	visible := efforts[:0]
+	for _, effort := range efforts {
+		if pggeo.ActivityVisibleTo(effort.ActivitySummary, viewerAthleteID) {
+			visible = append(visible, effort)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func TestVisibleSegmentEffortsHidesOtherAthletesPrivateActivities(t *testing.T) {
+	const viewer = int64(1)
+	const other = int64(2)
+
+	efforts := []pggeo.ActivityWithMatch{
+		{ActivitySummary: strava.ActivitySummary{ID: 10, AthleteID: viewer, InstanceVisibility: pggeo.ActivityVisibilityPrivate}},
+		{ActivitySummary: strava.ActivitySummary{ID: 20, AthleteID: other, InstanceVisibility: pggeo.ActivityVisibilityPrivate}},
+		{ActivitySummary: strava.ActivitySummary{ID: 30, AthleteID: other, InstanceVisibility: pggeo.ActivityVisibilityInstance}},
+		{ActivitySummary: strava.ActivitySummary{ID: 40, AthleteID: other}},
+	}
+
+	visible := visibleSegmentEfforts(viewer, efforts)
+	got := mobileSegmentEffortsFromActivities(visible)
+
+	want := []int64{10, 30}
+	if len(got) != len(want) {
+		t.Fatalf("len = %d, want %d (%#v)", len(got), len(want), got)
+	}
+	for i, id := range want {
+		if got[i].Activity.ID != id {
+			t.Fatalf("effort %d id = %d, want %d", i, got[i].Activity.ID, id)
+		}
+	}
+}
+
+func TestVisibleSegmentEffortsKeepsOwnersPrivateActivities(t *testing.T) {
+	efforts := []pggeo.ActivityWithMatch{
+		{ActivitySummary: strava.ActivitySummary{ID: 20, AthleteID: 2, InstanceVisibility: pggeo.ActivityVisibilityPrivate}},
+	}
+
+	if got := visibleSegmentEfforts(2, efforts); len(got) != 1 {
+		t.Fatalf("owner lost their own private effort: %#v", got)
+	}
+	if got := visibleSegmentEfforts(3, efforts); len(got) != 0 {
+		t.Fatalf("private effort leaked to another athlete: %#v", got)
+	}
+}
+
+func TestWriteActivityBackStatuses(t *testing.T) {
+	name := "Evening loop"
+	fields := strava.ActivityUpdate{Name: &name}
+	var calls int
+	var failWith error
+	s := &server{cfg: Config{StravaWriteBack: true}}
+	s.updateActivity = func(_ context.Context, accessToken string, activityID int64, got strava.ActivityUpdate) error {
+		calls++
+		if accessToken != "token" || activityID != 42 || got.Name == nil || *got.Name != name {
+			t.Errorf("updateActivity(%q, %d, %+v)", accessToken, activityID, got)
+		}
+		return failWith
+	}
+	scope := athleteScope{AthleteID: 1, StravaToken: "token"}
+
+	if got := s.writeActivityBack(context.Background(), scope, 42, fields); got.Status != stravaWriteBackUpdated {
+		t.Fatalf("success: %+v", got)
+	}
+	failWith = fmt.Errorf("%w: status 401", strava.ErrActivityWriteUnauthorized)
+	if got := s.writeActivityBack(context.Background(), scope, 42, fields); got.Status != stravaWriteBackFailed || !strings.Contains(got.Error, "sign in again") {
+		t.Fatalf("unauthorized: %+v", got)
+	}
+	if got := s.writeActivityBack(context.Background(), athleteScope{AthleteID: 1}, 42, fields); got.Status != stravaWriteBackFailed {
+		t.Fatalf("no token: %+v", got)
+	}
+	if got := s.writeActivityBack(context.Background(), scope, pggeo.ImportActivityIDBase+1, fields); got.Status != stravaWriteBackLocal {
+		t.Fatalf("imported activity: %+v", got)
+	}
+	s.cfg.StravaWriteBack = false
+	if got := s.writeActivityBack(context.Background(), scope, 42, fields); got.Status != stravaWriteBackDisabled {
+		t.Fatalf("disabled: %+v", got)
+	}
+	if calls != 2 {
+		t.Fatalf("Strava called %d times, want 2", calls)
+	}
+}
+
+func TestSegmentEffortsOmitPrivateActivitiesForVisitors(t *testing.T) {
+	const owner = int64(1)
+	s := &server{ctx: context.Background()}
+	s.segmentEfforts = func(athleteID, segmentID int64, _ float64, _ string, _ bool, _ pggeo.SegmentEffortFilter) ([]pggeo.ActivityWithMatch, error) {
+		if athleteID != owner || segmentID != 7 {
+			t.Fatalf("efforts of athlete %d segment %d, want %d and 7", athleteID, segmentID, owner)
+		}
+		return []pggeo.ActivityWithMatch{
+			{ActivitySummary: strava.ActivitySummary{ID: 10, AthleteID: owner, InstanceVisibility: pggeo.ActivityVisibilityPrivate}},
+			{ActivitySummary: strava.ActivitySummary{ID: 20, AthleteID: owner, InstanceVisibility: pggeo.ActivityVisibilityInstance}},
+		}, nil
+	}
+	segment := &pggeo.FavoriteSegment{ID: 7, AthleteID: owner}
+	effortIDs := func(scope athleteScope) []int64 {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.handleSegmentActivities(rec, httptest.NewRequest(http.MethodGet, "/api/segments/7/activities?tolerance=15", nil), scope, segment)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var efforts []struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &efforts); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+		ids := make([]int64, len(efforts))
+		for i, effort := range efforts {
+			ids[i] = effort.ID
+		}
+		return ids
+	}
+
+	if ids := effortIDs(athleteScope{AthleteID: owner}); !slices.Equal(ids, []int64{10, 20}) {
+		t.Fatalf("owner sees efforts %v, want both", ids)
+	}
+	// A visitor shown the public athlete is not the owner and only sees the shared ride
+	if ids := effortIDs(athleteScope{AthleteID: owner, Anonymous: true}); !slices.Equal(ids, []int64{20}) {
+		t.Fatalf("visitor sees efforts %v, want only the instance-visible one", ids)
+	}
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// adminScopeFromRequest resolves the caller like webScopeFromRequest and additionally
+// requires their athlete ID to be listed in admin_athlete_ids
+func (s *server) adminScopeFromRequest(w http.ResponseWriter, r *http.Request) (athleteScope, bool) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return athleteScope{}, false
+	}
+	if !slices.Contains(s.cfg.AdminAthleteIDs, scope.AthleteID) {
+		writeError(w, r, newAPIError(http.StatusForbidden, "Admin access required"))
+		return athleteScope{}, false
+	}
+	return scope, true
+}
+
+// handleAdminWebhookFailures handles GET /api/admin/webhooks/failures: outbound webhook
+// deliveries that gave up, newest first
+func (s *server) handleAdminWebhookFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if _, ok := s.adminScopeFromRequest(w, r); !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{"failures": s.outbound.Failures()})
+}
+
+// handleAdminQueues handles GET /api/admin/queues: depth, capacity, overflow policy,
+// drop and spill counts and latency of each in-process queue
+func (s *server) handleAdminQueues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if _, ok := s.adminScopeFromRequest(w, r); !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{"queues": s.queueStats()})
+}
+
+// handleAdminBackfillDistance handles POST /api/admin/backfill-distance: fills in the
+// missing cumulative distances of point samples, of every athlete or only ?athlete_id=
+func (s *server) handleAdminBackfillDistance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if _, ok := s.adminScopeFromRequest(w, r); !ok {
+		return
+	}
+	var athleteID int64
+	if value := r.URL.Query().Get("athlete_id"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid athlete_id"))
+			return
+		}
+		athleteID = parsed
+	}
+
+	var result *pggeo.DistanceBackfillResult
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		result, dbErr = pggeo.BackfillCumulativeDistance(s.ctx, conn, athleteID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to backfill cumulative distance", "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Backfilled cumulative distance", "points", result.Points, "activities", result.Activities)
+	writeJSON(w, result)
+}
+
+// handleAdminResimplify handles POST /api/admin/resimplify: simplifies every stored route
+// and segment again, to ?tolerance= meters or else the configured tolerance. It answers
+// when done; progress is logged per athlete.
+func (s *server) handleAdminResimplify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if _, ok := s.adminScopeFromRequest(w, r); !ok {
+		return
+	}
+	tolerance := pggeo.SimplifyTolerance()
+	if value := r.URL.Query().Get("tolerance"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 || parsed > pggeo.MaxSimplifyToleranceM {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid tolerance"))
+			return
+		}
+		tolerance = parsed
+	}
+
+	var result *pggeo.ResimplifyResult
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		result, dbErr = pggeo.ResimplifyAll(s.ctx, conn, tolerance)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to simplify routes again", "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Simplified routes and segments", "routes", result.Activities, "segments", result.Segments, "tolerance_m", result.ToleranceM)
+	writeJSON(w, result)
+}
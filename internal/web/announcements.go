@@ -0,0 +1,196 @@
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// adminAnnouncementsLimit is how many announcements GET /api/admin/announcements lists
+const adminAnnouncementsLimit = 50
+
+// activeAnnouncements returns the announcements shown now, without the ones the caller's
+// web session dismissed. Only resolved sessions count, so a made-up cookie does not
+// hide anything.
+func (s *server) activeAnnouncements(r *http.Request, scope athleteScope) ([]pggeo.Announcement, error) {
+	tokenKey := ""
+	if scope.Athlete != nil {
+		tokenKey = currentWebSessionKey(r)
+	}
+	var announcements []pggeo.Announcement
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		announcements, dbErr = pggeo.GetActiveAnnouncements(s.ctx, conn, time.Now(), tokenKey)
+		return dbErr
+	})
+	return announcements, err
+}
+
+// pageAnnouncements is activeAnnouncements for the banner of a page, which renders
+// without it when they cannot be loaded
+func (s *server) pageAnnouncements(r *http.Request, scope athleteScope) []pggeo.Announcement {
+	if s.pool == nil {
+		return nil
+	}
+	announcements, err := s.activeAnnouncements(r, scope)
+	if err != nil {
+		slog.Warn("Failed to load announcements", "error", err)
+		return nil
+	}
+	return announcements
+}
+
+// handleAnnouncements lists the active announcements (GET /api/announcements) and
+// dismisses one for the caller's web session (POST /api/announcements/{id}/dismiss).
+// Listing needs no login; API clients without a session get every active announcement.
+func (s *server) handleAnnouncements(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/announcements"), "/")
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		announcements, err := s.activeAnnouncements(r, s.webSessionFromRequest(r))
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"announcements": announcements})
+		return
+	}
+
+	idPart, action, _ := strings.Cut(rest, "/")
+	if action != "dismiss" {
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	announcementID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || announcementID <= 0 {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid announcement id"))
+		return
+	}
+	if _, ok := s.webScopeFromRequest(w, r); !ok {
+		return
+	}
+	tokenKey := currentWebSessionKey(r)
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.DismissAnnouncement(s.ctx, conn, announcementID, tokenKey)
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"dismissed": announcementID})
+}
+
+// adminAnnouncementRequest is the body of POST /api/admin/announcements. Times are
+// RFC 3339; without starts_at the announcement starts now, without ends_at it shows
+// until expired.
+type adminAnnouncementRequest struct {
+	Message  string     `json:"message"`
+	Level    string     `json:"level"`
+	StartsAt *time.Time `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+}
+
+// handleAdminAnnouncements lists the latest announcements (GET /api/admin/announcements),
+// creates one (POST) and expires one now (POST /api/admin/announcements/{id}/expire)
+func (s *server) handleAdminAnnouncements(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.adminScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/announcements"), "/")
+	if rest != "" {
+		s.handleAdminAnnouncementExpire(w, r, scope, rest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var announcements []pggeo.Announcement
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			announcements, dbErr = pggeo.ListAnnouncements(s.ctx, conn, adminAnnouncementsLimit)
+			return dbErr
+		})
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"announcements": announcements})
+	case http.MethodPost:
+		var req adminAnnouncementRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid request body"))
+			return
+		}
+		announcement := pggeo.Announcement{
+			Message:   req.Message,
+			Level:     strings.TrimSpace(req.Level),
+			EndsAt:    req.EndsAt,
+			CreatedBy: scope.AthleteID,
+		}
+		if announcement.Level == "" {
+			announcement.Level = pggeo.AnnouncementLevelInfo
+		}
+		if req.StartsAt != nil {
+			announcement.StartsAt = *req.StartsAt
+		}
+		var created *pggeo.Announcement
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			created, dbErr = pggeo.CreateAnnouncement(s.ctx, conn, announcement)
+			return dbErr
+		})
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Announcement created", "admin_id", scope.AthleteID, "level", created.Level, "announcement_id", created.ID)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, created)
+	default:
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+	}
+}
+
+func (s *server) handleAdminAnnouncementExpire(w http.ResponseWriter, r *http.Request, scope athleteScope, rest string) {
+	idPart, action, _ := strings.Cut(rest, "/")
+	if action != "expire" {
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	announcementID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil || announcementID <= 0 {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid announcement id"))
+		return
+	}
+	var expired *pggeo.Announcement
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		expired, dbErr = pggeo.ExpireAnnouncement(s.ctx, conn, announcementID, time.Now())
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Announcement expired", "admin_id", scope.AthleteID, "announcement_id", announcementID)
+	writeJSON(w, expired)
+}
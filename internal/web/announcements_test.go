@@ -0,0 +1,61 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestAnnouncementEndpointsGuardBeforeTheDatabase(t *testing.T) {
+	// No pool: every case must be answered before a query runs
+	s := &server{
+		ctx:        context.Background(),
+		cfg:        Config{AdminAthleteIDs: []int64{1}},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+	}
+	s.cacheWebAthlete("token-admin", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-rider", &strava.Athlete{ID: 2})
+
+	cases := []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{http.MethodGet, "/api/admin/announcements", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/admin/announcements", "token-rider", `{"message":"Maintenance"}`, http.StatusForbidden},
+		{http.MethodPost, "/api/admin/announcements/3/expire", "token-rider", "", http.StatusForbidden},
+		{http.MethodDelete, "/api/admin/announcements", "token-admin", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/admin/announcements", "token-admin", `{"message":`, http.StatusBadRequest},
+		{http.MethodGet, "/api/admin/announcements/3/expire", "token-admin", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/admin/announcements/x/expire", "token-admin", "", http.StatusBadRequest},
+		{http.MethodPost, "/api/admin/announcements/3/delete", "token-admin", "", http.StatusNotFound},
+		{http.MethodPost, "/api/announcements", "token-rider", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/announcements/3/dismiss", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/announcements/3/dismiss", "token-rider", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/announcements/0/dismiss", "token-rider", "", http.StatusBadRequest},
+	}
+	h := s.routes()
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.token != "" {
+			req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: tc.token})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %s as %q = %d, want %d", tc.method, tc.path, tc.token, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestPageAnnouncementsWithoutDatabaseRendersNone(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	req := httptest.NewRequest(http.MethodGet, "/strava/", nil)
+	if got := s.pageAnnouncements(req, athleteScope{}); got != nil {
+		t.Fatalf("pageAnnouncements = %v, want none", got)
+	}
+}
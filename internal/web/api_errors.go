@@ -0,0 +1,129 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"b11k/internal/pggeo"
+)
+
+// Error codes beyond the ones derived from the status
+const (
+	errorCodeInvalidInput  = "invalid_input"
+	errorCodeAlreadyExists = "already_exists"
+	errorCodeDatabaseBusy  = "database_busy"
+)
+
+// apiError is an error answered to an API client as
+// {"error": {"code": "...", "message": "..."}}. Message is what the client sees; Err,
+// when set, is only logged.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	// RetryAfterSeconds, when set, is sent as Retry-After and in the body
+	RetryAfterSeconds int
+	Err               error
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// newAPIError is an error with a message safe to show, its code derived from the status
+func newAPIError(status int, message string) *apiError {
+	return &apiError{Status: status, Code: statusErrorCode(status), Message: message}
+}
+
+// statusErrorCode names a status the way the API codes do, e.g. 404 is "not_found"
+func statusErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// apiErrorFor turns err into what the client may see. An *apiError is kept, pggeo domain
+// errors keep their own message and everything else answers fallbackStatus with the
+// generic status text, so SQL and driver messages never reach the client.
+func apiErrorFor(err error, fallbackStatus int) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if isRecoverableDBError(err) {
+		return &apiError{
+			Status:            http.StatusServiceUnavailable,
+			Code:              errorCodeDatabaseBusy,
+			Message:           "Database is recovering. Please retry shortly.",
+			RetryAfterSeconds: 2,
+			Err:               err,
+		}
+	}
+	status, code := fallbackStatus, ""
+	switch {
+	case errors.Is(err, pggeo.ErrNotFound), errors.Is(err, pggeo.ErrForeignAthlete):
+		status = http.StatusNotFound
+	case errors.Is(err, pggeo.ErrAlreadyExists):
+		status, code = http.StatusConflict, errorCodeAlreadyExists
+	case errors.Is(err, pggeo.ErrInvalidInput):
+		status, code = http.StatusBadRequest, errorCodeInvalidInput
+	}
+	if code == "" {
+		code = statusErrorCode(status)
+	}
+	message := http.StatusText(status)
+	// Another athlete's rows answer like missing ones, so their existence does not leak
+	var domainErr *pggeo.Error
+	if errors.As(err, &domainErr) && !errors.Is(err, pggeo.ErrForeignAthlete) {
+		message = domainErr.Msg
+	}
+	return &apiError{Status: status, Code: code, Message: message, Err: err}
+}
+
+// writeError answers err as a JSON error under /api/ and to clients asking for JSON,
+// and as plain text otherwise. Server errors are logged with their cause.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeAPIError(w, r, apiErrorFor(err, http.StatusInternalServerError))
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, apiErr *apiError) {
+	if apiErr.Status >= http.StatusInternalServerError && apiErr.Err != nil {
+		// #nosec G706 -- request path is escaped before logging.
+		slog.Error("Request failed", "method", r.Method, "path", safeLogText(r.URL.Path), "error", apiErr.Err)
+	}
+	if apiErr.RetryAfterSeconds > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfterSeconds))
+	}
+	if !wantsJSON(r) {
+		http.Error(w, apiErr.Message, apiErr.Status)
+		return
+	}
+	code := apiErr.Code
+	if code == "" {
+		code = statusErrorCode(apiErr.Status)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": struct {
+			Code              string `json:"code"`
+			Message           string `json:"message"`
+			RetryAfterSeconds int    `json:"retry_after_seconds,omitempty"`
+		}{code, apiErr.Message, apiErr.RetryAfterSeconds},
+	})
+}
+
+// wantsJSON reports whether errors for r are answered as JSON
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
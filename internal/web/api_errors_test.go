@@ -0,0 +1,102 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+type errorBody struct {
+	Error struct {
+		Code              string `json:"code"`
+		Message           string `json:"message"`
+		RetryAfterSeconds int    `json:"retry_after_seconds"`
+	} `json:"error"`
+}
+
+func decodeErrorBody(t *testing.T, rec *httptest.ResponseRecorder) errorBody {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type = %q, want JSON (%s)", ct, rec.Body.String())
+	}
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+func TestDBFailureAnswersGenericJSON(t *testing.T) {
+	s, _ := newSessionsTestServer(t)
+	s.cacheWebAthlete("token-a", &strava.Athlete{ID: 1})
+	s.listSessions = func(int64) ([]pggeo.WebSession, error) {
+		return nil, fmt.Errorf("failed to scan web session: %w", errors.New(`ERROR: column "token_hash" does not exist (SQLSTATE 42703)`))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	body := decodeErrorBody(t, rec)
+	if body.Error.Code != "internal_server_error" || body.Error.Message != "Internal Server Error" {
+		t.Fatalf("error = %+v, want the generic 500", body.Error)
+	}
+	for _, leak := range []string{"scan", "token_hash", "SQLSTATE"} {
+		if strings.Contains(rec.Body.String(), leak) {
+			t.Fatalf("body %q leaks %q", rec.Body.String(), leak)
+		}
+	}
+}
+
+func TestAPIErrorForMapsDomainErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"not found", fmt.Errorf("failed to load: %w", &pggeo.Error{Kind: pggeo.ErrNotFound, Msg: "activity with ID 7 not found"}), http.StatusNotFound, "not_found", "activity with ID 7 not found"},
+		{"missing activity", fmt.Errorf("failed to load: %w", fmt.Errorf("activity with ID 7 has no samples: %w", pggeo.ErrActivityNotFound)), http.StatusNotFound, "not_found", "activity not found"},
+		{"foreign athlete", &pggeo.Error{Kind: pggeo.ErrForeignAthlete, Msg: "activity with ID 7 belongs to another athlete"}, http.StatusNotFound, "not_found", "Not Found"},
+		{"invalid input", &pggeo.Error{Kind: pggeo.ErrInvalidInput, Msg: "notes are longer than 4096 bytes"}, http.StatusBadRequest, "invalid_input", "notes are longer than 4096 bytes"},
+		{"already exists", &pggeo.Error{Kind: pggeo.ErrAlreadyExists, Msg: "activity with ID 7 already exists"}, http.StatusConflict, "already_exists", "activity with ID 7 already exists"},
+		{"api error", newAPIError(http.StatusBadRequest, "invalid id"), http.StatusBadRequest, "bad_request", "invalid id"},
+		{"database busy", errors.New("failed to connect: connection refused"), http.StatusServiceUnavailable, "database_busy", "Database is recovering. Please retry shortly."},
+		{"anything else", errors.New("failed to scan activity: bad column"), http.StatusInternalServerError, "internal_server_error", "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, httptest.NewRequest(http.MethodGet, "/api/activities/7", nil), tt.err)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			body := decodeErrorBody(t, rec)
+			if body.Error.Code != tt.code || body.Error.Message != tt.message {
+				t.Fatalf("error = %+v, want %s %q", body.Error, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestWriteErrorOnPagesIsPlainText(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, httptest.NewRequest(http.MethodGet, "/activity/7", nil), errors.New("failed to scan activity: bad column"))
+	if rec.Code != http.StatusInternalServerError || strings.TrimSpace(rec.Body.String()) != "Internal Server Error" {
+		t.Fatalf("page error = %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Content-Type = %q, want plain text", ct)
+	}
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"b11k/internal/cache"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultAthleteCacheTTL = 15 * time.Minute
+	webAthleteNegativeTTL  = time.Minute
+	webAthleteCacheMaxSize = 1024
+)
+
+// errWebLoginRejected marks a login Strava refused (revoked or invalid token, failed
+// refresh). Such logins are cached negatively for webAthleteNegativeTTL.
+var errWebLoginRejected = errors.New("Strava rejected the login")
+
+// athleteCache maps web login cookies to their athlete and current access token, so each
+// request can resolve its identity without calling Strava. Concurrent first requests for
+// one login share a single lookup. The zero value is ready to use.
+type athleteCache struct {
+	mu      sync.Mutex
+	entries *cache.LRU[string, webAthleteEntry]
+	flights singleflight.Group
+	ttl     time.Duration    // defaultAthleteCacheTTL when zero
+	now     func() time.Time // time.Now when nil
+
+	hits         atomic.Int64
+	misses       atomic.Int64
+	negativeHits atomic.Int64
+	stravaCalls  atomic.Int64 // athlete lookups that reached Strava
+}
+
+// get returns the cached login for key, or runs load once for all concurrent callers and
+// caches its result. Errors wrapping errWebLoginRejected are cached negatively.
+func (c *athleteCache) get(key string, load func() (webAthleteEntry, error)) (webAthleteEntry, error) {
+	if entry, ok := c.lookup(key); ok {
+		if entry.Rejected {
+			c.negativeHits.Add(1)
+			return webAthleteEntry{}, errWebLoginRejected
+		}
+		c.hits.Add(1)
+		return entry, nil
+	}
+	c.misses.Add(1)
+
+	result, err, _ := c.flights.Do(key, func() (interface{}, error) {
+		// A flight that finished after this caller's miss has already filled the cache
+		if entry, ok := c.lookup(key); ok {
+			if entry.Rejected {
+				return nil, errWebLoginRejected
+			}
+			return entry, nil
+		}
+		entry, err := load()
+		if err != nil {
+			if errors.Is(err, errWebLoginRejected) {
+				c.reject(key)
+			}
+			return nil, err
+		}
+		c.store(key, entry)
+		return entry, nil
+	})
+	if err != nil {
+		return webAthleteEntry{}, err
+	}
+	return result.(webAthleteEntry), nil
+}
+
+// lookup returns a live entry; expired entries and logins whose access token is due for
+// refresh count as absent
+func (c *athleteCache) lookup(key string) (webAthleteEntry, bool) {
+	now := c.clock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return webAthleteEntry{}, false
+	}
+	entry, ok := c.entries.Get(key)
+	if !ok {
+		return webAthleteEntry{}, false
+	}
+	if !now.Before(entry.ExpiresAt) || (!entry.Rejected && webTokenNeedsRefresh(entry.TokenExpiresAt, now)) {
+		c.entries.Remove(key)
+		return webAthleteEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *athleteCache) store(key string, entry webAthleteEntry) {
+	ttl := c.ttl
+	if ttl <= 0 {
+		ttl = defaultAthleteCacheTTL
+	}
+	entry.ExpiresAt = c.clock().Add(ttl)
+	c.put(key, entry)
+}
+
+func (c *athleteCache) reject(key string) {
+	c.put(key, webAthleteEntry{Rejected: true, ExpiresAt: c.clock().Add(webAthleteNegativeTTL)})
+}
+
+func (c *athleteCache) put(key string, entry webAthleteEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = cache.NewLRU[string, webAthleteEntry](webAthleteCacheMaxSize)
+	}
+	c.entries.Add(key, entry)
+}
+
+func (c *athleteCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries != nil {
+		c.entries.Remove(key)
+	}
+}
+
+func (c *athleteCache) forgetAthlete(athleteID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries != nil {
+		c.entries.RemoveFunc(func(_ string, entry webAthleteEntry) bool {
+			return entry.Athlete != nil && entry.Athlete.ID == athleteID
+		})
+	}
+}
+
+func (c *athleteCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return 0
+	}
+	return c.entries.Len()
+}
+
+func (c *athleteCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// snapshot reports the cache counters for /readyz
+func (c *athleteCache) snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"entries":       c.len(),
+		"hits":          c.hits.Load(),
+		"misses":        c.misses.Load(),
+		"negative_hits": c.negativeHits.Load(),
+		"strava_calls":  c.stravaCalls.Load(),
+	}
+}
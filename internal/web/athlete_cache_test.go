@@ -0,0 +1,176 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+)
+
+// fakeStravaAthletes counts athlete lookups and fails for tokens listed in revoked
+type fakeStravaAthletes struct {
+	calls   atomic.Int64
+	revoked map[string]bool
+	release chan struct{} // when set, lookups wait for it to close
+}
+
+func (f *fakeStravaAthletes) fetch(accessToken string) (*strava.Athlete, error) {
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	if f.revoked[accessToken] {
+		return nil, fmt.Errorf("fetch athlete failed: 401")
+	}
+	return &strava.Athlete{ID: 42, FirstName: accessToken}, nil
+}
+
+// loginForTest resolves token the way webLogin does, minus the stored-token database lookup
+func loginForTest(s *server, token string) (webAthleteEntry, error) {
+	return s.webAthletes.get(webSessionStorageKey(token), func() (webAthleteEntry, error) {
+		athlete, err := s.fetchCurrentAthlete(token)
+		if err != nil {
+			return webAthleteEntry{}, fmt.Errorf("%w: %w", errWebLoginRejected, err)
+		}
+		return webAthleteEntry{Athlete: athlete, AccessToken: token}, nil
+	})
+}
+
+func newAthleteCacheTestServer(fake *fakeStravaAthletes, ttl time.Duration) (*server, *time.Time) {
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	s := &server{fetchAthlete: fake.fetch}
+	s.webAthletes.ttl = ttl
+	s.webAthletes.now = func() time.Time { return now }
+	return s, &now
+}
+
+func TestAthleteCacheOneStravaCallPerTTL(t *testing.T) {
+	fake := &fakeStravaAthletes{}
+	s, now := newAthleteCacheTestServer(fake, 15*time.Minute)
+
+	for i := 0; i < 5; i++ {
+		entry, err := loginForTest(s, "token-a")
+		if err != nil || entry.Athlete.ID != 42 {
+			t.Fatalf("login %d = %+v, %v", i, entry, err)
+		}
+	}
+	*now = now.Add(14 * time.Minute)
+	if _, err := loginForTest(s, "token-a"); err != nil {
+		t.Fatalf("login inside TTL: %v", err)
+	}
+	if got := fake.calls.Load(); got != 1 {
+		t.Fatalf("Strava calls inside one TTL window = %d, want 1", got)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if _, err := loginForTest(s, "token-a"); err != nil {
+		t.Fatalf("login after TTL: %v", err)
+	}
+	if got := fake.calls.Load(); got != 2 {
+		t.Fatalf("Strava calls after the TTL expired = %d, want 2", got)
+	}
+	if s.webAthletes.hits.Load() != 5 || s.webAthletes.misses.Load() != 2 {
+		t.Fatalf("hits/misses = %d/%d, want 5/2", s.webAthletes.hits.Load(), s.webAthletes.misses.Load())
+	}
+}
+
+func TestAthleteCacheSharesConcurrentFirstLookup(t *testing.T) {
+	fake := &fakeStravaAthletes{release: make(chan struct{})}
+	s, _ := newAthleteCacheTestServer(fake, time.Minute)
+
+	const requests = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := loginForTest(s, "token-a")
+			errs <- err
+		}()
+	}
+	// Hold Strava until every request has missed the cache
+	for s.webAthletes.misses.Load() < requests {
+		time.Sleep(time.Millisecond)
+	}
+	close(fake.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent login: %v", err)
+		}
+	}
+	if got := fake.calls.Load(); got != 1 {
+		t.Fatalf("Strava calls for %d concurrent first requests = %d, want 1", requests, got)
+	}
+}
+
+func TestAthleteCacheRemembersRejectedTokens(t *testing.T) {
+	fake := &fakeStravaAthletes{revoked: map[string]bool{"revoked": true}}
+	s, now := newAthleteCacheTestServer(fake, 15*time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if _, err := loginForTest(s, "revoked"); !errors.Is(err, errWebLoginRejected) {
+			t.Fatalf("login %d error = %v, want errWebLoginRejected", i, err)
+		}
+	}
+	if got := fake.calls.Load(); got != 1 {
+		t.Fatalf("Strava calls for a revoked token = %d, want 1", got)
+	}
+	if s.webAthletes.negativeHits.Load() != 2 {
+		t.Fatalf("negative hits = %d, want 2", s.webAthletes.negativeHits.Load())
+	}
+
+	*now = now.Add(webAthleteNegativeTTL + time.Second)
+	if _, err := loginForTest(s, "revoked"); err == nil {
+		t.Fatal("revoked token accepted")
+	}
+	if got := fake.calls.Load(); got != 2 {
+		t.Fatalf("Strava calls after the negative TTL = %d, want 2", got)
+	}
+}
+
+func TestAthleteCacheInvalidation(t *testing.T) {
+	fake := &fakeStravaAthletes{}
+	s, _ := newAthleteCacheTestServer(fake, 15*time.Minute)
+
+	if _, err := loginForTest(s, "token-a"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	// Logout and token refresh both forget the login's cached identity
+	s.forgetWebSession("token-a")
+	if _, err := loginForTest(s, "token-a"); err != nil {
+		t.Fatalf("login after logout: %v", err)
+	}
+	if got := fake.calls.Load(); got != 2 {
+		t.Fatalf("Strava calls after invalidation = %d, want 2", got)
+	}
+
+	// A login whose access token is due for refresh is looked up again
+	s.cacheWebLogin("token-b", webAthleteEntry{Athlete: &strava.Athlete{ID: 7}, AccessToken: "token-b", TokenExpiresAt: s.webAthletes.clock().Add(time.Minute)})
+	if _, err := loginForTest(s, "token-b"); err != nil {
+		t.Fatalf("login with expiring token: %v", err)
+	}
+	if got := fake.calls.Load(); got != 3 {
+		t.Fatalf("Strava calls for an expiring token = %d, want 3", got)
+	}
+}
+
+func TestAthleteCacheIsBounded(t *testing.T) {
+	fake := &fakeStravaAthletes{}
+	s, _ := newAthleteCacheTestServer(fake, time.Hour)
+	for i := 0; i < webAthleteCacheMaxSize+10; i++ {
+		s.cacheWebAthlete(fmt.Sprintf("token-%d", i), &strava.Athlete{ID: int64(i)})
+	}
+	if got := s.webAthletes.len(); got != webAthleteCacheMaxSize {
+		t.Fatalf("cache holds %d entries, want %d", got, webAthleteCacheMaxSize)
+	}
+	if _, ok := s.webAthletes.lookup(webSessionStorageKey("token-0")); ok {
+		t.Fatal("the least recently used login was not evicted")
+	}
+}
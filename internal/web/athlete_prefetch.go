@@ -0,0 +1,317 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"b11k/internal/cache"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	athletePrefetchRunning = "running"
+	athletePrefetchDone    = "done"
+	athletePrefetchFailed  = "failed"
+
+	// athleteProfileCacheSize bounds the prefetched profiles kept in memory
+	athleteProfileCacheSize = 1024
+
+	// athleteRefreshInterval is how old a stored athlete may get before resolving a login
+	// refreshes it from Strava in the background
+	athleteRefreshInterval = 24 * time.Hour
+)
+
+// athletePrefetch fetches one athlete's profile, HR zones and gear from Strava after login
+type athletePrefetch struct {
+	state      string
+	startedAt  time.Time
+	finishedAt time.Time
+	err        string
+}
+
+// athleteReady is the JSON reported by GET /api/me/ready
+type athleteReady struct {
+	Ready bool   `json:"ready"`
+	State string `json:"state"` // warm, running or failed
+	Error string `json:"error,omitempty"`
+}
+
+// queueAthletePrefetch fetches the athlete's Strava metadata in the background unless a
+// prefetch is already running for them. It never blocks.
+func (s *server) queueAthletePrefetch(athleteID int64, accessToken string) {
+	s.prefetchMu.Lock()
+	defer s.prefetchMu.Unlock()
+	if job := s.prefetches[athleteID]; job != nil && job.state == athletePrefetchRunning {
+		return
+	}
+	job := &athletePrefetch{state: athletePrefetchRunning, startedAt: time.Now()}
+	if s.prefetches == nil {
+		s.prefetches = make(map[int64]*athletePrefetch)
+	}
+	s.prefetches[athleteID] = job
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		s.runAthletePrefetch(athleteID, accessToken, job)
+	}()
+}
+
+// runAthletePrefetch fetches the athlete detail (with gear) and HR zones, waiting for the
+// Strava rate limiter, and stores them. Missing HR zones do not fail the prefetch; a
+// failed database write keeps the profile in memory until the next login.
+func (s *server) runAthletePrefetch(athleteID int64, accessToken string, job *athletePrefetch) {
+	started := time.Now()
+	profile, err := s.fetchAthleteProfile(s.jobContext(), accessToken)
+	if err == nil && profile.Athlete.ID != athleteID {
+		err = errForbidden
+	}
+	if err != nil {
+		s.digest.Warn(digestPrefetch, "failed", "Strava prefetch failed", "athlete_id", athleteID, "error", err)
+		s.prefetchMu.Lock()
+		job.state = athletePrefetchFailed
+		job.err = err.Error()
+		job.finishedAt = time.Now()
+		s.prefetchMu.Unlock()
+		return
+	}
+
+	if err := s.saveAthleteProfile(profile); err != nil {
+		s.digest.Warn(digestPrefetch, "failed", "Failed to store prefetched Strava profile", "athlete_id", athleteID, "error", err)
+	}
+	s.prefetchMu.Lock()
+	s.cacheAthleteProfile(profile)
+	job.state = athletePrefetchDone
+	job.finishedAt = time.Now()
+	s.prefetchMu.Unlock()
+	s.digest.Record(digestPrefetch, "prefetched", "Prefetched Strava profile", "athlete_id", athleteID, "gear", len(profile.Gear), "duration", time.Since(started).Round(time.Millisecond))
+}
+
+// fetchAthleteProfile calls Strava's athlete and zones endpoints, or the fake installed by tests
+func (s *server) fetchAthleteProfile(ctx context.Context, accessToken string) (*pggeo.AthleteProfile, error) {
+	if s.prefetchStrava != nil {
+		return s.prefetchStrava(ctx, accessToken)
+	}
+	detail, err := strava.FetchAthleteDetail(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	profile := &pggeo.AthleteProfile{Athlete: detail.Athlete, PrefetchedAt: time.Now()}
+	for _, bike := range detail.Bikes {
+		profile.Gear = append(profile.Gear, pggeo.Gear{ID: bike.ID, Name: bike.Name, Kind: pggeo.GearKindBike})
+	}
+	for _, shoe := range detail.Shoes {
+		profile.Gear = append(profile.Gear, pggeo.Gear{ID: shoe.ID, Name: shoe.Name, Kind: pggeo.GearKindShoe})
+	}
+	zones, err := strava.FetchHeartRateZonesContext(ctx, accessToken)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Warn("Prefetching without HR zones", "athlete_id", detail.ID, "error", err)
+	} else {
+		profile.HRZones = &zones.HeartRate
+	}
+	return profile, nil
+}
+
+func (s *server) saveAthleteProfile(profile *pggeo.AthleteProfile) error {
+	if s.storeProfile != nil {
+		return s.storeProfile(profile)
+	}
+	return s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.SaveAthleteProfile(s.ctx, conn, profile)
+	})
+}
+
+// cacheAthleteProfile keeps a profile in memory; the caller holds prefetchMu
+func (s *server) cacheAthleteProfile(profile *pggeo.AthleteProfile) {
+	if s.profiles == nil {
+		s.profiles = cache.NewLRU[int64, *pggeo.AthleteProfile](athleteProfileCacheSize)
+	}
+	s.profiles.Add(profile.Athlete.ID, profile)
+}
+
+// athleteProfile returns the athlete's prefetched profile from memory or the database,
+// or nil when none has been prefetched yet
+func (s *server) athleteProfile(athleteID int64) *pggeo.AthleteProfile {
+	s.prefetchMu.Lock()
+	if s.profiles != nil {
+		if profile, ok := s.profiles.Get(athleteID); ok {
+			s.prefetchMu.Unlock()
+			return profile
+		}
+	}
+	s.prefetchMu.Unlock()
+
+	var profile *pggeo.AthleteProfile
+	var err error
+	if s.loadProfile != nil {
+		profile, err = s.loadProfile(athleteID)
+	} else {
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			profile, dbErr = pggeo.GetAthleteProfile(s.ctx, conn, athleteID)
+			return dbErr
+		})
+	}
+	if err != nil {
+		slog.Warn("Failed to load Strava profile", "athlete_id", athleteID, "error", err)
+		return nil
+	}
+	if profile != nil {
+		s.prefetchMu.Lock()
+		s.cacheAthleteProfile(profile)
+		s.prefetchMu.Unlock()
+	}
+	return profile
+}
+
+// resolveAthlete returns the athlete behind a login from the database: the prefetched
+// profile, else the athletes table. A stored athlete older than athleteRefreshInterval
+// queues a background prefetch with accessToken. Strava is only called when neither is
+// stored, and the athlete it returns is stored for next time.
+func (s *server) resolveAthlete(athleteID int64, accessToken string) (*strava.Athlete, error) {
+	if profile := s.athleteProfile(athleteID); profile != nil {
+		s.refreshStaleAthlete(athleteID, accessToken, profile.PrefetchedAt)
+		athlete := profile.Athlete
+		return &athlete, nil
+	}
+	if stored := s.storedAthlete(athleteID); stored != nil {
+		s.refreshStaleAthlete(athleteID, accessToken, stored.UpdatedAt)
+		athlete := stored.Athlete
+		return &athlete, nil
+	}
+	athlete, err := s.fetchCurrentAthlete(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	s.rememberAthlete(athlete)
+	return athlete, nil
+}
+
+// refreshStaleAthlete queues a prefetch when the athlete was stored more than
+// athleteRefreshInterval ago; the prefetch rewrites both the profile and athletes
+func (s *server) refreshStaleAthlete(athleteID int64, accessToken string, storedAt time.Time) {
+	if accessToken != "" && time.Since(storedAt) > athleteRefreshInterval {
+		s.queueAthletePrefetch(athleteID, accessToken)
+	}
+}
+
+// storedAthlete reads the athletes table, returning nil when the athlete is not stored or
+// the read failed
+func (s *server) storedAthlete(athleteID int64) *pggeo.StoredAthlete {
+	var stored *pggeo.StoredAthlete
+	var err error
+	if s.loadAthlete != nil {
+		stored, err = s.loadAthlete(athleteID)
+	} else {
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			stored, dbErr = pggeo.GetAthlete(s.ctx, conn, athleteID)
+			return dbErr
+		})
+	}
+	if err != nil {
+		slog.Warn("Failed to load stored athlete", "athlete_id", athleteID, "error", err)
+		return nil
+	}
+	return stored
+}
+
+// rememberAthlete stores the athlete's identity as just seen on Strava. Failures are only
+// logged: the next login stores it again.
+func (s *server) rememberAthlete(athlete *strava.Athlete) {
+	var err error
+	if s.storeAthlete != nil {
+		err = s.storeAthlete(athlete)
+	} else {
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			return pggeo.UpsertAthlete(s.ctx, conn, athlete)
+		})
+	}
+	if err != nil {
+		slog.Warn("Failed to store athlete", "athlete_id", athlete.ID, "error", err)
+	}
+}
+
+// warmProfile returns the scope's prefetched profile. Logins from before prefetching
+// have none yet; one is queued and the caller falls back to Strava this time.
+func (s *server) warmProfile(scope athleteScope) *pggeo.AthleteProfile {
+	if scope.Athlete == nil {
+		return nil
+	}
+	profile := s.athleteProfile(scope.AthleteID)
+	if profile == nil && scope.StravaToken != "" {
+		s.queueAthletePrefetch(scope.AthleteID, scope.StravaToken)
+	}
+	return profile
+}
+
+// athleteHRZones returns the athlete's HR zones, nil when they have none
+func (s *server) athleteHRZones(ctx context.Context, scope athleteScope) (*strava.HeartRateZones, error) {
+	if profile := s.warmProfile(scope); profile != nil {
+		return profile.HRZones, nil
+	}
+	if scope.StravaToken == "" {
+		return nil, nil
+	}
+	var zones *strava.AthleteZones
+	var err error
+	if s.fetchZones != nil {
+		zones, err = s.fetchZones(scope.StravaToken)
+	} else {
+		zones, err = strava.FetchHeartRateZones(ctx, scope.StravaToken)
+	}
+	if err != nil || zones == nil {
+		return nil, err
+	}
+	return &zones.HeartRate, nil
+}
+
+// forgetAthleteProfile drops the athlete's in-memory profile and prefetch state
+func (s *server) forgetAthleteProfile(athleteID int64) {
+	s.prefetchMu.Lock()
+	defer s.prefetchMu.Unlock()
+	if s.profiles != nil {
+		s.profiles.Remove(athleteID)
+	}
+	delete(s.prefetches, athleteID)
+}
+
+// handleMeReady reports whether the login prefetch has landed, so the frontend can show a
+// short "setting things up" state. A failed prefetch is ready too: pages then fall back
+// to Strava.
+func (s *server) handleMeReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	s.prefetchMu.Lock()
+	job := s.prefetches[scope.AthleteID]
+	var ready athleteReady
+	if job != nil {
+		ready = athleteReady{State: job.state, Error: job.err}
+	}
+	s.prefetchMu.Unlock()
+
+	switch {
+	case ready.State == athletePrefetchRunning:
+	case ready.State == athletePrefetchFailed:
+		ready.Ready = true
+	case s.warmProfile(scope) != nil:
+		ready = athleteReady{Ready: true, State: "warm"}
+	default:
+		// warmProfile queued a prefetch for this older login
+		ready = athleteReady{State: athletePrefetchRunning}
+	}
+	writeJSON(w, ready)
+}
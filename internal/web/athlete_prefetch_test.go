@@ -0,0 +1,202 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// fakeStravaMetadata counts the Strava calls behind logins and pages
+type fakeStravaMetadata struct {
+	prefetches atomic.Int32
+	pageCalls  atomic.Int32
+	stored     atomic.Int32
+	release    chan struct{} // when set, prefetches wait for it to close
+	err        error
+}
+
+func newPrefetchTestServer(fake *fakeStravaMetadata) *server {
+	s := &server{ctx: context.Background()}
+	s.prefetchStrava = func(ctx context.Context, accessToken string) (*pggeo.AthleteProfile, error) {
+		fake.prefetches.Add(1)
+		if fake.release != nil {
+			<-fake.release
+		}
+		if fake.err != nil {
+			return nil, fake.err
+		}
+		return &pggeo.AthleteProfile{
+			Athlete: strava.Athlete{ID: 7, FirstName: "Ada"},
+			HRZones: &strava.HeartRateZones{Zones: []strava.HRZone{{Min: 0, Max: 130}, {Min: 130, Max: -1}}},
+			Gear:    []pggeo.Gear{{ID: "b1", Name: "Road", Kind: pggeo.GearKindBike}},
+		}, nil
+	}
+	s.storeProfile = func(*pggeo.AthleteProfile) error {
+		fake.stored.Add(1)
+		return nil
+	}
+	s.fetchAthlete = func(string) (*strava.Athlete, error) {
+		fake.pageCalls.Add(1)
+		return &strava.Athlete{ID: 7}, nil
+	}
+	s.fetchZones = func(string) (*strava.AthleteZones, error) {
+		fake.pageCalls.Add(1)
+		return &strava.AthleteZones{}, nil
+	}
+	s.fetchGear = func(_ context.Context, _, gearID string) (*strava.Gear, error) {
+		fake.pageCalls.Add(1)
+		return &strava.Gear{ID: gearID, Name: "from Strava"}, nil
+	}
+	s.storeToken = func(string, webStoredToken) error { return nil }
+	s.storeAthlete = func(*strava.Athlete) error { return nil }
+	return s
+}
+
+func meReady(t *testing.T, s *server, sessionID string) athleteReady {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/me/ready", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: sessionID})
+	rec := httptest.NewRecorder()
+	s.handleMeReady(rec, req)
+	var ready athleteReady
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &ready) != nil {
+		t.Fatalf("ready = %d %q", rec.Code, rec.Body.String())
+	}
+	return ready
+}
+
+func TestWarmLoginServesPagesWithoutStrava(t *testing.T) {
+	fake := &fakeStravaMetadata{release: make(chan struct{})}
+	s := newPrefetchTestServer(fake)
+
+	sessionID, err := s.startWebLogin(&strava.StravaTokenResponse{AccessToken: "token-a", RefreshToken: "refresh-a", Athlete: &strava.Athlete{ID: 7}})
+	if err != nil {
+		t.Fatalf("startWebLogin: %v", err)
+	}
+	if ready := meReady(t, s, sessionID); ready.Ready || ready.State != athletePrefetchRunning {
+		t.Fatalf("ready while prefetching = %+v, want running", ready)
+	}
+	close(fake.release)
+	s.jobs.Wait()
+	if ready := meReady(t, s, sessionID); !ready.Ready || ready.State != "warm" {
+		t.Fatalf("ready after the prefetch = %+v, want warm", ready)
+	}
+	if fake.prefetches.Load() != 1 || fake.stored.Load() != 1 {
+		t.Fatalf("%d prefetches stored %d times, want 1", fake.prefetches.Load(), fake.stored.Load())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: sessionID})
+	scope := s.webSessionFromRequest(req)
+	if scope.AthleteID != 7 {
+		t.Fatalf("scope athlete = %d, want 7", scope.AthleteID)
+	}
+	zones, err := s.athleteHRZones(context.Background(), scope)
+	if err != nil || zones == nil || len(zones.Zones) != 2 {
+		t.Fatalf("HR zones = %+v, %v; want the prefetched zones", zones, err)
+	}
+	activities := s.enrichGearNames(scope, []strava.ActivitySummary{{ID: 1, GearID: "b1"}, {ID: 2, GearID: "b1"}})
+	if activities[0].GearName == nil || *activities[0].GearName != "Road" || *activities[1].GearName != "Road" {
+		t.Fatalf("gear names = %v, %v; want Road", activities[0].GearName, activities[1].GearName)
+	}
+	rec := httptest.NewRecorder()
+	s.handleHRZones(rec, req)
+	var athleteZones strava.AthleteZones
+	if err := json.Unmarshal(rec.Body.Bytes(), &athleteZones); err != nil || len(athleteZones.HeartRate.Zones) != 2 {
+		t.Fatalf("/api/hrzones = %q, want the prefetched zones", rec.Body.String())
+	}
+	if profileZones, zonesError := buildProfileHRZones(s.athleteHRZones(context.Background(), scope)); len(profileZones) != 2 || zonesError != "" {
+		t.Fatalf("profile zones = %+v %q", profileZones, zonesError)
+	}
+
+	if got := fake.pageCalls.Load(); got != 0 {
+		t.Fatalf("login and pages made %d Strava calls, want 0", got)
+	}
+}
+
+func TestFailedPrefetchIsReadyAndPagesFallBackToStrava(t *testing.T) {
+	withShortDBRetryBackoff(t)
+	fake := &fakeStravaMetadata{err: errors.New("status 500")}
+	s := newPrefetchTestServer(fake)
+	s.pool = unreachablePool(t)
+	s.cacheWebAthlete("token-a", &strava.Athlete{ID: 7})
+	scope := athleteScope{AthleteID: 7, Athlete: &strava.Athlete{ID: 7}, StravaToken: "token-a"}
+
+	// A login from before prefetching has no profile: the page asks Strava and queues one
+	if _, err := s.athleteHRZones(context.Background(), scope); err != nil {
+		t.Fatalf("athleteHRZones: %v", err)
+	}
+	s.jobs.Wait()
+	if fake.pageCalls.Load() != 1 || fake.prefetches.Load() != 1 {
+		t.Fatalf("%d page calls and %d prefetches, want 1 each", fake.pageCalls.Load(), fake.prefetches.Load())
+	}
+	if ready := meReady(t, s, "token-a"); !ready.Ready || ready.State != athletePrefetchFailed || ready.Error == "" {
+		t.Fatalf("ready after a failed prefetch = %+v, want ready with the error", ready)
+	}
+	if fake.stored.Load() != 0 {
+		t.Fatal("a failed prefetch stored a profile")
+	}
+}
+
+func TestPrefetchIsQueuedOncePerAthlete(t *testing.T) {
+	fake := &fakeStravaMetadata{release: make(chan struct{})}
+	s := newPrefetchTestServer(fake)
+	for i := 0; i < 3; i++ {
+		s.queueAthletePrefetch(7, "token-a")
+	}
+	close(fake.release)
+	s.jobs.Wait()
+	if got := fake.prefetches.Load(); got != 1 {
+		t.Fatalf("%d prefetches, want 1", got)
+	}
+	s.forgetWebAthlete(7)
+	s.prefetchMu.Lock()
+	_, cached := s.profiles.Get(7)
+	s.prefetchMu.Unlock()
+	if cached {
+		t.Fatal("deleting the athlete kept their profile in memory")
+	}
+}
+
+func TestResolveAthleteReadsTheDatabaseBeforeStrava(t *testing.T) {
+	fake := &fakeStravaMetadata{}
+	s := newPrefetchTestServer(fake)
+	stored := map[int64]*pggeo.StoredAthlete{
+		7: {Athlete: strava.Athlete{ID: 7, FirstName: "Ada"}, UpdatedAt: time.Now().Add(-time.Hour)},
+		8: {Athlete: strava.Athlete{ID: 8, FirstName: "Grace"}, UpdatedAt: time.Now().Add(-2 * athleteRefreshInterval)},
+	}
+	s.loadProfile = func(int64) (*pggeo.AthleteProfile, error) { return nil, nil }
+	s.loadAthlete = func(athleteID int64) (*pggeo.StoredAthlete, error) { return stored[athleteID], nil }
+	var remembered []int64
+	s.storeAthlete = func(athlete *strava.Athlete) error {
+		remembered = append(remembered, athlete.ID)
+		return nil
+	}
+
+	// Fresh: answered from the athletes table alone
+	athlete, err := s.resolveAthlete(7, "token-a")
+	s.jobs.Wait()
+	if err != nil || athlete.FirstName != "Ada" || fake.pageCalls.Load() != 0 || fake.prefetches.Load() != 0 {
+		t.Fatalf("fresh athlete = %+v, %v after %d Strava calls and %d prefetches", athlete, err, fake.pageCalls.Load(), fake.prefetches.Load())
+	}
+
+	// Stale: still answered from the table, with a background refresh
+	athlete, err = s.resolveAthlete(8, "token-b")
+	s.jobs.Wait()
+	if err != nil || athlete.FirstName != "Grace" || fake.pageCalls.Load() != 0 || fake.prefetches.Load() != 1 {
+		t.Fatalf("stale athlete = %+v, %v after %d Strava calls and %d prefetches", athlete, err, fake.pageCalls.Load(), fake.prefetches.Load())
+	}
+
+	// Unknown: asks Strava once and stores the answer
+	if athlete, err = s.resolveAthlete(9, "token-c"); err != nil || athlete == nil || fake.pageCalls.Load() != 1 || len(remembered) != 1 {
+		t.Fatalf("unknown athlete = %+v, %v after %d Strava calls, stored %v", athlete, err, fake.pageCalls.Load(), remembered)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"b11k/internal/pggeo"
 	"b11k/internal/strava"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var (
@@ -21,19 +21,74 @@ type athleteScope struct {
 	AthleteID   int64
 	Athlete     *strava.Athlete
 	StravaToken string
+	// Anonymous is a visitor without a login shown the configured public athlete. They
+	// see what another athlete of the instance would: private activities stay hidden.
+	Anonymous bool
+}
+
+// viewerID is the athlete whose view of activities applies, per pggeo.ActivityVisibleTo;
+// anonymous visitors are nobody, so only instance-visible activities pass
+func (scope athleteScope) viewerID() int64 {
+	if scope.Anonymous {
+		return 0
+	}
+	return scope.AthleteID
 }
 
 func (s *server) webScopeFromRequest(w http.ResponseWriter, r *http.Request) (athleteScope, bool) {
-	s.ensureSessionFromRequest(r)
-	if s.user == nil {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	scope := s.webSessionFromRequest(r)
+	if scope.Athlete == nil {
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "Authentication required"))
+		return athleteScope{}, false
+	}
+	return scope, true
+}
+
+// webReadScopeFromRequest is webScopeFromRequest for read-only pages and endpoints,
+// which visitors without a login may use on the public athlete
+func (s *server) webReadScopeFromRequest(w http.ResponseWriter, r *http.Request) (athleteScope, bool) {
+	scope := s.webReadSessionFromRequest(r)
+	if scope.Athlete == nil {
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "Authentication required"))
 		return athleteScope{}, false
 	}
-	return athleteScope{
-		AthleteID:   s.user.ID,
-		Athlete:     s.user,
-		StravaToken: s.token,
-	}, true
+	return scope, true
+}
+
+// webReadSessionFromRequest is the logged-in athlete, else the anonymous view of the
+// public athlete when one is configured, else the empty scope
+func (s *server) webReadSessionFromRequest(r *http.Request) athleteScope {
+	scope := s.webSessionFromRequest(r)
+	if scope.Athlete != nil || s.cfg.PublicAthleteID == 0 {
+		return scope
+	}
+	athlete := &strava.Athlete{ID: s.cfg.PublicAthleteID}
+	if stored := s.storedAthlete(s.cfg.PublicAthleteID); stored != nil {
+		athlete = &stored.Athlete
+	}
+	return athleteScope{AthleteID: s.cfg.PublicAthleteID, Athlete: athlete, Anonymous: true}
+}
+
+// checkActivityVisible answers not found for an activity the viewer may not see. The
+// athlete's own queries already keep other athletes' activities out, so only anonymous
+// viewers need the check.
+func (s *server) checkActivityVisible(scope athleteScope, activityID int64) error {
+	if !scope.Anonymous {
+		return nil
+	}
+	var activity *strava.ActivitySummary
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		activity, dbErr = pggeo.GetActivityByID(s.ctx, conn, scope.AthleteID, activityID)
+		return dbErr
+	})
+	if err != nil {
+		return err
+	}
+	if !pggeo.ActivityVisibleTo(*activity, scope.viewerID()) {
+		return newAPIError(http.StatusNotFound, "activity not found")
+	}
+	return nil
 }
 
 func (s *server) mobileScopeFromSession(session mobileSession) athleteScope {
@@ -49,7 +104,7 @@ func (s *server) mobileScopeFromSession(session mobileSession) athleteScope {
 
 func (s *server) listFavoriteSegments(athleteID int64) ([]pggeo.FavoriteSegment, error) {
 	var segments []pggeo.FavoriteSegment
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		segments, dbErr = pggeo.ListFavoriteSegments(s.ctx, conn, athleteID)
 		return dbErr
@@ -57,44 +112,45 @@ func (s *server) listFavoriteSegments(athleteID int64) ([]pggeo.FavoriteSegment,
 	return segments, err
 }
 
-func (s *server) listSegmentDashboardSummaries(athleteID int64, toleranceMeters float64) ([]pggeo.SegmentDashboardSummary, error) {
+// listSegmentDashboardSummaries summarizes every segment at its effective tolerance;
+// explicitToleranceM overrides segment and athlete defaults when set.
+func (s *server) listSegmentDashboardSummaries(athleteID int64, explicitToleranceM *float64) ([]pggeo.SegmentDashboardSummary, error) {
+	athleteDefault := s.athleteDefaultTolerance(athleteID)
 	var segments []pggeo.SegmentDashboardSummary
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
-		segments, dbErr = pggeo.ListSegmentDashboardSummaries(s.ctx, conn, athleteID, toleranceMeters)
+		segments, dbErr = pggeo.ListSegmentDashboardSummaries(s.ctx, conn, athleteID, explicitToleranceM, athleteDefault, s.cfg.SegmentCacheTTL)
 		return dbErr
 	})
 	return segments, err
 }
 
+// getOwnedFavoriteSegment loads one of athleteID's segments; another athlete's segment is
+// not found, so its existence is not revealed
 func (s *server) getOwnedFavoriteSegment(athleteID, segmentID int64) (*pggeo.FavoriteSegment, error) {
 	var segment *pggeo.FavoriteSegment
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
-		segment, dbErr = pggeo.GetFavoriteSegment(s.ctx, conn, segmentID)
+		segment, dbErr = pggeo.GetFavoriteSegment(s.ctx, conn, athleteID, segmentID)
 		return dbErr
 	})
-	if err != nil {
-		return nil, err
-	}
-	if segment.AthleteID != athleteID {
-		return nil, errForbidden
-	}
-	return segment, nil
+	return segment, err
 }
 
-func (s *server) createFavoriteSegmentFromActivityRange(athleteID, activityID int64, name, description string, startIndex, endIndex int) (*pggeo.FavoriteSegment, error) {
+// activityRange returns the coordinates and samples of [startIndex, endIndex) of one of
+// athleteID's activities
+func (s *server) activityRange(athleteID, activityID int64, startIndex, endIndex int) ([][]float64, []pggeo.PointSample, error) {
 	var samples []pggeo.PointSample
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, athleteID, activityID)
 		return dbErr
 	})
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", errActivitySamplesMissing, err)
+		return nil, nil, fmt.Errorf("%w: %v", errActivitySamplesMissing, err)
 	}
 	if startIndex >= len(samples) || endIndex > len(samples) {
-		return nil, errSegmentIndexOutOfRange
+		return nil, nil, errSegmentIndexOutOfRange
 	}
 
 	latLngData := make([][]float64, 0, endIndex-startIndex)
@@ -103,34 +159,51 @@ func (s *server) createFavoriteSegmentFromActivityRange(athleteID, activityID in
 		latLngData = append(latLngData, []float64{samples[i].Lat, samples[i].Lng})
 		segmentSamples = append(segmentSamples, samples[i])
 	}
+	return latLngData, segmentSamples, nil
+}
+
+func (s *server) createFavoriteSegmentFromActivityRange(athleteID, activityID int64, name, description string, startIndex, endIndex int, defaultToleranceM *float64) (*pggeo.FavoriteSegment, error) {
+	latLngData, segmentSamples, err := s.activityRange(athleteID, activityID, startIndex, endIndex)
+	if err != nil {
+		return nil, err
+	}
 
 	var segment *pggeo.FavoriteSegment
-	err = s.withDB(func(conn *pgx.Conn) error {
+	err = s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
-		segment, dbErr = pggeo.InsertFavoriteSegment(s.ctx, conn, athleteID, name, description, latLngData, segmentSamples)
+		segment, dbErr = pggeo.InsertFavoriteSegment(s.ctx, conn, athleteID, name, description, latLngData, segmentSamples, defaultToleranceM)
 		return dbErr
 	})
 	return segment, err
 }
 
-func (s *server) createFavoriteSegmentFromPoints(athleteID int64, name, description string, latLngData [][]float64) (*pggeo.FavoriteSegment, error) {
+func (s *server) createFavoriteSegmentFromPoints(athleteID int64, name, description string, latLngData [][]float64, defaultToleranceM *float64) (*pggeo.FavoriteSegment, error) {
 	var segment *pggeo.FavoriteSegment
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
-		segment, dbErr = pggeo.InsertFavoriteSegment(s.ctx, conn, athleteID, name, description, latLngData, nil)
+		segment, dbErr = pggeo.InsertFavoriteSegment(s.ctx, conn, athleteID, name, description, latLngData, nil, defaultToleranceM)
 		return dbErr
 	})
 	return segment, err
 }
 
-func (s *server) updateOwnedFavoriteSegment(athleteID, segmentID int64, name, description string, latLngData [][]float64) (*pggeo.FavoriteSegment, error) {
-	if _, err := s.getOwnedFavoriteSegment(athleteID, segmentID); err != nil {
-		return nil, err
-	}
+func (s *server) updateOwnedFavoriteSegment(athleteID, segmentID int64, name, description string, latLngData [][]float64, pointSamples []pggeo.PointSample) (*pggeo.FavoriteSegment, error) {
+	var segment *pggeo.FavoriteSegment
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		segment, dbErr = pggeo.UpdateFavoriteSegment(s.ctx, conn, athleteID, segmentID, name, description, latLngData, pointSamples)
+		return dbErr
+	})
+	return segment, err
+}
+
+// renameOwnedFavoriteSegment changes only the name and description, keeping the
+// geometry and the cached matches
+func (s *server) renameOwnedFavoriteSegment(athleteID, segmentID int64, name, description string) (*pggeo.FavoriteSegment, error) {
 	var segment *pggeo.FavoriteSegment
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
-		segment, dbErr = pggeo.UpdateFavoriteSegment(s.ctx, conn, segmentID, name, description, latLngData)
+		segment, dbErr = pggeo.UpdateFavoriteSegmentDetails(s.ctx, conn, athleteID, segmentID, name, description)
 		return dbErr
 	})
 	return segment, err
@@ -138,7 +211,7 @@ func (s *server) updateOwnedFavoriteSegment(athleteID, segmentID int64, name, de
 
 func (s *server) discoveredCoverageStatus(athleteID int64) (*pggeo.DiscoveredCoverageStatus, error) {
 	var status *pggeo.DiscoveredCoverageStatus
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		status, dbErr = pggeo.GetDiscoveredCoverageStatus(s.ctx, conn, athleteID, s.cfg.DiscoveredSampleDistanceMeters, s.cfg.DiscoveredRevealRadiusMeters)
 		return dbErr
@@ -148,7 +221,7 @@ func (s *server) discoveredCoverageStatus(athleteID int64) (*pggeo.DiscoveredCov
 
 func (s *server) rebuildDiscoveredCoverage(athleteID int64) (*pggeo.DiscoveredCoverageStatus, error) {
 	var status *pggeo.DiscoveredCoverageStatus
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		status, dbErr = pggeo.RebuildDiscoveredCoverage(s.ctx, conn, athleteID, s.cfg.DiscoveredSampleDistanceMeters, s.cfg.DiscoveredRevealRadiusMeters)
 		return dbErr
@@ -158,7 +231,7 @@ func (s *server) rebuildDiscoveredCoverage(athleteID int64) (*pggeo.DiscoveredCo
 
 func (s *server) discoveredFogFeatureCollection(athleteID int64, minLng, minLat, maxLng, maxLat float64) (string, error) {
 	var featureCollection string
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		featureCollection, dbErr = pggeo.GetDiscoveredFogFeatureCollection(s.ctx, conn, athleteID, minLng, minLat, maxLng, maxLat, s.cfg.DiscoveredSampleDistanceMeters, s.cfg.DiscoveredRevealRadiusMeters)
 		return dbErr
@@ -168,7 +241,7 @@ func (s *server) discoveredFogFeatureCollection(athleteID int64, minLng, minLat,
 
 func (s *server) discoveredCoverageFeatureCollection(athleteID int64, minLng, minLat, maxLng, maxLat float64) (string, error) {
 	var featureCollection string
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		featureCollection, dbErr = pggeo.GetDiscoveredCoverageFeatureCollection(s.ctx, conn, athleteID, minLng, minLat, maxLng, maxLat, s.cfg.DiscoveredSampleDistanceMeters, s.cfg.DiscoveredRevealRadiusMeters)
 		return dbErr
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+
+	"b11k/internal/strava"
+)
+
+// handleStravaLogin starts the web OAuth flow. The page to return to (?next=, else a
+// same-site Referer) travels in the OAuth state with a nonce that the callback checks
+// against the browser's state cookie.
+func (s *server) handleStravaLogin(w http.ResponseWriter, r *http.Request) {
+	nonce, err := randomURLToken(18)
+	if err != nil {
+		http.Error(w, "failed to create auth state", http.StatusInternalServerError)
+		return
+	}
+	// Lax, unlike the login cookie: the callback is a navigation from strava.com
+	// #nosec G124 -- local HTTP needs an insecure cookie; production HTTPS requests set Secure.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    nonce,
+		Path:     s.url("/strava/"),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oauthStateLifetime.Seconds()),
+	})
+
+	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.StravaRedirectURI)
+	authCfg.ActivityWrite = s.cfg.StravaWriteBack
+	http.Redirect(w, r, strava.GenerateAuthURLWithState(*authCfg, encodeOAuthState(nonce, s.loginReturnPath(r))), http.StatusFound)
+}
+
+// handleStravaCallback finishes the web OAuth flow. A code is exchanged at most once;
+// when the same browser hits the callback again it gets its login back, and a browser
+// that is already logged in is sent on instead of seeing a failed exchange.
+func (s *server) handleStravaCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	nonce, next, stateOK := decodeOAuthState(query.Get("state"))
+	if stateOK {
+		cookie, err := r.Cookie(oauthStateCookieName)
+		stateOK = err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(nonce)) == 1
+	}
+
+	code := query.Get("code")
+	switch {
+	case code == "":
+		if s.webSessionFromRequest(r).Athlete != nil {
+			s.renderWebLoginDone(w, next)
+			return
+		}
+		s.renderWebLoginRetry(w, http.StatusBadRequest, next, "Strava sign-in was not completed.",
+			"Strava did not send an authorization. Sign in again to continue.")
+		return
+	case !stateOK:
+		if s.webSessionFromRequest(r).Athlete != nil {
+			s.renderWebLoginDone(w, next)
+			return
+		}
+		slog.Warn("Rejected Strava callback with a missing or mismatched state")
+		s.renderWebLoginRetry(w, http.StatusBadRequest, next, "This sign-in link has expired.",
+			"It may have been opened in another browser or after too long. Sign in again to continue.")
+		return
+	}
+
+	result := s.loginCodes.exchange(code, nonce, func() (string, error) {
+		tokenResp, err := s.exchangeLoginCode(r.Context(), code)
+		if err != nil {
+			return "", err
+		}
+		// The tokens are kept server-side under a new session ID, which is all the browser
+		// gets; the refresh token lets the login outlive the ~6 hour access token
+		return s.startWebLogin(tokenResp)
+	})
+	if result.err == nil && result.nonce == nonce {
+		s.setWebLoginCookie(w, r, result.sessionID)
+		s.renderWebLoginDone(w, next)
+		return
+	}
+	if s.webSessionFromRequest(r).Athlete != nil {
+		s.renderWebLoginDone(w, next)
+		return
+	}
+	if result.err != nil {
+		slog.Error("Strava token exchange failed; check that the Strava app's redirect URI matches", "redirect_uri", s.cfg.StravaRedirectURI, "error", result.err)
+	}
+	s.renderWebLoginRetry(w, http.StatusBadGateway, next, "Strava sign-in could not be completed.",
+		"Strava did not accept the authorization, which happens when a sign-in link is used twice or has expired. Sign in again to continue.")
+}
+
+func (s *server) handleStravaLogout(w http.ResponseWriter, r *http.Request) {
+	// Forget the cached identity and stored tokens for this browser's login
+	if sessionID := webSessionIDFromRequest(r); sessionID != "" {
+		s.forgetWebSession(sessionID)
+		if err := s.deleteWebToken(sessionID); err != nil {
+			slog.Warn("Failed to delete stored Strava tokens on logout", "error", err)
+		}
+	}
+	s.expireCookie(w, r, webSessionCookieName)
+	s.expireCookie(w, r, legacyTokenCookieName)
+
+	// Redirect to home page
+	http.Redirect(w, r, s.url("/"), http.StatusFound)
+}
@@ -0,0 +1,104 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"b11k/internal/strava"
+	"b11k/internal/sync"
+)
+
+// Pulling on page view keeps the activity list fresh without a Strava push subscription:
+// when an athlete views the list and their last sync is older than AutoPullStaleAfter,
+// a small incremental sync runs as an ordinary background sync job and the page offers
+// a refresh once it has saved something.
+
+const (
+	defaultAutoPullStaleAfter    = 6 * time.Hour
+	defaultAutoPullMaxActivities = 5
+	// autoPullMinBudget is the Strava requests that must be left in both rate limit
+	// windows before a page view pulls; a pull lists activities and then makes a few
+	// calls per new activity, and manual syncs should not end up waiting on it
+	autoPullMinBudget = 25
+)
+
+// maybeAutoPull starts a background pull for the viewer when pulling is enabled, their
+// last sync is stale and the Strava budget allows it. It never blocks the page: at most
+// one pull is started per athlete and staleness window, however many views arrive.
+// The window is kept in memory, so the first view after a restart may pull.
+func (s *server) maybeAutoPull(r *http.Request, scope athleteScope) {
+	if !s.cfg.AutoPullOnView || scope.Athlete == nil || scope.StravaToken == "" {
+		return
+	}
+	staleAfter := s.cfg.AutoPullStaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultAutoPullStaleAfter
+	}
+	now := time.Now()
+
+	s.syncJobMu.Lock()
+	if job := s.syncJobs[scope.AthleteID]; job != nil && job.lastActive(now).After(now.Add(-staleAfter)) {
+		s.syncJobMu.Unlock()
+		return
+	}
+	if s.autoPulls[scope.AthleteID].After(now.Add(-staleAfter)) {
+		s.syncJobMu.Unlock()
+		return
+	}
+	if short, daily := s.stravaBudget(); short < autoPullMinBudget || daily < autoPullMinBudget {
+		s.syncJobMu.Unlock()
+		return
+	}
+	// Claimed before starting, so views racing this one see a fresh pull
+	if s.autoPulls == nil {
+		s.autoPulls = make(map[int64]time.Time)
+	}
+	s.autoPulls[scope.AthleteID] = now
+	s.syncJobMu.Unlock()
+
+	job, err := s.startSyncJob(scope.AthleteID, s.autoPullConfig(r, scope))
+	if errors.Is(err, errSyncRunning) {
+		return
+	}
+	job.mu.Lock()
+	job.auto = true
+	job.mu.Unlock()
+	s.digest.Record(digestAutoPull, "started", "Pulling new activities on page view", "athlete_id", scope.AthleteID)
+}
+
+// autoPullConfig is an incremental sync of the configured types that fetches details
+// for at most AutoPullMaxActivities new activities
+func (s *server) autoPullConfig(r *http.Request, scope athleteScope) sync.SyncConfig {
+	maxActivities := s.cfg.AutoPullMaxActivities
+	if maxActivities <= 0 {
+		maxActivities = defaultAutoPullMaxActivities
+	}
+	sessionID := webSessionIDFromRequest(r)
+	return sync.SyncConfig{
+		StravaAccessToken: scope.StravaToken,
+		AccessTokenProvider: func() (string, error) {
+			entry, err := s.webLogin(sessionID)
+			return entry.AccessToken, err
+		},
+		DatabaseConfig:   s.syncDatabaseConfig(),
+		DiscoveredMap:    s.syncDiscoveredMapConfig(),
+		ActivityTypes:    s.cfg.ActivityTypes,
+		Incremental:      true,
+		MaxNewActivities: maxActivities,
+		OnActivitySaved:  s.syncedActivitySaved,
+		HealGPSSpikes:    s.cfg.HealGPSSpikes,
+		FetchGear:        s.fetchGear,
+		MatchSegments:    !s.cfg.LazySegmentCache,
+		Limits:           s.cfg.Limits,
+		TagRules:         s.cfg.TagRules,
+	}
+}
+
+// stravaBudget reports the Strava requests left in the 15-minute and daily windows
+func (s *server) stravaBudget() (short, daily int) {
+	if s.remainingStrava != nil {
+		return s.remainingStrava()
+	}
+	return strava.RemainingRequests()
+}
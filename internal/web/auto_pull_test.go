@@ -0,0 +1,99 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	syncpkg "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+	"b11k/internal/sync"
+)
+
+// newAutoPullTestServer pulls after 6h of staleness and counts the syncs it runs
+func newAutoPullTestServer(runs *atomic.Int64) *server {
+	s := newSyncJobTestServer()
+	s.cfg.AutoPullOnView = true
+	s.cfg.AutoPullStaleAfter = 6 * time.Hour
+	s.cfg.AutoPullMaxActivities = 3
+	s.remainingStrava = func() (int, int) { return 100, 1000 }
+	s.runSync = func(ctx context.Context, cfg sync.SyncConfig, progress sync.ProgressCallback) (*sync.SyncResult, error) {
+		runs.Add(1)
+		if !cfg.Incremental || cfg.MaxNewActivities != 3 {
+			return nil, context.Canceled
+		}
+		return &sync.SyncResult{AthleteID: 1, NewActivities: 5, SuccessfullyProcessed: 3, DeferredActivities: 2}, nil
+	}
+	return s
+}
+
+// viewIndex calls maybeAutoPull from many concurrent page views and waits for any
+// sync they started
+func viewIndex(s *server, views int) {
+	scope := athleteScope{AthleteID: 1, Athlete: &strava.Athlete{ID: 1}, StravaToken: "token"}
+	var wg syncpkg.WaitGroup
+	for i := 0; i < views; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.maybeAutoPull(httptest.NewRequest(http.MethodGet, "/", nil), scope)
+		}()
+	}
+	wg.Wait()
+	s.jobs.Wait()
+}
+
+func TestAutoPullRunsOncePerStalenessWindow(t *testing.T) {
+	var runs atomic.Int64
+	s := newAutoPullTestServer(&runs)
+
+	viewIndex(s, 50)
+	viewIndex(s, 50)
+	if runs.Load() != 1 {
+		t.Fatalf("100 views ran %d syncs, want 1", runs.Load())
+	}
+	status := s.syncJobFor(1).status()
+	if !status.Auto || status.State != syncJobDone || status.Summary.Success != 3 || status.Summary.Deferred != 2 {
+		t.Fatalf("status = %+v, summary %+v; want a finished auto pull", status, status.Summary)
+	}
+
+	// Once the window has passed, the next burst of views pulls exactly once more
+	stale := time.Now().Add(-7 * time.Hour)
+	s.syncJobs[1].finishedAt = stale
+	s.autoPulls[1] = stale
+	viewIndex(s, 50)
+	if runs.Load() != 2 {
+		t.Fatalf("views after the window ran %d syncs in total, want 2", runs.Load())
+	}
+}
+
+func TestAutoPullSkipsFreshSyncsLowBudgetAndDisabledConfig(t *testing.T) {
+	var runs atomic.Int64
+	s := newAutoPullTestServer(&runs)
+
+	// A manual sync that finished an hour ago is fresh enough
+	job, _ := addRunningSyncJob(s, 1)
+	job.state, job.finishedAt = syncJobDone, time.Now().Add(-time.Hour)
+	viewIndex(s, 5)
+
+	delete(s.syncJobs, 1)
+	s.remainingStrava = func() (int, int) { return 10, 1000 }
+	viewIndex(s, 5)
+
+	s.remainingStrava = func() (int, int) { return 100, 1000 }
+	s.cfg.AutoPullOnView = false
+	viewIndex(s, 5)
+	if runs.Load() != 0 {
+		t.Fatalf("ran %d syncs, want none", runs.Load())
+	}
+
+	// The skipped views did not use up the window
+	s.cfg.AutoPullOnView = true
+	viewIndex(s, 5)
+	if runs.Load() != 1 {
+		t.Fatalf("ran %d syncs once allowed, want 1", runs.Load())
+	}
+}
@@ -0,0 +1,19 @@
+package web
+
+import (
+	"b11k/internal/analysis"
+	"b11k/internal/strava"
+)
+
+// setAvgSpeeds fills the derived average speeds of an activity before it is served. The
+// stored average_speed is Strava's own moving average and stays as a deprecated alias.
+func setAvgSpeeds(activity *strava.ActivitySummary) {
+	activity.AvgSpeedMoving = analysis.MovingAvgSpeed(activity.Distance, activity.MovingTime)
+	activity.AvgSpeedElapsed = analysis.ElapsedAvgSpeed(activity.Distance, activity.ElapsedTime)
+}
+
+func fillAvgSpeeds(activities []strava.ActivitySummary) {
+	for i := range activities {
+		setAvgSpeeds(&activities[i])
+	}
+}
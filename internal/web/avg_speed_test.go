@@ -0,0 +1,31 @@
+package web
+
+import (
+	"encoding/json"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestActivityJSONNamesSpeedDerivations(t *testing.T) {
+	activities := []strava.ActivitySummary{
+		{ID: 1, Distance: 36000, MovingTime: 3600, ElapsedTime: 4000, AverageSpeed: 10},
+		{ID: 2, Distance: 500},
+	}
+	fillAvgSpeeds(activities)
+	body, err := json.Marshal(activities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded[0]; got["avg_speed_moving"] != 10.0 || got["avg_speed_elapsed"] != 9.0 || got["average_speed"] != 10.0 {
+		t.Fatalf("speeds = moving %v, elapsed %v, average %v; want 10, 9 and the deprecated alias 10",
+			got["avg_speed_moving"], got["avg_speed_elapsed"], got["average_speed"])
+	}
+	if got := decoded[1]; got["avg_speed_moving"] != 0.0 || got["avg_speed_elapsed"] != 0.0 {
+		t.Fatalf("activity without times has speeds %v and %v, want 0", got["avg_speed_moving"], got["avg_speed_elapsed"])
+	}
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NormalizeBasePath returns base_path in the form the server expects: empty for the root,
+// otherwise one leading slash and no trailing slash ("b11k/" becomes "/b11k").
+func NormalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// url returns the app path for a root-relative path, e.g. "/strava/" under the base path.
+// Every link, redirect and cookie path the server emits goes through it.
+func (s *server) url(path string) string {
+	return s.cfg.BasePath + path
+}
+
+// mountBasePath serves h under basePath with the prefix stripped, so routes and handlers
+// keep working on root-relative paths. The bare prefix redirects to prefix + "/" and
+// anything outside the prefix is not found.
+func mountBasePath(basePath string, h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.Handle(basePath+"/", http.StripPrefix(basePath, h))
+	mux.HandleFunc(basePath, func(w http.ResponseWriter, r *http.Request) {
+		target := basePath + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+	return mux
+}
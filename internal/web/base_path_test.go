@@ -0,0 +1,166 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":        "",
+		"/":       "",
+		"b11k":    "/b11k",
+		"/b11k/":  "/b11k",
+		" /a/b/ ": "/a/b",
+	} {
+		if got := NormalizeBasePath(in); got != want {
+			t.Fatalf("NormalizeBasePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// newBasePathTestServer serves the app under /b11k with cookie "token-a" logged in
+func newBasePathTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	// Templates and static files are loaded relative to the repository root
+	t.Chdir(filepath.Join("..", ".."))
+	tmpl, err := parseTemplates("/b11k")
+	if err != nil {
+		t.Fatalf("parseTemplates: %v", err)
+	}
+	s := &server{
+		ctx: context.Background(),
+		cfg: Config{
+			BasePath:          "/b11k",
+			StravaClientID:    "123",
+			StravaRedirectURI: "http://localhost:8080/b11k/strava/callback",
+		},
+		tmpl:       tmpl,
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+	}
+	s.cacheWebAthlete("token-a", &strava.Athlete{ID: 1})
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func getWithoutRedirects(t *testing.T, target string, cookie string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: cookie})
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestBasePathRedirects(t *testing.T) {
+	ts := newBasePathTestServer(t)
+
+	if resp := getWithoutRedirects(t, ts.URL+"/b11k", ""); resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/b11k/" {
+		t.Fatalf("bare prefix = %d -> %q, want 301 to /b11k/", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	login := getWithoutRedirects(t, ts.URL+"/b11k/strava/login", "")
+	loginURL, err := url.Parse(login.Header.Get("Location"))
+	if login.StatusCode != http.StatusFound || err != nil || loginURL.Host != "www.strava.com" {
+		t.Fatalf("login = %d -> %q, want a redirect to Strava", login.StatusCode, login.Header.Get("Location"))
+	}
+	if got := loginURL.Query().Get("redirect_uri"); got != "http://localhost:8080/b11k/strava/callback" {
+		t.Fatalf("OAuth redirect_uri = %q, want the prefixed callback", got)
+	}
+
+	logout := getWithoutRedirects(t, ts.URL+"/b11k/strava/logout", "")
+	if logout.StatusCode != http.StatusFound || logout.Header.Get("Location") != "/b11k/" {
+		t.Fatalf("logout = %d -> %q, want 302 to /b11k/", logout.StatusCode, logout.Header.Get("Location"))
+	}
+	cookies := logout.Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("logout cookies = %+v, want the session and legacy token cookies cleared", cookies)
+	}
+	for _, cookie := range cookies {
+		if cookie.Path != "/b11k/" || cookie.MaxAge >= 0 {
+			t.Fatalf("logout cookies = %+v, want the login cookies cleared on path /b11k/", cookies)
+		}
+	}
+}
+
+func TestBasePathServesPagesAPIAndAssets(t *testing.T) {
+	ts := newBasePathTestServer(t)
+
+	page := getWithoutRedirects(t, ts.URL+"/b11k/", "")
+	body := readBody(t, page)
+	if page.StatusCode != http.StatusOK || !strings.Contains(body, "window.__BASE_PATH__=") {
+		t.Fatalf("index = %d, want the page with the injected base path", page.StatusCode)
+	}
+	links := regexp.MustCompile(`(?:href|src|action)="(/[^"]*)"`).FindAllStringSubmatch(body, -1)
+	if len(links) == 0 {
+		t.Fatal("index has no root-relative links")
+	}
+	for _, link := range links {
+		if !strings.HasPrefix(link[1], "/b11k/") {
+			t.Fatalf("link %q escapes the base path", link[1])
+		}
+	}
+
+	status := getWithoutRedirects(t, ts.URL+"/b11k/api/sync/status", "token-a")
+	if body := readBody(t, status); status.StatusCode != http.StatusOK || !strings.Contains(body, `"state": "idle"`) {
+		t.Fatalf("sync status = %d %q, want idle", status.StatusCode, body)
+	}
+
+	asset := getWithoutRedirects(t, ts.URL+"/b11k/static/app.js", "")
+	if body := readBody(t, asset); asset.StatusCode != http.StatusOK || !strings.Contains(body, "function appURL(") {
+		t.Fatalf("static app.js = %d, want the script", asset.StatusCode)
+	}
+
+	for _, outside := range []string{"/", "/api/sync/status", "/static/app.js", "/b11kextra/"} {
+		if resp := getWithoutRedirects(t, ts.URL+outside, "token-a"); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("GET %s outside the base path = %d, want 404", outside, resp.StatusCode)
+		}
+	}
+}
+
+// app.js must build every app URL with appURL so it follows the base path
+func TestAppScriptPrefixesAppURLs(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("..", "..", "web", "static", "app.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unprefixed := regexp.MustCompile("(^|[^(])['\"`]/(api|strava|static|activity|segments?|profile|discovered)\\b")
+	for i, line := range strings.Split(string(source), "\n") {
+		if strings.Contains(line, "pathname.match") {
+			continue
+		}
+		if unprefixed.MatchString(line) && !strings.Contains(line, "appURL(") {
+			t.Fatalf("app.js:%d builds an app URL without appURL: %s", i+1, strings.TrimSpace(line))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// displayZone returns the zone the request shows and groups activity times in: the tz
+// query parameter when given ("activity_local" forces each activity's own time), otherwise
+// the athlete's display_timezone setting. Only an unknown tz parameter is an error; a
+// failed settings lookup falls back to activity-local time.
+func (s *server) displayZone(r *http.Request, athleteID int64) (pggeo.DisplayZone, error) {
+	if name := r.URL.Query().Get("tz"); name != "" {
+		return pggeo.LoadDisplayZone(name)
+	}
+	var zone pggeo.DisplayZone
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		zone, dbErr = pggeo.GetAthleteDisplayZone(s.ctx, conn, athleteID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Warn("Failed to load display time zone", "athlete_id", athleteID, "error", err)
+		return pggeo.DisplayZone{}, nil
+	}
+	return zone, nil
+}
+
+// optionalString distinguishes an absent JSON field from an explicit null
+type optionalString struct {
+	Set   bool
+	Value *string
+}
+
+func (o *optionalString) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
@@ -0,0 +1,112 @@
+package web
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultDistributionBins   = 20
+	maxDistributionActivities = 10
+)
+
+// distributionRequest is the parsed query of GET /api/segments/:id/effort-distribution.
+// Efforts[i] is the traversal of ActivityIDs[i] to compare.
+type distributionRequest struct {
+	ActivityIDs []int64
+	Efforts     []int
+	Metric      string
+	Bins        int
+}
+
+func parseDistributionRequest(r *http.Request) (distributionRequest, error) {
+	query := r.URL.Query()
+	req := distributionRequest{
+		Metric: strings.TrimSpace(query.Get("metric")),
+		Bins:   defaultDistributionBins,
+	}
+	if req.Metric == "" {
+		req.Metric = analysis.DistributionMetricWatts
+	}
+	if !analysis.ValidDistributionMetric(req.Metric) {
+		return req, fmt.Errorf("metric must be one of watts, cadence, heartrate")
+	}
+	if binsStr := strings.TrimSpace(query.Get("bins")); binsStr != "" {
+		bins, err := strconv.Atoi(binsStr)
+		if err != nil || bins < 1 || bins > analysis.MaxDistributionBins {
+			return req, fmt.Errorf("bins must be between 1 and %d", analysis.MaxDistributionBins)
+		}
+		req.Bins = bins
+	}
+
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(query.Get("activities"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		activityID, effort, err := parseEffortRef(part)
+		if err != nil {
+			return req, err
+		}
+		key := fmt.Sprintf("%d:%d", activityID, effort)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		req.ActivityIDs = append(req.ActivityIDs, activityID)
+		req.Efforts = append(req.Efforts, effort)
+	}
+	if len(req.ActivityIDs) == 0 {
+		return req, fmt.Errorf("activities parameter required")
+	}
+	if len(req.ActivityIDs) > maxDistributionActivities {
+		return req, fmt.Errorf("at most %d activities can be compared", maxDistributionActivities)
+	}
+	return req, nil
+}
+
+// handleSegmentEffortDistribution handles GET /api/segments/:id/effort-distribution?activities=1,2:3&metric=watts&bins=20
+// where "2:3" is the third traversal of activity 2
+func (s *server) handleSegmentEffortDistribution(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	req, err := parseDistributionRequest(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	values := make([][]float64, len(req.ActivityIDs))
+	excluded := make([]int, len(req.ActivityIDs))
+	for i, activityID := range req.ActivityIDs {
+		var samples []pggeo.PointSample
+		err := s.withReadDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			samples, dbErr = pggeo.GetPointSamplesForSegmentInActivity(s.ctx, conn, scope.AthleteID, activityID, segment.ID, effective.Meters, req.Efforts[i])
+			return dbErr
+		})
+		if err != nil {
+			slog.Error("Failed to load segment samples", "segment_id", segment.ID, "activity_id", activityID, "error", err)
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		values[i], excluded[i] = analysis.MetricValues(samples, req.Metric)
+	}
+
+	comparison := analysis.CompareDistributions(req.Metric, req.Bins, req.ActivityIDs, values, excluded)
+	for i := range comparison.Efforts {
+		comparison.Efforts[i].Effort = req.Efforts[i]
+	}
+	writeJSON(w, struct {
+		analysis.DistributionComparison
+		segmentTolerance
+	}{comparison, effective})
+}
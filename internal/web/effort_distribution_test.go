@@ -0,0 +1,46 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseDistributionRequest(t *testing.T) {
+	req, err := parseDistributionRequest(httptest.NewRequest(http.MethodGet, "/api/segments/1/effort-distribution?activities=3,4,3,4:2,3:1&metric=cadence&bins=12", nil))
+	if err != nil {
+		t.Fatalf("parseDistributionRequest: %v", err)
+	}
+	if len(req.ActivityIDs) != 3 || req.ActivityIDs[0] != 3 || req.ActivityIDs[1] != 4 || req.ActivityIDs[2] != 4 {
+		t.Fatalf("activity IDs = %v, want deduplicated [3 4 4]", req.ActivityIDs)
+	}
+	if len(req.Efforts) != 3 || req.Efforts[0] != 1 || req.Efforts[1] != 1 || req.Efforts[2] != 2 {
+		t.Fatalf("efforts = %v, want [1 1 2]", req.Efforts)
+	}
+	if req.Metric != "cadence" || req.Bins != 12 {
+		t.Fatalf("metric/bins = %s/%d, want cadence/12", req.Metric, req.Bins)
+	}
+
+	defaults, err := parseDistributionRequest(httptest.NewRequest(http.MethodGet, "/api/segments/1/effort-distribution?activities=5", nil))
+	if err != nil {
+		t.Fatalf("parseDistributionRequest defaults: %v", err)
+	}
+	if defaults.Metric != "watts" || defaults.Bins != defaultDistributionBins {
+		t.Fatalf("defaults = %s/%d, want watts/%d", defaults.Metric, defaults.Bins, defaultDistributionBins)
+	}
+
+	for _, query := range []string{
+		"activities=",
+		"activities=1&metric=speed",
+		"activities=1&bins=0",
+		"activities=1&bins=101",
+		"activities=x",
+		"activities=1:0",
+		"activities=1:x",
+		"activities=1,2,3,4,5,6,7,8,9,10,11",
+	} {
+		if _, err := parseDistributionRequest(httptest.NewRequest(http.MethodGet, "/api/segments/1/effort-distribution?"+query, nil)); err == nil {
+			t.Fatalf("%s: expected error", query)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"net/http"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleGear handles GET /api/gear: the caller's bikes and shoes with the distance and
+// count of their activities on each, longest distance first
+func (s *server) handleGear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var gear []pggeo.GearStats
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		gear, dbErr = pggeo.GetGearStats(s.ctx, conn, scope.AthleteID)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"gear": gear})
+}
@@ -0,0 +1,37 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestGearAPIRequiresLoginAndGet(t *testing.T) {
+	s := &server{
+		ctx:        context.Background(),
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+	}
+	s.cacheWebAthlete("token-rider", &strava.Athlete{ID: 2})
+	h := s.routes()
+	for _, tc := range []struct {
+		method, token string
+		want          int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodPost, "token-rider", http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tc.method, "/api/gear", nil)
+		if tc.token != "" {
+			req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: tc.token})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s as %q = %d, want %d", tc.method, tc.token, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// geoIPRange maps the addresses from start to end to a country
+type geoIPRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// geoIPTable looks up the country of an address in a local CSV file of
+// start_ip,end_ip,country rows, the layout of the free DB-IP country lite download. A
+// nil table knows no country.
+type geoIPTable struct {
+	ranges []geoIPRange // sorted by start, not overlapping
+}
+
+// loadGeoIPTable reads the CSV file at path
+func loadGeoIPTable(path string) (*geoIPTable, error) {
+	f, err := os.Open(path) // #nosec G304 -- path comes from the operator's config
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP file: %w", err)
+	}
+	defer f.Close()
+	table, err := parseGeoIPTable(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GeoIP file %s: %w", path, err)
+	}
+	return table, nil
+}
+
+func parseGeoIPTable(r io.Reader) (*geoIPTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var ranges []geoIPRange
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line %d: want start_ip,end_ip,country", line)
+		}
+		start, err := netip.ParseAddr(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		end, err := netip.ParseAddr(strings.TrimSpace(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if start.BitLen() != end.BitLen() || end.Less(start) {
+			return nil, fmt.Errorf("line %d: %s-%s is not a range", line, start, end)
+		}
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		ranges = append(ranges, geoIPRange{start: start.Unmap(), end: end.Unmap(), country: country})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+	return &geoIPTable{ranges: ranges}, nil
+}
+
+// country returns the country of ip, or "" when the table has none for it
+func (t *geoIPTable) country(ip string) string {
+	if t == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	// The last range starting at or before addr is the only one that can hold it
+	i := sort.Search(len(t.ranges), func(i int) bool { return addr.Less(t.ranges[i].start) }) - 1
+	if i < 0 {
+		return ""
+	}
+	r := t.ranges[i]
+	if r.start.BitLen() != addr.BitLen() || r.end.Less(addr) {
+		return ""
+	}
+	return r.country
+}
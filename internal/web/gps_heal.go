@@ -0,0 +1,120 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// errNoSamples is returned by healActivitySpikes for activities stored without streams
+var errNoSamples = errors.New("activity has no point samples to heal")
+
+// gpsHealResult is the response of POST /api/activities/:id/heal
+type gpsHealResult struct {
+	ID       int64            `json:"id"`
+	DryRun   bool             `json:"dry_run"`
+	MaxSpeed float64          `json:"max_speed"`
+	Spikes   []analysis.Spike `json:"spikes"`
+	// RemovedMeters is the distance healing takes, or took, off the activity
+	RemovedMeters float64 `json:"removed_m"`
+}
+
+// healActivitySpikes finds the stored activity's GPS spikes and, unless dryRun, moves
+// them back onto the route and queues its segment caches for a refresh. maxSpeed of
+// zero uses the threshold of the activity's sport type.
+func (s *server) healActivitySpikes(athleteID, activityID int64, maxSpeed float64, dryRun bool) (*gpsHealResult, error) {
+	result := &gpsHealResult{ID: activityID, DryRun: dryRun, MaxSpeed: maxSpeed, Spikes: []analysis.Spike{}}
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		activity, err := pggeo.GetActivityByID(s.ctx, conn, athleteID, activityID)
+		if err != nil {
+			return err
+		}
+		samples, err := pggeo.GetPointSamplesForActivity(s.ctx, conn, athleteID, activityID)
+		if err != nil {
+			return err
+		}
+		if len(samples) == 0 {
+			return errNoSamples
+		}
+		if result.MaxSpeed == 0 {
+			result.MaxSpeed = analysis.MaxPlausibleSpeed(activity.SportType)
+		}
+		if spikes := analysis.DetectSpikes(analysis.SamplePoints(samples), result.MaxSpeed); spikes != nil {
+			result.Spikes = spikes
+		}
+		for _, spike := range result.Spikes {
+			result.RemovedMeters += spike.ExtraMeters
+		}
+		if dryRun || len(result.Spikes) == 0 {
+			return nil
+		}
+
+		analysis.HealSamples(samples, result.Spikes)
+		// Samples before the first spike are unchanged
+		first := 0
+		for first < len(samples) && samples[first].PointIndex < result.Spikes[0].Index {
+			first++
+		}
+		return pggeo.HealActivityPoints(s.ctx, conn, athleteID, activityID, samples[first:], len(result.Spikes), result.RemovedMeters)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun && len(result.Spikes) > 0 {
+		s.queueSegmentCacheRefresh(athleteID, []int64{activityID})
+	}
+	return result, nil
+}
+
+// handleActivityHeal handles POST /api/activities/:id/heal. ?dry_run=true only reports
+// the spikes that would be healed; ?max_speed= overrides the spike threshold in m/s.
+func (s *server) handleActivityHeal(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	q := r.URL.Query()
+	dryRun := false
+	if value := q.Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "dry_run must be true or false"))
+			return
+		}
+		dryRun = parsed
+	}
+	maxSpeed := 0.0
+	if value := q.Get("max_speed"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 5 || parsed > 100 {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "max_speed must be between 5 and 100"))
+			return
+		}
+		maxSpeed = parsed
+	}
+
+	result, err := s.healActivitySpikes(athleteID, activityID, maxSpeed, dryRun)
+	if err != nil {
+		if errors.Is(err, errNoSamples) {
+			writeError(w, r, newAPIError(http.StatusUnprocessableEntity, err.Error()))
+			return
+		}
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+			return
+		}
+		slog.Error("Failed to heal GPS spikes", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !dryRun && len(result.Spikes) > 0 {
+		slog.Info("Healed GPS spikes", "activity_id", activityID, "spikes", len(result.Spikes), "removed_m", result.RemovedMeters)
+	}
+	writeJSON(w, result)
+}
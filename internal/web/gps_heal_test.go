@@ -0,0 +1,27 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActivityHealRejectsBadRequestsBeforeTheDB(t *testing.T) {
+	s := &server{}
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/activities/10/heal", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/activities/10/heal?dry_run=maybe", http.StatusBadRequest},
+		{http.MethodPost, "/api/activities/10/heal?max_speed=fast", http.StatusBadRequest},
+		{http.MethodPost, "/api/activities/10/heal?max_speed=2", http.StatusBadRequest},
+		{http.MethodPost, "/api/activities/10/heal?max_speed=101", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		s.handleActivityHeal(rec, httptest.NewRequest(tt.method, tt.path, nil), 1, 10)
+		if rec.Code != tt.want {
+			t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
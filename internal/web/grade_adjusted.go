@@ -0,0 +1,38 @@
+package web
+
+import (
+	"log/slog"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fillGradeAdjustedSpeeds computes and caches grade-adjusted speeds for efforts that
+// do not have one cached yet. When sortBy is "gap" the efforts are re-sorted afterwards.
+func (s *server) fillGradeAdjustedSpeeds(athleteID, segmentID int64, tolerance float64, activities []pggeo.ActivityWithMatch, sortBy string) {
+	for i := range activities {
+		effort := &activities[i]
+		if effort.SegmentGAP != nil || effort.SegmentStartIndex == nil || effort.SegmentEndIndex == nil {
+			continue
+		}
+		err := s.withReadDB(func(conn *pgxpool.Pool) error {
+			samples, err := pggeo.GetPointSamplesForActivityRange(s.ctx, conn, athleteID, effort.ID, *effort.SegmentStartIndex, *effort.SegmentEndIndex)
+			if err != nil {
+				return err
+			}
+			speed := analysis.GradeAdjustedSpeed(samples)
+			adjusted := analysis.HasGradeData(samples)
+			effort.SegmentGAP = &speed
+			effort.SegmentGAPAdjusted = &adjusted
+			return pggeo.CacheSegmentActivityGradeAdjustedSpeed(s.ctx, conn, segmentID, effort.ID, tolerance, effort.EffortNumber, speed, adjusted)
+		})
+		if err != nil {
+			slog.Warn("Failed to compute grade-adjusted speed", "segment_id", segmentID, "activity_id", effort.ID, "error", err)
+		}
+	}
+	if sortBy == "gap" {
+		pggeo.SortActivitiesWithMatches(activities, sortBy)
+	}
+}
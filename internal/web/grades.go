@@ -0,0 +1,93 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// errNoAltitudeData is returned by recomputeActivityGrades for activities without enough
+// altitude samples to derive grades from
+var errNoAltitudeData = errors.New("activity has no altitude data to derive grades from")
+
+// Bounds of ?window_m= on the grade recompute endpoint
+const (
+	minGradeWindowMeters = 10
+	maxGradeWindowMeters = 500
+)
+
+// recomputeActivityGrades replaces the activity's stored grades with ones derived from
+// its altitudes, smoothed over windowMeters, and returns how many samples were updated.
+// Whatever rewrites altitudes calls it afterwards so grade-based consumers agree with
+// the corrected profile.
+func (s *server) recomputeActivityGrades(athleteID, activityID int64, windowMeters float64) (int, error) {
+	updated := 0
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		samples, err := pggeo.GetPointSamplesForActivity(s.ctx, conn, athleteID, activityID)
+		if err != nil {
+			return err
+		}
+		if len(samples) == 0 {
+			return fmt.Errorf("activity with ID %d has no samples: %w", activityID, pggeo.ErrActivityNotFound)
+		}
+		grades := analysis.RecomputeGradesOver(samples, windowMeters)
+		if grades == nil {
+			return errNoAltitudeData
+		}
+		pointIndexes := make([]int, len(samples))
+		for i, sample := range samples {
+			pointIndexes[i] = sample.PointIndex
+		}
+		updated = len(samples)
+		return pggeo.ReplaceActivityGrades(s.ctx, conn, athleteID, activityID, pointIndexes, grades)
+	})
+	return updated, err
+}
+
+// handleActivityRecomputeGrades handles POST /api/activities/:id/recompute-grades.
+// ?window_m= sets the altitude smoothing window, 50 m by default.
+func (s *server) handleActivityRecomputeGrades(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+
+	window := analysis.DefaultGradeWindowMeters
+	if value := r.URL.Query().Get("window_m"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < minGradeWindowMeters || parsed > maxGradeWindowMeters {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "window_m must be between 10 and 500"))
+			return
+		}
+		window = parsed
+	}
+
+	updated, err := s.recomputeActivityGrades(athleteID, activityID, window)
+	if err != nil {
+		if errors.Is(err, errNoAltitudeData) {
+			writeError(w, r, newAPIError(http.StatusUnprocessableEntity, err.Error()))
+			return
+		}
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+			return
+		}
+		slog.Error("Failed to recompute grades", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Recomputed grades", "activity_id", activityID, "points", updated, "window_m", window)
+	writeJSON(w, map[string]interface{}{
+		"id":             activityID,
+		"grades_derived": true,
+		"samples":        updated,
+		"window_m":       window,
+	})
+}
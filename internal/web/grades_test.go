@@ -0,0 +1,26 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecomputeGradesRejectsBadRequestsBeforeTheDB(t *testing.T) {
+	s := &server{}
+	for _, tt := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/activities/10/recompute-grades", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/activities/10/recompute-grades?window_m=abc", http.StatusBadRequest},
+		{http.MethodPost, "/api/activities/10/recompute-grades?window_m=5", http.StatusBadRequest},
+		{http.MethodPost, "/api/activities/10/recompute-grades?window_m=501", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		s.handleActivityRecomputeGrades(rec, httptest.NewRequest(tt.method, tt.path, nil), 1, 10)
+		if rec.Code != tt.want {
+			t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxGraphSmoothSeconds bounds ?smooth= on graph endpoints
+const maxGraphSmoothSeconds = 600
+
+// Graph series longer than ?max_points= are downsampled. The default keeps the two
+// series the graph page plots under ~200 KB for any ride length, and still gives
+// about a point per pixel of chart width.
+const (
+	defaultGraphMaxPoints = 1000
+	minGraphMaxPoints     = 100
+	maxGraphMaxPoints     = 20000
+)
+
+// graphMetricsParam reads the comma-separated ?metrics= of graph endpoints: speed,
+// heartrate, height, cadence, watts and grade
+func graphMetricsParam(r *http.Request) ([]string, error) {
+	metricsStr := r.URL.Query().Get("metrics")
+	if metricsStr == "" {
+		return nil, fmt.Errorf("metrics parameter required")
+	}
+	metrics := strings.Split(metricsStr, ",")
+	for i := range metrics {
+		metrics[i] = strings.TrimSpace(metrics[i])
+	}
+	return metrics, nil
+}
+
+// graphSmoothParam reads ?smooth=N, the seconds of the rolling average applied to watts;
+// 0 when absent
+func graphSmoothParam(r *http.Request) (time.Duration, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("smooth"))
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 || seconds > maxGraphSmoothSeconds {
+		return 0, fmt.Errorf("smooth must be between 0 and %d seconds", maxGraphSmoothSeconds)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// graphMaxPointsParam reads ?max_points=N, the most points sent per series;
+// defaultGraphMaxPoints when absent
+func graphMaxPointsParam(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("max_points"))
+	if value == "" {
+		return defaultGraphMaxPoints, nil
+	}
+	maxPoints, err := strconv.Atoi(value)
+	if err != nil || maxPoints < minGraphMaxPoints || maxPoints > maxGraphMaxPoints {
+		return 0, fmt.Errorf("max_points must be between %d and %d", minGraphMaxPoints, maxGraphMaxPoints)
+	}
+	return maxPoints, nil
+}
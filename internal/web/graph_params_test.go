@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGraphParams(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/activities/1/graph?metrics=heartrate,%20watts,grade&smooth=30", nil)
+	metrics, err := graphMetricsParam(req)
+	if err != nil || !reflect.DeepEqual(metrics, []string{"heartrate", "watts", "grade"}) {
+		t.Fatalf("metrics = %v, %v", metrics, err)
+	}
+	if smooth, err := graphSmoothParam(req); err != nil || smooth != 30*time.Second {
+		t.Fatalf("smooth = %v, %v; want 30s", smooth, err)
+	}
+
+	if _, err := graphMetricsParam(httptest.NewRequest("GET", "/graph", nil)); err == nil {
+		t.Fatal("missing metrics were accepted")
+	}
+	if smooth, err := graphSmoothParam(httptest.NewRequest("GET", "/graph?metrics=watts", nil)); err != nil || smooth != 0 {
+		t.Fatalf("default smooth = %v, %v; want none", smooth, err)
+	}
+	for _, bad := range []string{"-1", "abc", "601"} {
+		if _, err := graphSmoothParam(httptest.NewRequest("GET", "/graph?smooth="+bad, nil)); err == nil {
+			t.Fatalf("smooth=%s was accepted", bad)
+		}
+	}
+}
+
+func TestGraphMaxPointsParam(t *testing.T) {
+	if maxPoints, err := graphMaxPointsParam(httptest.NewRequest("GET", "/graph", nil)); err != nil || maxPoints != defaultGraphMaxPoints {
+		t.Fatalf("default max_points = %d, %v", maxPoints, err)
+	}
+	if maxPoints, err := graphMaxPointsParam(httptest.NewRequest("GET", "/graph?max_points=2000", nil)); err != nil || maxPoints != 2000 {
+		t.Fatalf("max_points = %d, %v; want 2000", maxPoints, err)
+	}
+	for _, bad := range []string{"0", "99", "20001", "lots"} {
+		if _, err := graphMaxPointsParam(httptest.NewRequest("GET", "/graph?max_points="+bad, nil)); err == nil {
+			t.Fatalf("max_points=%s was accepted", bad)
+		}
+	}
+}
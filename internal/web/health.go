@@ -0,0 +1,170 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const readinessPingTimeout = 2 * time.Second
+
+// spatialHealth records the outcome of the startup spatial self-check
+type spatialHealth struct {
+	mu       sync.RWMutex
+	skipped  bool
+	degraded bool
+	detail   string
+	checks   []pggeo.SpatialCheckResult
+}
+
+func (h *spatialHealth) set(skipped, degraded bool, detail string, checks []pggeo.SpatialCheckResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.skipped = skipped
+	h.degraded = degraded
+	h.detail = detail
+	h.checks = checks
+}
+
+// Degraded reports whether the spatial helper functions produced wrong answers
+func (h *spatialHealth) Degraded() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.degraded
+}
+
+func (h *spatialHealth) snapshot() map[string]interface{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	status := "ok"
+	switch {
+	case h.skipped:
+		status = "skipped"
+	case h.degraded:
+		status = "degraded"
+	}
+	out := map[string]interface{}{"status": status}
+	if h.detail != "" {
+		out["detail"] = h.detail
+	}
+	if len(h.checks) > 0 {
+		out["checks"] = h.checks
+	}
+	return out
+}
+
+// runSpatialSelfCheck verifies the installed PostGIS helper functions against known answers.
+// A failure does not stop the server but flags it as degraded on /readyz.
+func (s *server) runSpatialSelfCheck() {
+	if s.cfg.SkipSpatialSelfCheck {
+		slog.Info("Spatial self-check skipped by configuration")
+		s.spatial.set(true, false, "disabled by configuration", nil)
+		return
+	}
+
+	started := time.Now()
+	var checks []pggeo.SpatialCheckResult
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		checks, dbErr = pggeo.VerifySpatialFunctions(s.ctx, conn)
+		return dbErr
+	})
+	elapsed := time.Since(started)
+	if err == nil {
+		slog.Info("Spatial self-check passed", "checks", len(checks), "duration", elapsed.Round(time.Millisecond))
+		s.spatial.set(false, false, "", checks)
+		return
+	}
+
+	var checkErr *pggeo.SpatialCheckError
+	if errors.As(err, &checkErr) {
+		slog.Error("Spatial self-check failed: PostGIS helper functions returned unexpected results, segment matching and map queries may be wrong")
+		for _, failed := range checkErr.Failed {
+			slog.Error("Spatial self-check mismatch", "check", failed.Name, "expected", failed.Expected, "got", failed.Got)
+		}
+	} else {
+		slog.Error("Spatial self-check could not run", "error", err)
+	}
+	s.spatial.set(false, true, err.Error(), checks)
+}
+
+// handleReadyz handles GET /readyz
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	database := map[string]interface{}{"status": "ok"}
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		ctx, cancel := context.WithTimeout(s.ctx, readinessPingTimeout)
+		defer cancel()
+		return conn.Ping(ctx)
+	})
+	if err != nil {
+		database = map[string]interface{}{"status": "down", "detail": err.Error()}
+	}
+
+	status := "ok"
+	code := http.StatusOK
+	if s.spatial.Degraded() {
+		status = "degraded"
+	}
+	if err != nil {
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":            status,
+		"database":          database,
+		"spatial_functions": s.spatial.snapshot(),
+		"athlete_cache":     s.webAthletes.snapshot(),
+	})
+}
+
+// handleHealthz handles GET /healthz: whether the database answers and has PostGIS. The
+// pool is pinged once without withDB's retries, so the answer is the current state.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, readinessPingTimeout)
+	defer cancel()
+	database := map[string]interface{}{"status": "ok"}
+	postgis := map[string]interface{}{"status": "unknown"}
+	status := "ok"
+	code := http.StatusOK
+	if err := s.pool.Ping(ctx); err != nil {
+		database = map[string]interface{}{"status": "down", "detail": err.Error()}
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+	} else if version, err := pggeo.PostGISVersion(ctx, s.pool); err != nil {
+		postgis = map[string]interface{}{"status": "missing", "detail": err.Error()}
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+	} else {
+		postgis = map[string]interface{}{"status": "ok", "version": version}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   status,
+		"database": database,
+		"postgis":  postgis,
+	})
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestSpatialHealthSnapshot(t *testing.T) {
+	var h spatialHealth
+	if h.Degraded() {
+		t.Fatal("zero value should not be degraded")
+	}
+
+	h.set(false, true, "spatial self-check failed", []pggeo.SpatialCheckResult{{Name: "x", Passed: false}})
+	if !h.Degraded() {
+		t.Fatal("expected degraded after failed check")
+	}
+	if got := h.snapshot()["status"]; got != "degraded" {
+		t.Fatalf("status = %v, want degraded", got)
+	}
+
+	h.set(true, false, "disabled by configuration", nil)
+	if h.Degraded() {
+		t.Fatal("skipped check should not be degraded")
+	}
+	if got := h.snapshot()["status"]; got != "skipped" {
+		t.Fatalf("status = %v, want skipped", got)
+	}
+}
+
+func TestRecoverableDBErrors(t *testing.T) {
+	recoverable := []error{
+		errors.New("conn closed"),
+		fmt.Errorf("failed to list activities: %w", errors.New("read tcp 127.0.0.1:5432: connection reset by peer")),
+		errors.New("unexpected EOF"),
+		&pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"},
+		&pgconn.PgError{Code: "57P03", Message: "the database system is starting up"},
+		fmt.Errorf("failed to query: %w", &pgconn.PgError{Code: "08006"}),
+	}
+	for _, err := range recoverable {
+		if !isRecoverableDBError(err) {
+			t.Errorf("%v should be recoverable", err)
+		}
+	}
+	permanent := []error{
+		nil,
+		errors.New("activity with ID 5 not found"),
+		&pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+		&pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"},
+	}
+	for _, err := range permanent {
+		if isRecoverableDBError(err) {
+			t.Errorf("%v should not be recoverable", err)
+		}
+	}
+}
+
+func withShortDBRetryBackoff(t *testing.T) {
+	saved := dbRetryBackoff
+	dbRetryBackoff = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { dbRetryBackoff = saved })
+}
+
+func TestWithDBRetriesUntilTheDatabaseIsBack(t *testing.T) {
+	withShortDBRetryBackoff(t)
+	s := &server{ctx: context.Background()}
+	attempts := 0
+	err := s.withDB(func(*pgxpool.Pool) error {
+		attempts++
+		if attempts < 3 {
+			return &pgconn.PgError{Code: "57P03", Message: "the database system is starting up"}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("withDB = %v after %d attempts, want success on the third", err, attempts)
+	}
+}
+
+func TestWithDBGivesUpAfterBoundedRetries(t *testing.T) {
+	withShortDBRetryBackoff(t)
+	s := &server{ctx: context.Background()}
+	attempts := 0
+	err := s.withDB(func(*pgxpool.Pool) error {
+		attempts++
+		return errors.New("conn closed")
+	})
+	if err == nil || attempts != len(dbRetryBackoff)+1 {
+		t.Fatalf("withDB = %v after %d attempts, want an error after %d", err, attempts, len(dbRetryBackoff)+1)
+	}
+
+	attempts = 0
+	notFound := errors.New("activity with ID 5 not found")
+	if err := s.withDB(func(*pgxpool.Pool) error {
+		attempts++
+		return notFound
+	}); err != notFound || attempts != 1 {
+		t.Fatalf("withDB = %v after %d attempts, want the error without retrying", err, attempts)
+	}
+}
+
+func TestDBPageErrorStatusFollowsDomainErrorKind(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("failed to load: %w", &pggeo.Error{Kind: pggeo.ErrNotFound, Msg: "activity with ID 5 not found"}), http.StatusNotFound},
+		{&pggeo.Error{Kind: pggeo.ErrForeignAthlete, Msg: "activity with ID 5 belongs to another athlete"}, http.StatusNotFound},
+		{fmt.Errorf("%w: %q", pggeo.ErrSegmentNameExists, "Climb"), http.StatusConflict},
+		{&pggeo.Error{Kind: pggeo.ErrInvalidInput, Msg: "invalid visibility"}, http.StatusBadRequest},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		s.handleDBPageError(rec, httptest.NewRequest(http.MethodGet, "/api/activities/5", nil), tc.err, http.StatusInternalServerError)
+		if rec.Code != tc.want {
+			t.Errorf("%v: status = %d, want %d", tc.err, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestHealthzReportsDatabaseDown(t *testing.T) {
+	s := &server{ctx: context.Background(), pool: unreachablePool(t)}
+	rec := httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status code = %d, want 503", rec.Code)
+	}
+	var body struct {
+		Status   string                 `json:"status"`
+		Database map[string]interface{} `json:"database"`
+		PostGIS  map[string]interface{} `json:"postgis"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "unavailable" || body.Database["status"] != "down" || body.PostGIS["status"] != "unknown" {
+		t.Fatalf("body = %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleHealthz(rec, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status code = %d, want 405", rec.Code)
+	}
+}
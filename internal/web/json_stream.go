@@ -0,0 +1,125 @@
+package web
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	// streamErrorTrailer carries the error of a response stream that failed after the
+	// status was sent, next to the sentinel element in the body
+	streamErrorTrailer = "X-Stream-Error"
+	// streamErrorMessage is the sentinel's text; the cause is only logged
+	streamErrorMessage = "response interrupted, the data is incomplete"
+)
+
+// wantsPrettyJSON reports whether the request asked for indented JSON with ?pretty=true
+func wantsPrettyJSON(r *http.Request) bool {
+	return r.URL.Query().Get("pretty") == "true"
+}
+
+// writeJSONCompact is writeJSON without indentation, for list endpoints where the
+// whitespace would be a large part of the payload. ?pretty=true indents it anyway.
+func writeJSONCompact(w http.ResponseWriter, r *http.Request, v interface{}) {
+	if wantsPrettyJSON(r) {
+		writeJSON(w, v)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// jsonArrayStream writes a JSON array one element at a time, so a response needs memory
+// for one element however long it is. Nothing is written before the first element: until
+// then an error can still be answered with a status code. After that the status is sent,
+// so abort ends the array with a {"stream_error": "..."} element instead; the body stays
+// valid JSON and the error is also sent in the X-Stream-Error trailer.
+type jsonArrayStream struct {
+	w       http.ResponseWriter
+	enc     *json.Encoder
+	prefix  string // before the '[', e.g. an enclosing object up to the array's key
+	suffix  string // after the ']'
+	started bool
+	done    bool
+	err     error // first write error; the client is gone
+}
+
+func newJSONArrayStream(w http.ResponseWriter, r *http.Request, prefix, suffix string) *jsonArrayStream {
+	enc := json.NewEncoder(w)
+	if wantsPrettyJSON(r) {
+		enc.SetIndent("", "  ")
+	}
+	return &jsonArrayStream{w: w, enc: enc, prefix: prefix, suffix: suffix}
+}
+
+func (s *jsonArrayStream) write(text string) {
+	if s.err == nil {
+		_, s.err = s.w.Write([]byte(text))
+	}
+}
+
+func (s *jsonArrayStream) start() {
+	if s.started {
+		s.write(",")
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	s.w.Header().Set("Trailer", streamErrorTrailer)
+	s.w.WriteHeader(http.StatusOK)
+	s.write(s.prefix + "[")
+}
+
+// encode appends v to the array
+func (s *jsonArrayStream) encode(v interface{}) error {
+	s.start()
+	if s.err == nil {
+		s.err = s.enc.Encode(v)
+	}
+	return s.err
+}
+
+// writeRaw appends an element that is already encoded JSON
+func (s *jsonArrayStream) writeRaw(element []byte) error {
+	s.start()
+	if s.err == nil {
+		_, s.err = s.w.Write(element)
+	}
+	return s.err
+}
+
+// close ends the array, writing an empty one if there were no elements
+func (s *jsonArrayStream) close() {
+	if s.done {
+		return
+	}
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		s.write(s.prefix + "[")
+	}
+	s.done = true
+	s.write("]" + s.suffix + "\n")
+}
+
+// abort handles err from the source of the elements. Before anything was written it
+// returns err for the caller to answer with a status; afterwards it ends the stream with
+// the error sentinel and returns nil, so a database retry does not repeat elements.
+func (s *jsonArrayStream) abort(err error) error {
+	if err == nil || !s.started {
+		return err
+	}
+	if s.done {
+		return nil
+	}
+	slog.Error("Response stream interrupted", "error", err)
+	if s.err == nil {
+		s.write(",")
+		_ = s.enc.Encode(map[string]string{"stream_error": streamErrorMessage})
+	}
+	s.w.Header().Set(streamErrorTrailer, streamErrorMessage)
+	s.done = true
+	s.write("]" + s.suffix + "\n")
+	return nil
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+func streamTestSamples(n int) []pggeo.PointSample {
+	samples := make([]pggeo.PointSample, n)
+	start := time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)
+	for i := range samples {
+		altitude := 10 + float64(i%200)/4
+		watts := 150 + i%90
+		samples[i] = pggeo.PointSample{
+			ID: int64(i + 1), ActivityID: 42, AthleteID: 7, PointIndex: i,
+			Time: start.Add(time.Duration(i) * time.Second),
+			Lat:  52.37 + float64(i)*1e-5, Lng: 4.90 + float64(i)*1e-5,
+			Altitude: &altitude, Watts: &watts,
+		}
+	}
+	return samples
+}
+
+// streamSamples streams samples through a jsonArrayStream, failing with failErr after
+// failAfter elements when failErr is set
+func streamSamples(w http.ResponseWriter, r *http.Request, samples []pggeo.PointSample, failAfter int, failErr error) error {
+	stream := newJSONArrayStream(w, r, "", "")
+	for i, sample := range samples {
+		if failErr != nil && i == failAfter {
+			return stream.abort(failErr)
+		}
+		if err := stream.encode(sample); err != nil {
+			return err
+		}
+	}
+	stream.close()
+	return nil
+}
+
+func TestJSONArrayStreamMatchesBufferedEncoding(t *testing.T) {
+	samples := streamTestSamples(1000)
+	rec := httptest.NewRecorder()
+	if err := streamSamples(rec, httptest.NewRequest("GET", "/points", nil), samples, 0, nil); err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	body := rec.Body.Bytes()
+	if !json.Valid(body) {
+		t.Fatalf("streamed body is not valid JSON: %.200s", body)
+	}
+	var decoded []pggeo.PointSample
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for i := range decoded {
+		decoded[i].Time = decoded[i].Time.UTC()
+	}
+	if !reflect.DeepEqual(decoded, samples) {
+		t.Fatal("streamed samples differ from the input")
+	}
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("status %d content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestJSONArrayStreamWithoutElementsIsAnEmptyArray(t *testing.T) {
+	for _, tc := range []struct{ prefix, suffix, want string }{
+		{"", "", "[]\n"},
+		{`{"type":"FeatureCollection","features":`, "}", `{"type":"FeatureCollection","features":[]}` + "\n"},
+	} {
+		rec := httptest.NewRecorder()
+		stream := newJSONArrayStream(rec, httptest.NewRequest("GET", "/", nil), tc.prefix, tc.suffix)
+		stream.close()
+		stream.close()
+		if rec.Body.String() != tc.want {
+			t.Fatalf("body = %q, want %q", rec.Body.String(), tc.want)
+		}
+	}
+}
+
+func TestJSONArrayStreamErrorBeforeFirstElementLeavesStatusToCaller(t *testing.T) {
+	rec := httptest.NewRecorder()
+	dbErr := errors.New("conn closed")
+	err := streamSamples(rec, httptest.NewRequest("GET", "/points", nil), streamTestSamples(3), 0, dbErr)
+	if !errors.Is(err, dbErr) {
+		t.Fatalf("abort before any element = %v, want the error back", err)
+	}
+	if rec.Body.Len() != 0 {
+		t.Fatalf("body = %q, want nothing written", rec.Body.String())
+	}
+}
+
+func TestJSONArrayStreamErrorMidStreamEndsWithSentinel(t *testing.T) {
+	samples := streamTestSamples(100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := streamSamples(w, r, samples, 40, errors.New("replica went away")); err != nil {
+			t.Errorf("abort after elements = %v, want nil so the query is not retried", err)
+		}
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !json.Valid(body) {
+		t.Fatalf("interrupted body is not valid JSON: ...%s", body[len(body)-200:])
+	}
+	var elements []map[string]interface{}
+	if err := json.Unmarshal(body, &elements); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(elements) != 41 {
+		t.Fatalf("got %d elements, want 40 samples and the sentinel", len(elements))
+	}
+	if elements[40]["stream_error"] != streamErrorMessage {
+		t.Fatalf("last element = %v, want the stream_error sentinel", elements[40])
+	}
+	if got := resp.Trailer.Get(streamErrorTrailer); got != streamErrorMessage {
+		t.Fatalf("trailer %s = %q, want %q", streamErrorTrailer, got, streamErrorMessage)
+	}
+}
+
+func TestJSONArrayStreamWrapsRawElements(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := newJSONArrayStream(rec, httptest.NewRequest("GET", "/", nil), `{"type":"FeatureCollection","features":`, "}")
+	for _, feature := range []string{`{"type":"Feature","id":1}`, `{"type":"Feature","id":2}`} {
+		if err := stream.writeRaw([]byte(feature)); err != nil {
+			t.Fatalf("writeRaw: %v", err)
+		}
+	}
+	if err := stream.abort(errors.New("scan failed")); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	stream.close()
+	want := `{"type":"FeatureCollection","features":[{"type":"Feature","id":1},{"type":"Feature","id":2},{"stream_error":"` + streamErrorMessage + `"}` + "\n]}\n"
+	if rec.Body.String() != want {
+		t.Fatalf("body = %q, want %q", rec.Body.String(), want)
+	}
+}
+
+func TestWriteJSONCompactHonoursPretty(t *testing.T) {
+	v := map[string][]int{"values": {1, 2}}
+	rec := httptest.NewRecorder()
+	writeJSONCompact(rec, httptest.NewRequest("GET", "/", nil), v)
+	if rec.Body.String() != `{"values":[1,2]}`+"\n" {
+		t.Fatalf("compact body = %q", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	writeJSONCompact(rec, httptest.NewRequest("GET", "/?pretty=true", nil), v)
+	if !strings.Contains(rec.Body.String(), "\n  \"values\"") {
+		t.Fatalf("pretty body = %q", rec.Body.String())
+	}
+}
+
+// discardResponseWriter is a ResponseWriter that keeps nothing, so benchmarks measure
+// the encoding rather than a recorder's buffer
+type discardResponseWriter struct{ header http.Header }
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+const benchmarkPoints = 50000
+
+// BenchmarkPointsBuffered is the points endpoint before streaming: every sample is
+// collected into a slice and encoded with indentation in one go
+func BenchmarkPointsBuffered(b *testing.B) {
+	source := streamTestSamples(benchmarkPoints)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var samples []pggeo.PointSample
+		for _, sample := range source {
+			samples = append(samples, sample)
+		}
+		writeJSON(&discardResponseWriter{header: http.Header{}}, samples)
+	}
+}
+
+// BenchmarkPointsStreamed encodes each sample as it arrives, as the endpoint does now
+func BenchmarkPointsStreamed(b *testing.B) {
+	source := streamTestSamples(benchmarkPoints)
+	r := httptest.NewRequest("GET", "/api/activities/42/points", nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = streamSamples(&discardResponseWriter{header: http.Header{}}, r, source, 0, nil)
+	}
+}
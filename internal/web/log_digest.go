@@ -0,0 +1,59 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"b11k/internal/digest"
+)
+
+// Subsystems counted in the log digest. Their routine per-item lines are only logged
+// with Config.DebugLogging; failures are logged as they happen.
+const (
+	digestStravaWebhooks = "strava webhooks"
+	digestPRDetection    = "pr detection"
+	digestSegmentRefresh = "segment cache refresh"
+	digestAutoPull       = "auto pull"
+	digestPrefetch       = "profile prefetch"
+	digestTokenRefresh   = "strava token refresh"
+	digestShareViews     = "share views"
+)
+
+// newLogDigest returns the collector with every subsystem's counters registered, so a
+// quiet subsystem still shows up in the digest with zeros. The outbound dispatcher
+// registers its own.
+func newLogDigest(cfg Config) *digest.Collector {
+	collector := digest.New(digest.Options{Debug: cfg.DebugLogging})
+	collector.Register(digestStravaWebhooks, "saved", "deleted", "ignored", "failed")
+	collector.Register(digestPRDetection, "checked", "prs", "dropped", "failed")
+	collector.Register(digestSegmentRefresh, "refreshes", "segments", "failed")
+	collector.Register(digestAutoPull, "started")
+	collector.Register(digestPrefetch, "prefetched", "failed")
+	collector.Register(digestTokenRefresh, "refreshed")
+	collector.Register(digestShareViews, "recorded", "pruned", "dropped", "failed")
+	return collector
+}
+
+// logDigestResponse is the body of GET /api/admin/digest
+type logDigestResponse struct {
+	IntervalSeconds int64           `json:"interval_seconds"`
+	Current         digest.Summary  `json:"current"`
+	Last            *digest.Summary `json:"last"`
+}
+
+// handleAdminDigest handles GET /api/admin/digest: the counts since the last log digest
+// and the last digest logged
+func (s *server) handleAdminDigest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if _, ok := s.adminScopeFromRequest(w, r); !ok {
+		return
+	}
+	writeJSON(w, logDigestResponse{
+		IntervalSeconds: int64(s.digest.Interval() / time.Second),
+		Current:         s.digest.Snapshot(),
+		Last:            s.digest.Last(),
+	})
+}
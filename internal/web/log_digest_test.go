@@ -0,0 +1,51 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/digest"
+	"b11k/internal/strava"
+)
+
+func TestAdminDigestReportsBackgroundCounts(t *testing.T) {
+	s := &server{
+		ctx:        context.Background(),
+		cfg:        Config{AdminAthleteIDs: []int64{1}},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+		digest:     newLogDigest(Config{}),
+	}
+	s.prChecks = newPRCheckQueue(s.digest)
+	s.cacheWebAthlete("token-admin", &strava.Athlete{ID: 1})
+	// Nothing consumes the queue, so the last activities push the first ones out
+	for id := int64(1); id <= prDetectionQueueSize+5; id++ {
+		_ = s.prChecks.Push(s.ctx, prCheck{athleteID: 2, activityID: id})
+	}
+	s.digest.Record(digestAutoPull, "started", "pull %d", 1)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/digest", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-admin"})
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	var body struct {
+		Current digest.Summary  `json:"current"`
+		Last    *digest.Summary `json:"last"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &body) != nil {
+		t.Fatalf("digest = %d %s", rec.Code, rec.Body.String())
+	}
+	counts := body.Current.Subsystems
+	if counts[digestPRDetection]["dropped"] != 5 || counts[digestAutoPull]["started"] != 1 {
+		t.Fatalf("counts = %v, want 5 dropped PR checks and 1 pull", counts)
+	}
+	if saved, ok := counts[digestStravaWebhooks]["saved"]; !ok || saved != 0 {
+		t.Fatalf("strava webhooks = %v, want a registered zero", counts[digestStravaWebhooks])
+	}
+	if body.Last != nil {
+		t.Fatalf("last digest = %+v before any was logged", body.Last)
+	}
+}
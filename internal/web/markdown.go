@@ -0,0 +1,183 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"html"
+	"html/template"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+
+	"b11k/internal/cache"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	markdownCacheSize = 1024
+	// markdownLinkRel is set on every rendered link, since notes link to arbitrary sites
+	markdownLinkRel = "nofollow noopener noreferrer"
+)
+
+// markdownRenderer renders notes with CommonMark plus GFM tables, strikethrough and
+// bare links. Raw HTML is never passed through; sanitizeMarkdown also removes it from the
+// tree along with every other node outside markdownAllowedKinds.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(
+	extension.Table,
+	extension.Strikethrough,
+	extension.Linkify,
+))
+
+// markdownAllowedKinds are the nodes rendered as HTML. Anything else (images, raw HTML)
+// is replaced by its text content, or dropped when it has none.
+var markdownAllowedKinds = map[ast.NodeKind]bool{
+	ast.KindDocument:        true,
+	ast.KindParagraph:       true,
+	ast.KindTextBlock:       true,
+	ast.KindHeading:         true,
+	ast.KindThematicBreak:   true,
+	ast.KindBlockquote:      true,
+	ast.KindList:            true,
+	ast.KindListItem:        true,
+	ast.KindCodeBlock:       true,
+	ast.KindFencedCodeBlock: true,
+	ast.KindText:            true,
+	ast.KindString:          true,
+	ast.KindEmphasis:        true,
+	ast.KindCodeSpan:        true,
+	ast.KindLink:            true,
+	ast.KindAutoLink:        true,
+	east.KindStrikethrough:  true,
+	east.KindTable:          true,
+	east.KindTableHeader:    true,
+	east.KindTableRow:       true,
+	east.KindTableCell:      true,
+}
+
+// safeMarkdownURL allows relative links and http, https and mailto links. Character
+// references are decoded first so "java&#x09;script:" is judged as the browser reads it.
+func safeMarkdownURL(raw []byte) bool {
+	u, err := url.Parse(strings.TrimSpace(html.UnescapeString(string(raw))))
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// sanitizeMarkdown rewrites the parsed tree so it only holds allowed nodes and safe links
+func sanitizeMarkdown(doc ast.Node, source []byte) {
+	var unwrap, remove []ast.Node
+	var autoLinks []*ast.AutoLink
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.RawHTML, *ast.HTMLBlock:
+			remove = append(remove, n)
+			return ast.WalkSkipChildren, nil
+		case *ast.Link:
+			if !safeMarkdownURL(node.Destination) {
+				unwrap = append(unwrap, n)
+				return ast.WalkContinue, nil
+			}
+			node.SetAttributeString("rel", []byte(markdownLinkRel))
+		case *ast.AutoLink:
+			if !safeMarkdownURL(node.URL(source)) {
+				autoLinks = append(autoLinks, node)
+				return ast.WalkContinue, nil
+			}
+			node.SetAttributeString("rel", []byte(markdownLinkRel))
+		default:
+			if !markdownAllowedKinds[n.Kind()] {
+				unwrap = append(unwrap, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, n := range remove {
+		n.Parent().RemoveChild(n.Parent(), n)
+	}
+	for _, link := range autoLinks {
+		link.Parent().ReplaceChild(link.Parent(), link, ast.NewString(link.Label(source)))
+	}
+	for _, n := range unwrap {
+		parent := n.Parent()
+		for child := n.FirstChild(); child != nil; {
+			next := child.NextSibling()
+			parent.InsertBefore(parent, n, child)
+			child = next
+		}
+		parent.RemoveChild(parent, n)
+	}
+}
+
+// markdownCache keeps rendered markdown keyed by the SHA-256 of the raw text, so a note
+// is rendered once however often its page is viewed. The zero value is ready to use.
+type markdownCache struct {
+	mu      sync.Mutex
+	entries *cache.LRU[[sha256.Size]byte, template.HTML]
+}
+
+func (c *markdownCache) get(key [sha256.Size]byte) (template.HTML, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return "", false
+	}
+	return c.entries.Get(key)
+}
+
+func (c *markdownCache) put(key [sha256.Size]byte, rendered template.HTML) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = cache.NewLRU[[sha256.Size]byte, template.HTML](markdownCacheSize)
+	}
+	c.entries.Add(key, rendered)
+}
+
+var renderedMarkdown markdownCache
+
+// renderMarkdown renders raw markdown to sanitized HTML; it is the "markdown" template
+// function and fills the *_html fields of the APIs
+func renderMarkdown(raw string) template.HTML {
+	if raw == "" {
+		return ""
+	}
+	key := sha256.Sum256([]byte(raw))
+	if rendered, ok := renderedMarkdown.get(key); ok {
+		return rendered
+	}
+
+	source := []byte(raw)
+	doc := markdownRenderer.Parser().Parse(text.NewReader(source))
+	sanitizeMarkdown(doc, source)
+	var buf bytes.Buffer
+	if err := markdownRenderer.Renderer().Render(&buf, source, doc); err != nil {
+		slog.Warn("Failed to render markdown, showing it as text", "error", err)
+		return template.HTML("<p>" + template.HTMLEscapeString(raw) + "</p>") // #nosec G203 -- the text is escaped
+	}
+	rendered := template.HTML(buf.String()) // #nosec G203 -- sanitized tree, raw HTML is never rendered
+	renderedMarkdown.put(key, rendered)
+	return rendered
+}
+
+// markdownHTML renders an optional markdown field such as a segment description
+func markdownHTML(raw *string) template.HTML {
+	if raw == nil {
+		return ""
+	}
+	return renderMarkdown(*raw)
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func TestRenderMarkdownFormatsStructuredNotes(t *testing.T) {
+	got := string(renderMarkdown("## Intervals\n\n- 4x8 min @ **300 W**\n- ~~5x5~~ skipped\n\n| tyre | psi |\n|---|---|\n| front | 58 |\n\nSee [the plan](https://example.com/plan)."))
+	for _, want := range []string{
+		"<h2>Intervals</h2>",
+		"<li>4x8 min @ <strong>300 W</strong></li>",
+		"<del>5x5</del>",
+		"<td>front</td>",
+		`<a href="https://example.com/plan" rel="nofollow noopener noreferrer">the plan</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("rendered %q, want it to contain %q", got, want)
+		}
+	}
+	if renderMarkdown("") != "" {
+		t.Fatal("empty notes should render nothing")
+	}
+}
+
+// markdownAllowedTags are the only elements rendered notes may contain
+var markdownAllowedTags = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"hr": true, "blockquote": true, "ul": true, "ol": true, "li": true, "pre": true,
+	"code": true, "em": true, "strong": true, "a": true, "del": true, "table": true,
+	"thead": true, "tbody": true, "tr": true, "th": true, "td": true, "br": true,
+}
+
+var (
+	htmlTagPattern  = regexp.MustCompile(`<\s*/?\s*([a-zA-Z0-9]+)`)
+	htmlHrefPattern = regexp.MustCompile(`href="([^"]*)"`)
+)
+
+func TestRenderMarkdownStripsHostileInput(t *testing.T) {
+	hostile := []string{
+		`<script>alert(1)</script>`,
+		"<div>\n<script>alert(1)</script>\n</div>",
+		`<img src=x onerror=alert(1)>`,
+		`<iframe src="https://evil.example"></iframe>`,
+		`inline <iframe src="https://evil.example"></iframe> frame`,
+		`[click](javascript:alert(1))`,
+		`[click](JaVaScRiPt:alert(1))`,
+		`[click](java&#x09;script:alert(1))`,
+		`[click](  javascript:alert(1)  )`,
+		`[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+		`[click](vbscript:msgbox(1))`,
+		`<javascript:alert(1)>`,
+		`![x](javascript:alert(1))`,
+		`![x](https://tracker.example/pixel.gif)`,
+		`[ref]` + "\n\n" + `[ref]: javascript:alert(1)`,
+		`<a href="javascript:alert(1)">x</a>`,
+		`<svg onload=alert(1)>`,
+		`<style>body{display:none}</style>`,
+		`[x](https://example.com "title\" onmouseover=\"alert(1)")`,
+		"```html\n<script>alert(1)</script>\n```",
+	}
+	for _, input := range hostile {
+		got := string(renderMarkdown(input))
+		lower := strings.ToLower(got)
+		for _, bad := range []string{"<script", "<iframe", "<img", "<svg", "<style", "onerror=", "onload=", `" onmouseover`} {
+			if strings.Contains(lower, bad) {
+				t.Errorf("%q rendered %q, which contains %q", input, got, bad)
+			}
+		}
+		for _, match := range htmlHrefPattern.FindAllStringSubmatch(got, -1) {
+			if !strings.HasPrefix(match[1], "https://") {
+				t.Errorf("%q rendered %q with a link to %q", input, got, match[1])
+			}
+		}
+		for _, match := range htmlTagPattern.FindAllStringSubmatch(got, -1) {
+			if !markdownAllowedTags[strings.ToLower(match[1])] {
+				t.Errorf("%q rendered %q with disallowed <%s>", input, got, match[1])
+			}
+		}
+	}
+}
+
+func TestRenderMarkdownKeepsLinkAndImageText(t *testing.T) {
+	if got := string(renderMarkdown(`[click me](javascript:alert(1))`)); got != "<p>click me</p>\n" {
+		t.Fatalf("unsafe link rendered %q, want only its text", got)
+	}
+	if got := string(renderMarkdown(`![front wheel](https://example.com/wheel.jpg)`)); got != "<p>front wheel</p>\n" {
+		t.Fatalf("image rendered %q, want its alt text", got)
+	}
+}
+
+func TestRenderMarkdownCachesByContent(t *testing.T) {
+	raw := "cached *note* " + t.Name()
+	first := renderMarkdown(raw)
+	key := sha256.Sum256([]byte(raw))
+	if cached, ok := renderedMarkdown.get(key); !ok || cached != first {
+		t.Fatalf("cache entry = %q, %v; want %q", cached, ok, first)
+	}
+	renderedMarkdown.put(key, "<p>from cache</p>")
+	if got := renderMarkdown(raw); got != "<p>from cache</p>" {
+		t.Fatalf("second render = %q, want the cached HTML", got)
+	}
+}
+
+func TestActivityPatchRejectsInvalidNotesBeforeTouchingTheDatabase(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	cases := map[string]string{
+		"no fields":      `{}`,
+		"too long":       `{"notes":"` + strings.Repeat("x", pggeo.MaxActivityNotesLength+1) + `"}`,
+		"bad visibility": `{"notes":"ok","visibility":"public"}`,
+		"blank name":     `{"name":"   "}`,
+		"long name":      `{"name":"` + strings.Repeat("x", pggeo.MaxActivityNameLength+1) + `"}`,
+		"long desc":      `{"description":"` + strings.Repeat("x", pggeo.MaxActivityDescriptionLength+1) + `"}`,
+	}
+	for name, body := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/api/activities/5", strings.NewReader(body))
+		s.handleActivityPatch(rec, req, athleteScope{AthleteID: 1}, 5)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
+
+func TestSegmentResponseRendersDescription(t *testing.T) {
+	description := "Steep *finish* <script>alert(1)</script>"
+	body, err := json.Marshal(newSegmentResponse(&pggeo.FavoriteSegment{ID: 3, Name: "Wall", Description: &description}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded["description"] != description {
+		t.Fatalf("description = %v, want the raw markdown", decoded["description"])
+	}
+	if decoded["description_html"] != "<p>Steep <em>finish</em> alert(1)</p>\n" {
+		t.Fatalf("description_html = %q", decoded["description_html"])
+	}
+}
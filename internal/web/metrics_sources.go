@@ -0,0 +1,20 @@
+package web
+
+import (
+	"b11k/internal/metrics"
+)
+
+// metricsSources exports the state /readyz and the admin endpoints already report on
+// /metrics: the spatial self-check, the athlete cache, the queues, the soft limits and
+// the digest counts
+func (s *server) metricsSources() metrics.Sources {
+	return metrics.Sources{
+		SpatialDegraded: s.spatial.Degraded,
+		AthleteCache: func() (int64, int64) {
+			return s.webAthletes.hits.Load(), s.webAthletes.misses.Load()
+		},
+		Queues:     s.queueStats,
+		SoftLimits: func() []metrics.SoftLimit { return s.softLimits.metrics(s.cfg.Limits) },
+		Digest:     s.digest.Totals,
+	}
+}
@@ -6,19 +6,23 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"html/template"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"b11k/internal/geo"
 	"b11k/internal/pggeo"
 	"b11k/internal/strava"
 	"b11k/internal/sync"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 const (
@@ -28,17 +32,17 @@ const (
 
 func (s *server) handleMobileAuthStart(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 	if s.cfg.StravaClientID == "" || s.cfg.StravaClientSecret == "" {
-		http.Error(w, "Strava client is not configured", http.StatusServiceUnavailable)
+		writeError(w, r, newAPIError(http.StatusServiceUnavailable, "Strava client is not configured"))
 		return
 	}
 
 	state, err := randomURLToken(24)
 	if err != nil {
-		http.Error(w, "failed to create auth state", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, "failed to create auth state"))
 		return
 	}
 
@@ -47,6 +51,7 @@ func (s *server) handleMobileAuthStart(w http.ResponseWriter, r *http.Request) {
 	s.mobileMu.Unlock()
 
 	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.IOSRedirectURI)
+	authCfg.ActivityWrite = s.cfg.StravaWriteBack
 	writeJSON(w, map[string]string{
 		"state":        state,
 		"redirect_uri": s.cfg.IOSRedirectURI,
@@ -57,7 +62,7 @@ func (s *server) handleMobileAuthStart(w http.ResponseWriter, r *http.Request) {
 
 func (s *server) handleMobileAuthExchange(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 
@@ -67,35 +72,35 @@ func (s *server) handleMobileAuthExchange(w http.ResponseWriter, r *http.Request
 		Scope string `json:"scope"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
 		return
 	}
 	if strings.TrimSpace(req.Code) == "" || strings.TrimSpace(req.State) == "" {
-		http.Error(w, "code and state are required", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "code and state are required"))
 		return
 	}
 	if !s.consumeMobileAuthState(req.State) {
-		http.Error(w, "invalid or expired state", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid or expired state"))
 		return
 	}
 
 	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.IOSRedirectURI)
-	tokenResp, err := strava.ExchangeCodeForTokenResponse(*authCfg, req.Code)
+	tokenResp, err := strava.ExchangeCodeForTokenResponse(r.Context(), *authCfg, req.Code)
 	if err != nil {
-		log.Printf("mobile token exchange failed: %v", err)
-		http.Error(w, mobileAuthFailedMessage, http.StatusBadGateway)
+		slog.Error("Mobile token exchange failed", "error", err)
+		writeError(w, r, newAPIError(http.StatusBadGateway, mobileAuthFailedMessage))
 		return
 	}
-	athlete, err := strava.FetchCurrentAthlete(tokenResp.AccessToken)
+	athlete, err := strava.FetchCurrentAthlete(r.Context(), tokenResp.AccessToken)
 	if err != nil {
-		log.Printf("mobile athlete fetch failed: %v", err)
-		http.Error(w, mobileAuthFailedMessage, http.StatusBadGateway)
+		slog.Error("Mobile athlete fetch failed", "error", err)
+		writeError(w, r, newAPIError(http.StatusBadGateway, mobileAuthFailedMessage))
 		return
 	}
 
 	session, err := s.createMobileSession(tokenResp, athlete)
 	if err != nil {
-		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, "failed to create session"))
 		return
 	}
 
@@ -107,7 +112,7 @@ func (s *server) handleMobileAuthExchange(w http.ResponseWriter, r *http.Request
 
 func (s *server) handleMobileAuthCallback(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 
@@ -115,7 +120,7 @@ func (s *server) handleMobileAuthCallback(w http.ResponseWriter, r *http.Request
 	code := strings.TrimSpace(r.URL.Query().Get("code"))
 	accessError := strings.TrimSpace(r.URL.Query().Get("error"))
 	if state == "" {
-		http.Error(w, "missing state", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "missing state"))
 		return
 	}
 	if accessError != "" {
@@ -131,7 +136,7 @@ func (s *server) handleMobileAuthCallback(w http.ResponseWriter, r *http.Request
 		return
 	}
 	if code == "" {
-		http.Error(w, "missing code", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "missing code"))
 		return
 	}
 	if !s.consumeMobileAuthState(state) {
@@ -144,9 +149,9 @@ func (s *server) handleMobileAuthCallback(w http.ResponseWriter, r *http.Request
 	}
 
 	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.IOSRedirectURI)
-	tokenResp, err := strava.ExchangeCodeForTokenResponse(*authCfg, code)
+	tokenResp, err := strava.ExchangeCodeForTokenResponse(r.Context(), *authCfg, code)
 	if err != nil {
-		log.Printf("mobile token exchange failed: %v", err)
+		slog.Error("Mobile token exchange failed", "error", err)
 		s.storeMobileAuthResult(state, mobileAuthResult{
 			Error:     mobileAuthFailedMessage,
 			ExpiresAt: time.Now().Add(10 * time.Minute),
@@ -154,9 +159,9 @@ func (s *server) handleMobileAuthCallback(w http.ResponseWriter, r *http.Request
 		s.renderMobileAuthCallbackPage(w, "B11K could not finish Strava login.", mobileAuthFailedMessage)
 		return
 	}
-	athlete, err := strava.FetchCurrentAthlete(tokenResp.AccessToken)
+	athlete, err := strava.FetchCurrentAthlete(r.Context(), tokenResp.AccessToken)
 	if err != nil {
-		log.Printf("mobile athlete fetch failed: %v", err)
+		slog.Error("Mobile athlete fetch failed", "error", err)
 		s.storeMobileAuthResult(state, mobileAuthResult{
 			Error:     mobileAuthFailedMessage,
 			ExpiresAt: time.Now().Add(10 * time.Minute),
@@ -189,12 +194,12 @@ func (s *server) handleMobileAuthCallback(w http.ResponseWriter, r *http.Request
 
 func (s *server) handleMobileAuthSession(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 	state := strings.TrimSpace(r.URL.Query().Get("state"))
 	if state == "" {
-		http.Error(w, "state is required", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "state is required"))
 		return
 	}
 
@@ -245,7 +250,7 @@ func (s *server) handleMobileActivities(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 	if pathText := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/mobile/activities"), "/"); pathText != "" {
@@ -254,7 +259,7 @@ func (s *server) handleMobileActivities(w http.ResponseWriter, r *http.Request)
 	}
 
 	var activities []strava.ActivitySummary
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		activities, dbErr = pggeo.GetAllActivities(s.ctx, conn, session.Athlete.ID)
 		return dbErr
@@ -265,6 +270,9 @@ func (s *server) handleMobileActivities(w http.ResponseWriter, r *http.Request)
 	}
 
 	activities = filterMobileActivities(activities, r)
+	if pinnedFirst(r) {
+		pggeo.SortActivitiesPinnedFirst(activities)
+	}
 
 	page := intQueryValue(r, "page", 1)
 	perPage := intQueryValue(r, "per_page", 100)
@@ -287,7 +295,7 @@ func (s *server) handleMobileActivities(w http.ResponseWriter, r *http.Request)
 		pagedActivities = activities[start:end]
 	}
 
-	writeJSON(w, map[string]interface{}{
+	writeJSONCompact(w, r, map[string]interface{}{
 		"count":      len(activities),
 		"page":       page,
 		"per_page":   perPage,
@@ -302,7 +310,7 @@ func (s *server) handleMobileActivityPath(w http.ResponseWriter, r *http.Request
 	}
 	parts := strings.Split(pathText, "/")
 	if len(parts) == 0 || parts[0] == "" {
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 		return
 	}
 	if len(parts) == 2 && parts[1] == "route" {
@@ -313,25 +321,25 @@ func (s *server) handleMobileActivityPath(w http.ResponseWriter, r *http.Request
 		s.handleMobileActivity(w, r, session, parts[0])
 		return
 	}
-	http.NotFound(w, r)
+	writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 }
 
 func (s *server) handleMobileActivity(w http.ResponseWriter, r *http.Request, session mobileSession, idText string) {
 	activityID, err := strconv.ParseInt(idText, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid activity id", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid activity id"))
 		return
 	}
 
 	var activity *strava.ActivitySummary
-	err = s.withDB(func(conn *pgx.Conn) error {
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		activity, dbErr = pggeo.GetActivityByID(s.ctx, conn, session.Athlete.ID, activityID)
 		return dbErr
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			http.Error(w, "activity not found", http.StatusNotFound)
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
 			return
 		}
 		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
@@ -345,12 +353,17 @@ func (s *server) handleMobileActivity(w http.ResponseWriter, r *http.Request, se
 func (s *server) handleMobileActivityRoute(w http.ResponseWriter, r *http.Request, session mobileSession, idText string) {
 	activityID, err := strconv.ParseInt(idText, 10, 64)
 	if err != nil {
-		http.Error(w, "invalid activity id", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid activity id"))
+		return
+	}
+	polyline, err := polylineFormatParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
 		return
 	}
 
 	var samples []pggeo.PointSample
-	err = s.withDB(func(conn *pgx.Conn) error {
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, session.Athlete.ID, activityID)
 		return dbErr
@@ -361,7 +374,7 @@ func (s *server) handleMobileActivityRoute(w http.ResponseWriter, r *http.Reques
 	}
 	source := "point_samples"
 	if len(samples) == 0 {
-		err = s.withDB(func(conn *pgx.Conn) error {
+		err = s.withReadDB(func(conn *pgxpool.Pool) error {
 			var dbErr error
 			samples, dbErr = pggeo.GetRoutePointsForActivity(s.ctx, conn, session.Athlete.ID, activityID)
 			return dbErr
@@ -376,7 +389,22 @@ func (s *server) handleMobileActivityRoute(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	writeJSON(w, map[string]interface{}{
+	if polyline {
+		// The route only; per-point streams need the default format
+		latLng := make([][]float64, len(samples))
+		for i, sample := range samples {
+			latLng[i] = []float64{sample.Lat, sample.Lng}
+		}
+		writeJSONCompact(w, r, map[string]interface{}{
+			"activity_id": activityID,
+			"count":       len(samples),
+			"source":      source,
+			"polyline":    geo.EncodePolyline(latLng),
+			"precision":   geo.PolylinePrecision,
+		})
+		return
+	}
+	writeJSONCompact(w, r, map[string]interface{}{
 		"activity_id": activityID,
 		"count":       len(samples),
 		"source":      source,
@@ -386,7 +414,7 @@ func (s *server) handleMobileActivityRoute(w http.ResponseWriter, r *http.Reques
 
 func (s *server) handleMobileSync(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 	session, ok := s.mobileSessionFromRequest(w, r)
@@ -394,6 +422,12 @@ func (s *server) handleMobileSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Mobile syncs run inline, but not alongside the athlete's background web sync
+	if job := s.syncJobFor(session.Athlete.ID); job != nil && job.running() {
+		writeError(w, r, newAPIError(http.StatusConflict, errSyncRunning.Error()))
+		return
+	}
+
 	startTime, endTime := mobileSyncTimeframeFromRequest(r)
 
 	logs := make([]string, 0, 32)
@@ -405,14 +439,18 @@ func (s *server) handleMobileSync(w http.ResponseWriter, r *http.Request) {
 		logs = append(logs, fmt.Sprintf("%s: %s", phase, message))
 	}
 
-	result, err := sync.SyncActivitiesFromStravaWithRetry(s.ctx, s.mobileSyncConfig(session, startTime, endTime), 3, progressCallback)
+	result, err := sync.SyncActivitiesFromStravaWithRetry(s.jobContext(), s.mobileSyncConfig(session, startTime, endTime), 3, progressCallback)
+	if result != nil {
+		s.queueSegmentCacheRefresh(session.Athlete.ID, result.SavedActivityIDs)
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("sync failed: %v", err), http.StatusBadGateway)
+		writeError(w, r, &apiError{Status: http.StatusBadGateway, Message: "sync failed", Err: err})
 		return
 	}
 
 	writeJSON(w, map[string]interface{}{
 		"summary": mobileSyncSummary(result),
+		"phases":  syncPhaseTotals(result.PhaseTimings),
 		"logs":    logs,
 	})
 }
@@ -437,22 +475,27 @@ func mobileSyncTimeframeFromRequest(r *http.Request) (time.Time, time.Time) {
 func (s *server) mobileSyncConfig(session mobileSession, startTime, endTime time.Time) sync.SyncConfig {
 	return sync.SyncConfig{
 		StravaAccessToken: session.Token,
-		DatabaseConfig: sync.DatabaseConfig{
-			Host:     s.cfg.PGIP,
-			Port:     s.cfg.PGPort,
-			User:     s.cfg.PGUser,
-			Password: s.cfg.PGPassword,
-			Database: s.cfg.PGDatabase,
+		AccessTokenProvider: func() (string, error) {
+			refreshed, err := s.refreshMobileSessionIfNeeded(session)
+			if err != nil {
+				return "", err
+			}
+			session = refreshed
+			return session.Token, nil
 		},
+		DatabaseConfig: s.syncDatabaseConfig(),
 		Timeframe: sync.TimeframeConfig{
 			StartTime: startTime,
 			EndTime:   endTime,
 		},
-		DiscoveredMap: sync.DiscoveredMapConfig{
-			Enabled:              s.cfg.DiscoveredMapEnabled,
-			RevealRadiusMeters:   s.cfg.DiscoveredRevealRadiusMeters,
-			SampleDistanceMeters: s.cfg.DiscoveredSampleDistanceMeters,
-		},
+		DiscoveredMap:   s.syncDiscoveredMapConfig(),
+		ActivityTypes:   s.cfg.ActivityTypes,
+		OnActivitySaved: s.syncedActivitySaved,
+		HealGPSSpikes:   s.cfg.HealGPSSpikes,
+		FetchGear:       s.fetchGear,
+		MatchSegments:   !s.cfg.LazySegmentCache,
+		Limits:          s.cfg.Limits,
+		TagRules:        s.cfg.TagRules,
 	}
 }
 
@@ -463,6 +506,9 @@ func mobileSyncSummary(result *sync.SyncResult) map[string]int {
 		"new":      result.NewActivities,
 		"success":  result.SuccessfullyProcessed,
 		"failed":   len(result.FailedActivities),
+		"gone":     len(result.GoneActivities),
+		"rejected": len(result.RejectedActivities),
+		"skipped":  result.SkippedActivities,
 	}
 }
 
@@ -476,13 +522,15 @@ type mobileStorageStats struct {
 
 func (s *server) mobileStorageStats(athleteID int64) (mobileStorageStats, error) {
 	var stats mobileStorageStats
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		return conn.QueryRow(s.ctx, `
 			SELECT
 				(SELECT COUNT(*) FROM activity_summaries WHERE athlete_id = $1),
 				(SELECT COUNT(*) FROM activity_geometries WHERE athlete_id = $1),
-				(SELECT COUNT(*) FROM point_samples WHERE athlete_id = $1),
-				(SELECT COUNT(DISTINCT activity_id) FROM point_samples WHERE athlete_id = $1),
+				(SELECT COUNT(*) FROM point_samples WHERE athlete_id = $1)
+					+ (SELECT COALESCE(SUM(point_count), 0) FROM activity_streams WHERE athlete_id = $1),
+				(SELECT COUNT(DISTINCT activity_id) FROM point_samples WHERE athlete_id = $1)
+					+ (SELECT COUNT(*) FROM activity_streams WHERE athlete_id = $1),
 				(SELECT COUNT(DISTINCT activity_id) FROM activity_geometries WHERE athlete_id = $1)
 		`, athleteID).Scan(
 			&stats.Activities,
@@ -510,7 +558,9 @@ type mobileActivity struct {
 	LocationCountry    *string    `json:"location_country"`
 	GearID             string     `json:"gear_id"`
 	GearName           *string    `json:"gear_name,omitempty"`
-	AverageSpeed       float64    `json:"average_speed"`
+	AverageSpeed       float64    `json:"average_speed"` // deprecated alias of avg_speed_moving
+	AvgSpeedMoving     float64    `json:"avg_speed_moving"`
+	AvgSpeedElapsed    float64    `json:"avg_speed_elapsed"`
 	MaxSpeed           float64    `json:"max_speed"`
 	AverageCadence     float64    `json:"average_cadence"`
 	AverageWatts       float64    `json:"average_watts"`
@@ -521,6 +571,9 @@ type mobileActivity struct {
 	SufferScore        float64    `json:"suffer_score"`
 	StartLatLng        *[]float64 `json:"start_latlng"`
 	EndLatLng          *[]float64 `json:"end_latlng"`
+	// Notes are only loaded for a single activity, never in lists
+	Notes     string        `json:"notes,omitempty"`
+	NotesHTML template.HTML `json:"notes_html,omitempty"`
 }
 
 type mobileRoutePoint struct {
@@ -582,6 +635,7 @@ func mobileActivitiesFromSummaries(activities []strava.ActivitySummary) []mobile
 }
 
 func mobileActivityFromSummary(activity strava.ActivitySummary) mobileActivity {
+	setAvgSpeeds(&activity)
 	startDate := activity.StartDate
 	if !activity.StartDateTime.IsZero() {
 		startDate = activity.StartDateTime.Format(time.RFC3339)
@@ -602,6 +656,8 @@ func mobileActivityFromSummary(activity strava.ActivitySummary) mobileActivity {
 		GearID:             activity.GearID,
 		GearName:           activity.GearName,
 		AverageSpeed:       activity.AverageSpeed,
+		AvgSpeedMoving:     activity.AvgSpeedMoving,
+		AvgSpeedElapsed:    activity.AvgSpeedElapsed,
 		MaxSpeed:           activity.MaxSpeed,
 		AverageCadence:     activity.AverageCadence,
 		AverageWatts:       activity.AverageWatts,
@@ -612,6 +668,8 @@ func mobileActivityFromSummary(activity strava.ActivitySummary) mobileActivity {
 		SufferScore:        activity.SufferScore,
 		StartLatLng:        activity.StartLatLng,
 		EndLatLng:          activity.EndLatLng,
+		Notes:              activity.Notes,
+		NotesHTML:          renderMarkdown(activity.Notes),
 	}
 }
 
@@ -684,6 +742,7 @@ func (s *server) createMobileSession(tokenResp *strava.StravaTokenResponse, athl
 	if err := s.saveMobileSession(session); err != nil {
 		return mobileSession{}, err
 	}
+	s.rememberAthlete(athlete)
 
 	s.mobileMu.Lock()
 	s.mobileSessions[sessionToken] = session
@@ -704,7 +763,7 @@ func (s *server) saveMobileSession(session mobileSession) error {
 	if err != nil {
 		return err
 	}
-	return s.withDB(func(conn *pgx.Conn) error {
+	return s.withDB(func(conn *pgxpool.Pool) error {
 		_, err := conn.Exec(s.ctx, `
 			INSERT INTO mobile_app_sessions (
 				session_token, athlete_id, athlete_firstname, athlete_lastname, athlete_profile,
@@ -734,7 +793,7 @@ func (s *server) loadMobileSession(sessionToken string) (mobileSession, error) {
 	if err == nil {
 		return session, nil
 	}
-	if err != pgx.ErrNoRows {
+	if !errors.Is(err, pgx.ErrNoRows) {
 		return mobileSession{}, err
 	}
 
@@ -752,7 +811,7 @@ func (s *server) loadMobileSessionByStorageKey(rawSessionToken, storageKey strin
 	var session mobileSession
 	var athlete strava.Athlete
 	var storedAccessToken, storedRefreshToken string
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		return conn.QueryRow(s.ctx, `
 			SELECT session_token, athlete_id, athlete_firstname, athlete_lastname, athlete_profile,
 			       strava_access_token, strava_refresh_token, strava_expires_at, session_expires_at, created_at
@@ -809,7 +868,7 @@ func (s *server) refreshMobileSessionIfNeeded(session mobileSession) (mobileSess
 	}
 
 	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.IOSRedirectURI)
-	tokenResp, err := strava.RefreshAccessToken(*authCfg, session.RefreshToken)
+	tokenResp, err := strava.RefreshAccessToken(s.ctx, *authCfg, session.RefreshToken)
 	if err != nil {
 		return mobileSession{}, err
 	}
@@ -828,7 +887,7 @@ func (s *server) refreshMobileSessionIfNeeded(session mobileSession) (mobileSess
 }
 
 func (s *server) touchMobileSession(sessionToken string) error {
-	return s.withDB(func(conn *pgx.Conn) error {
+	return s.withDB(func(conn *pgxpool.Pool) error {
 		_, err := conn.Exec(s.ctx, `
 			UPDATE mobile_app_sessions
 			SET last_seen_at = NOW()
@@ -839,7 +898,7 @@ func (s *server) touchMobileSession(sessionToken string) error {
 }
 
 func (s *server) deleteMobileSession(sessionToken string) error {
-	return s.withDB(func(conn *pgx.Conn) error {
+	return s.withDB(func(conn *pgxpool.Pool) error {
 		_, err := conn.Exec(s.ctx, `
 			DELETE FROM mobile_app_sessions
 			WHERE session_token = $1 OR session_token = $2
@@ -849,7 +908,7 @@ func (s *server) deleteMobileSession(sessionToken string) error {
 }
 
 func (s *server) deleteMobileSessionStorageKey(storageKey string) error {
-	return s.withDB(func(conn *pgx.Conn) error {
+	return s.withDB(func(conn *pgxpool.Pool) error {
 		_, err := conn.Exec(s.ctx, `DELETE FROM mobile_app_sessions WHERE session_token = $1`, storageKey)
 		return err
 	})
@@ -915,13 +974,13 @@ func (s *server) mobileSessionFromRequest(w http.ResponseWriter, r *http.Request
 	auth := r.Header.Get("Authorization")
 	const prefix = "Bearer "
 	if !strings.HasPrefix(auth, prefix) {
-		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "missing bearer token"))
 		return mobileSession{}, false
 	}
 
 	sessionToken := strings.TrimSpace(strings.TrimPrefix(auth, prefix))
 	if !isPlausibleMobileBearerToken(sessionToken) {
-		http.Error(w, "invalid session", http.StatusUnauthorized)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "invalid session"))
 		return mobileSession{}, false
 	}
 	s.mobileMu.Lock()
@@ -932,25 +991,25 @@ func (s *server) mobileSessionFromRequest(w http.ResponseWriter, r *http.Request
 		var err error
 		session, err = s.loadMobileSession(sessionToken)
 		if err != nil {
-			if err != pgx.ErrNoRows {
-				log.Printf("⚠️ Mobile session lookup failed: %v", err)
-				http.Error(w, "session lookup failed", http.StatusInternalServerError)
+			if !errors.Is(err, pgx.ErrNoRows) {
+				slog.Warn("Mobile session lookup failed", "error", err)
+				writeError(w, r, newAPIError(http.StatusInternalServerError, "session lookup failed"))
 				return mobileSession{}, false
 			}
-			http.Error(w, "invalid session", http.StatusUnauthorized)
+			writeError(w, r, newAPIError(http.StatusUnauthorized, "invalid session"))
 			return mobileSession{}, false
 		}
 	}
 
 	session, err := s.refreshMobileSessionIfNeeded(session)
 	if err != nil {
-		log.Printf("⚠️ Mobile session refresh failed: %v", err)
-		http.Error(w, "invalid or expired session", http.StatusUnauthorized)
+		slog.Warn("Mobile session refresh failed", "error", err)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "invalid or expired session"))
 		return mobileSession{}, false
 	}
 
 	if session.Token == "" || session.Athlete == nil {
-		http.Error(w, "invalid session", http.StatusUnauthorized)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "invalid session"))
 		return mobileSession{}, false
 	}
 
@@ -958,6 +1017,7 @@ func (s *server) mobileSessionFromRequest(w http.ResponseWriter, r *http.Request
 	s.mobileSessions[sessionToken] = session
 	s.mobileMu.Unlock()
 
+	noteRequestAthlete(r, session.Athlete.ID)
 	return session, true
 }
 
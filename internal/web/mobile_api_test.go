@@ -171,19 +171,23 @@ func TestMobileSegmentEffortsFromActivities(t *testing.T) {
 
 	efforts := mobileSegmentEffortsFromActivities([]pggeo.ActivityWithMatch{{
 		ActivitySummary: strava.ActivitySummary{
-			ID:        123,
-			Name:      "Hill Repeats",
-			Distance:  5000,
-			SportType: "Ride",
+			ID:           123,
+			Name:         "Hill Repeats",
+			Distance:     5000,
+			MovingTime:   1000,
+			ElapsedTime:  1250,
+			AverageSpeed: 5,
+			SportType:    "Ride",
 		},
-		MinDistanceM:       3.4,
-		OverlapLengthM:     1200,
-		OverlapPercentage:  96.5,
-		SegmentAvgHR:       &hr,
-		SegmentAvgSpeed:    &speed,
-		SegmentDistance:    &distance,
-		SegmentElevation:   &elevation,
-		SegmentElapsedSecs: &elapsed,
+		MinDistanceM:           3.4,
+		OverlapLengthM:         1200,
+		OverlapPercentage:      96.5,
+		SegmentAvgHR:           &hr,
+		SegmentAvgSpeed:        &speed,
+		SegmentAvgSpeedElapsed: &speed,
+		SegmentDistance:        &distance,
+		SegmentElevation:       &elevation,
+		SegmentElapsedSecs:     &elapsed,
 	}})
 
 	if len(efforts) != 1 {
@@ -198,6 +202,13 @@ func TestMobileSegmentEffortsFromActivities(t *testing.T) {
 	if efforts[0].OverlapPercentage != 96.5 {
 		t.Fatalf("overlap = %v, want 96.5", efforts[0].OverlapPercentage)
 	}
+	if efforts[0].SegmentAvgSpeedElapsed == nil || *efforts[0].SegmentAvgSpeedElapsed != speed ||
+		efforts[0].SegmentAvgSpeed == nil || *efforts[0].SegmentAvgSpeed != speed {
+		t.Fatalf("segment speeds = %v, %v; want both %v", efforts[0].SegmentAvgSpeedElapsed, efforts[0].SegmentAvgSpeed, speed)
+	}
+	if activity := efforts[0].Activity; activity.AvgSpeedMoving != 5 || activity.AvgSpeedElapsed != 4 || activity.AverageSpeed != 5 {
+		t.Fatalf("activity speeds moving=%v elapsed=%v average=%v, want 5, 4 and 5", activity.AvgSpeedMoving, activity.AvgSpeedElapsed, activity.AverageSpeed)
+	}
 }
 
 func TestPointSamplesInIndexRange(t *testing.T) {
@@ -7,7 +7,7 @@ import (
 
 func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request) {
 	if !s.cfg.DiscoveredMapEnabled {
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 		return
 	}
 
@@ -17,7 +17,7 @@ func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request)
 	}
 	scope := s.mobileScopeFromSession(session)
 	if scope.AthleteID == 0 {
-		http.Error(w, "invalid session", http.StatusUnauthorized)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "invalid session"))
 		return
 	}
 
@@ -25,7 +25,7 @@ func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request)
 	switch action {
 	case "status":
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		status, err := s.discoveredCoverageStatus(scope.AthleteID)
@@ -36,7 +36,7 @@ func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, status)
 	case "rebuild":
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		status, err := s.rebuildDiscoveredCoverage(scope.AthleteID)
@@ -47,12 +47,12 @@ func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request)
 		writeJSON(w, status)
 	case "fog":
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		minLng, minLat, maxLng, maxLat, ok := parseBBox(r.URL.Query().Get("bbox"))
 		if !ok {
-			http.Error(w, "bbox must be minLng,minLat,maxLng,maxLat", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusBadRequest, "bbox must be minLng,minLat,maxLng,maxLat"))
 			return
 		}
 		featureCollection, err := s.discoveredFogFeatureCollection(scope.AthleteID, minLng, minLat, maxLng, maxLat)
@@ -64,12 +64,12 @@ func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request)
 		_, _ = w.Write([]byte(featureCollection))
 	case "coverage":
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		minLng, minLat, maxLng, maxLat, ok := parseBBox(r.URL.Query().Get("bbox"))
 		if !ok {
-			http.Error(w, "bbox must be minLng,minLat,maxLng,maxLat", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusBadRequest, "bbox must be minLng,minLat,maxLng,maxLat"))
 			return
 		}
 		featureCollection, err := s.discoveredCoverageFeatureCollection(scope.AthleteID, minLng, minLat, maxLng, maxLat)
@@ -80,6 +80,6 @@ func (s *server) handleMobileDiscovered(w http.ResponseWriter, r *http.Request)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		_, _ = w.Write([]byte(featureCollection))
 	default:
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 	}
 }
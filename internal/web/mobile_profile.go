@@ -10,7 +10,7 @@ func (s *server) handleMobileProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 
@@ -29,7 +29,7 @@ func (s *server) handleMobileLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 		return
 	}
 
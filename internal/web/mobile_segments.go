@@ -4,7 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"html/template"
+	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
@@ -14,6 +15,7 @@ import (
 	"b11k/internal/pggeo"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type mobileSegmentSummary struct {
@@ -32,55 +34,69 @@ type mobileSegmentSummary struct {
 	MaxTimeLabel  string  `json:"max_time_label"`
 	MinHRLabel    string  `json:"min_hr_label"`
 	MaxHRLabel    string  `json:"max_hr_label"`
+	ToleranceM    float64 `json:"tolerance_m"`
 }
 
 type mobileSegment struct {
-	ID             int64                  `json:"id"`
-	Name           string                 `json:"name"`
-	Description    *string                `json:"description,omitempty"`
-	CreatedAt      string                 `json:"created_at"`
-	UpdatedAt      string                 `json:"updated_at"`
-	DistanceMeters *float64               `json:"distance_meters,omitempty"`
-	ElevationGainM *float64               `json:"elevation_gain_m,omitempty"`
-	ElevationLossM *float64               `json:"elevation_loss_m,omitempty"`
-	NetElevationM  *float64               `json:"net_elevation_m,omitempty"`
-	SlopePercent   *float64               `json:"slope_percent,omitempty"`
-	Direction      string                 `json:"direction"`
-	DirectionKey   string                 `json:"direction_key"`
-	Geometry       *mobileSegmentGeometry `json:"geometry,omitempty"`
-	SegmentGeog    string                 `json:"segment_geog,omitempty"`
-	SimplifiedGeog *string                `json:"segment_geog_simplified,omitempty"`
+	ID                int64                  `json:"id"`
+	Name              string                 `json:"name"`
+	Description       *string                `json:"description,omitempty"`
+	DescriptionHTML   template.HTML          `json:"description_html,omitempty"`
+	CreatedAt         string                 `json:"created_at"`
+	UpdatedAt         string                 `json:"updated_at"`
+	DistanceMeters    *float64               `json:"distance_meters,omitempty"`
+	ElevationGainM    *float64               `json:"elevation_gain_m,omitempty"`
+	ElevationLossM    *float64               `json:"elevation_loss_m,omitempty"`
+	NetElevationM     *float64               `json:"net_elevation_m,omitempty"`
+	DefaultToleranceM *float64               `json:"default_tolerance_m,omitempty"`
+	SlopePercent      *float64               `json:"slope_percent,omitempty"`
+	Direction         string                 `json:"direction"`
+	DirectionKey      string                 `json:"direction_key"`
+	Geometry          *mobileSegmentGeometry `json:"geometry,omitempty"`
+	SegmentGeog       string                 `json:"segment_geog,omitempty"`
+	SimplifiedGeog    *string                `json:"segment_geog_simplified,omitempty"`
 }
 
 type mobileSegmentEffort struct {
-	Activity           mobileActivity `json:"activity"`
-	MinDistanceM       float64        `json:"min_distance_m"`
-	OverlapLengthM     float64        `json:"overlap_length_m"`
-	OverlapPercentage  float64        `json:"overlap_percentage"`
-	SegmentAvgHR       *float64       `json:"segment_avg_hr,omitempty"`
-	SegmentAvgSpeed    *float64       `json:"segment_avg_speed,omitempty"`
-	SegmentDistance    *float64       `json:"segment_distance,omitempty"`
-	SegmentElevation   *float64       `json:"segment_elevation_gain,omitempty"`
-	SegmentElapsedSecs *float64       `json:"segment_elapsed_seconds,omitempty"`
+	Activity               mobileActivity `json:"activity"`
+	EffortNumber           int            `json:"effort_number"`
+	EffortCount            int            `json:"effort_count"`
+	Direction              string         `json:"direction"`
+	MinDistanceM           float64        `json:"min_distance_m"`
+	OverlapLengthM         float64        `json:"overlap_length_m"`
+	OverlapPercentage      float64        `json:"overlap_percentage"`
+	SegmentAvgHR           *float64       `json:"segment_avg_hr,omitempty"`
+	SegmentAvgSpeed        *float64       `json:"segment_avg_speed,omitempty"` // deprecated alias of segment_avg_speed_elapsed
+	SegmentAvgSpeedElapsed *float64       `json:"segment_avg_speed_elapsed,omitempty"`
+	SegmentDistance        *float64       `json:"segment_distance,omitempty"`
+	SegmentElevation       *float64       `json:"segment_elevation_gain,omitempty"`
+	SegmentElapsedSecs     *float64       `json:"segment_elapsed_seconds,omitempty"`
+	SegmentGAP             *float64       `json:"segment_grade_adjusted_speed,omitempty"`
+	SegmentGAPAdjusted     *bool          `json:"segment_grade_adjusted,omitempty"`
 }
 
 type mobileSegmentEffortDetail struct {
-	SegmentID  int64                      `json:"segment_id"`
-	ActivityID int64                      `json:"activity_id"`
-	Tolerance  float64                    `json:"tolerance"`
-	StartIndex int                        `json:"start_index"`
-	EndIndex   int                        `json:"end_index"`
-	Activity   mobileActivity             `json:"activity"`
-	Metrics    mobileSegmentEffortMetrics `json:"metrics"`
-	Points     []mobileRoutePoint         `json:"points"`
+	SegmentID       int64                      `json:"segment_id"`
+	ActivityID      int64                      `json:"activity_id"`
+	EffortNumber    int                        `json:"effort_number"`
+	EffortCount     int                        `json:"effort_count"`
+	Direction       string                     `json:"direction"`
+	Tolerance       float64                    `json:"tolerance"`
+	ToleranceSource string                     `json:"tolerance_source"`
+	StartIndex      int                        `json:"start_index"`
+	EndIndex        int                        `json:"end_index"`
+	Activity        mobileActivity             `json:"activity"`
+	Metrics         mobileSegmentEffortMetrics `json:"metrics"`
+	Points          []mobileRoutePoint         `json:"points"`
 }
 
 type mobileSegmentEffortMetrics struct {
-	AvgHR          float64 `json:"avg_hr"`
-	AvgSpeed       float64 `json:"avg_speed"`
-	Distance       float64 `json:"distance"`
-	ElevationGain  float64 `json:"elevation_gain"`
-	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	AvgHR           float64 `json:"avg_hr"`
+	AvgSpeed        float64 `json:"avg_speed"` // deprecated alias of avg_speed_elapsed
+	AvgSpeedElapsed float64 `json:"avg_speed_elapsed"`
+	Distance        float64 `json:"distance"`
+	ElevationGain   float64 `json:"elevation_gain"`
+	ElapsedSeconds  float64 `json:"elapsed_seconds"`
 }
 
 type mobileSegmentGeometry struct {
@@ -95,24 +111,26 @@ type mobileLatLng struct {
 }
 
 type mobileSegmentCreateRequest struct {
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	ActivityID  int64          `json:"activity_id"`
-	StartIndex  int            `json:"start_index"`
-	EndIndex    int            `json:"end_index"`
-	Points      []mobileLatLng `json:"points"`
-	Coordinates [][]float64    `json:"coordinates"`
-	LatLng      [][]float64    `json:"lat_lng"`
-	LatLngData  [][]float64    `json:"lat_lng_data"`
+	Name              string         `json:"name"`
+	Description       string         `json:"description"`
+	ActivityID        int64          `json:"activity_id"`
+	StartIndex        int            `json:"start_index"`
+	EndIndex          int            `json:"end_index"`
+	Points            []mobileLatLng `json:"points"`
+	Coordinates       [][]float64    `json:"coordinates"`
+	LatLng            [][]float64    `json:"lat_lng"`
+	LatLngData        [][]float64    `json:"lat_lng_data"`
+	DefaultToleranceM *float64       `json:"default_tolerance_m"`
 }
 
 type mobileSegmentUpdateRequest struct {
-	Name        *string        `json:"name"`
-	Description *string        `json:"description"`
-	Points      []mobileLatLng `json:"points"`
-	Coordinates [][]float64    `json:"coordinates"`
-	LatLng      [][]float64    `json:"lat_lng"`
-	LatLngData  [][]float64    `json:"lat_lng_data"`
+	Name              *string        `json:"name"`
+	Description       *string        `json:"description"`
+	Points            []mobileLatLng `json:"points"`
+	Coordinates       [][]float64    `json:"coordinates"`
+	LatLng            [][]float64    `json:"lat_lng"`
+	LatLngData        [][]float64    `json:"lat_lng_data"`
+	DefaultToleranceM optionalFloat  `json:"default_tolerance_m"`
 }
 
 func (s *server) handleMobileSegments(w http.ResponseWriter, r *http.Request) {
@@ -122,7 +140,7 @@ func (s *server) handleMobileSegments(w http.ResponseWriter, r *http.Request) {
 	}
 	scope := s.mobileScopeFromSession(session)
 	if scope.AthleteID == 0 {
-		http.Error(w, "invalid session", http.StatusUnauthorized)
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "invalid session"))
 		return
 	}
 
@@ -141,13 +159,12 @@ func (s *server) handleMobileSegments(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		s.handleMobileSegmentCreate(w, r, scope)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 	}
 }
 
 func (s *server) handleMobileSegmentsList(w http.ResponseWriter, r *http.Request, scope athleteScope) {
-	tolerance := floatQueryValue(r, "tolerance", 15.0)
-	summaries, err := s.listSegmentDashboardSummaries(scope.AthleteID, tolerance)
+	summaries, err := s.listSegmentDashboardSummaries(scope.AthleteID, explicitTolerance(r))
 	if err != nil {
 		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
 		return
@@ -165,35 +182,40 @@ func (s *server) handleMobileSegmentsList(w http.ResponseWriter, r *http.Request
 func (s *server) handleMobileSegmentCreate(w http.ResponseWriter, r *http.Request, scope athleteScope) {
 	var req mobileSegmentCreateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
 		return
 	}
 
 	name := strings.TrimSpace(req.Name)
 	if name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "name is required"))
+		return
+	}
+
+	if req.DefaultToleranceM != nil && !pggeo.ValidToleranceMeters(*req.DefaultToleranceM) {
+		writeError(w, r, newAPIError(http.StatusBadRequest, fmt.Sprintf("default_tolerance_m must be between 0 and %.0f meters", pggeo.MaxSegmentToleranceM)))
 		return
 	}
 
 	latLngData, hasPoints, err := mobileLatLngData(req.Points, req.Coordinates, req.LatLng, req.LatLngData)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
 		return
 	}
 
 	var segment *pggeo.FavoriteSegment
 	if hasPoints {
-		segment, err = s.createFavoriteSegmentFromPoints(scope.AthleteID, name, req.Description, latLngData)
+		segment, err = s.createFavoriteSegmentFromPoints(scope.AthleteID, name, req.Description, latLngData, req.DefaultToleranceM)
 	} else {
 		if req.ActivityID <= 0 {
-			http.Error(w, "activity_id is required when points are not provided", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusBadRequest, "activity_id is required when points are not provided"))
 			return
 		}
 		if req.StartIndex < 0 || req.EndIndex < 0 || req.StartIndex >= req.EndIndex {
-			http.Error(w, "invalid start_index or end_index", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid start_index or end_index"))
 			return
 		}
-		segment, err = s.createFavoriteSegmentFromActivityRange(scope.AthleteID, req.ActivityID, name, req.Description, req.StartIndex, req.EndIndex)
+		segment, err = s.createFavoriteSegmentFromActivityRange(scope.AthleteID, req.ActivityID, name, req.Description, req.StartIndex, req.EndIndex, req.DefaultToleranceM)
 	}
 	if err != nil {
 		s.handleMobileSegmentMutationError(w, r, err)
@@ -211,13 +233,13 @@ func (s *server) handleMobileSegmentCreate(w http.ResponseWriter, r *http.Reques
 func (s *server) handleMobileSegmentPath(w http.ResponseWriter, r *http.Request, scope athleteScope, pathText string) {
 	parts := strings.Split(pathText, "/")
 	if len(parts) == 0 || parts[0] == "" {
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 		return
 	}
 
 	segmentID, err := strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
-		http.Error(w, "invalid segment id", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid segment id"))
 		return
 	}
 	segment, err := s.getOwnedFavoriteSegment(scope.AthleteID, segmentID)
@@ -250,53 +272,60 @@ func (s *server) handleMobileSegmentPath(w http.ResponseWriter, r *http.Request,
 			return
 		}
 		if len(parts) == 2 && parts[1] == "activities" {
-			s.handleMobileSegmentActivities(w, r, scope, segmentID)
+			s.handleMobileSegmentActivities(w, r, scope, segment)
 			return
 		}
 		if len(parts) == 3 && parts[1] == "activities" {
 			activityID, err := strconv.ParseInt(parts[2], 10, 64)
 			if err != nil {
-				http.Error(w, "invalid activity id", http.StatusBadRequest)
+				writeError(w, r, newAPIError(http.StatusBadRequest, "invalid activity id"))
 				return
 			}
-			s.handleMobileSegmentActivityDetail(w, r, scope, segmentID, activityID)
+			s.handleMobileSegmentActivityDetail(w, r, scope, segment, activityID)
 			return
 		}
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 	case http.MethodPut, http.MethodPatch:
 		if len(parts) != 1 {
-			http.NotFound(w, r)
+			writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 			return
 		}
 		s.handleMobileSegmentUpdate(w, r, scope, segment)
 	case http.MethodDelete:
 		if len(parts) != 1 {
-			http.NotFound(w, r)
+			writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 			return
 		}
-		if err := s.withDB(func(conn *pgx.Conn) error {
-			return pggeo.DeleteFavoriteSegment(s.ctx, conn, segmentID)
+		if err := s.withDB(func(conn *pgxpool.Pool) error {
+			return pggeo.DeleteFavoriteSegment(s.ctx, conn, scope.AthleteID, segmentID)
 		}); err != nil {
 			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 	}
 }
 
-func (s *server) handleMobileSegmentActivityDetail(w http.ResponseWriter, r *http.Request, scope athleteScope, segmentID, activityID int64) {
-	tolerance := floatQueryValue(r, "tolerance", 15.0)
+func (s *server) handleMobileSegmentActivityDetail(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment, activityID int64) {
+	segmentID := segment.ID
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	tolerance := effective.Meters
+	effortNumber, err := effortNumberParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
 
 	var activity *pggeo.ActivityWithMatch
-	err := s.withDB(func(conn *pgx.Conn) error {
-		efforts, dbErr := pggeo.GetActivitiesForSegment(s.ctx, conn, scope.AthleteID, segmentID, tolerance, "total_time", false)
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		efforts, dbErr := pggeo.GetActivitiesForSegment(s.ctx, conn, scope.AthleteID, segmentID, tolerance, "total_time", false, s.cfg.SegmentCacheTTL, pggeo.SegmentEffortFilter{})
 		if dbErr != nil {
 			return dbErr
 		}
 		for i := range efforts {
-			if efforts[i].ID == activityID {
+			if efforts[i].ID == activityID && efforts[i].EffortNumber == effortNumber {
 				activity = &efforts[i]
 				return nil
 			}
@@ -305,17 +334,17 @@ func (s *server) handleMobileSegmentActivityDetail(w http.ResponseWriter, r *htt
 	})
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			http.Error(w, "segment effort not found", http.StatusNotFound)
+			writeError(w, r, newAPIError(http.StatusNotFound, "segment effort not found"))
 			return
 		}
 		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	detail, err := s.mobileSegmentEffortDetail(scope.AthleteID, segmentID, activityID, tolerance, *activity)
+	detail, err := s.mobileSegmentEffortDetail(scope.AthleteID, segmentID, effective, *activity)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			http.Error(w, "segment effort not found", http.StatusNotFound)
+			writeError(w, r, newAPIError(http.StatusNotFound, "segment effort not found"))
 			return
 		}
 		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
@@ -324,45 +353,50 @@ func (s *server) handleMobileSegmentActivityDetail(w http.ResponseWriter, r *htt
 	writeJSON(w, detail)
 }
 
-func (s *server) handleMobileSegmentActivities(w http.ResponseWriter, r *http.Request, scope athleteScope, segmentID int64) {
-	tolerance := floatQueryValue(r, "tolerance", 15.0)
+func (s *server) handleMobileSegmentActivities(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	segmentID := segment.ID
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	tolerance := effective.Meters
 	sortBy := strings.TrimSpace(r.URL.Query().Get("sort"))
 	if sortBy == "" {
 		sortBy = "total_time"
 	}
 	forceRefresh := r.URL.Query().Get("refresh") == "true"
+	filter, err := segmentEffortFilterParams(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
 
-	var activities []pggeo.ActivityWithMatch
-	err := s.withDB(func(conn *pgx.Conn) error {
-		var dbErr error
-		activities, dbErr = pggeo.GetActivitiesForSegment(s.ctx, conn, scope.AthleteID, segmentID, tolerance, sortBy, forceRefresh)
-		return dbErr
-	})
+	activities, err := s.loadSegmentEfforts(scope.AthleteID, segmentID, tolerance, sortBy, forceRefresh, filter)
 	if err != nil {
-		log.Printf("❌ Failed to load mobile activities for segment %d: %v", segmentID, err)
+		slog.Error("Failed to load mobile activities for segment", "segment_id", segmentID, "error", err)
 		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	activities = visibleSegmentEfforts(scope.viewerID(), activities)
+	s.fillGradeAdjustedSpeeds(scope.AthleteID, segmentID, tolerance, activities, sortBy)
 
-	writeJSON(w, map[string]interface{}{
-		"segment_id": segmentID,
-		"count":      len(activities),
-		"tolerance":  tolerance,
-		"sort":       sortBy,
-		"activities": mobileSegmentEffortsFromActivities(activities),
+	writeJSONCompact(w, r, map[string]interface{}{
+		"segment_id":       segmentID,
+		"count":            len(activities),
+		"tolerance":        tolerance,
+		"tolerance_source": effective.Source,
+		"sort":             sortBy,
+		"activities":       mobileSegmentEffortsFromActivities(activities),
 	})
 }
 
-func (s *server) mobileSegmentEffortDetail(athleteID, segmentID, activityID int64, tolerance float64, activity pggeo.ActivityWithMatch) (mobileSegmentEffortDetail, error) {
-	startIndex, endIndex, metrics, err := s.mobileSegmentEffortMetrics(athleteID, segmentID, activityID, tolerance)
-	if err != nil {
-		return mobileSegmentEffortDetail{}, err
+func (s *server) mobileSegmentEffortDetail(athleteID, segmentID int64, tolerance segmentTolerance, activity pggeo.ActivityWithMatch) (mobileSegmentEffortDetail, error) {
+	if activity.SegmentStartIndex == nil || activity.SegmentEndIndex == nil {
+		return mobileSegmentEffortDetail{}, pgx.ErrNoRows
 	}
+	startIndex, endIndex := *activity.SegmentStartIndex, *activity.SegmentEndIndex
 
 	var samples []pggeo.PointSample
-	err = s.withDB(func(conn *pgx.Conn) error {
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
-		samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, athleteID, activityID)
+		samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, athleteID, activity.ID)
 		return dbErr
 	})
 	if err != nil {
@@ -374,73 +408,32 @@ func (s *server) mobileSegmentEffortDetail(athleteID, segmentID, activityID int6
 	}
 
 	return mobileSegmentEffortDetail{
-		SegmentID:  segmentID,
-		ActivityID: activityID,
-		Tolerance:  tolerance,
-		StartIndex: startIndex,
-		EndIndex:   endIndex,
-		Activity:   mobileActivityFromSummary(activity.ActivitySummary),
-		Metrics:    metrics,
-		Points:     mobileRoutePointsFromSamples(segmentSamples),
-	}, nil
-}
-
-func (s *server) mobileSegmentEffortMetrics(athleteID, segmentID, activityID int64, tolerance float64) (int, int, mobileSegmentEffortMetrics, error) {
-	var cached *pggeo.SegmentActivityCacheEntry
-	err := s.withDB(func(conn *pgx.Conn) error {
-		var dbErr error
-		cached, dbErr = pggeo.GetCachedSegmentActivityMetrics(s.ctx, conn, segmentID, activityID, tolerance)
-		return dbErr
-	})
-	if err != nil {
-		return 0, 0, mobileSegmentEffortMetrics{}, err
-	}
-	if cached != nil && cached.StartIndex != nil && cached.EndIndex != nil &&
-		cached.AvgHR != nil && cached.AvgSpeed != nil && cached.DistanceM != nil &&
-		cached.ElevationGainM != nil && cached.ElapsedSeconds != nil {
-		return *cached.StartIndex, *cached.EndIndex, mobileSegmentEffortMetrics{
-			AvgHR:          *cached.AvgHR,
-			AvgSpeed:       *cached.AvgSpeed,
-			Distance:       *cached.DistanceM,
-			ElevationGain:  *cached.ElevationGainM,
-			ElapsedSeconds: *cached.ElapsedSeconds,
-		}, nil
-	}
-
-	var startIndex, endIndex int
-	var avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds float64
-	err = s.withDB(func(conn *pgx.Conn) error {
-		if err := conn.QueryRow(s.ctx,
-			`SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`,
-			segmentID, activityID, athleteID, tolerance,
-		).Scan(&startIndex, &endIndex); err != nil {
-			return err
-		}
-		if err := conn.QueryRow(s.ctx,
-			`SELECT * FROM get_activity_segment_metrics($1, $2, $3, $4)`,
-			segmentID, activityID, athleteID, tolerance,
-		).Scan(&avgHR, &avgSpeed, &distanceM, &elevationGainM, &elapsedSeconds); err != nil {
-			return err
-		}
-		return pggeo.CacheSegmentActivityMetrics(s.ctx, conn, segmentID, activityID, tolerance, startIndex, endIndex, avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds)
-	})
-	if err != nil {
-		return 0, 0, mobileSegmentEffortMetrics{}, err
-	}
-
-	return startIndex, endIndex, mobileSegmentEffortMetrics{
-		AvgHR:          avgHR,
-		AvgSpeed:       avgSpeed,
-		Distance:       distanceM,
-		ElevationGain:  elevationGainM,
-		ElapsedSeconds: elapsedSeconds,
+		SegmentID:       segmentID,
+		ActivityID:      activity.ID,
+		EffortNumber:    activity.EffortNumber,
+		EffortCount:     activity.EffortCount,
+		Direction:       activity.Direction,
+		Tolerance:       tolerance.Meters,
+		ToleranceSource: tolerance.Source,
+		StartIndex:      startIndex,
+		EndIndex:        endIndex,
+		Activity:        mobileActivityFromSummary(activity.ActivitySummary),
+		Metrics: mobileSegmentEffortMetrics{
+			AvgHR:           valueOrZero(activity.SegmentAvgHR),
+			AvgSpeed:        valueOrZero(activity.SegmentAvgSpeedElapsed),
+			AvgSpeedElapsed: valueOrZero(activity.SegmentAvgSpeedElapsed),
+			Distance:        valueOrZero(activity.SegmentDistance),
+			ElevationGain:   valueOrZero(activity.SegmentElevation),
+			ElapsedSeconds:  valueOrZero(activity.SegmentElapsedSecs),
+		},
+		Points: mobileRoutePointsFromSamples(segmentSamples),
 	}, nil
 }
 
 func (s *server) handleMobileSegmentUpdate(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
 	var req mobileSegmentUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
 		return
 	}
 
@@ -449,7 +442,7 @@ func (s *server) handleMobileSegmentUpdate(w http.ResponseWriter, r *http.Reques
 		name = strings.TrimSpace(*req.Name)
 	}
 	if name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "name is required"))
 		return
 	}
 
@@ -463,23 +456,36 @@ func (s *server) handleMobileSegmentUpdate(w http.ResponseWriter, r *http.Reques
 
 	latLngData, hasPoints, err := mobileLatLngData(req.Points, req.Coordinates, req.LatLng, req.LatLngData)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
 		return
 	}
-	if !hasPoints {
-		geometry, err := s.mobileSegmentGeometry(scope.AthleteID, segment.ID)
-		if err != nil {
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-		latLngData = latLngDataFromMobilePoints(geometry.Points)
+
+	if err := req.DefaultToleranceM.validate(); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
 	}
 
-	updated, err := s.updateOwnedFavoriteSegment(scope.AthleteID, segment.ID, name, description, latLngData)
+	var updated *pggeo.FavoriteSegment
+	if hasPoints {
+		updated, err = s.updateOwnedFavoriteSegment(scope.AthleteID, segment.ID, name, description, latLngData, nil)
+	} else {
+		updated, err = s.renameOwnedFavoriteSegment(scope.AthleteID, segment.ID, name, description)
+	}
 	if err != nil {
 		s.handleMobileSegmentMutationError(w, r, err)
 		return
 	}
+	if req.DefaultToleranceM.Set {
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			updated, dbErr = pggeo.SetSegmentDefaultTolerance(s.ctx, conn, scope.AthleteID, segment.ID, req.DefaultToleranceM.Value)
+			return dbErr
+		})
+		if err != nil {
+			s.handleMobileSegmentMutationError(w, r, err)
+			return
+		}
+	}
 
 	response, err := s.mobileSegmentFromFavorite(scope.AthleteID, updated, true)
 	if err != nil {
@@ -503,15 +509,21 @@ func mobileSegmentEffortsFromActivities(activities []pggeo.ActivityWithMatch) []
 	result := make([]mobileSegmentEffort, 0, len(activities))
 	for _, activity := range activities {
 		result = append(result, mobileSegmentEffort{
-			Activity:           mobileActivityFromSummary(activity.ActivitySummary),
-			MinDistanceM:       activity.MinDistanceM,
-			OverlapLengthM:     activity.OverlapLengthM,
-			OverlapPercentage:  activity.OverlapPercentage,
-			SegmentAvgHR:       activity.SegmentAvgHR,
-			SegmentAvgSpeed:    activity.SegmentAvgSpeed,
-			SegmentDistance:    activity.SegmentDistance,
-			SegmentElevation:   activity.SegmentElevation,
-			SegmentElapsedSecs: activity.SegmentElapsedSecs,
+			Activity:               mobileActivityFromSummary(activity.ActivitySummary),
+			EffortNumber:           activity.EffortNumber,
+			EffortCount:            activity.EffortCount,
+			Direction:              activity.Direction,
+			MinDistanceM:           activity.MinDistanceM,
+			OverlapLengthM:         activity.OverlapLengthM,
+			OverlapPercentage:      activity.OverlapPercentage,
+			SegmentAvgHR:           activity.SegmentAvgHR,
+			SegmentAvgSpeed:        activity.SegmentAvgSpeedElapsed,
+			SegmentAvgSpeedElapsed: activity.SegmentAvgSpeedElapsed,
+			SegmentDistance:        activity.SegmentDistance,
+			SegmentElevation:       activity.SegmentElevation,
+			SegmentElapsedSecs:     activity.SegmentElapsedSecs,
+			SegmentGAP:             activity.SegmentGAP,
+			SegmentGAPAdjusted:     activity.SegmentGAPAdjusted,
 		})
 	}
 	return result
@@ -524,19 +536,21 @@ func (s *server) mobileSegmentFromFavorite(athleteID int64, segment *pggeo.Favor
 	}
 
 	result := mobileSegment{
-		ID:             segment.ID,
-		Name:           segment.Name,
-		Description:    segment.Description,
-		CreatedAt:      segment.CreatedAt,
-		UpdatedAt:      segment.UpdatedAt,
-		DistanceMeters: &distance,
-		ElevationGainM: segment.ElevationGainM,
-		ElevationLossM: segment.ElevationLossM,
-		NetElevationM:  segment.NetElevationM,
-		SegmentGeog:    segment.SegmentGeog,
-		SimplifiedGeog: segment.SegmentGeogSimplified,
-		Direction:      "Unknown",
-		DirectionKey:   "unknown",
+		ID:                segment.ID,
+		Name:              segment.Name,
+		Description:       segment.Description,
+		DescriptionHTML:   markdownHTML(segment.Description),
+		CreatedAt:         segment.CreatedAt,
+		UpdatedAt:         segment.UpdatedAt,
+		DistanceMeters:    &distance,
+		ElevationGainM:    segment.ElevationGainM,
+		ElevationLossM:    segment.ElevationLossM,
+		NetElevationM:     segment.NetElevationM,
+		DefaultToleranceM: segment.DefaultToleranceM,
+		SegmentGeog:       segment.SegmentGeog,
+		SimplifiedGeog:    segment.SegmentGeogSimplified,
+		Direction:         "Unknown",
+		DirectionKey:      "unknown",
 	}
 
 	if segment.NetElevationM != nil && distance > 0 {
@@ -568,7 +582,7 @@ func (s *server) mobileSegmentFromFavorite(athleteID int64, segment *pggeo.Favor
 
 func (s *server) mobileSegmentGeometry(athleteID, segmentID int64) (mobileSegmentGeometry, error) {
 	var geoJSONText string
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		return conn.QueryRow(s.ctx, `
 			SELECT ST_AsGeoJSON(segment_geog::geometry)
 			FROM favorite_segments
@@ -583,7 +597,7 @@ func (s *server) mobileSegmentGeometry(athleteID, segmentID int64) (mobileSegmen
 
 func (s *server) segmentDistanceMeters(athleteID, segmentID int64) (float64, error) {
 	var distance float64
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
 		return conn.QueryRow(s.ctx, `
 			SELECT ST_Length(segment_geog)
 			FROM favorite_segments
@@ -612,6 +626,7 @@ func mobileSegmentSummariesFromDashboard(summaries []pggeo.SegmentDashboardSumma
 			MaxTimeLabel:  summary.MaxTimeLabel,
 			MinHRLabel:    summary.MinHRLabel,
 			MaxHRLabel:    summary.MaxHRLabel,
+			ToleranceM:    summary.ToleranceM,
 		})
 	}
 	return result
@@ -739,25 +754,9 @@ func parseMobileSegmentGeometry(geoJSONText string) (mobileSegmentGeometry, erro
 	}, nil
 }
 
-func floatQueryValue(r *http.Request, name string, fallback float64) float64 {
-	value := strings.TrimSpace(r.URL.Query().Get(name))
-	if value == "" {
-		return fallback
-	}
-	parsed, err := strconv.ParseFloat(value, 64)
-	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed <= 0 {
-		return fallback
-	}
-	return parsed
-}
-
 func (s *server) handleOwnedMobileSegmentError(w http.ResponseWriter, r *http.Request, err error) {
-	if errors.Is(err, errForbidden) {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-	if errors.Is(err, pgx.ErrNoRows) || strings.Contains(strings.ToLower(err.Error()), "not found") {
-		http.Error(w, "segment not found", http.StatusNotFound)
+	if errors.Is(err, pggeo.ErrSegmentNotFound) {
+		writeError(w, r, newAPIError(http.StatusNotFound, "segment not found"))
 		return
 	}
 	s.handleDBPageError(w, r, err, http.StatusInternalServerError)
@@ -765,12 +764,16 @@ func (s *server) handleOwnedMobileSegmentError(w http.ResponseWriter, r *http.Re
 
 func (s *server) handleMobileSegmentMutationError(w http.ResponseWriter, r *http.Request, err error) {
 	if errors.Is(err, errSegmentIndexOutOfRange) {
-		http.Error(w, "index out of range", http.StatusBadRequest)
+		writeError(w, r, newAPIError(http.StatusBadRequest, "index out of range"))
 		return
 	}
 	if errors.Is(err, errActivitySamplesMissing) {
 		s.handleDBPageError(w, r, err, http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, pggeo.ErrSegmentNameExists) {
+		writeError(w, r, newAPIError(http.StatusConflict, segmentNameExistsMessage))
+		return
+	}
 	s.handleOwnedMobileSegmentError(w, r, err)
 }
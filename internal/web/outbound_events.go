@@ -0,0 +1,120 @@
+package web
+
+import (
+	"context"
+
+	"b11k/internal/digest"
+	"b11k/internal/outbound"
+	"b11k/internal/pggeo"
+	"b11k/internal/queue"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// prDetectionQueueSize bounds activities waiting for PR detection; past it the oldest
+// waiting activity is skipped
+const prDetectionQueueSize = 256
+
+// prCheck is a newly saved activity waiting for PR detection
+type prCheck struct {
+	athleteID  int64
+	activityID int64
+}
+
+// activitySaved emits activity.created or activity.updated to the outbound webhooks and
+// queues new activities for PR detection when an endpoint wants segment.pr. It never
+// blocks, so it is safe on sync and request paths.
+func (s *server) activitySaved(activity *strava.ActivitySummary, created bool) {
+	eventType := outbound.EventActivityUpdated
+	if created {
+		eventType = outbound.EventActivityCreated
+	}
+	s.outbound.Emit(eventType, activity.AthleteID, activity)
+
+	if !created || s.prChecks == nil {
+		return
+	}
+	_ = s.prChecks.Push(s.ctx, prCheck{athleteID: activity.AthleteID, activityID: activity.ID})
+}
+
+// newPRCheckQueue returns the PR detection queue, which drops its oldest activity when
+// a bulk sync outpaces detection, counting the drops in collector
+func newPRCheckQueue(collector *digest.Collector) *queue.Queue[prCheck] {
+	checks, _ := queue.New("pr_detection", queue.Options[prCheck]{
+		Capacity: prDetectionQueueSize,
+		Policy:   queue.DropOldest,
+		OnDrop: func(check prCheck) {
+			collector.Warn(digestPRDetection, "dropped", "PR detection queue full, skipping activity", "activity_id", check.activityID)
+		},
+	})
+	return checks
+}
+
+// syncedActivitySaved is the sync OnActivitySaved hook; syncs only save new activities
+func (s *server) syncedActivitySaved(activity *strava.BikeActivity) {
+	s.activitySaved(&activity.Summary, true)
+}
+
+// runPRDetection measures queued activities on their athlete's favorite segments one at a
+// time and emits segment.pr for each new fastest traversal
+func (s *server) runPRDetection() {
+	for {
+		item, ok := s.prChecks.Pop(s.ctx)
+		if !ok {
+			return
+		}
+		check := item.Value
+		athleteDefault := s.athleteDefaultTolerance(check.athleteID)
+		var prs []pggeo.SegmentPR
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			prs, dbErr = pggeo.DetectSegmentPRs(s.ctx, conn, check.athleteID, check.activityID, athleteDefault)
+			return dbErr
+		})
+		s.prChecks.Done(item)
+		if err != nil {
+			s.digest.Warn(digestPRDetection, "failed", "PR detection failed", "activity_id", check.activityID, "error", err)
+			continue
+		}
+		s.digest.Add(digestPRDetection, "checked", 1)
+		for _, pr := range prs {
+			s.digest.Record(digestPRDetection, "prs", "Activity set a PR", "activity_id", pr.ActivityID, "segment_id", pr.SegmentID, "elapsed_s", pr.ElapsedSeconds, "previous_best_s", pr.PreviousBestSeconds)
+			s.outbound.Emit(outbound.EventSegmentPR, check.athleteID, pr)
+		}
+	}
+}
+
+// webhookStore keeps outbound webhook events that overflow their queue, or are still
+// queued at shutdown, in outbound_webhook_queue
+type webhookStore struct {
+	s *server
+}
+
+func (w webhookStore) Save(ctx context.Context, endpointURL string, events []outbound.Event) error {
+	return w.s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.SaveOutboundWebhookEvents(ctx, conn, endpointURL, events)
+	})
+}
+
+func (w webhookStore) Take(ctx context.Context, endpointURL string, limit int) ([]outbound.Event, error) {
+	var events []outbound.Event
+	err := w.s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		events, dbErr = pggeo.TakeOutboundWebhookEvents(ctx, conn, endpointURL, limit)
+		return dbErr
+	})
+	return events, err
+}
+
+// queueStats lists every in-process queue for GET /api/admin/queues
+func (s *server) queueStats() []queue.Stats {
+	stats := s.outbound.QueueStats()
+	if s.prChecks != nil {
+		stats = append(stats, s.prChecks.Stats())
+	}
+	if s.shareViews != nil {
+		stats = append(stats, s.shareViews.Stats())
+	}
+	return stats
+}
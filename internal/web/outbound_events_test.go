@@ -0,0 +1,147 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/outbound"
+	"b11k/internal/queue"
+	"b11k/internal/strava"
+)
+
+func TestActivitySavedEmitsSummaryWithoutPoints(t *testing.T) {
+	bodies := make(chan []byte, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	dispatcher, err := outbound.NewDispatcher([]outbound.Endpoint{{URL: receiver.URL, Secret: "s3cret"}}, outbound.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dispatcher.Start(ctx)
+	s := &server{ctx: ctx, outbound: dispatcher}
+
+	activity := &strava.BikeActivity{Summary: strava.ActivitySummary{ID: 10, AthleteID: 1, Name: "Morning Ride", Type: "Ride"}}
+	activity.LatLngStream.Data = [][]float64{{52.52, 13.405}, {52.53, 13.41}}
+	s.syncedActivitySaved(activity)
+	s.activitySaved(&activity.Summary, false)
+
+	for _, want := range []string{outbound.EventActivityCreated, outbound.EventActivityUpdated} {
+		select {
+		case body := <-bodies:
+			var event outbound.Event
+			var summary strava.ActivitySummary
+			if json.Unmarshal(body, &event) != nil || json.Unmarshal(event.Data, &summary) != nil {
+				t.Fatalf("undecodable event %q", body)
+			}
+			if event.Type != want || event.AthleteID != 1 || summary.ID != 10 || summary.Name != "Morning Ride" {
+				t.Fatalf("event = %s, want %s for activity 10", body, want)
+			}
+			if strings.Contains(string(body), "52.52") {
+				t.Fatalf("event carries points: %s", body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s delivery", want)
+		}
+	}
+}
+
+func TestAdminWebhookFailuresRequiresAdmin(t *testing.T) {
+	dispatcher, err := outbound.NewDispatcher([]outbound.Endpoint{{URL: "http://127.0.0.1:1/hook", Secret: "s3cret"}}, outbound.Options{QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		ctx:        context.Background(),
+		cfg:        Config{AdminAthleteIDs: []int64{1}},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+		outbound:   dispatcher,
+	}
+	s.cacheWebAthlete("token-admin", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-rider", &strava.Athlete{ID: 2})
+	// Not started, so the second event pushes the first out of the queue as a dead letter
+	s.activitySaved(&strava.ActivitySummary{ID: 10, AthleteID: 2}, true)
+	s.activitySaved(&strava.ActivitySummary{ID: 11, AthleteID: 2}, true)
+
+	h := s.routes()
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "token-rider": http.StatusForbidden, "token-admin": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/webhooks/failures", nil)
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: token})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("token %q = %d, want %d", token, rec.Code, want)
+		}
+		if want != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Failures []outbound.Failure `json:"failures"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Failures) != 1 || body.Failures[0].LastError != "queue full" {
+			t.Fatalf("failures = %s, want the overflowed event", rec.Body.String())
+		}
+	}
+}
+
+func TestAdminQueuesReportsEveryQueue(t *testing.T) {
+	dispatcher, err := outbound.NewDispatcher([]outbound.Endpoint{{URL: "http://127.0.0.1:1/hook", Secret: "s3cret"}}, outbound.Options{QueueSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		ctx:        context.Background(),
+		cfg:        Config{AdminAthleteIDs: []int64{1}},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+		outbound:   dispatcher,
+		prChecks:   newPRCheckQueue(nil),
+	}
+	s.cacheWebAthlete("token-admin", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-rider", &strava.Athlete{ID: 2})
+	// Nothing consumes either queue, so both overflow
+	for id := int64(1); id <= prDetectionQueueSize+5; id++ {
+		s.activitySaved(&strava.ActivitySummary{ID: id, AthleteID: 2}, true)
+	}
+
+	h := s.routes()
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/queues", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-rider"})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin = %d, want 403", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/queues", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-admin"})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var body struct {
+		Queues []queue.Stats `json:"queues"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &body) != nil || len(body.Queues) != 2 {
+		t.Fatalf("queues = %d %s", rec.Code, rec.Body.String())
+	}
+	webhooks, prs := body.Queues[0], body.Queues[1]
+	if webhooks.Policy != queue.DropOldest || webhooks.Depth != 2 || webhooks.Dropped != prDetectionQueueSize+3 {
+		t.Fatalf("webhook queue = %+v", webhooks)
+	}
+	if prs.Name != "pr_detection" || prs.Depth != prDetectionQueueSize || prs.Dropped != 5 {
+		t.Fatalf("PR detection queue = %+v", prs)
+	}
+}
@@ -0,0 +1,30 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// pageParams reads ?page= (from 1) and ?per_page= (1-100, default 20); invalid values
+// fall back to the defaults
+func pageParams(r *http.Request) (page, perPage int) {
+	page, perPage = 1, defaultPerPage
+	if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && n > 0 {
+		page = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && n > 0 && n <= maxPerPage {
+		perPage = n
+	}
+	return page, perPage
+}
+
+// pageCount is the number of pages for total items, at least one so an empty list still
+// has a page to show
+func pageCount(total, perPage int) int {
+	return max(1, (total+perPage-1)/perPage)
+}
@@ -0,0 +1,31 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPageParams(t *testing.T) {
+	cases := map[string][2]int{
+		"/api/activities":                        {1, 20},
+		"/api/activities?page=3&per_page=50":     {3, 50},
+		"/api/activities?page=0&per_page=101":    {1, 20},
+		"/api/activities?page=abc&per_page=-1":   {1, 20},
+		"/api/activities?page=2&per_page=100":    {2, 100},
+		"/?page=7&per_page=5&pinned_first=false": {7, 5},
+	}
+	for target, want := range cases {
+		page, perPage := pageParams(httptest.NewRequest("GET", target, nil))
+		if page != want[0] || perPage != want[1] {
+			t.Errorf("pageParams(%s) = %d, %d; want %d, %d", target, page, perPage, want[0], want[1])
+		}
+	}
+}
+
+func TestPageCount(t *testing.T) {
+	for _, tc := range []struct{ total, perPage, want int }{{0, 20, 1}, {1, 20, 1}, {20, 20, 1}, {21, 20, 2}, {100, 7, 15}} {
+		if got := pageCount(tc.total, tc.perPage); got != tc.want {
+			t.Errorf("pageCount(%d, %d) = %d, want %d", tc.total, tc.perPage, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pinnedFirst reports whether list endpoints should put pinned items first.
+// It defaults to true and can be disabled with ?pinned_first=false.
+func pinnedFirst(r *http.Request) bool {
+	return r.URL.Query().Get("pinned_first") != "false"
+}
+
+// handleActivityPin handles POST /api/activities/:id/pin and /unpin
+func (s *server) handleActivityPin(w http.ResponseWriter, r *http.Request, athleteID, activityID int64, pinned bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.SetActivityPinned(s.ctx, conn, athleteID, activityID, pinned)
+	})
+	if err != nil {
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+			return
+		}
+		slog.Error("Failed to update pin", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":     activityID,
+		"pinned": pinned,
+	})
+}
+
+// handleSegmentPin handles POST /api/segments/:id/pin and /unpin. The caller has already
+// checked that athleteID owns the segment.
+func (s *server) handleSegmentPin(w http.ResponseWriter, r *http.Request, athleteID, segmentID int64, pinned bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.SetSegmentPinned(s.ctx, conn, athleteID, segmentID, pinned)
+	})
+	if err != nil {
+		if errors.Is(err, pggeo.ErrSegmentNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, "segment not found"))
+			return
+		}
+		slog.Error("Failed to update pin", "segment_id", segmentID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":     segmentID,
+		"pinned": pinned,
+	})
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func TestPinnedFirstQueryParam(t *testing.T) {
+	cases := map[string]bool{
+		"/api/activities":                    true,
+		"/api/activities?pinned_first=true":  true,
+		"/api/activities?pinned_first=false": false,
+	}
+	for target, want := range cases {
+		if got := pinnedFirst(httptest.NewRequest("GET", target, nil)); got != want {
+			t.Errorf("pinnedFirst(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestSortActivitiesPinnedFirstKeepsOrder(t *testing.T) {
+	activities := []strava.ActivitySummary{
+		{ID: 1}, {ID: 2, Pinned: true}, {ID: 3}, {ID: 4, Pinned: true},
+	}
+	pggeo.SortActivitiesPinnedFirst(activities)
+	want := []int64{2, 4, 1, 3}
+	for i, id := range want {
+		if activities[i].ID != id {
+			t.Fatalf("order = %v, want %v", activityIDs(activities), want)
+		}
+	}
+	if pinned := pggeo.PinnedActivities(activities); len(pinned) != 2 || pinned[0].ID != 2 {
+		t.Fatalf("pinned = %v, want [2 4]", activityIDs(pinned))
+	}
+}
+
+func activityIDs(activities []strava.ActivitySummary) []int64 {
+	ids := make([]int64, len(activities))
+	for i, activity := range activities {
+		ids[i] = activity.ID
+	}
+	return ids
+}
@@ -0,0 +1,331 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"b11k/internal/cache"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	publicStatsTTL       = time.Hour
+	publicStatsCacheSize = 1024
+)
+
+// publicStatsEntry is a rendered /public/stats response
+type publicStatsEntry struct {
+	athleteID int64
+	tokenID   int64
+	body      []byte
+	expiresAt time.Time
+}
+
+// publicStatsCache keeps rendered public stats for publicStatsTTL, keyed by the token's
+// hash. Revoking bumps generation so responses computed meanwhile are not cached. The
+// zero value is ready to use.
+type publicStatsCache struct {
+	mu         sync.Mutex
+	entries    *cache.LRU[string, publicStatsEntry]
+	generation uint64
+}
+
+func (c *publicStatsCache) get(tokenKey string, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return nil, false
+	}
+	entry, ok := c.entries.Get(tokenKey)
+	if !ok || now.After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *publicStatsCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put caches entry unless tokens were revoked since generation was read
+func (c *publicStatsCache) put(tokenKey string, entry publicStatsEntry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if c.entries == nil {
+		c.entries = cache.NewLRU[string, publicStatsEntry](publicStatsCacheSize)
+	}
+	c.entries.Add(tokenKey, entry)
+}
+
+// forget drops the cached responses of one of the athlete's tokens, or all of them when
+// tokenID is 0
+func (c *publicStatsCache) forget(athleteID, tokenID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if c.entries == nil {
+		return
+	}
+	c.entries.RemoveFunc(func(_ string, entry publicStatsEntry) bool {
+		return entry.athleteID == athleteID && (tokenID == 0 || entry.tokenID == tokenID)
+	})
+}
+
+// publicStatsJSON renders the token's fields of stats; other fields are left out
+func publicStatsJSON(stats *pggeo.PublicStats, fields []string) ([]byte, error) {
+	oneDecimal := func(v float64) float64 { return math.Round(v*10) / 10 }
+	body := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case pggeo.PublicStatDistanceKM:
+			body[field] = oneDecimal(stats.DistanceM / 1000)
+		case pggeo.PublicStatRides:
+			body[field] = stats.Activities
+		case pggeo.PublicStatHours:
+			body[field] = oneDecimal(stats.MovingTimeS / 3600)
+		case pggeo.PublicStatElevationM:
+			body[field] = math.Round(stats.ElevationGainM)
+		case pggeo.PublicStatYearDistanceKM:
+			body[field] = oneDecimal(stats.YearDistanceM / 1000)
+		case pggeo.PublicStatLastActivityDate:
+			if stats.LastActivity != nil {
+				body[field] = stats.LastActivity.UTC().Format(dateParamLayout)
+			} else {
+				body[field] = nil
+			}
+		}
+	}
+	return json.Marshal(body)
+}
+
+// handlePublicStats serves GET /public/stats/{token} to any origin, for counters on
+// personal sites. Every request counts a view of the token, so a revoked, expired or used
+// up token ends at once, and queues the view for the owner's share stats; the stats
+// themselves are cached for an hour.
+func (s *server) handlePublicStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	switch r.Method {
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet:
+	default:
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/public/stats/")
+	if token == "" || strings.Contains(token, "/") {
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
+		return
+	}
+
+	tokenKey := shareTokenKey(token)
+	now := time.Now()
+	statsToken, err := s.usePublicStatsToken(tokenKey, now)
+	switch {
+	case errors.Is(err, pggeo.ErrShareTokenGone):
+		s.writeShareGone(w, r)
+		return
+	case err != nil:
+		slog.Error("Failed to check public stats link", "error", err)
+		writeError(w, r, newAPIError(http.StatusServiceUnavailable, "stats unavailable"))
+		return
+	case statsToken == nil:
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
+		return
+	}
+
+	body, ok := s.publicStats.get(tokenKey, now)
+	if !ok {
+		generation := s.publicStats.currentGeneration()
+		var stats *pggeo.PublicStats
+		yearStart := time.Date(now.UTC().Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		err := s.withReadDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			stats, dbErr = pggeo.GetPublicStats(s.ctx, conn, statsToken.AthleteID, yearStart)
+			return dbErr
+		})
+		if err == nil {
+			body, err = publicStatsJSON(stats, statsToken.Fields)
+		}
+		if err != nil {
+			slog.Error("Failed to load public stats", "error", err)
+			writeError(w, r, newAPIError(http.StatusServiceUnavailable, "stats unavailable"))
+			return
+		}
+		s.publicStats.put(tokenKey, publicStatsEntry{
+			athleteID: statsToken.AthleteID,
+			tokenID:   statsToken.ID,
+			body:      body,
+			expiresAt: now.Add(publicStatsTTL),
+		}, generation)
+	}
+
+	s.queueShareView(r, statsToken.ID, now)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(body)
+}
+
+// usePublicStatsToken counts a view of the token stored under tokenKey, see
+// pggeo.UsePublicStatsToken
+func (s *server) usePublicStatsToken(tokenKey string, now time.Time) (*pggeo.PublicStatsToken, error) {
+	if s.useStatsToken != nil {
+		return s.useStatsToken(tokenKey, now)
+	}
+	var token *pggeo.PublicStatsToken
+	// The primary, so a revoked token is never read back from a lagging replica
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		token, dbErr = pggeo.UsePublicStatsToken(s.ctx, conn, tokenKey, now)
+		return dbErr
+	})
+	return token, err
+}
+
+func (s *server) createPublicStatsToken(athleteID int64, tokenKey string, fields []string, limits pggeo.ShareLimits) (*pggeo.PublicStatsToken, error) {
+	if s.createStatsToken != nil {
+		return s.createStatsToken(athleteID, tokenKey, fields, limits)
+	}
+	var created *pggeo.PublicStatsToken
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		created, dbErr = pggeo.CreatePublicStatsToken(s.ctx, conn, athleteID, tokenKey, fields, limits)
+		return dbErr
+	})
+	return created, err
+}
+
+// publicStatsTokenResponse is a token as created by POST /api/public-stats-token; the
+// token itself is only ever returned here
+type publicStatsTokenResponse struct {
+	pggeo.PublicStatsToken
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// handlePublicStatsToken lists (GET), creates (POST {"fields": [...], "expires_in":
+// seconds, "max_views": n}, every field when empty, the limits optional) and revokes
+// (DELETE ?id=, every token without id) the caller's public stats tokens
+func (s *server) handlePublicStatsToken(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var tokens []pggeo.PublicStatsToken
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			tokens, dbErr = pggeo.ListPublicStatsTokens(s.ctx, conn, scope.AthleteID)
+			return dbErr
+		})
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"tokens": tokens})
+	case http.MethodPost:
+		var req struct {
+			Fields []string `json:"fields"`
+			shareLimitsRequest
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
+				return
+			}
+		}
+		fields, err := publicStatsTokenFields(req.Fields)
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		limits, err := req.limits(time.Now())
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		token, tokenKey, err := newShareToken()
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusInternalServerError, "failed to create token"))
+			return
+		}
+		created, err := s.createPublicStatsToken(scope.AthleteID, tokenKey, fields, limits)
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Public stats link created", "athlete_id", scope.AthleteID, "link_id", created.ID, "fields", strings.Join(fields, ","))
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, publicStatsTokenResponse{PublicStatsToken: *created, Token: token, URL: s.url("/public/stats/" + token)})
+	case http.MethodDelete:
+		var tokenID int64
+		if idStr := r.URL.Query().Get("id"); idStr != "" {
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil || id <= 0 {
+				writeError(w, r, newAPIError(http.StatusBadRequest, "invalid token id"))
+				return
+			}
+			tokenID = id
+		}
+		var revoked int64
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			revoked, dbErr = pggeo.DeletePublicStatsTokens(s.ctx, conn, scope.AthleteID, tokenID)
+			return dbErr
+		})
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		s.publicStats.forget(scope.AthleteID, tokenID)
+		if tokenID != 0 && revoked == 0 {
+			writeError(w, r, newAPIError(http.StatusNotFound, "token not found"))
+			return
+		}
+		writeJSON(w, map[string]int64{"revoked": revoked})
+	default:
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+	}
+}
+
+// publicStatsTokenFields validates and de-duplicates the requested fields, keeping the
+// order of pggeo.PublicStatFields; none requested means all of them
+func publicStatsTokenFields(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return append([]string(nil), pggeo.PublicStatFields...), nil
+	}
+	wanted := make(map[string]bool, len(requested))
+	for _, field := range requested {
+		field = strings.TrimSpace(field)
+		if !pggeo.ValidPublicStatField(field) {
+			return nil, fmt.Errorf("unknown field %q; choose from %s", field, strings.Join(pggeo.PublicStatFields, ", "))
+		}
+		wanted[field] = true
+	}
+	fields := make([]string, 0, len(wanted))
+	for _, field := range pggeo.PublicStatFields {
+		if wanted[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+func TestPublicStatsJSONKeepsOnlyTokenFields(t *testing.T) {
+	last := time.Date(2024, 6, 1, 23, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	stats := &pggeo.PublicStats{Activities: 12, DistanceM: 123456, MovingTimeS: 5400, ElevationGainM: 1500.6, YearDistanceM: 45678, LastActivity: &last}
+
+	body, err := publicStatsJSON(stats, []string{pggeo.PublicStatDistanceKM, pggeo.PublicStatLastActivityDate})
+	if err != nil {
+		t.Fatalf("publicStatsJSON: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("body %q: %v", body, err)
+	}
+	want := map[string]interface{}{"distance_km": 123.5, "last_activity_date": "2024-06-01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("body = %v, want %v", got, want)
+	}
+
+	body, _ = publicStatsJSON(stats, pggeo.PublicStatFields)
+	got = nil
+	_ = json.Unmarshal(body, &got)
+	if len(got) != len(pggeo.PublicStatFields) || got["hours"] != 1.5 || got["elevation_m"] != 1501.0 || got["rides"] != 12.0 {
+		t.Fatalf("all fields = %v", got)
+	}
+
+	fields, err := publicStatsTokenFields([]string{"hours", "rides", "hours"})
+	if err != nil || !reflect.DeepEqual(fields, []string{"rides", "hours"}) {
+		t.Fatalf("fields = %v, %v; want rides, hours", fields, err)
+	}
+	if _, err := publicStatsTokenFields([]string{"start_latlng"}); err == nil {
+		t.Fatal("a location field was accepted")
+	}
+	if fields, _ := publicStatsTokenFields(nil); len(fields) != len(pggeo.PublicStatFields) {
+		t.Fatalf("default fields = %v, want all", fields)
+	}
+}
+
+func getPublicStats(s *server, method, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/public/stats/"+token, nil)
+	req.Header.Set("Origin", "https://blog.example")
+	rec := httptest.NewRecorder()
+	s.handlePublicStats(rec, req)
+	return rec
+}
+
+// issuedStatsTokens answers token lookups with a token of athleteID for each issued
+// token, as public_stats_tokens would
+func issuedStatsTokens(s *server, athleteID int64, tokens ...string) {
+	issued := make(map[string]int64, len(tokens))
+	for i, token := range tokens {
+		issued[shareTokenKey(token)] = int64(i + 1)
+	}
+	s.useStatsToken = func(tokenKey string, _ time.Time) (*pggeo.PublicStatsToken, error) {
+		id, ok := issued[tokenKey]
+		if !ok {
+			return nil, nil
+		}
+		return &pggeo.PublicStatsToken{ID: id, AthleteID: athleteID, Fields: pggeo.PublicStatFields}, nil
+	}
+}
+
+func TestPublicStatsServesAnyOriginFromCache(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	issuedStatsTokens(s, 7, "tok-a")
+	s.publicStats.put(mobileSessionStorageKey("tok-a"), publicStatsEntry{
+		athleteID: 7, tokenID: 1, body: []byte(`{"rides":3}`), expiresAt: time.Now().Add(time.Hour),
+	}, s.publicStats.currentGeneration())
+
+	rec := getPublicStats(s, http.MethodGet, "tok-a")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"rides":3}` {
+		t.Fatalf("GET = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("CORS headers = %v", rec.Header())
+	}
+	if rec := getPublicStats(s, http.MethodOptions, "tok-a"); rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != "GET, OPTIONS" {
+		t.Fatalf("preflight = %d %v", rec.Code, rec.Header())
+	}
+	if rec := getPublicStats(s, http.MethodPost, "tok-a"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST = %d, want 405", rec.Code)
+	}
+
+	// The rest of the API stays same-origin
+	req := httptest.NewRequest(http.MethodGet, "/api/public-stats-token", nil)
+	req.Header.Set("Origin", "https://blog.example")
+	rec = httptest.NewRecorder()
+	s.handlePublicStatsToken(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("token API sent CORS headers: %v", rec.Header())
+	}
+}
+
+func TestRevokedPublicStatsTokenIsNotServedFromCache(t *testing.T) {
+	withShortDBRetryBackoff(t)
+	s := &server{ctx: context.Background(), pool: unreachablePool(t)}
+	// The token rows still answer, so only the cache decides what is served
+	issuedStatsTokens(s, 7, "tok-a", "tok-b", "tok-other")
+	cached := func(token string, athleteID, tokenID int64) {
+		s.publicStats.put(mobileSessionStorageKey(token), publicStatsEntry{
+			athleteID: athleteID, tokenID: tokenID, body: []byte(`{}`), expiresAt: time.Now().Add(time.Hour),
+		}, s.publicStats.currentGeneration())
+	}
+	cached("tok-a", 7, 1)
+	cached("tok-b", 7, 2)
+	cached("tok-other", 8, 3)
+
+	// A response rendered before the revocation must not be cached after it
+	generation := s.publicStats.currentGeneration()
+	s.publicStats.forget(7, 1)
+	s.publicStats.put(mobileSessionStorageKey("tok-a"), publicStatsEntry{athleteID: 7, tokenID: 1, body: []byte(`{}`), expiresAt: time.Now().Add(time.Hour)}, generation)
+
+	// Without the cache the handler goes to the (unreachable) database instead of a 200
+	if rec := getPublicStats(s, http.MethodGet, "tok-a"); rec.Code == http.StatusOK {
+		t.Fatalf("revoked token served %q", rec.Body.String())
+	}
+	if rec := getPublicStats(s, http.MethodGet, "tok-b"); rec.Code != http.StatusOK {
+		t.Fatalf("other token of the athlete = %d, want cached 200", rec.Code)
+	}
+
+	s.forgetWebAthlete(7)
+	if rec := getPublicStats(s, http.MethodGet, "tok-b"); rec.Code == http.StatusOK {
+		t.Fatal("deleting the athlete kept their public stats cached")
+	}
+	if rec := getPublicStats(s, http.MethodGet, "tok-other"); rec.Code != http.StatusOK {
+		t.Fatalf("another athlete's token = %d, want cached 200", rec.Code)
+	}
+}
+
+func TestPublicStatsErrorsUseTheAPIErrorShape(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	issuedStatsTokens(s, 7)
+
+	req := httptest.NewRequest(http.MethodGet, "/public/stats/unknown", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	s.handlePublicStats(rec, req)
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if rec.Code != http.StatusNotFound || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body.Error.Code != "not_found" {
+		t.Fatalf("unknown token = %d %q, want a JSON not_found error", rec.Code, rec.Body.String())
+	}
+
+	// Embeds that do not ask for JSON keep the plain text answer
+	if rec := getPublicStats(s, http.MethodGet, "unknown"); rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("unknown token without Accept = %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
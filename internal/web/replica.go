@@ -0,0 +1,90 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	replicaHealthInterval = 30 * time.Second
+	replicaPingTimeout    = 3 * time.Second
+)
+
+// readReplica is an optional read-only pool for heavy analytical reads. It starts out
+// unhealthy and is only used once a health check has reached it.
+type readReplica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// readPool returns the replica when one is configured and healthy, otherwise the primary
+func (s *server) readPool() *pgxpool.Pool {
+	if s.replica != nil && s.replica.healthy.Load() {
+		return s.replica.pool
+	}
+	return s.pool
+}
+
+// withReadDB runs a read-only op on the replica when available. Inserts, cache writes and
+// reads that must see a write from the same request go through withDB instead, so replica
+// lag never hides them. A replica failure marks it unhealthy and the op is retried on the
+// primary.
+func (s *server) withReadDB(op func(*pgxpool.Pool) error) error {
+	pool := s.readPool()
+	if pool == s.pool {
+		return s.withDB(op)
+	}
+	err := op(pool)
+	if err == nil || !isRecoverableDBError(err) && !isReplicaUnavailable(err) {
+		return err
+	}
+	s.markReplicaUnhealthy(err)
+	return s.withDB(op)
+}
+
+// isReplicaUnavailable reports errors that mean the replica could not serve the query at
+// all, as opposed to the query itself failing
+func isReplicaUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (s *server) markReplicaUnhealthy(err error) {
+	if s.replica.healthy.Swap(false) {
+		slog.Warn("Read replica unavailable, falling back to primary", "error", err)
+	}
+}
+
+// checkReplica pings the replica and updates its health, logging transitions
+func (s *server) checkReplica() {
+	ctx, cancel := context.WithTimeout(s.ctx, replicaPingTimeout)
+	defer cancel()
+	err := s.replica.pool.Ping(ctx)
+	if err != nil {
+		s.markReplicaUnhealthy(err)
+		return
+	}
+	if !s.replica.healthy.Swap(true) {
+		slog.Info("Read replica healthy, routing analytical reads to it")
+	}
+}
+
+// runReplicaHealthChecks re-checks the replica until the server shuts down
+func (s *server) runReplicaHealthChecks() {
+	ticker := time.NewTicker(replicaHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkReplica()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// unreachablePool returns a pool that never dials until used, so tests can compare which
+// pool an op was handed without a database
+func unreachablePool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	pool, err := pggeo.NewReplicaPool(context.Background(), "b11k", "", "127.0.0.1", "1", "b11k_db", 1)
+	if err != nil {
+		t.Fatalf("NewReplicaPool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func replicaTestServer(t *testing.T, healthy bool) *server {
+	s := &server{ctx: context.Background(), pool: unreachablePool(t), replica: &readReplica{pool: unreachablePool(t)}}
+	s.replica.healthy.Store(healthy)
+	return s
+}
+
+func poolUsedBy(t *testing.T, with func(func(*pgxpool.Pool) error) error) *pgxpool.Pool {
+	t.Helper()
+	var used *pgxpool.Pool
+	if err := with(func(conn *pgxpool.Pool) error {
+		used = conn
+		return nil
+	}); err != nil {
+		t.Fatalf("op failed: %v", err)
+	}
+	return used
+}
+
+func TestReadsRouteToHealthyReplica(t *testing.T) {
+	s := replicaTestServer(t, true)
+	if got := poolUsedBy(t, s.withReadDB); got != s.replica.pool {
+		t.Fatal("read-only op did not use the healthy replica")
+	}
+	if got := poolUsedBy(t, s.withDB); got != s.pool {
+		t.Fatal("write op did not use the primary")
+	}
+}
+
+func TestReadsUsePrimaryWithoutHealthyReplica(t *testing.T) {
+	unhealthy := replicaTestServer(t, false)
+	if got := poolUsedBy(t, unhealthy.withReadDB); got != unhealthy.pool {
+		t.Fatal("read-only op used an unhealthy replica")
+	}
+
+	unset := &server{pool: unreachablePool(t)}
+	if got := poolUsedBy(t, unset.withReadDB); got != unset.pool {
+		t.Fatal("read-only op did not use the primary when no replica is configured")
+	}
+}
+
+func TestReplicaFailureFallsBackToPrimary(t *testing.T) {
+	s := replicaTestServer(t, true)
+	var used []*pgxpool.Pool
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		used = append(used, conn)
+		if conn == s.replica.pool {
+			return fmt.Errorf("query replica: %w", context.DeadlineExceeded)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("withReadDB: %v", err)
+	}
+	if len(used) != 2 || used[0] != s.replica.pool || used[1] != s.pool {
+		t.Fatalf("expected replica then primary, got %d attempts", len(used))
+	}
+	if s.replica.healthy.Load() {
+		t.Fatal("replica still marked healthy after failing")
+	}
+	if got := poolUsedBy(t, s.withReadDB); got != s.pool {
+		t.Fatal("later reads did not stay on the primary")
+	}
+}
+
+func TestReplicaQueryErrorIsNotRetried(t *testing.T) {
+	s := replicaTestServer(t, true)
+	queryErr := errors.New("relation does not exist")
+	attempts := 0
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		attempts++
+		return queryErr
+	})
+	if !errors.Is(err, queryErr) || attempts != 1 {
+		t.Fatalf("got %v after %d attempts, want the query error after 1", err, attempts)
+	}
+	if !s.replica.healthy.Load() {
+		t.Fatal("a failing query should not mark the replica unhealthy")
+	}
+}
+
+func TestCheckReplicaMarksUnreachableReplicaUnhealthy(t *testing.T) {
+	s := replicaTestServer(t, true)
+	s.checkReplica()
+	if s.replica.healthy.Load() {
+		t.Fatal("unreachable replica still marked healthy")
+	}
+}
@@ -0,0 +1,145 @@
+package web
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"b11k/internal/metrics"
+)
+
+// requestLogKey carries the *requestLogEntry of a request in its context
+type requestLogKey struct{}
+
+// requestLogEntry collects what the handlers learn about a request for its log line
+type requestLogEntry struct {
+	athleteID atomic.Int64
+	route     string // ServeMux pattern, empty when none matched
+}
+
+// noteRequestAthlete records the signed-in athlete in the request's log line
+func noteRequestAthlete(r *http.Request, athleteID int64) {
+	if entry, ok := r.Context().Value(requestLogKey{}).(*requestLogEntry); ok {
+		entry.athleteID.Store(athleteID)
+	}
+}
+
+// notingRoute records the pattern mux served each request with
+func notingRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ServeMux sets the pattern on the request it was given
+		defer func() {
+			if entry, ok := r.Context().Value(requestLogKey{}).(*requestLogEntry); ok {
+				entry.route = r.Pattern
+			}
+		}()
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// observeRequests logs one line per request once it is answered, with method, path,
+// status, duration and the signed-in athlete, and times it by route in
+// http_request_duration_seconds. The query string is left out and tokens in the path
+// are masked, so credentials never reach the log. Health checks, metrics scrapes and
+// static files are logged at debug, server errors at error.
+func (s *server) observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		entry := &requestLogEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry))
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		elapsed := time.Since(started)
+
+		route := entry.route
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequest(route, r.Method, recorder.statusCode(), elapsed)
+		path := loggedRequestPath(r.URL.Path, s.cfg.BasePath)
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", path),
+			slog.Int("status", recorder.statusCode()),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+		}
+		if athleteID := entry.athleteID.Load(); athleteID != 0 {
+			attrs = append(attrs, slog.Int64("athlete_id", athleteID))
+		}
+		s.requestLog().LogAttrs(r.Context(), requestLogLevel(path, s.cfg.BasePath, recorder.statusCode()), "HTTP request", attrs...)
+	})
+}
+
+func (s *server) requestLog() *slog.Logger {
+	if s.requestLogger != nil {
+		return s.requestLogger
+	}
+	return slog.Default()
+}
+
+// requestLogLevel is error for server errors, debug for routine probes and assets, and
+// info otherwise
+func requestLogLevel(path, basePath string, status int) slog.Level {
+	if status >= http.StatusInternalServerError {
+		return slog.LevelError
+	}
+	switch path = strings.TrimPrefix(path, basePath); {
+	case path == "/healthz", path == "/readyz", path == "/metrics", strings.HasPrefix(path, "/static/"):
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// loggedRequestPath masks the public stats token, the only credential carried in a path
+func loggedRequestPath(path, basePath string) string {
+	prefix := basePath + "/public/stats/"
+	if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+		return prefix + "[redacted]"
+	}
+	return safeLogText(path)
+}
+
+// statusRecorder remembers the status a handler answered with. It passes Flush on and
+// unwraps for http.ResponseController, so streaming responses keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 && code >= http.StatusOK {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// statusCode is the answered status; a handler that wrote nothing answered 200
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
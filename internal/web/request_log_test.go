@@ -0,0 +1,173 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/digest"
+	"b11k/internal/metrics"
+	"b11k/internal/pggeo"
+)
+
+// captureRequestLog sends the request log of s to the returned buffer as JSON lines
+func captureRequestLog(s *server) *bytes.Buffer {
+	var buf bytes.Buffer
+	s.requestLogger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &buf
+}
+
+func lastRequestLog(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &record); err != nil {
+		t.Fatalf("request log %q: %v", buf.String(), err)
+	}
+	return record
+}
+
+func TestRequestLogRecordsTheSignedInAthlete(t *testing.T) {
+	s := newSyncJobTestServer()
+	buf := captureRequestLog(s)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sync/status?code=secret-code", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	s.routes().ServeHTTP(httptest.NewRecorder(), req)
+
+	record := lastRequestLog(t, buf)
+	if record["msg"] != "HTTP request" || record["level"] != "INFO" || record["method"] != "GET" ||
+		record["path"] != "/api/sync/status" || record["status"] != float64(200) || record["athlete_id"] != float64(1) {
+		t.Fatalf("request log = %v", record)
+	}
+	if _, ok := record["duration_ms"].(float64); !ok {
+		t.Fatalf("request log has no duration: %v", record)
+	}
+	if out := buf.String(); strings.Contains(out, "secret-code") || strings.Contains(out, "token-a") {
+		t.Fatalf("request log leaks a credential: %s", out)
+	}
+
+	// Without a login there is no athlete, and static files are only logged at debug
+	s.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/sync/status", nil))
+	if record = lastRequestLog(t, buf); record["status"] != float64(401) || record["athlete_id"] != nil {
+		t.Fatalf("anonymous request log = %v", record)
+	}
+	s.routes().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/static/missing.js", nil))
+	if record = lastRequestLog(t, buf); record["path"] != "/static/missing.js" || record["level"] != "DEBUG" {
+		t.Fatalf("static file log = %v", record)
+	}
+}
+
+func TestRequestLogStatusOfPanicsAndStreams(t *testing.T) {
+	s := newSyncJobTestServer()
+	buf := captureRequestLog(s)
+
+	s.observeRequests(recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/segments", nil))
+	if record := lastRequestLog(t, buf); record["status"] != float64(500) || record["level"] != "ERROR" {
+		t.Fatalf("panic log = %v", record)
+	}
+
+	// Streaming handlers still find a Flusher behind the recorder
+	rec := httptest.NewRecorder()
+	s.observeRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("the request log hides http.Flusher")
+		}
+		flusher.Flush()
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Fatalf("ResponseController.Flush: %v", err)
+		}
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/strava/sync", nil))
+	if !rec.Flushed {
+		t.Fatal("Flush did not reach the response")
+	}
+	if record := lastRequestLog(t, buf); record["status"] != float64(200) {
+		t.Fatalf("stream log = %v", record)
+	}
+}
+
+func TestLoggedRequestPathMasksPublicStatsTokens(t *testing.T) {
+	for _, tt := range []struct{ path, basePath, want string }{
+		{"/public/stats/abc123", "", "/public/stats/[redacted]"},
+		{"/b11k/public/stats/abc123", "/b11k", "/b11k/public/stats/[redacted]"},
+		{"/public/stats/", "", "/public/stats/"},
+		{"/api/activities/5\n", "", `/api/activities/5\n`},
+	} {
+		if got := loggedRequestPath(tt.path, tt.basePath); got != tt.want {
+			t.Fatalf("loggedRequestPath(%q, %q) = %q, want %q", tt.path, tt.basePath, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsAreServedWhenEnabledAndTimeRequestsByRoute(t *testing.T) {
+	s := newSyncJobTestServer()
+	captureRequestLog(s)
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /metrics while disabled = %d, want 404", rec.Code)
+	}
+
+	s.cfg.MetricsEnabled = true
+	req := httptest.NewRequest(http.MethodGet, "/api/sync/status", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	s.routes().ServeHTTP(httptest.NewRecorder(), req)
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", rec.Code)
+	}
+	for _, want := range []string{
+		`http_request_duration_seconds_count{method="GET",route="GET /api/sync/status",status="200"}`,
+		`http_request_duration_seconds_count{method="GET",route="unmatched",status="404"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics lack %q", want)
+		}
+	}
+}
+
+func TestMetricsExportTheServerState(t *testing.T) {
+	s := newSyncJobTestServer()
+	s.cfg.MetricsEnabled = true
+	s.cfg.Limits = pggeo.SoftLimits{MaxPointSamples: 100}
+	s.digest = digest.New(digest.Options{})
+	s.digest.Register(digestShareViews, "recorded", "pruned", "dropped", "failed")
+	s.shareViews = newShareViewQueue(s.digest)
+	s.spatial.set(false, true, "st_dwithin missing", nil)
+	s.softLimits.set(&pggeo.InstanceUsage{PointSamples: 150}, nil, time.Now(), nil)
+	s.webAthletes.hits.Add(4)
+	metrics.SetSources(s.metricsSources())
+	t.Cleanup(func() { metrics.SetSources(metrics.Sources{}) })
+
+	_ = s.shareViews.Push(context.Background(), pggeo.ShareView{TokenID: 1})
+	s.digest.Add(digestShareViews, "recorded", 2)
+	s.digest.Flush()
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`spatial_self_check_degraded 1`,
+		`athlete_cache_hits_total 4`,
+		`queue_depth{queue="share_views"} 1`,
+		`soft_limit_exceeded{limit="point_samples"} 1`,
+		`digest_events_total{counter="recorded",subsystem="share views"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics lack %q:\n%s", want, rec.Body.String())
+		}
+	}
+	if strings.Contains(rec.Body.String(), `limit="database_size"`) {
+		t.Fatal("an unset soft limit was exported")
+	}
+}
@@ -0,0 +1,330 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+
+	"b11k/internal/metrics"
+	"b11k/internal/pggeo"
+)
+
+// routes registers every handler on root-relative paths and mounts them under the
+// configured base path.
+func (s *server) routes() http.Handler {
+	mux := s.routeMux()
+	return s.observeRequests(mountBasePath(s.cfg.BasePath, s.securityMiddleware(recoverPanics(notingRoute(mux.ServeMux)))))
+}
+
+// routeMux is a ServeMux that keeps the patterns registered on it, so tests can sweep
+// every route
+type routeMux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func (m *routeMux) Handle(pattern string, handler http.Handler) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.Handle(pattern, handler)
+}
+
+func (m *routeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.patterns = append(m.patterns, pattern)
+	m.ServeMux.HandleFunc(pattern, handler)
+}
+
+// routeMux registers every handler on root-relative paths
+func (s *server) routeMux() *routeMux {
+	mux := &routeMux{ServeMux: http.NewServeMux()}
+	// Only the exact root: a catch-all would answer 404 where a method mismatch should be 405
+	mux.HandleFunc("/{$}", s.handleIndex)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/strava/", s.handleStravaHome)
+	mux.HandleFunc("/strava/login", s.handleStravaLogin)
+	mux.HandleFunc("GET /activity/{id}", s.handleActivity)
+	s.activityRoutes(mux)
+	mux.HandleFunc("/api/stats", s.handleStatsAPI)
+	mux.HandleFunc("/strava/callback", s.handleStravaCallback)
+	mux.HandleFunc("/strava/logout", s.handleStravaLogout)
+	mux.HandleFunc("/api/hrzones", s.handleHRZones)
+	mux.HandleFunc("/api/gear", s.handleGear)
+	mux.HandleFunc("GET /api/tags", s.handleTags)
+	mux.HandleFunc("/api/training-load", s.handleTrainingLoad)
+	mux.HandleFunc("/api/mobile/auth/start", s.handleMobileAuthStart)
+	mux.HandleFunc("/api/mobile/auth/exchange", s.handleMobileAuthExchange)
+	mux.HandleFunc("/api/mobile/auth/callback", s.handleMobileAuthCallback)
+	mux.HandleFunc("/api/mobile/auth/session", s.handleMobileAuthSession)
+	mux.HandleFunc("/api/mobile/me", s.handleMobileMe)
+	mux.HandleFunc("/api/mobile/profile", s.handleMobileProfile)
+	mux.HandleFunc("/api/mobile/logout", s.handleMobileLogout)
+	mux.HandleFunc("/api/mobile/sync", s.handleMobileSync)
+	mux.HandleFunc("/api/mobile/activities", s.handleMobileActivities)
+	mux.HandleFunc("/api/mobile/activities/", s.handleMobileActivities)
+	mux.HandleFunc("/api/mobile/segments", s.handleMobileSegments)
+	mux.HandleFunc("/api/mobile/segments/", s.handleMobileSegments)
+	s.syncRoutes(mux)
+	s.segmentRoutes(mux)
+	mux.HandleFunc("/segments", s.handleSegmentsPage)
+	mux.HandleFunc("GET /segment/{id}", s.handleSegmentPage)
+	mux.HandleFunc("/profile", s.handleProfilePage)
+	mux.HandleFunc("/api/me/export", s.handleMeExport)
+	mux.HandleFunc("/api/me/delete-request", s.handleMeDeleteRequest)
+	mux.HandleFunc("/api/me/settings", s.handleMeSettings)
+	mux.HandleFunc("/api/me/ready", s.handleMeReady)
+	mux.HandleFunc("/api/sessions", s.handleSessionsAPI)
+	mux.HandleFunc("/api/sessions/", s.handleSessionsAPI)
+	mux.HandleFunc("/api/announcements", s.handleAnnouncements)
+	mux.HandleFunc("/api/announcements/", s.handleAnnouncements)
+	mux.HandleFunc("/settings", s.handleSettingsPage)
+	mux.HandleFunc("/api/public-stats-token", s.handlePublicStatsToken)
+	mux.HandleFunc("/api/shares", s.handleShares)
+	mux.HandleFunc("/api/shares/{id}/stats", s.handleShareStats)
+	mux.HandleFunc("/public/stats/", s.handlePublicStats)
+	mux.HandleFunc("/api/admin/webhooks/failures", s.handleAdminWebhookFailures)
+	mux.HandleFunc("/api/admin/queues", s.handleAdminQueues)
+	mux.HandleFunc("/api/admin/digest", s.handleAdminDigest)
+	mux.HandleFunc("/api/admin/backfill-distance", s.handleAdminBackfillDistance)
+	mux.HandleFunc("/api/admin/resimplify", s.handleAdminResimplify)
+	mux.HandleFunc("/api/admin/limits", s.handleAdminLimits)
+	mux.HandleFunc("/api/admin/announcements", s.handleAdminAnnouncements)
+	mux.HandleFunc("/api/admin/announcements/", s.handleAdminAnnouncements)
+	if s.cfg.MetricsEnabled {
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+	if s.cfg.StravaWebhookVerifyToken != "" {
+		mux.HandleFunc("/strava/webhook", s.handleStravaWebhook)
+	}
+	if s.cfg.DiscoveredMapEnabled {
+		mux.HandleFunc("/api/mobile/discovered/", s.handleMobileDiscovered)
+		mux.HandleFunc("/discovered", s.handleDiscoveredPage)
+		mux.HandleFunc("/api/discovered/", s.handleDiscoveredAPI)
+	}
+
+	// static
+	mux.Handle("/static/", http.StripPrefix("/static/", s.staticFileServer()))
+	return mux
+}
+
+// Read-only routes, which anonymous visitors may use on the public athlete, resolve the
+// athlete with readable, activityReadRoute or segmentReadRoute; every other route needs
+// a login.
+
+// activityRoutes registers /api/activities and the endpoints of one activity
+func (s *server) activityRoutes(mux *routeMux) {
+	mux.HandleFunc("GET /api/activities", s.readable(s.handleActivitiesAPI))
+	mux.HandleFunc("POST /api/activities/visibility", s.handleActivitiesVisibilityAPI)
+	mux.HandleFunc("POST /api/activities/import", s.handleActivityImport)
+	mux.HandleFunc("GET /api/activities/geojson", s.handleRoutesGeoJSON)
+	mux.HandleFunc("GET /api/activities/compare", s.handleActivityCompare)
+	mux.HandleFunc("PATCH /api/activities/{id}", s.activityRoute(s.handleActivityPatch))
+	mux.HandleFunc("DELETE /api/activities/{id}", s.activityRoute(func(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64) {
+		s.handleActivityDelete(w, r, scope.AthleteID, activityID)
+	}))
+	for action, pinned := range map[string]bool{"pin": true, "unpin": false} {
+		mux.HandleFunc("POST /api/activities/{id}/"+action, s.activityRoute(func(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64) {
+			s.handleActivityPin(w, r, scope.AthleteID, activityID, pinned)
+		}))
+	}
+	mux.HandleFunc("POST /api/activities/{id}/recompute-grades", s.activityRoute(athleteActivity(s.handleActivityRecomputeGrades)))
+	mux.HandleFunc("POST /api/activities/{id}/heal", s.activityRoute(athleteActivity(s.handleActivityHeal)))
+	mux.HandleFunc("GET /api/activities/{id}/wind-estimate", s.activityRoute(athleteActivity(s.handleActivityWindEstimate)))
+	mux.HandleFunc("GET /api/activities/{id}/graph", s.activityReadRoute(s.handleActivityGraph))
+	mux.HandleFunc("GET /api/activities/{id}/points", s.activityReadRoute(s.handleActivityPoints))
+	mux.HandleFunc("GET /api/activities/{id}/similar", s.activityRoute(athleteActivity(s.handleActivitySimilar)))
+	mux.HandleFunc("GET /api/activities/{id}/tags", s.activityRoute(athleteActivity(s.handleActivityTags)))
+	mux.HandleFunc("POST /api/activities/{id}/tags", s.activityRoute(athleteActivity(s.handleActivityTagAdd)))
+	mux.HandleFunc("DELETE /api/activities/{id}/tags/{tag}", s.activityRoute(athleteActivity(s.handleActivityTagRemove)))
+}
+
+// segmentRoutes registers /api/segments and the endpoints of one favorite segment
+func (s *server) segmentRoutes(mux *routeMux) {
+	mux.HandleFunc("GET /api/segments", s.readable(s.handleSegmentsList))
+	mux.HandleFunc("POST /api/segments", s.signedIn(func(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+		s.handleSegmentCreate(w, r, scope.AthleteID)
+	}))
+	mux.HandleFunc("GET /api/segments/export.gpx", s.handleSegmentsGPXExport)
+	mux.HandleFunc("GET /api/segments/export", s.signedIn(s.handleSegmentsExport))
+	mux.HandleFunc("POST /api/segments/import", s.signedIn(s.handleSegmentsImport))
+	mux.HandleFunc("GET /api/segments/{id}", s.segmentReadRoute(s.handleSegmentGet))
+	mux.HandleFunc("PATCH /api/segments/{id}", s.segmentRoute(func(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+		s.handleSegmentPatch(w, r, scope.AthleteID, segment.ID)
+	}))
+	mux.HandleFunc("PUT /api/segments/{id}", s.segmentRoute(func(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+		s.handleSegmentUpdate(w, r, scope.AthleteID, segment)
+	}))
+	mux.HandleFunc("DELETE /api/segments/{id}", s.segmentRoute(s.handleSegmentDelete))
+	for action, pinned := range map[string]bool{"pin": true, "unpin": false} {
+		mux.HandleFunc("POST /api/segments/{id}/"+action, s.segmentRoute(func(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+			s.handleSegmentPin(w, r, scope.AthleteID, segment.ID, pinned)
+		}))
+	}
+	mux.HandleFunc("GET /api/segments/{id}/graph", s.segmentReadRoute(s.handleSegmentGraph))
+	mux.HandleFunc("GET /api/segments/{id}/effort-distribution", s.segmentRoute(s.handleSegmentEffortDistribution))
+	mux.HandleFunc("GET /api/segments/{id}/timeline", s.segmentRoute(s.handleSegmentTimeline))
+	mux.HandleFunc("GET /api/segments/{id}/gpx", s.segmentRoute(s.handleSegmentGPX))
+	mux.HandleFunc("GET /api/segments/{id}/metrics", s.segmentReadRoute(s.handleSegmentMetrics))
+	mux.HandleFunc("GET /api/segments/{id}/activities", s.segmentReadRoute(s.handleSegmentActivities))
+	mux.HandleFunc("GET /api/segments/{id}/activity/{activityID}/indices", s.segmentEffortRoute(true))
+	mux.HandleFunc("GET /api/segments/{id}/activity/{activityID}/metrics", s.segmentEffortRoute(false))
+}
+
+// syncRoutes registers the web sync endpoints
+func (s *server) syncRoutes(mux *routeMux) {
+	mux.HandleFunc("/strava/sync", s.handleStravaSyncSSE)
+	mux.HandleFunc("POST /api/sync/start", s.signedIn(s.handleSyncStart))
+	mux.HandleFunc("GET /api/sync/status", s.signedIn(s.handleSyncStatus))
+	mux.HandleFunc("POST /api/sync/cancel", s.signedIn(s.handleSyncCancel))
+	mux.HandleFunc("GET /api/sync/skipped", s.signedIn(s.handleSkippedActivities))
+	mux.HandleFunc("DELETE /api/sync/skipped/{id}", s.signedIn(s.handleSkippedActivityRetry))
+}
+
+// scopedHandler serves a request of a signed-in athlete
+type scopedHandler func(w http.ResponseWriter, r *http.Request, scope athleteScope)
+
+// activityHandler serves a request about one of the signed-in athlete's activities
+type activityHandler func(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64)
+
+// segmentHandler serves a request about one of the signed-in athlete's favorite segments
+type segmentHandler func(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment)
+
+// signedIn answers 401 unless the request carries a web login, then calls h
+func (s *server) signedIn(h scopedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := s.webScopeFromRequest(w, r)
+		if !ok {
+			return
+		}
+		h(w, r, scope)
+	}
+}
+
+// readable is signedIn for read-only endpoints: without a login, h gets the anonymous
+// view of the public athlete when one is configured
+func (s *server) readable(h scopedHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := s.webReadScopeFromRequest(w, r)
+		if !ok {
+			return
+		}
+		h(w, r, scope)
+	}
+}
+
+// activityRoute parses the {id} of an activity path, answering 400 when it is not an ID,
+// and calls h for the signed-in athlete. Activities of other athletes are left to h,
+// whose queries are scoped to the athlete.
+func (s *server) activityRoute(h activityHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		activityID, ok := pathID(w, r, "id", "invalid id")
+		if !ok {
+			return
+		}
+		s.signedIn(func(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+			h(w, r, scope, activityID)
+		})(w, r)
+	}
+}
+
+// activityReadRoute is activityRoute for read-only endpoints; anonymous visitors only
+// reach the public athlete's instance-visible activities
+func (s *server) activityReadRoute(h activityHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		activityID, ok := pathID(w, r, "id", "invalid id")
+		if !ok {
+			return
+		}
+		s.readable(func(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+			if err := s.checkActivityVisible(scope, activityID); err != nil {
+				s.handleDBPageError(w, r, err, http.StatusNotFound)
+				return
+			}
+			h(w, r, scope, activityID)
+		})(w, r)
+	}
+}
+
+// athleteActivity adapts handlers that only need the athlete's ID
+func athleteActivity(h func(w http.ResponseWriter, r *http.Request, athleteID, activityID int64)) activityHandler {
+	return func(w http.ResponseWriter, r *http.Request, scope athleteScope, activityID int64) {
+		h(w, r, scope.AthleteID, activityID)
+	}
+}
+
+// segmentRoute parses the {id} of a segment path and loads the segment, answering 404
+// when the athlete has no such segment, then calls h
+func (s *server) segmentRoute(h segmentHandler) http.HandlerFunc {
+	return s.ownedSegmentRoute(s.webScopeFromRequest, h)
+}
+
+// segmentReadRoute is segmentRoute for read-only endpoints, see readable
+func (s *server) segmentReadRoute(h segmentHandler) http.HandlerFunc {
+	return s.ownedSegmentRoute(s.webReadScopeFromRequest, h)
+}
+
+func (s *server) ownedSegmentRoute(resolve func(w http.ResponseWriter, r *http.Request) (athleteScope, bool), h segmentHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		segmentID, ok := pathID(w, r, "id", "invalid segment ID")
+		if !ok {
+			return
+		}
+		scope, ok := resolve(w, r)
+		if !ok {
+			return
+		}
+		segment, err := s.getOwnedFavoriteSegment(scope.AthleteID, segmentID)
+		if err != nil {
+			slog.Error("Failed to load segment", "segment_id", segmentID, "error", err)
+			s.handleDBPageError(w, r, err, http.StatusNotFound)
+			return
+		}
+		h(w, r, scope, segment)
+	}
+}
+
+// segmentEffortRoute serves the effort indices or metrics of {activityID} on segment {id}
+func (s *server) segmentEffortRoute(indices bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		activityID, ok := pathID(w, r, "activityID", "invalid activity ID")
+		if !ok {
+			return
+		}
+		s.segmentReadRoute(func(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+			if err := s.checkActivityVisible(scope, activityID); err != nil {
+				s.handleDBPageError(w, r, err, http.StatusNotFound)
+				return
+			}
+			s.handleSegmentEffort(w, r, scope, segment, activityID, indices)
+		})(w, r)
+	}
+}
+
+// pathID parses the path wildcard name as an ID, answering 400 with message otherwise
+func pathID(w http.ResponseWriter, r *http.Request, name, message string) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, message))
+		return 0, false
+	}
+	return id, true
+}
+
+// recoverPanics answers a handler panic with a logged 500 instead of a dropped
+// connection
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.Error("Panic serving request", "method", r.Method, "path", safeLogText(r.URL.Path), "panic", p, "stack", string(debug.Stack()))
+			writeError(w, r, newAPIError(http.StatusInternalServerError, "Internal server error"))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,256 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// TestRoutesRejectBeforeTouchingTheDatabase covers the answers the router and its
+// middleware give on their own: unknown paths, wrong methods, IDs that are not numbers
+// and requests without a login. None of them reach a handler that needs the database.
+func TestRoutesRejectBeforeTouchingTheDatabase(t *testing.T) {
+	s := newSyncJobTestServer()
+	h := s.routes()
+
+	tests := []struct {
+		method string
+		path   string
+		login  bool
+		want   int
+	}{
+		// Malformed paths
+		{http.MethodGet, "/api/activities/5/", true, http.StatusNotFound},
+		{http.MethodGet, "/api/activities/5/unknown", true, http.StatusNotFound},
+		{http.MethodGet, "/api/segments/5/activity/7", true, http.StatusNotFound},
+		{http.MethodGet, "/api/segments/5/activity", true, http.StatusNotFound},
+		{http.MethodGet, "/api/segments/5/activity/7/unknown", true, http.StatusNotFound},
+		{http.MethodGet, "/api/sync/unknown", true, http.StatusNotFound},
+		{http.MethodGet, "/activity/", true, http.StatusNotFound},
+		{http.MethodGet, "/no/such/page", true, http.StatusNotFound},
+
+		// IDs that are not numbers
+		{http.MethodGet, "/activity/abc", true, http.StatusBadRequest},
+		{http.MethodGet, "/segment/abc", true, http.StatusBadRequest},
+		{http.MethodGet, "/api/activities/abc/graph", true, http.StatusBadRequest},
+		{http.MethodPatch, "/api/activities/abc", false, http.StatusBadRequest},
+		{http.MethodGet, "/api/segments/abc", true, http.StatusBadRequest},
+		{http.MethodGet, "/api/segments/5/activity/abc/indices", true, http.StatusBadRequest},
+		{http.MethodGet, "/api/segments/abc/activity/7/metrics", true, http.StatusBadRequest},
+		{http.MethodDelete, "/api/sync/skipped/abc", true, http.StatusBadRequest},
+
+		// Wrong methods
+		{http.MethodPost, "/api/activities", true, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/activities/5/pin", true, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/activities/5/graph", true, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/segments", true, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/segments/5", true, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/segments/5/activity/7/indices", true, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/sync/start", true, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/sync/status", true, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/sync/skipped", true, http.StatusMethodNotAllowed},
+
+		// No login
+		{http.MethodGet, "/api/activities/5/graph", false, http.StatusUnauthorized},
+		{http.MethodDelete, "/api/activities/5", false, http.StatusUnauthorized},
+		{http.MethodGet, "/api/segments", false, http.StatusUnauthorized},
+		{http.MethodGet, "/api/segments/5", false, http.StatusUnauthorized},
+		{http.MethodGet, "/api/segments/5/activity/7/indices", false, http.StatusUnauthorized},
+		{http.MethodGet, "/api/sync/status", false, http.StatusUnauthorized},
+		{http.MethodPost, "/api/sync/cancel", false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.login {
+				req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoutesServeTheSignedInAthlete(t *testing.T) {
+	s := newSyncJobTestServer()
+	req := httptest.NewRequest(http.MethodGet, "/api/sync/status", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRecoverPanicsAnswers500(t *testing.T) {
+	h := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var parts []string
+		_ = parts[3]
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/segments/5", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+
+	aborted := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler passed on", p)
+		}
+	}()
+	aborted.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// newPublicAthleteTestServer shows athlete 7 to visitors without a login
+func newPublicAthleteTestServer() *server {
+	s := newSyncJobTestServer()
+	s.cfg.PublicAthleteID = 7
+	s.loadAthlete = func(athleteID int64) (*pggeo.StoredAthlete, error) {
+		return &pggeo.StoredAthlete{Athlete: strava.Athlete{ID: athleteID, FirstName: "Ada"}}, nil
+	}
+	return s
+}
+
+// anonymousRoutes are the patterns visitors may use without a login: the pages and
+// read-only endpoints of the public athlete, the login flows, health checks, public
+// stats links, static files and the endpoints with their own credentials
+var anonymousRoutes = map[string]bool{
+	"/{$}":                              true,
+	"/healthz":                          true,
+	"/readyz":                           true,
+	"/strava/":                          true,
+	"/strava/login":                     true,
+	"/strava/callback":                  true,
+	"/strava/logout":                    true,
+	"/strava/webhook":                   true,
+	"/api/mobile/auth/start":            true,
+	"/api/mobile/auth/exchange":         true,
+	"/api/mobile/auth/callback":         true,
+	"/api/mobile/auth/session":          true,
+	"GET /activity/{id}":                true,
+	"GET /api/activities":               true,
+	"GET /api/activities/{id}/graph":    true,
+	"GET /api/activities/{id}/points":   true,
+	"/segments":                         true,
+	"GET /segment/{id}":                 true,
+	"GET /api/segments":                 true,
+	"GET /api/segments/{id}":            true,
+	"GET /api/segments/{id}/graph":      true,
+	"GET /api/segments/{id}/metrics":    true,
+	"GET /api/segments/{id}/activities": true,
+	"GET /api/segments/{id}/activity/{activityID}/indices": true,
+	"GET /api/segments/{id}/activity/{activityID}/metrics": true,
+	"/api/announcements": true,
+	"/public/stats/":     true,
+	"/static/":           true,
+	"GET /metrics":       true,
+}
+
+// sweepPaths are requests under prefix patterns that reach their handler's protected
+// branch; other prefixes get an ID appended
+var sweepPaths = map[string]string{
+	"/api/announcements/":       "/api/announcements/5/dismiss",
+	"/api/admin/announcements/": "/api/admin/announcements/5/expire",
+	"/api/discovered/":          "/api/discovered/status",
+	"/api/mobile/discovered/":   "/api/mobile/discovered/status",
+}
+
+var sweepMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// sweepRequest returns the methods and the path a sweep sends to pattern
+func sweepRequest(pattern string) ([]string, string) {
+	methods := sweepMethods
+	path := pattern
+	if method, rest, ok := strings.Cut(pattern, " "); ok {
+		methods, path = []string{method}, rest
+	}
+	if sample, ok := sweepPaths[path]; ok {
+		return methods, sample
+	}
+	path = strings.NewReplacer("{id}", "5", "{activityID}", "7", "{tag}", "commute").Replace(path)
+	if strings.HasSuffix(path, "/") {
+		path += "5"
+	}
+	return methods, path
+}
+
+// TestAnonymousVisitorsCannotMutate sweeps every pattern registered in routes apart from
+// anonymousRoutes: with a public athlete configured, a visitor without a login must be
+// answered 401 or 403 before any handler touches data. Patterns without a method are
+// swept with every method; one only counts as turned away with another status when a
+// signed-in athlete gets the same 405, so the route does not serve that method at all.
+func TestAnonymousVisitorsCannotMutate(t *testing.T) {
+	s := newPublicAthleteTestServer()
+	s.cfg.MetricsEnabled = true
+	s.cfg.StravaWebhookVerifyToken = "verify"
+	s.cfg.DiscoveredMapEnabled = true
+	h := s.routes()
+	patterns := s.routeMux().patterns
+
+	for pattern := range anonymousRoutes {
+		if !slices.Contains(patterns, pattern) {
+			t.Errorf("anonymous route %q is not registered", pattern)
+		}
+	}
+	for _, pattern := range patterns {
+		if anonymousRoutes[pattern] {
+			continue
+		}
+		methods, path := sweepRequest(pattern)
+		for _, method := range methods {
+			t.Run(method+" "+pattern, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+				if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+					return
+				}
+				if rec.Code == http.StatusMethodNotAllowed && !strings.Contains(pattern, " ") {
+					req := httptest.NewRequest(method, path, nil)
+					req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+					signedIn := httptest.NewRecorder()
+					h.ServeHTTP(signedIn, req)
+					if signedIn.Code == http.StatusMethodNotAllowed {
+						return
+					}
+				}
+				t.Fatalf("%s %s = %d, want 401 or 403 (%s)", method, path, rec.Code, rec.Body.String())
+			})
+		}
+	}
+}
+
+func TestReadScopeShowsThePublicAthleteAnonymously(t *testing.T) {
+	s := newPublicAthleteTestServer()
+
+	scope := s.webReadSessionFromRequest(httptest.NewRequest(http.MethodGet, "/strava/", nil))
+	if !scope.Anonymous || scope.AthleteID != 7 || scope.Athlete == nil || scope.Athlete.FirstName != "Ada" {
+		t.Fatalf("anonymous scope = %+v, want the public athlete", scope)
+	}
+	if scope.viewerID() != 0 || scope.StravaToken != "" {
+		t.Fatalf("anonymous scope views as %d with token %q, want nobody", scope.viewerID(), scope.StravaToken)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/strava/", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	if scope := s.webReadSessionFromRequest(req); scope.Anonymous || scope.AthleteID != 1 || scope.viewerID() != 1 {
+		t.Fatalf("signed-in scope = %+v, want athlete 1 as themselves", scope)
+	}
+
+	s.cfg.PublicAthleteID = 0
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/segments", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status without a public athlete = %d, want 401", rec.Code)
+	}
+}
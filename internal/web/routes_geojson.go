@@ -0,0 +1,114 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// routesGeoJSONMaxUnbounded is how many activities an athlete may have before
+	// GET /api/activities/geojson requires a bbox
+	routesGeoJSONMaxUnbounded = 300
+	// routesGeoJSONMaxSimplifyM caps the simplify parameter
+	routesGeoJSONMaxSimplifyM = 1000.0
+)
+
+var errRoutesBBoxRequired = fmt.Errorf("bbox is required for more than %d activities", routesGeoJSONMaxUnbounded)
+
+// polylineFormatParam reads ?format= on route endpoints: "polyline" for polyline5
+// strings instead of coordinate arrays, "geojson" or nothing for the default
+func polylineFormatParam(r *http.Request) (bool, error) {
+	switch strings.TrimSpace(r.URL.Query().Get("format")) {
+	case "", "geojson":
+		return false, nil
+	case "polyline":
+		return true, nil
+	default:
+		return false, fmt.Errorf("format must be geojson or polyline")
+	}
+}
+
+// routesGeoJSONParams parses the optional bbox and simplify parameters. simplify is a
+// tolerance in meters, "stored" for the routes simplified when they were stored (the
+// default) or "full" for the routes as recorded.
+func routesGeoJSONParams(r *http.Request) (*pggeo.BBox, float64, error) {
+	var bbox *pggeo.BBox
+	if raw := strings.TrimSpace(r.URL.Query().Get("bbox")); raw != "" {
+		minLng, minLat, maxLng, maxLat, ok := parseBBox(raw)
+		if !ok {
+			return nil, 0, fmt.Errorf("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		bbox = &pggeo.BBox{MinLng: minLng, MinLat: minLat, MaxLng: maxLng, MaxLat: maxLat}
+	}
+
+	simplifyMeters := pggeo.SimplifyStored
+	switch raw := strings.TrimSpace(r.URL.Query().Get("simplify")); raw {
+	case "", "stored":
+	case "full":
+		simplifyMeters = pggeo.SimplifyFull
+	default:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 || value > routesGeoJSONMaxSimplifyM {
+			return nil, 0, fmt.Errorf("simplify must be full, stored or between 0 and %.0f meters", routesGeoJSONMaxSimplifyM)
+		}
+		simplifyMeters = value
+	}
+	return bbox, simplifyMeters, nil
+}
+
+// handleRoutesGeoJSON handles GET /api/activities/geojson?bbox=minLng,minLat,maxLng,maxLat&simplify=meters|full|stored,
+// every route of the athlete in the viewport as one FeatureCollection for a heatmap layer,
+// streamed feature by feature. ?format=polyline sends each route as a polyline5 string.
+func (s *server) handleRoutesGeoJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	bbox, simplifyMeters, err := routesGeoJSONParams(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	polyline, err := polylineFormatParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	stream := newJSONArrayStream(w, r, `{"type":"FeatureCollection","features":`, "}")
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		if bbox == nil {
+			count, err := pggeo.CountActivities(s.ctx, conn, scope.AthleteID, pggeo.ActivityFilter{})
+			if err != nil {
+				return err
+			}
+			if count > routesGeoJSONMaxUnbounded {
+				return errRoutesBBoxRequired
+			}
+		}
+		_, dbErr := pggeo.StreamRoutesGeoJSON(s.ctx, conn, scope.AthleteID, bbox, simplifyMeters, polyline, stream.writeRaw)
+		return stream.abort(dbErr)
+	})
+	if errors.Is(err, errRoutesBBoxRequired) {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to load routes GeoJSON", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	stream.close()
+}
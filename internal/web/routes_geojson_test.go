@@ -0,0 +1,50 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func TestRoutesGeoJSONParams(t *testing.T) {
+	bbox, simplify, err := routesGeoJSONParams(httptest.NewRequest("GET", "/api/activities/geojson", nil))
+	if err != nil || bbox != nil || simplify != 0 {
+		t.Fatalf("no params = %v, %v, %v; want no bbox and no simplification", bbox, simplify, err)
+	}
+
+	bbox, simplify, err = routesGeoJSONParams(httptest.NewRequest("GET", "/api/activities/geojson?bbox=4.8,52.3,5.0,52.4&simplify=25", nil))
+	if err != nil || bbox == nil || bbox.MinLng != 4.8 || bbox.MaxLat != 52.4 || simplify != 25 {
+		t.Fatalf("params = %+v, %v, %v", bbox, simplify, err)
+	}
+
+	for query, want := range map[string]float64{"simplify=full": pggeo.SimplifyFull, "simplify=stored": pggeo.SimplifyStored, "simplify=0": 0} {
+		if _, simplify, err := routesGeoJSONParams(httptest.NewRequest("GET", "/api/activities/geojson?"+query, nil)); err != nil || simplify != want {
+			t.Errorf("%s = %v, %v; want %v", query, simplify, err, want)
+		}
+	}
+
+	for _, query := range []string{
+		"bbox=5.0,52.3,4.8,52.4",
+		"bbox=4.8,52.3",
+		"simplify=-1",
+		"simplify=5000",
+		"simplify=abc",
+		"simplify=raw",
+	} {
+		if _, _, err := routesGeoJSONParams(httptest.NewRequest("GET", "/api/activities/geojson?"+query, nil)); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+}
+
+func TestPolylineFormatParam(t *testing.T) {
+	for query, want := range map[string]bool{"": false, "?format=geojson": false, "?format=polyline": true} {
+		if got, err := polylineFormatParam(httptest.NewRequest("GET", "/api/activities/geojson"+query, nil)); err != nil || got != want {
+			t.Fatalf("%q = %v, %v; want %v", query, got, err, want)
+		}
+	}
+	if _, err := polylineFormatParam(httptest.NewRequest("GET", "/api/activities/geojson?format=wkt", nil)); err == nil {
+		t.Fatal("format=wkt was accepted")
+	}
+}
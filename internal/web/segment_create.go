@@ -0,0 +1,83 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"b11k/internal/pggeo"
+)
+
+// segmentCreateRequest is the body of POST /api/segments. A segment is cut either from an
+// activity (activity_id with start_index/end_index) or from drawn points given as
+// [[lat,lng], ...]; drawn segments have no elevation data.
+type segmentCreateRequest struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	ActivityID  int64       `json:"activity_id"`
+	StartIndex  int         `json:"start_index"`
+	EndIndex    int         `json:"end_index"`
+	Points      [][]float64 `json:"points"`
+
+	DefaultToleranceM *float64 `json:"default_tolerance_m"`
+}
+
+// handleSegmentCreate handles POST /api/segments
+func (s *server) handleSegmentCreate(w http.ResponseWriter, r *http.Request, athleteID int64) {
+	var req segmentCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
+		return
+	}
+	if req.DefaultToleranceM != nil && !pggeo.ValidToleranceMeters(*req.DefaultToleranceM) {
+		writeError(w, r, newAPIError(http.StatusBadRequest, fmt.Sprintf("default_tolerance_m must be between 0 and %.0f meters", pggeo.MaxSegmentToleranceM)))
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "name is required"))
+		return
+	}
+
+	var segment *pggeo.FavoriteSegment
+	var err error
+	if req.Points != nil {
+		if req.ActivityID != 0 {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "send either points or activity_id, not both"))
+			return
+		}
+		latLngData, _, validationErr := copyLatLngPairs(req.Points, false)
+		if validationErr != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, validationErr.Error()))
+			return
+		}
+		segment, err = s.createFavoriteSegmentFromPoints(athleteID, req.Name, req.Description, latLngData, req.DefaultToleranceM)
+	} else {
+		if req.StartIndex < 0 || req.EndIndex < 0 || req.StartIndex >= req.EndIndex {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid start_index or end_index"))
+			return
+		}
+		segment, err = s.createFavoriteSegmentFromActivityRange(athleteID, req.ActivityID, req.Name, req.Description, req.StartIndex, req.EndIndex, req.DefaultToleranceM)
+	}
+	if err != nil {
+		if errors.Is(err, errSegmentIndexOutOfRange) {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "index out of range"))
+			return
+		}
+		if errors.Is(err, errActivitySamplesMissing) {
+			s.handleDBPageError(w, r, err, http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, pggeo.ErrSegmentNameExists) {
+			writeError(w, r, newAPIError(http.StatusConflict, segmentNameExistsMessage))
+			return
+		}
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, newSegmentResponse(segment))
+}
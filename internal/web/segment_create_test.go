@@ -0,0 +1,32 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSegmentCreateRejectsMalformedPointsBeforeTouchingTheDatabase(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	cases := map[string]string{
+		"single point":       `{"name":"Wall","points":[[46.5,6.6]]}`,
+		"no points":          `{"name":"Wall","points":[]}`,
+		"short pair":         `{"name":"Wall","points":[[46.5,6.6],[46.6]]}`,
+		"latitude too large": `{"name":"Wall","points":[[46.5,6.6],[91,6.7]]}`,
+		"longitude too low":  `{"name":"Wall","points":[[46.5,6.6],[46.6,-180.5]]}`,
+		"NaN":                `{"name":"Wall","points":[[46.5,6.6],[NaN,6.7]]}`,
+		"not numbers":        `{"name":"Wall","points":[[46.5,6.6],["46.6","6.7"]]}`,
+		"with activity":      `{"name":"Wall","activity_id":7,"points":[[46.5,6.6],[46.6,6.7]]}`,
+		"missing name":       `{"name":"  ","points":[[46.5,6.6],[46.6,6.7]]}`,
+	}
+	for name, body := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/segments", strings.NewReader(body))
+		s.handleSegmentCreate(rec, req, 1)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"b11k/internal/pggeo"
+)
+
+// effortNumberParam returns the effort query parameter: which traversal of the segment within
+// the activity a request is about. It defaults to the first.
+func effortNumberParam(r *http.Request) (int, error) {
+	return parseEffortNumber(r.URL.Query().Get("effort"))
+}
+
+func parseEffortNumber(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 1, nil
+	}
+	effort, err := strconv.Atoi(value)
+	if err != nil || effort < 1 {
+		return 0, fmt.Errorf("effort must be a positive integer")
+	}
+	return effort, nil
+}
+
+// segmentEffortFilterParams reads ?direction=forward|reverse and ?min_overlap=0-100 for
+// segment effort lists; without them efforts in both directions are listed
+func segmentEffortFilterParams(r *http.Request) (pggeo.SegmentEffortFilter, error) {
+	var filter pggeo.SegmentEffortFilter
+	query := r.URL.Query()
+	if direction := strings.TrimSpace(query.Get("direction")); direction != "" && direction != "both" {
+		if !pggeo.ValidSegmentDirection(direction) {
+			return filter, fmt.Errorf("direction must be forward, reverse or both")
+		}
+		filter.Direction = direction
+	}
+	if value := strings.TrimSpace(query.Get("min_overlap")); value != "" {
+		overlap, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(overlap) || overlap < 0 || overlap > 100 {
+			return filter, fmt.Errorf("min_overlap must be a percentage from 0 to 100")
+		}
+		filter.MinOverlapPercentage = overlap
+	}
+	return filter, nil
+}
+
+// parseEffortRef parses "activityID" or "activityID:effort" as used by effort lists
+func parseEffortRef(value string) (int64, int, error) {
+	idPart, effortPart, _ := strings.Cut(value, ":")
+	activityID, err := strconv.ParseInt(strings.TrimSpace(idPart), 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid activity ID %q", value)
+	}
+	effort, err := parseEffortNumber(effortPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid effort in %q", value)
+	}
+	return activityID, effort, nil
+}
+
+func valueOrZero(value *float64) float64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
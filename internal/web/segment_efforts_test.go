@@ -0,0 +1,53 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func TestParseEffortRef(t *testing.T) {
+	cases := []struct {
+		value      string
+		activityID int64
+		effort     int
+	}{
+		{"12", 12, 1},
+		{"12:3", 12, 3},
+		{" 12 : 2 ", 12, 2},
+		{"12:", 12, 1},
+	}
+	for _, tc := range cases {
+		activityID, effort, err := parseEffortRef(tc.value)
+		if err != nil || activityID != tc.activityID || effort != tc.effort {
+			t.Fatalf("parseEffortRef(%q) = %d, %d, %v; want %d, %d", tc.value, activityID, effort, err, tc.activityID, tc.effort)
+		}
+	}
+	for _, value := range []string{"", "x", "12:0", "12:-1", "12:x", ":2"} {
+		if _, _, err := parseEffortRef(value); err == nil {
+			t.Fatalf("parseEffortRef(%q): expected error", value)
+		}
+	}
+}
+
+func TestSegmentEffortFilterParams(t *testing.T) {
+	cases := map[string]pggeo.SegmentEffortFilter{
+		"":                                  {},
+		"?direction=both":                   {},
+		"?direction=forward":                {Direction: pggeo.SegmentDirectionForward},
+		"?direction=reverse&min_overlap=80": {Direction: pggeo.SegmentDirectionReverse, MinOverlapPercentage: 80},
+		"?min_overlap=0":                    {},
+	}
+	for query, want := range cases {
+		got, err := segmentEffortFilterParams(httptest.NewRequest("GET", "/api/segments/1/activities"+query, nil))
+		if err != nil || got != want {
+			t.Fatalf("segmentEffortFilterParams(%q) = %+v, %v; want %+v", query, got, err, want)
+		}
+	}
+	for _, query := range []string{"?direction=backwards", "?min_overlap=101", "?min_overlap=-1", "?min_overlap=NaN", "?min_overlap=x"} {
+		if _, err := segmentEffortFilterParams(httptest.NewRequest("GET", "/api/segments/1/activities"+query, nil)); err == nil {
+			t.Fatalf("segmentEffortFilterParams(%q): expected error", query)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/trackimport"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// gpxAsTrackParam reads ?as=route|track; routes are the default since head units
+// navigate them turn by turn
+func gpxAsTrackParam(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("as") {
+	case "", "route":
+		return false, nil
+	case "track":
+		return true, nil
+	default:
+		return false, fmt.Errorf("as must be route or track")
+	}
+}
+
+// segmentRouteTrack converts a segment route for the GPX writer
+func segmentRouteTrack(route pggeo.SegmentRoute) trackimport.Track {
+	track := trackimport.Track{Name: route.Name, Points: make([]trackimport.Point, len(route.Points))}
+	for i, point := range route.Points {
+		track.Points[i] = trackimport.Point{Lat: point.Lat, Lng: point.Lng, Altitude: point.Altitude}
+	}
+	return track
+}
+
+// gpxFilename turns a segment name into a safe attachment name
+func gpxFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)), r == '-', r == '_':
+			return r
+		case unicode.IsSpace(r), r == '.':
+			return '-'
+		}
+		return -1
+	}, name)
+	name = strings.Trim(name, "-")
+	if name == "" {
+		name = "segment"
+	}
+	return name + ".gpx"
+}
+
+// writeGPX renders the routes and sends them as an attachment; the file is built in
+// memory first so a failure still answers with an error status
+func writeGPX(w http.ResponseWriter, r *http.Request, filename string, routes []pggeo.SegmentRoute, asTrack bool) {
+	tracks := make([]trackimport.Track, len(routes))
+	for i, route := range routes {
+		tracks[i] = segmentRouteTrack(route)
+	}
+	var buf bytes.Buffer
+	if err := trackimport.WriteGPX(&buf, tracks, trackimport.GPXOptions{AsTrack: asTrack}); err != nil {
+		slog.Error("Failed to write GPX", "filename", filename, "error", err)
+		writeError(w, r, newAPIError(http.StatusInternalServerError, "Failed to write GPX"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// handleSegmentGPX handles GET /api/segments/:id/gpx?as=route|track
+func (s *server) handleSegmentGPX(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	asTrack, err := gpxAsTrackParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	var route *pggeo.SegmentRoute
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		route, dbErr = pggeo.GetSegmentRoute(s.ctx, conn, scope.AthleteID, segment.ID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to load segment route", "segment_id", segment.ID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeGPX(w, r, gpxFilename(route.Name), []pggeo.SegmentRoute{*route}, asTrack)
+}
+
+// handleSegmentsGPXExport handles GET /api/segments/export.gpx?as=route|track: every
+// segment of the athlete as one route (or track) each, in one file
+func (s *server) handleSegmentsGPXExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	asTrack, err := gpxAsTrackParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	var routes []pggeo.SegmentRoute
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		routes, dbErr = pggeo.GetSegmentRoutes(s.ctx, conn, scope.AthleteID, nil)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to load segment routes", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeGPX(w, r, "segments.gpx", routes, asTrack)
+}
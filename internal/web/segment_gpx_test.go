@@ -0,0 +1,47 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestGPXFilenameKeepsSafeCharacters(t *testing.T) {
+	for name, want := range map[string]string{
+		"Col du Galibier":      "Col-du-Galibier.gpx",
+		`../"evil"\name`:       "evilname.gpx",
+		"Étape 2.0":            "tape-2-0.gpx",
+		"  ":                   "segment.gpx",
+		"climb_1-north (easy)": "climb_1-north-easy.gpx",
+	} {
+		if got := gpxFilename(name); got != want {
+			t.Fatalf("gpxFilename(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSegmentsGPXExportValidatesRequest(t *testing.T) {
+	s := newWebhookTestServer()
+	s.cacheWebAthlete("token", &strava.Athlete{ID: 7})
+	h := s.routes()
+	for _, tc := range []struct {
+		method, target, token string
+		want                  int
+	}{
+		{http.MethodGet, "/api/segments/export.gpx", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/segments/export.gpx", "token", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/segments/export.gpx?as=waypoints", "token", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.token != "" {
+			req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: tc.token})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s %s = %d, want %d", tc.method, tc.target, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleSegmentsList handles GET /api/segments
+func (s *server) handleSegmentsList(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	segments, err := s.listFavoriteSegments(scope.AthleteID)
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if pinnedFirst(r) {
+		pggeo.SortSegmentsPinnedFirst(segments)
+	}
+	writeJSONCompact(w, r, segments)
+}
+
+// handleSegmentGet handles GET /api/segments/{id}
+func (s *server) handleSegmentGet(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	writeJSON(w, struct {
+		segmentResponse
+		EffectiveTolerance segmentTolerance `json:"effective_tolerance"`
+	}{newSegmentResponse(segment), s.segmentTolerance(r, scope.AthleteID, segment)})
+}
+
+// handleSegmentDelete handles DELETE /api/segments/{id}
+func (s *server) handleSegmentDelete(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.DeleteFavoriteSegment(s.ctx, conn, scope.AthleteID, segment.ID)
+	})
+	if err != nil {
+		slog.Error("Failed to delete segment", "segment_id", segment.ID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSegmentGraph handles GET /api/segments/{id}/graph?activity_id=: the graph series
+// of one traversal, picked with ?effort=N when the activity rides the segment more than once
+func (s *server) handleSegmentGraph(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	activityIDStr := r.URL.Query().Get("activity_id")
+	if activityIDStr == "" {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "activity_id parameter required"))
+		return
+	}
+	activityID, err := strconv.ParseInt(activityIDStr, 10, 64)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid activity_id"))
+		return
+	}
+	if err := s.checkActivityVisible(scope, activityID); err != nil {
+		s.handleDBPageError(w, r, err, http.StatusNotFound)
+		return
+	}
+	effortNumber, err := effortNumberParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	metrics, err := graphMetricsParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	smooth, err := graphSmoothParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	maxPoints, err := graphMaxPointsParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	includeZones := r.URL.Query().Get("include_zones") == "true"
+
+	var hrZones *strava.HeartRateZones
+	if includeZones {
+		hrZones, _ = s.athleteHRZones(r.Context(), scope)
+	}
+
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	var graphData *pggeo.GraphData
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		graphData, dbErr = pggeo.GetGraphDataForSegmentInActivity(s.ctx, conn, scope.AthleteID, activityID, segment.ID, effective.Meters, effortNumber, metrics, includeZones, hrZones)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to load segment graph data", "segment_id", segment.ID, "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	graphData.Watts = pggeo.SmoothGraphSeries(graphData.Watts, smooth)
+	graphData.Downsample(maxPoints)
+	writeJSONCompact(w, r, graphData)
+}
+
+// handleSegmentMetrics handles GET /api/segments/{id}/metrics: the segment's own
+// distance and elevation gain
+func (s *server) handleSegmentMetrics(w http.ResponseWriter, r *http.Request, _ athleteScope, segment *pggeo.FavoriteSegment) {
+	query := `SELECT * FROM get_segment_metrics($1)`
+	var distanceM, elevationGainM float64
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return conn.QueryRow(s.ctx, query, segment.ID).Scan(&distanceM, &elevationGainM)
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]float64{
+		"distance":       distanceM,
+		"elevation_gain": elevationGainM,
+	})
+}
+
+// handleSegmentEffort handles GET /api/segments/{id}/activity/{activityID}/indices and
+// .../metrics; ?effort=N picks the traversal when the activity rides the segment more
+// than once
+func (s *server) handleSegmentEffort(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment, activityID int64, indices bool) {
+	effortNumber, err := effortNumberParam(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	tolerance := effective.Meters
+
+	var effort *pggeo.SegmentActivityCacheEntry
+	var effortCount int
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		efforts, dbErr := pggeo.EnsureSegmentActivityEfforts(s.ctx, conn, scope.AthleteID, segment.ID, activityID, tolerance)
+		if dbErr != nil {
+			return dbErr
+		}
+		effortCount = len(efforts)
+		for i := range efforts {
+			if efforts[i].EffortNumber == effortNumber {
+				effort = &efforts[i]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if indices {
+		if effort == nil {
+			writeError(w, r, newAPIError(http.StatusNotFound, "segment effort not found"))
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"start_index":      *effort.StartIndex,
+			"end_index":        *effort.EndIndex,
+			"effort_number":    effort.EffortNumber,
+			"effort_count":     effortCount,
+			"direction":        effort.Direction,
+			"tolerance_m":      effective.Meters,
+			"tolerance_source": effective.Source,
+		})
+		return
+	}
+
+	// No traversal reports zeros, as the metrics panel expects. avg_speed is a
+	// deprecated alias of avg_speed_elapsed.
+	metrics := map[string]interface{}{
+		"avg_hr":            0.0,
+		"avg_speed":         0.0,
+		"avg_speed_elapsed": 0.0,
+		"distance":          0.0,
+		"elevation_gain":    0.0,
+		"elapsed_seconds":   0.0,
+		"effort_number":     effortNumber,
+		"effort_count":      effortCount,
+		"tolerance_m":       effective.Meters,
+		"tolerance_source":  effective.Source,
+	}
+	if effort != nil {
+		metrics["avg_hr"] = *effort.AvgHR
+		metrics["avg_speed"] = *effort.AvgSpeed
+		metrics["avg_speed_elapsed"] = *effort.AvgSpeed
+		metrics["distance"] = *effort.DistanceM
+		metrics["elevation_gain"] = *effort.ElevationGainM
+		metrics["elapsed_seconds"] = *effort.ElapsedSeconds
+	}
+	writeJSON(w, metrics)
+}
+
+// loadSegmentEfforts matches the athlete's activities against one of their segments,
+// from the cache unless forceRefresh
+func (s *server) loadSegmentEfforts(athleteID, segmentID int64, tolerance float64, sortBy string, forceRefresh bool, filter pggeo.SegmentEffortFilter) ([]pggeo.ActivityWithMatch, error) {
+	if s.segmentEfforts != nil {
+		return s.segmentEfforts(athleteID, segmentID, tolerance, sortBy, forceRefresh, filter)
+	}
+	var activities []pggeo.ActivityWithMatch
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		activities, dbErr = pggeo.GetActivitiesForSegment(s.ctx, conn, athleteID, segmentID, tolerance, sortBy, forceRefresh, s.cfg.SegmentCacheTTL, filter)
+		return dbErr
+	})
+	return activities, err
+}
+
+// handleSegmentActivities handles GET /api/segments/{id}/activities: the athlete's
+// efforts on the segment, sorted by ?sort= and narrowed by the effort filters
+func (s *server) handleSegmentActivities(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	segmentID := segment.ID
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	tolerance := effective.Meters
+	forceRefresh := r.URL.Query().Get("refresh") == "true"
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "distance" // default
+	}
+	filter, err := segmentEffortFilterParams(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	activities, err := s.loadSegmentEfforts(scope.AthleteID, segmentID, tolerance, sortBy, forceRefresh, filter)
+	if err != nil {
+		slog.Error("Failed to load activities for segment", "segment_id", segmentID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	activities = visibleSegmentEfforts(scope.viewerID(), activities)
+	for i := range activities {
+		setAvgSpeeds(&activities[i].ActivitySummary)
+	}
+	s.fillGradeAdjustedSpeeds(scope.AthleteID, segmentID, tolerance, activities, sortBy)
+	if scope.StravaToken != "" {
+		if zones, err := s.athleteHRZones(r.Context(), scope); err == nil && zones != nil {
+			for i := range activities {
+				activityID := activities[i].ID
+				zoneErr := s.withDB(func(conn *pgxpool.Pool) error {
+					var dbErr error
+					activities[i].SegmentHRZones, dbErr = pggeo.GetHRZoneDistributionForSegmentInActivity(s.ctx, conn, scope.AthleteID, activityID, segmentID, tolerance, activities[i].EffortNumber, zones)
+					return dbErr
+				})
+				if zoneErr != nil {
+					slog.Warn("Failed to calculate segment HR zones", "segment_id", segmentID, "activity_id", activityID, "error", zoneErr)
+				}
+			}
+		} else if err != nil {
+			slog.Warn("Failed to fetch HR zones for segment efforts", "segment_id", segmentID, "error", err)
+		}
+	}
+	setToleranceHeaders(w, effective)
+	writeJSONCompact(w, r, activities)
+}
+
+// handleSegmentsPage handles GET /segments - renders the segments list page
+func (s *server) handleSegmentsPage(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webReadScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	segments, err := s.listSegmentDashboardSummaries(scope.AthleteID, nil)
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if pinnedFirst(r) {
+		pggeo.SortSegmentSummariesPinnedFirst(segments)
+	}
+
+	data := struct {
+		Segments             []pggeo.SegmentDashboardSummary
+		Athlete              *strava.Athlete
+		ShowLoginCTA         bool
+		Anonymous            bool
+		Authorized           bool
+		DiscoveredMapEnabled bool
+		Announcements        []pggeo.Announcement
+	}{
+		Segments:             segments,
+		Athlete:              scope.Athlete,
+		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
+		Anonymous:            scope.Anonymous,
+		Authorized:           scope.StravaToken != "",
+		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
+		Announcements:        s.pageAnnouncements(r, scope),
+	}
+
+	if err := s.executeTemplate(w, "segments.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSegmentPage handles GET /segment/{id} - renders the segment detail page
+func (s *server) handleSegmentPage(w http.ResponseWriter, r *http.Request) {
+	segmentID, ok := pathID(w, r, "id", "invalid segment ID")
+	if !ok {
+		return
+	}
+
+	scope, ok := s.webReadScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	segment, err := s.getOwnedFavoriteSegment(scope.AthleteID, segmentID)
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	tolerance := s.segmentTolerance(r, scope.AthleteID, segment)
+	data := struct {
+		Segment              *pggeo.FavoriteSegment
+		Tolerance            segmentTolerance
+		Athlete              *strava.Athlete
+		ShowLoginCTA         bool
+		Anonymous            bool
+		Authorized           bool
+		MobileActivityOrder  string
+		DiscoveredMapEnabled bool
+		Announcements        []pggeo.Announcement
+		Timeline             *analysis.SegmentTimeline // elapsed-time chart data, nil when logged out
+	}{
+		Segment:              segment,
+		Tolerance:            tolerance,
+		Athlete:              scope.Athlete,
+		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
+		Anonymous:            scope.Anonymous,
+		Authorized:           scope.StravaToken != "",
+		MobileActivityOrder:  s.cfg.MobileActivityOrder,
+		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
+		Announcements:        s.pageAnnouncements(r, scope),
+	}
+	if data.Authorized {
+		timeline, err := s.loadSegmentTimeline(scope.AthleteID, segment, tolerance, analysis.TimelineMetricElapsed)
+		if err != nil {
+			slog.Warn("Failed to load segment timeline", "segment_id", segment.ID, "error", err)
+		} else {
+			data.Timeline = &timeline
+		}
+	}
+
+	if err := s.executeTemplate(w, "segment.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,148 @@
+package web
+
+import (
+	"log/slog"
+	"time"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// segmentRefreshPause is waited between segments of a post-import cache refresh so a
+	// bulk import does not keep the database busy for page requests
+	segmentRefreshPause = 200 * time.Millisecond
+
+	segmentRefreshRunning = "running"
+	segmentRefreshDone    = "done"
+	segmentRefreshFailed  = "failed"
+)
+
+// segmentRefreshJob warms one athlete's segment caches for activities added by syncs and
+// imports. Activities queued while it runs are refreshed in a further batch.
+type segmentRefreshJob struct {
+	state      string
+	startedAt  time.Time
+	finishedAt time.Time
+	pending    []int64
+	progress   pggeo.SegmentCacheRefresh
+	err        string
+}
+
+// segmentRefreshStatus is the JSON form of a job, reported by GET /api/sync/status
+type segmentRefreshStatus struct {
+	State            string                    `json:"state"` // running, done or failed
+	StartedAt        time.Time                 `json:"started_at"`
+	FinishedAt       *time.Time                `json:"finished_at,omitempty"`
+	QueuedActivities int                       `json:"queued_activities"`
+	Progress         pggeo.SegmentCacheRefresh `json:"progress"`
+	Error            string                    `json:"error,omitempty"`
+}
+
+// queueSegmentCacheRefresh refreshes the athlete's segment caches for newly added
+// activities in the background, unless LazySegmentCache is set. It never blocks.
+func (s *server) queueSegmentCacheRefresh(athleteID int64, activityIDs []int64) {
+	if s.cfg.LazySegmentCache || len(activityIDs) == 0 {
+		return
+	}
+	s.segmentRefreshMu.Lock()
+	defer s.segmentRefreshMu.Unlock()
+	if job := s.segmentRefreshes[athleteID]; job != nil && job.state == segmentRefreshRunning {
+		job.pending = append(job.pending, activityIDs...)
+		return
+	}
+	job := &segmentRefreshJob{
+		state:     segmentRefreshRunning,
+		startedAt: time.Now(),
+		pending:   append([]int64(nil), activityIDs...),
+	}
+	if s.segmentRefreshes == nil {
+		s.segmentRefreshes = make(map[int64]*segmentRefreshJob)
+	}
+	s.segmentRefreshes[athleteID] = job
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		s.runSegmentCacheRefresh(athleteID, job)
+	}()
+}
+
+// nextSegmentRefreshBatch takes the queued activities, or finishes the job when none are left
+func (s *server) nextSegmentRefreshBatch(job *segmentRefreshJob) []int64 {
+	s.segmentRefreshMu.Lock()
+	defer s.segmentRefreshMu.Unlock()
+	batch := job.pending
+	job.pending = nil
+	if len(batch) == 0 {
+		job.finishedAt = time.Now()
+		if job.err == "" {
+			job.state = segmentRefreshDone
+		} else {
+			job.state = segmentRefreshFailed
+		}
+	}
+	return batch
+}
+
+func (s *server) runSegmentCacheRefresh(athleteID int64, job *segmentRefreshJob) {
+	athleteDefault := s.athleteDefaultTolerance(athleteID)
+	for batch := s.nextSegmentRefreshBatch(job); len(batch) > 0; batch = s.nextSegmentRefreshBatch(job) {
+		s.digest.Record(digestSegmentRefresh, "refreshes", "Refreshing segment caches for new activities", "athlete_id", athleteID, "activities", len(batch))
+		started := time.Now()
+		var refresh pggeo.SegmentCacheRefresh
+		err := s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			refresh, dbErr = pggeo.RefreshSegmentCachesForActivities(s.jobContext(), conn, athleteID, batch, athleteDefault, segmentRefreshPause, func(progress pggeo.SegmentCacheRefresh) {
+				s.segmentRefreshMu.Lock()
+				job.progress = progress
+				s.segmentRefreshMu.Unlock()
+			})
+			return dbErr
+		})
+		stopped := s.jobContext().Err() != nil
+		s.segmentRefreshMu.Lock()
+		job.progress = refresh
+		if err != nil {
+			job.err = err.Error()
+		}
+		if stopped {
+			// Segments left over are matched on their next page visit
+			job.pending = nil
+		}
+		s.segmentRefreshMu.Unlock()
+		if stopped {
+			slog.Info("Segment cache refresh stopped by shutdown", "athlete_id", athleteID)
+			continue
+		}
+		if err != nil {
+			s.digest.Warn(digestSegmentRefresh, "failed", "Segment cache refresh failed", "athlete_id", athleteID, "error", err)
+			continue
+		}
+		s.digest.Add(digestSegmentRefresh, "segments", uint64(refresh.SegmentsDone))
+		s.digest.Debug("Refreshed segment caches", "athlete_id", athleteID, "segments", refresh.SegmentsDone,
+			"efforts", refresh.Efforts, "routes_simplified", refresh.Resimplified, "duration", time.Since(started).Round(time.Millisecond))
+	}
+}
+
+// segmentRefreshStatusFor returns the athlete's current or most recent refresh, or nil
+func (s *server) segmentRefreshStatusFor(athleteID int64) *segmentRefreshStatus {
+	s.segmentRefreshMu.Lock()
+	defer s.segmentRefreshMu.Unlock()
+	job := s.segmentRefreshes[athleteID]
+	if job == nil {
+		return nil
+	}
+	status := &segmentRefreshStatus{
+		State:            job.state,
+		StartedAt:        job.startedAt,
+		QueuedActivities: len(job.pending),
+		Progress:         job.progress,
+		Error:            job.err,
+	}
+	if !job.finishedAt.IsZero() {
+		finishedAt := job.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSegmentCacheRefreshSkippedWhenLazy(t *testing.T) {
+	s := newSyncJobTestServer()
+	s.cfg.LazySegmentCache = true
+	s.queueSegmentCacheRefresh(1, []int64{10, 11})
+	if status := s.segmentRefreshStatusFor(1); status != nil {
+		t.Fatalf("lazy server started refresh %+v", status)
+	}
+}
+
+func TestSegmentCacheRefreshQueuesBehindRunningJob(t *testing.T) {
+	s := newSyncJobTestServer()
+	job := &segmentRefreshJob{state: segmentRefreshRunning, startedAt: time.Now()}
+	s.segmentRefreshes = map[int64]*segmentRefreshJob{1: job}
+
+	s.queueSegmentCacheRefresh(1, []int64{10, 11})
+	s.queueSegmentCacheRefresh(1, nil)
+	s.queueSegmentCacheRefresh(1, []int64{12})
+	if s.segmentRefreshes[1] != job {
+		t.Fatal("a second job was started while one was running")
+	}
+	if batch := s.nextSegmentRefreshBatch(job); !reflect.DeepEqual(batch, []int64{10, 11, 12}) {
+		t.Fatalf("batch = %v, want every queued activity", batch)
+	}
+	if job.state != segmentRefreshRunning {
+		t.Fatalf("state = %q after taking a batch, want running", job.state)
+	}
+	if batch := s.nextSegmentRefreshBatch(job); batch != nil || job.state != segmentRefreshDone || job.finishedAt.IsZero() {
+		t.Fatalf("empty batch = %v, job = %+v; want a finished job", batch, job)
+	}
+
+	failed := &segmentRefreshJob{state: segmentRefreshRunning, err: "boom"}
+	if s.nextSegmentRefreshBatch(failed); failed.state != segmentRefreshFailed {
+		t.Fatalf("state = %q after an error, want failed", failed.state)
+	}
+}
+
+func TestSyncStatusReportsSegmentRefresh(t *testing.T) {
+	s := newSyncJobTestServer()
+	s.segmentRefreshes = map[int64]*segmentRefreshJob{1: {
+		state:     segmentRefreshRunning,
+		startedAt: time.Now(),
+		pending:   []int64{7, 8},
+	}}
+	s.segmentRefreshes[1].progress.SegmentsDone = 2
+	s.segmentRefreshes[1].progress.SegmentsTotal = 5
+
+	rec := syncAPIRequest(s, http.MethodGet, "/api/sync/status")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200", rec.Code)
+	}
+	var status syncJobStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	refresh := status.SegmentRefresh
+	if refresh == nil || refresh.State != segmentRefreshRunning || refresh.QueuedActivities != 2 ||
+		refresh.Progress.SegmentsDone != 2 || refresh.Progress.SegmentsTotal != 5 || refresh.FinishedAt != nil {
+		t.Fatalf("segment_refresh = %+v in %s", refresh, rec.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package web
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func parseTimelineMetric(r *http.Request) (string, error) {
+	metric := strings.TrimSpace(r.URL.Query().Get("metric"))
+	if metric == "" {
+		return analysis.TimelineMetricElapsed, nil
+	}
+	if !analysis.ValidTimelineMetric(metric) {
+		return "", fmt.Errorf("metric must be one of elapsed, speed, hr")
+	}
+	return metric, nil
+}
+
+// loadSegmentTimeline builds the segment's timeline in metric from the match cache at
+// the effective tolerance
+func (s *server) loadSegmentTimeline(athleteID int64, segment *pggeo.FavoriteSegment, tolerance segmentTolerance, metric string) (analysis.SegmentTimeline, error) {
+	var efforts []pggeo.SegmentTimelineEffort
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		efforts, dbErr = pggeo.GetSegmentTimeline(s.ctx, conn, athleteID, segment.ID, tolerance.Meters, pggeo.TimelineMinOverlapPercentage)
+		return dbErr
+	})
+	if err != nil {
+		return analysis.SegmentTimeline{}, err
+	}
+	return analysis.BuildSegmentTimeline(efforts, metric), nil
+}
+
+// handleSegmentTimeline handles GET /api/segments/:id/timeline?metric=elapsed|speed|hr
+func (s *server) handleSegmentTimeline(w http.ResponseWriter, r *http.Request, scope athleteScope, segment *pggeo.FavoriteSegment) {
+	metric, err := parseTimelineMetric(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	effective := s.segmentTolerance(r, scope.AthleteID, segment)
+	timeline, err := s.loadSegmentTimeline(scope.AthleteID, segment, effective, metric)
+	if err != nil {
+		slog.Error("Failed to load segment timeline", "segment_id", segment.ID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSONCompact(w, r, struct {
+		analysis.SegmentTimeline
+		segmentTolerance
+		MinOverlapPercentage float64 `json:"min_overlap_percentage"`
+	}{timeline, effective, pggeo.TimelineMinOverlapPercentage})
+}
@@ -0,0 +1,21 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTimelineMetric(t *testing.T) {
+	for query, want := range map[string]string{"": "elapsed", "metric=speed": "speed", "metric=hr": "hr", "metric=+elapsed": "elapsed"} {
+		got, err := parseTimelineMetric(httptest.NewRequest(http.MethodGet, "/api/segments/1/timeline?"+query, nil))
+		if err != nil || got != want {
+			t.Fatalf("%q: metric = %q, %v; want %q", query, got, err, want)
+		}
+	}
+	for _, query := range []string{"metric=watts", "metric=Elapsed"} {
+		if _, err := parseTimelineMetric(httptest.NewRequest(http.MethodGet, "/api/segments/1/timeline?"+query, nil)); err == nil {
+			t.Fatalf("%s: expected error", query)
+		}
+	}
+}
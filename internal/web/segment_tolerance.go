@@ -0,0 +1,196 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// segmentTolerance is the tolerance a segment endpoint actually matched with
+type segmentTolerance struct {
+	Meters float64 `json:"tolerance_m"`
+	Source string  `json:"tolerance_source"`
+}
+
+// optionalFloat distinguishes an absent JSON field from an explicit null
+type optionalFloat struct {
+	Set   bool
+	Value *float64
+}
+
+func (o *optionalFloat) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	o.Value = &v
+	return nil
+}
+
+// validate checks a requested default tolerance; clearing it is always allowed
+func (o optionalFloat) validate() error {
+	if o.Value != nil && !pggeo.ValidToleranceMeters(*o.Value) {
+		return fmt.Errorf("default_tolerance_m must be between 0 and %.0f meters", pggeo.MaxSegmentToleranceM)
+	}
+	return nil
+}
+
+// explicitTolerance returns the tolerance query parameter, or nil when absent or invalid
+func explicitTolerance(r *http.Request) *float64 {
+	value := strings.TrimSpace(r.URL.Query().Get("tolerance"))
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) || parsed <= 0 {
+		return nil
+	}
+	return &parsed
+}
+
+// resolveSegmentTolerance applies the precedence query parameter > segment default >
+// athlete setting > global default.
+func resolveSegmentTolerance(r *http.Request, segment *pggeo.FavoriteSegment, athleteDefault *float64) segmentTolerance {
+	var segmentDefault *float64
+	if segment != nil {
+		segmentDefault = segment.DefaultToleranceM
+	}
+	meters, source := pggeo.ResolveTolerance(explicitTolerance(r), segmentDefault, athleteDefault)
+	return segmentTolerance{Meters: meters, Source: source}
+}
+
+func (s *server) segmentTolerance(r *http.Request, athleteID int64, segment *pggeo.FavoriteSegment) segmentTolerance {
+	var athleteDefault *float64
+	if explicitTolerance(r) == nil && (segment == nil || segment.DefaultToleranceM == nil) {
+		athleteDefault = s.athleteDefaultTolerance(athleteID)
+	}
+	return resolveSegmentTolerance(r, segment, athleteDefault)
+}
+
+func (s *server) athleteDefaultTolerance(athleteID int64) *float64 {
+	var settings *pggeo.AthleteSettings
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		settings, dbErr = pggeo.GetAthleteSettings(s.ctx, conn, athleteID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Warn("Failed to load athlete settings", "athlete_id", athleteID, "error", err)
+		return nil
+	}
+	return settings.DefaultToleranceM
+}
+
+func setToleranceHeaders(w http.ResponseWriter, tolerance segmentTolerance) {
+	w.Header().Set("X-Tolerance-Meters", strconv.FormatFloat(tolerance.Meters, 'f', -1, 64))
+	w.Header().Set("X-Tolerance-Source", tolerance.Source)
+}
+
+// handleSegmentPatch handles PATCH /api/segments/:id with {"default_tolerance_m": number|null}
+func (s *server) handleSegmentPatch(w http.ResponseWriter, r *http.Request, athleteID, segmentID int64) {
+	var req struct {
+		DefaultToleranceM optionalFloat `json:"default_tolerance_m"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
+		return
+	}
+	if !req.DefaultToleranceM.Set {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "default_tolerance_m is required"))
+		return
+	}
+	if err := req.DefaultToleranceM.validate(); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	var segment *pggeo.FavoriteSegment
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		segment, dbErr = pggeo.SetSegmentDefaultTolerance(s.ctx, conn, athleteID, segmentID, req.DefaultToleranceM.Value)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to update segment", "segment_id", segmentID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, segment)
+}
+
+// handleMeSettings handles GET/PATCH /api/me/settings
+func (s *server) handleMeSettings(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var settings *pggeo.AthleteSettings
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			settings, dbErr = pggeo.GetAthleteSettings(s.ctx, conn, scope.AthleteID)
+			return dbErr
+		})
+	case http.MethodPatch:
+		var req struct {
+			DefaultToleranceM optionalFloat  `json:"default_tolerance_m"`
+			DisplayTimezone   optionalString `json:"display_timezone"`
+		}
+		if decodeErr := json.NewDecoder(r.Body).Decode(&req); decodeErr != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
+			return
+		}
+		if validateErr := req.DefaultToleranceM.validate(); validateErr != nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, validateErr.Error()))
+			return
+		}
+		if req.DisplayTimezone.Value != nil {
+			if _, zoneErr := pggeo.LoadDisplayZone(*req.DisplayTimezone.Value); zoneErr != nil {
+				writeError(w, r, newAPIError(http.StatusBadRequest, zoneErr.Error()))
+				return
+			}
+		}
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			if req.DefaultToleranceM.Set {
+				if settings, dbErr = pggeo.SetAthleteDefaultTolerance(s.ctx, conn, scope.AthleteID, req.DefaultToleranceM.Value); dbErr != nil {
+					return dbErr
+				}
+			}
+			if req.DisplayTimezone.Set {
+				if settings, dbErr = pggeo.SetAthleteDisplayTimezone(s.ctx, conn, scope.AthleteID, req.DisplayTimezone.Value); dbErr != nil {
+					return dbErr
+				}
+			}
+			if settings == nil {
+				settings, dbErr = pggeo.GetAthleteSettings(s.ctx, conn, scope.AthleteID)
+			}
+			return dbErr
+		})
+	default:
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to handle athlete settings", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, settings)
+}
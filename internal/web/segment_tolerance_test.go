@@ -0,0 +1,81 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestResolveSegmentTolerancePrecedence(t *testing.T) {
+	withDefault := &pggeo.FavoriteSegment{ID: 1, DefaultToleranceM: floatPtr(30)}
+	withoutDefault := &pggeo.FavoriteSegment{ID: 2}
+
+	tests := []struct {
+		name       string
+		url        string
+		segment    *pggeo.FavoriteSegment
+		athlete    *float64
+		wantMeters float64
+		wantSource string
+	}{
+		{"query wins over everything", "/x?tolerance=8", withDefault, floatPtr(20), 8, pggeo.ToleranceSourceRequest},
+		{"segment default wins over athlete", "/x", withDefault, floatPtr(20), 30, pggeo.ToleranceSourceSegment},
+		{"athlete setting wins over global", "/x", withoutDefault, floatPtr(20), 20, pggeo.ToleranceSourceAthlete},
+		{"global default last", "/x", withoutDefault, nil, pggeo.DefaultSegmentToleranceM, pggeo.ToleranceSourceDefault},
+		{"nil segment falls through", "/x", nil, nil, pggeo.DefaultSegmentToleranceM, pggeo.ToleranceSourceDefault},
+		{"invalid query ignored", "/x?tolerance=-5", withDefault, nil, 30, pggeo.ToleranceSourceSegment},
+		{"non-numeric query ignored", "/x?tolerance=wide", withoutDefault, floatPtr(20), 20, pggeo.ToleranceSourceAthlete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			got := resolveSegmentTolerance(r, tt.segment, tt.athlete)
+			if got.Meters != tt.wantMeters || got.Source != tt.wantSource {
+				t.Fatalf("tolerance = %+v, want %v from %s", got, tt.wantMeters, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestOptionalFloatDistinguishesNullFromAbsent(t *testing.T) {
+	var req struct {
+		DefaultToleranceM optionalFloat `json:"default_tolerance_m"`
+	}
+
+	if err := json.Unmarshal([]byte(`{}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.DefaultToleranceM.Set {
+		t.Fatal("absent field should not be set")
+	}
+
+	if err := json.Unmarshal([]byte(`{"default_tolerance_m": null}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if !req.DefaultToleranceM.Set || req.DefaultToleranceM.Value != nil {
+		t.Fatalf("null field = %+v, want set with nil value", req.DefaultToleranceM)
+	}
+
+	req.DefaultToleranceM = optionalFloat{}
+	if err := json.Unmarshal([]byte(`{"default_tolerance_m": 25}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if !req.DefaultToleranceM.Set || req.DefaultToleranceM.Value == nil || *req.DefaultToleranceM.Value != 25 {
+		t.Fatalf("numeric field = %+v, want 25", req.DefaultToleranceM)
+	}
+	if err := req.DefaultToleranceM.validate(); err != nil {
+		t.Fatalf("validate(25) = %v", err)
+	}
+
+	for _, bad := range []float64{0, -1, pggeo.MaxSegmentToleranceM + 1} {
+		if err := (optionalFloat{Set: true, Value: floatPtr(bad)}).validate(); err == nil {
+			t.Fatalf("validate(%v) = nil, want error", bad)
+		}
+	}
+}
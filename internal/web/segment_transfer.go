@@ -0,0 +1,239 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/trackimport"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// segmentFeatureCollection is the GeoJSON read by POST /api/segments/import; a single
+// Feature is read as a collection of one
+type segmentFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []segmentFeature `json:"features"`
+}
+
+type segmentFeature struct {
+	Type     string `json:"type"`
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties struct {
+		Name              string   `json:"name"`
+		Description       *string  `json:"description"`
+		ElevationGainM    *float64 `json:"elevation_gain_m"`
+		ElevationLossM    *float64 `json:"elevation_loss_m"`
+		NetElevationM     *float64 `json:"net_elevation_m"`
+		DefaultToleranceM *float64 `json:"default_tolerance_m"`
+		Pinned            bool     `json:"pinned"`
+	} `json:"properties"`
+}
+
+// segmentImportName names a segment the file left unnamed by its position
+func segmentImportName(name string, index int) string {
+	if name = strings.TrimSpace(name); name != "" {
+		return name
+	}
+	return fmt.Sprintf("Imported segment %d", index+1)
+}
+
+// segmentImportFromCoordinates validates [lng, lat(, altitude)] positions; altitudes are
+// kept when any position has one
+func segmentImportFromCoordinates(coordinates [][]float64) (pggeo.SegmentImport, error) {
+	points, _, err := copyLatLngPairs(coordinates, true)
+	if err != nil {
+		return pggeo.SegmentImport{}, err
+	}
+	imported := pggeo.SegmentImport{Points: points}
+	for i, position := range coordinates {
+		if len(position) > 2 {
+			if imported.Altitudes == nil {
+				imported.Altitudes = make([]*float64, len(coordinates))
+			}
+			altitude := position[2]
+			imported.Altitudes[i] = &altitude
+		}
+	}
+	return imported, nil
+}
+
+// parseSegmentsGeoJSON reads a FeatureCollection (or one Feature) of LineStrings. Each
+// feature becomes a segment or, when it is not a LineString of at least two valid
+// positions, an entry in rejected keyed by its index.
+func parseSegmentsGeoJSON(data []byte) (segments []pggeo.SegmentImport, rejected map[int]string, err error) {
+	var collection segmentFeatureCollection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return nil, nil, fmt.Errorf("invalid GeoJSON")
+	}
+	switch collection.Type {
+	case "FeatureCollection":
+	case "Feature":
+		var feature segmentFeature
+		if err := json.Unmarshal(data, &feature); err != nil {
+			return nil, nil, fmt.Errorf("invalid GeoJSON")
+		}
+		collection.Features = []segmentFeature{feature}
+	default:
+		return nil, nil, fmt.Errorf("GeoJSON must be a FeatureCollection or a Feature")
+	}
+
+	segments = make([]pggeo.SegmentImport, len(collection.Features))
+	rejected = map[int]string{}
+	for i, feature := range collection.Features {
+		if feature.Geometry == nil || feature.Geometry.Type != "LineString" {
+			rejected[i] = "geometry must be a LineString"
+			continue
+		}
+		var coordinates [][]float64
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &coordinates); err != nil {
+			rejected[i] = "invalid LineString coordinates"
+			continue
+		}
+		imported, err := segmentImportFromCoordinates(coordinates)
+		if err != nil {
+			rejected[i] = err.Error()
+			continue
+		}
+		props := feature.Properties
+		imported.Name = segmentImportName(props.Name, i)
+		if props.Description != nil {
+			imported.Description = *props.Description
+		}
+		imported.ElevationGainM, imported.ElevationLossM, imported.NetElevationM = props.ElevationGainM, props.ElevationLossM, props.NetElevationM
+		imported.DefaultToleranceM, imported.Pinned = props.DefaultToleranceM, props.Pinned
+		segments[i] = imported
+	}
+	return segments, rejected, nil
+}
+
+// parseSegmentsGPX reads one segment per track or route of a GPX file
+func parseSegmentsGPX(data []byte) (segments []pggeo.SegmentImport, rejected map[int]string, err error) {
+	paths, err := trackimport.ParseGPXPaths(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid GPX")
+	}
+	segments = make([]pggeo.SegmentImport, len(paths))
+	rejected = map[int]string{}
+	for i, path := range paths {
+		coordinates := make([][]float64, len(path.Points))
+		for j, point := range path.Points {
+			coordinates[j] = []float64{point.Lng, point.Lat}
+			if point.Altitude != nil {
+				coordinates[j] = append(coordinates[j], *point.Altitude)
+			}
+		}
+		imported, err := segmentImportFromCoordinates(coordinates)
+		if err != nil {
+			rejected[i] = err.Error()
+			continue
+		}
+		imported.Name = segmentImportName(path.Name, i)
+		segments[i] = imported
+	}
+	return segments, rejected, nil
+}
+
+// handleSegmentsExport handles GET /api/segments/export: every segment of the athlete as
+// a GeoJSON FeatureCollection that POST /api/segments/import reads back
+func (s *server) handleSegmentsExport(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	var geoJSON string
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		geoJSON, dbErr = pggeo.GetSegmentsGeoJSON(s.ctx, conn, scope.AthleteID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to export segments", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", `attachment; filename="segments.geojson"`)
+	_, _ = w.Write([]byte(geoJSON))
+}
+
+// handleSegmentsImport handles POST /api/segments/import?on_duplicate=skip|suffix. The body
+// is a GeoJSON FeatureCollection of LineStrings, as exported, or a GPX file whose tracks
+// and routes each become a segment. Features that can't be a segment are reported failed
+// without stopping the others.
+func (s *server) handleSegmentsImport(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	onDuplicate := r.URL.Query().Get("on_duplicate")
+	if onDuplicate == "" {
+		onDuplicate = pggeo.SegmentImportSkip
+	}
+	if !pggeo.ValidSegmentImportDuplicates(onDuplicate) {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "on_duplicate must be skip or suffix"))
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportFileBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, newAPIError(http.StatusRequestEntityTooLarge, "file too large"))
+			return
+		}
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
+		return
+	}
+
+	parse := parseSegmentsGeoJSON
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		parse = parseSegmentsGPX
+	}
+	segments, rejected, err := parse(data)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	valid := make([]pggeo.SegmentImport, 0, len(segments))
+	indexes := make([]int, 0, len(segments))
+	for i, segment := range segments {
+		if _, ok := rejected[i]; !ok {
+			valid = append(valid, segment)
+			indexes = append(indexes, i)
+		}
+	}
+
+	var result *pggeo.SegmentImportResult
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		result, dbErr = pggeo.ImportFavoriteSegments(s.ctx, conn, scope.AthleteID, valid, onDuplicate)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to import segments", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, mergeSegmentImportResult(result, indexes, rejected, len(segments)))
+}
+
+// mergeSegmentImportResult puts the features rejected before the import back among the
+// imported ones, in file order; indexes maps each imported item to its feature
+func mergeSegmentImportResult(result *pggeo.SegmentImportResult, indexes []int, rejected map[int]string, total int) *pggeo.SegmentImportResult {
+	merged := &pggeo.SegmentImportResult{Created: result.Created, Skipped: result.Skipped, Failed: result.Failed + len(rejected),
+		Items: make([]pggeo.SegmentImportItem, 0, total)}
+	next := 0
+	for i := 0; i < total; i++ {
+		if reason, ok := rejected[i]; ok {
+			merged.Items = append(merged.Items, pggeo.SegmentImportItem{Index: i, Status: "failed", Error: reason})
+			continue
+		}
+		item := result.Items[next]
+		item.Index = indexes[next]
+		merged.Items = append(merged.Items, item)
+		next++
+	}
+	return merged
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func TestParseSegmentsGeoJSONRejectsFeaturesThatAreNotSegments(t *testing.T) {
+	segments, rejected, err := parseSegmentsGeoJSON([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[6.9,45.1,100],[6.91,45.11]]},
+		 "properties":{"name":" Climb ","description":"pass","elevation_gain_m":12.5,"pinned":true}},
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[6.9,45.1]},"properties":{"name":"Spot"}},
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[6.9,45.1]]},"properties":{"name":"Dot"}},
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[6.9,95],[6.9,45]]},"properties":{"name":"Far"}},
+		{"type":"Feature","geometry":null,"properties":{}},
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[1,2],[3,4]]},"properties":{}}
+	]}`))
+	if err != nil || len(segments) != 6 || len(rejected) != 4 {
+		t.Fatalf("segments = %+v, rejected = %v, err = %v", segments, rejected, err)
+	}
+	climb := segments[0]
+	if climb.Name != "Climb" || climb.Description != "pass" || !climb.Pinned || climb.ElevationGainM == nil || *climb.ElevationGainM != 12.5 {
+		t.Fatalf("climb = %+v", climb)
+	}
+	if climb.Points[0][0] != 45.1 || climb.Points[0][1] != 6.9 || *climb.Altitudes[0] != 100 || climb.Altitudes[1] != nil {
+		t.Fatalf("climb points = %v, altitudes = %v", climb.Points, climb.Altitudes)
+	}
+	for i, want := range map[int]string{1: "LineString", 2: "at least two points", 3: "latitude"} {
+		if !strings.Contains(rejected[i], want) {
+			t.Fatalf("rejected[%d] = %q, want it to mention %q", i, rejected[i], want)
+		}
+	}
+	if segments[5].Name != "Imported segment 6" || segments[5].Altitudes != nil {
+		t.Fatalf("unnamed segment = %+v", segments[5])
+	}
+
+	single, rejected, err := parseSegmentsGeoJSON([]byte(`{"type":"Feature","geometry":{"type":"LineString","coordinates":[[1,2],[3,4]]},"properties":{"name":"One"}}`))
+	if err != nil || len(single) != 1 || single[0].Name != "One" || len(rejected) != 0 {
+		t.Fatalf("single feature = %+v, %v, %v", single, rejected, err)
+	}
+	if _, _, err := parseSegmentsGeoJSON([]byte(`{"type":"LineString","coordinates":[[1,2],[3,4]]}`)); err == nil {
+		t.Fatal("a bare geometry was accepted")
+	}
+}
+
+func TestParseSegmentsGPXReadsOneSegmentPerTrack(t *testing.T) {
+	segments, rejected, err := parseSegmentsGPX([]byte(`<gpx>
+		<trk><name>Climb</name><trkseg><trkpt lat="45.1" lon="6.9"><ele>100</ele></trkpt><trkpt lat="45.2" lon="6.95"/></trkseg></trk>
+		<trk><trkseg><trkpt lat="45.1" lon="6.9"/></trkseg></trk>
+		<rte><name>Back</name><rtept lat="45.2" lon="6.95"/><rtept lat="45.1" lon="6.9"/></rte>
+	</gpx>`))
+	if err != nil || len(segments) != 3 || len(rejected) != 1 || rejected[1] == "" {
+		t.Fatalf("segments = %+v, rejected = %v, err = %v", segments, rejected, err)
+	}
+	if segments[0].Name != "Climb" || segments[0].Points[1][0] != 45.2 || *segments[0].Altitudes[0] != 100 || segments[2].Name != "Back" {
+		t.Fatalf("segments = %+v", segments)
+	}
+}
+
+func TestMergeSegmentImportResultKeepsFileOrder(t *testing.T) {
+	result := &pggeo.SegmentImportResult{Created: 1, Skipped: 1, Items: []pggeo.SegmentImportItem{
+		{Index: 0, Name: "A", SegmentID: 9, Status: "created"},
+		{Index: 1, Name: "C", Status: "skipped"},
+	}}
+	merged := mergeSegmentImportResult(result, []int{0, 2}, map[int]string{1: "geometry must be a LineString"}, 3)
+	if merged.Created != 1 || merged.Skipped != 1 || merged.Failed != 1 || len(merged.Items) != 3 {
+		t.Fatalf("merged = %+v", merged)
+	}
+	if merged.Items[1].Status != "failed" || merged.Items[2].Index != 2 || merged.Items[2].Name != "C" {
+		t.Fatalf("items = %+v", merged.Items)
+	}
+}
+
+func TestSegmentsImportValidatesRequest(t *testing.T) {
+	s := newWebhookTestServer()
+	s.cacheWebAthlete("token", &strava.Athlete{ID: 7})
+	h := s.routes()
+	for _, tc := range []struct {
+		method, target, token, body string
+		want                        int
+	}{
+		{http.MethodGet, "/api/segments/export", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/segments/import", "", "{}", http.StatusUnauthorized},
+		{http.MethodPost, "/api/segments/import?on_duplicate=rename", "token", `{"type":"FeatureCollection","features":[]}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/segments/import", "token", "not json", http.StatusBadRequest},
+		{http.MethodPost, "/api/segments/import", "token", "<gpx", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		if tc.token != "" {
+			req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: tc.token})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s %s = %d, want %d (%s)", tc.method, tc.target, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"b11k/internal/pggeo"
+)
+
+// segmentNameExistsMessage answers creates and renames that reuse one of the athlete's
+// segment names with 409 Conflict
+const segmentNameExistsMessage = "you already have a segment with this name"
+
+// segmentResponse is a favorite segment as returned by the segment APIs, with its
+// markdown description rendered next to the raw text
+type segmentResponse struct {
+	*pggeo.FavoriteSegment
+	DescriptionHTML template.HTML `json:"description_html"`
+}
+
+func newSegmentResponse(segment *pggeo.FavoriteSegment) segmentResponse {
+	return segmentResponse{FavoriteSegment: segment, DescriptionHTML: markdownHTML(segment.Description)}
+}
+
+// segmentUpdateRequest is the body of PUT /api/segments/:id. Omitted name and description
+// keep their current values; activity_id with start_index/end_index replaces the geometry.
+type segmentUpdateRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	ActivityID  int64   `json:"activity_id"`
+	StartIndex  *int    `json:"start_index"`
+	EndIndex    *int    `json:"end_index"`
+}
+
+// hasGeometry reports whether the request replaces the segment's geometry
+func (req segmentUpdateRequest) hasGeometry() bool {
+	return req.ActivityID != 0 || req.StartIndex != nil || req.EndIndex != nil
+}
+
+// handleSegmentUpdate handles PUT /api/segments/:id. Renaming keeps the geometry and the
+// cached matches; a new activity range recomputes the geometry like POST /api/segments
+// and invalidates the cache.
+func (s *server) handleSegmentUpdate(w http.ResponseWriter, r *http.Request, athleteID int64, segment *pggeo.FavoriteSegment) {
+	var req segmentUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "Invalid request body"))
+		return
+	}
+
+	name := segment.Name
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+	}
+	if name == "" {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "name is required"))
+		return
+	}
+	description := ""
+	if segment.Description != nil {
+		description = *segment.Description
+	}
+	if req.Description != nil {
+		description = strings.TrimSpace(*req.Description)
+	}
+
+	var updated *pggeo.FavoriteSegment
+	var err error
+	if req.hasGeometry() {
+		if req.ActivityID == 0 || req.StartIndex == nil || req.EndIndex == nil {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "activity_id, start_index and end_index are required to change the geometry"))
+			return
+		}
+		startIndex, endIndex := *req.StartIndex, *req.EndIndex
+		if startIndex < 0 || endIndex < 0 || startIndex >= endIndex {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "invalid start_index or end_index"))
+			return
+		}
+		latLngData, samples, rangeErr := s.activityRange(athleteID, req.ActivityID, startIndex, endIndex)
+		if rangeErr != nil {
+			if errors.Is(rangeErr, errSegmentIndexOutOfRange) {
+				writeError(w, r, newAPIError(http.StatusBadRequest, "index out of range"))
+				return
+			}
+			s.handleDBPageError(w, r, rangeErr, http.StatusNotFound)
+			return
+		}
+		updated, err = s.updateOwnedFavoriteSegment(athleteID, segment.ID, name, description, latLngData, samples)
+	} else {
+		updated, err = s.renameOwnedFavoriteSegment(athleteID, segment.ID, name, description)
+	}
+	if err != nil {
+		if errors.Is(err, pggeo.ErrSegmentNameExists) {
+			writeError(w, r, newAPIError(http.StatusConflict, segmentNameExistsMessage))
+			return
+		}
+		slog.Error("Failed to update segment", "segment_id", segment.ID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, newSegmentResponse(updated))
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func TestSegmentUpdateRejectsInvalidRequestsBeforeTouchingTheDatabase(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	segment := &pggeo.FavoriteSegment{ID: 7, AthleteID: 1, Name: "Old name"}
+	cases := map[string]string{
+		"empty name":          `{"name":"  "}`,
+		"range without index": `{"activity_id":5,"start_index":3}`,
+		"index without range": `{"end_index":9}`,
+		"inverted range":      `{"activity_id":5,"start_index":9,"end_index":3}`,
+		"malformed body":      `{"name":`,
+	}
+	for name, body := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/segments/7", strings.NewReader(body))
+		s.handleSegmentUpdate(rec, req, 1, segment)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, rec.Code)
+		}
+	}
+}
+
+func TestSegmentUpdateRequestHasGeometry(t *testing.T) {
+	start, end := 0, 10
+	if (segmentUpdateRequest{}).hasGeometry() {
+		t.Fatal("a name-only update should keep the geometry")
+	}
+	if !(segmentUpdateRequest{ActivityID: 1, StartIndex: &start, EndIndex: &end}).hasGeometry() {
+		t.Fatal("an activity range should replace the geometry")
+	}
+}
+
+func TestDuplicateSegmentNameIsAConflict(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	err := fmt.Errorf("%w: %q", pggeo.ErrSegmentNameExists, "Climb")
+	rec := httptest.NewRecorder()
+	s.handleMobileSegmentMutationError(rec, httptest.NewRequest(http.MethodPost, "/api/mobile/segments", nil), err)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), segmentNameExistsMessage) {
+		t.Fatalf("status %d body %q, want 409 with %q", rec.Code, rec.Body.String(), segmentNameExistsMessage)
+	}
+}
+
+func TestAnotherAthletesSegmentIsNotFound(t *testing.T) {
+	s := &server{ctx: context.Background()}
+	// The segment queries are scoped by athlete, so another athlete's segment comes back missing
+	err := fmt.Errorf("failed to get segment: %w", pggeo.ErrSegmentNotFound)
+	rec := httptest.NewRecorder()
+	s.handleMobileSegmentMutationError(rec, httptest.NewRequest(http.MethodPut, "/api/mobile/segments/7", nil), err)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("mobile status %d, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/segments/7", nil)
+	req.Header.Set("Accept", "application/json")
+	s.handleDBPageError(rec, req, err, http.StatusInternalServerError)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("web status %d, want 404", rec.Code)
+	}
+}
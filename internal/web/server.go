@@ -6,7 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"math"
 	"net"
 	"net/http"
@@ -19,11 +19,18 @@ import (
 	syncpkg "sync"
 	"time"
 
+	"b11k/internal/cache"
+	"b11k/internal/digest"
+	"b11k/internal/logging"
+	"b11k/internal/metrics"
+	"b11k/internal/outbound"
 	"b11k/internal/pggeo"
+	"b11k/internal/queue"
 	"b11k/internal/strava"
 	"b11k/internal/sync"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Config struct {
@@ -46,17 +53,74 @@ type Config struct {
 	DiscoveredMapEnabled           bool
 	DiscoveredRevealRadiusMeters   float64
 	DiscoveredSampleDistanceMeters float64
+	AccountDeletionGraceDays       int
+	SkipSpatialSelfCheck           bool
+	PGMaxConns                     int
+	PGReplicaIP                    string
+	PGReplicaPort                  string
+	// LazySegmentCache skips matching synced activities to segments during the sync and
+	// the segment cache refresh queued after syncs and imports; segment pages then match
+	// new activities on their first visit
+	LazySegmentCache bool
+	// StravaWriteBack sends activity renames and description edits to Strava too; logins
+	// then also ask for the activity:write scope
+	StravaWriteBack bool
+	// BasePath is the URL prefix the app is served under ("/b11k"), empty for the root;
+	// see NormalizeBasePath
+	BasePath string
+	// AthleteCacheTTL is how long a login's athlete is cached; zero means 15 minutes
+	AthleteCacheTTL time.Duration
+	// SegmentCacheTTL is how long a segment's cached matches are served before the
+	// segment is matched against every activity again; zero means an hour
+	SegmentCacheTTL time.Duration
+	// ActivityTypes is the default sync type filter; empty syncs every type
+	ActivityTypes []string
+	// StravaWebhookVerifyToken enables /strava/webhook; Strava echoes it back when the
+	// push subscription is created
+	StravaWebhookVerifyToken string
+	// OutboundWebhooks receive activity and PR events; see package outbound
+	OutboundWebhooks []outbound.Endpoint
+	// AdminAthleteIDs may use /api/admin/ endpoints
+	AdminAthleteIDs []int64
+	// PublicAthleteID, when set, is shown read-only to visitors without a login
+	PublicAthleteID int64
+	// ShutdownGracePeriod bounds how long shutdown waits for requests and syncs; zero
+	// means 30 seconds
+	ShutdownGracePeriod time.Duration
+	// AutoPullOnView starts a background incremental sync when the activity list is
+	// viewed and the athlete's last sync is older than AutoPullStaleAfter; see auto_pull.go
+	AutoPullOnView        bool
+	AutoPullStaleAfter    time.Duration
+	AutoPullMaxActivities int
+	// HealGPSSpikes moves GPS teleport spikes back onto the route when activities are
+	// saved; without it they are only counted
+	HealGPSSpikes bool
+	// ShareViewRetention is how long views of share links are kept for their stats; zero
+	// means 90 days
+	ShareViewRetention time.Duration
+	// ShareViewGeoIPFile, when set, is a start_ip,end_ip,country CSV file the country of
+	// share link views is looked up in
+	ShareViewGeoIPFile string
+	// Limits are checked every 15 minutes and warned about with a banner once exceeded;
+	// with Limits.Enforce syncs stop fetching new activities past them
+	Limits pggeo.SoftLimits
+	// DigestInterval is how often background work is summed up in one log line per
+	// subsystem; zero means a day. DebugLogging also logs each routine event.
+	DigestInterval time.Duration
+	DebugLogging   bool
+	// MetricsEnabled serves Prometheus metrics at GET /metrics
+	MetricsEnabled bool
+	// TagRules tag activities as they are first stored by syncs, webhooks and imports
+	TagRules []sync.TagRule
 }
 
 type server struct {
-	ctx    context.Context
-	cfg    Config
-	conn   *pgx.Conn
-	connMu syncpkg.Mutex // Mutex to serialize database access (single connection)
-	tmpl   *template.Template
-	token  string
-	user   *strava.Athlete
+	ctx  context.Context
+	cfg  Config
+	pool *pgxpool.Pool
+	tmpl *template.Template
 
+	replica           *readReplica
 	mobileMu          syncpkg.Mutex
 	mobileSessions    map[string]mobileSession
 	mobileAuthStates  map[string]time.Time
@@ -64,9 +128,82 @@ type server struct {
 	rateMu            syncpkg.Mutex
 	rateLimits        map[string]rateLimitEntry
 	secretBox         *secretBox
-}
-
-const stravaTokenCookieName = "strava_token" // #nosec G101 -- cookie name only; not a credential value.
+	webAthletes       athleteCache
+	fetchAthlete      func(accessToken string) (*strava.Athlete, error) // tests only; nil calls Strava
+	athleteToken      func(athleteID int64) (string, error)             // tests only; nil reads athlete_tokens
+	requestLogger     *slog.Logger                                      // tests only; nil logs requests to slog.Default
+	loginCodes        loginCodeCache
+	syncJobMu         syncpkg.Mutex
+	syncJobs          map[int64]*syncJob
+	jobsCtx           context.Context    // parent of syncs and cache refreshes; cancelled when shutdown starts, unlike ctx
+	stopJobs          context.CancelFunc // cancels jobsCtx
+	jobs              syncpkg.WaitGroup  // running syncs and cache refreshes, awaited by shutdown
+	segmentRefreshMu  syncpkg.Mutex
+	segmentRefreshes  map[int64]*segmentRefreshJob
+	prefetchMu        syncpkg.Mutex
+	prefetches        map[int64]*athletePrefetch
+	profiles          *cache.LRU[int64, *pggeo.AthleteProfile] // prefetched Strava profiles, guarded by prefetchMu
+	windEstimates     windEstimateCache
+	publicStats       publicStatsCache
+	sessionTouches    webSessionTouches
+	outbound          *outbound.Dispatcher
+	prChecks          *queue.Queue[prCheck]
+	shareViews        *queue.Queue[pggeo.ShareView]
+	geoIP             *geoIPTable // nil without Config.ShareViewGeoIPFile
+	spatial           spatialHealth
+	softLimits        softLimitGauge
+	digest            *digest.Collector // nil in tests, which logs only failures
+
+	// When each athlete's last pull on page view started; guarded by syncJobMu
+	autoPulls map[int64]time.Time
+
+	exchangeCode func(code string) (*strava.StravaTokenResponse, error) // tests only; nil calls Strava
+	storeToken   func(tokenKey string, stored webStoredToken) error     // tests only; nil writes athlete_tokens
+	loadToken    func(tokenKey string) (webStoredToken, error)          // tests only; nil reads athlete_tokens
+	deleteToken  func(tokenKey string) error                            // tests only; nil deletes from athlete_tokens
+	storeProfile func(profile *pggeo.AthleteProfile) error              // tests only; nil writes athlete_profiles
+	loadProfile  func(athleteID int64) (*pggeo.AthleteProfile, error)   // tests only; nil reads athlete_profiles
+	storeAthlete func(athlete *strava.Athlete) error                    // tests only; nil writes athletes
+	loadAthlete  func(athleteID int64) (*pggeo.StoredAthlete, error)    // tests only; nil reads athletes
+
+	// Background syncs; tests only, nil runs the real sync and reads the shared limiter
+	runSync         func(ctx context.Context, cfg sync.SyncConfig, progress sync.ProgressCallback) (*sync.SyncResult, error)
+	remainingStrava func() (short, daily int)
+
+	// Strava calls of the login prefetch and of pages without a prefetched profile;
+	// tests only, nil calls Strava
+	prefetchStrava func(ctx context.Context, accessToken string) (*pggeo.AthleteProfile, error)
+	fetchZones     func(accessToken string) (*strava.AthleteZones, error)
+	fetchGear      func(ctx context.Context, accessToken, gearID string) (*strava.Gear, error)
+	updateActivity func(ctx context.Context, accessToken string, activityID int64, fields strava.ActivityUpdate) error
+
+	// web_sessions rows behind /api/sessions; tests only, nil uses the database
+	listSessions  func(athleteID int64) ([]pggeo.WebSession, error)
+	revokeSession func(athleteID, sessionID int64) (tokenKey string, err error)
+	touchSession  func(tokenKey, userAgent string, usedAt time.Time) error
+
+	// public_stats_tokens rows behind /public/stats; tests only, nil uses the database
+	useStatsToken    func(tokenKey string, now time.Time) (*pggeo.PublicStatsToken, error)
+	createStatsToken func(athleteID int64, tokenKey string, fields []string, limits pggeo.ShareLimits) (*pggeo.PublicStatsToken, error)
+	writeShareView   func(view pggeo.ShareView) error // tests only; nil writes share_views
+
+	// Efforts on a segment; tests only, nil matches in the database
+	segmentEfforts func(athleteID, segmentID int64, toleranceMeters float64, sortBy string, forceRefresh bool, filter pggeo.SegmentEffortFilter) ([]pggeo.ActivityWithMatch, error)
+
+	// Soft limit checks; tests only, nil measures the database and posts announcements
+	instanceUsage func() (*pggeo.InstanceUsage, error)
+	limitBanner   func(message string, now time.Time) error
+}
+
+// defaultShutdownGracePeriod is used when Config.ShutdownGracePeriod is zero
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// webSessionCookieName holds an opaque web session ID; the Strava tokens stay server-side
+const webSessionCookieName = "b11k_session"
+
+// legacyTokenCookieName held the raw Strava access token before web sessions. It is never
+// read, only expired on login and logout.
+const legacyTokenCookieName = "strava_token" // #nosec G101 -- cookie name only; not a credential value.
 const mobileSessionLifetime = 90 * 24 * time.Hour
 
 type mobileSession struct {
@@ -92,102 +229,170 @@ type rateLimitEntry struct {
 }
 
 func RunServer(ctx context.Context, cfg Config) {
-	log.Printf("🌐 Starting web server on port %s", cfg.WebPort)
+	slog.Info("Starting web server", "port", cfg.WebPort)
 
 	secretBox, err := newSecretBox(cfg.TokenEncryptionKey)
 	if err != nil {
-		log.Fatalf("Invalid token encryption key: %v", err)
+		logging.Fatal("Invalid token encryption key", "error", err)
 	}
 	if requiresTokenEncryption(cfg) && secretBox == nil {
-		log.Fatalf("B11K_TOKEN_ENCRYPTION_KEY is required when exposing the mobile API over public HTTPS")
+		logging.Fatal("B11K_TOKEN_ENCRYPTION_KEY is required when exposing the mobile API over public HTTPS")
 	}
 
-	conn, err := pggeo.Connect(ctx, cfg.PGUser, cfg.PGPassword, cfg.PGIP, cfg.PGPort, cfg.PGDatabase)
+	pool, err := pggeo.ConnectPool(ctx, cfg.PGUser, cfg.PGPassword, cfg.PGIP, cfg.PGPort, cfg.PGDatabase, cfg.PGMaxConns)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		logging.Fatal("Failed to connect to the database", "error", err)
 	}
+	defer pool.Close()
+	slog.Info("Database pool ready", "max_connections", pool.Config().MaxConns)
 
 	// Validate and migrate schema (forceRebuild=false for normal server startup)
-	if err := pggeo.ValidateAndMigrateSchema(ctx, conn, false); err != nil {
-		log.Fatalf("Error validating/migrating database schema: %v", err)
+	if err := pggeo.ValidateAndMigrateSchema(ctx, pool, false); err != nil {
+		logging.Fatal("Failed to validate or migrate the database schema", "error", err)
 	}
 
-	tmpl, err := parseTemplates()
+	tmpl, err := parseTemplates(cfg.BasePath)
 	if err != nil {
-		log.Fatalf("parse templates: %v", err)
+		logging.Fatal("Failed to parse templates", "error", err)
 	}
 
+	// Requests still being drained at shutdown keep a live context; ctx only starts it
+	baseCtx, cancelBase := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelBase()
+	jobsCtx, stopJobs := context.WithCancel(baseCtx)
+	defer stopJobs()
+
 	s := &server{
-		ctx:               ctx,
+		ctx:               baseCtx,
+		jobsCtx:           jobsCtx,
+		stopJobs:          stopJobs,
 		cfg:               cfg,
-		conn:              conn,
+		pool:              pool,
 		tmpl:              tmpl,
 		mobileSessions:    make(map[string]mobileSession),
 		mobileAuthStates:  make(map[string]time.Time),
 		mobileAuthResults: make(map[string]mobileAuthResult),
 		rateLimits:        make(map[string]rateLimitEntry),
+		syncJobs:          make(map[int64]*syncJob),
 		secretBox:         secretBox,
+		digest:            newLogDigest(cfg),
+	}
+	dispatcher, err := outbound.NewDispatcher(cfg.OutboundWebhooks, outbound.Options{Store: webhookStore{s: s}, Digest: s.digest})
+	if err != nil {
+		logging.Fatal("Invalid outbound webhook config", "error", err)
+	}
+	s.outbound = dispatcher
+	s.webAthletes.ttl = cfg.AthleteCacheTTL
+	if cfg.ShareViewGeoIPFile != "" {
+		if s.geoIP, err = loadGeoIPTable(cfg.ShareViewGeoIPFile); err != nil {
+			logging.Fatal("Invalid share view GeoIP file", "error", err)
+		}
+		slog.Info("Share view GeoIP lookup enabled", "ranges", len(s.geoIP.ranges))
 	}
+	s.shareViews = newShareViewQueue(s.digest)
 	if cfg.DevReloadTemplates {
-		log.Printf("🔁 Dev template reload enabled")
+		slog.Info("Dev template reload enabled")
+	}
+	if cfg.DebugLogging {
+		slog.Info("Debug logging enabled")
 	}
 	if secretBox != nil {
-		log.Printf("🔒 Strava token encryption at rest enabled")
+		slog.Info("Strava token encryption at rest enabled")
 	}
 	if cfg.PublicAPIHost != "" {
-		log.Printf("🔐 Public API host configured: %s", cfg.PublicAPIHost)
-	}
-
-	// Routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", s.handleIndex)
-	mux.HandleFunc("/strava/", s.handleStravaHome)
-	mux.HandleFunc("/strava/login", s.handleStravaLogin)
-	mux.HandleFunc("/activity/", s.handleActivity)
-	mux.HandleFunc("/api/activities", s.handleActivitiesAPI)
-	mux.HandleFunc("/api/activities/", s.handleActivityPointsAPI)
-	mux.HandleFunc("/strava/callback", s.handleStravaCallback)
-	mux.HandleFunc("/strava/logout", s.handleStravaLogout)
-	mux.HandleFunc("/api/hrzones", s.handleHRZones)
-	mux.HandleFunc("/api/mobile/auth/start", s.handleMobileAuthStart)
-	mux.HandleFunc("/api/mobile/auth/exchange", s.handleMobileAuthExchange)
-	mux.HandleFunc("/api/mobile/auth/callback", s.handleMobileAuthCallback)
-	mux.HandleFunc("/api/mobile/auth/session", s.handleMobileAuthSession)
-	mux.HandleFunc("/api/mobile/me", s.handleMobileMe)
-	mux.HandleFunc("/api/mobile/profile", s.handleMobileProfile)
-	mux.HandleFunc("/api/mobile/logout", s.handleMobileLogout)
-	mux.HandleFunc("/api/mobile/sync", s.handleMobileSync)
-	mux.HandleFunc("/api/mobile/activities", s.handleMobileActivities)
-	mux.HandleFunc("/api/mobile/activities/", s.handleMobileActivities)
-	mux.HandleFunc("/api/mobile/segments", s.handleMobileSegments)
-	mux.HandleFunc("/api/mobile/segments/", s.handleMobileSegments)
-	mux.HandleFunc("/strava/sync", s.handleStravaSyncSSE)
-	mux.HandleFunc("/api/segments", s.handleSegmentsAPI)
-	mux.HandleFunc("/api/segments/", s.handleSegmentAPI)
-	mux.HandleFunc("/segments", s.handleSegmentsPage)
-	mux.HandleFunc("/segment/", s.handleSegmentPage)
-	mux.HandleFunc("/profile", s.handleProfilePage)
-	if cfg.DiscoveredMapEnabled {
-		mux.HandleFunc("/api/mobile/discovered/", s.handleMobileDiscovered)
-		mux.HandleFunc("/discovered", s.handleDiscoveredPage)
-		mux.HandleFunc("/api/discovered/", s.handleDiscoveredAPI)
-	}
-
-	// static
-	mux.Handle("/static/", http.StripPrefix("/static/", s.staticFileServer()))
+		slog.Info("Public API host configured", "host", cfg.PublicAPIHost)
+	}
+
+	if cfg.PGReplicaIP != "" {
+		replicaPool, err := pggeo.NewReplicaPool(ctx, cfg.PGUser, cfg.PGPassword, cfg.PGReplicaIP, cfg.PGReplicaPort, cfg.PGDatabase, cfg.PGMaxConns)
+		if err != nil {
+			logging.Fatal("Invalid read replica config", "error", err)
+		}
+		defer replicaPool.Close()
+		slog.Info("Read replica configured", "host", cfg.PGReplicaIP, "port", cfg.PGReplicaPort)
+		s.replica = &readReplica{pool: replicaPool}
+		s.checkReplica()
+		go s.runReplicaHealthChecks()
+	}
+
+	if cfg.MetricsEnabled {
+		metrics.SetSources(s.metricsSources())
+	}
+	s.registerWindEstimateInvalidator()
+	s.runSpatialSelfCheck()
+	go s.runAccountDeletions()
+	go s.digest.Run(baseCtx, cfg.DigestInterval)
+	go s.runWebSessionTouches()
+	go s.runExpiredWebSessionSweep()
+	go s.runShareTokenSweep()
+	go s.runShareViewWriter()
+	if cfg.Limits.Enabled() {
+		slog.Info("Soft limits enabled", "enforced", cfg.Limits.Enforce)
+		go s.runSoftLimitChecks()
+	}
+	if dispatcher != nil {
+		// Runs past the signal so shutdown can drain it once syncs stop emitting
+		dispatcher.Start(baseCtx)
+		slog.Info("Outbound webhooks enabled", "endpoints", len(cfg.OutboundWebhooks))
+		if dispatcher.Wants(outbound.EventSegmentPR) {
+			s.prChecks = newPRCheckQueue(s.digest)
+			go s.runPRDetection()
+		}
+	}
 
 	addr := ":" + strings.TrimPrefix(cfg.WebPort, ":")
 	httpServer := &http.Server{
 		Addr:              addr,
-		Handler:           s.securityMiddleware(mux),
+		Handler:           s.routes(),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		WriteTimeout:      15 * time.Minute,
 		IdleTimeout:       2 * time.Minute,
 	}
-	if err := httpServer.ListenAndServe(); err != nil {
-		log.Fatalf("server error: %v", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		logging.Fatal("Server failed", "error", err)
+	case <-ctx.Done():
+	}
+	s.shutdown(httpServer)
+}
+
+// shutdown stops accepting requests, cancels running syncs so they stop after the
+// activity being saved, and waits up to ShutdownGracePeriod for both to finish and for
+// queued webhook deliveries, saving those left to the database. The database pools are
+// closed by RunServer once it returns.
+func (s *server) shutdown(httpServer *http.Server) {
+	grace := s.cfg.ShutdownGracePeriod
+	if grace <= 0 {
+		grace = defaultShutdownGracePeriod
+	}
+	slog.Info("Shutting down, waiting for requests and syncs", "grace", grace)
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	s.stopJobs()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Warn("Requests still running at shutdown", "error", err)
+	}
+	jobsDone := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		slog.Warn("Background jobs still running at shutdown")
 	}
+	s.outbound.Shutdown(ctx)
+	s.flushWebSessionTouches()
+	s.digest.Flush()
+	slog.Info("Server stopped", "duration", time.Since(started).Round(time.Millisecond))
 }
 
 func (s *server) securityMiddleware(next http.Handler) http.Handler {
@@ -197,15 +402,15 @@ func (s *server) securityMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("X-Frame-Options", "DENY")
 
 		if !s.isRequestAllowed(r) {
-			http.NotFound(w, r)
+			writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 			return
 		}
 		if !s.isPublicRequestTransportAllowed(r) {
-			http.Error(w, "HTTPS is required", http.StatusForbidden)
+			writeError(w, r, newAPIError(http.StatusForbidden, "HTTPS is required"))
 			return
 		}
 		if s.isDisallowedBrowserMobileAPIRequest(r) {
-			http.Error(w, "browser origins are not allowed for mobile API", http.StatusForbidden)
+			writeError(w, r, newAPIError(http.StatusForbidden, "browser origins are not allowed for mobile API"))
 			return
 		}
 		if !s.allowRequestRate(w, r) {
@@ -306,7 +511,7 @@ func (s *server) isDisallowedBrowserMobileAPIRequest(r *http.Request) bool {
 
 func (s *server) allowRequestRate(w http.ResponseWriter, r *http.Request) bool {
 	path := r.URL.Path
-	if !strings.HasPrefix(path, "/api/mobile/") && path != "/strava/login" && path != "/strava/callback" {
+	if !strings.HasPrefix(path, "/api/mobile/") && !strings.HasPrefix(path, "/public/stats/") && path != "/strava/login" && path != "/strava/callback" {
 		return true
 	}
 
@@ -331,6 +536,9 @@ func (s *server) allowRequestRate(w http.ResponseWriter, r *http.Request) bool {
 	case path == "/strava/login" || path == "/strava/callback":
 		limit = 40
 		bucket = "web-auth"
+	case strings.HasPrefix(path, "/public/stats/"):
+		limit = 120
+		bucket = "public-stats"
 	}
 
 	key := clientIP(r) + ":" + bucket
@@ -339,7 +547,7 @@ func (s *server) allowRequestRate(w http.ResponseWriter, r *http.Request) bool {
 		return true
 	}
 	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
-	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	writeError(w, r, newAPIError(http.StatusTooManyRequests, "rate limit exceeded"))
 	return false
 }
 
@@ -454,15 +662,23 @@ func isLocalOrPrivateHost(host string) bool {
 	return ip.IsLoopback() || ip.IsPrivate()
 }
 
-func parseTemplates() (*template.Template, error) {
+// parseTemplates loads the page templates. User-controlled text must reach them as plain
+// values so html/template escapes it for its context; see template_xss_test.go. Links go
+// through the url and asset funcs, which prefix basePath.
+func parseTemplates(basePath string) (*template.Template, error) {
 	return template.New("").Funcs(template.FuncMap{
-		"mul":  func(a, b float64) float64 { return a * b },
-		"kcal": func(kj float64) float64 { return kj * 0.239006 },
-		"add":  func(a, b int) int { return a + b },
-		"sub":  func(a, b int) int { return a - b },
+		"mul":             func(a, b float64) float64 { return a * b },
+		"kcal":            func(kj float64) float64 { return kj * 0.239006 },
+		"add":             func(a, b int) int { return a + b },
+		"sub":             func(a, b int) int { return a - b },
+		"sparklinePoints": sparklinePoints,
+		"markdown":        renderMarkdown,
+		"markdownPtr":     markdownHTML,
 		"asset": func(path string) string {
-			return cacheBustedAsset(path)
+			return basePath + cacheBustedAsset(path)
 		},
+		"url":      func(path string) string { return basePath + path },
+		"basePath": func() string { return basePath },
 		"hasActivity": func(data interface{}) bool {
 			if data == nil {
 				return false
@@ -476,16 +692,23 @@ func parseTemplates() (*template.Template, error) {
 			}
 			return v.FieldByName("Activity").IsValid()
 		},
+		"startTime": func(activity strava.ActivitySummary, zone pggeo.DisplayZone) string {
+			return zone.Format(activity.StartDateTime, activity.UtcOffset)
+		},
 	}).ParseFiles(
 		filepath.FromSlash("web/templates/index.html"),
 		filepath.FromSlash("web/templates/activity.html"),
 		filepath.FromSlash("web/templates/segments.html"),
 		filepath.FromSlash("web/templates/segment.html"),
 		filepath.FromSlash("web/templates/profile.html"),
+		filepath.FromSlash("web/templates/settings.html"),
 		filepath.FromSlash("web/templates/discovered.html"),
+		filepath.FromSlash("web/templates/share_gone.html"),
 		filepath.FromSlash("web/templates/partials/topbar.html"),
 		filepath.FromSlash("web/templates/partials/map.html"),
 		filepath.FromSlash("web/templates/partials/graph.html"),
+		filepath.FromSlash("web/templates/partials/distribution.html"),
+		filepath.FromSlash("web/templates/partials/timeline.html"),
 		filepath.FromSlash("web/templates/partials/color_controls.html"),
 		filepath.FromSlash("web/templates/partials/activity_sidebar.html"),
 		filepath.FromSlash("web/templates/partials/segment_sidebar.html"),
@@ -511,9 +734,9 @@ func cacheBustedAsset(path string) string {
 func (s *server) executeTemplate(w http.ResponseWriter, name string, data interface{}) error {
 	tmpl := s.tmpl
 	if s.cfg.DevReloadTemplates {
-		reloaded, err := parseTemplates()
+		reloaded, err := parseTemplates(s.cfg.BasePath)
 		if err != nil {
-			log.Printf("template reload error: %v", err)
+			slog.Error("Failed to reload templates", "error", err)
 			return err
 		}
 		tmpl = reloaded
@@ -521,54 +744,53 @@ func (s *server) executeTemplate(w http.ResponseWriter, name string, data interf
 	return tmpl.ExecuteTemplate(w, name, data)
 }
 
-func (s *server) withDB(op func(*pgx.Conn) error) error {
-	s.connMu.Lock()
-	defer s.connMu.Unlock()
+// withDB runs op against the connection pool. Connections are checked out per query, so
+// concurrent requests no longer wait on each other. An op that hit a dead pooled connection
+// is retried once; the pool discards broken connections and dials a fresh one.
+// dbRetryBackoff is waited before each retry of an operation that failed on a broken or
+// unreachable connection. The pool drops broken connections, so a retry dials afresh; the
+// total wait bounds how long a request hangs while the database restarts.
+var dbRetryBackoff = []time.Duration{100 * time.Millisecond, 500 * time.Millisecond, 1500 * time.Millisecond}
 
-	err := op(s.conn)
-	if err == nil {
-		return nil
-	}
-	if !isRecoverableDBError(err) {
+func (s *server) withDB(op func(*pgxpool.Pool) error) error {
+	err := op(s.pool)
+	if err == nil || !isRecoverableDBError(err) {
 		return err
 	}
 
-	log.Printf("⚠️ Database connection looked busy/stale, reconnecting: %v", err)
-	if recErr := s.reconnectDBLocked(); recErr != nil {
-		return fmt.Errorf("database recovery failed after %v: %w", err, recErr)
-	}
-
-	if retryErr := op(s.conn); retryErr != nil {
-		return retryErr
-	}
-	log.Printf("✅ Database connection recovered")
-	return nil
-}
-
-func (s *server) reconnectDBLocked() error {
-	if s.conn != nil {
-		_ = s.conn.Close(context.Background())
-	}
-
-	ctx, cancel := context.WithTimeout(s.ctx, 15*time.Second)
-	defer cancel()
-
-	conn, err := pggeo.Connect(ctx, s.cfg.PGUser, s.cfg.PGPassword, s.cfg.PGIP, s.cfg.PGPort, s.cfg.PGDatabase)
-	if err != nil {
-		return err
-	}
-	if err := pggeo.ValidateAndMigrateSchema(ctx, conn, false); err != nil {
-		_ = conn.Close(context.Background())
-		return err
+	slog.Warn("Database connection looked busy or stale, retrying", "error", err)
+	for _, wait := range dbRetryBackoff {
+		select {
+		case <-time.After(wait):
+		case <-s.ctx.Done():
+			return err
+		}
+		err = op(s.pool)
+		if err == nil {
+			slog.Info("Database connection recovered")
+			return nil
+		}
+		if !isRecoverableDBError(err) {
+			return err
+		}
 	}
-	s.conn = conn
-	return nil
+	return err
 }
 
 func isRecoverableDBError(err error) bool {
 	if err == nil {
 		return false
 	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions; 57P01-57P03 are sent while the server
+		// shuts down, crashes or is still starting up
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
 	msg := strings.ToLower(err.Error())
 	recoverableFragments := []string{
 		"conn busy",
@@ -576,7 +798,9 @@ func isRecoverableDBError(err error) bool {
 		"conn closed",
 		"closed connection",
 		"connection reset",
+		"connection refused",
 		"broken pipe",
+		"unexpected eof",
 	}
 	for _, fragment := range recoverableFragments {
 		if strings.Contains(msg, fragment) {
@@ -588,18 +812,8 @@ func isRecoverableDBError(err error) bool {
 
 func (s *server) renderDatabaseBusy(w http.ResponseWriter, r *http.Request, err error) {
 	// #nosec G706 -- request path is escaped before logging.
-	log.Printf("⚠️ Database still recovering for %s: %v", safeLogText(r.URL.Path), err)
+	slog.Warn("Database still recovering", "path", safeLogText(r.URL.Path), "error", err)
 	w.Header().Set("Retry-After", "2")
-	if strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json") {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":               "Database is recovering. Please retry shortly.",
-			"retry_after_seconds": 2,
-		})
-		return
-	}
-
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusServiceUnavailable)
 	_, _ = w.Write([]byte(`<!doctype html>
@@ -658,35 +872,26 @@ func (s *server) renderDatabaseBusy(w http.ResponseWriter, r *http.Request, err
 </html>`))
 }
 
+// handleDBPageError answers a failed database call: the reconnecting page while the
+// database recovers, otherwise writeError's answer with fallbackStatus for errors that
+// are not pggeo domain errors
 func (s *server) handleDBPageError(w http.ResponseWriter, r *http.Request, err error, fallbackStatus int) {
-	if isRecoverableDBError(err) {
+	if isRecoverableDBError(err) && !wantsJSON(r) {
 		s.renderDatabaseBusy(w, r, err)
 		return
 	}
-	http.Error(w, err.Error(), fallbackStatus)
-}
-
-func (s *server) ensureSessionFromRequest(r *http.Request) {
-	if s.token == "" {
-		if cookie, err := r.Cookie(stravaTokenCookieName); err == nil {
-			s.token = cookie.Value
-		}
-	}
-	if s.user == nil && s.token != "" {
-		if a, err := strava.FetchCurrentAthlete(s.token); err == nil {
-			s.user = a
-		} else {
-			log.Printf("⚠️ Failed to fetch current athlete: %v", err)
-		}
-	}
+	writeAPIError(w, r, apiErrorFor(err, fallbackStatus))
 }
 
-func (s *server) enrichGearNames(activities []strava.ActivitySummary) []strava.ActivitySummary {
-	if s.token == "" || s.user == nil {
+// enrichGearNames names the gear of activities synced before their gear was known, from
+// the prefetched gear list or else from Strava
+func (s *server) enrichGearNames(scope athleteScope, activities []strava.ActivitySummary) []strava.ActivitySummary {
+	if scope.StravaToken == "" || scope.Athlete == nil {
 		return activities
 	}
 
 	seen := make(map[string]*string)
+	var profileGear map[string]string
 	for i := range activities {
 		gearID := strings.TrimSpace(activities[i].GearID)
 		if gearID == "" || activities[i].GearName != nil {
@@ -696,11 +901,23 @@ func (s *server) enrichGearNames(activities []strava.ActivitySummary) []strava.A
 			activities[i].GearName = cached
 			continue
 		}
+		if profileGear == nil {
+			profileGear = make(map[string]string)
+			if profile := s.warmProfile(scope); profile != nil {
+				profileGear = profile.GearNames()
+			}
+		}
+		if name, ok := profileGear[gearID]; ok {
+			// Retired gear missing from the list still goes to Strava below
+			activities[i].GearName = &name
+			seen[gearID] = &name
+			continue
+		}
 
-		gear, err := strava.FetchGear(s.token, gearID)
+		gear, err := s.fetchGearByID(scope.StravaToken, gearID)
 		if err != nil || gear == nil || strings.TrimSpace(gear.Name) == "" {
 			if err != nil {
-				log.Printf("⚠️ Failed to fetch gear %s: %v", gearID, err)
+				slog.Warn("Failed to fetch gear", "gear_id", gearID, "error", err)
 			}
 			seen[gearID] = nil
 			continue
@@ -709,490 +926,40 @@ func (s *server) enrichGearNames(activities []strava.ActivitySummary) []strava.A
 		name := strings.TrimSpace(gear.Name)
 		activities[i].GearName = &name
 		seen[gearID] = &name
-		if err := s.withDB(func(conn *pgx.Conn) error {
-			return pggeo.UpdateGearNameForGearID(s.ctx, conn, s.user.ID, gearID, name)
+		if err := s.withDB(func(conn *pgxpool.Pool) error {
+			return pggeo.UpdateGearNameForGearID(s.ctx, conn, scope.AthleteID, gearID, name)
 		}); err != nil {
-			log.Printf("⚠️ Failed to cache gear name for %s: %v", gearID, err)
+			slog.Warn("Failed to cache gear name", "gear_id", gearID, "error", err)
 		}
 	}
 	return activities
 }
 
-func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+// fetchGearByID calls Strava's gear endpoint, or the fake installed by tests
+func (s *server) fetchGearByID(accessToken, gearID string) (*strava.Gear, error) {
+	if s.fetchGear != nil {
+		return s.fetchGear(s.ctx, accessToken, gearID)
 	}
-	// Check for token in cookie if not in memory
-	s.ensureSessionFromRequest(r)
-	s.renderActivitiesPageWithReq(w, r)
-}
-
-func (s *server) handleStravaHome(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/strava/" {
-		http.NotFound(w, r)
-		return
-	}
-	// Check for token in cookie if not in memory
-	s.ensureSessionFromRequest(r)
-	s.renderActivitiesPageWithReq(w, r)
-}
-
-func (s *server) renderActivitiesPageWithReq(w http.ResponseWriter, r *http.Request) {
-	s.ensureSessionFromRequest(r)
-
-	// pagination params
-	page := 1
-	perPage := 20
-	if p := r.URL.Query().Get("page"); p != "" {
-		if n, err := strconv.Atoi(p); err == nil && n > 0 {
-			page = n
-		}
-	}
-	if pp := r.URL.Query().Get("per_page"); pp != "" {
-		if n, err := strconv.Atoi(pp); err == nil && n > 0 && n <= 100 {
-			perPage = n
-		}
-	}
-	// Get all activities for the current athlete (no date restriction)
-	var activities []strava.ActivitySummary
-	var err error
-	if s.user != nil {
-		err = s.withDB(func(conn *pgx.Conn) error {
-			var dbErr error
-			activities, dbErr = pggeo.GetAllActivities(s.ctx, conn, s.user.ID)
-			return dbErr
-		})
-		if err != nil {
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-		activities = s.enrichGearNames(activities)
-	}
-
-	// paginate in-memory for now
-	total := len(activities)
-	totalPages := (total + perPage - 1) / perPage
-	if totalPages == 0 {
-		totalPages = 1
-	}
-	if page > totalPages {
-		page = totalPages
-	}
-	startIdx := (page - 1) * perPage
-	endIdx := startIdx + perPage
-	if endIdx > total {
-		endIdx = total
-	}
-	pageItems := activities
-	if total > 0 {
-		pageItems = activities[startIdx:endIdx]
-	}
-	data := struct {
-		Activities           []strava.ActivitySummary
-		ShowLoginCTA         bool
-		Authorized           bool
-		Athlete              *strava.Athlete
-		CurrentPage          int
-		TotalPages           int
-		HasNext              bool
-		HasPrev              bool
-		PerPage              int
-		DiscoveredMapEnabled bool
-	}{
-		Activities:           pageItems,
-		ShowLoginCTA:         s.token == "" && s.cfg.StravaClientID != "",
-		Authorized:           s.token != "",
-		Athlete:              s.user,
-		CurrentPage:          page,
-		TotalPages:           totalPages,
-		HasNext:              page < totalPages,
-		HasPrev:              page > 1,
-		PerPage:              perPage,
-		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
-	}
-	if err := s.executeTemplate(w, "index.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
-func (s *server) handleStravaLogin(w http.ResponseWriter, r *http.Request) {
-	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.StravaRedirectURI)
-	loginURL := strava.GenerateAuthURL(*authCfg)
-	http.Redirect(w, r, loginURL, http.StatusFound)
-}
-
-func (s *server) handleActivity(w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/activity/")
-	if idStr == "" {
-		http.NotFound(w, r)
-		return
-	}
-	activityID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
-		return
-	}
-	// Load athlete from cookie token if available
-	if s.token == "" {
-		if cookie, err := r.Cookie(stravaTokenCookieName); err == nil {
-			s.token = cookie.Value
-		}
-	}
-	if s.user == nil && s.token != "" {
-		if a, err := strava.FetchCurrentAthlete(s.token); err == nil {
-			s.user = a
-		}
-	}
-
-	// Check if user is authenticated
-	if s.user == nil {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
-		return
-	}
-
-	var activity *strava.ActivitySummary
-	err = s.withDB(func(conn *pgx.Conn) error {
-		var dbErr error
-		activity, dbErr = pggeo.GetActivityByID(s.ctx, conn, s.user.ID, activityID)
-		return dbErr
-	})
-	if err != nil {
-		s.handleDBPageError(w, r, err, http.StatusNotFound)
-		return
-	}
-	enriched := s.enrichGearNames([]strava.ActivitySummary{*activity})
-	if len(enriched) > 0 {
-		activity = &enriched[0]
-	}
-
-	var activityHRZones []pggeo.HRZoneDistribution
-	if s.token != "" {
-		if zones, err := strava.FetchHeartRateZones(s.token); err == nil && zones != nil {
-			err = s.withDB(func(conn *pgx.Conn) error {
-				var dbErr error
-				activityHRZones, dbErr = pggeo.GetHRZoneDistributionForActivity(s.ctx, conn, s.user.ID, activityID, &zones.HeartRate)
-				return dbErr
-			})
-			if err != nil {
-				log.Printf("⚠️ Failed to calculate activity HR zones for %d: %v", activityID, err)
-			}
-		}
-	}
-	data := struct {
-		Activity             strava.ActivitySummary
-		ActivityHRZones      []pggeo.HRZoneDistribution
-		Athlete              *strava.Athlete
-		ShowLoginCTA         bool
-		Authorized           bool
-		MobileActivityOrder  string
-		DiscoveredMapEnabled bool
-	}{
-		Activity:             *activity,
-		ActivityHRZones:      activityHRZones,
-		Athlete:              s.user,
-		ShowLoginCTA:         s.token == "" && s.cfg.StravaClientID != "",
-		Authorized:           s.token != "",
-		MobileActivityOrder:  s.cfg.MobileActivityOrder,
-		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
-	}
-	if err := s.executeTemplate(w, "activity.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
-func (s *server) handleActivitiesAPI(w http.ResponseWriter, r *http.Request) {
-	// Check if user is authenticated
-	if s.user == nil {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
-		return
-	}
-
-	end := time.Now()
-	start := end.AddDate(0, 0, -180)
-	var activities []strava.ActivitySummary
-	err := s.withDB(func(conn *pgx.Conn) error {
-		var dbErr error
-		activities, dbErr = pggeo.GetActivitiesByDateRange(s.ctx, conn, s.user.ID, start, end)
-		return dbErr
-	})
-	if err != nil {
-		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-		return
-	}
-	activities = s.enrichGearNames(activities)
-	writeJSON(w, activities)
-}
-
-// handleStravaSyncSSE starts a sync and streams progress logs using Server-Sent Events
-func (s *server) handleStravaSyncSSE(w http.ResponseWriter, r *http.Request) {
-	if s.token == "" {
-		if cookie, err := r.Cookie(stravaTokenCookieName); err == nil {
-			s.token = cookie.Value
-		}
-	}
-	if s.token == "" {
-		http.Error(w, "not authorized with Strava", http.StatusUnauthorized)
-		return
-	}
-
-	// Parse timeframe from query (?start=YYYY-MM-DD&end=YYYY-MM-DD)
-	q := r.URL.Query()
-	var startTime time.Time
-	var endTime time.Time
-	if startStr := q.Get("start"); startStr != "" {
-		if t, err := time.Parse("2006-01-02", startStr); err == nil {
-			startTime = t
-		}
-	}
-	if endStr := q.Get("end"); endStr != "" {
-		if t, err := time.Parse("2006-01-02", endStr); err == nil {
-			endTime = t
-		}
-	}
-
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
-		return
-	}
-
-	// helper to send a line
-	send := func(event, data string) {
-		if event != "" {
-			_, _ = w.Write([]byte("event: " + event + "\n"))
-		}
-		_, _ = w.Write([]byte("data: " + data + "\n\n"))
-		flusher.Flush()
-	}
-
-	send("log", "Starting sync...")
-
-	cfg := sync.SyncConfig{
-		StravaAccessToken: s.token,
-		DatabaseConfig: sync.DatabaseConfig{
-			Host:     s.cfg.PGIP,
-			Port:     s.cfg.PGPort,
-			User:     s.cfg.PGUser,
-			Password: s.cfg.PGPassword,
-			Database: s.cfg.PGDatabase,
-		},
-		Timeframe: sync.TimeframeConfig{
-			StartTime: startTime,
-			EndTime:   endTime,
-		},
-		DiscoveredMap: sync.DiscoveredMapConfig{
-			Enabled:              s.cfg.DiscoveredMapEnabled,
-			RevealRadiusMeters:   s.cfg.DiscoveredRevealRadiusMeters,
-			SampleDistanceMeters: s.cfg.DiscoveredSampleDistanceMeters,
-		},
-	}
-
-	// Create progress callback that sends SSE events
-	progressCallback := func(phase string, current, total int, message string) {
-		progressData := struct {
-			Phase   string `json:"phase"`
-			Current int    `json:"current"`
-			Total   int    `json:"total"`
-			Message string `json:"message"`
-		}{
-			Phase:   phase,
-			Current: current,
-			Total:   total,
-			Message: message,
-		}
-		progressJSON, _ := json.Marshal(progressData)
-		send("progress", string(progressJSON))
-	}
-
-	// Run sync synchronously; for large syncs consider goroutine + channels
-	result, err := sync.SyncActivitiesFromStravaWithRetry(s.ctx, cfg, 3, progressCallback)
-	if err != nil {
-		send("error", "Sync failed: "+err.Error())
-		return
-	}
-
-	// Summarize
-	summary := struct {
-		Total    int `json:"total"`
-		Existing int `json:"existing"`
-		New      int `json:"new"`
-		Success  int `json:"success"`
-		Failed   int `json:"failed"`
-	}{result.TotalActivitiesFound, result.ExistingActivities, result.NewActivities, result.SuccessfullyProcessed, len(result.FailedActivities)}
-
-	b, _ := json.Marshal(summary)
-	send("summary", string(b))
-	send("done", "ok")
-}
-
-func (s *server) handleActivityPointsAPI(w http.ResponseWriter, r *http.Request) {
-	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/activities/"), "/")
-	if len(parts) < 1 {
-		http.NotFound(w, r)
-		return
-	}
-
-	activityID, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
-		return
-	}
-
-	// Check for token in cookie if not in memory
-	if s.token == "" {
-		if cookie, err := r.Cookie(stravaTokenCookieName); err == nil {
-			s.token = cookie.Value
-		}
-	}
-	if s.user == nil && s.token != "" {
-		if a, err := strava.FetchCurrentAthlete(s.token); err == nil {
-			s.user = a
-		}
-	}
-
-	// Check if user is authenticated
-	if s.user == nil {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
-		return
-	}
-
-	// Handle graph endpoint
-	if len(parts) == 2 && parts[1] == "graph" {
-		metricsStr := r.URL.Query().Get("metrics")
-		if metricsStr == "" {
-			http.Error(w, "metrics parameter required", http.StatusBadRequest)
-			return
-		}
-		metrics := strings.Split(metricsStr, ",")
-		for i := range metrics {
-			metrics[i] = strings.TrimSpace(metrics[i])
-		}
-
-		includeZones := r.URL.Query().Get("include_zones") == "true"
-
-		var hrZones *strava.HeartRateZones
-		if includeZones {
-			zones, err := strava.FetchHeartRateZones(s.token)
-			if err == nil && zones != nil {
-				hrZones = &zones.HeartRate
-			}
-		}
-
-		var graphData *pggeo.GraphData
-		err := s.withDB(func(conn *pgx.Conn) error {
-			var dbErr error
-			graphData, dbErr = pggeo.GetGraphDataForActivity(s.ctx, conn, s.user.ID, activityID, metrics, includeZones, hrZones)
-			return dbErr
-		})
-		if err != nil {
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-		writeJSON(w, graphData)
-		return
-	}
-
-	// Handle points endpoint
-	if len(parts) == 2 && parts[1] == "points" {
-		var samples []pggeo.PointSample
-		err := s.withDB(func(conn *pgx.Conn) error {
-			var dbErr error
-			samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, s.user.ID, activityID)
-			return dbErr
-		})
-		if err != nil {
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-		writeJSON(w, samples)
-		return
-	}
-
-	http.NotFound(w, r)
+	return strava.FetchGear(s.ctx, accessToken, gearID)
 }
 
 func (s *server) handleHRZones(w http.ResponseWriter, r *http.Request) {
-	if s.token == "" {
-		if cookie, err := r.Cookie(stravaTokenCookieName); err == nil {
-			s.token = cookie.Value
-		}
-	}
-	if s.token == "" {
-		http.Error(w, "not authorized", http.StatusUnauthorized)
+	scope := s.webSessionFromRequest(r)
+	if scope.StravaToken == "" {
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "not authorized"))
 		return
 	}
-	zones, err := strava.FetchHeartRateZones(s.token)
-	if err != nil {
+	zones, err := s.athleteHRZones(r.Context(), scope)
+	if err != nil || zones == nil {
 		// Some athletes may not have HR zones configured or API could deny access.
 		// Return empty zones with 200 so the UI can degrade gracefully.
-		log.Printf("hr zones fetch error: %v", err)
+		if err != nil {
+			slog.Warn("Failed to fetch HR zones", "error", err)
+		}
 		writeJSON(w, &strava.AthleteZones{HeartRate: strava.HeartRateZones{Zones: []strava.HRZone{}}})
 		return
 	}
-	writeJSON(w, zones)
-}
-
-func (s *server) handleStravaCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "missing code", http.StatusBadRequest)
-		return
-	}
-
-	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.StravaRedirectURI)
-	tok, err := strava.ExchangeCodeForToken(*authCfg, code)
-	if err != nil {
-		log.Printf("❌ Token exchange error: %v", err)
-		log.Printf("💡 Check that your Strava app's redirect URI matches: %s", s.cfg.StravaRedirectURI)
-		http.Error(w, "Strava login could not be completed. Check the server logs for details.", http.StatusBadGateway)
-		return
-	}
-	s.token = tok
-
-	// #nosec G124 -- local HTTP needs an insecure cookie; production HTTPS requests set Secure.
-	http.SetCookie(w, &http.Cookie{
-		Name:     stravaTokenCookieName,
-		Value:    tok,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   s.secureCookies(r),
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   60 * 60 * 24 * 30, // 30 days
-	})
-
-	// Preload athlete profile for header display
-	if a, err := strava.FetchCurrentAthlete(s.token); err == nil {
-		s.user = a
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_, _ = w.Write([]byte("<html><body><h3>Strava authorized ✅</h3><p>You can close this tab.</p><p><a href='/'>&larr; Back to activities</a></p></body></html>"))
-}
-
-func (s *server) handleStravaLogout(w http.ResponseWriter, r *http.Request) {
-	// Clear the token from memory
-	s.token = ""
-
-	// #nosec G124 -- local HTTP needs an insecure cookie; production HTTPS requests set Secure.
-	http.SetCookie(w, &http.Cookie{
-		Name:     stravaTokenCookieName,
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   s.secureCookies(r),
-		SameSite: http.SameSiteStrictMode,
-		MaxAge:   -1, // Expire immediately
-	})
-
-	// Redirect to home page
-	http.Redirect(w, r, "/", http.StatusFound)
+	writeJSON(w, &strava.AthleteZones{HeartRate: *zones})
 }
 
 func writeJSON(w http.ResponseWriter, v interface{}) {
@@ -1223,15 +990,19 @@ func (s *server) handleDiscoveredPage(w http.ResponseWriter, r *http.Request) {
 	data := struct {
 		Athlete                        *strava.Athlete
 		ShowLoginCTA                   bool
+		Anonymous                      bool
 		Authorized                     bool
 		DiscoveredMapEnabled           bool
+		Announcements                  []pggeo.Announcement
 		DiscoveredRevealRadiusMeters   float64
 		DiscoveredSampleDistanceMeters float64
 	}{
 		Athlete:                        scope.Athlete,
 		ShowLoginCTA:                   scope.StravaToken == "" && s.cfg.StravaClientID != "",
+		Anonymous:                      scope.Anonymous,
 		Authorized:                     scope.StravaToken != "",
 		DiscoveredMapEnabled:           s.cfg.DiscoveredMapEnabled,
+		Announcements:                  s.pageAnnouncements(r, scope),
 		DiscoveredRevealRadiusMeters:   s.cfg.DiscoveredRevealRadiusMeters,
 		DiscoveredSampleDistanceMeters: s.cfg.DiscoveredSampleDistanceMeters,
 	}
@@ -1244,7 +1015,7 @@ func (s *server) handleDiscoveredPage(w http.ResponseWriter, r *http.Request) {
 
 func (s *server) handleDiscoveredAPI(w http.ResponseWriter, r *http.Request) {
 	if !s.cfg.DiscoveredMapEnabled {
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 		return
 	}
 	scope, ok := s.webScopeFromRequest(w, r)
@@ -1256,7 +1027,7 @@ func (s *server) handleDiscoveredAPI(w http.ResponseWriter, r *http.Request) {
 	switch action {
 	case "status":
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		status, err := s.discoveredCoverageStatus(scope.AthleteID)
@@ -1267,7 +1038,7 @@ func (s *server) handleDiscoveredAPI(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, status)
 	case "rebuild":
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		status, err := s.rebuildDiscoveredCoverage(scope.AthleteID)
@@ -1278,12 +1049,12 @@ func (s *server) handleDiscoveredAPI(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, status)
 	case "fog":
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		minLng, minLat, maxLng, maxLat, ok := parseBBox(r.URL.Query().Get("bbox"))
 		if !ok {
-			http.Error(w, "bbox must be minLng,minLat,maxLng,maxLat", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusBadRequest, "bbox must be minLng,minLat,maxLng,maxLat"))
 			return
 		}
 		featureCollection, err := s.discoveredFogFeatureCollection(scope.AthleteID, minLng, minLat, maxLng, maxLat)
@@ -1295,12 +1066,12 @@ func (s *server) handleDiscoveredAPI(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(featureCollection))
 	case "coverage":
 		if r.Method != http.MethodGet {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
 			return
 		}
 		minLng, minLat, maxLng, maxLat, ok := parseBBox(r.URL.Query().Get("bbox"))
 		if !ok {
-			http.Error(w, "bbox must be minLng,minLat,maxLng,maxLat", http.StatusBadRequest)
+			writeError(w, r, newAPIError(http.StatusBadRequest, "bbox must be minLng,minLat,maxLng,maxLat"))
 			return
 		}
 		featureCollection, err := s.discoveredCoverageFeatureCollection(scope.AthleteID, minLng, minLat, maxLng, maxLat)
@@ -1311,7 +1082,7 @@ func (s *server) handleDiscoveredAPI(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		_, _ = w.Write([]byte(featureCollection))
 	default:
-		http.NotFound(w, r)
+		writeError(w, r, newAPIError(http.StatusNotFound, "not found"))
 	}
 }
 
@@ -1340,445 +1111,6 @@ func parseBBox(raw string) (float64, float64, float64, float64, bool) {
 	return values[0], values[1], values[2], values[3], true
 }
 
-// handleSegmentsAPI handles GET /api/segments and POST /api/segments
-func (s *server) handleSegmentsAPI(w http.ResponseWriter, r *http.Request) {
-	scope, ok := s.webScopeFromRequest(w, r)
-	if !ok {
-		return
-	}
-
-	switch r.Method {
-	case "GET":
-		segments, err := s.listFavoriteSegments(scope.AthleteID)
-		if err != nil {
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-		writeJSON(w, segments)
-	case "POST":
-		var req struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			ActivityID  int64  `json:"activity_id"`
-			StartIndex  int    `json:"start_index"`
-			EndIndex    int    `json:"end_index"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
-
-		if req.Name == "" {
-			http.Error(w, "name is required", http.StatusBadRequest)
-			return
-		}
-		if req.StartIndex < 0 || req.EndIndex < 0 || req.StartIndex >= req.EndIndex {
-			http.Error(w, "invalid start_index or end_index", http.StatusBadRequest)
-			return
-		}
-
-		segment, err := s.createFavoriteSegmentFromActivityRange(scope.AthleteID, req.ActivityID, req.Name, req.Description, req.StartIndex, req.EndIndex)
-		if err != nil {
-			if errors.Is(err, errSegmentIndexOutOfRange) {
-				http.Error(w, "index out of range", http.StatusBadRequest)
-				return
-			}
-			if errors.Is(err, errActivitySamplesMissing) {
-				s.handleDBPageError(w, r, err, http.StatusNotFound)
-				return
-			}
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-
-		writeJSON(w, segment)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-// handleSegmentAPI handles GET /api/segments/:id and DELETE /api/segments/:id
-func (s *server) handleSegmentAPI(w http.ResponseWriter, r *http.Request) {
-	// Extract segment ID from path
-	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/segments/"), "/")
-	if len(parts) == 0 || parts[0] == "" {
-		http.Error(w, "segment ID required", http.StatusBadRequest)
-		return
-	}
-
-	segmentID, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		http.Error(w, "invalid segment ID", http.StatusBadRequest)
-		return
-	}
-
-	scope, ok := s.webScopeFromRequest(w, r)
-	if !ok {
-		return
-	}
-
-	segment, err := s.getOwnedFavoriteSegment(scope.AthleteID, segmentID)
-	if err != nil {
-		if errors.Is(err, errForbidden) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		log.Printf("❌ Failed to load segment %d: %v", segmentID, err)
-		s.handleDBPageError(w, r, err, http.StatusNotFound)
-		return
-	}
-
-	switch r.Method {
-	case "GET":
-		// Handle GET /api/segments/:id/graph
-		if len(parts) == 2 && parts[1] == "graph" {
-			activityIDStr := r.URL.Query().Get("activity_id")
-			if activityIDStr == "" {
-				http.Error(w, "activity_id parameter required", http.StatusBadRequest)
-				return
-			}
-			activityID, err := strconv.ParseInt(activityIDStr, 10, 64)
-			if err != nil {
-				http.Error(w, "invalid activity_id", http.StatusBadRequest)
-				return
-			}
-
-			metricsStr := r.URL.Query().Get("metrics")
-			if metricsStr == "" {
-				http.Error(w, "metrics parameter required", http.StatusBadRequest)
-				return
-			}
-			metrics := strings.Split(metricsStr, ",")
-			for i := range metrics {
-				metrics[i] = strings.TrimSpace(metrics[i])
-			}
-
-			includeZones := r.URL.Query().Get("include_zones") == "true"
-
-			var hrZones *strava.HeartRateZones
-			if includeZones {
-				zones, err := strava.FetchHeartRateZones(scope.StravaToken)
-				if err == nil && zones != nil {
-					hrZones = &zones.HeartRate
-				}
-			}
-
-			var graphData *pggeo.GraphData
-			err = s.withDB(func(conn *pgx.Conn) error {
-				var dbErr error
-				graphData, dbErr = pggeo.GetGraphDataForSegmentInActivity(s.ctx, conn, scope.AthleteID, activityID, segmentID, metrics, includeZones, hrZones)
-				return dbErr
-			})
-			if err != nil {
-				log.Printf("❌ Failed to load segment graph data for segment %d activity %d: %v", segmentID, activityID, err)
-				s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, graphData)
-			return
-		}
-		// Handle GET /api/segments/:id/metrics
-		if len(parts) == 2 && parts[1] == "metrics" {
-			query := `SELECT * FROM get_segment_metrics($1)`
-			var distanceM, elevationGainM float64
-			err := s.withDB(func(conn *pgx.Conn) error {
-				return conn.QueryRow(s.ctx, query, segmentID).Scan(&distanceM, &elevationGainM)
-			})
-			if err != nil {
-				s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, map[string]float64{
-				"distance":       distanceM,
-				"elevation_gain": elevationGainM,
-			})
-			return
-		}
-		// Handle GET /api/segments/:id/activity/:activityId/indices
-		if len(parts) == 4 && parts[1] == "activity" && parts[3] == "indices" {
-			activityID, err := strconv.ParseInt(parts[2], 10, 64)
-			if err != nil {
-				http.Error(w, "invalid activity ID", http.StatusBadRequest)
-				return
-			}
-			tolerance := 15.0
-			if tolStr := r.URL.Query().Get("tolerance"); tolStr != "" {
-				if tol, err := strconv.ParseFloat(tolStr, 64); err == nil {
-					tolerance = tol
-				}
-			}
-
-			// Check cache first (with mutex)
-			var cached *pggeo.SegmentActivityCacheEntry
-			err = s.withDB(func(conn *pgx.Conn) error {
-				var dbErr error
-				cached, dbErr = pggeo.GetCachedSegmentActivityMetrics(s.ctx, conn, segmentID, activityID, tolerance)
-				return dbErr
-			})
-			if err == nil && cached != nil && cached.StartIndex != nil && cached.EndIndex != nil {
-				writeJSON(w, map[string]int{
-					"start_index": *cached.StartIndex,
-					"end_index":   *cached.EndIndex,
-				})
-				return
-			}
-
-			// Calculate if not cached (with mutex)
-			query := `SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`
-			var startIndex, endIndex int
-			err = s.withDB(func(conn *pgx.Conn) error {
-				return conn.QueryRow(s.ctx, query, segmentID, activityID, scope.AthleteID, tolerance).Scan(&startIndex, &endIndex)
-			})
-			if err != nil {
-				s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-				return
-			}
-
-			// Cache the result (metrics will be cached separately)
-			writeJSON(w, map[string]int{
-				"start_index": startIndex,
-				"end_index":   endIndex,
-			})
-			return
-		}
-		// Handle GET /api/segments/:id/activity/:activityId/metrics
-		if len(parts) == 4 && parts[1] == "activity" && parts[3] == "metrics" {
-			activityID, err := strconv.ParseInt(parts[2], 10, 64)
-			if err != nil {
-				http.Error(w, "invalid activity ID", http.StatusBadRequest)
-				return
-			}
-			tolerance := 15.0
-			if tolStr := r.URL.Query().Get("tolerance"); tolStr != "" {
-				if tol, err := strconv.ParseFloat(tolStr, 64); err == nil {
-					tolerance = tol
-				}
-			}
-
-			// Check cache first (with mutex)
-			var cached *pggeo.SegmentActivityCacheEntry
-			err = s.withDB(func(conn *pgx.Conn) error {
-				var dbErr error
-				cached, dbErr = pggeo.GetCachedSegmentActivityMetrics(s.ctx, conn, segmentID, activityID, tolerance)
-				return dbErr
-			})
-			if err == nil && cached != nil && cached.AvgHR != nil && cached.AvgSpeed != nil {
-				distance := 0.0
-				if cached.DistanceM != nil {
-					distance = *cached.DistanceM
-				}
-				elevationGain := 0.0
-				if cached.ElevationGainM != nil {
-					elevationGain = *cached.ElevationGainM
-				}
-				elapsedSeconds := 0.0
-				if cached.ElapsedSeconds != nil {
-					elapsedSeconds = *cached.ElapsedSeconds
-				}
-				writeJSON(w, map[string]float64{
-					"avg_hr":          *cached.AvgHR,
-					"avg_speed":       *cached.AvgSpeed,
-					"distance":        distance,
-					"elevation_gain":  elevationGain,
-					"elapsed_seconds": elapsedSeconds,
-				})
-				return
-			}
-
-			// Calculate if not cached (with mutex)
-			query := `SELECT * FROM get_activity_segment_metrics($1, $2, $3, $4)`
-			var avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds float64
-			err = s.withDB(func(conn *pgx.Conn) error {
-				return conn.QueryRow(s.ctx, query, segmentID, activityID, scope.AthleteID, tolerance).Scan(&avgHR, &avgSpeed, &distanceM, &elevationGainM, &elapsedSeconds)
-			})
-			if err != nil {
-				// If no rows returned (no matching points), return zeros
-				writeJSON(w, map[string]float64{
-					"avg_hr":          0,
-					"avg_speed":       0,
-					"distance":        0,
-					"elevation_gain":  0,
-					"elapsed_seconds": 0,
-				})
-				return
-			}
-
-			// Get indices for caching (with mutex)
-			var startIndex, endIndex int
-			idxQuery := `SELECT * FROM find_segment_point_indices($1, $2, $3, $4)`
-			_ = s.withDB(func(conn *pgx.Conn) error {
-				if err := conn.QueryRow(s.ctx, idxQuery, segmentID, activityID, scope.AthleteID, tolerance).Scan(&startIndex, &endIndex); err != nil {
-					return err
-				}
-				return pggeo.CacheSegmentActivityMetrics(s.ctx, conn, segmentID, activityID, tolerance, startIndex, endIndex, avgHR, avgSpeed, distanceM, elevationGainM, elapsedSeconds)
-			})
-
-			writeJSON(w, map[string]float64{
-				"avg_hr":          avgHR,
-				"avg_speed":       avgSpeed,
-				"distance":        distanceM,
-				"elevation_gain":  elevationGainM,
-				"elapsed_seconds": elapsedSeconds,
-			})
-			return
-		}
-		// Handle GET /api/segments/:id/activities
-		if len(parts) == 2 && parts[1] == "activities" {
-			// Parse query parameters
-			tolerance := 15.0 // default
-			if tolStr := r.URL.Query().Get("tolerance"); tolStr != "" {
-				if tol, err := strconv.ParseFloat(tolStr, 64); err == nil {
-					tolerance = tol
-				}
-			}
-			forceRefresh := r.URL.Query().Get("refresh") == "true"
-			sortBy := r.URL.Query().Get("sort")
-			if sortBy == "" {
-				sortBy = "distance" // default
-			}
-
-			var activities []pggeo.ActivityWithMatch
-			err := s.withDB(func(conn *pgx.Conn) error {
-				var dbErr error
-				activities, dbErr = pggeo.GetActivitiesForSegment(s.ctx, conn, scope.AthleteID, segmentID, tolerance, sortBy, forceRefresh)
-				return dbErr
-			})
-			if err != nil {
-				log.Printf("❌ Failed to load activities for segment %d: %v", segmentID, err)
-				s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-				return
-			}
-			if scope.StravaToken != "" {
-				if zones, err := strava.FetchHeartRateZones(scope.StravaToken); err == nil && zones != nil {
-					for i := range activities {
-						activityID := activities[i].ID
-						zoneErr := s.withDB(func(conn *pgx.Conn) error {
-							var dbErr error
-							activities[i].SegmentHRZones, dbErr = pggeo.GetHRZoneDistributionForSegmentInActivity(s.ctx, conn, scope.AthleteID, activityID, segmentID, tolerance, &zones.HeartRate)
-							return dbErr
-						})
-						if zoneErr != nil {
-							log.Printf("⚠️ Failed to calculate segment HR zones for segment %d activity %d: %v", segmentID, activityID, zoneErr)
-						}
-					}
-				} else if err != nil {
-					log.Printf("⚠️ Failed to fetch HR zones for segment efforts: %v", err)
-				}
-			}
-			writeJSON(w, activities)
-			return
-		}
-		// Regular GET /api/segments/:id
-		if len(parts) != 1 {
-			http.NotFound(w, r)
-			return
-		}
-		writeJSON(w, segment)
-	case "DELETE":
-		if len(parts) != 1 {
-			http.NotFound(w, r)
-			return
-		}
-		err = s.withDB(func(conn *pgx.Conn) error {
-			return pggeo.DeleteFavoriteSegment(s.ctx, conn, segmentID)
-		})
-		if err != nil {
-			log.Printf("❌ Failed to delete segment %d: %v", segmentID, err)
-			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-			return
-		}
-
-		w.WriteHeader(http.StatusNoContent)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
-}
-
-// handleSegmentsPage handles GET /segments - renders the segments list page
-func (s *server) handleSegmentsPage(w http.ResponseWriter, r *http.Request) {
-	scope, ok := s.webScopeFromRequest(w, r)
-	if !ok {
-		return
-	}
-
-	segments, err := s.listSegmentDashboardSummaries(scope.AthleteID, 15.0)
-	if err != nil {
-		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
-		return
-	}
-
-	data := struct {
-		Segments             []pggeo.SegmentDashboardSummary
-		Athlete              *strava.Athlete
-		ShowLoginCTA         bool
-		Authorized           bool
-		DiscoveredMapEnabled bool
-	}{
-		Segments:             segments,
-		Athlete:              scope.Athlete,
-		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
-		Authorized:           scope.StravaToken != "",
-		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
-	}
-
-	if err := s.executeTemplate(w, "segments.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
-// handleSegmentPage handles GET /segment/:id - renders the segment detail page
-func (s *server) handleSegmentPage(w http.ResponseWriter, r *http.Request) {
-	// Extract segment ID from path
-	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/segment/"), "/")
-	if len(parts) == 0 || parts[0] == "" {
-		http.Error(w, "segment ID required", http.StatusBadRequest)
-		return
-	}
-
-	segmentID, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		http.Error(w, "invalid segment ID", http.StatusBadRequest)
-		return
-	}
-
-	scope, ok := s.webScopeFromRequest(w, r)
-	if !ok {
-		return
-	}
-
-	segment, err := s.getOwnedFavoriteSegment(scope.AthleteID, segmentID)
-	if err != nil {
-		if errors.Is(err, errForbidden) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		s.handleDBPageError(w, r, err, http.StatusNotFound)
-		return
-	}
-
-	data := struct {
-		Segment              *pggeo.FavoriteSegment
-		Athlete              *strava.Athlete
-		ShowLoginCTA         bool
-		Authorized           bool
-		MobileActivityOrder  string
-		DiscoveredMapEnabled bool
-	}{
-		Segment:              segment,
-		Athlete:              scope.Athlete,
-		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
-		Authorized:           scope.StravaToken != "",
-		MobileActivityOrder:  s.cfg.MobileActivityOrder,
-		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
-	}
-
-	if err := s.executeTemplate(w, "segment.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-}
-
 type profileBikeStat struct {
 	GearID     string  `json:"gear_id"`
 	Label      string  `json:"label"`
@@ -1797,19 +1129,21 @@ type profileHRZone struct {
 }
 
 type profileData struct {
-	Athlete              *strava.Athlete   `json:"athlete"`
-	ShowLoginCTA         bool              `json:"show_login_cta"`
-	Authorized           bool              `json:"authorized"`
-	HRZones              []profileHRZone   `json:"hr_zones"`
-	HRZonesError         string            `json:"hr_zones_error,omitempty"`
-	TotalBikeKM          float64           `json:"total_bike_km"`
-	TotalActivities      int               `json:"total_activities"`
-	BikeStats            []profileBikeStat `json:"bike_stats"`
-	BestMonth            profilePeriodStat `json:"best_month"`
-	BestYear             profilePeriodStat `json:"best_year"`
-	HasRecordedRides     bool              `json:"has_recorded_rides"`
-	HasRecordedMonths    bool              `json:"has_recorded_months"`
-	DiscoveredMapEnabled bool              `json:"discovered_map_enabled"`
+	Athlete              *strava.Athlete      `json:"athlete"`
+	ShowLoginCTA         bool                 `json:"show_login_cta"`
+	Anonymous            bool                 `json:"anonymous"`
+	Authorized           bool                 `json:"authorized"`
+	HRZones              []profileHRZone      `json:"hr_zones"`
+	HRZonesError         string               `json:"hr_zones_error,omitempty"`
+	TotalBikeKM          float64              `json:"total_bike_km"`
+	TotalActivities      int                  `json:"total_activities"`
+	BikeStats            []profileBikeStat    `json:"bike_stats"`
+	BestMonth            profilePeriodStat    `json:"best_month"`
+	BestYear             profilePeriodStat    `json:"best_year"`
+	HasRecordedRides     bool                 `json:"has_recorded_rides"`
+	HasRecordedMonths    bool                 `json:"has_recorded_months"`
+	DiscoveredMapEnabled bool                 `json:"discovered_map_enabled"`
+	Announcements        []pggeo.Announcement `json:"announcements,omitempty"`
 }
 
 func (s *server) handleProfilePage(w http.ResponseWriter, r *http.Request) {
@@ -1822,6 +1156,7 @@ func (s *server) handleProfilePage(w http.ResponseWriter, r *http.Request) {
 		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	data.Announcements = s.pageAnnouncements(r, scope)
 
 	if err := s.executeTemplate(w, "profile.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1835,7 +1170,7 @@ func (s *server) buildProfileData(scope athleteScope) (profileData, error) {
 	}
 
 	var activities []strava.ActivitySummary
-	err := s.withDB(func(conn *pgx.Conn) error {
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
 		var dbErr error
 		activities, dbErr = pggeo.GetAllActivities(s.ctx, conn, scope.AthleteID)
 		return dbErr
@@ -1843,15 +1178,16 @@ func (s *server) buildProfileData(scope athleteScope) (profileData, error) {
 	if err != nil {
 		return profileData{}, err
 	}
-	activities = s.enrichGearNames(activities)
+	activities = s.enrichGearNames(scope, activities)
 
-	zones, zonesError := buildProfileHRZones(scope.StravaToken)
+	zones, zonesError := buildProfileHRZones(s.athleteHRZones(s.ctx, scope))
 	bikeStats, totalBikeKM := buildBikeStats(activities)
 	bestMonth, bestYear := findBusiestPeriods(activities)
 
 	return profileData{
 		Athlete:              scope.Athlete,
 		ShowLoginCTA:         scope.StravaToken == "" && s.cfg.StravaClientID != "",
+		Anonymous:            scope.Anonymous,
 		Authorized:           scope.StravaToken != "",
 		HRZones:              zones,
 		HRZonesError:         zonesError,
@@ -1866,23 +1202,20 @@ func (s *server) buildProfileData(scope athleteScope) (profileData, error) {
 	}, nil
 }
 
-func buildProfileHRZones(token string) ([]profileHRZone, string) {
+func buildProfileHRZones(athleteZones *strava.HeartRateZones, err error) ([]profileHRZone, string) {
+	if err != nil {
+		return nil, err.Error()
+	}
 	var zones []profileHRZone
-	var zonesError string
-	if token != "" {
-		athleteZones, err := strava.FetchHeartRateZones(token)
-		if err != nil {
-			zonesError = err.Error()
-		} else if athleteZones != nil {
-			for i, zone := range athleteZones.HeartRate.Zones {
-				zones = append(zones, profileHRZone{
-					Label: fmt.Sprintf("Z%d", i+1),
-					Range: formatHRZoneRange(zone),
-				})
-			}
+	if athleteZones != nil {
+		for i, zone := range athleteZones.Zones {
+			zones = append(zones, profileHRZone{
+				Label: fmt.Sprintf("Z%d", i+1),
+				Range: formatHRZoneRange(zone),
+			})
 		}
 	}
-	return zones, zonesError
+	return zones, ""
 }
 
 func buildBikeStats(activities []strava.ActivitySummary) ([]profileBikeStat, float64) {
@@ -0,0 +1,326 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"b11k/internal/cache"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// webSessionTouchInterval is how stale a session's last_used_at may get; a session is
+	// written at most once per interval however many requests it makes
+	webSessionTouchInterval = 5 * time.Minute
+	// webSessionFlushInterval is how often recorded session use is written
+	webSessionFlushInterval  = time.Minute
+	webSessionTouchCacheSize = 4096
+	webSessionUserAgentMax   = 120
+	// webSessionSweepInterval is how often logins past webSessionLifetime that were not
+	// used again are deleted
+	webSessionSweepInterval = time.Hour
+)
+
+// webSessionUse is a session's latest use waiting to be written to web_sessions
+type webSessionUse struct {
+	userAgent string
+	usedAt    time.Time
+}
+
+// webSessionTouches throttles and batches last_used_at updates. The zero value is ready to use.
+type webSessionTouches struct {
+	mu       sync.Mutex
+	recorded *cache.LRU[string, time.Time] // last use queued per session key
+	pending  map[string]webSessionUse
+}
+
+// record queues a session's use unless one was queued within webSessionTouchInterval
+func (t *webSessionTouches) record(tokenKey, userAgent string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recorded == nil {
+		t.recorded = cache.NewLRU[string, time.Time](webSessionTouchCacheSize)
+	}
+	if last, ok := t.recorded.Get(tokenKey); ok && now.Sub(last) < webSessionTouchInterval {
+		return false
+	}
+	t.recorded.Add(tokenKey, now)
+	if t.pending == nil {
+		t.pending = make(map[string]webSessionUse)
+	}
+	t.pending[tokenKey] = webSessionUse{userAgent: truncateUserAgent(userAgent), usedAt: now}
+	return true
+}
+
+func (t *webSessionTouches) take() map[string]webSessionUse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.pending = nil
+	return pending
+}
+
+// forget drops a revoked session so its queued use cannot be written back
+func (t *webSessionTouches) forget(tokenKey string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recorded != nil {
+		t.recorded.Remove(tokenKey)
+	}
+	delete(t.pending, tokenKey)
+}
+
+func truncateUserAgent(userAgent string) string {
+	userAgent = strings.TrimSpace(userAgent)
+	if utf8.RuneCountInString(userAgent) <= webSessionUserAgentMax {
+		return userAgent
+	}
+	runes := []rune(userAgent)
+	return string(runes[:webSessionUserAgentMax-1]) + "…"
+}
+
+// runWebSessionTouches writes recorded session use until the server context ends
+func (s *server) runWebSessionTouches() {
+	ticker := time.NewTicker(webSessionFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushWebSessionTouches()
+		}
+	}
+}
+
+// runExpiredWebSessionSweep deletes the web logins past webSessionLifetime every
+// webSessionSweepInterval until the server context ends. Logins used again are deleted
+// on that use already.
+func (s *server) runExpiredWebSessionSweep() {
+	ticker := time.NewTicker(webSessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		s.deleteExpiredWebSessions(time.Now())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *server) deleteExpiredWebSessions(now time.Time) {
+	var deleted int64
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		deleted, dbErr = pggeo.DeleteWebLoginsCreatedBefore(s.ctx, conn, webSessionKeyPrefix, now.Add(-webSessionLifetime))
+		return dbErr
+	})
+	if err != nil {
+		slog.Warn("Failed to delete expired web sessions", "error", err)
+		return
+	}
+	if deleted > 0 {
+		slog.Info("Deleted expired web sessions", "deleted", deleted)
+	}
+}
+
+// flushWebSessionTouches writes every queued session use
+func (s *server) flushWebSessionTouches() {
+	for tokenKey, use := range s.sessionTouches.take() {
+		var err error
+		if s.touchSession != nil {
+			err = s.touchSession(tokenKey, use.userAgent, use.usedAt)
+		} else {
+			err = s.withDB(func(conn *pgxpool.Pool) error {
+				return pggeo.TouchWebSession(s.ctx, conn, tokenKey, use.userAgent, use.usedAt)
+			})
+		}
+		if err != nil {
+			slog.Warn("Failed to record web session use", "error", err)
+		}
+	}
+}
+
+// webSessionInfo is a session as listed by GET /api/sessions and the settings page
+type webSessionInfo struct {
+	ID         int64     `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"` // the session making the request
+}
+
+// listWebSessions returns the athlete's sessions with their token keys, after writing
+// queued use so the list is current
+func (s *server) listWebSessions(athleteID int64) ([]pggeo.WebSession, error) {
+	s.flushWebSessionTouches()
+	if s.listSessions != nil {
+		return s.listSessions(athleteID)
+	}
+	var sessions []pggeo.WebSession
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		sessions, dbErr = pggeo.ListWebSessions(s.ctx, conn, athleteID)
+		return dbErr
+	})
+	return sessions, err
+}
+
+func webSessionInfos(sessions []pggeo.WebSession, currentKey string) []webSessionInfo {
+	infos := make([]webSessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, webSessionInfo{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Current:    currentKey != "" && session.TokenKey == currentKey,
+		})
+	}
+	return infos
+}
+
+// currentWebSessionKey returns the storage key of the request's session, or ""
+func currentWebSessionKey(r *http.Request) string {
+	if sessionID := webSessionIDFromRequest(r); sessionID != "" {
+		return webSessionStorageKey(sessionID)
+	}
+	return ""
+}
+
+// revokeWebSession deletes one of the athlete's sessions and its tokens and drops the
+// cached login, so the session's next request is rejected. It returns the revoked
+// session's key, or "" when the athlete has no such session.
+func (s *server) revokeWebSession(athleteID, sessionID int64) (string, error) {
+	var tokenKey string
+	var err error
+	if s.revokeSession != nil {
+		tokenKey, err = s.revokeSession(athleteID, sessionID)
+	} else {
+		err = s.withDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			tokenKey, dbErr = pggeo.RevokeWebSession(s.ctx, conn, athleteID, sessionID)
+			return dbErr
+		})
+	}
+	if err != nil || tokenKey == "" {
+		return "", err
+	}
+	s.webAthletes.forget(tokenKey)
+	s.sessionTouches.forget(tokenKey)
+	return tokenKey, nil
+}
+
+// handleSessionsAPI lists the caller's web sessions (GET /api/sessions) and revokes one
+// (DELETE /api/sessions/{id}). Revoking the current session needs ?confirm=current and
+// logs the caller out.
+func (s *server) handleSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	currentKey := currentWebSessionKey(r)
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions"), "/")
+
+	if rest == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+			return
+		}
+		sessions, err := s.listWebSessions(scope.AthleteID)
+		if err != nil {
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"sessions": webSessionInfos(sessions, currentKey)})
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	sessionID, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || sessionID <= 0 {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid session id"))
+		return
+	}
+	sessions, err := s.listWebSessions(scope.AthleteID)
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	var target *pggeo.WebSession
+	for i := range sessions {
+		if sessions[i].ID == sessionID {
+			target = &sessions[i]
+		}
+	}
+	if target == nil {
+		writeError(w, r, newAPIError(http.StatusNotFound, "session not found"))
+		return
+	}
+	current := target.TokenKey == currentKey
+	if current && r.URL.Query().Get("confirm") != "current" {
+		writeError(w, r, newAPIError(http.StatusConflict, "this is the current session; add ?confirm=current to log out"))
+		return
+	}
+
+	tokenKey, err := s.revokeWebSession(scope.AthleteID, sessionID)
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if tokenKey == "" {
+		writeError(w, r, newAPIError(http.StatusNotFound, "session not found"))
+		return
+	}
+	slog.Info("Web session revoked", "athlete_id", scope.AthleteID, "session_id", sessionID)
+	if current {
+		s.expireCookie(w, r, webSessionCookieName)
+	}
+	writeJSON(w, map[string]interface{}{"revoked": sessionID, "current": current})
+}
+
+// settingsPageData feeds settings.html
+type settingsPageData struct {
+	Athlete              *strava.Athlete
+	ShowLoginCTA         bool
+	Anonymous            bool
+	Authorized           bool
+	DiscoveredMapEnabled bool
+	Announcements        []pggeo.Announcement
+	Sessions             []webSessionInfo
+	SessionsError        string
+}
+
+func (s *server) handleSettingsPage(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	data := settingsPageData{
+		Athlete:              scope.Athlete,
+		Authorized:           scope.StravaToken != "",
+		DiscoveredMapEnabled: s.cfg.DiscoveredMapEnabled,
+		Announcements:        s.pageAnnouncements(r, scope),
+	}
+	sessions, err := s.listWebSessions(scope.AthleteID)
+	if err != nil {
+		slog.Warn("Failed to list web sessions", "athlete_id", scope.AthleteID, "error", err)
+		data.SessionsError = "Sessions are unavailable right now"
+	}
+	data.Sessions = webSessionInfos(sessions, currentWebSessionKey(r))
+	if err := s.executeTemplate(w, "settings.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// fakeWebSessions stands in for web_sessions
+type fakeWebSessions struct {
+	mu      sync.Mutex
+	rows    map[int64]pggeo.WebSession
+	touches []string
+}
+
+func newSessionsTestServer(t *testing.T, rows ...pggeo.WebSession) (*server, *fakeWebSessions) {
+	withShortDBRetryBackoff(t)
+	fake := &fakeWebSessions{rows: make(map[int64]pggeo.WebSession)}
+	for _, row := range rows {
+		fake.rows[row.ID] = row
+	}
+	// Sessions missing from the cache cannot be loaded
+	s := &server{ctx: context.Background(), pool: unreachablePool(t)}
+	s.listSessions = func(athleteID int64) ([]pggeo.WebSession, error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		var sessions []pggeo.WebSession
+		for _, row := range fake.rows {
+			if row.AthleteID == athleteID {
+				sessions = append(sessions, row)
+			}
+		}
+		return sessions, nil
+	}
+	s.revokeSession = func(athleteID, sessionID int64) (string, error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		row, ok := fake.rows[sessionID]
+		if !ok || row.AthleteID != athleteID {
+			return "", nil
+		}
+		delete(fake.rows, sessionID)
+		return row.TokenKey, nil
+	}
+	s.touchSession = func(tokenKey, _ string, _ time.Time) error {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.touches = append(fake.touches, tokenKey)
+		return nil
+	}
+	return s, fake
+}
+
+func sessionRequest(s *server, method, path, sessionID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: sessionID})
+	rec := httptest.NewRecorder()
+	s.handleSessionsAPI(rec, req)
+	return rec
+}
+
+func TestRevokedSessionIsRejectedOnNextRequest(t *testing.T) {
+	s, _ := newSessionsTestServer(t,
+		pggeo.WebSession{ID: 1, TokenKey: webSessionStorageKey("sess-laptop"), AthleteID: 7, UserAgent: "Laptop"},
+		pggeo.WebSession{ID: 2, TokenKey: webSessionStorageKey("sess-phone"), AthleteID: 7, UserAgent: "Phone"},
+		pggeo.WebSession{ID: 3, TokenKey: webSessionStorageKey("sess-other"), AthleteID: 8},
+	)
+	s.cacheWebAthlete("sess-laptop", &strava.Athlete{ID: 7})
+	s.cacheWebAthlete("sess-phone", &strava.Athlete{ID: 7})
+
+	rec := sessionRequest(s, http.MethodGet, "/api/sessions", "sess-laptop")
+	var listed struct {
+		Sessions []webSessionInfo `json:"sessions"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &listed) != nil || len(listed.Sessions) != 2 {
+		t.Fatalf("list = %d %q, want the athlete's 2 sessions", rec.Code, rec.Body.String())
+	}
+	for _, session := range listed.Sessions {
+		if session.Current != (session.ID == 1) {
+			t.Fatalf("session %d current = %v", session.ID, session.Current)
+		}
+	}
+
+	if rec := sessionRequest(s, http.MethodDelete, "/api/sessions/3", "sess-laptop"); rec.Code != http.StatusNotFound {
+		t.Fatalf("revoking another athlete's session = %d, want 404", rec.Code)
+	}
+	if rec := sessionRequest(s, http.MethodDelete, "/api/sessions/2", "sess-laptop"); rec.Code != http.StatusOK {
+		t.Fatalf("revoke = %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := sessionRequest(s, http.MethodGet, "/api/sessions", "sess-phone"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked session's next request = %d, want 401", rec.Code)
+	}
+	if rec := sessionRequest(s, http.MethodGet, "/api/sessions", "sess-laptop"); rec.Code != http.StatusOK {
+		t.Fatalf("remaining session = %d, want 200", rec.Code)
+	}
+}
+
+func TestCurrentSessionNeedsConfirmation(t *testing.T) {
+	s, fake := newSessionsTestServer(t, pggeo.WebSession{ID: 1, TokenKey: webSessionStorageKey("sess-laptop"), AthleteID: 7})
+	s.cacheWebAthlete("sess-laptop", &strava.Athlete{ID: 7})
+
+	if rec := sessionRequest(s, http.MethodDelete, "/api/sessions/1", "sess-laptop"); rec.Code != http.StatusConflict {
+		t.Fatalf("revoking the current session unconfirmed = %d, want 409", rec.Code)
+	}
+	if len(fake.rows) != 1 {
+		t.Fatal("an unconfirmed self-revocation deleted the session")
+	}
+
+	rec := sessionRequest(s, http.MethodDelete, "/api/sessions/1?confirm=current", "sess-laptop")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirmed = %d %q", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != webSessionCookieName || cookies[0].MaxAge >= 0 {
+		t.Fatalf("cookies = %+v, want the session cookie expired", cookies)
+	}
+	if rec := sessionRequest(s, http.MethodGet, "/api/sessions", "sess-laptop"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("after logging itself out = %d, want 401", rec.Code)
+	}
+}
+
+func TestSessionUseIsWrittenAtMostOncePerInterval(t *testing.T) {
+	s, fake := newSessionsTestServer(t)
+	s.cacheWebAthlete("sess-laptop", &strava.Athlete{ID: 7})
+	key := webSessionStorageKey("sess-laptop")
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "sess-laptop"})
+		if scope := s.webSessionFromRequest(req); scope.AthleteID != 7 {
+			t.Fatalf("scope = %+v", scope)
+		}
+	}
+	s.flushWebSessionTouches()
+	s.flushWebSessionTouches()
+	if len(fake.touches) != 1 || fake.touches[0] != key {
+		t.Fatalf("touches = %v, want one write for five requests", fake.touches)
+	}
+
+	now := time.Now()
+	if s.sessionTouches.record(key, "Laptop", now.Add(time.Minute)) {
+		t.Fatal("a use a minute later was queued")
+	}
+	if !s.sessionTouches.record(key, "Laptop", now.Add(webSessionTouchInterval+time.Second)) {
+		t.Fatal("a use after the interval was not queued")
+	}
+	s.flushWebSessionTouches()
+	if len(fake.touches) != 2 {
+		t.Fatalf("touches = %v, want a second write after the interval", fake.touches)
+	}
+
+	// A revoked session's queued use is never written back
+	s.sessionTouches.record(key, "Laptop", now.Add(2*webSessionTouchInterval))
+	s.sessionTouches.forget(key)
+	s.flushWebSessionTouches()
+	if len(fake.touches) != 2 {
+		t.Fatalf("touches = %v, revoked session written", fake.touches)
+	}
+}
@@ -0,0 +1,179 @@
+package web
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// shareTokenSize is the random bytes of a share token, 256 bits
+	shareTokenSize = 32
+	// maxShareTokenLifetime caps expires_in
+	maxShareTokenLifetime = 5 * 365 * 24 * time.Hour
+	// shareTokenSweepInterval is how often expired share tokens and old share views are
+	// deleted
+	shareTokenSweepInterval = time.Hour
+)
+
+// Kinds of share token listed by GET /api/shares
+const shareKindStats = "stats"
+
+// newShareToken returns a random token to hand out and the key it is stored under. Only
+// the key reaches the database, so reading the table does not give working tokens.
+func newShareToken() (token, tokenKey string, err error) {
+	token, err = randomURLToken(shareTokenSize)
+	if err != nil {
+		return "", "", err
+	}
+	return token, shareTokenKey(token), nil
+}
+
+// shareTokenKey is the stored form of a share token; lookups hash the token they are given
+func shareTokenKey(token string) string {
+	return mobileSessionStorageKey(token)
+}
+
+// shareLimitsRequest holds the optional limits of a share token creation request
+type shareLimitsRequest struct {
+	// ExpiresIn is the token's lifetime in seconds
+	ExpiresIn *int64 `json:"expires_in"`
+	MaxViews  *int64 `json:"max_views"`
+}
+
+// limits validates the request and returns its limits counted from now
+func (req shareLimitsRequest) limits(now time.Time) (pggeo.ShareLimits, error) {
+	var limits pggeo.ShareLimits
+	if req.ExpiresIn != nil {
+		lifetime := time.Duration(*req.ExpiresIn) * time.Second
+		if *req.ExpiresIn <= 0 || lifetime > maxShareTokenLifetime {
+			return limits, fmt.Errorf("expires_in must be between 1 and %d seconds", int64(maxShareTokenLifetime/time.Second))
+		}
+		expiresAt := now.Add(lifetime)
+		limits.ExpiresAt = &expiresAt
+	}
+	if req.MaxViews != nil {
+		if *req.MaxViews <= 0 {
+			return limits, fmt.Errorf("max_views must be positive")
+		}
+		maxViews := *req.MaxViews
+		limits.MaxViews = &maxViews
+	}
+	return limits, nil
+}
+
+// shareListing is one of the caller's share tokens in GET /api/shares
+type shareListing struct {
+	Kind      string     `json:"kind"`
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxViews  *int64     `json:"max_views"`
+	Views     int64      `json:"views"`
+	// RemainingViews is nil without max_views
+	RemainingViews *int64 `json:"remaining_views"`
+	// Gone is set once the token expired or ran out of views
+	Gone bool `json:"gone"`
+}
+
+func newShareListing(kind string, token pggeo.PublicStatsToken, now time.Time) shareListing {
+	listing := shareListing{
+		Kind:      kind,
+		ID:        token.ID,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		MaxViews:  token.MaxViews,
+		Views:     token.Views,
+		Gone:      token.ExpiresAt != nil && !token.ExpiresAt.After(now),
+	}
+	if token.MaxViews != nil {
+		remaining := max(*token.MaxViews-token.Views, 0)
+		listing.RemainingViews = &remaining
+		listing.Gone = listing.Gone || remaining == 0
+	}
+	return listing
+}
+
+// handleShares lists (GET /api/shares) the caller's share tokens of every kind with
+// their use against their limits. The tokens themselves are never listed: only their
+// hashes are stored.
+func (s *server) handleShares(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	var statsTokens []pggeo.PublicStatsToken
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		statsTokens, dbErr = pggeo.ListPublicStatsTokens(s.ctx, conn, scope.AthleteID)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	shares := make([]shareListing, 0, len(statsTokens))
+	for _, token := range statsTokens {
+		shares = append(shares, newShareListing(shareKindStats, token, now))
+	}
+	writeJSON(w, map[string]interface{}{"shares": shares})
+}
+
+// writeShareGone answers a request with an expired or used up share token: a page of its
+// own for browsers, an error with code "gone" for everything else
+func (s *server) writeShareGone(w http.ResponseWriter, r *http.Request) {
+	if s.tmpl != nil && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusGone)
+		if err := s.executeTemplate(w, "share_gone.html", nil); err != nil {
+			slog.Error("Failed to render the share gone page", "error", err)
+		}
+		return
+	}
+	writeError(w, r, newAPIError(http.StatusGone, "This share link has expired or reached its view limit"))
+}
+
+// runShareTokenSweep deletes expired share tokens and share views past their retention
+// until the server context ends
+func (s *server) runShareTokenSweep() {
+	ticker := time.NewTicker(shareTokenSweepInterval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		s.sweepExpiredShareTokens(now)
+		s.pruneShareViews(now)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *server) sweepExpiredShareTokens(now time.Time) {
+	var deleted int64
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		deleted, dbErr = pggeo.DeleteExpiredPublicStatsTokens(s.ctx, conn, now)
+		return dbErr
+	})
+	if err != nil {
+		slog.Warn("Failed to delete expired share links", "error", err)
+		return
+	}
+	if deleted > 0 {
+		slog.Info("Deleted expired share links", "deleted", deleted)
+	}
+}
@@ -0,0 +1,193 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// fakeStatsTokens stands in for public_stats_tokens, counting views like
+// pggeo.UsePublicStatsToken
+type fakeStatsTokens struct {
+	mu   sync.Mutex
+	rows map[string]*pggeo.PublicStatsToken
+}
+
+func newShareTestServer(t *testing.T) (*server, *fakeStatsTokens) {
+	withShortDBRetryBackoff(t)
+	fake := &fakeStatsTokens{rows: make(map[string]*pggeo.PublicStatsToken)}
+	s := &server{ctx: context.Background(), pool: unreachablePool(t)}
+	s.cacheWebAthlete("sess-7", &strava.Athlete{ID: 7})
+	s.createStatsToken = func(athleteID int64, tokenKey string, fields []string, limits pggeo.ShareLimits) (*pggeo.PublicStatsToken, error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		row := &pggeo.PublicStatsToken{
+			ID: int64(len(fake.rows) + 1), AthleteID: athleteID, Fields: fields,
+			CreatedAt: time.Now(), ExpiresAt: limits.ExpiresAt, MaxViews: limits.MaxViews,
+		}
+		fake.rows[tokenKey] = row
+		created := *row
+		return &created, nil
+	}
+	s.useStatsToken = func(tokenKey string, now time.Time) (*pggeo.PublicStatsToken, error) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		row, ok := fake.rows[tokenKey]
+		switch {
+		case !ok:
+			return nil, nil
+		case row.ExpiresAt != nil && !row.ExpiresAt.After(now),
+			row.MaxViews != nil && row.Views >= *row.MaxViews:
+			return nil, pggeo.ErrShareTokenGone
+		}
+		row.Views++
+		used := *row
+		return &used, nil
+	}
+	return s, fake
+}
+
+// createStatsToken creates a token through the API and caches stats for it, so serving
+// it needs no database
+func createStatsToken(t *testing.T, s *server, body string) publicStatsTokenResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/public-stats-token", strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "sess-7"})
+	rec := httptest.NewRecorder()
+	s.handlePublicStatsToken(rec, req)
+	var created publicStatsTokenResponse
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &created) != nil {
+		t.Fatalf("create = %d %q", rec.Code, rec.Body.String())
+	}
+	s.publicStats.put(shareTokenKey(created.Token), publicStatsEntry{
+		athleteID: 7, tokenID: created.ID, body: []byte(`{"rides":3}`), expiresAt: time.Now().Add(time.Hour),
+	}, s.publicStats.currentGeneration())
+	return created
+}
+
+func TestShareTokensAreStoredOnlyAsHashes(t *testing.T) {
+	s, fake := newShareTestServer(t)
+	created := createStatsToken(t, s, `{}`)
+
+	raw, err := base64.RawURLEncoding.DecodeString(created.Token)
+	if err != nil || len(raw) != 32 {
+		t.Fatalf("token %q is %d bytes, %v; want 256 random bits", created.Token, len(raw), err)
+	}
+	if len(fake.rows) != 1 {
+		t.Fatalf("stored %d rows, want 1", len(fake.rows))
+	}
+	for tokenKey := range fake.rows {
+		if tokenKey != shareTokenKey(created.Token) || strings.Contains(tokenKey, created.Token) {
+			t.Fatalf("stored key %q, want only the hash of the token", tokenKey)
+		}
+		// Whoever reads the table holds keys, which do not work as tokens
+		if rec := getPublicStats(s, http.MethodGet, tokenKey); rec.Code != http.StatusNotFound {
+			t.Fatalf("the stored key as a token = %d, want 404", rec.Code)
+		}
+	}
+	if rec := getPublicStats(s, http.MethodGet, created.Token); rec.Code != http.StatusOK {
+		t.Fatalf("the token = %d, want 200", rec.Code)
+	}
+}
+
+func TestExpiredShareTokenIsGone(t *testing.T) {
+	s, fake := newShareTestServer(t)
+	for _, body := range []string{`{"expires_in": 0}`, `{"expires_in": -5}`, `{"max_views": 0}`, `{"expires_in": 999999999999}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/public-stats-token", strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "sess-7"})
+		rec := httptest.NewRecorder()
+		s.handlePublicStatsToken(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("create with %s = %d, want 400", body, rec.Code)
+		}
+	}
+
+	created := createStatsToken(t, s, `{"expires_in": 3600}`)
+	if created.ExpiresAt == nil || time.Until(*created.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("expires_at = %v, want an hour from now", created.ExpiresAt)
+	}
+	if rec := getPublicStats(s, http.MethodGet, created.Token); rec.Code != http.StatusOK {
+		t.Fatalf("before expiry = %d, want 200", rec.Code)
+	}
+
+	// The stats are still cached, yet the expired token is refused
+	past := time.Now().Add(-time.Second)
+	fake.rows[shareTokenKey(created.Token)].ExpiresAt = &past
+	rec := getPublicStats(s, http.MethodGet, created.Token)
+	if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), "expired") {
+		t.Fatalf("after expiry = %d %q, want 410", rec.Code, rec.Body.String())
+	}
+
+	// Browsers get a page of its own
+	t.Chdir(filepath.Join("..", ".."))
+	tmpl, err := parseTemplates("")
+	if err != nil {
+		t.Fatalf("parseTemplates: %v", err)
+	}
+	s.tmpl = tmpl
+	req := httptest.NewRequest(http.MethodGet, "/public/stats/"+created.Token, nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec = httptest.NewRecorder()
+	s.handlePublicStats(rec, req)
+	if rec.Code != http.StatusGone || !strings.Contains(rec.Body.String(), "no longer available") {
+		t.Fatalf("browser after expiry = %d %q, want the gone page", rec.Code, rec.Body.String())
+	}
+}
+
+func TestShareTokenViewsUnderConcurrentRequests(t *testing.T) {
+	s, _ := newShareTestServer(t)
+	created := createStatsToken(t, s, `{"max_views": 5}`)
+
+	const requests = 40
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- getPublicStats(s, http.MethodGet, created.Token).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 5 || counts[http.StatusGone] != requests-5 {
+		t.Fatalf("status counts = %v, want 5 served and the rest gone", counts)
+	}
+}
+
+func TestShareListingShowsUseAgainstLimits(t *testing.T) {
+	now := time.Now()
+	maxViews, expired := int64(10), now.Add(-time.Minute)
+	listing := newShareListing(shareKindStats, pggeo.PublicStatsToken{ID: 1, MaxViews: &maxViews, Views: 4}, now)
+	if listing.RemainingViews == nil || *listing.RemainingViews != 6 || listing.Gone {
+		t.Fatalf("listing = %+v, want 6 views left", listing)
+	}
+	listing = newShareListing(shareKindStats, pggeo.PublicStatsToken{ID: 2, MaxViews: &maxViews, Views: 10}, now)
+	if *listing.RemainingViews != 0 || !listing.Gone {
+		t.Fatalf("used up listing = %+v, want gone", listing)
+	}
+	listing = newShareListing(shareKindStats, pggeo.PublicStatsToken{ID: 3, ExpiresAt: &expired, Views: 2}, now)
+	if listing.RemainingViews != nil || !listing.Gone {
+		t.Fatalf("expired listing = %+v, want gone without a view limit", listing)
+	}
+
+	body, _ := json.Marshal(newShareListing(shareKindStats, pggeo.PublicStatsToken{ID: 4}, now))
+	if !bytes.Contains(body, []byte(`"kind":"stats"`)) || !bytes.Contains(body, []byte(`"remaining_views":null`)) {
+		t.Fatalf("unlimited listing = %s", body)
+	}
+}
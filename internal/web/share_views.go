@@ -0,0 +1,148 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"b11k/internal/digest"
+	"b11k/internal/pggeo"
+	"b11k/internal/queue"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// shareViewQueueSize bounds views waiting to be written; past it the oldest is dropped
+	shareViewQueueSize = 1024
+	// defaultShareViewRetention is used when Config.ShareViewRetention is zero
+	defaultShareViewRetention = 90 * 24 * time.Hour
+)
+
+// botUserAgentMarkers identify crawlers, link previews and scripts by their user agent,
+// lower case
+var botUserAgentMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "facebookexternalhit", "headless",
+	"curl/", "wget/", "python-requests", "go-http-client", "okhttp", "java/",
+}
+
+// isBotUserAgent reports whether a view came from a crawler or script rather than a
+// person; an empty user agent counts as a bot
+func isBotUserAgent(userAgent string) bool {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		return true
+	}
+	for _, marker := range botUserAgentMarkers {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// newShareViewQueue returns the queue share views are written through, which drops its
+// oldest view when the database falls behind, counting the drops in collector
+func newShareViewQueue(collector *digest.Collector) *queue.Queue[pggeo.ShareView] {
+	views, _ := queue.New("share_views", queue.Options[pggeo.ShareView]{
+		Capacity: shareViewQueueSize,
+		Policy:   queue.DropOldest,
+		OnDrop: func(view pggeo.ShareView) {
+			collector.Warn(digestShareViews, "dropped", "Share view queue full, dropping view", "link_id", view.TokenID)
+		},
+	})
+	return views
+}
+
+// queueShareView records a view of a share token in the background. It never blocks and
+// never fails, so the public page answers the same whatever happens to the view.
+func (s *server) queueShareView(r *http.Request, tokenID int64, now time.Time) {
+	if s.shareViews == nil {
+		return
+	}
+	userAgent := r.UserAgent()
+	view := pggeo.ShareView{
+		TokenID:   tokenID,
+		ViewedAt:  now,
+		UserAgent: truncateUserAgent(userAgent),
+		Country:   s.geoIP.country(clientIP(r)),
+		Bot:       isBotUserAgent(userAgent),
+	}
+	_ = s.shareViews.Push(s.ctx, view)
+}
+
+// runShareViewWriter writes queued share views one at a time until the server context ends
+func (s *server) runShareViewWriter() {
+	for {
+		item, ok := s.shareViews.Pop(s.ctx)
+		if !ok {
+			return
+		}
+		err := s.saveShareView(item.Value)
+		s.shareViews.Done(item)
+		if err != nil {
+			s.digest.Warn(digestShareViews, "failed", "Failed to record share view", "link_id", item.Value.TokenID, "error", err)
+			continue
+		}
+		s.digest.Add(digestShareViews, "recorded", 1)
+	}
+}
+
+func (s *server) saveShareView(view pggeo.ShareView) error {
+	if s.writeShareView != nil {
+		return s.writeShareView(view)
+	}
+	return s.withDB(func(conn *pgxpool.Pool) error {
+		return pggeo.RecordShareView(s.ctx, conn, view)
+	})
+}
+
+// pruneShareViews deletes the share views older than the configured retention
+func (s *server) pruneShareViews(now time.Time) {
+	retention := s.cfg.ShareViewRetention
+	if retention <= 0 {
+		retention = defaultShareViewRetention
+	}
+	var deleted int64
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		deleted, dbErr = pggeo.DeleteShareViewsBefore(s.ctx, conn, now.Add(-retention))
+		return dbErr
+	})
+	if err != nil {
+		s.digest.Warn(digestShareViews, "failed", "Failed to prune share views", "error", err)
+		return
+	}
+	s.digest.Add(digestShareViews, "pruned", uint64(deleted))
+}
+
+// handleShareStats handles GET /api/shares/{id}/stats: the views of one of the caller's
+// share links still kept, with bots counted apart
+func (s *server) handleShareStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	tokenID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || tokenID <= 0 {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "invalid share id"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var stats *pggeo.ShareViewStats
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		stats, dbErr = pggeo.GetShareViewStats(s.ctx, conn, scope.AthleteID, tokenID)
+		return dbErr
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, stats)
+}
@@ -0,0 +1,159 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+)
+
+// shareViewServer serves "tok-a" of athlete 7 from the public stats cache and queues its
+// views, with write handling each view the writer takes off the queue
+func shareViewServer(t *testing.T, write func(view pggeo.ShareView) error) *server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &server{ctx: ctx, shareViews: newShareViewQueue(nil), writeShareView: write}
+	issuedStatsTokens(s, 7, "tok-a")
+	s.publicStats.put(shareTokenKey("tok-a"), publicStatsEntry{
+		athleteID: 7, tokenID: 1, body: []byte(`{"rides":3}`), expiresAt: time.Now().Add(time.Hour),
+	}, s.publicStats.currentGeneration())
+	return s
+}
+
+func TestShareViewsAreWrittenInTheBackground(t *testing.T) {
+	written := make(chan pggeo.ShareView, 2)
+	s := shareViewServer(t, func(view pggeo.ShareView) error {
+		written <- view
+		return nil
+	})
+	geoIP, err := parseGeoIPTable(strings.NewReader("# start,end,country\n192.0.2.0,192.0.2.255,de\n2001:db8::,2001:db8::ffff,fr\n"))
+	if err != nil {
+		t.Fatalf("parseGeoIPTable: %v", err)
+	}
+	s.geoIP = geoIP
+	go s.runShareViewWriter()
+
+	for _, ua := range []string{"Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0", "Mozilla/5.0 (compatible; Googlebot/2.1)"} {
+		req := httptest.NewRequest(http.MethodGet, "/public/stats/tok-a", nil)
+		req.RemoteAddr = "192.0.2.10:4321"
+		req.Header.Set("User-Agent", ua)
+		rec := httptest.NewRecorder()
+		s.handlePublicStats(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"rides":3}` {
+			t.Fatalf("status %d, body %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	for _, wantBot := range []bool{false, true} {
+		select {
+		case view := <-written:
+			if view.TokenID != 1 || view.Country != "DE" || view.Bot != wantBot || view.ViewedAt.IsZero() {
+				t.Fatalf("view = %+v, want link 1 from DE, bot %v", view, wantBot)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("share view was never written")
+		}
+	}
+}
+
+func TestFailingShareViewWriteNeverAffectsThePublicPage(t *testing.T) {
+	release := make(chan struct{})
+	attempts := make(chan struct{}, 10)
+	s := shareViewServer(t, func(pggeo.ShareView) error {
+		attempts <- struct{}{}
+		<-release
+		return errors.New("database down")
+	})
+	go s.runShareViewWriter()
+	defer close(release)
+
+	// The writer is stuck on the first view and then fails every one; the page answers
+	// at once regardless
+	for i := 0; i < 3; i++ {
+		done := make(chan int, 1)
+		go func() {
+			done <- getPublicStats(s, http.MethodGet, "tok-a").Code
+		}()
+		select {
+		case code := <-done:
+			if code != http.StatusOK {
+				t.Fatalf("request %d answered %d while the view write failed", i, code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d waited on the view write", i)
+		}
+	}
+	select {
+	case <-attempts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the writer never took a view")
+	}
+	if stats := s.shareViews.Stats(); stats.Enqueued != 3 {
+		t.Fatalf("queue stats = %+v, want 3 views queued", stats)
+	}
+}
+
+func TestShareViewQueueDropsOldestViews(t *testing.T) {
+	views := newShareViewQueue(nil)
+	for i := 0; i < shareViewQueueSize+5; i++ {
+		if err := views.Push(context.Background(), pggeo.ShareView{TokenID: int64(i)}); err != nil {
+			t.Fatalf("Push: %v", err)
+		}
+	}
+	stats := views.Stats()
+	if stats.Depth != shareViewQueueSize || stats.Dropped != 5 {
+		t.Fatalf("stats = %+v, want a full queue and 5 dropped", stats)
+	}
+	if item, _ := views.TryPop(); item.Value.TokenID != 5 {
+		t.Fatalf("oldest view kept = %d, want 5", item.Value.TokenID)
+	}
+}
+
+func TestIsBotUserAgent(t *testing.T) {
+	for ua, want := range map[string]bool{
+		"":                                      true,
+		"Mozilla/5.0 (compatible; bingbot/2.0)": true,
+		"Slackbot-LinkExpanding 1.0":            true,
+		"facebookexternalhit/1.1":               true,
+		"curl/8.5.0":                            true,
+		"Mozilla/5.0 (iPhone) Safari/604.1":     false,
+		"Mozilla/5.0 (Windows NT 10.0) Chrome/126.0": false,
+	} {
+		if got := isBotUserAgent(ua); got != want {
+			t.Errorf("isBotUserAgent(%q) = %v, want %v", ua, got, want)
+		}
+	}
+}
+
+func TestGeoIPTableLooksUpCountries(t *testing.T) {
+	table, err := parseGeoIPTable(strings.NewReader("10.0.0.0,10.0.0.255,NL\n1.0.0.0,1.0.0.255,AU\n2001:db8::,2001:db8::ff,JP\n"))
+	if err != nil {
+		t.Fatalf("parseGeoIPTable: %v", err)
+	}
+	for ip, want := range map[string]string{
+		"1.0.0.7":         "AU",
+		"10.0.0.255":      "NL",
+		"10.0.1.0":        "",
+		"::ffff:10.0.0.1": "NL",
+		"2001:db8::10":    "JP",
+		"2001:db8::1:0":   "",
+		"not an address":  "",
+		"0.255.255.255":   "",
+	} {
+		if got := table.country(ip); got != want {
+			t.Errorf("country(%q) = %q, want %q", ip, got, want)
+		}
+	}
+	if (*geoIPTable)(nil).country("1.0.0.7") != "" {
+		t.Error("a nil table found a country")
+	}
+	if _, err := parseGeoIPTable(strings.NewReader("10.0.0.9,10.0.0.1,NL\n")); err == nil {
+		t.Error("a reversed range was accepted")
+	}
+}
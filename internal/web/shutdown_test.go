@@ -0,0 +1,71 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newShutdownTestServer(grace time.Duration) *server {
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	return &server{
+		ctx:      context.Background(),
+		jobsCtx:  jobsCtx,
+		stopJobs: stopJobs,
+		cfg:      Config{ShutdownGracePeriod: grace},
+	}
+}
+
+func TestShutdownCancelsJobsAndWaitsForThem(t *testing.T) {
+	s := newShutdownTestServer(5 * time.Second)
+	finished := make(chan struct{})
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		<-s.jobContext().Done()
+		// The activity being saved when the sync was cancelled
+		time.Sleep(50 * time.Millisecond)
+		close(finished)
+	}()
+
+	s.shutdown(&http.Server{})
+	select {
+	case <-finished:
+	default:
+		t.Fatal("shutdown returned before the running job finished")
+	}
+	if s.ctx.Err() != nil {
+		t.Fatal("shutdown cancelled the request context")
+	}
+}
+
+func TestShutdownGivesUpAfterGracePeriod(t *testing.T) {
+	s := newShutdownTestServer(50 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		<-release
+	}()
+
+	started := time.Now()
+	s.shutdown(&http.Server{})
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("shutdown took %s with a stuck job, want about the grace period", elapsed)
+	}
+}
+
+func TestSegmentRefreshStopsAtShutdown(t *testing.T) {
+	withShortDBRetryBackoff(t)
+	s := newShutdownTestServer(time.Second)
+	s.pool = unreachablePool(t)
+	s.stopJobs()
+	job := &segmentRefreshJob{state: segmentRefreshRunning, pending: []int64{1, 2}}
+	s.segmentRefreshes = map[int64]*segmentRefreshJob{1: job}
+	s.runSegmentCacheRefresh(1, job)
+	if job.state != segmentRefreshFailed || len(job.pending) != 0 {
+		t.Fatalf("job = %+v, want it stopped with nothing queued", job)
+	}
+}
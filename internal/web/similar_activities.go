@@ -0,0 +1,58 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// similarActivitiesTolerance parses the optional ?tolerance= of
+// GET /api/activities/{id}/similar, defaulting to pggeo.DefaultSimilarRouteToleranceM
+func similarActivitiesTolerance(r *http.Request) (float64, error) {
+	if strings.TrimSpace(r.URL.Query().Get("tolerance")) == "" {
+		return pggeo.DefaultSimilarRouteToleranceM, nil
+	}
+	tolerance := explicitTolerance(r)
+	if tolerance == nil || !pggeo.ValidToleranceMeters(*tolerance) {
+		return 0, fmt.Errorf("tolerance must be between 0 and %.0f meters", pggeo.MaxSegmentToleranceM)
+	}
+	return *tolerance, nil
+}
+
+// handleActivitySimilar handles GET /api/activities/{id}/similar, the athlete's other
+// rides of the same route with their times, newest first, for the activity page's
+// previous attempts list
+func (s *server) handleActivitySimilar(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	tolerance, err := similarActivitiesTolerance(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	var similar []pggeo.SimilarActivity
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		similar, dbErr = pggeo.FindSimilarActivities(s.ctx, conn, athleteID, activityID, tolerance)
+		return dbErr
+	})
+	if err != nil {
+		if errors.Is(err, pggeo.ErrActivityNotFound) {
+			writeError(w, r, err)
+			return
+		}
+		slog.Error("Failed to find similar activities", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"activity_id": activityID,
+		"tolerance_m": tolerance,
+		"activities":  similar,
+	})
+}
@@ -0,0 +1,41 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/pggeo"
+)
+
+func TestSimilarActivitiesTolerance(t *testing.T) {
+	if tolerance, err := similarActivitiesTolerance(httptest.NewRequest(http.MethodGet, "/api/activities/1/similar", nil)); err != nil || tolerance != pggeo.DefaultSimilarRouteToleranceM {
+		t.Fatalf("default tolerance = %v, %v", tolerance, err)
+	}
+	if tolerance, err := similarActivitiesTolerance(httptest.NewRequest(http.MethodGet, "/api/activities/1/similar?tolerance=50", nil)); err != nil || tolerance != 50 {
+		t.Fatalf("tolerance = %v, %v; want 50", tolerance, err)
+	}
+	for _, query := range []string{"tolerance=0", "tolerance=-5", "tolerance=abc", "tolerance=500"} {
+		if _, err := similarActivitiesTolerance(httptest.NewRequest(http.MethodGet, "/api/activities/1/similar?"+query, nil)); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+}
+
+func TestActivitySimilarNeedsALoginAndAValidTolerance(t *testing.T) {
+	s := newSyncJobTestServer()
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/activities/5/similar", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous status = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/activities/5/similar?tolerance=1000", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status with a wide tolerance = %d, want 400", rec.Code)
+	}
+}
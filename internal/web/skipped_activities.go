@@ -0,0 +1,52 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// handleSkippedActivities handles GET /api/sync/skipped, the activities the sync stopped
+// fetching because Strava answered 404
+func (s *server) handleSkippedActivities(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	var skipped []pggeo.SkippedActivity
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var err error
+		skipped, err = pggeo.ListSkippedActivities(s.ctx, conn, scope.AthleteID)
+		return err
+	})
+	if err != nil {
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, skipped)
+}
+
+// handleSkippedActivityRetry handles DELETE /api/sync/skipped/{id}, which lets the next
+// sync try one of the skipped activities again
+func (s *server) handleSkippedActivityRetry(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	activityID, ok := pathID(w, r, "id", "invalid activity ID")
+	if !ok {
+		return
+	}
+	var deleted bool
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var err error
+		deleted, err = pggeo.DeleteSkippedActivity(s.ctx, conn, scope.AthleteID, activityID)
+		return err
+	})
+	if err != nil {
+		slog.Error("Failed to clear skipped activity", "activity_id", activityID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		writeError(w, r, newAPIError(http.StatusNotFound, "skipped activity not found"))
+		return
+	}
+	slog.Info("Skipped activity will be fetched again by the next sync", "activity_id", activityID, "athlete_id", scope.AthleteID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,181 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"b11k/internal/metrics"
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// softLimitCheckInterval is how often the database is measured against the soft limits
+const softLimitCheckInterval = 15 * time.Minute
+
+// softLimitAnnouncementPrefix starts the warning banner posted while a soft limit is
+// exceeded, so later checks find it again to keep, update or expire it
+const softLimitAnnouncementPrefix = "This instance is running out of storage"
+
+// softLimitLabels name the limits in the banner; its counts would change every check
+var softLimitLabels = map[string]string{
+	pggeo.LimitPointSamples:      "stored GPS points",
+	pggeo.LimitDatabaseSize:      "database size",
+	pggeo.LimitAthleteActivities: "activities per athlete",
+}
+
+// softLimitGauge is the latest soft limit check, served by /api/admin/limits
+type softLimitGauge struct {
+	mu         sync.RWMutex
+	usage      *pggeo.InstanceUsage
+	violations []pggeo.LimitViolation
+	checkedAt  time.Time
+	err        string
+}
+
+func (g *softLimitGauge) set(usage *pggeo.InstanceUsage, violations []pggeo.LimitViolation, checkedAt time.Time, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.usage = usage
+	g.violations = violations
+	g.checkedAt = checkedAt
+	g.err = ""
+	if err != nil {
+		g.err = err.Error()
+	}
+}
+
+func (g *softLimitGauge) snapshot(limits pggeo.SoftLimits) map[string]interface{} {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := map[string]interface{}{"limits": limits, "violations": []pggeo.LimitViolation{}}
+	if !g.checkedAt.IsZero() {
+		out["checked_at"] = g.checkedAt
+	}
+	if g.usage != nil {
+		out["usage"] = g.usage
+	}
+	if len(g.violations) > 0 {
+		out["violations"] = g.violations
+	}
+	if g.err != "" {
+		out["error"] = g.err
+	}
+	return out
+}
+
+// metrics returns each configured limit with the usage last measured against it, none
+// before the first successful check
+func (g *softLimitGauge) metrics(limits pggeo.SoftLimits) []metrics.SoftLimit {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.usage == nil {
+		return nil
+	}
+	var out []metrics.SoftLimit
+	for _, limit := range []metrics.SoftLimit{
+		{Name: pggeo.LimitPointSamples, Value: g.usage.PointSamples, Max: limits.MaxPointSamples},
+		{Name: pggeo.LimitDatabaseSize, Value: g.usage.DatabaseBytes, Max: limits.MaxDatabaseBytes},
+		{Name: pggeo.LimitAthleteActivities, Value: g.usage.TopAthleteActivities, Max: limits.MaxActivitiesPerAthlete},
+	} {
+		if limit.Max > 0 {
+			out = append(out, limit)
+		}
+	}
+	return out
+}
+
+// softLimitBanner is the announcement shown while violations last, empty when there
+// are none
+func softLimitBanner(violations []pggeo.LimitViolation, enforced bool) string {
+	if len(violations) == 0 {
+		return ""
+	}
+	labels := make([]string, len(violations))
+	for i, v := range violations {
+		labels[i] = softLimitLabels[v.Limit]
+	}
+	message := softLimitAnnouncementPrefix + " (" + strings.Join(labels, ", ") + ")."
+	if enforced {
+		message += " Syncs will not fetch new activities until space is freed."
+	}
+	return message
+}
+
+// runSoftLimitChecks measures the database against the configured soft limits every
+// softLimitCheckInterval until the server stops
+func (s *server) runSoftLimitChecks() {
+	ticker := time.NewTicker(softLimitCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.checkSoftLimits(time.Now())
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkSoftLimits measures the database, logs each exceeded limit with what to do about
+// it and keeps the warning banner in step with them
+func (s *server) checkSoftLimits(now time.Time) {
+	usage, err := s.measureInstanceUsage()
+	if err != nil {
+		slog.Warn("Failed to measure database usage for the soft limits", "error", err)
+		s.softLimits.set(nil, nil, now, err)
+		return
+	}
+	violations := s.cfg.Limits.Check(*usage)
+	s.softLimits.set(usage, violations, now, nil)
+	for _, v := range violations {
+		slog.Warn("Soft limit exceeded", "limit", v.Limit, "usage", v.String(), "remediation", v.Remediation)
+	}
+	if err := s.postSoftLimitBanner(softLimitBanner(violations, s.cfg.Limits.Enforce), now); err != nil {
+		slog.Warn("Failed to update the soft limit announcement", "error", err)
+	}
+}
+
+func (s *server) measureInstanceUsage() (*pggeo.InstanceUsage, error) {
+	if s.instanceUsage != nil {
+		return s.instanceUsage()
+	}
+	var usage *pggeo.InstanceUsage
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		usage, dbErr = pggeo.GetInstanceUsage(s.ctx, conn, 0)
+		return dbErr
+	})
+	return usage, err
+}
+
+// postSoftLimitBanner shows message as the soft limit warning, or expires the warning
+// when message is empty
+func (s *server) postSoftLimitBanner(message string, now time.Time) error {
+	if s.limitBanner != nil {
+		return s.limitBanner(message, now)
+	}
+	return s.withDB(func(conn *pgxpool.Pool) error {
+		_, err := pggeo.ReplaceAnnouncement(s.ctx, conn, softLimitAnnouncementPrefix, pggeo.Announcement{
+			Message: message,
+			Level:   pggeo.AnnouncementLevelWarning,
+		}, now)
+		return err
+	})
+}
+
+// handleAdminLimits handles GET /api/admin/limits: the configured soft limits, the usage
+// last measured against them and the limits it exceeded
+func (s *server) handleAdminLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	if _, ok := s.adminScopeFromRequest(w, r); !ok {
+		return
+	}
+	writeJSON(w, s.softLimits.snapshot(s.cfg.Limits))
+}
@@ -0,0 +1,122 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func TestSoftLimitChecksWarnAndClear(t *testing.T) {
+	usage := pggeo.InstanceUsage{PointSamples: 900, DatabaseBytes: 512 << 20, TopAthleteID: 2, TopAthleteActivities: 40}
+	var measureErr error
+	var banners []string
+	s := &server{
+		ctx: context.Background(),
+		cfg: Config{
+			AdminAthleteIDs: []int64{1},
+			Limits:          pggeo.SoftLimits{MaxPointSamples: 1000, MaxDatabaseBytes: 1 << 30, MaxActivitiesPerAthlete: 50, Enforce: true},
+		},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+		instanceUsage: func() (*pggeo.InstanceUsage, error) {
+			current := usage
+			return &current, measureErr
+		},
+		limitBanner: func(message string, _ time.Time) error {
+			banners = append(banners, message)
+			return nil
+		},
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	s.checkSoftLimits(now)
+	if len(banners) != 1 || banners[0] != "" {
+		t.Fatalf("banners under the limits = %q, want one clear", banners)
+	}
+
+	// Each limit crossed in turn shows up in the banner
+	usage.PointSamples = 1200
+	s.checkSoftLimits(now)
+	usage.DatabaseBytes = 2 << 30
+	s.checkSoftLimits(now)
+	usage.TopAthleteActivities = 60
+	s.checkSoftLimits(now)
+	want := []string{
+		"",
+		"This instance is running out of storage (stored GPS points). Syncs will not fetch new activities until space is freed.",
+		"This instance is running out of storage (stored GPS points, database size). Syncs will not fetch new activities until space is freed.",
+		"This instance is running out of storage (stored GPS points, database size, activities per athlete). Syncs will not fetch new activities until space is freed.",
+	}
+	for i := range want {
+		if i >= len(banners) || banners[i] != want[i] {
+			t.Fatalf("banners = %q, want %q", banners, want)
+		}
+	}
+
+	// The gauge reports the last check to admins only
+	h := s.routes()
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/limits", nil)
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: token})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	s.cacheWebAthlete("token-admin", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-rider", &strava.Athlete{ID: 2})
+	if rec := get("token-rider"); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin = %d, want 403", rec.Code)
+	}
+	rec := get("token-admin")
+	var body struct {
+		Usage      pggeo.InstanceUsage    `json:"usage"`
+		Violations []pggeo.LimitViolation `json:"violations"`
+		CheckedAt  time.Time              `json:"checked_at"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("limits = %d %s", rec.Code, rec.Body.String())
+	}
+	if len(body.Violations) != 3 || body.Usage.PointSamples != 1200 || !body.CheckedAt.Equal(now) {
+		t.Fatalf("limits = %s, want all three violations", rec.Body.String())
+	}
+	if body.Violations[2].AthleteID != 2 || !strings.Contains(body.Violations[2].Remediation, "max_activities_per_athlete") {
+		t.Fatalf("athlete violation = %+v", body.Violations[2])
+	}
+
+	// A failed measurement leaves the banner alone and shows up in the gauge
+	measureErr = errors.New("connection refused")
+	s.checkSoftLimits(now.Add(time.Hour))
+	if len(banners) != 4 {
+		t.Fatalf("banners after a failed check = %q", banners)
+	}
+	if rec := get("token-admin"); !strings.Contains(rec.Body.String(), "connection refused") {
+		t.Fatalf("limits after a failed check = %s", rec.Body.String())
+	}
+
+	// Freeing space clears the banner
+	measureErr = nil
+	usage = pggeo.InstanceUsage{PointSamples: 10, DatabaseBytes: 1 << 20, TopAthleteID: 2, TopAthleteActivities: 5}
+	s.checkSoftLimits(now.Add(2 * time.Hour))
+	if banners[len(banners)-1] != "" {
+		t.Fatalf("banner after freeing space = %q, want cleared", banners[len(banners)-1])
+	}
+}
+
+func TestSoftLimitBannerWithoutEnforcement(t *testing.T) {
+	violations := []pggeo.LimitViolation{{Limit: pggeo.LimitDatabaseSize}}
+	got := softLimitBanner(violations, false)
+	if got != "This instance is running out of storage (database size)." {
+		t.Fatalf("banner = %q", got)
+	}
+	if softLimitBanner(nil, true) != "" {
+		t.Fatal("a banner without violations")
+	}
+}
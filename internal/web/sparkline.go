@@ -0,0 +1,88 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Size of the sparkline SVG drawn in activity rows
+const (
+	sparklineWidth  = 80.0
+	sparklineHeight = 16.0
+)
+
+// sparklineMetric returns the ?sparkline= metric, speed by default; "none" disables
+// sparklines and an unknown metric falls back to speed
+func sparklineMetric(r *http.Request) string {
+	metric := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("sparkline")))
+	if metric == "none" {
+		return ""
+	}
+	if !pggeo.ValidSparklineMetric(metric) {
+		return "speed"
+	}
+	return metric
+}
+
+// fillSparklines sets the Sparkline of each activity with one query. Failures are logged
+// and leave the activities without sparklines.
+func (s *server) fillSparklines(athleteID int64, activities []strava.ActivitySummary, metric string) {
+	if metric == "" || len(activities) == 0 {
+		return
+	}
+	ids := make([]int64, len(activities))
+	for i, activity := range activities {
+		ids[i] = activity.ID
+	}
+	var sparklines map[int64][]float64
+	err := s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		sparklines, dbErr = pggeo.GetActivitySparklines(s.ctx, conn, athleteID, ids, metric, pggeo.DefaultSparklinePoints)
+		return dbErr
+	})
+	if err != nil {
+		slog.Warn("Failed to load sparklines", "metric", metric, "error", err)
+		return
+	}
+	for i := range activities {
+		activities[i].Sparkline = sparklines[activities[i].ID]
+	}
+}
+
+// sparklinePoints scales values into an SVG polyline points attribute spanning the
+// sparkline box, lowest value at the bottom
+func sparklinePoints(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	minValue, maxValue := values[0], values[0]
+	for _, v := range values {
+		if v < minValue {
+			minValue = v
+		}
+		if v > maxValue {
+			maxValue = v
+		}
+	}
+	step := 0.0
+	if len(values) > 1 {
+		step = sparklineWidth / float64(len(values)-1)
+	}
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		y := sparklineHeight / 2
+		if maxValue > minValue {
+			y = sparklineHeight - (v-minValue)/(maxValue-minValue)*sparklineHeight
+		}
+		points[i] = strconv.FormatFloat(float64(i)*step, 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+	}
+	return strings.Join(points, " ")
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSparklineMetricQueryParam(t *testing.T) {
+	cases := map[string]string{
+		"/api/activities":                     "speed",
+		"/api/activities?sparkline=heartrate": "heartrate",
+		"/api/activities?sparkline=HeartRate": "heartrate",
+		"/api/activities?sparkline=none":      "",
+		"/api/activities?sparkline=unknown":   "speed",
+	}
+	for target, want := range cases {
+		if got := sparklineMetric(httptest.NewRequest("GET", target, nil)); got != want {
+			t.Errorf("sparklineMetric(%s) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestSparklinePoints(t *testing.T) {
+	if got := sparklinePoints(nil); got != "" {
+		t.Fatalf("sparklinePoints(nil) = %q, want empty", got)
+	}
+	if got, want := sparklinePoints([]float64{1, 3, 2}), "0.0,16.0 40.0,0.0 80.0,8.0"; got != want {
+		t.Fatalf("sparklinePoints = %q, want %q", got, want)
+	}
+	// A flat series is drawn through the middle instead of dividing by zero
+	if got, want := sparklinePoints([]float64{5, 5}), "0.0,8.0 80.0,8.0"; got != want {
+		t.Fatalf("flat sparklinePoints = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,247 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	sseHeartbeatInterval = 20 * time.Second
+	sseWriteTimeout      = 10 * time.Second
+	sseRetryMillis       = 3000
+	syncStreamHistory    = 500
+)
+
+type sseEvent struct {
+	ID    int64
+	Event string
+	Data  string
+}
+
+// sseWriter writes Server-Sent Events to one client. Every write gets its own deadline so
+// a stalled client cannot block the sender for long, and the first failed write marks the
+// client as gone; later writes are dropped instead of erroring invisibly.
+type sseWriter struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	lastSent time.Time
+	gone     atomic.Bool
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	return &sseWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+// clientGone reports whether a write to the client has failed or the request was cancelled.
+func (sw *sseWriter) clientGone() bool {
+	return sw.gone.Load()
+}
+
+// send writes a single event and reports whether the client is still reachable.
+func (sw *sseWriter) send(ev sseEvent) bool {
+	var b strings.Builder
+	if ev.ID > 0 {
+		b.WriteString("id: " + strconv.FormatInt(ev.ID, 10) + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	for _, line := range strings.Split(ev.Data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return sw.write(b.String())
+}
+
+// retry tells the browser how long EventSource should wait before reconnecting.
+func (sw *sseWriter) retry(millis int) bool {
+	return sw.write("retry: " + strconv.Itoa(millis) + "\n\n")
+}
+
+// comment writes an SSE comment line, which clients ignore but proxies count as traffic.
+func (sw *sseWriter) comment(text string) bool {
+	return sw.write(": " + text + "\n\n")
+}
+
+func (sw *sseWriter) write(payload string) bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.gone.Load() {
+		return false
+	}
+	if err := sw.rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		sw.markGone(err)
+		return false
+	}
+	if _, err := sw.w.Write([]byte(payload)); err != nil {
+		sw.markGone(err)
+		return false
+	}
+	if err := sw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		sw.markGone(err)
+		return false
+	}
+	sw.lastSent = time.Now()
+	return true
+}
+
+func (sw *sseWriter) markGone(err error) {
+	if sw.gone.CompareAndSwap(false, true) && err != nil {
+		slog.Warn("SSE client disconnected", "error", err)
+	}
+}
+
+// startHeartbeat sends a comment whenever nothing was written for interval, so proxies
+// that drop idle streams keep the connection open during long sync phases. The client
+// is marked gone when ctx is cancelled. The returned function stops the heartbeat.
+func (sw *sseWriter) startHeartbeat(ctx context.Context, interval time.Duration) func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				sw.markGone(nil)
+				return
+			case <-ticker.C:
+				sw.mu.Lock()
+				idle := time.Since(sw.lastSent)
+				sw.mu.Unlock()
+				if idle >= interval && !sw.comment("heartbeat") {
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(stop) }) }
+}
+
+// syncStream fans the events of one running sync out to every attached SSE client and
+// keeps a bounded history, so a client reconnecting with Last-Event-ID can re-attach to
+// the live progress instead of starting a second sync. Clients are written to outside mu,
+// so a stalled client never holds up the sync job checking on its stream; sendMu keeps
+// each client's events in order.
+type syncStream struct {
+	sendMu   sync.Mutex
+	mu       sync.Mutex
+	nextID   int64
+	history  []sseEvent
+	clients  map[*sseWriter]struct{}
+	done     chan struct{}
+	finished bool
+}
+
+func newSyncStream() *syncStream {
+	return &syncStream{
+		clients: make(map[*sseWriter]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// publish records an event and sends it to every attached client that is still connected.
+func (st *syncStream) publish(event, data string) {
+	st.sendMu.Lock()
+	defer st.sendMu.Unlock()
+
+	st.mu.Lock()
+	st.nextID++
+	ev := sseEvent{ID: st.nextID, Event: event, Data: data}
+	st.history = append(st.history, ev)
+	if len(st.history) > syncStreamHistory {
+		st.history = st.history[len(st.history)-syncStreamHistory:]
+	}
+	clients := make([]*sseWriter, 0, len(st.clients))
+	for client := range st.clients {
+		clients = append(clients, client)
+	}
+	st.mu.Unlock()
+
+	for _, client := range clients {
+		if !client.send(ev) {
+			st.detach(client)
+		}
+	}
+}
+
+// hasClients reports whether any attached client is still connected.
+func (st *syncStream) hasClients() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for client := range st.clients {
+		if client.clientGone() {
+			delete(st.clients, client)
+		}
+	}
+	return len(st.clients) > 0
+}
+
+// attach replays the events after lastEventID and subscribes the client to new ones.
+// A finished stream with nothing left to replay resends its final event so the client
+// learns the outcome instead of reconnecting forever.
+func (st *syncStream) attach(sw *sseWriter, lastEventID int64) {
+	st.sendMu.Lock()
+	defer st.sendMu.Unlock()
+
+	st.mu.Lock()
+	var replay []sseEvent
+	for _, ev := range st.history {
+		if ev.ID > lastEventID {
+			replay = append(replay, ev)
+		}
+	}
+	finished := st.finished
+	if finished && len(replay) == 0 && len(st.history) > 0 {
+		replay = st.history[len(st.history)-1:]
+	}
+	if !finished {
+		st.clients[sw] = struct{}{}
+	}
+	st.mu.Unlock()
+
+	for _, ev := range replay {
+		if !sw.send(ev) {
+			st.detach(sw)
+			return
+		}
+	}
+}
+
+func (st *syncStream) detach(sw *sseWriter) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.clients, sw)
+}
+
+func (st *syncStream) running() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return !st.finished
+}
+
+// finish marks the sync complete and releases every attached client.
+func (st *syncStream) finish() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.finished {
+		return
+	}
+	st.finished = true
+	st.clients = make(map[*sseWriter]struct{})
+	close(st.done)
+}
@@ -0,0 +1,194 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEWriterDetectsClosedClient(t *testing.T) {
+	gone := make(chan bool, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := newSSEWriter(w)
+		stop := sw.startHeartbeat(r.Context(), time.Hour)
+		defer stop()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if !sw.send(sseEvent{Event: "progress", Data: strings.Repeat("x", 1024)}) {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		gone <- sw.clientGone()
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "event: progress\n" {
+		t.Fatalf("first line = %q, want progress event", line)
+	}
+	_ = resp.Body.Close()
+
+	select {
+	case wasGone := <-gone:
+		if !wasGone {
+			t.Fatal("writer did not notice the closed client")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler kept writing to a closed client")
+	}
+}
+
+func TestSSEWriterFormatsEvents(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := newSSEWriter(rec)
+
+	if !sw.send(sseEvent{ID: 7, Event: "log", Data: "line one\nline two"}) {
+		t.Fatal("send failed on a live recorder")
+	}
+
+	want := "id: 7\nevent: log\ndata: line one\ndata: line two\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("body = %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+}
+
+func TestSSEWriterHeartbeatWhenIdle(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := newSSEWriter(rec)
+	stop := sw.startHeartbeat(context.Background(), 20*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	sw.mu.Lock()
+	body := rec.Body.String()
+	sw.mu.Unlock()
+	if !strings.Contains(body, ": heartbeat\n\n") {
+		t.Fatalf("body = %q, want heartbeat comment", body)
+	}
+}
+
+func TestSyncStreamReplaysAfterLastEventID(t *testing.T) {
+	stream := newSyncStream()
+	stream.publish("log", "first")
+	stream.publish("log", "second")
+
+	rec := httptest.NewRecorder()
+	sw := newSSEWriter(rec)
+	stream.attach(sw, 1)
+	stream.publish("log", "third")
+
+	body := rec.Body.String()
+	if strings.Contains(body, "data: first") {
+		t.Fatalf("replayed an event the client already had: %q", body)
+	}
+	if !strings.Contains(body, "id: 2\nevent: log\ndata: second") || !strings.Contains(body, "id: 3\nevent: log\ndata: third") {
+		t.Fatalf("body = %q, want events 2 and 3", body)
+	}
+}
+
+func TestSyncStreamFinishedResendsFinalEvent(t *testing.T) {
+	stream := newSyncStream()
+	stream.publish("summary", "{}")
+	stream.publish("done", "ok")
+	stream.finish()
+
+	rec := httptest.NewRecorder()
+	stream.attach(newSSEWriter(rec), 2)
+
+	if body := rec.Body.String(); !strings.Contains(body, "event: done") {
+		t.Fatalf("body = %q, want final done event", body)
+	}
+	select {
+	case <-stream.done:
+	default:
+		t.Fatal("finished stream did not close done channel")
+	}
+}
+
+func TestSyncStreamDropsGoneClients(t *testing.T) {
+	stream := newSyncStream()
+	sw := newSSEWriter(httptest.NewRecorder())
+	stream.attach(sw, 0)
+	if !stream.hasClients() {
+		t.Fatal("hasClients = false, want true after attach")
+	}
+
+	sw.markGone(nil)
+	stream.publish("progress", "{}")
+	if stream.hasClients() {
+		t.Fatal("hasClients = true, want false after client disconnect")
+	}
+}
+
+// stalledWriter blocks every write until release is closed, like a client that stopped
+// reading
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestSyncStreamStalledClientDoesNotHoldTheStream(t *testing.T) {
+	stream := newSyncStream()
+	stalled := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}, 1), release: make(chan struct{})}
+	stream.attach(newSSEWriter(stalled), 0)
+	healthy := httptest.NewRecorder()
+
+	published := make(chan struct{})
+	go func() {
+		stream.publish("progress", `{"n":1}`)
+		close(published)
+	}()
+	select {
+	case <-stalled.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish never wrote to the client")
+	}
+
+	// The job's checks answer while the write is stuck
+	checked := make(chan bool, 1)
+	go func() {
+		checked <- stream.hasClients() && stream.running()
+	}()
+	select {
+	case ok := <-checked:
+		if !ok {
+			t.Fatal("stream lost its client or stopped running during the write")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("hasClients waited on the stalled write")
+	}
+
+	close(stalled.release)
+	<-published
+	stream.attach(newSSEWriter(healthy), 0)
+	stream.publish("progress", `{"n":2}`)
+	if body := healthy.Body.String(); strings.Index(body, `{"n":1}`) > strings.Index(body, `{"n":2}`) || !strings.Contains(body, `{"n":1}`) {
+		t.Fatalf("late client got %q, want both events in order", body)
+	}
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// statsResponse is GET /api/stats: totals and per-activity averages for the range plus
+// one row per week or month, oldest first, cut in Timezone. ExploredDistanceM is the
+// lifetime length of new roads, whatever the range.
+type statsResponse struct {
+	Start      string              `json:"start,omitempty"`
+	End        string              `json:"end,omitempty"` // inclusive
+	Tag        string              `json:"tag,omitempty"`
+	ExcludeTag string              `json:"exclude_tag,omitempty"`
+	Group      string              `json:"group"`
+	Timezone   string              `json:"timezone"`
+	Totals     pggeo.StatsTotals   `json:"totals"`
+	Averages   pggeo.StatsAverages `json:"averages"`
+	Periods    []pggeo.StatsPeriod `json:"periods"`
+
+	ExploredDistanceM float64 `json:"explored_distance_m"`
+}
+
+// handleStatsAPI handles GET /api/stats?start=2024-01-01&end=2024-12-31&group=week|month&tz=Europe/Berlin,
+// optionally only over the activities with ?tag= or without ?exclude_tag=
+func (s *server) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	start, end, err := dateRangeParams(r)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	filter := pggeo.ActivityFilter{Start: start, End: end}
+	if err := tagFilterParams(r, &filter); err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	group := strings.TrimSpace(r.URL.Query().Get("group"))
+	if group == "" {
+		group = pggeo.StatsGroupMonth
+	}
+	if group != pggeo.StatsGroupWeek && group != pggeo.StatsGroupMonth {
+		writeError(w, r, newAPIError(http.StatusBadRequest, "group must be week or month"))
+		return
+	}
+	zone, err := s.displayZone(r, scope.AthleteID)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	var stats *pggeo.AthleteStats
+	var explored float64
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		if stats, dbErr = pggeo.GetAthleteStats(s.ctx, conn, scope.AthleteID, filter, zone); dbErr != nil {
+			return dbErr
+		}
+		explored, dbErr = pggeo.GetExploredDistance(s.ctx, conn, scope.AthleteID)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to load stats", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := statsResponse{Tag: filter.Tag, ExcludeTag: filter.ExcludeTag, Group: group, Timezone: zone.Name(), Totals: stats.Totals, Averages: stats.Averages, Periods: stats.Months, ExploredDistanceM: explored}
+	if group == pggeo.StatsGroupWeek {
+		response.Periods = stats.Weeks
+	}
+	if !start.IsZero() {
+		response.Start = start.Format(dateParamLayout)
+	}
+	if !end.IsZero() {
+		response.End = end.AddDate(0, 0, -1).Format(dateParamLayout)
+	}
+	writeJSON(w, response)
+}
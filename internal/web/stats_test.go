@@ -0,0 +1,54 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestStatsAPIValidatesBeforeQuerying(t *testing.T) {
+	s := newWebhookTestServer()
+	s.cacheWebAthlete("token", &strava.Athlete{ID: 7})
+	h := s.routes()
+	for target, want := range map[string]int{
+		"/api/stats?group=year":                      http.StatusBadRequest,
+		"/api/stats?start=2024-13-01":                http.StatusBadRequest,
+		"/api/stats?start=2024-12-31&end=2024-01-01": http.StatusBadRequest,
+		"/api/stats?tz=Mars/Olympus_Mons":            http.StatusBadRequest,
+		"/api/stats?exclude_tag=no%20spaces":         http.StatusBadRequest,
+		"/api/training-load?tz=Local":                http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("GET %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("GET /api/stats without login = %d, want 401", rec.Code)
+	}
+}
+
+func TestMeSettingsRejectsUnknownDisplayTimezone(t *testing.T) {
+	s := newWebhookTestServer()
+	s.cacheWebAthlete("token", &strava.Athlete{ID: 7})
+	h := s.routes()
+	for _, body := range []string{`{"display_timezone": "Mars/Olympus_Mons"}`, `{"display_timezone": 2}`} {
+		req := httptest.NewRequest(http.MethodPatch, "/api/me/settings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("PATCH %s = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+	"b11k/internal/sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// stravaWebhookTimeout bounds the Strava calls and database work for one event
+const stravaWebhookTimeout = 5 * time.Minute
+
+// handleStravaWebhook handles /strava/webhook. GET answers Strava's subscription check by
+// echoing hub.challenge when hub.verify_token matches; POST receives an event, which is
+// acknowledged at once (Strava expects a reply within two seconds) and processed in the
+// background.
+func (s *server) handleStravaWebhook(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		if s.cfg.StravaWebhookVerifyToken == "" || q.Get("hub.mode") != "subscribe" ||
+			subtle.ConstantTimeCompare([]byte(q.Get("hub.verify_token")), []byte(s.cfg.StravaWebhookVerifyToken)) != 1 {
+			http.Error(w, "invalid verify token", http.StatusForbidden)
+			return
+		}
+		slog.Info("Strava webhook subscription verified")
+		writeJSON(w, map[string]string{"hub.challenge": q.Get("hub.challenge")})
+	case http.MethodPost:
+		var event strava.WebhookEvent
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&event); err != nil {
+			http.Error(w, "invalid event", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if event.ObjectType != "activity" {
+			return
+		}
+		go s.processStravaWebhookEvent(event)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// processStravaWebhookEvent stores a created or updated activity, or removes a deleted one.
+// Events are not signed, so they are only taken as a hint: the activity is fetched from
+// Strava with the owner's own token, and deleted only once Strava no longer has it.
+// Owners who never logged in here with a stored refresh token are ignored.
+func (s *server) processStravaWebhookEvent(event strava.WebhookEvent) {
+	ctx, cancel := context.WithTimeout(s.ctx, stravaWebhookTimeout)
+	defer cancel()
+
+	accessToken, err := s.athleteAccessToken(event.OwnerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.digest.Record(digestStravaWebhooks, "ignored", "Ignoring Strava webhook event of an unknown athlete", "aspect", event.AspectType, "activity_id", event.ObjectID, "athlete_id", event.OwnerID)
+		return
+	}
+	if err != nil {
+		s.digest.Error(digestStravaWebhooks, "failed", "Failed to get a Strava token", "athlete_id", event.OwnerID, "error", err)
+		return
+	}
+
+	switch event.AspectType {
+	case "create", "update":
+		s.ingestWebhookActivity(ctx, accessToken, event)
+	case "delete":
+		s.deleteWebhookActivity(ctx, accessToken, event)
+	}
+}
+
+func (s *server) ingestWebhookActivity(ctx context.Context, accessToken string, event strava.WebhookEvent) {
+	activity, err := strava.FetchActivity(ctx, accessToken, event.ObjectID)
+	if err != nil {
+		s.digest.Error(digestStravaWebhooks, "failed", "Failed to fetch activity from Strava webhook event", "activity_id", event.ObjectID, "error", err)
+		return
+	}
+	if activity.Summary.AthleteID != event.OwnerID {
+		s.digest.Record(digestStravaWebhooks, "ignored", "Ignoring Strava webhook event for another athlete's activity", "activity_id", event.ObjectID, "owner_id", activity.Summary.AthleteID, "athlete_id", event.OwnerID)
+		return
+	}
+	if !strava.MatchesActivityType(activity.Summary, s.cfg.ActivityTypes) {
+		s.digest.Record(digestStravaWebhooks, "ignored", "Skipping activity not of a synced type", "type", activity.Summary.Type, "activity_id", event.ObjectID)
+		return
+	}
+
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		if err := sync.SaveActivity(ctx, conn, activity, s.cfg.HealGPSSpikes); err != nil {
+			return err
+		}
+		if event.AspectType == "create" {
+			if err := sync.TagActivity(ctx, conn, s.cfg.TagRules, activity); err != nil {
+				slog.Warn("Failed to tag activity", "activity_id", event.ObjectID, "error", err)
+			}
+		}
+		if s.cfg.DiscoveredMapEnabled {
+			if _, err := pggeo.RebuildDiscoveredCoverage(ctx, conn, event.OwnerID, s.cfg.DiscoveredSampleDistanceMeters, s.cfg.DiscoveredRevealRadiusMeters); err != nil {
+				slog.Warn("Failed to rebuild discovered map coverage", "athlete_id", event.OwnerID, "error", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.digest.Error(digestStravaWebhooks, "failed", "Failed to save activity from Strava webhook event", "activity_id", event.ObjectID, "error", err)
+		return
+	}
+	s.activitySaved(&activity.Summary, event.AspectType == "create")
+	s.digest.Record(digestStravaWebhooks, "saved", "Saved activity from Strava webhook event", "activity_id", event.ObjectID, "name", activity.Summary.Name, "aspect", event.AspectType)
+}
+
+func (s *server) deleteWebhookActivity(ctx context.Context, accessToken string, event strava.WebhookEvent) {
+	_, err := strava.FetchActivity(ctx, accessToken, event.ObjectID)
+	switch {
+	case err == nil:
+		s.digest.Record(digestStravaWebhooks, "ignored", "Ignoring Strava webhook delete of an activity Strava still has", "activity_id", event.ObjectID)
+		return
+	case !errors.Is(err, strava.ErrActivityNotFound):
+		s.digest.Error(digestStravaWebhooks, "failed", "Failed to confirm activity deletion with Strava", "activity_id", event.ObjectID, "error", err)
+		return
+	}
+
+	var deleted bool
+	err = s.withDB(func(conn *pgxpool.Pool) error {
+		var err error
+		deleted, err = pggeo.DeleteActivity(ctx, conn, event.OwnerID, event.ObjectID)
+		return err
+	})
+	if err != nil {
+		s.digest.Error(digestStravaWebhooks, "failed", "Failed to delete activity from Strava webhook event", "activity_id", event.ObjectID, "error", err)
+		return
+	}
+	if deleted {
+		s.digest.Record(digestStravaWebhooks, "deleted", "Deleted activity after Strava webhook event", "activity_id", event.ObjectID, "athlete_id", event.OwnerID)
+	}
+}
+
+// athleteAccessToken returns a current Strava access token from the athlete's stored
+// tokens, refreshing it when it is about to expire. It returns pgx.ErrNoRows for athletes
+// without stored tokens.
+func (s *server) athleteAccessToken(athleteID int64) (string, error) {
+	if s.athleteToken != nil {
+		return s.athleteToken(athleteID)
+	}
+	tokenKey, stored, err := s.loadAthleteToken(athleteID)
+	if err != nil {
+		return "", err
+	}
+	if webTokenNeedsRefresh(stored.ExpiresAt, time.Now()) {
+		if stored, err = s.refreshStoredToken(tokenKey, stored); err != nil {
+			return "", err
+		}
+	}
+	return stored.AccessToken, nil
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func newWebhookTestServer() *server {
+	return &server{
+		ctx:        context.Background(),
+		cfg:        Config{StravaWebhookVerifyToken: "verify-me"},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+	}
+}
+
+func TestStravaWebhookEchoesChallenge(t *testing.T) {
+	h := newWebhookTestServer().routes()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/strava/webhook?hub.mode=subscribe&hub.challenge=15f7d1a91c1f40f8&hub.verify_token=verify-me", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"hub.challenge": "15f7d1a91c1f40f8"`) {
+		t.Fatalf("verification = %d %q, want the challenge echoed", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/strava/webhook?hub.mode=subscribe&hub.challenge=15f7d1a91c1f40f8&hub.verify_token=wrong", nil))
+	if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "15f7d1a91c1f40f8") {
+		t.Fatalf("wrong verify token = %d %q, want 403", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStravaWebhookAcknowledgesEvents(t *testing.T) {
+	h := newWebhookTestServer().routes()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/strava/webhook", strings.NewReader(`{"object_type":"athlete","object_id":1,"aspect_type":"update","owner_id":1,"updates":{"authorized":"false"}}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("athlete event = %d, want 200", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/strava/webhook", strings.NewReader(`not json`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("malformed event = %d, want 400", rec.Code)
+	}
+}
+
+func TestStravaWebhookIgnoresUnknownAthletes(t *testing.T) {
+	s := newWebhookTestServer()
+	var asked []int64
+	s.athleteToken = func(athleteID int64) (string, error) {
+		asked = append(asked, athleteID)
+		return "", pgx.ErrNoRows
+	}
+	// Without a pool or Strava, anything past the token lookup would fail loudly
+	for _, aspect := range []string{"create", "update", "delete"} {
+		s.processStravaWebhookEvent(strava.WebhookEvent{ObjectType: "activity", ObjectID: 10, AspectType: aspect, OwnerID: 42})
+	}
+	if len(asked) != 3 || asked[0] != 42 {
+		t.Fatalf("token lookups = %v, want one per event for athlete 42", asked)
+	}
+}
+
+func TestStravaWebhookRejectsEmptyVerifyToken(t *testing.T) {
+	s := newWebhookTestServer()
+	s.cfg.StravaWebhookVerifyToken = ""
+	rec := httptest.NewRecorder()
+	s.handleStravaWebhook(rec, httptest.NewRequest(http.MethodGet, "/strava/webhook?hub.mode=subscribe&hub.challenge=abc&hub.verify_token=", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("empty verify token = %d, want 403", rec.Code)
+	}
+}
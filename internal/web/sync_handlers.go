@@ -0,0 +1,82 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// handleStravaSyncSSE streams the athlete's sync as Server-Sent Events. It attaches to the
+// running sync when there is one and otherwise starts a background sync job; the job keeps
+// running when the client goes away. With ?attach=1 it never starts a sync.
+func (s *server) handleStravaSyncSSE(w http.ResponseWriter, r *http.Request) {
+	scope := s.webSessionFromRequest(r)
+	if scope.StravaToken == "" || scope.Athlete == nil {
+		http.Error(w, "not authorized with Strava", http.StatusUnauthorized)
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sw := newSSEWriter(w)
+	stopHeartbeat := sw.startHeartbeat(r.Context(), sseHeartbeatInterval)
+	defer stopHeartbeat()
+	sw.retry(sseRetryMillis)
+
+	// Re-attach to the athlete's running sync (or a reconnecting client's finished one) instead of starting another
+	lastEventID := r.Header.Get("Last-Event-ID")
+	job := s.syncJobFor(scope.AthleteID)
+	if job == nil || (!job.running() && lastEventID == "") {
+		if r.URL.Query().Get("attach") != "" {
+			sw.send(sseEvent{Event: "done", Data: "idle"})
+			return
+		}
+		// A sync started in between is attached to like any other running one
+		job, _ = s.startSyncJob(scope.AthleteID, s.webSyncConfig(r, scope))
+	}
+	lastID, _ := strconv.ParseInt(lastEventID, 10, 64)
+	job.stream.attach(sw, lastID)
+	select {
+	case <-job.stream.done:
+	case <-r.Context().Done():
+	}
+	job.stream.detach(sw)
+}
+
+// handleSyncStart handles POST /api/sync/start
+func (s *server) handleSyncStart(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	if scope.StravaToken == "" {
+		writeError(w, r, newAPIError(http.StatusUnauthorized, "not authorized with Strava"))
+		return
+	}
+	job, err := s.startSyncJob(scope.AthleteID, s.webSyncConfig(r, scope))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if errors.Is(err, errSyncRunning) {
+		w.WriteHeader(http.StatusConflict)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	_ = json.NewEncoder(w).Encode(job.status())
+}
+
+// handleSyncStatus handles GET /api/sync/status
+func (s *server) handleSyncStatus(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	w.Header().Set("Cache-Control", "no-store")
+	status := s.syncJobFor(scope.AthleteID).status()
+	status.SegmentRefresh = s.segmentRefreshStatusFor(scope.AthleteID)
+	writeJSON(w, status)
+}
+
+// handleSyncCancel handles POST /api/sync/cancel
+func (s *server) handleSyncCancel(w http.ResponseWriter, r *http.Request, scope athleteScope) {
+	job := s.syncJobFor(scope.AthleteID)
+	if job == nil || !job.running() {
+		writeError(w, r, newAPIError(http.StatusConflict, "no sync is running"))
+		return
+	}
+	job.cancel()
+	writeJSON(w, job.status())
+}
@@ -0,0 +1,330 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	syncpkg "sync"
+	"time"
+
+	"b11k/internal/strava"
+	"b11k/internal/sync"
+)
+
+const (
+	syncJobRunning   = "running"
+	syncJobDone      = "done"
+	syncJobFailed    = "failed"
+	syncJobCancelled = "cancelled"
+)
+
+var errSyncRunning = errors.New("a sync is already running")
+
+// syncJob is one athlete's sync running in the background. It outlives the request that
+// started it: progress goes to the attached SSE clients through stream and is kept as a
+// snapshot for /api/sync/status, and the job ends only when the sync does or is cancelled.
+type syncJob struct {
+	stream *syncStream
+	cancel context.CancelFunc
+
+	mu         syncpkg.Mutex
+	state      string
+	startedAt  time.Time
+	finishedAt time.Time
+	progress   *syncProgress
+	summary    *syncSummary
+	err        string
+	auto       bool // started by a page view, see maybeAutoPull
+}
+
+// syncProgress is the latest progress callback of a sync
+type syncProgress struct {
+	Phase   string `json:"phase"`
+	Current int    `json:"current"`
+	Total   int    `json:"total"`
+	Message string `json:"message"`
+}
+
+// syncSummary is the outcome of a finished sync
+type syncSummary struct {
+	Total    int `json:"total"`
+	Existing int `json:"existing"`
+	New      int `json:"new"`
+	Success  int `json:"success"`
+	Failed   int `json:"failed"`
+	Gone     int `json:"gone"`
+	Rejected int `json:"rejected,omitempty"`
+	Skipped  int `json:"skipped"`
+	Deferred int `json:"deferred,omitempty"`
+	NewGear  int `json:"new_gear,omitempty"`
+	// SegmentMatches counts segment matches the sync cached for its new activities
+	SegmentMatches int              `json:"segment_matches,omitempty"`
+	Seconds        float64          `json:"seconds"`
+	Phases         []syncPhaseTotal `json:"phases"`
+}
+
+// syncJobStatus is the JSON form of a job for GET /api/sync/status
+type syncJobStatus struct {
+	State      string        `json:"state"` // idle, running, done, failed or cancelled
+	Auto       bool          `json:"auto,omitempty"`
+	StartedAt  *time.Time    `json:"started_at,omitempty"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Progress   *syncProgress `json:"progress,omitempty"`
+	Summary    *syncSummary  `json:"summary,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	// SegmentRefresh is the segment cache refresh queued after the latest sync or import
+	SegmentRefresh *segmentRefreshStatus `json:"segment_refresh,omitempty"`
+}
+
+// syncPhaseTotal is one entry of the per-phase timing breakdown in sync summaries
+type syncPhaseTotal struct {
+	Phase   string  `json:"phase"`
+	Seconds float64 `json:"seconds"`
+}
+
+func syncPhaseTotals(timings []sync.PhaseTiming) []syncPhaseTotal {
+	totals := make([]syncPhaseTotal, 0, len(timings))
+	for _, timing := range timings {
+		totals = append(totals, syncPhaseTotal{Phase: timing.Phase, Seconds: timing.Duration.Seconds()})
+	}
+	return totals
+}
+
+func newSyncSummary(result *sync.SyncResult) *syncSummary {
+	return &syncSummary{
+		Total:          result.TotalActivitiesFound,
+		Existing:       result.ExistingActivities,
+		New:            result.NewActivities,
+		Success:        result.SuccessfullyProcessed,
+		Failed:         len(result.FailedActivities),
+		Gone:           len(result.GoneActivities),
+		Rejected:       len(result.RejectedActivities),
+		Skipped:        result.SkippedActivities,
+		Deferred:       result.DeferredActivities,
+		NewGear:        result.NewGear,
+		SegmentMatches: result.SegmentMatches,
+		Seconds:        result.ProcessingTime.Seconds(),
+		Phases:         syncPhaseTotals(result.PhaseTimings),
+	}
+}
+
+func (job *syncJob) running() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.state == syncJobRunning
+}
+
+// lastActive is when the job last touched Strava: now while it runs, otherwise when it
+// finished
+func (job *syncJob) lastActive(now time.Time) time.Time {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.state == syncJobRunning {
+		return now
+	}
+	return job.finishedAt
+}
+
+func (job *syncJob) setProgress(progress syncProgress) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.progress = &progress
+}
+
+func (job *syncJob) finish(result *sync.SyncResult, err error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.finishedAt = time.Now()
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.state = syncJobCancelled
+	case err != nil:
+		job.state = syncJobFailed
+		job.err = err.Error()
+	default:
+		job.state = syncJobDone
+	}
+	if result != nil {
+		job.summary = newSyncSummary(result)
+	}
+}
+
+func (job *syncJob) status() syncJobStatus {
+	if job == nil {
+		return syncJobStatus{State: "idle"}
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	status := syncJobStatus{
+		State:    job.state,
+		Progress: job.progress,
+		Summary:  job.summary,
+		Error:    job.err,
+		Auto:     job.auto,
+	}
+	startedAt := job.startedAt
+	status.StartedAt = &startedAt
+	if !job.finishedAt.IsZero() {
+		finishedAt := job.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	return status
+}
+
+// syncJobFor returns the athlete's current or most recent sync job, or nil
+func (s *server) syncJobFor(athleteID int64) *syncJob {
+	s.syncJobMu.Lock()
+	defer s.syncJobMu.Unlock()
+	return s.syncJobs[athleteID]
+}
+
+// startSyncJob runs cfg in the background for athleteID. While the athlete already has a
+// running sync it returns that job with errSyncRunning.
+func (s *server) startSyncJob(athleteID int64, cfg sync.SyncConfig) (*syncJob, error) {
+	s.syncJobMu.Lock()
+	defer s.syncJobMu.Unlock()
+	if job := s.syncJobs[athleteID]; job != nil && job.running() {
+		return job, errSyncRunning
+	}
+	ctx, cancel := context.WithCancel(s.jobContext())
+	job := &syncJob{
+		stream:    newSyncStream(),
+		cancel:    cancel,
+		state:     syncJobRunning,
+		startedAt: time.Now(),
+	}
+	if s.syncJobs == nil {
+		s.syncJobs = make(map[int64]*syncJob)
+	}
+	s.syncJobs[athleteID] = job
+	s.jobs.Add(1)
+	go func() {
+		defer s.jobs.Done()
+		s.runSyncJob(ctx, athleteID, job, cfg)
+	}()
+	return job, nil
+}
+
+// jobContext is the parent context of syncs and segment cache refreshes, cancelled when
+// the server starts shutting down
+func (s *server) jobContext() context.Context {
+	if s.jobsCtx == nil {
+		return s.ctx
+	}
+	return s.jobsCtx
+}
+
+func (s *server) runSyncJob(ctx context.Context, athleteID int64, job *syncJob, cfg sync.SyncConfig) {
+	defer job.stream.finish()
+	defer job.cancel()
+	send := job.stream.publish
+	send("log", "Starting sync...")
+	slog.Info("Background sync started", "athlete_id", athleteID)
+
+	// Progress is always kept for the status endpoint; once every client is gone it is no
+	// longer formatted or sent
+	progressCallback := func(phase string, current, total int, message string) {
+		progress := syncProgress{Phase: phase, Current: current, Total: total, Message: message}
+		job.setProgress(progress)
+		if !job.stream.hasClients() {
+			return
+		}
+		progressJSON, _ := json.Marshal(progress)
+		send("progress", string(progressJSON))
+	}
+
+	runSync := s.runSync
+	if runSync == nil {
+		runSync = func(ctx context.Context, cfg sync.SyncConfig, progress sync.ProgressCallback) (*sync.SyncResult, error) {
+			return sync.SyncActivitiesFromStravaWithRetry(ctx, cfg, 3, progress)
+		}
+	}
+	result, err := runSync(ctx, cfg, progressCallback)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	job.finish(result, err)
+	if result != nil {
+		// Activities saved before a cancellation or failure are kept, so refresh them too
+		s.queueSegmentCacheRefresh(athleteID, result.SavedActivityIDs)
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Info("Sync cancelled", "athlete_id", athleteID)
+		send("error", "Sync cancelled")
+	case err != nil:
+		slog.Error("Sync failed", "athlete_id", athleteID, "error", err)
+		send("error", "Sync failed: "+err.Error())
+	default:
+		b, _ := json.Marshal(newSyncSummary(result))
+		send("log", "Time by phase: "+sync.FormatPhaseTimings(result.PhaseTimings))
+		send("summary", string(b))
+		send("done", "ok")
+	}
+}
+
+// webSyncConfig builds the sync for a web request: ?start=YYYY-MM-DD&end=YYYY-MM-DD picks
+// the timeframe, ?mode=incremental fetches only activities newer than the latest stored
+// one, and ?types=Ride,VirtualRide overrides the configured type filter.
+func (s *server) webSyncConfig(r *http.Request, scope athleteScope) sync.SyncConfig {
+	q := r.URL.Query()
+	var startTime time.Time
+	var endTime time.Time
+	if startStr := q.Get("start"); startStr != "" {
+		if t, err := time.Parse("2006-01-02", startStr); err == nil {
+			startTime = t
+		}
+	}
+	if endStr := q.Get("end"); endStr != "" {
+		if t, err := time.Parse("2006-01-02", endStr); err == nil {
+			endTime = t
+		}
+	}
+	activityTypes := s.cfg.ActivityTypes
+	if q.Has("types") {
+		activityTypes = strava.ParseActivityTypes(q.Get("types"))
+	}
+
+	sessionID := webSessionIDFromRequest(r)
+	return sync.SyncConfig{
+		StravaAccessToken: scope.StravaToken,
+		AccessTokenProvider: func() (string, error) {
+			entry, err := s.webLogin(sessionID)
+			return entry.AccessToken, err
+		},
+		DatabaseConfig: s.syncDatabaseConfig(),
+		Timeframe: sync.TimeframeConfig{
+			StartTime: startTime,
+			EndTime:   endTime,
+		},
+		DiscoveredMap:   s.syncDiscoveredMapConfig(),
+		ActivityTypes:   activityTypes,
+		Incremental:     q.Get("mode") == "incremental",
+		OnActivitySaved: s.syncedActivitySaved,
+		HealGPSSpikes:   s.cfg.HealGPSSpikes,
+		FetchGear:       s.fetchGear,
+		MatchSegments:   !s.cfg.LazySegmentCache,
+		Limits:          s.cfg.Limits,
+		TagRules:        s.cfg.TagRules,
+	}
+}
+
+func (s *server) syncDatabaseConfig() sync.DatabaseConfig {
+	return sync.DatabaseConfig{
+		Host:     s.cfg.PGIP,
+		Port:     s.cfg.PGPort,
+		User:     s.cfg.PGUser,
+		Password: s.cfg.PGPassword,
+		Database: s.cfg.PGDatabase,
+	}
+}
+
+func (s *server) syncDiscoveredMapConfig() sync.DiscoveredMapConfig {
+	return sync.DiscoveredMapConfig{
+		Enabled:              s.cfg.DiscoveredMapEnabled,
+		RevealRadiusMeters:   s.cfg.DiscoveredRevealRadiusMeters,
+		SampleDistanceMeters: s.cfg.DiscoveredSampleDistanceMeters,
+	}
+}
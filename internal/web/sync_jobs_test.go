@@ -0,0 +1,185 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/strava"
+	"b11k/internal/sync"
+)
+
+// newSyncJobTestServer returns a server where cookie "token-a" is logged in as athlete 1
+func newSyncJobTestServer() *server {
+	s := &server{ctx: context.Background(), syncJobs: make(map[int64]*syncJob)}
+	s.cacheWebAthlete("token-a", &strava.Athlete{ID: 1})
+	return s
+}
+
+// addRunningSyncJob registers a running job for athleteID without starting a real sync
+func addRunningSyncJob(s *server, athleteID int64) (*syncJob, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &syncJob{stream: newSyncStream(), cancel: cancel, state: syncJobRunning, startedAt: time.Now()}
+	s.syncJobs[athleteID] = job
+	return job, ctx
+}
+
+func syncAPIRequest(s *server, method, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSyncStatusIdleWithoutJob(t *testing.T) {
+	s := newSyncJobTestServer()
+	rec := syncAPIRequest(s, http.MethodGet, "/api/sync/status")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want 200", rec.Code)
+	}
+	var status syncJobStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "idle" || status.StartedAt != nil {
+		t.Fatalf("status = %+v, want idle", status)
+	}
+}
+
+func TestSyncStartWhileRunningConflicts(t *testing.T) {
+	s := newSyncJobTestServer()
+	running, _ := addRunningSyncJob(s, 1)
+
+	job, err := s.startSyncJob(1, sync.SyncConfig{})
+	if !errors.Is(err, errSyncRunning) || job != running {
+		t.Fatalf("startSyncJob = %p, %v; want the running job and errSyncRunning", job, err)
+	}
+	rec := syncAPIRequest(s, http.MethodPost, "/api/sync/start")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("start while running = %d, want 409", rec.Code)
+	}
+	var status syncJobStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status.State != syncJobRunning {
+		t.Fatalf("conflict body = %q, want the running job's status", rec.Body.String())
+	}
+	// Another athlete's sync is unaffected
+	if other := s.syncJobFor(2); other != nil {
+		t.Fatalf("athlete 2 has job %+v", other)
+	}
+}
+
+func TestSyncCancel(t *testing.T) {
+	s := newSyncJobTestServer()
+	if rec := syncAPIRequest(s, http.MethodPost, "/api/sync/cancel"); rec.Code != http.StatusConflict {
+		t.Fatalf("cancel without a sync = %d, want 409", rec.Code)
+	}
+
+	_, ctx := addRunningSyncJob(s, 1)
+	if rec := syncAPIRequest(s, http.MethodGet, "/api/sync/cancel"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET cancel = %d, want 405", rec.Code)
+	}
+	if rec := syncAPIRequest(s, http.MethodPost, "/api/sync/cancel"); rec.Code != http.StatusOK {
+		t.Fatalf("cancel = %d, want 200", rec.Code)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("cancel did not cancel the job's context")
+	}
+}
+
+func TestSyncJobFinishStates(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{nil, syncJobDone},
+		{context.Canceled, syncJobCancelled},
+		{errors.New("strava down"), syncJobFailed},
+	}
+	for _, tc := range cases {
+		job := &syncJob{state: syncJobRunning, startedAt: time.Now()}
+		job.finish(&sync.SyncResult{NewActivities: 3, SuccessfullyProcessed: 2}, tc.err)
+		status := job.status()
+		if status.State != tc.want || status.FinishedAt == nil {
+			t.Fatalf("finish(%v) = %+v, want state %s", tc.err, status, tc.want)
+		}
+		if status.Summary == nil || status.Summary.New != 3 || status.Summary.Success != 2 {
+			t.Fatalf("finish(%v) summary = %+v", tc.err, status.Summary)
+		}
+		if job.running() {
+			t.Fatalf("finish(%v) left the job running", tc.err)
+		}
+	}
+}
+
+func TestSyncSSEAttachesToRunningJob(t *testing.T) {
+	s := newSyncJobTestServer()
+	job, _ := addRunningSyncJob(s, 1)
+	// Progress published while no tab was open is replayed on attach
+	job.stream.publish("progress", `{"phase":"saving","current":2,"total":5}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/strava/sync?attach=1", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	rec := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		s.handleStravaSyncSSE(rec, req)
+		close(finished)
+	}()
+	for !job.stream.hasClients() {
+		time.Sleep(time.Millisecond)
+	}
+	job.stream.publish("done", "ok")
+	job.stream.finish()
+	<-finished
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `"current":2`) || !strings.Contains(body, "event: done\ndata: ok") {
+		t.Fatalf("body = %q, want replayed progress and the done event", body)
+	}
+	if len(s.syncJobs) != 1 {
+		t.Fatalf("attach started another sync: %d jobs", len(s.syncJobs))
+	}
+}
+
+func TestSyncSSEAttachWithoutJob(t *testing.T) {
+	s := newSyncJobTestServer()
+	req := httptest.NewRequest(http.MethodGet, "/strava/sync?attach=1", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "token-a"})
+	rec := httptest.NewRecorder()
+	s.handleStravaSyncSSE(rec, req)
+
+	if body := rec.Body.String(); !strings.Contains(body, "event: done\ndata: idle") {
+		t.Fatalf("body = %q, want an idle done event", body)
+	}
+	if s.syncJobFor(1) != nil {
+		t.Fatal("attach started a sync")
+	}
+}
+
+func TestSkippedActivityAPIValidatesBeforeTouchingTheDatabase(t *testing.T) {
+	s := newSyncJobTestServer()
+	if rec := syncAPIRequest(s, http.MethodDelete, "/api/sync/skipped/abc"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid ID status = %d, want 400", rec.Code)
+	}
+	if rec := syncAPIRequest(s, http.MethodPost, "/api/sync/skipped/12"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d, want 405", rec.Code)
+	}
+	if rec := syncAPIRequest(s, http.MethodDelete, "/api/sync/skipped"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE without ID status = %d, want 405", rec.Code)
+	}
+}
+
+func TestSyncSummaryCountsGoneAndSkipped(t *testing.T) {
+	summary := newSyncSummary(&sync.SyncResult{GoneActivities: []int64{7, 8}, SkippedActivities: 3})
+	if summary.Gone != 2 || summary.Skipped != 3 {
+		t.Fatalf("summary = %+v, want 2 gone and 3 skipped", summary)
+	}
+}
@@ -0,0 +1,249 @@
+package web
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+// Names a user can put on activities, segments or imported files that try to break out of
+// HTML, attribute and script contexts
+var adversarialNames = []string{
+	`</script><script>alert("xss-script")</script>`,
+	`"><img src=x onerror=alert("xss-attr")>`,
+	`';alert("xss-quote");//`,
+	"line\u2028separator\u2029xss-unicode",
+	`<!--<script>alert("xss-comment")//`,
+}
+
+var scriptBlockPattern = regexp.MustCompile(`(?is)<script\b[^>]*>(.*?)</script>`)
+
+// renderPageForTest renders a page template the way executeTemplate does
+func renderPageForTest(t *testing.T, tmpl *template.Template, name string, data interface{}) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&out, name, data); err != nil {
+		t.Fatalf("render %s: %v", name, err)
+	}
+	return out.String()
+}
+
+// assertNoInjection fails when a rendered page lets an adversarial name run script:
+// extra or altered script blocks, a raw injected tag or handler, or a raw line separator
+// inside a script
+func assertNoInjection(t *testing.T, page, baseline string) {
+	t.Helper()
+	got, want := scriptBlockPattern.FindAllStringSubmatch(page, -1), scriptBlockPattern.FindAllStringSubmatch(baseline, -1)
+	if len(got) != len(want) {
+		t.Fatalf("page has %d script blocks, %d with harmless names", len(got), len(want))
+	}
+	for i := range got {
+		if strings.Contains(got[i][1], "xss-") || strings.ContainsAny(got[i][1], "\u2028\u2029") {
+			t.Fatalf("script block %d carries injected content: %q", i, got[i][1])
+		}
+	}
+	for _, raw := range []string{`<img src=x`, `<script>alert`, `javascript:alert`} {
+		if strings.Contains(page, raw) {
+			t.Fatalf("page contains unescaped %q", raw)
+		}
+	}
+}
+
+func adversarialActivity(name string) strava.ActivitySummary {
+	gear := name
+	return strava.ActivitySummary{
+		ID:              1,
+		Name:            name,
+		Distance:        1000,
+		GearID:          "b1",
+		GearName:        &gear,
+		LocationCity:    &gear,
+		LocationCountry: &gear,
+		Notes:           name,
+		Sparkline:       []float64{1, 2, 3},
+		StartDateTime:   time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+	}
+}
+
+func adversarialAthlete(name string) *strava.Athlete {
+	return &strava.Athlete{ID: 1, FirstName: name, LastName: name, Profile: `javascript:alert("xss-avatar")`}
+}
+
+func TestTemplatesEscapeAdversarialNames(t *testing.T) {
+	pages := map[string]func(name string) (string, interface{}){
+		"index.html": func(name string) (string, interface{}) {
+			activity := adversarialActivity(name)
+			pinned := activity
+			pinned.Pinned = true
+			return "index.html", struct {
+				Activities           []strava.ActivitySummary
+				Pinned               []strava.ActivitySummary
+				Type                 string
+				TypeOptions          []string
+				Filter               url.Values
+				Sort                 string
+				Filtered             bool
+				PrevURL              string
+				NextURL              string
+				ShowLoginCTA         bool
+				Anonymous            bool
+				Authorized           bool
+				Athlete              *strava.Athlete
+				CurrentPage          int
+				TotalPages           int
+				HasNext              bool
+				HasPrev              bool
+				PerPage              int
+				DiscoveredMapEnabled bool
+				Announcements        []pggeo.Announcement
+				DisplayZone          pggeo.DisplayZone
+			}{
+				Activities:  []strava.ActivitySummary{activity, pinned},
+				Pinned:      []strava.ActivitySummary{pinned},
+				Type:        name,
+				TypeOptions: []string{name},
+				Filter:      url.Values{"q": {name}, "start": {name}, "min_distance": {name}},
+				Sort:        name,
+				Filtered:    true,
+				PrevURL:     "/strava/?" + url.Values{"page": {"1"}, "q": {name}}.Encode(),
+				NextURL:     "/strava/?" + url.Values{"page": {"3"}, "q": {name}}.Encode(),
+				Authorized:  true,
+				Athlete:     adversarialAthlete(name),
+				CurrentPage: 2,
+				TotalPages:  3,
+				HasNext:     true,
+				HasPrev:     true,
+				PerPage:     20,
+				Announcements: []pggeo.Announcement{
+					{ID: 1, Message: name, Level: pggeo.AnnouncementLevelWarning},
+					{ID: 2, Message: name, Level: pggeo.AnnouncementLevelInfo},
+				},
+			}
+		},
+		"activity.html": func(name string) (string, interface{}) {
+			return "activity.html", struct {
+				Activity             strava.ActivitySummary
+				ActivityHRZones      []pggeo.HRZoneDistribution
+				Athlete              *strava.Athlete
+				ShowLoginCTA         bool
+				Anonymous            bool
+				Authorized           bool
+				MobileActivityOrder  string
+				DiscoveredMapEnabled bool
+				Announcements        []pggeo.Announcement
+				DisplayZone          pggeo.DisplayZone
+			}{
+				Activity:            adversarialActivity(name),
+				ActivityHRZones:     []pggeo.HRZoneDistribution{{Zone: 1, Label: name, Percentage: 50}},
+				Athlete:             adversarialAthlete(name),
+				Authorized:          true,
+				MobileActivityOrder: name,
+			}
+		},
+		"segments.html": func(name string) (string, interface{}) {
+			return "segments.html", struct {
+				Segments             []pggeo.SegmentDashboardSummary
+				Athlete              *strava.Athlete
+				ShowLoginCTA         bool
+				Anonymous            bool
+				Authorized           bool
+				DiscoveredMapEnabled bool
+				Announcements        []pggeo.Announcement
+			}{
+				Segments: []pggeo.SegmentDashboardSummary{{
+					ID: 1, Name: name, Description: &name, CreatedAt: name,
+					Direction: name, DirectionKey: name, SortName: name, SortDirection: name,
+				}},
+				Athlete:    adversarialAthlete(name),
+				Authorized: true,
+			}
+		},
+		"segment.html": func(name string) (string, interface{}) {
+			return "segment.html", struct {
+				Segment              *pggeo.FavoriteSegment
+				Tolerance            segmentTolerance
+				Athlete              *strava.Athlete
+				ShowLoginCTA         bool
+				Anonymous            bool
+				Authorized           bool
+				MobileActivityOrder  string
+				DiscoveredMapEnabled bool
+				Announcements        []pggeo.Announcement
+				Timeline             *analysis.SegmentTimeline
+			}{
+				Segment:             &pggeo.FavoriteSegment{ID: 1, Name: name, Description: &name, CreatedAt: name},
+				Tolerance:           segmentTolerance{Meters: 25, Source: name},
+				Athlete:             adversarialAthlete(name),
+				Authorized:          true,
+				MobileActivityOrder: name,
+				Timeline: &analysis.SegmentTimeline{Metric: analysis.TimelineMetricElapsed, Points: []analysis.TimelinePoint{
+					{Date: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC), Value: 300, ActivityID: 1, Effort: 1},
+				}},
+			}
+		},
+		"settings.html": func(name string) (string, interface{}) {
+			return "settings.html", settingsPageData{
+				Athlete:       adversarialAthlete(name),
+				Authorized:    true,
+				Sessions:      []webSessionInfo{{ID: 1, UserAgent: name, Current: true}, {ID: 2, UserAgent: name}},
+				Announcements: []pggeo.Announcement{{ID: 1, Message: name, Level: pggeo.AnnouncementLevelInfo}},
+			}
+		},
+	}
+
+	// Templates are loaded relative to the repository root
+	t.Chdir(filepath.Join("..", ".."))
+	tmpl, err := parseTemplates("")
+	if err != nil {
+		t.Fatalf("parseTemplates: %v", err)
+	}
+	for page, build := range pages {
+		t.Run(page, func(t *testing.T) {
+			baseline := renderPageForTest(t, tmpl, page, second(build("Harmless name")))
+			for _, name := range adversarialNames {
+				assertNoInjection(t, renderPageForTest(t, tmpl, page, second(build(name))), baseline)
+			}
+		})
+	}
+}
+
+func second(_ string, data interface{}) interface{} {
+	return data
+}
+
+// markdownRendererFiles may build template.HTML: they render a sanitized markdown tree
+// that never carries raw HTML, covered by TestRenderMarkdownStripsHostileInput
+var markdownRendererFiles = map[string]bool{"markdown.go": true}
+
+// Escaping only holds while no view-model value bypasses html/template. Data for inline
+// scripts must be plain values html/template escapes for the script context, or data-*
+// attributes read by app.js.
+func TestWebCodeDoesNotBypassTemplateEscaping(t *testing.T) {
+	bypass := regexp.MustCompile(`template\.(HTML|HTMLAttr|JS|JSStr|CSS|URL|Srcset)\(|"text/template"`)
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") || markdownRendererFiles[file] {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if match := bypass.Find(source); match != nil {
+			t.Errorf("%s uses %s, which skips contextual escaping", file, match)
+		}
+	}
+}
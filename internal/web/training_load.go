@@ -0,0 +1,64 @@
+package web
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"b11k/internal/pggeo"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Weeks GET /api/training-load covers by default and at most
+const (
+	defaultTrainingLoadWeeks = 12
+	maxTrainingLoadWeeks     = 104
+)
+
+// handleTrainingLoad handles GET /api/training-load?weeks=12&tz=Europe/Berlin: per-week
+// moving time, distance, suffer score and time in each HR zone, oldest week first and
+// ending with the current one. Without HR zones the weeks carry no time in zone.
+func (s *server) handleTrainingLoad(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+	scope, ok := s.webScopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+	weeks := defaultTrainingLoadWeeks
+	if value := r.URL.Query().Get("weeks"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTrainingLoadWeeks {
+			writeError(w, r, newAPIError(http.StatusBadRequest, "weeks must be between 1 and "+strconv.Itoa(maxTrainingLoadWeeks)))
+			return
+		}
+		weeks = n
+	}
+	displayZone, err := s.displayZone(r, scope.AthleteID)
+	if err != nil {
+		writeError(w, r, newAPIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+
+	zones, err := s.athleteHRZones(r.Context(), scope)
+	if err != nil {
+		slog.Warn("Failed to load HR zones for training load", "athlete_id", scope.AthleteID, "error", err)
+		zones = nil
+	}
+	var load *pggeo.TrainingLoad
+	err = s.withReadDB(func(conn *pgxpool.Pool) error {
+		var dbErr error
+		load, dbErr = pggeo.GetTrainingLoad(s.ctx, conn, scope.AthleteID, weeks, time.Now(), zones, displayZone)
+		return dbErr
+	})
+	if err != nil {
+		slog.Error("Failed to load training load", "athlete_id", scope.AthleteID, "error", err)
+		s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, load)
+}
@@ -0,0 +1,40 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"b11k/internal/strava"
+)
+
+func TestTrainingLoadAPIValidatesRequest(t *testing.T) {
+	s := &server{
+		ctx:        context.Background(),
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+	}
+	s.cacheWebAthlete("token-rider", &strava.Athlete{ID: 2})
+	h := s.routes()
+	for _, tc := range []struct {
+		method, target, token string
+		want                  int
+	}{
+		{http.MethodGet, "/api/training-load", "", http.StatusUnauthorized},
+		{http.MethodPost, "/api/training-load", "token-rider", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/training-load?weeks=0", "token-rider", http.StatusBadRequest},
+		{http.MethodGet, "/api/training-load?weeks=105", "token-rider", http.StatusBadRequest},
+		{http.MethodGet, "/api/training-load?weeks=twelve", "token-rider", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.token != "" {
+			req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: tc.token})
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s %s as %q = %d, want %d", tc.method, tc.target, tc.token, rec.Code, tc.want)
+		}
+	}
+}
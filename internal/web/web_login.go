@@ -0,0 +1,244 @@
+package web
+
+import (
+	"context"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"b11k/internal/strava"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	oauthStateCookieName = "b11k_oauth_state"
+	oauthStateLifetime   = 10 * time.Minute
+	// loginCodeTTL is how long an exchanged code is remembered, so a second hit of the
+	// same callback URL is recognised instead of failing at Strava
+	loginCodeTTL       = 5 * time.Minute
+	maxReturnPathBytes = 1024
+)
+
+// loginCode is the outcome of exchanging one OAuth code. nonce is the state nonce of the
+// browser that started the exchange; only that browser may reuse the result.
+type loginCode struct {
+	nonce     string
+	sessionID string
+	err       error
+	expiresAt time.Time
+}
+
+// loginCodeCache coalesces concurrent exchanges of one OAuth code and remembers recent
+// results. The zero value is ready to use.
+type loginCodeCache struct {
+	mu      sync.Mutex
+	codes   map[string]loginCode
+	flights singleflight.Group
+}
+
+// exchange returns the remembered result for code, or runs exchange once for all
+// concurrent callers and remembers its result
+func (c *loginCodeCache) exchange(code, nonce string, exchange func() (string, error)) loginCode {
+	if result, ok := c.lookup(code); ok {
+		return result
+	}
+	result, _, _ := c.flights.Do(code, func() (interface{}, error) {
+		if result, ok := c.lookup(code); ok {
+			return result, nil
+		}
+		sessionID, err := exchange()
+		result := loginCode{nonce: nonce, sessionID: sessionID, err: err, expiresAt: time.Now().Add(loginCodeTTL)}
+		c.store(code, result)
+		return result, nil
+	})
+	return result.(loginCode)
+}
+
+func (c *loginCodeCache) lookup(code string) (loginCode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.codes[code]
+	if !ok || time.Now().After(result.expiresAt) {
+		return loginCode{}, false
+	}
+	return result, true
+}
+
+func (c *loginCodeCache) store(code string, result loginCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.codes == nil {
+		c.codes = make(map[string]loginCode)
+	}
+	now := time.Now()
+	for key, existing := range c.codes {
+		if now.After(existing.expiresAt) {
+			delete(c.codes, key)
+		}
+	}
+	c.codes[code] = result
+}
+
+func (s *server) exchangeLoginCode(ctx context.Context, code string) (*strava.StravaTokenResponse, error) {
+	if s.exchangeCode != nil {
+		return s.exchangeCode(code)
+	}
+	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.StravaRedirectURI)
+	return strava.ExchangeCodeForTokenResponse(ctx, *authCfg, code)
+}
+
+func (s *server) setWebLoginCookie(w http.ResponseWriter, r *http.Request, sessionID string) {
+	// #nosec G124 -- local HTTP needs an insecure cookie; production HTTPS requests set Secure.
+	http.SetCookie(w, &http.Cookie{
+		Name:     webSessionCookieName,
+		Value:    sessionID,
+		Path:     s.url("/"),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(webSessionLifetime.Seconds()),
+	})
+	s.expireCookie(w, r, legacyTokenCookieName)
+}
+
+// expireCookie removes a login cookie from the browser
+func (s *server) expireCookie(w http.ResponseWriter, r *http.Request, name string) {
+	// #nosec G124 -- local HTTP needs an insecure cookie; production HTTPS requests set Secure.
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     s.url("/"),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1, // Expire immediately
+	})
+}
+
+// loginReturnPath picks the app path to return to after login from ?next= or a
+// same-site Referer, defaulting to the index
+func (s *server) loginReturnPath(r *http.Request) string {
+	if next, ok := safeReturnPath(r.URL.Query().Get("next")); ok {
+		return next
+	}
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host {
+		return "/"
+	}
+	path := referer.Path
+	if s.cfg.BasePath != "" {
+		if path != s.cfg.BasePath && !strings.HasPrefix(path, s.cfg.BasePath+"/") {
+			return "/"
+		}
+		path = strings.TrimPrefix(path, s.cfg.BasePath)
+	}
+	if referer.RawQuery != "" {
+		path += "?" + referer.RawQuery
+	}
+	if next, ok := safeReturnPath(path); ok {
+		return next
+	}
+	return "/"
+}
+
+// safeReturnPath accepts root-relative app paths only, so the state cannot send the
+// browser to another site or back into the login flow
+func safeReturnPath(path string) (string, bool) {
+	if path == "" || len(path) > maxReturnPathBytes || !strings.HasPrefix(path, "/") ||
+		strings.HasPrefix(path, "//") || strings.ContainsAny(path, "\\\r\n\t") {
+		return "", false
+	}
+	parsed, err := url.Parse(path)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || strings.HasPrefix(parsed.Path, "/strava/") {
+		return "", false
+	}
+	return path, true
+}
+
+// encodeOAuthState joins the nonce and the return path; randomURLToken never produces a dot
+func encodeOAuthState(nonce, next string) string {
+	return nonce + "." + base64.RawURLEncoding.EncodeToString([]byte(next))
+}
+
+// decodeOAuthState splits a state from encodeOAuthState. The return path falls back to
+// the index when it is missing or unsafe.
+func decodeOAuthState(state string) (nonce, next string, ok bool) {
+	nonce, encoded, found := strings.Cut(state, ".")
+	next = "/"
+	if decoded, err := base64.RawURLEncoding.DecodeString(encoded); err == nil {
+		if path, safe := safeReturnPath(string(decoded)); safe {
+			next = path
+		}
+	}
+	return nonce, next, found && nonce != ""
+}
+
+var webLoginPage = template.Must(template.New("web_login").Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  {{if .Refresh}}<meta http-equiv="refresh" content="0;url={{.LinkURL}}">{{end}}
+  <title>B11K</title>
+  <style>
+    :root { color-scheme: dark; }
+    body { margin: 0; min-height: 100vh; display: grid; place-items: center; background: #0d1117; color: #eef2f5; font: 16px/1.5 -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; }
+    main { width: min(520px, calc(100vw - 40px)); border: 1px solid #2b3442; border-radius: 8px; background: #151a22; padding: 28px; }
+    h1 { margin: 0 0 10px; font-size: 28px; }
+    p { margin: 0 0 16px; color: rgba(238,242,245,.72); }
+    a { color: #fc5200; }
+  </style>
+</head>
+<body>
+  <main>
+    <h1>{{.Title}}</h1>
+    <p>{{.Detail}}</p>
+    <a href="{{.LinkURL}}">{{.LinkLabel}}</a>
+  </main>
+</body>
+</html>`))
+
+type webLoginPageData struct {
+	Title     string
+	Detail    string
+	LinkURL   string
+	LinkLabel string
+	Refresh   bool
+}
+
+// renderWebLoginDone sends a logged-in browser on to next. The hop goes through a page
+// rather than a redirect because the login cookie is SameSite=Strict and would not be
+// sent on a redirect that started at strava.com.
+func (s *server) renderWebLoginDone(w http.ResponseWriter, next string) {
+	s.renderWebLoginPage(w, http.StatusOK, webLoginPageData{
+		Title:     "Strava authorized ✅",
+		Detail:    "Taking you back to B11K.",
+		LinkURL:   s.url(next),
+		LinkLabel: "Continue",
+		Refresh:   true,
+	})
+}
+
+func (s *server) renderWebLoginRetry(w http.ResponseWriter, status int, next, title, detail string) {
+	s.renderWebLoginPage(w, status, webLoginPageData{
+		Title:     title,
+		Detail:    detail,
+		LinkURL:   s.url("/strava/login") + "?next=" + url.QueryEscape(next),
+		LinkLabel: "Sign in with Strava",
+	})
+}
+
+func (s *server) renderWebLoginPage(w http.ResponseWriter, status int, data webLoginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := webLoginPage.Execute(w, data); err != nil {
+		slog.Warn("Failed to render login page", "error", err)
+	}
+}
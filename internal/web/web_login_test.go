@@ -0,0 +1,218 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+)
+
+func newLoginTestServer(exchanges *atomic.Int32, exchangeErr error) (*server, map[string]webStoredToken) {
+	s := &server{
+		ctx:        context.Background(),
+		cfg:        Config{StravaClientID: "123", StravaRedirectURI: "http://localhost:8080/strava/callback"},
+		rateLimits: make(map[string]rateLimitEntry),
+		syncJobs:   make(map[int64]*syncJob),
+	}
+	s.exchangeCode = func(code string) (*strava.StravaTokenResponse, error) {
+		exchanges.Add(1)
+		if exchangeErr != nil {
+			return nil, exchangeErr
+		}
+		return &strava.StravaTokenResponse{AccessToken: "token-" + code, RefreshToken: "refresh-" + code}, nil
+	}
+	s.fetchAthlete = func(accessToken string) (*strava.Athlete, error) {
+		return &strava.Athlete{ID: 7}, nil
+	}
+	stored := make(map[string]webStoredToken)
+	s.storeToken = func(tokenKey string, token webStoredToken) error {
+		stored[tokenKey] = token
+		return nil
+	}
+	s.prefetchStrava = func(ctx context.Context, accessToken string) (*pggeo.AthleteProfile, error) {
+		return &pggeo.AthleteProfile{Athlete: strava.Athlete{ID: 7}}, nil
+	}
+	s.storeProfile = func(profile *pggeo.AthleteProfile) error { return nil }
+	s.storeAthlete = func(*strava.Athlete) error { return nil }
+	return s, stored
+}
+
+// startLogin runs /strava/login and returns the state Strava would echo with the
+// browser's state cookie
+func startLogin(t *testing.T, h http.Handler, target string) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || err != nil {
+		t.Fatalf("login = %d -> %q", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oauthStateCookieName {
+			return location.Query().Get("state"), cookie
+		}
+	}
+	t.Fatal("login set no state cookie")
+	return "", nil
+}
+
+func callback(h http.Handler, code, state string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/strava/callback?code="+code+"&state="+url.QueryEscape(state), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func loginCookie(rec *httptest.ResponseRecorder) string {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == webSessionCookieName {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestStravaCallbackHitTwiceKeepsTheLogin(t *testing.T) {
+	var exchanges atomic.Int32
+	s, _ := newLoginTestServer(&exchanges, nil)
+	h := s.routes()
+	state, stateCookie := startLogin(t, h, "/strava/login?next=/activity/42")
+
+	first := callback(h, "abc", state, stateCookie)
+	session := loginCookie(first)
+	if first.Code != http.StatusOK || session == "" || !strings.Contains(first.Body.String(), `url=/activity/42`) {
+		t.Fatalf("first hit = %d cookie %q body %q, want the login and a hop to /activity/42", first.Code, session, first.Body.String())
+	}
+
+	second := callback(h, "abc", state, stateCookie)
+	if second.Code != http.StatusOK || loginCookie(second) != session || !strings.Contains(second.Body.String(), "Strava authorized") {
+		t.Fatalf("second hit = %d %q, want the same login again", second.Code, second.Body.String())
+	}
+
+	// A link preview bot replaying the URL has neither the state cookie nor a login
+	bot := callback(h, "abc", state)
+	if bot.Code != http.StatusBadRequest || loginCookie(bot) != "" || !strings.Contains(bot.Body.String(), "/strava/login?next=%2Factivity%2F42") {
+		t.Fatalf("replay without state cookie = %d cookie %q, want a retry page and no login", bot.Code, loginCookie(bot))
+	}
+
+	// A browser that is already logged in is sent on whatever happens to the code
+	loggedIn := callback(h, "abc", state, &http.Cookie{Name: webSessionCookieName, Value: session})
+	if loggedIn.Code != http.StatusOK || !strings.Contains(loggedIn.Body.String(), "Strava authorized") {
+		t.Fatalf("replay while logged in = %d %q, want the success page", loggedIn.Code, loggedIn.Body.String())
+	}
+
+	if exchanges.Load() != 1 {
+		t.Fatalf("%d token exchanges, want 1", exchanges.Load())
+	}
+}
+
+func TestStravaCallbackFailedExchangeOffersRetry(t *testing.T) {
+	var exchanges atomic.Int32
+	s, _ := newLoginTestServer(&exchanges, errors.New("token exchange failed with status 400"))
+	h := s.routes()
+	state, stateCookie := startLogin(t, h, "/strava/login")
+
+	rec := callback(h, "expired", state, stateCookie)
+	body := rec.Body.String()
+	if rec.Code != http.StatusBadGateway || loginCookie(rec) != "" || !strings.Contains(body, "<html") || !strings.Contains(body, `href="/strava/login?next=%2F"`) {
+		t.Fatalf("failed exchange = %d %q, want a friendly page linking to the login", rec.Code, body)
+	}
+	if strings.Contains(body, "status 400") {
+		t.Fatal("retry page leaks the Strava error")
+	}
+}
+
+func TestStravaLoginKeepsTokensServerSide(t *testing.T) {
+	var exchanges atomic.Int32
+	s, stored := newLoginTestServer(&exchanges, nil)
+	h := s.routes()
+	state, stateCookie := startLogin(t, h, "/strava/login")
+
+	rec := callback(h, "abc", state, stateCookie)
+	var session *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if strings.Contains(cookie.Value, "token-abc") || strings.Contains(cookie.Value, "refresh-abc") {
+			t.Fatalf("cookie %s carries a Strava token: %q", cookie.Name, cookie.Value)
+		}
+		switch cookie.Name {
+		case webSessionCookieName:
+			session = cookie
+		case legacyTokenCookieName:
+			if cookie.MaxAge >= 0 {
+				t.Fatalf("legacy token cookie was not expired: %+v", cookie)
+			}
+		}
+	}
+	if session == nil || len(session.Value) < 40 || !session.HttpOnly || session.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("session cookie = %+v, want an opaque HttpOnly strict cookie", session)
+	}
+	token, ok := stored[webSessionStorageKey(session.Value)]
+	if !ok || token.AccessToken != "token-abc" || token.RefreshToken != "refresh-abc" || token.AthleteID != 7 {
+		t.Fatalf("stored tokens = %+v, %v; want them under the session", token, ok)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(session)
+	if scope := s.webSessionFromRequest(req); scope.AthleteID != 7 || scope.StravaToken != "token-abc" {
+		t.Fatalf("scope = athlete %d token %q, want the session's athlete and token", scope.AthleteID, scope.StravaToken)
+	}
+}
+
+func TestLoginCookiesFollowWebProtocol(t *testing.T) {
+	for protocol, wantSecure := range map[string]bool{"https": true, "http": false} {
+		var exchanges atomic.Int32
+		s, _ := newLoginTestServer(&exchanges, nil)
+		s.cfg.WebProtocol = protocol
+		// Handlers directly: the plain-HTTP test requests would not pass the HTTPS check
+		state, stateCookie := startLogin(t, http.HandlerFunc(s.handleStravaLogin), "/strava/login")
+		if stateCookie.Secure != wantSecure {
+			t.Fatalf("%s: state cookie Secure = %v", protocol, stateCookie.Secure)
+		}
+		for _, cookie := range callback(http.HandlerFunc(s.handleStravaCallback), "abc", state, stateCookie).Result().Cookies() {
+			if cookie.Secure != wantSecure {
+				t.Fatalf("%s: cookie %s Secure = %v", protocol, cookie.Name, cookie.Secure)
+			}
+		}
+	}
+}
+
+func TestStravaLoginReturnPath(t *testing.T) {
+	s := &server{cfg: Config{BasePath: "/b11k"}}
+	cases := []struct {
+		target, referer, want string
+	}{
+		{"/strava/login?next=/segments", "", "/segments"},
+		{"/strava/login?next=//evil.example/x", "", "/"},
+		{"/strava/login?next=https://evil.example/x", "", "/"},
+		{"/strava/login?next=/strava/logout", "", "/"},
+		{"/strava/login", "http://example.com/b11k/activity/9?tab=graph", "/activity/9?tab=graph"},
+		{"/strava/login", "http://elsewhere.example/b11k/activity/9", "/"},
+		{"/strava/login", "http://example.com/other/activity/9", "/"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+		if tc.referer != "" {
+			req.Header.Set("Referer", tc.referer)
+		}
+		if got := s.loginReturnPath(req); got != tc.want {
+			t.Errorf("loginReturnPath(%s, referer %q) = %q, want %q", tc.target, tc.referer, got, tc.want)
+		}
+	}
+
+	if _, next, ok := decodeOAuthState(encodeOAuthState("nonce", "/activity/1")); !ok || next != "/activity/1" {
+		t.Fatalf("state round trip = %q, %v", next, ok)
+	}
+	if _, next, _ := decodeOAuthState("nonce." + "Ly9ldmlsLmV4YW1wbGU"); next != "/" {
+		t.Fatalf("state with //evil.example decoded to %q, want /", next)
+	}
+}
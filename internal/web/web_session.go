@@ -0,0 +1,332 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	webTokenRefreshMargin = 2 * time.Minute
+	webSessionLifetime    = 30 * 24 * time.Hour
+	webSessionIDBytes     = 32
+	// webSessionKeyPrefix starts the athlete_tokens keys of web sessions
+	webSessionKeyPrefix = "session:"
+)
+
+// webAthleteEntry caches the athlete and current Strava access token behind a web session
+// so each request can resolve its own identity without calling Strava every time.
+type webAthleteEntry struct {
+	Athlete        *strava.Athlete
+	AccessToken    string
+	TokenExpiresAt time.Time // zero for logins without a stored refresh token
+	ExpiresAt      time.Time
+	Rejected       bool // negative entry: Strava refused this login recently
+}
+
+// webStoredToken is a row of athlete_tokens with its secrets decrypted
+type webStoredToken struct {
+	AthleteID    int64
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time // when the login was stored; set by loadWebToken only
+}
+
+// webSessionIDFromRequest returns the web session cookie, or "" when absent
+func webSessionIDFromRequest(r *http.Request) string {
+	cookie, err := r.Cookie(webSessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// webSessionStorageKey is the athlete_tokens key of a web session. It differs from the
+// keys of logins that kept the raw access token in the browser, so those cookies cannot
+// resolve a session.
+func webSessionStorageKey(sessionID string) string {
+	return webSessionKeyPrefix + mobileSessionStorageKey(sessionID)
+}
+
+// webSessionExpired reports whether a login stored at createdAt is past
+// webSessionLifetime. Its cookie expires then too, but a kept copy must not outlive it.
+func webSessionExpired(createdAt, now time.Time) bool {
+	return !now.Before(createdAt.Add(webSessionLifetime))
+}
+
+// webSessionFromRequest resolves the caller's identity from the session cookie. The scope
+// is anonymous when there is no session or it could not be resolved. StravaToken is the
+// session's current access token, refreshed when it is about to expire.
+func (s *server) webSessionFromRequest(r *http.Request) athleteScope {
+	sessionID := webSessionIDFromRequest(r)
+	if sessionID == "" {
+		return athleteScope{}
+	}
+	entry, err := s.webLogin(sessionID)
+	if err != nil {
+		slog.Warn("Failed to resolve web login", "error", err)
+		return athleteScope{}
+	}
+	s.sessionTouches.record(webSessionStorageKey(sessionID), r.UserAgent(), time.Now())
+	noteRequestAthlete(r, entry.Athlete.ID)
+	return athleteScope{
+		AthleteID:   entry.Athlete.ID,
+		Athlete:     entry.Athlete,
+		StravaToken: entry.AccessToken,
+	}
+}
+
+// webLogin returns the cached login for a session, refreshing its Strava access token
+// through the stored refresh token when it is about to expire.
+func (s *server) webLogin(sessionID string) (webAthleteEntry, error) {
+	return s.webAthletes.get(webSessionStorageKey(sessionID), func() (webAthleteEntry, error) {
+		return s.loadWebLogin(sessionID)
+	})
+}
+
+// loadWebLogin resolves a session from its stored tokens and Strava. Unknown sessions and
+// failures on the Strava side wrap errWebLoginRejected so the cache remembers them briefly.
+func (s *server) loadWebLogin(sessionID string) (webAthleteEntry, error) {
+	stored, err := s.loadWebToken(sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return webAthleteEntry{}, fmt.Errorf("%w: unknown web session", errWebLoginRejected)
+	}
+	if err != nil {
+		return webAthleteEntry{}, err
+	}
+	if webTokenNeedsRefresh(stored.ExpiresAt, time.Now()) {
+		if stored, err = s.refreshWebToken(sessionID, stored); err != nil {
+			return webAthleteEntry{}, err
+		}
+	}
+	entry := webAthleteEntry{AccessToken: stored.AccessToken, TokenExpiresAt: stored.ExpiresAt}
+
+	// A stored athlete answers without Strava; revoked logins then surface when their
+	// token is refreshed
+	athlete, err := s.resolveAthlete(stored.AthleteID, entry.AccessToken)
+	if err != nil {
+		return webAthleteEntry{}, fmt.Errorf("%w: %w", errWebLoginRejected, err)
+	}
+	entry.Athlete = athlete
+	return entry, nil
+}
+
+// fetchCurrentAthlete calls Strava's athlete endpoint, or the fake installed by tests
+func (s *server) fetchCurrentAthlete(accessToken string) (*strava.Athlete, error) {
+	s.webAthletes.stravaCalls.Add(1)
+	if s.fetchAthlete != nil {
+		return s.fetchAthlete(accessToken)
+	}
+	return strava.FetchCurrentAthlete(s.ctx, accessToken)
+}
+
+func webTokenNeedsRefresh(tokenExpiresAt, now time.Time) bool {
+	return !tokenExpiresAt.IsZero() && tokenExpiresAt.Sub(now) <= webTokenRefreshMargin
+}
+
+// startWebLogin stores the tokens of a fresh Strava authorization under a new session ID,
+// stores the athlete, primes the athlete cache, queues the Strava metadata prefetch and returns the ID for
+// the session cookie.
+func (s *server) startWebLogin(tokenResp *strava.StravaTokenResponse) (string, error) {
+	athlete := tokenResp.Athlete
+	if athlete == nil || athlete.ID == 0 {
+		var err error
+		if athlete, err = s.fetchCurrentAthlete(tokenResp.AccessToken); err != nil {
+			return "", fmt.Errorf("failed to fetch current athlete: %w", err)
+		}
+	}
+	sessionID, err := randomURLToken(webSessionIDBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to create web session: %w", err)
+	}
+	stored := webStoredToken{
+		AthleteID:    athlete.ID,
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+		ExpiresAt:    stravaTokenExpiry(tokenResp.ExpiresAt),
+	}
+	if err := s.saveWebToken(sessionID, stored); err != nil {
+		return "", fmt.Errorf("failed to store web session: %w", err)
+	}
+	s.rememberAthlete(athlete)
+	s.cacheWebLogin(sessionID, webAthleteEntry{Athlete: athlete, AccessToken: stored.AccessToken, TokenExpiresAt: stored.ExpiresAt})
+	s.queueAthletePrefetch(athlete.ID, stored.AccessToken)
+	return sessionID, nil
+}
+
+func (s *server) refreshWebToken(sessionID string, stored webStoredToken) (webStoredToken, error) {
+	return s.refreshStoredToken(webSessionStorageKey(sessionID), stored)
+}
+
+// refreshStoredToken refreshes the athlete_tokens row stored under tokenKey
+func (s *server) refreshStoredToken(tokenKey string, stored webStoredToken) (webStoredToken, error) {
+	if strings.TrimSpace(stored.RefreshToken) == "" {
+		return webStoredToken{}, fmt.Errorf("%w: no refresh token stored", errWebLoginRejected)
+	}
+	authCfg := strava.NewStravaAuthConfig(s.cfg.StravaClientID, s.cfg.StravaClientSecret, s.cfg.StravaRedirectURI)
+	tokenResp, err := strava.RefreshAccessToken(s.ctx, *authCfg, stored.RefreshToken)
+	if err != nil {
+		return webStoredToken{}, fmt.Errorf("%w: %w", errWebLoginRejected, err)
+	}
+	if strings.TrimSpace(tokenResp.AccessToken) == "" {
+		return webStoredToken{}, fmt.Errorf("%w: Strava did not return an access token", errWebLoginRejected)
+	}
+	stored.AccessToken = tokenResp.AccessToken
+	if strings.TrimSpace(tokenResp.RefreshToken) != "" {
+		stored.RefreshToken = tokenResp.RefreshToken
+	}
+	stored.ExpiresAt = stravaTokenExpiry(tokenResp.ExpiresAt)
+	if err := s.saveStoredToken(tokenKey, stored); err != nil {
+		return webStoredToken{}, err
+	}
+	// Drop the login's cached identity that still carries the old access token
+	s.webAthletes.forget(tokenKey)
+	s.digest.Record(digestTokenRefresh, "refreshed", "Refreshed Strava access token", "athlete_id", stored.AthleteID)
+	return stored, nil
+}
+
+func (s *server) saveWebToken(sessionID string, stored webStoredToken) error {
+	return s.saveStoredToken(webSessionStorageKey(sessionID), stored)
+}
+
+func (s *server) saveStoredToken(tokenKey string, stored webStoredToken) error {
+	if s.storeToken != nil {
+		return s.storeToken(tokenKey, stored)
+	}
+	accessToken, err := s.encryptSecret(stored.AccessToken)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := s.encryptSecret(stored.RefreshToken)
+	if err != nil {
+		return err
+	}
+	return s.withDB(func(conn *pgxpool.Pool) error {
+		_, err := conn.Exec(s.ctx, `
+			INSERT INTO athlete_tokens (token_key, athlete_id, access_token, refresh_token, expires_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+			ON CONFLICT (token_key) DO UPDATE SET
+				athlete_id = EXCLUDED.athlete_id,
+				access_token = EXCLUDED.access_token,
+				refresh_token = EXCLUDED.refresh_token,
+				expires_at = EXCLUDED.expires_at,
+				updated_at = NOW()
+		`, tokenKey, stored.AthleteID, accessToken, refreshToken, stored.ExpiresAt)
+		return err
+	})
+}
+
+// loadWebToken returns the stored tokens of a web session, or pgx.ErrNoRows. A session
+// past webSessionLifetime is deleted and reported as pgx.ErrNoRows too.
+func (s *server) loadWebToken(sessionID string) (webStoredToken, error) {
+	stored, err := s.readWebToken(sessionID)
+	if err != nil {
+		return webStoredToken{}, err
+	}
+	if webSessionExpired(stored.CreatedAt, time.Now()) {
+		if err := s.deleteWebToken(sessionID); err != nil {
+			slog.Warn("Failed to delete expired web session", "athlete_id", stored.AthleteID, "error", err)
+		}
+		return webStoredToken{}, pgx.ErrNoRows
+	}
+	return stored, nil
+}
+
+func (s *server) readWebToken(sessionID string) (webStoredToken, error) {
+	tokenKey := webSessionStorageKey(sessionID)
+	if s.loadToken != nil {
+		return s.loadToken(tokenKey)
+	}
+	var stored webStoredToken
+	var accessToken, refreshToken string
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return conn.QueryRow(s.ctx, `
+			SELECT athlete_id, access_token, refresh_token, expires_at, COALESCE(created_at, updated_at, NOW())
+			FROM athlete_tokens
+			WHERE token_key = $1
+		`, tokenKey).Scan(&stored.AthleteID, &accessToken, &refreshToken, &stored.ExpiresAt, &stored.CreatedAt)
+	})
+	if err != nil {
+		return webStoredToken{}, err
+	}
+	return s.decryptStoredToken(stored, accessToken, refreshToken)
+}
+
+// loadAthleteToken returns the athlete's most recently updated stored tokens and the key
+// they are stored under, or pgx.ErrNoRows when none of their logins stored any.
+func (s *server) loadAthleteToken(athleteID int64) (string, webStoredToken, error) {
+	var tokenKey string
+	var stored webStoredToken
+	var accessToken, refreshToken string
+	err := s.withDB(func(conn *pgxpool.Pool) error {
+		return conn.QueryRow(s.ctx, `
+			SELECT token_key, athlete_id, access_token, refresh_token, expires_at
+			FROM athlete_tokens
+			WHERE athlete_id = $1
+			ORDER BY updated_at DESC
+			LIMIT 1
+		`, athleteID).Scan(&tokenKey, &stored.AthleteID, &accessToken, &refreshToken, &stored.ExpiresAt)
+	})
+	if err != nil {
+		return "", webStoredToken{}, err
+	}
+	stored, err = s.decryptStoredToken(stored, accessToken, refreshToken)
+	return tokenKey, stored, err
+}
+
+func (s *server) decryptStoredToken(stored webStoredToken, accessToken, refreshToken string) (webStoredToken, error) {
+	var err error
+	if stored.AccessToken, err = s.decryptSecret(accessToken); err != nil {
+		return webStoredToken{}, err
+	}
+	if stored.RefreshToken, err = s.decryptSecret(refreshToken); err != nil {
+		return webStoredToken{}, err
+	}
+	return stored, nil
+}
+
+func (s *server) deleteWebToken(sessionID string) error {
+	tokenKey := webSessionStorageKey(sessionID)
+	s.sessionTouches.forget(tokenKey)
+	if s.deleteToken != nil {
+		return s.deleteToken(tokenKey)
+	}
+	return s.withDB(func(conn *pgxpool.Pool) error {
+		if _, err := conn.Exec(s.ctx, `DELETE FROM web_sessions WHERE token_key = $1`, tokenKey); err != nil {
+			return err
+		}
+		if _, err := conn.Exec(s.ctx, `DELETE FROM announcement_dismissals WHERE token_key = $1`, tokenKey); err != nil {
+			return err
+		}
+		_, err := conn.Exec(s.ctx, `DELETE FROM athlete_tokens WHERE token_key = $1`, tokenKey)
+		return err
+	})
+}
+
+// cacheWebAthlete caches an athlete for a session whose access token is the session ID
+func (s *server) cacheWebAthlete(sessionID string, athlete *strava.Athlete) {
+	s.cacheWebLogin(sessionID, webAthleteEntry{Athlete: athlete, AccessToken: sessionID})
+}
+
+func (s *server) cacheWebLogin(sessionID string, entry webAthleteEntry) {
+	s.webAthletes.store(webSessionStorageKey(sessionID), entry)
+}
+
+func (s *server) forgetWebSession(sessionID string) {
+	s.webAthletes.forget(webSessionStorageKey(sessionID))
+}
+
+func (s *server) forgetWebAthlete(athleteID int64) {
+	s.webAthletes.forgetAthlete(athleteID)
+	s.forgetAthleteProfile(athleteID)
+	s.publicStats.forget(athleteID, 0)
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestWebScopeFromRequestIsPerCookie(t *testing.T) {
+	s := &server{}
+	s.cacheWebAthlete("token-a", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-b", &strava.Athlete{ID: 2})
+
+	for token, want := range map[string]int64{"token-a": 1, "token-b": 2} {
+		req := httptest.NewRequest(http.MethodGet, "/api/activities", nil)
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: token})
+		scope, ok := s.webScopeFromRequest(httptest.NewRecorder(), req)
+		if !ok {
+			t.Fatalf("%s: expected authenticated scope", token)
+		}
+		if scope.AthleteID != want || scope.StravaToken != token {
+			t.Fatalf("%s: got athlete %d token %q, want athlete %d", token, scope.AthleteID, scope.StravaToken, want)
+		}
+	}
+}
+
+func TestWebScopeFromRequestWithoutCookie(t *testing.T) {
+	s := &server{}
+	rec := httptest.NewRecorder()
+	if _, ok := s.webScopeFromRequest(rec, httptest.NewRequest(http.MethodGet, "/api/activities", nil)); ok {
+		t.Fatal("expected anonymous request to be rejected")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestForgetWebAthlete(t *testing.T) {
+	s := &server{}
+	s.cacheWebAthlete("token-a", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-a2", &strava.Athlete{ID: 1})
+	s.cacheWebAthlete("token-b", &strava.Athlete{ID: 2})
+
+	s.forgetWebAthlete(1)
+	if s.webAthletes.len() != 1 {
+		t.Fatalf("expected only athlete 2 to remain, got %d entries", s.webAthletes.len())
+	}
+	s.forgetWebSession("token-b")
+	if s.webAthletes.len() != 0 {
+		t.Fatalf("expected empty cache, got %d entries", s.webAthletes.len())
+	}
+}
+
+func TestWebSessionUsesLoginAccessToken(t *testing.T) {
+	s := &server{}
+	// A login from yesterday: the cookie still holds the original access token while the
+	// stored tokens have since been refreshed.
+	s.cacheWebLogin("cookie-token", webAthleteEntry{
+		Athlete:        &strava.Athlete{ID: 7},
+		AccessToken:    "refreshed-token",
+		TokenExpiresAt: time.Now().Add(5 * time.Hour),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/activities", nil)
+	req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: "cookie-token"})
+	scope := s.webSessionFromRequest(req)
+	if scope.AthleteID != 7 || scope.StravaToken != "refreshed-token" {
+		t.Fatalf("scope = athlete %d token %q, want athlete 7 with the refreshed token", scope.AthleteID, scope.StravaToken)
+	}
+}
+
+func TestWebTokenNeedsRefresh(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      bool
+	}{
+		{name: "no stored token", expiresAt: time.Time{}, want: false},
+		{name: "valid", expiresAt: now.Add(time.Hour), want: false},
+		{name: "inside margin", expiresAt: now.Add(time.Minute), want: true},
+		{name: "expired", expiresAt: now.Add(-time.Hour), want: true},
+	}
+	for _, tt := range tests {
+		if got := webTokenNeedsRefresh(tt.expiresAt, now); got != tt.want {
+			t.Fatalf("%s: webTokenNeedsRefresh = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExpiredWebSessionsAreDeletedOnUse(t *testing.T) {
+	now := time.Now()
+	created := map[string]time.Time{
+		webSessionStorageKey("fresh"): now.Add(-webSessionLifetime + time.Hour),
+		webSessionStorageKey("old"):   now.Add(-webSessionLifetime - time.Hour),
+	}
+	var deleted []string
+	s := &server{
+		loadToken: func(tokenKey string) (webStoredToken, error) {
+			createdAt, ok := created[tokenKey]
+			if !ok {
+				return webStoredToken{}, pgx.ErrNoRows
+			}
+			return webStoredToken{AthleteID: 7, AccessToken: "access", CreatedAt: createdAt}, nil
+		},
+		deleteToken: func(tokenKey string) error {
+			deleted = append(deleted, tokenKey)
+			delete(created, tokenKey)
+			return nil
+		},
+		loadProfile: func(athleteID int64) (*pggeo.AthleteProfile, error) {
+			return &pggeo.AthleteProfile{Athlete: strava.Athlete{ID: athleteID}, PrefetchedAt: now}, nil
+		},
+	}
+
+	for cookie, want := range map[string]int64{"fresh": 7, "old": 0} {
+		req := httptest.NewRequest(http.MethodGet, "/api/activities", nil)
+		req.AddCookie(&http.Cookie{Name: webSessionCookieName, Value: cookie})
+		if scope := s.webSessionFromRequest(req); scope.AthleteID != want {
+			t.Fatalf("%s session resolved athlete %d, want %d", cookie, scope.AthleteID, want)
+		}
+	}
+	if len(deleted) != 1 || deleted[0] != webSessionStorageKey("old") {
+		t.Fatalf("deleted %v, want only the expired session", deleted)
+	}
+}
+
+func TestWebSessionExpired(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		age  time.Duration
+		want bool
+	}{
+		{time.Hour, false},
+		{webSessionLifetime - time.Second, false},
+		{webSessionLifetime, true},
+		{webSessionLifetime + 24*time.Hour, true},
+	} {
+		if got := webSessionExpired(now.Add(-tt.age), now); got != tt.want {
+			t.Errorf("webSessionExpired(age %s) = %v, want %v", tt.age, got, tt.want)
+		}
+	}
+}
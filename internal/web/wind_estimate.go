@@ -0,0 +1,126 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"b11k/internal/analysis"
+	"b11k/internal/cache"
+	"b11k/internal/pggeo"
+	"b11k/internal/strava"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const windEstimateCacheSize = 512
+
+type windEstimateKey struct {
+	athleteID  int64
+	activityID int64
+}
+
+// windEstimateEntry is a computed estimate, or the reason the activity has none
+type windEstimateEntry struct {
+	estimate *analysis.WindEstimate
+	err      error
+}
+
+// windEstimateCache keeps computed wind estimates, including refusals, so each activity's
+// samples are analysed once. The zero value is ready to use.
+type windEstimateCache struct {
+	mu      sync.Mutex
+	entries *cache.LRU[windEstimateKey, windEstimateEntry]
+}
+
+func (c *windEstimateCache) get(key windEstimateKey) (windEstimateEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		return windEstimateEntry{}, false
+	}
+	return c.entries.Get(key)
+}
+
+func (c *windEstimateCache) put(key windEstimateKey, entry windEstimateEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = cache.NewLRU[windEstimateKey, windEstimateEntry](windEstimateCacheSize)
+	}
+	c.entries.Add(key, entry)
+}
+
+func (c *windEstimateCache) forget(key windEstimateKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries != nil {
+		c.entries.Remove(key)
+	}
+}
+
+// registerWindEstimateInvalidator forgets an activity's wind estimate whenever its points
+// change or it is deleted, see pggeo.OnActivityChanged
+func (s *server) registerWindEstimateInvalidator() {
+	pggeo.RegisterActivityInvalidator(pggeo.ActivityInvalidator{
+		Name:    "wind estimates",
+		Changes: []pggeo.ActivityChange{pggeo.ActivityReplaced, pggeo.ActivityDeleted, pggeo.ActivityHealed},
+		Invalidate: func(_ context.Context, _ pggeo.DB, athleteID, activityID int64, _ pggeo.ActivityChange) error {
+			s.windEstimates.forget(windEstimateKey{athleteID: athleteID, activityID: activityID})
+			return nil
+		},
+		Invalidated: func(_ context.Context, _ pggeo.DB, athleteID, activityID int64, _ pggeo.ActivityChange) (bool, error) {
+			_, cached := s.windEstimates.get(windEstimateKey{athleteID: athleteID, activityID: activityID})
+			return !cached, nil
+		},
+	})
+}
+
+// handleActivityWindEstimate handles GET /api/activities/:id/wind-estimate. The estimate is
+// computed on first request; rides that are not out-and-back or lack data get 422 with the
+// reason.
+func (s *server) handleActivityWindEstimate(w http.ResponseWriter, r *http.Request, athleteID, activityID int64) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, newAPIError(http.StatusMethodNotAllowed, "Method not allowed"))
+		return
+	}
+
+	key := windEstimateKey{athleteID: athleteID, activityID: activityID}
+	entry, ok := s.windEstimates.get(key)
+	if !ok {
+		var activity *strava.ActivitySummary
+		var samples []pggeo.PointSample
+		err := s.withReadDB(func(conn *pgxpool.Pool) error {
+			var dbErr error
+			activity, dbErr = pggeo.GetActivityByID(s.ctx, conn, athleteID, activityID)
+			if dbErr != nil {
+				return dbErr
+			}
+			samples, dbErr = pggeo.GetPointSamplesForActivity(s.ctx, conn, athleteID, activityID)
+			return dbErr
+		})
+		if err != nil {
+			if errors.Is(err, pggeo.ErrActivityNotFound) {
+				writeError(w, r, newAPIError(http.StatusNotFound, "activity not found"))
+				return
+			}
+			slog.Error("Failed to load activity for wind estimate", "activity_id", activityID, "error", err)
+			s.handleDBPageError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		entry.estimate, entry.err = analysis.EstimateWind(activity, samples)
+		s.windEstimates.put(key, entry)
+	}
+
+	if entry.err != nil {
+		if errors.Is(entry.err, analysis.ErrWindNotOutAndBack) || errors.Is(entry.err, analysis.ErrWindInsufficientData) {
+			writeError(w, r, newAPIError(http.StatusUnprocessableEntity, entry.err.Error()))
+			return
+		}
+		writeError(w, r, entry.err)
+		return
+	}
+	writeJSON(w, entry.estimate)
+}
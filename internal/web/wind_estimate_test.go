@@ -0,0 +1,72 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"b11k/internal/analysis"
+	"b11k/internal/pggeo"
+)
+
+func TestActivityWindEstimateServesCachedResults(t *testing.T) {
+	s := &server{}
+	s.windEstimates.put(windEstimateKey{athleteID: 1, activityID: 10}, windEstimateEntry{
+		estimate: &analysis.WindEstimate{ActivityID: 10, HeadwindOutMPS: 1.5},
+	})
+	s.windEstimates.put(windEstimateKey{athleteID: 1, activityID: 11}, windEstimateEntry{
+		err: fmt.Errorf("%w: only 40%% of the route retraces itself", analysis.ErrWindNotOutAndBack),
+	})
+
+	rec := httptest.NewRecorder()
+	s.handleActivityWindEstimate(rec, httptest.NewRequest(http.MethodGet, "/api/activities/10/wind-estimate", nil), 1, 10)
+	var estimate analysis.WindEstimate
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &estimate) != nil || estimate.HeadwindOutMPS != 1.5 {
+		t.Fatalf("cached estimate = %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleActivityWindEstimate(rec, httptest.NewRequest(http.MethodGet, "/api/activities/11/wind-estimate", nil), 1, 11)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "not out-and-back") {
+		t.Fatalf("cached refusal = %d %q, want 422 with the reason", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleActivityWindEstimate(rec, httptest.NewRequest(http.MethodPost, "/api/activities/10/wind-estimate", nil), 1, 10)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST = %d, want 405", rec.Code)
+	}
+}
+
+func TestActivityChangesForgetWindEstimates(t *testing.T) {
+	s := &server{}
+	s.registerWindEstimateInvalidator()
+	changed, kept := windEstimateKey{athleteID: 1, activityID: 10}, windEstimateKey{athleteID: 1, activityID: 11}
+	for _, key := range []windEstimateKey{changed, kept} {
+		s.windEstimates.put(key, windEstimateEntry{estimate: &analysis.WindEstimate{ActivityID: key.activityID}})
+	}
+
+	var invalidator *pggeo.ActivityInvalidator
+	for _, registered := range pggeo.ActivityInvalidators() {
+		if registered.Name == "wind estimates" {
+			invalidator = &registered
+		}
+	}
+	if invalidator == nil {
+		t.Fatal("wind estimates invalidator not registered")
+	}
+	ctx := context.Background()
+	if err := invalidator.Invalidate(ctx, nil, changed.athleteID, changed.activityID, pggeo.ActivityHealed); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := invalidator.Invalidated(ctx, nil, changed.athleteID, changed.activityID, pggeo.ActivityHealed); !ok {
+		t.Fatal("healed activity keeps its wind estimate")
+	}
+	if _, ok := s.windEstimates.get(kept); !ok {
+		t.Fatal("another activity's wind estimate was forgotten")
+	}
+}
@@ -1458,6 +1458,9 @@ final class AppViewModel: NSObject, ObservableObject, ASWebAuthenticationPresent
             throw AppError.message("Invalid backend response.")
         }
         guard (200..<300).contains(http.statusCode) else {
+            if let apiError = try? JSONDecoder().decode(APIErrorResponse.self, from: data) {
+                throw AppError.http(http.statusCode, apiError.error.message)
+            }
             let text = String(data: data, encoding: .utf8) ?? "HTTP \(http.statusCode)"
             throw AppError.http(http.statusCode, text.trimmingCharacters(in: .whitespacesAndNewlines))
         }
@@ -1548,6 +1551,16 @@ struct AuthSessionResponse: Decodable {
     }
 }
 
+// API errors are {"error": {"code", "message"}}
+struct APIErrorResponse: Decodable {
+    struct Detail: Decodable {
+        let code: String
+        let message: String
+    }
+
+    let error: Detail
+}
+
 struct LogoutResponse: Decodable {
     let loggedOut: Bool
 
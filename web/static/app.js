@@ -1,4 +1,36 @@
 (() => {
+  // The server injects its base_path so the app also works under a URL prefix
+  function appURL(path) {
+    return (window.__BASE_PATH__ || '') + path;
+  }
+
+  // Power graphs use a 30 s rolling average, as normalized power does
+  function graphSmoothQuery(metrics) {
+    return metrics.includes('watts') ? '&smooth=30' : '';
+  }
+
+  // Streamed arrays end with a {stream_error} element when the server failed mid-response;
+  // drop it so the points before it still render
+  function streamedArray(items) {
+    if (!Array.isArray(items) || items.length === 0) return items;
+    const last = items[items.length - 1];
+    if (last && last.stream_error) {
+      console.warn(last.stream_error);
+      return items.slice(0, -1);
+    }
+    return items;
+  }
+
+  // API errors are {"error": {"code", "message"}}; other answers are plain text
+  async function responseError(response) {
+    const text = await response.text();
+    try {
+      const body = JSON.parse(text);
+      if (body && body.error && body.error.message) return body.error.message;
+    } catch (_) { /* plain-text error */ }
+    return text.trim();
+  }
+
   function onActivityPage() {
     const mapStyleURL = window.__MAP_STYLE_URL__;
     if (!mapStyleURL) return;
@@ -12,7 +44,7 @@
       zoom: 2
     });
     installMissingStyleImageFallback(map);
-    fetch('/api/activities/' + id + '/points').then(r=>r.json()).then(points => {
+    fetch(appURL('/api/activities/') + id + '/points').then(r=>r.json()).then(streamedArray).then(points => {
       if (!Array.isArray(points) || points.length===0) return;
       const lineCoords = points.map(p => [p.lng, p.lat]);
       const features = points.map((p, idx) => ({
@@ -237,7 +269,7 @@
                 if (legend) legend.style.display = 'none';
               } else if (metric === 'hrzones') {
               try {
-                const zr = await fetch('/api/hrzones');
+                const zr = await fetch(appURL('/api/hrzones'));
                 if (!zr.ok) throw new Error('zones fetch failed');
                 const z = await zr.json();
                 const colors = ['#1b3a8a', '#00c2ff', '#2ecc71', '#f1c40f', '#e74c3c'];
@@ -526,7 +558,7 @@
               const description = document.getElementById('segment-description').value.trim();
 
               try {
-                const response = await fetch('/api/segments', {
+                const response = await fetch(appURL('/api/segments'), {
                   method: 'POST',
                   headers: { 'Content-Type': 'application/json' },
                   body: JSON.stringify({
@@ -539,7 +571,7 @@
                 });
 
                 if (!response.ok) {
-                  const error = await response.text();
+                  const error = await responseError(response);
                   throw new Error(error || 'Failed to create segment');
                 }
 
@@ -547,10 +579,10 @@
                 segmentForm.reset();
                 const segmentID = segment.id || segment.ID;
                 if (segmentID) {
-                  window.location.href = `/segment/${segmentID}`;
+                  window.location.href = appURL(`/segment/${segmentID}`);
                 } else {
                   setSegmentMode(false);
-                  window.location.href = '/segments';
+                  window.location.href = appURL('/segments');
                 }
               } catch (error) {
                 alert('Error creating segment: ' + error.message);
@@ -632,12 +664,12 @@
             if (metric2) metrics.push(metric2);
             
             const includeZones = metric1 === 'heartrate' || metric2 === 'heartrate';
-            const url = `/api/activities/${id}/graph?metrics=${metrics.join(',')}&include_zones=${includeZones}`;
+            const url = appURL(`/api/activities/${id}/graph?metrics=${metrics.join(',')}&include_zones=${includeZones}${graphSmoothQuery(metrics)}`);
             
             try {
               const response = await fetch(url);
               if (!response.ok) {
-                const errorText = await response.text();
+                const errorText = await responseError(response);
                 throw new Error(`Failed to fetch graph data: ${response.status} ${errorText}`);
               }
               const data = await response.json();
@@ -657,7 +689,9 @@
                 speed: getCSSVar('--graph-speed'),
                 heartrate: getCSSVar('--graph-heartrate'),
                 height: getCSSVar('--graph-height'),
-                cadence: getCSSVar('--graph-cadence')
+                cadence: getCSSVar('--graph-cadence'),
+                watts: getCSSVar('--graph-watts'),
+                grade: getCSSVar('--graph-grade')
               };
               
               let yAxisIDLeft = 'y';
@@ -858,6 +892,8 @@
                               else if (label === 'HR') unit = ' bpm';
                               else if (label === 'Height') unit = ' m';
                               else if (label === 'Cadence') unit = ' rpm';
+                              else if (label === 'Watts') unit = ' W';
+                              else if (label === 'Grade') unit = ' %';
                               return `${label}: ${value.toFixed(1)}${unit}`;
                             }
                           }
@@ -1057,11 +1093,11 @@
 
   async function loadRouteMarkerImages(map) {
     const markerIcons = [
-      { id: 'route-marker-start', path: '/static/icons/point.svg', color: '#47d18c', size: 40 },
-      { id: 'route-marker-finish', path: '/static/icons/point.svg', color: '#e74c3c', size: 40 },
-      { id: 'route-marker-hr', path: '/static/icons/hr.svg', color: '#ff7a59', size: 34 },
-      { id: 'route-marker-speed', path: '/static/icons/speed.svg', color: '#ff7a59', size: 34 },
-      { id: 'route-marker-cadence', path: '/static/icons/cadence.svg', color: '#ff7a59', size: 34 }
+      { id: 'route-marker-start', path: appURL('/static/icons/point.svg'), color: '#47d18c', size: 40 },
+      { id: 'route-marker-finish', path: appURL('/static/icons/point.svg'), color: '#e74c3c', size: 40 },
+      { id: 'route-marker-hr', path: appURL('/static/icons/hr.svg'), color: '#ff7a59', size: 34 },
+      { id: 'route-marker-speed', path: appURL('/static/icons/speed.svg'), color: '#ff7a59', size: 34 },
+      { id: 'route-marker-cadence', path: appURL('/static/icons/cadence.svg'), color: '#ff7a59', size: 34 }
     ];
 
     await Promise.all(markerIcons.map(icon => addTintedSvgImage(map, icon)));
@@ -1358,6 +1394,7 @@
     const progressText = document.getElementById('progress-text');
     const progressPhase = document.getElementById('progress-phase');
     const progressBarContainer = document.getElementById('progress-bar-container');
+    const cancelBtn = document.getElementById('sync-cancel');
     
     if (!form || !logEl) return;
     
@@ -1367,11 +1404,24 @@
       e.preventDefault();
       const fd = new FormData(form);
       const params = new URLSearchParams();
-      const start = fd.get('start');
-      const end = fd.get('end');
-      if (start) params.set('start', start);
-      if (end) params.set('end', end);
-      const url = '/strava/sync' + (params.toString() ? ('?' + params.toString()) : '');
+      // "Sync new" picks the timeframe on the server from the latest stored activity
+      if (e.submitter && e.submitter.value === 'incremental') {
+        params.set('mode', 'incremental');
+      } else {
+        const start = fd.get('start');
+        const end = fd.get('end');
+        if (start) params.set('start', start);
+        if (end) params.set('end', end);
+      }
+      const types = (fd.get('types') || '').trim();
+      if (types) params.set('types', types);
+      const url = appURL('/strava/sync') + (params.toString() ? ('?' + params.toString()) : '');
+      watchSync(url);
+    });
+
+    // The sync runs as a background job on the server: follow its events (starting it
+    // unless the URL only attaches) until it finishes
+    function watchSync(url) {
       logEl.style.display = 'block';
       logEl.textContent = '';
       // Force show progress elements
@@ -1399,11 +1449,21 @@
         }
       }
       currentPhase = null;
-      
+      if (cancelBtn) cancelBtn.style.display = '';
+
       const ev = new EventSource(url);
       ev.addEventListener('log', (m) => { logEl.textContent += m.data + "\n"; });
       ev.addEventListener('summary', (m) => { logEl.textContent += "Summary: " + m.data + "\n"; });
-      ev.addEventListener('error', (m) => { logEl.textContent += "Error: " + m.data + "\n"; });
+      ev.addEventListener('error', (m) => {
+        // Named "error" events carry data from the server; connection errors do not
+        if (m.data === undefined) return;
+        logEl.textContent += "Error: " + m.data + "\n";
+        ev.close();
+        if (progressEl) {
+          progressEl.style.display = 'none';
+        }
+        if (cancelBtn) cancelBtn.style.display = 'none';
+      });
       ev.addEventListener('progress', (m) => {
         try {
           const data = JSON.parse(m.data);
@@ -1464,6 +1524,7 @@
           const phaseLabels = {
             'fetching_activities': 'Fetching activities',
             'fetching_details': 'Fetching details',
+            'waiting_rate_limit': 'Waiting for Strava rate limit',
             'saving': 'Saving activities'
           };
           if (progressPhase) {
@@ -1482,21 +1543,76 @@
           // Silently ignore parsing errors
         }
       });
-      ev.addEventListener('done', () => { 
-        ev.close(); 
+      ev.addEventListener('done', (m) => {
+        ev.close();
         // Hide progress bar upon completion
         if (progressEl) {
           progressEl.style.display = 'none';
         }
-        location.reload(); 
+        if (cancelBtn) cancelBtn.style.display = 'none';
+        // "idle" answers an attach when no sync is running
+        if (m.data !== 'idle') location.reload();
       });
-      ev.onerror = () => { 
-        ev.close(); 
+      ev.onerror = () => {
+        // EventSource reconnects with Last-Event-ID and re-attaches to the running sync;
+        // only give up once the browser has stopped retrying
+        if (ev.readyState !== EventSource.CLOSED) return;
         if (progressEl) {
           progressEl.style.display = 'none';
         }
+        if (cancelBtn) cancelBtn.style.display = 'none';
       };
-    });
+    }
+
+    if (cancelBtn) {
+      cancelBtn.addEventListener('click', async () => {
+        cancelBtn.disabled = true;
+        try {
+          const res = await fetch(appURL('/api/sync/cancel'), { method: 'POST' });
+          if (!res.ok) logEl.textContent += "Cancel failed: " + await responseError(res) + "\n";
+        } finally {
+          cancelBtn.disabled = false;
+        }
+      });
+    }
+
+    // A pull started by viewing this page runs quietly: once it has saved activities,
+    // offer a refresh instead of reloading under the reader
+    function watchAutoPull() {
+      const ev = new EventSource(appURL('/strava/sync?attach=1'));
+      ev.addEventListener('done', () => {
+        ev.close();
+        fetch(appURL('/api/sync/status')).then((res) => res.ok ? res.json() : null).then(showAutoPullBanner).catch(() => {});
+      });
+      ev.addEventListener('error', (m) => {
+        if (m.data !== undefined) ev.close();
+      });
+    }
+
+    function showAutoPullBanner(status) {
+      const banner = document.getElementById('sync-banner');
+      if (!banner || !status || !status.auto || status.state !== 'done') return;
+      const saved = status.summary ? status.summary.success : 0;
+      // Each pull is announced once per tab, so the refreshed page does not offer it again
+      if (!saved || sessionStorage.getItem('b11k.autoPullSeen') === status.finished_at) return;
+      banner.textContent = `Found ${saved} new ${saved === 1 ? 'activity' : 'activities'} — `;
+      const link = document.createElement('a');
+      link.href = location.href;
+      link.textContent = 'refresh';
+      link.addEventListener('click', () => sessionStorage.setItem('b11k.autoPullSeen', status.finished_at));
+      banner.appendChild(link);
+      banner.hidden = false;
+    }
+
+    // A sync started earlier keeps running after the tab closes: show its live progress
+    fetch(appURL('/api/sync/status')).then((res) => res.ok ? res.json() : null).then((status) => {
+      if (status && status.state === 'running') {
+        if (status.auto) watchAutoPull();
+        else watchSync(appURL('/strava/sync?attach=1'));
+      } else {
+        showAutoPullBanner(status);
+      }
+    }).catch(() => {});
   }
 
   function fmtSpeed(v){ return v!=null ? (v*3.6).toFixed(1)+" km/h" : '—'; }
@@ -1513,6 +1629,332 @@
     window.location.href = url.toString();
   };
 
+  // Pin/unpin buttons on the activities and segments lists; the page is reloaded so the
+  // server can put pinned items first
+  function onPinButtons() {
+    document.querySelectorAll('.pin-toggle-btn[data-pin-id]').forEach(btn => {
+      btn.addEventListener('click', async () => {
+        const kind = btn.dataset.pinKind;
+        const action = btn.dataset.pinned === 'true' ? 'unpin' : 'pin';
+        btn.disabled = true;
+        try {
+          const response = await fetch(appURL(`/api/${kind}/${btn.dataset.pinId}/${action}`), { method: 'POST' });
+          if (!response.ok) {
+            const error = await responseError(response);
+            throw new Error(error || `Failed to ${action}`);
+          }
+          window.location.reload();
+        } catch (err) {
+          btn.disabled = false;
+          alert(err.message);
+        }
+      });
+    });
+  }
+
+  // Shows "Setting things up" in the topbar until the post-login Strava prefetch has landed
+  async function onSetupHint() {
+    const hint = document.getElementById('setup-hint');
+    if (!hint) return;
+    for (let attempt = 0; attempt < 30; attempt++) {
+      try {
+        const response = await fetch(appURL('/api/me/ready'));
+        if (!response.ok) break;
+        const ready = await response.json();
+        hint.hidden = ready.ready;
+        if (ready.ready) break;
+      } catch (_) {
+        break;
+      }
+      await new Promise(resolve => setTimeout(resolve, 1000));
+    }
+    hint.hidden = true;
+  }
+
+  // Announcement banners under the topbar. A logged-in dismissal is stored for the web
+  // session; without one the banner only stays hidden in this tab.
+  function onAnnouncements() {
+    const banners = document.querySelectorAll('[data-announcement-id]');
+    if (!banners.length) return;
+    const dismissed = (sessionStorage.getItem('b11k.dismissedAnnouncements') || '').split(',');
+    banners.forEach((banner) => {
+      if (dismissed.includes(banner.dataset.announcementId)) banner.remove();
+    });
+    const rememberInTab = (id) => {
+      if (!dismissed.includes(id)) dismissed.push(id);
+      sessionStorage.setItem('b11k.dismissedAnnouncements', dismissed.filter(Boolean).join(','));
+    };
+    document.querySelectorAll('[data-announcement-dismiss]').forEach((button) => {
+      button.addEventListener('click', () => {
+        const id = button.dataset.announcementDismiss;
+        const banner = button.closest('[data-announcement-id]');
+        if (banner) banner.remove();
+        fetch(appURL(`/api/announcements/${id}/dismiss`), { method: 'POST' })
+          .then((response) => { if (!response.ok) rememberInTab(id); })
+          .catch(() => rememberInTab(id));
+      });
+    });
+  }
+
+  // Stacked weekly time-in-zone chart on the profile page from /api/training-load
+  function onTrainingLoad() {
+    const canvas = document.getElementById('training-load-chart');
+    if (!canvas || typeof Chart === 'undefined') return;
+    const summary = document.getElementById('training-load-summary');
+    const styles = getComputedStyle(document.documentElement);
+    const zoneColor = (i) => styles.getPropertyValue(`--graph-hr-zone${Math.min(i + 1, 5)}`).trim();
+    fetch(appURL('/api/training-load?weeks=12'))
+      .then((response) => {
+        if (!response.ok) throw new Error('Failed to load training load');
+        return response.json();
+      })
+      .then((load) => {
+        const weeks = load.weeks || [];
+        const zones = load.zones || [];
+        if (!zones.length && summary) {
+          summary.textContent = 'Heart rate zones are not available, so time in zone cannot be shown.';
+        }
+        const labels = weeks.map((week) => new Date(week.start).toLocaleDateString(undefined, { month: 'short', day: 'numeric' }));
+        const datasets = zones.map((zone, i) => ({
+          label: zone.label,
+          data: weeks.map((week) => (week.zone_seconds[i] || 0) / 3600),
+          backgroundColor: zoneColor(i),
+          stack: 'zones'
+        }));
+        new Chart(canvas.getContext('2d'), {
+          type: 'bar',
+          data: { labels, datasets },
+          options: {
+            responsive: true,
+            maintainAspectRatio: false,
+            scales: {
+              x: { stacked: true },
+              y: { stacked: true, title: { display: true, text: 'Hours' } }
+            },
+            plugins: {
+              tooltip: {
+                callbacks: {
+                  label: (item) => `${item.dataset.label}: ${item.parsed.y.toFixed(1)} h`,
+                  footer: (items) => {
+                    const week = weeks[items[0].dataIndex];
+                    return `${week.activities} activities · ${(week.distance_m / 1000).toFixed(1)} km · suffer score ${Math.round(week.suffer_score)}`;
+                  }
+                }
+              }
+            }
+          }
+        });
+      })
+      .catch((error) => {
+        if (summary) summary.textContent = error.message;
+      });
+  }
+
+  // Notes editor on the activity page; the server renders the markdown and answers with notes_html
+  function onActivityNotes() {
+    const btn = document.getElementById('activity-notes-save-btn');
+    const input = document.getElementById('activity-notes-input');
+    const rendered = document.getElementById('activity-notes');
+    if (!btn || !input || !rendered) return;
+
+    btn.addEventListener('click', async () => {
+      btn.disabled = true;
+      try {
+        const response = await fetch(appURL(`/api/activities/${btn.dataset.activityId}`), {
+          method: 'PATCH',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ notes: input.value }),
+        });
+        if (!response.ok) {
+          const error = await responseError(response);
+          throw new Error(error || 'Failed to save notes');
+        }
+        const result = await response.json();
+        rendered.innerHTML = result.notes_html || '<p class="muted">No notes yet.</p>';
+        input.value = result.notes || '';
+      } catch (err) {
+        alert('Error saving notes: ' + err.message);
+      } finally {
+        btn.disabled = false;
+      }
+    });
+  }
+
+  // Previous rides of the same route on the activity page, hidden when there are none or
+  // the visitor is not logged in
+  async function onSimilarActivities() {
+    const panel = document.getElementById('similar-activities');
+    const summary = document.getElementById('similar-activities-summary');
+    const list = document.getElementById('similar-activities-list');
+    if (!panel || !summary || !list) return;
+
+    const formatDuration = seconds => {
+      const total = Math.round(seconds);
+      const h = Math.floor(total / 3600);
+      const m = Math.floor((total % 3600) / 60);
+      const s = total % 60;
+      if (h > 0) return `${h}:${String(m).padStart(2, '0')}:${String(s).padStart(2, '0')}`;
+      return `${m}:${String(s).padStart(2, '0')}`;
+    };
+    try {
+      const response = await fetch(appURL(`/api/activities/${panel.dataset.activityId}/similar`));
+      if (!response.ok) return;
+      const result = await response.json();
+      const activities = result.activities || [];
+      if (activities.length === 0) return;
+
+      const fastest = activities.reduce((best, a) => (a.elapsed_time < best.elapsed_time ? a : best));
+      summary.textContent = `Ridden ${activities.length} other time${activities.length === 1 ? '' : 's'}, fastest ${formatDuration(fastest.elapsed_time)}`;
+      list.replaceChildren(...activities.map(a => {
+        const item = document.createElement('li');
+        const link = document.createElement('a');
+        link.className = 'link';
+        link.href = appURL(`/activity/${a.activity_id}`);
+        link.textContent = new Date(a.start_date).toLocaleDateString(undefined, { month: 'short', day: 'numeric', year: 'numeric' });
+        const times = document.createElement('span');
+        times.className = 'muted';
+        times.textContent = `${formatDuration(a.elapsed_time)} (moving ${formatDuration(a.moving_time)})`;
+        item.append(link, times);
+        return item;
+      }));
+      panel.hidden = false;
+    } catch (err) {
+      console.warn('Failed to load similar activities', err);
+    }
+  }
+
+  // Name and description editor on the activity page; the edit is kept locally even when
+  // sending it on to Strava fails, which the server reports as partial
+  function onActivityDetails() {
+    const btn = document.getElementById('activity-details-save-btn');
+    const nameInput = document.getElementById('activity-name-input');
+    const descriptionInput = document.getElementById('activity-description-input');
+    const title = document.getElementById('activity-name');
+    const description = document.getElementById('activity-description');
+    if (!btn || !nameInput || !descriptionInput || !title || !description) return;
+
+    btn.addEventListener('click', async () => {
+      btn.disabled = true;
+      try {
+        const response = await fetch(appURL(`/api/activities/${btn.dataset.activityId}`), {
+          method: 'PATCH',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ name: nameInput.value, description: descriptionInput.value }),
+        });
+        if (!response.ok) {
+          const error = await responseError(response);
+          throw new Error(error || 'Failed to save activity');
+        }
+        const result = await response.json();
+        title.textContent = result.name;
+        nameInput.value = result.name;
+        description.textContent = result.description || '';
+        description.hidden = !result.description;
+        if (result.partial) {
+          alert('Saved here, but not on Strava: ' + ((result.strava && result.strava.error) || 'unknown error'));
+        }
+      } catch (err) {
+        alert('Error saving activity: ' + err.message);
+      } finally {
+        btn.disabled = false;
+      }
+    });
+  }
+
+  // Delete button on the activity page; a deleted activity has no page, so go back to the list
+  function onSessionRevoke() {
+    document.querySelectorAll('[data-session-revoke]').forEach((button) => {
+      button.addEventListener('click', async () => {
+        const current = button.dataset.current === 'true';
+        if (current && !confirm('Log out of this browser?')) return;
+        button.disabled = true;
+        try {
+          const query = current ? '?confirm=current' : '';
+          const response = await fetch(appURL(`/api/sessions/${button.dataset.sessionRevoke}${query}`), { method: 'DELETE' });
+          if (!response.ok) {
+            const error = await responseError(response);
+            throw new Error(error || 'Failed to revoke session');
+          }
+          if (current) {
+            window.location.href = appURL('/');
+            return;
+          }
+          const row = button.closest('[data-session-row]');
+          if (row) row.remove();
+        } catch (err) {
+          button.disabled = false;
+          alert('Error revoking session: ' + err.message);
+        }
+      });
+    });
+  }
+
+  function onActivityDelete() {
+    const btn = document.getElementById('delete-activity-btn');
+    const modal = document.getElementById('delete-activity-modal');
+    const cancelBtn = document.getElementById('delete-activity-cancel-btn');
+    const confirmBtn = document.getElementById('delete-activity-confirm-btn');
+    if (!btn || !modal || !cancelBtn || !confirmBtn) return;
+
+    btn.addEventListener('click', () => {
+      modal.style.display = 'flex';
+    });
+    cancelBtn.addEventListener('click', () => {
+      modal.style.display = 'none';
+    });
+    confirmBtn.addEventListener('click', async () => {
+      confirmBtn.disabled = true;
+      try {
+        const response = await fetch(appURL(`/api/activities/${btn.dataset.activityId}`), { method: 'DELETE' });
+        if (!response.ok) {
+          const error = await responseError(response);
+          throw new Error(error || 'Failed to delete activity');
+        }
+        window.location.href = appURL('/');
+      } catch (err) {
+        confirmBtn.disabled = false;
+        alert('Error deleting activity: ' + err.message);
+      }
+    });
+  }
+
+  function onImportForm() {
+    const form = document.getElementById('import-form');
+    if (!form) return;
+    const status = document.getElementById('import-status');
+    form.addEventListener('submit', async (e) => {
+      e.preventDefault();
+      const button = form.querySelector('button[type="submit"]');
+      button.disabled = true;
+      status.textContent = 'Importing...';
+      try {
+        const response = await fetch(appURL('/api/activities/import'), { method: 'POST', body: new FormData(form) });
+        const text = await response.text();
+        let result = null;
+        try { result = JSON.parse(text); } catch (_) { /* plain-text error */ }
+        if (!result || !result.results) throw new Error((result && result.error && result.error.message) || text || 'Import failed');
+        const failures = result.results.filter(r => r.error).map(r => `${r.filename}: ${r.error}`);
+        const known = result.results.filter(r => r.status === 'already_imported').map(r => `${r.filename}: already imported as activity ${r.activity_id}`);
+        if (result.imported > 0) {
+          if (failures.length) alert(`Some files were not imported:\n${failures.join('\n')}`);
+          window.location.reload();
+          return;
+        }
+        if (known.length && !failures.length) {
+          status.textContent = '';
+          button.disabled = false;
+          alert(known.join('\n'));
+          return;
+        }
+        throw new Error(failures.concat(known).join('\n') || 'Import failed');
+      } catch (err) {
+        status.textContent = '';
+        button.disabled = false;
+        alert(err.message);
+      }
+    });
+  }
+
   function onSegmentsPage() {
     const dashboard = document.getElementById('segments-dashboard');
     const filterInput = document.getElementById('segments-filter');
@@ -1530,12 +1972,36 @@
       const value = Number(card.dataset[key]);
       return Number.isFinite(value) ? value : 0;
     };
+    const pinnedFirst = new URLSearchParams(window.location.search).get('pinned_first') !== 'false';
+    const pinRank = card => (pinnedFirst && card.dataset.pinned === 'true' ? 0 : 1);
+
+    const compareBy = (a, b) => {
+      switch (sortSelect?.value || 'name') {
+      case 'attempts':
+        return asNumber(b, 'attempts') - asNumber(a, 'attempts');
+      case 'best':
+        return asNumber(a, 'best') - asNumber(b, 'best');
+      case 'worst':
+        return asNumber(b, 'worst') - asNumber(a, 'worst');
+      case 'minhr':
+        return asNumber(a, 'minhr') - asNumber(b, 'minhr');
+      case 'maxhr':
+        return asNumber(b, 'maxhr') - asNumber(a, 'maxhr');
+      case 'slope':
+        return asNumber(b, 'slope') - asNumber(a, 'slope');
+      case 'ascent':
+        return asNumber(b, 'ascent') - asNumber(a, 'ascent');
+      case 'direction':
+        return (a.dataset.direction || '').localeCompare(b.dataset.direction || '') || (a.dataset.name || '').localeCompare(b.dataset.name || '');
+      default:
+        return (a.dataset.name || '').localeCompare(b.dataset.name || '');
+      }
+    };
 
     const applyDashboardControls = () => {
       if (!dashboard) return;
       const query = (filterInput?.value || '').trim().toLowerCase();
       const direction = directionSelect?.value || 'all';
-      const sortBy = sortSelect?.value || 'name';
 
       const visible = segmentCards.filter(card => {
         const matchesText = !query || (card.dataset.name || '').includes(query);
@@ -1544,29 +2010,7 @@
         return !card.hidden;
       });
 
-      visible.sort((a, b) => {
-        switch (sortBy) {
-        case 'attempts':
-          return asNumber(b, 'attempts') - asNumber(a, 'attempts');
-        case 'best':
-          return asNumber(a, 'best') - asNumber(b, 'best');
-        case 'worst':
-          return asNumber(b, 'worst') - asNumber(a, 'worst');
-        case 'minhr':
-          return asNumber(a, 'minhr') - asNumber(b, 'minhr');
-        case 'maxhr':
-          return asNumber(b, 'maxhr') - asNumber(a, 'maxhr');
-        case 'slope':
-          return asNumber(b, 'slope') - asNumber(a, 'slope');
-        case 'ascent':
-          return asNumber(b, 'ascent') - asNumber(a, 'ascent');
-        case 'direction':
-          return (a.dataset.direction || '').localeCompare(b.dataset.direction || '') || (a.dataset.name || '').localeCompare(b.dataset.name || '');
-        default:
-          return (a.dataset.name || '').localeCompare(b.dataset.name || '');
-        }
-      });
-
+      visible.sort((a, b) => pinRank(a) - pinRank(b) || compareBy(a, b));
       visible.forEach(card => dashboard.appendChild(card));
     };
 
@@ -1577,6 +2021,28 @@
       applyDashboardControls();
     }
 
+    document.querySelectorAll('.rename-segment-btn').forEach(btn => {
+      btn.addEventListener('click', async () => {
+        const current = btn.getAttribute('data-segment-name') || '';
+        const name = (window.prompt('New segment name', current) || '').trim();
+        if (!name || name === current) return;
+        try {
+          const response = await fetch(appURL(`/api/segments/${btn.getAttribute('data-segment-id')}`), {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name })
+          });
+          if (!response.ok) {
+            const error = await responseError(response);
+            throw new Error(error || 'Failed to rename segment');
+          }
+          window.location.reload();
+        } catch (err) {
+          alert(err.message);
+        }
+      });
+    });
+
     if (deleteButtons.length > 0 && deleteModal && deleteCancelBtn && deleteConfirmBtn) {
       deleteButtons.forEach(btn => {
         btn.addEventListener('click', () => {
@@ -1597,12 +2063,12 @@
         if (!segmentToDelete) return;
 
         try {
-          const response = await fetch(`/api/segments/${segmentToDelete}`, {
+          const response = await fetch(appURL(`/api/segments/${segmentToDelete}`), {
             method: 'DELETE'
           });
 
           if (!response.ok) {
-            const error = await response.text();
+            const error = await responseError(response);
             throw new Error(error || 'Failed to delete segment');
           }
 
@@ -1621,7 +2087,7 @@
           if (remainingSegments.length === 0) {
             const list = document.querySelector('#segments-dashboard');
             if (list) {
-              list.innerHTML = '<div class="item">No segments found. Create segments from activity pages.</div>';
+              list.innerHTML = '<div class="item">No segments found. Create segments from activity pages or draw one above.</div>';
             }
           }
         } catch (error) {
@@ -1639,6 +2105,90 @@
     }
   }
 
+  function onSegmentDraw() {
+    const panel = document.getElementById('segment-draw');
+    const nameInput = document.getElementById('segment-draw-name');
+    const undoBtn = document.getElementById('segment-draw-undo-btn');
+    const clearBtn = document.getElementById('segment-draw-clear-btn');
+    const saveBtn = document.getElementById('segment-draw-save-btn');
+    const statusEl = document.getElementById('segment-draw-status');
+    if (!panel || !nameInput || !saveBtn || typeof maplibregl === 'undefined') return;
+
+    // points are [lat, lng] pairs, the order POST /api/segments expects
+    const points = [];
+    let map = null;
+
+    const drawnGeoJSON = () => ({
+      type: 'FeatureCollection',
+      features: [
+        ...(points.length > 1 ? [{ type: 'Feature', geometry: { type: 'LineString', coordinates: points.map(p => [p[1], p[0]]) } }] : []),
+        ...points.map(p => ({ type: 'Feature', geometry: { type: 'Point', coordinates: [p[1], p[0]] } }))
+      ]
+    });
+
+    const refresh = () => {
+      const source = map && map.getSource('segment-draw');
+      if (source) source.setData(drawnGeoJSON());
+      saveBtn.disabled = points.length < 2 || nameInput.value.trim() === '';
+      statusEl.textContent = points.length === 0 ? '' : `${points.length} point${points.length === 1 ? '' : 's'}`;
+    };
+
+    const createMap = () => {
+      map = new maplibregl.Map({
+        container: 'segment-draw-map',
+        style: window.__MAP_STYLE_URL__,
+        center: [0, 0],
+        zoom: 2
+      });
+      installMissingStyleImageFallback(map);
+      map.getCanvas().style.cursor = 'crosshair';
+      map.on('load', () => {
+        map.addSource('segment-draw', { type: 'geojson', data: drawnGeoJSON() });
+        map.addLayer({ id: 'segment-draw-line', type: 'line', source: 'segment-draw', filter: ['==', ['geometry-type'], 'LineString'], paint: { 'line-color': '#e4572e', 'line-width': 4 } });
+        map.addLayer({ id: 'segment-draw-points', type: 'circle', source: 'segment-draw', filter: ['==', ['geometry-type'], 'Point'], paint: { 'circle-radius': 4, 'circle-color': '#ffffff', 'circle-stroke-color': '#e4572e', 'circle-stroke-width': 2 } });
+      });
+      map.on('click', e => {
+        points.push([e.lngLat.lat, e.lngLat.lng]);
+        refresh();
+      });
+    };
+
+    // The map is created when the panel is first opened so it gets a sized container
+    panel.addEventListener('toggle', () => {
+      if (panel.open && !map) createMap();
+    });
+    nameInput.addEventListener('input', refresh);
+    undoBtn?.addEventListener('click', () => {
+      points.pop();
+      refresh();
+    });
+    clearBtn?.addEventListener('click', () => {
+      points.length = 0;
+      refresh();
+    });
+    saveBtn.addEventListener('click', async () => {
+      const name = nameInput.value.trim();
+      if (!name || points.length < 2) return;
+      saveBtn.disabled = true;
+      statusEl.textContent = 'Creating segment...';
+      try {
+        const response = await fetch(appURL('/api/segments'), {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ name, points })
+        });
+        if (!response.ok) {
+          const error = await responseError(response);
+          throw new Error(error || 'Failed to create segment');
+        }
+        window.location.reload();
+      } catch (err) {
+        statusEl.textContent = err.message;
+        refresh();
+      }
+    });
+  }
+
   function onSegmentPage() {
     const mapStyleURL = window.__MAP_STYLE_URL__;
     const segmentID = window.__SEGMENT_ID__;
@@ -1656,7 +2206,7 @@
     installMissingStyleImageFallback(map);
 
     // Load segment geometry and display on map
-    fetch(`/api/segments/${segmentID}`).then(r => r.json()).then(segment => {
+    fetch(appURL(`/api/segments/${segmentID}`)).then(r => r.json()).then(segment => {
       if (!segment || !segment.segment_geog) return;
 
       // Parse WKT LINESTRING
@@ -1733,7 +2283,7 @@
       }
       
         // Fetch segment metrics from API
-      fetch(`/api/segments/${segmentID}/metrics`)
+      fetch(appURL(`/api/segments/${segmentID}/metrics`))
         .then(r => {
           if (!r.ok) {
             throw new Error(`HTTP ${r.status}: ${r.statusText}`);
@@ -1774,6 +2324,7 @@
     const refreshBtn = document.getElementById('refresh-cache-btn');
     const toleranceInput = document.getElementById('tolerance');
     const sortSelect = document.getElementById('sort-by');
+    const directionSelect = document.getElementById('effort-direction');
     const activitiesSection = document.getElementById('activities-section');
     const activitiesList = document.getElementById('activities-list');
     const activitiesLoading = document.getElementById('activities-loading');
@@ -1790,8 +2341,15 @@
       "'": '&#39;'
     }[ch]));
 
+    // Efforts are keyed by activity and traversal: one activity can cross the segment more than once
+    const effortKey = activity => `${activity.id}:${activity.effort_number || 1}`;
+    const effortLapLabel = activity => activity.effort_count > 1 ? `Lap ${activity.effort_number} of ${activity.effort_count}` : '';
+    const effortTitle = activity => {
+      const lap = effortLapLabel(activity);
+      return `${activity.name || 'Effort'}${lap ? ` (${lap})` : ''}`;
+    };
     const secondsValue = value => Number.isFinite(Number(value)) && Number(value) > 0 ? Number(value) : null;
-    const speedValue = activity => Number(activity.segment_avg_speed || activity.average_speed || 0);
+    const speedValue = activity => Number(activity.segment_avg_speed_elapsed || activity.avg_speed_elapsed || 0);
     const hrValue = activity => Number(activity.segment_avg_hr || activity.average_heartrate || 0);
     const effortDate = activity => {
       const raw = activity.start_date_formatted || activity.start_date;
@@ -1829,7 +2387,7 @@
       for (let i = 1; i < chronological.length; i++) {
         const current = chronological[i];
         const previous = chronological[i - 1];
-        previousByID.set(current.id, secondsValue(current.segment_elapsed_seconds) - secondsValue(previous.segment_elapsed_seconds));
+        previousByID.set(effortKey(current), secondsValue(current.segment_elapsed_seconds) - secondsValue(previous.segment_elapsed_seconds));
       }
 
       return activities.map(activity => {
@@ -1838,7 +2396,7 @@
           ...activity,
           effortSeconds,
           deltaBest: effortSeconds !== null && bestTime !== null ? effortSeconds - bestTime : null,
-          deltaPrevious: previousByID.has(activity.id) ? previousByID.get(activity.id) : null
+          deltaPrevious: previousByID.has(effortKey(activity)) ? previousByID.get(effortKey(activity)) : null
         };
       });
     };
@@ -1886,15 +2444,25 @@
       `;
     };
 
+    const renderGradeAdjustedSpeed = activity => {
+      const gap = Number(activity.segment_grade_adjusted_speed || 0);
+      if (gap <= 0) return '';
+      if (activity.segment_grade_adjusted === false) {
+        return `<span class="meta" title="No grade or altitude data; raw speed shown">GAP ${(gap * 3.6).toFixed(1)} (unadjusted)</span>`;
+      }
+      return `<span class="meta" title="Grade-adjusted speed">GAP ${(gap * 3.6).toFixed(1)}</span>`;
+    };
+
     function loadActivities(forceRefresh = false) {
       const tolerance = parseFloat(toleranceInput.value) || 15;
       const sortBy = sortSelect.value || 'distance';
+      const direction = directionSelect?.value || 'forward';
       const refreshParam = forceRefresh ? '&refresh=true' : '';
 
       activitiesLoading.style.display = 'block';
       activitiesSection.style.display = 'none';
 
-      fetch(`/api/segments/${segmentID}/activities?tolerance=${tolerance}&sort=${sortBy}${refreshParam}`)
+      fetch(appURL(`/api/segments/${segmentID}/activities?tolerance=${tolerance}&sort=${sortBy}&direction=${direction}${refreshParam}`))
         .then(r => {
           if (!r.ok) throw new Error(`HTTP ${r.status}: ${r.statusText}`);
           return r.json();
@@ -1902,9 +2470,12 @@
         .then(activities => {
           activitiesLoading.style.display = 'none';
           activitiesSection.style.display = 'block';
+          // Finding efforts fills the match cache the timeline is drawn from
+          loadSegmentTimeline();
 
           if (activities.length === 0) {
-            activitiesList.innerHTML = '<div class="muted">No same-direction efforts found for this segment.</div>';
+            const kind = { forward: 'same-direction ', reverse: 'reverse ' }[direction] || '';
+            activitiesList.innerHTML = `<div class="muted">No ${kind}efforts found for this segment.</div>`;
             return;
           }
 
@@ -1933,15 +2504,15 @@
                     const deltaBestClass = activity.deltaBest === 0 ? 'delta-good' : 'delta-slow';
                     const deltaPrevClass = activity.deltaPrevious !== null && activity.deltaPrevious <= 0 ? 'delta-good' : 'delta-slow';
                     return `
-                      <tr class="effort-row ${selectedEfforts.has(activity.id) ? 'selected' : ''}" data-activity-id="${activity.id}">
-                        <td><button type="button" class="compare-toggle" data-activity-id="${activity.id}">${selectedEfforts.has(activity.id) ? 'On' : 'Add'}</button></td>
+                      <tr class="effort-row ${selectedEfforts.has(effortKey(activity)) ? 'selected' : ''}" data-effort-key="${effortKey(activity)}">
+                        <td><button type="button" class="compare-toggle" data-effort-key="${effortKey(activity)}">${selectedEfforts.has(effortKey(activity)) ? 'On' : 'Add'}</button></td>
                         <td>
                           <span class="effort-name">${escapeHtml(activity.name || 'Activity')}</span>
-                          <span class="meta">${formatEffortDate(activity)} · <a class="link" href="/activity/${activity.id}">Open</a></span>
+                          <span class="meta">${formatEffortDate(activity)}${effortLapLabel(activity) ? ` · ${effortLapLabel(activity)}` : ''}${activity.direction === 'reverse' ? ' · Reverse' : ''} · <a class="link" href="${appURL('/activity/' + activity.id)}">Open</a></span>
                         </td>
                         <td>${formatDuration(activity.effortSeconds)}</td>
                         <td>${hr > 0 ? `${Math.round(hr)}` : 'n/a'}</td>
-                        <td>${speed > 0 ? `${(speed * 3.6).toFixed(1)}` : 'n/a'}</td>
+                        <td>${speed > 0 ? `${(speed * 3.6).toFixed(1)}` : 'n/a'}${renderGradeAdjustedSpeed(activity)}</td>
                         <td>${renderZoneMini(activity.segment_hr_zones)}</td>
                         <td class="${deltaBestClass}">${activity.deltaBest === null ? 'n/a' : formatDelta(activity.deltaBest)}</td>
                         <td class="${deltaPrevClass}">${activity.deltaPrevious === null ? 'n/a' : formatDelta(activity.deltaPrevious)}</td>
@@ -1955,21 +2526,21 @@
           `;
 
           // Add click handlers
-          activitiesList.querySelectorAll('.effort-row[data-activity-id]').forEach(row => {
+          activitiesList.querySelectorAll('.effort-row[data-effort-key]').forEach(row => {
             row.addEventListener('click', (e) => {
               // Don't navigate if clicking on the "View Full" link
               if (e.target.tagName === 'A') return;
               if (e.target.closest('button')) return;
               
-              const activityID = parseInt(row.getAttribute('data-activity-id'));
-              const activity = efforts.find(item => item.id === activityID);
+              const key = row.getAttribute('data-effort-key');
+              const activity = efforts.find(item => effortKey(item) === key);
               toggleEffortComparison(activity, tolerance);
             });
           });
-          activitiesList.querySelectorAll('.compare-toggle[data-activity-id]').forEach(btn => {
+          activitiesList.querySelectorAll('.compare-toggle[data-effort-key]').forEach(btn => {
             btn.addEventListener('click', () => {
-              const activityID = parseInt(btn.getAttribute('data-activity-id'));
-              const activity = efforts.find(item => item.id === activityID);
+              const key = btn.getAttribute('data-effort-key');
+              const activity = efforts.find(item => effortKey(item) === key);
               toggleEffortComparison(activity, tolerance);
             });
           });
@@ -1982,34 +2553,34 @@
     }
 
     function repaintEffortSelection() {
-      activitiesList.querySelectorAll('.effort-row[data-activity-id]').forEach(row => {
-        const activityID = parseInt(row.getAttribute('data-activity-id'));
-        const index = Array.from(selectedEfforts.keys()).indexOf(activityID);
+      activitiesList.querySelectorAll('.effort-row[data-effort-key]').forEach(row => {
+        const index = Array.from(selectedEfforts.keys()).indexOf(row.getAttribute('data-effort-key'));
         row.classList.toggle('selected', index >= 0);
         row.style.setProperty('--effort-color', index >= 0 ? compareColors[index] : 'transparent');
       });
-      activitiesList.querySelectorAll('.compare-toggle[data-activity-id]').forEach(btn => {
-        const activityID = parseInt(btn.getAttribute('data-activity-id'));
-        btn.textContent = selectedEfforts.has(activityID) ? 'On' : 'Add';
+      activitiesList.querySelectorAll('.compare-toggle[data-effort-key]').forEach(btn => {
+        btn.textContent = selectedEfforts.has(btn.getAttribute('data-effort-key')) ? 'On' : 'Add';
       });
     }
 
     function toggleEffortComparison(activity, tolerance) {
       if (!activity) return;
-      if (selectedEfforts.has(activity.id)) {
-        selectedEfforts.delete(activity.id);
+      const key = effortKey(activity);
+      if (selectedEfforts.has(key)) {
+        selectedEfforts.delete(key);
       } else {
         if (selectedEfforts.size >= 3) {
           alert('Select up to three efforts to compare.');
           return;
         }
-        selectedEfforts.set(activity.id, activity);
+        selectedEfforts.set(key, activity);
       }
 
-      selectedActivityID = selectedEfforts.size > 0 ? Array.from(selectedEfforts.keys())[selectedEfforts.size - 1] : null;
+      selectedActivityID = selectedEfforts.size > 0 ? Array.from(selectedEfforts.values())[selectedEfforts.size - 1].id : null;
       repaintEffortSelection();
       renderSelectedEffortsOnMap(tolerance);
       updateSegmentComparisonGraph();
+      updateEffortDistribution();
     }
 
     function clearComparisonMapLayers() {
@@ -2025,10 +2596,10 @@
       }
     }
 
-    function fetchSegmentEffort(activityID, segID, tolerance) {
+    function fetchSegmentEffort(activityID, segID, tolerance, effortNumber = 1) {
       return Promise.all([
-        fetch(`/api/activities/${activityID}/points`).then(r => r.json()),
-        fetch(`/api/segments/${segID}/activity/${activityID}/indices?tolerance=${tolerance}`).then(r => r.json())
+        fetch(appURL(`/api/activities/${activityID}/points`)).then(r => r.json()).then(streamedArray),
+        fetch(appURL(`/api/segments/${segID}/activity/${activityID}/indices?tolerance=${tolerance}&effort=${effortNumber}`)).then(r => r.json())
       ]).then(([points, indices]) => {
         if (!Array.isArray(points) || points.length === 0) return null;
         const startIdx = indices.start_index || 0;
@@ -2053,13 +2624,13 @@
 
     function renderSelectedEffortsOnMap(tolerance) {
       clearComparisonMapLayers();
-      const selections = Array.from(selectedEfforts.keys());
+      const selections = Array.from(selectedEfforts.values());
       if (selections.length === 0) {
         currentActivityFeatures = null;
         return;
       }
 
-      Promise.all(selections.map(activityID => fetchSegmentEffort(activityID, segmentID, tolerance)))
+      Promise.all(selections.map(activity => fetchSegmentEffort(activity.id, segmentID, tolerance, activity.effort_number || 1)))
         .then(efforts => {
           efforts.filter(Boolean).forEach((effort, index) => {
             const color = compareColors[index];
@@ -2099,11 +2670,11 @@
     }
 
     function loadActivityPoints(activityID, segID, tolerance, preserveColorMetric = null) {
-      fetch(`/api/activities/${activityID}/points`).then(r => r.json()).then(points => {
+      fetch(appURL(`/api/activities/${activityID}/points`)).then(r => r.json()).then(streamedArray).then(points => {
         if (!Array.isArray(points) || points.length === 0) return;
 
         // Get segment portion indices
-        fetch(`/api/segments/${segID}/activity/${activityID}/indices?tolerance=${tolerance}`)
+        fetch(appURL(`/api/segments/${segID}/activity/${activityID}/indices?tolerance=${tolerance}`))
           .then(r => r.json())
           .then(indices => {
             const startIdx = indices.start_index || 0;
@@ -2407,7 +2978,7 @@
 
       Promise.all(selected.map((activity, effortIndex) => {
         const includeZones = metrics.includes('heartrate');
-        const url = `/api/segments/${segmentID}/graph?metrics=${metrics.join(',')}&activity_id=${activity.id}&include_zones=${includeZones}`;
+        const url = appURL(`/api/segments/${segmentID}/graph?metrics=${metrics.join(',')}&activity_id=${activity.id}&effort=${activity.effort_number || 1}&include_zones=${includeZones}${graphSmoothQuery(metrics)}`);
         return fetch(url)
           .then(r => {
             if (!r.ok) throw new Error(`Graph fetch failed for ${activity.name}`);
@@ -2425,7 +2996,7 @@
             const firstDistance = metricData[0].distance || 0;
             const baseColor = compareColors[effortIndex];
             datasets.push({
-              label: `${activity.name || 'Effort'} · ${metricLabel(metric)}`,
+              label: `${effortTitle(activity)} · ${metricLabel(metric)}`,
               data: metricData.map(point => {
                 let x;
                 if (xAxisType === 'distance' && point.distance != null) {
@@ -2519,6 +3090,231 @@
       });
     }
 
+    // Power/cadence/HR histograms of the selected efforts over shared bins
+    let distributionChartInstance = null;
+    const distributionMetricSelect = document.getElementById('distribution-metric-select');
+    const distributionCanvas = document.getElementById('distribution-canvas');
+    const distributionContainer = document.getElementById('distribution-container');
+    const distributionPlaceholder = document.getElementById('distribution-placeholder');
+    const distributionSummary = document.getElementById('distribution-summary');
+    const distributionUnits = { watts: 'W', cadence: 'rpm', heartrate: 'bpm' };
+
+    function resetEffortDistribution(message) {
+      if (distributionChartInstance) {
+        distributionChartInstance.destroy();
+        distributionChartInstance = null;
+      }
+      if (distributionContainer) distributionContainer.classList.add('graph-empty');
+      if (distributionCanvas) distributionCanvas.style.display = 'none';
+      if (distributionSummary) distributionSummary.innerHTML = '';
+      if (distributionPlaceholder) {
+        distributionPlaceholder.textContent = message;
+        distributionPlaceholder.style.display = 'block';
+      }
+    }
+
+    function updateEffortDistribution() {
+      if (!distributionMetricSelect || !distributionCanvas) return;
+      const selected = Array.from(selectedEfforts.values());
+      if (selected.length === 0) {
+        resetEffortDistribution('Add efforts to compare their distributions');
+        return;
+      }
+
+      const metric = distributionMetricSelect.value;
+      const unit = distributionUnits[metric] || '';
+      const ids = selected.map(effortKey).join(',');
+      fetch(appURL(`/api/segments/${segmentID}/effort-distribution?activities=${ids}&metric=${metric}&bins=20`))
+        .then(r => {
+          if (!r.ok) throw new Error('Distribution fetch failed');
+          return r.json();
+        })
+        .then(data => {
+          if (!Array.isArray(data.edges) || data.edges.length < 2) {
+            resetEffortDistribution('No data for this metric in the selected efforts');
+            return;
+          }
+
+          const labels = data.edges.slice(0, -1).map((edge, i) => `${Math.round(edge)}–${Math.round(data.edges[i + 1])}`);
+          const datasets = data.efforts.map((effort, index) => {
+            const activity = selectedEfforts.get(effortKey({ id: effort.activity_id, effort_number: effort.effort })) || {};
+            const total = effort.samples || 1;
+            return {
+              label: effortTitle(activity),
+              data: effort.counts.map(count => count * 100 / total),
+              backgroundColor: compareColors[index] + '99',
+              borderColor: compareColors[index],
+              borderWidth: 1
+            };
+          });
+
+          if (distributionChartInstance) distributionChartInstance.destroy();
+          if (distributionContainer) distributionContainer.classList.remove('graph-empty');
+          if (distributionPlaceholder) distributionPlaceholder.style.display = 'none';
+          distributionCanvas.style.display = 'block';
+          distributionCanvas.style.width = '100%';
+          distributionCanvas.style.height = '220px';
+          distributionChartInstance = new Chart(distributionCanvas.getContext('2d'), {
+            type: 'bar',
+            data: { labels, datasets },
+            options: {
+              responsive: true,
+              maintainAspectRatio: false,
+              plugins: {
+                legend: { display: true, position: 'top', labels: { color: '#e0e0e0', boxWidth: 28 } },
+                tooltip: {
+                  callbacks: {
+                    title: context => `${context[0].label} ${unit}`,
+                    label: context => `${context.dataset.label}: ${context.parsed.y.toFixed(1)}%`
+                  }
+                }
+              },
+              scales: {
+                x: { ticks: { color: '#e0e0e0' }, grid: { color: '#333' }, title: { display: true, text: unit, color: '#e0e0e0' } },
+                y: { ticks: { color: '#e0e0e0', callback: value => `${value}%` }, grid: { color: '#333' } }
+              }
+            }
+          });
+
+          if (distributionSummary) {
+            distributionSummary.innerHTML = '';
+            data.efforts.forEach((effort, index) => {
+              const activity = selectedEfforts.get(effortKey({ id: effort.activity_id, effort_number: effort.effort })) || {};
+              const row = document.createElement('div');
+              const swatch = document.createElement('span');
+              swatch.className = 'swatch';
+              swatch.style.background = compareColors[index];
+              row.appendChild(swatch);
+              const stats = effort.samples > 0
+                ? `median ${Math.round(effort.median)} ${unit} · p95 ${Math.round(effort.p95)} ${unit}`
+                : 'no data';
+              row.appendChild(document.createTextNode(`${effortTitle(activity)}: ${stats} · ${effort.excluded} excluded`));
+              distributionSummary.appendChild(row);
+            });
+          }
+        })
+        .catch(error => {
+          console.error('Error loading effort distribution:', error);
+          resetEffortDistribution('Could not load the distribution');
+        });
+    }
+
+    if (distributionMetricSelect) {
+      distributionMetricSelect.addEventListener('change', updateEffortDistribution);
+    }
+
+    // Cached efforts over time with the rolling best; the page embeds the elapsed-time
+    // data and other metrics are fetched when picked
+    let timelineChartInstance = null;
+    const timelineMetricSelect = document.getElementById('timeline-metric-select');
+    const timelineCanvas = document.getElementById('timeline-canvas');
+    const timelineContainer = document.getElementById('timeline-container');
+    const timelinePlaceholder = document.getElementById('timeline-placeholder');
+    const timelineSummary = document.getElementById('timeline-summary');
+    const timelineMetrics = {
+      elapsed: { label: 'Time', format: value => formatDuration(value), scale: value => value },
+      speed: { label: 'Speed', format: value => `${value.toFixed(1)} km/h`, scale: value => value * 3.6 },
+      hr: { label: 'HR', format: value => `${Math.round(value)} bpm`, scale: value => value }
+    };
+
+    function renderSegmentTimeline(data) {
+      if (!timelineCanvas) return;
+      const metric = timelineMetrics[data.metric] || timelineMetrics.elapsed;
+      const points = Array.isArray(data.points) ? data.points : [];
+      if (timelineChartInstance) {
+        timelineChartInstance.destroy();
+        timelineChartInstance = null;
+      }
+      if (timelineSummary) {
+        timelineSummary.textContent = data.excluded > 0 ? `${data.excluded} efforts without ${metric.label.toLowerCase()} data excluded` : '';
+      }
+      if (points.length === 0) {
+        if (timelineContainer) timelineContainer.classList.add('graph-empty');
+        timelineCanvas.style.display = 'none';
+        if (timelinePlaceholder) timelinePlaceholder.style.display = 'block';
+        return;
+      }
+
+      const datasets = [{
+        label: metric.label,
+        data: points.map(p => ({ x: new Date(p.date).getTime(), y: metric.scale(p.value), activityID: p.activity_id })),
+        showLine: false,
+        borderColor: getCSSVar('--graph-speed'),
+        backgroundColor: getCSSVar('--graph-speed'),
+        pointRadius: 3,
+        pointHoverRadius: 5
+      }];
+      if (points.some(p => p.best !== undefined && p.best !== null)) {
+        datasets.push({
+          label: 'Best so far',
+          data: points.map(p => ({ x: new Date(p.date).getTime(), y: metric.scale(p.best) })),
+          stepped: true,
+          borderColor: getCSSVar('--graph-heartrate'),
+          backgroundColor: 'transparent',
+          borderWidth: 2,
+          pointRadius: 0
+        });
+      }
+
+      if (timelineContainer) timelineContainer.classList.remove('graph-empty');
+      if (timelinePlaceholder) timelinePlaceholder.style.display = 'none';
+      timelineCanvas.style.display = 'block';
+      timelineCanvas.style.width = '100%';
+      timelineCanvas.style.height = '220px';
+      timelineChartInstance = new Chart(timelineCanvas.getContext('2d'), {
+        type: 'line',
+        data: { datasets },
+        options: {
+          responsive: true,
+          maintainAspectRatio: false,
+          onClick: (event, elements) => {
+            const point = elements.length > 0 ? datasets[elements[0].datasetIndex].data[elements[0].index] : null;
+            if (point && point.activityID) window.location.href = appURL(`/activity/${point.activityID}`);
+          },
+          plugins: {
+            legend: { display: true, position: 'top', labels: { color: '#e0e0e0', boxWidth: 28 } },
+            tooltip: {
+              callbacks: {
+                label: context => `${context.dataset.label}: ${metric.format(context.parsed.y)}`
+              }
+            }
+          },
+          scales: {
+            x: { type: 'time', time: { unit: 'month' }, ticks: { color: '#e0e0e0' }, grid: { color: '#333' } },
+            y: {
+              reverse: data.metric === 'elapsed',
+              ticks: { color: '#e0e0e0', callback: value => data.metric === 'elapsed' ? formatDuration(value) : value },
+              grid: { color: '#333' }
+            }
+          }
+        }
+      });
+    }
+
+    function loadSegmentTimeline() {
+      if (!timelineMetricSelect || !timelineCanvas) return;
+      const tolerance = parseFloat(toleranceInput.value) || 15;
+      fetch(appURL(`/api/segments/${segmentID}/timeline?metric=${timelineMetricSelect.value}&tolerance=${tolerance}`))
+        .then(r => {
+          if (!r.ok) throw new Error('Timeline fetch failed');
+          return r.json();
+        })
+        .then(renderSegmentTimeline)
+        .catch(error => console.error('Error loading segment timeline:', error));
+    }
+
+    if (timelineMetricSelect) {
+      timelineMetricSelect.addEventListener('change', loadSegmentTimeline);
+      const timelineData = document.getElementById('timeline-data');
+      if (timelineData) {
+        try {
+          renderSegmentTimeline(JSON.parse(timelineData.textContent));
+        } catch (e) {
+          console.warn('Invalid timeline data:', e);
+        }
+      }
+    }
+
     function updateSegmentGraph(activityID, segID) {
       if (!metric1Select || !metric2Select || !graphCanvas || !activityID) return;
       
@@ -2554,7 +3350,7 @@
       if (metric2) metrics.push(metric2);
       
       const includeZones = metric1 === 'heartrate' || metric2 === 'heartrate';
-      const url = `/api/segments/${segID}/graph?metrics=${metrics.join(',')}&activity_id=${activityID}&include_zones=${includeZones}`;
+      const url = appURL(`/api/segments/${segID}/graph?metrics=${metrics.join(',')}&activity_id=${activityID}&include_zones=${includeZones}${graphSmoothQuery(metrics)}`);
       
       fetch(url)
         .then(r => {
@@ -2563,7 +3359,7 @@
         })
         .then(data => {
           // Store points for synchronization (fetch from activity points)
-          fetch(`/api/activities/${activityID}/points`)
+          fetch(appURL(`/api/activities/${activityID}/points`))
             .then(r => r.json())
             .then(points => {
               segmentGraphPoints = points;
@@ -2582,7 +3378,9 @@
             speed: getCSSVar('--graph-speed'),
             heartrate: getCSSVar('--graph-heartrate'),
             height: getCSSVar('--graph-height'),
-            cadence: getCSSVar('--graph-cadence')
+            cadence: getCSSVar('--graph-cadence'),
+            watts: getCSSVar('--graph-watts'),
+            grade: getCSSVar('--graph-grade')
           };
           
           let yAxisIDLeft = 'y';
@@ -2788,6 +3586,8 @@
                           else if (label === 'HR') unit = ' bpm';
                           else if (label === 'Height') unit = ' m';
                           else if (label === 'Cadence') unit = ' rpm';
+                          else if (label === 'Watts') unit = ' W';
+                          else if (label === 'Grade') unit = ' %';
                           return `${label}: ${value.toFixed(1)}${unit}`;
                         }
                       }
@@ -2936,7 +3736,7 @@
             if (legend) legend.style.display = 'none';
           } else if (metric === 'hrzones') {
             try {
-              const zr = await fetch('/api/hrzones');
+              const zr = await fetch(appURL('/api/hrzones'));
               if (!zr.ok) throw new Error('zones fetch failed');
               const z = await zr.json();
               const colors = ['#1b3a8a', '#00c2ff', '#2ecc71', '#f1c40f', '#e74c3c'];
@@ -2998,7 +3798,7 @@
     }
 
     function loadSegmentMetrics(activityID, segID, tolerance) {
-      fetch(`/api/segments/${segID}/activity/${activityID}/metrics?tolerance=${tolerance}`)
+      fetch(appURL(`/api/segments/${segID}/activity/${activityID}/metrics?tolerance=${tolerance}`))
         .then(r => {
           if (!r.ok) {
             throw new Error(`HTTP ${r.status}: ${r.statusText}`);
@@ -3013,9 +3813,9 @@
           if (metrics.avg_hr && metrics.avg_hr > 0) {
             html += `Avg HR: ${Math.round(metrics.avg_hr)} bpm`;
           }
-          if (metrics.avg_speed && metrics.avg_speed > 0) {
+          if (metrics.avg_speed_elapsed && metrics.avg_speed_elapsed > 0) {
             if (html) html += ' • ';
-            html += `Avg Speed: ${(metrics.avg_speed * 3.6).toFixed(1)} km/h`;
+            html += `Avg Speed: ${(metrics.avg_speed_elapsed * 3.6).toFixed(1)} km/h`;
           }
           if (metrics.distance && metrics.distance > 0) {
             if (html) html += ' • ';
@@ -3032,7 +3832,10 @@
           console.error(`Failed to load metrics for activity ${activityID}:`, err);
           const metricsEl = document.getElementById(`segment-metrics-${activityID}`);
           if (metricsEl) {
-            metricsEl.innerHTML = `<span class="meta">Error: ${err.message}</span>`;
+            const errorEl = document.createElement('span');
+            errorEl.className = 'meta';
+            errorEl.textContent = `Error: ${err.message}`;
+            metricsEl.replaceChildren(errorEl);
           }
         });
     }
@@ -3043,13 +3846,44 @@
     if (refreshBtn) {
       refreshBtn.addEventListener('click', () => loadActivities(true));
     }
-    if (sortSelect) {
-      sortSelect.addEventListener('change', () => {
+    const setDefaultToleranceBtn = document.getElementById('set-default-tolerance-btn');
+    if (setDefaultToleranceBtn) {
+      setDefaultToleranceBtn.addEventListener('click', () => {
+        const tolerance = parseFloat(toleranceInput.value);
+        if (!Number.isFinite(tolerance) || tolerance <= 0) return;
+        setDefaultToleranceBtn.disabled = true;
+        fetch(appURL(`/api/segments/${segmentID}`), {
+          method: 'PATCH',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ default_tolerance_m: tolerance })
+        })
+          .then(r => {
+            if (!r.ok) throw new Error(`HTTP ${r.status}: ${r.statusText}`);
+            return r.json();
+          })
+          .then(segment => {
+            const sourceEl = document.getElementById('tolerance-source');
+            if (sourceEl) {
+              sourceEl.dataset.source = 'segment';
+              sourceEl.textContent = `Segment default: ${segment.default_tolerance_m} m`;
+            }
+          })
+          .catch(err => {
+            console.error('Failed to save default tolerance:', err);
+            alert(`Failed to save default tolerance: ${err.message}`);
+          })
+          .finally(() => {
+            setDefaultToleranceBtn.disabled = false;
+          });
+      });
+    }
+    [sortSelect, directionSelect].forEach(select => {
+      select?.addEventListener('change', () => {
         if (activitiesSection.style.display !== 'none') {
           loadActivities(false);
         }
       });
-    }
+    });
     loadActivities(false);
   }
 
@@ -3081,8 +3915,8 @@
     };
 
     const loadStatus = async () => {
-      const response = await fetch('/api/discovered/status');
-      if (!response.ok) throw new Error(await response.text() || 'Failed to load discovered map status');
+      const response = await fetch(appURL('/api/discovered/status'));
+      if (!response.ok) throw new Error(await responseError(response) || 'Failed to load discovered map status');
       const status = await response.json();
 
       if (status.stale) {
@@ -3114,11 +3948,11 @@
         bounds.maxLat
       ].join(',');
       const [fogResponse, coverageResponse] = await Promise.all([
-        fetch(`/api/discovered/fog?bbox=${encodeURIComponent(bbox)}`),
-        fetch(`/api/discovered/coverage?bbox=${encodeURIComponent(bbox)}`)
+        fetch(appURL(`/api/discovered/fog?bbox=${encodeURIComponent(bbox)}`)),
+        fetch(appURL(`/api/discovered/coverage?bbox=${encodeURIComponent(bbox)}`))
       ]);
-      if (!fogResponse.ok) throw new Error(await fogResponse.text() || 'Failed to load discovered fog');
-      if (!coverageResponse.ok) throw new Error(await coverageResponse.text() || 'Failed to load discovered coverage');
+      if (!fogResponse.ok) throw new Error(await responseError(fogResponse) || 'Failed to load discovered fog');
+      if (!coverageResponse.ok) throw new Error(await responseError(coverageResponse) || 'Failed to load discovered coverage');
       const fog = await fogResponse.json();
       const coverage = await coverageResponse.json();
       if (requestID !== fogRequestID) return;
@@ -3186,8 +4020,8 @@
         rebuildBtn.disabled = true;
         setStatus('Rebuilding discovered coverage...', 'warning');
         try {
-          const response = await fetch('/api/discovered/rebuild', { method: 'POST' });
-          if (!response.ok) throw new Error(await response.text() || 'Failed to rebuild discovered map');
+          const response = await fetch(appURL('/api/discovered/rebuild'), { method: 'POST' });
+          if (!response.ok) throw new Error(await responseError(response) || 'Failed to rebuild discovered map');
           hasFitCoverage = false;
           await loadStatus();
           await fetchDiscoveredOverlay();
@@ -3217,8 +4051,8 @@
   }
 
   if (document.readyState === 'loading') {
-    document.addEventListener('DOMContentLoaded', () => { onActivityPage(); onIndexPage(); onSegmentsPage(); onSegmentPage(); onDiscoveredPage(); });
+    document.addEventListener('DOMContentLoaded', () => { onActivityPage(); onIndexPage(); onPinButtons(); onActivityDelete(); onActivityNotes(); onActivityDetails(); onSimilarActivities(); onImportForm(); onSegmentsPage(); onSegmentDraw(); onSegmentPage(); onDiscoveredPage(); onSetupHint(); onSessionRevoke(); onAnnouncements(); onTrainingLoad(); });
   } else {
-    onActivityPage(); onIndexPage(); onSegmentsPage(); onSegmentPage(); onDiscoveredPage();
+    onActivityPage(); onIndexPage(); onPinButtons(); onActivityDelete(); onActivityNotes(); onActivityDetails(); onSimilarActivities(); onImportForm(); onSegmentsPage(); onSegmentDraw(); onSegmentPage(); onDiscoveredPage(); onSetupHint(); onSessionRevoke(); onAnnouncements(); onTrainingLoad();
   }
 })();